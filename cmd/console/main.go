@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,12 +18,22 @@ import (
 	"github.com/last-emo-boy/infra-core/pkg/api/handlers"
 	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
 	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/auth/oidc"
+	"github.com/last-emo-boy/infra-core/pkg/client"
 	"github.com/last-emo-boy/infra-core/pkg/config"
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/events"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
 	"github.com/last-emo-boy/infra-core/pkg/services"
+	"github.com/last-emo-boy/infra-core/pkg/tracing"
 )
 
 func main() {
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
 	log.Println("🚀 Starting Console API Server...")
 
 	// Load configuration
@@ -28,32 +42,154 @@ func main() {
 		environment = "development"
 	}
 
-	cfg, err := config.Load()
+	cfg, err := config.Read()
 	if err != nil {
 		log.Fatalf("❌ Failed to load configuration: %v", err)
 	}
+	if *printConfig {
+		if err := cfg.WriteRedacted(os.Stdout); err != nil {
+			log.Fatalf("❌ Failed to print configuration: %v", err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+
+	closeLogs, err := logging.Setup("console", cfg.Console.Logs, environment)
+	if err != nil {
+		log.Printf("⚠️ Logging to stderr only: %v", err)
+	}
+	defer closeLogs()
+
+	shutdownTracing, err := tracing.Setup("console", cfg.Tracing)
+	if err != nil {
+		log.Fatalf("❌ Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
 	log.Printf("📋 Environment: %s", environment)
 	log.Printf("🌐 Server will start on %s:%d", cfg.Console.Host, cfg.Console.Port)
 
+	srv, err := newConsoleServer(cfg, environment)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	addr := fmt.Sprintf("%s:%d", cfg.Console.Host, cfg.Console.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("❌ Failed to start server: %v", err)
+	}
+
+	log.Printf("🚀 Console API server starting on %s", addr)
+	log.Printf("📝 Environment: %s", environment)
+	if len(cfg.Console.Auth.JWT.Secret) > 8 {
+		log.Printf("🔑 JWT Secret: %s...", cfg.Console.Auth.JWT.Secret[:8])
+	} else {
+		log.Printf("🔑 JWT Secret: Generated automatically")
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	if err := srv.run(listener, quit); err != nil {
+		log.Fatalf("❌ Console stopped: %v", err)
+	}
+	log.Println("✅ Console shutdown complete")
+}
+
+// shutdownTimeout is how long requests in flight get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// consoleServer is the console API server and the background services
+// running alongside it
+type consoleServer struct {
+	db                 *database.DB
+	router             *gin.Engine
+	server             *http.Server
+	health             *health.Monitor
+	auditLogger        *services.AuditLogger
+	healthChecker      *services.HealthChecker
+	metricsDownsampler *services.MetricsDownsampler
+	metricsCollector   *services.MetricsCollector
+	certificateMonitor *services.CertificateMonitor
+	retentionManager   *services.RetentionManager
+	eventRelay         *services.EventRelay
+}
+
+// newConsoleServer opens the database and sets up the console's routes and
+// background services without starting them
+func newConsoleServer(cfg *config.Config, environment string) (*consoleServer, error) {
 	// Initialize database
 	db, err := database.NewDB(cfg)
 	if err != nil {
-		log.Fatalf("❌ Failed to initialize database: %v", err)
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
-	defer db.Close()
 
 	// Initialize auth service
 	authService, err := auth.NewAuth(&cfg.Console)
 	if err != nil {
-		log.Fatalf("❌ Failed to initialize auth service: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize auth service: %w", err)
+	}
+
+	// Master key of the secrets services read environment variables from,
+	// generated on first start. Without it secrets cannot be written.
+	secretsKey, err := database.LoadMasterKey(cfg, true)
+	if err != nil {
+		log.Printf("⚠️ Secrets are unavailable: %v", err)
 	}
+	secretHandler := handlers.NewSecretHandler(db, secretsKey)
+	templateHandler := handlers.NewTemplateHandler(db)
+
+	// Audit logger, started with the other background services
+	auditLogger := services.NewAuditLogger(db, services.DefaultAuditBufferSize)
 
 	// Create handlers
 	userHandler := handlers.NewUserHandler(authService, db)
-	serviceHandler := handlers.NewServiceHandler(db)
+	bootstrapAdmin(userHandler)
+	serviceLogs := orchestrator.LogOptionsFromConfig(cfg.Orchestrator.ServiceLogs)
+	serviceHandler := handlers.NewServiceHandler(db, orchestrator.NewLogReader(db, serviceLogs.Dir))
+	servicePorts := orchestrator.NewPortRegistry(db, cfg)
+	serviceHandler.SetPortRegistry(servicePorts)
+	templateHandler.SetPortRegistry(servicePorts)
 	systemHandler := handlers.NewSystemHandler(db)
+	systemHandler.SetConfig(cfg)
+	probeClient, snapClient, gateClient, orchestratorClient := daemonClients(cfg.Console.Daemons)
+	systemHandler.SetDaemonClients(probeClient, snapClient)
+	systemHandler.SetGateClient(gateClient)
+	templateHandler.SetOrchestratorClient(orchestratorClient)
+	monitor := newConsoleMonitor(db, cfg, probeClient, snapClient)
+
+	// Events the UI follows in real time: those of the console's background
+	// services, and those the daemons publish, relayed
+	eventBus := events.NewBus("console", events.DefaultHistory)
+	eventRelay := services.NewEventRelay(eventBus)
+	if orchestratorClient != nil {
+		eventRelay.Add("orchestrator", orchestratorClient)
+	}
+	if probeClient != nil {
+		eventRelay.Add("probe daemon", probeClient)
+	}
+	if snapClient != nil {
+		eventRelay.Add("snap daemon", snapClient)
+	}
+	healthChecker := services.NewHealthChecker(db, cfg.Console.ServiceHealth)
+	healthChecker.SetEventBus(eventBus)
+	certificateMonitor := services.NewCertificateMonitor(db)
+	certificateMonitor.SetEventBus(eventBus)
 	ssoHandler := handlers.NewSSOHandler(authService, db)
+	oidcProvider, err := oidc.NewProvider(authService, db, cfg.Console.OIDCIssuer())
+	if err != nil {
+		log.Printf("⚠️ OpenID Connect provider disabled: set console.external_url or console.auth.oidc.issuer")
+	}
+
+	incidentWindow, _ := time.ParseDuration(cfg.Console.IncidentWindow) // validated on load, zero falls back to the default
+	deploymentHandler := handlers.NewDeploymentHandler(db, incidentWindow)
+	orgHandler := handlers.NewOrgHandler(db, authService)
+	deployHookHandler := handlers.NewDeployHookHandler(db, secretsKey, cfg.Console.DeployHooks)
+	deployHookHandler.SetOrchestratorClient(orchestratorClient)
+	searchHandler := handlers.NewSearchHandler(db, authService)
 
 	// Setup Gin router
 	if environment == "production" {
@@ -63,29 +199,25 @@ func main() {
 	r := gin.New()
 
 	// Global middleware
+	r.Use(middleware.RequestIDMiddleware())
+	r.Use(middleware.TracingMiddleware())
 	r.Use(middleware.LoggingMiddleware())
 	r.Use(middleware.RecoveryMiddleware())
-	r.Use(middleware.CORSMiddleware())
+	r.Use(middleware.CORSMiddleware(cfg.Console.CORS, environment))
+	r.Use(middleware.AuditMiddleware(auditLogger))
 
 	// Static UI support
-	uiDistDir := "/app/ui/dist"
-	uiIndexFile := filepath.Join(uiDistDir, "index.html")
-	uiAvailable := false
+	ui := handlers.NewUIHandler(cfg.Console.UI.Dir)
+	uiAvailable := ui.Available()
 
-	if info, err := os.Stat(uiIndexFile); err == nil && !info.IsDir() {
-		uiAvailable = true
-		log.Printf("🖥️  UI assets detected at %s", uiDistDir)
-
-		// Serve static assets (JS/CSS/etc.)
-		assetsDir := filepath.Join(uiDistDir, "assets")
-		if stat, err := os.Stat(assetsDir); err == nil && stat.IsDir() {
-			r.Static("/assets", assetsDir)
-		}
+	if uiAvailable {
+		log.Printf("🖥️  UI assets detected at %s", ui.Dir())
 
-		// Serve index for root requests
-		r.GET("/", func(c *gin.Context) {
-			c.File(uiIndexFile)
-		})
+		// Hashed JS/CSS/etc. and the index for root requests
+		r.GET("/assets/*filepath", ui.Asset)
+		r.HEAD("/assets/*filepath", ui.Asset)
+		r.GET("/", ui.Index)
+		r.HEAD("/", ui.Index)
 	} else {
 		// Root health check (legacy JSON response)
 		r.GET("/", func(c *gin.Context) {
@@ -99,6 +231,25 @@ func main() {
 		})
 	}
 
+	// Liveness, readiness and detailed health endpoints
+	monitor.RegisterRoutes(r)
+
+	// OpenID Connect provider. Authorization requires a console session.
+	if oidcProvider != nil {
+		r.GET(oidc.DiscoveryPath, oidcProvider.Discovery)
+		r.GET(oidc.JWKSPath, oidcProvider.JWKS)
+		r.GET(oidc.AuthorizePath, middleware.SSOAuthMiddleware(authService, db), oidcProvider.Authorize)
+		r.POST(oidc.TokenPath, oidcProvider.Token)
+		r.GET(oidc.UserInfoPath, oidcProvider.UserInfo)
+	}
+
+	// Registered services proxied for signed in users
+	portal := r.Group("/portal")
+	portal.Use(middleware.SSOAuthMiddleware(authService, db))
+	{
+		portal.Any("/:service/*path", ssoHandler.ProxyService)
+	}
+
 	// Public routes
 	api := r.Group("/api/v1")
 	{
@@ -107,27 +258,34 @@ func main() {
 		{
 			auth.POST("/register", userHandler.Register)
 			auth.POST("/login", userHandler.Login)
+			auth.POST("/password-reset/request", userHandler.RequestPasswordReset)
+			auth.POST("/password-reset/confirm", userHandler.ConfirmPasswordReset)
+			auth.GET("/jwks", userHandler.JWKS)
+		}
+
+		// First-run setup, creating the first admin
+		setup := api.Group("/setup")
+		{
+			setup.GET("/status", userHandler.SetupStatus)
+			setup.POST("/admin", userHandler.CreateAdmin)
 		}
 
-		// Health check endpoint
-		api.GET("/health", systemHandler.HealthCheck)
+		// Metrics reported by daemons on other hosts, such as the gate's
+		// traffic, authenticated by console.metrics.ingest_token
+		api.POST("/system/metrics/ingest", systemHandler.IngestMetrics)
+
+		// Deploy hooks CI calls, authenticated by their signature under
+		// the service's deploy hook secret
+		api.POST("/services/:id/deploy-hook", deployHookHandler.TriggerDeployHook)
+
+		// Health check endpoints, also served at the root. Readiness fails
+		// until the background services started and once shutdown begins.
+		monitor.RegisterRoutes(api)
 	}
 
-	// SPA fallback for non-API routes when UI is present
+	// SPA fallback for non-API, non-asset routes when UI is present
 	if uiAvailable {
-		r.NoRoute(func(c *gin.Context) {
-			path := c.Request.URL.Path
-			if strings.HasPrefix(path, "/api/") {
-				c.JSON(http.StatusNotFound, gin.H{
-					"error":   "endpoint_not_found",
-					"path":    path,
-					"message": "The requested API route was not found",
-				})
-				return
-			}
-
-			c.File(uiIndexFile)
-		})
+		r.NoRoute(ui.Fallback)
 	} else {
 		r.NoRoute(func(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{
@@ -141,12 +299,33 @@ func main() {
 	// Protected routes
 	protected := api.Group("/")
 	protected.Use(middleware.AuthMiddleware(authService, db))
+	protected.Use(middleware.RequireMethodScope())
+	if cfg.MultiTenancy.Enabled {
+		protected.Use(middleware.OrgMiddleware(db))
+	}
 	{
 		// User management
 		users := protected.Group("/users")
 		{
 			users.GET("/profile", userHandler.GetProfile)
+			users.PUT("/profile", userHandler.UpdateProfile)
+			users.POST("/profile/password", userHandler.ChangePassword)
+			users.GET("/profile/sessions", userHandler.ListSessions)
+			users.DELETE("/profile/sessions/:id", userHandler.RevokeSession)
 			users.PUT("/:id", userHandler.UpdateUser)
+			users.POST("/2fa/setup", userHandler.SetupTOTP)
+			users.POST("/2fa/confirm", userHandler.ConfirmTOTP)
+			users.POST("/2fa/disable", userHandler.DisableTOTP)
+			users.POST("/api-keys", userHandler.CreateAPIKey)
+			users.GET("/api-keys", userHandler.ListAPIKeys)
+			users.DELETE("/api-keys/:key_id", userHandler.RevokeAPIKey)
+		}
+
+		// Admin-only signing key rotation
+		adminAuth := protected.Group("/auth")
+		adminAuth.Use(middleware.RequireRole(authService, "admin"))
+		{
+			adminAuth.POST("/rotate-keys", userHandler.RotateKeys)
 		}
 
 		// Admin-only user management
@@ -155,6 +334,27 @@ func main() {
 		{
 			adminUsers.GET("/", userHandler.ListUsers)
 			adminUsers.DELETE("/:id", userHandler.DeleteUser)
+			adminUsers.POST("/:id/unlock", userHandler.UnlockUser)
+			adminUsers.POST("/:id/impersonate", userHandler.ImpersonateUser)
+		}
+
+		// Organizations, which services, routes and registered services are
+		// scoped to when multi-tenancy is enabled
+		orgs := protected.Group("/orgs")
+		{
+			orgs.GET("/", orgHandler.ListOrgs)
+			orgs.GET("/:id/members", orgHandler.ListOrgMembers)
+			orgs.PUT("/:id/members/:user_id", orgHandler.SetOrgMember)
+			orgs.DELETE("/:id/members/:user_id", orgHandler.RemoveOrgMember)
+			orgs.POST("/:id/token", orgHandler.SwitchOrg)
+		}
+
+		// Admin-only organization management
+		adminOrgs := orgs.Group("/")
+		adminOrgs.Use(middleware.RequireRole(authService, "admin"))
+		{
+			adminOrgs.POST("/", orgHandler.CreateOrg)
+			adminOrgs.DELETE("/:id", orgHandler.DeleteOrg)
 		}
 
 		// Service management
@@ -163,12 +363,71 @@ func main() {
 			services.POST("/", serviceHandler.CreateService)
 			services.GET("/", serviceHandler.ListServices)
 			services.GET("/summary", serviceHandler.GetServiceSummary)
+			services.POST("/validate", serviceHandler.ValidateServiceSpec)
 			services.GET("/:id", serviceHandler.GetService)
 			services.PUT("/:id", serviceHandler.UpdateService)
 			services.DELETE("/:id", serviceHandler.DeleteService)
+			services.GET("/:id/revisions", serviceHandler.ListServiceRevisions)
+			services.POST("/:id/revisions/:rev/revert", serviceHandler.RevertServiceRevision)
 			services.POST("/:id/start", serviceHandler.StartService)
 			services.POST("/:id/stop", serviceHandler.StopService)
 			services.GET("/:id/logs", serviceHandler.GetServiceLogs)
+			services.GET("/:id/logs/stream", serviceHandler.StreamServiceLogs)
+		}
+
+		// Admin-only bulk service actions
+		adminServices := services.Group("/")
+		adminServices.Use(middleware.RequireRole(authService, "admin"))
+		{
+			adminServices.POST("/bulk", serviceHandler.BulkServices)
+			adminServices.POST("/:id/deploy-hook/secret", deployHookHandler.GenerateDeployHookSecret)
+			adminServices.DELETE("/:id/deploy-hook", deployHookHandler.DeleteDeployHook)
+		}
+
+		// Secrets, listed without their values
+		secrets := protected.Group("/secrets")
+		{
+			secrets.GET("/", secretHandler.ListSecrets)
+			secrets.GET("/:name", secretHandler.GetSecret)
+		}
+
+		// Admin-only secret management
+		adminSecrets := secrets.Group("/")
+		adminSecrets.Use(middleware.RequireRole(authService, "admin"))
+		{
+			adminSecrets.POST("/", secretHandler.CreateSecret)
+			adminSecrets.PUT("/:name", secretHandler.UpdateSecret)
+			adminSecrets.DELETE("/:name", secretHandler.DeleteSecret)
+		}
+
+		// Service templates
+		serviceTemplates := protected.Group("/templates")
+		{
+			serviceTemplates.GET("/", templateHandler.ListTemplates)
+			serviceTemplates.GET("/:id", templateHandler.GetTemplate)
+		}
+
+		// Admin-only template management and services created from templates
+		adminTemplates := serviceTemplates.Group("/")
+		adminTemplates.Use(middleware.RequireRole(authService, "admin"))
+		{
+			adminTemplates.POST("/", templateHandler.CreateTemplate)
+			adminTemplates.PUT("/:id", templateHandler.UpdateTemplate)
+			adminTemplates.DELETE("/:id", templateHandler.DeleteTemplate)
+			adminTemplates.POST("/:id/instantiate", templateHandler.InstantiateTemplate)
+		}
+
+		// Global search
+		protected.GET("/search", searchHandler.Search)
+
+		// Real-time events as server-sent events
+		protected.GET("/events/stream", eventBus.Stream)
+
+		// Deployment analytics
+		deployments := protected.Group("/deployments")
+		{
+			deployments.GET("/stats", deploymentHandler.GetDeploymentStats)
+			deployments.GET("/:id", deploymentHandler.GetDeployment)
 		}
 
 		// SSO management
@@ -177,6 +436,10 @@ func main() {
 			// Service registration and management
 			sso.GET("/services", ssoHandler.ListServices)
 			sso.GET("/user/services", ssoHandler.ListUserServices)
+			sso.PUT("/user/services/:id/pin", ssoHandler.PinService)
+			sso.DELETE("/user/services/:id/pin", ssoHandler.UnpinService)
+			sso.POST("/services/:id/visit", ssoHandler.RecordServiceVisit)
+			sso.POST("/services/:id/launch", ssoHandler.LaunchService)
 			sso.GET("/services/:id", ssoHandler.GetService)
 			sso.GET("/services/:id/health", ssoHandler.GetServiceHealth)
 			sso.GET("/services/:id/health/history", ssoHandler.GetServiceHealthHistory)
@@ -193,8 +456,15 @@ func main() {
 				adminSSO.GET("/services/:id/permissions", ssoHandler.ListServicePermissions)
 				adminSSO.PUT("/services/:id", ssoHandler.UpdateService)
 				adminSSO.DELETE("/services/:id", ssoHandler.DeleteService)
+				adminSSO.GET("/services/:id/maintenance", ssoHandler.ListMaintenanceWindows)
+				adminSSO.POST("/services/:id/maintenance", ssoHandler.CreateMaintenanceWindow)
+				adminSSO.PUT("/services/:id/maintenance/:window_id", ssoHandler.UpdateMaintenanceWindow)
+				adminSSO.DELETE("/services/:id/maintenance/:window_id", ssoHandler.DeleteMaintenanceWindow)
 				adminSSO.POST("/permissions/:user_id/:service_id/grant", ssoHandler.GrantServiceAccess)
 				adminSSO.POST("/permissions/:user_id/:service_id/revoke", ssoHandler.RevokeServiceAccess)
+				adminSSO.GET("/oauth/clients", ssoHandler.ListOAuthClients)
+				adminSSO.POST("/oauth/clients", ssoHandler.CreateOAuthClient)
+				adminSSO.DELETE("/oauth/clients/:client_id", ssoHandler.DeleteOAuthClient)
 			}
 		}
 
@@ -204,6 +474,7 @@ func main() {
 			system.GET("/info", systemHandler.GetSystemInfo)
 			system.GET("/metrics", systemHandler.GetMetrics)
 			system.GET("/dashboard", systemHandler.GetDashboardData)
+			system.GET("/certificates", systemHandler.ListCertificates)
 		}
 
 		// Admin-only system management
@@ -211,18 +482,21 @@ func main() {
 		adminSystem.Use(middleware.RequireRole(authService, "admin"))
 		{
 			adminSystem.GET("/audit", systemHandler.GetAuditLogs)
+			adminSystem.GET("/maintenance", systemHandler.ListMaintenanceRuns)
+			adminSystem.GET("/db/queries", systemHandler.GetQueryStats)
+			adminSystem.POST("/backup", systemHandler.CreateBackup)
+			adminSystem.GET("/backups", systemHandler.ListBackups)
+			adminSystem.GET("/config", systemHandler.GetConfig)
+			adminSystem.GET("/export", systemHandler.ExportConfig)
+			adminSystem.POST("/import", systemHandler.ImportConfig)
+			adminSystem.GET("/certificates/ca", systemHandler.DownloadCACertificate)
+			adminSystem.POST("/certificates/:id/renew", systemHandler.RenewCertificate)
+			adminSystem.DELETE("/certificates/:id", systemHandler.DeleteCertificate)
 		}
 	}
 
-	// Start health checker service
-	healthChecker := services.NewHealthChecker(db)
-	healthChecker.Start()
-	log.Printf("🏥 Health checker service started")
-
 	// Create HTTP server
-	addr := fmt.Sprintf("%s:%d", cfg.Console.Host, cfg.Console.Port)
 	server := &http.Server{
-		Addr:           addr,
 		Handler:        r,
 		ReadTimeout:    30 * time.Second,
 		WriteTimeout:   30 * time.Second,
@@ -230,15 +504,163 @@ func main() {
 		MaxHeaderBytes: 1 << 20, // 1MB
 	}
 
-	log.Printf("🚀 Console API server starting on %s", addr)
-	log.Printf("📝 Environment: %s", environment)
-	if len(cfg.Console.Auth.JWT.Secret) > 8 {
-		log.Printf("🔑 JWT Secret: %s...", cfg.Console.Auth.JWT.Secret[:8])
-	} else {
-		log.Printf("🔑 JWT Secret: Generated automatically")
+	return &consoleServer{
+		db:                 db,
+		router:             r,
+		server:             server,
+		health:             monitor,
+		auditLogger:        auditLogger,
+		healthChecker:      healthChecker,
+		metricsDownsampler: services.NewMetricsDownsampler(db, cfg.Console.Metrics),
+		// Host metrics report disk usage of the data directory
+		metricsCollector:   services.NewMetricsCollector(db, cfg.Console.Metrics, filepath.Dir(cfg.Console.Database.Path)),
+		certificateMonitor: certificateMonitor,
+		retentionManager:   services.NewRetentionManager(db, cfg.Console.Retention),
+		eventRelay:         eventRelay,
+	}, nil
+}
+
+// run starts the background services and serves on listener until a signal
+// arrives on quit, then shuts down
+func (s *consoleServer) run(listener net.Listener, quit <-chan os.Signal) error {
+	s.auditLogger.Start()
+	s.healthChecker.Start()
+	log.Printf("🏥 Health checker service started")
+	s.metricsDownsampler.Start()
+	s.metricsCollector.Start()
+	s.certificateMonitor.Start()
+	s.retentionManager.Start()
+	s.eventRelay.Start()
+	s.health.SetStarted()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.server.Serve(listener)
+	}()
+
+	var err error
+	select {
+	case sig := <-quit:
+		log.Printf("🛑 Received %s, shutting down console...", sig)
+	case err = <-serveErr:
 	}
 
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("❌ Failed to start server: %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if shutdownErr := s.shutdown(ctx); err == nil {
+		err = shutdownErr
+	}
+	return err
+}
+
+// shutdown fails readiness checks so the gate stops routing to the console,
+// lets requests in flight finish, stops the background services, drains the
+// audit log and finally closes the database
+func (s *consoleServer) shutdown(ctx context.Context) error {
+	s.health.SetDraining()
+
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("❌ Server forced to shutdown: %v", err)
+	}
+
+	s.healthChecker.Stop()
+	s.metricsCollector.Stop()
+	s.metricsDownsampler.Stop()
+	s.certificateMonitor.Stop()
+	s.retentionManager.Stop()
+	s.eventRelay.Stop()
+	s.auditLogger.Stop()
+
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newConsoleMonitor creates the console's health monitor. The console is
+// ready while its database is; the data directory and the daemons it reads
+// from only degrade its health.
+func newConsoleMonitor(db *database.DB, cfg *config.Config, probeClient *client.Probe, snapClient *client.Snap) *health.Monitor {
+	monitor := health.New("console")
+	monitor.AddCheck(health.Database(db))
+	monitor.AddCheck(health.WritableDir("data_dir", filepath.Dir(cfg.Console.Database.Path)))
+	if probeClient != nil {
+		monitor.AddCheck(health.Check{Name: "probe", Run: func(ctx context.Context) error {
+			_, err := probeClient.Health(ctx)
+			return err
+		}})
+	}
+	if snapClient != nil {
+		monitor.AddCheck(health.Check{Name: "snap", Run: func(ctx context.Context) error {
+			_, err := snapClient.Health(ctx)
+			return err
+		}})
+	}
+	return monitor
+}
+
+// daemonClients creates clients of the probe, snap and orchestrator daemons
+// and the gate the console is configured to call, nil for those without a URL
+func daemonClients(daemons config.DaemonsConfig) (*client.Probe, *client.Snap, *client.Gate, *client.Orchestrator) {
+	timeout, _ := time.ParseDuration(daemons.Timeout) // validated on load
+	if timeout <= 0 {
+		timeout = client.DefaultTimeout
+	}
+	httpClient := client.WithHTTPClient(&http.Client{Timeout: timeout})
+
+	var probeClient *client.Probe
+	if daemons.ProbeURL != "" {
+		probeClient = client.NewProbe(daemons.ProbeURL, daemons.Token, httpClient)
+		log.Printf("📡 Reading active alerts from the probe daemon at %s", daemons.ProbeURL)
+	}
+	var snapClient *client.Snap
+	if daemons.SnapURL != "" {
+		snapClient = client.NewSnap(daemons.SnapURL, daemons.Token, httpClient)
+		log.Printf("📡 Reading snapshots from the snap daemon at %s", daemons.SnapURL)
+	}
+	var gateClient *client.Gate
+	if daemons.GateURL != "" {
+		gateClient = client.NewGate(daemons.GateURL, daemons.Token, httpClient)
+		log.Printf("📡 Renewing certificates through the gate at %s", daemons.GateURL)
+	}
+	var orchestratorClient *client.Orchestrator
+	if daemons.OrchestratorURL != "" {
+		orchestratorClient = client.NewOrchestrator(daemons.OrchestratorURL, daemons.Token, httpClient)
+		log.Printf("📡 Deploying services from templates through the orchestrator at %s", daemons.OrchestratorURL)
+	}
+	return probeClient, snapClient, gateClient, orchestratorClient
+}
+
+// bootstrapAdmin creates the first admin from INFRA_CORE_ADMIN_USERNAME,
+// INFRA_CORE_ADMIN_PASSWORD and INFRA_CORE_ADMIN_EMAIL, so automated deploys
+// need not go through setup. Without a password one is generated and logged
+// once.
+func bootstrapAdmin(userHandler *handlers.UserHandler) {
+	username := os.Getenv("INFRA_CORE_ADMIN_USERNAME")
+	password := os.Getenv("INFRA_CORE_ADMIN_PASSWORD")
+	if username == "" && password == "" {
+		return
+	}
+	if username == "" {
+		username = "admin"
+	}
+	email := os.Getenv("INFRA_CORE_ADMIN_EMAIL")
+	if email == "" {
+		email = username + "@localhost"
+	}
+
+	user, generated, err := userHandler.BootstrapAdmin(username, email, password)
+	if err != nil {
+		log.Fatalf("❌ Failed to create admin account: %v", err)
+	}
+	if user == nil {
+		return
+	}
+	if generated != "" {
+		log.Printf("🔑 Created admin account %s with generated password: %s", user.Username, generated)
+		log.Printf("⚠️ The password is not shown again, change it after signing in")
+		return
 	}
+	log.Printf("🔑 Created admin account %s", user.Username)
 }
@@ -1,16 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/router"
+	"github.com/last-emo-boy/infra-core/pkg/tracing"
 )
 
 func TestMain(m *testing.M) {
@@ -294,4 +308,194 @@ func TestHealthCheckResponseFormat(t *testing.T) {
 		assert.Contains(t, response, field, "Response should contain field: %s", field)
 		assert.NotEmpty(t, response[field], "Field %s should not be empty", field)
 	}
-}
\ No newline at end of file
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth: config.AuthConfig{
+				JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24},
+			},
+		},
+	}
+	srv, err := newConsoleServer(cfg, "test")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	srv.router.GET("/slow", func(c *gin.Context) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		c.String(http.StatusOK, "done")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	baseURL := "http://" + listener.Addr().String()
+
+	// Live but not ready until the background services started
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"starting"`)
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- srv.run(listener, quit)
+	}()
+
+	resp, err := http.Get(baseURL + "/api/v1/health/ready")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(baseURL + "/slow")
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		slow <- result{status: resp.StatusCode, body: string(body), err: err}
+	}()
+	<-started
+
+	process, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, process.Signal(syscall.SIGTERM))
+
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Console should shut down after SIGTERM")
+	}
+
+	// The request in flight was allowed to finish
+	completed := <-slow
+	require.NoError(t, completed.err)
+	assert.Equal(t, http.StatusOK, completed.status)
+	assert.Equal(t, "done", completed.body)
+
+	// Readiness fails from the start of shutdown
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/health/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"shutting_down"`)
+
+	// Background services stopped before the database was closed
+	srv.auditLogger.Log(&database.AuditLog{Action: "test"})
+	assert.Equal(t, int64(1), srv.auditLogger.Dropped())
+	assert.Error(t, srv.db.HealthCheck())
+}
+
+func TestHealthReportsDaemons(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	snapDaemon := httptest.NewServer(http.NotFoundHandler())
+	snapDaemon.Close()
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth: config.AuthConfig{
+				JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24},
+			},
+			Daemons: config.DaemonsConfig{SnapURL: snapDaemon.URL},
+		},
+	}
+	srv, err := newConsoleServer(cfg, "test")
+	require.NoError(t, err)
+	defer srv.shutdown(context.Background())
+	srv.health.SetStarted()
+
+	// An unreachable daemon degrades the console without failing readiness
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var report health.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, health.StatusDegraded, report.Status)
+	assert.True(t, report.Ready)
+	require.Contains(t, report.Checks, "snap")
+	assert.Equal(t, health.StatusUnhealthy, report.Checks["snap"].Status)
+	assert.Equal(t, health.StatusHealthy, report.Checks["database"].Status)
+	assert.Equal(t, health.StatusHealthy, report.Checks["data_dir"].Status)
+
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/health/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Losing the database fails both
+	require.NoError(t, srv.db.Close())
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestTracePropagation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tracing.Install(provider)()
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth: config.AuthConfig{
+				JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24},
+			},
+		},
+	}
+	srv, err := newConsoleServer(cfg, "test")
+	require.NoError(t, err)
+	defer srv.shutdown(context.Background())
+
+	console := httptest.NewServer(srv.router)
+	defer console.Close()
+
+	gate := router.NewRouter(cfg)
+	require.NoError(t, gate.AddRoute(&router.Route{ID: "console", PathPrefix: "/", Upstream: console.URL}))
+
+	w := httptest.NewRecorder()
+	gate.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/setup/status", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	gateSpan, ok := spans["gate GET"]
+	require.True(t, ok, "gate span missing")
+	consoleSpan, ok := spans["GET /api/v1/setup/status"]
+	require.True(t, ok, "console span missing")
+	dbSpan, ok := spans["SELECT users"]
+	require.True(t, ok, "database span missing")
+
+	// One trace from the gate down to the database
+	traceID := gateSpan.SpanContext.TraceID()
+	assert.Equal(t, traceID, consoleSpan.SpanContext.TraceID())
+	assert.Equal(t, traceID, dbSpan.SpanContext.TraceID())
+	assert.Equal(t, gateSpan.SpanContext.SpanID(), consoleSpan.Parent.SpanID())
+	assert.Equal(t, consoleSpan.SpanContext.SpanID(), dbSpan.Parent.SpanID())
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/last-emo-boy/infra-core/pkg/config"
@@ -10,11 +12,23 @@ import (
 )
 
 func main() {
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.Read()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if *printConfig {
+		if err := cfg.WriteRedacted(os.Stdout); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	fmt.Println("Testing Infra-Core Database System")
 	fmt.Printf("Database Path: %s\n", cfg.Console.Database.Path)
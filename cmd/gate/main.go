@@ -2,24 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/last-emo-boy/infra-core/pkg/acme"
 	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
 	"github.com/last-emo-boy/infra-core/pkg/router"
+	"github.com/last-emo-boy/infra-core/pkg/upgrade"
+	"github.com/last-emo-boy/infra-core/pkg/tracing"
 )
 
 func main() {
 	var (
-		version    = flag.Bool("version", false, "Show version information")
+		version     = flag.Bool("version", false, "Show version information")
+		printConfig = flag.Bool("print-config", false, "Print the effective configuration with secrets redacted and exit")
 	)
 	flag.Parse()
 
@@ -30,50 +41,131 @@ func main() {
 	}
 
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.Read()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if *printConfig {
+		if err := cfg.WriteRedacted(os.Stdout); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	closeLogs, err := logging.Setup("gate", cfg.Gate.Logs, cfg.Environment())
+	if err != nil {
+		log.Printf("Warning: Logging to stderr only: %v", err)
+	}
+	defer closeLogs()
+
+	shutdownTracing, err := tracing.Setup("gate", cfg.Tracing)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
 	fmt.Printf("Starting Infra-Core Gate v1.0.0\n")
 	fmt.Printf("HTTP Port: %d\n", cfg.Gate.Ports.HTTP)
 	fmt.Printf("HTTPS Port: %d\n", cfg.Gate.Ports.HTTPS)
 	fmt.Printf("Data Directory: %s\n", cfg.Gate.ACME.CacheDir)
 
+	// Pick up listeners handed over by systemd or a previous Gate process
+	upgrader, err := upgrade.New(cfg.Gate.Upgrade.ReusePort)
+	if err != nil {
+		log.Fatalf("Failed to initialize upgrader: %v", err)
+	}
+	if status := upgrader.Status(); status.ListenerSource != upgrade.SourceFresh {
+		fmt.Printf("Using %s listeners (generation %d)\n", status.ListenerSource, status.Generation)
+	}
+
 	// Create router
 	r := router.NewRouter(cfg)
+	if err := r.SetTrustedProxies(cfg.Gate.TrustedProxies); err != nil {
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
+	}
+	if err := r.SetDefaultAccessControl(cfg.Gate.AccessControl); err != nil {
+		log.Fatalf("Failed to configure access control: %v", err)
+	}
+	if err := r.LoadErrorPages(cfg.Gate.ErrorPages.Dir); err != nil {
+		log.Fatalf("Failed to load error pages: %v", err)
+	}
+
+	// Log requests in the background, never delaying them
+	if cfg.Gate.AccessLog.Enabled {
+		accessLog, err := router.NewAccessLogger(cfg.Gate.AccessLog)
+		if err != nil {
+			log.Fatalf("Failed to open access log: %v", err)
+		}
+		accessLog.Start()
+		defer accessLog.Stop()
+		r.SetAccessLogger(accessLog)
+	}
+
+	// Export per-route traffic to the console's metrics, keeping what the
+	// console could not take until the next flush
+	exportCtx, stopExport := context.WithCancel(context.Background())
+	defer stopExport()
+	exportDone := make(chan struct{})
+	if cfg.Gate.MetricsExport.Enabled {
+		writer, closeWriter, err := newMetricsWriter(cfg)
+		if err != nil {
+			log.Fatalf("Failed to set up metrics export: %v", err)
+		}
+		defer closeWriter()
+		exporter := router.NewTrafficExporter(r, writer, cfg.Gate.MetricsExport)
+		r.SetTrafficExporter(exporter)
+		go func() {
+			defer close(exportDone)
+			exporter.Run(exportCtx)
+		}()
+	} else {
+		close(exportDone)
+	}
 
-	// Create ACME client for HTTPS
-	var acmeClient *acme.Client
-	if cfg.Gate.ACME.Email != "" {
+	// Issue certificates for HTTPS from the local CA in self-signed mode,
+	// otherwise through ACME
+	var (
+		acmeClient *acme.Client
+		localCA    *acme.LocalCA
+		certs      certificateManager
+	)
+	if cfg.Gate.TLS.Mode == config.TLSModeSelfSigned {
+		validity, _ := config.ParseRetention(cfg.Gate.TLS.LeafValidity) // validated on load, zero falls back to the default
+		localCA, err = acme.NewLocalCA(cfg.Gate.ACME.CacheDir, validity)
+		if err != nil {
+			log.Fatalf("Failed to load local CA: %v", err)
+		}
+		certs = localCA
+		fmt.Println("Issuing self-signed certificates from the local CA")
+	} else if cfg.Gate.ACME.Email != "" {
 		acmeClient, err = acme.NewClient(cfg)
 		if err != nil {
 			log.Printf("Warning: Failed to create ACME client: %v", err)
 		} else {
+			certs = acmeClient
 			fmt.Println("ACME client initialized successfully")
 		}
 	}
 
-	err = r.AddRoute(&router.Route{
-		ID:         "console",
-		Host:       "",
-		PathPrefix: "/console",
-		Upstream:   fmt.Sprintf("http://127.0.0.1:%d", cfg.Console.Port),
-	})
-	if err != nil {
-		log.Printf("Warning: Failed to add console route: %v", err)
+	// Record issued certificates in the shared database for the console
+	if certs != nil {
+		db, err := database.NewDB(cfg)
+		if err != nil {
+			log.Printf("Warning: Not recording certificates: %v", err)
+		} else {
+			defer db.Close()
+			certs.SetRepository(db.CertificateRepository())
+		}
 	}
 
-	// Add default route to hello service
-	err = r.AddRoute(&router.Route{
-		ID:         "default",
-		Host:       "",
-		PathPrefix: "/",
-		Upstream:   fmt.Sprintf("http://127.0.0.1:%d", cfg.Console.Port),
-	})
-	if err != nil {
-		log.Printf("Warning: Failed to add default route: %v", err)
-	}
+	// Add the static routes, serving the starting page on those waiting for
+	// their upstream rather than failing with 502
+	routesCtx, stopRoutes := context.WithCancel(context.Background())
+	defer stopRoutes()
+	addStaticRoutes(routesCtx, r, staticRoutes(cfg), router.DefaultReadyInterval)
 
 	// Create HTTP handler with ACME support
 	var httpHandler http.Handler = r
@@ -91,8 +183,32 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Create HTTPS server, selecting certificates by SNI
+	var tlsConfig *tls.Config
+	if localCA != nil {
+		tlsConfig = localCA.TLSConfig()
+	} else {
+		defaultCert, err := loadDefaultCertificate(cfg)
+		if err != nil {
+			log.Fatalf("Failed to load default certificate: %v", err)
+		}
+		tlsConfig = acmeClient.TLSConfig(defaultCert)
+	}
+	httpsServer := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.Gate.Ports.HTTPS),
+		Handler:      r,
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
 	// Create metrics server
-	metricsHandler := createMetricsHandler(r)
+	monitor := newGateMonitor(cfg, r, upgrader)
+	metricsHandler := createMetricsHandler(r, monitor)
+	if certs != nil {
+		metricsHandler = createCertificateHandler(metricsHandler, certs, localCA)
+	}
 	metricsServer := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Gate.Ports.HTTP+1000),
 		Handler:      metricsHandler,
@@ -100,65 +216,251 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	httpListener, err := upgrader.Listen("http", httpServer.Addr)
+	if err != nil {
+		log.Fatalf("HTTP server failed: %v", err)
+	}
+	httpsListener, err := upgrader.Listen("https", httpsServer.Addr)
+	if err != nil {
+		log.Fatalf("HTTPS server failed: %v", err)
+	}
+	metricsListener, err := upgrader.Listen("metrics", metricsServer.Addr)
+	if err != nil {
+		log.Fatalf("Metrics server failed: %v", err)
+	}
+
 	// Start servers in goroutines
 	go func() {
 		fmt.Printf("HTTP server listening on :%d\n", cfg.Gate.Ports.HTTP)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := httpServer.Serve(httpListener); err != nil && !stopped(err) {
 			log.Fatalf("HTTP server failed: %v", err)
 		}
 	}()
 
+	go func() {
+		fmt.Printf("HTTPS server listening on :%d\n", cfg.Gate.Ports.HTTPS)
+		if err := httpsServer.Serve(tls.NewListener(httpsListener, httpsServer.TLSConfig)); err != nil && !stopped(err) {
+			log.Fatalf("HTTPS server failed: %v", err)
+		}
+	}()
+
 	go func() {
 		fmt.Printf("Metrics server listening on :%d\n", cfg.Gate.Ports.HTTP+1000)
-		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := metricsServer.Serve(metricsListener); err != nil && !stopped(err) {
 			log.Fatalf("Metrics server failed: %v", err)
 		}
 	}()
 
-	// Wait for interrupt signal
+	// Keep managed certificates current without a restart
+	renewCtx, stopRenewal := context.WithCancel(context.Background())
+	defer stopRenewal()
+	if certs != nil {
+		go renewCertificates(renewCtx, certs, certificateRenewInterval)
+	}
+
+	// Let the previous Gate process, if any, stop accepting and drain
+	if err := upgrader.Ready(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	monitor.SetStarted()
+
+	// Wait for interrupt or upgrade signal
+	signals := []os.Signal{os.Interrupt, syscall.SIGTERM}
+	if upgrade.Signal != nil {
+		signals = append(signals, upgrade.Signal)
+	}
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, signals...)
 
-	fmt.Println("\nShutting down Gate...")
+	for sig := range sigChan {
+		if sig != upgrade.Signal {
+			fmt.Println("\nShutting down Gate...")
+			break
+		}
 
-	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+		fmt.Println("Upgrading Gate...")
+		if err := upgrader.Upgrade(); err != nil {
+			log.Printf("Upgrade failed, continuing to serve: %v", err)
+			continue
+		}
+		fmt.Printf("New Gate process %d is serving, draining connections\n", upgrader.Status().ChildPID)
+		break
+	}
 
-	if err := httpServer.Shutdown(ctx); err != nil {
-		log.Printf("HTTP server shutdown error: %v", err)
+	// Graceful shutdown, failing readiness and letting in-flight requests finish
+	monitor.SetDraining()
+	drainTimeout := 30 * time.Second
+	if cfg.Gate.Upgrade.DrainTimeout != "" {
+		drainTimeout, _ = time.ParseDuration(cfg.Gate.Upgrade.DrainTimeout) // validated on load
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
 
-	if err := metricsServer.Shutdown(ctx); err != nil {
-		log.Printf("Metrics server shutdown error: %v", err)
+	if err := upgrader.Drain(ctx, httpServer, httpsServer, metricsServer); err != nil {
+		log.Printf("Server shutdown error: %v", err)
 	}
 
+	// Write the traffic of the last interval
+	stopExport()
+	<-exportDone
+
 	fmt.Println("Gate stopped")
 }
 
-// createMetricsHandler creates an HTTP handler for metrics endpoint
-func createMetricsHandler(r *router.Router) http.Handler {
-	mux := http.NewServeMux()
+// stopped reports whether a server stopped serving because it was drained,
+// which closes its listener before shutting it down
+func stopped(err error) bool {
+	return errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed)
+}
+
+// certificateRenewInterval is how often managed certificates are reloaded and renewed
+const certificateRenewInterval = 12 * time.Hour
+
+// staticRoutes returns the gate's static routes or, when none are configured,
+// routes to the console held until it is ready
+func staticRoutes(cfg *config.Config) []config.StaticRouteConfig {
+	if len(cfg.Gate.StaticRoutes) > 0 {
+		return cfg.Gate.StaticRoutes
+	}
 
-	// Health check endpoint
-	mux.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
-		ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
-		defer cancel()
+	console := fmt.Sprintf("http://127.0.0.1:%d", cfg.Console.Port)
+	return []config.StaticRouteConfig{
+		{ID: "console", Path: "/console", Upstream: console, ReadyPath: "/health/ready"},
+		{ID: "default", Path: "/", Upstream: console, ReadyPath: "/health/ready"},
+	}
+}
 
-		if err := r.HealthCheck(ctx); err != nil {
-			http.Error(w, fmt.Sprintf("Health check failed: %v", err), http.StatusServiceUnavailable)
+// addStaticRoutes adds static routes to the router and releases the held
+// ones once their upstream is ready, polling each ready URL once for all the
+// routes sharing it until ctx is cancelled
+func addStaticRoutes(ctx context.Context, r *router.Router, routes []config.StaticRouteConfig, interval time.Duration) {
+	var readyURLs []string
+	held := make(map[string][]string)
+	for _, route := range routes {
+		if err := r.AddRoute(router.StaticRoute(route)); err != nil {
+			log.Printf("Warning: Failed to add route %s: %v", route.ID, err)
+			continue
+		}
+		readyURL := router.ReadyURL(route)
+		if readyURL == "" {
+			continue
+		}
+		if _, exists := held[readyURL]; !exists {
+			readyURLs = append(readyURLs, readyURL)
+		}
+		held[readyURL] = append(held[readyURL], route.ID)
+	}
+
+	for _, readyURL := range readyURLs {
+		go r.ReleaseWhenReady(ctx, readyURL, interval, held[readyURL]...)
+	}
+}
+
+// loadDefaultCertificate loads the configured default certificate, or generates
+// a self-signed one for the gate host when none is configured
+func loadDefaultCertificate(cfg *config.Config) (*tls.Certificate, error) {
+	if cfg.Gate.TLS.DefaultCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Gate.TLS.DefaultCert, cfg.Gate.TLS.DefaultKey)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}
+
+	hosts := []string{"localhost"}
+	if ip := net.ParseIP(cfg.Gate.Host); ip == nil || !ip.IsUnspecified() {
+		hosts = append(hosts, cfg.Gate.Host)
+	}
+	return acme.SelfSignedCertificate(hosts...)
+}
+
+// certificateManager issues the gate's certificates, either the ACME client or
+// the local CA
+type certificateManager interface {
+	SetRepository(repo *database.CertificateRepository)
+	ListCertificates() map[string]*acme.CertificateFiles
+	RenewCertificate(domain string) error
+	RenewExpiring() error
+}
+
+// renewCertificates periodically picks up certificates renewed by other gate
+// processes and renews those expiring soon, until ctx is cancelled
+func renewCertificates(ctx context.Context, certs certificateManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
+			// Only ACME certificates are shared through the cache directory
+			if acmeClient, ok := certs.(*acme.Client); ok {
+				if err := acmeClient.ReloadCertificates(); err != nil {
+					log.Printf("❌ Failed to reload certificates: %v", err)
+				}
+			}
+			if err := certs.RenewExpiring(); err != nil {
+				log.Printf("❌ Failed to renew certificates: %v", err)
+			}
 		}
+	}
+}
 
-		w.Header().Set("Content-Type", "application/json")
+// newGateMonitor creates the gate's health monitor. The gate is ready once it
+// serves and has routes, and degraded while none of their upstreams is up.
+func newGateMonitor(cfg *config.Config, r *router.Router, upgrader *upgrade.Upgrader) *health.Monitor {
+	monitor := health.New("gate")
+	monitor.AddCheck(health.Check{
+		Name:     "router",
+		Critical: true,
+		Run: func(ctx context.Context) error {
+			err := r.HealthCheck(ctx)
+			if errors.Is(err, router.ErrNoUpstreamReachable) {
+				return health.Degraded(err)
+			}
+			return err
+		},
+	})
+	if cfg.Gate.ACME.CacheDir != "" {
+		monitor.AddCheck(health.WritableDir("data_dir", cfg.Gate.ACME.CacheDir))
+	}
+	if upgrader != nil {
+		monitor.AddDetail("upgrade", func() interface{} { return upgrader.Status() })
+	}
+	return monitor
+}
+
+// createMetricsHandler creates an HTTP handler for metrics endpoint
+func createMetricsHandler(r *router.Router, monitor *health.Monitor) http.Handler {
+	mux := http.NewServeMux()
+
+	// Liveness, readiness and detailed health endpoints
+	monitor.HandleFunc(mux)
+
+	// Prometheus metrics endpoint
+	writePrometheus := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", router.PrometheusContentType)
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, `{"status":"healthy","timestamp":"%s"}`, time.Now().Format(time.RFC3339))
+		if err := r.WritePrometheus(w); err != nil {
+			log.Printf("❌ Failed to write Prometheus metrics: %v", err)
+		}
+	}
+	mux.HandleFunc("/metrics/prometheus", func(w http.ResponseWriter, req *http.Request) {
+		writePrometheus(w)
 	})
 
-	// Metrics endpoint
+	// Metrics endpoint, serving the Prometheus text format to scrapers that ask for it
 	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		if wantsPrometheus(req) {
+			writePrometheus(w)
+			return
+		}
+
 		metrics := r.GetMetrics()
+		upstreams, _ := json.Marshal(metrics.Upstreams)
+		cache, _ := json.Marshal(metrics.Cache)
+		circuits, _ := json.Marshal(metrics.Circuits)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -168,11 +470,17 @@ func createMetricsHandler(r *router.Router) http.Handler {
 			"request_count": %v,
 			"error_count": %v,
 			"response_times": %v,
+			"upstreams": %s,
+			"cache": %s,
+			"circuits": %s,
 			"timestamp": "%s"
 		}`,
 			formatMetricsMap(metrics.RequestCount),
 			formatMetricsMap(metrics.ErrorCount),
 			formatMetricsMap(metrics.ResponseTimes),
+			upstreams,
+			cache,
+			circuits,
 			time.Now().Format(time.RFC3339),
 		)
 	})
@@ -182,28 +490,111 @@ func createMetricsHandler(r *router.Router) http.Handler {
 		switch req.Method {
 		case http.MethodGet:
 			routes := r.ListRoutes()
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
+			sort.Slice(routes, func(i, j int) bool {
+				return routes[i].ID < routes[j].ID
+			})
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"routes":   routes,
+				"count":    len(routes),
+				"circuits": r.CircuitStates(),
+			})
+
+		case http.MethodPost:
+			route, err := decodeRoute(req)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+				return
+			}
 
-			fmt.Fprintf(w, `{"routes":[`)
-			for i, route := range routes {
-				if i > 0 {
-					fmt.Fprintf(w, ",")
-				}
-				fmt.Fprintf(w, `{
-					"id": "%s",
-					"host": "%s", 
-					"path_prefix": "%s",
-					"upstream": "%s",
-					"created_at": "%s",
-					"updated_at": "%s"
-				}`,
-					route.ID, route.Host, route.PathPrefix, route.Upstream,
-					route.CreatedAt.Format(time.RFC3339),
-					route.UpdatedAt.Format(time.RFC3339),
-				)
+			if err := r.AddRoute(route); err != nil {
+				writeRouteError(w, err)
+				return
 			}
-			fmt.Fprintf(w, `],"count":%d}`, len(routes))
+
+			log.Printf("➕ Added route %s -> %s", route.ID, route.PathPrefix)
+			writeJSON(w, http.StatusCreated, route)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Route matching diagnostics endpoint
+	mux.HandleFunc("/routes/explain", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := req.URL.Query()
+		explainReq, err := router.ExplainRequest(query.Get("host"), query.Get("path"), query.Get("method"), query["header"])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(r.Explain(explainReq))
+	})
+
+	// Single route management endpoint, used to adjust upstream weights at runtime
+	mux.HandleFunc("/routes/", func(w http.ResponseWriter, req *http.Request) {
+		routeID := strings.TrimPrefix(req.URL.Path, "/routes/")
+		if routeID == "" {
+			http.NotFound(w, req)
+			return
+		}
+
+		// Purge the responses cached for a route
+		if cacheRouteID, ok := strings.CutSuffix(routeID, "/cache"); ok {
+			if req.Method != http.MethodDelete {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			purged, err := r.PurgeCache(cacheRouteID)
+			if err != nil {
+				writeRouteError(w, err)
+				return
+			}
+			log.Printf("🧹 Purged %d cached responses of route %s", purged, cacheRouteID)
+			writeJSON(w, http.StatusOK, map[string]interface{}{"route": cacheRouteID, "purged": purged})
+			return
+		}
+
+		switch req.Method {
+		case http.MethodGet:
+			route, err := r.GetRoute(routeID)
+			if err != nil {
+				writeRouteError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, route)
+
+		case http.MethodPut:
+			route, err := decodeRoute(req)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+				return
+			}
+			route.ID = routeID
+
+			if err := r.UpdateRoute(route); err != nil {
+				writeRouteError(w, err)
+				return
+			}
+
+			log.Printf("🔄 Updated route %s", route.ID)
+			writeJSON(w, http.StatusOK, route)
+
+		case http.MethodDelete:
+			if err := r.RemoveRoute(routeID); err != nil {
+				writeRouteError(w, err)
+				return
+			}
+
+			log.Printf("➖ Removed route %s", routeID)
+			w.WriteHeader(http.StatusNoContent)
 
 		default:
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -213,6 +604,50 @@ func createMetricsHandler(r *router.Router) http.Handler {
 	return mux
 }
 
+// decodeRoute reads a route from a JSON request body
+func decodeRoute(req *http.Request) (*router.Route, error) {
+	var route router.Route
+	if err := json.NewDecoder(req.Body).Decode(&route); err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
+
+// writeRouteError maps a router error to an HTTP status
+func writeRouteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, router.ErrRouteNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, router.ErrRouteExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("❌ Failed to write response: %v", err)
+	}
+}
+
+// wantsPrometheus reports whether a metrics request asks for the Prometheus text format,
+// either with ?format=prometheus or an Accept header naming text/plain or OpenMetrics
+func wantsPrometheus(req *http.Request) bool {
+	if format := req.URL.Query().Get("format"); format != "" {
+		return format == "prometheus"
+	}
+
+	accept := req.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") {
+		return false
+	}
+	return strings.Contains(accept, "text/plain") || strings.Contains(accept, "application/openmetrics-text")
+}
+
 // formatMetricsMap formats a metrics map for JSON output
 func formatMetricsMap(m map[string]int64) string {
 	if len(m) == 0 {
@@ -236,19 +671,56 @@ func formatMetricsMap(m map[string]int64) string {
 func createACMEHandler(router http.Handler, acmeClient *acme.Client) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Handle ACME challenges first, before routing
-		if strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
-			// Extract token from path
-			token := r.URL.Path[len("/.well-known/acme-challenge/"):]
-			if token != "" {
-				// Serve ACME challenge response
-				w.Header().Set("Content-Type", "text/plain")
-				w.WriteHeader(http.StatusOK)
-				fmt.Fprintf(w, "ACME challenge token: %s", token)
-				return
-			}
+		if strings.HasPrefix(r.URL.Path, acme.ChallengePathPrefix) && r.URL.Path != acme.ChallengePathPrefix {
+			acmeClient.ServeChallenge(w, r)
+			return
 		}
 
 		// Regular routing
 		router.ServeHTTP(w, r)
 	})
 }
+
+// createCertificateHandler adds the certificate renewal endpoint,
+// POST /certificates/{domain}/renew, to the management handler. Renewal waits
+// on the CA, so it runs in the background and the request is only accepted.
+// With a local CA, GET /certificates/ca returns its certificate.
+func createCertificateHandler(next http.Handler, certs certificateManager, localCA *acme.LocalCA) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		domain, ok := strings.CutPrefix(req.URL.Path, "/certificates/")
+		if !ok {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if domain == "ca" && localCA != nil {
+			if req.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"certificate": string(localCA.CACertificatePEM())})
+			return
+		}
+		domain, ok = strings.CutSuffix(domain, "/renew")
+		if !ok || domain == "" || strings.Contains(domain, "/") {
+			http.NotFound(w, req)
+			return
+		}
+		if req.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, exists := certs.ListCertificates()[domain]; !exists {
+			http.Error(w, acme.ErrUnknownDomain.Error(), http.StatusNotFound)
+			return
+		}
+
+		go func() {
+			if err := certs.RenewCertificate(domain); err != nil {
+				log.Printf("❌ Failed to renew certificate for %s: %v", domain, err)
+				return
+			}
+			log.Printf("🔄 Renewed certificate for %s", domain)
+		}()
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"domain": domain, "status": "renewing"})
+	})
+}
@@ -4,15 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/acme"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/router"
 )
 
 func TestMain(m *testing.M) {
@@ -198,73 +205,130 @@ func TestACMEHandler(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("regular route"))
 	})
-	
-	// Create ACME handler (simplified version)
-	acmeHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Handle ACME challenges first
-		if strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
-			token := r.URL.Path[len("/.well-known/acme-challenge/"):]
-			if token != "" {
-				w.Header().Set("Content-Type", "text/plain")
-				w.WriteHeader(http.StatusOK)
-				fmt.Fprintf(w, "ACME challenge token: %s", token)
-				return
-			}
-		}
-		
-		// Regular routing
-		mockRouter.ServeHTTP(w, r)
-	})
-	
-	server := httptest.NewServer(acmeHandler)
+
+	acmeClient := &acme.Client{}
+	require.NoError(t, acmeClient.SetChallengeResponse("test-token", "test-token.account-thumbprint"))
+
+	server := httptest.NewServer(createACMEHandler(mockRouter, acmeClient))
 	defer server.Close()
-	
-	// Test ACME challenge
-	t.Run("ACME challenge", func(t *testing.T) {
-		resp, err := http.Get(server.URL + "/.well-known/acme-challenge/test-token")
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := http.Get(server.URL + path)
 		require.NoError(t, err)
 		defer resp.Body.Close()
-		
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	// Test ACME challenge
+	t.Run("ACME challenge", func(t *testing.T) {
+		resp, body := get("/.well-known/acme-challenge/test-token")
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
-		
-		body := make([]byte, 1024)
-		n, _ := resp.Body.Read(body)
-		bodyStr := string(body[:n])
-		
-		assert.Equal(t, "ACME challenge token: test-token", bodyStr)
+		assert.Equal(t, "test-token.account-thumbprint", body)
 	})
-	
+
+	// Test unknown token
+	t.Run("ACME challenge unknown token", func(t *testing.T) {
+		resp, body := get("/.well-known/acme-challenge/other-token")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.NotEqual(t, "regular route", body)
+	})
+
 	// Test empty token
 	t.Run("ACME challenge empty token", func(t *testing.T) {
-		resp, err := http.Get(server.URL + "/.well-known/acme-challenge/")
-		require.NoError(t, err)
-		defer resp.Body.Close()
-		
 		// Should fall through to regular routing
+		resp, body := get("/.well-known/acme-challenge/")
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
-		
-		body := make([]byte, 1024)
-		n, _ := resp.Body.Read(body)
-		bodyStr := string(body[:n])
-		
-		assert.Equal(t, "regular route", bodyStr)
+		assert.Equal(t, "regular route", body)
 	})
-	
+
 	// Test regular route
 	t.Run("regular route", func(t *testing.T) {
-		resp, err := http.Get(server.URL + "/api/test")
-		require.NoError(t, err)
-		defer resp.Body.Close()
-		
+		resp, body := get("/api/test")
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
-		
-		body := make([]byte, 1024)
-		n, _ := resp.Body.Read(body)
-		bodyStr := string(body[:n])
-		
-		assert.Equal(t, "regular route", bodyStr)
+		assert.Equal(t, "regular route", body)
+	})
+}
+
+func TestCertificateHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("management"))
 	})
+	handler := createCertificateHandler(next, &acme.Client{}, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{name: "other endpoint", method: http.MethodGet, path: "/metrics", want: http.StatusOK},
+		{name: "unknown domain", method: http.MethodPost, path: "/certificates/missing.example.com/renew", want: http.StatusNotFound},
+		{name: "no domain", method: http.MethodPost, path: "/certificates//renew", want: http.StatusNotFound},
+		{name: "unknown action", method: http.MethodPost, path: "/certificates/example.com", want: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodGet, path: "/certificates/example.com/renew", want: http.StatusMethodNotAllowed},
+		{name: "CA without a local CA", method: http.MethodGet, path: "/certificates/ca", want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, tt.want, w.Code)
+			if tt.want == http.StatusOK {
+				assert.Equal(t, "management", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLocalCACertificateHandler(t *testing.T) {
+	localCA, err := acme.NewLocalCA(t.TempDir(), 0)
+	require.NoError(t, err)
+	minted, err := localCA.Certificate("gate.lan")
+	require.NoError(t, err)
+	handler := createCertificateHandler(http.NotFoundHandler(), localCA, localCA)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/certificates/ca", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, string(localCA.CACertificatePEM()), response["certificate"])
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/certificates/gate.lan/renew", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	require.Eventually(t, func() bool {
+		renewed, err := localCA.Certificate("gate.lan")
+		return err == nil && renewed != minted
+	}, 5*time.Second, 10*time.Millisecond, "the certificate is minted again in the background")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/certificates/other.lan/renew", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestLoadDefaultCertificate(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Gate.Host = "gate.example.com"
+
+	cert, err := loadDefaultCertificate(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"localhost", "gate.example.com"}, cert.Leaf.DNSNames)
+
+	// An unspecified bind address is left out of the certificate
+	cfg.Gate.Host = "0.0.0.0"
+	cert, err = loadDefaultCertificate(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"localhost"}, cert.Leaf.DNSNames)
+	assert.Empty(t, cert.Leaf.IPAddresses)
+
+	cfg.Gate.TLS.DefaultCert = "missing.crt"
+	cfg.Gate.TLS.DefaultKey = "missing.key"
+	_, err = loadDefaultCertificate(cfg)
+	assert.Error(t, err)
 }
 
 func TestServerConfiguration(t *testing.T) {
@@ -295,6 +359,273 @@ func TestServerConfiguration(t *testing.T) {
 	assert.Equal(t, 10*time.Second, metricsServer.WriteTimeout)
 }
 
+func TestUpdateRouteWeights(t *testing.T) {
+	r := router.NewRouter(&config.Config{})
+	require.NoError(t, r.AddRoute(&router.Route{
+		ID:         "app",
+		PathPrefix: "/",
+		Upstream:   "http://127.0.0.1:8081",
+	}))
+
+	server := httptest.NewServer(createMetricsHandler(r, health.New("gate")))
+	defer server.Close()
+
+	put := func(path, body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPut, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("update weights", func(t *testing.T) {
+		resp := put("/routes/app", `{
+			"path_prefix": "/",
+			"upstreams": [
+				{"url": "http://127.0.0.1:8081", "weight": 90},
+				{"url": "http://127.0.0.1:8082", "weight": 10}
+			],
+			"sticky": "ip"
+		}`)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		route, err := r.GetRoute("app")
+		require.NoError(t, err)
+		require.Len(t, route.Upstreams, 2)
+		assert.Equal(t, 10, route.Upstreams[1].Weight)
+		assert.Equal(t, router.StickyIP, route.Sticky)
+	})
+
+	t.Run("unknown route", func(t *testing.T) {
+		resp := put("/routes/missing", `{"upstream": "http://127.0.0.1:8081"}`)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("invalid weights", func(t *testing.T) {
+		resp := put("/routes/app", `{"upstreams": [{"url": "http://127.0.0.1:8081", "weight": -1}]}`)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestRouteCRUD(t *testing.T) {
+	r := router.NewRouter(&config.Config{})
+	server := httptest.NewServer(createMetricsHandler(r, health.New("gate")))
+	defer server.Close()
+
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("create", func(t *testing.T) {
+		resp := do(http.MethodPost, "/routes", `{
+			"id": "api",
+			"host": "api.example.com",
+			"path_prefix": "/v1",
+			"upstream": "http://127.0.0.1:8081"
+		}`)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var route router.Route
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&route))
+		assert.Equal(t, "api", route.ID)
+		assert.False(t, route.CreatedAt.IsZero())
+
+		_, err := r.GetRoute("api")
+		assert.NoError(t, err)
+	})
+
+	t.Run("create rejects", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			body   string
+			status int
+		}{
+			{name: "duplicate ID", body: `{"id": "api", "upstream": "http://127.0.0.1:8082"}`, status: http.StatusConflict},
+			{name: "malformed JSON", body: `{"id": "web",`, status: http.StatusBadRequest},
+			{name: "missing ID", body: `{"upstream": "http://127.0.0.1:8082"}`, status: http.StatusBadRequest},
+			{name: "missing upstream", body: `{"id": "web"}`, status: http.StatusBadRequest},
+			{name: "invalid upstream", body: `{"id": "web", "upstream": "ftp://127.0.0.1"}`, status: http.StatusBadRequest},
+			{name: "invalid access control", body: `{"id": "web", "upstream": "http://127.0.0.1:8082", "access_control": {"deny_cidrs": ["office"]}}`, status: http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.status, do(http.MethodPost, "/routes", tt.body).StatusCode)
+			})
+		}
+		assert.Len(t, r.ListRoutes(), 1)
+	})
+
+	t.Run("list escapes fields", func(t *testing.T) {
+		resp := do(http.MethodPost, "/routes", `{"id": "quoted", "path_prefix": "/a\"b", "upstream": "http://127.0.0.1:8083"}`)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		resp = do(http.MethodGet, "/routes", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var list struct {
+			Routes []router.Route `json:"routes"`
+			Count  int            `json:"count"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+		assert.Equal(t, 2, list.Count)
+		require.Len(t, list.Routes, 2)
+		assert.Equal(t, "api", list.Routes[0].ID)
+		assert.Equal(t, `/a"b`, list.Routes[1].PathPrefix)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		resp := do(http.MethodGet, "/routes/api", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var route router.Route
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&route))
+		assert.Equal(t, "api.example.com", route.Host)
+
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/routes/missing", "").StatusCode)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		resp := do(http.MethodPut, "/routes/api", `{"host": "api.example.com", "path_prefix": "/v2", "upstream": "http://127.0.0.1:8084"}`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		route, err := r.GetRoute("api")
+		require.NoError(t, err)
+		assert.Equal(t, "/v2", route.PathPrefix)
+		assert.Equal(t, "http://127.0.0.1:8084", route.Upstream)
+		assert.Nil(t, route.AccessControl)
+
+		resp = do(http.MethodPut, "/routes/api", `{"path_prefix": "/v2", "upstream": "http://127.0.0.1:8084", "access_control": {"allow_cidrs": ["10.0.0.0/8"]}}`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		route, err = r.GetRoute("api")
+		require.NoError(t, err)
+		require.NotNil(t, route.AccessControl)
+		assert.Equal(t, []string{"10.0.0.0/8"}, route.AccessControl.AllowCIDRs)
+
+		assert.Equal(t, http.StatusNotFound, do(http.MethodPut, "/routes/missing", `{"upstream": "http://127.0.0.1:8084"}`).StatusCode)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/routes/api", `not json`).StatusCode)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/routes/api", `{"upstream": "127.0.0.1"}`).StatusCode)
+	})
+
+	t.Run("purge cache", func(t *testing.T) {
+		resp := do(http.MethodDelete, "/routes/api/cache", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "api", body["route"])
+		assert.Equal(t, float64(0), body["purged"])
+
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/routes/missing/cache", "").StatusCode)
+		assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "/routes/api/cache", "").StatusCode)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/routes/api", "").StatusCode)
+		_, err := r.GetRoute("api")
+		assert.ErrorIs(t, err, router.ErrRouteNotFound)
+
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/routes/api", "").StatusCode)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodPatch, "/routes", "").StatusCode)
+		assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodPost, "/routes/quoted", "").StatusCode)
+	})
+}
+
+func TestExplainRoute(t *testing.T) {
+	r := router.NewRouter(&config.Config{})
+	require.NoError(t, r.AddRoute(&router.Route{
+		ID:         "api",
+		PathPrefix: "/api",
+		Upstream:   "http://127.0.0.1:8081",
+	}))
+	require.NoError(t, r.AddRoute(&router.Route{
+		ID:         "api-canary",
+		PathPrefix: "/api",
+		Headers:    map[string]string{"X-Canary": "1"},
+		Upstream:   "http://127.0.0.1:8082",
+	}))
+
+	server := httptest.NewServer(createMetricsHandler(r, health.New("gate")))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/routes/explain?host=example.com&path=/api/users&header=X-Canary:1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var trace router.MatchTrace
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&trace))
+	require.NotNil(t, trace.Route)
+	assert.Equal(t, "api-canary", trace.Route.ID)
+	assert.Equal(t, http.MethodGet, trace.Method)
+	require.Len(t, trace.Candidates, 2)
+	assert.Equal(t, router.ReasonFewerConstraints, trace.Candidates[0].Reason)
+	assert.Equal(t, router.ReasonSelected, trace.Candidates[1].Reason)
+
+	resp, err = http.Post(server.URL+"/routes/explain", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	r := router.NewRouter(&config.Config{})
+	server := httptest.NewServer(createMetricsHandler(r, health.New("gate")))
+	defer server.Close()
+
+	get := func(path, accept string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		accept     string
+		prometheus bool
+	}{
+		{name: "json by default", path: "/metrics"},
+		{name: "json accept", path: "/metrics", accept: "application/json"},
+		{name: "text accept", path: "/metrics", accept: "text/plain;version=0.0.4;q=0.9,*/*;q=0.1", prometheus: true},
+		{name: "openmetrics accept", path: "/metrics", accept: "application/openmetrics-text;version=1.0.0", prometheus: true},
+		{name: "format query", path: "/metrics?format=prometheus", prometheus: true},
+		{name: "prometheus path", path: "/metrics/prometheus", prometheus: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := get(tt.path, tt.accept)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			if tt.prometheus {
+				assert.Equal(t, router.PrometheusContentType, resp.Header.Get("Content-Type"))
+				assert.Contains(t, body, "# TYPE gate_requests_total counter")
+				assert.Contains(t, body, "# TYPE gate_response_time_seconds histogram")
+			} else {
+				assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+				assert.True(t, json.Valid([]byte(body)), body)
+			}
+		})
+	}
+}
+
 func TestRouteStructure(t *testing.T) {
 	// Test route structure definition
 	type Route struct {
@@ -370,4 +701,136 @@ func TestShutdownTimeout(t *testing.T) {
 	
 	// Allow for small timing differences (within 1 second)
 	assert.True(t, timeDiff < time.Second && timeDiff > -time.Second)
-}
\ No newline at end of file
+}
+
+func TestGateHealthEndpoints(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Gate.ACME.CacheDir = t.TempDir()
+	r := router.NewRouter(cfg)
+	monitor := newGateMonitor(cfg, r, nil)
+	server := httptest.NewServer(createMetricsHandler(r, monitor))
+	defer server.Close()
+
+	get := func(path string) (int, map[string]interface{}) {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+
+	// Not ready until the listeners are serving
+	status, body := get("/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, health.StatusStarting, body["status"])
+	status, _ = get("/health/live")
+	assert.Equal(t, http.StatusOK, status)
+
+	// Without routes the gate cannot serve anything
+	monitor.SetStarted()
+	status, body = get("/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, health.StatusNotReady, body["status"])
+	status, body = get("/health")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, health.StatusUnhealthy, body["status"])
+
+	// With every upstream down the gate is ready but degraded
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	require.NoError(t, r.AddRoute(&router.Route{ID: "app", PathPrefix: "/", Upstream: down.URL}))
+	status, _ = get("/health/ready")
+	assert.Equal(t, http.StatusOK, status)
+	status, body = get("/health")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, health.StatusDegraded, body["status"])
+	checks := body["checks"].(map[string]interface{})
+	assert.Equal(t, health.StatusDegraded, checks["router"].(map[string]interface{})["status"])
+	assert.Equal(t, health.StatusHealthy, checks["data_dir"].(map[string]interface{})["status"])
+
+	up := httptest.NewServer(http.NotFoundHandler())
+	defer up.Close()
+	require.NoError(t, r.UpdateRoute(&router.Route{ID: "app", PathPrefix: "/", Upstream: up.URL}))
+	status, body = get("/health")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, health.StatusHealthy, body["status"])
+
+	// Readiness fails once shutdown begins
+	monitor.SetDraining()
+	status, body = get("/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, health.StatusShuttingDown, body["status"])
+}
+
+func TestStaticRoutes(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Console.Port = 8082
+
+	// Without static routes everything goes to the console once it is ready
+	routes := staticRoutes(cfg)
+	require.Len(t, routes, 2)
+	assert.Equal(t, config.StaticRouteConfig{ID: "console", Path: "/console", Upstream: "http://127.0.0.1:8082", ReadyPath: "/health/ready"}, routes[0])
+	assert.Equal(t, "/", routes[1].Path)
+
+	cfg.Gate.StaticRoutes = []config.StaticRouteConfig{{ID: "app", Upstream: "http://127.0.0.1:3000"}}
+	assert.Equal(t, cfg.Gate.StaticRoutes, staticRoutes(cfg))
+}
+
+func TestAddStaticRoutes(t *testing.T) {
+	var ready atomic.Bool
+	var polls atomic.Int64
+	console := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/health/ready" {
+			polls.Add(1)
+			if !ready.Load() {
+				http.Error(w, "starting", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprint(w, "console")
+	}))
+	defer console.Close()
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "app")
+	}))
+	defer app.Close()
+
+	r := router.NewRouter(&config.Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addStaticRoutes(ctx, r, []config.StaticRouteConfig{
+		{ID: "console", Path: "/console", Upstream: console.URL, ReadyPath: "/health/ready"},
+		{ID: "default", Path: "/", Upstream: console.URL, ReadyPath: "/health/ready"},
+		{ID: "app", Path: "/app", Upstream: app.URL},
+	}, 20*time.Millisecond)
+
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	// Routes without a ready path are proxied at once, the others hold
+	status, body := get("/app/")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "app", body)
+	status, body = get("/console/")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Contains(t, body, "starting up")
+	status, _ = get("/")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+
+	// Both console routes are released by a single poller
+	require.Eventually(t, func() bool { return polls.Load() >= 2 }, time.Second, 5*time.Millisecond)
+	ready.Store(true)
+	require.Eventually(t, func() bool {
+		consoleStatus, _ := get("/console/")
+		defaultStatus, _ := get("/")
+		return consoleStatus == http.StatusOK && defaultStatus == http.StatusOK
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	released := polls.Load()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, released, polls.Load(), "polling stops once released")
+}
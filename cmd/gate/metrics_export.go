@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/router"
+)
+
+// newMetricsWriter returns where the gate exports its traffic metrics: the
+// console's ingest endpoint when gate.metrics_export.console_url is set,
+// otherwise the database the gate shares with the console. The returned
+// function releases the writer.
+func newMetricsWriter(cfg *config.Config) (router.MetricsWriter, func(), error) {
+	export := cfg.Gate.MetricsExport
+	if export.ConsoleURL != "" {
+		token := export.Token
+		if token == "" {
+			token = cfg.Console.Metrics.IngestToken
+		}
+		console := client.NewConsole(export.ConsoleURL, token)
+		return router.MetricsWriterFunc(console.IngestMetrics), func() {}, nil
+	}
+
+	db, err := database.NewDB(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open the console database: %w", err)
+	}
+	return databaseMetricsWriter(db), func() { _ = db.Close() }, nil
+}
+
+// databaseMetricsWriter writes metrics to the metrics table, recording
+// those of routes with an upstream service as that service's
+func databaseMetricsWriter(db *database.DB) router.MetricsWriter {
+	return router.MetricsWriterFunc(func(ctx context.Context, metrics []*database.Metric) error {
+		bound := db.WithContext(ctx)
+		if err := bound.RouteRepository().AttributeMetrics(metrics); err != nil {
+			return err
+		}
+		return bound.MetricRepository().InsertBatch(metrics)
+	})
+}
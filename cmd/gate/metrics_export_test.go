@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/router"
+)
+
+func TestMetricsExportToDatabase(t *testing.T) {
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")}},
+	}
+	writer, closeWriter, err := newMetricsWriter(cfg)
+	require.NoError(t, err)
+	defer closeWriter()
+
+	// The console's routes name the service behind them
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	service := &database.Service{Name: "web", Image: "web:1", Port: 8090, Status: "running"}
+	require.NoError(t, db.ServiceRepository().Create(service))
+	require.NoError(t, db.RouteRepository().Create(&database.Route{ID: "web", Host: "web.example.com", PathPrefix: "/", UpstreamServiceID: &service.ID}))
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	r := router.NewRouter(cfg)
+	exporter := router.NewTrafficExporter(r, writer, cfg.Gate.MetricsExport)
+	r.SetTrafficExporter(exporter)
+	require.NoError(t, r.AddRoute(&router.Route{ID: "web", Host: "web.example.com", Upstream: backend.URL}))
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://web.example.com/", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	require.NoError(t, exporter.Flush(context.Background()))
+
+	metrics, err := db.MetricRepository().GetByService(service.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, metrics, 5)
+	for _, metric := range metrics {
+		if metric.MetricName == router.TrafficMetricRequests {
+			assert.Equal(t, 3.0, metric.MetricValue)
+		}
+	}
+}
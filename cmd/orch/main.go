@@ -2,22 +2,32 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
 	"github.com/last-emo-boy/infra-core/pkg/config"
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
 	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+	"github.com/last-emo-boy/infra-core/pkg/services"
+	"github.com/last-emo-boy/infra-core/pkg/tracing"
 )
 
 func main() {
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
 	log.Println("🎭 Starting InfraCore Orchestrator...")
 
 	// Load environment
@@ -27,10 +37,31 @@ func main() {
 	}
 
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.Read()
 	if err != nil {
 		log.Fatalf("❌ Failed to load configuration: %v", err)
 	}
+	if *printConfig {
+		if err := cfg.WriteRedacted(os.Stdout); err != nil {
+			log.Fatalf("❌ Failed to print configuration: %v", err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+
+	closeLogs, err := logging.Setup("orchestrator", cfg.Orchestrator.Logs, environment)
+	if err != nil {
+		log.Printf("⚠️ Logging to stderr only: %v", err)
+	}
+	defer closeLogs()
+
+	shutdownTracing, err := tracing.Setup("orchestrator", cfg.Tracing)
+	if err != nil {
+		log.Fatalf("❌ Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
 	log.Printf("📋 Environment: %s", environment)
 
@@ -41,71 +72,41 @@ func main() {
 	}
 	defer db.Close()
 
+	// With a primary, this orchestrator only runs the services the primary
+	// schedules on its node
+	if cfg.Orchestrator.Cluster.Primary != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		orchestrator.NewAgent(db, cfg).Run(ctx)
+		log.Println("✅ Node agent stopped")
+		return
+	}
+
 	// Create orchestrator
 	orch := orchestrator.New(db, cfg)
 
-	// Start orchestrator
-	if err := orch.Start(); err != nil {
-		log.Fatalf("❌ Failed to start orchestrator: %v", err)
-	}
+	// Ready once the orchestrator engine has started
+	monitor := health.New("orchestrator")
+	monitor.AddCheck(health.Database(db))
+	monitor.AddCheck(health.WritableDir("data_dir", filepath.Dir(cfg.Console.Database.Path)))
+	monitor.AddDetail("orchestrator", func() interface{} { return orch.GetStatus() })
 
 	// Set up Gin router
 	if environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	r := gin.Default()
+	r := gin.New()
+	r.Use(middleware.RequestIDMiddleware())
+	r.Use(middleware.TracingMiddleware())
+	r.Use(middleware.LoggingMiddleware())
+	r.Use(middleware.RecoveryMiddleware())
 
-	// Health check endpoint
-	r.GET("/health", func(c *gin.Context) {
-		status := orch.GetStatus()
-		c.JSON(http.StatusOK, gin.H{
-			"status":      "healthy",
-			"orchestrator": status,
-			"timestamp":   time.Now().Unix(),
-		})
-	})
+	// Liveness, readiness and detailed health endpoints
+	monitor.RegisterRoutes(r)
 
 	// API routes
-	api := r.Group("/api/v1")
-	{
-		// Service orchestration
-		services := api.Group("/services")
-		{
-			services.POST("/deploy", orch.DeployService)
-			services.POST("/:id/start", orch.StartService)
-			services.POST("/:id/stop", orch.StopService)
-			services.POST("/:id/restart", orch.RestartService)
-			services.DELETE("/:id", orch.RemoveService)
-			services.GET("/:id/status", orch.GetServiceStatus)
-			services.GET("/:id/logs", orch.GetServiceLogs)
-		}
-
-		// Deployment management
-		deployments := api.Group("/deployments")
-		{
-			deployments.GET("/", orch.ListDeployments)
-			deployments.GET("/:id", orch.GetDeployment)
-			deployments.POST("/:id/rollback", orch.RollbackDeployment)
-			deployments.DELETE("/:id", orch.DeleteDeployment)
-		}
-
-		// Cluster management
-		cluster := api.Group("/cluster")
-		{
-			cluster.GET("/nodes", orch.ListNodes)
-			cluster.GET("/resources", orch.GetClusterResources)
-			cluster.GET("/events", orch.GetClusterEvents)
-		}
-
-		// Orchestrator control
-		control := api.Group("/control")
-		{
-			control.POST("/sync", orch.SyncServices)
-			control.POST("/cleanup", orch.CleanupResources)
-			control.GET("/metrics", orch.GetMetrics)
-		}
-	}
+	orch.RegisterRoutes(r.Group("/api/v1"))
 
 	// Create HTTP server
 	port := cfg.Orchestrator.Port
@@ -130,12 +131,24 @@ func main() {
 		}
 	}()
 
+	// Start orchestrator while the server answers readiness checks with 503
+	if err := orch.Start(); err != nil {
+		log.Fatalf("❌ Failed to start orchestrator: %v", err)
+	}
+
+	// Collect the memory of the service processes the orchestrator runs
+	serviceMetrics := services.NewServiceMetricsCollector(db, cfg.Console.Metrics, orch)
+	serviceMetrics.Start()
+	defer serviceMetrics.Stop()
+	monitor.SetStarted()
+
 	// Wait for interrupt signal to gracefully shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Println("🛑 Shutting down orchestrator...")
+	monitor.SetDraining()
 
 	// Shutdown server with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -149,4 +162,4 @@ func main() {
 	orch.Stop()
 
 	log.Println("✅ Orchestrator shutdown complete")
-}
\ No newline at end of file
+}
@@ -2,22 +2,31 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
 	"github.com/last-emo-boy/infra-core/pkg/config"
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
 	"github.com/last-emo-boy/infra-core/pkg/probe"
+	"github.com/last-emo-boy/infra-core/pkg/tracing"
 )
 
 func main() {
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
 	log.Println("🔍 Starting InfraCore Probe Monitor...")
 
 	// Load environment
@@ -27,10 +36,31 @@ func main() {
 	}
 
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.Read()
 	if err != nil {
 		log.Fatalf("❌ Failed to load configuration: %v", err)
 	}
+	if *printConfig {
+		if err := cfg.WriteRedacted(os.Stdout); err != nil {
+			log.Fatalf("❌ Failed to print configuration: %v", err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+
+	closeLogs, err := logging.Setup("probe", cfg.Probe.Logs, environment)
+	if err != nil {
+		log.Printf("⚠️ Logging to stderr only: %v", err)
+	}
+	defer closeLogs()
+
+	shutdownTracing, err := tracing.Setup("probe", cfg.Tracing)
+	if err != nil {
+		log.Fatalf("❌ Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
 	log.Printf("📋 Environment: %s", environment)
 
@@ -44,71 +74,31 @@ func main() {
 	// Create probe monitor
 	probeMonitor := probe.New(db, cfg)
 
-	// Start probe monitor
-	if err := probeMonitor.Start(); err != nil {
-		log.Fatalf("❌ Failed to start probe monitor: %v", err)
-	}
+	// Ready once the probe monitor has started
+	monitor := health.New("probe")
+	monitor.AddCheck(health.Database(db))
+	monitor.AddCheck(health.WritableDir("data_dir", filepath.Dir(cfg.Console.Database.Path)))
+	monitor.AddDetail("probe", func() interface{} { return probeMonitor.GetStatus() })
 
 	// Set up Gin router
 	if environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	r := gin.Default()
+	r := gin.New()
+	r.Use(middleware.RequestIDMiddleware())
+	r.Use(middleware.TracingMiddleware())
+	r.Use(middleware.LoggingMiddleware())
+	r.Use(middleware.RecoveryMiddleware())
+	if cfg.MultiTenancy.Enabled {
+		r.Use(middleware.OrgScopeMiddleware())
+	}
 
-	// Health check endpoint
-	r.GET("/health", func(c *gin.Context) {
-		status := probeMonitor.GetStatus()
-		c.JSON(http.StatusOK, gin.H{
-			"status":    "healthy",
-			"probe":     status,
-			"timestamp": time.Now().Unix(),
-		})
-	})
+	// Liveness, readiness and detailed health endpoints
+	monitor.RegisterRoutes(r)
 
 	// API routes
-	api := r.Group("/api/v1")
-	{
-		// Probe configuration
-		probes := api.Group("/probes")
-		{
-			probes.POST("/", probeMonitor.CreateProbe)
-			probes.GET("/", probeMonitor.ListProbes)
-			probes.GET("/:id", probeMonitor.GetProbe)
-			probes.PUT("/:id", probeMonitor.UpdateProbe)
-			probes.DELETE("/:id", probeMonitor.DeleteProbe)
-			probes.POST("/:id/enable", probeMonitor.EnableProbe)
-			probes.POST("/:id/disable", probeMonitor.DisableProbe)
-		}
-
-		// Probe results and metrics
-		results := api.Group("/results")
-		{
-			results.GET("/", probeMonitor.ListResults)
-			results.GET("/:probe_id", probeMonitor.GetProbeResults)
-			results.GET("/:probe_id/latest", probeMonitor.GetLatestResult)
-			results.GET("/:probe_id/metrics", probeMonitor.GetProbeMetrics)
-			results.GET("/:probe_id/history", probeMonitor.GetProbeHistory)
-		}
-
-		// Health monitoring
-		health := api.Group("/health")
-		{
-			health.GET("/services", probeMonitor.GetServiceHealth)
-			health.GET("/services/:service_id", probeMonitor.GetServiceHealthDetail)
-			health.GET("/overview", probeMonitor.GetHealthOverview)
-			health.GET("/alerts", probeMonitor.GetActiveAlerts)
-		}
-
-		// Monitoring control
-		control := api.Group("/control")
-		{
-			control.POST("/scan", probeMonitor.TriggerFullScan)
-			control.POST("/cleanup", probeMonitor.CleanupOldResults)
-			control.GET("/metrics", probeMonitor.GetMonitorMetrics)
-			control.GET("/status", probeMonitor.GetDetailedStatus)
-		}
-	}
+	probeMonitor.RegisterRoutes(r.Group("/api/v1"))
 
 	// Create HTTP server
 	port := cfg.Probe.Port
@@ -133,12 +123,19 @@ func main() {
 		}
 	}()
 
+	// Start probe monitor while the server answers readiness checks with 503
+	if err := probeMonitor.Start(); err != nil {
+		log.Fatalf("❌ Failed to start probe monitor: %v", err)
+	}
+	monitor.SetStarted()
+
 	// Wait for interrupt signal to gracefully shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Println("🛑 Shutting down probe monitor...")
+	monitor.SetDraining()
 
 	// Shutdown server with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -152,4 +149,4 @@ func main() {
 	probeMonitor.Stop()
 
 	log.Println("✅ Probe monitor shutdown complete")
-}
\ No newline at end of file
+}
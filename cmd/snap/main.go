@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,12 +12,19 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
 	"github.com/last-emo-boy/infra-core/pkg/config"
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
 	"github.com/last-emo-boy/infra-core/pkg/snap"
+	"github.com/last-emo-boy/infra-core/pkg/tracing"
 )
 
 func main() {
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
 	log.Printf("📦 Starting InfraCore Snap Service...")
 
 	// Load environment
@@ -26,10 +34,31 @@ func main() {
 	}
 
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.Read()
+	if err != nil {
+		log.Fatalf("❌ Failed to load configuration: %v", err)
+	}
+	if *printConfig {
+		if err := cfg.WriteRedacted(os.Stdout); err != nil {
+			log.Fatalf("❌ Failed to print configuration: %v", err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+
+	closeLogs, err := logging.Setup("snap", cfg.Snap.Logs, environment)
+	if err != nil {
+		log.Printf("⚠️ Logging to stderr only: %v", err)
+	}
+	defer closeLogs()
+
+	shutdownTracing, err := tracing.Setup("snap", cfg.Tracing)
 	if err != nil {
-		log.Fatalf("❌ Failed to load config: %v", err)
+		log.Fatalf("❌ Failed to set up tracing: %v", err)
 	}
+	defer shutdownTracing(context.Background())
 
 	log.Printf("📋 Environment: %s", environment)
 
@@ -56,53 +85,26 @@ func main() {
 		log.Fatalf("❌ Failed to initialize snap manager: %v", err)
 	}
 
-	log.Printf("📦 Starting snap manager engine...")
-	snapManager.Start(context.Background())
-	log.Printf("✅ Snap manager started")
+	// Ready once the snap manager has started
+	monitor := health.New("snap")
+	monitor.AddCheck(health.Database(db))
+	monitor.AddCheck(health.WritableDir("data_dir", snapDir))
 
 	// Setup HTTP router
-	router := gin.Default()
+	router := gin.New()
+	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.TracingMiddleware())
+	router.Use(middleware.LoggingMiddleware())
+	router.Use(middleware.RecoveryMiddleware())
+	if cfg.MultiTenancy.Enabled {
+		router.Use(middleware.OrgScopeMiddleware())
+	}
 
-	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":    "healthy",
-			"service":   "infra-core-snap",
-			"timestamp": time.Now().Unix(),
-		})
-	})
+	// Liveness, readiness and detailed health endpoints
+	monitor.RegisterRoutes(router)
 
 	// API routes
-	api := router.Group("/api/v1")
-	{
-		// Snap plans
-		api.POST("/plans", snapManager.CreatePlan)
-		api.GET("/plans", snapManager.ListPlans)
-		api.GET("/plans/:id", snapManager.GetPlan)
-		api.PUT("/plans/:id", snapManager.UpdatePlan)
-		api.DELETE("/plans/:id", snapManager.DeletePlan)
-		api.POST("/plans/:id/enable", snapManager.EnablePlan)
-		api.POST("/plans/:id/disable", snapManager.DisablePlan)
-
-		// Snapshots
-		api.POST("/snapshots", snapManager.CreateSnapshot)
-		api.GET("/snapshots", snapManager.ListSnapshots)
-		api.GET("/snapshots/:id", snapManager.GetSnapshot)
-		api.DELETE("/snapshots/:id", snapManager.DeleteSnapshot)
-		api.GET("/snapshots/:id/status", snapManager.GetSnapshotStatus)
-		api.POST("/snapshots/:id/verify", snapManager.VerifySnapshot)
-
-		// Restore operations
-		api.POST("/restore", snapManager.RestoreSnapshot)
-		api.GET("/restore/:id/status", snapManager.GetRestoreStatus)
-		api.POST("/restore/:id/cancel", snapManager.CancelRestore)
-
-		// Management
-		api.GET("/stats", snapManager.GetStats)
-		api.POST("/cleanup", snapManager.CleanupOrphans)
-		api.POST("/scrub", snapManager.TriggerScrub)
-		api.GET("/scrub/status", snapManager.GetScrubStatus)
-	}
+	snapManager.RegisterRoutes(router.Group("/api/v1"))
 
 	// Start HTTP server
 	port := cfg.Snap.Port
@@ -126,9 +128,16 @@ func main() {
 		}
 	}()
 
+	// Start snap manager while the server answers readiness checks with 503
+	log.Printf("📦 Starting snap manager engine...")
+	snapManager.Start(context.Background())
+	log.Printf("✅ Snap manager started")
+	monitor.SetStarted()
+
 	// Wait for interrupt signal
 	<-quit
 	log.Printf("📦 Shutting down Snap Service...")
+	monitor.SetDraining()
 
 	// Create shutdown context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -143,4 +152,4 @@ func main() {
 	}
 
 	log.Printf("✅ Snap Service stopped gracefully")
-}
\ No newline at end of file
+}
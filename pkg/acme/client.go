@@ -9,7 +9,9 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,12 +19,18 @@ import (
 	"time"
 
 	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
 
 	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
 )
 
+// ErrUnknownDomain is returned when renewing a domain the client holds no
+// certificate for
+var ErrUnknownDomain = errors.New("no certificate for domain")
+
 // Client represents an ACME client for automatic certificate management
 type Client struct {
 	config     *config.Config
@@ -34,6 +42,17 @@ type Client struct {
 	// Certificate cache
 	certificates map[string]*tls.Certificate
 	certFiles    map[string]*CertificateFiles
+
+	// Records issued and renewed certificates when set
+	repo *database.CertificateRepository
+
+	// Solves DNS-01 challenges, nil without a DNS provider
+	dns01 *DNS01Provider
+
+	// Pending HTTP-01 challenge responses keyed by token. Guarded by its own
+	// mutex because IssueCertificate holds mu while the CA validates.
+	challenges  map[string]string
+	challengeMu sync.RWMutex
 }
 
 // User represents an ACME user
@@ -86,7 +105,17 @@ func NewClient(cfg *config.Config) (*Client, error) {
 		certDir:      certDir,
 		certificates: make(map[string]*tls.Certificate),
 		certFiles:    make(map[string]*CertificateFiles),
+		challenges:   make(map[string]string),
+	}
+
+	dnsProvider, err := newDNS01Provider(cfg.Gate.ACME.DNS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS provider: %w", err)
+	}
+	if dnsProvider == nil && cfg.Gate.ACME.ChallengeType == config.ChallengeDNS01 {
+		return nil, fmt.Errorf("challenge type %s requires a DNS provider", config.ChallengeDNS01)
 	}
+	client.dns01 = dnsProvider
 
 	// Load or create user
 	user, err := client.loadOrCreateUser()
@@ -110,12 +139,28 @@ func NewClient(cfg *config.Config) (*Client, error) {
 
 	client.legoClient = legoClient
 
-	// Setup HTTP-01 challenge
-	err = legoClient.Challenge.SetHTTP01Provider(&HTTP01Provider{
-		client: client,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to setup HTTP-01 provider: %w", err)
+	// Setup HTTP-01 challenge, unless the CA can't reach the gate on port 80
+	if cfg.Gate.ACME.ChallengeType != config.ChallengeDNS01 {
+		err = legoClient.Challenge.SetHTTP01Provider(&HTTP01Provider{
+			client: client,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup HTTP-01 provider: %w", err)
+		}
+	}
+
+	// Setup DNS-01 challenge. Lego prefers HTTP-01 when both are set up, and
+	// the CA offers only DNS-01 for wildcard domains, which therefore always
+	// use it. The provider waits for its records to propagate, replacing
+	// lego's own check.
+	if dnsProvider != nil {
+		err = legoClient.Challenge.SetDNS01Provider(dnsProvider, dns01.WrapPreCheck(
+			func(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
+				return true, nil
+			}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup DNS-01 provider: %w", err)
+		}
 	}
 
 	// Register user if needed
@@ -224,19 +269,52 @@ func (c *Client) saveUser(user *User) error {
 	return nil
 }
 
+// SetRepository records the certificates the client issues and renews in repo
+func (c *Client) SetRepository(repo *database.CertificateRepository) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.repo = repo
+}
+
 // IssueCertificate issues a new certificate for the given domains
 func (c *Client) IssueCertificate(domains []string) error {
+	return c.issue(domains, false)
+}
+
+// RenewCertificate renews the certificate of a domain now, however long it
+// has left, returning ErrUnknownDomain if the client holds none for it
+func (c *Client) RenewCertificate(domain string) error {
+	c.mu.RLock()
+	_, exists := c.certFiles[domain]
+	c.mu.RUnlock()
+	if !exists {
+		return ErrUnknownDomain
+	}
+	return c.issue([]string{domain}, true)
+}
+
+// issue obtains a certificate for the given domains, unless the current one
+// has more than 30 days left and the renewal isn't forced
+func (c *Client) issue(domains []string, force bool) error {
 	if len(domains) == 0 {
 		return fmt.Errorf("no domains specified")
 	}
 
+	if c.dns01 == nil {
+		for _, domain := range domains {
+			if isWildcard(domain) {
+				return fmt.Errorf("%w: %s", ErrWildcardRequiresDNS, domain)
+			}
+		}
+	}
+
 	primaryDomain := domains[0]
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	// Check if certificate already exists and is valid
-	if certFile, exists := c.certFiles[primaryDomain]; exists {
+	if certFile, exists := c.certFiles[primaryDomain]; exists && !force {
 		if time.Now().Before(certFile.NotAfter.Add(-30 * 24 * time.Hour)) {
 			return nil // Certificate is still valid (more than 30 days left)
 		}
@@ -247,6 +325,9 @@ func (c *Client) IssueCertificate(domains []string) error {
 		Bundle:  true,
 	}
 
+	// Obtain presents each challenge through HTTP01Provider or DNS01Provider
+	// before the order is finalized, and cleans it up once the CA has
+	// validated it or given up
 	certificates, err := c.legoClient.Certificate.Obtain(request)
 	if err != nil {
 		return fmt.Errorf("failed to obtain certificate: %w", err)
@@ -267,9 +348,42 @@ func (c *Client) IssueCertificate(domains []string) error {
 	c.certificates[primaryDomain] = cert
 	c.certFiles[primaryDomain] = certFile
 
+	// The certificate is in use either way, so a failure to record it is
+	// only logged
+	if err := c.record(certFile); err != nil {
+		log.Printf("❌ Failed to record certificate for %s: %v", primaryDomain, err)
+	}
+
 	return nil
 }
 
+// record saves an issued certificate to the repository, if there is one; the
+// caller holds mu
+func (c *Client) record(certFile *CertificateFiles) error {
+	return recordCertificate(c.repo, certFile)
+}
+
+// recordCertificate saves an issued certificate to repo unless it is nil
+func recordCertificate(repo *database.CertificateRepository, certFile *CertificateFiles) error {
+	if repo == nil {
+		return nil
+	}
+
+	cert := &database.Certificate{
+		Domain:    certFile.Domain,
+		NotBefore: certFile.NotBefore,
+		NotAfter:  certFile.NotAfter,
+		CertPath:  certFile.CertPath,
+		KeyPath:   certFile.KeyPath,
+		Status:    "valid",
+		AutoRenew: true,
+	}
+	if certFile.IssuerPath != "" {
+		cert.IssuerPath = &certFile.IssuerPath
+	}
+	return repo.Save(cert)
+}
+
 // GetCertificate returns certificate for the given domain
 func (c *Client) GetCertificate(domain string) (*tls.Certificate, error) {
 	c.mu.RLock()
@@ -317,9 +431,10 @@ func (c *Client) RenewExpiring() error {
 
 // saveCertificate saves certificate to disk
 func (c *Client) saveCertificate(domain string, certificates *certificate.Resource) (*CertificateFiles, error) {
-	certPath := filepath.Join(c.certDir, domain+".crt")
-	keyPath := filepath.Join(c.certDir, domain+".key")
-	issuerPath := filepath.Join(c.certDir, domain+".issuer.crt")
+	name := certFileName(domain)
+	certPath := filepath.Join(c.certDir, name+".crt")
+	keyPath := filepath.Join(c.certDir, name+".key")
+	issuerPath := filepath.Join(c.certDir, name+".issuer.crt")
 
 	// Write certificate
 	if err := os.WriteFile(certPath, certificates.Certificate, 0644); err != nil {
@@ -363,16 +478,43 @@ func (c *Client) saveCertificate(domain string, certificates *certificate.Resour
 
 // loadCertificates loads all certificates from disk
 func (c *Client) loadCertificates() error {
+	certificates, certFiles := c.readCertificates()
+	for domain, cert := range certificates {
+		c.certificates[domain] = cert
+		c.certFiles[domain] = certFiles[domain]
+	}
+	return nil
+}
+
+// ReloadCertificates re-reads the certificate cache and swaps it in, picking up
+// certificates renewed by another gate process sharing the cache directory
+func (c *Client) ReloadCertificates() error {
+	certificates, certFiles := c.readCertificates()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.certificates = certificates
+	c.certFiles = certFiles
+	return nil
+}
+
+// readCertificates reads every certificate and key pair in the cache directory
+func (c *Client) readCertificates() (map[string]*tls.Certificate, map[string]*CertificateFiles) {
+	certificates := make(map[string]*tls.Certificate)
+	certFiles := make(map[string]*CertificateFiles)
+
 	files, err := os.ReadDir(c.certDir)
 	if err != nil {
-		return nil // Directory doesn't exist or is empty
+		return certificates, certFiles // Directory doesn't exist or is empty
 	}
 
 	for _, file := range files {
 		if strings.HasSuffix(file.Name(), ".crt") && !strings.Contains(file.Name(), ".issuer.") {
-			domain := strings.TrimSuffix(file.Name(), ".crt")
+			name := strings.TrimSuffix(file.Name(), ".crt")
+			domain := certDomain(name)
 			certPath := filepath.Join(c.certDir, file.Name())
-			keyPath := filepath.Join(c.certDir, domain+".key")
+			keyPath := filepath.Join(c.certDir, name+".key")
 
 			if !fileExists(keyPath) {
 				continue
@@ -398,12 +540,12 @@ func (c *Client) loadCertificates() error {
 				}
 			}
 
-			c.certificates[domain] = cert
-			c.certFiles[domain] = certFile
+			certificates[domain] = cert
+			certFiles[domain] = certFile
 		}
 	}
 
-	return nil
+	return certificates, certFiles
 }
 
 // loadCertificate loads a certificate from files
@@ -415,6 +557,23 @@ func (c *Client) loadCertificate(certFile *CertificateFiles) (*tls.Certificate,
 	return &cert, nil
 }
 
+// certFileName returns the cache file name of a domain's certificate, with
+// the wildcard label spelled _ as lego does
+func certFileName(domain string) string {
+	if isWildcard(domain) {
+		return "_" + strings.TrimPrefix(domain, "*")
+	}
+	return domain
+}
+
+// certDomain is the inverse of certFileName
+func certDomain(name string) string {
+	if strings.HasPrefix(name, "_.") {
+		return "*" + strings.TrimPrefix(name, "_")
+	}
+	return name
+}
+
 // fileExists checks if a file exists
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
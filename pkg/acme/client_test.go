@@ -15,6 +15,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
 )
 
 func TestNewUser(t *testing.T) {
@@ -350,4 +351,59 @@ func TestACMEDirectoryURLs(t *testing.T) {
 			strings.Contains(url, "directory")
 		assert.False(t, isValid, "Invalid ACME URL should fail validation: %s", url)
 	}
-}
\ No newline at end of file
+}
+func TestClientRecordsCertificates(t *testing.T) {
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "acme.db"), Timeout: "30s"},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	client := &Client{
+		certificates: make(map[string]*tls.Certificate),
+		certFiles:    make(map[string]*CertificateFiles),
+	}
+	certFile := &CertificateFiles{
+		Domain:     "example.com",
+		CertPath:   "/certs/example.com.crt",
+		KeyPath:    "/certs/example.com.key",
+		IssuerPath: "/certs/example.com.issuer.crt",
+		NotBefore:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:   time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	// Without a repository nothing is recorded
+	require.NoError(t, client.record(certFile))
+
+	client.SetRepository(db.CertificateRepository())
+	require.NoError(t, client.record(certFile))
+	cert, err := db.CertificateRepository().GetByDomain("example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "valid", cert.Status)
+	assert.True(t, cert.AutoRenew)
+	assert.True(t, cert.NotAfter.Equal(certFile.NotAfter))
+	require.NotNil(t, cert.IssuerPath)
+	assert.Equal(t, certFile.IssuerPath, *cert.IssuerPath)
+
+	// A renewal updates the same record
+	require.NoError(t, db.CertificateRepository().UpdateStatus(cert.ID, "expired"))
+	certFile.NotAfter = certFile.NotAfter.Add(90 * 24 * time.Hour)
+	require.NoError(t, client.record(certFile))
+	certs, err := db.CertificateRepository().List()
+	require.NoError(t, err)
+	require.Len(t, certs, 1)
+	assert.Equal(t, cert.ID, certs[0].ID)
+	assert.Equal(t, "valid", certs[0].Status)
+	assert.True(t, certs[0].NotAfter.Equal(certFile.NotAfter))
+}
+
+func TestRenewUnknownDomain(t *testing.T) {
+	client := &Client{
+		certificates: make(map[string]*tls.Certificate),
+		certFiles:    make(map[string]*CertificateFiles),
+	}
+	assert.ErrorIs(t, client.RenewCertificate("missing.example.com"), ErrUnknownDomain)
+}
@@ -0,0 +1,190 @@
+package acme
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// cloudflareAPI is the base URL of the Cloudflare API
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// challengeTTL is the TTL of the TXT records created for challenges, the
+// shortest Cloudflare allows
+const challengeTTL = 120
+
+// CloudflareProvider publishes DNS-01 records through the Cloudflare API
+type CloudflareProvider struct {
+	token   string
+	zoneID  string
+	baseURL string
+	client  *http.Client
+
+	// Records created, keyed by name and value, so CleanUp deletes only those
+	mu      sync.Mutex
+	records map[string]cloudflareRecord
+}
+
+// cloudflareRecord locates a DNS record created by the provider
+type cloudflareRecord struct {
+	zoneID string
+	id     string
+}
+
+// cloudflareResponse is the envelope of every Cloudflare API response
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// NewCloudflareProvider creates a Cloudflare DNS provider authenticated with
+// the API token in cfg
+func NewCloudflareProvider(cfg config.CloudflareConfig) (*CloudflareProvider, error) {
+	if cfg.APIToken == "" {
+		return nil, fmt.Errorf("cloudflare API token is required")
+	}
+	return &CloudflareProvider{
+		token:   cfg.APIToken,
+		zoneID:  cfg.ZoneID,
+		baseURL: cloudflareAPI,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		records: make(map[string]cloudflareRecord),
+	}, nil
+}
+
+// Present creates the TXT record of a challenge
+func (p *CloudflareProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value := ChallengeRecord(domain, keyAuth)
+	name := strings.TrimSuffix(fqdn, ".")
+
+	zoneID, err := p.findZone(name)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{
+		"type":    "TXT",
+		"name":    name,
+		"content": value,
+		"ttl":     challengeTTL,
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := p.do(http.MethodPost, "/zones/"+url.PathEscape(zoneID)+"/dns_records", body, &created); err != nil {
+		return fmt.Errorf("failed to create TXT record %s: %w", name, err)
+	}
+
+	p.mu.Lock()
+	p.records[fqdn+" "+value] = cloudflareRecord{zoneID: zoneID, id: created.ID}
+	p.mu.Unlock()
+	return nil
+}
+
+// CleanUp deletes the TXT record of a challenge, if Present created one
+func (p *CloudflareProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value := ChallengeRecord(domain, keyAuth)
+	key := fqdn + " " + value
+
+	p.mu.Lock()
+	record, exists := p.records[key]
+	p.mu.Unlock()
+	if !exists {
+		return nil
+	}
+
+	path := "/zones/" + url.PathEscape(record.zoneID) + "/dns_records/" + url.PathEscape(record.id)
+	if err := p.do(http.MethodDelete, path, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete TXT record %s: %w", strings.TrimSuffix(fqdn, "."), err)
+	}
+
+	p.mu.Lock()
+	delete(p.records, key)
+	p.mu.Unlock()
+	return nil
+}
+
+// findZone returns the configured zone, or looks up the zone of a record
+// name by trying each of its parent domains, the closest first
+func (p *CloudflareProvider) findZone(name string) (string, error) {
+	if p.zoneID != "" {
+		return p.zoneID, nil
+	}
+
+	labels := strings.Split(name, ".")
+	for i := 1; i < len(labels)-1; i++ {
+		zone := strings.Join(labels[i:], ".")
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := p.do(http.MethodGet, "/zones?name="+url.QueryEscape(zone), nil, &zones); err != nil {
+			return "", fmt.Errorf("failed to look up zone %s: %w", zone, err)
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("no cloudflare zone found for %s", name)
+}
+
+// do sends a request to the API and decodes the result into out, if given
+func (p *CloudflareProvider) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, p.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope cloudflareResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&envelope); err != nil {
+		return fmt.Errorf("unexpected response with status %d: %w", resp.StatusCode, err)
+	}
+	if !envelope.Success || resp.StatusCode >= 300 {
+		messages := make([]string, 0, len(envelope.Errors))
+		for _, e := range envelope.Errors {
+			messages = append(messages, fmt.Sprintf("%s (%d)", e.Message, e.Code))
+		}
+		if len(messages) == 0 {
+			messages = append(messages, resp.Status)
+		}
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	if out != nil && len(envelope.Result) > 0 {
+		if err := json.Unmarshal(envelope.Result, out); err != nil {
+			return fmt.Errorf("failed to decode result: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package acme
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// fakeCloudflare serves the zone and DNS record endpoints of the Cloudflare
+// API for a single zone
+type fakeCloudflare struct {
+	mu      sync.Mutex
+	zone    string
+	records map[string]map[string]string // record ID to its fields
+	lookups []string
+	nextID  int
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reply := func(status int, result interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": status < 300, "errors": []interface{}{}, "result": result})
+	}
+	if r.Header.Get("Authorization") != "Bearer cf-token" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 10000, "message": "Authentication error"}}})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/zones":
+		name := r.URL.Query().Get("name")
+		f.lookups = append(f.lookups, name)
+		zones := []map[string]string{}
+		if name == f.zone {
+			zones = append(zones, map[string]string{"id": "zone-1", "name": name})
+		}
+		reply(http.StatusOK, zones)
+	case r.Method == http.MethodPost && r.URL.Path == "/zones/zone-1/dns_records":
+		var record struct{ Type, Name, Content string }
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			reply(http.StatusBadRequest, nil)
+			return
+		}
+		f.nextID++
+		id := fmt.Sprintf("record-%d", f.nextID)
+		f.records[id] = map[string]string{"type": record.Type, "name": record.Name, "content": record.Content}
+		reply(http.StatusOK, map[string]string{"id": id})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/zones/zone-1/dns_records/"):
+		id := strings.TrimPrefix(r.URL.Path, "/zones/zone-1/dns_records/")
+		if _, exists := f.records[id]; !exists {
+			reply(http.StatusNotFound, nil)
+			return
+		}
+		delete(f.records, id)
+		reply(http.StatusOK, map[string]string{"id": id})
+	default:
+		reply(http.StatusNotFound, nil)
+	}
+}
+
+func newTestCloudflare(t *testing.T, token string) (*CloudflareProvider, *fakeCloudflare) {
+	fake := &fakeCloudflare{zone: "example.com", records: make(map[string]map[string]string)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	provider, err := NewCloudflareProvider(config.CloudflareConfig{APIToken: token})
+	require.NoError(t, err)
+	provider.baseURL = server.URL
+	return provider, fake
+}
+
+func TestCloudflareProvider(t *testing.T) {
+	provider, fake := newTestCloudflare(t, "cf-token")
+
+	require.NoError(t, provider.Present("app.example.com", "token", "token.key"))
+	assert.Equal(t, []string{"app.example.com", "example.com"}, fake.lookups, "the closest zone is looked up first")
+	require.Len(t, fake.records, 1)
+	_, value := ChallengeRecord("app.example.com", "token.key")
+	assert.Equal(t, map[string]string{"type": "TXT", "name": "_acme-challenge.app.example.com", "content": value}, fake.records["record-1"])
+
+	// Wildcards share the record name of their base domain
+	require.NoError(t, provider.Present("*.example.com", "wildcard", "wildcard.key"))
+	assert.Equal(t, "_acme-challenge.example.com", fake.records["record-2"]["name"])
+
+	require.NoError(t, provider.CleanUp("app.example.com", "token", "token.key"))
+	assert.NotContains(t, fake.records, "record-1")
+	assert.Contains(t, fake.records, "record-2")
+	require.NoError(t, provider.CleanUp("*.example.com", "wildcard", "wildcard.key"))
+	assert.Empty(t, fake.records)
+
+	// Records the provider did not create are left alone
+	require.NoError(t, provider.CleanUp("other.example.com", "token", "token.key"))
+}
+
+func TestCloudflareProviderErrors(t *testing.T) {
+	provider, fake := newTestCloudflare(t, "wrong-token")
+	err := provider.Present("app.example.com", "token", "token.key")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Authentication error (10000)")
+	assert.Empty(t, fake.records)
+
+	provider, _ = newTestCloudflare(t, "cf-token")
+	err = provider.Present("app.example.org", "token", "token.key")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no cloudflare zone found")
+
+	// A configured zone skips the lookup
+	provider, fake = newTestCloudflare(t, "cf-token")
+	provider.zoneID = "zone-1"
+	require.NoError(t, provider.Present("app.example.org", "token", "token.key"))
+	assert.Empty(t, fake.lookups)
+	assert.Len(t, fake.records, 1)
+
+	_, err = NewCloudflareProvider(config.CloudflareConfig{})
+	assert.Error(t, err)
+}
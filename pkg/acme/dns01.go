@@ -0,0 +1,220 @@
+package acme
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// Propagation checks of DNS-01 records, unless configured otherwise
+const (
+	defaultPropagationTimeout = 2 * time.Minute
+	defaultPollingInterval    = 5 * time.Second
+)
+
+// ErrWildcardRequiresDNS is returned when a wildcard certificate is requested
+// without a DNS provider, as the CA validates wildcards only through DNS-01
+var ErrWildcardRequiresDNS = errors.New("wildcard certificates require a DNS provider")
+
+// DNSProvider publishes the TXT records of DNS-01 challenges. The record for
+// a domain and key authorization is the one ChallengeRecord returns; CleanUp
+// is called for every Present, including those that failed.
+type DNSProvider interface {
+	Present(domain, token, keyAuth string) error
+	CleanUp(domain, token, keyAuth string) error
+}
+
+// PropagationCheck reports whether the TXT record fqdn is visible with value
+type PropagationCheck func(fqdn, value string) (bool, error)
+
+// DNS01Provider solves DNS-01 challenges through a DNSProvider. Present
+// returns once the record has propagated, so the CA is only asked to validate
+// a record it can see, and removes the record again when it fails.
+type DNS01Provider struct {
+	provider DNSProvider
+	check    PropagationCheck
+	timeout  time.Duration
+	interval time.Duration
+
+	// Records presented and not yet cleaned up, keyed by domain and token
+	mu        sync.Mutex
+	presented map[string]bool
+}
+
+// NewDNS01Provider creates a DNS-01 solver publishing records through
+// provider and polling check every interval until timeout
+func NewDNS01Provider(provider DNSProvider, check PropagationCheck, timeout, interval time.Duration) *DNS01Provider {
+	return &DNS01Provider{
+		provider:  provider,
+		check:     check,
+		timeout:   timeout,
+		interval:  interval,
+		presented: make(map[string]bool),
+	}
+}
+
+// newDNS01Provider creates the DNS-01 solver configured in cfg, or returns
+// nil if no provider is set
+func newDNS01Provider(cfg config.ACMEDNSConfig) (*DNS01Provider, error) {
+	var provider DNSProvider
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "cloudflare":
+		cloudflare, err := NewCloudflareProvider(cfg.Cloudflare)
+		if err != nil {
+			return nil, err
+		}
+		provider = cloudflare
+	default:
+		return nil, fmt.Errorf("unknown DNS provider %q", cfg.Provider)
+	}
+
+	timeout, err := durationOr(cfg.PropagationTimeout, defaultPropagationTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid propagation timeout: %w", err)
+	}
+	interval, err := durationOr(cfg.PollingInterval, defaultPollingInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid polling interval: %w", err)
+	}
+
+	return NewDNS01Provider(provider, ResolverCheck(cfg.Nameservers), timeout, interval), nil
+}
+
+// durationOr parses value, returning fallback when it is unset
+func durationOr(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// Present publishes the record of a challenge and waits for it to propagate
+func (p *DNS01Provider) Present(domain, token, keyAuth string) error {
+	p.mu.Lock()
+	p.presented[domain+" "+token] = true
+	p.mu.Unlock()
+
+	// The provider may have created the record before failing, so failures
+	// clean up as well
+	if err := p.provider.Present(domain, token, keyAuth); err != nil {
+		p.cleanUpAfterFailure(domain, token, keyAuth)
+		return fmt.Errorf("failed to present DNS-01 record for %s: %w", domain, err)
+	}
+
+	fqdn, value := ChallengeRecord(domain, keyAuth)
+	if err := p.waitForPropagation(fqdn, value); err != nil {
+		p.cleanUpAfterFailure(domain, token, keyAuth)
+		return err
+	}
+
+	return nil
+}
+
+// CleanUp removes the record of a challenge, unless it is already gone
+func (p *DNS01Provider) CleanUp(domain, token, keyAuth string) error {
+	key := domain + " " + token
+
+	p.mu.Lock()
+	presented := p.presented[key]
+	delete(p.presented, key)
+	p.mu.Unlock()
+
+	if !presented {
+		return nil
+	}
+	return p.provider.CleanUp(domain, token, keyAuth)
+}
+
+// cleanUpAfterFailure removes the record of a failed challenge; the failure
+// is what gets reported, so a failed cleanup is only logged
+func (p *DNS01Provider) cleanUpAfterFailure(domain, token, keyAuth string) {
+	if err := p.CleanUp(domain, token, keyAuth); err != nil {
+		log.Printf("❌ Failed to clean up DNS-01 record for %s: %v", domain, err)
+	}
+}
+
+// waitForPropagation polls the propagation check until the record is
+// visible, returning the last problem seen if it isn't within the timeout
+func (p *DNS01Provider) waitForPropagation(fqdn, value string) error {
+	deadline := time.Now().Add(p.timeout)
+	for {
+		visible, err := p.check(fqdn, value)
+		if visible {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			if err != nil {
+				return fmt.Errorf("DNS record %s did not propagate within %s: %w", fqdn, p.timeout, err)
+			}
+			return fmt.Errorf("DNS record %s did not propagate within %s", fqdn, p.timeout)
+		}
+		time.Sleep(p.interval)
+	}
+}
+
+// ChallengeRecord returns the name and value of the TXT record answering a
+// DNS-01 challenge. Wildcard domains are validated on their base domain.
+func ChallengeRecord(domain, keyAuth string) (fqdn, value string) {
+	domain = strings.TrimSuffix(strings.TrimPrefix(domain, "*."), ".")
+	digest := sha256.Sum256([]byte(keyAuth))
+	return "_acme-challenge." + domain + ".", base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// isWildcard reports whether a certificate domain is a wildcard
+func isWildcard(domain string) bool {
+	return strings.HasPrefix(domain, "*.")
+}
+
+// ResolverCheck returns a propagation check that looks the record up on each
+// nameserver, host or host:port, and passes once all of them return it. The
+// system resolver is used when no nameservers are given.
+func ResolverCheck(nameservers []string) PropagationCheck {
+	resolvers := []*net.Resolver{net.DefaultResolver}
+	if len(nameservers) > 0 {
+		resolvers = make([]*net.Resolver, len(nameservers))
+		for i, server := range nameservers {
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+			resolvers[i] = nameserverResolver(server)
+		}
+	}
+
+	return func(fqdn, value string) (bool, error) {
+		for _, resolver := range resolvers {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			records, err := resolver.LookupTXT(ctx, fqdn)
+			cancel()
+			if err != nil {
+				return false, err
+			}
+			if !slices.Contains(records, value) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
+
+// nameserverResolver returns a resolver sending every query to server
+func nameserverResolver(server string) *net.Resolver {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
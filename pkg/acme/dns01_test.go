@@ -0,0 +1,205 @@
+package acme
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// fakeDNS is a DNS provider keeping its TXT records in memory and logging
+// every call, shared with the propagation check it hands out
+type fakeDNS struct {
+	mu         sync.Mutex
+	records    map[string][]string
+	events     []string
+	presentErr error
+	visibleAt  int // checks answered before records become visible, -1 for never
+	checks     int
+}
+
+func newFakeDNS() *fakeDNS {
+	return &fakeDNS{records: make(map[string][]string)}
+}
+
+func (f *fakeDNS) Present(domain, token, keyAuth string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, "present "+domain)
+	if f.presentErr != nil {
+		return f.presentErr
+	}
+	fqdn, value := ChallengeRecord(domain, keyAuth)
+	f.records[fqdn] = append(f.records[fqdn], value)
+	return nil
+}
+
+func (f *fakeDNS) CleanUp(domain, token, keyAuth string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, "cleanup "+domain)
+	fqdn, value := ChallengeRecord(domain, keyAuth)
+	values := f.records[fqdn]
+	for i, v := range values {
+		if v == value {
+			f.records[fqdn] = append(values[:i], values[i+1:]...)
+			break
+		}
+	}
+	if len(f.records[fqdn]) == 0 {
+		delete(f.records, fqdn)
+	}
+	return nil
+}
+
+func (f *fakeDNS) check(fqdn, value string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, "check "+fqdn)
+	f.checks++
+	if f.visibleAt < 0 || f.checks <= f.visibleAt {
+		return false, errors.New("no such host")
+	}
+	for _, v := range f.records[fqdn] {
+		if v == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeDNS) log() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.events...)
+}
+
+func newFakeDNS01(f *fakeDNS) *DNS01Provider {
+	return NewDNS01Provider(f, f.check, 50*time.Millisecond, time.Millisecond)
+}
+
+func TestChallengeRecord(t *testing.T) {
+	// The value is the unpadded base64url SHA-256 digest of the key authorization
+	fqdn, value := ChallengeRecord("example.com", "token.thumbprint")
+	assert.Equal(t, "_acme-challenge.example.com.", fqdn)
+	assert.Equal(t, "61rBZ_4knHblO0MNoxFsXZ_eTFUHum0B6IVRbhvUn5I", value)
+
+	wildcard, _ := ChallengeRecord("*.example.com", "token.thumbprint")
+	assert.Equal(t, fqdn, wildcard, "wildcards are validated on their base domain")
+}
+
+func TestDNS01Lifecycle(t *testing.T) {
+	dns := newFakeDNS()
+	dns.visibleAt = 2
+	provider := newFakeDNS01(dns)
+
+	require.NoError(t, provider.Present("example.com", "token", "token.key"))
+	// Present returns only once the record is visible to the resolvers
+	assert.Equal(t, []string{
+		"present example.com",
+		"check _acme-challenge.example.com.",
+		"check _acme-challenge.example.com.",
+		"check _acme-challenge.example.com.",
+	}, dns.log())
+
+	require.NoError(t, provider.CleanUp("example.com", "token", "token.key"))
+	assert.Equal(t, "cleanup example.com", dns.log()[4])
+	assert.Empty(t, dns.records)
+
+	// Records are removed once
+	require.NoError(t, provider.CleanUp("example.com", "token", "token.key"))
+	assert.Len(t, dns.log(), 5)
+}
+
+func TestDNS01Failures(t *testing.T) {
+	t.Run("record never propagates", func(t *testing.T) {
+		dns := newFakeDNS()
+		dns.visibleAt = -1
+		provider := newFakeDNS01(dns)
+
+		err := provider.Present("example.com", "token", "token.key")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "did not propagate")
+		assert.Contains(t, err.Error(), "no such host")
+
+		events := dns.log()
+		assert.Equal(t, "present example.com", events[0])
+		assert.Equal(t, "cleanup example.com", events[len(events)-1])
+		assert.Empty(t, dns.records, "the record is removed before the failure is reported")
+
+		// Lego cleans up every challenge it presented, failed or not
+		require.NoError(t, provider.CleanUp("example.com", "token", "token.key"))
+		assert.Len(t, dns.log(), len(events))
+	})
+
+	t.Run("provider fails", func(t *testing.T) {
+		dns := newFakeDNS()
+		dns.presentErr = errors.New("rate limited")
+		provider := newFakeDNS01(dns)
+
+		err := provider.Present("example.com", "token", "token.key")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "rate limited")
+		assert.Equal(t, []string{"present example.com", "cleanup example.com"}, dns.log(), "no propagation check for a record that failed")
+	})
+}
+
+func TestDNS01Wildcard(t *testing.T) {
+	dns := newFakeDNS()
+	provider := newFakeDNS01(dns)
+
+	// A certificate for a domain and its wildcard has two challenges with
+	// records of the same name, both present until validated
+	require.NoError(t, provider.Present("*.example.com", "wildcard", "wildcard.key"))
+	require.NoError(t, provider.Present("example.com", "apex", "apex.key"))
+	assert.Len(t, dns.records["_acme-challenge.example.com."], 2)
+
+	require.NoError(t, provider.CleanUp("*.example.com", "wildcard", "wildcard.key"))
+	_, apex := ChallengeRecord("example.com", "apex.key")
+	assert.Equal(t, []string{apex}, dns.records["_acme-challenge.example.com."])
+	require.NoError(t, provider.CleanUp("example.com", "apex", "apex.key"))
+	assert.Empty(t, dns.records)
+}
+
+func TestWildcardRequiresDNSProvider(t *testing.T) {
+	// Rejected before the CA is contacted; this client has no lego client
+	client := newCacheClient(t.TempDir())
+	err := client.IssueCertificate([]string{"example.com", "*.example.com"})
+	assert.ErrorIs(t, err, ErrWildcardRequiresDNS)
+
+	provider, err := newDNS01Provider(config.ACMEDNSConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, provider, "DNS-01 is unavailable without a provider")
+
+	_, err = newDNS01Provider(config.ACMEDNSConfig{Provider: "route53"})
+	assert.Error(t, err)
+	_, err = newDNS01Provider(config.ACMEDNSConfig{Provider: "cloudflare"})
+	assert.Error(t, err, "cloudflare needs an API token")
+
+	provider, err = newDNS01Provider(config.ACMEDNSConfig{Provider: "cloudflare", PropagationTimeout: "5m", Cloudflare: config.CloudflareConfig{APIToken: "token"}})
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, provider.timeout)
+	assert.Equal(t, defaultPollingInterval, provider.interval)
+}
+
+func TestWildcardCertificateCache(t *testing.T) {
+	certDir := t.TempDir()
+	writeSelfSigned(t, certDir, "*.example.com", "example.com")
+	assert.FileExists(t, filepath.Join(certDir, "_.example.com.crt"))
+
+	client := newCacheClient(certDir)
+	require.NoError(t, client.loadCertificates())
+	assert.Contains(t, client.ListCertificates(), "*.example.com")
+
+	cert, ok := client.SelectCertificate("api.example.com")
+	require.True(t, ok)
+	assert.Equal(t, []string{"*.example.com", "example.com"}, cert.Leaf.DNSNames)
+	_, ok = client.SelectCertificate("a.b.example.com")
+	assert.False(t, ok, "wildcards cover a single label")
+}
@@ -3,56 +3,118 @@ package acme
 import (
 	"fmt"
 	"net/http"
-	"sync"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
+// ChallengePathPrefix is the URL path under which HTTP-01 challenges are served
+const ChallengePathPrefix = "/.well-known/acme-challenge/"
+
+// challengeDir is the cache subdirectory holding pending challenge responses,
+// so a restarted or upgraded gate can still answer a validation in flight
+const challengeDir = "challenges"
+
 // HTTP01Provider provides HTTP-01 challenge handling
 type HTTP01Provider struct {
-	client     *Client
-	challenges map[string]string
-	mu         sync.RWMutex
+	client *Client
 }
 
-// Present sets up the HTTP-01 challenge
+// Present registers the key authorization for a token before the CA validates it
 func (p *HTTP01Provider) Present(domain, token, keyAuth string) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	return p.client.SetChallengeResponse(token, keyAuth)
+}
+
+// CleanUp removes the HTTP-01 challenge once validation is done
+func (p *HTTP01Provider) CleanUp(domain, token, keyAuth string) error {
+	return p.client.DeleteChallengeResponse(token)
+}
 
-	if p.challenges == nil {
-		p.challenges = make(map[string]string)
+// ServeHTTP serves HTTP-01 challenge requests
+func (p *HTTP01Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.client.ServeChallenge(w, r)
+}
+
+// SetChallengeResponse stores the key authorization to serve for a challenge token
+func (c *Client) SetChallengeResponse(token, keyAuth string) error {
+	if !validToken(token) {
+		return fmt.Errorf("invalid challenge token: %q", token)
+	}
+
+	c.challengeMu.Lock()
+	defer c.challengeMu.Unlock()
+
+	if c.challenges == nil {
+		c.challenges = make(map[string]string)
+	}
+	c.challenges[token] = keyAuth
+
+	if c.certDir == "" {
+		return nil
+	}
+
+	dir := filepath.Join(c.certDir, challengeDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create challenge directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, token), []byte(keyAuth), 0600); err != nil {
+		return fmt.Errorf("failed to write challenge response: %w", err)
 	}
 
-	p.challenges[token] = keyAuth
 	return nil
 }
 
-// CleanUp removes the HTTP-01 challenge
-func (p *HTTP01Provider) CleanUp(domain, token, keyAuth string) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// GetChallengeResponse returns the key authorization for a challenge token,
+// falling back to the cache directory for challenges registered before a restart
+func (c *Client) GetChallengeResponse(token string) (string, bool) {
+	if !validToken(token) {
+		return "", false
+	}
 
-	delete(p.challenges, token)
-	return nil
+	c.challengeMu.RLock()
+	keyAuth, exists := c.challenges[token]
+	c.challengeMu.RUnlock()
+	if exists || c.certDir == "" {
+		return keyAuth, exists
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.certDir, challengeDir, token))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
 }
 
-// ServeHTTP serves HTTP-01 challenge requests
-func (p *HTTP01Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Extract token from path: /.well-known/acme-challenge/{token}
-	if r.URL.Path[:len("/.well-known/acme-challenge/")] != "/.well-known/acme-challenge/" {
-		http.NotFound(w, r)
-		return
+// DeleteChallengeResponse removes a challenge token from memory and the cache directory
+func (c *Client) DeleteChallengeResponse(token string) error {
+	if !validToken(token) {
+		return fmt.Errorf("invalid challenge token: %q", token)
 	}
 
-	token := r.URL.Path[len("/.well-known/acme-challenge/"):]
-	if token == "" {
+	c.challengeMu.Lock()
+	defer c.challengeMu.Unlock()
+
+	delete(c.challenges, token)
+
+	if c.certDir == "" {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(c.certDir, challengeDir, token)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove challenge response: %w", err)
+	}
+
+	return nil
+}
+
+// ServeChallenge answers a request for /.well-known/acme-challenge/{token}
+// with the stored key authorization, or 404 if the token is unknown
+func (c *Client) ServeChallenge(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, ChallengePathPrefix) {
 		http.NotFound(w, r)
 		return
 	}
 
-	p.mu.RLock()
-	keyAuth, exists := p.challenges[token]
-	p.mu.RUnlock()
-
+	keyAuth, exists := c.GetChallengeResponse(strings.TrimPrefix(r.URL.Path, ChallengePathPrefix))
 	if !exists {
 		http.NotFound(w, r)
 		return
@@ -63,11 +125,18 @@ func (p *HTTP01Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, keyAuth)
 }
 
-// GetChallengeResponse returns the challenge response for a token
-func (p *HTTP01Provider) GetChallengeResponse(token string) (string, bool) {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-
-	keyAuth, exists := p.challenges[token]
-	return keyAuth, exists
+// validToken reports whether a token only uses the base64url alphabet, which
+// also keeps it safe to use as a file name
+func validToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, r := range token {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,79 @@
+package acme
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fetchChallenge requests a challenge token the way the CA does
+func fetchChallenge(t *testing.T, server *httptest.Server, token string) (int, string) {
+	resp, err := http.Get(server.URL + http01.ChallengePath(token))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestHTTP01Challenge(t *testing.T) {
+	certDir := t.TempDir()
+	client := &Client{certDir: certDir}
+	provider := &HTTP01Provider{client: client}
+
+	server := httptest.NewServer(provider)
+	defer server.Close()
+
+	const token = "evaGxfADs6pSRb2LAv9IZf17Dt3juxGJ-PCt92wr-oA"
+	const keyAuth = token + ".9jg46WB3rR_AHD-EBXdN7cBkH1WOu0tA3M9fm21mqTI"
+
+	status, _ := fetchChallenge(t, server, token)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	require.NoError(t, provider.Present("example.com", token, keyAuth))
+
+	status, body := fetchChallenge(t, server, token)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, keyAuth, body)
+
+	// A restarted gate answers from the cache directory
+	restarted := httptest.NewServer(&HTTP01Provider{client: &Client{certDir: certDir}})
+	defer restarted.Close()
+
+	status, body = fetchChallenge(t, restarted, token)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, keyAuth, body)
+
+	require.NoError(t, provider.CleanUp("example.com", token, keyAuth))
+
+	status, _ = fetchChallenge(t, server, token)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = fetchChallenge(t, restarted, token)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	_, err := os.Stat(filepath.Join(certDir, challengeDir, token))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestChallengeTokenValidation(t *testing.T) {
+	client := &Client{certDir: t.TempDir()}
+
+	for _, token := range []string{"", "../user", "a/b", "a.b", "tok en"} {
+		assert.Error(t, client.SetChallengeResponse(token, "x"), token)
+		_, exists := client.GetChallengeResponse(token)
+		assert.False(t, exists, token)
+	}
+
+	require.NoError(t, client.SetChallengeResponse("Valid_token-123", "x"))
+	keyAuth, exists := client.GetChallengeResponse("Valid_token-123")
+	assert.True(t, exists)
+	assert.Equal(t, "x", keyAuth)
+}
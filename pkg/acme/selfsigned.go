@@ -0,0 +1,425 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// Local CA settings
+const (
+	localCADir           = "local-ca"
+	localCAValidity      = 10 * 365 * 24 * time.Hour
+	defaultLeafValidity  = 30 * 24 * time.Hour
+	maxLocalCertificates = 1024 // bounds the certificates minted for SNI names sent by clients
+)
+
+// ErrInvalidHost is returned when a certificate is requested for something
+// that is neither a host name nor an IP address
+var ErrInvalidHost = errors.New("invalid host name")
+
+// LocalCA issues certificates from a CA of its own, for LANs and development
+// setups where an ACME CA can't validate the gate. The CA is generated at
+// first start and kept in the cache directory; clients trust the gate once
+// they trust the CA certificate. A certificate is minted for each host name or
+// IP address the gate is reached by, and minted again once a third of its
+// validity is left.
+type LocalCA struct {
+	dir      string
+	validity time.Duration
+	now      func() time.Time
+
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+
+	mu           sync.Mutex
+	certificates map[string]*tls.Certificate
+	certFiles    map[string]*CertificateFiles
+
+	// Records minted certificates when set
+	repo *database.CertificateRepository
+}
+
+// NewLocalCA loads the local CA from cacheDir, creating it on first start,
+// along with the certificates it minted before. Certificates are valid for
+// validity, or 30 days when zero.
+func NewLocalCA(cacheDir string, validity time.Duration) (*LocalCA, error) {
+	if validity <= 0 {
+		validity = defaultLeafValidity
+	}
+
+	dir := filepath.Join(cacheDir, localCADir)
+	if err := os.MkdirAll(filepath.Join(dir, "leaves"), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create local CA directory: %w", err)
+	}
+
+	ca := &LocalCA{
+		dir:          dir,
+		validity:     validity,
+		now:          time.Now,
+		certificates: make(map[string]*tls.Certificate),
+		certFiles:    make(map[string]*CertificateFiles),
+	}
+	if err := ca.loadOrCreate(); err != nil {
+		return nil, err
+	}
+	ca.loadCertificates()
+	return ca, nil
+}
+
+// SetRepository records the certificates the CA mints in repo
+func (ca *LocalCA) SetRepository(repo *database.CertificateRepository) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.repo = repo
+}
+
+// CACertificatePEM returns the PEM encoded CA certificate, for clients to trust
+func (ca *LocalCA) CACertificatePEM() []byte {
+	return ca.certPEM
+}
+
+// caPaths returns the paths of the CA certificate and key
+func (ca *LocalCA) caPaths() (string, string) {
+	return filepath.Join(ca.dir, "ca.crt"), filepath.Join(ca.dir, "ca.key")
+}
+
+// loadOrCreate loads the CA certificate and key, generating them if missing
+func (ca *LocalCA) loadOrCreate() error {
+	certPath, keyPath := ca.caPaths()
+
+	if fileExists(certPath) && fileExists(keyPath) {
+		pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return fmt.Errorf("failed to load local CA: %w", err)
+		}
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return fmt.Errorf("failed to load local CA: unsupported key type %T", pair.PrivateKey)
+		}
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse local CA certificate: %w", err)
+		}
+		ca.cert, ca.key = cert, key
+		ca.certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pair.Certificate[0]})
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate local CA key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	now := ca.now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Infra-Core Gate"}, CommonName: "Infra-Core Local CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(localCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create local CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("failed to parse local CA certificate: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := writeKeyPair(certPath, keyPath, certPEM, key); err != nil {
+		return fmt.Errorf("failed to save local CA: %w", err)
+	}
+
+	ca.cert, ca.key, ca.certPEM = cert, key, certPEM
+	return nil
+}
+
+// loadCertificates loads the certificates minted by the current CA; those
+// of a CA that has since been replaced are minted again on demand
+func (ca *LocalCA) loadCertificates() {
+	files, err := os.ReadDir(filepath.Join(ca.dir, "leaves"))
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".crt")
+		if !ok {
+			continue
+		}
+		certFile := &CertificateFiles{
+			CertPath:   filepath.Join(ca.dir, "leaves", file.Name()),
+			KeyPath:    filepath.Join(ca.dir, "leaves", name+".key"),
+			IssuerPath: filepath.Join(ca.dir, "ca.crt"),
+		}
+		cert, err := tls.LoadX509KeyPair(certFile.CertPath, certFile.KeyPath)
+		if err != nil {
+			continue // Skip invalid certificates
+		}
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				continue
+			}
+		}
+		if cert.Leaf.CheckSignatureFrom(ca.cert) != nil {
+			continue
+		}
+
+		host := leafHost(cert.Leaf)
+		if host == "" {
+			continue
+		}
+		certFile.Domain = host
+		certFile.NotBefore = cert.Leaf.NotBefore
+		certFile.NotAfter = cert.Leaf.NotAfter
+		ca.certificates[host] = &cert
+		ca.certFiles[host] = certFile
+	}
+}
+
+// Certificate returns the certificate for a host name or IP address, minting
+// one if there is none yet or the current one is due for renewal
+func (ca *LocalCA) Certificate(host string) (*tls.Certificate, error) {
+	host, err := normalizeHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	cert, exists := ca.certificates[host]
+	if exists && !ca.renewalDue(cert.Leaf) {
+		return cert, nil
+	}
+	if !exists && len(ca.certificates) >= maxLocalCertificates {
+		return nil, fmt.Errorf("no certificate for %q: the local CA already holds %d certificates", host, maxLocalCertificates)
+	}
+	return ca.mint(host)
+}
+
+// GetCertificate selects the certificate of a TLS handshake by SNI name.
+// Clients connecting by IP address send no name, so the address they
+// connected to is used instead.
+func (ca *LocalCA) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := hello.ServerName
+	if host == "" && hello.Conn != nil {
+		if addr, ok := hello.Conn.LocalAddr().(*net.TCPAddr); ok {
+			host = addr.IP.String()
+		}
+	}
+	return ca.Certificate(host)
+}
+
+// TLSConfig returns a server TLS config serving certificates of the local CA
+func (ca *LocalCA) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: ca.GetCertificate,
+	}
+}
+
+// ListCertificates returns the certificates minted so far
+func (ca *LocalCA) ListCertificates() map[string]*CertificateFiles {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	result := make(map[string]*CertificateFiles)
+	for k, v := range ca.certFiles {
+		result[k] = v
+	}
+	return result
+}
+
+// RenewCertificate mints the certificate of a host again now, returning
+// ErrUnknownDomain if none was minted for it before
+func (ca *LocalCA) RenewCertificate(host string) error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if _, exists := ca.certificates[host]; !exists {
+		return ErrUnknownDomain
+	}
+	_, err := ca.mint(host)
+	return err
+}
+
+// RenewExpiring mints again the certificates due for renewal
+func (ca *LocalCA) RenewExpiring() error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	for host, cert := range ca.certificates {
+		if !ca.renewalDue(cert.Leaf) {
+			continue
+		}
+		if _, err := ca.mint(host); err != nil {
+			return fmt.Errorf("failed to renew certificate for %s: %w", host, err)
+		}
+	}
+	return nil
+}
+
+// renewalDue reports whether a certificate has a third of its validity left
+func (ca *LocalCA) renewalDue(leaf *x509.Certificate) bool {
+	return leaf == nil || !ca.now().Before(leaf.NotAfter.Add(-ca.validity/3))
+}
+
+// mint issues, saves and records a certificate for a normalized host; the
+// caller holds mu
+func (ca *LocalCA) mint(host string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := ca.now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"Infra-Core Gate"}, CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(ca.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	// Colons of IPv6 addresses are not portable in file names
+	name := strings.ReplaceAll(host, ":", "_")
+	certFile := &CertificateFiles{
+		Domain:     host,
+		CertPath:   filepath.Join(ca.dir, "leaves", name+".crt"),
+		KeyPath:    filepath.Join(ca.dir, "leaves", name+".key"),
+		IssuerPath: filepath.Join(ca.dir, "ca.crt"),
+		NotBefore:  leaf.NotBefore,
+		NotAfter:   leaf.NotAfter,
+		Created:    now,
+		Updated:    now,
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := writeKeyPair(certFile.CertPath, certFile.KeyPath, certPEM, key); err != nil {
+		return nil, fmt.Errorf("failed to save certificate: %w", err)
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	ca.certificates[host] = cert
+	ca.certFiles[host] = certFile
+
+	// The certificate is in use either way, so a failure to record it is
+	// only logged
+	if err := recordCertificate(ca.repo, certFile); err != nil {
+		log.Printf("❌ Failed to record certificate for %s: %v", host, err)
+	}
+	return cert, nil
+}
+
+// normalizeHost lowercases a host name, rejecting anything that is neither a
+// valid DNS name nor an IP address
+func normalizeHost(host string) (string, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	if host == "" || len(host) > 253 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidHost, host)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", fmt.Errorf("%w: %q", ErrInvalidHost, host)
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return "", fmt.Errorf("%w: %q", ErrInvalidHost, host)
+			}
+		}
+	}
+	return host, nil
+}
+
+// leafHost returns the host a minted certificate was issued for
+func leafHost(leaf *x509.Certificate) string {
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames[0]
+	}
+	if len(leaf.IPAddresses) > 0 {
+		return leaf.IPAddresses[0].String()
+	}
+	return ""
+}
+
+// randomSerial returns a random 128-bit certificate serial number
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
+
+// writeKeyPair writes a PEM certificate and its private key, the key readable
+// by the owner only
+func writeKeyPair(certPath, keyPath string, certPEM []byte, key *ecdsa.PrivateKey) error {
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return nil
+}
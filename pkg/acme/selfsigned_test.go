@@ -0,0 +1,184 @@
+package acme
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// caPool returns a pool trusting only the local CA
+func caPool(t *testing.T, ca *LocalCA) *x509.CertPool {
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(ca.CACertificatePEM()))
+	return pool
+}
+
+func TestLocalCAChain(t *testing.T) {
+	ca, err := NewLocalCA(t.TempDir(), 0)
+	require.NoError(t, err)
+	pool := caPool(t, ca)
+
+	tests := []struct {
+		name   string
+		host   string
+		verify string
+	}{
+		{name: "DNS name", host: "nas.lan", verify: "nas.lan"},
+		{name: "DNS name with trailing dot and capitals", host: "Media.Home.Arpa.", verify: "media.home.arpa"},
+		{name: "IPv4 address", host: "192.168.1.20", verify: "192.168.1.20"},
+		{name: "IPv6 address", host: "fd00::20", verify: "fd00::20"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := ca.Certificate(tt.host)
+			require.NoError(t, err)
+			require.NotNil(t, cert.Leaf)
+
+			_, err = cert.Leaf.Verify(x509.VerifyOptions{
+				DNSName:   tt.verify,
+				Roots:     pool,
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+			assert.NoError(t, err)
+
+			// The certificate names only its own host
+			_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: "other.lan", Roots: pool})
+			assert.Error(t, err)
+
+			// And is not trusted without the local CA
+			_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: tt.verify, Roots: x509.NewCertPool()})
+			assert.Error(t, err)
+		})
+	}
+
+	again, err := ca.Certificate("nas.lan")
+	require.NoError(t, err)
+	first, err := ca.Certificate("NAS.lan")
+	require.NoError(t, err)
+	assert.Same(t, first, again, "certificates are minted once per host")
+	assert.Len(t, ca.ListCertificates(), 4)
+}
+
+func TestLocalCAInvalidHosts(t *testing.T) {
+	ca, err := NewLocalCA(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	for _, host := range []string{"", "../../etc/passwd", "bad_name.lan", "-lead.lan", "a..b", "*.lan"} {
+		_, err := ca.Certificate(host)
+		assert.ErrorIs(t, err, ErrInvalidHost, "%q", host)
+	}
+	assert.Empty(t, ca.ListCertificates())
+}
+
+func TestLocalCAPersistence(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := NewLocalCA(dir, 0)
+	require.NoError(t, err)
+	cert, err := ca.Certificate("nas.lan")
+	require.NoError(t, err)
+
+	// A restarted gate keeps its CA and the certificates it minted
+	reloaded, err := NewLocalCA(dir, 0)
+	require.NoError(t, err)
+	assert.Equal(t, ca.CACertificatePEM(), reloaded.CACertificatePEM())
+	require.Contains(t, reloaded.ListCertificates(), "nas.lan")
+	again, err := reloaded.Certificate("nas.lan")
+	require.NoError(t, err)
+	assert.Equal(t, cert.Leaf.SerialNumber, again.Leaf.SerialNumber)
+}
+
+func TestLocalCARenewal(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ca, err := NewLocalCA(t.TempDir(), 30*24*time.Hour)
+	require.NoError(t, err)
+	ca.now = func() time.Time { return clock }
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "certs.db"), Timeout: "30s"},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	ca.SetRepository(db.CertificateRepository())
+
+	first, err := ca.Certificate("nas.lan")
+	require.NoError(t, err)
+	record, err := db.CertificateRepository().GetByDomain("nas.lan")
+	require.NoError(t, err)
+	assert.True(t, record.NotAfter.Equal(first.Leaf.NotAfter))
+
+	// Unchanged until a third of the validity is left
+	clock = clock.Add(19 * 24 * time.Hour)
+	require.NoError(t, ca.RenewExpiring())
+	current, err := ca.Certificate("nas.lan")
+	require.NoError(t, err)
+	assert.Same(t, first, current)
+
+	clock = clock.Add(2 * 24 * time.Hour)
+	require.NoError(t, ca.RenewExpiring())
+	renewed, err := ca.Certificate("nas.lan")
+	require.NoError(t, err)
+	assert.NotSame(t, first, renewed)
+	assert.True(t, renewed.Leaf.NotAfter.After(first.Leaf.NotAfter))
+
+	records, err := db.CertificateRepository().List()
+	require.NoError(t, err)
+	require.Len(t, records, 1, "renewals update the record of the host")
+	assert.True(t, records[0].NotAfter.Equal(renewed.Leaf.NotAfter))
+
+	// Forced renewals need a certificate to renew
+	require.NoError(t, ca.RenewCertificate("nas.lan"))
+	assert.ErrorIs(t, ca.RenewCertificate("other.lan"), ErrUnknownDomain)
+}
+
+func TestLocalCAHandshake(t *testing.T) {
+	ca, err := NewLocalCA(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	// httptest would add its own certificate, served to clients sending no
+	// SNI name
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})}
+	go server.Serve(tls.NewListener(listener, ca.TLSConfig()))
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	get := func(t *testing.T, url, serverName string) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: caPool(t, ca), ServerName: serverName}}}
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(body))
+	}
+
+	// Clients connecting by IP address send no SNI name; the address is
+	// certified instead
+	t.Run("IP address", func(t *testing.T) {
+		get(t, "https://127.0.0.1:"+port, "")
+	})
+	t.Run("SNI name", func(t *testing.T) {
+		get(t, "https://127.0.0.1:"+port, "gate.lan")
+	})
+	assert.Contains(t, ca.ListCertificates(), "127.0.0.1")
+	assert.Contains(t, ca.ListCertificates(), "gate.lan")
+}
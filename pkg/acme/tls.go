@@ -0,0 +1,112 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// selfSignedValidity is how long a generated default certificate is valid
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// SelectCertificate returns the managed certificate for an SNI server name.
+// An exact domain match wins; otherwise any certificate whose SANs cover the
+// name, including wildcards, is used. A nil client has no certificates.
+func (c *Client) SelectCertificate(serverName string) (*tls.Certificate, bool) {
+	if c == nil || serverName == "" {
+		return nil, false
+	}
+
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if cert, exists := c.certificates[serverName]; exists {
+		return cert, true
+	}
+
+	for _, cert := range c.certificates {
+		if cert.Leaf != nil && cert.Leaf.VerifyHostname(serverName) == nil {
+			return cert, true
+		}
+	}
+
+	return nil, false
+}
+
+// TLSConfig returns a server TLS config that selects managed certificates by
+// SNI and serves fallback when none matches
+func (c *Client) TLSConfig(fallback *tls.Certificate) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert, ok := c.SelectCertificate(hello.ServerName); ok {
+				return cert, nil
+			}
+			if fallback == nil {
+				return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+			}
+			return fallback, nil
+		},
+	}
+}
+
+// SelfSignedCertificate generates a self-signed certificate for the given host
+// names and IP addresses, used as the default when no managed certificate matches
+func SelfSignedCertificate(hosts ...string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Infra-Core Gate"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if len(template.DNSNames) > 0 {
+		template.Subject.CommonName = template.DNSNames[0]
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
@@ -0,0 +1,144 @@
+package acme
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSelfSigned stores a self-signed certificate for hosts in the cache
+// directory under the name of the first host, as IssueCertificate would
+func writeSelfSigned(t *testing.T, certDir string, hosts ...string) *tls.Certificate {
+	cert, err := SelfSignedCertificate(hosts...)
+	require.NoError(t, err)
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	require.NoError(t, os.WriteFile(filepath.Join(certDir, certFileName(hosts[0])+".crt"), certPEM, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(certDir, certFileName(hosts[0])+".key"), keyPEM, 0600))
+	return cert
+}
+
+// startTLSServer accepts TLS connections with config and completes their handshakes
+func startTLSServer(t *testing.T, config *tls.Config) string {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// servedCertificate returns the leaf certificate presented for serverName
+func servedCertificate(t *testing.T, addr, serverName string) *x509.Certificate {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	require.NotEmpty(t, certs)
+	return certs[0]
+}
+
+func newCacheClient(certDir string) *Client {
+	return &Client{
+		certDir:      certDir,
+		certificates: make(map[string]*tls.Certificate),
+		certFiles:    make(map[string]*CertificateFiles),
+	}
+}
+
+func TestSNICertificateSelection(t *testing.T) {
+	certDir := t.TempDir()
+	writeSelfSigned(t, certDir, "a.example.com")
+	writeSelfSigned(t, certDir, "b.example.com", "www.b.example.com")
+
+	client := newCacheClient(certDir)
+	require.NoError(t, client.loadCertificates())
+
+	fallback, err := SelfSignedCertificate("localhost")
+	require.NoError(t, err)
+
+	addr := startTLSServer(t, client.TLSConfig(fallback))
+
+	tests := []struct {
+		serverName string
+		expected   []string
+	}{
+		{serverName: "a.example.com", expected: []string{"a.example.com"}},
+		{serverName: "b.example.com", expected: []string{"b.example.com", "www.b.example.com"}},
+		{serverName: "WWW.B.example.com", expected: []string{"b.example.com", "www.b.example.com"}},
+		{serverName: "c.example.com", expected: []string{"localhost"}},
+		{serverName: "", expected: []string{"localhost"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			assert.Equal(t, tt.expected, servedCertificate(t, addr, tt.serverName).DNSNames)
+		})
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	certDir := t.TempDir()
+	writeSelfSigned(t, certDir, "a.example.com")
+
+	client := newCacheClient(certDir)
+	require.NoError(t, client.loadCertificates())
+
+	addr := startTLSServer(t, client.TLSConfig(nil))
+	before := servedCertificate(t, addr, "a.example.com")
+
+	// A renewal written to the cache is served after a reload, without a restart
+	renewed := writeSelfSigned(t, certDir, "a.example.com")
+	writeSelfSigned(t, certDir, "b.example.com")
+	require.NoError(t, client.ReloadCertificates())
+
+	after := servedCertificate(t, addr, "a.example.com")
+	assert.NotEqual(t, before.SerialNumber, after.SerialNumber)
+	assert.Equal(t, renewed.Leaf.SerialNumber, after.SerialNumber)
+	assert.Equal(t, []string{"b.example.com"}, servedCertificate(t, addr, "b.example.com").DNSNames)
+	assert.Len(t, client.ListCertificates(), 2)
+
+	// Without a fallback unknown names fail the handshake
+	_, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "c.example.com", InsecureSkipVerify: true})
+	assert.Error(t, err)
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	cert, err := SelfSignedCertificate("gate.example.com", "127.0.0.1")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"gate.example.com"}, cert.Leaf.DNSNames)
+	require.Len(t, cert.Leaf.IPAddresses, 1)
+	assert.True(t, cert.Leaf.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")))
+	assert.NoError(t, cert.Leaf.VerifyHostname("gate.example.com"))
+}
+
+func TestSelectCertificateNilClient(t *testing.T) {
+	var client *Client
+	_, ok := client.SelectCertificate("a.example.com")
+	assert.False(t, ok)
+}
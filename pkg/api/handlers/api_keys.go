@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// CreateAPIKeyRequest creates an API key for the current user
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes"` // defaults to read-only
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKey issues a new API key for the current user. The full key is
+// only ever returned in this response.
+func (h *UserHandler) CreateAPIKey(c *gin.Context) {
+	if !h.requireTokenAuth(c) {
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{auth.ScopeRead}
+	}
+	for _, scope := range scopes {
+		if !auth.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope: " + scope})
+			return
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	key, prefix, hash, err := h.auth.GenerateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	apiKey := &database.APIKey{
+		UserID:    user.ID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := h.db.WithContext(c.Request.Context()).APIKeyRepository().Create(apiKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	recordAudit(c, auditActionCreate, auditResourceAPIKey, strconv.Itoa(apiKey.ID), gin.H{
+		"name":   apiKey.Name,
+		"prefix": apiKey.Prefix,
+		"scopes": apiKey.Scopes,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key created successfully. Store the key now, it will not be shown again.",
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// ListAPIKeys lists the current user's API keys without their secrets
+func (h *UserHandler) ListAPIKeys(c *gin.Context) {
+	if !h.requireTokenAuth(c) {
+		return
+	}
+
+	keys, err := h.db.WithContext(c.Request.Context()).APIKeyRepository().ListByUser(c.GetInt("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": keys,
+		"total":    len(keys),
+	})
+}
+
+// RevokeAPIKey revokes one of the current user's API keys
+func (h *UserHandler) RevokeAPIKey(c *gin.Context) {
+	if !h.requireTokenAuth(c) {
+		return
+	}
+
+	keyID, err := strconv.Atoi(c.Param("key_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	err = h.db.WithContext(c.Request.Context()).APIKeyRepository().Revoke(c.GetInt("user_id"), keyID)
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	recordAudit(c, auditActionRevoke, auditResourceAPIKey, strconv.Itoa(keyID), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+// requireTokenAuth rejects requests authenticated with an API key, so that a
+// leaked key cannot be used to mint or manage further keys, and requests made
+// with an impersonation token, which must not outlive it as a key
+func (h *UserHandler) requireTokenAuth(c *gin.Context) bool {
+	if _, usingAPIKey := c.Get("api_key_id"); usingAPIKey {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot manage API keys"})
+		return false
+	}
+	if _, impersonating := c.Get("impersonator_id"); impersonating {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Impersonation tokens cannot manage API keys"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	r, db, user := newUserTestRouter(t, config.LockoutConfig{})
+
+	w, created := postJSON(t, r, "/api/v1/users/api-keys", gin.H{"name": "ci"})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	key := created["key"].(string)
+	apiKey := created["api_key"].(map[string]interface{})
+	assert.True(t, strings.HasPrefix(key, apiKey["prefix"].(string)+"_"))
+	assert.Equal(t, "read", apiKey["scopes"])
+	assert.Nil(t, apiKey["key_hash"])
+
+	// The stored key authenticates by hash only
+	stored, err := db.APIKeyRepository().ListByUser(user.ID)
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.NotEqual(t, key, stored[0].KeyHash)
+
+	// Listings never include the full key
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/users/api-keys", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":1`)
+	assert.NotContains(t, w.Body.String(), key)
+
+	keyID := int(apiKey["id"].(float64))
+	w, _ = sendJSON(t, r, http.MethodDelete, fmt.Sprintf("/api/v1/users/api-keys/%d", keyID), gin.H{})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	stored, err = db.APIKeyRepository().ListByUser(user.ID)
+	require.NoError(t, err)
+	assert.True(t, stored[0].Revoked)
+
+	w, _ = sendJSON(t, r, http.MethodDelete, "/api/v1/users/api-keys/999", gin.H{})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	r, _, _ := newUserTestRouter(t, config.LockoutConfig{})
+
+	tests := []struct {
+		name         string
+		body         gin.H
+		expectedCode int
+	}{
+		{name: "read-write scopes", body: gin.H{"name": "deploy", "scopes": []string{"read", "write"}}, expectedCode: http.StatusCreated},
+		{name: "future expiry", body: gin.H{"name": "temp", "expires_at": time.Now().Add(time.Hour)}, expectedCode: http.StatusCreated},
+		{name: "missing name", body: gin.H{}, expectedCode: http.StatusBadRequest},
+		{name: "unknown scope", body: gin.H{"name": "bad", "scopes": []string{"admin"}}, expectedCode: http.StatusBadRequest},
+		{name: "past expiry", body: gin.H{"name": "old", "expires_at": time.Now().Add(-time.Hour)}, expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := postJSON(t, r, "/api/v1/users/api-keys", tt.body)
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestAPIKeysCannotManageAPIKeys(t *testing.T) {
+	_, db, user := newUserTestRouter(t, config.LockoutConfig{})
+
+	handler := NewUserHandler(nil, db)
+	r := gin.New()
+	users := r.Group("/api/v1/users", func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		c.Set("api_key_id", 1)
+	})
+	users.POST("/api-keys", handler.CreateAPIKey)
+	users.GET("/api-keys", handler.ListAPIKeys)
+
+	w, _ := postJSON(t, r, "/api/v1/users/api-keys", gin.H{"name": "escalate", "scopes": []string{"write"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w, _ = sendJSON(t, r, http.MethodGet, "/api/v1/users/api-keys", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/diff"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/services"
+)
+
+// Audit log actions
+const (
+	auditActionCreate      = "create"
+	auditActionUpdate      = "update"
+	auditActionDelete      = "delete"
+	auditActionStart       = "start"
+	auditActionStop        = "stop"
+	auditActionGrant       = "grant"
+	auditActionRevoke      = "revoke"
+	auditActionRotate      = "rotate"
+	auditActionExport      = "export"
+	auditActionImport      = "import"
+	auditActionRenew       = "renew"
+	auditActionRestart     = "restart"
+	auditActionImpersonate = "impersonate"
+	auditActionRevert      = "revert"
+	auditActionDeploy      = "deploy"
+)
+
+// Audit log resource types
+const (
+	auditResourceUser              = "user"
+	auditResourceAPIKey            = "api_key"
+	auditResourceService           = "service"
+	auditResourceRegisteredService = "registered_service"
+	auditResourceServicePermission = "service_permission"
+	auditResourceBackup            = "backup"
+	auditResourceMaintenance       = "maintenance_window"
+	auditResourceOAuthClient       = "oauth_client"
+	auditResourceSigningKey        = "signing_key"
+	auditResourceSession           = "sso_session"
+	auditResourceSystemConfig      = "system_config"
+	auditResourceCertificate       = "certificate"
+	auditResourceSecret            = "secret"
+	auditResourceServiceTemplate   = "service_template"
+	auditResourceOrganization      = "organization"
+	auditResourceOrgMember         = "organization_member"
+	auditResourceDeployHook        = "deploy_hook"
+)
+
+// auditRedactedFields are fields whose values may hold secrets, so diffs only
+// record that they changed
+var auditRedactedFields = map[string]bool{
+	"environment": true,
+	"yaml_config": true, // holds the environment too
+}
+
+// recordAudit queues an audit entry for an action by the authenticated user,
+// and the admin impersonating them if any. It does nothing when no audit
+// logger is installed on the request.
+func recordAudit(c *gin.Context, action, resourceType, resourceID string, details interface{}) {
+	value, exists := c.Get("audit_logger")
+	if !exists {
+		return
+	}
+	logger, ok := value.(*services.AuditLogger)
+	if !ok {
+		return
+	}
+
+	entry := &database.AuditLog{
+		Action:       action,
+		ResourceType: resourceType,
+	}
+	if userID, exists := c.Get("user_id"); exists {
+		if id, ok := userID.(int); ok {
+			entry.UserID = &id
+		}
+	}
+	if impersonatorID, exists := c.Get("impersonator_id"); exists {
+		if id, ok := impersonatorID.(int); ok {
+			entry.ImpersonatorID = &id
+		}
+	}
+	if resourceID != "" {
+		entry.ResourceID = &resourceID
+	}
+	if details != nil {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Warn("failed to encode audit details", "action", action, "resource_type", resourceType, "error", err)
+		} else {
+			detailsJSON := string(encoded)
+			entry.Details = &detailsJSON
+		}
+	}
+	if ip := c.ClientIP(); ip != "" {
+		entry.IPAddress = &ip
+	}
+	if userAgent := c.Request.UserAgent(); userAgent != "" {
+		entry.UserAgent = &userAgent
+	}
+
+	logger.Log(entry)
+	c.Set("audit_recorded", true)
+}
+
+// auditSnapshot captures a resource's JSON fields so that changes made to it
+// afterwards can be diffed with auditChanges
+func auditSnapshot(resource interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	encoded, err := json.Marshal(resource)
+	if err != nil {
+		return fields
+	}
+	_ = json.Unmarshal(encoded, &fields)
+	return fields
+}
+
+// auditChanges diffs a resource against an earlier snapshot, returning
+// {"field": {"from": old, "to": new}} for each field that changed, with
+// the fields of nested objects joined with dots as diff.Compare does
+func auditChanges(before map[string]interface{}, resource interface{}) map[string]interface{} {
+	changes := map[string]interface{}{}
+	for _, change := range diff.Compare(before, resource) {
+		field, _, _ := strings.Cut(change.Field, ".")
+		switch {
+		case field == "updated_at":
+		case auditRedactedFields[field]:
+			changes[field] = gin.H{"changed": true}
+		default:
+			changes[change.Field] = gin.H{"from": change.From, "to": change.To}
+		}
+	}
+	return changes
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/services"
+)
+
+func TestAuditEntriesForMutations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth: config.AuthConfig{
+				AllowRegistration: true,
+				JWT:               config.JWTConfig{Secret: "test-secret", ExpiresHours: 24},
+			},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	admin := &database.User{Username: "admin", Email: "admin@example.com", PasswordHash: "x", Role: "admin"}
+	require.NoError(t, db.UserRepository().Create(admin))
+
+	logger := services.NewAuditLogger(db, 0)
+	logger.Start()
+
+	userHandler := NewUserHandler(authService, db)
+	serviceHandler := NewServiceHandler(db, nil)
+	ssoHandler := NewSSOHandler(authService, db)
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("audit_logger", logger)
+		c.Set("user_id", admin.ID)
+		c.Set("role", admin.Role)
+	})
+	r.POST("/api/v1/auth/register", userHandler.Register)
+	r.PUT("/api/v1/users/:id", userHandler.UpdateUser)
+	r.POST("/api/v1/services/", serviceHandler.CreateService)
+	r.PUT("/api/v1/services/:id", serviceHandler.UpdateService)
+	r.POST("/api/v1/services/:id/start", serviceHandler.StartService)
+	r.DELETE("/api/v1/services/:id", serviceHandler.DeleteService)
+	r.POST("/api/v1/sso/services", ssoHandler.RegisterService)
+	r.POST("/api/v1/sso/permissions/:user_id/:service_id/grant", ssoHandler.GrantServiceAccess)
+	r.GET("/api/v1/system/audit", NewSystemHandler(db).GetAuditLogs)
+
+	w, registered := postJSON(t, r, "/api/v1/auth/register", gin.H{"username": "bob", "email": "bob@example.com", "password": "Sturdy-Passphrase-42"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	bobID := int(registered["user_id"].(float64))
+
+	w, _ = sendJSON(t, r, http.MethodPut, fmt.Sprintf("/api/v1/users/%d", bobID), gin.H{"role": "operator"})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w, created := postJSON(t, r, "/api/v1/services/", gin.H{"name": "web", "image": "nginx:1.25", "port": 80})
+	require.Equal(t, http.StatusCreated, w.Code)
+	serviceID := created["service_id"].(string)
+
+	w, _ = sendJSON(t, r, http.MethodPut, "/api/v1/services/"+serviceID, gin.H{"image": "nginx:1.27", "environment": gin.H{"TOKEN": "secret"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	w, _ = postJSON(t, r, "/api/v1/services/"+serviceID+"/start", gin.H{})
+	require.Equal(t, http.StatusOK, w.Code)
+	w, _ = sendJSON(t, r, http.MethodDelete, "/api/v1/services/"+serviceID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w, sso := postJSON(t, r, "/api/v1/sso/services", gin.H{"name": "grafana", "display_name": "Grafana", "service_url": "http://grafana:3000", "category": "monitoring", "required_role": "user"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w, _ = postJSON(t, r, fmt.Sprintf("/api/v1/sso/permissions/%d/%s/grant", bobID, sso["id"]), gin.H{})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Stopping the logger flushes everything queued by the requests above
+	logger.Stop()
+
+	auditLogs := func(query string) []*database.AuditLog {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/system/audit"+query, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			AuditLogs []*database.AuditLog `json:"audit_logs"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.AuditLogs
+	}
+
+	var recorded []string
+	for _, entry := range auditLogs("") {
+		recorded = append(recorded, entry.ResourceType+"."+entry.Action)
+		require.NotNil(t, entry.UserID)
+		assert.Equal(t, admin.ID, *entry.UserID)
+		assert.NotNil(t, entry.IPAddress)
+	}
+	assert.Equal(t, []string{
+		"service_permission.grant",
+		"registered_service.create",
+		"service.delete",
+		"service.start",
+		"service.update",
+		"service.create",
+		"user.update",
+		"user.create",
+	}, recorded)
+
+	// Updates record a diff, redacting values that may hold secrets
+	updates := auditLogs("?action=update&resource_type=service")
+	require.Len(t, updates, 1)
+	require.NotNil(t, updates[0].Details)
+	var details map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(*updates[0].Details), &details))
+	assert.Equal(t, map[string]interface{}{"from": "nginx:1.25", "to": "nginx:1.27"}, details["image"])
+	assert.Equal(t, map[string]interface{}{"changed": true}, details["environment"])
+	assert.NotContains(t, *updates[0].Details, "secret")
+
+	userUpdates := auditLogs(fmt.Sprintf("?resource_type=user&action=update&user_id=%d", admin.ID))
+	require.Len(t, userUpdates, 1)
+	assert.Contains(t, *userUpdates[0].Details, `"role":{"from":"user","to":"operator"}`)
+
+	assert.Empty(t, auditLogs("?since=2999-01-01T00:00:00Z"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/system/audit?until=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
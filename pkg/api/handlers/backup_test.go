@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestDatabaseBackupEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(dir, "console.db"), BackupDir: filepath.Join(dir, "backups")},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	handler := NewSystemHandler(db)
+	r := gin.New()
+	r.POST("/api/v1/system/backup", handler.CreateBackup)
+	r.GET("/api/v1/system/backups", handler.ListBackups)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/system/backups", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":0`)
+
+	w, created := postJSON(t, r, "/api/v1/system/backup", gin.H{})
+	require.Equal(t, http.StatusCreated, w.Code)
+	backup := created["backup"].(map[string]interface{})
+	assert.Equal(t, cfg.Console.Database.BackupDir, filepath.Dir(backup["path"].(string)))
+	assert.Greater(t, backup["size_bytes"], float64(0))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/system/backups", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var listed struct {
+		Backups []database.BackupInfo `json:"backups"`
+		Total   int                   `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Equal(t, 1, listed.Total)
+	assert.Equal(t, backup["sha256"], listed.Backups[0].SHA256)
+	assert.Equal(t, backup["name"], listed.Backups[0].Name)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+)
+
+// CertificateView is a certificate with the whole days left until it expires,
+// negative once it has
+type CertificateView struct {
+	*database.Certificate
+	DaysRemaining int `json:"days_remaining"`
+}
+
+// daysRemaining returns the whole days from now until notAfter
+func daysRemaining(notAfter, now time.Time) int {
+	return int(notAfter.Sub(now).Hours() / 24)
+}
+
+// SetGateClient sets the client of the gate's management API, through which
+// certificates are renewed
+func (h *SystemHandler) SetGateClient(gate *client.Gate) {
+	h.gateClient = gate
+}
+
+// ListCertificates lists the TLS certificates issued by the gate, soonest to
+// expire first
+func (h *SystemHandler) ListCertificates(c *gin.Context) {
+	certs, err := h.db.WithContext(c.Request.Context()).CertificateRepository().List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list certificates"})
+		return
+	}
+
+	now := time.Now()
+	views := make([]CertificateView, 0, len(certs))
+	for _, cert := range certs {
+		views = append(views, CertificateView{Certificate: cert, DaysRemaining: daysRemaining(cert.NotAfter, now)})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"certificates": views,
+		"count":        len(views),
+	})
+}
+
+// RenewCertificate asks the gate to renew a certificate through ACME now.
+// Renewal runs in the gate's background, so the response only confirms that
+// it started; the record is updated once the CA has issued the certificate.
+func (h *SystemHandler) RenewCertificate(c *gin.Context) {
+	if h.gateClient == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Gate is not configured"})
+		return
+	}
+
+	id := c.Param("id")
+	cert, err := h.db.WithContext(c.Request.Context()).CertificateRepository().GetByID(id)
+	if errors.Is(err, database.ErrCertificateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get certificate"})
+		return
+	}
+
+	if _, err := h.gateClient.RenewCertificate(c.Request.Context(), cert.Domain); err != nil {
+		if errors.Is(err, client.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Gate holds no certificate for this domain"})
+			return
+		}
+		logging.FromContext(c.Request.Context()).Error("failed to renew certificate", "domain", cert.Domain, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to reach the gate"})
+		return
+	}
+	recordAudit(c, auditActionRenew, auditResourceCertificate, cert.ID, gin.H{"domain": cert.Domain})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Certificate renewal started",
+		"id":      cert.ID,
+		"domain":  cert.Domain,
+	})
+}
+
+// DeleteCertificate deletes a certificate record. The gate keeps serving the
+// certificate files, and records it again the next time it renews it.
+func (h *SystemHandler) DeleteCertificate(c *gin.Context) {
+	id := c.Param("id")
+	certs := h.db.WithContext(c.Request.Context()).CertificateRepository()
+	cert, err := certs.GetByID(id)
+	if errors.Is(err, database.ErrCertificateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get certificate"})
+		return
+	}
+
+	if err := certs.Delete(id); err != nil {
+		if errors.Is(err, database.ErrCertificateNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete certificate"})
+		return
+	}
+	recordAudit(c, auditActionDelete, auditResourceCertificate, id, gin.H{"domain": cert.Domain})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Certificate deleted successfully"})
+}
+
+// DownloadCACertificate serves the certificate of the gate's local CA, for
+// admins to install on clients so that they trust the gate's self-signed
+// certificates
+func (h *SystemHandler) DownloadCACertificate(c *gin.Context) {
+	if h.gateClient == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Gate is not configured"})
+		return
+	}
+
+	pem, err := h.gateClient.CACertificate(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, client.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Gate does not issue self-signed certificates"})
+			return
+		}
+		logging.FromContext(c.Request.Context()).Error("failed to get CA certificate", "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to reach the gate"})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="infra-core-ca.crt"`)
+	c.Data(http.StatusOK, "application/x-pem-file", pem)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestCertificateEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now().UTC()
+	for id, notAfter := range map[string]time.Time{
+		"app": now.Add(20*24*time.Hour + time.Hour),
+		"api": now.Add(-2*24*time.Hour - time.Hour),
+	} {
+		require.NoError(t, db.CertificateRepository().Create(&database.Certificate{
+			ID:        id,
+			Domain:    id + ".example.com",
+			NotBefore: now.Add(-60 * 24 * time.Hour),
+			NotAfter:  notAfter,
+			CertPath:  id + ".crt",
+			KeyPath:   id + ".key",
+			Status:    "valid",
+		}))
+	}
+
+	// A fake gate management API holding a certificate for app.example.com only
+	var renewed []string
+	gate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/certificates/ca" {
+			_, _ = w.Write([]byte(`{"certificate":"-----BEGIN CERTIFICATE-----\n"}`))
+			return
+		}
+		if r.Method == http.MethodPost && r.URL.Path == "/certificates/app.example.com/renew" {
+			renewed = append(renewed, "app.example.com")
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"domain":"app.example.com","status":"renewing"}`))
+			return
+		}
+		http.Error(w, "no certificate for domain", http.StatusNotFound)
+	}))
+	defer gate.Close()
+
+	handler := NewSystemHandler(db)
+	r := gin.New()
+	r.GET("/api/v1/system/certificates", handler.ListCertificates)
+	r.POST("/api/v1/system/certificates/:id/renew", handler.RenewCertificate)
+	r.DELETE("/api/v1/system/certificates/:id", handler.DeleteCertificate)
+	r.GET("/api/v1/system/certificates/ca", handler.DownloadCACertificate)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	t.Run("list with days remaining", func(t *testing.T) {
+		w := serve(http.MethodGet, "/api/v1/system/certificates")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var listed struct {
+			Certificates []struct {
+				ID            string `json:"id"`
+				Domain        string `json:"domain"`
+				DaysRemaining int    `json:"days_remaining"`
+			} `json:"certificates"`
+			Count int `json:"count"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+		require.Equal(t, 2, listed.Count)
+		assert.Equal(t, "api", listed.Certificates[0].ID, "soonest to expire first")
+		assert.Equal(t, -2, listed.Certificates[0].DaysRemaining)
+		assert.Equal(t, "app.example.com", listed.Certificates[1].Domain)
+		assert.Equal(t, 20, listed.Certificates[1].DaysRemaining)
+	})
+
+	t.Run("renew without a gate", func(t *testing.T) {
+		assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodPost, "/api/v1/system/certificates/app/renew").Code)
+		assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodGet, "/api/v1/system/certificates/ca").Code)
+	})
+
+	handler.SetGateClient(client.NewGate(gate.URL, ""))
+
+	t.Run("download CA certificate", func(t *testing.T) {
+		w := serve(http.MethodGet, "/api/v1/system/certificates/ca")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-pem-file", w.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="infra-core-ca.crt"`, w.Header().Get("Content-Disposition"))
+		assert.Equal(t, "-----BEGIN CERTIFICATE-----\n", w.Body.String())
+	})
+
+	t.Run("renew", func(t *testing.T) {
+		w := serve(http.MethodPost, "/api/v1/system/certificates/app/renew")
+		require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"domain":"app.example.com"`)
+		assert.Equal(t, []string{"app.example.com"}, renewed)
+	})
+
+	t.Run("renew a certificate the gate doesn't hold", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/api/v1/system/certificates/api/renew").Code)
+	})
+
+	t.Run("renew a missing certificate", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/api/v1/system/certificates/missing/renew").Code)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(http.MethodDelete, "/api/v1/system/certificates/api").Code)
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/api/v1/system/certificates/api").Code)
+
+		certs, err := db.CertificateRepository().List()
+		require.NoError(t, err)
+		require.Len(t, certs, 1)
+		assert.Equal(t, "app", certs[0].ID)
+	})
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/probe"
+)
+
+// maxConfigExportSize limits the size of an imported configuration export
+const maxConfigExportSize = 16 << 20
+
+// ExportConfig returns the configuration of the install as one document, in
+// JSON or, with format=yaml, YAML, to be imported on another host. Probes are
+// read from the probe daemon, when one is configured.
+func (h *SystemHandler) ExportConfig(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "yaml" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or yaml"})
+		return
+	}
+
+	doc, err := h.db.WithContext(c.Request.Context()).ExportConfig()
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to export configuration", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export configuration"})
+		return
+	}
+	if h.probeClient != nil {
+		if doc.Probes, err = h.exportProbes(c.Request.Context()); err != nil {
+			logging.FromContext(c.Request.Context()).Error("failed to export probes", "error", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to export probes: " + err.Error()})
+			return
+		}
+	}
+	recordAudit(c, auditActionExport, auditResourceSystemConfig, "", configExportCounts(doc))
+
+	filename := "infra-core-" + doc.ExportedAt.Format("20060102-150405") + "." + format
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "json" {
+		c.JSON(http.StatusOK, doc)
+		return
+	}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode configuration"})
+		return
+	}
+	c.Data(http.StatusOK, "application/yaml; charset=utf-8", out)
+}
+
+// ImportConfig applies a configuration export, sent as JSON or, with a YAML
+// content type, YAML. The mode query parameter is merge (the default) or
+// replace. Nothing is applied unless every resource can be, and the report
+// says what was, or would have been, created, updated and skipped. Probes are
+// applied to the probe daemon once everything else was imported.
+func (h *SystemHandler) ImportConfig(c *gin.Context) {
+	mode := c.DefaultQuery("mode", database.ImportMerge)
+	if mode != database.ImportMerge && mode != database.ImportReplace {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be merge or replace"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxConfigExportSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read configuration"})
+		return
+	}
+	if len(body) > maxConfigExportSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Configuration is larger than %d bytes", maxConfigExportSize)})
+		return
+	}
+
+	var doc database.ConfigExport
+	if strings.Contains(c.ContentType(), "yaml") {
+		decoder := yaml.NewDecoder(bytes.NewReader(body))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&doc)
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&doc)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid configuration: " + err.Error()})
+		return
+	}
+	if len(doc.Probes) > 0 && h.probeClient == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No probe daemon is configured to import probes into"})
+		return
+	}
+
+	importedBy, _ := c.Get("user_id")
+	userID, _ := importedBy.(int)
+	report, err := h.db.WithContext(c.Request.Context()).ImportConfig(&doc, mode, userID)
+	if errors.Is(err, database.ErrInvalidConfigExport) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to import configuration", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import configuration"})
+		return
+	}
+	if !report.Applied {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Configuration was not imported", "report": report})
+		return
+	}
+	if h.probeClient != nil {
+		err := h.importProbes(c.Request.Context(), doc.Probes, mode, &report.Probes)
+		if err != nil || report.Probes.Errored > 0 {
+			if err != nil {
+				report.Probes.Messages = append(report.Probes.Messages, err.Error())
+			}
+			logging.FromContext(c.Request.Context()).Error("failed to import probes", "error", err, "errored", report.Probes.Errored)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Configuration was imported, but not every probe was", "report": report})
+			return
+		}
+	}
+	recordAudit(c, auditActionImport, auditResourceSystemConfig, "", gin.H{"mode": mode, "counts": configExportCounts(&doc)})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Configuration imported successfully", "report": report})
+}
+
+// configExportCounts counts the resources of each kind in an export
+func configExportCounts(doc *database.ConfigExport) gin.H {
+	return gin.H{
+		"services":            len(doc.Services),
+		"routes":              len(doc.Routes),
+		"registered_services": len(doc.RegisteredServices),
+		"permissions":         len(doc.Permissions),
+		"snap_plans":          len(doc.SnapPlans),
+		"probes":              len(doc.Probes),
+	}
+}
+
+// exportProbes reads the probes of the probe daemon, sorted by name
+func (h *SystemHandler) exportProbes(ctx context.Context) ([]database.ExportedProbe, error) {
+	probes, err := h.probeClient.ListProbes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	db := h.db.WithContext(ctx)
+	exported := make([]database.ExportedProbe, 0, len(probes))
+	for _, config := range probes {
+		p, err := exportedProbe(db, config)
+		if err != nil {
+			return nil, fmt.Errorf("probe %s: %w", config.Name, err)
+		}
+		exported = append(exported, p)
+	}
+	sort.Slice(exported, func(i, j int) bool { return exported[i].Name < exported[j].Name })
+	return exported, nil
+}
+
+// exportedProbe describes a probe for an export, referring to the service it
+// is bound to by name. A probe bound to a service that no longer exists keeps
+// only its target.
+func exportedProbe(db *database.DB, config *probe.ProbeConfig) (database.ExportedProbe, error) {
+	p := database.ExportedProbe{
+		Name:            config.Name,
+		Type:            config.Type,
+		Target:          config.Target,
+		Interval:        config.Interval.String(),
+		Timeout:         config.Timeout.String(),
+		Retries:         config.Retries,
+		Enabled:         config.Enabled,
+		ExpectedStatus:  config.ExpectedStatus,
+		ExpectedContent: config.ExpectedContent,
+		Headers:         config.Headers,
+		Tags:            config.Tags,
+		Config:          config.Config,
+	}
+	if t := config.Thresholds; t != nil {
+		p.Thresholds = &database.ExportedProbeThresholds{
+			ResponseTime:    t.ResponseTime.String(),
+			SuccessRate:     t.SuccessRate,
+			ConsecutiveFail: t.ConsecutiveFail,
+		}
+	}
+
+	if ref := config.ServiceRef; ref != nil {
+		var name string
+		var err error
+		if ref.Type == probe.ServiceRefManaged {
+			var service *database.Service
+			if service, err = db.ServiceRepository().GetByID(ref.ID); err == nil {
+				name = service.Name
+			}
+		} else {
+			var service *database.RegisteredService
+			if service, err = db.RegisteredServiceRepository().GetByID(ref.ID); err == nil {
+				name = service.Name
+			}
+		}
+		if err != nil && !database.IsNotFound(err) {
+			return p, err
+		}
+		if err == nil {
+			p.Service = &database.ExportedProbeService{Type: ref.Type, Name: name, Endpoint: ref.Endpoint}
+		}
+	}
+
+	normalizeProbe(&p)
+	return p, nil
+}
+
+// normalizeProbe writes durations the way time.Duration does and drops empty
+// collections, so that a probe compares equal to its copy in the daemon
+func normalizeProbe(p *database.ExportedProbe) {
+	canonical := func(value string) string {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d.String()
+		}
+		return value
+	}
+	p.Interval = canonical(p.Interval)
+	p.Timeout = canonical(p.Timeout)
+	if p.Thresholds != nil {
+		p.Thresholds.ResponseTime = canonical(p.Thresholds.ResponseTime)
+	}
+	if len(p.Headers) == 0 {
+		p.Headers = nil
+	}
+	if len(p.Tags) == 0 {
+		p.Tags = nil
+	}
+	if len(p.Config) == 0 {
+		p.Config = nil
+	}
+}
+
+// importProbes applies the probes of an import to the probe daemon. In
+// replace mode every probe of the daemon is deleted first; in merge mode
+// probes are matched to existing ones by name. Probes the daemon rejects are
+// recorded in result; the returned error is that of listing the probes.
+func (h *SystemHandler) importProbes(ctx context.Context, probes []database.ExportedProbe, mode string, result *database.ImportResult) error {
+	existing, err := h.probeClient.ListProbes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list probes: %w", err)
+	}
+
+	current := make(map[string]*probe.ProbeConfig, len(existing))
+	for _, config := range existing {
+		if mode == database.ImportReplace {
+			if err := h.probeClient.DeleteProbe(ctx, config.ID); err != nil {
+				result.Fail(config.Name, "failed to delete: %v", err)
+			}
+			continue
+		}
+		current[config.Name] = config
+	}
+
+	db := h.db.WithContext(ctx)
+	for _, p := range probes {
+		normalizeProbe(&p)
+		req, err := probeRequest(db, p)
+		if err != nil {
+			result.Fail(p.Name, "%v", err)
+			continue
+		}
+
+		config, exists := current[p.Name]
+		if exists {
+			old, err := exportedProbe(db, config)
+			if err != nil {
+				result.Fail(p.Name, "%v", err)
+				continue
+			}
+			if reflect.DeepEqual(old, p) {
+				result.Skipped++
+				continue
+			}
+		}
+
+		// The daemon cannot change the type of a probe, which is replaced instead
+		if exists && config.Type == p.Type {
+			config, err = h.probeClient.UpdateProbe(ctx, config.ID, req)
+			if err == nil {
+				result.Updated++
+			}
+		} else {
+			if exists {
+				if err := h.probeClient.DeleteProbe(ctx, config.ID); err != nil {
+					result.Fail(p.Name, "failed to replace: %v", err)
+					continue
+				}
+			}
+			config, err = h.probeClient.CreateProbe(ctx, req)
+			if err == nil {
+				result.Created++
+			}
+		}
+		if err != nil {
+			result.Fail(p.Name, "%v", err)
+			continue
+		}
+
+		switch {
+		case p.Enabled && !config.Enabled:
+			err = h.probeClient.EnableProbe(ctx, config.ID)
+		case !p.Enabled && config.Enabled:
+			err = h.probeClient.DisableProbe(ctx, config.ID)
+		}
+		if err != nil {
+			result.Fail(p.Name, "failed to enable or disable: %v", err)
+		}
+	}
+	return nil
+}
+
+// probeRequest builds the request creating or updating an imported probe,
+// resolving the service it is bound to by name
+func probeRequest(db *database.DB, p database.ExportedProbe) (*probe.CreateProbeRequest, error) {
+	req := &probe.CreateProbeRequest{
+		Name:            p.Name,
+		Type:            p.Type,
+		Target:          p.Target,
+		Interval:        p.Interval,
+		Timeout:         p.Timeout,
+		Retries:         p.Retries,
+		ExpectedStatus:  p.ExpectedStatus,
+		ExpectedContent: p.ExpectedContent,
+		Headers:         p.Headers,
+		Tags:            p.Tags,
+		Config:          p.Config,
+	}
+	if t := p.Thresholds; t != nil {
+		responseTime, _ := time.ParseDuration(t.ResponseTime) // checked by the import
+		req.Thresholds = &probe.ProbeThresholds{
+			ResponseTime:    responseTime,
+			SuccessRate:     t.SuccessRate,
+			ConsecutiveFail: t.ConsecutiveFail,
+		}
+	}
+
+	if service := p.Service; service != nil {
+		var id string
+		if service.Type == database.ProbeServiceManaged {
+			found, err := db.ServiceRepository().GetByName(service.Name)
+			if err != nil {
+				return nil, err
+			}
+			id = found.ID
+		} else {
+			found, err := db.RegisteredServiceRepository().GetByName(service.Name)
+			if err != nil {
+				return nil, err
+			}
+			id = found.ID
+		}
+		req.ServiceRef = &probe.ServiceRef{Type: service.Type, ID: id, Endpoint: service.Endpoint}
+	}
+	return req, nil
+}
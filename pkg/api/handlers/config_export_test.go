@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/probe"
+)
+
+// newConfigExportRouter serves export and import on a fresh database, as the
+// admin with ID 1, with a probe daemon sharing the database
+func newConfigExportRouter(t *testing.T) (*gin.Engine, *database.DB, *client.Probe) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{}
+	cfg.Console.Database.Path = filepath.Join(t.TempDir(), "console.db")
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	admin := &database.User{Username: "admin", Email: "admin@example.com", PasswordHash: "secret-hash", Role: "admin"}
+	require.NoError(t, db.UserRepository().Create(admin))
+
+	probes := client.NewProbe(serveDaemonAPI(t, probe.New(db, cfg).RegisterRoutes).URL, "")
+	handler := NewSystemHandler(db)
+	handler.SetDaemonClients(probes, nil)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("user_id", admin.ID)
+		c.Next()
+	})
+	r.GET("/api/v1/system/export", handler.ExportConfig)
+	r.POST("/api/v1/system/import", handler.ImportConfig)
+	return r, db, probes
+}
+
+func TestExportImportConfig(t *testing.T) {
+	source, sourceDB, sourceProbes := newConfigExportRouter(t)
+	web := &database.Service{Name: "web", Image: "nginx:1.27", Port: 80, Replicas: 1, YAMLConfig: "name: web\nimage: nginx:1.27\nport: 80\n"}
+	require.NoError(t, sourceDB.ServiceRepository().Create(web))
+	require.NoError(t, sourceDB.RouteRepository().Create(&database.Route{Host: "example.com", PathPrefix: "/", UpstreamServiceID: &web.ID}))
+	grafana := &database.RegisteredService{Name: "grafana", DisplayName: "Grafana", ServiceURL: "http://grafana:3000",
+		Category: "monitoring", RequiredRole: "user", Status: database.RegisteredServiceActive}
+	require.NoError(t, sourceDB.RegisteredServiceRepository().Create(grafana))
+	require.NoError(t, sourceDB.UserServicePermissionRepository().Grant(1, grafana.ID, 1, nil))
+	ctx := context.Background()
+	_, err := sourceProbes.CreateProbe(ctx, &probe.CreateProbeRequest{Name: "homepage", Type: "http",
+		Target: "https://example.com", Interval: "30s", Tags: []string{"public"}})
+	require.NoError(t, err)
+	bound, err := sourceProbes.CreateProbe(ctx, &probe.CreateProbeRequest{Name: "web port", Type: "tcp",
+		ServiceRef: &probe.ServiceRef{Type: probe.ServiceRefManaged, ID: web.ID}})
+	require.NoError(t, err)
+	require.NoError(t, sourceProbes.DisableProbe(ctx, bound.ID))
+
+	w := httptest.NewRecorder()
+	source.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/system/export?format=yaml", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".yaml")
+	exported := w.Body.String()
+	assert.Contains(t, exported, "version: 1")
+	assert.Contains(t, exported, "upstream_service: web")
+	assert.NotContains(t, exported, "secret-hash")
+	assert.Contains(t, exported, "name: homepage")
+	assert.NotContains(t, exported, web.ID, "probes should refer to services by name")
+
+	target, targetDB, targetProbes := newConfigExportRouter(t)
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/system/import?mode=merge", strings.NewReader(exported))
+	request.Header.Set("Content-Type", "application/yaml")
+	w = httptest.NewRecorder()
+	target.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	routes, err := targetDB.RouteRepository().List()
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	imported, err := targetDB.ServiceRepository().GetByName("web")
+	require.NoError(t, err)
+	assert.Equal(t, imported.ID, *routes[0].UpstreamServiceID, "the route should refer to the imported service")
+	assert.Equal(t, web.YAMLConfig, imported.YAMLConfig)
+	services, err := targetDB.UserServicePermissionRepository().ListUserServices(1)
+	require.NoError(t, err)
+	assert.Len(t, services, 1)
+
+	probes, err := targetProbes.ListProbes(ctx)
+	require.NoError(t, err)
+	require.Len(t, probes, 2)
+	byName := map[string]*probe.ProbeConfig{}
+	for _, p := range probes {
+		byName[p.Name] = p
+	}
+	require.Contains(t, byName, "homepage")
+	assert.Equal(t, "https://example.com", byName["homepage"].Target)
+	assert.Equal(t, 30*time.Second, byName["homepage"].Interval)
+	assert.Equal(t, []string{"public"}, byName["homepage"].Tags)
+	assert.True(t, byName["homepage"].Enabled)
+	require.Contains(t, byName, "web port")
+	require.NotNil(t, byName["web port"].ServiceRef)
+	assert.Equal(t, imported.ID, byName["web port"].ServiceRef.ID, "the probe should be bound to the imported service")
+	assert.False(t, byName["web port"].Enabled)
+
+	// Importing again leaves the probes alone
+	request = httptest.NewRequest(http.MethodPost, "/api/v1/system/import?mode=merge", strings.NewReader(exported))
+	request.Header.Set("Content-Type", "application/yaml")
+	w = httptest.NewRecorder()
+	target.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"probes":{"created":0,"updated":0,"skipped":2`)
+
+	// A document with problems is reported and not applied
+	w, response := sendJSON(t, target, http.MethodPost, "/api/v1/system/import?mode=replace", gin.H{
+		"version": 1,
+		"routes":  []gin.H{{"host": "example.com", "path_prefix": "/", "upstream_service": "missing"}},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	report := response["report"].(map[string]interface{})
+	assert.Equal(t, false, report["applied"])
+	assert.Equal(t, float64(1), report["routes"].(map[string]interface{})["errored"])
+	_, err = targetDB.ServiceRepository().GetByName("web")
+	assert.NoError(t, err, "a rejected replace should leave everything in place")
+
+	w, _ = sendJSON(t, target, http.MethodPost, "/api/v1/system/import", gin.H{"version": 99})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = sendJSON(t, target, http.MethodPost, "/api/v1/system/import", gin.H{"version": 1, "servics": []gin.H{}})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "unknown fields should be rejected")
+	w, _ = sendJSON(t, target, http.MethodPost, "/api/v1/system/import?mode=append", gin.H{"version": 1})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
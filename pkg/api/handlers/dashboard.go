@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/snap"
+)
+
+// dashboardCacheTTL is how long a composed dashboard is served to every
+// caller, so that dashboards polled by many clients do not each query SQLite
+const dashboardCacheTTL = 5 * time.Second
+
+// Limits of the dashboard sections
+const (
+	dashboardTrafficWindow      = 24 * time.Hour
+	dashboardCertificateWarning = 14 * 24 * time.Hour
+	dashboardRecentDeployments  = 10
+	dashboardRecentMetrics      = 10
+	dashboardUsageWindow        = 5 * time.Minute
+	dashboardTopConsumers       = 5
+)
+
+// Metrics summed into the dashboard's request and error totals
+const (
+	metricRequestCount = "request_count"
+	metricErrorCount   = "error_count"
+)
+
+// Metrics the orchestrator samples of each service instance, which rank the
+// dashboard's top consumers
+const (
+	metricScopeInstance  = "instance"
+	metricInstanceCPU    = "cpu_usage"
+	metricInstanceMemory = "memory_rss_bytes"
+)
+
+// dashboardBuilder composes the dashboard section by section. A section
+// that fails is null and reported in errors, without failing the others.
+type dashboardBuilder struct {
+	ctx    context.Context
+	data   gin.H
+	errors []gin.H
+}
+
+// add sets a section to the result of compose
+func (b *dashboardBuilder) add(section, message string, compose func() (interface{}, error)) {
+	value, err := compose()
+	if err != nil {
+		logging.FromContext(b.ctx).Warn("dashboard section failed", "section", section, "error", err)
+		b.data[section] = nil
+		b.errors = append(b.errors, gin.H{"section": section, "error": message})
+		return
+	}
+	b.data[section] = value
+}
+
+// GetDashboardData returns data for the dashboard. It is composed at most
+// once per dashboardCacheTTL; callers in between get the cached result.
+// Dashboards of an organization are composed for each request instead.
+func (h *SystemHandler) GetDashboardData(c *gin.Context) {
+	if _, scoped := database.OrgScope(c.Request.Context()); scoped {
+		c.JSON(http.StatusOK, h.composeDashboard(c.Request.Context(), time.Now()))
+		return
+	}
+
+	h.dashboardMu.Lock()
+	defer h.dashboardMu.Unlock()
+
+	now := time.Now()
+	if h.dashboard == nil || now.Sub(h.dashboardAt) >= dashboardCacheTTL {
+		h.dashboard = h.composeDashboard(c.Request.Context(), now)
+		h.dashboardAt = now
+	}
+	c.JSON(http.StatusOK, h.dashboard)
+}
+
+// composeDashboard queries every section of the dashboard. Active alerts and
+// the latest snapshots come from the probe and snap daemons when the console
+// has clients for them, and from the database otherwise.
+func (h *SystemHandler) composeDashboard(ctx context.Context, now time.Time) gin.H {
+	b := &dashboardBuilder{ctx: ctx, data: gin.H{}, errors: []gin.H{}}
+	db := h.db.WithContext(ctx)
+
+	b.add("services", "Failed to fetch services", func() (interface{}, error) {
+		services, err := db.ServiceRepository().List()
+		if err != nil {
+			return nil, err
+		}
+
+		counts := map[string]int{
+			"total":   len(services),
+			"running": 0,
+			"stopped": 0,
+			"error":   0,
+		}
+		for _, service := range services {
+			counts[service.Status]++
+		}
+		return counts, nil
+	})
+
+	registeredServices, registeredErr := db.RegisteredServiceRepository().List()
+	b.add("registered_services", "Failed to fetch registered services", func() (interface{}, error) {
+		if registeredErr != nil {
+			return nil, registeredErr
+		}
+
+		counts := map[string]int{
+			"total":                               len(registeredServices),
+			database.RegisteredServiceActive:      0,
+			database.RegisteredServiceUnreachable: 0,
+		}
+		for _, service := range registeredServices {
+			counts[service.Status]++
+		}
+		return counts, nil
+	})
+	b.add("failing_services", "Failed to fetch registered services", func() (interface{}, error) {
+		if registeredErr != nil {
+			return nil, registeredErr
+		}
+
+		failing := []gin.H{}
+		for _, service := range registeredServices {
+			if service.FailureStreak > 0 {
+				failing = append(failing, gin.H{
+					"id":             service.ID,
+					"name":           service.Name,
+					"status":         service.Status,
+					"failure_streak": service.FailureStreak,
+					"last_healthy":   service.LastHealthy,
+				})
+			}
+		}
+		return failing, nil
+	})
+	b.add("service_health", "Failed to fetch service health checks", func() (interface{}, error) {
+		summaries, err := db.RegisteredServiceRepository().GetHealthSummary()
+		if err != nil {
+			return nil, err
+		}
+
+		health := make([]gin.H, 0, len(summaries))
+		for _, summary := range summaries {
+			health = append(health, gin.H{
+				"id":            summary.ServiceID,
+				"name":          summary.Name,
+				"status":        summary.Status,
+				"is_healthy":    summary.IsHealthy,
+				"response_time": summary.ResponseTime,
+				"error_message": summary.ErrorMessage,
+				"checked_at":    summary.CheckedAt,
+			})
+		}
+		return health, nil
+	})
+
+	b.add("alerts", "Failed to count active alerts", func() (interface{}, error) {
+		bySeverity, err := h.activeAlertsBySeverity(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		active := 0
+		for _, count := range bySeverity {
+			active += count
+		}
+		return gin.H{"active": active, "by_severity": bySeverity}, nil
+	})
+
+	b.add("traffic", "Failed to sum request metrics", func() (interface{}, error) {
+		since := now.Add(-dashboardTrafficWindow)
+		metrics := db.MetricRepository()
+		requests, err := metrics.Sum(metricRequestCount, since)
+		if err != nil {
+			return nil, err
+		}
+		failures, err := metrics.Sum(metricErrorCount, since)
+		if err != nil {
+			return nil, err
+		}
+
+		errorRate := 0.0
+		if requests > 0 {
+			errorRate = failures / requests * 100
+		}
+		return gin.H{
+			"requests":   requests,
+			"errors":     failures,
+			"error_rate": errorRate,
+			"since":      since.UTC().Format(time.RFC3339),
+		}, nil
+	})
+
+	b.add("top_consumers", "Failed to fetch service instance usage", func() (interface{}, error) {
+		since := now.Add(-dashboardUsageWindow)
+		consumers := gin.H{}
+		for key, name := range map[string]string{"cpu": metricInstanceCPU, "memory": metricInstanceMemory} {
+			metrics, err := db.MetricRepository().Top(metricScopeInstance, name, since, dashboardTopConsumers)
+			if err != nil {
+				return nil, err
+			}
+
+			top := []gin.H{}
+			for _, metric := range metrics {
+				var labels struct {
+					ServiceID string `json:"service_id"`
+					Service   string `json:"service"`
+				}
+				if metric.Labels != nil {
+					_ = json.Unmarshal([]byte(*metric.Labels), &labels)
+				}
+				top = append(top, gin.H{
+					"instance":   metric.ScopeID,
+					"service_id": labels.ServiceID,
+					"service":    labels.Service,
+					"value":      metric.MetricValue,
+					"sampled_at": metric.Timestamp,
+				})
+			}
+			consumers[key] = top
+		}
+		return consumers, nil
+	})
+
+	b.add("recent_deployments", "Failed to fetch recent deployments", func() (interface{}, error) {
+		deployments, err := db.DeploymentRepository().ListRecent(dashboardRecentDeployments)
+		if err != nil {
+			return nil, err
+		}
+		if deployments == nil {
+			deployments = []*database.Deployment{}
+		}
+		return deployments, nil
+	})
+
+	b.add("backups", "Failed to fetch latest snapshots", func() (interface{}, error) {
+		snapshots, err := h.latestSnapshots(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		backups := []gin.H{}
+		for _, snapshot := range snapshots {
+			var age interface{}
+			if snapshot.Timestamp != nil {
+				age = int64(now.Sub(*snapshot.Timestamp).Seconds())
+			}
+			backups = append(backups, gin.H{
+				"plan_id":     snapshot.PlanID,
+				"plan_name":   snapshot.PlanName,
+				"enabled":     snapshot.Enabled,
+				"snapshot_id": snapshot.SnapshotID,
+				"timestamp":   snapshot.Timestamp,
+				"size_bytes":  snapshot.SizeBytes,
+				"kind":        snapshot.Kind,
+				"age_seconds": age,
+			})
+		}
+		return backups, nil
+	})
+
+	b.add("certificates", "Failed to fetch expiring certificates", func() (interface{}, error) {
+		certs, err := db.CertificateRepository().ListExpiring(now.Add(dashboardCertificateWarning))
+		if err != nil {
+			return nil, err
+		}
+
+		warnings := []gin.H{}
+		for _, cert := range certs {
+			remaining := cert.NotAfter.Sub(now)
+			warnings = append(warnings, gin.H{
+				"id":        cert.ID,
+				"domain":    cert.Domain,
+				"not_after": cert.NotAfter,
+				"status":    cert.Status,
+				"days_left": daysRemaining(cert.NotAfter, now),
+				"expired":   remaining <= 0,
+			})
+		}
+		return warnings, nil
+	})
+
+	b.add("users", "Failed to fetch users", func() (interface{}, error) {
+		// The users of an organization are its members
+		if orgID, scoped := database.OrgScope(ctx); scoped {
+			members, err := db.OrganizationRepository().ListMembers(orgID)
+			if err != nil {
+				return nil, err
+			}
+			return gin.H{"total": len(members)}, nil
+		}
+
+		users, err := db.UserRepository().List()
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"total": len(users)}, nil
+	})
+
+	b.add("recent_metrics", "Failed to fetch recent metrics", func() (interface{}, error) {
+		metrics, err := db.MetricRepository().GetRecent(dashboardRecentMetrics)
+		if err != nil {
+			return nil, err
+		}
+		if metrics == nil {
+			metrics = []*database.Metric{}
+		}
+		return metrics, nil
+	})
+
+	b.data["system"] = gin.H{
+		"uptime":     time.Since(h.startTime).String(),
+		"goroutines": runtime.NumGoroutine(),
+	}
+	b.data["errors"] = b.errors
+	b.data["timestamp"] = now.UTC().Format(time.RFC3339)
+	return b.data
+}
+
+// activeAlertsBySeverity counts the active probe alerts by severity
+func (h *SystemHandler) activeAlertsBySeverity(ctx context.Context) (map[string]int, error) {
+	if h.probeClient == nil {
+		return h.db.WithContext(ctx).ProbeAlertRepository().CountActiveBySeverity()
+	}
+
+	alerts, err := h.probeClient.ActiveAlerts(ctx, client.AlertQuery{Limit: -1})
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, alert := range alerts {
+		counts[alert.Severity]++
+	}
+	return counts, nil
+}
+
+// latestSnapshots lists every snapshot plan with its latest completed
+// snapshot, by plan name. The plans of an organization come from the
+// database, which records who owns them.
+func (h *SystemHandler) latestSnapshots(ctx context.Context) ([]*database.PlanSnapshot, error) {
+	if _, scoped := database.OrgScope(ctx); scoped || h.snapClient == nil {
+		return h.db.WithContext(ctx).SnapshotRepository().LatestPerPlan()
+	}
+
+	plans, err := h.snapClient.ListPlans(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Name < plans[j].Name })
+
+	latest := make([]*database.PlanSnapshot, 0, len(plans))
+	for _, plan := range plans {
+		entry := &database.PlanSnapshot{PlanID: plan.ID, PlanName: plan.Name, Enabled: plan.Enabled}
+		snapshots, err := h.snapClient.ListSnapshots(ctx, client.SnapshotQuery{PlanID: plan.ID, Status: snap.StatusCompleted, Limit: 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(snapshots) > 0 {
+			snapshot := snapshots[0]
+			entry.SnapshotID = &snapshot.ID
+			entry.Timestamp = &snapshot.Timestamp
+			entry.SizeBytes = &snapshot.Size
+			entry.Kind = &snapshot.Kind
+		}
+		latest = append(latest, entry)
+	}
+	return latest, nil
+}
@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/probe"
+	"github.com/last-emo-boy/infra-core/pkg/snap"
+)
+
+func TestGetDashboardData(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for name, status := range map[string]string{"api": "running", "web": "running", "worker": "stopped"} {
+		service := &database.Service{Name: name, Image: name + ":1", Status: status}
+		require.NoError(t, db.ServiceRepository().Create(service))
+		require.NoError(t, db.DeploymentRepository().Create(&database.Deployment{ServiceID: service.ID, Status: "succeeded"}))
+	}
+
+	for _, id := range []string{"portal", "wiki"} {
+		require.NoError(t, db.RegisteredServiceRepository().Create(&database.RegisteredService{
+			ID:           id,
+			Name:         id,
+			DisplayName:  id,
+			ServiceURL:   "http://localhost",
+			Category:     "web",
+			RequiredRole: "user",
+			Status:       database.RegisteredServiceActive,
+		}))
+	}
+	require.NoError(t, db.ServiceHealthCheckRepository().Record(&database.ServiceHealthCheck{
+		ServiceID:    "portal",
+		IsHealthy:    true,
+		ResponseTime: 12,
+		CheckedAt:    now,
+	}))
+
+	alerts := db.ProbeAlertRepository()
+	require.NoError(t, alerts.UpsertBatch([]*database.ProbeAlert{
+		{ID: "a1", ProbeID: "p1", Type: "availability", Severity: "critical", Status: "active", Message: "down", Count: 1, FirstSeen: now, LastSeen: now},
+		{ID: "a2", ProbeID: "p2", Type: "performance", Severity: "medium", Status: "resolved", Message: "slow", Count: 1, FirstSeen: now, LastSeen: now, ResolvedAt: &now},
+	}))
+
+	for name, value := range map[string]float64{metricRequestCount: 200, metricErrorCount: 10} {
+		for _, age := range []time.Duration{time.Hour, 48 * time.Hour} {
+			require.NoError(t, db.MetricRepository().Insert(&database.Metric{
+				Timestamp:   now.Add(-age),
+				ScopeType:   "route",
+				ScopeID:     "portal",
+				MetricName:  name,
+				MetricValue: value,
+			}))
+		}
+	}
+
+	// Instances are ranked by their latest sample within the usage window
+	for _, sample := range []struct {
+		instance string
+		age      time.Duration
+		cpu      float64
+		memory   float64
+	}{
+		{"api-0", time.Minute, 80, 100 << 20},
+		{"web-0", 2 * time.Minute, 5, 300 << 20},
+		{"web-0", time.Minute, 10, 200 << 20},
+		{"worker-0", time.Hour, 99, 900 << 20},
+	} {
+		labels := `{"service_id":"svc-` + sample.instance + `","service":"` + strings.TrimSuffix(sample.instance, "-0") + `"}`
+		for name, value := range map[string]float64{metricInstanceCPU: sample.cpu, metricInstanceMemory: sample.memory} {
+			require.NoError(t, db.MetricRepository().Insert(&database.Metric{
+				Timestamp:   now.Add(-sample.age),
+				ScopeType:   metricScopeInstance,
+				ScopeID:     sample.instance,
+				MetricName:  name,
+				MetricValue: value,
+				Labels:      &labels,
+			}))
+		}
+	}
+
+	_, err = db.Exec("INSERT INTO snap_plans (id, name, cron_expression, paths) VALUES ('nightly', 'nightly', '@daily', '[]')")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO snapshots (id, plan_id, timestamp, manifest_path, size_bytes, kind, status) VALUES ('s1', 'nightly', ?, '', 2048, 'full', 'completed')",
+		now.Add(-2*time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, db.CertificateRepository().Create(&database.Certificate{
+		ID:        "cert-1",
+		Domain:    "portal.example.com",
+		NotBefore: now.Add(-80 * 24 * time.Hour),
+		NotAfter:  now.Add(10*24*time.Hour + time.Hour),
+		CertPath:  "cert.pem",
+		KeyPath:   "key.pem",
+		Status:    "valid",
+	}))
+
+	get := func(handler *SystemHandler) map[string]interface{} {
+		r := gin.New()
+		r.GET("/system/dashboard", handler.GetDashboardData)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/system/dashboard", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	handler := NewSystemHandler(db)
+	response := get(handler)
+	assert.Empty(t, response["errors"])
+	assert.Equal(t, map[string]interface{}{
+		"total":   float64(3),
+		"running": float64(2),
+		"stopped": float64(1),
+		"error":   float64(0),
+	}, response["services"])
+
+	health := response["service_health"].([]interface{})
+	require.Len(t, health, 2)
+	portal := health[0].(map[string]interface{})
+	assert.Equal(t, "portal", portal["id"])
+	assert.Equal(t, true, portal["is_healthy"])
+	assert.Equal(t, float64(12), portal["response_time"])
+	wiki := health[1].(map[string]interface{})
+	assert.Equal(t, "wiki", wiki["id"])
+	assert.Nil(t, wiki["is_healthy"], "services never checked have no health")
+	assert.Nil(t, wiki["checked_at"])
+
+	assert.Equal(t, map[string]interface{}{
+		"active":      float64(1),
+		"by_severity": map[string]interface{}{"critical": float64(1)},
+	}, response["alerts"])
+
+	traffic := response["traffic"].(map[string]interface{})
+	assert.Equal(t, float64(200), traffic["requests"], "only the last day is counted")
+	assert.Equal(t, float64(10), traffic["errors"])
+	assert.Equal(t, float64(5), traffic["error_rate"])
+
+	topConsumers := response["top_consumers"].(map[string]interface{})
+	memory := topConsumers["memory"].([]interface{})
+	require.Len(t, memory, 2, "samples outside the usage window are left out")
+	top := memory[0].(map[string]interface{})
+	assert.Equal(t, "web-0", top["instance"])
+	assert.Equal(t, "web", top["service"])
+	assert.Equal(t, "svc-web-0", top["service_id"])
+	assert.Equal(t, float64(200<<20), top["value"])
+	cpu := topConsumers["cpu"].([]interface{})
+	require.Len(t, cpu, 2)
+	assert.Equal(t, "api-0", cpu[0].(map[string]interface{})["instance"])
+
+	assert.Len(t, response["recent_deployments"], 3)
+
+	backups := response["backups"].([]interface{})
+	require.Len(t, backups, 1)
+	backup := backups[0].(map[string]interface{})
+	assert.Equal(t, "nightly", backup["plan_name"])
+	assert.Equal(t, "s1", backup["snapshot_id"])
+	assert.InDelta(t, (2 * time.Hour).Seconds(), backup["age_seconds"], 60)
+
+	certs := response["certificates"].([]interface{})
+	require.Len(t, certs, 1)
+	cert := certs[0].(map[string]interface{})
+	assert.Equal(t, "portal.example.com", cert["domain"])
+	assert.Equal(t, float64(10), cert["days_left"])
+	assert.Equal(t, false, cert["expired"])
+
+	assert.Equal(t, map[string]interface{}{"total": float64(0)}, response["users"])
+	assert.NotNil(t, response["system"])
+
+	// Responses are cached for a few seconds
+	require.NoError(t, alerts.UpsertBatch([]*database.ProbeAlert{
+		{ID: "a3", ProbeID: "p3", Type: "availability", Severity: "high", Status: "active", Message: "down", Count: 1, FirstSeen: now, LastSeen: now},
+	}))
+	assert.Equal(t, float64(1), get(handler)["alerts"].(map[string]interface{})["active"])
+	handler.dashboardAt = handler.dashboardAt.Add(-dashboardCacheTTL)
+	assert.Equal(t, float64(2), get(handler)["alerts"].(map[string]interface{})["active"])
+
+	// Failed sections are null without failing the others
+	_, err = db.Exec("DROP TABLE probe_alerts")
+	require.NoError(t, err)
+	_, err = db.Exec("DROP TABLE certificates")
+	require.NoError(t, err)
+
+	response = get(NewSystemHandler(db))
+	assert.Contains(t, response, "alerts")
+	assert.Nil(t, response["alerts"])
+	assert.Contains(t, response, "certificates")
+	assert.Nil(t, response["certificates"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"section": "alerts", "error": "Failed to count active alerts"},
+		map[string]interface{}{"section": "certificates", "error": "Failed to fetch expiring certificates"},
+	}, response["errors"])
+	assert.Equal(t, float64(3), response["services"].(map[string]interface{})["total"])
+	assert.Len(t, response["backups"], 1)
+}
+
+func TestGetDashboardDataScopedToOrg(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	// Each organization has a service with a deployment, a route with a
+	// certificate, traffic, an instance, an active alert and a member
+	for _, orgID := range []string{"acme", "globex"} {
+		require.NoError(t, db.OrganizationRepository().Create(&database.Organization{ID: orgID, DisplayName: orgID}))
+		scoped := db.WithContext(database.WithOrgScope(context.Background(), orgID))
+
+		service := &database.Service{Name: orgID + "-web", Image: "nginx", Status: "running"}
+		require.NoError(t, scoped.ServiceRepository().Create(service))
+		require.NoError(t, db.DeploymentRepository().Create(&database.Deployment{ServiceID: service.ID, Status: "succeeded"}))
+
+		route := &database.Route{Host: orgID + ".example.com", PathPrefix: "/", UpstreamServiceID: &service.ID}
+		require.NoError(t, scoped.RouteRepository().Create(route))
+		require.NoError(t, db.CertificateRepository().Create(&database.Certificate{
+			ID:        orgID + "-cert",
+			Domain:    route.Host,
+			NotBefore: now.Add(-80 * 24 * time.Hour),
+			NotAfter:  now.Add(24 * time.Hour),
+			CertPath:  "cert.pem",
+			KeyPath:   "key.pem",
+			Status:    "valid",
+		}))
+
+		labels := `{"service_id":"` + service.ID + `","service":"` + service.Name + `"}`
+		metrics := []*database.Metric{
+			{Timestamp: now.Add(-time.Minute), ScopeType: "service", ScopeID: service.ID, MetricName: metricRequestCount, MetricValue: 100},
+			{Timestamp: now.Add(-time.Minute), ScopeType: "route", ScopeID: route.ID, MetricName: metricRequestCount, MetricValue: 50},
+			{Timestamp: now.Add(-time.Minute), ScopeType: metricScopeInstance, ScopeID: orgID + "-0", MetricName: metricInstanceCPU, MetricValue: 10, Labels: &labels},
+		}
+		for _, metric := range metrics {
+			require.NoError(t, db.MetricRepository().Insert(metric))
+		}
+
+		owner := orgID
+		require.NoError(t, db.ProbeAlertRepository().UpsertBatch([]*database.ProbeAlert{
+			{ID: orgID + "-down", ProbeID: orgID + "-probe", Type: "availability", Severity: "critical", Status: "active", Message: "down", Count: 1, FirstSeen: now, LastSeen: now, OrgID: &owner},
+		}))
+
+		user := &database.User{Username: orgID + "-user", Email: orgID + "@example.com", PasswordHash: "hash", Role: "user"}
+		require.NoError(t, db.UserRepository().Create(user))
+		require.NoError(t, db.OrganizationRepository().SetMember(orgID, user.ID, database.OrgRoleMember))
+	}
+	require.NoError(t, db.MetricRepository().Insert(&database.Metric{
+		Timestamp: now.Add(-time.Minute), ScopeType: "host", ScopeID: "node-1", MetricName: "cpu_percent", MetricValue: 42,
+	}))
+
+	r := gin.New()
+	r.GET("/system/dashboard", NewSystemHandler(db).GetDashboardData)
+	req := httptest.NewRequest(http.MethodGet, "/system/dashboard", nil)
+	req = req.WithContext(database.WithOrgScope(req.Context(), "acme"))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Empty(t, response["errors"])
+
+	deployments := response["recent_deployments"].([]interface{})
+	require.Len(t, deployments, 1, "other organizations' deployments are left out")
+
+	traffic := response["traffic"].(map[string]interface{})
+	assert.Equal(t, float64(150), traffic["requests"], "only the organization's services and routes are counted")
+
+	cpu := response["top_consumers"].(map[string]interface{})["cpu"].([]interface{})
+	require.Len(t, cpu, 1)
+	assert.Equal(t, "acme-0", cpu[0].(map[string]interface{})["instance"])
+
+	certs := response["certificates"].([]interface{})
+	require.Len(t, certs, 1)
+	assert.Equal(t, "acme.example.com", certs[0].(map[string]interface{})["domain"])
+
+	assert.Equal(t, map[string]interface{}{"total": float64(1)}, response["users"])
+	assert.Equal(t, map[string]interface{}{
+		"active":      float64(1),
+		"by_severity": map[string]interface{}{"critical": float64(1)},
+	}, response["alerts"])
+
+	recent := response["recent_metrics"].([]interface{})
+	assert.Len(t, recent, 3, "host metrics and other organizations' metrics are left out")
+	for _, metric := range recent {
+		assert.NotEqual(t, "host", metric.(map[string]interface{})["scope_type"])
+	}
+}
+
+// serveDaemonAPI serves the API a daemon registers under /api/v1
+func serveDaemonAPI(t *testing.T, register func(api gin.IRouter)) *httptest.Server {
+	r := gin.New()
+	register(r.Group("/api/v1"))
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetDashboardDataFromDaemons(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newDB := func(name string) (*config.Config, *database.DB) {
+		cfg := &config.Config{}
+		cfg.Console.Database.Path = filepath.Join(t.TempDir(), name+".db")
+		db, err := database.NewDB(cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return cfg, db
+	}
+	_, consoleDB := newDB("console")
+	probeCfg, probeDB := newDB("probe")
+	_, snapDB := newDB("snap")
+
+	// The console's own copies are stale and must not be used
+	now := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, consoleDB.ProbeAlertRepository().UpsertBatch([]*database.ProbeAlert{
+		{ID: "stale", ProbeID: "p0", Type: "availability", Severity: "low", Status: "active", Message: "old", Count: 1, FirstSeen: now, LastSeen: now},
+	}))
+
+	require.NoError(t, probeDB.ProbeAlertRepository().UpsertBatch([]*database.ProbeAlert{
+		{ID: "a1", ProbeID: "p1", Type: "availability", Severity: "critical", Status: "active", Message: "down", Count: 1, FirstSeen: now, LastSeen: now},
+		{ID: "a2", ProbeID: "p2", Type: "availability", Severity: "critical", Status: "active", Message: "down", Count: 1, FirstSeen: now, LastSeen: now},
+		{ID: "a3", ProbeID: "p3", Type: "performance", Severity: "medium", Status: "active", Message: "slow", Count: 1, FirstSeen: now, LastSeen: now},
+	}))
+	probeServer := serveDaemonAPI(t, probe.New(probeDB, probeCfg).RegisterRoutes)
+
+	_, err := snapDB.Exec(`INSERT INTO snap_plans (id, name, cron_expression, paths, enabled) VALUES
+		('p-weekly', 'weekly', '0 3 * * 0', '[]', 0), ('p-nightly', 'nightly', '0 2 * * *', '[]', 1)`)
+	require.NoError(t, err)
+	for _, snapshot := range []struct {
+		id     string
+		age    time.Duration
+		status string
+	}{{"s-old", 26 * time.Hour, "completed"}, {"s-new", 2 * time.Hour, "completed"}, {"s-failed", time.Hour, "failed"}} {
+		_, err = snapDB.Exec("INSERT INTO snapshots (id, plan_id, timestamp, manifest_path, size_bytes, kind, status) VALUES (?, 'p-nightly', ?, '', 4096, 'full', ?)",
+			snapshot.id, now.Add(-snapshot.age), snapshot.status)
+		require.NoError(t, err)
+	}
+	manager, err := snap.NewSnapManager(snapDB.DB, config.SnapConfig{RepoDir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(manager.Stop)
+	snapServer := serveDaemonAPI(t, manager.RegisterRoutes)
+
+	handler := NewSystemHandler(consoleDB)
+	handler.SetDaemonClients(client.NewProbe(probeServer.URL, ""), client.NewSnap(snapServer.URL, ""))
+	get := func() map[string]interface{} {
+		handler.dashboardAt = time.Time{}
+		r := gin.New()
+		r.GET("/system/dashboard", handler.GetDashboardData)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/system/dashboard", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	response := get()
+	assert.Empty(t, response["errors"])
+	assert.Equal(t, map[string]interface{}{
+		"active":      float64(3),
+		"by_severity": map[string]interface{}{"critical": float64(2), "medium": float64(1)},
+	}, response["alerts"])
+
+	backups := response["backups"].([]interface{})
+	require.Len(t, backups, 2)
+	nightly := backups[0].(map[string]interface{})
+	assert.Equal(t, "nightly", nightly["plan_name"], "plans are ordered by name")
+	assert.Equal(t, true, nightly["enabled"])
+	assert.Equal(t, "s-new", nightly["snapshot_id"], "the latest completed snapshot is shown")
+	assert.Equal(t, float64(4096), nightly["size_bytes"])
+	assert.InDelta(t, (2 * time.Hour).Seconds(), nightly["age_seconds"], 60)
+	weekly := backups[1].(map[string]interface{})
+	assert.Equal(t, "weekly", weekly["plan_name"])
+	assert.Nil(t, weekly["snapshot_id"], "plans without snapshots have none")
+	assert.Nil(t, weekly["age_seconds"])
+
+	// An unreachable daemon fails only its section
+	probeServer.Close()
+	handler.SetDaemonClients(client.NewProbe(probeServer.URL, "", client.WithRetries(0, 0)), client.NewSnap(snapServer.URL, ""))
+	response = get()
+	assert.Nil(t, response["alerts"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"section": "alerts", "error": "Failed to count active alerts"},
+	}, response["errors"])
+	assert.Len(t, response["backups"], 2)
+}
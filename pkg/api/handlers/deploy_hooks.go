@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+)
+
+// Deploy hook defaults used when the console config leaves them unset
+const (
+	DefaultDeployHookClockSkew  = 5 * time.Minute
+	DefaultDeployHookMaxDeploys = 5
+	DefaultDeployHookWindow     = 10 * time.Minute
+)
+
+// Limits of deploy hook requests
+const (
+	maxDeployHookBody  = 64 << 10
+	maxDeployHookNonce = 128
+	maxDeployHookNotes = 4096
+)
+
+// imageTagPattern is what image tags look like
+var imageTagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// DeployHookHandler deploys services when CI calls their signed webhooks
+type DeployHookHandler struct {
+	db           *database.DB
+	key          []byte
+	orchestrator *client.Orchestrator
+
+	maxClockSkew time.Duration
+	maxDeploys   int
+	window       time.Duration
+
+	mu      sync.Mutex
+	deploys map[string][]time.Time // service ID to when its hook deployed it within the window
+}
+
+// NewDeployHookHandler creates a deploy hook handler sealing hook secrets
+// with the secrets master key
+func NewDeployHookHandler(db *database.DB, key []byte, cfg config.DeployHooksConfig) *DeployHookHandler {
+	h := &DeployHookHandler{
+		db:           db,
+		key:          key,
+		maxClockSkew: DefaultDeployHookClockSkew,
+		maxDeploys:   DefaultDeployHookMaxDeploys,
+		window:       DefaultDeployHookWindow,
+		deploys:      make(map[string][]time.Time),
+	}
+	if skew, err := time.ParseDuration(cfg.MaxClockSkew); err == nil && skew > 0 {
+		h.maxClockSkew = skew
+	}
+	if cfg.MaxDeploys > 0 {
+		h.maxDeploys = cfg.MaxDeploys
+	}
+	if window, err := time.ParseDuration(cfg.Window); err == nil && window > 0 {
+		h.window = window
+	}
+	return h
+}
+
+// SetOrchestratorClient sets the client of the orchestrator that deploy
+// hooks deploy through. Without one hooks are answered 503.
+func (h *DeployHookHandler) SetOrchestratorClient(orchestrator *client.Orchestrator) {
+	h.orchestrator = orchestrator
+}
+
+// DeployHookSecretRequest sets up a service's deploy hook
+type DeployHookSecretRequest struct {
+	Strategy string `json:"strategy"` // rolling or recreate, the orchestrator's default when empty
+}
+
+// DeployHookRequest is the body of a deploy hook call
+type DeployHookRequest struct {
+	ImageTag    string `json:"image_tag" binding:"required"`
+	TriggeredBy string `json:"triggered_by"` // such as the CI pipeline and commit
+	Notes       string `json:"notes"`
+}
+
+// GenerateDeployHookSecret generates a new secret for a service's deploy
+// hook, replacing any it had. The secret is only ever returned in this
+// response.
+func (h *DeployHookHandler) GenerateDeployHookSecret(c *gin.Context) {
+	var req DeployHookSecretRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Strategy != "" && req.Strategy != orchestrator.StrategyRolling && req.Strategy != orchestrator.StrategyRecreate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid strategy, expected rolling or recreate"})
+		return
+	}
+
+	db := h.db.WithContext(c.Request.Context())
+	service, err := db.ServiceRepository().GetByID(c.Param("id"))
+	if err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
+
+	secret, err := auth.GenerateDeployHookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate deploy hook secret"})
+		return
+	}
+	hook := &database.DeployHook{ServiceID: service.ID, Strategy: req.Strategy}
+	if userID, ok := c.Get("user_id"); ok {
+		if id, ok := userID.(int); ok {
+			hook.CreatedBy = &id
+		}
+	}
+	err = db.DeployHookRepository(h.key).Set(hook, secret)
+	if errors.Is(err, database.ErrNoMasterKey) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Deploy hooks are unavailable: no master key is configured"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save deploy hook"})
+		return
+	}
+	recordAudit(c, auditActionRotate, auditResourceDeployHook, service.ID, gin.H{"strategy": hook.Strategy})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":          "Deploy hook secret generated; it will not be shown again",
+		"secret":           secret,
+		"hook":             hook,
+		"url":              "/api/v1/services/" + service.ID + "/deploy-hook",
+		"signature_header": auth.DeployHookSignatureHeader,
+		"timestamp_header": auth.DeployHookTimestampHeader,
+		"nonce_header":     auth.DeployHookNonceHeader,
+	})
+}
+
+// DeleteDeployHook removes a service's deploy hook
+func (h *DeployHookHandler) DeleteDeployHook(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	service, err := db.ServiceRepository().GetByID(c.Param("id"))
+	if err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
+
+	err = db.DeployHookRepository(h.key).Delete(service.ID)
+	if errors.Is(err, database.ErrDeployHookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deploy hook not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete deploy hook"})
+		return
+	}
+	recordAudit(c, auditActionDelete, auditResourceDeployHook, service.ID, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deploy hook deleted successfully"})
+}
+
+// TriggerDeployHook sets the image tag of a service and deploys it with
+// its hook's strategy, for CI to call after building an image. Requests
+// are authenticated by their signature under the hook's secret instead of
+// a token, and carry a timestamp within the allowed clock skew and a nonce
+// that may only be used once. Each service's hook deploys it at most
+// max_deploys times per window. The response holds the deployment ID,
+// which CI polls GET /api/v1/deployments/:id with.
+func (h *DeployHookHandler) TriggerDeployHook(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxDeployHookBody+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	if len(body) > maxDeployHookBody {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+		return
+	}
+
+	serviceID := c.Param("id")
+	timestamp := c.GetHeader(auth.DeployHookTimestampHeader)
+	nonce := c.GetHeader(auth.DeployHookNonceHeader)
+	signature := c.GetHeader(auth.DeployHookSignatureHeader)
+	if timestamp == "" || nonce == "" || signature == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Signature, timestamp and nonce headers are required"})
+		return
+	}
+	if len(nonce) > maxDeployHookNonce {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nonce is longer than " + strconv.Itoa(maxDeployHookNonce) + " characters"})
+		return
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid timestamp, expected Unix seconds"})
+		return
+	}
+	signedAt := time.Unix(seconds, 0)
+	now := time.Now()
+	if skew := now.Sub(signedAt); skew > h.maxClockSkew || skew < -h.maxClockSkew {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Timestamp is outside the allowed clock skew"})
+		return
+	}
+
+	// Services without a hook are answered as bad signatures, so that
+	// callers cannot tell which services have one
+	db := h.db.WithContext(c.Request.Context())
+	hooks := db.DeployHookRepository(h.key)
+	hook, secret, err := hooks.Get(serviceID)
+	if err != nil && !errors.Is(err, database.ErrDeployHookNotFound) {
+		if errors.Is(err, database.ErrNoMasterKey) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Deploy hooks are unavailable: no master key is configured"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get deploy hook"})
+		return
+	}
+	if hook == nil || !auth.VerifyDeployHook(secret, timestamp, nonce, body, signature) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	}
+
+	err = hooks.UseNonce(serviceID, nonce, signedAt.Add(h.maxClockSkew))
+	if errors.Is(err, database.ErrDeployHookReplayed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Nonce was already used"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record nonce"})
+		return
+	}
+
+	var req DeployHookRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	if !imageTagPattern.MatchString(req.ImageTag) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image tag"})
+		return
+	}
+	if len(req.TriggeredBy) > maxDeployHookNotes || len(req.Notes) > maxDeployHookNotes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "triggered_by and notes must be at most " + strconv.Itoa(maxDeployHookNotes) + " bytes"})
+		return
+	}
+	if h.orchestrator == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No orchestrator is configured"})
+		return
+	}
+	if retryAfter, ok := h.allowDeploy(serviceID, now); !ok {
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+0.999)))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many deployments of this service, retry later"})
+		return
+	}
+
+	service, err := db.ServiceRepository().GetByID(serviceID)
+	if err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
+	original := *service
+	serviceSpec, _ := currentSpec(service)
+	serviceSpec.Image = withImageTag(serviceSpec.Image, req.ImageTag)
+	yamlConfig, err := serviceSpec.YAML()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update service"})
+		return
+	}
+
+	result, err := h.orchestrator.Deploy(c.Request.Context(), &orchestrator.DeployRequest{
+		Spec:          yamlConfig,
+		Strategy:      hook.Strategy,
+		TriggerSource: orchestrator.TriggerDeployHook,
+		TriggeredBy:   req.TriggeredBy,
+		Notes:         req.Notes,
+	})
+	if err != nil {
+		status := http.StatusBadGateway
+		var clientErr *client.Error
+		if errors.As(err, &clientErr) && clientErr.StatusCode == http.StatusConflict {
+			status = http.StatusConflict // a deployment of the service is in progress
+		}
+		c.JSON(status, gin.H{"error": "Failed to trigger deployment: " + err.Error(), "image": serviceSpec.Image})
+		return
+	}
+
+	// The service only records the new image once its deployment started,
+	// so a failed deployment leaves it showing what actually runs
+	applySpec(service, serviceSpec)
+	service.YAMLConfig = yamlConfig
+	service.Status = "running"
+	err = h.db.WithTx(c.Request.Context(), func(tx *database.DB) error {
+		revisions := tx.ServiceRevisionRepository()
+		if err := revisions.Record(&original, nil); err != nil {
+			return err
+		}
+		service.Version = original.Version
+		if err := tx.ServiceRepository().Update(service); err != nil {
+			return err
+		}
+		return revisions.Record(service, nil)
+	})
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to record deployed image", "service_id", service.ID, "deployment_id", result.DeploymentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Deployment started but the service could not be updated", "deployment_id": result.DeploymentID})
+		return
+	}
+	recordAudit(c, auditActionDeploy, auditResourceService, service.ID, gin.H{
+		"source":        orchestrator.TriggerDeployHook,
+		"image":         service.Image,
+		"previous":      original.Image,
+		"triggered_by":  req.TriggeredBy,
+		"notes":         req.Notes,
+		"deployment_id": result.DeploymentID,
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment_id": result.DeploymentID,
+		"revision":      result.Revision,
+		"status":        result.Status,
+		"service_id":    service.ID,
+		"image":         service.Image,
+		"status_url":    "/api/v1/deployments/" + result.DeploymentID,
+	})
+}
+
+// allowDeploy reports whether a service's hook may deploy it now, or how
+// long until it may
+func (h *DeployHookHandler) allowDeploy(serviceID string, now time.Time) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	recent := h.deploys[serviceID][:0]
+	for _, at := range h.deploys[serviceID] {
+		if now.Sub(at) < h.window {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= h.maxDeploys {
+		h.deploys[serviceID] = recent
+		return recent[0].Add(h.window).Sub(now), false
+	}
+	h.deploys[serviceID] = append(recent, now)
+	return 0, true
+}
+
+// withImageTag returns an image with its tag, and any digest, replaced
+func withImageTag(image, tag string) string {
+	for i := len(image) - 1; i >= 0; i-- {
+		if image[i] == '@' {
+			image = image[:i]
+			break
+		}
+	}
+	for i := len(image) - 1; i >= 0 && image[i] != '/'; i-- {
+		if image[i] == ':' {
+			image = image[:i]
+			break
+		}
+	}
+	return image + ":" + tag
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+)
+
+// hookRuntime is an orchestrator runtime that only remembers the images
+// of the instances it runs
+type hookRuntime struct {
+	mutex   sync.Mutex
+	running map[string]string
+}
+
+func (r *hookRuntime) Start(ctx context.Context, service *orchestrator.ServiceInstance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.running[service.ID] = service.Image
+	return nil
+}
+
+func (r *hookRuntime) Stop(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.running, id)
+	return nil
+}
+
+func (r *hookRuntime) Status(ctx context.Context, id string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.running[id]; !exists {
+		return "", orchestrator.ErrInstanceNotFound
+	}
+	return "running", nil
+}
+
+func (r *hookRuntime) CheckHealth(ctx context.Context, service *orchestrator.ServiceInstance) error {
+	return nil
+}
+
+func (r *hookRuntime) images() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var images []string
+	for _, image := range r.running {
+		images = append(images, image)
+	}
+	return images
+}
+
+// newDeployHookTestRouter serves the deploy hook endpoints and a started
+// orchestrator sharing the console's database
+func newDeployHookTestRouter(t *testing.T, hooks config.DeployHooksConfig) (*gin.Engine, *database.DB, *hookRuntime) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Console.Database.Path = filepath.Join(dir, "console.db")
+	cfg.Orchestrator.ServiceLogs.Dir = filepath.Join(dir, "logs")
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	runtime := &hookRuntime{running: make(map[string]string)}
+	o := orchestrator.New(db, cfg)
+	o.SetRuntime(runtime)
+	require.NoError(t, o.Start())
+	t.Cleanup(func() { o.Stop() })
+	daemon := gin.New()
+	o.RegisterRoutes(daemon.Group("/api/v1"))
+	server := httptest.NewServer(daemon)
+	t.Cleanup(server.Close)
+
+	h := NewDeployHookHandler(db, []byte("0123456789abcdef0123456789abcdef"), hooks)
+	h.SetOrchestratorClient(client.NewOrchestrator(server.URL, ""))
+	deployments := NewDeploymentHandler(db, 0)
+	r := gin.New()
+	r.POST("/api/v1/services/:id/deploy-hook", h.TriggerDeployHook)
+	r.POST("/api/v1/services/:id/deploy-hook/secret", h.GenerateDeployHookSecret)
+	r.DELETE("/api/v1/services/:id/deploy-hook", h.DeleteDeployHook)
+	r.GET("/api/v1/deployments/:id", deployments.GetDeployment)
+	return r, db, runtime
+}
+
+// createHookedService creates a service with a deploy hook and returns its
+// ID and the hook's secret
+func createHookedService(t *testing.T, r *gin.Engine, db *database.DB) (string, string) {
+	service := &database.Service{Name: "api", Image: "registry.local:5000/team/api:1.0", Port: 18431, Replicas: 1, Status: "stopped"}
+	require.NoError(t, db.ServiceRepository().Create(service))
+
+	w, response := postJSON(t, r, "/api/v1/services/"+service.ID+"/deploy-hook/secret", gin.H{"strategy": "recreate"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	return service.ID, response["secret"].(string)
+}
+
+// callDeployHook calls a service's deploy hook, signing the body with secret
+// at a time and with a nonce
+func callDeployHook(t *testing.T, r *gin.Engine, serviceID, secret string, at time.Time, nonce string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	payload, err := json.Marshal(body)
+	require.NoError(t, err)
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/services/"+serviceID+"/deploy-hook", bytes.NewReader(payload))
+	req.Header.Set(auth.DeployHookTimestampHeader, timestamp)
+	req.Header.Set(auth.DeployHookNonceHeader, nonce)
+	req.Header.Set(auth.DeployHookSignatureHeader, auth.SignDeployHook(secret, timestamp, nonce, payload))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w, response
+}
+
+func TestDeployHookSignature(t *testing.T) {
+	r, db, _ := newDeployHookTestRouter(t, config.DeployHooksConfig{})
+	serviceID, secret := createHookedService(t, r, db)
+	body := gin.H{"image_tag": "1.1"}
+
+	w, _ := postJSON(t, r, "/api/v1/services/"+serviceID+"/deploy-hook/secret", gin.H{"strategy": "blue-green"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = postJSON(t, r, "/api/v1/services/missing/deploy-hook/secret", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Unsigned requests are rejected
+	w, _ = postJSON(t, r, "/api/v1/services/"+serviceID+"/deploy-hook", body)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	tests := []struct {
+		name      string
+		serviceID string
+		secret    string
+		at        time.Time
+	}{
+		{"wrong secret", serviceID, "icd_wrong", time.Now()},
+		{"stale timestamp", serviceID, secret, time.Now().Add(-10 * time.Minute)},
+		{"future timestamp", serviceID, secret, time.Now().Add(10 * time.Minute)},
+		{"service without a hook", "missing", secret, time.Now()},
+	}
+	for _, test := range tests {
+		w, _ := callDeployHook(t, r, test.serviceID, test.secret, test.at, "nonce-"+test.name, body)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, test.name)
+	}
+
+	// A tampered body does not match its signature
+	payload, _ := json.Marshal(body)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/services/"+serviceID+"/deploy-hook", bytes.NewReader([]byte(`{"image_tag":"evil"}`)))
+	req.Header.Set(auth.DeployHookTimestampHeader, timestamp)
+	req.Header.Set(auth.DeployHookNonceHeader, "tampered")
+	req.Header.Set(auth.DeployHookSignatureHeader, auth.SignDeployHook(secret, timestamp, "tampered", payload))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Signed requests must still be valid
+	w, _ = callDeployHook(t, r, serviceID, secret, time.Now(), "bad-tag", gin.H{"image_tag": "../latest"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Rotating the secret invalidates the old one, and deleting the hook
+	// disables it
+	w, _ = postJSON(t, r, "/api/v1/services/"+serviceID+"/deploy-hook/secret", nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	w, _ = callDeployHook(t, r, serviceID, secret, time.Now(), "rotated", body)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = sendJSON(t, r, http.MethodDelete, "/api/v1/services/"+serviceID+"/deploy-hook", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = sendJSON(t, r, http.MethodDelete, "/api/v1/services/"+serviceID+"/deploy-hook", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDeployHookReplayAndRateLimit(t *testing.T) {
+	r, db, _ := newDeployHookTestRouter(t, config.DeployHooksConfig{MaxDeploys: 1, Window: "1h"})
+	serviceID, secret := createHookedService(t, r, db)
+
+	at := time.Now()
+	w, _ := callDeployHook(t, r, serviceID, secret, at, "once", gin.H{"image_tag": "1.1"})
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	// The same signed request cannot be sent again
+	w, _ = callDeployHook(t, r, serviceID, secret, at, "once", gin.H{"image_tag": "1.1"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Deploys beyond the limit are answered with when to retry
+	w, _ = callDeployHook(t, r, serviceID, secret, time.Now(), "twice", gin.H{"image_tag": "1.2"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 3600, retryAfter, 5)
+}
+
+func TestDeployHookDeploys(t *testing.T) {
+	r, db, runtime := newDeployHookTestRouter(t, config.DeployHooksConfig{})
+	serviceID, secret := createHookedService(t, r, db)
+
+	w, response := callDeployHook(t, r, serviceID, secret, time.Now(), "deploy-1", gin.H{
+		"image_tag":    "1.1",
+		"triggered_by": "pipeline #42",
+		"notes":        "Fix login",
+	})
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, "registry.local:5000/team/api:1.1", response["image"])
+	deploymentID := response["deployment_id"].(string)
+	require.NotEmpty(t, deploymentID)
+	assert.Equal(t, "/api/v1/deployments/"+deploymentID, response["status_url"])
+
+	// The service now runs the new image
+	service, err := db.ServiceRepository().GetByID(serviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "registry.local:5000/team/api:1.1", service.Image)
+	assert.Contains(t, service.YAMLConfig, "registry.local:5000/team/api:1.1")
+	revisions, err := db.ServiceRevisionRepository().List(serviceID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, revisions)
+
+	// CI can poll the deployment until it finished
+	var deployment map[string]interface{}
+	require.Eventually(t, func() bool {
+		w, deployment = sendJSON(t, r, http.MethodGet, "/api/v1/deployments/"+deploymentID, nil)
+		return w.Code == http.StatusOK && deployment["status"] == "deployed"
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, serviceID, deployment["service_id"])
+	assert.Equal(t, "recreate", deployment["strategy"])
+	assert.Equal(t, orchestrator.TriggerDeployHook, deployment["trigger_source"])
+	assert.Equal(t, "pipeline #42", deployment["triggered_by"])
+	assert.Equal(t, "Fix login", deployment["notes"])
+	assert.Equal(t, []string{"registry.local:5000/team/api:1.1"}, runtime.images())
+
+	w, _ = sendJSON(t, r, http.MethodGet, "/api/v1/deployments/missing", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDeployHookFailedDeployKeepsImage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.Console.Database.Path = filepath.Join(t.TempDir(), "console.db")
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	orchestratorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"runtime unavailable"}`, http.StatusInternalServerError)
+	}))
+	t.Cleanup(orchestratorServer.Close)
+
+	h := NewDeployHookHandler(db, []byte("0123456789abcdef0123456789abcdef"), config.DeployHooksConfig{})
+	h.SetOrchestratorClient(client.NewOrchestrator(orchestratorServer.URL, ""))
+	r := gin.New()
+	r.POST("/api/v1/services/:id/deploy-hook", h.TriggerDeployHook)
+	r.POST("/api/v1/services/:id/deploy-hook/secret", h.GenerateDeployHookSecret)
+	serviceID, secret := createHookedService(t, r, db)
+
+	w, response := callDeployHook(t, r, serviceID, secret, time.Now(), "deploy-1", gin.H{"image_tag": "1.1"})
+	require.Equal(t, http.StatusBadGateway, w.Code, w.Body.String())
+	assert.Equal(t, "registry.local:5000/team/api:1.1", response["image"])
+
+	// The service still shows the image that runs, without a new revision
+	service, err := db.ServiceRepository().GetByID(serviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "registry.local:5000/team/api:1.0", service.Image)
+	assert.Equal(t, "stopped", service.Status)
+	revisions, err := db.ServiceRevisionRepository().List(serviceID)
+	require.NoError(t, err)
+	assert.Empty(t, revisions)
+}
+
+func TestWithImageTag(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                          "nginx:2",
+		"nginx:1.27":                     "nginx:2",
+		"registry.local:5000/nginx":      "registry.local:5000/nginx:2",
+		"registry.local:5000/nginx:1.27": "registry.local:5000/nginx:2",
+		"nginx@sha256:abc":               "nginx:2",
+		"nginx:1.27@sha256:abc":          "nginx:2",
+	}
+	for image, expected := range tests {
+		assert.Equal(t, expected, withImageTag(image, "2"), image)
+	}
+}
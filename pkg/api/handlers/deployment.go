@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// DefaultIncidentWindow is used when no incident window is configured
+const DefaultIncidentWindow = time.Hour
+
+// maxStatsBuckets bounds the number of buckets a single stats request may span
+const maxStatsBuckets = 1000
+
+// DeploymentHandler handles deployment-related API endpoints
+type DeploymentHandler struct {
+	db             *database.DB
+	incidentWindow time.Duration
+}
+
+// NewDeploymentHandler creates a new DeploymentHandler. Incidents opened on a
+// service within incidentWindow after a deployment are attributed to it.
+func NewDeploymentHandler(db *database.DB, incidentWindow time.Duration) *DeploymentHandler {
+	if incidentWindow <= 0 {
+		incidentWindow = DefaultIncidentWindow
+	}
+	return &DeploymentHandler{db: db, incidentWindow: incidentWindow}
+}
+
+// DeploymentStatsResponse is shaped for rendering as a calendar heatmap
+type DeploymentStatsResponse struct {
+	From                  time.Time              `json:"from"`
+	To                    time.Time              `json:"to"`
+	Bucket                string                 `json:"bucket"`
+	Timezone              string                 `json:"timezone"`
+	IncidentWindowSeconds int64                  `json:"incident_window_seconds"`
+	Buckets               []*DeploymentStatsCell `json:"buckets"`
+	ByWeekday             []*DeploymentStatsCell `json:"by_weekday,omitempty"`
+	Totals                DeploymentStatsCounts  `json:"totals"`
+}
+
+// DeploymentStatsCell is a single heatmap cell
+type DeploymentStatsCell struct {
+	Start   *time.Time `json:"start,omitempty"`
+	End     *time.Time `json:"end,omitempty"`
+	Label   string     `json:"label"`
+	Weekday string     `json:"weekday,omitempty"`
+	Week    string     `json:"week,omitempty"`
+	DeploymentStatsCounts
+	Services []*database.DeploymentBucketStats `json:"services,omitempty"`
+}
+
+// DeploymentStatsCounts holds the aggregated values of a cell
+type DeploymentStatsCounts struct {
+	Deployments        int      `json:"deployments"`
+	Failures           int      `json:"failures"`
+	Rollbacks          int      `json:"rollbacks"`
+	FailureRate        float64  `json:"failure_rate"` // failed or rolled back / total
+	Incidents          int      `json:"incidents"`
+	MeanTimeToIncident *float64 `json:"mean_time_to_incident_seconds"`
+}
+
+// add merges a bucket's aggregates, keeping the mean time to incident weighted by incident count
+func (c *DeploymentStatsCounts) add(stats *database.DeploymentBucketStats) {
+	if stats.MeanTimeToIncident != nil && stats.Incidents > 0 {
+		total := *stats.MeanTimeToIncident * float64(stats.Incidents)
+		if c.MeanTimeToIncident != nil {
+			total += *c.MeanTimeToIncident * float64(c.Incidents)
+		}
+		mean := total / float64(c.Incidents+stats.Incidents)
+		c.MeanTimeToIncident = &mean
+	}
+
+	c.Deployments += stats.Deployments
+	c.Failures += stats.Failures
+	c.Rollbacks += stats.Rollbacks
+	c.Incidents += stats.Incidents
+	if c.Deployments > 0 {
+		c.FailureRate = float64(c.Failures+c.Rollbacks) / float64(c.Deployments)
+	}
+}
+
+// GetDeploymentStats returns deployment outcomes grouped into day or week buckets
+func (h *DeploymentHandler) GetDeploymentStats(c *gin.Context) {
+	bucket := c.DefaultQuery("bucket", "day")
+	if bucket != "day" && bucket != "week" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bucket must be one of: day, week"})
+		return
+	}
+
+	tz := c.DefaultQuery("tz", "UTC")
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid tz: %s", tz)})
+		return
+	}
+
+	to := time.Now().In(loc)
+	if raw := c.Query("to"); raw != "" {
+		if to, err = parseStatsTime(raw, loc, true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to: " + err.Error()})
+			return
+		}
+	}
+
+	from := to.AddDate(0, 0, -30)
+	if bucket == "week" {
+		from = to.AddDate(0, 0, -7*12)
+	}
+	if raw := c.Query("from"); raw != "" {
+		if from, err = parseStatsTime(raw, loc, false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from: " + err.Error()})
+			return
+		}
+	}
+
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	window := h.incidentWindow
+	if raw := c.Query("window"); raw != "" {
+		if window, err = time.ParseDuration(raw); err != nil || window <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window: " + raw})
+			return
+		}
+	}
+
+	buckets := statsBuckets(from, to, bucket, loc)
+	if len(buckets) > maxStatsBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("range spans more than %d buckets", maxStatsBuckets)})
+		return
+	}
+
+	byService := c.Query("breakdown") == "service"
+	rows, err := h.db.WithContext(c.Request.Context()).DeploymentRepository().Stats(buckets, window, byService)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute deployment stats"})
+		return
+	}
+
+	response := &DeploymentStatsResponse{
+		From:                  buckets[0].Start,
+		To:                    buckets[len(buckets)-1].End,
+		Bucket:                bucket,
+		Timezone:              loc.String(),
+		IncidentWindowSeconds: int64(window / time.Second),
+		Buckets:               make([]*DeploymentStatsCell, len(buckets)),
+	}
+
+	for i, b := range buckets {
+		start, end := b.Start, b.End
+		cell := &DeploymentStatsCell{Start: &start, End: &end}
+		year, week := start.ISOWeek()
+		cell.Week = fmt.Sprintf("%d-W%02d", year, week)
+		if bucket == "day" {
+			cell.Label = start.Format("2006-01-02")
+			cell.Weekday = start.Weekday().String()
+		} else {
+			cell.Label = cell.Week
+		}
+		response.Buckets[i] = cell
+	}
+
+	for _, row := range rows {
+		cell := response.Buckets[row.Bucket]
+		cell.add(row)
+		response.Totals.add(row)
+		if byService && row.ServiceID != nil {
+			cell.Services = append(cell.Services, row)
+		}
+	}
+
+	if bucket == "day" {
+		response.ByWeekday = weekdayRollup(response.Buckets)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// parseStatsTime parses an RFC3339 timestamp or a date in the given location.
+// A date used as the end of a range includes that whole day.
+func parseStatsTime(raw string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.In(loc), nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", raw, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC3339 timestamp or YYYY-MM-DD date")
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// statsBuckets splits [from, to) into calendar days or ISO weeks in loc,
+// widening the range to whole buckets. Edges follow local midnight so days
+// across DST changes are 23 or 25 hours long.
+func statsBuckets(from, to time.Time, bucket string, loc *time.Location) []database.TimeBucket {
+	from, to = from.In(loc), to.In(loc)
+
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	step := 1
+	if bucket == "week" {
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		step = 7
+	}
+
+	var buckets []database.TimeBucket
+	for start.Before(to) && len(buckets) <= maxStatsBuckets {
+		end := time.Date(start.Year(), start.Month(), start.Day()+step, 0, 0, 0, 0, loc)
+		buckets = append(buckets, database.TimeBucket{Start: start, End: end})
+		start = end
+	}
+	return buckets
+}
+
+// weekdayRollup sums day buckets by weekday, Monday first
+func weekdayRollup(cells []*DeploymentStatsCell) []*DeploymentStatsCell {
+	rollup := make([]*DeploymentStatsCell, 7)
+	for i := range rollup {
+		rollup[i] = &DeploymentStatsCell{Weekday: time.Weekday((i + 1) % 7).String()}
+		rollup[i].Label = rollup[i].Weekday
+	}
+
+	for _, cell := range cells {
+		day := rollup[(int(cell.Start.Weekday())+6)%7]
+		day.add(&database.DeploymentBucketStats{
+			Deployments:        cell.Deployments,
+			Failures:           cell.Failures,
+			Rollbacks:          cell.Rollbacks,
+			Incidents:          cell.Incidents,
+			MeanTimeToIncident: cell.MeanTimeToIncident,
+		})
+	}
+	return rollup
+}
+
+// GetDeployment returns a deployment record, for following a deployment
+// triggered through a deploy hook until it finishes
+func (h *DeploymentHandler) GetDeployment(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	deployment, err := db.DeploymentRepository().GetByID(c.Param("id"))
+	if err != nil {
+		respondDBError(c, err, "Deployment", "get deployment")
+		return
+	}
+	// Deployments are only visible along with their service
+	if _, err := db.ServiceRepository().GetByID(deployment.ServiceID); err != nil {
+		respondDBError(c, err, "Deployment", "get deployment")
+		return
+	}
+
+	c.JSON(http.StatusOK, deployment)
+}
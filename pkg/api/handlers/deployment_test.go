@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// newDeploymentStatsFixture seeds two services with deployments around the
+// America/New_York midnight and DST edges of March 2025
+func newDeploymentStatsFixture(t *testing.T) *database.DB {
+	t.Helper()
+
+	db, err := database.NewDB(&config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: ":memory:"},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	_, err = db.Exec(`INSERT INTO services (id, name, image) VALUES ('svc-a', 'alpha', 'alpha:1'), ('svc-b', 'beta', 'beta:1')`)
+	require.NoError(t, err)
+
+	utc := func(s string) time.Time {
+		ts, err := time.Parse(time.RFC3339, s)
+		require.NoError(t, err)
+		return ts
+	}
+
+	deployments := []struct {
+		id, service, status, startedAt string
+	}{
+		{"d1", "svc-a", "failed", "2025-03-07T03:30:00Z"},      // Thu 22:30 EST, Fri in UTC
+		{"d2", "svc-a", "success", "2025-03-07T05:00:00Z"},     // Fri 00:00 EST, exactly on the edge
+		{"d3", "svc-a", "rolled_back", "2025-03-08T04:59:59Z"}, // Fri 23:59:59 EST, Sat in UTC
+		{"d4", "svc-b", "success", "2025-03-10T04:30:00Z"},     // Mon 00:30 EDT, after spring forward
+	}
+	for _, d := range deployments {
+		_, err := db.Exec(`INSERT INTO deployments (id, service_id, version, status, started_at) VALUES (?, ?, 1, ?, ?)`,
+			d.id, d.service, d.status, utc(d.startedAt).Format("2006-01-02 15:04:05"))
+		require.NoError(t, err)
+	}
+
+	incidents := []struct {
+		service, openedAt string
+	}{
+		{"svc-a", "2025-03-07T05:20:00Z"}, // 20 minutes after d2
+		{"svc-a", "2025-03-07T05:40:00Z"}, // later incident, ignored for d2
+		{"svc-b", "2025-03-07T05:10:00Z"}, // different service
+		{"svc-a", "2025-03-08T06:59:59Z"}, // 2 hours after d3
+	}
+	repo := db.IncidentRepository()
+	for _, i := range incidents {
+		require.NoError(t, repo.Create(&database.Incident{
+			ServiceID: i.service,
+			Title:     "elevated error rate",
+			OpenedAt:  utc(i.openedAt),
+		}))
+	}
+
+	return db
+}
+
+func getDeploymentStats(t *testing.T, handler *DeploymentHandler, query string) (int, *DeploymentStatsResponse) {
+	t.Helper()
+
+	router := gin.New()
+	router.GET("/deployments/stats", handler.GetDeploymentStats)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/deployments/stats?"+query, nil))
+
+	var response DeploymentStatsResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	}
+	return w.Code, &response
+}
+
+func TestGetDeploymentStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewDeploymentHandler(newDeploymentStatsFixture(t), 0)
+
+	t.Run("day buckets in local time", func(t *testing.T) {
+		code, stats := getDeploymentStats(t, handler, "from=2025-03-06&to=2025-03-10&tz=America/New_York")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, stats.Buckets, 5)
+		assert.Equal(t, "America/New_York", stats.Timezone)
+		assert.Equal(t, int64(3600), stats.IncidentWindowSeconds)
+
+		labels := make([]string, len(stats.Buckets))
+		deployments := make([]int, len(stats.Buckets))
+		for i, bucket := range stats.Buckets {
+			labels[i] = bucket.Label
+			deployments[i] = bucket.Deployments
+		}
+		assert.Equal(t, []string{"2025-03-06", "2025-03-07", "2025-03-08", "2025-03-09", "2025-03-10"}, labels)
+		assert.Equal(t, []int{1, 2, 0, 0, 1}, deployments)
+
+		thursday := stats.Buckets[0]
+		assert.Equal(t, "Thursday", thursday.Weekday)
+		assert.Equal(t, 1, thursday.Failures)
+		assert.Nil(t, thursday.MeanTimeToIncident)
+
+		friday := stats.Buckets[1]
+		assert.Equal(t, "Friday", friday.Weekday)
+		assert.Equal(t, 0, friday.Failures)
+		assert.Equal(t, 1, friday.Rollbacks)
+		assert.Equal(t, 1, friday.Incidents)
+		require.NotNil(t, friday.MeanTimeToIncident)
+		assert.InDelta(t, 1200, *friday.MeanTimeToIncident, 0.01)
+		assert.InDelta(t, 0.5, friday.FailureRate, 0.0001)
+		assert.Equal(t, time.Date(2025, 3, 7, 5, 0, 0, 0, time.UTC), friday.Start.UTC())
+
+		// Spring forward makes the local day 23 hours long
+		sunday := stats.Buckets[3]
+		assert.Equal(t, 23*time.Hour, sunday.End.Sub(*sunday.Start))
+
+		assert.Equal(t, 4, stats.Totals.Deployments)
+		assert.Equal(t, 1, stats.Totals.Failures)
+		assert.Equal(t, 1, stats.Totals.Rollbacks)
+
+		require.Len(t, stats.ByWeekday, 7)
+		assert.Equal(t, "Monday", stats.ByWeekday[0].Weekday)
+		assert.Equal(t, "Friday", stats.ByWeekday[4].Weekday)
+		assert.Equal(t, 2, stats.ByWeekday[4].Deployments)
+		assert.Equal(t, 1, stats.ByWeekday[0].Deployments)
+	})
+
+	t.Run("day buckets in UTC", func(t *testing.T) {
+		code, stats := getDeploymentStats(t, handler, "from=2025-03-06&to=2025-03-10")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, stats.Buckets, 5)
+
+		deployments := make([]int, len(stats.Buckets))
+		for i, bucket := range stats.Buckets {
+			deployments[i] = bucket.Deployments
+		}
+		assert.Equal(t, []int{0, 2, 1, 0, 1}, deployments)
+		assert.Equal(t, 1, stats.Buckets[1].Failures)
+		assert.Equal(t, 1, stats.Buckets[2].Rollbacks)
+	})
+
+	t.Run("week buckets", func(t *testing.T) {
+		code, stats := getDeploymentStats(t, handler, "from=2025-03-06&to=2025-03-10&tz=America/New_York&bucket=week")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, stats.Buckets, 2)
+		assert.Equal(t, "2025-W10", stats.Buckets[0].Label)
+		assert.Equal(t, "Monday", stats.Buckets[0].Start.Weekday().String())
+		assert.Equal(t, 3, stats.Buckets[0].Deployments)
+		assert.Equal(t, 1, stats.Buckets[1].Deployments)
+		assert.Empty(t, stats.ByWeekday)
+	})
+
+	t.Run("window override", func(t *testing.T) {
+		code, stats := getDeploymentStats(t, handler, "from=2025-03-07&to=2025-03-07&tz=America/New_York&window=3h")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, stats.Buckets, 1)
+		assert.Equal(t, 2, stats.Buckets[0].Incidents)
+		require.NotNil(t, stats.Buckets[0].MeanTimeToIncident)
+		assert.InDelta(t, (1200.0+7200.0)/2, *stats.Buckets[0].MeanTimeToIncident, 0.01)
+	})
+
+	t.Run("service breakdown", func(t *testing.T) {
+		code, stats := getDeploymentStats(t, handler, "from=2025-03-06&to=2025-03-10&tz=America/New_York&breakdown=service")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, stats.Buckets[1].Services, 1)
+		assert.Equal(t, "alpha", *stats.Buckets[1].Services[0].ServiceName)
+		assert.Equal(t, 2, stats.Buckets[1].Services[0].Deployments)
+		require.Len(t, stats.Buckets[4].Services, 1)
+		assert.Equal(t, "beta", *stats.Buckets[4].Services[0].ServiceName)
+		assert.Empty(t, stats.Buckets[2].Services)
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, query := range []string{
+			"bucket=month",
+			"tz=Mars/Olympus",
+			"from=yesterday",
+			"from=2025-03-10&to=2025-03-01",
+			"window=-1h",
+			"from=2000-01-01&to=2025-01-01",
+		} {
+			code, _ := getDeploymentStats(t, handler, query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+		}
+	})
+}
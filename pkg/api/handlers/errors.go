@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+)
+
+// respondDBError responds to a failed repository call on a resource: 404
+// "<resource> not found" when the row does not exist, and otherwise 500
+// "Failed to <action>", logging the error. The request ID middleware adds
+// the request ID to both bodies.
+func respondDBError(c *gin.Context, err error, resource, action string) {
+	if database.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": resource + " not found"})
+		return
+	}
+	logging.FromContext(c.Request.Context()).Error("failed to "+action, "error", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action})
+}
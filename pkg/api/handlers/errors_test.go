@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestNotFoundStatuses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	userHandler := NewUserHandler(authService, db)
+	serviceHandler := NewServiceHandler(db, nil)
+	ssoHandler := NewSSOHandler(authService, db)
+	r := gin.New()
+	r.Use(middleware.RequestIDMiddleware())
+	r.Use(func(c *gin.Context) {
+		c.Set("user_id", 1)
+		c.Set("role", "admin")
+	})
+	r.PUT("/users/:id", userHandler.UpdateUser)
+	r.DELETE("/users/:id", userHandler.DeleteUser)
+	r.GET("/services/:id", serviceHandler.GetService)
+	r.POST("/services/:id/start", serviceHandler.StartService)
+	r.DELETE("/services/:id", serviceHandler.DeleteService)
+	r.GET("/sso/services/:id", ssoHandler.GetService)
+	r.DELETE("/sso/services/:id", ssoHandler.DeleteService)
+
+	requests := []struct {
+		method, path, resource string
+	}{
+		{http.MethodPut, "/users/42", "User"},
+		{http.MethodDelete, "/users/42", "User"},
+		{http.MethodGet, "/services/missing", "Service"},
+		{http.MethodPost, "/services/missing/start", "Service"},
+		{http.MethodDelete, "/services/missing", "Service"},
+		{http.MethodGet, "/sso/services/missing", "Service"},
+		{http.MethodDelete, "/sso/services/missing", "Service"},
+	}
+
+	// Missing resources are answered 404 with the same body
+	for _, req := range requests {
+		w, response := sendJSON(t, r, req.method, req.path, gin.H{"email": "new@example.com"})
+		assert.Equal(t, http.StatusNotFound, w.Code, req.method+" "+req.path)
+		assert.Equal(t, req.resource+" not found", response["error"], req.method+" "+req.path)
+		assert.NotEmpty(t, response["request_id"], req.method+" "+req.path)
+	}
+
+	// Database failures are answered 500 with the request ID to report
+	db.Close()
+	for _, req := range requests {
+		w, response := sendJSON(t, r, req.method, req.path, gin.H{"email": "new@example.com"})
+		assert.Equal(t, http.StatusInternalServerError, w.Code, req.method+" "+req.path)
+		assert.Contains(t, response["error"], "Failed to", req.method+" "+req.path)
+		assert.Equal(t, w.Header().Get("X-Request-ID"), response["request_id"], req.method+" "+req.path)
+	}
+}
@@ -31,7 +31,7 @@ func TestNewServiceHandler(t *testing.T) {
 	// Create mock db
 	mockDB := &database.DB{}
 	
-	handler := NewServiceHandler(mockDB)
+	handler := NewServiceHandler(mockDB, nil)
 	
 	assert.NotNil(t, handler)
 	assert.Equal(t, mockDB, handler.db)
@@ -71,7 +71,6 @@ func TestRegisterRequestValidation(t *testing.T) {
 				Username: "testuser",
 				Email:    "test@example.com",
 				Password: "password123",
-				Role:     "user",
 			},
 			isValid: true,
 		},
@@ -81,7 +80,6 @@ func TestRegisterRequestValidation(t *testing.T) {
 				Username: "",
 				Email:    "test@example.com",
 				Password: "password123",
-				Role:     "user",
 			},
 			isValid: false,
 		},
@@ -91,7 +89,6 @@ func TestRegisterRequestValidation(t *testing.T) {
 				Username: "testuser",
 				Email:    "",
 				Password: "password123",
-				Role:     "user",
 			},
 			isValid: false,
 		},
@@ -101,7 +98,6 @@ func TestRegisterRequestValidation(t *testing.T) {
 				Username: "testuser",
 				Email:    "test@example.com",
 				Password: "",
-				Role:     "user",
 			},
 			isValid: false,
 		},
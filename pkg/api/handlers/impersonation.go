@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// ImpersonateUser issues a short-lived token with which the admin acts as
+// another user, to see the portal as they do (admin only). Requests made
+// with it are audited with both identities. Impersonation tokens cannot
+// impersonate in turn, and other admins can only be impersonated when
+// console.auth.impersonation.allow_admins is set.
+func (h *UserHandler) ImpersonateUser(c *gin.Context) {
+	value, exists := c.Get("claims")
+	claims, ok := value.(*auth.Claims)
+	if !exists || !ok {
+		// API keys carry no session to bind the token to
+		c.JSON(http.StatusForbidden, gin.H{"error": "Impersonation requires a signed in admin"})
+		return
+	}
+	if claims.Impersonating() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Impersonation tokens cannot impersonate"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if userID == claims.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot impersonate yourself"})
+		return
+	}
+
+	user, err := h.db.WithContext(c.Request.Context()).UserRepository().GetByID(userID)
+	if err != nil {
+		respondDBError(c, err, "User", "get user")
+		return
+	}
+	if user.Role == "admin" && !h.auth.AdminImpersonationAllowed() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Impersonating admins is disabled"})
+		return
+	}
+
+	// The token lists the services the user was granted, as their login does
+	userServices, err := h.db.WithContext(c.Request.Context()).UserServicePermissionRepository().ListUserServices(user.ID)
+	if err != nil {
+		userServices = []*database.UserService{}
+	}
+	services := make([]string, len(userServices))
+	for i, service := range userServices {
+		services[i] = service.Name
+	}
+
+	token, expiresAt, err := h.auth.GenerateImpersonationToken(claims, user.ID, user.Username, user.Role, services)
+	if err != nil {
+		if errors.Is(err, auth.ErrNestedImpersonation) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Impersonation tokens cannot impersonate"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	recordAudit(c, auditActionImpersonate, auditResourceUser, strconv.Itoa(user.ID), gin.H{
+		"username":   user.Username,
+		"role":       user.Role,
+		"expires_at": time.Unix(expiresAt, 0).UTC(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":           token,
+		"user_id":         user.ID,
+		"username":        user.Username,
+		"role":            user.Role,
+		"expires_at":      expiresAt,
+		"impersonator_id": claims.UserID,
+		"impersonator":    claims.Username,
+	})
+}
+
+// rejectImpersonation answers 403 to requests made with an impersonation
+// token, for changes to the user's own credentials that an admin acting as
+// them could use to lock them out. It reports whether the request was
+// rejected.
+func rejectImpersonation(c *gin.Context, action string) bool {
+	if _, impersonating := c.Get("impersonator_id"); impersonating {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Impersonation tokens cannot " + action})
+		return true
+	}
+	return false
+}
+
+// impersonationState describes who is impersonating the user of a request,
+// for the UI to show while an admin acts as someone else
+func impersonationState(c *gin.Context) gin.H {
+	impersonatorID, impersonating := c.Get("impersonator_id")
+	if !impersonating {
+		return gin.H{"active": false}
+	}
+
+	state := gin.H{
+		"active":          true,
+		"impersonator_id": impersonatorID,
+		"impersonator":    c.GetString("impersonator"),
+	}
+	if value, exists := c.Get("claims"); exists {
+		if claims, ok := value.(*auth.Claims); ok && claims.ExpiresAt != nil {
+			state["expires_at"] = claims.ExpiresAt.Unix()
+		}
+	}
+	return state
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/services"
+)
+
+// impersonationTest serves the user endpoints behind the authentication and
+// audit middleware, as the console does
+type impersonationTest struct {
+	t      *testing.T
+	r      *gin.Engine
+	db     *database.DB
+	logger *services.AuditLogger
+	users  map[string]*database.User
+}
+
+func newImpersonationTest(t *testing.T, allowAdmins bool) *impersonationTest {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth: config.AuthConfig{
+				JWT:           config.JWTConfig{Secret: "test-secret", ExpiresHours: 24},
+				Impersonation: config.ImpersonationConfig{TokenTTL: "10m", AllowAdmins: allowAdmins},
+			},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	hash, err := authService.HashPassword("password123")
+	require.NoError(t, err)
+	users := make(map[string]*database.User)
+	for name, role := range map[string]string{"admin": "admin", "root": "admin", "alice": "user"} {
+		user := &database.User{Username: name, Email: name + "@example.com", PasswordHash: hash, Role: role}
+		require.NoError(t, db.UserRepository().Create(user))
+		users[name] = user
+	}
+
+	logger := services.NewAuditLogger(db, 0)
+	logger.Start()
+	t.Cleanup(logger.Stop)
+
+	handler := NewUserHandler(authService, db)
+	r := gin.New()
+	r.Use(middleware.AuditMiddleware(logger))
+	r.POST("/api/v1/auth/login", handler.Login)
+	group := r.Group("/api/v1/users", middleware.AuthMiddleware(authService, db))
+	group.GET("/profile", handler.GetProfile)
+	group.PUT("/profile", handler.UpdateProfile)
+	group.POST("/profile/password", handler.ChangePassword)
+	group.DELETE("/profile/sessions/:id", handler.RevokeSession)
+	group.POST("/2fa/setup", handler.SetupTOTP)
+	group.POST("/2fa/confirm", handler.ConfirmTOTP)
+	group.POST("/2fa/disable", handler.DisableTOTP)
+	group.POST("/api-keys", handler.CreateAPIKey)
+	admin := group.Group("/", middleware.RequireRole(authService, "admin"))
+	admin.POST("/:id/impersonate", handler.ImpersonateUser)
+
+	return &impersonationTest{t: t, r: r, db: db, logger: logger, users: users}
+}
+
+// do sends a request with a token and, if not nil, a JSON body
+func (it *impersonationTest) do(method, path, token string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		require.NoError(it.t, err)
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	it.r.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	require.NoError(it.t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w, response
+}
+
+// login signs a user in, returning the token
+func (it *impersonationTest) login(username string) string {
+	w, response := it.do(http.MethodPost, "/api/v1/auth/login", "", gin.H{"username": username, "password": "password123"})
+	require.Equal(it.t, http.StatusOK, w.Code, w.Body.String())
+	return response["token"].(string)
+}
+
+// impersonate impersonates a user with a token, returning the response
+func (it *impersonationTest) impersonate(token, username string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return it.do(http.MethodPost, fmt.Sprintf("/api/v1/users/%d/impersonate", it.users[username].ID), token, nil)
+}
+
+func TestImpersonateUser(t *testing.T) {
+	it := newImpersonationTest(t, false)
+	adminToken := it.login("admin")
+	admin, alice := it.users["admin"], it.users["alice"]
+
+	w, response := it.impersonate(adminToken, "alice")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "alice", response["username"])
+	assert.Equal(t, float64(admin.ID), response["impersonator_id"])
+	token := response["token"].(string)
+
+	// Requests made with the token act as the user, flagged as impersonated
+	w, profile := it.do(http.MethodGet, "/api/v1/users/profile", token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get(middleware.ImpersonatingHeader))
+	assert.Equal(t, "alice", profile["username"])
+	impersonation := profile["impersonation"].(map[string]interface{})
+	assert.Equal(t, true, impersonation["active"])
+	assert.Equal(t, float64(admin.ID), impersonation["impersonator_id"])
+	assert.Equal(t, "admin", impersonation["impersonator"])
+	assert.Equal(t, response["expires_at"], impersonation["expires_at"])
+
+	w, profile = it.do(http.MethodGet, "/api/v1/users/profile", adminToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(middleware.ImpersonatingHeader))
+	assert.Equal(t, map[string]interface{}{"active": false}, profile["impersonation"])
+
+	// Impersonation tokens cannot impersonate, even when the user is an admin,
+	// nor mint API keys that would outlive them
+	w, _ = it.impersonate(token, "root")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = it.do(http.MethodPost, "/api/v1/users/api-keys", token, gin.H{"name": "ci"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Mutations are audited with both identities
+	w, _ = it.do(http.MethodPut, "/api/v1/users/profile", token, gin.H{"display_name": "Alice"})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	it.logger.Stop()
+	entries, err := it.db.AuditLogRepository().List(database.AuditLogFilter{})
+	require.NoError(t, err)
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.ResourceType+"."+entry.Action)
+	}
+	// Refused attempts are audited by the middleware, handled ones by their handler
+	assert.Equal(t, []string{
+		"user.update",
+		"request.impersonated_request",
+		"request.impersonated_request",
+		"user.impersonate",
+	}, actions)
+	assert.Equal(t, "/api/v1/users/api-keys", *entries[1].ResourceID)
+	assert.Contains(t, *entries[1].Details, `"status":403`)
+	assert.Equal(t, "/api/v1/users/:id/impersonate", *entries[2].ResourceID)
+	for _, entry := range entries[:3] {
+		require.NotNil(t, entry.ImpersonatorID)
+		assert.Equal(t, admin.ID, *entry.ImpersonatorID)
+	}
+
+	update := entries[0]
+	assert.Equal(t, auditActionUpdate, update.Action)
+	require.NotNil(t, update.UserID)
+	assert.Equal(t, alice.ID, *update.UserID)
+	require.NotNil(t, update.ImpersonatorID)
+	assert.Equal(t, admin.ID, *update.ImpersonatorID)
+	assert.Contains(t, *update.Details, `"display_name"`)
+
+	impersonate := entries[3]
+	assert.Equal(t, auditActionImpersonate, impersonate.Action)
+	assert.Equal(t, admin.ID, *impersonate.UserID)
+	assert.Nil(t, impersonate.ImpersonatorID)
+	assert.Equal(t, fmt.Sprint(alice.ID), *impersonate.ResourceID)
+}
+
+func TestImpersonateUserRestrictions(t *testing.T) {
+	it := newImpersonationTest(t, false)
+	adminToken := it.login("admin")
+
+	w, _ := it.impersonate(adminToken, "root")
+	assert.Equal(t, http.StatusForbidden, w.Code, "admins cannot be impersonated by default")
+	w, _ = it.impersonate(adminToken, "admin")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = it.do(http.MethodPost, "/api/v1/users/999/impersonate", adminToken, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = it.impersonate(it.login("alice"), "root")
+	assert.Equal(t, http.StatusForbidden, w.Code, "only admins impersonate")
+
+	// Configured to, admins can impersonate admins, but not further
+	it = newImpersonationTest(t, true)
+	adminToken = it.login("admin")
+	w, response := it.impersonate(adminToken, "root")
+	require.Equal(t, http.StatusOK, w.Code)
+	w, _ = it.impersonate(response["token"].(string), "alice")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Impersonation tokens cannot impersonate")
+}
+
+func TestImpersonationCannotChangeCredentials(t *testing.T) {
+	it := newImpersonationTest(t, false)
+	it.login("alice")
+	_, response := it.impersonate(it.login("admin"), "alice")
+	token := response["token"].(string)
+	alice := it.users["alice"]
+
+	sessions, err := it.db.SSOSessionRepository().ListActiveByUser(alice.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, sessions)
+
+	// An admin acting as a user cannot take over or lock out their account
+	requests := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPost, "/api/v1/users/2fa/setup", nil},
+		{http.MethodPost, "/api/v1/users/2fa/confirm", gin.H{"code": "123456"}},
+		{http.MethodPost, "/api/v1/users/2fa/disable", gin.H{"code": "123456"}},
+		{http.MethodPost, "/api/v1/users/profile/password", gin.H{"current_password": "password123", "new_password": "N3w-passw0rd!"}},
+		{http.MethodDelete, "/api/v1/users/profile/sessions/" + sessions[0].ID, nil},
+	}
+	for _, req := range requests {
+		w, _ := it.do(req.method, req.path, token, req.body)
+		assert.Equal(t, http.StatusForbidden, w.Code, req.path)
+		assert.Contains(t, w.Body.String(), "Impersonation tokens cannot", req.path)
+	}
+
+	user, err := it.db.UserRepository().GetByID(alice.ID)
+	require.NoError(t, err)
+	assert.Nil(t, user.TOTPSecret)
+	assert.Equal(t, alice.PasswordHash, user.PasswordHash)
+	session, err := it.db.SSOSessionRepository().GetByID(sessions[0].ID)
+	require.NoError(t, err)
+	assert.True(t, session.IsActive)
+
+	// The user can still manage their own credentials
+	w, _ := it.do(http.MethodPost, "/api/v1/users/2fa/setup", it.login("alice"), nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
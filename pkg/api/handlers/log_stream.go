@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+)
+
+// Log stream limits
+const (
+	logStreamBufferSize   = 256
+	logStreamMaxTail      = 10000
+	logStreamPollInterval = 250 * time.Millisecond
+	logStreamCheckPeriod  = time.Second
+	logStreamWriteWait    = 10 * time.Second
+	logStreamPongWait     = 60 * time.Second
+	logStreamPingPeriod   = logStreamPongWait * 9 / 10
+)
+
+// logStreamUpgrader upgrades log stream requests. The default origin check
+// is kept because the auth middleware also accepts the auth_token cookie,
+// which a cross-site page would otherwise be able to ride.
+var logStreamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// LogStreamMessage is a log line pushed to a log stream client
+type LogStreamMessage struct {
+	Timestamp time.Time `json:"timestamp"`
+	Line      string    `json:"line"`
+	Stream    string    `json:"stream"`
+}
+
+// LogStreamNotice tells a log stream client that lines were dropped because
+// it did not keep up
+type LogStreamNotice struct {
+	Notice  string `json:"notice"`
+	Dropped int    `json:"dropped"`
+}
+
+// logStreamQueue is the bounded send buffer of a log stream. Lines pushed
+// while it is full are dropped, and a notice with the number dropped is
+// queued in their place once there is room again.
+type logStreamQueue struct {
+	items   chan interface{}
+	dropped int
+}
+
+// newLogStreamQueue creates a queue holding up to size messages
+func newLogStreamQueue(size int) *logStreamQueue {
+	return &logStreamQueue{items: make(chan interface{}, size)}
+}
+
+// push queues a line without blocking. It must only be called from one
+// goroutine.
+func (q *logStreamQueue) push(message LogStreamMessage) {
+	if q.dropped > 0 {
+		notice := LogStreamNotice{Notice: "client too slow, log lines dropped", Dropped: q.dropped}
+		select {
+		case q.items <- notice:
+			q.dropped = 0
+		default:
+			q.dropped++
+			return
+		}
+	}
+
+	select {
+	case q.items <- message:
+	default:
+		q.dropped++
+	}
+}
+
+// StreamServiceLogs upgrades to a WebSocket and pushes a service's new log
+// lines as they are appended. tail=N first sends the last N lines. The stream
+// is closed when the client disconnects or the service is deleted.
+func (h *ServiceHandler) StreamServiceLogs(c *gin.Context) {
+	serviceID := c.Param("id")
+
+	if _, err := h.db.WithContext(c.Request.Context()).ServiceRepository().GetByID(serviceID); err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
+
+	tail := 0
+	if value := c.Query("tail"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > logStreamMaxTail {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tail, expected 0 to " + strconv.Itoa(logStreamMaxTail)})
+			return
+		}
+		tail = parsed
+	}
+
+	// Reading a single line is enough to find the end of the logs
+	lines, position, err := h.logs.Read(serviceID, nil, max(tail, 1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read service logs"})
+		return
+	}
+	if tail == 0 {
+		lines = nil
+	}
+
+	conn, err := logStreamUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already replied
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+	queue := newLogStreamQueue(logStreamBufferSize)
+	closing := make(chan []byte, 1)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	defer conn.Close()
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		h.readLogStream(conn)
+	}()
+	go func() {
+		defer wg.Done()
+		h.pollLogStream(ctx, serviceID, lines, position, queue, closing)
+	}()
+
+	ping := time.NewTicker(logStreamPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message := <-closing:
+			// Send what was queued before the stream ended
+		drain:
+			for {
+				select {
+				case item := <-queue.items:
+					if writeLogStream(conn, item) != nil {
+						return
+					}
+				default:
+					break drain
+				}
+			}
+			_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(logStreamWriteWait))
+			return
+		case item := <-queue.items:
+			if writeLogStream(conn, item) != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(logStreamWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readLogStream reads from the client until it disconnects, so that control
+// frames are handled. It fails if no pong arrives within the pong wait.
+func (h *ServiceHandler) readLogStream(conn *websocket.Conn) {
+	conn.SetReadLimit(512)
+	_ = conn.SetReadDeadline(time.Now().Add(logStreamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(logStreamPongWait))
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// pollLogStream queues the initial lines and then the lines appended after
+// position until the stream is cancelled. If the service is deleted or its
+// logs cannot be read, it sends the close message to end the stream with.
+func (h *ServiceHandler) pollLogStream(ctx context.Context, serviceID string, lines []orchestrator.LogLine,
+	position orchestrator.LogPosition, queue *logStreamQueue, closing chan<- []byte) {
+	poll := time.NewTicker(logStreamPollInterval)
+	defer poll.Stop()
+	check := time.NewTicker(logStreamCheckPeriod)
+	defer check.Stop()
+
+	var err error
+	for {
+		for _, line := range lines {
+			queue.push(LogStreamMessage{Timestamp: line.Timestamp, Line: line.Message, Stream: line.Stream})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-check.C:
+			if _, err := h.db.WithContext(ctx).ServiceRepository().GetByID(serviceID); database.IsNotFound(err) {
+				closing <- websocket.FormatCloseMessage(websocket.CloseGoingAway, "service deleted")
+				return
+			}
+			lines = nil
+			continue
+		case <-poll.C:
+		}
+
+		lines, position, err = h.logs.ReadFrom(serviceID, position)
+		if err != nil {
+			logging.FromContext(ctx).Error("failed to stream service logs", "service_id", serviceID, "error", err)
+			closing <- websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "failed to read service logs")
+			return
+		}
+	}
+}
+
+// writeLogStream writes a queued message as JSON
+func writeLogStream(conn *websocket.Conn, item interface{}) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(logStreamWriteWait))
+	return conn.WriteJSON(item)
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+)
+
+// setupLogStreamTest starts a server streaming the logs of service "web"
+func setupLogStreamTest(t *testing.T) (*database.DB, *orchestrator.LogCollector, *httptest.Server) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{Database: config.DatabaseConfig{Path: filepath.Join(dir, "console.db")}},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	require.NoError(t, db.ServiceRepository().Create(&database.Service{ID: "web", Name: "web", Image: "nginx", Port: 80, Status: "running"}))
+
+	logDir := filepath.Join(dir, "logs")
+	collector := orchestrator.NewLogCollector(db, orchestrator.LogCollectorOptions{Dir: logDir, MaxFileSize: 1 << 20, MaxFiles: 2})
+	t.Cleanup(collector.Stop)
+
+	r := gin.New()
+	r.GET("/api/v1/services/:id/logs/stream", NewServiceHandler(db, orchestrator.NewLogReader(db, logDir)).StreamServiceLogs)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return db, collector, server
+}
+
+// dialLogStream opens a log stream of the test server
+func dialLogStream(t *testing.T, server *httptest.Server, path string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + path
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestStreamServiceLogs(t *testing.T) {
+	_, collector, server := setupLogStreamTest(t)
+
+	base := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			require.NoError(t, collector.WriteLine("web", orchestrator.StreamStdout, fmt.Sprintf("line %02d", i), base.Add(time.Duration(i)*time.Second)))
+		}
+	}
+	write(0, 3)
+
+	conn := dialLogStream(t, server, "/api/v1/services/web/logs/stream?tail=2")
+	write(3, 20)
+
+	for i := 1; i < 20; i++ {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		var message LogStreamMessage
+		require.NoError(t, conn.ReadJSON(&message))
+		assert.Equal(t, fmt.Sprintf("line %02d", i), message.Line)
+		assert.Equal(t, orchestrator.StreamStdout, message.Stream)
+		assert.True(t, base.Add(time.Duration(i)*time.Second).Equal(message.Timestamp))
+	}
+}
+
+func TestStreamServiceLogsClosesOnServiceDelete(t *testing.T) {
+	db, _, server := setupLogStreamTest(t)
+
+	conn := dialLogStream(t, server, "/api/v1/services/web/logs/stream")
+	require.NoError(t, db.ServiceRepository().Delete("web"))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "unexpected error: %v", err)
+}
+
+func TestStreamServiceLogsRejectsRequests(t *testing.T) {
+	_, _, server := setupLogStreamTest(t)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	_, resp, err := websocket.DefaultDialer.Dial(url+"/api/v1/services/unknown/logs/stream", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	_, resp, err = websocket.DefaultDialer.Dial(url+"/api/v1/services/web/logs/stream?tail=-1", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestLogStreamQueueDropsWithNotice(t *testing.T) {
+	queue := newLogStreamQueue(3)
+	for i := 0; i < 5; i++ {
+		queue.push(LogStreamMessage{Line: fmt.Sprintf("line %d", i)})
+	}
+	assert.Equal(t, 2, queue.dropped)
+
+	// The notice takes the first free slot, ahead of the next line
+	<-queue.items
+	<-queue.items
+	queue.push(LogStreamMessage{Line: "line 5"})
+
+	assert.Equal(t, LogStreamMessage{Line: "line 2"}, <-queue.items)
+	assert.Equal(t, LogStreamNotice{Notice: "client too slow, log lines dropped", Dropped: 2}, <-queue.items)
+	assert.Equal(t, LogStreamMessage{Line: "line 5"}, <-queue.items)
+	assert.Equal(t, 0, queue.dropped)
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// MaintenanceWindowRequest schedules maintenance of a registered service
+type MaintenanceWindowRequest struct {
+	StartsAt *time.Time `json:"starts_at"` // defaults to now for new windows
+	EndsAt   time.Time  `json:"ends_at" binding:"required"`
+	Message  string     `json:"message"`
+}
+
+// MaintenanceStatus tells portal users whether a service is under maintenance
+type MaintenanceStatus struct {
+	Active  bool       `json:"active"`
+	Message string     `json:"message,omitempty"`
+	EndsAt  *time.Time `json:"ends_at,omitempty"`
+}
+
+// maintenanceStatus describes the active window of a service, if any
+func maintenanceStatus(window *database.MaintenanceWindow) MaintenanceStatus {
+	if window == nil {
+		return MaintenanceStatus{}
+	}
+	return MaintenanceStatus{Active: true, Message: window.Message, EndsAt: &window.EndsAt}
+}
+
+// activeMaintenance returns the active maintenance window of a service, or nil
+func (h *SSOHandler) activeMaintenance(serviceID string) *database.MaintenanceWindow {
+	window, err := h.db.MaintenanceWindowRepository().GetActive(serviceID, time.Now())
+	if err != nil {
+		return nil
+	}
+	return window
+}
+
+// ListMaintenanceWindows lists the maintenance windows of a service
+func (h *SSOHandler) ListMaintenanceWindows(c *gin.Context) {
+	serviceID := c.Param("id")
+	if _, err := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository().GetByID(serviceID); err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
+
+	windows, err := h.db.WithContext(c.Request.Context()).MaintenanceWindowRepository().ListByService(serviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list maintenance windows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"service_id": serviceID,
+		"windows":    windows,
+		"count":      len(windows),
+	})
+}
+
+// CreateMaintenanceWindow schedules maintenance of a service. Windows of a
+// service may not overlap.
+func (h *SSOHandler) CreateMaintenanceWindow(c *gin.Context) {
+	serviceID := c.Param("id")
+
+	var req MaintenanceWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository().GetByID(serviceID); err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
+
+	window := &database.MaintenanceWindow{ServiceID: serviceID}
+	if !applyMaintenanceRequest(c, window, req) {
+		return
+	}
+	if userID, ok := c.Get("user_id"); ok {
+		createdBy := userID.(int)
+		window.CreatedBy = &createdBy
+	}
+
+	if err := h.db.WithContext(c.Request.Context()).MaintenanceWindowRepository().Create(window); err != nil {
+		respondMaintenanceError(c, err, "Failed to create maintenance window")
+		return
+	}
+	recordAudit(c, auditActionCreate, auditResourceMaintenance, strconv.Itoa(window.ID), gin.H{
+		"service_id": serviceID,
+		"starts_at":  window.StartsAt,
+		"ends_at":    window.EndsAt,
+	})
+
+	c.JSON(http.StatusCreated, window)
+}
+
+// UpdateMaintenanceWindow reschedules a maintenance window or changes its message
+func (h *SSOHandler) UpdateMaintenanceWindow(c *gin.Context) {
+	window, ok := h.maintenanceWindow(c)
+	if !ok {
+		return
+	}
+
+	var req MaintenanceWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before := auditSnapshot(window)
+	if !applyMaintenanceRequest(c, window, req) {
+		return
+	}
+
+	if err := h.db.WithContext(c.Request.Context()).MaintenanceWindowRepository().Update(window); err != nil {
+		respondMaintenanceError(c, err, "Failed to update maintenance window")
+		return
+	}
+	recordAudit(c, auditActionUpdate, auditResourceMaintenance, strconv.Itoa(window.ID), auditChanges(before, window))
+
+	c.JSON(http.StatusOK, window)
+}
+
+// DeleteMaintenanceWindow cancels a maintenance window, ending it early if it's active
+func (h *SSOHandler) DeleteMaintenanceWindow(c *gin.Context) {
+	window, ok := h.maintenanceWindow(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.WithContext(c.Request.Context()).MaintenanceWindowRepository().Delete(window.ID); err != nil {
+		respondMaintenanceError(c, err, "Failed to delete maintenance window")
+		return
+	}
+	recordAudit(c, auditActionDelete, auditResourceMaintenance, strconv.Itoa(window.ID), gin.H{"service_id": window.ServiceID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Maintenance window deleted successfully"})
+}
+
+// maintenanceWindow loads the window named in the path, responding with 404
+// unless it belongs to the service in the path
+func (h *SSOHandler) maintenanceWindow(c *gin.Context) (*database.MaintenanceWindow, bool) {
+	windowID, err := strconv.Atoi(c.Param("window_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid maintenance window ID"})
+		return nil, false
+	}
+
+	window, err := h.db.WithContext(c.Request.Context()).MaintenanceWindowRepository().GetByID(windowID)
+	if err != nil || window.ServiceID != c.Param("id") {
+		if err == nil || errors.Is(err, database.ErrMaintenanceWindowNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance window not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get maintenance window"})
+		}
+		return nil, false
+	}
+	return window, true
+}
+
+// applyMaintenanceRequest sets the period and message of a window, responding
+// with 400 unless the window ends after it starts. Without a start time new
+// windows start now and existing ones keep their start.
+func applyMaintenanceRequest(c *gin.Context, window *database.MaintenanceWindow, req MaintenanceWindowRequest) bool {
+	startsAt := window.StartsAt
+	if startsAt.IsZero() {
+		startsAt = time.Now()
+	}
+	if req.StartsAt != nil {
+		startsAt = *req.StartsAt
+	}
+	if !req.EndsAt.After(startsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Maintenance window must end after it starts"})
+		return false
+	}
+
+	window.StartsAt = startsAt
+	window.EndsAt = req.EndsAt
+	window.Message = req.Message
+	return true
+}
+
+func respondMaintenanceError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, database.ErrMaintenanceWindowOverlap):
+		c.JSON(http.StatusConflict, gin.H{"error": "Maintenance window overlaps another window of the service"})
+	case errors.Is(err, database.ErrMaintenanceWindowNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance window not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
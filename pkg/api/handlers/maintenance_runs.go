@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// defaultMaintenanceRuns is how many maintenance runs are listed by default
+const defaultMaintenanceRuns = 30
+
+// maintenanceRunResponse returns a maintenance run with its deleted rows
+// decoded
+func maintenanceRunResponse(run *database.MaintenanceRun) gin.H {
+	deleted, err := run.DeletedRows()
+	if err != nil {
+		deleted = map[string]int64{}
+	}
+	var total int64
+	for _, rows := range deleted {
+		total += rows
+	}
+	return gin.H{
+		"id":            run.ID,
+		"started_at":    run.StartedAt,
+		"duration_ms":   run.DurationMS,
+		"deleted":       deleted,
+		"total_deleted": total,
+		"error":         run.Error,
+	}
+}
+
+// ListMaintenanceRuns lists the latest runs of the retention cleanup, newest
+// first, with the rows each deleted per table
+func (h *SystemHandler) ListMaintenanceRuns(c *gin.Context) {
+	limit := defaultMaintenanceRuns
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxPageLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit, expected 1 to " + strconv.Itoa(maxPageLimit)})
+			return
+		}
+		limit = parsed
+	}
+
+	runs, err := h.db.WithContext(c.Request.Context()).MaintenanceRunRepository().List(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list maintenance runs"})
+		return
+	}
+
+	response := make([]gin.H, 0, len(runs))
+	for _, run := range runs {
+		response = append(response, maintenanceRunResponse(run))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"runs":  response,
+		"count": len(response),
+	})
+}
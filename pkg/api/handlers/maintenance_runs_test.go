@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestListMaintenanceRuns(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := database.NewDB(&config.Config{Console: config.ConsoleConfig{Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db"), Timeout: "30s"}}})
+	require.NoError(t, err)
+	defer db.Close()
+
+	start := time.Date(2026, 10, 16, 3, 0, 0, 0, time.UTC)
+	repo := db.MaintenanceRunRepository()
+	require.NoError(t, repo.Create(&database.MaintenanceRun{StartedAt: start, DurationMS: 12, Deleted: `{"audit_logs":3,"metrics_rollup":40}`}))
+	require.NoError(t, repo.Create(&database.MaintenanceRun{StartedAt: start.Add(24 * time.Hour), DurationMS: 8, Deleted: `{"audit_logs":0}`, Error: "login_attempts: database is locked"}))
+
+	r := gin.New()
+	r.GET("/system/maintenance", NewSystemHandler(db).ListMaintenanceRuns)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/system/maintenance", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Runs []struct {
+			StartedAt    time.Time        `json:"started_at"`
+			DurationMS   int64            `json:"duration_ms"`
+			Deleted      map[string]int64 `json:"deleted"`
+			TotalDeleted int64            `json:"total_deleted"`
+			Error        string           `json:"error"`
+		} `json:"runs"`
+		Count int `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, 2, response.Count)
+	assert.Equal(t, "login_attempts: database is locked", response.Runs[0].Error, "newest first")
+	assert.Equal(t, map[string]int64{"audit_logs": 3, "metrics_rollup": 40}, response.Runs[1].Deleted)
+	assert.Equal(t, int64(43), response.Runs[1].TotalDeleted)
+	assert.True(t, start.Equal(response.Runs[1].StartedAt))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/system/maintenance?limit=1", nil))
+	assert.Contains(t, w.Body.String(), `"count":1`)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/system/maintenance?limit=0", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestMaintenanceWindows(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	admin := &database.User{Username: "admin", Email: "admin@example.com", PasswordHash: "x", Role: "admin"}
+	require.NoError(t, db.UserRepository().Create(admin))
+	for _, name := range []string{"portal", "wiki"} {
+		require.NoError(t, db.RegisteredServiceRepository().Create(&database.RegisteredService{
+			ID:           name,
+			Name:         name,
+			DisplayName:  name,
+			ServiceURL:   "http://localhost",
+			Category:     "web",
+			IsPublic:     true,
+			RequiredRole: "user",
+			Status:       database.RegisteredServiceActive,
+		}))
+	}
+
+	ssoHandler := NewSSOHandler(authService, db)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("user_id", admin.ID)
+		c.Set("role", admin.Role)
+	})
+	r.GET("/sso/services/:id", ssoHandler.GetService)
+	r.GET("/sso/user/services", ssoHandler.ListUserServices)
+	r.GET("/sso/services/:id/maintenance", ssoHandler.ListMaintenanceWindows)
+	r.POST("/sso/services/:id/maintenance", ssoHandler.CreateMaintenanceWindow)
+	r.PUT("/sso/services/:id/maintenance/:window_id", ssoHandler.UpdateMaintenanceWindow)
+	r.DELETE("/sso/services/:id/maintenance/:window_id", ssoHandler.DeleteMaintenanceWindow)
+
+	serve := func(method, path, body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+	window := func(startsAt, endsAt time.Time, message string) string {
+		return fmt.Sprintf(`{"starts_at": %q, "ends_at": %q, "message": %q}`,
+			startsAt.Format(time.RFC3339Nano), endsAt.Format(time.RFC3339Nano), message)
+	}
+
+	now := time.Now().UTC()
+	code, response := serve(http.MethodPost, "/sso/services/portal/maintenance",
+		window(now.Add(-time.Minute), now.Add(time.Hour), "Upgrading the portal"))
+	require.Equal(t, http.StatusCreated, code, response)
+	activeID := int(response["id"].(float64))
+	assert.Equal(t, float64(admin.ID), response["created_by"])
+
+	// Windows starting now default their start time
+	code, response = serve(http.MethodPost, "/sso/services/wiki/maintenance",
+		fmt.Sprintf(`{"ends_at": %q}`, now.Add(time.Hour).Format(time.RFC3339Nano)))
+	require.Equal(t, http.StatusCreated, code, response)
+	wikiID := int(response["id"].(float64))
+
+	code, response = serve(http.MethodPost, "/sso/services/portal/maintenance",
+		window(now.Add(30*time.Minute), now.Add(2*time.Hour), "Overlapping"))
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "Maintenance window overlaps another window of the service", response["error"])
+
+	code, response = serve(http.MethodPost, "/sso/services/portal/maintenance",
+		window(now.Add(2*time.Hour), now.Add(time.Hour), "Backwards"))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "Maintenance window must end after it starts", response["error"])
+
+	code, _ = serve(http.MethodPost, "/sso/services/missing/maintenance", window(now, now.Add(time.Hour), ""))
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, response = serve(http.MethodPost, "/sso/services/portal/maintenance",
+		window(now.Add(24*time.Hour), now.Add(25*time.Hour), "Scheduled reindex"))
+	require.Equal(t, http.StatusCreated, code, response)
+	scheduledID := int(response["id"].(float64))
+
+	code, response = serve(http.MethodGet, "/sso/services/portal/maintenance", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(2), response["count"])
+
+	// Portal users see the active window
+	code, response = serve(http.MethodGet, "/sso/services/portal", "")
+	require.Equal(t, http.StatusOK, code)
+	maintenance := response["maintenance"].(map[string]interface{})
+	assert.Equal(t, true, maintenance["active"])
+	assert.Equal(t, "Upgrading the portal", maintenance["message"])
+	endsAt, err := time.Parse(time.RFC3339Nano, maintenance["ends_at"].(string))
+	require.NoError(t, err)
+	assert.WithinDuration(t, now.Add(time.Hour), endsAt, time.Millisecond)
+
+	code, response = serve(http.MethodGet, "/sso/user/services", "")
+	require.Equal(t, http.StatusOK, code)
+	services := response["services"].([]interface{})
+	require.Len(t, services, 2)
+	for _, service := range services {
+		service := service.(map[string]interface{})
+		assert.Equal(t, true, service["maintenance"].(map[string]interface{})["active"], service["id"])
+	}
+
+	// Ending the window early takes the service out of maintenance
+	code, response = serve(http.MethodPut, fmt.Sprintf("/sso/services/portal/maintenance/%d", activeID),
+		window(now.Add(-time.Minute), now.Add(-time.Second), "Upgrade finished early"))
+	require.Equal(t, http.StatusOK, code, response)
+	code, response = serve(http.MethodGet, "/sso/services/portal", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"active": false}, response["maintenance"])
+
+	// Windows are only reachable through their own service
+	code, _ = serve(http.MethodDelete, fmt.Sprintf("/sso/services/portal/maintenance/%d", wikiID), "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = serve(http.MethodDelete, fmt.Sprintf("/sso/services/wiki/maintenance/%d", wikiID), "")
+	assert.Equal(t, http.StatusOK, code)
+
+	// Rescheduling into another window conflicts, keeping its own start otherwise
+	code, _ = serve(http.MethodPut, fmt.Sprintf("/sso/services/portal/maintenance/%d", activeID),
+		fmt.Sprintf(`{"ends_at": %q}`, now.Add(24*time.Hour+time.Minute).Format(time.RFC3339Nano)))
+	assert.Equal(t, http.StatusConflict, code)
+	code, response = serve(http.MethodPut, fmt.Sprintf("/sso/services/portal/maintenance/%d", scheduledID),
+		fmt.Sprintf(`{"ends_at": %q, "message": "Longer reindex"}`, now.Add(26*time.Hour).Format(time.RFC3339Nano)))
+	require.Equal(t, http.StatusOK, code, response)
+	startsAt, err := time.Parse(time.RFC3339Nano, response["starts_at"].(string))
+	require.NoError(t, err)
+	assert.WithinDuration(t, now.Add(24*time.Hour), startsAt, time.Millisecond)
+}
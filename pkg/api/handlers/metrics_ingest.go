@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// IngestMetricsRequest is a batch of metrics reported by a daemon
+type IngestMetricsRequest struct {
+	Metrics []*database.Metric `json:"metrics" binding:"required"`
+}
+
+// IngestMetrics records metrics reported by a daemon that does not share
+// the console's database, such as the gate's per-route traffic. Callers
+// authenticate with console.metrics.ingest_token as a bearer token; the
+// endpoint is not found when no token is configured. Metrics of a route
+// that has an upstream service are recorded as that service's.
+func (h *SystemHandler) IngestMetrics(c *gin.Context) {
+	var expected string
+	if h.config != nil {
+		expected = h.config.Console.Metrics.IngestToken
+	}
+	if expected == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Metrics ingestion is disabled"})
+		return
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ingest token"})
+		return
+	}
+
+	var req IngestMetricsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now().UTC()
+	for i, metric := range req.Metrics {
+		if metric == nil || metric.ScopeType == "" || metric.ScopeID == "" || metric.MetricName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Metrics need a scope_type, scope_id and metric_name", "index": i})
+			return
+		}
+		if metric.Timestamp.IsZero() {
+			metric.Timestamp = now
+		}
+	}
+
+	db := h.db.WithContext(c.Request.Context())
+	if err := db.RouteRepository().AttributeMetrics(req.Metrics); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up routes"})
+		return
+	}
+	if err := db.MetricRepository().InsertBatch(req.Metrics); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record metrics"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ingested": len(req.Metrics)})
+}
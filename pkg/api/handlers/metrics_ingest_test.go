@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestIngestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Metrics:  config.MetricsConfig{IngestToken: "ingest-secret"},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	service := &database.Service{Name: "api", Image: "api:1", Port: 8090, Status: "running"}
+	require.NoError(t, db.ServiceRepository().Create(service))
+	serviceID := service.ID
+	require.NoError(t, db.RouteRepository().Create(&database.Route{ID: "api", Host: "api.example.com", PathPrefix: "/", UpstreamServiceID: &serviceID}))
+
+	handler := NewSystemHandler(db)
+	handler.SetConfig(cfg)
+	r := gin.New()
+	r.POST("/api/v1/system/metrics/ingest", handler.IngestMetrics)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	labels := `{"route":"api"}`
+	now := time.Now().UTC()
+	metrics := func() []*database.Metric {
+		return []*database.Metric{
+			{Timestamp: now, ScopeType: "route", ScopeID: "api", MetricName: "gate_requests", MetricValue: 12, Labels: &labels},
+			{Timestamp: now, ScopeType: "route", ScopeID: "docs", MetricName: "gate_requests", MetricValue: 3},
+		}
+	}
+
+	// The token is required
+	err = client.NewConsole(server.URL, "wrong").IngestMetrics(context.Background(), metrics())
+	assert.True(t, errors.Is(err, client.ErrUnauthorized), "got %v", err)
+	err = client.NewConsole(server.URL, "").IngestMetrics(context.Background(), metrics())
+	assert.True(t, errors.Is(err, client.ErrUnauthorized), "got %v", err)
+
+	require.NoError(t, client.NewConsole(server.URL, "ingest-secret").IngestMetrics(context.Background(), metrics()))
+
+	// Routes of a service are recorded as the service, keeping their labels
+	recorded, err := db.MetricRepository().GetByService(serviceID, 10)
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, 12.0, recorded[0].MetricValue)
+	require.NotNil(t, recorded[0].Labels)
+	assert.JSONEq(t, labels, *recorded[0].Labels)
+	other, err := db.MetricRepository().Query("route", "docs", "gate_requests", now.Add(-time.Minute), now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	assert.Len(t, other, 1)
+
+	// Metrics without a scope are refused
+	err = client.NewConsole(server.URL, "ingest-secret").IngestMetrics(context.Background(),
+		[]*database.Metric{{MetricName: "gate_requests", MetricValue: 1}})
+	var clientErr *client.Error
+	require.ErrorAs(t, err, &clientErr)
+	assert.Equal(t, http.StatusBadRequest, clientErr.StatusCode)
+
+	// Without a configured token, the endpoint does not exist
+	cfg.Console.Metrics.IngestToken = ""
+	err = client.NewConsole(server.URL, "ingest-secret").IngestMetrics(context.Background(), metrics())
+	assert.True(t, errors.Is(err, client.ErrNotFound), "got %v", err)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestGetAggregatedMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{Database: config.DatabaseConfig{Path: ":memory:"}},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	base := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for i, value := range []float64{2, 4, 9} {
+		require.NoError(t, db.MetricRepository().Insert(&database.Metric{
+			Timestamp:   base.Add(time.Duration(i) * 4 * time.Minute),
+			ScopeType:   "service",
+			ScopeID:     "api",
+			MetricName:  "latency_ms",
+			MetricValue: value,
+		}))
+	}
+
+	r := gin.New()
+	r.GET("/api/v1/system/metrics", NewSystemHandler(db).GetMetrics)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/system/metrics?"+query, nil))
+		return w
+	}
+
+	w := get("service=api&metric=latency_ms&step=5m&agg=max&from=2026-10-16T12:00:00Z&to=2026-10-16T12:15:00Z")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var body struct {
+		Buckets []database.MetricBucket `json:"buckets"`
+		Agg     string                  `json:"agg"`
+		Step    string                  `json:"step"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "max", body.Agg)
+	assert.Equal(t, "5m0s", body.Step)
+	require.Len(t, body.Buckets, 3)
+	assert.Equal(t, 4.0, *body.Buckets[0].Value)
+	assert.Equal(t, 9.0, *body.Buckets[1].Value)
+	assert.Nil(t, body.Buckets[2].Value)
+
+	for _, query := range []string{
+		"step=5m&agg=avg",
+		"service=api&metric=latency_ms&step=5m&agg=median",
+		"service=api&metric=latency_ms&step=five",
+		"service=api&metric=latency_ms&agg=avg&from=yesterday",
+	} {
+		assert.Equal(t, http.StatusBadRequest, get(query).Code, query)
+	}
+
+	w = get("limit=10")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":3`)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// CreateOAuthClientRequest registers an OpenID Connect client
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name" binding:"required"`
+	RedirectURIs []string `json:"redirect_uris" binding:"required,min=1"`
+}
+
+// oauthClientResponse returns a client with its redirect URIs decoded
+func oauthClientResponse(client *database.OAuthClient) gin.H {
+	uris, err := client.RedirectURIList()
+	if err != nil {
+		uris = []string{}
+	}
+	return gin.H{
+		"client_id":     client.ID,
+		"name":          client.Name,
+		"redirect_uris": uris,
+		"created_by":    client.CreatedBy,
+		"created_at":    client.CreatedAt,
+	}
+}
+
+// CreateOAuthClient registers a client of the OpenID Connect provider. The
+// client secret is only ever returned in this response.
+func (h *SSOHandler) CreateOAuthClient(c *gin.Context) {
+	var req CreateOAuthClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, uri := range req.RedirectURIs {
+		parsed, err := url.Parse(uri)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.Fragment != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid redirect URI: " + uri})
+			return
+		}
+	}
+
+	secret, hash, err := h.auth.GenerateSessionToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate client secret"})
+		return
+	}
+	uris, err := json.Marshal(req.RedirectURIs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode redirect URIs"})
+		return
+	}
+
+	client := &database.OAuthClient{
+		ID:           uuid.New().String(),
+		Name:         req.Name,
+		SecretHash:   hash,
+		RedirectURIs: string(uris),
+	}
+	if userID, exists := c.Get("user_id"); exists {
+		if id, ok := userID.(int); ok {
+			client.CreatedBy = &id
+		}
+	}
+	if err := h.db.WithContext(c.Request.Context()).OAuthClientRepository().Create(client); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create OAuth client"})
+		return
+	}
+	recordAudit(c, auditActionCreate, auditResourceOAuthClient, client.ID, gin.H{
+		"name":          client.Name,
+		"redirect_uris": req.RedirectURIs,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":       "OAuth client created successfully. Store the secret now, it will not be shown again.",
+		"client_secret": secret,
+		"client":        oauthClientResponse(client),
+	})
+}
+
+// ListOAuthClients lists the registered OpenID Connect clients without their
+// secrets
+func (h *SSOHandler) ListOAuthClients(c *gin.Context) {
+	clients, err := h.db.WithContext(c.Request.Context()).OAuthClientRepository().List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list OAuth clients"})
+		return
+	}
+
+	response := make([]gin.H, 0, len(clients))
+	for _, client := range clients {
+		response = append(response, oauthClientResponse(client))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"clients": response,
+		"count":   len(response),
+	})
+}
+
+// DeleteOAuthClient removes a registered OpenID Connect client. Tokens
+// already issued to it stay valid until they expire.
+func (h *SSOHandler) DeleteOAuthClient(c *gin.Context) {
+	clientID := c.Param("client_id")
+
+	err := h.db.WithContext(c.Request.Context()).OAuthClientRepository().Delete(clientID)
+	if errors.Is(err, database.ErrOAuthClientNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OAuth client not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete OAuth client"})
+		return
+	}
+	recordAudit(c, auditActionDelete, auditResourceOAuthClient, clientID, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "OAuth client deleted successfully"})
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestOAuthClientLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	admin := &database.User{Username: "admin", Email: "admin@example.com", PasswordHash: "x", Role: "admin"}
+	require.NoError(t, db.UserRepository().Create(admin))
+
+	ssoHandler := NewSSOHandler(authService, db)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", admin.ID) })
+	r.GET("/sso/oauth/clients", ssoHandler.ListOAuthClients)
+	r.POST("/sso/oauth/clients", ssoHandler.CreateOAuthClient)
+	r.DELETE("/sso/oauth/clients/:client_id", ssoHandler.DeleteOAuthClient)
+
+	for name, body := range map[string]gin.H{
+		"missing redirect URIs": {"name": "wiki"},
+		"no redirect URIs":      {"name": "wiki", "redirect_uris": []string{}},
+		"relative redirect URI": {"name": "wiki", "redirect_uris": []string{"/callback"}},
+		"redirect URI fragment": {"name": "wiki", "redirect_uris": []string{"https://wiki.example.com/cb#x"}},
+		"non-http redirect URI": {"name": "wiki", "redirect_uris": []string{"javascript:alert(1)"}},
+	} {
+		w, _ := postJSON(t, r, "/sso/oauth/clients", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+
+	w, created := postJSON(t, r, "/sso/oauth/clients", gin.H{
+		"name":          "wiki",
+		"redirect_uris": []string{"https://wiki.example.com/callback"},
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	secret := created["client_secret"].(string)
+	client := created["client"].(map[string]interface{})
+	clientID := client["client_id"].(string)
+	assert.Equal(t, []interface{}{"https://wiki.example.com/callback"}, client["redirect_uris"])
+	assert.Equal(t, float64(admin.ID), client["created_by"])
+
+	// Only the secret's hash is stored
+	stored, err := db.OAuthClientRepository().GetByID(clientID)
+	require.NoError(t, err)
+	assert.Equal(t, authService.HashSessionToken(secret), stored.SecretHash)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sso/oauth/clients", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"count":1`)
+	assert.NotContains(t, w.Body.String(), secret)
+	assert.NotContains(t, w.Body.String(), stored.SecretHash)
+
+	w, _ = sendJSON(t, r, http.MethodDelete, "/sso/oauth/clients/"+clientID, gin.H{})
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err = db.OAuthClientRepository().GetByID(clientID)
+	assert.ErrorIs(t, err, database.ErrOAuthClientNotFound)
+
+	w, _ = sendJSON(t, r, http.MethodDelete, "/sso/oauth/clients/"+clientID, gin.H{})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
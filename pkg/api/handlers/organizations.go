@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// orgIDPattern is what organization IDs look like: a slug, sent as the
+// X-Org-ID header
+var orgIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// OrgHandler manages organizations and their members
+type OrgHandler struct {
+	db   *database.DB
+	auth *auth.Auth
+}
+
+// NewOrgHandler creates an organization handler
+func NewOrgHandler(db *database.DB, auth *auth.Auth) *OrgHandler {
+	return &OrgHandler{db: db, auth: auth}
+}
+
+// CreateOrgRequest creates an organization
+type CreateOrgRequest struct {
+	ID          string `json:"id" binding:"required"`
+	DisplayName string `json:"display_name"`
+}
+
+// SetOrgMemberRequest sets the role of an organization member
+type SetOrgMemberRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// OrgResponse is an organization with the caller's role in it
+type OrgResponse struct {
+	*database.Organization
+	Role string `json:"role"`
+}
+
+// ListOrgs lists the current user's organizations, or every one for admins
+func (h *OrgHandler) ListOrgs(c *gin.Context) {
+	repo := h.db.WithContext(c.Request.Context()).OrganizationRepository()
+
+	orgs := []OrgResponse{}
+	if c.GetString("role") == "admin" {
+		all, err := repo.List()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list organizations"})
+			return
+		}
+		for _, org := range all {
+			orgs = append(orgs, OrgResponse{Organization: org, Role: database.OrgRoleAdmin})
+		}
+	} else {
+		memberships, err := repo.ListMemberships(c.GetInt("user_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list organizations"})
+			return
+		}
+		for _, membership := range memberships {
+			org, err := repo.Get(membership.OrgID)
+			if err != nil {
+				continue // deleted meanwhile
+			}
+			orgs = append(orgs, OrgResponse{Organization: org, Role: membership.Role})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organizations": orgs, "count": len(orgs), "active": c.GetString("org_id")})
+}
+
+// CreateOrg creates an organization (admin only)
+func (h *OrgHandler) CreateOrg(c *gin.Context) {
+	var req CreateOrgRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !orgIDPattern.MatchString(req.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID, expected lowercase letters, digits and dashes"})
+		return
+	}
+	if req.DisplayName == "" {
+		req.DisplayName = req.ID
+	}
+
+	org := &database.Organization{ID: req.ID, DisplayName: req.DisplayName}
+	if err := h.db.WithContext(c.Request.Context()).OrganizationRepository().Create(org); err != nil {
+		if errors.Is(err, database.ErrOrganizationExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Organization already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+		return
+	}
+	recordAudit(c, auditActionCreate, auditResourceOrganization, org.ID, gin.H{"display_name": org.DisplayName})
+
+	c.JSON(http.StatusCreated, gin.H{"organization": org})
+}
+
+// DeleteOrg deletes an organization that owns nothing anymore (admin only)
+func (h *OrgHandler) DeleteOrg(c *gin.Context) {
+	orgID := c.Param("id")
+
+	if err := h.db.WithContext(c.Request.Context()).OrganizationRepository().Delete(orgID); err != nil {
+		switch {
+		case errors.Is(err, database.ErrOrganizationNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		case errors.Is(err, database.ErrOrganizationNotEmpty):
+			c.JSON(http.StatusConflict, gin.H{"error": "Organization still owns services, routes, registered services or snapshot plans"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete organization"})
+		}
+		return
+	}
+	recordAudit(c, auditActionDelete, auditResourceOrganization, orgID, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Organization deleted successfully"})
+}
+
+// ListOrgMembers lists the members of an organization the caller is in
+func (h *OrgHandler) ListOrgMembers(c *gin.Context) {
+	orgID := c.Param("id")
+	if _, ok := h.orgRole(c, orgID); !ok {
+		return
+	}
+
+	members, err := h.db.WithContext(c.Request.Context()).OrganizationRepository().ListMembers(orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list organization members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": members, "count": len(members)})
+}
+
+// SetOrgMember adds a user to an organization, or changes their role in it
+// (organization admins and admins only)
+func (h *OrgHandler) SetOrgMember(c *gin.Context) {
+	orgID := c.Param("id")
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req SetOrgMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role != database.OrgRoleAdmin && req.Role != database.OrgRoleMember {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role, expected org_admin or member"})
+		return
+	}
+
+	if !h.requireOrgAdmin(c, orgID) {
+		return
+	}
+
+	db := h.db.WithContext(c.Request.Context())
+	user, err := db.UserRepository().GetByID(userID)
+	if err != nil {
+		respondDBError(c, err, "User", "get user")
+		return
+	}
+	if err := db.OrganizationRepository().SetMember(orgID, user.ID, req.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set organization member"})
+		return
+	}
+	recordAudit(c, auditActionGrant, auditResourceOrgMember, orgID+":"+strconv.Itoa(user.ID), gin.H{
+		"username": user.Username,
+		"role":     req.Role,
+	})
+
+	member, err := db.OrganizationRepository().GetMember(orgID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization member"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"member": member})
+}
+
+// RemoveOrgMember removes a user from an organization (organization admins
+// and admins only)
+func (h *OrgHandler) RemoveOrgMember(c *gin.Context) {
+	orgID := c.Param("id")
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if !h.requireOrgAdmin(c, orgID) {
+		return
+	}
+
+	if err := h.db.WithContext(c.Request.Context()).OrganizationRepository().RemoveMember(orgID, userID); err != nil {
+		if errors.Is(err, database.ErrOrgMemberNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization member not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove organization member"})
+		return
+	}
+	recordAudit(c, auditActionRevoke, auditResourceOrgMember, orgID+":"+strconv.Itoa(userID), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Organization member removed successfully"})
+}
+
+// SwitchOrg issues a copy of the current token acting in another
+// organization the user is a member of. It expires with the token it was
+// issued for.
+func (h *OrgHandler) SwitchOrg(c *gin.Context) {
+	value, exists := c.Get("claims")
+	claims, ok := value.(*auth.Claims)
+	if !exists || !ok {
+		// API keys name their organization with the X-Org-ID header instead
+		c.JSON(http.StatusForbidden, gin.H{"error": "Switching organizations requires a signed in user"})
+		return
+	}
+
+	orgID := c.Param("id")
+	if _, ok := h.orgRole(c, orgID); !ok {
+		return
+	}
+
+	token, expiresAt, err := h.auth.GenerateOrgToken(claims, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"org_id":     orgID,
+		"expires_at": time.Unix(expiresAt, 0).UTC(),
+	})
+}
+
+// orgRole returns the current user's role in an organization; admins act as
+// organization admins of every one. Organizations the user is not a member
+// of answer 404, as if they did not exist.
+func (h *OrgHandler) orgRole(c *gin.Context, orgID string) (string, bool) {
+	repo := h.db.WithContext(c.Request.Context()).OrganizationRepository()
+
+	var role string
+	var err error
+	if c.GetString("role") == "admin" {
+		_, err = repo.Get(orgID)
+		role = database.OrgRoleAdmin
+	} else {
+		var member *database.OrgMember
+		if member, err = repo.GetMember(orgID, c.GetInt("user_id")); err == nil {
+			role = member.Role
+		}
+	}
+	if errors.Is(err, database.ErrOrganizationNotFound) || errors.Is(err, database.ErrOrgMemberNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization"})
+		return "", false
+	}
+	return role, true
+}
+
+// requireOrgAdmin reports whether the current user administers an
+// organization, answering 403 to its other members
+func (h *OrgHandler) requireOrgAdmin(c *gin.Context, orgID string) bool {
+	role, ok := h.orgRole(c, orgID)
+	if !ok {
+		return false
+	}
+	if role != database.OrgRoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only organization admins can manage members"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// orgTest serves the service and organization endpoints behind the
+// authentication and organization middleware, as the console does with
+// multi-tenancy enabled
+type orgTest struct {
+	t      *testing.T
+	r      *gin.Engine
+	db     *database.DB
+	auth   *auth.Auth
+	tokens map[string]string
+	users  map[string]*database.User
+}
+
+func newOrgTest(t *testing.T) *orgTest {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	ot := &orgTest{t: t, db: db, auth: authService, tokens: map[string]string{}, users: map[string]*database.User{}}
+	for name, role := range map[string]string{"admin": "admin", "alice": "user", "bob": "user", "carol": "user"} {
+		user := &database.User{Username: name, Email: name + "@example.com", PasswordHash: "hash", Role: role}
+		require.NoError(t, db.UserRepository().Create(user))
+		token, _, err := authService.GenerateToken(user.ID, name, role)
+		require.NoError(t, err)
+		ot.users[name], ot.tokens[name] = user, token
+	}
+
+	// alice administers acme, bob is a member of globex, carol of neither
+	orgs := db.OrganizationRepository()
+	for _, id := range []string{"acme", "globex"} {
+		require.NoError(t, orgs.Create(&database.Organization{ID: id, DisplayName: id}))
+	}
+	require.NoError(t, orgs.SetMember("acme", ot.users["alice"].ID, database.OrgRoleAdmin))
+	require.NoError(t, orgs.SetMember("globex", ot.users["bob"].ID, database.OrgRoleMember))
+
+	serviceHandler := NewServiceHandler(db, nil)
+	ssoHandler := NewSSOHandler(authService, db)
+	orgHandler := NewOrgHandler(db, authService)
+	r := gin.New()
+	api := r.Group("/api/v1", middleware.AuthMiddleware(authService, db), middleware.OrgMiddleware(db))
+	api.POST("/services", serviceHandler.CreateService)
+	api.GET("/services", serviceHandler.ListServices)
+	api.GET("/services/:id", serviceHandler.GetService)
+	api.PUT("/services/:id", serviceHandler.UpdateService)
+	api.DELETE("/services/:id", serviceHandler.DeleteService)
+	api.GET("/sso/services/:id", ssoHandler.GetService)
+	api.DELETE("/sso/services/:id", ssoHandler.DeleteService)
+	api.GET("/orgs", orgHandler.ListOrgs)
+	api.GET("/orgs/:id/members", orgHandler.ListOrgMembers)
+	api.PUT("/orgs/:id/members/:user_id", orgHandler.SetOrgMember)
+	api.POST("/orgs/:id/token", orgHandler.SwitchOrg)
+	ot.r = r
+	return ot
+}
+
+// do sends a request as a user, in an organization if orgID is set
+func (ot *orgTest) do(method, path, user, orgID string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		require.NoError(ot.t, err)
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer "+ot.tokens[user])
+	if orgID != "" {
+		req.Header.Set(middleware.OrgHeader, orgID)
+	}
+	w := httptest.NewRecorder()
+	ot.r.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	require.NoError(ot.t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w, response
+}
+
+// createService creates a service in an organization directly
+func (ot *orgTest) createService(orgID, name string) *database.Service {
+	service := &database.Service{Name: name, Image: "nginx:latest", Port: 8080, Replicas: 1, Status: "stopped"}
+	scoped := ot.db.WithContext(database.WithOrgScope(context.Background(), orgID))
+	require.NoError(ot.t, scoped.ServiceRepository().Create(service))
+	return service
+}
+
+func TestOrgMemberGetsNotFoundOnOtherOrgs(t *testing.T) {
+	ot := newOrgTest(t)
+	acmeService := ot.createService("acme", "acme-web")
+	globexService := ot.createService("globex", "globex-web")
+	registered := &database.RegisteredService{Name: "globex-portal", DisplayName: "Portal", ServiceURL: "http://localhost:9000"}
+	require.NoError(t, ot.db.WithContext(database.WithOrgScope(context.Background(), "globex")).RegisteredServiceRepository().Create(registered))
+
+	// alice lists and gets the services of acme only
+	w, response := ot.do(http.MethodGet, "/api/v1/services", "alice", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	items := response["items"].([]interface{})
+	require.Len(t, items, 1)
+	assert.Equal(t, acmeService.ID, items[0].(map[string]interface{})["id"])
+	assert.Equal(t, "acme", items[0].(map[string]interface{})["org_id"])
+
+	w, _ = ot.do(http.MethodGet, "/api/v1/services/"+acmeService.ID, "alice", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// globex's resources do not exist for her: 404, not 403
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/api/v1/services/" + globexService.ID},
+		{http.MethodPut, "/api/v1/services/" + globexService.ID},
+		{http.MethodDelete, "/api/v1/services/" + globexService.ID},
+		{http.MethodGet, "/api/v1/sso/services/" + registered.ID},
+		{http.MethodDelete, "/api/v1/sso/services/" + registered.ID},
+	} {
+		w, response := ot.do(req.method, req.path, "alice", "", gin.H{"replicas": 3})
+		assert.Equal(t, http.StatusNotFound, w.Code, "%s %s: %v", req.method, req.path, response)
+	}
+
+	// Naming globex does not help
+	w, response = ot.do(http.MethodGet, "/api/v1/services/"+globexService.ID, "alice", "globex", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Organization not found", response["error"])
+	w, _ = ot.do(http.MethodGet, "/api/v1/orgs/globex/members", "alice", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	stored, err := ot.db.ServiceRepository().GetByID(globexService.ID)
+	require.NoError(t, err, "services of other organizations are not deleted")
+	assert.Equal(t, 1, stored.Replicas)
+
+	// Services alice creates belong to acme, whatever the request says
+	w, response = ot.do(http.MethodPost, "/api/v1/services", "alice", "", gin.H{"name": "acme-api", "image": "nginx:latest", "port": 8081, "org_id": "globex"})
+	require.Equal(t, http.StatusCreated, w.Code, response)
+	w, response = ot.do(http.MethodGet, "/api/v1/services", "bob", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, 1, response["total"])
+
+	// carol, in no organization, sees nothing
+	w, response = ot.do(http.MethodGet, "/api/v1/services", "carol", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, 0, response["total"])
+
+	// Admins see every organization's services unless they name one
+	w, response = ot.do(http.MethodGet, "/api/v1/services", "admin", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, 3, response["total"])
+	w, response = ot.do(http.MethodGet, "/api/v1/services", "admin", "globex", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, 1, response["total"])
+	w, _ = ot.do(http.MethodGet, "/api/v1/services", "admin", "missing", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestOrgMembersAndSwitching(t *testing.T) {
+	ot := newOrgTest(t)
+	globexService := ot.createService("globex", "globex-web")
+
+	// Members cannot manage members, organization admins can
+	path := fmt.Sprintf("/api/v1/orgs/globex/members/%d", ot.users["carol"].ID)
+	w, _ := ot.do(http.MethodPut, path, "bob", "", gin.H{"role": database.OrgRoleMember})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = ot.do(http.MethodPut, path, "admin", "", gin.H{"role": "owner"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, response := ot.do(http.MethodPut, fmt.Sprintf("/api/v1/orgs/acme/members/%d", ot.users["bob"].ID), "alice", "", gin.H{"role": database.OrgRoleMember})
+	require.Equal(t, http.StatusOK, w.Code, response)
+	assert.Equal(t, "bob", response["member"].(map[string]interface{})["username"])
+
+	// bob, now in both, lists both and acts in his first unless he switches
+	w, response = ot.do(http.MethodGet, "/api/v1/orgs", "bob", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, 2, response["count"])
+	assert.Equal(t, "globex", response["active"])
+
+	w, response = ot.do(http.MethodPost, "/api/v1/orgs/acme/token", "bob", "", nil)
+	require.Equal(t, http.StatusOK, w.Code, response)
+	claims, err := ot.auth.ValidateToken(response["token"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "acme", claims.OrgID)
+	assert.Equal(t, ot.users["bob"].ID, claims.UserID)
+
+	ot.tokens["bob"] = response["token"].(string)
+	w, _ = ot.do(http.MethodGet, "/api/v1/services/"+globexService.ID, "bob", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = ot.do(http.MethodGet, "/api/v1/services/"+globexService.ID, "bob", "globex", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w, _ = ot.do(http.MethodPost, "/api/v1/orgs/globex/token", "carol", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parseListOptions reads the limit, offset and sort query parameters plus the
+// named filter parameters. Sorting by "-field" sorts descending. It writes a
+// 400 response and returns false if a parameter is invalid.
+func parseListOptions(c *gin.Context, filters ...string) (database.ListOptions, bool) {
+	opts := database.ListOptions{Limit: defaultPageLimit}
+
+	if limit := c.Query("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 || value > maxPageLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit, expected 1 to " + strconv.Itoa(maxPageLimit)})
+			return opts, false
+		}
+		opts.Limit = value
+	}
+
+	if offset := c.Query("offset"); offset != "" {
+		value, err := strconv.Atoi(offset)
+		if err != nil || value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return opts, false
+		}
+		opts.Offset = value
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		opts.SortBy = strings.TrimPrefix(sort, "-")
+		opts.SortDir = database.SortAsc
+		if strings.HasPrefix(sort, "-") {
+			opts.SortDir = database.SortDesc
+		}
+	}
+
+	for _, filter := range filters {
+		if value := c.Query(filter); value != "" {
+			if opts.Filters == nil {
+				opts.Filters = map[string]string{}
+			}
+			opts.Filters[filter] = value
+		}
+	}
+
+	return opts, true
+}
+
+// respondListError writes the response for a failed paged list query
+func respondListError(c *gin.Context, err error, message string) {
+	if errors.Is(err, database.ErrInvalidListOptions) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// pagedResponse is the body returned by paged list endpoints
+func pagedResponse(items interface{}, total int, opts database.ListOptions) gin.H {
+	return gin.H{
+		"items":  items,
+		"total":  total,
+		"limit":  opts.Limit,
+		"offset": opts.Offset,
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestListServicesPagination(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, service := range []*database.Service{
+		{Name: "api", Image: "api:1", Port: 8080, Replicas: 1, Status: "running"},
+		{Name: "web", Image: "nginx:1", Port: 80, Replicas: 1, Status: "running"},
+		{Name: "cron", Image: "cron:1", Port: 9000, Replicas: 1, Status: "stopped"},
+		{Name: "db", Image: "postgres:16", Port: 5432, Replicas: 1, Status: "running"},
+	} {
+		require.NoError(t, db.ServiceRepository().Create(service))
+	}
+
+	r := gin.New()
+	r.GET("/api/v1/services", NewServiceHandler(db, nil).ListServices)
+
+	list := func(query string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/services"+query, nil))
+
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+
+	code, page := list("?status=running&sort=-name&limit=2&offset=1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(3), page["total"])
+	assert.Equal(t, float64(2), page["limit"])
+	assert.Equal(t, float64(1), page["offset"])
+
+	items := page["items"].([]interface{})
+	require.Len(t, items, 2)
+	assert.Equal(t, "db", items[0].(map[string]interface{})["name"])
+	assert.Equal(t, "api", items[1].(map[string]interface{})["name"])
+
+	code, page = list("?offset=10")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(4), page["total"])
+	assert.Equal(t, float64(defaultPageLimit), page["limit"])
+	assert.Empty(t, page["items"])
+
+	for _, query := range []string{"?sort=environment", "?sort=name%3B+DROP+TABLE+services", "?limit=0", "?limit=100000", "?offset=-1"} {
+		code, _ := list(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
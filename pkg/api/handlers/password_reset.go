@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+)
+
+// defaultResetSender logs reset links when no other sender is configured
+var defaultResetSender auth.ResetSender = auth.LogResetSender{}
+
+// passwordResetRequestedMessage is returned for every reset request so that
+// responses do not reveal which emails are registered
+const passwordResetRequestedMessage = "If the email is registered, a password reset link has been sent"
+
+// PasswordResetRequest starts a password reset for an email address
+type PasswordResetRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// PasswordResetConfirmRequest sets a new password using a reset token
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"` // checked against the password policy
+}
+
+// RequestPasswordReset issues a single-use reset token for the account with
+// the given email and sends its link. Unknown emails get the same response.
+func (h *UserHandler) RequestPasswordReset(c *gin.Context) {
+	var req PasswordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.sendPasswordReset(req.Email); err != nil {
+		// Log rather than fail so the response stays the same for every email
+		logging.FromContext(c.Request.Context()).Error("failed to send password reset", "email", req.Email, "error", err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": passwordResetRequestedMessage})
+}
+
+// sendPasswordReset creates a reset token for the user with email, if any, and sends its link
+func (h *UserHandler) sendPasswordReset(email string) error {
+	user, err := h.db.UserRepository().GetByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	token, tokenHash, err := h.auth.GenerateSessionToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	resetToken := &database.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		ExpiresAt: now.Add(h.auth.PasswordResetTTL()),
+		CreatedAt: now,
+	}
+	if err := h.db.PasswordResetTokenRepository().Create(resetToken); err != nil {
+		return err
+	}
+
+	return h.resetSender.SendPasswordReset(user.Email, h.auth.PasswordResetLink(token))
+}
+
+// ConfirmPasswordReset sets a new password using a reset token, then
+// invalidates the token and every session of the user
+func (h *UserHandler) ConfirmPasswordReset(c *gin.Context) {
+	var req PasswordResetConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens := h.db.WithContext(c.Request.Context()).PasswordResetTokenRepository()
+	tokenHash := h.auth.HashSessionToken(req.Token)
+	resetToken, err := tokens.GetValid(tokenHash, time.Now())
+	if err != nil {
+		respondResetTokenError(c, err)
+		return
+	}
+
+	repo := h.db.WithContext(c.Request.Context()).UserRepository()
+	user, err := repo.GetByID(resetToken.UserID)
+	if err != nil {
+		respondDBError(c, err, "User", "get user")
+		return
+	}
+
+	// Check the policy before using the token so a rejected password can be retried
+	if violations := h.auth.ValidatePassword(req.Password, user.Username, user.Email); len(violations) > 0 {
+		respondPasswordViolations(c, violations)
+		return
+	}
+
+	hashedPassword, err := h.auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password"})
+		return
+	}
+
+	// The token is used, the password changed and sessions ended together, so
+	// a failure leaves the token valid for another attempt
+	user.PasswordHash = hashedPassword
+	var failure string
+	err = h.db.WithTx(c.Request.Context(), func(tx *database.DB) error {
+		failure = ""
+		// Consuming can still fail if the token was used concurrently
+		if _, err := tx.PasswordResetTokenRepository().Consume(tokenHash, time.Now()); err != nil {
+			return err
+		}
+		if err := tx.UserRepository().Update(user); err != nil {
+			failure = "Failed to update password"
+			return err
+		}
+		if err := tx.SSOSessionRepository().InvalidateUserSessions(user.ID); err != nil {
+			failure = "Failed to invalidate sessions"
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		if failure == "" {
+			respondResetTokenError(c, err)
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+		}
+		return
+	}
+	recordAudit(c, auditActionUpdate, auditResourceUser, strconv.Itoa(user.ID), gin.H{
+		"password": gin.H{"changed": true, "via": "reset"},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// respondResetTokenError reports a reset token that could not be verified or consumed
+func respondResetTokenError(c *gin.Context, err error) {
+	if errors.Is(err, database.ErrInvalidResetToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify reset token"})
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// recordingResetSender keeps the reset links it is asked to send
+type recordingResetSender struct {
+	links map[string][]string
+}
+
+func (s *recordingResetSender) SendPasswordReset(email, link string) error {
+	s.links[email] = append(s.links[email], link)
+	return nil
+}
+
+// lastToken returns the token from the latest link sent to email
+func (s *recordingResetSender) lastToken(t *testing.T, email string) string {
+	links := s.links[email]
+	require.NotEmpty(t, links, "no reset link sent to %s", email)
+
+	link, err := url.Parse(links[len(links)-1])
+	require.NoError(t, err)
+	return link.Query().Get("token")
+}
+
+func newPasswordResetTestRouter(t *testing.T) (*gin.Engine, *database.DB, *database.User, *recordingResetSender) {
+	_, db, user := newUserTestRouter(t, config.LockoutConfig{})
+
+	consoleConfig := &config.ConsoleConfig{
+		Auth: config.AuthConfig{
+			JWT:           config.JWTConfig{Secret: "test-secret", ExpiresHours: 24},
+			PasswordReset: config.PasswordResetConfig{URL: "https://console.example.com/reset-password"},
+		},
+	}
+	authService, err := auth.NewAuth(consoleConfig)
+	require.NoError(t, err)
+
+	sender := &recordingResetSender{links: make(map[string][]string)}
+	handler := NewUserHandler(authService, db)
+	handler.SetResetSender(sender)
+
+	r := gin.New()
+	r.POST("/api/v1/auth/login", handler.Login)
+	r.POST("/api/v1/auth/password-reset/request", handler.RequestPasswordReset)
+	r.POST("/api/v1/auth/password-reset/confirm", handler.ConfirmPasswordReset)
+	return r, db, user, sender
+}
+
+func TestPasswordReset(t *testing.T) {
+	r, db, user, sender := newPasswordResetTestRouter(t)
+
+	session := &database.SSOSession{
+		UserID:    user.ID,
+		TokenHash: "session-hash",
+		ExpiresAt: time.Now().Add(time.Hour),
+		IPAddress: "127.0.0.1",
+		UserAgent: "test",
+		IsActive:  true,
+	}
+	require.NoError(t, db.SSOSessionRepository().Create(session))
+
+	// Known and unknown emails get identical responses
+	known, knownBody := postJSON(t, r, "/api/v1/auth/password-reset/request", gin.H{"email": "alice@example.com"})
+	unknown, unknownBody := postJSON(t, r, "/api/v1/auth/password-reset/request", gin.H{"email": "nobody@example.com"})
+	assert.Equal(t, http.StatusAccepted, known.Code)
+	assert.Equal(t, known.Code, unknown.Code)
+	assert.Equal(t, knownBody, unknownBody)
+	assert.Empty(t, sender.links["nobody@example.com"])
+
+	require.Len(t, sender.links["alice@example.com"], 1)
+	assert.Contains(t, sender.links["alice@example.com"][0], "https://console.example.com/reset-password?token=")
+	token := sender.lastToken(t, "alice@example.com")
+
+	// The password policy applies before the token is used
+	w, rejected := postJSON(t, r, "/api/v1/auth/password-reset/confirm", gin.H{"token": token, "password": "short"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{auth.RuleMinLength}, responseRules(t, rejected))
+
+	w, _ = postJSON(t, r, "/api/v1/auth/password-reset/confirm", gin.H{"token": token, "password": "new-password"})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The new password works, and existing sessions are ended
+	w, _ = postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "password123"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "new-password"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, err := db.SSOSessionRepository().GetByTokenHash("session-hash")
+	assert.Error(t, err)
+
+	// Tokens are single-use
+	w, _ = postJSON(t, r, "/api/v1/auth/password-reset/confirm", gin.H{"token": token, "password": "another-password"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPasswordResetTokenExpiry(t *testing.T) {
+	r, db, _, sender := newPasswordResetTestRouter(t)
+
+	w, _ := postJSON(t, r, "/api/v1/auth/password-reset/request", gin.H{"email": "alice@example.com"})
+	require.Equal(t, http.StatusAccepted, w.Code)
+	token := sender.lastToken(t, "alice@example.com")
+
+	_, err := db.Exec("UPDATE password_reset_tokens SET expires_at = ?", time.Now().Add(-time.Minute).UTC().Format("2006-01-02 15:04:05"))
+	require.NoError(t, err)
+
+	w, _ = postJSON(t, r, "/api/v1/auth/password-reset/confirm", gin.H{"token": token, "password": "new-password"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, _ = postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "password123"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPasswordResetSupersedesEarlierTokens(t *testing.T) {
+	r, _, _, sender := newPasswordResetTestRouter(t)
+
+	postJSON(t, r, "/api/v1/auth/password-reset/request", gin.H{"email": "alice@example.com"})
+	first := sender.lastToken(t, "alice@example.com")
+	postJSON(t, r, "/api/v1/auth/password-reset/request", gin.H{"email": "alice@example.com"})
+	second := sender.lastToken(t, "alice@example.com")
+	require.NotEqual(t, first, second)
+
+	w, _ := postJSON(t, r, "/api/v1/auth/password-reset/confirm", gin.H{"token": first, "password": "new-password"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, _ = postJSON(t, r, "/api/v1/auth/password-reset/confirm", gin.H{"token": second, "password": "new-password"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+)
+
+// Identity headers the portal proxy sets on requests to proxied services.
+// Headers with the same prefix sent by clients are removed, so services can
+// trust them.
+const (
+	headerAuthPrefix = "X-Auth-"
+	headerAuthUser   = "X-Auth-User"
+	headerAuthEmail  = "X-Auth-Email"
+	headerAuthRole   = "X-Auth-Role"
+	headerAuthToken  = "X-Auth-Token" // short-lived proxy token, verifiable against /api/v1/auth/jwks
+)
+
+// proxyPathPattern is the form of proxy paths, a single URL path segment
+var proxyPathPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// validateProxyPath checks the proxy settings of a service registration,
+// writing an error response if they are invalid. An empty proxy path is
+// cleared. serviceID is the service being updated, if any.
+func (h *SSOHandler) validateProxyPath(c *gin.Context, req *RegisterServiceRequest, serviceID string) bool {
+	if req.ProxyPath != nil && *req.ProxyPath == "" {
+		req.ProxyPath = nil
+	}
+	if req.ProxyPath == nil {
+		if req.ProxyEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A proxy path is required to enable the proxy"})
+			return false
+		}
+		return true
+	}
+
+	if !proxyPathPattern.MatchString(*req.ProxyPath) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Proxy path must be lowercase letters, digits and dashes"})
+		return false
+	}
+	existing, err := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository().GetByProxyPath(*req.ProxyPath)
+	if err == nil && existing.ID != serviceID {
+		c.JSON(http.StatusConflict, gin.H{"error": "Proxy path is already used by another service"})
+		return false
+	}
+	return true
+}
+
+// ProxyService proxies requests under /portal/:service to a registered
+// service with the proxy enabled, on behalf of the signed in user. The user's
+// identity is passed in the X-Auth-* headers and the console credentials are
+// withheld from the service. It must run behind middleware that
+// authenticates the console session.
+func (h *SSOHandler) ProxyService(c *gin.Context) {
+	service, err := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository().GetByProxyPath(c.Param("service"))
+	if err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
+	if !service.ProxyEnabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		return
+	}
+	if service.Status == database.RegisteredServiceInactive {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service is not available"})
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	user, err := h.db.WithContext(c.Request.Context()).UserRepository().GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Users need the service's role, and explicit access unless it is public
+	allowed := h.auth.RequireRole(user.Role, service.RequiredRole)
+	if allowed && !service.IsPublic {
+		allowed, err = h.db.WithContext(c.Request.Context()).UserServicePermissionRepository().CheckPermission(user.ID, service.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check service permission"})
+			return
+		}
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this service"})
+		return
+	}
+
+	target, err := url.Parse(service.ServiceURL)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Invalid service URL"})
+		return
+	}
+	token, _, err := h.auth.GenerateProxyToken(user.ID, user.Username, user.Role, service.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate proxy token"})
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.Out.URL.Path = strings.TrimSuffix(target.Path, "/") + c.Param("path")
+			r.Out.URL.RawPath = ""
+			r.SetXForwarded()
+
+			stripCredentials(r.Out)
+			r.Out.Header.Set(headerAuthUser, user.Username)
+			r.Out.Header.Set(headerAuthEmail, user.Email)
+			r.Out.Header.Set(headerAuthRole, user.Role)
+			r.Out.Header.Set(headerAuthToken, token)
+			r.Out.Header.Set(logging.RequestIDHeader, logging.RequestID(c.Request.Context()))
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, r.Context().Err()) {
+				return
+			}
+			logging.FromContext(r.Context()).Warn("failed to proxy request", "service", service.Name, "error", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Service is unreachable"})
+		},
+	}
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// stripCredentials removes identity headers sent by the client and the
+// console credentials from a request before it is proxied. Headers spelled
+// with underscores are removed too, as some servers treat them as dashes.
+func stripCredentials(r *http.Request) {
+	for name := range r.Header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(strings.ReplaceAll(name, "_", "-")), headerAuthPrefix) {
+			r.Header.Del(name)
+		}
+	}
+	r.Header.Del("Authorization")
+
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != "auth_token" {
+			r.AddCookie(cookie)
+		}
+	}
+
+	query := r.URL.Query()
+	if query.Has("token") || query.Has("sso_token") {
+		query.Del("token")
+		query.Del("sso_token")
+		r.URL.RawQuery = query.Encode()
+	}
+}
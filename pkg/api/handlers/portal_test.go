@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// proxiedRequest is what a proxied service received
+type proxiedRequest struct {
+	Path    string      `json:"path"`
+	Query   string      `json:"query"`
+	Headers http.Header `json:"headers"`
+}
+
+func TestProxyService(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The proxied service echoes the requests it receives
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(proxiedRequest{Path: r.URL.Path, Query: r.URL.RawQuery, Headers: r.Header})
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	users := make(map[string]*database.User)
+	for _, name := range []string{"alice", "bob"} {
+		user := &database.User{Username: name, Email: name + "@example.com", PasswordHash: "x", Role: "user"}
+		require.NoError(t, db.UserRepository().Create(user))
+		users[name] = user
+	}
+	admin := &database.User{Username: "admin", Email: "admin@example.com", PasswordHash: "x", Role: "admin"}
+	require.NoError(t, db.UserRepository().Create(admin))
+
+	proxyPath := func(path string) *string { return &path }
+	wiki := &database.RegisteredService{
+		ID:           "wiki",
+		Name:         "wiki",
+		DisplayName:  "Wiki",
+		ServiceURL:   backend.URL + "/app/",
+		Category:     "docs",
+		RequiredRole: "user",
+		Status:       database.RegisteredServiceActive,
+		ProxyEnabled: true,
+		ProxyPath:    proxyPath("wiki"),
+	}
+	require.NoError(t, db.RegisteredServiceRepository().Create(wiki))
+	require.NoError(t, db.RegisteredServiceRepository().Create(&database.RegisteredService{
+		ID:           "ops",
+		Name:         "ops",
+		DisplayName:  "Ops",
+		ServiceURL:   backend.URL,
+		Category:     "ops",
+		IsPublic:     true,
+		RequiredRole: "admin",
+		Status:       database.RegisteredServiceActive,
+		ProxyEnabled: true,
+		ProxyPath:    proxyPath("ops"),
+	}))
+	require.NoError(t, db.UserServicePermissionRepository().Grant(users["alice"].ID, "wiki", admin.ID, nil))
+
+	ssoHandler := NewSSOHandler(authService, db)
+	r := gin.New()
+	portal := r.Group("/portal", middleware.SSOAuthMiddleware(authService, db))
+	portal.Any("/:service/*path", ssoHandler.ProxyService)
+
+	// The reverse proxy needs a real connection to the client
+	console := httptest.NewServer(r)
+	defer console.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	type response struct {
+		Code     int
+		Location string
+		Proxied  proxiedRequest
+	}
+	request := func(user *database.User, path string, header http.Header) response {
+		req, err := http.NewRequest(http.MethodGet, console.URL+path, nil)
+		require.NoError(t, err)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if user != nil {
+			token, _, err := authService.GenerateToken(user.ID, user.Username, user.Role)
+			require.NoError(t, err)
+			req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		result := response{Code: resp.StatusCode, Location: resp.Header.Get("Location")}
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result.Proxied))
+		}
+		return result
+	}
+
+	t.Run("unauthenticated users are sent to the login", func(t *testing.T) {
+		resp := request(nil, "/portal/wiki/pages", nil)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.Code)
+		assert.True(t, strings.HasPrefix(resp.Location, "/login?redirect="))
+	})
+
+	t.Run("identity headers are injected", func(t *testing.T) {
+		resp := request(users["alice"], "/portal/wiki/pages/home?rev=2", http.Header{
+			"X-Auth-User":   {"admin"},
+			"X-Auth-Role":   {"admin"},
+			"X-Auth-Groups": {"admins"},
+			"X_auth_email":  {"admin@example.com"},
+			"Cookie":        {"theme=dark"},
+		})
+		require.Equal(t, http.StatusOK, resp.Code)
+
+		proxied := resp.Proxied
+		assert.Equal(t, "/app/pages/home", proxied.Path)
+		assert.Equal(t, "rev=2", proxied.Query)
+		assert.Equal(t, []string{"alice"}, proxied.Headers.Values("X-Auth-User"))
+		assert.Equal(t, []string{"alice@example.com"}, proxied.Headers.Values("X-Auth-Email"))
+		assert.Equal(t, []string{"user"}, proxied.Headers.Values("X-Auth-Role"))
+
+		// Client-supplied identity headers and console credentials never
+		// reach the service
+		assert.Empty(t, proxied.Headers.Values("X-Auth-Groups"))
+		assert.Empty(t, proxied.Headers.Values("X_auth_email"))
+		assert.Empty(t, proxied.Headers.Values("Authorization"))
+		assert.Equal(t, "theme=dark", proxied.Headers.Get("Cookie"))
+
+		claims, err := authService.ValidateSSOToken(proxied.Headers.Get("X-Auth-Token"))
+		require.NoError(t, err)
+		assert.Equal(t, "alice", claims.Username)
+		assert.Equal(t, auth.TokenTypeProxy, claims.Type)
+		assert.Equal(t, jwt.ClaimStrings{"wiki"}, claims.Audience)
+		assert.Empty(t, claims.SessionID)
+	})
+
+	t.Run("services cannot replay the identity token against the console", func(t *testing.T) {
+		resp := request(users["alice"], "/portal/wiki/pages", nil)
+		require.Equal(t, http.StatusOK, resp.Code)
+		token := resp.Proxied.Headers.Get("X-Auth-Token")
+		require.NotEmpty(t, token)
+
+		api := gin.New()
+		api.GET("/api/v1/users/profile", middleware.AuthMiddleware(authService, db), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		for _, req := range []*http.Request{
+			httptest.NewRequest(http.MethodGet, "/api/v1/users/profile", nil),
+			httptest.NewRequest(http.MethodGet, "/api/v1/users/profile?token="+token, nil),
+		} {
+			if req.URL.RawQuery == "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+	})
+
+	t.Run("credentials in the query are not passed on", func(t *testing.T) {
+		token, _, err := authService.GenerateToken(users["alice"].ID, "alice", "user")
+		require.NoError(t, err)
+		resp := request(nil, "/portal/wiki/?token="+token+"&rev=3", nil)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "rev=3", resp.Proxied.Query)
+		assert.Equal(t, "/app/", resp.Proxied.Path)
+	})
+
+	t.Run("users without permission are denied", func(t *testing.T) {
+		resp := request(users["bob"], "/portal/wiki/pages", http.Header{"X-Auth-User": {"alice"}})
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("public services still require their role", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request(users["alice"], "/portal/ops/", nil).Code)
+		assert.Equal(t, http.StatusOK, request(admin, "/portal/ops/", nil).Code)
+	})
+
+	t.Run("services without the proxy are not found", func(t *testing.T) {
+		wiki.ProxyEnabled = false
+		require.NoError(t, db.RegisteredServiceRepository().Update(wiki))
+		assert.Equal(t, http.StatusNotFound, request(users["alice"], "/portal/wiki/pages", nil).Code)
+		assert.Equal(t, http.StatusNotFound, request(users["alice"], "/portal/unknown/", nil).Code)
+	})
+}
+
+func TestRegisterServiceProxyPath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	ssoHandler := NewSSOHandler(authService, db)
+	r := gin.New()
+	r.POST("/sso/services", ssoHandler.RegisterService)
+
+	register := func(name string, proxy gin.H) int {
+		body := gin.H{
+			"name":          name,
+			"display_name":  name,
+			"service_url":   "http://localhost:8080",
+			"category":      "web",
+			"required_role": "user",
+		}
+		for key, value := range proxy {
+			body[key] = value
+		}
+		w, _ := postJSON(t, r, "/sso/services", body)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusCreated, register("plain", nil))
+	assert.Equal(t, http.StatusCreated, register("plain-2", gin.H{"proxy_path": ""}), "empty proxy paths are unset")
+	assert.Equal(t, http.StatusCreated, register("wiki", gin.H{"proxy_enabled": true, "proxy_path": "wiki"}))
+	assert.Equal(t, http.StatusConflict, register("wiki-2", gin.H{"proxy_enabled": true, "proxy_path": "wiki"}))
+	assert.Equal(t, http.StatusBadRequest, register("docs", gin.H{"proxy_enabled": true}))
+	assert.Equal(t, http.StatusBadRequest, register("docs", gin.H{"proxy_enabled": true, "proxy_path": "docs/v2"}))
+
+	service, err := db.RegisteredServiceRepository().GetByProxyPath("wiki")
+	require.NoError(t, err)
+	assert.Equal(t, "wiki", service.Name)
+	assert.True(t, service.ProxyEnabled)
+}
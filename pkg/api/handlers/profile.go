@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// UpdateProfileRequest updates the current user's own profile. Only fields
+// users may change about themselves are accepted.
+type UpdateProfileRequest struct {
+	Email       *string `json:"email" binding:"omitempty,email"`
+	DisplayName *string `json:"display_name" binding:"omitempty,max=100"`
+}
+
+// ChangePasswordRequest changes the current user's password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"` // checked against the password policy
+}
+
+// UpdateProfile updates the current user's email and display name
+func (h *UserHandler) UpdateProfile(c *gin.Context) {
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	before := auditSnapshot(user)
+
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	if req.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+	}
+
+	if err := h.db.WithContext(c.Request.Context()).UserRepository().Update(user); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+		return
+	}
+	recordAudit(c, auditActionUpdate, auditResourceUser, strconv.Itoa(user.ID), auditChanges(before, user))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Profile updated successfully",
+		"user_id":      user.ID,
+		"username":     user.Username,
+		"email":        user.Email,
+		"display_name": user.DisplayName,
+	})
+}
+
+// ChangePassword changes the current user's password after verifying the
+// current one. All of the user's other sessions are signed out, while the
+// session making the change stays signed in.
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+	if rejectImpersonation(c, "change the password") {
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.auth.CheckPassword(req.CurrentPassword, user.PasswordHash); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	if violations := h.auth.ValidatePassword(req.NewPassword, user.Username, user.Email); len(violations) > 0 {
+		respondPasswordViolations(c, violations)
+		return
+	}
+
+	hashedPassword, err := h.auth.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password"})
+		return
+	}
+
+	user.PasswordHash = hashedPassword
+	if err := h.db.WithContext(c.Request.Context()).UserRepository().Update(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	signedOut, err := h.db.WithContext(c.Request.Context()).SSOSessionRepository().InvalidateOtherUserSessions(user.ID, c.GetString("session_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invalidate sessions"})
+		return
+	}
+	recordAudit(c, auditActionUpdate, auditResourceUser, strconv.Itoa(user.ID), gin.H{
+		"password":            gin.H{"changed": true, "via": "profile"},
+		"sessions_signed_out": signedOut,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "Password changed successfully",
+		"sessions_signed_out": signedOut,
+	})
+}
+
+// ListSessions lists the current user's active sessions, marking the one
+// the request was made with
+func (h *UserHandler) ListSessions(c *gin.Context) {
+	sessions, err := h.db.WithContext(c.Request.Context()).SSOSessionRepository().ListActiveByUser(c.GetInt("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	currentID := c.GetString("session_id")
+	sessionList := []gin.H{}
+	for _, session := range sessions {
+		sessionList = append(sessionList, gin.H{
+			"id":         session.ID,
+			"ip_address": session.IPAddress,
+			"user_agent": session.UserAgent,
+			"created_at": session.CreatedAt,
+			"last_used":  session.LastUsed,
+			"expires_at": session.ExpiresAt,
+			"current":    session.ID == currentID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessionList,
+		"total":    len(sessionList),
+	})
+}
+
+// RevokeSession signs out one of the current user's sessions
+func (h *UserHandler) RevokeSession(c *gin.Context) {
+	if rejectImpersonation(c, "revoke sessions") {
+		return
+	}
+
+	sessionRepo := h.db.WithContext(c.Request.Context()).SSOSessionRepository()
+	session, err := sessionRepo.GetByID(c.Param("id"))
+	if errors.Is(err, database.ErrSSOSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session"})
+		return
+	}
+	if session.UserID != c.GetInt("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	if err := sessionRepo.Invalidate(session.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	recordAudit(c, auditActionRevoke, auditResourceSession, session.ID, gin.H{
+		"ip_address": session.IPAddress,
+		"user_agent": session.UserAgent,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// profileClient signs users in to a router serving the profile endpoints
+// behind the authentication middleware, so that sessions are enforced
+type profileClient struct {
+	t *testing.T
+	r *gin.Engine
+}
+
+func newProfileClient(t *testing.T) (*profileClient, *database.DB) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	hash, err := authService.HashPassword("password123")
+	require.NoError(t, err)
+	for _, name := range []string{"alice", "bob"} {
+		user := &database.User{Username: name, Email: name + "@example.com", PasswordHash: hash, Role: "user"}
+		require.NoError(t, db.UserRepository().Create(user))
+	}
+
+	handler := NewUserHandler(authService, db)
+	r := gin.New()
+	r.POST("/api/v1/auth/login", handler.Login)
+	users := r.Group("/api/v1/users", middleware.AuthMiddleware(authService, db))
+	users.GET("/profile", handler.GetProfile)
+	users.PUT("/profile", handler.UpdateProfile)
+	users.POST("/profile/password", handler.ChangePassword)
+	users.GET("/profile/sessions", handler.ListSessions)
+	users.DELETE("/profile/sessions/:id", handler.RevokeSession)
+
+	return &profileClient{t: t, r: r}, db
+}
+
+// do sends a request with a token and, if not nil, a JSON body
+func (p *profileClient) do(method, path, token string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		require.NoError(p.t, err)
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	p.r.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	require.NoError(p.t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w, response
+}
+
+// login signs in from a device named by its user agent, returning the token
+func (p *profileClient) login(username, password, userAgent string) string {
+	payload, err := json.Marshal(gin.H{"username": username, "password": password})
+	require.NoError(p.t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", bytes.NewReader(payload))
+	req.Header.Set("User-Agent", userAgent)
+	w := httptest.NewRecorder()
+	p.r.ServeHTTP(w, req)
+	require.Equal(p.t, http.StatusOK, w.Code, w.Body.String())
+
+	var response auth.LoginResponse
+	require.NoError(p.t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Token
+}
+
+// signedIn reports whether a token is still accepted
+func (p *profileClient) signedIn(token string) bool {
+	w, _ := p.do(http.MethodGet, "/api/v1/users/profile", token, nil)
+	return w.Code == http.StatusOK
+}
+
+func TestUpdateProfile(t *testing.T) {
+	client, db := newProfileClient(t)
+	token := client.login("alice", "password123", "laptop")
+
+	w, response := client.do(http.MethodPut, "/api/v1/users/profile", token, gin.H{
+		"email":        "alice@example.org",
+		"display_name": " Alice Liddell ",
+		"role":         "admin",
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Alice Liddell", response["display_name"])
+
+	user, err := db.UserRepository().GetByUsername("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.org", user.Email)
+	assert.Equal(t, "Alice Liddell", user.DisplayName)
+	assert.Equal(t, "user", user.Role, "users cannot change their own role")
+
+	w, _ = client.do(http.MethodPut, "/api/v1/users/profile", token, gin.H{"email": "not-an-email"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = client.do(http.MethodPut, "/api/v1/users/profile", token, gin.H{"email": "bob@example.com"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	_, response = client.do(http.MethodGet, "/api/v1/users/profile", token, nil)
+	assert.Equal(t, "Alice Liddell", response["display_name"])
+}
+
+func TestChangePassword(t *testing.T) {
+	client, _ := newProfileClient(t)
+	laptop := client.login("alice", "password123", "laptop")
+	phone := client.login("alice", "password123", "phone")
+	bob := client.login("bob", "password123", "desktop")
+
+	change := func(current, next string) (int, map[string]interface{}) {
+		w, response := client.do(http.MethodPost, "/api/v1/users/profile/password", laptop, gin.H{
+			"current_password": current,
+			"new_password":     next,
+		})
+		return w.Code, response
+	}
+
+	code, _ := change("wrong-password", "granite-meadow")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, response := change("password123", "alice123")
+	require.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{auth.RulePersonalInfo}, responseRules(t, response))
+	assert.True(t, client.signedIn(phone), "failed changes sign nobody out")
+
+	code, response = change("password123", "granite-meadow")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), response["sessions_signed_out"])
+
+	assert.True(t, client.signedIn(laptop), "the session changing the password stays signed in")
+	assert.False(t, client.signedIn(phone), "other sessions are signed out")
+	assert.True(t, client.signedIn(bob), "sessions of other users are unaffected")
+
+	w, _ := postJSON(t, client.r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "password123"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	client.login("alice", "granite-meadow", "phone")
+}
+
+func TestProfileSessions(t *testing.T) {
+	client, _ := newProfileClient(t)
+	laptop := client.login("alice", "password123", "laptop")
+	phone := client.login("alice", "password123", "phone")
+	bob := client.login("bob", "password123", "desktop")
+
+	listSessions := func(token string) map[string]map[string]interface{} {
+		w, response := client.do(http.MethodGet, "/api/v1/users/profile/sessions", token, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		byAgent := map[string]map[string]interface{}{}
+		for _, s := range response["sessions"].([]interface{}) {
+			session := s.(map[string]interface{})
+			byAgent[session["user_agent"].(string)] = session
+		}
+		return byAgent
+	}
+
+	sessions := listSessions(laptop)
+	require.Len(t, sessions, 2, "only the caller's sessions are listed")
+	assert.Equal(t, true, sessions["laptop"]["current"])
+	assert.Equal(t, false, sessions["phone"]["current"])
+	assert.NotEmpty(t, sessions["phone"]["ip_address"])
+	assert.NotEmpty(t, sessions["phone"]["last_used"])
+	phoneSession := "/api/v1/users/profile/sessions/" + sessions["phone"]["id"].(string)
+
+	// Users cannot revoke each other's sessions
+	w, _ := client.do(http.MethodDelete, phoneSession, bob, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.True(t, client.signedIn(phone))
+	w, _ = client.do(http.MethodDelete, "/api/v1/users/profile/sessions/unknown", laptop, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, _ = client.do(http.MethodDelete, phoneSession, laptop, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, client.signedIn(phone))
+	assert.True(t, client.signedIn(laptop))
+	assert.Len(t, listSessions(laptop), 1)
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// defaultQueryStats is how many statements are listed by default
+const defaultQueryStats = 20
+
+// queryStatsOrders are the orders statements can be listed in
+var queryStatsOrders = map[string]bool{
+	database.QueryStatsByTotal: true,
+	database.QueryStatsByCount: true,
+	database.QueryStatsByP50:   true,
+	database.QueryStatsByP95:   true,
+	database.QueryStatsByP99:   true,
+	database.QueryStatsByMax:   true,
+}
+
+// GetQueryStats lists the statements the database spent the most time on,
+// aggregated by fingerprint. ?sort orders them by total time (the default),
+// count, p50, p95, p99 or max instead.
+func (h *SystemHandler) GetQueryStats(c *gin.Context) {
+	limit := defaultQueryStats
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxPageLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit, expected 1 to " + strconv.Itoa(maxPageLimit)})
+			return
+		}
+		limit = parsed
+	}
+	order := c.DefaultQuery("sort", database.QueryStatsByTotal)
+	if !queryStatsOrders[order] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort, expected total, count, p50, p95, p99 or max"})
+		return
+	}
+
+	stats := h.db.QueryStats()
+	if stats == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false, "queries": []database.QueryStat{}})
+		return
+	}
+
+	queries := stats.Top(limit, order)
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":           true,
+		"slow_threshold_ms": stats.SlowThreshold().Milliseconds(),
+		"totals":            stats.Totals(),
+		"sort":              order,
+		"queries":           queries,
+		"count":             len(queries),
+	})
+}
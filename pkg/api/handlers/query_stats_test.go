@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestGetQueryStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	open := func(enabled bool) *database.DB {
+		cfg := &config.Config{}
+		cfg.Console.Database = config.DatabaseConfig{
+			Path:       filepath.Join(t.TempDir(), "console.db"),
+			QueryStats: config.QueryStatsConfig{Enabled: enabled},
+		}
+		db, err := database.NewDB(cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	serve := func(db *database.DB, target string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		r := gin.New()
+		r.GET("/api/v1/system/db/queries", NewSystemHandler(db).GetQueryStats)
+		return sendJSON(t, r, http.MethodGet, target, nil)
+	}
+
+	w, response := serve(open(false), "/api/v1/system/db/queries")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, false, response["enabled"])
+	assert.Empty(t, response["queries"])
+
+	db := open(true)
+	db.QueryStats().Reset()
+	for i := 0; i < 3; i++ {
+		_, err := db.UserRepository().GetByID(i + 1)
+		require.Error(t, err)
+	}
+	w, response = serve(db, "/api/v1/system/db/queries?sort=count&limit=1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, response["enabled"])
+	assert.Equal(t, float64(database.DefaultSlowQueryThreshold.Milliseconds()), response["slow_threshold_ms"])
+	queries := response["queries"].([]interface{})
+	require.Len(t, queries, 1)
+	top := queries[0].(map[string]interface{})
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?", top["fingerprint"])
+	assert.Equal(t, float64(3), top["count"])
+
+	for _, target := range []string{"/api/v1/system/db/queries?sort=median", "/api/v1/system/db/queries?limit=0"} {
+		w, _ = serve(db, target)
+		assert.Equal(t, http.StatusBadRequest, w.Code, target)
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+)
+
+// SearchHandler searches the console's resources for its search box
+type SearchHandler struct {
+	db   *database.DB
+	auth *auth.Auth
+}
+
+// NewSearchHandler creates a search handler
+func NewSearchHandler(db *database.DB, auth *auth.Auth) *SearchHandler {
+	return &SearchHandler{db: db, auth: auth}
+}
+
+// Search returns the services, routes, users and registered services
+// matching the q query parameter, grouped by type. The types parameter
+// lists the types to search, comma-separated, and limit the results of each
+// type. Non-admins only find what the other endpoints show them: services,
+// and the registered services they can access.
+func (h *SearchHandler) Search(c *gin.Context) {
+	opts := database.SearchOptions{Query: strings.TrimSpace(c.Query("q")), Limit: defaultSearchLimit}
+	if utf8.RuneCountInString(opts.Query) < database.MinSearchQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query must be at least " + strconv.Itoa(database.MinSearchQueryLength) + " characters"})
+		return
+	}
+	if limit := c.Query("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 || value > maxSearchLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit, expected 1 to " + strconv.Itoa(maxSearchLimit)})
+			return
+		}
+		opts.Limit = value
+	}
+
+	types := database.SearchTypes
+	if value := c.Query("types"); value != "" {
+		types = strings.Split(value, ",")
+	}
+	role, _ := c.Get("role")
+	userRole, _ := role.(string)
+	isAdmin := userRole == "admin"
+	for _, resultType := range types {
+		if !isAdmin && (resultType == database.SearchTypeUsers || resultType == database.SearchTypeRoutes) {
+			continue
+		}
+		opts.Types = append(opts.Types, resultType)
+	}
+	if !isAdmin {
+		userID, _ := c.Get("user_id")
+		opts.UserID, _ = userID.(int)
+		opts.HasRole = func(requiredRole string) bool {
+			return h.auth.RequireRole(userRole, requiredRole)
+		}
+	}
+
+	results := map[string][]*database.SearchResult{}
+	if len(opts.Types) > 0 {
+		var err error
+		results, err = h.db.WithContext(c.Request.Context()).SearchRepository().Search(opts)
+		if errors.Is(err, database.ErrInvalidSearch) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":   opts.Query,
+		"results": results,
+	})
+}
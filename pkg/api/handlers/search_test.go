@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestSearch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	users := map[string]*database.User{}
+	for name, role := range map[string]string{"opsadmin": "admin", "opsuser": "user"} {
+		user := &database.User{Username: name, Email: name + "@example.com", PasswordHash: "x", Role: role}
+		require.NoError(t, db.UserRepository().Create(user))
+		users[role] = user
+	}
+	require.NoError(t, db.ServiceRepository().Create(&database.Service{Name: "ops-api", Image: "api:1", Port: 8080, Replicas: 1, Status: "stopped"}))
+	require.NoError(t, db.RouteRepository().Create(&database.Route{Host: "ops.example.com", PathPrefix: "/"}))
+	for _, service := range []*database.RegisteredService{
+		{ID: "wiki", Name: "wiki", DisplayName: "Ops Wiki", IsPublic: true, RequiredRole: "user"},
+		{ID: "vault", Name: "vault", DisplayName: "Ops Vault", RequiredRole: "admin"},
+	} {
+		service.ServiceURL = "https://" + service.Name + ".example.com"
+		service.Category = "web"
+		service.Status = database.RegisteredServiceActive
+		require.NoError(t, db.RegisteredServiceRepository().Create(service))
+	}
+
+	searchHandler := NewSearchHandler(db, authService)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		user := users[c.GetHeader("X-Test-Role")]
+		c.Set("user_id", user.ID)
+		c.Set("role", user.Role)
+	})
+	r.GET("/search", searchHandler.Search)
+	search := func(role, query string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/search"+query, nil)
+		req.Header.Set("X-Test-Role", role)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+	labels := func(response map[string]interface{}, resultType string) []string {
+		t.Helper()
+		results, ok := response["results"].(map[string]interface{})
+		require.True(t, ok)
+		group, ok := results[resultType].([]interface{})
+		if !ok {
+			return nil
+		}
+		var labels []string
+		for _, result := range group {
+			labels = append(labels, result.(map[string]interface{})["label"].(string))
+		}
+		return labels
+	}
+
+	// Admins find every type of resource
+	code, response := search("admin", "?q=OPS")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "OPS", response["query"])
+	assert.Equal(t, []string{"ops-api"}, labels(response, "services"))
+	assert.Equal(t, []string{"ops.example.com"}, labels(response, "routes"))
+	assert.ElementsMatch(t, []string{"opsadmin", "opsuser"}, labels(response, "users"))
+	assert.ElementsMatch(t, []string{"Ops Wiki", "Ops Vault"}, labels(response, "sso_services"))
+	result := response["results"].(map[string]interface{})["services"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "services", result["type"])
+	assert.Equal(t, "api:1", result["secondary"])
+	assert.Equal(t, "<mark>ops</mark>-api", result["snippet"])
+
+	code, response = search("admin", "?q=ops&types=users&limit=1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Len(t, labels(response, "users"), 1)
+	assert.Nil(t, labels(response, "services"))
+
+	// Users find no users or routes, and only the services they can access
+	code, response = search("user", "?q=ops")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"ops-api"}, labels(response, "services"))
+	assert.Equal(t, []string{"Ops Wiki"}, labels(response, "sso_services"))
+	assert.Nil(t, labels(response, "users"))
+	assert.Nil(t, labels(response, "routes"))
+
+	code, response = search("user", "?q=ops&types=users")
+	require.Equal(t, http.StatusOK, code)
+	assert.Empty(t, response["results"])
+
+	for _, query := range []string{"", "?q=", "?q=%20op%20", "?q=ops&limit=0", "?q=ops&limit=51", "?q=ops&types=secrets"} {
+		code, response := search("admin", query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+		assert.NotEmpty(t, response["error"], query)
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+// maxSecretSize limits the size of a secret's value
+const maxSecretSize = 64 << 10
+
+// SecretHandler manages the secrets services read environment variables
+// from. Values are written but never returned; only the orchestrator reads
+// them, when it starts a service.
+type SecretHandler struct {
+	db  *database.DB
+	key []byte
+}
+
+// NewSecretHandler creates a secret handler sealing values with key. Without
+// a key secrets can be listed and deleted but not written.
+func NewSecretHandler(db *database.DB, key []byte) *SecretHandler {
+	return &SecretHandler{db: db, key: key}
+}
+
+// CreateSecretRequest creates a secret
+type CreateSecretRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Value       string `json:"value"`
+	Description string `json:"description"`
+}
+
+// UpdateSecretRequest replaces the value of a secret, and its description
+// when given
+type UpdateSecretRequest struct {
+	Value       string  `json:"value"`
+	Description *string `json:"description,omitempty"`
+}
+
+// repository returns the secret repository of a request
+func (h *SecretHandler) repository(c *gin.Context) *database.SecretRepository {
+	return h.db.WithContext(c.Request.Context()).SecretRepository(h.key)
+}
+
+// ListSecrets lists the secrets by name, without their values
+func (h *SecretHandler) ListSecrets(c *gin.Context) {
+	secrets, err := h.repository(c).List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list secrets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secrets": secrets,
+		"count":   len(secrets),
+	})
+}
+
+// GetSecret returns a secret without its value
+func (h *SecretHandler) GetSecret(c *gin.Context) {
+	secret, err := h.repository(c).GetMetadata(c.Param("name"))
+	if errors.Is(err, database.ErrSecretNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"secret": secret})
+}
+
+// CreateSecret creates a secret
+func (h *SecretHandler) CreateSecret(c *gin.Context) {
+	var req CreateSecretRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !spec.ValidSecretName(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Secret names must be 1 to 63 letters, digits, '_', '.' or '-', starting with a letter or digit"})
+		return
+	}
+	if !checkSecretSize(c, req.Value) {
+		return
+	}
+
+	repo := h.repository(c)
+	_, err := repo.GetMetadata(req.Name)
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Secret already exists"})
+		return
+	}
+	if !errors.Is(err, database.ErrSecretNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create secret"})
+		return
+	}
+
+	secret := &database.Secret{Name: req.Name, Description: req.Description}
+	if userID, exists := c.Get("user_id"); exists {
+		if id, ok := userID.(int); ok {
+			secret.CreatedBy = &id
+		}
+	}
+	if !h.save(c, repo, secret, req.Value) {
+		return
+	}
+	recordAudit(c, auditActionCreate, auditResourceSecret, secret.Name, gin.H{"name": secret.Name})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Secret created successfully",
+		"secret":  secret,
+	})
+}
+
+// UpdateSecret replaces the value of a secret. Running services keep the
+// old value until they are restarted.
+func (h *SecretHandler) UpdateSecret(c *gin.Context) {
+	var req UpdateSecretRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkSecretSize(c, req.Value) {
+		return
+	}
+
+	repo := h.repository(c)
+	secret, err := repo.GetMetadata(c.Param("name"))
+	if errors.Is(err, database.ErrSecretNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update secret"})
+		return
+	}
+
+	if req.Description != nil {
+		secret.Description = *req.Description
+	}
+	if !h.save(c, repo, secret, req.Value) {
+		return
+	}
+	recordAudit(c, auditActionUpdate, auditResourceSecret, secret.Name, gin.H{"name": secret.Name})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Secret updated successfully",
+		"secret":  secret,
+	})
+}
+
+// DeleteSecret deletes a secret. Services referring to it fail to start
+// until it is created again.
+func (h *SecretHandler) DeleteSecret(c *gin.Context) {
+	name := c.Param("name")
+
+	err := h.repository(c).Delete(name)
+	if errors.Is(err, database.ErrSecretNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete secret"})
+		return
+	}
+	recordAudit(c, auditActionDelete, auditResourceSecret, name, gin.H{"name": name})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Secret deleted successfully"})
+}
+
+// save stores a secret and its value, responding with the failure if it
+// could not be stored
+func (h *SecretHandler) save(c *gin.Context, repo *database.SecretRepository, secret *database.Secret, value string) bool {
+	err := repo.Set(secret, value)
+	if errors.Is(err, database.ErrNoMasterKey) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Secrets are unavailable: no master key is configured"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secret"})
+		return false
+	}
+	return true
+}
+
+// checkSecretSize rejects values larger than maxSecretSize
+func checkSecretSize(c *gin.Context, value string) bool {
+	if len(value) > maxSecretSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Secret value is larger than %d bytes", maxSecretSize)})
+		return false
+	}
+	return true
+}
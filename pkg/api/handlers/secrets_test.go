@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// newSecretRouter serves the secret endpoints with the given master key
+func newSecretRouter(t *testing.T, db *database.DB, key []byte) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	h := NewSecretHandler(db, key)
+	r := gin.New()
+	r.GET("/secrets", h.ListSecrets)
+	r.GET("/secrets/:name", h.GetSecret)
+	r.POST("/secrets", h.CreateSecret)
+	r.PUT("/secrets/:name", h.UpdateSecret)
+	r.DELETE("/secrets/:name", h.DeleteSecret)
+	return r
+}
+
+func TestSecretLifecycle(t *testing.T) {
+	db, err := database.NewDB(&config.Config{Console: config.ConsoleConfig{Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db"), Timeout: "30s"}}})
+	require.NoError(t, err)
+	defer db.Close()
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	r := newSecretRouter(t, db, key)
+
+	for name, body := range map[string]gin.H{
+		"missing name": {"value": "x"},
+		"invalid name": {"name": "db/password", "value": "x"},
+	} {
+		w, _ := postJSON(t, r, "/secrets", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+	w, _ := postJSON(t, r, "/secrets", gin.H{"name": "big", "value": strings.Repeat("x", maxSecretSize+1)})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w, created := postJSON(t, r, "/secrets", gin.H{"name": "db-password", "value": "hunter2", "description": "Primary database"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "hunter2")
+	assert.Equal(t, "db-password", created["secret"].(map[string]interface{})["name"])
+
+	w, _ = postJSON(t, r, "/secrets", gin.H{"name": "db-password", "value": "other"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Values are never returned
+	for _, path := range []string{"/secrets", "/secrets/db-password"} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, w.Code, path)
+		assert.Contains(t, w.Body.String(), "Primary database", path)
+		assert.NotContains(t, w.Body.String(), "hunter2", path)
+	}
+
+	w, _ = sendJSON(t, r, http.MethodPut, "/secrets/db-password", gin.H{"value": "correct horse"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "correct horse")
+	assert.Contains(t, w.Body.String(), "Primary database", "the description is kept unless given")
+	value, err := db.SecretRepository(key).Get("db-password")
+	require.NoError(t, err)
+	assert.Equal(t, "correct horse", value)
+
+	w, _ = sendJSON(t, r, http.MethodPut, "/secrets/missing", gin.H{"value": "x"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Without a master key secrets are listed but cannot be written
+	noKey := newSecretRouter(t, db, nil)
+	w, _ = postJSON(t, noKey, "/secrets", gin.H{"name": "api-token", "value": "x"})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = httptest.NewRecorder()
+	noKey.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/secrets", nil))
+	assert.Contains(t, w.Body.String(), `"count":1`)
+
+	w, _ = sendJSON(t, r, http.MethodDelete, "/secrets/db-password", gin.H{})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = sendJSON(t, r, http.MethodDelete, "/secrets/db-password", gin.H{})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
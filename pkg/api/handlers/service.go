@@ -3,50 +3,73 @@ package handlers
 import (
 	"net/http"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/diff"
+	"github.com/last-emo-boy/infra-core/pkg/logs"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
 )
 
 // ServiceHandler handles service-related API endpoints
 type ServiceHandler struct {
-	db *database.DB
+	db    *database.DB
+	logs  *orchestrator.LogReader
+	ports *orchestrator.PortRegistry
 }
 
-// NewServiceHandler creates a new ServiceHandler
-func NewServiceHandler(db *database.DB) *ServiceHandler {
-	return &ServiceHandler{db: db}
+// NewServiceHandler creates a new ServiceHandler reading service logs with
+// the given reader, which may be nil when no logs are collected
+func NewServiceHandler(db *database.DB, logs *orchestrator.LogReader) *ServiceHandler {
+	return &ServiceHandler{db: db, logs: logs}
 }
 
-// CreateServiceRequest represents service creation data
+// SetPortRegistry sets the registry that keeps services off taken ports and
+// allocates ports to services created without one. Without it every service
+// must have a port, which is not checked.
+func (h *ServiceHandler) SetPortRegistry(ports *orchestrator.PortRegistry) {
+	h.ports = ports
+}
+
+// CreateServiceRequest represents service creation data. The service is
+// given either field by field or as a YAML spec in yaml_config.
 type CreateServiceRequest struct {
-	Name        string            `json:"name" binding:"required"`
-	Image       string            `json:"image" binding:"required"`
-	Port        int               `json:"port" binding:"required"`
-	Environment map[string]string `json:"environment,omitempty"`
-	Command     []string          `json:"command,omitempty"`
-	Args        []string          `json:"args,omitempty"`
-	Replicas    int               `json:"replicas,omitempty"`
-	HealthCheck *struct {
+	Name          string            `json:"name"`
+	Image         string            `json:"image"`
+	Port          int               `json:"port"`
+	Environment   map[string]string `json:"environment,omitempty"`
+	EnvFromSecret map[string]string `json:"env_from_secret,omitempty"` // variable to secret name
+	Command       []string          `json:"command,omitempty"`
+	Args          []string          `json:"args,omitempty"`
+	Replicas      int               `json:"replicas,omitempty"`
+	HealthCheck   *struct {
 		Path     string `json:"path"`
 		Interval int    `json:"interval"`
 		Timeout  int    `json:"timeout"`
 		Retries  int    `json:"retries"`
 	} `json:"health_check,omitempty"`
+	YAMLConfig string       `json:"yaml_config,omitempty"`
+	Logging    *logs.Config `json:"logging,omitempty"`
 }
 
-// UpdateServiceRequest represents service update data
+// UpdateServiceRequest represents service update data. A yaml_config
+// replaces the service's spec, and the other fields are applied on top of it.
 type UpdateServiceRequest struct {
-	Image       *string           `json:"image,omitempty"`
-	Port        *int              `json:"port,omitempty"`
-	Environment map[string]string `json:"environment,omitempty"`
-	Command     []string          `json:"command,omitempty"`
-	Args        []string          `json:"args,omitempty"`
-	Replicas    *int              `json:"replicas,omitempty"`
-	Status      *string           `json:"status,omitempty"` // running, stopped, error
+	Image         *string           `json:"image,omitempty"`
+	Port          *int              `json:"port,omitempty"`
+	Environment   map[string]string `json:"environment,omitempty"`
+	EnvFromSecret map[string]string `json:"env_from_secret,omitempty"`
+	Command       []string          `json:"command,omitempty"`
+	Args          []string          `json:"args,omitempty"`
+	Replicas      *int              `json:"replicas,omitempty"`
+	Status        *string           `json:"status,omitempty"` // running, stopped, error
+	YAMLConfig    *string           `json:"yaml_config,omitempty"`
+	Logging       *logs.Config      `json:"logging,omitempty"`
 }
 
 // CreateService creates a new service
@@ -57,36 +80,46 @@ func (h *ServiceHandler) CreateService(c *gin.Context) {
 		return
 	}
 
-	// Default values
-	if req.Replicas == 0 {
-		req.Replicas = 1
-	}
-
-	service := &database.Service{
-		ID:       uuid.New().String(),
-		Name:     req.Name,
-		Image:    req.Image,
-		Port:     req.Port,
-		Replicas: req.Replicas,
-		Status:   "stopped",
+	serviceSpec, problems := req.spec(h.ports != nil)
+	if len(problems) > 0 {
+		respondSpecProblems(c, problems)
+		return
 	}
 
-	// Convert request to service format
-	if len(req.Environment) > 0 {
-		service.Environment = req.Environment
+	// The YAML is kept as written, or generated from the fields
+	yamlConfig := req.YAMLConfig
+	if yamlConfig == "" {
+		var err error
+		if yamlConfig, err = serviceSpec.YAML(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service"})
+			return
+		}
 	}
-	if len(req.Command) > 0 {
-		service.Command = req.Command
+	if req.Logging != nil {
+		var err error
+		if yamlConfig, err = logs.ApplyToServiceYAML(yamlConfig, *req.Logging); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
-	if len(req.Args) > 0 {
-		service.Args = req.Args
+
+	service := &database.Service{
+		ID:         uuid.New().String(),
+		Status:     "stopped",
+		YAMLConfig: yamlConfig,
 	}
+	applySpec(service, serviceSpec)
 
-	repo := h.db.ServiceRepository()
-	if err := repo.Create(service); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service"})
+	repo := h.db.WithContext(c.Request.Context()).ServiceRepository()
+	if !saveOnPort(c, h.ports, service, serviceSpec, func() error { return repo.Create(service) }, "Failed to create service") {
 		return
 	}
+	recordAudit(c, auditActionCreate, auditResourceService, service.ID, gin.H{
+		"name":     service.Name,
+		"image":    service.Image,
+		"port":     service.Port,
+		"replicas": service.Replicas,
+	})
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message":    "Service created successfully",
@@ -95,38 +128,49 @@ func (h *ServiceHandler) CreateService(c *gin.Context) {
 	})
 }
 
-// ListServices returns a list of all services
+// ListServices returns a page of services, optionally filtered by status or image
 func (h *ServiceHandler) ListServices(c *gin.Context) {
-	repo := h.db.ServiceRepository()
-	services, err := repo.List()
+	opts, ok := parseListOptions(c, "status", "image")
+	if !ok {
+		return
+	}
+
+	repo := h.db.WithContext(c.Request.Context()).ServiceRepository()
+	services, total, err := repo.ListPaged(opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch services"})
+		respondListError(c, err, "Failed to fetch services")
 		return
 	}
+	if services == nil {
+		services = []*database.Service{}
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"services": services,
-		"total":    len(services),
-	})
+	c.JSON(http.StatusOK, pagedResponse(services, total, opts))
 }
 
 // GetService returns service details by ID
 func (h *ServiceHandler) GetService(c *gin.Context) {
 	serviceID := c.Param("id")
 
-	repo := h.db.ServiceRepository()
+	repo := h.db.WithContext(c.Request.Context()).ServiceRepository()
 	service, err := repo.GetByID(serviceID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		respondDBError(c, err, "Service", "get service")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"service": service})
 }
 
-// UpdateService updates service configuration
+// UpdateService updates service configuration. With ?dry_run=true the
+// update is validated and its changes returned without saving anything.
+// Every update saved records the service's configuration as a revision.
 func (h *ServiceHandler) UpdateService(c *gin.Context) {
 	serviceID := c.Param("id")
+	dryRun, ok := dryRunParam(c)
+	if !ok {
+		return
+	}
 
 	var req UpdateServiceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -134,56 +178,157 @@ func (h *ServiceHandler) UpdateService(c *gin.Context) {
 		return
 	}
 
-	repo := h.db.ServiceRepository()
+	repo := h.db.WithContext(c.Request.Context()).ServiceRepository()
 	service, err := repo.GetByID(serviceID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		respondDBError(c, err, "Service", "get service")
 		return
 	}
 
-	// Update fields
-	if req.Image != nil {
-		service.Image = *req.Image
+	h.update(c, service, &req, dryRun, auditActionUpdate, nil)
+}
+
+// update applies an update request to a service and saves it as a new
+// revision, or with dryRun only responds with the changes it makes. The
+// update is audited as action, with its changes and any details given.
+func (h *ServiceHandler) update(c *gin.Context, service *database.Service, req *UpdateServiceRequest, dryRun bool, action string, details gin.H) {
+	original := *service
+	before := auditSnapshot(service)
+	beforeSpec, _ := currentSpec(service)
+
+	serviceSpec, yamlConfig := currentSpec(service)
+	if req.YAMLConfig != nil {
+		parsed, problems := spec.ParseAndValidate(*req.YAMLConfig)
+		if len(problems) == 0 && parsed.Name != service.Name {
+			problems = append(problems, spec.ValidationError{Field: "name", Message: "cannot be changed from " + service.Name})
+		}
+		if len(problems) > 0 {
+			respondSpecProblems(c, problems)
+			return
+		}
+		serviceSpec, yamlConfig = parsed, *req.YAMLConfig
 	}
-	if req.Port != nil {
-		service.Port = *req.Port
+
+	// Field updates are applied to the spec, whose YAML is then regenerated
+	changed := req.applyTo(serviceSpec)
+	if changed || req.YAMLConfig != nil {
+		if problems := checkServiceSpec(serviceSpec, serviceSpec.Validate(), h.ports != nil); len(problems) > 0 {
+			respondSpecProblems(c, problems)
+			return
+		}
 	}
-	if req.Replicas != nil {
-		service.Replicas = *req.Replicas
+	if changed {
+		var err error
+		if yamlConfig, err = serviceSpec.YAML(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update service"})
+			return
+		}
 	}
+	if req.Logging != nil {
+		var err error
+		if yamlConfig, err = logs.ApplyToServiceYAML(yamlConfig, *req.Logging); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	applySpec(service, serviceSpec)
+	service.YAMLConfig = yamlConfig
 	if req.Status != nil {
 		service.Status = *req.Status
 	}
-	if req.Environment != nil {
-		service.Environment = req.Environment
+
+	if dryRun {
+		changes := serviceChanges(beforeSpec, &original, service)
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run":  true,
+			"changes":  changes,
+			"redeploy": diff.Redeploys(changes),
+			"service":  service,
+		})
+		return
 	}
-	if req.Command != nil {
-		service.Command = req.Command
+
+	var createdBy *int
+	if userID, exists := c.Get("user_id"); exists {
+		if id, ok := userID.(int); ok {
+			createdBy = &id
+		}
 	}
-	if req.Args != nil {
-		service.Args = req.Args
+	save := func() error {
+		return h.db.WithTx(c.Request.Context(), func(tx *database.DB) error {
+			// Services saved before revisions were kept get their current
+			// configuration recorded first, so that they can be reverted to
+			revisions := tx.ServiceRevisionRepository()
+			if err := revisions.Record(&original, nil); err != nil {
+				return err
+			}
+			service.Version = original.Version
+			if err := tx.ServiceRepository().Update(service); err != nil {
+				return err
+			}
+			return revisions.Record(service, createdBy)
+		})
 	}
-
-	if err := repo.Update(service); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update service"})
+	if !saveOnPort(c, h.ports, service, serviceSpec, save, "Failed to update service") {
 		return
 	}
+	auditDetails := auditChanges(before, service)
+	for key, value := range details {
+		auditDetails[key] = value
+	}
+	recordAudit(c, action, auditResourceService, service.ID, auditDetails)
 
+	changes := serviceChanges(beforeSpec, &original, service)
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Service updated successfully",
-		"service": service,
+		"message":  "Service updated successfully",
+		"service":  service,
+		"changes":  changes,
+		"redeploy": diff.Redeploys(changes),
 	})
 }
 
+// serviceChanges returns the changes of an update to a service: those of
+// its spec, and of its status
+func serviceChanges(beforeSpec *spec.Spec, before, after *database.Service) []diff.Change {
+	afterSpec, _ := currentSpec(after)
+	changes := spec.Diff(beforeSpec, afterSpec)
+	if before.Status != after.Status {
+		changes = append(changes, diff.Change{Field: "status", From: before.Status, To: after.Status})
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	}
+	return changes
+}
+
+// dryRunParam parses the dry_run query parameter, rejecting the request
+// when it is not a boolean
+func dryRunParam(c *gin.Context) (bool, bool) {
+	value := c.Query("dry_run")
+	if value == "" {
+		return false, true
+	}
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be true or false"})
+		return false, false
+	}
+	return dryRun, true
+}
+
 // DeleteService deletes a service
 func (h *ServiceHandler) DeleteService(c *gin.Context) {
 	serviceID := c.Param("id")
 
-	repo := h.db.ServiceRepository()
+	repo := h.db.WithContext(c.Request.Context()).ServiceRepository()
+	if _, err := repo.GetByID(serviceID); err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
 	if err := repo.Delete(serviceID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete service"})
+		respondDBError(c, err, "Service", "delete service")
 		return
 	}
+	recordAudit(c, auditActionDelete, auditResourceService, serviceID, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Service deleted successfully"})
 }
@@ -192,19 +337,20 @@ func (h *ServiceHandler) DeleteService(c *gin.Context) {
 func (h *ServiceHandler) StartService(c *gin.Context) {
 	serviceID := c.Param("id")
 
-	repo := h.db.ServiceRepository()
+	repo := h.db.WithContext(c.Request.Context()).ServiceRepository()
 	service, err := repo.GetByID(serviceID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		respondDBError(c, err, "Service", "get service")
 		return
 	}
 
 	// Update status to running
 	service.Status = "running"
 	if err := repo.Update(service); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start service"})
+		respondDBError(c, err, "Service", "start service")
 		return
 	}
+	recordAudit(c, auditActionStart, auditResourceService, serviceID, nil)
 
 	// TODO: Implement actual service orchestration
 	c.JSON(http.StatusOK, gin.H{
@@ -218,19 +364,20 @@ func (h *ServiceHandler) StartService(c *gin.Context) {
 func (h *ServiceHandler) StopService(c *gin.Context) {
 	serviceID := c.Param("id")
 
-	repo := h.db.ServiceRepository()
+	repo := h.db.WithContext(c.Request.Context()).ServiceRepository()
 	service, err := repo.GetByID(serviceID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		respondDBError(c, err, "Service", "get service")
 		return
 	}
 
 	// Update status to stopped
 	service.Status = "stopped"
 	if err := repo.Update(service); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stop service"})
+		respondDBError(c, err, "Service", "stop service")
 		return
 	}
+	recordAudit(c, auditActionStop, auditResourceService, serviceID, nil)
 
 	// TODO: Implement actual service orchestration
 	c.JSON(http.StatusOK, gin.H{
@@ -240,25 +387,17 @@ func (h *ServiceHandler) StopService(c *gin.Context) {
 	})
 }
 
-// GetServiceLogs returns service logs
+// GetServiceLogs returns logs captured from a service, see
+// orchestrator.ServeServiceLogs for the supported query parameters
 func (h *ServiceHandler) GetServiceLogs(c *gin.Context) {
 	serviceID := c.Param("id")
 
-	// Get query parameters
-	tail := c.DefaultQuery("tail", "100")
-	since := c.Query("since")
+	if _, err := h.db.WithContext(c.Request.Context()).ServiceRepository().GetByID(serviceID); err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
 
-	// TODO: Implement actual log retrieval
-	c.JSON(http.StatusOK, gin.H{
-		"service_id": serviceID,
-		"logs": []string{
-			"2025-01-22T21:30:00Z [INFO] Service started",
-			"2025-01-22T21:30:01Z [INFO] Listening on port 3000",
-			"2025-01-22T21:30:05Z [INFO] Health check passed",
-		},
-		"tail":  tail,
-		"since": since,
-	})
+	orchestrator.ServeServiceLogs(c, h.logs, serviceID)
 }
 
 // ServiceSummaryResponse represents aggregated service information
@@ -279,7 +418,7 @@ type ServiceStatusCounts struct {
 
 // GetServiceSummary returns aggregated information about services
 func (h *ServiceHandler) GetServiceSummary(c *gin.Context) {
-	repo := h.db.ServiceRepository()
+	repo := h.db.WithContext(c.Request.Context()).ServiceRepository()
 	services, err := repo.List()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch services"})
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// Actions of a bulk service request
+const (
+	bulkActionStart   = "start"
+	bulkActionStop    = "stop"
+	bulkActionRestart = "restart"
+	bulkActionDelete  = "delete"
+)
+
+// Bulk service requests act on at most maxBulkServices services, running
+// bulkWorkers actions at a time
+const (
+	maxBulkServices = 200
+	bulkWorkers     = 4
+)
+
+// errServiceNotFound is the result of a bulk action on a missing service
+var errServiceNotFound = errors.New("service not found")
+
+// BulkServiceRequest applies one action to several services, named by ID or
+// matched by a selector
+type BulkServiceRequest struct {
+	Action   string           `json:"action" binding:"required"` // start, stop, restart or delete
+	IDs      []string         `json:"ids,omitempty"`
+	Selector *ServiceSelector `json:"selector,omitempty"`
+}
+
+// ServiceSelector matches services by status and image, like the filters of
+// ListServices
+type ServiceSelector struct {
+	Status string `json:"status,omitempty"`
+	Image  string `json:"image,omitempty"`
+}
+
+// BulkResult is the outcome of a bulk action on one resource
+type BulkResult struct {
+	Success bool   `json:"success"`
+	Status  string `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BulkServices applies an action to several services concurrently. Each
+// service succeeds or fails on its own; the response maps every ID to its
+// result.
+func (h *ServiceHandler) BulkServices(c *gin.Context) {
+	var req BulkServiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	repo := h.db.WithContext(c.Request.Context()).ServiceRepository()
+	var status string
+	switch req.Action {
+	case bulkActionStart, bulkActionRestart:
+		status = "running"
+	case bulkActionStop:
+		status = "stopped"
+	case bulkActionDelete:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid action %q, must be start, stop, restart or delete", req.Action)})
+		return
+	}
+
+	ids, err := req.serviceIDs()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Selector != nil {
+		services, err := repo.List()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch services"})
+			return
+		}
+		ids = req.Selector.match(services)
+		if len(ids) > maxBulkServices {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Selector matches %d services, at most %d can be changed at once", len(ids), maxBulkServices)})
+			return
+		}
+	}
+
+	results := runBulk(ids, bulkWorkers, func(id string) error {
+		service, err := repo.GetByID(id)
+		if err != nil {
+			return errServiceNotFound
+		}
+		if req.Action == bulkActionDelete {
+			return repo.Delete(id)
+		}
+		service.Status = status
+		return repo.Update(service)
+	})
+
+	// Audit entries are recorded once the workers are done with the context
+	succeeded := 0
+	for _, id := range ids {
+		result := results[id]
+		if !result.Success {
+			continue
+		}
+		succeeded++
+		result.Status = status
+		if req.Action == bulkActionDelete {
+			result.Status = "deleted"
+		}
+		recordAudit(c, req.Action, auditResourceService, id, gin.H{"bulk": true})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"action":    req.Action,
+		"results":   results,
+		"succeeded": succeeded,
+		"failed":    len(ids) - succeeded,
+	})
+}
+
+// serviceIDs validates which services a bulk request names, returning the
+// distinct IDs it lists, or none if it has a selector
+func (req *BulkServiceRequest) serviceIDs() ([]string, error) {
+	switch {
+	case len(req.IDs) > 0 && req.Selector != nil:
+		return nil, errors.New("ids and selector cannot both be set")
+	case req.Selector != nil:
+		// An empty selector would match every service
+		if req.Selector.Status == "" && req.Selector.Image == "" {
+			return nil, errors.New("selector must set status or image")
+		}
+		return nil, nil
+	case len(req.IDs) == 0:
+		return nil, errors.New("ids or selector is required")
+	}
+
+	ids := make([]string, 0, len(req.IDs))
+	seen := make(map[string]bool, len(req.IDs))
+	for _, id := range req.IDs {
+		if id == "" {
+			return nil, errors.New("ids cannot be empty")
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxBulkServices {
+		return nil, fmt.Errorf("at most %d services can be changed at once", maxBulkServices)
+	}
+	return ids, nil
+}
+
+// match returns the IDs of the services the selector matches
+func (s *ServiceSelector) match(services []*database.Service) []string {
+	ids := []string{}
+	for _, service := range services {
+		if (s.Status == "" || service.Status == s.Status) && (s.Image == "" || service.Image == s.Image) {
+			ids = append(ids, service.ID)
+		}
+	}
+	return ids
+}
+
+// runBulk runs an action for each ID, at most workers at a time, and
+// returns the result of each
+func runBulk(ids []string, workers int, action func(id string) error) map[string]*BulkResult {
+	results := make(map[string]*BulkResult, len(ids))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workers)
+
+	for _, id := range ids {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(id string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result := &BulkResult{Success: true}
+			if err := action(id); err != nil {
+				result = &BulkResult{Error: err.Error()}
+			}
+			mu.Lock()
+			results[id] = result
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+	return results
+}
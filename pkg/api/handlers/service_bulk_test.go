@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/services"
+)
+
+func TestBulkServices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	admin := &database.User{Username: "admin", Email: "admin@example.com", PasswordHash: "x", Role: "admin"}
+	require.NoError(t, db.UserRepository().Create(admin))
+
+	repo := db.ServiceRepository()
+	var ids []string
+	for i, image := range []string{"nginx:1.25", "nginx:1.25", "redis:7"} {
+		service := &database.Service{Name: fmt.Sprintf("svc-%d", i), Image: image, Port: 80 + i, Replicas: 1, Status: "stopped"}
+		require.NoError(t, repo.Create(service))
+		ids = append(ids, service.ID)
+	}
+
+	logger := services.NewAuditLogger(db, 0)
+	logger.Start()
+
+	role := admin.Role
+	serviceHandler := NewServiceHandler(db, nil)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("audit_logger", logger)
+		c.Set("user_id", admin.ID)
+		c.Set("role", role)
+	})
+	r.POST("/api/v1/services/bulk", middleware.RequireRole(authService, "admin"), serviceHandler.BulkServices)
+
+	status := func(id string) string {
+		service, err := repo.GetByID(id)
+		require.NoError(t, err)
+		return service.Status
+	}
+
+	// Missing services fail without stopping the others
+	w, response := postJSON(t, r, "/api/v1/services/bulk", gin.H{"action": "start", "ids": []string{ids[0], "missing", ids[1], ids[0]}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), response["succeeded"])
+	assert.Equal(t, float64(1), response["failed"])
+	results := response["results"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"success": true, "status": "running"}, results[ids[0]])
+	assert.Equal(t, map[string]interface{}{"success": false, "error": "service not found"}, results["missing"])
+	assert.Equal(t, "running", status(ids[0]))
+	assert.Equal(t, "running", status(ids[1]))
+	assert.Equal(t, "stopped", status(ids[2]))
+
+	// A selector matches services by status and image
+	w, response = postJSON(t, r, "/api/v1/services/bulk", gin.H{"action": "delete", "selector": gin.H{"image": "nginx:1.25", "status": "running"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), response["succeeded"])
+	_, err = repo.GetByID(ids[0])
+	assert.Error(t, err)
+	assert.Equal(t, "stopped", status(ids[2]))
+
+	for _, body := range []gin.H{
+		{"action": "scale", "ids": []string{ids[2]}},
+		{"action": "stop"},
+		{"action": "stop", "selector": gin.H{}},
+		{"action": "stop", "ids": []string{ids[2]}, "selector": gin.H{"status": "running"}},
+		{"action": "stop", "ids": []string{""}},
+	} {
+		w, _ = postJSON(t, r, "/api/v1/services/bulk", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	// Only admins may act on services in bulk
+	role = "user"
+	w, _ = postJSON(t, r, "/api/v1/services/bulk", gin.H{"action": "start", "ids": []string{ids[2]}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "stopped", status(ids[2]))
+
+	// Every affected service gets its own audit entry
+	logger.Stop()
+	entries, err := db.AuditLogRepository().List(database.AuditLogFilter{Limit: 20})
+	require.NoError(t, err)
+	var recorded []string
+	for _, entry := range entries {
+		recorded = append(recorded, entry.Action+" "+*entry.ResourceID)
+	}
+	assert.ElementsMatch(t, []string{
+		"start " + ids[0], "start " + ids[1], "delete " + ids[0], "delete " + ids[1],
+	}, recorded)
+}
+
+func TestRunBulkBoundsConcurrency(t *testing.T) {
+	var running, peak atomic.Int64
+	ids := make([]string, 20)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("id-%d", i)
+	}
+
+	results := runBulk(ids, 3, func(id string) error {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for {
+			seen := peak.Load()
+			if current <= seen || peak.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		if id == "id-7" {
+			return fmt.Errorf("boom")
+		}
+		return nil
+	})
+
+	assert.Equal(t, int64(3), peak.Load())
+	require.Len(t, results, 20)
+	assert.Equal(t, &BulkResult{Error: "boom"}, results["id-7"])
+	assert.True(t, results["id-8"].Success)
+}
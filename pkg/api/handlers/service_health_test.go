@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/services"
+)
+
+func TestServiceHealthStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	healthURL := upstream.URL + "/health"
+	repo := db.RegisteredServiceRepository()
+	require.NoError(t, repo.Create(&database.RegisteredService{
+		ID:           "down-service",
+		Name:         "down",
+		DisplayName:  "Down",
+		ServiceURL:   upstream.URL,
+		Category:     "api",
+		RequiredRole: "user",
+		Status:       database.RegisteredServiceActive,
+		HealthURL:    &healthURL,
+	}))
+	require.NoError(t, repo.Create(&database.RegisteredService{
+		ID:           "idle-service",
+		Name:         "idle",
+		DisplayName:  "Idle",
+		ServiceURL:   "http://localhost:1",
+		Category:     "api",
+		RequiredRole: "user",
+		Status:       database.RegisteredServiceInactive,
+	}))
+
+	checker := services.NewHealthChecker(db, config.ServiceHealthConfig{FailureThreshold: 2})
+	for i := 0; i < 2; i++ {
+		_, err := checker.CheckService("down-service")
+		require.NoError(t, err)
+	}
+
+	ssoHandler := NewSSOHandler(authService, db)
+	systemHandler := NewSystemHandler(db)
+	r := gin.New()
+	r.GET("/sso/services/:id/health", ssoHandler.GetServiceHealth)
+	r.GET("/system/dashboard", systemHandler.GetDashboardData)
+
+	get := func(path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+
+	code, response := get("/sso/services/down-service/health")
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, "down-service", response["service_id"])
+	assert.Equal(t, false, response["is_healthy"])
+	assert.Equal(t, "503 Service Unavailable", response["error_message"])
+	assert.Equal(t, database.RegisteredServiceUnreachable, response["status"])
+	assert.Equal(t, float64(2), response["failure_streak"])
+
+	code, response = get("/sso/services/idle-service/health")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "No health check data available", response["error"])
+
+	code, response = get("/sso/services/missing/health")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "Service not found", response["error"])
+
+	code, response = get("/system/dashboard")
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, map[string]interface{}{
+		"total":       float64(2),
+		"active":      float64(0),
+		"inactive":    float64(1),
+		"unreachable": float64(1),
+	}, response["registered_services"])
+
+	failing := response["failing_services"].([]interface{})
+	require.Len(t, failing, 1)
+	service := failing[0].(map[string]interface{})
+	assert.Equal(t, "down-service", service["id"])
+	assert.Equal(t, database.RegisteredServiceUnreachable, service["status"])
+	assert.Equal(t, float64(2), service["failure_streak"])
+}
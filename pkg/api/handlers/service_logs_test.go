@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+)
+
+func TestGetServiceLogs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{Database: config.DatabaseConfig{Path: filepath.Join(dir, "console.db")}},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.ServiceRepository().Create(&database.Service{ID: "web", Name: "web", Image: "nginx", Port: 80, Status: "running"}))
+
+	logDir := filepath.Join(dir, "logs")
+	collector := orchestrator.NewLogCollector(db, orchestrator.LogCollectorOptions{Dir: logDir, MaxFileSize: 1 << 20, MaxFiles: 2})
+	defer collector.Stop()
+	at := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, collector.WriteLine("web", orchestrator.StreamStderr, "listening on :80", at))
+
+	r := gin.New()
+	r.GET("/api/v1/services/:id/logs", NewServiceHandler(db, orchestrator.NewLogReader(db, logDir)).GetServiceLogs)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/services/web/logs", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		ServiceID string   `json:"service_id"`
+		Logs      []string `json:"logs"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "web", body.ServiceID)
+	assert.Equal(t, []string{"2026-10-16T12:00:00Z stderr listening on :80"}, body.Logs)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/services/unknown/logs", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+// saveOnPort saves a service once the port of its spec is checked against
+// those of other services, the daemons and processes on the host. A spec
+// without a port is given a free one, which is written into the service's
+// YAML. Without a registry the service is saved as it is. It responds to
+// errors, with failure when the service could not be saved, and reports
+// whether it was.
+func saveOnPort(c *gin.Context, ports *orchestrator.PortRegistry, service *database.Service, s *spec.Spec, save func() error, failure string) bool {
+	if ports == nil {
+		if err := save(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+			return false
+		}
+		return true
+	}
+
+	requested := s.PrimaryPort()
+	_, err := ports.Assign(s.Name, requested, s.Replicas, true, func(port int) error {
+		if port != requested {
+			document, err := spec.WithPort(service.YAMLConfig, port)
+			if err != nil {
+				return err
+			}
+			service.YAMLConfig = document
+			s.SetPrimaryPort(port)
+		}
+		applySpec(service, s)
+		return save()
+	})
+
+	var conflict *orchestrator.PortConflictError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &conflict):
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Port %d is already used by %s", conflict.Port, conflict.Owner), "port": conflict.Port, "owner": conflict.Owner})
+	case errors.Is(err, orchestrator.ErrPortOutOfRange):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The ports of the service's replicas run past 65535"})
+	case errors.Is(err, orchestrator.ErrNoFreePort):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No free port left to allocate"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+	}
+	return false
+}
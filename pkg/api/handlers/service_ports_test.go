@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+func newServicePortsTestRouter(t *testing.T) (*gin.Engine, *database.DB) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Port:     8082,
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+		},
+		Orchestrator: config.OrchestratorConfig{ServicePorts: config.ServicePortsConfig{Min: 31000, Max: 31099}},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	handler := NewServiceHandler(db, nil)
+	handler.SetPortRegistry(orchestrator.NewPortRegistry(db, cfg))
+	r := gin.New()
+	r.POST("/api/v1/services/", handler.CreateService)
+	r.PUT("/api/v1/services/:id", handler.UpdateService)
+	return r, db
+}
+
+func TestCreateServiceAllocatesPorts(t *testing.T) {
+	r, db := newServicePortsTestRouter(t)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 5)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w, _ := postJSON(t, r, "/api/v1/services/", gin.H{"name": fmt.Sprintf("svc-%d", i), "image": "nginx:latest"})
+			codes[i] = w.Code
+		}(i)
+	}
+	wg.Wait()
+	for _, code := range codes {
+		require.Equal(t, http.StatusCreated, code)
+	}
+
+	services, err := db.ServiceRepository().List()
+	require.NoError(t, err)
+	require.Len(t, services, len(codes))
+	seen := map[int]bool{}
+	for _, service := range services {
+		assert.True(t, service.Port >= 31000 && service.Port <= 31099, service.Port)
+		assert.False(t, seen[service.Port], "port %d allocated twice", service.Port)
+		seen[service.Port] = true
+
+		// The port is written into the spec as well
+		stored, problems := spec.ParseAndValidate(service.YAMLConfig)
+		require.Empty(t, problems)
+		assert.Equal(t, service.Port, stored.PrimaryPort())
+	}
+}
+
+func TestCreateServiceRejectsTakenPorts(t *testing.T) {
+	r, db := newServicePortsTestRouter(t)
+
+	w, created := postJSON(t, r, "/api/v1/services/", gin.H{"yaml_config": "name: web\nimage: nginx:latest\nport: 31050\nreplicas: 2\n"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w, response := postJSON(t, r, "/api/v1/services/", gin.H{"name": "api", "image": "api:1", "port": 31051})
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "Port 31051 is already used by service web", response["error"])
+
+	w, response = postJSON(t, r, "/api/v1/services/", gin.H{"name": "api", "image": "api:1", "port": 8082})
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "the console", response["owner"])
+
+	// A service may keep its own ports when updated
+	w, _ = sendJSON(t, r, http.MethodPut, "/api/v1/services/"+created["service_id"].(string), gin.H{"image": "nginx:1.27"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	services, err := db.ServiceRepository().List()
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+	assert.Equal(t, 31050, services[0].Port)
+}
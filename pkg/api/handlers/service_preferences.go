@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// PinServiceRequest places a pinned service among the user's pinned services
+type PinServiceRequest struct {
+	SortOrder *int `json:"sort_order"` // defaults to after the other pinned services
+}
+
+// PinService pins a service to the top of the current user's service list
+func (h *SSOHandler) PinService(c *gin.Context) {
+	var req PinServiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, service, ok := h.accessibleService(c)
+	if !ok {
+		return
+	}
+
+	repo := h.db.WithContext(c.Request.Context()).UserServicePreferenceRepository()
+	if err := repo.Pin(userID, service.ID, req.SortOrder); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to pin service"})
+		return
+	}
+	h.respondPreference(c, repo, userID, service.ID)
+}
+
+// UnpinService unpins a service from the current user's service list
+func (h *SSOHandler) UnpinService(c *gin.Context) {
+	userID, service, ok := h.accessibleService(c)
+	if !ok {
+		return
+	}
+
+	repo := h.db.WithContext(c.Request.Context()).UserServicePreferenceRepository()
+	if err := repo.Unpin(userID, service.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unpin service"})
+		return
+	}
+	h.respondPreference(c, repo, userID, service.ID)
+}
+
+// RecordServiceVisit records that the current user launched a service, which
+// moves it up among their unpinned services
+func (h *SSOHandler) RecordServiceVisit(c *gin.Context) {
+	userID, service, ok := h.accessibleService(c)
+	if !ok {
+		return
+	}
+
+	repo := h.db.WithContext(c.Request.Context()).UserServicePreferenceRepository()
+	if err := repo.RecordVisit(userID, service.ID, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record service visit"})
+		return
+	}
+	h.respondPreference(c, repo, userID, service.ID)
+}
+
+// accessibleService loads the service in the path for the current user,
+// responding with 404 if it doesn't exist and 403 if the user may not access
+// it, as ListUserServices would not list it
+func (h *SSOHandler) accessibleService(c *gin.Context) (int, *database.RegisteredService, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return 0, nil, false
+	}
+	role, _ := c.Get("role")
+
+	service, err := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository().GetByID(c.Param("id"))
+	if err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return 0, nil, false
+	}
+	if service.IsPublic {
+		return userID.(int), service, true
+	}
+
+	permRepo := h.db.WithContext(c.Request.Context()).UserServicePermissionRepository()
+	hasPermission, _ := permRepo.CheckPermission(userID.(int), service.ID)
+	roleName, _ := role.(string)
+	if !hasPermission || !h.auth.RequireRole(roleName, service.RequiredRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this service"})
+		return 0, nil, false
+	}
+	return userID.(int), service, true
+}
+
+// respondPreference responds with the current user's preferences for a service
+func (h *SSOHandler) respondPreference(c *gin.Context, repo *database.UserServicePreferenceRepository, userID int, serviceID string) {
+	preference, err := repo.Get(userID, serviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get service preference"})
+		return
+	}
+	c.JSON(http.StatusOK, preference)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestServicePreferences(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	user := &database.User{Username: "dev", Email: "dev@example.com", PasswordHash: "x", Role: "user"}
+	require.NoError(t, db.UserRepository().Create(user))
+	for _, name := range []string{"grafana", "jenkins", "wiki", "vault"} {
+		require.NoError(t, db.RegisteredServiceRepository().Create(&database.RegisteredService{
+			ID:           name,
+			Name:         name,
+			DisplayName:  name,
+			ServiceURL:   "http://localhost",
+			Category:     "web",
+			IsPublic:     name != "vault", // the user has no access to vault
+			RequiredRole: "user",
+			Status:       database.RegisteredServiceActive,
+		}))
+	}
+
+	ssoHandler := NewSSOHandler(authService, db)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		c.Set("role", user.Role)
+	})
+	r.GET("/sso/user/services", ssoHandler.ListUserServices)
+	r.PUT("/sso/user/services/:id/pin", ssoHandler.PinService)
+	r.DELETE("/sso/user/services/:id/pin", ssoHandler.UnpinService)
+	r.POST("/sso/services/:id/visit", ssoHandler.RecordServiceVisit)
+
+	serve := func(method, path, body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+	listed := func() []map[string]interface{} {
+		code, response := serve(http.MethodGet, "/sso/user/services", "")
+		require.Equal(t, http.StatusOK, code)
+		var services []map[string]interface{}
+		for _, service := range response["services"].([]interface{}) {
+			services = append(services, service.(map[string]interface{}))
+		}
+		return services
+	}
+	names := func() []string {
+		var names []string
+		for _, service := range listed() {
+			names = append(names, service["name"].(string))
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"grafana", "jenkins", "wiki"}, names())
+
+	// Launching a service moves it up, pinning one moves it to the top
+	code, response := serve(http.MethodPost, "/sso/services/jenkins/visit", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotNil(t, response["last_accessed"])
+	assert.Equal(t, []string{"jenkins", "grafana", "wiki"}, names())
+
+	code, response = serve(http.MethodPut, "/sso/user/services/wiki/pin", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response["pinned"])
+	code, _ = serve(http.MethodPut, "/sso/user/services/grafana/pin", `{"sort_order": -1}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"grafana", "wiki", "jenkins"}, names())
+
+	services := listed()
+	assert.Equal(t, true, services[0]["pinned"])
+	assert.Equal(t, float64(-1), services[0]["sort_order"])
+	assert.Nil(t, services[0]["last_accessed"])
+	assert.Equal(t, false, services[2]["pinned"])
+	assert.NotNil(t, services[2]["last_accessed"])
+	assert.Contains(t, services[2], "maintenance", "the service fields are still included")
+
+	code, response = serve(http.MethodDelete, "/sso/user/services/grafana/pin", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, response["pinned"])
+	assert.Equal(t, []string{"wiki", "jenkins", "grafana"}, names())
+
+	// Services the user can't access or that don't exist can't be pinned
+	code, _ = serve(http.MethodPut, "/sso/user/services/vault/pin", "")
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = serve(http.MethodPost, "/sso/services/missing/visit", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = serve(http.MethodPut, "/sso/user/services/wiki/pin", `{"sort_order": "first"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/diff"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+// ListServiceRevisions lists the configurations a service had, newest
+// first, each with its changes from the revision before it
+func (h *ServiceHandler) ListServiceRevisions(c *gin.Context) {
+	serviceID := c.Param("id")
+	db := h.db.WithContext(c.Request.Context())
+	service, err := db.ServiceRepository().GetByID(serviceID)
+	if err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
+
+	revisions, err := db.ServiceRevisionRepository().List(serviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list service revisions"})
+		return
+	}
+
+	configs := make([]*database.Service, len(revisions))
+	for i, revision := range revisions {
+		if configs[i], err = revision.Service(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read service revision"})
+			return
+		}
+	}
+
+	items := make([]gin.H, len(revisions))
+	for i, revision := range revisions {
+		item := gin.H{
+			"revision":   revision.Revision,
+			"current":    revision.Revision == service.Version,
+			"created_by": revision.CreatedBy,
+			"created_at": revision.CreatedAt,
+			"service":    configs[i],
+		}
+		if i+1 < len(revisions) {
+			previous := configs[i+1]
+			previousSpec, _ := currentSpec(previous)
+			changes := serviceChanges(previousSpec, previous, configs[i])
+			item["changes"] = changes
+			item["redeploy"] = diff.Redeploys(changes)
+		}
+		items[i] = item
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"service_id": serviceID,
+		"version":    service.Version,
+		"revisions":  items,
+		"count":      len(items),
+	})
+}
+
+// RevertServiceRevision updates a service back to the configuration of one
+// of its revisions, which is saved as a new revision. Its status is kept.
+// With ?dry_run=true the changes are returned without saving anything.
+func (h *ServiceHandler) RevertServiceRevision(c *gin.Context) {
+	serviceID := c.Param("id")
+	dryRun, ok := dryRunParam(c)
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return
+	}
+
+	db := h.db.WithContext(c.Request.Context())
+	service, err := db.ServiceRepository().GetByID(serviceID)
+	if err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
+	revision, err := db.ServiceRevisionRepository().Get(serviceID, number)
+	if err != nil {
+		if errors.Is(err, database.ErrServiceRevisionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Service revision not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get service revision"})
+		return
+	}
+	config, err := revision.Service()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read service revision"})
+		return
+	}
+
+	h.update(c, service, revertRequest(config), dryRun, auditActionRevert, gin.H{"revision": revision.Revision})
+}
+
+// revertRequest returns the update request restoring the configuration of
+// a revision: its spec when it has a valid one, otherwise its fields
+func revertRequest(config *database.Service) *UpdateServiceRequest {
+	if _, problems := spec.ParseAndValidate(config.YAMLConfig); len(problems) == 0 {
+		return &UpdateServiceRequest{YAMLConfig: &config.YAMLConfig}
+	}
+
+	environment := config.Environment
+	if environment == nil {
+		environment = map[string]string{}
+	}
+	command, args := config.Command, config.Args
+	if command == nil {
+		command = []string{}
+	}
+	if args == nil {
+		args = []string{}
+	}
+	return &UpdateServiceRequest{
+		Image:       &config.Image,
+		Port:        &config.Port,
+		Replicas:    &config.Replicas,
+		Environment: environment,
+		Command:     command,
+		Args:        args,
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func newServiceRevisionsTestRouter(t *testing.T) (*gin.Engine, *database.DB) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.NewDB(&config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	handler := NewServiceHandler(db, nil)
+	r := gin.New()
+	r.POST("/api/v1/services/", handler.CreateService)
+	r.PUT("/api/v1/services/:id", handler.UpdateService)
+	r.GET("/api/v1/services/:id/revisions", handler.ListServiceRevisions)
+	r.POST("/api/v1/services/:id/revisions/:rev/revert", handler.RevertServiceRevision)
+	return r, db
+}
+
+func TestUpdateServiceDryRun(t *testing.T) {
+	r, db := newServiceRevisionsTestRouter(t)
+	w, created := postJSON(t, r, "/api/v1/services/", gin.H{"yaml_config": "name: api\nimage: api:1\nport: 8080\nenv:\n  MODE: live\n"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	id := created["service_id"].(string)
+
+	w, response := sendJSON(t, r, http.MethodPut, "/api/v1/services/"+id+"?dry_run=true", gin.H{
+		"environment": gin.H{"MODE": "test", "DEBUG": "1"},
+		"replicas":    3,
+		"status":      "running",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, true, response["dry_run"])
+	assert.Equal(t, true, response["redeploy"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"field": "env.DEBUG", "from": nil, "to": "1", "redeploy": true},
+		map[string]interface{}{"field": "env.MODE", "from": "live", "to": "test", "redeploy": true},
+		map[string]interface{}{"field": "replicas", "from": nil, "to": float64(3), "redeploy": false},
+		map[string]interface{}{"field": "status", "from": "stopped", "to": "running", "redeploy": false},
+	}, response["changes"])
+
+	// Nothing was saved
+	service, err := db.ServiceRepository().GetByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"MODE": "live"}, service.Environment)
+	assert.Equal(t, 1, service.Replicas)
+	revisions, err := db.ServiceRevisionRepository().List(id)
+	require.NoError(t, err)
+	assert.Empty(t, revisions)
+
+	// Dry runs are validated as updates are
+	w, _ = sendJSON(t, r, http.MethodPut, "/api/v1/services/"+id+"?dry_run=true", gin.H{"port": 70000})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = sendJSON(t, r, http.MethodPut, "/api/v1/services/"+id+"?dry_run=maybe", gin.H{"image": "api:2"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestServiceRevisions(t *testing.T) {
+	r, db := newServiceRevisionsTestRouter(t)
+	w, created := postJSON(t, r, "/api/v1/services/", gin.H{"yaml_config": "name: api\nimage: api:1\nport: 8080\nenv:\n  MODE: live\n"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	id := created["service_id"].(string)
+	original, err := db.ServiceRepository().GetByID(id)
+	require.NoError(t, err)
+
+	// Every update saved is a revision, the first also recording the
+	// configuration the service was created with
+	w, response := sendJSON(t, r, http.MethodPut, "/api/v1/services/"+id, gin.H{"image": "api:2"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, response["redeploy"])
+	w, _ = sendJSON(t, r, http.MethodPut, "/api/v1/services/"+id, gin.H{"environment": gin.H{"MODE": "test"}})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	revisionsPath := "/api/v1/services/" + id + "/revisions"
+	w, response = sendJSON(t, r, http.MethodGet, revisionsPath, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, float64(3), response["count"])
+	revisions := response["revisions"].([]interface{})
+	latest, first := revisions[0].(map[string]interface{}), revisions[2].(map[string]interface{})
+	assert.Equal(t, float64(original.Version+2), latest["revision"])
+	assert.Equal(t, true, latest["current"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"field": "env.MODE", "from": "live", "to": "test", "redeploy": true},
+	}, latest["changes"])
+	assert.Equal(t, float64(original.Version), first["revision"])
+	assert.Equal(t, false, first["current"])
+	assert.Equal(t, "api:1", first["service"].(map[string]interface{})["image"])
+	assert.NotContains(t, first, "changes")
+
+	// Reverting previews and then saves the configuration as a new revision
+	revertPath := fmt.Sprintf("%s/%d/revert", revisionsPath, original.Version)
+	w, response = sendJSON(t, r, http.MethodPost, revertPath+"?dry_run=true", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Len(t, response["changes"], 2)
+	service, err := db.ServiceRepository().GetByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, "api:2", service.Image)
+
+	w, _ = sendJSON(t, r, http.MethodPost, revertPath, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	service, err = db.ServiceRepository().GetByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, "api:1", service.Image)
+	assert.Equal(t, map[string]string{"MODE": "live"}, service.Environment)
+	assert.Equal(t, original.YAMLConfig, service.YAMLConfig)
+	assert.Equal(t, original.Version+3, service.Version)
+
+	stored, err := db.ServiceRevisionRepository().List(id)
+	require.NoError(t, err)
+	require.Len(t, stored, 4)
+	assert.Equal(t, service.Version, stored[0].Revision)
+
+	w, _ = sendJSON(t, r, http.MethodPost, revisionsPath+"/99/revert", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = sendJSON(t, r, http.MethodPost, revisionsPath+"/latest/revert", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = sendJSON(t, r, http.MethodGet, "/api/v1/services/missing/revisions", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+// maxSpecSize limits the size of a service spec sent for validation
+const maxSpecSize = 1 << 20
+
+// ValidateServiceSpec checks a service spec without saving anything. The
+// body is the YAML spec, or JSON with the spec in yaml_config as services
+// are created with.
+func (h *ServiceHandler) ValidateServiceSpec(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSpecSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read service spec"})
+		return
+	}
+	if len(body) > maxSpecSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Service spec is larger than %d bytes", maxSpecSize)})
+		return
+	}
+
+	document := string(body)
+	if c.ContentType() == gin.MIMEJSON {
+		var req struct {
+			YAMLConfig string `json:"yaml_config"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+			return
+		}
+		document = req.YAMLConfig
+	}
+
+	serviceSpec, problems := spec.ParseAndValidate(document)
+	if len(problems) == 0 {
+		problems = checkServiceSpec(serviceSpec, nil, h.ports != nil)
+	}
+	if len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"valid": false, "error": "Invalid service spec", "errors": problems})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"valid": true, "spec": serviceSpec})
+}
+
+// respondSpecProblems rejects a request whose service spec is invalid
+func respondSpecProblems(c *gin.Context, problems []spec.ValidationError) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service spec", "errors": problems})
+}
+
+// checkServiceSpec adds the console's own requirements to the problems
+// found in a spec: console services are reached on a port, which may be left
+// out when one is allocated
+func checkServiceSpec(s *spec.Spec, problems []spec.ValidationError, allocatePort bool) []spec.ValidationError {
+	if s.PrimaryPort() == 0 && !allocatePort {
+		problems = append(problems, spec.ValidationError{Field: "port", Message: "is required"})
+	}
+	return problems
+}
+
+// spec returns the service spec of a create request, parsed from its
+// yaml_config or built from its fields. Without allocatePort it must have a
+// port.
+func (req *CreateServiceRequest) spec(allocatePort bool) (*spec.Spec, []spec.ValidationError) {
+	if req.YAMLConfig != "" {
+		if req.Name != "" || req.Image != "" || req.Port != 0 || req.Environment != nil || req.EnvFromSecret != nil ||
+			req.Command != nil || req.Args != nil || req.Replicas != 0 || req.HealthCheck != nil {
+			return nil, []spec.ValidationError{{
+				Field:   "yaml_config",
+				Message: "replaces the name, image, port, environment, env_from_secret, command, args, replicas and health_check fields, which must be left unset",
+			}}
+		}
+		parsed, problems := spec.ParseAndValidate(req.YAMLConfig)
+		if len(problems) > 0 {
+			return nil, problems
+		}
+		return parsed, checkServiceSpec(parsed, nil, allocatePort)
+	}
+
+	replicas := req.Replicas
+	if replicas == 0 {
+		replicas = 1
+	}
+	s := &spec.Spec{
+		Name:          req.Name,
+		Image:         req.Image,
+		Port:          req.Port,
+		Replicas:      replicas,
+		Env:           req.Environment,
+		EnvFromSecret: req.EnvFromSecret,
+		Command:       req.Command,
+		Args:          req.Args,
+	}
+	if check := req.HealthCheck; check != nil {
+		s.HealthCheck = &spec.HealthCheck{Path: check.Path, Retries: check.Retries}
+		if check.Interval > 0 {
+			s.HealthCheck.Interval = fmt.Sprintf("%ds", check.Interval)
+		}
+		if check.Timeout > 0 {
+			s.HealthCheck.Timeout = fmt.Sprintf("%ds", check.Timeout)
+		}
+	}
+	return s, checkServiceSpec(s, s.Validate(), allocatePort)
+}
+
+// applyTo applies the field updates of a request to a spec, reporting
+// whether any were given
+func (req *UpdateServiceRequest) applyTo(s *spec.Spec) bool {
+	changed := false
+	if req.Image != nil {
+		s.Image, changed = *req.Image, true
+	}
+	if req.Port != nil {
+		s.SetPrimaryPort(*req.Port)
+		changed = true
+	}
+	if req.Replicas != nil {
+		s.Replicas, changed = *req.Replicas, true
+	}
+	if req.Environment != nil {
+		s.Env, changed = req.Environment, true
+	}
+	if req.EnvFromSecret != nil {
+		s.EnvFromSecret, changed = req.EnvFromSecret, true
+	}
+	if req.Command != nil {
+		s.Command, changed = req.Command, true
+	}
+	if req.Args != nil {
+		s.Args, changed = req.Args, true
+	}
+	return changed
+}
+
+// currentSpec returns the spec of a stored service and its YAML. Services
+// saved before specs were validated may have YAML that is not a valid spec,
+// in which case the spec is rebuilt from the service's columns.
+func currentSpec(service *database.Service) (*spec.Spec, string) {
+	if parsed, problems := spec.ParseAndValidate(service.YAMLConfig); len(problems) == 0 {
+		return parsed, service.YAMLConfig
+	}
+	return &spec.Spec{
+		Name:     service.Name,
+		Image:    service.Image,
+		Port:     service.Port,
+		Replicas: service.Replicas,
+		Env:      service.Environment,
+		Command:  service.Command,
+		Args:     service.Args,
+	}, service.YAMLConfig
+}
+
+// applySpec fills a service's columns from its spec, so that they cannot
+// drift from its YAML
+func applySpec(service *database.Service, s *spec.Spec) {
+	service.Name = s.Name
+	service.Image = s.Image
+	service.Port = s.PrimaryPort()
+	service.Replicas = s.Replicas
+	if service.Replicas == 0 {
+		service.Replicas = 1
+	}
+	service.Environment = s.Env
+	service.Command = s.Command
+	service.Args = s.Args
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+func newServiceSpecTestRouter(t *testing.T) (*gin.Engine, *database.DB) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	handler := NewServiceHandler(db, nil)
+	r := gin.New()
+	r.POST("/api/v1/services/", handler.CreateService)
+	r.POST("/api/v1/services/validate", handler.ValidateServiceSpec)
+	r.PUT("/api/v1/services/:id", handler.UpdateService)
+	return r, db
+}
+
+func TestCreateServiceFromSpec(t *testing.T) {
+	r, db := newServiceSpecTestRouter(t)
+
+	yamlConfig := "name: api\nimage: api:1.2\nports:\n  - internal: 8080\nreplicas: 2\nenv:\n  MODE: live\n"
+	w, created := postJSON(t, r, "/api/v1/services/", gin.H{"yaml_config": yamlConfig})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	// The columns are filled from the spec, which is stored as written
+	service, err := db.ServiceRepository().GetByID(created["service_id"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "api", service.Name)
+	assert.Equal(t, "api:1.2", service.Image)
+	assert.Equal(t, 8080, service.Port)
+	assert.Equal(t, 2, service.Replicas)
+	assert.Equal(t, map[string]string{"MODE": "live"}, service.Environment)
+	assert.Equal(t, yamlConfig, service.YAMLConfig)
+
+	// Field updates regenerate the YAML so that it stays in step
+	w, _ = sendJSON(t, r, http.MethodPut, "/api/v1/services/"+service.ID, gin.H{"image": "api:1.3", "port": 9090})
+	require.Equal(t, http.StatusOK, w.Code)
+	service, err = db.ServiceRepository().GetByID(service.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 9090, service.Port)
+	stored, problems := spec.ParseAndValidate(service.YAMLConfig)
+	require.Empty(t, problems)
+	assert.Equal(t, "api:1.3", stored.Image)
+	assert.Equal(t, 9090, stored.PrimaryPort())
+	assert.Equal(t, 2, stored.Replicas)
+
+	// A new spec replaces the old one but cannot rename the service
+	w, response := sendJSON(t, r, http.MethodPut, "/api/v1/services/"+service.ID, gin.H{"yaml_config": "name: web\nimage: api:1.3\nport: 80\n"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "name", response["errors"].([]interface{})[0].(map[string]interface{})["field"])
+
+	w, _ = sendJSON(t, r, http.MethodPut, "/api/v1/services/"+service.ID, gin.H{"yaml_config": "name: api\nimage: api:2.0\nport: 80\n"})
+	require.Equal(t, http.StatusOK, w.Code)
+	service, err = db.ServiceRepository().GetByID(service.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "api:2.0", service.Image)
+	assert.Equal(t, 80, service.Port)
+	assert.Equal(t, 1, service.Replicas)
+	assert.Empty(t, service.Environment)
+}
+
+func TestCreateServiceRejectsInvalidSpecs(t *testing.T) {
+	r, db := newServiceSpecTestRouter(t)
+
+	w, response := postJSON(t, r, "/api/v1/services/", gin.H{"yaml_config": "name: api\nimage: api:1.2\nport: 8080\nreplcas: 2\n"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "Invalid service spec", response["error"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"field":   "replcas",
+		"line":    float64(4),
+		"message": `unknown field "replcas", did you mean "replicas"?`,
+	}}, response["errors"])
+
+	// Fields are checked by the same rules
+	w, response = postJSON(t, r, "/api/v1/services/", gin.H{"name": "api", "image": "api:1.2", "port": 99999})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "port", response["errors"].([]interface{})[0].(map[string]interface{})["field"])
+
+	w, response = postJSON(t, r, "/api/v1/services/", gin.H{"name": "api", "image": "api:1.2"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, response["errors"], map[string]interface{}{"field": "port", "message": "is required"})
+
+	w, _ = postJSON(t, r, "/api/v1/services/", gin.H{"name": "api", "yaml_config": "name: api\nimage: api:1.2\nport: 80\n"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	services, err := db.ServiceRepository().List()
+	require.NoError(t, err)
+	assert.Empty(t, services)
+}
+
+func TestValidateServiceSpec(t *testing.T) {
+	r, db := newServiceSpecTestRouter(t)
+
+	validate := func(contentType, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/services/validate", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+
+	code, response := validate("application/yaml", "name: api\nimage: api:1.2\nport: 8080\n")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response["valid"])
+	assert.Equal(t, "api:1.2", response["spec"].(map[string]interface{})["image"])
+
+	code, response = validate("application/json", `{"yaml_config": "name: api\nimage: api:1.2\nport: high\n"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, false, response["valid"])
+	assert.Len(t, response["errors"], 1)
+
+	// Nothing is saved
+	services, err := db.ServiceRepository().List()
+	require.NoError(t, err)
+	assert.Empty(t, services)
+}
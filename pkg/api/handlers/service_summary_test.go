@@ -68,7 +68,7 @@ func TestGetServiceSummary(t *testing.T) {
 		_ = db.Close()
 	})
 
-	handler := NewServiceHandler(db)
+	handler := NewServiceHandler(db, nil)
 	repo := db.ServiceRepository()
 
 	services := []*database.Service{
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// SetupStatus reports whether the console still needs its first admin
+func (h *UserHandler) SetupStatus(c *gin.Context) {
+	admins, err := h.db.WithContext(c.Request.Context()).UserRepository().CountByRole("admin")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check setup status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"admin_exists":         admins > 0,
+		"setup_required":       admins == 0,
+		"registration_enabled": h.auth.RegistrationAllowed(),
+	})
+}
+
+// CreateAdmin creates the first admin account of a fresh install. It is gone
+// for good once any admin exists.
+func (h *UserHandler) CreateAdmin(c *gin.Context) {
+	admins, err := h.db.WithContext(c.Request.Context()).UserRepository().CountByRole("admin")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check setup status"})
+		return
+	}
+	if admins > 0 {
+		c.JSON(http.StatusGone, gin.H{"error": "Setup has already been completed"})
+		return
+	}
+
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if violations := h.auth.ValidatePassword(req.Password, req.Username, req.Email); len(violations) > 0 {
+		respondPasswordViolations(c, violations)
+		return
+	}
+
+	user, err := h.createFirstAdmin(req.Username, req.Email, req.Password)
+	if errors.Is(err, database.ErrAdminExists) {
+		c.JSON(http.StatusGone, gin.H{"error": "Setup has already been completed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username or email already exists"})
+		return
+	}
+	recordAudit(c, auditActionCreate, auditResourceUser, strconv.Itoa(user.ID), gin.H{
+		"username": user.Username,
+		"email":    user.Email,
+		"role":     user.Role,
+		"via":      "setup",
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Admin account created successfully",
+		"user_id":  user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+	})
+}
+
+// BootstrapAdmin creates the first admin at startup, for deploys that cannot
+// go through setup. Nothing is created if an admin already exists. An empty
+// password is replaced by a generated one, which is returned so that it can
+// be shown once.
+func (h *UserHandler) BootstrapAdmin(username, email, password string) (*database.User, string, error) {
+	admins, err := h.db.UserRepository().CountByRole("admin")
+	if err != nil {
+		return nil, "", err
+	}
+	if admins > 0 {
+		return nil, "", nil
+	}
+
+	var generated string
+	if password == "" {
+		if generated, err = h.auth.GeneratePassword(username, email); err != nil {
+			return nil, "", err
+		}
+		password = generated
+	} else if violations := h.auth.ValidatePassword(password, username, email); len(violations) > 0 {
+		return nil, "", fmt.Errorf("admin password does not meet the password policy: %s", violations[0].Message)
+	}
+
+	user, err := h.createFirstAdmin(username, email, password)
+	if errors.Is(err, database.ErrAdminExists) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return user, generated, nil
+}
+
+// createFirstAdmin stores a new admin account unless an admin exists
+func (h *UserHandler) createFirstAdmin(username, email, password string) (*database.User, error) {
+	hashedPassword, err := h.auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &database.User{
+		Username:     username,
+		Email:        email,
+		PasswordHash: hashedPassword,
+		Role:         "admin",
+	}
+	if err := h.db.UserRepository().CreateFirstAdmin(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
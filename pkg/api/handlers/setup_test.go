@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// newSetupTestRouter serves setup, registration and login on a fresh database
+func newSetupTestRouter(t *testing.T, allowRegistration bool) (*gin.Engine, *UserHandler, *database.DB) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth: config.AuthConfig{
+				AllowRegistration: allowRegistration,
+				JWT:               config.JWTConfig{Secret: "test-secret", ExpiresHours: 24},
+			},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	handler := NewUserHandler(authService, db)
+	r := gin.New()
+	r.GET("/api/v1/setup/status", handler.SetupStatus)
+	r.POST("/api/v1/setup/admin", handler.CreateAdmin)
+	r.POST("/api/v1/auth/register", handler.Register)
+	r.POST("/api/v1/auth/login", handler.Login)
+	return r, handler, db
+}
+
+// setupStatus fetches the setup status
+func setupStatus(t *testing.T, r *gin.Engine) map[string]interface{} {
+	w, status := sendJSON(t, r, http.MethodGet, "/api/v1/setup/status", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	return status
+}
+
+func TestSetupFirstAdmin(t *testing.T) {
+	r, _, db := newSetupTestRouter(t, true)
+
+	status := setupStatus(t, r)
+	assert.Equal(t, true, status["setup_required"])
+	assert.Equal(t, false, status["admin_exists"])
+	assert.Equal(t, true, status["registration_enabled"])
+
+	// Registration cannot be used to become an admin
+	w, registered := postJSON(t, r, "/api/v1/auth/register", gin.H{
+		"username": "mallory",
+		"email":    "mallory@example.com",
+		"password": "violet-harbour",
+		"role":     "admin",
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "user", registered["role"])
+	assert.Equal(t, true, setupStatus(t, r)["setup_required"])
+
+	w, response := postJSON(t, r, "/api/v1/setup/admin", gin.H{"username": "root", "email": "root@example.com", "password": "root"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, responseRules(t, response), auth.RulePersonalInfo)
+
+	w, created := postJSON(t, r, "/api/v1/setup/admin", gin.H{"username": "root", "email": "root@example.com", "password": "granite-meadow"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "admin", created["role"])
+
+	w, login := postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "root", "password": "granite-meadow"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "admin", login["role"])
+
+	status = setupStatus(t, r)
+	assert.Equal(t, false, status["setup_required"])
+	assert.Equal(t, true, status["admin_exists"])
+
+	// Setup is gone once an admin exists
+	w, _ = postJSON(t, r, "/api/v1/setup/admin", gin.H{"username": "root2", "email": "root2@example.com", "password": "granite-meadow"})
+	assert.Equal(t, http.StatusGone, w.Code)
+	admins, err := db.UserRepository().CountByRole("admin")
+	require.NoError(t, err)
+	assert.Equal(t, 1, admins)
+}
+
+func TestRegistrationDisabled(t *testing.T) {
+	r, _, db := newSetupTestRouter(t, false)
+	assert.Equal(t, false, setupStatus(t, r)["registration_enabled"])
+
+	w, _ := postJSON(t, r, "/api/v1/auth/register", gin.H{"username": "dave", "email": "dave@example.com", "password": "violet-harbour"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	_, err := db.UserRepository().GetByUsername("dave")
+	assert.Error(t, err)
+
+	// Setup still works without registration
+	w, _ = postJSON(t, r, "/api/v1/setup/admin", gin.H{"username": "root", "email": "root@example.com", "password": "granite-meadow"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	r, handler, _ := newSetupTestRouter(t, false)
+
+	_, _, err := handler.BootstrapAdmin("admin", "admin@localhost", "admin123")
+	assert.Error(t, err, "supplied passwords must meet the password policy")
+
+	user, generated, err := handler.BootstrapAdmin("admin", "admin@localhost", "")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, "admin", user.Role)
+	require.NotEmpty(t, generated)
+
+	w, _ := postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "admin", "password": generated})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Later starts leave the existing admin alone
+	user, generated, err = handler.BootstrapAdmin("admin", "admin@localhost", "another-password")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+	assert.Empty(t, generated)
+	assert.Equal(t, false, setupStatus(t, r)["setup_required"])
+}
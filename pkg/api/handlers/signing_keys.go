@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+)
+
+// JWKS returns the public keys console tokens are verified with, so that
+// other services can verify tokens without being able to issue them
+func (h *UserHandler) JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": h.auth.JWKS()})
+}
+
+// RotateKeys replaces the token signing key. Tokens signed with the previous
+// key stay valid until they expire.
+func (h *UserHandler) RotateKeys(c *gin.Context) {
+	key, retired, err := h.auth.RotateKeys()
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to rotate signing keys", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate signing keys"})
+		return
+	}
+	recordAudit(c, auditActionRotate, auditResourceSigningKey, key.KeyID, gin.H{
+		"algorithm":   key.Algorithm,
+		"retired_kid": retired.KeyID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Signing keys rotated successfully",
+		"kid":         key.KeyID,
+		"algorithm":   key.Algorithm,
+		"retired_kid": retired.KeyID,
+	})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+func TestRotateKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	consoleConfig := &config.ConsoleConfig{
+		Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+		Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+	}
+	authService, err := auth.NewAuth(consoleConfig)
+	require.NoError(t, err)
+	token, _, err := authService.GenerateToken(1, "admin", "admin")
+	require.NoError(t, err)
+
+	handler := NewUserHandler(authService, nil)
+	r := gin.New()
+	r.GET("/api/v1/auth/jwks", handler.JWKS)
+	r.POST("/api/v1/auth/rotate-keys", handler.RotateKeys)
+
+	jwks := func() []auth.JWK {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/auth/jwks", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Keys []auth.JWK `json:"keys"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Keys
+	}
+
+	before := jwks()
+	require.Len(t, before, 1)
+	assert.Equal(t, "RS256", before[0].Algorithm)
+
+	w, rotated := postJSON(t, r, "/api/v1/auth/rotate-keys", gin.H{})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, before[0].KeyID, rotated["retired_kid"])
+	assert.NotEqual(t, before[0].KeyID, rotated["kid"])
+
+	after := jwks()
+	require.Len(t, after, 2, "the retired key is published until its tokens expire")
+	assert.Equal(t, rotated["kid"], after[0].KeyID)
+	assert.Equal(t, before[0], after[1])
+
+	_, err = authService.ValidateToken(token)
+	assert.NoError(t, err, "sessions survive a rotation")
+}
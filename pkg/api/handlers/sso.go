@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -11,6 +13,7 @@ import (
 
 	"github.com/last-emo-boy/infra-core/pkg/auth"
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
 )
 
 // SSOHandler handles SSO-related API endpoints
@@ -39,26 +42,49 @@ type RegisterServiceRequest struct {
 	IsPublic     bool    `json:"is_public"`
 	RequiredRole string  `json:"required_role" binding:"required"`
 	HealthURL    *string `json:"health_url"`
+	ProxyEnabled bool    `json:"proxy_enabled"` // proxy /portal/<proxy_path> to the service for signed in users
+	ProxyPath    *string `json:"proxy_path"`
 }
 
 // ServiceResponse represents service response data
 type ServiceResponse struct {
-	ID           string     `json:"id"`
-	Name         string     `json:"name"`
-	DisplayName  string     `json:"display_name"`
-	Description  *string    `json:"description"`
-	ServiceURL   string     `json:"service_url"`
-	CallbackURL  *string    `json:"callback_url"`
-	Icon         *string    `json:"icon"`
-	Category     string     `json:"category"`
-	IsPublic     bool       `json:"is_public"`
-	RequiredRole string     `json:"required_role"`
-	Status       string     `json:"status"`
-	HealthURL    *string    `json:"health_url"`
-	LastHealthy  *time.Time `json:"last_healthy"`
-	IsHealthy    bool       `json:"is_healthy"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at"`
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	DisplayName   string            `json:"display_name"`
+	Description   *string           `json:"description"`
+	ServiceURL    string            `json:"service_url"`
+	CallbackURL   *string           `json:"callback_url"`
+	Icon          *string           `json:"icon"`
+	Category      string            `json:"category"`
+	IsPublic      bool              `json:"is_public"`
+	RequiredRole  string            `json:"required_role"`
+	Status        string            `json:"status"`
+	HealthURL     *string           `json:"health_url"`
+	LastHealthy   *time.Time        `json:"last_healthy"`
+	IsHealthy     bool              `json:"is_healthy"`
+	FailureStreak int               `json:"failure_streak"`
+	ProxyEnabled  bool              `json:"proxy_enabled"`
+	ProxyPath     *string           `json:"proxy_path"`
+	Maintenance   MaintenanceStatus `json:"maintenance"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// UserServiceResponse is a service as listed for the current user, with the
+// user's preferences for it
+type UserServiceResponse struct {
+	ServiceResponse
+	Pinned       bool       `json:"pinned"`
+	SortOrder    int        `json:"sort_order"`
+	LastAccessed *time.Time `json:"last_accessed"`
+}
+
+// ServiceHealthResponse is the latest health check of a service along with
+// the status and failure streak the health checker derived from its checks
+type ServiceHealthResponse struct {
+	*database.ServiceHealthCheck
+	Status        string `json:"status"`
+	FailureStreak int    `json:"failure_streak"`
 }
 
 // SSOLoginRequest represents SSO login request
@@ -94,6 +120,9 @@ func (h *SSOHandler) RegisterService(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !h.validateProxyPath(c, &req, "") {
+		return
+	}
 
 	// Create service record
 	service := &database.RegisteredService{
@@ -109,41 +138,52 @@ func (h *SSOHandler) RegisterService(c *gin.Context) {
 		RequiredRole: req.RequiredRole,
 		Status:       "active",
 		HealthURL:    req.HealthURL,
+		ProxyEnabled: req.ProxyEnabled,
+		ProxyPath:    req.ProxyPath,
 	}
 
-	repo := h.db.RegisteredServiceRepository()
+	repo := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository()
 	if err := repo.Create(service); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register service"})
 		return
 	}
+	recordAudit(c, auditActionCreate, auditResourceRegisteredService, service.ID, gin.H{
+		"name":        service.Name,
+		"service_url": service.ServiceURL,
+	})
 
-	response := h.convertToServiceResponse(service, false)
+	response := h.convertToServiceResponse(service, false, nil)
 	c.JSON(http.StatusCreated, response)
 }
 
-// ListServices lists all registered services
+// ListServices lists a page of registered services, optionally filtered by
+// category, status or required role
 func (h *SSOHandler) ListServices(c *gin.Context) {
-	repo := h.db.RegisteredServiceRepository()
-	services, err := repo.List()
+	opts, ok := parseListOptions(c, "category", "status", "required_role")
+	if !ok {
+		return
+	}
+
+	repo := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository()
+	services, total, err := repo.ListPaged(opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list services"})
+		respondListError(c, err, "Failed to list services")
 		return
 	}
+	maintenance := h.activeMaintenanceWindows()
 
-	var responses []ServiceResponse
+	responses := []ServiceResponse{}
 	for _, service := range services {
 		isHealthy := h.checkServiceHealth(service.ID)
-		response := h.convertToServiceResponse(service, isHealthy)
+		response := h.convertToServiceResponse(service, isHealthy, maintenance[service.ID])
 		responses = append(responses, response)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"services": responses,
-		"count":    len(responses),
-	})
+	c.JSON(http.StatusOK, pagedResponse(responses, total, opts))
 }
 
-// ListUserServices lists services accessible to the current user
+// ListUserServices lists services accessible to the current user, pinned
+// ones first and then by most recent use
 func (h *SSOHandler) ListUserServices(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -153,14 +193,15 @@ func (h *SSOHandler) ListUserServices(c *gin.Context) {
 
 	userRole, _ := c.Get("role")
 
-	repo := h.db.UserServicePermissionRepository()
+	repo := h.db.WithContext(c.Request.Context()).UserServicePermissionRepository()
 	services, err := repo.ListUserServices(userID.(int))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list user services"})
 		return
 	}
+	maintenance := h.activeMaintenanceWindows()
 
-	var responses []ServiceResponse
+	var responses []UserServiceResponse
 	for _, service := range services {
 		// Check role requirements
 		if !h.auth.RequireRole(userRole.(string), service.RequiredRole) && !service.IsPublic {
@@ -168,8 +209,12 @@ func (h *SSOHandler) ListUserServices(c *gin.Context) {
 		}
 
 		isHealthy := h.checkServiceHealth(service.ID)
-		response := h.convertToServiceResponse(service, isHealthy)
-		responses = append(responses, response)
+		responses = append(responses, UserServiceResponse{
+			ServiceResponse: h.convertToServiceResponse(&service.RegisteredService, isHealthy, maintenance[service.ID]),
+			Pinned:          service.Pinned,
+			SortOrder:       service.SortOrder,
+			LastAccessed:    service.LastAccessed,
+		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -182,15 +227,15 @@ func (h *SSOHandler) ListUserServices(c *gin.Context) {
 func (h *SSOHandler) GetService(c *gin.Context) {
 	serviceID := c.Param("id")
 
-	repo := h.db.RegisteredServiceRepository()
+	repo := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository()
 	service, err := repo.GetByID(serviceID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		respondDBError(c, err, "Service", "get service")
 		return
 	}
 
 	isHealthy := h.checkServiceHealth(service.ID)
-	response := h.convertToServiceResponse(service, isHealthy)
+	response := h.convertToServiceResponse(service, isHealthy, h.activeMaintenance(service.ID))
 	c.JSON(http.StatusOK, response)
 }
 
@@ -204,12 +249,16 @@ func (h *SSOHandler) UpdateService(c *gin.Context) {
 		return
 	}
 
-	repo := h.db.RegisteredServiceRepository()
+	repo := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository()
 	service, err := repo.GetByID(serviceID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
+	if !h.validateProxyPath(c, &req, service.ID) {
 		return
 	}
+	before := auditSnapshot(service)
 
 	// Update service fields
 	service.DisplayName = req.DisplayName
@@ -221,14 +270,17 @@ func (h *SSOHandler) UpdateService(c *gin.Context) {
 	service.IsPublic = req.IsPublic
 	service.RequiredRole = req.RequiredRole
 	service.HealthURL = req.HealthURL
+	service.ProxyEnabled = req.ProxyEnabled
+	service.ProxyPath = req.ProxyPath
 
 	if err := repo.Update(service); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update service"})
+		respondDBError(c, err, "Service", "update service")
 		return
 	}
+	recordAudit(c, auditActionUpdate, auditResourceRegisteredService, service.ID, auditChanges(before, service))
 
 	isHealthy := h.checkServiceHealth(service.ID)
-	response := h.convertToServiceResponse(service, isHealthy)
+	response := h.convertToServiceResponse(service, isHealthy, h.activeMaintenance(service.ID))
 	c.JSON(http.StatusOK, response)
 }
 
@@ -236,11 +288,16 @@ func (h *SSOHandler) UpdateService(c *gin.Context) {
 func (h *SSOHandler) DeleteService(c *gin.Context) {
 	serviceID := c.Param("id")
 
-	repo := h.db.RegisteredServiceRepository()
+	repo := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository()
+	if _, err := repo.GetByID(serviceID); err != nil {
+		respondDBError(c, err, "Service", "get service")
+		return
+	}
 	if err := repo.Delete(serviceID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete service"})
+		respondDBError(c, err, "Service", "delete service")
 		return
 	}
+	recordAudit(c, auditActionDelete, auditResourceRegisteredService, serviceID, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Service deleted successfully"})
 }
@@ -265,10 +322,10 @@ func (h *SSOHandler) InitiateSSO(c *gin.Context) {
 	sessionID, _ := c.Get("session_id")
 
 	// Check if service exists and user has access
-	serviceRepo := h.db.RegisteredServiceRepository()
+	serviceRepo := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository()
 	service, err := serviceRepo.GetByName(req.ServiceName)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		respondDBError(c, err, "Service", "get service")
 		return
 	}
 
@@ -279,7 +336,7 @@ func (h *SSOHandler) InitiateSSO(c *gin.Context) {
 	}
 
 	// Check explicit service permissions
-	permRepo := h.db.UserServicePermissionRepository()
+	permRepo := h.db.WithContext(c.Request.Context()).UserServicePermissionRepository()
 	hasPermission, err := permRepo.CheckPermission(userID.(int), service.ID)
 	if err == nil && !hasPermission && !service.IsPublic {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this service"})
@@ -322,7 +379,76 @@ func (h *SSOHandler) InitiateSSO(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-// ValidateSSO validates an SSO token
+// LaunchService returns a URL that signs the current user in to a service:
+// its callback URL, or its service URL without one, with a single-use SSO
+// token valid for auth.SSOLaunchTTL attached
+func (h *SSOHandler) LaunchService(c *gin.Context) {
+	userID, service, ok := h.accessibleService(c)
+	if !ok {
+		return
+	}
+	username := c.GetString("username")
+	role := c.GetString("role")
+	sessionID := c.GetString("session_id")
+
+	target := service.ServiceURL
+	if service.CallbackURL != nil && *service.CallbackURL != "" {
+		target = *service.CallbackURL
+	}
+	launchURL, err := url.Parse(target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Service has an invalid callback URL"})
+		return
+	}
+
+	ssoToken, expiresAt, err := h.auth.GenerateSSOTokenWithTTL(
+		auth.SSOLaunchTTL,
+		userID,
+		username,
+		role,
+		sessionID,
+		"infra-core",
+		service.Name,
+		target,
+		[]string{}, // permissions
+		[]string{service.Name},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate SSO token"})
+		return
+	}
+
+	launchToken := &database.SSOLaunchToken{
+		TokenHash: h.auth.HashSessionToken(ssoToken),
+		UserID:    userID,
+		ServiceID: service.ID,
+		ExpiresAt: time.Unix(expiresAt, 0),
+	}
+	if sessionID != "" {
+		launchToken.SessionID = &sessionID
+	}
+	db := h.db.WithContext(c.Request.Context())
+	if err := db.SSOLaunchTokenRepository().Create(launchToken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create SSO token"})
+		return
+	}
+	if err := db.UserServicePreferenceRepository().RecordVisit(userID, service.ID, time.Now()); err != nil {
+		logging.FromContext(c.Request.Context()).Warn("failed to record launch", "service", service.Name, "error", err)
+	}
+
+	query := launchURL.Query()
+	query.Set("sso_token", ssoToken)
+	launchURL.RawQuery = query.Encode()
+
+	c.JSON(http.StatusOK, SSOLoginResponse{
+		SSOToken:    ssoToken,
+		RedirectURL: launchURL.String(),
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// ValidateSSO validates an SSO token. Tokens of launch URLs are redeemed, so
+// they are only valid once.
 func (h *SSOHandler) ValidateSSO(c *gin.Context) {
 	token := c.Query("token")
 	if token == "" {
@@ -336,6 +462,17 @@ func (h *SSOHandler) ValidateSSO(c *gin.Context) {
 		return
 	}
 
+	repo := h.db.WithContext(c.Request.Context()).SSOLaunchTokenRepository()
+	_, err = repo.Consume(h.auth.HashSessionToken(token), c.ClientIP(), time.Now())
+	switch {
+	case errors.Is(err, database.ErrSSOLaunchTokenUsed):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "SSO token already used or expired"})
+		return
+	case err != nil && !errors.Is(err, database.ErrSSOLaunchTokenNotFound):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate SSO token"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"valid":      true,
 		"user_id":    claims.UserID,
@@ -350,13 +487,13 @@ func (h *SSOHandler) ValidateSSO(c *gin.Context) {
 func (h *SSOHandler) ListServicePermissions(c *gin.Context) {
 	serviceID := c.Param("id")
 
-	serviceRepo := h.db.RegisteredServiceRepository()
+	serviceRepo := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository()
 	if _, err := serviceRepo.GetByID(serviceID); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		respondDBError(c, err, "Service", "get service")
 		return
 	}
 
-	permRepo := h.db.UserServicePermissionRepository()
+	permRepo := h.db.WithContext(c.Request.Context()).UserServicePermissionRepository()
 	permissions, err := permRepo.ListServicePermissions(serviceID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list service permissions"})
@@ -373,7 +510,7 @@ func (h *SSOHandler) ListServicePermissions(c *gin.Context) {
 
 	grantorUsernames := make(map[int]string)
 	if len(grantorIDs) > 0 {
-		userRepo := h.db.UserRepository()
+		userRepo := h.db.WithContext(c.Request.Context()).UserRepository()
 		for userID := range grantorIDs {
 			if user, err := userRepo.GetByID(userID); err == nil {
 				grantorUsernames[userID] = user.Username
@@ -423,11 +560,12 @@ func (h *SSOHandler) GrantServiceAccess(c *gin.Context) {
 
 	grantedBy, _ := c.Get("user_id")
 
-	repo := h.db.UserServicePermissionRepository()
+	repo := h.db.WithContext(c.Request.Context()).UserServicePermissionRepository()
 	if err := repo.Grant(userID, serviceID, grantedBy.(int), nil); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant service access"})
 		return
 	}
+	recordAudit(c, auditActionGrant, auditResourceServicePermission, serviceID, gin.H{"user_id": userID})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Service access granted successfully"})
 }
@@ -443,11 +581,12 @@ func (h *SSOHandler) RevokeServiceAccess(c *gin.Context) {
 		return
 	}
 
-	repo := h.db.UserServicePermissionRepository()
+	repo := h.db.WithContext(c.Request.Context()).UserServicePermissionRepository()
 	if err := repo.Revoke(userID, serviceID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke service access"})
 		return
 	}
+	recordAudit(c, auditActionRevoke, auditResourceServicePermission, serviceID, gin.H{"user_id": userID})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Service access revoked successfully"})
 }
@@ -456,14 +595,23 @@ func (h *SSOHandler) RevokeServiceAccess(c *gin.Context) {
 func (h *SSOHandler) GetServiceHealth(c *gin.Context) {
 	serviceID := c.Param("id")
 
-	healthRepo := h.db.ServiceHealthCheckRepository()
-	healthCheck, err := healthRepo.GetLatest(serviceID)
+	summary, err := h.db.WithContext(c.Request.Context()).RegisteredServiceRepository().GetServiceHealthSummary(serviceID)
 	if err != nil {
+		respondDBError(c, err, "Service", "get service health")
+		return
+	}
+
+	healthCheck := summary.LatestCheck()
+	if healthCheck == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "No health check data available"})
 		return
 	}
 
-	c.JSON(http.StatusOK, healthCheck)
+	c.JSON(http.StatusOK, ServiceHealthResponse{
+		ServiceHealthCheck: healthCheck,
+		Status:             summary.Status,
+		FailureStreak:      summary.FailureStreak,
+	})
 }
 
 // GetServiceHealthHistory gets the health check history for a service
@@ -477,7 +625,7 @@ func (h *SSOHandler) GetServiceHealthHistory(c *gin.Context) {
 		}
 	}
 
-	healthRepo := h.db.ServiceHealthCheckRepository()
+	healthRepo := h.db.WithContext(c.Request.Context()).ServiceHealthCheckRepository()
 	checks, err := healthRepo.GetHistory(serviceID, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get health check history"})
@@ -492,24 +640,38 @@ func (h *SSOHandler) GetServiceHealthHistory(c *gin.Context) {
 
 // Helper methods
 
-func (h *SSOHandler) convertToServiceResponse(service *database.RegisteredService, isHealthy bool) ServiceResponse {
+// activeMaintenanceWindows returns the active maintenance windows keyed by
+// service ID, or none if they can't be loaded
+func (h *SSOHandler) activeMaintenanceWindows() map[string]*database.MaintenanceWindow {
+	windows, err := h.db.MaintenanceWindowRepository().ListActive(time.Now())
+	if err != nil {
+		return nil
+	}
+	return windows
+}
+
+func (h *SSOHandler) convertToServiceResponse(service *database.RegisteredService, isHealthy bool, window *database.MaintenanceWindow) ServiceResponse {
 	return ServiceResponse{
-		ID:           service.ID,
-		Name:         service.Name,
-		DisplayName:  service.DisplayName,
-		Description:  service.Description,
-		ServiceURL:   service.ServiceURL,
-		CallbackURL:  service.CallbackURL,
-		Icon:         service.Icon,
-		Category:     service.Category,
-		IsPublic:     service.IsPublic,
-		RequiredRole: service.RequiredRole,
-		Status:       service.Status,
-		HealthURL:    service.HealthURL,
-		LastHealthy:  service.LastHealthy,
-		IsHealthy:    isHealthy,
-		CreatedAt:    service.CreatedAt,
-		UpdatedAt:    service.UpdatedAt,
+		ID:            service.ID,
+		Name:          service.Name,
+		DisplayName:   service.DisplayName,
+		Description:   service.Description,
+		ServiceURL:    service.ServiceURL,
+		CallbackURL:   service.CallbackURL,
+		Icon:          service.Icon,
+		Category:      service.Category,
+		IsPublic:      service.IsPublic,
+		RequiredRole:  service.RequiredRole,
+		Status:        service.Status,
+		HealthURL:     service.HealthURL,
+		LastHealthy:   service.LastHealthy,
+		IsHealthy:     isHealthy,
+		FailureStreak: service.FailureStreak,
+		ProxyEnabled:  service.ProxyEnabled,
+		ProxyPath:     service.ProxyPath,
+		Maintenance:   maintenanceStatus(window),
+		CreatedAt:     service.CreatedAt,
+		UpdatedAt:     service.UpdatedAt,
 	}
 }
 
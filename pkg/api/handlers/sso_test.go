@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestLaunchService(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24}},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	user := &database.User{Username: "dev", Email: "dev@example.com", PasswordHash: "x", Role: "user"}
+	require.NoError(t, db.UserRepository().Create(user))
+	session := &database.SSOSession{ID: "session-1", UserID: user.ID, TokenHash: "hash", ExpiresAt: time.Now().Add(time.Hour),
+		IPAddress: "192.0.2.1", UserAgent: "test", IsActive: true}
+	require.NoError(t, db.SSOSessionRepository().Create(session))
+
+	callback := "https://wiki.example.com/sso/callback?next=%2Fhome"
+	require.NoError(t, db.RegisteredServiceRepository().Create(&database.RegisteredService{
+		ID: "wiki", Name: "wiki", DisplayName: "Wiki", ServiceURL: "https://wiki.example.com", CallbackURL: &callback,
+		Category: "web", IsPublic: true, RequiredRole: "user", Status: database.RegisteredServiceActive,
+	}))
+	require.NoError(t, db.RegisteredServiceRepository().Create(&database.RegisteredService{
+		ID: "vault", Name: "vault", DisplayName: "Vault", ServiceURL: "https://vault.example.com",
+		Category: "security", RequiredRole: "admin", Status: database.RegisteredServiceActive,
+	}))
+
+	ssoHandler := NewSSOHandler(authService, db)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("role", user.Role)
+		c.Set("session_id", session.ID)
+	})
+	r.POST("/sso/services/:id/launch", ssoHandler.LaunchService)
+	r.GET("/sso/validate", ssoHandler.ValidateSSO)
+
+	serve := func(method, path string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "198.51.100.7:4321"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+	launch := func() string {
+		code, response := serve(http.MethodPost, "/sso/services/wiki/launch")
+		require.Equal(t, http.StatusOK, code, response)
+		return response["sso_token"].(string)
+	}
+
+	// The launch URL is the callback with a short-lived token attached
+	code, response := serve(http.MethodPost, "/sso/services/wiki/launch")
+	require.Equal(t, http.StatusOK, code)
+	token := response["sso_token"].(string)
+	launchURL, err := url.Parse(response["redirect_url"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "https://wiki.example.com/sso/callback", launchURL.Scheme+"://"+launchURL.Host+launchURL.Path)
+	assert.Equal(t, "/home", launchURL.Query().Get("next"))
+	assert.Equal(t, token, launchURL.Query().Get("sso_token"))
+
+	claims, err := authService.ValidateSSOToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "wiki", claims.TargetService)
+	assert.LessOrEqual(t, claims.ExpiresAt.Sub(claims.IssuedAt.Time), 60*time.Second)
+
+	// Launching counts as a visit
+	preference, err := db.UserServicePreferenceRepository().Get(user.ID, "wiki")
+	require.NoError(t, err)
+	assert.NotNil(t, preference.LastAccessed)
+
+	// The token is redeemed once, recording where in the session
+	code, response = serve(http.MethodGet, "/sso/validate?token="+url.QueryEscape(token))
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, true, response["valid"])
+	assert.Equal(t, "dev", response["username"])
+
+	stored, err := db.SSOSessionRepository().GetByID(session.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.LastHandoffIP)
+	assert.Equal(t, "198.51.100.7", *stored.LastHandoffIP)
+	assert.NotNil(t, stored.LastHandoffAt)
+
+	code, response = serve(http.MethodGet, "/sso/validate?token="+url.QueryEscape(token))
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Contains(t, response["error"], "already used")
+
+	// Nor does it authenticate to the console API, consumed or not
+	api := gin.New()
+	api.GET("/api/v1/users/profile", middleware.AuthMiddleware(authService, db), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	for _, apiToken := range []string{token, launch()} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/profile", nil)
+		req.Header.Set("Authorization", "Bearer "+apiToken)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	// Tokens expire after a minute even if unused
+	expiring := launch()
+	_, err = db.SSOLaunchTokenRepository().Consume(authService.HashSessionToken(expiring), "198.51.100.7", time.Now().Add(2*time.Minute))
+	assert.ErrorIs(t, err, database.ErrSSOLaunchTokenUsed)
+
+	// Users may only launch services they can access
+	code, response = serve(http.MethodPost, "/sso/services/vault/launch")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Contains(t, response["error"], "Access denied")
+	code, _ = serve(http.MethodPost, "/sso/services/missing/launch")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Tokens of the SSO login flow are not single-use
+	loginToken, _, err := authService.GenerateSSOToken(user.ID, user.Username, user.Role, session.ID, "infra-core", "wiki",
+		"https://wiki.example.com", []string{}, []string{"wiki"})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		code, _ = serve(http.MethodGet, "/sso/validate?token="+url.QueryEscape(loginToken))
+		assert.Equal(t, http.StatusOK, code)
+	}
+	code, _ = serve(http.MethodGet, "/sso/validate?token="+strings.Repeat("x", 20))
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
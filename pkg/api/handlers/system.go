@@ -1,19 +1,37 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"runtime"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/config"
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
 )
 
 // SystemHandler handles system-related API endpoints
 type SystemHandler struct {
 	db        *database.DB
+	config    *config.Config
 	startTime time.Time
+
+	dashboardMu sync.Mutex
+	dashboard   gin.H
+	dashboardAt time.Time
+
+	// Clients of the daemons the dashboard reads from, if configured
+	probeClient *client.Probe
+	snapClient  *client.Snap
+
+	// Client of the gate, which renews certificates, if configured
+	gateClient *client.Gate
 }
 
 // NewSystemHandler creates a new SystemHandler
@@ -24,24 +42,16 @@ func NewSystemHandler(db *database.DB) *SystemHandler {
 	}
 }
 
-// HealthCheck returns the health status of the system
-func (h *SystemHandler) HealthCheck(c *gin.Context) {
-	// Check database connectivity
-	if err := h.db.HealthCheck(); err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"status":    "unhealthy",
-			"database":  "disconnected",
-			"timestamp": time.Now().UTC().Format(time.RFC3339),
-		})
-		return
-	}
+// SetConfig sets the configuration served by GetConfig
+func (h *SystemHandler) SetConfig(cfg *config.Config) {
+	h.config = cfg
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":    "healthy",
-		"database":  "connected",
-		"uptime":    time.Since(h.startTime).String(),
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
-	})
+// SetDaemonClients sets the clients the dashboard reads active alerts and
+// the latest snapshots through. Either may be nil to read from the database.
+func (h *SystemHandler) SetDaemonClients(probe *client.Probe, snap *client.Snap) {
+	h.probeClient = probe
+	h.snapClient = snap
 }
 
 // GetSystemInfo returns detailed system information
@@ -50,12 +60,18 @@ func (h *SystemHandler) GetSystemInfo(c *gin.Context) {
 	runtime.ReadMemStats(&m)
 
 	// Get database statistics
-	stats, err := h.db.GetStats()
+	stats, err := h.db.WithContext(c.Request.Context()).GetStats()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get database stats"})
 		return
 	}
 
+	migrations, err := h.db.WithContext(c.Request.Context()).MigrationStatus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get schema version"})
+		return
+	}
+
 	systemInfo := gin.H{
 		"uptime":     time.Since(h.startTime).String(),
 		"version":    "1.0.0",
@@ -69,19 +85,30 @@ func (h *SystemHandler) GetSystemInfo(c *gin.Context) {
 		},
 		"goroutines": runtime.NumGoroutine(),
 		"database":   stats,
-		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		"schema": gin.H{
+			"current_version": migrations.CurrentVersion,
+			"latest_version":  migrations.LatestVersion,
+			"pending":         migrations.Pending,
+		},
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
 	}
 
 	c.JSON(http.StatusOK, systemInfo)
 }
 
-// GetMetrics returns system metrics
+// GetMetrics returns system metrics. With step or agg set it aggregates one
+// metric series into time buckets instead.
 func (h *SystemHandler) GetMetrics(c *gin.Context) {
+	if c.Query("step") != "" || c.Query("agg") != "" {
+		h.getAggregatedMetrics(c)
+		return
+	}
+
 	// Get query parameters
 	service := c.Query("service")
 	limit := c.DefaultQuery("limit", "100")
 
-	repo := h.db.MetricRepository()
+	repo := h.db.WithContext(c.Request.Context()).MetricRepository()
 
 	var metrics []*database.Metric
 	var err error
@@ -107,96 +134,213 @@ func (h *SystemHandler) GetMetrics(c *gin.Context) {
 	})
 }
 
-// GetAuditLogs returns audit logs
-func (h *SystemHandler) GetAuditLogs(c *gin.Context) {
-	// Get query parameters
-	userID := c.Query("user_id")
-	action := c.Query("action")
-	limit := c.DefaultQuery("limit", "50")
+// getAggregatedMetrics aggregates the series named by metric and either
+// service or scope_type and scope_id over an RFC3339 from/to range, which
+// defaults to the last hour
+func (h *SystemHandler) getAggregatedMetrics(c *gin.Context) {
+	scopeType, scopeID := c.Query("scope_type"), c.Query("scope_id")
+	if service := c.Query("service"); service != "" {
+		scopeType, scopeID = "service", service
+	}
+	metricName := c.Query("metric")
+	if scopeType == "" || scopeID == "" || metricName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "metric and either service or scope_type and scope_id are required"})
+		return
+	}
 
-	// TODO: Implement audit log filtering
-	auditLogs := []gin.H{
-		{
-			"id":         1,
-			"user_id":    1,
-			"username":   "admin",
-			"action":     "service.create",
-			"resource":   "hello-service",
-			"details":    gin.H{"image": "nginx:latest"},
-			"timestamp":  time.Now().Add(-1 * time.Hour).UTC().Format(time.RFC3339),
-			"ip_address": "127.0.0.1",
-		},
-		{
-			"id":         2,
-			"user_id":    1,
-			"username":   "admin",
-			"action":     "user.login",
-			"resource":   "",
-			"details":    gin.H{},
-			"timestamp":  time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339),
-			"ip_address": "127.0.0.1",
-		},
+	step, err := time.ParseDuration(c.DefaultQuery("step", "5m"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid step"})
+		return
+	}
+	agg := c.DefaultQuery("agg", database.MetricAggAvg)
+
+	to := time.Now()
+	from := to.Add(-time.Hour)
+	if until, err := parseTimeQuery(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to time, expected RFC3339"})
+		return
+	} else if until != nil {
+		to = *until
+	}
+	if since, err := parseTimeQuery(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from time, expected RFC3339"})
+		return
+	} else if since != nil {
+		from = *since
+	}
+
+	buckets, err := h.db.WithContext(c.Request.Context()).MetricRepository().QueryAggregated(scopeType, scopeID, metricName, from, to, step, agg)
+	if errors.Is(err, database.ErrInvalidMetricQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate metrics"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"audit_logs": auditLogs,
-		"total":      len(auditLogs),
-		"filters": gin.H{
-			"user_id": userID,
-			"action":  action,
-			"limit":   limit,
-		},
+		"buckets":    buckets,
+		"total":      len(buckets),
+		"scope_type": scopeType,
+		"scope_id":   scopeID,
+		"metric":     metricName,
+		"step":       step.String(),
+		"agg":        agg,
+		"from":       from.UTC().Format(time.RFC3339),
+		"to":         to.UTC().Format(time.RFC3339),
 	})
 }
 
-// GetDashboardData returns data for the dashboard
-func (h *SystemHandler) GetDashboardData(c *gin.Context) {
-	// Get service counts
-	serviceRepo := h.db.ServiceRepository()
-	services, err := serviceRepo.List()
+// CreateBackup writes a timestamped backup of the live database
+func (h *SystemHandler) CreateBackup(c *gin.Context) {
+	backup, err := h.db.WithContext(c.Request.Context()).CreateBackup()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch services"})
+		logging.FromContext(c.Request.Context()).Error("failed to back up database", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to back up database"})
 		return
 	}
+	recordAudit(c, auditActionCreate, auditResourceBackup, backup.Name, gin.H{"size_bytes": backup.SizeBytes})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Database backed up successfully",
+		"backup":  backup,
+	})
+}
 
-	serviceCounts := map[string]int{
-		"total":   len(services),
-		"running": 0,
-		"stopped": 0,
-		"error":   0,
+// ListBackups lists database backups with their checksums, newest first
+func (h *SystemHandler) ListBackups(c *gin.Context) {
+	backups, err := h.db.WithContext(c.Request.Context()).ListBackups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list backups"})
+		return
 	}
 
-	for _, service := range services {
-		serviceCounts[service.Status]++
+	c.JSON(http.StatusOK, gin.H{
+		"backups":    backups,
+		"total":      len(backups),
+		"backup_dir": h.db.WithContext(c.Request.Context()).BackupDir(),
+	})
+}
+
+// GetConfig returns the effective configuration, after defaults and
+// environment overrides, with secrets redacted
+func (h *SystemHandler) GetConfig(c *gin.Context) {
+	if h.config == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Configuration is not available"})
+		return
 	}
 
-	// Get user count
-	userRepo := h.db.UserRepository()
-	users, err := userRepo.List()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+	c.JSON(http.StatusOK, gin.H{
+		"environment": h.config.Environment(),
+		"config":      h.config.Redacted(),
+	})
+}
+
+// GetAuditLogs returns audit logs, filtered by user_id, action, resource_type
+// and an RFC3339 since/until time range
+func (h *SystemHandler) GetAuditLogs(c *gin.Context) {
+	limit := c.DefaultQuery("limit", "50")
+
+	if c.Query("type") == "login" {
+		h.getLoginAttempts(c, limit)
 		return
 	}
 
-	// Get recent metrics
-	metricRepo := h.db.MetricRepository()
-	recentMetrics, err := metricRepo.GetRecent(10)
+	filter := database.AuditLogFilter{
+		Action:       c.Query("action"),
+		ResourceType: c.Query("resource_type"),
+	}
+
+	var err error
+	if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	if filter.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0")); err != nil || filter.Offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		id, err := strconv.Atoi(userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		filter.UserID = &id
+	}
+	if filter.Since, err = parseTimeQuery(c, "since"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since time, expected RFC3339"})
+		return
+	}
+	if filter.Until, err = parseTimeQuery(c, "until"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until time, expected RFC3339"})
+		return
+	}
+
+	auditLogs, err := h.db.WithContext(c.Request.Context()).AuditLogRepository().List(filter)
 	if err != nil {
-		recentMetrics = []*database.Metric{} // Empty slice on error
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit logs"})
+		return
+	}
+	if auditLogs == nil {
+		auditLogs = []*database.AuditLog{}
 	}
 
-	dashboardData := gin.H{
-		"services": serviceCounts,
-		"users": gin.H{
-			"total": len(users),
-		},
-		"system": gin.H{
-			"uptime":     time.Since(h.startTime).String(),
-			"goroutines": runtime.NumGoroutine(),
+	c.JSON(http.StatusOK, gin.H{
+		"audit_logs": auditLogs,
+		"total":      len(auditLogs),
+		"filters": gin.H{
+			"user_id":       c.Query("user_id"),
+			"action":        filter.Action,
+			"resource_type": filter.ResourceType,
+			"since":         c.Query("since"),
+			"until":         c.Query("until"),
+			"limit":         filter.Limit,
+			"offset":        filter.Offset,
 		},
-		"recent_metrics": recentMetrics,
-		"timestamp":      time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// parseTimeQuery parses an optional RFC3339 query parameter
+func parseTimeQuery(c *gin.Context, param string) (*time.Time, error) {
+	value := c.Query(param)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// getLoginAttempts returns recent login attempts, optionally for one username
+func (h *SystemHandler) getLoginAttempts(c *gin.Context, limitParam string) {
+	limit, err := strconv.Atoi(limitParam)
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	username := c.Query("username")
+	attempts, err := h.db.WithContext(c.Request.Context()).LoginAttemptRepository().List(username, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch login attempts"})
+		return
+	}
+	if attempts == nil {
+		attempts = []*database.LoginAttempt{}
 	}
 
-	c.JSON(http.StatusOK, dashboardData)
+	c.JSON(http.StatusOK, gin.H{
+		"type":           "login",
+		"login_attempts": attempts,
+		"total":          len(attempts),
+		"filters": gin.H{
+			"username": username,
+			"limit":    limit,
+		},
+	})
 }
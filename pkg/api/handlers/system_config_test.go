@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestGetConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Port:     8082,
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth: config.AuthConfig{
+				JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24},
+			},
+			ServiceHealth: config.ServiceHealthConfig{WebhookURL: "https://hooks.example.com/token"},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	handler := NewSystemHandler(db)
+	r := gin.New()
+	r.GET("/api/v1/system/config", handler.GetConfig)
+
+	w, _ := sendJSON(t, r, http.MethodGet, "/api/v1/system/config", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	handler.SetConfig(cfg)
+	w, response := sendJSON(t, r, http.MethodGet, "/api/v1/system/config", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "test-secret")
+	assert.NotContains(t, w.Body.String(), "hooks.example.com")
+
+	console := response["config"].(map[string]interface{})["console"].(map[string]interface{})
+	assert.Equal(t, float64(8082), console["port"])
+	jwt := console["auth"].(map[string]interface{})["jwt"].(map[string]interface{})
+	assert.Equal(t, "[REDACTED]", jwt["secret"])
+	assert.Equal(t, "test-secret", cfg.Console.Auth.JWT.Secret, "serving the configuration must not redact it in place")
+}
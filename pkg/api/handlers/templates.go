@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+	"github.com/last-emo-boy/infra-core/pkg/services/templates"
+)
+
+// TemplateHandler manages service templates and creates services from them
+type TemplateHandler struct {
+	db           *database.DB
+	orchestrator *client.Orchestrator
+	ports        *orchestrator.PortRegistry
+}
+
+// NewTemplateHandler creates a template handler
+func NewTemplateHandler(db *database.DB) *TemplateHandler {
+	return &TemplateHandler{db: db}
+}
+
+// SetOrchestratorClient sets the client of the orchestrator that services
+// created from templates are deployed through. Without one services can be
+// created but not deployed.
+func (h *TemplateHandler) SetOrchestratorClient(orchestrator *client.Orchestrator) {
+	h.orchestrator = orchestrator
+}
+
+// SetPortRegistry sets the registry that keeps services created from
+// templates off taken ports, allocating one when the template leaves the
+// port out
+func (h *TemplateHandler) SetPortRegistry(ports *orchestrator.PortRegistry) {
+	h.ports = ports
+}
+
+// TemplateRequest creates a service template or replaces one
+type TemplateRequest struct {
+	Name        string               `json:"name" binding:"required"`
+	Description string               `json:"description"`
+	Category    string               `json:"category"`
+	Body        string               `json:"body" binding:"required"` // YAML service spec with {{variable}} placeholders
+	Variables   []templates.Variable `json:"variables"`
+}
+
+// InstantiateTemplateRequest creates a service from a template
+type InstantiateTemplateRequest struct {
+	Values map[string]interface{} `json:"values"`
+	Deploy bool                   `json:"deploy"` // deploy the service through the orchestrator once created
+}
+
+// TemplateResponse is a service template with its variables decoded
+type TemplateResponse struct {
+	*database.ServiceTemplate
+	Variables []templates.Variable `json:"variables"`
+}
+
+// repository returns the service template repository of a request
+func (h *TemplateHandler) repository(c *gin.Context) *database.ServiceTemplateRepository {
+	return h.db.WithContext(c.Request.Context()).ServiceTemplateRepository()
+}
+
+// ListTemplates lists the service templates by category and name, only those
+// of the category query parameter when it is set
+func (h *TemplateHandler) ListTemplates(c *gin.Context) {
+	list, err := h.repository(c).List(c.Query("category"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list templates"})
+		return
+	}
+
+	responses := make([]*TemplateResponse, 0, len(list))
+	for _, template := range list {
+		response, err := newTemplateResponse(template)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list templates"})
+			return
+		}
+		responses = append(responses, response)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"templates": responses,
+		"count":     len(responses),
+	})
+}
+
+// GetTemplate returns a service template
+func (h *TemplateHandler) GetTemplate(c *gin.Context) {
+	template, ok := h.template(c)
+	if !ok {
+		return
+	}
+	response, err := newTemplateResponse(template)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"template": response})
+}
+
+// CreateTemplate creates a service template
+func (h *TemplateHandler) CreateTemplate(c *gin.Context) {
+	var req TemplateRequest
+	if !bindTemplateRequest(c, &req) {
+		return
+	}
+
+	repo := h.repository(c)
+	if !checkTemplateName(c, repo, req.Name, "") {
+		return
+	}
+	variables, err := json.Marshal(req.Variables)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template"})
+		return
+	}
+
+	template := &database.ServiceTemplate{
+		Name:        req.Name,
+		Description: req.Description,
+		Category:    req.Category,
+		Body:        req.Body,
+		Variables:   string(variables),
+	}
+	if err := repo.Create(template); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template"})
+		return
+	}
+	recordAudit(c, auditActionCreate, auditResourceServiceTemplate, template.ID, gin.H{"name": template.Name})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Template created successfully",
+		"template": &TemplateResponse{ServiceTemplate: template, Variables: req.Variables},
+	})
+}
+
+// UpdateTemplate replaces a service template. Built-in templates can't be
+// changed; create a copy instead.
+func (h *TemplateHandler) UpdateTemplate(c *gin.Context) {
+	var req TemplateRequest
+	if !bindTemplateRequest(c, &req) {
+		return
+	}
+
+	template, ok := h.template(c)
+	if !ok {
+		return
+	}
+	if template.Builtin {
+		c.JSON(http.StatusConflict, gin.H{"error": "Built-in templates cannot be changed"})
+		return
+	}
+	repo := h.repository(c)
+	if !checkTemplateName(c, repo, req.Name, template.ID) {
+		return
+	}
+	variables, err := json.Marshal(req.Variables)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update template"})
+		return
+	}
+
+	template.Name = req.Name
+	template.Description = req.Description
+	template.Category = req.Category
+	template.Body = req.Body
+	template.Variables = string(variables)
+	if err := repo.Update(template); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update template"})
+		return
+	}
+	recordAudit(c, auditActionUpdate, auditResourceServiceTemplate, template.ID, gin.H{"name": template.Name})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Template updated successfully",
+		"template": &TemplateResponse{ServiceTemplate: template, Variables: req.Variables},
+	})
+}
+
+// DeleteTemplate deletes a service template. Services created from it are
+// kept; built-in templates can't be deleted.
+func (h *TemplateHandler) DeleteTemplate(c *gin.Context) {
+	template, ok := h.template(c)
+	if !ok {
+		return
+	}
+	if template.Builtin {
+		c.JSON(http.StatusConflict, gin.H{"error": "Built-in templates cannot be deleted"})
+		return
+	}
+
+	err := h.repository(c).Delete(template.ID)
+	if errors.Is(err, database.ErrServiceTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete template"})
+		return
+	}
+	recordAudit(c, auditActionDelete, auditResourceServiceTemplate, template.ID, gin.H{"name": template.Name})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Template deleted successfully"})
+}
+
+// InstantiateTemplate creates a service from a template: the values are
+// checked against the template's variables and substituted into its body,
+// and the resulting spec is validated like that of any new service. With
+// deploy set the service is then deployed through the orchestrator; a
+// failed deployment leaves the service created, reporting the error in
+// deployment_error.
+func (h *TemplateHandler) InstantiateTemplate(c *gin.Context) {
+	var req InstantiateTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Deploy && h.orchestrator == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Orchestrator is not configured"})
+		return
+	}
+
+	template, ok := h.template(c)
+	if !ok {
+		return
+	}
+	var variables []templates.Variable
+	if err := json.Unmarshal([]byte(template.Variables), &variables); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read template variables"})
+		return
+	}
+
+	rendered, problems := templates.Render(template.Body, variables, req.Values)
+	if len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template values", "errors": problems})
+		return
+	}
+	serviceSpec, problems := spec.ParseAndValidate(rendered)
+	if len(problems) == 0 {
+		problems = checkServiceSpec(serviceSpec, nil, h.ports != nil)
+	}
+	if len(problems) > 0 {
+		respondSpecProblems(c, problems)
+		return
+	}
+
+	repo := h.db.WithContext(c.Request.Context()).ServiceRepository()
+	if _, err := repo.GetByName(serviceSpec.Name); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Service %s already exists", serviceSpec.Name)})
+		return
+	}
+
+	service := &database.Service{
+		ID:         uuid.New().String(),
+		Status:     "stopped",
+		YAMLConfig: rendered,
+	}
+	applySpec(service, serviceSpec)
+	if !saveOnPort(c, h.ports, service, serviceSpec, func() error { return repo.Create(service) }, "Failed to create service") {
+		return
+	}
+	recordAudit(c, auditActionCreate, auditResourceService, service.ID, gin.H{
+		"name":     service.Name,
+		"image":    service.Image,
+		"port":     service.Port,
+		"replicas": service.Replicas,
+		"template": template.ID,
+	})
+
+	response := gin.H{
+		"message":    "Service created successfully",
+		"service_id": service.ID,
+		"service":    service,
+	}
+	if req.Deploy {
+		result, err := h.orchestrator.Deploy(c.Request.Context(), &orchestrator.DeployRequest{Spec: service.YAMLConfig})
+		if err != nil {
+			response["deployment_error"] = err.Error()
+		} else {
+			response["deployment"] = result
+			service.Status = "running"
+			if err := repo.Update(service); err != nil {
+				logging.FromContext(c.Request.Context()).Warn("failed to mark service running", "service_id", service.ID, "error", err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// template returns the template of a request's id parameter, responding
+// with an error when it can't be read
+func (h *TemplateHandler) template(c *gin.Context) (*database.ServiceTemplate, bool) {
+	template, err := h.repository(c).GetByID(c.Param("id"))
+	if errors.Is(err, database.ErrServiceTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get template"})
+		return nil, false
+	}
+	return template, true
+}
+
+// bindTemplateRequest binds and checks a template request, responding with
+// every problem of its body and variables
+func bindTemplateRequest(c *gin.Context, req *TemplateRequest) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if len(req.Body) > maxSpecSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Template body is larger than %d bytes", maxSpecSize)})
+		return false
+	}
+	if req.Variables == nil {
+		req.Variables = []templates.Variable{}
+	}
+	if problems := templates.Check(req.Body, req.Variables); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template", "errors": problems})
+		return false
+	}
+	return true
+}
+
+// checkTemplateName rejects a name already used by a template other than id
+func checkTemplateName(c *gin.Context, repo *database.ServiceTemplateRepository, name, id string) bool {
+	existing, err := repo.GetByName(name)
+	if err == nil && existing.ID != id {
+		c.JSON(http.StatusConflict, gin.H{"error": "Template name already exists"})
+		return false
+	}
+	if err != nil && !errors.Is(err, database.ErrServiceTemplateNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check template name"})
+		return false
+	}
+	return true
+}
+
+// newTemplateResponse decodes the variables of a template
+func newTemplateResponse(template *database.ServiceTemplate) (*TemplateResponse, error) {
+	variables := []templates.Variable{}
+	if err := json.Unmarshal([]byte(template.Variables), &variables); err != nil {
+		return nil, err
+	}
+	return &TemplateResponse{ServiceTemplate: template, Variables: variables}, nil
+}
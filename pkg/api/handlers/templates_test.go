@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/client"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+	"github.com/last-emo-boy/infra-core/pkg/services"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+func setupTemplateTest(t *testing.T) (*gin.Engine, *TemplateHandler, *database.DB, *services.AuditLogger) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	admin := &database.User{Username: "admin", Email: "admin@example.com", PasswordHash: "x", Role: "admin"}
+	require.NoError(t, db.UserRepository().Create(admin))
+
+	logger := services.NewAuditLogger(db, 0)
+	logger.Start()
+	t.Cleanup(logger.Stop)
+
+	handler := NewTemplateHandler(db)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("audit_logger", logger)
+		c.Set("user_id", admin.ID)
+		c.Set("role", "admin")
+	})
+	r.GET("/api/v1/templates", handler.ListTemplates)
+	r.GET("/api/v1/templates/:id", handler.GetTemplate)
+	r.POST("/api/v1/templates", handler.CreateTemplate)
+	r.PUT("/api/v1/templates/:id", handler.UpdateTemplate)
+	r.DELETE("/api/v1/templates/:id", handler.DeleteTemplate)
+	r.POST("/api/v1/templates/:id/instantiate", handler.InstantiateTemplate)
+	return r, handler, db, logger
+}
+
+func TestBuiltinTemplates(t *testing.T) {
+	r, _, _, _ := setupTemplateTest(t)
+
+	w, response := sendJSON(t, r, http.MethodGet, "/api/v1/templates", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var ids []string
+	for _, template := range response["templates"].([]interface{}) {
+		template := template.(map[string]interface{})
+		ids = append(ids, template["id"].(string))
+		assert.Equal(t, true, template["builtin"])
+		assert.NotEmpty(t, template["variables"])
+	}
+	assert.Equal(t, []string{"postgres", "redis", "static-site"}, ids)
+
+	w, response = sendJSON(t, r, http.MethodGet, "/api/v1/templates?category=web", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(1), response["count"])
+
+	// Built-in templates are read-only
+	w, _ = sendJSON(t, r, http.MethodDelete, "/api/v1/templates/redis", nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w, _ = sendJSON(t, r, http.MethodPut, "/api/v1/templates/redis", gin.H{"name": "Redis", "body": "name: redis"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestTemplateCRUD(t *testing.T) {
+	r, _, _, _ := setupTemplateTest(t)
+
+	body := "name: \"{{name}}\"\nimage: \"nginx:latest\"\nport: \"{{port}}\"\n"
+	w, response := sendJSON(t, r, http.MethodPost, "/api/v1/templates", gin.H{
+		"name":      "Web",
+		"category":  "web",
+		"body":      body,
+		"variables": []gin.H{{"name": "name", "required": true}, {"name": "port", "type": "integer", "default": 80}},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	template := response["template"].(map[string]interface{})
+	id := template["id"].(string)
+	assert.Equal(t, "string", template["variables"].([]interface{})[0].(map[string]interface{})["type"])
+
+	// Invalid templates are rejected with every problem
+	w, response = sendJSON(t, r, http.MethodPost, "/api/v1/templates", gin.H{
+		"name":      "Broken",
+		"body":      "name: \"{{name}}\"\nport: {{port}}\n",
+		"variables": []gin.H{{"name": "port", "type": "float"}},
+	})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, response["errors"], 3)
+
+	w, _ = sendJSON(t, r, http.MethodPost, "/api/v1/templates", gin.H{"name": "Web", "body": "name: web"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w, response = sendJSON(t, r, http.MethodPut, "/api/v1/templates/"+id, gin.H{"name": "Web server", "body": body,
+		"variables": []gin.H{{"name": "name", "required": true}, {"name": "port", "type": "integer", "default": 8080}}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "Web server", response["template"].(map[string]interface{})["name"])
+
+	w, _ = sendJSON(t, r, http.MethodDelete, "/api/v1/templates/"+id, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = sendJSON(t, r, http.MethodGet, "/api/v1/templates/"+id, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestInstantiateTemplate(t *testing.T) {
+	r, handler, db, logger := setupTemplateTest(t)
+
+	// Values are checked against the variables
+	w, response := sendJSON(t, r, http.MethodPost, "/api/v1/templates/redis/instantiate", gin.H{
+		"values": gin.H{"name": "cache", "appendonly": "maybe", "port": 6379.5},
+	})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, response["errors"], 2)
+
+	// Without an orchestrator the service can be created but not deployed
+	w, _ = sendJSON(t, r, http.MethodPost, "/api/v1/templates/redis/instantiate", gin.H{"values": gin.H{"name": "cache"}, "deploy": true})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w, response = sendJSON(t, r, http.MethodPost, "/api/v1/templates/redis/instantiate", gin.H{
+		"values": gin.H{"name": "cache", "port": 16379, "appendonly": "yes"},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	service, err := db.ServiceRepository().GetByID(response["service_id"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "cache", service.Name)
+	assert.Equal(t, "redis:7-alpine", service.Image)
+	assert.Equal(t, 6379, service.Port)
+	assert.Equal(t, "stopped", service.Status)
+	parsed, problems := spec.ParseAndValidate(service.YAMLConfig)
+	require.Empty(t, problems)
+	assert.Equal(t, []spec.Port{{Internal: 6379, External: 16379}}, parsed.Ports)
+	assert.Equal(t, []string{"redis-server", "--appendonly", "yes", "--maxmemory", "256mb"}, parsed.Command)
+
+	w, _ = sendJSON(t, r, http.MethodPost, "/api/v1/templates/redis/instantiate", gin.H{"values": gin.H{"name": "cache"}})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// With deploy set the rendered spec is sent to the orchestrator
+	var deployed orchestrator.DeployRequest
+	orchestratorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/services/deploy", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&deployed))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(gin.H{"deployment_id": "deploy-1", "revision": 1, "status": "running"})
+	}))
+	defer orchestratorServer.Close()
+	handler.SetOrchestratorClient(client.NewOrchestrator(orchestratorServer.URL, ""))
+
+	w, response = sendJSON(t, r, http.MethodPost, "/api/v1/templates/static-site/instantiate", gin.H{
+		"values": gin.H{"name": "docs", "content_dir": "/srv/docs"},
+		"deploy": true,
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "deploy-1", response["deployment"].(map[string]interface{})["deployment_id"])
+	service, err = db.ServiceRepository().GetByID(response["service_id"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "running", service.Status)
+	assert.Equal(t, service.YAMLConfig, deployed.Spec)
+
+	// A path with a colon would add a volume option, so the pattern rejects it
+	w, _ = sendJSON(t, r, http.MethodPost, "/api/v1/templates/static-site/instantiate", gin.H{
+		"values": gin.H{"name": "evil", "content_dir": "/:/usr/share/nginx/html"},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	logger.Stop()
+	entries, err := db.AuditLogRepository().List(database.AuditLogFilter{Limit: 20})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Contains(t, *entries[0].Details, `"template":`)
+}
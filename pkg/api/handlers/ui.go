@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultUIDir is where the console serves its UI from when
+// console.ui.dir is unset, as the container image installs it
+const DefaultUIDir = "/app/ui/dist"
+
+// Cache policies of the UI. Assets have content hashes in their names, so
+// browsers may keep them forever; index.html refers to the current ones and
+// is revalidated on every load.
+const (
+	uiAssetCacheControl = "public, max-age=31536000, immutable"
+	uiIndexCacheControl = "no-cache"
+)
+
+// uiEncodings are the pre-compressed siblings of UI files, by their
+// extension, in order of preference
+var uiEncodings = []struct {
+	coding string
+	ext    string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// UIHandler serves the console's single page UI from its built dist
+// directory: hashed files under /assets, and index.html for every other
+// page the UI routes itself. Files are served from their .br or .gz
+// sibling when the client accepts that encoding.
+type UIHandler struct {
+	dir string
+}
+
+// NewUIHandler creates a handler serving the UI built into dir, or
+// DefaultUIDir if empty
+func NewUIHandler(dir string) *UIHandler {
+	if dir == "" {
+		dir = DefaultUIDir
+	}
+	return &UIHandler{dir: dir}
+}
+
+// Dir returns the directory the UI is served from
+func (h *UIHandler) Dir() string {
+	return h.dir
+}
+
+// Available reports whether the directory holds a built UI
+func (h *UIHandler) Available() bool {
+	info, err := os.Stat(filepath.Join(h.dir, "index.html"))
+	return err == nil && !info.IsDir()
+}
+
+// Index serves index.html
+func (h *UIHandler) Index(c *gin.Context) {
+	if !h.serveFile(c, "index.html", uiIndexCacheControl) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "UI not found"})
+	}
+}
+
+// Asset serves a file under /assets, which never falls back to index.html
+func (h *UIHandler) Asset(c *gin.Context) {
+	// Cleaned on its own so that it cannot leave the assets
+	name := "assets" + path.Clean("/"+c.Param("filepath"))
+	if !h.serveFile(c, name, uiAssetCacheControl) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+	}
+}
+
+// Fallback serves the files at the root of the UI, such as favicon.ico, and
+// index.html for any other path, which the UI routes itself. API and asset
+// paths that no route matched are not found.
+func (h *UIHandler) Fallback(c *gin.Context) {
+	urlPath := c.Request.URL.Path
+	switch {
+	case strings.HasPrefix(urlPath, "/api/"):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "endpoint_not_found",
+			"path":    urlPath,
+			"message": "The requested API route was not found",
+		})
+		return
+	case strings.HasPrefix(urlPath, "/assets/"):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	case c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "endpoint_not_found",
+			"path":    urlPath,
+			"message": "The requested route was not found",
+		})
+		return
+	}
+
+	if name := path.Clean(urlPath); path.Dir(name) == "/" && name != "/index.html" {
+		if h.serveFile(c, name, uiIndexCacheControl) {
+			return
+		}
+	}
+	h.Index(c)
+}
+
+// serveFile serves a file of the UI directory with a cache policy and
+// reports whether it exists. Conditional and range requests are answered
+// from its ETag and modification time.
+func (h *UIHandler) serveFile(c *gin.Context, name, cacheControl string) bool {
+	name = path.Clean("/" + name)
+	file := filepath.Join(h.dir, filepath.FromSlash(name))
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	served, coding := file, ""
+	accepted := c.GetHeader("Accept-Encoding")
+	for _, encoding := range uiEncodings {
+		if !acceptsEncoding(accepted, encoding.coding) {
+			continue
+		}
+		if compressed, err := os.Stat(file + encoding.ext); err == nil && compressed.Mode().IsRegular() {
+			served, coding, info = file+encoding.ext, encoding.coding, compressed
+			break
+		}
+	}
+
+	f, err := os.Open(served)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := c.Writer.Header()
+	header.Add("Vary", "Accept-Encoding")
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if coding != "" {
+		header.Set("Content-Encoding", coding)
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("Cache-Control", cacheControl)
+	etag := fmt.Sprintf(`"%x-%x`, info.ModTime().UnixNano(), info.Size())
+	if coding != "" {
+		etag += "-" + coding
+	}
+	header.Set("ETag", etag+`"`)
+
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), f)
+	return true
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header accepts a
+// content coding, by name or through *, with a non-zero quality
+func acceptsEncoding(header, coding string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != coding && name != "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.EqualFold(strings.TrimSpace(key), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if name == coding {
+			// An explicit quality overrides the one of *
+			return quality > 0
+		}
+		accepted = quality > 0
+	}
+	return accepted
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupUITest builds a UI directory and routes it as the console does
+func setupUITest(t *testing.T) (*UIHandler, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":              "<!doctype html><title>console</title>",
+		"favicon.ico":             "icon",
+		"assets/app-1a2b3c.js":    "console.log('app')",
+		"assets/app-1a2b3c.js.gz": "gzipped app",
+		"assets/app-1a2b3c.js.br": "brotli app",
+		"assets/app-4d5e6f.css":   "body{}",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	ui := NewUIHandler(dir)
+	r := gin.New()
+	r.GET("/assets/*filepath", ui.Asset)
+	r.HEAD("/assets/*filepath", ui.Asset)
+	r.GET("/", ui.Index)
+	r.GET("/api/v1/health", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "healthy"}) })
+	r.NoRoute(ui.Fallback)
+	return ui, r
+}
+
+// serveUI requests a path of the UI with the given headers
+func serveUI(r *gin.Engine, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestUIHandlerCacheHeaders(t *testing.T) {
+	ui, r := setupUITest(t)
+	assert.True(t, ui.Available())
+	assert.False(t, NewUIHandler(t.TempDir()).Available())
+	assert.Equal(t, DefaultUIDir, NewUIHandler("").Dir())
+
+	// Hashed assets are cached for good
+	w := serveUI(r, http.MethodGet, "/assets/app-4d5e6f.css", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "body{}", w.Body.String())
+	assert.Equal(t, "text/css; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// Conditional requests with the current ETag are not modified
+	w = serveUI(r, http.MethodGet, "/assets/app-4d5e6f.css", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	w = serveUI(r, http.MethodGet, "/assets/app-4d5e6f.css", map[string]string{"If-None-Match": `"stale"`})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The index is revalidated, wherever the UI routes to
+	for _, path := range []string{"/", "/services/web", "/index.html"} {
+		w = serveUI(r, http.MethodGet, path, nil)
+		require.Equal(t, http.StatusOK, w.Code, path)
+		assert.Contains(t, w.Body.String(), "<title>console</title>", path)
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"), path)
+		assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"), path)
+	}
+
+	// Files at the root of the UI are served as they are
+	w = serveUI(r, http.MethodGet, "/favicon.ico", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "icon", w.Body.String())
+}
+
+func TestUIHandlerEncodingNegotiation(t *testing.T) {
+	_, r := setupUITest(t)
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+		body           string
+	}{
+		{"", "", "console.log('app')"},
+		{"gzip", "gzip", "gzipped app"},
+		{"gzip, deflate, br", "br", "brotli app"},
+		{"br;q=0, gzip;q=0.5", "gzip", "gzipped app"},
+		{"*", "br", "brotli app"},
+		{"*, br;q=0", "gzip", "gzipped app"},
+		{"identity", "", "console.log('app')"},
+	}
+	etags := make(map[string]string)
+	for _, tt := range tests {
+		w := serveUI(r, http.MethodGet, "/assets/app-1a2b3c.js", map[string]string{"Accept-Encoding": tt.acceptEncoding})
+		require.Equal(t, http.StatusOK, w.Code, tt.acceptEncoding)
+		assert.Equal(t, tt.body, w.Body.String(), tt.acceptEncoding)
+		assert.Equal(t, tt.encoding, w.Header().Get("Content-Encoding"), tt.acceptEncoding)
+		assert.Equal(t, "text/javascript; charset=utf-8", w.Header().Get("Content-Type"), tt.acceptEncoding)
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"), tt.acceptEncoding)
+		etags[tt.encoding] = w.Header().Get("ETag")
+	}
+	assert.Len(t, etags, 3, "each encoding has its own ETag")
+
+	// Files without compressed siblings are served as they are
+	w := serveUI(r, http.MethodGet, "/assets/app-4d5e6f.css", map[string]string{"Accept-Encoding": "gzip, br"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "body{}", w.Body.String())
+}
+
+func TestUIHandlerNotFound(t *testing.T) {
+	_, r := setupUITest(t)
+
+	// Missing assets are not found rather than the index
+	for _, path := range []string{"/assets/app-000000.js", "/assets/", "/assets/../index.html"} {
+		w := serveUI(r, http.MethodGet, path, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+		assert.NotContains(t, w.Body.String(), "<title>console</title>", path)
+	}
+
+	// So are unknown API routes
+	w := serveUI(r, http.MethodGet, "/api/v1/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "endpoint_not_found")
+	w = serveUI(r, http.MethodGet, "/api/v1/health", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Paths cannot leave the UI directory
+	w = serveUI(r, http.MethodGet, "/../../etc/passwd", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<title>console</title>")
+
+	// HEAD requests of assets have headers only
+	w = serveUI(r, http.MethodHead, "/assets/app-4d5e6f.css", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "6", w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Body.String())
+}
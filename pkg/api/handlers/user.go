@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -10,41 +10,55 @@ import (
 
 	"github.com/last-emo-boy/infra-core/pkg/auth"
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
 )
 
 // UserHandler handles user-related API endpoints
 type UserHandler struct {
-	auth *auth.Auth
-	db   *database.DB
+	auth        *auth.Auth
+	db          *database.DB
+	resetSender auth.ResetSender
 }
 
-// NewUserHandler creates a new UserHandler
+// NewUserHandler creates a new UserHandler. Password reset links are logged
+// until a sender is set with SetResetSender.
 func NewUserHandler(auth *auth.Auth, db *database.DB) *UserHandler {
 	return &UserHandler{
-		auth: auth,
-		db:   db,
+		auth:        auth,
+		db:          db,
+		resetSender: defaultResetSender,
 	}
 }
 
+// SetResetSender sets how password reset links are delivered
+func (h *UserHandler) SetResetSender(sender auth.ResetSender) {
+	h.resetSender = sender
+}
+
 // RegisterRequest represents user registration data
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
-	Role     string `json:"role,omitempty"`
+	Password string `json:"password" binding:"required"` // checked against the password policy
 }
 
-// Register creates a new user account
+// Register creates a new user account. Registered users always get the user
+// role, admins are created through setup or promoted by another admin.
 func (h *UserHandler) Register(c *gin.Context) {
+	if !h.auth.RegistrationAllowed() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is disabled"})
+		return
+	}
+
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Default role is 'user'
-	if req.Role == "" {
-		req.Role = "user"
+	if violations := h.auth.ValidatePassword(req.Password, req.Username, req.Email); len(violations) > 0 {
+		respondPasswordViolations(c, violations)
+		return
 	}
 
 	// Hash password
@@ -59,14 +73,19 @@ func (h *UserHandler) Register(c *gin.Context) {
 		Username:     req.Username,
 		Email:        req.Email,
 		PasswordHash: hashedPassword,
-		Role:         req.Role,
+		Role:         "user",
 	}
 
-	repo := h.db.UserRepository()
+	repo := h.db.WithContext(c.Request.Context()).UserRepository()
 	if err := repo.Create(user); err != nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "Username or email already exists"})
 		return
 	}
+	recordAudit(c, auditActionCreate, auditResourceUser, strconv.Itoa(user.ID), gin.H{
+		"username": user.Username,
+		"email":    user.Email,
+		"role":     user.Role,
+	})
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message":  "User created successfully",
@@ -84,20 +103,53 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// Refuse locked accounts before checking credentials
+	if lockedUntil, locked := h.lockedUntil(c, req.Username); locked {
+		h.recordLoginAttempt(c, req.Username, false, true)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(lockedUntil).Seconds()))))
+		c.JSON(http.StatusLocked, gin.H{
+			"error":        "Account locked after too many failed login attempts",
+			"locked_until": lockedUntil.UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
 	// Find user by username
-	repo := h.db.UserRepository()
+	repo := h.db.WithContext(c.Request.Context()).UserRepository()
 	user, err := repo.GetByUsername(req.Username)
 	if err != nil {
+		h.recordLoginAttempt(c, req.Username, false, false)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	// Check password
 	if err := h.auth.CheckPassword(req.Password, user.PasswordHash); err != nil {
+		h.recordLoginAttempt(c, req.Username, false, false)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	// Users with two-factor login must also present a current TOTP code. Asking
+	// for the code is a challenge rather than a failed attempt.
+	if user.TOTPEnabled {
+		if req.TOTPCode == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":         "Two-factor code required",
+				"totp_required": true,
+			})
+			return
+		}
+		if user.TOTPSecret == nil || !h.auth.ValidateTOTPCode(*user.TOTPSecret, req.TOTPCode, time.Now()) {
+			h.recordLoginAttempt(c, req.Username, false, false)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":         "Invalid two-factor code",
+				"totp_required": true,
+			})
+			return
+		}
+	}
+
 	// Create SSO session
 	_, sessionHash, err := h.auth.GenerateSessionToken()
 	if err != nil {
@@ -116,17 +168,17 @@ func (h *UserHandler) Login(c *gin.Context) {
 		LastUsed:  time.Now(),
 	}
 
-	sessionRepo := h.db.SSOSessionRepository()
+	sessionRepo := h.db.WithContext(c.Request.Context()).SSOSessionRepository()
 	if err := sessionRepo.Create(ssoSession); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
 	// Get user services for token
-	permRepo := h.db.UserServicePermissionRepository()
+	permRepo := h.db.WithContext(c.Request.Context()).UserServicePermissionRepository()
 	userServices, err := permRepo.ListUserServices(user.ID)
 	if err != nil {
-		userServices = []*database.RegisteredService{} // Empty on error
+		userServices = []*database.UserService{} // Empty on error
 	}
 
 	services := make([]string, len(userServices))
@@ -151,8 +203,14 @@ func (h *UserHandler) Login(c *gin.Context) {
 	// Update last login
 	if err := repo.UpdateLastLogin(user.ID); err != nil {
 		// Log error but don't fail the login
-		fmt.Printf("Failed to update last login for user %d: %v\n", user.ID, err)
+		logging.FromContext(c.Request.Context()).Warn("failed to update last login", "user_id", user.ID, "error", err)
+	}
+
+	// A successful login resets the failure count
+	if _, err := h.db.WithContext(c.Request.Context()).LoginAttemptRepository().Clear(user.Username); err != nil {
+		logging.FromContext(c.Request.Context()).Warn("failed to clear failed logins", "user_id", user.ID, "error", err)
 	}
+	h.recordLoginAttempt(c, user.Username, true, false)
 
 	response := auth.LoginResponse{
 		Token:     token,
@@ -165,42 +223,218 @@ func (h *UserHandler) Login(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-// GetProfile returns the current user's profile
+// respondPasswordViolations rejects a password that breaks the password policy,
+// listing each rule so clients can show specific errors
+func respondPasswordViolations(c *gin.Context, violations []auth.PasswordViolation) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":      "Password does not meet the password policy",
+		"violations": violations,
+	})
+}
+
+// lockedUntil reports whether a username is locked out by recent failed
+// logins, and when the lock expires
+func (h *UserHandler) lockedUntil(c *gin.Context, username string) (time.Time, bool) {
+	maxAttempts, window := h.auth.LockoutPolicy()
+
+	failures, err := h.db.WithContext(c.Request.Context()).LoginAttemptRepository().RecentFailures(username, time.Now().Add(-window))
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to check failed logins", "username", username, "error", err)
+		return time.Time{}, false
+	}
+	if len(failures) < maxAttempts {
+		return time.Time{}, false
+	}
+
+	// The lock lifts once the oldest failure needed to reach the limit leaves the window
+	return failures[maxAttempts-1].Add(window), true
+}
+
+// recordLoginAttempt stores a login attempt, logging rather than failing the request on error
+func (h *UserHandler) recordLoginAttempt(c *gin.Context, username string, success, locked bool) {
+	attempt := &database.LoginAttempt{
+		Username:  username,
+		IPAddress: c.ClientIP(),
+		Success:   success,
+		Locked:    locked,
+	}
+	if err := h.db.WithContext(c.Request.Context()).LoginAttemptRepository().Record(attempt); err != nil {
+		logging.FromContext(c.Request.Context()).Warn("failed to record login attempt", "username", username, "error", err)
+	}
+}
+
+// GetProfile returns the current user's profile, and whether an admin is
+// impersonating them
 func (h *UserHandler) GetProfile(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	user, ok := h.currentUser(c)
+	if !ok {
 		return
 	}
 
-	repo := h.db.UserRepository()
-	user, err := repo.GetByID(userID.(int))
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":       user.ID,
+		"username":      user.Username,
+		"email":         user.Email,
+		"display_name":  user.DisplayName,
+		"role":          user.Role,
+		"created_at":    user.CreatedAt,
+		"last_login":    user.LastLogin,
+		"totp_enabled":  user.TOTPEnabled,
+		"impersonation": impersonationState(c),
+	})
+}
+
+// TOTPCodeRequest carries a TOTP code from the user's authenticator app
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// SetupTOTP generates a new TOTP secret for the current user. The secret stays
+// pending, and login is unaffected, until it is confirmed with ConfirmTOTP.
+func (h *UserHandler) SetupTOTP(c *gin.Context) {
+	if rejectImpersonation(c, "change two-factor authentication") {
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := h.auth.GenerateTOTPSecret()
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	if err := h.db.WithContext(c.Request.Context()).UserRepository().UpdateTOTP(user.ID, &secret, false); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store secret"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"user_id":    user.ID,
-		"username":   user.Username,
-		"email":      user.Email,
-		"role":       user.Role,
-		"created_at": user.CreatedAt,
-		"last_login": user.LastLogin,
+		"secret":      secret,
+		"otpauth_url": h.auth.TOTPURL(secret, user.Username),
 	})
 }
 
-// ListUsers returns a list of all users (admin only)
+// ConfirmTOTP verifies a code against the pending secret and enables two-factor login
+func (h *UserHandler) ConfirmTOTP(c *gin.Context) {
+	if rejectImpersonation(c, "change two-factor authentication") {
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor setup has not been started"})
+		return
+	}
+
+	if !h.auth.ValidateTOTPCode(*user.TOTPSecret, req.Code, time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	if err := h.db.WithContext(c.Request.Context()).UserRepository().UpdateTOTP(user.ID, user.TOTPSecret, true); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+	recordAudit(c, auditActionUpdate, auditResourceUser, strconv.Itoa(user.ID), gin.H{
+		"totp_enabled": gin.H{"from": false, "to": true},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled"})
+}
+
+// DisableTOTP turns off two-factor login after verifying a current code
+func (h *UserHandler) DisableTOTP(c *gin.Context) {
+	if rejectImpersonation(c, "change two-factor authentication") {
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !user.TOTPEnabled || user.TOTPSecret == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	if !h.auth.ValidateTOTPCode(*user.TOTPSecret, req.Code, time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	if err := h.db.WithContext(c.Request.Context()).UserRepository().UpdateTOTP(user.ID, nil, false); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+	recordAudit(c, auditActionUpdate, auditResourceUser, strconv.Itoa(user.ID), gin.H{
+		"totp_enabled": gin.H{"from": true, "to": false},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// currentUser loads the authenticated user, writing an error response on failure
+func (h *UserHandler) currentUser(c *gin.Context) (*database.User, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	user, err := h.db.WithContext(c.Request.Context()).UserRepository().GetByID(userID.(int))
+	if err != nil {
+		respondDBError(c, err, "User", "get user")
+		return nil, false
+	}
+	return user, true
+}
+
+// ListUsers returns a page of users, optionally filtered by role (admin only)
 func (h *UserHandler) ListUsers(c *gin.Context) {
-	repo := h.db.UserRepository()
-	users, err := repo.List()
+	opts, ok := parseListOptions(c, "role")
+	if !ok {
+		return
+	}
+
+	repo := h.db.WithContext(c.Request.Context()).UserRepository()
+	users, total, err := repo.ListPaged(opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		respondListError(c, err, "Failed to fetch users")
 		return
 	}
 
 	// Remove sensitive data
-	var userList []gin.H
+	userList := []gin.H{}
 	for _, user := range users {
 		userList = append(userList, gin.H{
 			"user_id":    user.ID,
@@ -212,10 +446,7 @@ func (h *UserHandler) ListUsers(c *gin.Context) {
 		})
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"users": userList,
-		"total": len(userList),
-	})
+	c.JSON(http.StatusOK, pagedResponse(userList, total, opts))
 }
 
 // UpdateUser updates user information (admin or self)
@@ -237,8 +468,9 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 	}
 
 	var req struct {
-		Email string `json:"email,omitempty"`
-		Role  string `json:"role,omitempty"`
+		Email    string `json:"email,omitempty"`
+		Role     string `json:"role,omitempty"`
+		Password string `json:"password,omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -246,12 +478,13 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
-	repo := h.db.UserRepository()
+	repo := h.db.WithContext(c.Request.Context()).UserRepository()
 	user, err := repo.GetByID(targetUserID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		respondDBError(c, err, "User", "get user")
 		return
 	}
+	before := auditSnapshot(user)
 
 	// Update fields
 	if req.Email != "" {
@@ -263,10 +496,29 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		user.Role = req.Role
 	}
 
+	if req.Password != "" {
+		if violations := h.auth.ValidatePassword(req.Password, user.Username, user.Email); len(violations) > 0 {
+			respondPasswordViolations(c, violations)
+			return
+		}
+
+		hashedPassword, err := h.auth.HashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password"})
+			return
+		}
+		user.PasswordHash = hashedPassword
+	}
+
 	if err := repo.Update(user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		respondDBError(c, err, "User", "update user")
 		return
 	}
+	changes := auditChanges(before, user)
+	if req.Password != "" {
+		changes["password"] = gin.H{"changed": true}
+	}
+	recordAudit(c, auditActionUpdate, auditResourceUser, strconv.Itoa(user.ID), changes)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "User updated successfully",
@@ -277,6 +529,38 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 	})
 }
 
+// UnlockUser clears a user's failed logins so a lockout no longer applies (admin only)
+func (h *UserHandler) UnlockUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := h.db.WithContext(c.Request.Context()).UserRepository().GetByID(userID)
+	if err != nil {
+		respondDBError(c, err, "User", "get user")
+		return
+	}
+
+	cleared, err := h.db.WithContext(c.Request.Context()).LoginAttemptRepository().Clear(user.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+	recordAudit(c, auditActionUpdate, auditResourceUser, strconv.Itoa(user.ID), gin.H{
+		"unlocked":         true,
+		"cleared_failures": cleared,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "User unlocked successfully",
+		"user_id":          user.ID,
+		"username":         user.Username,
+		"cleared_failures": cleared,
+	})
+}
+
 // DeleteUser deletes a user account (admin only)
 func (h *UserHandler) DeleteUser(c *gin.Context) {
 	userIDParam := c.Param("id")
@@ -286,11 +570,12 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	repo := h.db.UserRepository()
+	repo := h.db.WithContext(c.Request.Context()).UserRepository()
 	if err := repo.Delete(userID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		respondDBError(c, err, "User", "delete user")
 		return
 	}
+	recordAudit(c, auditActionDelete, auditResourceUser, strconv.Itoa(userID), nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
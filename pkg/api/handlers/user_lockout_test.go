@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestLoginLockout(t *testing.T) {
+	r, _, user := newUserTestRouter(t, config.LockoutConfig{MaxAttempts: 3, Window: "10m"})
+	wrong := gin.H{"username": "alice", "password": "wrong-password"}
+	right := gin.H{"username": "alice", "password": "password123"}
+
+	for i := 0; i < 3; i++ {
+		w, _ := postJSON(t, r, "/api/v1/auth/login", wrong)
+		require.Equal(t, http.StatusUnauthorized, w.Code, "attempt %d", i+1)
+	}
+
+	// Once locked even the right password is refused until the window passes
+	w, locked := postJSON(t, r, "/api/v1/auth/login", right)
+	require.Equal(t, http.StatusLocked, w.Code)
+	lockedUntil, err := time.Parse(time.RFC3339, locked["locked_until"].(string))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), lockedUntil, 5*time.Second)
+
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 600, retryAfter, 5)
+
+	// Attempts while locked are refused without extending the lock
+	w, again := postJSON(t, r, "/api/v1/auth/login", right)
+	require.Equal(t, http.StatusLocked, w.Code)
+	assert.Equal(t, locked["locked_until"], again["locked_until"])
+
+	// An admin unlock clears the lock for known users
+	w, _ = postJSON(t, r, "/api/v1/users/999/unlock", gin.H{})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, unlocked := postJSON(t, r, fmt.Sprintf("/api/v1/users/%d/unlock", user.ID), gin.H{})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(5), unlocked["cleared_failures"])
+
+	w, _ = postJSON(t, r, "/api/v1/auth/login", right)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLoginSuccessResetsFailures(t *testing.T) {
+	r, _, _ := newUserTestRouter(t, config.LockoutConfig{MaxAttempts: 3, Window: "10m"})
+	wrong := gin.H{"username": "alice", "password": "wrong-password"}
+	right := gin.H{"username": "alice", "password": "password123"}
+
+	for _, body := range []gin.H{wrong, wrong, right, wrong, wrong, right} {
+		w, _ := postJSON(t, r, "/api/v1/auth/login", body)
+		require.NotEqual(t, http.StatusLocked, w.Code)
+	}
+}
+
+func TestLoginLockoutWindowExpires(t *testing.T) {
+	r, db, _ := newUserTestRouter(t, config.LockoutConfig{MaxAttempts: 3, Window: "10m"})
+	repo := db.LoginAttemptRepository()
+
+	// Failures older than the window no longer count
+	for i := 0; i < 3; i++ {
+		require.NoError(t, repo.Record(&database.LoginAttempt{Username: "alice", CreatedAt: time.Now().Add(-11 * time.Minute)}))
+	}
+	w, _ := postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "password123"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Lockout applies to usernames that do not exist, so probing reveals nothing
+	for i := 0; i < 3; i++ {
+		require.NoError(t, repo.Record(&database.LoginAttempt{Username: "mallory", CreatedAt: time.Now().Add(-time.Minute)}))
+	}
+	w, _ = postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "mallory", "password": "anything"})
+	assert.Equal(t, http.StatusLocked, w.Code)
+}
+
+func TestLoginAttemptAudit(t *testing.T) {
+	r, _, _ := newUserTestRouter(t, config.LockoutConfig{})
+
+	postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "wrong-password"})
+	postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "password123"})
+	postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "bob", "password": "password123"})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/system/audit?type=login&username=alice", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Attempts []database.LoginAttempt `json:"login_attempts"`
+		Total    int                     `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, 2, response.Total)
+	assert.True(t, response.Attempts[0].Success)
+	assert.False(t, response.Attempts[1].Success)
+	assert.True(t, response.Attempts[1].Cleared)
+	assert.Equal(t, "192.0.2.1", response.Attempts[0].IPAddress)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/system/audit?type=login&limit=none", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// responseRules returns the rules listed in a password policy error response
+func responseRules(t *testing.T, response map[string]interface{}) []string {
+	violations, ok := response["violations"].([]interface{})
+	require.True(t, ok, "response has no violations: %v", response)
+
+	var rules []string
+	for _, v := range violations {
+		violation := v.(map[string]interface{})
+		assert.NotEmpty(t, violation["message"])
+		rules = append(rules, violation["rule"].(string))
+	}
+	return rules
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	r, _, _ := newUserTestRouter(t, config.LockoutConfig{})
+
+	w, response := postJSON(t, r, "/api/v1/auth/register", gin.H{"username": "dave", "email": "dave@example.com", "password": "a"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{auth.RuleMinLength}, responseRules(t, response))
+
+	w, response = postJSON(t, r, "/api/v1/auth/register", gin.H{"username": "dave", "email": "dave@example.com", "password": "dave-rocks"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{auth.RulePersonalInfo}, responseRules(t, response))
+
+	w, _ = postJSON(t, r, "/api/v1/auth/register", gin.H{"username": "dave", "email": "dave@example.com", "password": "violet-harbour"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestUpdateUserPassword(t *testing.T) {
+	r, _, user := newUserTestRouter(t, config.LockoutConfig{})
+	path := fmt.Sprintf("/api/v1/users/%d", user.ID)
+
+	w, response := sendJSON(t, r, http.MethodPut, path, gin.H{"password": "alice123"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{auth.RulePersonalInfo}, responseRules(t, response))
+
+	// A rejected password leaves the old one in place
+	w, _ = postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "password123"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w, _ = sendJSON(t, r, http.MethodPut, path, gin.H{"password": "granite-meadow"})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w, _ = postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "granite-meadow"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Updates without a password keep the current one
+	w, _ = sendJSON(t, r, http.MethodPut, path, gin.H{"email": "alice@example.org"})
+	require.Equal(t, http.StatusOK, w.Code)
+	w, _ = postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "granite-meadow"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// newUserTestRouter serves the login, 2FA and admin endpoints for a single
+// user, standing in for the auth middleware by setting that user's ID
+func newUserTestRouter(t *testing.T, lockout config.LockoutConfig) (*gin.Engine, *database.DB, *database.User) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth: config.AuthConfig{
+				AllowRegistration: true,
+				JWT:               config.JWTConfig{Secret: "test-secret", ExpiresHours: 24},
+				Lockout:           lockout,
+			},
+		},
+	}
+
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	hash, err := authService.HashPassword("password123")
+	require.NoError(t, err)
+	user := &database.User{Username: "alice", Email: "alice@example.com", PasswordHash: hash, Role: "user"}
+	require.NoError(t, db.UserRepository().Create(user))
+
+	handler := NewUserHandler(authService, db)
+	r := gin.New()
+	r.POST("/api/v1/auth/register", handler.Register)
+	r.POST("/api/v1/auth/login", handler.Login)
+
+	users := r.Group("/api/v1/users", func(c *gin.Context) { c.Set("user_id", user.ID) })
+	users.GET("/profile", handler.GetProfile)
+	users.POST("/2fa/setup", handler.SetupTOTP)
+	users.POST("/2fa/confirm", handler.ConfirmTOTP)
+	users.POST("/2fa/disable", handler.DisableTOTP)
+	users.POST("/api-keys", handler.CreateAPIKey)
+	users.GET("/api-keys", handler.ListAPIKeys)
+	users.DELETE("/api-keys/:key_id", handler.RevokeAPIKey)
+	users.PUT("/:id", handler.UpdateUser)
+	users.POST("/:id/unlock", handler.UnlockUser)
+	r.GET("/api/v1/system/audit", NewSystemHandler(db).GetAuditLogs)
+
+	return r, db, user
+}
+
+func postJSON(t *testing.T, r *gin.Engine, path string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return sendJSON(t, r, http.MethodPost, path, body)
+}
+
+func sendJSON(t *testing.T, r *gin.Engine, method, path string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	payload, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(payload)))
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w, response
+}
+
+func currentCode(t *testing.T, secret string, offset time.Duration) string {
+	code, err := auth.TOTPCode(secret, time.Now().Add(offset))
+	require.NoError(t, err)
+	return code
+}
+
+func TestTOTPEnrollmentAndLogin(t *testing.T) {
+	r, db, user := newUserTestRouter(t, config.LockoutConfig{})
+	credentials := gin.H{"username": "alice", "password": "password123"}
+
+	// Setup issues a pending secret without affecting login
+	w, setup := postJSON(t, r, "/api/v1/users/2fa/setup", gin.H{})
+	require.Equal(t, http.StatusOK, w.Code)
+	secret := setup["secret"].(string)
+	assert.Contains(t, setup["otpauth_url"], "otpauth://totp/Infra-Core:alice?")
+	assert.Contains(t, setup["otpauth_url"], "secret="+secret)
+
+	stored, err := db.UserRepository().GetByID(user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.TOTPSecret)
+	assert.False(t, stored.TOTPEnabled)
+
+	w, _ = postJSON(t, r, "/api/v1/auth/login", credentials)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A wrong code does not confirm the secret
+	w, _ = postJSON(t, r, "/api/v1/users/2fa/confirm", gin.H{"code": "000000"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, _ = postJSON(t, r, "/api/v1/users/2fa/confirm", gin.H{"code": currentCode(t, secret, 0)})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w, _ = postJSON(t, r, "/api/v1/users/2fa/setup", gin.H{})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Login now needs a code alongside the password
+	w, challenge := postJSON(t, r, "/api/v1/auth/login", credentials)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, true, challenge["totp_required"])
+	assert.Nil(t, challenge["token"])
+
+	w, _ = postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "password123", "totp_code": "000000"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, _ = postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "wrong", "totp_code": currentCode(t, secret, 0)})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, login := postJSON(t, r, "/api/v1/auth/login", gin.H{"username": "alice", "password": "password123", "totp_code": currentCode(t, secret, -30*time.Second)})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, login["token"])
+
+	profile := httptest.NewRecorder()
+	r.ServeHTTP(profile, httptest.NewRequest(http.MethodGet, "/api/v1/users/profile", nil))
+	assert.Contains(t, profile.Body.String(), `"totp_enabled":true`)
+
+	// Disabling requires a valid code and clears the secret
+	w, _ = postJSON(t, r, "/api/v1/users/2fa/disable", gin.H{"code": "000000"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, _ = postJSON(t, r, "/api/v1/users/2fa/disable", gin.H{"code": currentCode(t, secret, 0)})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	stored, err = db.UserRepository().GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.TOTPSecret)
+	assert.False(t, stored.TOTPEnabled)
+
+	w, _ = postJSON(t, r, "/api/v1/auth/login", credentials)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestTOTPConfirmWithoutSetup(t *testing.T) {
+	r, _, _ := newUserTestRouter(t, config.LockoutConfig{})
+
+	w, _ := postJSON(t, r, "/api/v1/users/2fa/confirm", gin.H{"code": "123456"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, _ = postJSON(t, r, "/api/v1/users/2fa/disable", gin.H{"code": "123456"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, _ = postJSON(t, r, "/api/v1/users/2fa/confirm", gin.H{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
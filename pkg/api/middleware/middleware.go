@@ -1,21 +1,48 @@
 package middleware
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/services"
+	"github.com/last-emo-boy/infra-core/pkg/tracing"
 )
 
-// AuthMiddleware creates authentication middleware with session support
+// ImpersonatingHeader is set to "true" on responses to requests made with an
+// impersonation token, so that clients can tell whose data they show
+const ImpersonatingHeader = "X-Impersonating"
+
+// Audit entries of mutating requests made with an impersonation token that
+// no handler recorded an entry for
+const (
+	auditActionImpersonatedRequest = "impersonated_request"
+	auditResourceRequest           = "request"
+)
+
+// AuthMiddleware creates authentication middleware with session support.
+// Requests made with an impersonation token act as the impersonated user;
+// the admin behind them is set as "impersonator_id" and "impersonator".
 func AuthMiddleware(authService *auth.Auth, db *database.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := extractAPIKey(c); key != "" {
+			authenticateAPIKey(c, authService, db, key)
+			return
+		}
+
 		token := extractToken(c)
 		if token == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization token required"})
@@ -33,8 +60,8 @@ func AuthMiddleware(authService *auth.Auth, db *database.DB) gin.HandlerFunc {
 		// Check session if exists
 		if claims.SessionID != "" && db != nil {
 			sessionRepo := db.SSOSessionRepository()
-			session, err := sessionRepo.GetByTokenHash(authService.HashSessionToken(token))
-			if err != nil || !session.IsActive {
+			session, ok := activeSession(sessionRepo, claims)
+			if !ok {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
 				c.Abort()
 				return
@@ -43,7 +70,7 @@ func AuthMiddleware(authService *auth.Auth, db *database.DB) gin.HandlerFunc {
 			// Update last used timestamp
 			if err := sessionRepo.UpdateLastUsed(session.ID); err != nil {
 				// Log but don't fail the request
-				log.Printf("Failed to update session last used time: %v", err)
+				logging.FromContext(c.Request.Context()).Warn("failed to update session last used time", "error", err)
 			}
 		}
 
@@ -56,10 +83,160 @@ func AuthMiddleware(authService *auth.Auth, db *database.DB) gin.HandlerFunc {
 		c.Set("services", claims.Services)
 		c.Set("claims", claims)
 
+		if claims.Impersonating() {
+			c.Set("impersonator_id", claims.ImpersonatorID)
+			c.Set("impersonator", claims.Impersonator)
+			c.Header(ImpersonatingHeader, "true")
+		}
+
 		c.Next()
+
+		if claims.Impersonating() {
+			auditImpersonatedRequest(c, claims)
+		}
 	}
 }
 
+// auditImpersonatedRequest records a mutating request made with an
+// impersonation token, unless its handler already recorded what it did
+func auditImpersonatedRequest(c *gin.Context, claims *auth.Claims) {
+	if auth.ScopeForMethod(c.Request.Method) == auth.ScopeRead || c.GetBool("audit_recorded") {
+		return
+	}
+	value, exists := c.Get("audit_logger")
+	if !exists {
+		return
+	}
+	logger, ok := value.(*services.AuditLogger)
+	if !ok {
+		return
+	}
+
+	details, _ := json.Marshal(gin.H{
+		"method":       c.Request.Method,
+		"path":         c.Request.URL.Path,
+		"status":       c.Writer.Status(),
+		"impersonator": claims.Impersonator,
+	})
+	detailsJSON := string(details)
+	route := c.FullPath()
+	if route == "" {
+		route = c.Request.URL.Path
+	}
+	entry := &database.AuditLog{
+		UserID:         &claims.UserID,
+		ImpersonatorID: &claims.ImpersonatorID,
+		Action:         auditActionImpersonatedRequest,
+		ResourceType:   auditResourceRequest,
+		ResourceID:     &route,
+		Details:        &detailsJSON,
+	}
+	if ip := c.ClientIP(); ip != "" {
+		entry.IPAddress = &ip
+	}
+	if userAgent := c.Request.UserAgent(); userAgent != "" {
+		entry.UserAgent = &userAgent
+	}
+	logger.Log(entry)
+}
+
+// activeSession gets the SSO session a token was issued for, reporting
+// whether it still belongs to the token's user and is active and unexpired.
+// Impersonation tokens belong to the session of the admin behind them.
+func activeSession(sessionRepo *database.SSOSessionRepository, claims *auth.Claims) (*database.SSOSession, bool) {
+	session, err := sessionRepo.GetByID(claims.SessionID)
+	if err != nil {
+		return nil, false
+	}
+	owner := claims.UserID
+	if claims.Impersonating() {
+		owner = claims.ImpersonatorID
+	}
+	if session.UserID != owner || !session.IsActive || !session.ExpiresAt.After(time.Now()) {
+		return nil, false
+	}
+	return session, true
+}
+
+// authenticateAPIKey authenticates the request as the owner of an API key,
+// setting the same user context as a token would
+func authenticateAPIKey(c *gin.Context, authService *auth.Auth, db *database.DB, key string) {
+	if db == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+
+	now := time.Now()
+	apiKeyRepo := db.APIKeyRepository()
+	apiKey, err := apiKeyRepo.GetActiveByHash(authService.HashSessionToken(key), now)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+
+	user, err := db.UserRepository().GetByID(apiKey.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+
+	if err := apiKeyRepo.UpdateLastUsed(apiKey.ID, now); err != nil {
+		// Log but don't fail the request
+		logging.FromContext(c.Request.Context()).Warn("failed to update API key last used time", "error", err)
+	}
+
+	// Add user info to context
+	c.Set("user_id", user.ID)
+	c.Set("username", user.Username)
+	c.Set("role", user.Role)
+	c.Set("api_key_id", apiKey.ID)
+	c.Set("api_key_scopes", auth.ParseScopes(apiKey.Scopes))
+
+	c.Next()
+}
+
+// RequireScope creates middleware that requires API key requests to carry a
+// scope. Requests authenticated with a token are not restricted by scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasScope(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireMethodScope creates middleware that requires API key requests to
+// carry the read scope for safe methods and the write scope otherwise
+func RequireMethodScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := auth.ScopeForMethod(c.Request.Method)
+		if !hasScope(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// hasScope reports whether the request may perform actions needing scope
+func hasScope(c *gin.Context, scope string) bool {
+	scopes, exists := c.Get("api_key_scopes")
+	if !exists {
+		return true
+	}
+	granted, _ := scopes.([]string)
+	return auth.ScopeAllows(granted, scope)
+}
+
 // RequireRole creates role-based authorization middleware
 func RequireRole(authService *auth.Auth, requiredRole string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -80,7 +257,9 @@ func RequireRole(authService *auth.Auth, requiredRole string) gin.HandlerFunc {
 	}
 }
 
-// SSOAuthMiddleware creates SSO authentication middleware
+// SSOAuthMiddleware creates SSO authentication middleware. Impersonation
+// tokens are refused: they only act as the user in the console, not in the
+// services it signs users in to.
 func SSOAuthMiddleware(authService *auth.Auth, db *database.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Try to get token from various sources
@@ -98,12 +277,17 @@ func SSOAuthMiddleware(authService *auth.Auth, db *database.DB) gin.HandlerFunc
 			redirectToSSOLogin(c)
 			return
 		}
+		if claims.Impersonating() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Impersonation tokens cannot sign in to services"})
+			c.Abort()
+			return
+		}
 
 		// Check if session is still valid
 		if claims.SessionID != "" {
 			sessionRepo := db.SSOSessionRepository()
-			session, err := sessionRepo.GetByTokenHash(authService.HashSessionToken(token))
-			if err != nil || !session.IsActive {
+			session, ok := activeSession(sessionRepo, claims)
+			if !ok {
 				// Session expired or invalid, redirect to SSO login
 				redirectToSSOLogin(c)
 				return
@@ -112,7 +296,7 @@ func SSOAuthMiddleware(authService *auth.Auth, db *database.DB) gin.HandlerFunc
 			// Update last used timestamp
 			if err := sessionRepo.UpdateLastUsed(session.ID); err != nil {
 				// Log but don't fail the request
-				log.Printf("Failed to update session last used time: %v", err)
+				logging.FromContext(c.Request.Context()).Warn("failed to update session last used time", "error", err)
 			}
 		}
 
@@ -269,6 +453,15 @@ func extractToken(c *gin.Context) string {
 	return ""
 }
 
+// extractAPIKey extracts an API key from an "Authorization: ApiKey <key>" header
+func extractAPIKey(c *gin.Context) string {
+	scheme, key, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || scheme != auth.APIKeyScheme {
+		return ""
+	}
+	return strings.TrimSpace(key)
+}
+
 // redirectToSSOLogin redirects the user to SSO login with the current URL as redirect target
 func redirectToSSOLogin(c *gin.Context) {
 	// Build current URL
@@ -297,15 +490,73 @@ func redirectToSSOLogin(c *gin.Context) {
 	c.Abort()
 }
 
-// CORSMiddleware handles CORS headers
-func CORSMiddleware() gin.HandlerFunc {
+// AuditMiddleware makes the audit logger available to handlers that record
+// audit entries
+func AuditMiddleware(logger *services.AuditLogger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Set("audit_logger", logger)
+		c.Next()
+	}
+}
+
+// Defaults for CORS settings left unset in the configuration
+var (
+	defaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	defaultCORSHeaders = []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token",
+		"Authorization", "Accept", "Origin", "Cache-Control", "X-Requested-With", logging.RequestIDHeader}
+	defaultCORSMaxAge = 10 * time.Minute
+)
+
+// CORSMiddleware lets the browser origins allowed by cfg call the API with
+// credentials. A matching Origin is reflected, never "*", and preflight
+// requests are answered without reaching the handlers. Requests from other
+// origins get no CORS headers, so browsers keep them same-origin.
+func CORSMiddleware(cfg config.CORSConfig, environment string) gin.HandlerFunc {
+	allowed := newOriginMatcher(cfg.AllowedOrigins, environment)
+
+	methods := strings.Join(defaultCORSMethods, ", ")
+	if len(cfg.AllowedMethods) > 0 {
+		methods = strings.ToUpper(strings.Join(cfg.AllowedMethods, ", "))
+	}
+	headers := strings.Join(defaultCORSHeaders, ", ")
+	if len(cfg.AllowedHeaders) > 0 {
+		headers = strings.Join(cfg.AllowedHeaders, ", ")
+	}
+	maxAge := defaultCORSMaxAge
+	if parsed, err := time.ParseDuration(cfg.MaxAge); err == nil { // validated on load
+		maxAge = parsed
+	}
 
-		if c.Request.Method == "OPTIONS" {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		// Responses differ by origin, so caches must not share them
+		c.Writer.Header().Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if !allowed.match(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", logging.RequestIDHeader+", "+ImpersonatingHeader)
+
+		if preflight {
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+			c.Writer.Header().Set("Access-Control-Allow-Methods", methods)
+			c.Writer.Header().Set("Access-Control-Allow-Headers", headers)
+			c.Writer.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
 			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
@@ -314,21 +565,191 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-// LoggingMiddleware logs HTTP requests
+// originMatcher decides which origins CORS is allowed for
+type originMatcher struct {
+	exact     map[string]bool
+	wildcards []originWildcard
+	loopback  bool // any http(s) origin on localhost, 127.0.0.1 or [::1]
+}
+
+// originWildcard is a "scheme://*.domain[:port]" origin pattern
+type originWildcard struct {
+	scheme string // with "://"
+	suffix string // ".domain[:port]"
+}
+
+// newOriginMatcher builds the matcher for the configured origins. Without
+// any, development allows localhost origins and production none.
+func newOriginMatcher(origins []string, environment string) *originMatcher {
+	m := &originMatcher{exact: make(map[string]bool)}
+	if len(origins) == 0 {
+		m.loopback = environment != "production"
+		return m
+	}
+
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		if scheme, domain, ok := strings.Cut(origin, "://*."); ok {
+			m.wildcards = append(m.wildcards, originWildcard{scheme: scheme + "://", suffix: "." + domain})
+			continue
+		}
+		m.exact[origin] = true
+	}
+	return m
+}
+
+// match reports whether CORS is allowed for an Origin header. Wildcards match
+// a single subdomain level, as gate route hosts do.
+func (m *originMatcher) match(origin string) bool {
+	origin = strings.ToLower(origin)
+	if m.exact[origin] {
+		return true
+	}
+
+	for _, wildcard := range m.wildcards {
+		host, ok := strings.CutPrefix(origin, wildcard.scheme)
+		if !ok {
+			continue
+		}
+		if sub, ok := strings.CutSuffix(host, wildcard.suffix); ok && sub != "" && !strings.ContainsAny(sub, ".:/") {
+			return true
+		}
+	}
+
+	if m.loopback {
+		u, err := url.Parse(origin)
+		if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Path == "" {
+			switch u.Hostname() {
+			case "localhost", "127.0.0.1", "::1":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequestIDMiddleware gives every request an ID, taken from the
+// X-Request-ID header the gate forwards or generated when missing. The ID is
+// echoed in the response, stored in the gin context as "request_id", attached
+// to the request-scoped logger and added to JSON error responses.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := logging.RequestIDFrom(c.GetHeader(logging.RequestIDHeader))
+
+		ctx := logging.WithRequestID(c.Request.Context(), id)
+		ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("request_id", id))
+		c.Request = c.Request.WithContext(ctx)
+		c.Set("request_id", id)
+		c.Header(logging.RequestIDHeader, id)
+		c.Writer = &requestIDWriter{ResponseWriter: c.Writer, requestID: id}
+
+		c.Next()
+	}
+}
+
+// requestIDWriter adds the request ID to JSON error bodies such as
+// {"error": "..."} so that clients can quote it when reporting problems
+type requestIDWriter struct {
+	gin.ResponseWriter
+	requestID string
+}
+
+func (w *requestIDWriter) Write(data []byte) (int, error) {
+	if w.Status() < http.StatusBadRequest || w.Written() ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(data)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return w.ResponseWriter.Write(data)
+	}
+	if _, isError := body["error"]; !isError {
+		return w.ResponseWriter.Write(data)
+	}
+	if _, exists := body["request_id"]; exists {
+		return w.ResponseWriter.Write(data)
+	}
+	body["request_id"] = w.requestID
+	withID, err := json.Marshal(body)
+	if err != nil {
+		return w.ResponseWriter.Write(data)
+	}
+	if _, err := w.ResponseWriter.Write(withID); err != nil {
+		return 0, err
+	}
+	// Callers check that everything they passed was written
+	return len(data), nil
+}
+
+func (w *requestIDWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// TracingMiddleware records a server span for each request, continuing the
+// trace of the gate or another caller that sent a traceparent header. It does
+// nothing while tracing is off.
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tracing.Enabled() {
+			c.Next()
+			return
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracing.StartServerSpan(c.Request, c.Request.Method+" "+route)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		span.SetAttributes(attribute.String("http.route", route))
+		if id := c.GetString("request_id"); id != "" {
+			span.SetAttributes(attribute.String("request_id", id))
+		}
+		tracing.SetHTTPStatus(span, c.Writer.Status())
+	}
+}
+
+// LoggingMiddleware writes an access log entry for each request through the
+// request-scoped logger
 func LoggingMiddleware() gin.HandlerFunc {
-	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		return fmt.Sprintf("%s - [%s] \"%s %s %s %d %s \"%s\" %s\"\n",
-			param.ClientIP,
-			param.TimeStamp.Format("02/Jan/2006:15:04:05 -0700"),
-			param.Method,
-			param.Path,
-			param.Request.Proto,
-			param.StatusCode,
-			param.Latency,
-			param.Request.UserAgent(),
-			param.ErrorMessage,
-		)
-	})
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", path),
+			slog.String("proto", c.Request.Proto),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+			slog.Int("bytes", c.Writer.Size()),
+		}
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", span.TraceID().String()))
+		}
+		if errs := c.Errors.ByType(gin.ErrorTypePrivate).String(); errs != "" {
+			attrs = append(attrs, slog.String("error", errs))
+		}
+		logging.FromContext(c.Request.Context()).LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
 }
 
 // RecoveryMiddleware handles panics
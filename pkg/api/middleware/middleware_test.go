@@ -1,9 +1,14 @@
 package middleware
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -12,6 +17,8 @@ import (
 	"github.com/last-emo-boy/infra-core/pkg/auth"
 	"github.com/last-emo-boy/infra-core/pkg/config"
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/services"
 )
 
 func TestAuthMiddleware(t *testing.T) {
@@ -404,54 +411,135 @@ func TestExtractToken(t *testing.T) {
 
 func TestCORSMiddleware(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	
+
+	cfg := config.CORSConfig{
+		AllowedOrigins: []string{"https://console.example.com", "https://*.apps.example.com"},
+		MaxAge:         "1h",
+	}
 	r := gin.New()
-	r.Use(CORSMiddleware())
+	r.Use(CORSMiddleware(cfg, "production"))
 	r.GET("/test", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "test"})
 	})
-	r.OPTIONS("/test", func(c *gin.Context) {
-		c.Status(http.StatusOK)
-	})
-	
+
 	tests := []struct {
-		name           string
-		method         string
-		expectedCode   int
-		checkHeaders   bool
+		name    string
+		origin  string
+		allowed bool
 	}{
-		{
-			name:           "GET request with CORS headers",
-			method:         "GET",
-			expectedCode:   http.StatusOK,
-			checkHeaders:   true,
-		},
-		{
-			name:           "OPTIONS preflight request",
-			method:         "OPTIONS",
-			expectedCode:   http.StatusNoContent,
-			checkHeaders:   true,
-		},
+		{"exact origin", "https://console.example.com", true},
+		{"origin differing in case", "https://Console.Example.com", true},
+		{"wildcard subdomain", "https://grafana.apps.example.com", true},
+		{"wildcard matches one level only", "https://a.b.apps.example.com", false},
+		{"wildcard does not match the bare domain", "https://apps.example.com", false},
+		{"different scheme", "http://console.example.com", false},
+		{"different port", "https://console.example.com:8443", false},
+		{"lookalike domain", "https://console.example.com.evil.com", false},
+		{"localhost in production", "http://localhost:5173", false},
 	}
-	
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req, err := http.NewRequest(tt.method, "/test", nil)
-			require.NoError(t, err)
-			
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("Origin", tt.origin)
 			w := httptest.NewRecorder()
 			r.ServeHTTP(w, req)
-			
-			assert.Equal(t, tt.expectedCode, w.Code)
-			
-			if tt.checkHeaders {
-				assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+
+			// The request itself is served either way, browsers enforce CORS
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Header().Values("Vary"), "Origin")
+			if tt.allowed {
+				assert.Equal(t, tt.origin, w.Header().Get("Access-Control-Allow-Origin"))
 				assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
-				assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
-				assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "GET")
+			} else {
+				assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+				assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
 			}
 		})
 	}
+
+	t.Run("same-origin request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
+
+func TestCORSPreflight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handled := false
+	r := gin.New()
+	r.Use(CORSMiddleware(config.CORSConfig{
+		AllowedOrigins: []string{"https://console.example.com"},
+		AllowedMethods: []string{"get", "post"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		MaxAge:         "1h",
+	}, "production"))
+	r.OPTIONS("/test", func(c *gin.Context) {
+		handled = true
+		c.Status(http.StatusOK)
+	})
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/test", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "authorization")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preflight("https://console.example.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://console.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Authorization, Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "3600", w.Header().Get("Access-Control-Max-Age"))
+	assert.False(t, handled, "preflight requests should not reach handlers")
+
+	w = preflight("https://evil.example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+
+	// A plain OPTIONS request is not a preflight
+	req := httptest.NewRequest(http.MethodOptions, "/test", nil)
+	req.Header.Set("Origin", "https://console.example.com")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, handled)
+}
+
+func TestCORSDefaults(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	request := func(environment, origin string) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.Use(CORSMiddleware(config.CORSConfig{}, environment))
+		req := httptest.NewRequest(http.MethodOptions, "/test", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "DELETE")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Development allows the UI dev server on any localhost port
+	for _, origin := range []string{"http://localhost:5173", "http://127.0.0.1:3000", "http://[::1]:8080"} {
+		w := request("development", origin)
+		assert.Equal(t, http.StatusNoContent, w.Code, origin)
+		assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"), origin)
+		assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "DELETE")
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	}
+	assert.Equal(t, http.StatusForbidden, request("development", "https://example.com").Code)
+
+	// Production is same-origin only
+	assert.Equal(t, http.StatusForbidden, request("production", "http://localhost:5173").Code)
 }
 
 func TestLoggingMiddleware(t *testing.T) {
@@ -568,7 +656,7 @@ func TestMiddlewareIntegration(t *testing.T) {
 	
 	// Test middleware chain
 	r := gin.New()
-	r.Use(CORSMiddleware())
+	r.Use(CORSMiddleware(config.CORSConfig{}, "development"))
 	r.Use(LoggingMiddleware())
 	r.Use(RecoveryMiddleware())
 	r.Use(AuthMiddleware(mockAuth, mockDB))
@@ -580,6 +668,7 @@ func TestMiddlewareIntegration(t *testing.T) {
 	
 	req, err := http.NewRequest("GET", "/protected", nil)
 	require.NoError(t, err)
+	req.Header.Set("Origin", "http://localhost:5173")
 	
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
@@ -588,5 +677,313 @@ func TestMiddlewareIntegration(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 	
 	// Check CORS headers are still present
-	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
-}
\ No newline at end of file
+	assert.Equal(t, "http://localhost:5173", w.Header().Get("Access-Control-Allow-Origin"))
+}
+func TestAPIKeyAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth: config.AuthConfig{
+				JWT: config.JWTConfig{Secret: "test-secret-key-for-testing", ExpiresHours: 24},
+			},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	user := &database.User{Username: "robot", Email: "robot@example.com", PasswordHash: "x", Role: "admin"}
+	require.NoError(t, db.UserRepository().Create(user))
+
+	createKey := func(scopes string, revoked bool, expiresAt *time.Time) (string, *database.APIKey) {
+		key, prefix, hash, err := authService.GenerateAPIKey()
+		require.NoError(t, err)
+		apiKey := &database.APIKey{UserID: user.ID, Name: prefix, Prefix: prefix, KeyHash: hash, Scopes: scopes, Revoked: revoked, ExpiresAt: expiresAt}
+		require.NoError(t, db.APIKeyRepository().Create(apiKey))
+		return key, apiKey
+	}
+
+	readKey, readAPIKey := createKey(auth.ScopeRead, false, nil)
+	writeKey, _ := createKey(auth.ScopeRead+","+auth.ScopeWrite, false, nil)
+	revokedKey, _ := createKey(auth.ScopeWrite, true, nil)
+	expired := time.Now().Add(-time.Minute)
+	expiredKey, _ := createKey(auth.ScopeWrite, false, &expired)
+
+	router := gin.New()
+	api := router.Group("/api", AuthMiddleware(authService, db), RequireMethodScope())
+	handler := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":  c.GetInt("user_id"),
+			"username": c.GetString("username"),
+			"role":     c.GetString("role"),
+		})
+	}
+	api.GET("/resource", handler)
+	api.POST("/resource", handler)
+	api.DELETE("/admin", RequireRole(authService, "admin"), handler)
+	api.GET("/write-only", RequireScope(auth.ScopeWrite), handler)
+
+	token, _, err := authService.GenerateToken(user.ID, user.Username, user.Role)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		authHeader   string
+		expectedCode int
+	}{
+		{name: "read key can read", method: http.MethodGet, path: "/api/resource", authHeader: "ApiKey " + readKey, expectedCode: http.StatusOK},
+		{name: "read key cannot write", method: http.MethodPost, path: "/api/resource", authHeader: "ApiKey " + readKey, expectedCode: http.StatusForbidden},
+		{name: "read key lacks required scope", method: http.MethodGet, path: "/api/write-only", authHeader: "ApiKey " + readKey, expectedCode: http.StatusForbidden},
+		{name: "write key can write", method: http.MethodPost, path: "/api/resource", authHeader: "ApiKey " + writeKey, expectedCode: http.StatusOK},
+		{name: "write key has required scope", method: http.MethodGet, path: "/api/write-only", authHeader: "ApiKey " + writeKey, expectedCode: http.StatusOK},
+		{name: "key carries owner's role", method: http.MethodDelete, path: "/api/admin", authHeader: "ApiKey " + writeKey, expectedCode: http.StatusOK},
+		{name: "revoked key", method: http.MethodGet, path: "/api/resource", authHeader: "ApiKey " + revokedKey, expectedCode: http.StatusUnauthorized},
+		{name: "expired key", method: http.MethodGet, path: "/api/resource", authHeader: "ApiKey " + expiredKey, expectedCode: http.StatusUnauthorized},
+		{name: "unknown key", method: http.MethodGet, path: "/api/resource", authHeader: "ApiKey ick_00000000_deadbeef", expectedCode: http.StatusUnauthorized},
+		{name: "token is not scope restricted", method: http.MethodGet, path: "/api/write-only", authHeader: "Bearer " + token, expectedCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", tt.authHeader)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedCode == http.StatusOK {
+				assert.Contains(t, w.Body.String(), `"username":"robot"`)
+				assert.Contains(t, w.Body.String(), `"role":"admin"`)
+			}
+		})
+	}
+
+	// Successful authentication records when the key was last used
+	stored, err := db.APIKeyRepository().GetActiveByHash(readAPIKey.KeyHash, time.Now())
+	require.NoError(t, err)
+	assert.NotNil(t, stored.LastUsed)
+}
+
+func TestImpersonationToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth: config.AuthConfig{
+				JWT: config.JWTConfig{Secret: "test-secret-key-for-testing", ExpiresHours: 24},
+			},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	admin := &database.User{Username: "admin", Email: "admin@example.com", PasswordHash: "x", Role: "admin"}
+	alice := &database.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x", Role: "user"}
+	require.NoError(t, db.UserRepository().Create(admin))
+	require.NoError(t, db.UserRepository().Create(alice))
+	session := &database.SSOSession{UserID: admin.ID, TokenHash: "hash", ExpiresAt: time.Now().Add(time.Hour), IsActive: true, LastUsed: time.Now()}
+	require.NoError(t, db.SSOSessionRepository().Create(session))
+
+	adminToken, _, err := authService.GenerateTokenWithSession(admin.ID, admin.Username, admin.Role, session.ID, nil, nil)
+	require.NoError(t, err)
+	adminClaims, err := authService.ValidateToken(adminToken)
+	require.NoError(t, err)
+	token, _, err := authService.GenerateImpersonationToken(adminClaims, alice.ID, alice.Username, alice.Role, nil)
+	require.NoError(t, err)
+
+	logger := services.NewAuditLogger(db, 0)
+	logger.Start()
+
+	router := gin.New()
+	api := router.Group("/api", AuditMiddleware(logger), AuthMiddleware(authService, db))
+	handler := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":         c.GetInt("user_id"),
+			"username":        c.GetString("username"),
+			"role":            c.GetString("role"),
+			"impersonator_id": c.GetInt("impersonator_id"),
+			"impersonator":    c.GetString("impersonator"),
+		})
+	}
+	api.GET("/resource", handler)
+	api.POST("/resource/:id", handler)
+	api.DELETE("/admin", RequireRole(authService, "admin"), handler)
+	api.PUT("/audited", func(c *gin.Context) {
+		c.Set("audit_recorded", true)
+		handler(c)
+	})
+	router.GET("/oauth/authorize", SSOAuthMiddleware(authService, db), handler)
+	router.POST("/portal/wiki/pages", SSOAuthMiddleware(authService, db), handler)
+
+	serve := func(method, path, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w, response
+	}
+
+	// The request acts as the user, with the admin behind it alongside
+	w, response := serve(http.MethodGet, "/api/resource", token)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get(ImpersonatingHeader))
+	assert.Equal(t, float64(alice.ID), response["user_id"])
+	assert.Equal(t, "alice", response["username"])
+	assert.Equal(t, "user", response["role"])
+	assert.Equal(t, float64(admin.ID), response["impersonator_id"])
+	assert.Equal(t, "admin", response["impersonator"])
+
+	w, _ = serve(http.MethodDelete, "/api/admin", token)
+	assert.Equal(t, http.StatusForbidden, w.Code, "the user's role applies")
+
+	w, response = serve(http.MethodGet, "/api/resource", adminToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(ImpersonatingHeader))
+	assert.Zero(t, response["impersonator_id"])
+
+	w, _ = serve(http.MethodPost, "/api/resource/42", token)
+	require.Equal(t, http.StatusOK, w.Code)
+	w, _ = serve(http.MethodPut, "/api/audited", token)
+	require.Equal(t, http.StatusOK, w.Code)
+	w, _ = serve(http.MethodPost, "/api/resource/43", adminToken)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Impersonation tokens cannot sign in to services as the user, where
+	// nothing would mark or audit their requests
+	w, _ = serve(http.MethodGet, "/oauth/authorize", token)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = serve(http.MethodPost, "/portal/wiki/pages", token)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, response = serve(http.MethodGet, "/oauth/authorize", adminToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "admin", response["username"])
+
+	// Ending the admin's session ends the impersonation
+	require.NoError(t, db.SSOSessionRepository().Invalidate(session.ID))
+	w, _ = serve(http.MethodGet, "/api/resource", token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Mutating requests made with the impersonation token are audited, even
+	// refused ones, unless their handler audited them
+	logger.Stop()
+	entries, err := db.AuditLogRepository().List(database.AuditLogFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "/api/admin", *entries[1].ResourceID)
+	assert.Contains(t, *entries[1].Details, `"status":403`)
+	entry := entries[0]
+	assert.Equal(t, "impersonated_request", entry.Action)
+	assert.Equal(t, "request", entry.ResourceType)
+	assert.Equal(t, "/api/resource/:id", *entry.ResourceID)
+	assert.Equal(t, alice.ID, *entry.UserID)
+	require.NotNil(t, entry.ImpersonatorID)
+	assert.Equal(t, admin.ID, *entry.ImpersonatorID)
+	assert.Contains(t, *entry.Details, `"path":"/api/resource/42"`)
+	assert.Contains(t, *entry.Details, `"impersonator":"admin"`)
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var logs bytes.Buffer
+	logger := logging.New("console", config.LogConfig{Format: logging.FormatJSON}, "", &logs)
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(logging.WithLogger(c.Request.Context(), logger))
+		c.Next()
+	})
+	r.Use(RequestIDMiddleware())
+	r.Use(LoggingMiddleware())
+	r.GET("/items/:id", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Info("looking up item")
+		if c.Param("id") == "missing" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"request_id": c.GetString("request_id")})
+	})
+
+	t.Run("propagates incoming ID", func(t *testing.T) {
+		logs.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/items/1", nil)
+		req.Header.Set("X-Request-ID", "req-123")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "req-123", w.Header().Get("X-Request-ID"))
+		assert.JSONEq(t, `{"request_id":"req-123"}`, w.Body.String())
+
+		entries := decodeLogEntries(t, &logs)
+		require.Len(t, entries, 2)
+		assert.Equal(t, "looking up item", entries[0]["msg"])
+		assert.Equal(t, "req-123", entries[0]["request_id"])
+
+		access := entries[1]
+		assert.Equal(t, "request", access["msg"])
+		assert.Equal(t, "INFO", access["level"])
+		assert.Equal(t, "console", access["component"])
+		assert.Equal(t, "req-123", access["request_id"])
+		assert.Equal(t, "GET", access["method"])
+		assert.Equal(t, "/items/1", access["path"])
+		assert.Equal(t, float64(http.StatusOK), access["status"])
+		assert.Contains(t, access, "latency")
+		assert.Contains(t, access, "client_ip")
+	})
+
+	t.Run("generates ID and adds it to errors", func(t *testing.T) {
+		logs.Reset()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items/missing", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		id := w.Header().Get("X-Request-ID")
+		require.NotEmpty(t, id)
+
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "Item not found", body["error"])
+		assert.Equal(t, id, body["request_id"])
+
+		entries := decodeLogEntries(t, &logs)
+		require.Len(t, entries, 2)
+		assert.Equal(t, "WARN", entries[1]["level"])
+		assert.Equal(t, id, entries[1]["request_id"])
+	})
+
+	t.Run("replaces invalid ID", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/items/1", nil)
+		req.Header.Set("X-Request-ID", "not a valid\tid")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.NotEqual(t, "not a valid\tid", w.Header().Get("X-Request-ID"))
+		assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+	})
+}
+
+// decodeLogEntries parses JSON log output, one entry per line
+func decodeLogEntries(t *testing.T, logs *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		entries = append(entries, entry)
+	}
+	return entries
+}
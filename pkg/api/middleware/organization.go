@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// OrgHeader names the organization a request acts in, overriding the one
+// its token carries
+const OrgHeader = database.OrgHeader
+
+// OrgMiddleware resolves the organization a request acts in and scopes the
+// request context to it, so that the services, routes and registered
+// services handlers see are those of the organization. The organization is
+// the one OrgHeader names, else the one of the token's org_id claim while
+// the user is a member of it, else the user's first. Naming an organization
+// the user is not a member of answers 404, as for any other resource of
+// another organization; users without one see nothing. Admins are not
+// scoped unless they name an organization. The organization and the user's
+// role in it are set as "org_id" and "org_role".
+func OrgMiddleware(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID := c.GetHeader(OrgHeader)
+		repo := db.WithContext(c.Request.Context()).OrganizationRepository()
+		if orgID == "" {
+			if value, exists := c.Get("claims"); exists {
+				if claims, ok := value.(*auth.Claims); ok {
+					orgID = claims.OrgID
+				}
+			}
+			// Tokens outlive memberships: a token naming an organization
+			// the user left acts in their first one
+			if orgID != "" && c.GetString("role") != "admin" {
+				if _, err := repo.GetMember(orgID, c.GetInt("user_id")); errors.Is(err, database.ErrOrgMemberNotFound) {
+					orgID = ""
+				}
+			}
+		}
+
+		orgRole := ""
+		var err error
+		switch {
+		case c.GetString("role") == "admin":
+			if orgID == "" {
+				c.Next()
+				return
+			}
+			_, err = repo.Get(orgID)
+			orgRole = database.OrgRoleAdmin
+		case orgID != "":
+			var member *database.OrgMember
+			if member, err = repo.GetMember(orgID, c.GetInt("user_id")); err == nil {
+				orgRole = member.Role
+			}
+		default:
+			var memberships []*database.OrgMember
+			if memberships, err = repo.ListMemberships(c.GetInt("user_id")); err == nil && len(memberships) > 0 {
+				orgID, orgRole = memberships[0].OrgID, memberships[0].Role
+			}
+		}
+		if errors.Is(err, database.ErrOrganizationNotFound) || errors.Is(err, database.ErrOrgMemberNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve organization"})
+			c.Abort()
+			return
+		}
+
+		c.Set("org_id", orgID)
+		c.Set("org_role", orgRole)
+		c.Request = c.Request.WithContext(database.WithOrgScope(c.Request.Context(), orgID))
+		c.Next()
+	}
+}
+
+// OrgScopeMiddleware scopes the request context of a daemon to the
+// organization OrgHeader names, which the console sets on the requests it
+// makes for users scoped to one, so that the daemon's handlers only see the
+// organization's probes or snapshot plans. Requests without the header are
+// not scoped.
+func OrgScopeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if values, ok := c.Request.Header[http.CanonicalHeaderKey(OrgHeader)]; ok {
+			c.Request = c.Request.WithContext(database.WithOrgScope(c.Request.Context(), values[0]))
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	a := &Auth{}
+
+	key, prefix, hash, err := a.GenerateAPIKey()
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(prefix, "ick_"))
+	assert.True(t, strings.HasPrefix(key, prefix+"_"))
+	assert.Len(t, key, len(prefix)+1+64)
+	assert.Equal(t, a.HashSessionToken(key), hash)
+	assert.NotContains(t, hash, key)
+
+	other, otherPrefix, _, err := a.GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+	assert.NotEqual(t, prefix, otherPrefix)
+}
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		name     string
+		granted  []string
+		required string
+		expected bool
+	}{
+		{name: "read grants read", granted: []string{ScopeRead}, required: ScopeRead, expected: true},
+		{name: "read does not grant write", granted: []string{ScopeRead}, required: ScopeWrite},
+		{name: "write grants read", granted: []string{ScopeWrite}, required: ScopeRead, expected: true},
+		{name: "write grants write", granted: []string{ScopeRead, ScopeWrite}, required: ScopeWrite, expected: true},
+		{name: "no scopes", granted: nil, required: ScopeRead},
+		{name: "unknown scope", granted: []string{"admin"}, required: ScopeWrite},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ScopeAllows(tt.granted, tt.required))
+		})
+	}
+}
+
+func TestScopeForMethod(t *testing.T) {
+	assert.Equal(t, ScopeRead, ScopeForMethod(http.MethodGet))
+	assert.Equal(t, ScopeRead, ScopeForMethod(http.MethodHead))
+	assert.Equal(t, ScopeWrite, ScopeForMethod(http.MethodPost))
+	assert.Equal(t, ScopeWrite, ScopeForMethod(http.MethodDelete))
+}
+
+func TestParseScopes(t *testing.T) {
+	assert.Equal(t, []string{"read", "write"}, ParseScopes("read, write"))
+	assert.Equal(t, []string{"read"}, ParseScopes("read,,"))
+	assert.Nil(t, ParseScopes(""))
+}
@@ -6,20 +6,53 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/last-emo-boy/infra-core/pkg/config"
 )
 
-// Auth handles authentication and authorization
+const (
+	// DefaultLockoutAttempts is the number of consecutive failed logins that
+	// locks an account when none is configured
+	DefaultLockoutAttempts = 5
+	// DefaultLockoutWindow is the period failed logins are counted over when
+	// none is configured
+	DefaultLockoutWindow = 15 * time.Minute
+)
+
+// Auth handles authentication and authorization. Tokens are signed with an
+// asymmetric key that can be rotated, or with the shared secret if HS256 is
+// configured.
 type Auth struct {
 	config    *config.ConsoleConfig
 	jwtSecret []byte
+	keyPath   string
+	keys      []*signingKey // the active key first, then retired keys
+	keysMu    sync.RWMutex
 }
 
+// Types of tokens, set in their token_type claim. Only console tokens
+// authenticate to the console API; SSO and proxy tokens are meant for the
+// service in their audience.
+const (
+	TokenTypeConsole = "console"
+	TokenTypeSSO     = "sso"   // handed to a service on login or launch
+	TokenTypeProxy   = "proxy" // sent to a service with requests proxied through the portal
+)
+
+// ErrWrongTokenType is returned when validating a token of a type the
+// caller does not accept
+var ErrWrongTokenType = errors.New("wrong token type")
+
+// ProxyTokenTTL is how long the identity token sent with a proxied request
+// stays valid
+const ProxyTokenTTL = 5 * time.Minute
+
 // Claims represents JWT token claims
 type Claims struct {
 	UserID      int      `json:"user_id"`
@@ -28,6 +61,15 @@ type Claims struct {
 	SessionID   string   `json:"session_id"`
 	Permissions []string `json:"permissions"`
 	Services    []string `json:"services"`
+	// ImpersonatorID and Impersonator identify the admin acting as the user
+	// with an impersonation token, and are empty otherwise
+	ImpersonatorID int    `json:"impersonator_id,omitempty"`
+	Impersonator   string `json:"impersonator,omitempty"`
+	// OrgID is the organization the token acts in when multi-tenancy is
+	// enabled, and empty for the user's first organization
+	OrgID string `json:"org_id,omitempty"`
+	// Type is one of the TokenType constants
+	Type string `json:"token_type"`
 	jwt.RegisteredClaims
 }
 
@@ -43,6 +85,7 @@ type SSOClaims struct {
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	TOTPCode string `json:"totp_code,omitempty"` // required when two-factor login is enabled
 }
 
 // LoginResponse represents login response data
@@ -66,10 +109,40 @@ func NewAuth(config *config.ConsoleConfig) (*Auth, error) {
 		jwtSecret = []byte(hex.EncodeToString(randomSecret))
 	}
 
-	return &Auth{
+	a := &Auth{
 		config:    config,
 		jwtSecret: jwtSecret,
-	}, nil
+		keyPath:   signingKeyPath(config),
+	}
+	keys, err := loadSigningKeys(a.keyPath, config.Auth.JWT.Algorithm, a.keyOverlap(), time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWT signing keys: %w", err)
+	}
+	a.keys = keys
+
+	return a, nil
+}
+
+// LockoutPolicy returns how many consecutive failed logins lock an account and
+// the window they are counted over
+func (a *Auth) LockoutPolicy() (int, time.Duration) {
+	maxAttempts, window := DefaultLockoutAttempts, DefaultLockoutWindow
+	if a.config == nil {
+		return maxAttempts, window
+	}
+
+	if a.config.Auth.Lockout.MaxAttempts > 0 {
+		maxAttempts = a.config.Auth.Lockout.MaxAttempts
+	}
+	if configured, err := time.ParseDuration(a.config.Auth.Lockout.Window); err == nil && configured > 0 {
+		window = configured
+	}
+	return maxAttempts, window
+}
+
+// RegistrationAllowed reports whether anyone may create a user account
+func (a *Auth) RegistrationAllowed() bool {
+	return a.config != nil && a.config.Auth.AllowRegistration
 }
 
 // HashPassword hashes a password using bcrypt
@@ -102,16 +175,16 @@ func (a *Auth) GenerateTokenWithSession(userID int, username, role, sessionID st
 		SessionID:   sessionID,
 		Permissions: permissions,
 		Services:    services,
+		Type:        TokenTypeConsole,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "infra-core-sso",
+			Issuer:    tokenIssuer,
 			Subject:   fmt.Sprintf("user:%d", userID),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(a.jwtSecret)
+	tokenString, err := a.signConsoleClaims(claims)
 	if err != nil {
 		return "", 0, fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -119,9 +192,19 @@ func (a *Auth) GenerateTokenWithSession(userID int, username, role, sessionID st
 	return tokenString, expirationTime.Unix(), nil
 }
 
+// SSOLaunchTTL is how long the SSO token of a service launch URL stays valid
+const SSOLaunchTTL = time.Minute
+
 // GenerateSSOToken generates a JWT token for SSO authentication
 func (a *Auth) GenerateSSOToken(userID int, username, role, sessionID, sourceService, targetService, redirectURL string, permissions, services []string) (string, int64, error) {
-	expirationTime := time.Now().Add(5 * time.Minute) // Short-lived token for SSO flow
+	// Short-lived token for SSO flow
+	return a.GenerateSSOTokenWithTTL(5*time.Minute, userID, username, role, sessionID, sourceService, targetService, redirectURL, permissions, services)
+}
+
+// GenerateSSOTokenWithTTL generates a JWT token for SSO authentication that
+// expires after ttl
+func (a *Auth) GenerateSSOTokenWithTTL(ttl time.Duration, userID int, username, role, sessionID, sourceService, targetService, redirectURL string, permissions, services []string) (string, int64, error) {
+	expirationTime := time.Now().Add(ttl)
 
 	claims := &SSOClaims{
 		Claims: &Claims{
@@ -131,12 +214,14 @@ func (a *Auth) GenerateSSOToken(userID int, username, role, sessionID, sourceSer
 			SessionID:   sessionID,
 			Permissions: permissions,
 			Services:    services,
+			Type:        TokenTypeSSO,
 			RegisteredClaims: jwt.RegisteredClaims{
 				ExpiresAt: jwt.NewNumericDate(expirationTime),
 				IssuedAt:  jwt.NewNumericDate(time.Now()),
-				Issuer:    "infra-core-sso",
+				Issuer:    tokenIssuer,
 				Subject:   fmt.Sprintf("user:%d", userID),
 				Audience:  []string{targetService},
+				ID:        uuid.New().String(), // tokens minted within a second differ
 			},
 		},
 		SourceService: sourceService,
@@ -144,8 +229,7 @@ func (a *Auth) GenerateSSOToken(userID int, username, role, sessionID, sourceSer
 		RedirectURL:   redirectURL,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(a.jwtSecret)
+	tokenString, err := a.signConsoleClaims(claims)
 	if err != nil {
 		return "", 0, fmt.Errorf("failed to sign SSO token: %w", err)
 	}
@@ -153,44 +237,71 @@ func (a *Auth) GenerateSSOToken(userID int, username, role, sessionID, sourceSer
 	return tokenString, expirationTime.Unix(), nil
 }
 
-// ValidateToken validates a JWT token and returns the claims
+// GenerateProxyToken generates the token identifying a user to a service
+// whose requests are proxied through the portal. It belongs to no session
+// and only the service accepts it.
+func (a *Auth) GenerateProxyToken(userID int, username, role, targetService string) (string, int64, error) {
+	expirationTime := time.Now().Add(ProxyTokenTTL)
+
+	claims := &Claims{
+		UserID:   userID,
+		Username: username,
+		Role:     role,
+		Type:     TokenTypeProxy,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    tokenIssuer,
+			Subject:   fmt.Sprintf("user:%d", userID),
+			Audience:  []string{targetService},
+			ID:        uuid.New().String(),
+		},
+	}
+
+	tokenString, err := a.signConsoleClaims(claims)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to sign proxy token: %w", err)
+	}
+
+	return tokenString, expirationTime.Unix(), nil
+}
+
+// ValidateToken validates a console token and returns the claims. Tokens
+// minted for a service, which have an audience, are rejected.
 func (a *Auth) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return a.jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, a.verificationKey, jwt.WithIssuer(tokenIssuer))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
 	}
-
-	return nil, errors.New("invalid token")
+	if claims.Type != TokenTypeConsole || len(claims.Audience) > 0 {
+		return nil, fmt.Errorf("%w: expected a console token", ErrWrongTokenType)
+	}
+	return claims, nil
 }
 
-// ValidateSSOToken validates an SSO JWT token and returns the claims
+// ValidateSSOToken validates a token minted for a service, an SSO or proxy
+// token, and returns the claims
 func (a *Auth) ValidateSSOToken(tokenString string) (*SSOClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &SSOClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return a.jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &SSOClaims{}, a.verificationKey, jwt.WithIssuer(tokenIssuer))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse SSO token: %w", err)
 	}
 
-	if claims, ok := token.Claims.(*SSOClaims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*SSOClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid SSO token")
 	}
-
-	return nil, errors.New("invalid SSO token")
+	if claims.Type != TokenTypeSSO && claims.Type != TokenTypeProxy {
+		return nil, fmt.Errorf("%w: expected an SSO token", ErrWrongTokenType)
+	}
+	return claims, nil
 }
 
 // RequireRole checks if the user has the required role
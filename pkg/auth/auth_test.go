@@ -158,6 +158,7 @@ func TestGenerateToken(t *testing.T) {
 			},
 		},
 		jwtSecret: []byte("test-secret"),
+		keys:      testSigningKeys(),
 	}
 
 	tests := []struct {
@@ -209,6 +210,7 @@ func TestGenerateTokenWithSession(t *testing.T) {
 			},
 		},
 		jwtSecret: []byte("test-secret"),
+		keys:      testSigningKeys(),
 	}
 
 	userID := 1
@@ -246,6 +248,7 @@ func TestGenerateSSOToken(t *testing.T) {
 			},
 		},
 		jwtSecret: []byte("test-secret"),
+		keys:      testSigningKeys(),
 	}
 
 	userID := 1
@@ -291,6 +294,7 @@ func TestValidateToken(t *testing.T) {
 			},
 		},
 		jwtSecret: []byte("test-secret"),
+		keys:      testSigningKeys(),
 	}
 
 	// Generate a valid token
@@ -367,6 +371,7 @@ func TestValidateSSOToken(t *testing.T) {
 			},
 		},
 		jwtSecret: []byte("test-secret"),
+		keys:      testSigningKeys(),
 	}
 
 	// Generate a valid SSO token
@@ -415,6 +420,44 @@ func TestValidateSSOToken(t *testing.T) {
 	}
 }
 
+func TestValidateTokenRejectsServiceTokens(t *testing.T) {
+	auth := &Auth{
+		config: &config.ConsoleConfig{
+			Auth: config.AuthConfig{
+				JWT: config.JWTConfig{
+					Secret:       "test-secret",
+					ExpiresHours: 24,
+				},
+			},
+		},
+		jwtSecret: []byte("test-secret"),
+		keys:      testSigningKeys(),
+	}
+
+	ssoToken, _, err := auth.GenerateSSOToken(1, "testuser", "user", "session123", "console", "wiki", "", nil, nil)
+	require.NoError(t, err)
+	proxyToken, _, err := auth.GenerateProxyToken(1, "testuser", "user", "wiki")
+	require.NoError(t, err)
+
+	// Tokens minted for a service do not authenticate to the console
+	for name, token := range map[string]string{"sso": ssoToken, "proxy": proxyToken} {
+		_, err := auth.ValidateToken(token)
+		assert.ErrorIs(t, err, ErrWrongTokenType, name)
+	}
+
+	claims, err := auth.ValidateSSOToken(proxyToken)
+	require.NoError(t, err)
+	assert.Equal(t, TokenTypeProxy, claims.Type)
+	assert.Equal(t, jwt.ClaimStrings{"wiki"}, claims.Audience)
+	assert.Empty(t, claims.SessionID)
+
+	// and console tokens are not accepted as SSO tokens
+	consoleToken, _, err := auth.GenerateToken(1, "testuser", "user")
+	require.NoError(t, err)
+	_, err = auth.ValidateSSOToken(consoleToken)
+	assert.ErrorIs(t, err, ErrWrongTokenType)
+}
+
 func TestRequireRole(t *testing.T) {
 	auth := &Auth{}
 
@@ -611,6 +654,7 @@ func TestTokenIntegration(t *testing.T) {
 			},
 		},
 		jwtSecret: []byte("integration-test-secret"),
+		keys:      testSigningKeys(),
 	}
 
 	// Test complete flow: generate -> validate -> use
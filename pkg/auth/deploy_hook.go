@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Headers of a deploy hook request. The signature is "sha256=" followed by
+// the hex HMAC-SHA256, under the hook's secret, of the timestamp, the nonce
+// and the body joined by dots.
+const (
+	DeployHookSignatureHeader = "X-Deploy-Signature"
+	DeployHookTimestampHeader = "X-Deploy-Timestamp" // Unix seconds
+	DeployHookNonceHeader     = "X-Deploy-Nonce"     // unique per request
+)
+
+// deployHookTag starts every deploy hook secret so leaked ones are easy to
+// recognise
+const deployHookTag = "icd"
+
+// deployHookSignaturePrefix names the signature's algorithm
+const deployHookSignaturePrefix = "sha256="
+
+// GenerateDeployHookSecret creates a new deploy hook secret, to hand to the
+// user once
+func GenerateDeployHookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate deploy hook secret: %w", err)
+	}
+	return deployHookTag + "_" + hex.EncodeToString(secret), nil
+}
+
+// SignDeployHook returns the signature header of a deploy hook request
+func SignDeployHook(secret, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + nonce + "."))
+	mac.Write(body)
+	return deployHookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyDeployHook reports whether signature is that of a deploy hook
+// request, in constant time
+func VerifyDeployHook(secret, timestamp, nonce string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, deployHookSignaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(SignDeployHook(secret, timestamp, nonce, body)))
+}
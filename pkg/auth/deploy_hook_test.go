@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployHookSignature(t *testing.T) {
+	secret, err := GenerateDeployHookSecret()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, "icd_"))
+	other, err := GenerateDeployHookSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+
+	body := []byte(`{"image_tag":"1.2.3"}`)
+	signature := SignDeployHook(secret, "1700000000", "nonce-1", body)
+	assert.True(t, strings.HasPrefix(signature, "sha256="))
+	assert.True(t, VerifyDeployHook(secret, "1700000000", "nonce-1", body, signature))
+
+	// Every signed part counts
+	assert.False(t, VerifyDeployHook(other, "1700000000", "nonce-1", body, signature))
+	assert.False(t, VerifyDeployHook(secret, "1700000001", "nonce-1", body, signature))
+	assert.False(t, VerifyDeployHook(secret, "1700000000", "nonce-2", body, signature))
+	assert.False(t, VerifyDeployHook(secret, "1700000000", "nonce-1", []byte(`{"image_tag":"1.2.4"}`), signature))
+	assert.False(t, VerifyDeployHook(secret, "1700000000", "nonce-1", body, strings.TrimPrefix(signature, "sha256=")))
+	assert.False(t, VerifyDeployHook(secret, "1700000000", "nonce-1", body, ""))
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+func TestGenerateImpersonationToken(t *testing.T) {
+	auth := &Auth{
+		config: &config.ConsoleConfig{
+			Auth: config.AuthConfig{
+				JWT:           config.JWTConfig{Secret: "test-secret", ExpiresHours: 24},
+				Impersonation: config.ImpersonationConfig{TokenTTL: "10m"},
+			},
+		},
+		jwtSecret: []byte("test-secret"),
+		keys:      testSigningKeys(),
+	}
+	assert.Equal(t, 10*time.Minute, auth.ImpersonationTTL())
+	assert.False(t, auth.AdminImpersonationAllowed())
+
+	adminToken, _, err := auth.GenerateTokenWithSession(1, "admin", "admin", "session123", nil, nil)
+	require.NoError(t, err)
+	admin, err := auth.ValidateToken(adminToken)
+	require.NoError(t, err)
+	assert.False(t, admin.Impersonating())
+
+	token, expiresAt, err := auth.GenerateImpersonationToken(admin, 7, "alice", "user", []string{"grafana"})
+	require.NoError(t, err)
+	assert.InDelta(t, time.Now().Add(10*time.Minute).Unix(), expiresAt, 2)
+
+	claims, err := auth.ValidateToken(token)
+	require.NoError(t, err)
+	assert.True(t, claims.Impersonating())
+	assert.Equal(t, 7, claims.UserID)
+	assert.Equal(t, "alice", claims.Username)
+	assert.Equal(t, "user", claims.Role)
+	assert.Equal(t, []string{"grafana"}, claims.Services)
+	assert.Equal(t, 1, claims.ImpersonatorID)
+	assert.Equal(t, "admin", claims.Impersonator)
+	assert.Equal(t, "session123", claims.SessionID)
+
+	// Impersonation tokens cannot impersonate in turn
+	_, _, err = auth.GenerateImpersonationToken(claims, 8, "bob", "user", nil)
+	assert.ErrorIs(t, err, ErrNestedImpersonation)
+
+	// Nor outlive the admin's token
+	admin.ExpiresAt.Time = time.Now().Add(time.Minute)
+	_, expiresAt, err = auth.GenerateImpersonationToken(admin, 7, "alice", "user", nil)
+	require.NoError(t, err)
+	assert.Equal(t, admin.ExpiresAt.Unix(), expiresAt)
+
+	var unconfigured Auth
+	assert.Equal(t, DefaultImpersonationTTL, unconfigured.ImpersonationTTL())
+}
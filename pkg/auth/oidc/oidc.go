@@ -0,0 +1,448 @@
+// Package oidc is a minimal OpenID Connect provider, so that applications
+// which speak OpenID Connect can authenticate users against the console.
+// It supports discovery, the signing key set, the authorization code flow
+// with optional PKCE and the userinfo endpoint, for clients registered in
+// the oauth_clients table. Clients are registered by admins and trusted, so
+// users are not asked for consent.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// Scopes a client may request. Other requested scopes are ignored.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// Endpoint paths, relative to the issuer
+const (
+	DiscoveryPath = "/.well-known/openid-configuration"
+	JWKSPath      = "/oauth/jwks"
+	AuthorizePath = "/oauth/authorize"
+	TokenPath     = "/oauth/token"
+	UserInfoPath  = "/oauth/userinfo"
+)
+
+const (
+	// codeTTL is how long an authorization code can be exchanged for tokens
+	codeTTL = time.Minute
+	// tokenTTL is how long ID and access tokens are valid
+	tokenTTL = time.Hour
+	// accessTokenType is the JWT type of access tokens, which tells them
+	// apart from ID tokens signed with the same key
+	accessTokenType = "at+jwt"
+)
+
+// Errors of the authorization and token endpoints, as defined by OAuth 2.0
+const (
+	errInvalidRequest          = "invalid_request"
+	errInvalidClient           = "invalid_client"
+	errInvalidGrant            = "invalid_grant"
+	errInvalidScope            = "invalid_scope"
+	errInvalidToken            = "invalid_token"
+	errUnsupportedGrantType    = "unsupported_grant_type"
+	errUnsupportedResponseType = "unsupported_response_type"
+	errServerError             = "server_error"
+)
+
+// Provider serves the OpenID Connect endpoints
+type Provider struct {
+	auth   *auth.Auth
+	db     *database.DB
+	issuer string
+	codes  map[string]*authorizationCode
+	mutex  sync.Mutex
+}
+
+// authorizationCode is an issued code awaiting exchange for tokens
+type authorizationCode struct {
+	clientID      string
+	redirectURI   string
+	userID        int
+	scopes        []string
+	nonce         string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+// IDTokenClaims are the claims of ID tokens and the userinfo response
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	Role              string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// accessTokenClaims are the claims of access tokens, which only grant access
+// to the userinfo endpoint
+type accessTokenClaims struct {
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+// ErrNoIssuer is returned when creating a provider without an issuer
+var ErrNoIssuer = errors.New("no issuer configured")
+
+// NewProvider creates a provider signing tokens with the auth service's key
+// and issuing them as issuer, the public URL of the console. The issuer is
+// never taken from requests, whose Host and X-Forwarded-Proto headers
+// clients control.
+func NewProvider(authService *auth.Auth, db *database.DB, issuer string) (*Provider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	if issuer == "" {
+		return nil, ErrNoIssuer
+	}
+	return &Provider{
+		auth:   authService,
+		db:     db,
+		issuer: issuer,
+		codes:  make(map[string]*authorizationCode),
+	}, nil
+}
+
+// Discovery serves the provider metadata
+func (p *Provider) Discovery(c *gin.Context) {
+	issuer := p.issuer
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + AuthorizePath,
+		"token_endpoint":                        issuer + TokenPath,
+		"userinfo_endpoint":                     issuer + UserInfoPath,
+		"jwks_uri":                              issuer + JWKSPath,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": p.auth.SigningAlgorithms(),
+		"scopes_supported":                      []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "nonce", "name", "preferred_username", "email", "role"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// JWKS serves the public keys tokens are verified with, including retired
+// keys whose tokens have not expired yet
+func (p *Provider) JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": p.auth.JWKS()})
+}
+
+// Authorize issues an authorization code to the client for the signed in
+// user and redirects back to the client. It must run behind middleware that
+// authenticates the console session and sets user_id.
+func (p *Provider) Authorize(c *gin.Context) {
+	clientID := c.Query("client_id")
+	redirectURI := c.Query("redirect_uri")
+
+	// Until the redirect URI is known to belong to the client, errors are
+	// shown to the user instead of redirecting to a possibly foreign site
+	client, err := p.db.OAuthClientRepository().GetByID(clientID)
+	if errors.Is(err, database.ErrOAuthClientNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown OAuth client"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get OAuth client"})
+		return
+	}
+	uris, err := client.RedirectURIList()
+	if err != nil || !slices.Contains(uris, redirectURI) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Redirect URI is not registered for the OAuth client"})
+		return
+	}
+
+	state := c.Query("state")
+	if c.Query("response_type") != "code" {
+		redirectError(c, redirectURI, state, errUnsupportedResponseType, "only the code response type is supported")
+		return
+	}
+	scopes := requestedScopes(c.Query("scope"))
+	if !slices.Contains(scopes, ScopeOpenID) {
+		redirectError(c, redirectURI, state, errInvalidScope, "the openid scope is required")
+		return
+	}
+	codeChallenge := c.Query("code_challenge")
+	if method := c.Query("code_challenge_method"); codeChallenge != "" && method != "S256" {
+		redirectError(c, redirectURI, state, errInvalidRequest, "only the S256 code challenge method is supported")
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		redirectError(c, redirectURI, state, errServerError, "failed to issue authorization code")
+		return
+	}
+	now := time.Now()
+	p.mutex.Lock()
+	p.removeExpiredCodes(now)
+	p.codes[code] = &authorizationCode{
+		clientID:      client.ID,
+		redirectURI:   redirectURI,
+		userID:        userID.(int),
+		scopes:        scopes,
+		nonce:         c.Query("nonce"),
+		codeChallenge: codeChallenge,
+		expiresAt:     now.Add(codeTTL),
+	}
+	p.mutex.Unlock()
+
+	params := url.Values{"code": {code}}
+	if state != "" {
+		params.Set("state", state)
+	}
+	c.Redirect(http.StatusFound, withQuery(redirectURI, params))
+}
+
+// Token exchanges an authorization code for an ID token and an access token.
+// Clients authenticate with HTTP basic authentication or form parameters.
+func (p *Provider) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	client, ok := p.authenticateClient(c)
+	if !ok {
+		return
+	}
+	if grantType := c.PostForm("grant_type"); grantType != "authorization_code" {
+		tokenError(c, http.StatusBadRequest, errUnsupportedGrantType, "only the authorization_code grant type is supported")
+		return
+	}
+
+	// Codes are single use, even if the exchange fails
+	now := time.Now()
+	p.mutex.Lock()
+	code, exists := p.codes[c.PostForm("code")]
+	delete(p.codes, c.PostForm("code"))
+	p.mutex.Unlock()
+
+	if !exists || now.After(code.expiresAt) || code.clientID != client.ID {
+		tokenError(c, http.StatusBadRequest, errInvalidGrant, "invalid or expired authorization code")
+		return
+	}
+	if c.PostForm("redirect_uri") != code.redirectURI {
+		tokenError(c, http.StatusBadRequest, errInvalidGrant, "redirect_uri does not match the authorization request")
+		return
+	}
+	if code.codeChallenge != "" && !verifyCodeChallenge(code.codeChallenge, c.PostForm("code_verifier")) {
+		tokenError(c, http.StatusBadRequest, errInvalidGrant, "invalid code_verifier")
+		return
+	}
+
+	user, err := p.db.UserRepository().GetByID(code.userID)
+	if err != nil {
+		tokenError(c, http.StatusBadRequest, errInvalidGrant, "the user no longer exists")
+		return
+	}
+
+	expiresAt := now.Add(tokenTTL)
+	registered := jwt.RegisteredClaims{
+		Issuer:    p.issuer,
+		Subject:   subject(user.ID),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+
+	idClaims := userClaims(user, code.scopes)
+	idClaims.Nonce = code.nonce
+	idClaims.RegisteredClaims = registered
+	idClaims.Audience = jwt.ClaimStrings{client.ID}
+	idToken, err := p.auth.SignClaims(idClaims)
+	if err != nil {
+		tokenError(c, http.StatusInternalServerError, errServerError, "failed to sign ID token")
+		return
+	}
+
+	accessClaims := &accessTokenClaims{
+		ClientID:         client.ID,
+		Scope:            strings.Join(code.scopes, " "),
+		RegisteredClaims: registered,
+	}
+	accessClaims.Audience = jwt.ClaimStrings{p.issuer + UserInfoPath}
+	accessToken, err := p.signAccessToken(accessClaims)
+	if err != nil {
+		tokenError(c, http.StatusInternalServerError, errServerError, "failed to sign access token")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenTTL.Seconds()),
+		"id_token":     idToken,
+		"scope":        accessClaims.Scope,
+	})
+}
+
+// UserInfo returns the claims of the user an access token was issued for
+func (p *Provider) UserInfo(c *gin.Context) {
+	scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		tokenError(c, http.StatusUnauthorized, errInvalidToken, "access token required")
+		return
+	}
+
+	claims := &accessTokenClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Header["typ"] != accessTokenType {
+			return nil, errors.New("not an access token")
+		}
+		return p.auth.PublicKeyFor(t)
+	}, jwt.WithIssuer(p.issuer), jwt.WithAudience(p.issuer+UserInfoPath))
+	if err != nil || !parsed.Valid {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		tokenError(c, http.StatusUnauthorized, errInvalidToken, "invalid access token")
+		return
+	}
+
+	var userID int
+	if _, err := fmt.Sscanf(claims.Subject, "user:%d", &userID); err != nil {
+		tokenError(c, http.StatusUnauthorized, errInvalidToken, "invalid access token")
+		return
+	}
+	user, err := p.db.UserRepository().GetByID(userID)
+	if err != nil {
+		tokenError(c, http.StatusUnauthorized, errInvalidToken, "the user no longer exists")
+		return
+	}
+
+	info := userClaims(user, strings.Fields(claims.Scope))
+	info.Subject = claims.Subject
+	c.JSON(http.StatusOK, info)
+}
+
+// authenticateClient authenticates the client of a token request, writing
+// an error response if it fails
+func (p *Provider) authenticateClient(c *gin.Context) (*database.OAuthClient, bool) {
+	clientID, secret, basic := c.Request.BasicAuth()
+	if !basic {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	client, err := p.db.OAuthClientRepository().GetByID(clientID)
+	if err != nil && !errors.Is(err, database.ErrOAuthClientNotFound) {
+		tokenError(c, http.StatusInternalServerError, errServerError, "failed to get OAuth client")
+		return nil, false
+	}
+	if client == nil || secret == "" ||
+		subtle.ConstantTimeCompare([]byte(p.auth.HashSessionToken(secret)), []byte(client.SecretHash)) != 1 {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		tokenError(c, http.StatusUnauthorized, errInvalidClient, "client authentication failed")
+		return nil, false
+	}
+	return client, true
+}
+
+// signAccessToken signs access token claims with the access token type
+func (p *Provider) signAccessToken(claims *accessTokenClaims) (string, error) {
+	return p.auth.SignClaimsWithType(claims, accessTokenType)
+}
+
+// removeExpiredCodes forgets codes that can no longer be exchanged. Callers
+// hold p.mutex.
+func (p *Provider) removeExpiredCodes(now time.Time) {
+	for code, issued := range p.codes {
+		if now.After(issued.expiresAt) {
+			delete(p.codes, code)
+		}
+	}
+}
+
+// userClaims returns the claims about a user that the scopes grant. The
+// role claim is always included.
+func userClaims(user *database.User, scopes []string) *IDTokenClaims {
+	claims := &IDTokenClaims{Role: user.Role}
+	if slices.Contains(scopes, ScopeProfile) {
+		claims.Name = user.Username
+		claims.PreferredUsername = user.Username
+	}
+	if slices.Contains(scopes, ScopeEmail) {
+		claims.Email = user.Email
+	}
+	return claims
+}
+
+// requestedScopes returns the known scopes of a space-separated scope list
+func requestedScopes(scope string) []string {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if (s == ScopeOpenID || s == ScopeProfile || s == ScopeEmail) && !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// verifyCodeChallenge checks a PKCE code verifier against its S256 challenge
+func verifyCodeChallenge(challenge, verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return verifier != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// subject returns the subject identifier of a user, as in console tokens
+func subject(userID int) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// randomToken returns a random hex token for authorization codes
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// withQuery appends query parameters to a URL that may already have some
+func withQuery(rawURL string, params url.Values) string {
+	separator := "?"
+	if strings.Contains(rawURL, "?") {
+		separator = "&"
+	}
+	return rawURL + separator + params.Encode()
+}
+
+// redirectError sends an authorization error back to the client
+func redirectError(c *gin.Context, redirectURI, state, code, description string) {
+	params := url.Values{"error": {code}, "error_description": {description}}
+	if state != "" {
+		params.Set("state", state)
+	}
+	c.Redirect(http.StatusFound, withQuery(redirectURI, params))
+}
+
+// tokenError writes an OAuth 2.0 error response
+func tokenError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
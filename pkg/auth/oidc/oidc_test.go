@@ -0,0 +1,314 @@
+package oidc
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
+	"github.com/last-emo-boy/infra-core/pkg/auth"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+const (
+	testClientID     = "wiki"
+	testClientSecret = "wiki-secret"
+	testRedirectURI  = "https://wiki.example.com/callback"
+)
+
+// oidcTestServer is a console serving the provider endpoints, with a
+// registered client and a signed in user
+type oidcTestServer struct {
+	*httptest.Server
+	auth    *auth.Auth
+	user    *database.User
+	session string
+	client  *http.Client
+}
+
+func newOIDCTestServer(t *testing.T) *oidcTestServer {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+			Auth: config.AuthConfig{
+				JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 24},
+			},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	authService, err := auth.NewAuth(&cfg.Console)
+	require.NoError(t, err)
+
+	user := &database.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x", Role: "admin"}
+	require.NoError(t, db.UserRepository().Create(user))
+	session, _, err := authService.GenerateToken(user.ID, user.Username, user.Role)
+	require.NoError(t, err)
+
+	require.NoError(t, db.OAuthClientRepository().Create(&database.OAuthClient{
+		ID:           testClientID,
+		Name:         "Wiki",
+		SecretHash:   authService.HashSessionToken(testClientSecret),
+		RedirectURIs: `["` + testRedirectURI + `"]`,
+	}))
+
+	// The listener is bound before the server starts, so the issuer is known
+	r := gin.New()
+	server := httptest.NewUnstartedServer(r)
+	provider, err := NewProvider(authService, db, "http://"+server.Listener.Addr().String()+"/")
+	require.NoError(t, err)
+	r.GET(DiscoveryPath, provider.Discovery)
+	r.GET(JWKSPath, provider.JWKS)
+	r.GET(AuthorizePath, middleware.SSOAuthMiddleware(authService, db), provider.Authorize)
+	r.POST(TokenPath, provider.Token)
+	r.GET(UserInfoPath, provider.UserInfo)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return &oidcTestServer{
+		Server:  server,
+		auth:    authService,
+		user:    user,
+		session: session,
+		client: &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}},
+	}
+}
+
+// authorize starts the code flow with the given parameters and returns the
+// response, which is not followed if it redirects
+func (s *oidcTestServer) authorize(t *testing.T, params url.Values, signedIn bool) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, s.URL+AuthorizePath+"?"+params.Encode(), nil)
+	require.NoError(t, err)
+	if signedIn {
+		req.Header.Set("Authorization", "Bearer "+s.session)
+	}
+	resp, err := s.client.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// exchange redeems a code at the token endpoint
+func (s *oidcTestServer) exchange(t *testing.T, form url.Values, secret string) (int, map[string]interface{}) {
+	req, err := http.NewRequest(http.MethodPost, s.URL+TokenPath, strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(testClientID, secret)
+	return s.do(t, req)
+}
+
+func (s *oidcTestServer) do(t *testing.T, req *http.Request) (int, map[string]interface{}) {
+	resp, err := s.client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+func (s *oidcTestServer) get(t *testing.T, path string, v interface{}) {
+	resp, err := s.client.Get(s.URL + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+func authorizeParams(challenge string) url.Values {
+	return url.Values{
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid profile email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	s := newOIDCTestServer(t)
+
+	var discovery map[string]interface{}
+	s.get(t, DiscoveryPath, &discovery)
+	assert.Equal(t, s.URL, discovery["issuer"])
+	assert.Equal(t, s.URL+AuthorizePath, discovery["authorization_endpoint"])
+	assert.Equal(t, s.URL+TokenPath, discovery["token_endpoint"])
+	assert.Equal(t, s.URL+JWKSPath, discovery["jwks_uri"])
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	resp := s.authorize(t, authorizeParams(challenge), true)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, testRedirectURI, location.Scheme+"://"+location.Host+location.Path)
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {verifier},
+	}
+	status, tokens := s.exchange(t, form, testClientSecret)
+	require.Equal(t, http.StatusOK, status, tokens)
+	assert.Equal(t, "Bearer", tokens["token_type"])
+	assert.Equal(t, "openid profile email", tokens["scope"])
+
+	// The ID token verifies against the published key set
+	var jwks struct {
+		Keys []auth.JWK `json:"keys"`
+	}
+	s.get(t, JWKSPath, &jwks)
+	require.Len(t, jwks.Keys, 1)
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		n, err := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+		require.NoError(t, err)
+		e, err := base64.RawURLEncoding.DecodeString(jwk.Exponent)
+		require.NoError(t, err)
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	claims := &IDTokenClaims{}
+	_, err = jwt.ParseWithClaims(tokens["id_token"].(string), claims, func(token *jwt.Token) (interface{}, error) {
+		return keys[token.Header["kid"].(string)], nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(s.URL), jwt.WithAudience(testClientID))
+	require.NoError(t, err)
+	assert.Equal(t, subject(s.user.ID), claims.Subject)
+	assert.Equal(t, "n-0S6", claims.Nonce)
+	assert.Equal(t, "alice", claims.PreferredUsername)
+	assert.Equal(t, "alice@example.com", claims.Email)
+	assert.Equal(t, "admin", claims.Role)
+
+	// OIDC tokens are not console sessions
+	_, err = s.auth.ValidateToken(tokens["id_token"].(string))
+	assert.Error(t, err)
+	_, err = s.auth.ValidateToken(tokens["access_token"].(string))
+	assert.Error(t, err)
+
+	userInfo := func(token string) (int, map[string]interface{}) {
+		req, err := http.NewRequest(http.MethodGet, s.URL+UserInfoPath, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return s.do(t, req)
+	}
+	status, info := userInfo(tokens["access_token"].(string))
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, subject(s.user.ID), info["sub"])
+	assert.Equal(t, "alice@example.com", info["email"])
+	assert.Equal(t, "admin", info["role"])
+
+	status, _ = userInfo(tokens["id_token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, status, "ID tokens are not access tokens")
+	status, _ = userInfo(s.session)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// Codes are single use
+	status, body := s.exchange(t, form, testClientSecret)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, errInvalidGrant, body["error"])
+}
+
+func TestAuthorizationCodeFlowErrors(t *testing.T) {
+	s := newOIDCTestServer(t)
+
+	// Authorization requires a console session
+	resp := s.authorize(t, authorizeParams(""), false)
+	require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Location"), "/login?redirect="))
+
+	// Unknown clients and redirect URIs are not redirected to
+	params := authorizeParams("")
+	params.Set("client_id", "unknown")
+	assert.Equal(t, http.StatusBadRequest, s.authorize(t, params, true).StatusCode)
+	params = authorizeParams("")
+	params.Set("redirect_uri", "https://evil.example.com/callback")
+	assert.Equal(t, http.StatusBadRequest, s.authorize(t, params, true).StatusCode)
+
+	params = authorizeParams("")
+	params.Set("scope", "profile")
+	resp = s.authorize(t, params, true)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, errInvalidScope, location.Query().Get("error"))
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+
+	code := func(challenge string) string {
+		resp := s.authorize(t, authorizeParams(challenge), true)
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		location, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(t, err)
+		return location.Query().Get("code")
+	}
+	form := func(code string) url.Values {
+		return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {testRedirectURI}}
+	}
+
+	status, body := s.exchange(t, form(code("")), "wrong-secret")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, errInvalidClient, body["error"])
+
+	wrongRedirect := form(code(""))
+	wrongRedirect.Set("redirect_uri", "https://wiki.example.com/other")
+	status, body = s.exchange(t, wrongRedirect, testClientSecret)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, errInvalidGrant, body["error"])
+
+	pkce := form(code("challenge-without-verifier"))
+	pkce.Set("code_verifier", "wrong")
+	status, body = s.exchange(t, pkce, testClientSecret)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, errInvalidGrant, body["error"])
+
+	refresh := form(code(""))
+	refresh.Set("grant_type", "refresh_token")
+	status, body = s.exchange(t, refresh, testClientSecret)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, errUnsupportedGrantType, body["error"])
+}
+
+func TestIssuerIgnoresRequestHeaders(t *testing.T) {
+	s := newOIDCTestServer(t)
+
+	// Clients control the Host and X-Forwarded-Proto headers, so they must
+	// not change the issuer tokens are issued and checked for
+	req, err := http.NewRequest(http.MethodGet, s.URL+DiscoveryPath, nil)
+	require.NoError(t, err)
+	req.Host = "attacker.example.com"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	code, discovery := s.do(t, req)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, s.URL, discovery["issuer"])
+	assert.Equal(t, s.URL+TokenPath, discovery["token_endpoint"])
+
+	_, err = NewProvider(s.auth, nil, "")
+	assert.ErrorIs(t, err, ErrNoIssuer)
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// GenerateOrgToken generates a copy of a token acting in another
+// organization. It keeps the token's session, identity and expiry, so
+// switching organizations never extends a login.
+func (a *Auth) GenerateOrgToken(current *Claims, orgID string) (string, int64, error) {
+	claims := *current
+	claims.OrgID = orgID
+	claims.Type = TokenTypeConsole
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: current.ExpiresAt,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		Issuer:    tokenIssuer,
+		Subject:   current.Subject,
+		ID:        uuid.New().String(),
+	}
+
+	tokenString, err := a.signConsoleClaims(&claims)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to sign organization token: %w", err)
+	}
+
+	var expiresAt int64
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time.Unix()
+	}
+	return tokenString, expiresAt, nil
+}
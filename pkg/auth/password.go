@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+const (
+	// DefaultPasswordMinLength is the minimum password length when none is configured
+	DefaultPasswordMinLength = 8
+
+	// passwordMaxBytes is the longest password bcrypt can hash
+	passwordMaxBytes = 72
+
+	// generatedPasswordLength is the length of generated passwords, unless
+	// the policy requires longer ones
+	generatedPasswordLength = 20
+
+	// generatedPasswordAlphabet leaves out characters that are easily confused
+	generatedPasswordAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789-_.!@#%"
+
+	// minPersonalInfoLength is the shortest username or email part checked for
+	// inside a password, so that very short usernames do not reject everything
+	minPersonalInfoLength = 3
+)
+
+// Password policy rules reported in violations
+const (
+	RuleMinLength    = "min_length"
+	RuleMaxLength    = "max_length"
+	RuleUpper        = "uppercase"
+	RuleLower        = "lowercase"
+	RuleDigit        = "digit"
+	RuleSymbol       = "symbol"
+	RulePersonalInfo = "personal_info"
+	RuleCommon       = "common"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// commonPasswords is the lower-cased set of passwords from commonPasswordList
+var commonPasswords = parseCommonPasswords(commonPasswordList)
+
+// PasswordViolation describes a password policy rule a password breaks
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicy validates new passwords
+type PasswordPolicy struct {
+	config.PasswordPolicyConfig
+}
+
+// NewPasswordPolicy creates a policy from configuration, applying defaults for unset values
+func NewPasswordPolicy(cfg config.PasswordPolicyConfig) *PasswordPolicy {
+	if cfg.MinLength <= 0 {
+		cfg.MinLength = DefaultPasswordMinLength
+	}
+	return &PasswordPolicy{PasswordPolicyConfig: cfg}
+}
+
+// Validate returns the rules password breaks for the account with username
+// and email, or nil when it satisfies the policy. Lengths are counted in
+// characters rather than bytes.
+func (p *PasswordPolicy) Validate(password, username, email string) []PasswordViolation {
+	var violations []PasswordViolation
+	add := func(rule, message string) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: message})
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		add(RuleMinLength, fmt.Sprintf("Password must be at least %d characters long", p.MinLength))
+	}
+	if len(password) > passwordMaxBytes {
+		add(RuleMaxLength, fmt.Sprintf("Password must be at most %d bytes long", passwordMaxBytes))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		add(RuleUpper, "Password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		add(RuleLower, "Password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		add(RuleDigit, "Password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		add(RuleSymbol, "Password must contain a symbol")
+	}
+
+	if containsPersonalInfo(password, username, email) {
+		add(RulePersonalInfo, "Password must not contain the username or email")
+	}
+
+	if p.RejectCommon {
+		if _, common := commonPasswords[strings.ToLower(password)]; common {
+			add(RuleCommon, "Password is too common")
+		}
+	}
+
+	return violations
+}
+
+// ValidatePassword checks a new password against the configured password policy
+func (a *Auth) ValidatePassword(password, username, email string) []PasswordViolation {
+	var cfg config.PasswordPolicyConfig
+	if a.config != nil {
+		cfg = a.config.Auth.PasswordPolicy
+	}
+	return NewPasswordPolicy(cfg).Validate(password, username, email)
+}
+
+// GeneratePassword generates a random password for the account with username
+// and email that satisfies the configured password policy
+func (a *Auth) GeneratePassword(username, email string) (string, error) {
+	var cfg config.PasswordPolicyConfig
+	if a.config != nil {
+		cfg = a.config.Auth.PasswordPolicy
+	}
+	policy := NewPasswordPolicy(cfg)
+	length := max(generatedPasswordLength, policy.MinLength)
+
+	// Random passwords only rarely miss a required character class
+	for attempt := 0; attempt < 100; attempt++ {
+		password := make([]byte, length)
+		for i := range password {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(generatedPasswordAlphabet))))
+			if err != nil {
+				return "", fmt.Errorf("failed to generate password: %w", err)
+			}
+			password[i] = generatedPasswordAlphabet[n.Int64()]
+		}
+		if len(policy.Validate(string(password), username, email)) == 0 {
+			return string(password), nil
+		}
+	}
+	return "", errors.New("failed to generate a password satisfying the password policy")
+}
+
+// containsPersonalInfo reports whether password contains the username, the
+// email or the local part of the email, ignoring case
+func containsPersonalInfo(password, username, email string) bool {
+	password = strings.ToLower(password)
+
+	candidates := []string{username, email}
+	if local, _, found := strings.Cut(email, "@"); found {
+		candidates = append(candidates, local)
+	}
+
+	for _, candidate := range candidates {
+		candidate = strings.ToLower(candidate)
+		if utf8.RuneCountInString(candidate) >= minPersonalInfoLength && strings.Contains(password, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCommonPasswords reads one password per line, skipping blanks and comments
+func parseCommonPasswords(list string) map[string]struct{} {
+	passwords := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+}
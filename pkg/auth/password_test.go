@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// violatedRules returns the rules named in violations, in order
+func violatedRules(violations []PasswordViolation) []string {
+	var rules []string
+	for _, v := range violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+func TestPasswordPolicyDefaults(t *testing.T) {
+	policy := NewPasswordPolicy(config.PasswordPolicyConfig{})
+	assert.Equal(t, DefaultPasswordMinLength, policy.MinLength)
+
+	tests := []struct {
+		name     string
+		password string
+		expected []string
+	}{
+		{name: "single character", password: "a", expected: []string{RuleMinLength}},
+		{name: "one below minimum", password: "correct", expected: []string{RuleMinLength}},
+		{name: "exactly minimum", password: "correcth"},
+		{name: "long passphrase", password: "correct horse battery staple"},
+		{name: "common password allowed when not rejected", password: "password123"},
+		{name: "bcrypt limit", password: strings.Repeat("x", passwordMaxBytes)},
+		{name: "over bcrypt limit", password: strings.Repeat("x", passwordMaxBytes+1), expected: []string{RuleMaxLength}},
+		{name: "contains username", password: "my-alice-secret", expected: []string{RulePersonalInfo}},
+		{name: "contains email local part, any case", password: "ALICE.SMITH!2024", expected: []string{RulePersonalInfo}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, violatedRules(policy.Validate(tt.password, "alice", "alice.smith@example.com")))
+		})
+	}
+}
+
+func TestPasswordPolicyUnicode(t *testing.T) {
+	policy := NewPasswordPolicy(config.PasswordPolicyConfig{MinLength: 8, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true})
+
+	tests := []struct {
+		name     string
+		password string
+		expected []string
+	}{
+		// Eight characters but sixteen bytes, so the length is counted in characters
+		{name: "cyrillic at minimum", password: "Пароль1!"},
+		{name: "seven multibyte characters", password: "Пароль1", expected: []string{RuleMinLength, RuleSymbol}},
+		{name: "uncased letters", password: "密码密码密码12", expected: []string{RuleUpper, RuleLower, RuleSymbol}},
+		{name: "accented cases", password: "ÉcoleÀ9#", expected: nil},
+		{name: "emoji count as symbols", password: "Secret1🔑", expected: nil},
+		{name: "non-ascii digits", password: "Secret٣!x", expected: nil},
+		{name: "multibyte over bcrypt limit", password: strings.Repeat("Ж", 37) + "a1!", expected: []string{RuleMaxLength}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, violatedRules(policy.Validate(tt.password, "bob", "bob@example.com")))
+		})
+	}
+}
+
+func TestPasswordPolicyCharacterClasses(t *testing.T) {
+	policy := NewPasswordPolicy(config.PasswordPolicyConfig{MinLength: 4, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true})
+
+	assert.Equal(t, []string{RuleUpper, RuleDigit, RuleSymbol}, violatedRules(policy.Validate("lowercase", "", "")))
+	assert.Equal(t, []string{RuleLower, RuleDigit, RuleSymbol}, violatedRules(policy.Validate("UPPERCASE", "", "")))
+	assert.Equal(t, []string{RuleUpper, RuleLower, RuleSymbol}, violatedRules(policy.Validate("12345678", "", "")))
+	assert.Equal(t, []string{RuleMinLength, RuleUpper, RuleLower, RuleDigit}, violatedRules(policy.Validate("!", "", "")))
+	assert.Empty(t, policy.Validate("Aa1 ", "", ""), "a space counts as a symbol")
+}
+
+func TestPasswordPolicyPersonalInfo(t *testing.T) {
+	policy := NewPasswordPolicy(config.PasswordPolicyConfig{MinLength: 1})
+
+	// Very short usernames are not checked, so they do not reject everything
+	assert.Empty(t, policy.Validate("a-strong-password", "a", "a@example.com"))
+	assert.Empty(t, policy.Validate("jo-strong-password", "jo", "jo@example.com"))
+
+	assert.Equal(t, []string{RulePersonalInfo}, violatedRules(policy.Validate("xXjoeXx", "joe", "")))
+	assert.Equal(t, []string{RulePersonalInfo}, violatedRules(policy.Validate("joe@example.com", "", "joe@example.com")))
+	assert.Equal(t, []string{RulePersonalInfo}, violatedRules(policy.Validate("ÜBERUSER", "überuser", "")))
+}
+
+func TestPasswordPolicyCommonPasswords(t *testing.T) {
+	policy := NewPasswordPolicy(config.PasswordPolicyConfig{MinLength: 1, RejectCommon: true})
+
+	assert.Equal(t, []string{RuleCommon}, violatedRules(policy.Validate("password123", "", "")))
+	assert.Equal(t, []string{RuleCommon}, violatedRules(policy.Validate("QWERTY", "", "")))
+	assert.Empty(t, policy.Validate("tangerine-lighthouse", "", ""))
+
+	// The list's comment header is not treated as a password
+	_, commented := commonPasswords["# one password per line, compared case-insensitively."]
+	assert.False(t, commented)
+	assert.Greater(t, len(commonPasswords), 100)
+}
+
+func TestValidatePasswordUsesConfig(t *testing.T) {
+	a := &Auth{config: &config.ConsoleConfig{
+		Auth: config.AuthConfig{PasswordPolicy: config.PasswordPolicyConfig{MinLength: 12, RejectCommon: true}},
+	}}
+	assert.Equal(t, []string{RuleMinLength, RuleCommon}, violatedRules(a.ValidatePassword("letmein", "carol", "carol@example.com")))
+	assert.Empty(t, a.ValidatePassword("orange-kettle-42", "carol", "carol@example.com"))
+
+	var unconfigured Auth
+	assert.Equal(t, []string{RuleMinLength}, violatedRules(unconfigured.ValidatePassword("short", "", "")))
+}
+
+func TestGeneratePassword(t *testing.T) {
+	a := &Auth{config: &config.ConsoleConfig{
+		Auth: config.AuthConfig{PasswordPolicy: config.PasswordPolicyConfig{
+			MinLength:     24,
+			RequireUpper:  true,
+			RequireLower:  true,
+			RequireDigit:  true,
+			RequireSymbol: true,
+			RejectCommon:  true,
+		}},
+	}}
+
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		password, err := a.GeneratePassword("admin", "admin@example.com")
+		require.NoError(t, err)
+		assert.Len(t, password, 24)
+		assert.Empty(t, a.ValidatePassword(password, "admin", "admin@example.com"))
+		assert.False(t, seen[password], "passwords are random")
+		seen[password] = true
+	}
+
+	var unconfigured Auth
+	password, err := unconfigured.GeneratePassword("", "")
+	require.NoError(t, err)
+	assert.Len(t, password, generatedPasswordLength)
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPasswordResetTTL is how long a reset token stays valid when none is configured
+	DefaultPasswordResetTTL = time.Hour
+
+	// defaultPasswordResetURL is the console page reset tokens are sent to when none is configured
+	defaultPasswordResetURL = "/reset-password"
+)
+
+// ResetSender delivers password reset links to users
+type ResetSender interface {
+	SendPasswordReset(email, link string) error
+}
+
+// LogResetSender writes reset links to the console log, for deployments
+// without outgoing mail
+type LogResetSender struct{}
+
+// SendPasswordReset logs the reset link for an email address
+func (LogResetSender) SendPasswordReset(email, link string) error {
+	log.Printf("📧 Password reset requested for %s: %s", email, link)
+	return nil
+}
+
+// PasswordResetTTL returns how long a password reset token stays valid
+func (a *Auth) PasswordResetTTL() time.Duration {
+	if a.config != nil {
+		if ttl, err := time.ParseDuration(a.config.Auth.PasswordReset.TokenTTL); err == nil && ttl > 0 {
+			return ttl
+		}
+	}
+	return DefaultPasswordResetTTL
+}
+
+// PasswordResetLink returns the link a user follows to reset their password with token
+func (a *Auth) PasswordResetLink(token string) string {
+	base := defaultPasswordResetURL
+	if a.config != nil && a.config.Auth.PasswordReset.URL != "" {
+		base = a.config.Auth.PasswordReset.URL
+	}
+
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	return base + separator + "token=" + url.QueryEscape(token)
+}
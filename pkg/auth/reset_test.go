@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+func TestPasswordResetSettings(t *testing.T) {
+	defaults := &Auth{config: &config.ConsoleConfig{}}
+	assert.Equal(t, DefaultPasswordResetTTL, defaults.PasswordResetTTL())
+	assert.Equal(t, "/reset-password?token=abc", defaults.PasswordResetLink("abc"))
+
+	configured := &Auth{config: &config.ConsoleConfig{
+		Auth: config.AuthConfig{PasswordReset: config.PasswordResetConfig{
+			TokenTTL: "30m",
+			URL:      "https://console.example.com/#/reset?source=email",
+		}},
+	}}
+	assert.Equal(t, 30*time.Minute, configured.PasswordResetTTL())
+	assert.Equal(t, "https://console.example.com/#/reset?source=email&token=a%2Bb", configured.PasswordResetLink("a+b"))
+
+	var unconfigured Auth
+	assert.Equal(t, DefaultPasswordResetTTL, unconfigured.PasswordResetTTL())
+}
@@ -0,0 +1,454 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+const (
+	// tokenIssuer is the issuer of console session and SSO tokens
+	tokenIssuer = "infra-core-sso"
+
+	// signingKeyFile is the file the signing keys are kept in next to the
+	// database when no key file is configured
+	signingKeyFile = "jwt_signing_key.pem"
+
+	// signingKeyBits is the size of generated RSA signing keys
+	signingKeyBits = 2048
+
+	// retiredAtHeader is the PEM header recording when a key was rotated out
+	retiredAtHeader = "Retired-At"
+
+	// minKeyOverlap is the shortest time retired keys keep verifying tokens,
+	// covering short-lived SSO and OpenID Connect tokens
+	minKeyOverlap = time.Hour
+)
+
+// Token signing algorithms
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmHS256 = "HS256"
+)
+
+// signingKey is an asymmetric key tokens are signed or verified with
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	retiredAt time.Time // zero while the key signs tokens
+}
+
+// newSigningKey wraps an RSA or Ed25519 private key
+func newSigningKey(private crypto.Signer) (*signingKey, error) {
+	key := &signingKey{private: private}
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", private)
+	}
+	key.id = keyThumbprint(key.jwk())
+	return key, nil
+}
+
+// generateSigningKey generates a key for the configured algorithm. HS256
+// still needs one for OpenID Connect tokens, which are always asymmetric.
+func generateSigningKey(algorithm string) (*signingKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case "", AlgorithmRS256, AlgorithmHS256:
+		private, err = rsa.GenerateKey(rand.Reader, signingKeyBits)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(private)
+}
+
+// jwk returns the public half of the key in JSON Web Key format
+func (k *signingKey) jwk() JWK {
+	jwk := JWK{Use: "sig", Algorithm: k.method.Alg(), KeyID: k.id}
+	switch public := k.private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.Modulus = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.Exponent = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// signingKeyPath returns where the token signing keys are kept, or "" if the
+// keys are not persisted, for in-memory databases without a key file
+func signingKeyPath(cfg *config.ConsoleConfig) string {
+	if cfg.Auth.JWT.KeyFile != "" {
+		return cfg.Auth.JWT.KeyFile
+	}
+	if path := cfg.Database.Path; path != "" && path != ":memory:" {
+		return filepath.Join(filepath.Dir(path), signingKeyFile)
+	}
+	return ""
+}
+
+// loadSigningKeys reads the token signing keys from a PEM file, generating
+// and saving a new key if the file does not exist yet. Without a path a new
+// key is generated for this process only. The active key comes first,
+// followed by retired keys that still verify tokens at now.
+func loadSigningKeys(path, algorithm string, overlap time.Duration, now time.Time) ([]*signingKey, error) {
+	if path == "" {
+		key, err := generateSigningKey(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		return []*signingKey{key}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return parseSigningKeys(data, overlap, now)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := generateSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	data, err = encodeSigningKeys([]*signingKey{key})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		// Another process saved a key first
+		return loadSigningKeys(path, algorithm, overlap, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return []*signingKey{key}, file.Close()
+}
+
+// saveSigningKeys replaces the key file, so that a crash never leaves a
+// partially written key ring behind
+func saveSigningKeys(path string, keys []*signingKey) error {
+	data, err := encodeSigningKeys(keys)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// encodeSigningKeys encodes keys as PKCS#8 PEM blocks, marking retired keys
+// with the time they were retired
+func encodeSigningKeys(keys []*signingKey) ([]byte, error) {
+	var data []byte
+	for _, key := range keys {
+		der, err := x509.MarshalPKCS8PrivateKey(key.private)
+		if err != nil {
+			return nil, err
+		}
+		block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		if !key.retiredAt.IsZero() {
+			block.Headers = map[string]string{retiredAtHeader: key.retiredAt.UTC().Format(time.RFC3339)}
+		}
+		data = append(data, pem.EncodeToMemory(block)...)
+	}
+	return data, nil
+}
+
+// parseSigningKeys parses the PEM blocks of a key file, dropping retired keys
+// whose tokens have expired by now. Exactly one key must be active.
+func parseSigningKeys(data []byte, overlap time.Duration, now time.Time) ([]*signingKey, error) {
+	var active *signingKey
+	var retired []*signingKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		private, err := parsePrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, err := newSigningKey(private)
+		if err != nil {
+			return nil, err
+		}
+
+		value, ok := block.Headers[retiredAtHeader]
+		if !ok {
+			if active != nil {
+				return nil, errors.New("signing key file has more than one active key")
+			}
+			active = key
+			continue
+		}
+		if key.retiredAt, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("invalid %s header in signing key file: %w", retiredAtHeader, err)
+		}
+		if now.Before(key.retiredAt.Add(overlap)) {
+			retired = append(retired, key)
+		}
+	}
+	if active == nil {
+		return nil, errors.New("no active key in signing key file")
+	}
+	return append([]*signingKey{active}, retired...), nil
+}
+
+// parsePrivateKey parses a PKCS#1 RSA or PKCS#8 RSA or Ed25519 private key
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing key type %T", parsed)
+	}
+	return key, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n,omitempty"`   // RSA
+	Exponent  string `json:"e,omitempty"`   // RSA
+	Curve     string `json:"crv,omitempty"` // OKP
+	X         string `json:"x,omitempty"`   // OKP
+}
+
+// keyOverlap returns how long a retired key keeps verifying tokens: the
+// lifetime of the longest-lived tokens it may have signed
+func (a *Auth) keyOverlap() time.Duration {
+	overlap := time.Duration(a.config.Auth.JWT.ExpiresHours) * time.Hour
+	if overlap < minKeyOverlap {
+		overlap = minKeyOverlap
+	}
+	return overlap
+}
+
+// activeKey returns the key new tokens are signed with
+func (a *Auth) activeKey() *signingKey {
+	a.keysMu.RLock()
+	defer a.keysMu.RUnlock()
+	return a.keys[0]
+}
+
+// liveKeys returns the active key and the retired keys still verifying
+// tokens
+func (a *Auth) liveKeys() []*signingKey {
+	a.keysMu.RLock()
+	defer a.keysMu.RUnlock()
+
+	now := time.Now()
+	overlap := a.keyOverlap()
+	keys := make([]*signingKey, 0, len(a.keys))
+	for _, key := range a.keys {
+		if key.retiredAt.IsZero() || now.Before(key.retiredAt.Add(overlap)) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// PublicJWK returns the public half of the active signing key
+func (a *Auth) PublicJWK() JWK {
+	return a.activeKey().jwk()
+}
+
+// JWKS returns the public keys tokens may be verified with, the active key
+// first
+func (a *Auth) JWKS() []JWK {
+	keys := a.liveKeys()
+	jwks := make([]JWK, 0, len(keys))
+	for _, key := range keys {
+		jwks = append(jwks, key.jwk())
+	}
+	return jwks
+}
+
+// SigningAlgorithms returns the algorithms of the keys tokens may be verified
+// with
+func (a *Auth) SigningAlgorithms() []string {
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, key := range a.liveKeys() {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algorithms = append(algorithms, alg)
+		}
+	}
+	return algorithms
+}
+
+// RotateKeys generates a new signing key of the configured algorithm and
+// retires the active key. The retired key keeps verifying tokens until the
+// tokens it signed have expired. It returns the new and the retired key.
+func (a *Auth) RotateKeys() (active, retired JWK, err error) {
+	key, err := generateSigningKey(a.config.Auth.JWT.Algorithm)
+	if err != nil {
+		return JWK{}, JWK{}, err
+	}
+
+	a.keysMu.Lock()
+	defer a.keysMu.Unlock()
+
+	now := time.Now()
+	overlap := a.keyOverlap()
+	previous := *a.keys[0]
+	previous.retiredAt = now
+	keys := []*signingKey{key, &previous}
+	for _, old := range a.keys[1:] {
+		if now.Before(old.retiredAt.Add(overlap)) {
+			keys = append(keys, old)
+		}
+	}
+
+	if a.keyPath != "" {
+		if err := saveSigningKeys(a.keyPath, keys); err != nil {
+			return JWK{}, JWK{}, fmt.Errorf("failed to save signing keys: %w", err)
+		}
+	}
+	a.keys = keys
+	return key.jwk(), previous.jwk(), nil
+}
+
+// SignClaims signs claims as a JWT with the active signing key
+func (a *Auth) SignClaims(claims jwt.Claims) (string, error) {
+	return a.SignClaimsWithType(claims, "JWT")
+}
+
+// SignClaimsWithType signs claims like SignClaims with the given token type
+// header, so that tokens for different purposes cannot be mistaken for each
+// other
+func (a *Auth) SignClaimsWithType(claims jwt.Claims, typ string) (string, error) {
+	key := a.activeKey()
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	token.Header["typ"] = typ
+	return token.SignedString(key.private)
+}
+
+// signConsoleClaims signs console session and SSO tokens, with the shared
+// secret if HS256 is configured
+func (a *Auth) signConsoleClaims(claims jwt.Claims) (string, error) {
+	if a.config.Auth.JWT.Algorithm == AlgorithmHS256 {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.jwtSecret)
+	}
+	return a.SignClaims(claims)
+}
+
+// PublicKeyFor returns the public key an asymmetrically signed token is
+// verified with, chosen by its kid header. Tokens without a kid are verified
+// with the active key.
+func (a *Auth) PublicKeyFor(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range a.liveKeys() {
+		if kid != "" && kid != key.id {
+			continue
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.private.Public(), nil
+	}
+	return nil, fmt.Errorf("unknown signing key: %s", kid)
+}
+
+// verificationKey returns the key a console token is verified with. Tokens
+// signed with the shared secret are only accepted when HS256 is configured,
+// as anyone able to verify them could also forge them.
+func (a *Auth) verificationKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if a.config.Auth.JWT.Algorithm != AlgorithmHS256 {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return a.jwtSecret, nil
+	}
+	return a.PublicKeyFor(token)
+}
+
+// keyThumbprint returns the RFC 7638 thumbprint of a public key, used as its
+// key ID
+func keyThumbprint(jwk JWK) string {
+	// The required members in lexicographic order, as the thumbprint requires
+	var members interface{}
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.Exponent, jwk.KeyType, jwk.Modulus}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
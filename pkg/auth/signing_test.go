@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// testSigningKey is a signing key shared by tests, as generating one is slow
+var testSigningKey = sync.OnceValue(func() *signingKey {
+	private, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+	if err != nil {
+		panic(err)
+	}
+	key, err := newSigningKey(private)
+	if err != nil {
+		panic(err)
+	}
+	return key
+})
+
+// testSigningKeys returns a key ring holding only the shared test key
+func testSigningKeys() []*signingKey {
+	return []*signingKey{testSigningKey()}
+}
+
+func TestSigningKeyPersistence(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.ConsoleConfig{
+		Database: config.DatabaseConfig{Path: filepath.Join(dir, "console.db")},
+		Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 1}},
+	}
+
+	first, err := NewAuth(cfg)
+	require.NoError(t, err)
+	info, err := os.Stat(filepath.Join(dir, signingKeyFile))
+	require.NoError(t, err, "the key is saved next to the database")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// Tokens survive a restart
+	token, _, err := first.GenerateToken(1, "admin", "admin")
+	require.NoError(t, err)
+	second, err := NewAuth(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, first.PublicJWK(), second.PublicJWK())
+	claims, err := second.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", claims.Username)
+
+	cfg.Auth.JWT.KeyFile = filepath.Join(dir, "keys", "signing.pem")
+	third, err := NewAuth(cfg)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.PublicJWK().KeyID, third.PublicJWK().KeyID)
+	_, err = third.ValidateToken(token)
+	assert.Error(t, err, "tokens signed with another key are rejected")
+
+	require.NoError(t, os.WriteFile(cfg.Auth.JWT.KeyFile, []byte("not a key"), 0600))
+	_, err = NewAuth(cfg)
+	assert.Error(t, err)
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.ConsoleConfig{
+		Database: config.DatabaseConfig{Path: filepath.Join(dir, "console.db")},
+		Auth:     config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 2}},
+	}
+
+	a, err := NewAuth(cfg)
+	require.NoError(t, err)
+	oldToken, _, err := a.GenerateToken(1, "admin", "admin")
+	require.NoError(t, err)
+	oldKey := a.PublicJWK()
+
+	newKey, retiredKey, err := a.RotateKeys()
+	require.NoError(t, err)
+	assert.Equal(t, oldKey, retiredKey)
+	assert.NotEqual(t, oldKey.KeyID, newKey.KeyID)
+	assert.Equal(t, newKey, a.PublicJWK())
+	assert.Equal(t, []JWK{newKey, oldKey}, a.JWKS())
+
+	newToken, _, err := a.GenerateToken(2, "alice", "user")
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, newKey.KeyID, parsed.Header["kid"])
+
+	// Both keys verify tokens during the overlap, also after a restart
+	restarted, err := NewAuth(cfg)
+	require.NoError(t, err)
+	for _, auth := range []*Auth{a, restarted} {
+		_, err = auth.ValidateToken(oldToken)
+		assert.NoError(t, err, "tokens of the retired key are valid until they expire")
+		_, err = auth.ValidateToken(newToken)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, a.JWKS(), restarted.JWKS())
+
+	// Once tokens of the retired key have expired, it is no longer trusted
+	a.keys[1].retiredAt = time.Now().Add(-2*time.Hour - time.Minute)
+	_, err = a.ValidateToken(oldToken)
+	assert.Error(t, err)
+	_, err = a.ValidateToken(newToken)
+	assert.NoError(t, err)
+	assert.Equal(t, []JWK{newKey}, a.JWKS())
+
+	// and is dropped from the key file on load and the next rotation
+	require.NoError(t, saveSigningKeys(a.keyPath, a.keys))
+	restarted, err = NewAuth(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []JWK{newKey}, restarted.JWKS())
+	_, _, err = a.RotateKeys()
+	require.NoError(t, err)
+	data, err := os.ReadFile(a.keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "BEGIN PRIVATE KEY"))
+}
+
+func TestSigningAlgorithms(t *testing.T) {
+	claims := &Claims{
+		UserID:   1,
+		Username: "admin",
+		Role:     "admin",
+		Type:     TokenTypeConsole,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Issuer:    tokenIssuer,
+		},
+	}
+	newAuth := func(algorithm string) *Auth {
+		a, err := NewAuth(&config.ConsoleConfig{
+			Auth: config.AuthConfig{JWT: config.JWTConfig{Secret: "test-secret", ExpiresHours: 1, Algorithm: algorithm}},
+		})
+		require.NoError(t, err)
+		return a
+	}
+	algorithm := func(token string) string {
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+		require.NoError(t, err)
+		return parsed.Method.Alg()
+	}
+
+	a := newAuth("")
+	token, _, err := a.GenerateToken(1, "admin", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmRS256, algorithm(token))
+	jwk := a.PublicJWK()
+	assert.Equal(t, "RSA", jwk.KeyType)
+	assert.Equal(t, "AQAB", jwk.Exponent)
+
+	// Tokens signed with the shared secret are rejected unless HS256 is
+	// configured, as holders of the secret could forge them
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.jwtSecret)
+	require.NoError(t, err)
+	_, err = a.ValidateToken(legacy)
+	assert.Error(t, err)
+
+	// Tokens of other issuers signed with the same key, such as OpenID
+	// Connect tokens, are not console tokens
+	foreign := *claims
+	foreign.Issuer = "https://console.example.com"
+	signed, err := a.SignClaims(&foreign)
+	require.NoError(t, err)
+	_, err = a.ValidateToken(signed)
+	assert.Error(t, err)
+
+	ed := newAuth(AlgorithmEdDSA)
+	token, _, err = ed.GenerateToken(1, "admin", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmEdDSA, algorithm(token))
+	_, err = ed.ValidateToken(token)
+	assert.NoError(t, err)
+	jwk = ed.PublicJWK()
+	assert.Equal(t, "OKP", jwk.KeyType)
+	assert.Equal(t, "Ed25519", jwk.Curve)
+	assert.Empty(t, jwk.Modulus)
+	_, err = a.ValidateToken(token)
+	assert.Error(t, err)
+
+	hs := newAuth(AlgorithmHS256)
+	token, _, err = hs.GenerateToken(1, "admin", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmHS256, algorithm(token))
+	_, err = hs.ValidateToken(token)
+	assert.NoError(t, err)
+	legacy, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(hs.jwtSecret)
+	require.NoError(t, err)
+	_, err = hs.ValidateToken(legacy)
+	assert.NoError(t, err)
+	_, err = hs.SignClaims(&foreign)
+	assert.NoError(t, err, "OpenID Connect tokens are still signed with the key pair")
+}
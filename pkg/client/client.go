@@ -0,0 +1,306 @@
+// Package client calls the APIs of the orchestrator, probe and snap daemons,
+// the management API of the gate and the console endpoints daemons report
+// to, so that the console and tools do not build requests by hand. Failed requests return an *Error, which errors.Is
+// matches against ErrNotFound, ErrForbidden, ErrUnauthorized and ErrServer.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/events"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/tracing"
+)
+
+const (
+	// DefaultTimeout bounds each request when no HTTP client is given
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultRetries is how many times a request that failed to reach the
+	// daemon is sent again
+	DefaultRetries = 2
+
+	// DefaultRetryDelay is the wait before the first retry, which doubles
+	// for each one after it
+	DefaultRetryDelay = 200 * time.Millisecond
+
+	// maxErrorBody limits how much of an error response is read
+	maxErrorBody = 64 << 10
+)
+
+var (
+	// ErrUnauthorized matches errors for 401 responses
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden matches errors for 403 responses
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound matches errors for 404 responses
+	ErrNotFound = errors.New("not found")
+	// ErrServer matches errors for 5xx responses
+	ErrServer = errors.New("server error")
+)
+
+// Error is a response from a daemon with an error status
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string // the error field of the response, or its body
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Unwrap returns the sentinel error for the status, if there is one
+func (e *Error) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode >= 500:
+		return ErrServer
+	}
+	return nil
+}
+
+// Option configures a client
+type Option func(*base)
+
+// WithHTTPClient sends requests with client instead of one with
+// DefaultTimeout
+func WithHTTPClient(client *http.Client) Option {
+	return func(b *base) {
+		b.http = client
+	}
+}
+
+// WithRetries sets how many times a request that failed to reach the daemon
+// is sent again, and the wait before the first retry. Zero retries disables
+// them.
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(b *base) {
+		b.retries = retries
+		b.retryDelay = delay
+	}
+}
+
+// base sends the requests of every client
+type base struct {
+	baseURL    string
+	token      string
+	http       *http.Client
+	retries    int
+	retryDelay time.Duration
+}
+
+func newBase(baseURL, token string, opts []Option) base {
+	b := base{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		http:       &http.Client{Timeout: DefaultTimeout},
+		retries:    DefaultRetries,
+		retryDelay: DefaultRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(&b)
+	}
+	return b
+}
+
+// do sends a request with body, if not nil, as JSON and decodes the
+// response into out, if not nil. Requests that could not connect are
+// retried, as are idempotent ones that failed without a response.
+func (b *base) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("%s %s: failed to encode request: %w", method, path, err)
+		}
+	}
+
+	target := b.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	delay := b.retryDelay
+	for attempt := 0; ; attempt++ {
+		resp, err := b.send(ctx, method, target, payload)
+		if err == nil {
+			return decodeResponse(resp, method, path, out)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s %s: %w", method, path, ctx.Err())
+		}
+		if attempt >= b.retries || !retryable(method, err) {
+			return fmt.Errorf("%s %s: %w", method, path, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s %s: %w", method, path, ctx.Err())
+		}
+		delay *= 2
+	}
+}
+
+func (b *base) send(ctx context.Context, method, target string, payload []byte) (*http.Response, error) {
+	req, err := b.newRequest(ctx, method, target, payload)
+	if err != nil {
+		return nil, err
+	}
+	return b.http.Do(req)
+}
+
+// newRequest creates a request to the daemon with the token, request ID,
+// organization scope and trace context of ctx
+func (b *base) newRequest(ctx context.Context, method, target string, payload []byte) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+	// The daemon only sees the organization the caller is scoped to
+	if orgID, scoped := database.OrgScope(ctx); scoped {
+		req.Header.Set(database.OrgHeader, orgID)
+	}
+	if tracing.Enabled() {
+		tracing.Inject(ctx, req.Header)
+	}
+	return req, nil
+}
+
+// retryable reports whether a request that failed without a response may be
+// sent again. One that could not connect never reached the daemon; others
+// are only repeated when that is harmless.
+func retryable(method string, err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// decodeResponse decodes a successful response into out, or turns an error
+// response into an *Error
+func decodeResponse(resp *http.Response, method, path string, out interface{}) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		var body struct {
+			Error string `json:"error"`
+		}
+		message := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &body) == nil && body.Error != "" {
+			message = body.Error
+		}
+		return &Error{Method: method, Path: path, StatusCode: resp.StatusCode, Message: message}
+	}
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: failed to decode response: %w", method, path, err)
+	}
+	return nil
+}
+
+// Message is the response of an operation that only reports its outcome
+type Message struct {
+	Message string `json:"message"`
+}
+
+// health returns the detailed health report of a daemon
+func (b *base) health(ctx context.Context) (*health.Report, error) {
+	var report health.Report
+	if err := b.do(ctx, http.MethodGet, "/health", nil, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// events streams the events of a daemon to handle until ctx is done, the
+// daemon ends the stream, which returns nil, or handle fails. The stream is
+// not retried and outlives the client's timeout.
+func (b *base) events(ctx context.Context, query events.Query, handle func(events.Event) error) error {
+	const path = "/api/v1/events/stream"
+	target := b.baseURL + path
+	if len(query.Types) > 0 {
+		target += "?" + url.Values{"types": {strings.Join(query.Types, ",")}}.Encode()
+	}
+
+	req, err := b.newRequest(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", path, err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if query.LastEventID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(query.LastEventID, 10))
+	}
+
+	streaming := *b.http
+	streaming.Timeout = 0
+	resp, err := streaming.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", path, err)
+	}
+	if resp.StatusCode >= 400 {
+		return decodeResponse(resp, http.MethodGet, path, nil)
+	}
+	defer resp.Body.Close()
+
+	err = events.Read(resp.Body, handle)
+	if ctx.Err() != nil {
+		return fmt.Errorf("GET %s: %w", path, ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", path, err)
+	}
+	return nil
+}
+
+// escape escapes an ID for use as a path segment
+func escape(id string) string {
+	return url.PathEscape(id)
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+)
+
+// newDaemonConfig returns the configuration of a daemon with its database
+// and directories in a temporary directory
+func newDaemonConfig(t *testing.T) *config.Config {
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Console.Database.Path = filepath.Join(dir, "infra-core.db")
+	cfg.Orchestrator.ServiceLogs.Dir = filepath.Join(dir, "logs")
+	cfg.Snap.RepoDir = filepath.Join(dir, "snapshots")
+	return cfg
+}
+
+// newDaemonDB opens the database of cfg, closed when the test ends
+func newDaemonDB(t *testing.T, cfg *config.Config) *database.DB {
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// serveDaemon serves the API registered by register the way the daemons
+// do, and returns its base URL
+func serveDaemon(t *testing.T, register func(api gin.IRouter)) string {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.RequestIDMiddleware())
+	monitor := health.New("test")
+	monitor.SetStarted()
+	monitor.RegisterRoutes(r)
+	register(r.Group("/api/v1"))
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestErrorStatuses(t *testing.T) {
+	tests := []struct {
+		status   int
+		sentinel error
+	}{
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusInternalServerError, ErrServer},
+		{http.StatusBadGateway, ErrServer},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			baseURL := serveDaemon(t, func(api gin.IRouter) {
+				api.GET("/stats", func(c *gin.Context) {
+					c.JSON(tt.status, gin.H{"error": "Nope"})
+				})
+			})
+
+			_, err := NewSnap(baseURL, "").Stats(context.Background())
+			require.Error(t, err)
+			assert.ErrorIs(t, err, tt.sentinel)
+			var apiErr *Error
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, tt.status, apiErr.StatusCode)
+			assert.Equal(t, "Nope", apiErr.Message)
+			assert.Equal(t, "/api/v1/stats", apiErr.Path)
+		})
+	}
+
+	// Other client errors match no sentinel
+	baseURL := serveDaemon(t, func(api gin.IRouter) {
+		api.GET("/stats", func(c *gin.Context) {
+			c.String(http.StatusBadRequest, "plain text")
+		})
+	})
+	_, err := NewSnap(baseURL, "").Stats(context.Background())
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "plain text", apiErr.Message)
+	for _, sentinel := range []error{ErrUnauthorized, ErrForbidden, ErrNotFound, ErrServer} {
+		assert.False(t, errors.Is(err, sentinel))
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	baseURL := serveDaemon(t, func(api gin.IRouter) {
+		api.POST("/scrub", func(c *gin.Context) {
+			headers <- c.Request.Header.Clone()
+			c.JSON(http.StatusOK, gin.H{"id": "scrub-1", "status": "running"})
+		})
+	})
+
+	ctx := logging.WithRequestID(context.Background(), "req-123")
+	task, err := NewSnap(baseURL+"/", "secret-token").Scrub(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "scrub-1", task.ID)
+
+	header := <-headers
+	assert.Equal(t, "Bearer secret-token", header.Get("Authorization"))
+	assert.Equal(t, "req-123", header.Get(logging.RequestIDHeader), "the request ID should be passed on")
+	assert.Empty(t, header.Get("Content-Type"), "requests without a body have no content type")
+	assert.NotContains(t, header, database.OrgHeader, "unscoped requests name no organization")
+
+	_, err = NewSnap(baseURL+"/", "secret-token").Scrub(database.WithOrgScope(ctx, "acme"))
+	require.NoError(t, err)
+	assert.Equal(t, "acme", (<-headers).Get(database.OrgHeader), "the organization scope should be passed on")
+}
+
+func TestRetriesConnectionErrors(t *testing.T) {
+	// Reserve a port nothing listens on, then start the daemon on it after
+	// the first attempt has failed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	var attempts atomic.Int32
+	client := NewSnap("http://"+address, "", WithRetries(5, 50*time.Millisecond))
+	client.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if attempts.Add(1) == 2 {
+			listener, err := net.Listen("tcp", address)
+			require.NoError(t, err)
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"total_snapshots": 3}`))
+			}))
+			server.Listener = listener
+			server.Start()
+			t.Cleanup(server.Close)
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	stats, err := client.Stats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.TotalSnapshots)
+	assert.Equal(t, int32(2), attempts.Load())
+
+	// Without retries the connection error is returned
+	_, err = NewSnap("http://127.0.0.1:1", "", WithRetries(0, 0)).Stats(context.Background())
+	require.Error(t, err)
+	var opErr *net.OpError
+	assert.ErrorAs(t, err, &opErr)
+}
+
+func TestRetriesStopWithContext(t *testing.T) {
+	client := NewSnap("http://127.0.0.1:1", "", WithRetries(100, time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Stats(ctx)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestRetryable(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")}
+
+	assert.True(t, retryable(http.MethodPost, dialErr), "requests that never connected are always retried")
+	assert.True(t, retryable(http.MethodGet, readErr))
+	assert.True(t, retryable(http.MethodDelete, readErr))
+	assert.False(t, retryable(http.MethodPost, readErr), "a POST may have been applied")
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,29 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// Console is a client of the console endpoints that daemons report to
+type Console struct {
+	base
+}
+
+// NewConsole creates a client of the console at baseURL, such as
+// http://localhost:8082, sending token as a bearer token if not empty
+func NewConsole(baseURL, token string, opts ...Option) *Console {
+	return &Console{base: newBase(baseURL, token, opts)}
+}
+
+// IngestMetrics records metrics in the console's metrics table. Metrics of
+// a route that has an upstream service are recorded as that service's. It
+// fails with ErrNotFound when the console has no ingest token configured.
+func (c *Console) IngestMetrics(ctx context.Context, metrics []*database.Metric) error {
+	body := struct {
+		Metrics []*database.Metric `json:"metrics"`
+	}{Metrics: metrics}
+	return c.do(ctx, http.MethodPost, "/api/v1/system/metrics/ingest", nil, body, nil)
+}
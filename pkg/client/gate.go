@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// Gate is a client of the gate's management API
+type Gate struct {
+	base
+}
+
+// NewGate creates a client of the gate's management API at baseURL, such as
+// http://localhost:9080, sending token as a bearer token if not empty
+func NewGate(baseURL, token string, opts ...Option) *Gate {
+	return &Gate{base: newBase(baseURL, token, opts)}
+}
+
+// CertificateRenewal is the response to starting a certificate renewal
+type CertificateRenewal struct {
+	Domain string `json:"domain"`
+	Status string `json:"status"`
+}
+
+// RenewCertificate starts renewing the certificate of a domain through ACME.
+// The gate renews in the background; the result shows in the certificate
+// records once the CA has issued it. It fails with ErrNotFound when the gate
+// holds no certificate for the domain.
+func (g *Gate) RenewCertificate(ctx context.Context, domain string) (*CertificateRenewal, error) {
+	var renewal CertificateRenewal
+	if err := g.do(ctx, http.MethodPost, "/certificates/"+escape(domain)+"/renew", nil, nil, &renewal); err != nil {
+		return nil, err
+	}
+	return &renewal, nil
+}
+
+// CACertificate returns the PEM encoded certificate of the gate's local CA.
+// It fails with ErrNotFound unless the gate issues self-signed certificates.
+func (g *Gate) CACertificate(ctx context.Context) ([]byte, error) {
+	var response struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := g.do(ctx, http.MethodGet, "/certificates/ca", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return []byte(response.Certificate), nil
+}
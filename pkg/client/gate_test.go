@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGateCertificates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /certificates/ca":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"certificate":"-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"}`))
+		case "POST /certificates/app.example.com/renew":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"domain":"app.example.com","status":"renewing"}`))
+		default:
+			http.Error(w, "no certificate for domain", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	gate := NewGate(server.URL, "")
+	renewal, err := gate.RenewCertificate(context.Background(), "app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &CertificateRenewal{Domain: "app.example.com", Status: "renewing"}, renewal)
+
+	_, err = gate.RenewCertificate(context.Background(), "missing.example.com")
+	assert.True(t, errors.Is(err, ErrNotFound), "got %v", err)
+	var clientErr *Error
+	require.ErrorAs(t, err, &clientErr)
+	assert.Equal(t, "no certificate for domain", clientErr.Message)
+
+	pem, err := gate.CACertificate(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n", string(pem))
+}
@@ -0,0 +1,274 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/events"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+)
+
+// Orchestrator is a client of the orchestrator daemon
+type Orchestrator struct {
+	base
+}
+
+// NewOrchestrator creates a client of the orchestrator at baseURL, such as
+// http://localhost:8084, sending token as a bearer token if not empty
+func NewOrchestrator(baseURL, token string, opts ...Option) *Orchestrator {
+	return &Orchestrator{base: newBase(baseURL, token, opts)}
+}
+
+// DeployResult is the response to a deployment
+type DeployResult struct {
+	DeploymentID string   `json:"deployment_id"`
+	Revision     int      `json:"revision"`
+	Services     []string `json:"services"` // IDs of the service instances
+	Port         int      `json:"port"`     // of the first instance, 0 for services without one
+	Status       string   `json:"status"`
+}
+
+// ServiceAction is the response to starting, stopping, restarting or
+// removing a service instance
+type ServiceAction struct {
+	ServiceID string `json:"service_id"`
+	Status    string `json:"status"`
+}
+
+// ServiceLogs are the latest log lines of a service instance
+type ServiceLogs struct {
+	ServiceID string   `json:"service_id"`
+	Logs      []string `json:"logs"`
+	Total     int      `json:"total"`
+	Tail      int      `json:"tail"`
+	Since     string   `json:"since"`
+}
+
+// LogOptions selects the log lines to return; zero values use the daemon's
+// defaults
+type LogOptions struct {
+	Tail  int
+	Since time.Time
+}
+
+// RollbackResult is the response to a rollback
+type RollbackResult struct {
+	DeploymentID         string   `json:"deployment_id"`
+	RollbackDeploymentID string   `json:"rollback_deployment_id"`
+	Revision             int      `json:"revision"`
+	TargetRevision       int      `json:"target_revision"`
+	Services             []string `json:"services"`
+	Status               string   `json:"status"`
+}
+
+// ClusterResources is the resource usage of the cluster
+type ClusterResources struct {
+	Resources *orchestrator.NodeResources `json:"cluster_resources"`
+	NodeCount int                         `json:"node_count"`
+}
+
+// SyncResult is the response to synchronizing services
+type SyncResult struct {
+	Message       string   `json:"message"`
+	SyncedCount   int      `json:"synced_count"`
+	RestoredCount int      `json:"restored_count"`
+	TotalServices int      `json:"total_services"`
+	Order         []string `json:"order"`
+}
+
+// OrchestratorCleanup is the response to cleaning up stopped services
+type OrchestratorCleanup struct {
+	Message           string `json:"message"`
+	CleanedCount      int    `json:"cleaned_count"`
+	RemainingServices int    `json:"remaining_services"`
+}
+
+// OrchestratorMetrics are the counts of services, deployments and nodes
+type OrchestratorMetrics struct {
+	Services    map[string]int `json:"services"`
+	Deployments map[string]int `json:"deployments"`
+	Nodes       map[string]int `json:"nodes"`
+	Uptime      string         `json:"uptime"`
+}
+
+// Health returns the detailed health of the orchestrator. It fails with ErrServer
+// while a critical dependency of the daemon is down.
+func (o *Orchestrator) Health(ctx context.Context) (*health.Report, error) {
+	return o.health(ctx)
+}
+
+// Events streams the orchestrator's service status changes and deployment
+// progress to handle until ctx is done, the daemon ends the stream or handle
+// fails
+func (o *Orchestrator) Events(ctx context.Context, query events.Query, handle func(events.Event) error) error {
+	return o.events(ctx, query, handle)
+}
+
+// Deploy deploys a service
+func (o *Orchestrator) Deploy(ctx context.Context, req *orchestrator.DeployRequest) (*DeployResult, error) {
+	var result DeployResult
+	if err := o.do(ctx, http.MethodPost, "/api/v1/services/deploy", nil, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// StartService starts a service instance
+func (o *Orchestrator) StartService(ctx context.Context, id string) (*ServiceAction, error) {
+	return o.serviceAction(ctx, http.MethodPost, "/api/v1/services/"+escape(id)+"/start")
+}
+
+// StopService stops a service instance
+func (o *Orchestrator) StopService(ctx context.Context, id string) (*ServiceAction, error) {
+	return o.serviceAction(ctx, http.MethodPost, "/api/v1/services/"+escape(id)+"/stop")
+}
+
+// RestartService restarts a service instance
+func (o *Orchestrator) RestartService(ctx context.Context, id string) (*ServiceAction, error) {
+	return o.serviceAction(ctx, http.MethodPost, "/api/v1/services/"+escape(id)+"/restart")
+}
+
+// RemoveService stops and removes a service instance
+func (o *Orchestrator) RemoveService(ctx context.Context, id string) (*ServiceAction, error) {
+	return o.serviceAction(ctx, http.MethodDelete, "/api/v1/services/"+escape(id))
+}
+
+func (o *Orchestrator) serviceAction(ctx context.Context, method, path string) (*ServiceAction, error) {
+	var action ServiceAction
+	if err := o.do(ctx, method, path, nil, nil, &action); err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+// ServiceStatus returns a service instance
+func (o *Orchestrator) ServiceStatus(ctx context.Context, id string) (*orchestrator.ServiceInstance, error) {
+	var service orchestrator.ServiceInstance
+	if err := o.do(ctx, http.MethodGet, "/api/v1/services/"+escape(id)+"/status", nil, nil, &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
+}
+
+// ServiceLogs returns the latest log lines of a service instance. Following
+// the logs is not supported.
+func (o *Orchestrator) ServiceLogs(ctx context.Context, id string, opts LogOptions) (*ServiceLogs, error) {
+	query := url.Values{}
+	if opts.Tail > 0 {
+		query.Set("tail", strconv.Itoa(opts.Tail))
+	}
+	if !opts.Since.IsZero() {
+		query.Set("since", opts.Since.Format(time.RFC3339))
+	}
+
+	var logs ServiceLogs
+	if err := o.do(ctx, http.MethodGet, "/api/v1/services/"+escape(id)+"/logs", query, nil, &logs); err != nil {
+		return nil, err
+	}
+	return &logs, nil
+}
+
+// ListDeployments lists the deployments
+func (o *Orchestrator) ListDeployments(ctx context.Context) ([]*orchestrator.Deployment, error) {
+	var response struct {
+		Deployments []*orchestrator.Deployment `json:"deployments"`
+	}
+	if err := o.do(ctx, http.MethodGet, "/api/v1/deployments/", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Deployments, nil
+}
+
+// GetDeployment returns a deployment
+func (o *Orchestrator) GetDeployment(ctx context.Context, id string) (*orchestrator.Deployment, error) {
+	var deployment orchestrator.Deployment
+	if err := o.do(ctx, http.MethodGet, "/api/v1/deployments/"+escape(id), nil, nil, &deployment); err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+// Rollback rolls a deployment back to the previous successful deployment of
+// its service, or to revision if it is not zero
+func (o *Orchestrator) Rollback(ctx context.Context, id string, revision int) (*RollbackResult, error) {
+	query := url.Values{}
+	if revision > 0 {
+		query.Set("revision", strconv.Itoa(revision))
+	}
+
+	var result RollbackResult
+	if err := o.do(ctx, http.MethodPost, "/api/v1/deployments/"+escape(id)+"/rollback", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DeleteDeployment deletes a deployment
+func (o *Orchestrator) DeleteDeployment(ctx context.Context, id string) error {
+	return o.do(ctx, http.MethodDelete, "/api/v1/deployments/"+escape(id), nil, nil, nil)
+}
+
+// ListNodes lists the cluster nodes
+func (o *Orchestrator) ListNodes(ctx context.Context) ([]*orchestrator.Node, error) {
+	var response struct {
+		Nodes []*orchestrator.Node `json:"nodes"`
+	}
+	if err := o.do(ctx, http.MethodGet, "/api/v1/cluster/nodes", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Nodes, nil
+}
+
+// ClusterResources returns the resource usage of the cluster
+func (o *Orchestrator) ClusterResources(ctx context.Context) (*ClusterResources, error) {
+	var resources ClusterResources
+	if err := o.do(ctx, http.MethodGet, "/api/v1/cluster/resources", nil, nil, &resources); err != nil {
+		return nil, err
+	}
+	return &resources, nil
+}
+
+// ClusterEvents returns the cluster events, newest first
+func (o *Orchestrator) ClusterEvents(ctx context.Context) ([]orchestrator.ClusterEvent, error) {
+	var response struct {
+		Events []orchestrator.ClusterEvent `json:"events"`
+	}
+	if err := o.do(ctx, http.MethodGet, "/api/v1/cluster/events", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Events, nil
+}
+
+// Sync restores services without instances and starts the instances that
+// are not running
+func (o *Orchestrator) Sync(ctx context.Context) (*SyncResult, error) {
+	var result SyncResult
+	if err := o.do(ctx, http.MethodPost, "/api/v1/control/sync", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Cleanup forgets the stopped service instances
+func (o *Orchestrator) Cleanup(ctx context.Context) (*OrchestratorCleanup, error) {
+	var result OrchestratorCleanup
+	if err := o.do(ctx, http.MethodPost, "/api/v1/control/cleanup", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Metrics returns the orchestrator metrics
+func (o *Orchestrator) Metrics(ctx context.Context) (*OrchestratorMetrics, error) {
+	var response struct {
+		Metrics OrchestratorMetrics `json:"metrics"`
+	}
+	if err := o.do(ctx, http.MethodGet, "/api/v1/control/metrics", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response.Metrics, nil
+}
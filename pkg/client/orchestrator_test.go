@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/events"
+	"github.com/last-emo-boy/infra-core/pkg/orchestrator"
+)
+
+// startOrchestrator serves a started orchestrator with the simulated runtime
+func startOrchestrator(t *testing.T) *Orchestrator {
+	cfg := newDaemonConfig(t)
+	o := orchestrator.New(newDaemonDB(t, cfg), cfg)
+	require.NoError(t, o.Start())
+	t.Cleanup(func() { o.Stop() })
+	return NewOrchestrator(serveDaemon(t, o.RegisterRoutes), "")
+}
+
+func TestOrchestratorClient(t *testing.T) {
+	client := startOrchestrator(t)
+	ctx := context.Background()
+
+	health, err := client.Health(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "healthy", health.Status)
+
+	deployed, err := client.Deploy(ctx, &orchestrator.DeployRequest{Name: "web", Image: "nginx:1.27", Port: 8080, Replicas: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "deploying", deployed.Status)
+	require.Len(t, deployed.Services, 1)
+
+	service, err := client.ServiceStatus(ctx, deployed.Services[0])
+	require.NoError(t, err)
+	assert.Equal(t, "web", service.Name)
+	assert.Equal(t, "nginx:1.27", service.Image)
+
+	deployment, err := client.GetDeployment(ctx, deployed.DeploymentID)
+	require.NoError(t, err)
+	assert.Equal(t, "web", deployment.ServiceName)
+	deployments, err := client.ListDeployments(ctx)
+	require.NoError(t, err)
+	assert.Len(t, deployments, 1)
+
+	stopped, err := client.StopService(ctx, deployed.Services[0])
+	require.NoError(t, err)
+	assert.Equal(t, "stopped", stopped.Status)
+	cleanup, err := client.Cleanup(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cleanup.CleanedCount)
+
+	_, err = client.ServiceStatus(ctx, deployed.Services[0])
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = client.RestartService(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	metrics, err := client.Metrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, metrics.Services["total"])
+	assert.Equal(t, 1, metrics.Deployments["total"])
+
+	resources, err := client.ClusterResources(ctx)
+	require.NoError(t, err)
+	assert.NotNil(t, resources.Resources)
+	events, err := client.ClusterEvents(ctx)
+	require.NoError(t, err)
+	assert.NotNil(t, events)
+}
+
+func TestOrchestratorEvents(t *testing.T) {
+	cfg := newDaemonConfig(t)
+	o := orchestrator.New(newDaemonDB(t, cfg), cfg)
+	require.NoError(t, o.Start())
+	t.Cleanup(func() { o.Stop() })
+	client := NewOrchestrator(serveDaemon(t, o.RegisterRoutes), "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Follow the stream until the deployment finished
+	received := make(chan events.Event, 100)
+	followed := make(chan error, 1)
+	go func() {
+		followed <- client.Events(ctx, events.Query{}, func(event events.Event) error {
+			received <- event
+			return nil
+		})
+	}()
+	require.Eventually(t, func() bool { return o.EventBus().Stats().Subscribers == 1 }, time.Second, 5*time.Millisecond)
+
+	deployed, err := client.Deploy(context.Background(), &orchestrator.DeployRequest{Name: "web", Image: "nginx:1.27", Port: 8080, Replicas: 1})
+	require.NoError(t, err)
+
+	var streamed []events.Event
+	var statuses []string
+	for done := false; !done; {
+		select {
+		case event := <-received:
+			streamed = append(streamed, event)
+			switch event.Type {
+			case events.DeploymentProgress:
+				var progress events.Deployment
+				require.NoError(t, json.Unmarshal(event.Data, &progress))
+				assert.Equal(t, deployed.DeploymentID, progress.DeploymentID)
+				done = progress.Status == "deployed"
+			case events.ServiceStatusChanged:
+				var status events.ServiceStatus
+				require.NoError(t, json.Unmarshal(event.Data, &status))
+				assert.Equal(t, "web", status.Service)
+				statuses = append(statuses, status.NewStatus)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("deployment did not finish")
+		}
+	}
+	for i, event := range streamed {
+		assert.Equal(t, uint64(i+1), event.ID, "events arrive in order")
+		assert.Equal(t, "orchestrator", event.Source)
+	}
+	assert.Contains(t, statuses, "running")
+	cancel()
+	assert.ErrorIs(t, <-followed, context.Canceled)
+
+	// Reconnecting replays the events after the last one seen
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var replayed []uint64
+	err = client.Events(ctx, events.Query{Types: []string{events.DeploymentProgress}, LastEventID: 1}, func(event events.Event) error {
+		replayed = append(replayed, event.ID)
+		if event.ID == streamed[len(streamed)-1].ID {
+			cancel()
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	require.NotEmpty(t, replayed)
+	assert.Greater(t, replayed[0], uint64(1))
+	assert.IsIncreasing(t, replayed)
+
+	err = client.Events(context.Background(), events.Query{Types: []string{"service.created"}}, func(events.Event) error { return nil })
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+}
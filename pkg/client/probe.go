@@ -0,0 +1,366 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/events"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/probe"
+)
+
+// Probe is a client of the probe daemon
+type Probe struct {
+	base
+}
+
+// NewProbe creates a client of the probe daemon at baseURL, such as
+// http://localhost:8085, sending token as a bearer token if not empty
+func NewProbe(baseURL, token string, opts ...Option) *Probe {
+	return &Probe{base: newBase(baseURL, token, opts)}
+}
+
+// ResultQuery selects probe results: those of the last Hours hours, or all
+// when zero, and at most Limit of them, or the daemon's default when zero
+type ResultQuery struct {
+	Hours int
+	Limit int
+}
+
+func (q ResultQuery) values() url.Values {
+	query := url.Values{}
+	if q.Hours != 0 {
+		query.Set("hours", strconv.Itoa(q.Hours))
+	}
+	if q.Limit != 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return query
+}
+
+// AlertQuery selects active alerts: those seen in the last Hours hours, or
+// all when zero, and at most Limit of them, the daemon's default when zero
+// or every one when negative
+type AlertQuery struct {
+	Hours int
+	Limit int
+}
+
+// SLAQuery selects the period of an SLA report, the last 30 days when From
+// and To are zero, split by Granularity, day by default. Percentile is that
+// of the response times reported, 99 when zero, and CountUnmonitored
+// overrides whether unmonitored time counts as downtime.
+type SLAQuery struct {
+	From             time.Time
+	To               time.Time
+	Granularity      string
+	Percentile       float64
+	CountUnmonitored *bool
+}
+
+func (q SLAQuery) values() url.Values {
+	query := url.Values{}
+	if !q.From.IsZero() {
+		query.Set("from", q.From.UTC().Format(time.RFC3339))
+	}
+	if !q.To.IsZero() {
+		query.Set("to", q.To.UTC().Format(time.RFC3339))
+	}
+	if q.Granularity != "" {
+		query.Set("granularity", q.Granularity)
+	}
+	if q.Percentile != 0 {
+		query.Set("percentile", strconv.FormatFloat(q.Percentile, 'f', -1, 64))
+	}
+	if q.CountUnmonitored != nil {
+		query.Set("count_unmonitored", strconv.FormatBool(*q.CountUnmonitored))
+	}
+	return query
+}
+
+// ServiceHealthDetail is the probes of a service and their results, keyed
+// by probe
+type ServiceHealthDetail struct {
+	ServiceID string                          `json:"service_id"`
+	Probes    []*probe.ProbeConfig            `json:"probes"`
+	Results   map[string][]*probe.ProbeResult `json:"results"`
+}
+
+// HealthOverview summarizes the health of every probe
+type HealthOverview struct {
+	OverallStatus   string    `json:"overall_status"`
+	TotalProbes     int       `json:"total_probes"`
+	EnabledProbes   int       `json:"enabled_probes"`
+	HealthyProbes   int       `json:"healthy_probes"`
+	UnhealthyProbes int       `json:"unhealthy_probes"`
+	ActiveAlerts    int       `json:"active_alerts"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// ProbeCleanup is the response to removing old results and resolved alerts
+type ProbeCleanup struct {
+	Message       string `json:"message"`
+	PrunedResults int    `json:"pruned_results"`
+	PrunedAlerts  int    `json:"pruned_alerts"`
+}
+
+// MonitorMetrics are the counts of probes, results and alerts
+type MonitorMetrics struct {
+	Probes  map[string]int `json:"probes"`
+	Results map[string]int `json:"results"`
+	Alerts  map[string]int `json:"alerts"`
+	Uptime  string         `json:"uptime"`
+}
+
+// Health returns the detailed health of the probe daemon. It fails with ErrServer
+// while a critical dependency of the daemon is down.
+func (p *Probe) Health(ctx context.Context) (*health.Report, error) {
+	return p.health(ctx)
+}
+
+// Events streams the alerts the probe daemon creates and resolves to handle
+// until ctx is done, the daemon ends the stream or handle fails
+func (p *Probe) Events(ctx context.Context, query events.Query, handle func(events.Event) error) error {
+	return p.events(ctx, query, handle)
+}
+
+// CreateProbe creates a probe
+func (p *Probe) CreateProbe(ctx context.Context, req *probe.CreateProbeRequest) (*probe.ProbeConfig, error) {
+	var response struct {
+		Probe *probe.ProbeConfig `json:"probe"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/api/v1/probes/", nil, req, &response); err != nil {
+		return nil, err
+	}
+	return response.Probe, nil
+}
+
+// SyncServiceProbes creates a health probe for every registered service with
+// a health URL that is not probed yet, and returns the probes created
+func (p *Probe) SyncServiceProbes(ctx context.Context) ([]*probe.ProbeConfig, error) {
+	var response struct {
+		Created []*probe.ProbeConfig `json:"created"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/api/v1/probes/sync-services", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Created, nil
+}
+
+// ListProbes lists the probes
+func (p *Probe) ListProbes(ctx context.Context) ([]*probe.ProbeConfig, error) {
+	var response struct {
+		Probes []*probe.ProbeConfig `json:"probes"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/api/v1/probes/", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Probes, nil
+}
+
+// GetProbe returns a probe
+func (p *Probe) GetProbe(ctx context.Context, id string) (*probe.ProbeConfig, error) {
+	var config probe.ProbeConfig
+	if err := p.do(ctx, http.MethodGet, "/api/v1/probes/"+escape(id), nil, nil, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// UpdateProbe replaces the configuration of a probe
+func (p *Probe) UpdateProbe(ctx context.Context, id string, req *probe.CreateProbeRequest) (*probe.ProbeConfig, error) {
+	var response struct {
+		Probe *probe.ProbeConfig `json:"probe"`
+	}
+	if err := p.do(ctx, http.MethodPut, "/api/v1/probes/"+escape(id), nil, req, &response); err != nil {
+		return nil, err
+	}
+	return response.Probe, nil
+}
+
+// DeleteProbe deletes a probe
+func (p *Probe) DeleteProbe(ctx context.Context, id string) error {
+	return p.do(ctx, http.MethodDelete, "/api/v1/probes/"+escape(id), nil, nil, nil)
+}
+
+// EnableProbe enables a probe
+func (p *Probe) EnableProbe(ctx context.Context, id string) error {
+	return p.do(ctx, http.MethodPost, "/api/v1/probes/"+escape(id)+"/enable", nil, nil, nil)
+}
+
+// DisableProbe disables a probe
+func (p *Probe) DisableProbe(ctx context.Context, id string) error {
+	return p.do(ctx, http.MethodPost, "/api/v1/probes/"+escape(id)+"/disable", nil, nil, nil)
+}
+
+// ListResults returns the latest results of every probe, at most limit of
+// them or the daemon's default when zero
+func (p *Probe) ListResults(ctx context.Context, limit int) ([]*probe.ProbeResult, error) {
+	query := url.Values{}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var response struct {
+		Results []*probe.ProbeResult `json:"results"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/api/v1/results/", query, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+// ProbeResults returns the results of a probe, newest first
+func (p *Probe) ProbeResults(ctx context.Context, probeID string, q ResultQuery) ([]*probe.ProbeResult, error) {
+	var response struct {
+		Results []*probe.ProbeResult `json:"results"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/api/v1/results/"+escape(probeID), q.values(), nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+// LatestResult returns the latest result of a probe
+func (p *Probe) LatestResult(ctx context.Context, probeID string) (*probe.ProbeResult, error) {
+	var result probe.ProbeResult
+	if err := p.do(ctx, http.MethodGet, "/api/v1/results/"+escape(probeID)+"/latest", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ProbeMetrics returns the aggregated metrics of a probe
+func (p *Probe) ProbeMetrics(ctx context.Context, probeID string) (*probe.ProbeMetrics, error) {
+	var metrics probe.ProbeMetrics
+	if err := p.do(ctx, http.MethodGet, "/api/v1/results/"+escape(probeID)+"/metrics", nil, nil, &metrics); err != nil {
+		return nil, err
+	}
+	return &metrics, nil
+}
+
+// ProbeHistory returns the results of a probe over the last Hours hours,
+// the last day when zero
+func (p *Probe) ProbeHistory(ctx context.Context, probeID string, q ResultQuery) ([]*probe.ProbeResult, error) {
+	var response struct {
+		History []*probe.ProbeResult `json:"history"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/api/v1/results/"+escape(probeID)+"/history", q.values(), nil, &response); err != nil {
+		return nil, err
+	}
+	return response.History, nil
+}
+
+// ProbeSLA reports the uptime of a probe
+func (p *Probe) ProbeSLA(ctx context.Context, probeID string, q SLAQuery) (*probe.SLAReport, error) {
+	var report probe.SLAReport
+	if err := p.do(ctx, http.MethodGet, "/api/v1/results/"+escape(probeID)+"/sla", q.values(), nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// TagSLA reports the uptime of the probes with a tag
+func (p *Probe) TagSLA(ctx context.Context, tag string, q SLAQuery) (*probe.SLAReport, error) {
+	query := q.values()
+	query.Set("tag", tag)
+
+	var report probe.SLAReport
+	if err := p.do(ctx, http.MethodGet, "/api/v1/health/sla", query, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ServiceHealth returns the health of the services the probes are tagged
+// with, keyed by service
+func (p *Probe) ServiceHealth(ctx context.Context) (map[string]interface{}, error) {
+	var response struct {
+		Services map[string]interface{} `json:"services"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/api/v1/health/services", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Services, nil
+}
+
+// ServiceHealthDetail returns the probes of a service and their results
+func (p *Probe) ServiceHealthDetail(ctx context.Context, serviceID string) (*ServiceHealthDetail, error) {
+	var detail ServiceHealthDetail
+	if err := p.do(ctx, http.MethodGet, "/api/v1/health/services/"+escape(serviceID), nil, nil, &detail); err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
+// HealthOverview summarizes the health of every probe
+func (p *Probe) HealthOverview(ctx context.Context) (*HealthOverview, error) {
+	var overview HealthOverview
+	if err := p.do(ctx, http.MethodGet, "/api/v1/health/overview", nil, nil, &overview); err != nil {
+		return nil, err
+	}
+	return &overview, nil
+}
+
+// ActiveAlerts returns the active alerts, including those raised before the
+// daemon last restarted
+func (p *Probe) ActiveAlerts(ctx context.Context, q AlertQuery) ([]*probe.Alert, error) {
+	query := url.Values{}
+	if q.Hours != 0 {
+		query.Set("hours", strconv.Itoa(q.Hours))
+	}
+	if q.Limit != 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	var response struct {
+		Alerts []*probe.Alert `json:"alerts"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/api/v1/health/alerts", query, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Alerts, nil
+}
+
+// Scan runs every probe now, without waiting for the results
+func (p *Probe) Scan(ctx context.Context) error {
+	return p.do(ctx, http.MethodPost, "/api/v1/control/scan", nil, nil, nil)
+}
+
+// Cleanup removes old results and resolved alerts
+func (p *Probe) Cleanup(ctx context.Context) (*ProbeCleanup, error) {
+	var result ProbeCleanup
+	if err := p.do(ctx, http.MethodPost, "/api/v1/control/cleanup", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Metrics returns the probe monitor metrics
+func (p *Probe) Metrics(ctx context.Context) (*MonitorMetrics, error) {
+	var response struct {
+		Metrics MonitorMetrics `json:"metrics"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/api/v1/control/metrics", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response.Metrics, nil
+}
+
+// Status returns the detailed status of the probe monitor
+func (p *Probe) Status(ctx context.Context) (map[string]interface{}, error) {
+	var status map[string]interface{}
+	if err := p.do(ctx, http.MethodGet, "/api/v1/control/status", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// TestNotification sends a test message through a notification channel
+func (p *Probe) TestNotification(ctx context.Context, channel, message string) error {
+	req := probe.TestNotificationRequest{Channel: channel, Message: message}
+	return p.do(ctx, http.MethodPost, "/api/v1/control/notifications/test", nil, &req, nil)
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/probe"
+)
+
+// startProbe serves a probe monitor that does not run its probes, and
+// returns a client of it and the monitor's database
+func startProbe(t *testing.T) (*Probe, *database.DB) {
+	cfg := newDaemonConfig(t)
+	db := newDaemonDB(t, cfg)
+	monitor := probe.New(db, cfg)
+	return NewProbe(serveDaemon(t, monitor.RegisterRoutes), ""), db
+}
+
+func TestProbeClient(t *testing.T) {
+	client, _ := startProbe(t)
+	ctx := context.Background()
+
+	created, err := client.CreateProbe(ctx, &probe.CreateProbeRequest{
+		Name:     "portal",
+		Type:     "http",
+		Target:   "http://portal.internal/health",
+		Interval: "30s",
+		Tags:     []string{"portal"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, created.Interval, "durations should survive the round trip")
+	assert.Equal(t, 200, created.ExpectedStatus)
+
+	fetched, err := client.GetProbe(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "portal", fetched.Name)
+	probes, err := client.ListProbes(ctx)
+	require.NoError(t, err)
+	assert.Len(t, probes, 1)
+
+	require.NoError(t, client.DisableProbe(ctx, created.ID))
+	overview, err := client.HealthOverview(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, overview.TotalProbes)
+	assert.Equal(t, 0, overview.EnabledProbes)
+
+	detail, err := client.ServiceHealthDetail(ctx, "portal")
+	require.NoError(t, err)
+	assert.Len(t, detail.Probes, 1)
+
+	_, err = client.LatestResult(ctx, created.ID)
+	assert.ErrorIs(t, err, ErrNotFound, "a probe that never ran has no results")
+
+	require.NoError(t, client.DeleteProbe(ctx, created.ID))
+	_, err = client.GetProbe(ctx, created.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, client.EnableProbe(ctx, created.ID), ErrNotFound)
+
+	err = client.TestNotification(ctx, "missing", "")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestProbeClientActiveAlerts(t *testing.T) {
+	client, db := startProbe(t)
+	ctx := context.Background()
+
+	// Alerts raised before the daemon restarted are served from the database
+	now := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, db.ProbeAlertRepository().UpsertBatch([]*database.ProbeAlert{
+		{ID: "a1", ProbeID: "p1", Type: "availability", Severity: "critical", Status: "active", Message: "down", Count: 2, FirstSeen: now.Add(-3 * time.Hour), LastSeen: now.Add(-2 * time.Hour)},
+		{ID: "a2", ProbeID: "p2", Type: "performance", Severity: "medium", Status: "active", Message: "slow", Count: 1, FirstSeen: now, LastSeen: now},
+		{ID: "a3", ProbeID: "p3", Type: "performance", Severity: "low", Status: "resolved", Message: "slow", Count: 1, FirstSeen: now, LastSeen: now, ResolvedAt: &now},
+	}))
+
+	alerts, err := client.ActiveAlerts(ctx, AlertQuery{Limit: -1})
+	require.NoError(t, err)
+	require.Len(t, alerts, 2)
+	severities := map[string]bool{}
+	for _, alert := range alerts {
+		severities[alert.Severity] = true
+	}
+	assert.Equal(t, map[string]bool{"critical": true, "medium": true}, severities)
+
+	recent, err := client.ActiveAlerts(ctx, AlertQuery{Hours: 1})
+	require.NoError(t, err)
+	require.Len(t, recent, 1)
+	assert.Equal(t, "a2", recent[0].ID)
+}
+
+func TestProbeClientSyncServiceProbes(t *testing.T) {
+	client, db := startProbe(t)
+	ctx := context.Background()
+
+	healthURL := "http://wiki.internal/healthz"
+	require.NoError(t, db.RegisteredServiceRepository().Create(&database.RegisteredService{
+		ID: "wiki-id", Name: "wiki", DisplayName: "Wiki", ServiceURL: "http://wiki.internal", HealthURL: &healthURL,
+		Category: "web", RequiredRole: "user", Status: database.RegisteredServiceActive,
+	}))
+
+	created, err := client.SyncServiceProbes(ctx)
+	require.NoError(t, err)
+	require.Len(t, created, 1)
+	assert.Equal(t, &probe.ServiceRef{Type: probe.ServiceRefRegistered, ID: "wiki-id", Endpoint: probe.EndpointHealth}, created[0].ServiceRef)
+
+	created, err = client.SyncServiceProbes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, created)
+}
+
+func TestProbeClientSLA(t *testing.T) {
+	client, db := startProbe(t)
+	ctx := context.Background()
+
+	created, err := client.CreateProbe(ctx, &probe.CreateProbeRequest{
+		Name: "portal", Type: "http", Target: "http://portal.internal/health", Interval: "1m", Tags: []string{"portal"},
+	})
+	require.NoError(t, err)
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, db.ProbeResultRepository().InsertBatch([]*database.ProbeResult{
+		{ID: "r1", ProbeID: created.ID, Status: "success", ResponseTime: int64(time.Millisecond), Timestamp: day.Add(time.Hour)},
+		{ID: "r2", ProbeID: created.ID, Status: "failure", Timestamp: day.Add(time.Hour + time.Minute)},
+		{ID: "r3", ProbeID: created.ID, Status: "success", ResponseTime: int64(time.Millisecond), Timestamp: day.Add(time.Hour + 2*time.Minute)},
+	}))
+
+	counted := true
+	query := SLAQuery{From: day, To: day.AddDate(0, 0, 7), Granularity: probe.GranularityWeek, CountUnmonitored: &counted}
+	report, err := client.ProbeSLA(ctx, created.ID, query)
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, report.ProbeID)
+	require.Len(t, report.Buckets, 2)
+	assert.Equal(t, 1, report.Summary.Incidents)
+	assert.Equal(t, 1.0, report.Summary.DowntimeMinutes-report.Summary.UnmonitoredMinutes)
+
+	report, err = client.TagSLA(ctx, "portal", query)
+	require.NoError(t, err)
+	assert.Equal(t, []string{created.ID}, report.Probes)
+
+	_, err = client.TagSLA(ctx, "missing", query)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,377 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/events"
+	"github.com/last-emo-boy/infra-core/pkg/health"
+	"github.com/last-emo-boy/infra-core/pkg/snap"
+)
+
+// Snap is a client of the snap daemon
+type Snap struct {
+	base
+}
+
+// NewSnap creates a client of the snap daemon at baseURL, such as
+// http://localhost:8086, sending token as a bearer token if not empty
+func NewSnap(baseURL, token string, opts ...Option) *Snap {
+	return &Snap{base: newBase(baseURL, token, opts)}
+}
+
+// Plan is a backup plan
+type Plan struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	CronExpr    string     `json:"cron_expr"`
+	Paths       []string   `json:"paths"`
+	KeepDaily   int        `json:"keep_daily"`
+	KeepWeekly  int        `json:"keep_weekly"`
+	KeepMonthly int        `json:"keep_monthly"`
+	Enabled     bool       `json:"enabled"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	NextRun     *time.Time `json:"next_run,omitempty"` // set for enabled plans
+}
+
+// Snapshot is a snapshot taken by a plan
+type Snapshot struct {
+	ID           string    `json:"id"`
+	PlanID       string    `json:"plan_id"`
+	PlanName     string    `json:"plan_name,omitempty"` // only set in lists
+	Timestamp    time.Time `json:"timestamp"`
+	ManifestPath string    `json:"manifest_path"`
+	Size         int64     `json:"size"`
+	Kind         string    `json:"kind"`
+	ParentID     string    `json:"parent_id"`
+	Status       string    `json:"status"`
+}
+
+// SnapshotQuery selects snapshots; zero values select all of them, up to
+// the daemon's default limit
+type SnapshotQuery struct {
+	PlanID string
+	Status string
+	Limit  int
+	Offset int
+}
+
+// Task is the response to starting a snapshot or scrub
+type Task struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// TaskStatus is the status of a snapshot, with the live progress of a
+// running one
+type TaskStatus struct {
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Status   string             `json:"status"`
+	Progress float64            `json:"progress"`
+	Message  string             `json:"message,omitempty"`
+	Started  time.Time          `json:"started"`
+	Details  *snap.TaskProgress `json:"details,omitempty"`
+}
+
+// BlockCleanup is the response to removing blocks no snapshot refers to
+type BlockCleanup struct {
+	Message       string `json:"message"`
+	BlocksRemoved int    `json:"blocks_removed"`
+	SpaceFreed    int64  `json:"space_freed"`
+}
+
+// SnapStats are the totals of the snapshot repository
+type SnapStats struct {
+	TotalSnapshots int64 `json:"total_snapshots"`
+	TotalSize      int64 `json:"total_size"`
+	TotalPlans     int64 `json:"total_plans"`
+	ActivePlans    int64 `json:"active_plans"`
+	RunningTasks   int   `json:"running_tasks"`
+
+	// Storage is the space the repository uses and has left
+	Storage snap.StorageUsage `json:"storage"`
+
+	// Blocks compares the data of the snapshots with what the repository
+	// stores for it, after deduplication and compression
+	Blocks snap.BlockStats `json:"blocks"`
+}
+
+// ScrubStatus is the status of repository scrubs
+type ScrubStatus struct {
+	Status        string    `json:"status"`
+	LastRun       time.Time `json:"last_run"`
+	BlocksChecked int       `json:"blocks_checked"`
+	ErrorsFound   int       `json:"errors_found"`
+}
+
+// Health returns the detailed health of the snap daemon. It fails with ErrServer
+// while a critical dependency of the daemon is down.
+func (s *Snap) Health(ctx context.Context) (*health.Report, error) {
+	return s.health(ctx)
+}
+
+// Events streams the snapshots the snap daemon completes to handle until
+// ctx is done, the daemon ends the stream or handle fails
+func (s *Snap) Events(ctx context.Context, query events.Query, handle func(events.Event) error) error {
+	return s.events(ctx, query, handle)
+}
+
+// CreatePlan creates a backup plan
+func (s *Snap) CreatePlan(ctx context.Context, req *snap.CreatePlanRequest) (*Plan, error) {
+	var plan Plan
+	if err := s.do(ctx, http.MethodPost, "/api/v1/plans", nil, req, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// ListPlans lists the backup plans, newest first
+func (s *Snap) ListPlans(ctx context.Context) ([]*Plan, error) {
+	var response struct {
+		Plans []*Plan `json:"plans"`
+	}
+	if err := s.do(ctx, http.MethodGet, "/api/v1/plans", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Plans, nil
+}
+
+// GetPlan returns a backup plan
+func (s *Snap) GetPlan(ctx context.Context, id string) (*Plan, error) {
+	var plan Plan
+	if err := s.do(ctx, http.MethodGet, "/api/v1/plans/"+escape(id), nil, nil, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// UpdatePlan updates the fields of a backup plan set in req
+func (s *Snap) UpdatePlan(ctx context.Context, id string, req *snap.UpdatePlanRequest) error {
+	return s.do(ctx, http.MethodPut, "/api/v1/plans/"+escape(id), nil, req, nil)
+}
+
+// DeletePlan deletes a backup plan
+func (s *Snap) DeletePlan(ctx context.Context, id string) error {
+	return s.do(ctx, http.MethodDelete, "/api/v1/plans/"+escape(id), nil, nil, nil)
+}
+
+// EnablePlan enables a backup plan
+func (s *Snap) EnablePlan(ctx context.Context, id string) error {
+	return s.do(ctx, http.MethodPost, "/api/v1/plans/"+escape(id)+"/enable", nil, nil, nil)
+}
+
+// DisablePlan disables a backup plan
+func (s *Snap) DisablePlan(ctx context.Context, id string) error {
+	return s.do(ctx, http.MethodPost, "/api/v1/plans/"+escape(id)+"/disable", nil, nil, nil)
+}
+
+// PlanRuns returns the latest scheduled runs of a backup plan, at most limit
+// of them or the daemon's default when zero
+func (s *Snap) PlanRuns(ctx context.Context, id string, limit int) ([]snap.PlanRun, error) {
+	query := url.Values{}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var response struct {
+		Runs []snap.PlanRun `json:"runs"`
+	}
+	if err := s.do(ctx, http.MethodGet, "/api/v1/plans/"+escape(id)+"/runs", query, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Runs, nil
+}
+
+// CreateSnapshot starts taking a snapshot
+func (s *Snap) CreateSnapshot(ctx context.Context, req *snap.CreateSnapshotRequest) (*Task, error) {
+	var task Task
+	if err := s.do(ctx, http.MethodPost, "/api/v1/snapshots", nil, req, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ListSnapshots lists snapshots, newest first
+func (s *Snap) ListSnapshots(ctx context.Context, q SnapshotQuery) ([]*Snapshot, error) {
+	query := url.Values{}
+	if q.PlanID != "" {
+		query.Set("plan_id", q.PlanID)
+	}
+	if q.Status != "" {
+		query.Set("status", q.Status)
+	}
+	if q.Limit != 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset != 0 {
+		query.Set("offset", strconv.Itoa(q.Offset))
+	}
+
+	var response struct {
+		Snapshots []*Snapshot `json:"snapshots"`
+	}
+	if err := s.do(ctx, http.MethodGet, "/api/v1/snapshots", query, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Snapshots, nil
+}
+
+// GetSnapshot returns a snapshot
+func (s *Snap) GetSnapshot(ctx context.Context, id string) (*Snapshot, error) {
+	var snapshot Snapshot
+	if err := s.do(ctx, http.MethodGet, "/api/v1/snapshots/"+escape(id), nil, nil, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// DeleteSnapshot deletes a snapshot and the blocks only it refers to
+func (s *Snap) DeleteSnapshot(ctx context.Context, id string) (*BlockCleanup, error) {
+	var result BlockCleanup
+	if err := s.do(ctx, http.MethodDelete, "/api/v1/snapshots/"+escape(id), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SnapshotStatus returns the status of a snapshot. Streaming its progress is
+// not supported.
+func (s *Snap) SnapshotStatus(ctx context.Context, id string) (*TaskStatus, error) {
+	var status TaskStatus
+	if err := s.do(ctx, http.MethodGet, "/api/v1/snapshots/"+escape(id)+"/status", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// VerifySnapshot verifies a snapshot
+func (s *Snap) VerifySnapshot(ctx context.Context, id string) (*Task, error) {
+	var task Task
+	if err := s.do(ctx, http.MethodPost, "/api/v1/snapshots/"+escape(id)+"/verify", nil, nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// SnapshotFiles is a page of the files of a directory in a snapshot
+type SnapshotFiles struct {
+	SnapshotID string             `json:"snapshot_id"`
+	Path       string             `json:"path"`
+	Recursive  bool               `json:"recursive"`
+	Files      []snap.BrowseEntry `json:"files"`
+	Total      int                `json:"total"`
+	Limit      int                `json:"limit"`
+	Offset     int                `json:"offset"`
+}
+
+// FileQuery selects the files of a snapshot: those of the directory Path, or
+// of the snapshot paths when empty, and with Recursive every file under it
+type FileQuery struct {
+	Path      string
+	Recursive bool
+	Limit     int
+	Offset    int
+}
+
+// ListSnapshotFiles lists the files of a directory in a snapshot
+func (s *Snap) ListSnapshotFiles(ctx context.Context, id string, q FileQuery) (*SnapshotFiles, error) {
+	query := url.Values{}
+	if q.Path != "" {
+		query.Set("path", q.Path)
+	}
+	if q.Recursive {
+		query.Set("recursive", "true")
+	}
+	if q.Limit != 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset != 0 {
+		query.Set("offset", strconv.Itoa(q.Offset))
+	}
+
+	var files SnapshotFiles
+	if err := s.do(ctx, http.MethodGet, "/api/v1/snapshots/"+escape(id)+"/files", query, nil, &files); err != nil {
+		return nil, err
+	}
+	return &files, nil
+}
+
+// RestoreFile restores a file or directory of a snapshot and returns once it
+// is restored
+func (s *Snap) RestoreFile(ctx context.Context, id string, req *snap.RestoreFileRequest) (*snap.FileRestore, error) {
+	var result snap.FileRestore
+	if err := s.do(ctx, http.MethodPost, "/api/v1/snapshots/"+escape(id)+"/restore-file", nil, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Restore starts restoring a snapshot
+func (s *Snap) Restore(ctx context.Context, req *snap.RestoreRequest) (*snap.RestoreJob, error) {
+	var job snap.RestoreJob
+	if err := s.do(ctx, http.MethodPost, "/api/v1/restore", nil, req, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RestoreStatus returns a restore, with the live progress of a running one
+func (s *Snap) RestoreStatus(ctx context.Context, id string) (*snap.RestoreJob, error) {
+	var job snap.RestoreJob
+	if err := s.do(ctx, http.MethodGet, "/api/v1/restore/"+escape(id)+"/status", nil, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CancelRestore cancels a restore and waits until its partial output is
+// removed
+func (s *Snap) CancelRestore(ctx context.Context, id string) (*Task, error) {
+	var task Task
+	if err := s.do(ctx, http.MethodPost, "/api/v1/restore/"+escape(id)+"/cancel", nil, nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// Stats returns the totals of the snapshot repository
+func (s *Snap) Stats(ctx context.Context) (*SnapStats, error) {
+	var stats SnapStats
+	if err := s.do(ctx, http.MethodGet, "/api/v1/stats", nil, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Cleanup removes the blocks no snapshot refers to
+func (s *Snap) Cleanup(ctx context.Context) (*BlockCleanup, error) {
+	var result BlockCleanup
+	if err := s.do(ctx, http.MethodPost, "/api/v1/cleanup", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Scrub starts checking the blocks of the repository
+func (s *Snap) Scrub(ctx context.Context) (*Task, error) {
+	var task Task
+	if err := s.do(ctx, http.MethodPost, "/api/v1/scrub", nil, nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ScrubStatus returns the status of repository scrubs
+func (s *Snap) ScrubStatus(ctx context.Context) (*ScrubStatus, error) {
+	var status ScrubStatus
+	if err := s.do(ctx, http.MethodGet, "/api/v1/scrub/status", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/snap"
+)
+
+// startSnap serves a snap manager with its repository in a temporary
+// directory
+func startSnap(t *testing.T) *Snap {
+	cfg := newDaemonConfig(t)
+	manager, err := snap.NewSnapManager(newDaemonDB(t, cfg).DB, cfg.Snap)
+	require.NoError(t, err)
+	t.Cleanup(manager.Stop)
+	return NewSnap(serveDaemon(t, manager.RegisterRoutes), "")
+}
+
+// takeSnapshot takes a snapshot of plan and waits until it completed
+func takeSnapshot(t *testing.T, client *Snap, planID string) string {
+	ctx := context.Background()
+	task, err := client.CreateSnapshot(ctx, &snap.CreateSnapshotRequest{PlanID: planID})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		status, err := client.SnapshotStatus(ctx, task.ID)
+		return err == nil && status.Status == snap.StatusCompleted
+	}, 10*time.Second, 20*time.Millisecond)
+	return task.ID
+}
+
+func TestSnapClient(t *testing.T) {
+	client := startSnap(t)
+	ctx := context.Background()
+
+	data := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(data, "app.conf"), []byte("listen 80\n"), 0o644))
+
+	nightly, err := client.CreatePlan(ctx, &snap.CreatePlanRequest{Name: "nightly", CronExpr: "0 2 * * *", Paths: []string{data}, Enabled: true})
+	require.NoError(t, err)
+	weekly, err := client.CreatePlan(ctx, &snap.CreatePlanRequest{Name: "weekly", CronExpr: "0 3 * * 0", Paths: []string{data}})
+	require.NoError(t, err)
+
+	plan, err := client.GetPlan(ctx, nightly.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{data}, plan.Paths)
+	assert.NotNil(t, plan.NextRun, "enabled plans are scheduled")
+	plans, err := client.ListPlans(ctx)
+	require.NoError(t, err)
+	assert.Len(t, plans, 2)
+
+	first := takeSnapshot(t, client, nightly.ID)
+	second := takeSnapshot(t, client, nightly.ID)
+	takeSnapshot(t, client, weekly.ID)
+
+	snapshots, err := client.ListSnapshots(ctx, SnapshotQuery{PlanID: nightly.ID, Status: snap.StatusCompleted})
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	assert.ElementsMatch(t, []string{first, second}, []string{snapshots[0].ID, snapshots[1].ID})
+	assert.Equal(t, "nightly", snapshots[0].PlanName)
+	latest, err := client.ListSnapshots(ctx, SnapshotQuery{PlanID: nightly.ID, Limit: 1})
+	require.NoError(t, err)
+	assert.Len(t, latest, 1)
+	failed, err := client.ListSnapshots(ctx, SnapshotQuery{Status: snap.StatusFailed})
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+
+	snapshot, err := client.GetSnapshot(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, nightly.ID, snapshot.PlanID)
+	assert.Positive(t, snapshot.Size)
+
+	files, err := client.ListSnapshotFiles(ctx, first, FileQuery{Path: data})
+	require.NoError(t, err)
+	require.Len(t, files.Files, 1)
+	assert.Equal(t, "app.conf", files.Files[0].Name)
+	require.NoError(t, os.WriteFile(filepath.Join(data, "app.conf"), []byte("listen 8080\n"), 0o644))
+	restored, err := client.RestoreFile(ctx, first, &snap.RestoreFileRequest{FilePath: filepath.Join(data, "app.conf")})
+	require.NoError(t, err)
+	assert.Equal(t, 1, restored.Files)
+	conf, err := os.ReadFile(filepath.Join(data, "app.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "listen 80\n", string(conf))
+
+	stats, err := client.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.TotalSnapshots)
+	assert.Equal(t, int64(1), stats.ActivePlans)
+
+	require.NoError(t, client.DisablePlan(ctx, nightly.ID))
+	runs, err := client.PlanRuns(ctx, nightly.ID, 0)
+	require.NoError(t, err)
+	assert.Empty(t, runs)
+
+	_, err = client.GetPlan(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = client.GetSnapshot(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = client.RestoreStatus(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = client.ListSnapshotFiles(ctx, "missing", FileQuery{})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -1,10 +1,14 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,12 +20,41 @@ type Config struct {
 	Orchestrator OrchestratorConfig `yaml:"orchestrator" json:"orchestrator"`
 	Probe        ProbeMonitorConfig `yaml:"probe" json:"probe"`
 	Snap         SnapConfig         `yaml:"snap" json:"snap"`
+	Tracing      TracingConfig      `yaml:"tracing" json:"tracing"`
+	Secrets      SecretsConfig      `yaml:"secrets" json:"secrets"`
+	MultiTenancy MultiTenancyConfig `yaml:"multi_tenancy" json:"multi_tenancy"`
+
+	environment string // INFRA_CORE_ENV the configuration was read for
 }
 
+// LogConfig controls a daemon's own log output
 type LogConfig struct {
-	Level   string `yaml:"level" json:"level"`
-	Console bool   `yaml:"console" json:"console"`
-	File    string `yaml:"file" json:"file"`
+	Level   string `yaml:"level" json:"level"`     // debug, info, warn or error
+	Format  string `yaml:"format" json:"format"`   // json or text, defaults to json in production and text elsewhere
+	Console bool   `yaml:"console" json:"console"` // write to stderr as well as file
+	File    string `yaml:"file" json:"file"`       // append to this file, stderr only when unset
+}
+
+// TracingConfig controls OpenTelemetry tracing, shared by every daemon
+type TracingConfig struct {
+	Endpoint     string            `yaml:"endpoint" json:"endpoint"`           // OTLP/HTTP collector such as http://localhost:4318, tracing is off when unset
+	SampleRatio  float64           `yaml:"sample_ratio" json:"sample_ratio"`   // share of new traces recorded, 0 records all
+	ServiceNames map[string]string `yaml:"service_names" json:"service_names"` // daemon to the service name its spans are reported under, default infra-core-<daemon>
+}
+
+// SecretsConfig holds the master key sealing service secrets, shared by the
+// console, which stores them, and the orchestrator, which resolves them when
+// it starts services
+type SecretsConfig struct {
+	MasterKey string `yaml:"master_key" json:"master_key"` // base64 of 32 random bytes, read from key_file when unset
+	KeyFile   string `yaml:"key_file" json:"key_file"`     // generated by the console when missing, default secrets.key beside the console database
+}
+
+// MultiTenancyConfig scopes the console's services, routes and registered
+// services, and the probes and snapshot plans of the daemons, to
+// organizations. When disabled every user sees every resource.
+type MultiTenancyConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
 }
 
 type PortsConfig struct {
@@ -30,45 +63,312 @@ type PortsConfig struct {
 }
 
 type ACMEConfig struct {
-	DirectoryURL  string `yaml:"directory_url" json:"directory_url"`
-	Email         string `yaml:"email" json:"email"`
-	CacheDir      string `yaml:"cache_dir" json:"cache_dir"`
-	ChallengeType string `yaml:"challenge_type" json:"challenge_type"`
-	Enabled       bool   `yaml:"enabled" json:"enabled"`
+	DirectoryURL  string        `yaml:"directory_url" json:"directory_url"`
+	Email         string        `yaml:"email" json:"email"`
+	CacheDir      string        `yaml:"cache_dir" json:"cache_dir"`
+	ChallengeType string        `yaml:"challenge_type" json:"challenge_type"` // http-01 (the default) or dns-01; wildcard domains always use dns-01
+	Enabled       bool          `yaml:"enabled" json:"enabled"`
+	DNS           ACMEDNSConfig `yaml:"dns" json:"dns"`
+}
+
+// ACME challenge types
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+)
+
+// ACMEDNSConfig selects the DNS provider publishing the TXT records of DNS-01
+// challenges, needed for wildcard certificates and for gates the CA can't
+// reach on port 80
+type ACMEDNSConfig struct {
+	Provider           string           `yaml:"provider" json:"provider"`                       // cloudflare; DNS-01 is unavailable when unset
+	PropagationTimeout string           `yaml:"propagation_timeout" json:"propagation_timeout"` // how long to wait for a record to be visible before giving up, default 2m
+	PollingInterval    string           `yaml:"polling_interval" json:"polling_interval"`       // time between propagation checks, default 5s
+	Nameservers        []string         `yaml:"nameservers" json:"nameservers"`                 // resolvers queried for propagation, host or host:port; the system's when unset
+	Cloudflare         CloudflareConfig `yaml:"cloudflare" json:"cloudflare"`
+}
+
+// CloudflareConfig holds the credentials of the Cloudflare DNS provider
+type CloudflareConfig struct {
+	APIToken string `yaml:"api_token" json:"api_token"` // API token with the Zone:DNS:Edit permission
+	ZoneID   string `yaml:"zone_id" json:"zone_id"`     // looked up from the domain when unset
 }
 
 type GateConfig struct {
-	Host  string      `yaml:"host" json:"host"`
-	Ports PortsConfig `yaml:"ports" json:"ports"`
-	Logs  LogConfig   `yaml:"logs" json:"logs"`
-	ACME  ACMEConfig  `yaml:"acme" json:"acme"`
+	Host      string          `yaml:"host" json:"host"`
+	Ports     PortsConfig     `yaml:"ports" json:"ports"`
+	Logs      LogConfig       `yaml:"logs" json:"logs"`
+	AccessLog AccessLogConfig `yaml:"access_log" json:"access_log"`
+	ACME      ACMEConfig      `yaml:"acme" json:"acme"`
+	Upgrade   UpgradeConfig   `yaml:"upgrade" json:"upgrade"`
+	TLS       TLSConfig       `yaml:"tls" json:"tls"`
+
+	// TrustedProxies are the addresses or CIDRs whose X-Forwarded-For header
+	// names the client, for access logs and access control
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies"`
+
+	// AccessControl applies to routes that do not set their own
+	AccessControl AccessControlConfig `yaml:"access_control" json:"access_control"`
+
+	// Cache bounds the memory of the responses cached for routes
+	Cache GateCacheConfig `yaml:"cache" json:"cache"`
+
+	// CircuitBreaker stops dialing upstreams that keep failing
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker" json:"circuit_breaker"`
+
+	// MetricsExport writes per-route traffic metrics to the console
+	MetricsExport GateMetricsExportConfig `yaml:"metrics_export" json:"metrics_export"`
+
+	// ErrorPages replaces the built-in pages of the errors the gate answers
+	// itself, such as an unreachable upstream
+	ErrorPages ErrorPagesConfig `yaml:"error_pages" json:"error_pages"`
+
+	// StaticRoutes are served from startup. When none are set, the gate
+	// routes everything to the console once it is ready.
+	StaticRoutes []StaticRouteConfig `yaml:"static_routes" json:"static_routes"`
+}
+
+// StaticRouteConfig is a route the gate adds on startup. A route with a
+// ready path answers with a "starting up" page until its upstream answers
+// that path with a 2xx status.
+type StaticRouteConfig struct {
+	ID          string `yaml:"id" json:"id"`
+	Host        string `yaml:"host" json:"host"`                 // every host when empty
+	Path        string `yaml:"path" json:"path"`                 // path prefix, default /
+	Upstream    string `yaml:"upstream" json:"upstream"`         // such as http://127.0.0.1:8082
+	StripPrefix bool   `yaml:"strip_prefix" json:"strip_prefix"` // remove the path prefix before proxying
+	Priority    int    `yaml:"priority" json:"priority"`
+	ReadyPath   string `yaml:"ready_path" json:"ready_path"` // polled on the upstream before proxying, such as /health/ready; proxied at once when empty
+	ServiceID   string `yaml:"service_id" json:"service_id"` // the service the upstream runs, whose metrics include the route's traffic
+}
+
+// CircuitBreakerConfig controls the gate's per-upstream circuit breakers. An
+// upstream failing this many requests in a row within the window is not
+// dialed for the cooldown; requests get 503 with Retry-After instead.
+type CircuitBreakerConfig struct {
+	Failures int    `yaml:"failures" json:"failures"` // consecutive failures, default 5
+	Window   string `yaml:"window" json:"window"`     // default 30s
+	Cooldown string `yaml:"cooldown" json:"cooldown"` // default 30s
+}
+
+// GateCacheConfig bounds the gate's response cache, shared by every route
+// that enables caching. The least recently used responses are evicted first.
+type GateCacheConfig struct {
+	MaxSizeMB  int `yaml:"max_size_mb" json:"max_size_mb"`   // memory of all cached responses, default 64
+	MaxEntryKB int `yaml:"max_entry_kb" json:"max_entry_kb"` // larger responses are not cached, default 1024
+}
+
+// AccessLogConfig controls the gate's log of the requests it serves
+type AccessLogConfig struct {
+	Enabled    bool           `yaml:"enabled" json:"enabled"`
+	Format     string         `yaml:"format" json:"format"`           // json (one object per line, the default) or combined
+	File       string         `yaml:"file" json:"file"`               // append to this file, stdout when unset
+	MaxSizeMB  int            `yaml:"max_size_mb" json:"max_size_mb"` // rotate the file once it reaches this size, default 100
+	MaxFiles   int            `yaml:"max_files" json:"max_files"`     // rotated files kept, oldest are deleted, default 5
+	BufferSize int            `yaml:"buffer_size" json:"buffer_size"` // entries waiting to be written before new ones are dropped
+	Sample     map[string]int `yaml:"sample" json:"sample"`           // route ID to N, logging 1 in N requests of busy routes
+}
+
+// GateMetricsExportConfig controls the export of the gate's per-route
+// request counts, error counts and latency percentiles to the console's
+// metrics. Routes of an upstream service are recorded as that service.
+type GateMetricsExportConfig struct {
+	Enabled       bool   `yaml:"enabled" json:"enabled"`
+	FlushInterval string `yaml:"flush_interval" json:"flush_interval"` // aggregate and write this often, default 1m
+	ConsoleURL    string `yaml:"console_url" json:"console_url"`       // post to the console's ingest endpoint, or write to the database when empty
+	Token         string `yaml:"token" json:"token"`                   // bearer token of the ingest endpoint, default console.metrics.ingest_token
+	MaxBuffered   int    `yaml:"max_buffered" json:"max_buffered"`     // metrics kept while the console is unreachable, oldest dropped first, default 10000
+}
+
+// ErrorPagesConfig is where the gate loads the HTML templates of its error
+// pages from. A template named after a status, such as 502.html, serves
+// that status and error.html any other; the built-in page serves those the
+// directory has no template for.
+type ErrorPagesConfig struct {
+	Dir string `yaml:"dir" json:"dir"`
+}
+
+// AccessControlConfig restricts who may reach a gate route. Clients in a
+// denied network are refused even when an allowed network contains them.
+type AccessControlConfig struct {
+	AllowCIDRs []string         `yaml:"allow_cidrs" json:"allow_cidrs,omitempty"` // when set, only clients in these networks are served
+	DenyCIDRs  []string         `yaml:"deny_cidrs" json:"deny_cidrs,omitempty"`   // clients in these networks are refused
+	BasicAuth  *BasicAuthConfig `yaml:"basic_auth" json:"basic_auth,omitempty"`   // credential required on top of the network rules
+}
+
+// BasicAuthConfig is the HTTP basic auth credential of a route
+type BasicAuthConfig struct {
+	Username     string `yaml:"username" json:"username"`
+	PasswordHash string `yaml:"password_hash" json:"password_hash"` // bcrypt hash of the password
+}
+
+// TLS modes of the gate
+const (
+	TLSModeACME       = "acme"
+	TLSModeSelfSigned = "self-signed"
+)
+
+// TLSConfig controls the gate's HTTPS listener. In self-signed mode the gate
+// keeps a local CA in the ACME cache directory and mints a certificate for
+// every host name or IP address it is reached by, for LANs the ACME CA can't
+// validate.
+type TLSConfig struct {
+	Mode         string `yaml:"mode" json:"mode"`                   // acme (the default) or self-signed
+	LeafValidity string `yaml:"leaf_validity" json:"leaf_validity"` // validity of self-signed certificates, renewed when a third is left, default 30d
+	ForceHTTPS   bool   `yaml:"force_https" json:"force_https"`     // redirect plain HTTP requests on every route to HTTPS
+	DefaultCert  string `yaml:"default_cert" json:"default_cert"`   // certificate served when no managed certificate matches the SNI name
+	DefaultKey   string `yaml:"default_key" json:"default_key"`     // key for default_cert; a self-signed pair is generated when both are unset
+}
+
+// UpgradeConfig controls how the gate hands its listeners to a new process
+type UpgradeConfig struct {
+	ReusePort    bool   `yaml:"reuse_port" json:"reuse_port"`       // bind listeners with SO_REUSEPORT
+	DrainTimeout string `yaml:"drain_timeout" json:"drain_timeout"` // how long the old process drains in-flight requests
 }
 
 type DatabaseConfig struct {
-	Path    string `yaml:"path" json:"path"`
-	WALMode bool   `yaml:"wal_mode" json:"wal_mode"`
-	Timeout string `yaml:"timeout" json:"timeout"`
+	Path               string `yaml:"path" json:"path"`
+	WALMode            bool   `yaml:"wal_mode" json:"wal_mode"`
+	Timeout            string `yaml:"timeout" json:"timeout"`
+	RetryTimeout       string `yaml:"retry_timeout" json:"retry_timeout"` // how long writes finding the database locked are retried, 10s by default
+	RepairOrphans      bool   `yaml:"repair_orphans" json:"repair_orphans"`
+	DisableAutoMigrate bool   `yaml:"disable_auto_migrate" json:"disable_auto_migrate"` // refuse to start on a stale schema instead of migrating
+	BackupDir          string `yaml:"backup_dir" json:"backup_dir"`                     // defaults to a backups directory next to the database
+
+	// QueryStats times the statements of repositories
+	QueryStats QueryStatsConfig `yaml:"query_stats" json:"query_stats"`
+}
+
+// QueryStatsConfig controls the timing of database statements, aggregated
+// by statement with their literal values left out, and the logging of slow
+// ones. Nothing is timed when it is disabled.
+type QueryStatsConfig struct {
+	Enabled       bool   `yaml:"enabled" json:"enabled"`
+	SlowThreshold string `yaml:"slow_threshold" json:"slow_threshold"` // log statements taking longer, with their arguments redacted, default 200ms
+	MaxStatements int    `yaml:"max_statements" json:"max_statements"` // distinct statements tracked, the rest counted together, default 500
 }
 
 type JWTConfig struct {
 	Secret       string `yaml:"secret" json:"secret"`
 	ExpiresHours int    `yaml:"expires_hours" json:"expires_hours"`
+	Algorithm    string `yaml:"algorithm" json:"algorithm"` // RS256 (default) or EdDSA key pairs, or HS256 to sign console tokens with the secret
+	KeyFile      string `yaml:"key_file" json:"key_file"`   // PEM signing keys, generated if missing; defaults to jwt_signing_key.pem next to the database
+}
+
+// OIDCConfig controls the console's OpenID Connect provider
+type OIDCConfig struct {
+	Issuer string `yaml:"issuer" json:"issuer"` // public URL of the console that clients discover the provider at, unset to use console.external_url
+}
+
+// OIDCIssuer returns the issuer of the console's OpenID Connect provider:
+// the configured one, else the console's external URL. The provider is
+// disabled when both are unset.
+func (c *ConsoleConfig) OIDCIssuer() string {
+	if c.Auth.OIDC.Issuer != "" {
+		return c.Auth.OIDC.Issuer
+	}
+	return c.ExternalURL
 }
 
 type SessionConfig struct {
 	TimeoutMinutes int `yaml:"timeout_minutes" json:"timeout_minutes"`
 }
 
+// LockoutConfig controls how repeated failed logins lock an account
+type LockoutConfig struct {
+	MaxAttempts int    `yaml:"max_attempts" json:"max_attempts"` // consecutive failures before locking
+	Window      string `yaml:"window" json:"window"`             // period failures are counted over
+}
+
+// PasswordResetConfig controls password reset tokens and the links sent for them
+type PasswordResetConfig struct {
+	TokenTTL string `yaml:"token_ttl" json:"token_ttl"` // how long a reset token stays valid
+	URL      string `yaml:"url" json:"url"`             // reset page the token is appended to
+}
+
+// PasswordPolicyConfig sets the rules new passwords must satisfy. Passwords
+// containing the username or email are always rejected.
+type PasswordPolicyConfig struct {
+	MinLength     int  `yaml:"min_length" json:"min_length"` // in characters, defaults to 8
+	RequireUpper  bool `yaml:"require_upper" json:"require_upper"`
+	RequireLower  bool `yaml:"require_lower" json:"require_lower"`
+	RequireDigit  bool `yaml:"require_digit" json:"require_digit"`
+	RequireSymbol bool `yaml:"require_symbol" json:"require_symbol"`
+	RejectCommon  bool `yaml:"reject_common" json:"reject_common"` // reject passwords on the built-in common password list
+}
+
 type AuthConfig struct {
-	JWT     JWTConfig     `yaml:"jwt" json:"jwt"`
-	Session SessionConfig `yaml:"session" json:"session"`
+	AllowRegistration bool                 `yaml:"allow_registration" json:"allow_registration"` // let anyone create a user account with /auth/register
+	JWT               JWTConfig            `yaml:"jwt" json:"jwt"`
+	Session           SessionConfig        `yaml:"session" json:"session"`
+	Lockout           LockoutConfig        `yaml:"lockout" json:"lockout"`
+	PasswordReset     PasswordResetConfig  `yaml:"password_reset" json:"password_reset"`
+	PasswordPolicy    PasswordPolicyConfig `yaml:"password_policy" json:"password_policy"`
+	OIDC              OIDCConfig           `yaml:"oidc" json:"oidc"`
+	Impersonation     ImpersonationConfig  `yaml:"impersonation" json:"impersonation"`
 }
 
+// ImpersonationConfig controls how admins act as other users with
+// POST /api/v1/users/:id/impersonate
+type ImpersonationConfig struct {
+	TokenTTL    string `yaml:"token_ttl" json:"token_ttl"`       // how long an impersonation token stays valid
+	AllowAdmins bool   `yaml:"allow_admins" json:"allow_admins"` // let admins impersonate other admins
+}
+
+// MetricsConfig controls how often host and service metrics are collected
+// and how long raw console metrics are kept
+type MetricsConfig struct {
+	CollectInterval string `yaml:"collect_interval" json:"collect_interval"` // how often host and service metrics are sampled
+	RollupAfter     string `yaml:"rollup_after" json:"rollup_after"`         // raw metrics older than this are rolled up into 5-minute buckets
+	RawRetention    string `yaml:"raw_retention" json:"raw_retention"`       // raw metrics older than this are deleted, rollups are kept
+	IngestToken     string `yaml:"ingest_token" json:"ingest_token"`         // bearer token of POST /api/v1/system/metrics/ingest, disabled when empty
+}
+
+// RetentionConfig controls the console's daily cleanup of old rows. Each
+// retention is a duration such as "36h" or a number of days such as "30d";
+// unset keeps the default. Expired SSO sessions are always deleted.
+type RetentionConfig struct {
+	Interval      string `yaml:"interval" json:"interval"`             // how often the cleanup runs, default 24h
+	Metrics       string `yaml:"metrics" json:"metrics"`               // 5-minute metric rollups, default 90d; raw metrics follow metrics.raw_retention
+	HealthChecks  string `yaml:"health_checks" json:"health_checks"`   // health checks of registered services, default 7d
+	AuditLogs     string `yaml:"audit_logs" json:"audit_logs"`         // default 365d
+	LoginAttempts string `yaml:"login_attempts" json:"login_attempts"` // default 30d
+}
+
+// ServiceHealthConfig controls how health checks of registered services
+// change their status and who is told about it
+type ServiceHealthConfig struct {
+	FailureThreshold int    `yaml:"failure_threshold" json:"failure_threshold"` // consecutive failed checks before a service is marked unreachable
+	WebhookURL       string `yaml:"webhook_url" json:"webhook_url"`             // receives a JSON POST on every status change, unset to disable
+}
+
+// DaemonsConfig tells the console where the probe, snap and orchestrator
+// daemons and the gate's management API serve. A daemon without a URL is not
+// called; the dashboard then reads what it needs from the database instead,
+// certificates can't be renewed from the console without the gate, and
+// services created from templates can't be deployed without the orchestrator.
+type DaemonsConfig struct {
+	ProbeURL        string `yaml:"probe_url" json:"probe_url"`               // such as http://localhost:8085
+	SnapURL         string `yaml:"snap_url" json:"snap_url"`                 // such as http://localhost:8086
+	GateURL         string `yaml:"gate_url" json:"gate_url"`                 // management port, the HTTP port + 1000, such as http://localhost:9080
+	OrchestratorURL string `yaml:"orchestrator_url" json:"orchestrator_url"` // such as http://localhost:8084
+	Token           string `yaml:"token" json:"token"`                       // sent as a bearer token, unset to send none
+	Timeout         string `yaml:"timeout" json:"timeout"`                   // per request, default 10s
+}
+
+// UIConfig is where the console serves its web UI from
+type UIConfig struct {
+	Dir string `yaml:"dir" json:"dir"` // the built UI with index.html and assets/, /app/ui/dist by default
+}
+
+// CORSConfig controls which browser origins may call the console API with
+// credentials. With no allowed origins, production serves same-origin
+// requests only and other environments allow localhost origins on any port.
 type CORSConfig struct {
-	Enabled bool     `yaml:"enabled" json:"enabled"`
-	Origins []string `yaml:"origins" json:"origins"`
-	Methods []string `yaml:"methods" json:"methods"`
-	Headers []string `yaml:"headers" json:"headers"`
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins"` // exact origins or one-level wildcards such as https://*.example.com
+	AllowedMethods []string `yaml:"allowed_methods" json:"allowed_methods"` // methods preflight requests may ask for, unset for the defaults
+	AllowedHeaders []string `yaml:"allowed_headers" json:"allowed_headers"` // request headers preflight requests may ask for, unset for the defaults
+	MaxAge         string   `yaml:"max_age" json:"max_age"`                 // how long browsers may cache a preflight response, default 10m
 }
 
 type ConsoleConfig struct {
@@ -78,6 +378,27 @@ type ConsoleConfig struct {
 	Database DatabaseConfig `yaml:"database" json:"database"`
 	Auth     AuthConfig     `yaml:"auth" json:"auth"`
 	CORS     CORSConfig     `yaml:"cors" json:"cors"`
+	Metrics  MetricsConfig  `yaml:"metrics" json:"metrics"`
+
+	Retention     RetentionConfig     `yaml:"retention" json:"retention"`
+	ServiceHealth ServiceHealthConfig `yaml:"service_health" json:"service_health"`
+	Daemons       DaemonsConfig       `yaml:"daemons" json:"daemons"`
+	UI            UIConfig            `yaml:"ui" json:"ui"`
+	ExternalURL   string              `yaml:"external_url" json:"external_url"` // public URL users and clients reach the console at, such as https://console.example.com
+
+	// IncidentWindow is how long after a deployment an incident on the same
+	// service is attributed to it in deployment stats
+	IncidentWindow string `yaml:"incident_window" json:"incident_window"`
+
+	DeployHooks DeployHooksConfig `yaml:"deploy_hooks" json:"deploy_hooks"`
+}
+
+// DeployHooksConfig controls the signed webhooks CI deploys services with,
+// see POST /api/v1/services/:id/deploy-hook
+type DeployHooksConfig struct {
+	MaxClockSkew string `yaml:"max_clock_skew" json:"max_clock_skew"` // how far a hook's timestamp may be from the console's clock, default 5m
+	MaxDeploys   int    `yaml:"max_deploys" json:"max_deploys"`       // deployments a service's hook may trigger per window, default 5
+	Window       string `yaml:"window" json:"window"`                 // default 10m
 }
 
 type OrchestratorConfig struct {
@@ -89,47 +410,183 @@ type OrchestratorConfig struct {
 	DefaultReplicas     int    `yaml:"default_replicas" json:"default_replicas"`
 	MaxDeployments      int    `yaml:"max_deployments" json:"max_deployments"`
 	EnableMetrics       bool   `yaml:"enable_metrics" json:"enable_metrics"`
+	Runtime             string `yaml:"runtime" json:"runtime"`                       // simulated, process or docker
+	StopGracePeriod     string `yaml:"stop_grace_period" json:"stop_grace_period"`   // wait after SIGTERM before SIGKILL
+	DockerHost          string `yaml:"docker_host" json:"docker_host"`               // Docker Engine API, unix:// or tcp://
+	DependencyTimeout   string `yaml:"dependency_timeout" json:"dependency_timeout"` // wait for dependencies to become healthy
+
+	Logs         LogConfig          `yaml:"logs" json:"logs"`
+	ServiceLogs  ServiceLogsConfig  `yaml:"service_logs" json:"service_logs"`
+	ServicePorts ServicePortsConfig `yaml:"service_ports" json:"service_ports"`
+	Cluster      ClusterConfig      `yaml:"cluster" json:"cluster"`
+	Limits       LimitsConfig       `yaml:"limits" json:"limits"`
+}
+
+// LimitsConfig is how the orchestrator enforces the memory requirements of
+// the service instances it runs as local processes, whose CPU and memory it
+// samples when resource_monitoring is on. Container runtimes enforce the
+// requirements themselves.
+type LimitsConfig struct {
+	SampleInterval string `yaml:"sample_interval" json:"sample_interval"` // between samples, default 15s
+	MemoryAction   string `yaml:"memory_action" json:"memory_action"`     // alert or restart when an instance stays over its memory, default alert
+	MemorySamples  int    `yaml:"memory_samples" json:"memory_samples"`   // consecutive samples over the memory before acting, default 3
+}
+
+// ClusterConfig joins orchestrators into a cluster when cluster_mode is on.
+// The primary runs services on itself and on the nodes that register with
+// it; an orchestrator with a primary set is instead the agent of a node,
+// named node_name. Capacity and labels describe the node an orchestrator
+// runs on, whichever it is.
+type ClusterConfig struct {
+	Token             string            `yaml:"token" json:"token"`                           // shared by the primary and its nodes, required for nodes to register
+	Primary           string            `yaml:"primary" json:"primary"`                       // URL of the primary orchestrator, set on node agents
+	Address           string            `yaml:"address" json:"address"`                       // where the services of the node are reached, node_name by default
+	HeartbeatInterval string            `yaml:"heartbeat_interval" json:"heartbeat_interval"` // between the heartbeats of an agent, default 10s
+	NodeTimeout       string            `yaml:"node_timeout" json:"node_timeout"`             // a node without heartbeats for this long is not ready, default 30s
+	CPU               string            `yaml:"cpu" json:"cpu"`                               // cores offered, like "4", all of the host's by default
+	Memory            string            `yaml:"memory" json:"memory"`                         // like "8Gi", not limited when empty
+	Pods              int               `yaml:"pods" json:"pods"`                             // service instances run at most, default 100
+	Labels            map[string]string `yaml:"labels" json:"labels"`                         // matched by the node selectors of services
+}
+
+// ServicePortsConfig is the range services created or deployed with port 0
+// are given a free port from, 20000 to 29999 when unset
+type ServicePortsConfig struct {
+	Min int `yaml:"min" json:"min"`
+	Max int `yaml:"max" json:"max"`
+}
+
+// ServiceLogsConfig controls where captured service output is written and how
+// much of it is kept
+type ServiceLogsConfig struct {
+	Dir           string `yaml:"dir" json:"dir"`                           // one subdirectory per service
+	MaxFileSizeMB int    `yaml:"max_file_size_mb" json:"max_file_size_mb"` // rotate a service's log file once it reaches this size
+	MaxFiles      int    `yaml:"max_files" json:"max_files"`               // rotated files kept per service, oldest are deleted
 }
 
 type ProbeMonitorConfig struct {
-	Port              int    `yaml:"port" json:"port"`
-	CheckInterval     string `yaml:"check_interval" json:"check_interval"`
-	AlertInterval     string `yaml:"alert_interval" json:"alert_interval"`
-	CleanupInterval   string `yaml:"cleanup_interval" json:"cleanup_interval"`
-	ResultRetention   string `yaml:"result_retention" json:"result_retention"`
-	AlertRetention    string `yaml:"alert_retention" json:"alert_retention"`
-	EnableNotifications bool `yaml:"enable_notifications" json:"enable_notifications"`
-	MaxConcurrentProbes int  `yaml:"max_concurrent_probes" json:"max_concurrent_probes"`
+	Port                int                      `yaml:"port" json:"port"`
+	Logs                LogConfig                `yaml:"logs" json:"logs"`
+	CheckInterval       string                   `yaml:"check_interval" json:"check_interval"`
+	AlertInterval       string                   `yaml:"alert_interval" json:"alert_interval"`
+	CleanupInterval     string                   `yaml:"cleanup_interval" json:"cleanup_interval"`
+	ResultRetention     string                   `yaml:"result_retention" json:"result_retention"`
+	AlertRetention      string                   `yaml:"alert_retention" json:"alert_retention"`
+	EnableNotifications bool                     `yaml:"enable_notifications" json:"enable_notifications"`
+	MaxConcurrentProbes int                      `yaml:"max_concurrent_probes" json:"max_concurrent_probes"`
+	SLACountUnmonitored bool                     `yaml:"sla_count_unmonitored" json:"sla_count_unmonitored"` // whether time without results counts against uptime
+	Notifications       ProbeNotificationsConfig `yaml:"notifications" json:"notifications"`
+	Publishers          ProbePublishersConfig    `yaml:"publishers" json:"publishers"`
+}
+
+// ProbeNotificationsConfig routes probe alerts to notification channels by severity
+type ProbeNotificationsConfig struct {
+	Channels   []NotificationChannelConfig `yaml:"channels" json:"channels"`
+	Routes     map[string][]string         `yaml:"routes" json:"routes"`           // severity to the names of the channels it is sent to
+	RateLimit  string                      `yaml:"rate_limit" json:"rate_limit"`   // minimum time between notifications of the same kind for a probe, default 5m
+	MaxRetries int                         `yaml:"max_retries" json:"max_retries"` // delivery retries after a failed attempt, default 3
+}
+
+// NotificationChannelConfig configures one notification channel
+type NotificationChannelConfig struct {
+	Name string     `yaml:"name" json:"name"`
+	Type string     `yaml:"type" json:"type"` // webhook, slack or email
+	URL  string     `yaml:"url" json:"url"`   // webhook and slack only
+	SMTP SMTPConfig `yaml:"smtp" json:"smtp"` // email only
+}
+
+// ProbePublishersConfig pushes changes of probe state to external endpoints
+// such as status pages
+type ProbePublishersConfig struct {
+	Targets        []PublisherTargetConfig `yaml:"targets" json:"targets"`
+	Debounce       int                     `yaml:"debounce" json:"debounce"`                 // consecutive checks in a new state before it changes, default 2
+	MaxRetries     int                     `yaml:"max_retries" json:"max_retries"`           // delivery retries after a failed attempt, default 3
+	DeadLetterFile string                  `yaml:"dead_letter_file" json:"dead_letter_file"` // JSON lines of undeliverable events, unset to only log them
+}
+
+// PublisherTargetConfig configures one endpoint state changes are pushed to
+type PublisherTargetConfig struct {
+	Name   string `yaml:"name" json:"name"`
+	URL    string `yaml:"url" json:"url"`
+	Secret string `yaml:"secret" json:"-"` // signs the events, see the X-Infra-Core-Signature header
+}
+
+// SMTPConfig configures the mail server email notifications are sent through
+type SMTPConfig struct {
+	Host     string   `yaml:"host" json:"host"`
+	Port     int      `yaml:"port" json:"port"` // default 587
+	Username string   `yaml:"username" json:"username"`
+	Password string   `yaml:"password" json:"-"`
+	From     string   `yaml:"from" json:"from"`
+	To       []string `yaml:"to" json:"to"`
 }
 
 type SnapConfig struct {
-	Port        int    `yaml:"port" json:"port"`
-	RepoDir     string `yaml:"repo_dir" json:"repo_dir"`
-	TempDir     string `yaml:"temp_dir" json:"temp_dir"`
-	MaxParallel int    `yaml:"max_parallel" json:"max_parallel"`
-	RateLimit   string `yaml:"rate_limit" json:"rate_limit"`
-	ScrubInterval string `yaml:"scrub_interval" json:"scrub_interval"`
+	Port             int       `yaml:"port" json:"port"`
+	Logs             LogConfig `yaml:"logs" json:"logs"`
+	RepoDir          string    `yaml:"repo_dir" json:"repo_dir"`
+	TempDir          string    `yaml:"temp_dir" json:"temp_dir"`
+	MaxParallel      int       `yaml:"max_parallel" json:"max_parallel"`
+	SnapshotWorkers  int       `yaml:"snapshot_workers" json:"snapshot_workers"` // files a snapshot reads at once, default 4
+	RateLimit        string    `yaml:"rate_limit" json:"rate_limit"`             // reads of snapshots per second, such as 10MB/s, unlimited when empty
+	ThrottledPlans   []string  `yaml:"throttled_plans" json:"throttled_plans"`   // names of the plans rate_limit applies to, all of them when empty
+	ScrubInterval    string    `yaml:"scrub_interval" json:"scrub_interval"`
+	FullEvery        int       `yaml:"full_every" json:"full_every"`                   // every Nth snapshot of a plan is full, 0 for only the first
+	MaxRepoBytes     int64     `yaml:"max_repo_bytes" json:"max_repo_bytes"`           // quota of the blocks in the repository, 0 for none
+	MinFreeDiskBytes int64     `yaml:"min_free_disk_bytes" json:"min_free_disk_bytes"` // free space snapshots leave on the repository's disk, 0 for none
 	DefaultRetention struct {
 		Daily   int `yaml:"daily" json:"daily"`
 		Weekly  int `yaml:"weekly" json:"weekly"`
 		Monthly int `yaml:"monthly" json:"monthly"`
 	} `yaml:"default_retention" json:"default_retention"`
+	Chunking    SnapChunkingConfig `yaml:"chunking" json:"chunking"`
+	Compression string             `yaml:"compression" json:"compression"` // of new blocks, none (default) or gzip
+}
+
+// MaxChunkSize is the largest block a snapshot splits files into
+const MaxChunkSize = 4 << 20
+
+// SnapChunkingConfig sets how snapshots split files into blocks: in fixed
+// blocks of MaxChunkSize, or at boundaries found in the content, so that
+// bytes inserted into a file only change the blocks around them
+type SnapChunkingConfig struct {
+	Mode    string `yaml:"mode" json:"mode"`         // fixed (default) or cdc
+	MinSize int    `yaml:"min_size" json:"min_size"` // cdc only, default 256KB
+	AvgSize int    `yaml:"avg_size" json:"avg_size"` // cdc only, default 1MB
+	MaxSize int    `yaml:"max_size" json:"max_size"` // cdc only, default and at most MaxChunkSize
+}
+
+// Sizes returns the smallest, average and largest content-defined blocks,
+// with defaults for those unset
+func (c SnapChunkingConfig) Sizes() (minSize, avgSize, maxSize int) {
+	minSize, avgSize, maxSize = c.MinSize, c.AvgSize, c.MaxSize
+	if minSize == 0 {
+		minSize = 256 << 10
+	}
+	if avgSize == 0 {
+		avgSize = 1 << 20
+	}
+	if maxSize == 0 {
+		maxSize = MaxChunkSize
+	}
+	return minSize, avgSize, maxSize
 }
 
 // Global configuration instance
 var globalConfig *Config
 
-// Load loads configuration from file and environment variables
-func Load() (*Config, error) {
+// Read loads configuration from file and environment variables without
+// validating it
+func Read() (*Config, error) {
 	environment := os.Getenv("INFRA_CORE_ENV")
 	if environment == "" {
 		environment = "development"
 	}
-	
+
 	// Determine config file path
 	configPath := fmt.Sprintf("./configs/%s.yaml", environment)
 
-	config := &Config{}
+	config := &Config{environment: environment}
 
 	// Load from file if exists
 	if fileExists(configPath) {
@@ -153,8 +610,17 @@ func Load() (*Config, error) {
 		config.Console.Auth.JWT.Secret = generateRandomSecret(32)
 	}
 
-	// Validate configuration
-	if err := validate(config, environment); err != nil {
+	return config, nil
+}
+
+// Load reads and validates configuration and makes it the global instance
+func Load() (*Config, error) {
+	config, err := Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
@@ -170,6 +636,61 @@ func Get() *Config {
 	return globalConfig
 }
 
+// Environment returns the INFRA_CORE_ENV the configuration was read for
+func (c *Config) Environment() string {
+	return c.environment
+}
+
+// redactedValue replaces secrets in a redacted configuration
+const redactedValue = "[REDACTED]"
+
+// Redacted returns a copy of the configuration with secrets replaced, safe to
+// print or serve. Secrets that are not set are left empty.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redact(&redacted.Console.Auth.JWT.Secret)
+	// Webhook URLs usually carry a token
+	redact(&redacted.Console.ServiceHealth.WebhookURL)
+	redact(&redacted.Console.Daemons.Token)
+	redact(&redacted.Console.Metrics.IngestToken)
+	redact(&redacted.Gate.MetricsExport.Token)
+	redact(&redacted.Orchestrator.Cluster.Token)
+	redact(&redacted.Gate.ACME.DNS.Cloudflare.APIToken)
+	redact(&redacted.Secrets.MasterKey)
+
+	channels := make([]NotificationChannelConfig, len(c.Probe.Notifications.Channels))
+	for i, channel := range c.Probe.Notifications.Channels {
+		redact(&channel.URL)
+		redact(&channel.SMTP.Password)
+		channels[i] = channel
+	}
+	redacted.Probe.Notifications.Channels = channels
+
+	targets := make([]PublisherTargetConfig, len(c.Probe.Publishers.Targets))
+	for i, target := range c.Probe.Publishers.Targets {
+		redact(&target.Secret)
+		targets[i] = target
+	}
+	redacted.Probe.Publishers.Targets = targets
+	return &redacted
+}
+
+// WriteRedacted writes the redacted configuration as YAML
+func (c *Config) WriteRedacted(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func redact(value *string) {
+	if *value != "" {
+		*value = redactedValue
+	}
+}
+
 // overrideWithEnv overrides configuration with environment variables
 func overrideWithEnv(config *Config) {
 	// Gate configuration
@@ -192,6 +713,21 @@ func overrideWithEnv(config *Config) {
 	if val := os.Getenv("INFRA_CORE_ACME_ENABLED"); val != "" {
 		config.Gate.ACME.Enabled = strings.ToLower(val) == "true"
 	}
+	if val := os.Getenv("INFRA_CORE_CLOUDFLARE_API_TOKEN"); val != "" {
+		config.Gate.ACME.DNS.Cloudflare.APIToken = val
+	}
+
+	// Log level of every daemon
+	if val := os.Getenv("INFRA_CORE_LOG_LEVEL"); val != "" {
+		for _, logs := range []*LogConfig{&config.Gate.Logs, &config.Console.Logs, &config.Orchestrator.Logs, &config.Probe.Logs, &config.Snap.Logs} {
+			logs.Level = val
+		}
+	}
+
+	// Tracing configuration, using the standard OpenTelemetry variable
+	if val := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); val != "" {
+		config.Tracing.Endpoint = val
+	}
 
 	// Console configuration
 	if val := os.Getenv("INFRA_CORE_CONSOLE_HOST"); val != "" {
@@ -202,12 +738,24 @@ func overrideWithEnv(config *Config) {
 			config.Console.Port = port
 		}
 	}
+	if val := os.Getenv("INFRA_CORE_SECRETS_KEY"); val != "" {
+		config.Secrets.MasterKey = val
+	}
 	if val := os.Getenv("INFRA_CORE_JWT_SECRET"); val != "" {
 		config.Console.Auth.JWT.Secret = val
 	}
 	if val := os.Getenv("INFRA_CORE_DB_PATH"); val != "" {
 		config.Console.Database.Path = val
 	}
+	if val := os.Getenv("INFRA_CORE_DB_REPAIR_ORPHANS"); val != "" {
+		config.Console.Database.RepairOrphans = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("INFRA_CORE_DB_BACKUP_DIR"); val != "" {
+		config.Console.Database.BackupDir = val
+	}
+	if val := os.Getenv("INFRA_CORE_DB_DISABLE_AUTO_MIGRATE"); val != "" {
+		config.Console.Database.DisableAutoMigrate = strings.ToLower(val) == "true"
+	}
 
 	// Orchestrator configuration
 	if val := os.Getenv("INFRA_CORE_ORCH_PORT"); val != "" {
@@ -249,57 +797,65 @@ func overrideWithEnv(config *Config) {
 	}
 }
 
-// validate validates the configuration
-func validate(config *Config, environment string) error {
-	// Validate Gate config
-	if config.Gate.Host == "" {
-		return fmt.Errorf("gate.host cannot be empty")
-	}
-	if config.Gate.Ports.HTTP <= 0 || config.Gate.Ports.HTTP > 65535 {
-		return fmt.Errorf("invalid gate.ports.http: %d", config.Gate.Ports.HTTP)
-	}
-	if config.Gate.Ports.HTTPS <= 0 || config.Gate.Ports.HTTPS > 65535 {
-		return fmt.Errorf("invalid gate.ports.https: %d", config.Gate.Ports.HTTPS)
-	}
-
-	// Validate Console config
-	if config.Console.Host == "" {
-		return fmt.Errorf("console.host cannot be empty")
-	}
-	if config.Console.Port <= 0 || config.Console.Port > 65535 {
-		return fmt.Errorf("invalid console.port: %d", config.Console.Port)
-	}
-	if config.Console.Database.Path == "" {
-		return fmt.Errorf("console.database.path cannot be empty")
+// ParseRetention parses a retention period. It accepts Go durations such as
+// "36h" as well as whole days such as "7d".
+func ParseRetention(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid retention: %s", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
 	}
 
-	// Validate Orchestrator config
-	if config.Orchestrator.Port <= 0 || config.Orchestrator.Port > 65535 {
-		return fmt.Errorf("invalid orchestrator.port: %d", config.Orchestrator.Port)
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention: %s", value)
 	}
+	return d, nil
+}
 
-	// Validate Probe config
-	if config.Probe.Port <= 0 || config.Probe.Port > 65535 {
-		return fmt.Errorf("invalid probe.port: %d", config.Probe.Port)
-	}
+// byteUnits are the units of byte rates, in powers of 1024
+var byteUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+}
 
-	// Validate Snap config
-	if config.Snap.Port <= 0 || config.Snap.Port > 65535 {
-		return fmt.Errorf("invalid snap.port: %d", config.Snap.Port)
+// ParseByteRate parses a rate in bytes per second such as 512KB/s or
+// 10MB/s. The unit is optional; KB, MB and GB are powers of 1024.
+func ParseByteRate(value string) (int64, error) {
+	amount, ok := strings.CutSuffix(strings.TrimSpace(value), "/s")
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q: must end in /s, such as 10MB/s", value)
 	}
-	if config.Snap.RepoDir == "" {
-		return fmt.Errorf("snap.repo_dir cannot be empty")
+	digits := strings.TrimRightFunc(amount, unicode.IsLetter)
+	multiplier, ok := byteUnits[strings.ToUpper(amount[len(digits):])]
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q: unknown unit, use B, KB, MB or GB", value)
 	}
-	if config.Snap.TempDir == "" {
-		return fmt.Errorf("snap.temp_dir cannot be empty")
+	n, err := strconv.ParseInt(strings.TrimSpace(digits), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q: must be a positive number of bytes per second", value)
 	}
+	return n * multiplier, nil
+}
 
-	// JWT secret is required in production
-	if environment == "production" && config.Console.Auth.JWT.Secret == "" {
-		return fmt.Errorf("console.auth.jwt.secret is required in production environment")
-	}
+// MasterKeySize is the size of the secrets master key, an AES-256 key
+const MasterKeySize = 32
 
-	return nil
+// ParseMasterKey decodes a base64 secrets master key
+func ParseMasterKey(value string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	if len(key) != MasterKeySize {
+		return nil, fmt.Errorf("invalid master key: must be %d bytes, got %d", MasterKeySize, len(key))
+	}
+	return key, nil
 }
 
 // generateRandomSecret generates a random secret for JWT
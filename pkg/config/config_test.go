@@ -4,8 +4,11 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func createTestConfig(t *testing.T) string {
@@ -62,7 +65,7 @@ snap:
   repo_dir: "./snapshots"
   temp_dir: "./temp"
   max_parallel: 5
-  rate_limit: "10/m"
+  rate_limit: "10MB/s"
   scrub_interval: "24h"
   default_retention:
     daily: 7
@@ -111,6 +114,27 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+func TestStaticRoutes(t *testing.T) {
+	var gate GateConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+static_routes:
+  - id: console
+    upstream: "http://127.0.0.1:8082"
+    ready_path: "/health/ready"
+  - id: docs
+    host: "docs.example.com"
+    path: "/v2"
+    upstream: "http://127.0.0.1:4000"
+    strip_prefix: true
+    priority: 10
+`), &gate))
+
+	assert.Equal(t, []StaticRouteConfig{
+		{ID: "console", Upstream: "http://127.0.0.1:8082", ReadyPath: "/health/ready"},
+		{ID: "docs", Host: "docs.example.com", Path: "/v2", Upstream: "http://127.0.0.1:4000", StripPrefix: true, Priority: 10},
+	}, gate.StaticRoutes)
+}
+
 func TestLoadWithEnvironmentVariables(t *testing.T) {
 	// Create temporary config and change working directory
 	tmpDir := createTestConfig(t)
@@ -205,6 +229,144 @@ func TestValidateConfiguration(t *testing.T) {
 	if err != nil {
 		t.Errorf("Valid configuration should pass validation: %v", err)
 	}
+
+	config.Console.IncidentWindow = "an hour"
+	if err := validate(config, "development"); err == nil {
+		t.Error("Invalid incident window should fail validation")
+	}
+	config.Console.IncidentWindow = ""
+
+	config.Console.Auth.Lockout.Window = "15 minutes"
+	if err := validate(config, "development"); err == nil {
+		t.Error("Invalid lockout window should fail validation")
+	}
+	config.Console.Auth.Lockout.Window = "15m"
+
+	config.Console.Auth.PasswordReset.TokenTTL = "-1h"
+	if err := validate(config, "development"); err == nil {
+		t.Error("Negative password reset token TTL should fail validation")
+	}
+	config.Console.Auth.PasswordReset.TokenTTL = "1h"
+
+	config.Console.Auth.PasswordPolicy.MinLength = -1
+	if err := validate(config, "development"); err == nil {
+		t.Error("Negative password minimum length should fail validation")
+	}
+	config.Console.Auth.PasswordPolicy.MinLength = 0
+
+	config.Probe.ResultRetention = "a week"
+	if err := validate(config, "development"); err == nil {
+		t.Error("Invalid result retention should fail validation")
+	}
+	config.Probe.ResultRetention = "24h"
+
+	config.Probe.Notifications = ProbeNotificationsConfig{
+		Channels: []NotificationChannelConfig{{Name: "ops", Type: "webhook", URL: "https://hooks.example.com/ops"}},
+		Routes:   map[string][]string{"critical": {"ops", "pager"}},
+	}
+	if err := validate(config, "development"); err == nil {
+		t.Error("Routing alerts to an unknown notification channel should fail validation")
+	}
+	config.Probe.Notifications.Routes = map[string][]string{"critical": {"ops"}}
+	config.Probe.Notifications.Channels = append(config.Probe.Notifications.Channels,
+		NotificationChannelConfig{Name: "mail", Type: "email", SMTP: SMTPConfig{Host: "smtp.example.com"}})
+	if err := validate(config, "development"); err == nil {
+		t.Error("Email notification channel without sender and recipients should fail validation")
+	}
+	config.Probe.Notifications.Channels = config.Probe.Notifications.Channels[:1]
+	if err := validate(config, "development"); err != nil {
+		t.Errorf("Valid notification channels should pass validation: %v", err)
+	}
+	config.Probe.Notifications = ProbeNotificationsConfig{}
+
+	config.Console.Metrics = MetricsConfig{RollupAfter: "7d", RawRetention: "1d"}
+	if err := validate(config, "development"); err == nil {
+		t.Error("Rolling up metrics after they are deleted should fail validation")
+	}
+	config.Console.Metrics = MetricsConfig{CollectInterval: "0s"}
+	if err := validate(config, "development"); err == nil {
+		t.Error("Zero metrics collection interval should fail validation")
+	}
+	config.Console.Metrics = MetricsConfig{CollectInterval: "30s", RollupAfter: "1h", RawRetention: "7d"}
+
+	config.Console.Auth.JWT.Algorithm = "ES256"
+	if err := validate(config, "development"); err == nil {
+		t.Error("Unsupported JWT algorithm should fail validation")
+	}
+	config.Console.Auth.JWT.Algorithm = "EdDSA"
+
+	config.Console.Auth.OIDC.Issuer = "console.example.com"
+	if err := validate(config, "development"); err == nil {
+		t.Error("OIDC issuer without a scheme should fail validation")
+	}
+	config.Console.Auth.OIDC.Issuer = "https://console.example.com"
+	if err := validate(config, "development"); err != nil {
+		t.Errorf("Valid OIDC issuer should pass validation: %v", err)
+	}
+
+	config.Orchestrator.Runtime = "kubernetes"
+	if err := validate(config, "development"); err == nil {
+		t.Error("Unknown orchestrator runtime should fail validation")
+	}
+	config.Orchestrator.Runtime = "docker"
+	config.Orchestrator.DockerHost = "/var/run/docker.sock"
+	if err := validate(config, "development"); err == nil {
+		t.Error("Docker host without a scheme should fail validation")
+	}
+	config.Orchestrator.DockerHost = "unix:///var/run/docker.sock"
+	config.Orchestrator.StopGracePeriod = "0s"
+	if err := validate(config, "development"); err == nil {
+		t.Error("Zero stop grace period should fail validation")
+	}
+	config.Orchestrator.StopGracePeriod = "10s"
+	config.Orchestrator.DependencyTimeout = "soon"
+	if err := validate(config, "development"); err == nil {
+		t.Error("Invalid dependency timeout should fail validation")
+	}
+	config.Orchestrator.DependencyTimeout = "5m"
+	config.Console.ServiceHealth.FailureThreshold = -1
+	if err := validate(config, "development"); err == nil {
+		t.Error("Negative service health failure threshold should fail validation")
+	}
+	config.Console.ServiceHealth.FailureThreshold = 3
+	config.Console.ServiceHealth.WebhookURL = "hooks.example.com/health"
+	if err := validate(config, "development"); err == nil {
+		t.Error("Service health webhook without a scheme should fail validation")
+	}
+	config.Console.ServiceHealth.WebhookURL = "https://hooks.example.com/health"
+	config.Snap.FullEvery = -1
+	if err := validate(config, "development"); err == nil {
+		t.Error("Negative snap full_every should fail validation")
+	}
+	config.Snap.FullEvery = 7
+
+	config.Gate.TLS.DefaultCert = "/etc/infra-core/default.crt"
+	if err := validate(config, "development"); err == nil {
+		t.Error("Default certificate without a key should fail validation")
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	tests := map[string]time.Duration{
+		"7d":  7 * 24 * time.Hour,
+		"0d":  0,
+		"36h": 36 * time.Hour,
+		"90m": 90 * time.Minute,
+	}
+	for value, expected := range tests {
+		got, err := ParseRetention(value)
+		if err != nil {
+			t.Errorf("ParseRetention(%q) failed: %v", value, err)
+		} else if got != expected {
+			t.Errorf("ParseRetention(%q) = %v, expected %v", value, got, expected)
+		}
+	}
+
+	for _, value := range []string{"", "d", "1.5d", "-1d", "-1h", "week"} {
+		if _, err := ParseRetention(value); err == nil {
+			t.Errorf("ParseRetention(%q) should fail", value)
+		}
+	}
 }
 
 func TestValidateInvalidConfiguration(t *testing.T) {
@@ -305,4 +467,32 @@ func TestGetAfterLoad(t *testing.T) {
 	if config1 != config2 {
 		t.Error("Get() should return the same instance as Load()")
 	}
-}
\ No newline at end of file
+}
+func TestParseByteRate(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"100/s":    100,
+		"100B/s":   100,
+		"512KB/s":  512 << 10,
+		"10MB/s":   10 << 20,
+		"10mb/s":   10 << 20,
+		"1GB/s":    1 << 30,
+		" 5 MB/s ": 5 << 20,
+	} {
+		rate, err := ParseByteRate(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, rate, value)
+	}
+
+	for _, value := range []string{"", "10MB", "10/m", "MB/s", "0MB/s", "-1MB/s", "10TB/s", "1.5MB/s"} {
+		_, err := ParseByteRate(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestOIDCIssuer(t *testing.T) {
+	console := ConsoleConfig{ExternalURL: "https://console.example.com"}
+	assert.Equal(t, "https://console.example.com", console.OIDCIssuer(), "the issuer defaults to the external URL")
+	console.Auth.OIDC.Issuer = "https://id.example.com"
+	assert.Equal(t, "https://id.example.com", console.OIDCIssuer())
+	assert.Empty(t, (&ConsoleConfig{}).OIDCIssuer())
+}
@@ -0,0 +1,606 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+// ValidationError is a problem with one configuration value
+type ValidationError struct {
+	Path    string `json:"path"` // YAML path of the value, such as console.auth.lockout.window
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors lists every problem found in a configuration
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = "  - " + err.Error()
+	}
+	return fmt.Sprintf("%d configuration problem(s):\n%s", len(e), strings.Join(lines, "\n"))
+}
+
+// Validate checks the configuration, returning ValidationErrors listing every
+// problem rather than stopping at the first
+func (c *Config) Validate() error {
+	return validate(c, c.environment)
+}
+
+// validator collects the problems found while validating a configuration
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// required checks that a value is set
+func (v *validator) required(path, value string) {
+	if value == "" {
+		v.add(path, "cannot be empty")
+	}
+}
+
+// port checks that a port is a valid TCP port
+func (v *validator) port(path string, port int) {
+	if port <= 0 || port > 65535 {
+		v.add(path, "must be between 1 and 65535, got %d", port)
+	}
+}
+
+// nonNegative checks a count that may be zero
+func (v *validator) nonNegative(path string, n int) {
+	if n < 0 {
+		v.add(path, "cannot be negative, got %d", n)
+	}
+}
+
+// duration checks that a value, if set, is a positive Go duration
+func (v *validator) duration(path, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		v.add(path, "must be a positive duration such as 30s or 5m, got %q", value)
+		return 0
+	}
+	return d
+}
+
+// retention checks that a value, if set, is a retention period
+func (v *validator) retention(path, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := ParseRetention(value)
+	if err != nil {
+		v.add(path, "must be a duration such as 36h or a number of days such as 7d, got %q", value)
+		return 0
+	}
+	return d
+}
+
+// httpURL checks that a value, if set, is an http or https URL
+func (v *validator) httpURL(path, value string) {
+	if value == "" {
+		return
+	}
+	if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add(path, "must be an http:// or https:// URL, got %q", value)
+	}
+}
+
+// baseURL checks that a value, if set, is an http or https URL that paths
+// can be appended to
+func (v *validator) baseURL(path, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		v.add(path, "must be an http:// or https:// URL without query or fragment, got %q", value)
+	}
+}
+
+// logs checks a daemon's log level and format
+func (v *validator) logs(path string, logs LogConfig) {
+	switch strings.ToLower(logs.Level) {
+	case "", "debug", "info", "warn", "warning", "error":
+	default:
+		v.add(path+".level", "must be debug, info, warn or error, got %q", logs.Level)
+	}
+	switch logs.Format {
+	case "", "json", "text":
+	default:
+		v.add(path+".format", "must be json or text, got %q", logs.Format)
+	}
+}
+
+// accessLog checks the gate's access log settings
+func (v *validator) accessLog(path string, accessLog AccessLogConfig) {
+	switch accessLog.Format {
+	case "", "json", "combined":
+	default:
+		v.add(path+".format", "must be json or combined, got %q", accessLog.Format)
+	}
+	v.nonNegative(path+".max_size_mb", accessLog.MaxSizeMB)
+	v.nonNegative(path+".max_files", accessLog.MaxFiles)
+	v.nonNegative(path+".buffer_size", accessLog.BufferSize)
+	for route, n := range accessLog.Sample {
+		if n < 1 {
+			v.add(path+".sample."+route, "must be at least 1, got %d", n)
+		}
+	}
+}
+
+// networks checks that every entry is an IP address or CIDR
+func (v *validator) networks(path string, networks []string) {
+	for i, network := range networks {
+		if _, _, err := net.ParseCIDR(network); err != nil && net.ParseIP(network) == nil {
+			v.add(fmt.Sprintf("%s[%d]", path, i), "must be an IP address or CIDR, got %q", network)
+		}
+	}
+}
+
+// accessControl checks a gate access control policy
+func (v *validator) accessControl(path string, ac AccessControlConfig) {
+	v.networks(path+".allow_cidrs", ac.AllowCIDRs)
+	v.networks(path+".deny_cidrs", ac.DenyCIDRs)
+	if ac.BasicAuth != nil {
+		v.required(path+".basic_auth.username", ac.BasicAuth.Username)
+		if _, err := bcrypt.Cost([]byte(ac.BasicAuth.PasswordHash)); err != nil {
+			v.add(path+".basic_auth.password_hash", "must be a bcrypt hash")
+		}
+	}
+}
+
+// validate validates the configuration for an environment
+func validate(config *Config, environment string) error {
+	v := &validator{}
+
+	validateGate(v, config.Gate)
+	validateConsole(v, config.Console)
+	validateOrchestrator(v, config.Orchestrator)
+	validateProbe(v, config.Probe)
+	validateSnap(v, config.Snap)
+	validateTracing(v, config.Tracing)
+	if config.Secrets.MasterKey != "" {
+		if _, err := ParseMasterKey(config.Secrets.MasterKey); err != nil {
+			v.add("secrets.master_key", "must be the base64 encoding of %d bytes, such as the output of openssl rand -base64 %d", MasterKeySize, MasterKeySize)
+		}
+	}
+	validatePorts(v, config)
+
+	// JWT secret is required in production
+	if environment == "production" && config.Console.Auth.JWT.Secret == "" {
+		v.add("console.auth.jwt.secret", "is required in the production environment")
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// validatePorts checks that no two services listen on the same port
+func validatePorts(v *validator, config *Config) {
+	ports := []struct {
+		path string
+		port int
+	}{
+		{"gate.ports.http", config.Gate.Ports.HTTP},
+		{"gate.ports.https", config.Gate.Ports.HTTPS},
+		{"console.port", config.Console.Port},
+		{"orchestrator.port", config.Orchestrator.Port},
+		{"probe.port", config.Probe.Port},
+		{"snap.port", config.Snap.Port},
+	}
+
+	used := make(map[int]string)
+	for _, p := range ports {
+		if p.port <= 0 {
+			continue
+		}
+		if other, ok := used[p.port]; ok {
+			v.add(p.path, "port %d is already used by %s", p.port, other)
+			continue
+		}
+		used[p.port] = p.path
+	}
+}
+
+func validateGate(v *validator, gate GateConfig) {
+	v.required("gate.host", gate.Host)
+	v.port("gate.ports.http", gate.Ports.HTTP)
+	v.port("gate.ports.https", gate.Ports.HTTPS)
+	v.logs("gate.logs", gate.Logs)
+	v.accessLog("gate.access_log", gate.AccessLog)
+	v.networks("gate.trusted_proxies", gate.TrustedProxies)
+	v.accessControl("gate.access_control", gate.AccessControl)
+	v.nonNegative("gate.cache.max_size_mb", gate.Cache.MaxSizeMB)
+	v.nonNegative("gate.cache.max_entry_kb", gate.Cache.MaxEntryKB)
+	v.nonNegative("gate.circuit_breaker.failures", gate.CircuitBreaker.Failures)
+	v.duration("gate.circuit_breaker.window", gate.CircuitBreaker.Window)
+	v.duration("gate.circuit_breaker.cooldown", gate.CircuitBreaker.Cooldown)
+	v.duration("gate.upgrade.drain_timeout", gate.Upgrade.DrainTimeout)
+	v.duration("gate.metrics_export.flush_interval", gate.MetricsExport.FlushInterval)
+	v.httpURL("gate.metrics_export.console_url", gate.MetricsExport.ConsoleURL)
+	v.nonNegative("gate.metrics_export.max_buffered", gate.MetricsExport.MaxBuffered)
+	validateStaticRoutes(v, gate.StaticRoutes)
+	if (gate.TLS.DefaultCert == "") != (gate.TLS.DefaultKey == "") {
+		v.add("gate.tls", "default_cert and default_key must be set together")
+	}
+	switch gate.TLS.Mode {
+	case "", TLSModeACME:
+	case TLSModeSelfSigned:
+		v.required("gate.acme.cache_dir", gate.ACME.CacheDir)
+		v.retention("gate.tls.leaf_validity", gate.TLS.LeafValidity)
+		if validity, err := ParseRetention(gate.TLS.LeafValidity); err == nil && validity < time.Hour {
+			v.add("gate.tls.leaf_validity", "must be at least 1h, got %q", gate.TLS.LeafValidity)
+		}
+	default:
+		v.add("gate.tls.mode", "must be acme or self-signed, got %q", gate.TLS.Mode)
+	}
+
+	if gate.ACME.Enabled {
+		if address, err := mail.ParseAddress(gate.ACME.Email); err != nil || address.Address != gate.ACME.Email {
+			v.add("gate.acme.email", "must be an email address when ACME is enabled, got %q", gate.ACME.Email)
+		}
+		v.required("gate.acme.cache_dir", gate.ACME.CacheDir)
+		v.httpURL("gate.acme.directory_url", gate.ACME.DirectoryURL)
+		v.acmeDNS("gate.acme", gate.ACME)
+	}
+}
+
+// validateStaticRoutes checks that the gate's static routes have unique IDs
+// and can be proxied
+func validateStaticRoutes(v *validator, routes []StaticRouteConfig) {
+	ids := make(map[string]bool)
+	for i, route := range routes {
+		path := fmt.Sprintf("gate.static_routes[%d]", i)
+		if route.ID == "" {
+			v.add(path+".id", "cannot be empty")
+		} else if ids[route.ID] {
+			v.add(path+".id", "duplicate route %s", route.ID)
+		}
+		ids[route.ID] = true
+
+		v.required(path+".upstream", route.Upstream)
+		v.httpURL(path+".upstream", route.Upstream)
+		if route.Path != "" && !strings.HasPrefix(route.Path, "/") {
+			v.add(path+".path", "must start with /, got %q", route.Path)
+		}
+		if route.ReadyPath != "" && !strings.HasPrefix(route.ReadyPath, "/") {
+			v.add(path+".ready_path", "must start with /, got %q", route.ReadyPath)
+		}
+	}
+}
+
+// acmeDNS checks the challenge type and the DNS provider solving DNS-01
+// challenges
+func (v *validator) acmeDNS(path string, acme ACMEConfig) {
+	dns := acme.DNS
+	switch acme.ChallengeType {
+	case "", ChallengeHTTP01:
+	case ChallengeDNS01:
+		if dns.Provider == "" {
+			v.add(path+".dns.provider", "must be set when challenge_type is dns-01")
+		}
+	default:
+		v.add(path+".challenge_type", "must be http-01 or dns-01, got %q", acme.ChallengeType)
+	}
+
+	switch dns.Provider {
+	case "":
+		return
+	case "cloudflare":
+		v.required(path+".dns.cloudflare.api_token", dns.Cloudflare.APIToken)
+	default:
+		v.add(path+".dns.provider", "must be cloudflare, got %q", dns.Provider)
+	}
+	v.duration(path+".dns.propagation_timeout", dns.PropagationTimeout)
+	v.duration(path+".dns.polling_interval", dns.PollingInterval)
+	for i, server := range dns.Nameservers {
+		host := server
+		if h, _, err := net.SplitHostPort(server); err == nil {
+			host = h
+		}
+		if host == "" || strings.ContainsAny(host, "/ ") {
+			v.add(fmt.Sprintf("%s.dns.nameservers[%d]", path, i), "must be a host or host:port, got %q", server)
+		}
+	}
+}
+
+func validateConsole(v *validator, console ConsoleConfig) {
+	v.required("console.host", console.Host)
+	v.port("console.port", console.Port)
+	v.baseURL("console.external_url", console.ExternalURL)
+	v.logs("console.logs", console.Logs)
+	v.required("console.database.path", console.Database.Path)
+	v.duration("console.database.timeout", console.Database.Timeout)
+	v.duration("console.database.retry_timeout", console.Database.RetryTimeout)
+	v.duration("console.database.query_stats.slow_threshold", console.Database.QueryStats.SlowThreshold)
+	v.nonNegative("console.database.query_stats.max_statements", console.Database.QueryStats.MaxStatements)
+	v.duration("console.incident_window", console.IncidentWindow)
+	v.duration("console.deploy_hooks.max_clock_skew", console.DeployHooks.MaxClockSkew)
+	v.nonNegative("console.deploy_hooks.max_deploys", console.DeployHooks.MaxDeploys)
+	v.duration("console.deploy_hooks.window", console.DeployHooks.Window)
+
+	auth := console.Auth
+	if auth.JWT.ExpiresHours <= 0 {
+		v.add("console.auth.jwt.expires_hours", "must be positive, got %d", auth.JWT.ExpiresHours)
+	}
+	switch auth.JWT.Algorithm {
+	case "", "RS256", "EdDSA", "HS256":
+	default:
+		v.add("console.auth.jwt.algorithm", "must be RS256, EdDSA or HS256, got %q", auth.JWT.Algorithm)
+	}
+	v.nonNegative("console.auth.session.timeout_minutes", auth.Session.TimeoutMinutes)
+	v.nonNegative("console.auth.lockout.max_attempts", auth.Lockout.MaxAttempts)
+	v.duration("console.auth.lockout.window", auth.Lockout.Window)
+	v.nonNegative("console.auth.password_policy.min_length", auth.PasswordPolicy.MinLength)
+	v.duration("console.auth.password_reset.token_ttl", auth.PasswordReset.TokenTTL)
+	v.duration("console.auth.impersonation.token_ttl", auth.Impersonation.TokenTTL)
+	v.baseURL("console.auth.oidc.issuer", auth.OIDC.Issuer)
+
+	validateCORS(v, console.CORS)
+	validateMetrics(v, console.Metrics)
+	validateRetention(v, console.Retention, console.Auth.Lockout)
+	v.nonNegative("console.service_health.failure_threshold", console.ServiceHealth.FailureThreshold)
+	v.httpURL("console.service_health.webhook_url", console.ServiceHealth.WebhookURL)
+	v.httpURL("console.daemons.probe_url", console.Daemons.ProbeURL)
+	v.httpURL("console.daemons.snap_url", console.Daemons.SnapURL)
+	v.httpURL("console.daemons.gate_url", console.Daemons.GateURL)
+	v.httpURL("console.daemons.orchestrator_url", console.Daemons.OrchestratorURL)
+	v.duration("console.daemons.timeout", console.Daemons.Timeout)
+}
+
+// validateCORS checks that allowed origins are bare scheme://host[:port]
+// origins. A lone "*" is refused because credentials are always allowed.
+func validateCORS(v *validator, cors CORSConfig) {
+	for i, origin := range cors.AllowedOrigins {
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			v.add(fmt.Sprintf("console.cors.allowed_origins[%d]", i),
+				"must be an origin such as https://app.example.com or https://*.example.com, got %q", origin)
+		}
+	}
+	v.duration("console.cors.max_age", cors.MaxAge)
+}
+
+// validateMetrics checks the collection interval and that raw metrics are
+// rolled up before they are deleted
+func validateMetrics(v *validator, metrics MetricsConfig) {
+	v.duration("console.metrics.collect_interval", metrics.CollectInterval)
+	rollupAfter := v.retention("console.metrics.rollup_after", metrics.RollupAfter)
+	rawRetention := v.retention("console.metrics.raw_retention", metrics.RawRetention)
+	if rollupAfter > 0 && rawRetention > 0 && rollupAfter >= rawRetention {
+		v.add("console.metrics.rollup_after", "must be shorter than console.metrics.raw_retention")
+	}
+}
+
+// validateRetention checks the cleanup's retentions and that failed logins
+// are kept for as long as they count towards a lockout
+func validateRetention(v *validator, retention RetentionConfig, lockout LockoutConfig) {
+	v.duration("console.retention.interval", retention.Interval)
+	v.retention("console.retention.metrics", retention.Metrics)
+	v.retention("console.retention.health_checks", retention.HealthChecks)
+	v.retention("console.retention.audit_logs", retention.AuditLogs)
+	loginAttempts := v.retention("console.retention.login_attempts", retention.LoginAttempts)
+	if window, err := time.ParseDuration(lockout.Window); err == nil && loginAttempts > 0 && loginAttempts < window {
+		v.add("console.retention.login_attempts", "must not be shorter than console.auth.lockout.window")
+	}
+}
+
+func validateOrchestrator(v *validator, orch OrchestratorConfig) {
+	v.port("orchestrator.port", orch.Port)
+	v.logs("orchestrator.logs", orch.Logs)
+	switch orch.Runtime {
+	case "", "simulated", "process", "docker":
+	default:
+		v.add("orchestrator.runtime", "must be simulated, process or docker, got %q", orch.Runtime)
+	}
+	v.duration("orchestrator.health_check_interval", orch.HealthCheckInterval)
+	v.duration("orchestrator.dependency_timeout", orch.DependencyTimeout)
+	v.duration("orchestrator.stop_grace_period", orch.StopGracePeriod)
+	if host := orch.DockerHost; host != "" && !strings.HasPrefix(host, "unix://") && !strings.HasPrefix(host, "tcp://") {
+		v.add("orchestrator.docker_host", "must be a unix:// or tcp:// address, got %q", host)
+	}
+	v.nonNegative("orchestrator.default_replicas", orch.DefaultReplicas)
+	v.nonNegative("orchestrator.max_deployments", orch.MaxDeployments)
+	v.nonNegative("orchestrator.service_logs.max_file_size_mb", orch.ServiceLogs.MaxFileSizeMB)
+	v.nonNegative("orchestrator.service_logs.max_files", orch.ServiceLogs.MaxFiles)
+	if ports := orch.ServicePorts; ports.Min != 0 || ports.Max != 0 {
+		v.port("orchestrator.service_ports.min", ports.Min)
+		v.port("orchestrator.service_ports.max", ports.Max)
+		if ports.Max > 0 && ports.Min > ports.Max {
+			v.add("orchestrator.service_ports", "min %d is above max %d", ports.Min, ports.Max)
+		}
+	}
+
+	cluster := orch.Cluster
+	v.httpURL("orchestrator.cluster.primary", cluster.Primary)
+	if cluster.Primary != "" && cluster.Token == "" {
+		v.add("orchestrator.cluster.token", "is required to register with the primary")
+	}
+	v.duration("orchestrator.cluster.heartbeat_interval", cluster.HeartbeatInterval)
+	v.duration("orchestrator.cluster.node_timeout", cluster.NodeTimeout)
+	if _, err := spec.ParseCPU(cluster.CPU); err != nil {
+		v.add("orchestrator.cluster.cpu", "must be cores like \"4\" or millicores like \"500m\", got %q", cluster.CPU)
+	}
+	if _, err := spec.ParseMemory(cluster.Memory); err != nil {
+		v.add("orchestrator.cluster.memory", "must be bytes with an optional unit like \"8Gi\", got %q", cluster.Memory)
+	}
+	v.nonNegative("orchestrator.cluster.pods", cluster.Pods)
+
+	v.duration("orchestrator.limits.sample_interval", orch.Limits.SampleInterval)
+	switch orch.Limits.MemoryAction {
+	case "", "alert", "restart":
+	default:
+		v.add("orchestrator.limits.memory_action", "must be alert or restart, got %q", orch.Limits.MemoryAction)
+	}
+	v.nonNegative("orchestrator.limits.memory_samples", orch.Limits.MemorySamples)
+}
+
+func validateProbe(v *validator, probe ProbeMonitorConfig) {
+	v.port("probe.port", probe.Port)
+	v.logs("probe.logs", probe.Logs)
+	v.duration("probe.check_interval", probe.CheckInterval)
+	v.duration("probe.alert_interval", probe.AlertInterval)
+	v.duration("probe.cleanup_interval", probe.CleanupInterval)
+	v.retention("probe.result_retention", probe.ResultRetention)
+	v.retention("probe.alert_retention", probe.AlertRetention)
+	v.nonNegative("probe.max_concurrent_probes", probe.MaxConcurrentProbes)
+	validateProbeNotifications(v, probe.Notifications)
+	validateProbePublishers(v, probe.Publishers)
+}
+
+// validateProbeNotifications checks that channels are complete and that routes
+// only name known severities and channels
+func validateProbeNotifications(v *validator, cfg ProbeNotificationsConfig) {
+	channels := make(map[string]bool)
+	for i, channel := range cfg.Channels {
+		path := fmt.Sprintf("probe.notifications.channels[%d]", i)
+		if channel.Name == "" {
+			v.add(path+".name", "cannot be empty")
+		} else if channels[channel.Name] {
+			v.add(path+".name", "duplicate channel %s", channel.Name)
+		}
+		channels[channel.Name] = true
+
+		switch channel.Type {
+		case "webhook", "slack":
+			if !strings.HasPrefix(channel.URL, "http://") && !strings.HasPrefix(channel.URL, "https://") {
+				v.add(path+".url", "must be an http:// or https:// URL for channel %s", channel.Name)
+			}
+		case "email":
+			if channel.SMTP.Host == "" || channel.SMTP.From == "" || len(channel.SMTP.To) == 0 {
+				v.add(path+".smtp", "channel %s needs host, from and to", channel.Name)
+			}
+			if channel.SMTP.Port < 0 || channel.SMTP.Port > 65535 {
+				v.add(path+".smtp.port", "must be between 1 and 65535, got %d", channel.SMTP.Port)
+			}
+		default:
+			v.add(path+".type", "must be webhook, slack or email, got %q", channel.Type)
+		}
+	}
+
+	for severity, names := range cfg.Routes {
+		path := "probe.notifications.routes." + severity
+		switch severity {
+		case "low", "medium", "high", "critical":
+		default:
+			v.add(path, "unknown severity %s, must be low, medium, high or critical", severity)
+		}
+		for _, name := range names {
+			if !channels[name] {
+				v.add(path, "unknown channel %s", name)
+			}
+		}
+	}
+
+	if cfg.RateLimit != "" {
+		if limit, err := time.ParseDuration(cfg.RateLimit); err != nil || limit < 0 {
+			v.add("probe.notifications.rate_limit", "must be a duration such as 5m, got %q", cfg.RateLimit)
+		}
+	}
+	v.nonNegative("probe.notifications.max_retries", cfg.MaxRetries)
+}
+
+// validateProbePublishers checks that publisher targets are complete
+func validateProbePublishers(v *validator, cfg ProbePublishersConfig) {
+	names := make(map[string]bool)
+	for i, target := range cfg.Targets {
+		path := fmt.Sprintf("probe.publishers.targets[%d]", i)
+		if target.Name == "" {
+			v.add(path+".name", "cannot be empty")
+		} else if names[target.Name] {
+			v.add(path+".name", "duplicate target %s", target.Name)
+		}
+		names[target.Name] = true
+
+		if !strings.HasPrefix(target.URL, "http://") && !strings.HasPrefix(target.URL, "https://") {
+			v.add(path+".url", "must be an http:// or https:// URL for target %s", target.Name)
+		}
+		if target.Secret == "" {
+			v.add(path+".secret", "cannot be empty, events to %s are signed with it", target.Name)
+		}
+	}
+	v.nonNegative("probe.publishers.debounce", cfg.Debounce)
+	v.nonNegative("probe.publishers.max_retries", cfg.MaxRetries)
+}
+
+func validateSnap(v *validator, snap SnapConfig) {
+	v.port("snap.port", snap.Port)
+	v.logs("snap.logs", snap.Logs)
+	v.required("snap.repo_dir", snap.RepoDir)
+	v.required("snap.temp_dir", snap.TempDir)
+	v.nonNegative("snap.max_parallel", snap.MaxParallel)
+	v.nonNegative("snap.snapshot_workers", snap.SnapshotWorkers)
+	v.nonNegative("snap.full_every", snap.FullEvery)
+	if snap.RateLimit != "" {
+		if _, err := ParseByteRate(snap.RateLimit); err != nil {
+			v.add("snap.rate_limit", "must be bytes per second such as 10MB/s, got %q", snap.RateLimit)
+		}
+	}
+	if snap.MaxRepoBytes < 0 {
+		v.add("snap.max_repo_bytes", "cannot be negative, got %d", snap.MaxRepoBytes)
+	}
+	if snap.MinFreeDiskBytes < 0 {
+		v.add("snap.min_free_disk_bytes", "cannot be negative, got %d", snap.MinFreeDiskBytes)
+	}
+	switch snap.Chunking.Mode {
+	case "", "fixed":
+	case "cdc":
+		v.nonNegative("snap.chunking.min_size", snap.Chunking.MinSize)
+		v.nonNegative("snap.chunking.avg_size", snap.Chunking.AvgSize)
+		v.nonNegative("snap.chunking.max_size", snap.Chunking.MaxSize)
+		if minSize, avgSize, maxSize := snap.Chunking.Sizes(); minSize >= avgSize || avgSize >= maxSize {
+			v.add("snap.chunking", "sizes must grow from min_size to avg_size to max_size, got %d, %d and %d", minSize, avgSize, maxSize)
+		} else if maxSize > MaxChunkSize {
+			v.add("snap.chunking.max_size", "must be at most %d, got %d", MaxChunkSize, maxSize)
+		}
+	default:
+		v.add("snap.chunking.mode", "must be fixed or cdc, got %q", snap.Chunking.Mode)
+	}
+	switch snap.Compression {
+	case "", "none", "gzip":
+	default:
+		v.add("snap.compression", "must be none or gzip, got %q", snap.Compression)
+	}
+	v.duration("snap.scrub_interval", snap.ScrubInterval)
+	v.nonNegative("snap.default_retention.daily", snap.DefaultRetention.Daily)
+	v.nonNegative("snap.default_retention.weekly", snap.DefaultRetention.Weekly)
+	v.nonNegative("snap.default_retention.monthly", snap.DefaultRetention.Monthly)
+}
+
+func validateTracing(v *validator, tracing TracingConfig) {
+	v.httpURL("tracing.endpoint", tracing.Endpoint)
+	if tracing.SampleRatio < 0 || tracing.SampleRatio > 1 {
+		v.add("tracing.sample_ratio", "must be between 0 and 1, got %g", tracing.SampleRatio)
+	}
+	for daemon := range tracing.ServiceNames {
+		switch daemon {
+		case "gate", "console", "orchestrator", "probe", "snap":
+		default:
+			v.add("tracing.service_names."+daemon, "unknown daemon %s, must be gate, console, orchestrator, probe or snap", daemon)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// validConfig returns a configuration that passes validation
+func validConfig() *Config {
+	config := &Config{environment: "development"}
+	config.Gate.Host = "0.0.0.0"
+	config.Gate.Ports = PortsConfig{HTTP: 8080, HTTPS: 8443}
+	config.Gate.ACME = ACMEConfig{
+		DirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory",
+		Email:        "ops@example.com",
+		CacheDir:     "./certs",
+	}
+	config.Console.Host = "0.0.0.0"
+	config.Console.Port = 8081
+	config.Console.Database = DatabaseConfig{Path: "./test.db", Timeout: "30s"}
+	config.Console.Auth.JWT = JWTConfig{Secret: "test-secret", ExpiresHours: 24}
+	config.Orchestrator = OrchestratorConfig{Port: 8084, HealthCheckInterval: "30s", DefaultReplicas: 1, MaxDeployments: 50}
+	config.Probe = ProbeMonitorConfig{Port: 8083, CheckInterval: "10s", AlertInterval: "30s", CleanupInterval: "5m", MaxConcurrentProbes: 10}
+	config.Snap = SnapConfig{Port: 8085, RepoDir: "./snapshots", TempDir: "./temp", MaxParallel: 4, ScrubInterval: "24h"}
+	config.Snap.DefaultRetention.Daily = 7
+	return config
+}
+
+// validationPaths returns the paths of the problems validate reports
+func validationPaths(t *testing.T, config *Config) []string {
+	err := config.Validate()
+	if err == nil {
+		return nil
+	}
+
+	var problems ValidationErrors
+	require.True(t, errors.As(err, &problems), "validation should return ValidationErrors, got %v", err)
+	paths := make([]string, len(problems))
+	for i, problem := range problems {
+		paths[i] = problem.Path
+	}
+	return paths
+}
+
+func TestValidateRules(t *testing.T) {
+	require.NoError(t, validConfig().Validate())
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		path   string
+	}{
+		{"missing gate host", func(c *Config) { c.Gate.Host = "" }, "gate.host"},
+		{"missing database path", func(c *Config) { c.Console.Database.Path = "" }, "console.database.path"},
+		{"missing snapshot repository", func(c *Config) { c.Snap.RepoDir = "" }, "snap.repo_dir"},
+		{"missing snapshot temp directory", func(c *Config) { c.Snap.TempDir = "" }, "snap.temp_dir"},
+		{"port out of range", func(c *Config) { c.Console.Port = 70000 }, "console.port"},
+		{"port missing", func(c *Config) { c.Probe.Port = 0 }, "probe.port"},
+		{"conflicting ports", func(c *Config) { c.Snap.Port = c.Console.Port }, "snap.port"},
+		{"conflicting gate ports", func(c *Config) { c.Orchestrator.Port = c.Gate.Ports.HTTPS }, "orchestrator.port"},
+		{"unparseable database timeout", func(c *Config) { c.Console.Database.Timeout = "30" }, "console.database.timeout"},
+		{"negative database retry timeout", func(c *Config) { c.Console.Database.RetryTimeout = "-1s" }, "console.database.retry_timeout"},
+		{"invalid slow query threshold", func(c *Config) { c.Console.Database.QueryStats.SlowThreshold = "slow" }, "console.database.query_stats.slow_threshold"},
+		{"unparseable health check interval", func(c *Config) { c.Orchestrator.HealthCheckInterval = "often" }, "orchestrator.health_check_interval"},
+		{"zero probe interval", func(c *Config) { c.Probe.CheckInterval = "0s" }, "probe.check_interval"},
+		{"unparseable scrub interval", func(c *Config) { c.Snap.ScrubInterval = "daily" }, "snap.scrub_interval"},
+		{"unparseable result retention", func(c *Config) { c.Probe.ResultRetention = "a week" }, "probe.result_retention"},
+		{"unsigned publisher target", func(c *Config) {
+			c.Probe.Publishers.Targets = []PublisherTargetConfig{{Name: "status", URL: "https://status.example.com/hooks"}}
+		}, "probe.publishers.targets[0].secret"},
+		{"negative snapshot retention", func(c *Config) { c.Snap.DefaultRetention.Monthly = -1 }, "snap.default_retention.monthly"},
+		{"negative parallelism", func(c *Config) { c.Snap.MaxParallel = -1 }, "snap.max_parallel"},
+		{"negative snapshot workers", func(c *Config) { c.Snap.SnapshotWorkers = -1 }, "snap.snapshot_workers"},
+		{"unparseable snapshot rate limit", func(c *Config) { c.Snap.RateLimit = "10/m" }, "snap.rate_limit"},
+		{"negative repository quota", func(c *Config) { c.Snap.MaxRepoBytes = -1 }, "snap.max_repo_bytes"},
+		{"negative free disk floor", func(c *Config) { c.Snap.MinFreeDiskBytes = -1 }, "snap.min_free_disk_bytes"},
+		{"unknown chunking mode", func(c *Config) { c.Snap.Chunking.Mode = "rabin" }, "snap.chunking.mode"},
+		{"chunk sizes out of order", func(c *Config) {
+			c.Snap.Chunking = SnapChunkingConfig{Mode: "cdc", MinSize: 1 << 20, AvgSize: 512 << 10}
+		}, "snap.chunking"},
+		{"oversized chunks", func(c *Config) { c.Snap.Chunking = SnapChunkingConfig{Mode: "cdc", MaxSize: 8 << 20} }, "snap.chunking.max_size"},
+		{"unknown compression", func(c *Config) { c.Snap.Compression = "zstd" }, "snap.compression"},
+		{"negative replicas", func(c *Config) { c.Orchestrator.DefaultReplicas = -1 }, "orchestrator.default_replicas"},
+		{"unknown log level", func(c *Config) { c.Probe.Logs.Level = "verbose" }, "probe.logs.level"},
+		{"unknown log format", func(c *Config) { c.Gate.Logs.Format = "xml" }, "gate.logs.format"},
+		{"unknown access log format", func(c *Config) { c.Gate.AccessLog.Format = "clf" }, "gate.access_log.format"},
+		{"malformed trusted proxy", func(c *Config) { c.Gate.TrustedProxies = []string{"10.0.0.0/8", "proxy.local"} }, "gate.trusted_proxies[1]"},
+		{"access log sampling below one", func(c *Config) { c.Gate.AccessLog.Sample = map[string]int{"api": 0} }, "gate.access_log.sample.api"},
+		{"zero metrics export interval", func(c *Config) { c.Gate.MetricsExport.FlushInterval = "0s" }, "gate.metrics_export.flush_interval"},
+		{"metrics export to a non-HTTP console", func(c *Config) { c.Gate.MetricsExport.ConsoleURL = "console:8082" }, "gate.metrics_export.console_url"},
+		{"malformed denied network", func(c *Config) { c.Gate.AccessControl.DenyCIDRs = []string{"10.0.0.0/33"} }, "gate.access_control.deny_cidrs[0]"},
+		{"basic auth with a plain password", func(c *Config) {
+			c.Gate.AccessControl.BasicAuth = &BasicAuthConfig{Username: "ops", PasswordHash: "secret"}
+		}, "gate.access_control.basic_auth.password_hash"},
+		{"unknown TLS mode", func(c *Config) { c.Gate.TLS.Mode = "letsencrypt" }, "gate.tls.mode"},
+		{"unparseable leaf validity", func(c *Config) {
+			c.Gate.TLS.Mode = TLSModeSelfSigned
+			c.Gate.TLS.LeafValidity = "a month"
+		}, "gate.tls.leaf_validity"},
+		{"leaf validity under an hour", func(c *Config) {
+			c.Gate.TLS.Mode = TLSModeSelfSigned
+			c.Gate.TLS.LeafValidity = "10m"
+		}, "gate.tls.leaf_validity"},
+		{"tracing endpoint without scheme", func(c *Config) { c.Tracing.Endpoint = "collector:4318" }, "tracing.endpoint"},
+		{"sample ratio above one", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "tracing.sample_ratio"},
+		{"wildcard CORS origin", func(c *Config) { c.Console.CORS.AllowedOrigins = []string{"*"} }, "console.cors.allowed_origins[0]"},
+		{"CORS origin with a path", func(c *Config) {
+			c.Console.CORS.AllowedOrigins = []string{"https://*.example.com", "https://app.example.com/ui"}
+		}, "console.cors.allowed_origins[1]"},
+		{"invalid CORS max age", func(c *Config) { c.Console.CORS.MaxAge = "ten minutes" }, "console.cors.max_age"},
+		{"daemon URL without scheme", func(c *Config) { c.Console.Daemons.ProbeURL = "localhost:8085" }, "console.daemons.probe_url"},
+		{"gate URL without scheme", func(c *Config) { c.Console.Daemons.GateURL = "localhost:9080" }, "console.daemons.gate_url"},
+		{"service port range without max", func(c *Config) { c.Orchestrator.ServicePorts.Min = 20000 }, "orchestrator.service_ports.max"},
+		{"inverted service port range", func(c *Config) { c.Orchestrator.ServicePorts = ServicePortsConfig{Min: 30000, Max: 20000} }, "orchestrator.service_ports"},
+		{"cluster primary without token", func(c *Config) { c.Orchestrator.Cluster.Primary = "http://primary:8084" }, "orchestrator.cluster.token"},
+		{"cluster primary without scheme", func(c *Config) { c.Orchestrator.Cluster = ClusterConfig{Primary: "primary:8084", Token: "secret"} }, "orchestrator.cluster.primary"},
+		{"invalid node cpu", func(c *Config) { c.Orchestrator.Cluster.CPU = "four" }, "orchestrator.cluster.cpu"},
+		{"invalid node memory", func(c *Config) { c.Orchestrator.Cluster.Memory = "8 gigs" }, "orchestrator.cluster.memory"},
+		{"unknown memory action", func(c *Config) { c.Orchestrator.Limits.MemoryAction = "kill" }, "orchestrator.limits.memory_action"},
+		{"invalid usage sample interval", func(c *Config) { c.Orchestrator.Limits.SampleInterval = "often" }, "orchestrator.limits.sample_interval"},
+		{"orchestrator URL without scheme", func(c *Config) { c.Console.Daemons.OrchestratorURL = "localhost:8084" }, "console.daemons.orchestrator_url"},
+		{"unparseable daemon timeout", func(c *Config) { c.Console.Daemons.Timeout = "10" }, "console.daemons.timeout"},
+		{"unparseable retention interval", func(c *Config) { c.Console.Retention.Interval = "daily" }, "console.retention.interval"},
+		{"unparseable audit log retention", func(c *Config) { c.Console.Retention.AuditLogs = "a year" }, "console.retention.audit_logs"},
+		{"login attempts kept shorter than the lockout window", func(c *Config) {
+			c.Console.Auth.Lockout.Window = "2h"
+			c.Console.Retention.LoginAttempts = "1h"
+		}, "console.retention.login_attempts"},
+		{"non-expiring tokens", func(c *Config) { c.Console.Auth.JWT.ExpiresHours = 0 }, "console.auth.jwt.expires_hours"},
+		{"unparseable impersonation token TTL", func(c *Config) { c.Console.Auth.Impersonation.TokenTTL = "15" }, "console.auth.impersonation.token_ttl"},
+		{"unparseable deploy hook clock skew", func(c *Config) { c.Console.DeployHooks.MaxClockSkew = "5" }, "console.deploy_hooks.max_clock_skew"},
+		{"negative deploy hook rate limit", func(c *Config) { c.Console.DeployHooks.MaxDeploys = -1 }, "console.deploy_hooks.max_deploys"},
+		{"ACME without email", func(c *Config) { c.Gate.ACME.Enabled = true; c.Gate.ACME.Email = "" }, "gate.acme.email"},
+		{"ACME with malformed email", func(c *Config) { c.Gate.ACME.Enabled = true; c.Gate.ACME.Email = "ops@" }, "gate.acme.email"},
+		{"ACME email with a display name", func(c *Config) { c.Gate.ACME.Enabled = true; c.Gate.ACME.Email = "Ops <ops@example.com>" }, "gate.acme.email"},
+		{"ACME without cache directory", func(c *Config) { c.Gate.ACME.Enabled = true; c.Gate.ACME.CacheDir = "" }, "gate.acme.cache_dir"},
+		{"unparseable circuit breaker cooldown", func(c *Config) { c.Gate.CircuitBreaker.Cooldown = "30" }, "gate.circuit_breaker.cooldown"},
+		{"static route without upstream", func(c *Config) { c.Gate.StaticRoutes = []StaticRouteConfig{{ID: "console"}} }, "gate.static_routes[0].upstream"},
+		{"static route with relative upstream", func(c *Config) {
+			c.Gate.StaticRoutes = []StaticRouteConfig{{ID: "console", Upstream: "127.0.0.1:8082"}}
+		}, "gate.static_routes[0].upstream"},
+		{"duplicate static route", func(c *Config) {
+			c.Gate.StaticRoutes = []StaticRouteConfig{{ID: "app", Upstream: "http://127.0.0.1:3000"}, {ID: "app", Upstream: "http://127.0.0.1:3001"}}
+		}, "gate.static_routes[1].id"},
+		{"static route ready path without slash", func(c *Config) {
+			c.Gate.StaticRoutes = []StaticRouteConfig{{ID: "app", Upstream: "http://127.0.0.1:3000", ReadyPath: "health"}}
+		}, "gate.static_routes[0].ready_path"},
+		{"unknown challenge type", func(c *Config) { c.Gate.ACME.Enabled = true; c.Gate.ACME.ChallengeType = "tls-alpn-01" }, "gate.acme.challenge_type"},
+		{"DNS-01 without provider", func(c *Config) { c.Gate.ACME.Enabled = true; c.Gate.ACME.ChallengeType = ChallengeDNS01 }, "gate.acme.dns.provider"},
+		{"unknown DNS provider", func(c *Config) {
+			c.Gate.ACME.Enabled = true
+			c.Gate.ACME.DNS.Provider = "route53"
+		}, "gate.acme.dns.provider"},
+		{"Cloudflare without API token", func(c *Config) { c.Gate.ACME.Enabled = true; c.Gate.ACME.DNS.Provider = "cloudflare" }, "gate.acme.dns.cloudflare.api_token"},
+		{"unparseable propagation timeout", func(c *Config) {
+			c.Gate.ACME.Enabled = true
+			c.Gate.ACME.DNS = ACMEDNSConfig{Provider: "cloudflare", PropagationTimeout: "2", Cloudflare: CloudflareConfig{APIToken: "token"}}
+		}, "gate.acme.dns.propagation_timeout"},
+		{"malformed nameserver", func(c *Config) {
+			c.Gate.ACME.Enabled = true
+			c.Gate.ACME.DNS = ACMEDNSConfig{Provider: "cloudflare", Nameservers: []string{"1.1.1.1:53", ":53"}, Cloudflare: CloudflareConfig{APIToken: "token"}}
+		}, "gate.acme.dns.nameservers[1]"},
+		{"master key of the wrong size", func(c *Config) { c.Secrets.MasterKey = "c2hvcnQ=" }, "secrets.master_key"},
+		{"master key that is not base64", func(c *Config) { c.Secrets.MasterKey = "not base64!" }, "secrets.master_key"},
+		{"external URL with a query", func(c *Config) { c.Console.ExternalURL = "https://console.example.com/?x=1" }, "console.external_url"},
+		{"relative OIDC issuer", func(c *Config) { c.Console.Auth.OIDC.Issuer = "/console" }, "console.auth.oidc.issuer"},
+		{"production without JWT secret", func(c *Config) { c.environment = "production"; c.Console.Auth.JWT.Secret = "" }, "console.auth.jwt.secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validConfig()
+			tt.modify(config)
+			assert.Equal(t, []string{tt.path}, validationPaths(t, config))
+		})
+	}
+
+	// Rules that only apply with ACME enabled
+	config := validConfig()
+	config.Gate.ACME.Email = ""
+	assert.NoError(t, config.Validate())
+	config.Gate.ACME.Enabled = true
+	config.Gate.ACME.Email = "ops@example.com"
+	assert.NoError(t, config.Validate())
+	config.Gate.ACME.ChallengeType = ChallengeDNS01
+	config.Gate.ACME.DNS = ACMEDNSConfig{Provider: "cloudflare", PropagationTimeout: "5m", Nameservers: []string{"1.1.1.1", "ns.example.com:53"}, Cloudflare: CloudflareConfig{APIToken: "token"}}
+	assert.NoError(t, config.Validate())
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	config := validConfig()
+	config.Gate.Host = ""
+	config.Probe.Port = config.Gate.Ports.HTTP
+	config.Snap.ScrubInterval = "daily"
+	config.Gate.ACME.Enabled = true
+	config.Gate.ACME.Email = "not-an-email"
+
+	assert.ElementsMatch(t, []string{"gate.host", "gate.acme.email", "probe.port", "snap.scrub_interval"}, validationPaths(t, config))
+
+	err := config.Validate()
+	assert.Contains(t, err.Error(), "4 configuration problem(s)")
+	assert.Contains(t, err.Error(), "probe.port: port 8080 is already used by gate.ports.http")
+}
+
+func TestLoadReportsEnvironmentOverrideProblems(t *testing.T) {
+	tmpDir := createTestConfig(t)
+	defer os.RemoveAll(tmpDir)
+
+	originalWd, _ := os.Getwd()
+	require.NoError(t, os.Chdir(tmpDir))
+	defer func() {
+		require.NoError(t, os.Chdir(originalWd))
+	}()
+
+	t.Setenv("INFRA_CORE_CONSOLE_PORT", "8080")
+
+	// Read merges overrides without validating
+	config, err := Read()
+	require.NoError(t, err)
+	assert.Equal(t, "development", config.Environment())
+	assert.Equal(t, 8080, config.Console.Port)
+
+	_, err = Load()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "console.port: port 8080 is already used by gate.ports.http")
+}
+
+func TestRedacted(t *testing.T) {
+	config := validConfig()
+	config.Console.ServiceHealth.WebhookURL = "https://hooks.example.com/services/T000/B000/secret"
+	config.Console.Daemons.Token = "daemon-token"
+	config.Gate.ACME.DNS.Cloudflare.APIToken = "cloudflare-token"
+	config.Secrets.MasterKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	config.Probe.Notifications.Channels = []NotificationChannelConfig{
+		{Name: "ops", Type: "slack", URL: "https://hooks.slack.com/services/T000/B000/secret"},
+		{Name: "mail", Type: "email", SMTP: SMTPConfig{Host: "smtp.example.com", Username: "alerts", Password: "smtp-password"}},
+	}
+	config.Probe.Publishers.Targets = []PublisherTargetConfig{{Name: "status", URL: "https://status.example.com/hooks", Secret: "publisher-secret"}}
+
+	redacted := config.Redacted()
+	assert.Equal(t, redactedValue, redacted.Console.Auth.JWT.Secret)
+	assert.Equal(t, redactedValue, redacted.Console.ServiceHealth.WebhookURL)
+	assert.Equal(t, redactedValue, redacted.Console.Daemons.Token)
+	assert.Equal(t, redactedValue, redacted.Gate.ACME.DNS.Cloudflare.APIToken)
+	assert.Equal(t, redactedValue, redacted.Secrets.MasterKey)
+	assert.Equal(t, redactedValue, redacted.Probe.Notifications.Channels[0].URL)
+	assert.Equal(t, redactedValue, redacted.Probe.Notifications.Channels[1].SMTP.Password)
+	assert.Empty(t, redacted.Probe.Notifications.Channels[1].URL, "unset secrets stay empty")
+	assert.Equal(t, "alerts", redacted.Probe.Notifications.Channels[1].SMTP.Username)
+	assert.Equal(t, redactedValue, redacted.Probe.Publishers.Targets[0].Secret)
+	assert.Equal(t, "publisher-secret", config.Probe.Publishers.Targets[0].Secret)
+	assert.Equal(t, config.Console.Port, redacted.Console.Port)
+
+	// The original is left alone
+	assert.Equal(t, "test-secret", config.Console.Auth.JWT.Secret)
+	assert.Equal(t, "smtp-password", config.Probe.Notifications.Channels[1].SMTP.Password)
+
+	var out bytes.Buffer
+	require.NoError(t, config.WriteRedacted(&out))
+	for _, secret := range []string{"test-secret", "smtp-password", "B000/secret", "cloudflare-token", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="} {
+		assert.NotContains(t, out.String(), secret)
+	}
+	assert.Contains(t, out.String(), "secret: '[REDACTED]'")
+	assert.Contains(t, out.String(), "repo_dir: ./snapshots")
+}
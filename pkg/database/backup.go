@@ -0,0 +1,125 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupPrefix = "console-"
+	backupSuffix = ".db"
+
+	// backupTimeFormat names backups so they sort chronologically
+	backupTimeFormat = "20060102-150405.000"
+)
+
+// BackupInfo describes a database backup file
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	SizeBytes int64     `json:"size_bytes"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BackupTo writes a consistent copy of the live database to path using
+// VACUUM INTO, which is safe while the database is in use and in WAL mode.
+// The file must not already exist.
+func (db *DB) BackupTo(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup file %s already exists", path)
+	}
+
+	if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// BackupDir returns the directory backups are written to, defaulting to a
+// backups directory next to the database file
+func (db *DB) BackupDir() string {
+	if dir := db.config.Console.Database.BackupDir; dir != "" {
+		return dir
+	}
+	if db.config.Console.Database.Path == ":memory:" {
+		return filepath.Join(os.TempDir(), "infra-core-backups")
+	}
+	return filepath.Join(filepath.Dir(db.config.Console.Database.Path), "backups")
+}
+
+// CreateBackup writes a timestamped backup into BackupDir
+func (db *DB) CreateBackup() (*BackupInfo, error) {
+	name := backupPrefix + time.Now().UTC().Format(backupTimeFormat) + backupSuffix
+	path := filepath.Join(db.BackupDir(), name)
+
+	if err := db.BackupTo(path); err != nil {
+		return nil, err
+	}
+	return backupInfo(path)
+}
+
+// ListBackups lists the backups in BackupDir, newest first
+func (db *DB) ListBackups() ([]*BackupInfo, error) {
+	entries, err := os.ReadDir(db.BackupDir())
+	if os.IsNotExist(err) {
+		return []*BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	backups := []*BackupInfo{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+
+		info, err := backupInfo(filepath.Join(db.BackupDir(), name))
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, info)
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// backupInfo describes the backup file at path, including its checksum
+func backupInfo(path string) (*BackupInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat backup: %w", err)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, fmt.Errorf("failed to checksum backup: %w", err)
+	}
+
+	return &BackupInfo{
+		Name:      stat.Name(),
+		Path:      path,
+		SizeBytes: stat.Size(),
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt: stat.ModTime().UTC(),
+	}, nil
+}
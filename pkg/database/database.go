@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"time"
@@ -14,8 +16,14 @@ import (
 
 // DB represents the database connection
 type DB struct {
-	*sqlx.DB
-	config *config.Config
+	*sqlx.DB              // writes; one connection for file databases, so they queue
+	reader       *sqlx.DB // read pool for queries, nil when they share the write pool
+	tx           *sqlx.Tx // transaction statements run in, see WithTx
+	config       *config.Config
+	ctx          context.Context // statements run with this, see WithContext
+	retryTimeout time.Duration   // how long busy statements are retried
+	stats        *retryStats
+	queries      *QueryStats // nil when statements are not timed
 }
 
 // NewDB creates a new database connection
@@ -25,20 +33,26 @@ func NewDB(cfg *config.Config) (*DB, error) {
 	// Handle special case for in-memory database
 	if dbPath == ":memory:" {
 		// Connect directly to in-memory database
-		db, err := sqlx.Connect("sqlite", ":memory:")
+		db, err := sqlx.Connect("sqlite", ":memory:?"+connectionPragmas(cfg))
 		if err != nil {
 			return nil, fmt.Errorf("failed to connect to in-memory database: %w", err)
 		}
 
 		// Create database instance
 		database := &DB{
-			DB:     db,
-			config: cfg,
+			DB:           db,
+			config:       cfg,
+			retryTimeout: retryTimeout(cfg),
+			stats:        &retryStats{},
+			queries:      newQueryStats(cfg.Console.Database.QueryStats),
 		}
 
-		// Initialize schema
-		if err := database.InitSchema(); err != nil {
-			return nil, fmt.Errorf("failed to initialize schema: %w", err)
+		if err := database.prepareSchema(); err != nil {
+			return nil, err
+		}
+
+		if err := database.checkIntegrity(); err != nil {
+			return nil, err
 		}
 
 		return database, nil
@@ -50,314 +64,187 @@ func NewDB(cfg *config.Config) (*DB, error) {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	// Build connection string
-	connStr := dbPath
+	// Build connection string; pragmas are applied to every pooled connection.
+	// Incremental auto-vacuum only takes effect when the file is created.
+	connStr := dbPath + "?" + connectionPragmas(cfg)
 	if cfg.Console.Database.WALMode {
-		connStr += "?_journal_mode=WAL&_sync=NORMAL&_cache_size=1000&_foreign_keys=ON"
+		connStr += "&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=cache_size(1000)"
 	}
 
-	// Open database
-	db, err := sqlx.Open("sqlite", connStr)
+	// Writes go through a single connection, so within the process they wait
+	// their turn rather than failing on each other's locks. Transactions take
+	// the write lock when they begin, where the busy timeout applies, instead
+	// of failing at their first write.
+	db, err := sqlx.Open("sqlite", connStr+"&_pragma=auto_vacuum(INCREMENTAL)&_txlock=immediate")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
-
-	// Configure connection pool with reasonable defaults
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(5)
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
 	db.SetConnMaxLifetime(time.Hour)
 
 	// Test connection
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	dbWrapper := &DB{
-		DB:     db,
-		config: cfg,
+		DB:           db,
+		config:       cfg,
+		retryTimeout: retryTimeout(cfg),
+		stats:        &retryStats{},
+		queries:      newQueryStats(cfg.Console.Database.QueryStats),
 	}
 
-	// Initialize schema
-	if err := dbWrapper.InitSchema(); err != nil {
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	if err := dbWrapper.prepareSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Queries run on their own pool so long reads do not hold up writes
+	reader, err := sqlx.Open("sqlite", connStr+"&_pragma=query_only(1)")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	reader.SetMaxOpenConns(10)
+	reader.SetMaxIdleConns(5)
+	reader.SetConnMaxLifetime(time.Hour)
+	dbWrapper.reader = reader
+
+	if err := dbWrapper.checkIntegrity(); err != nil {
+		dbWrapper.Close()
+		return nil, err
 	}
 
 	return dbWrapper, nil
 }
 
-// InitSchema initializes the database schema
-func (db *DB) InitSchema() error {
-	schema := `
-	-- Users table
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT UNIQUE NOT NULL,
-		email TEXT UNIQUE NOT NULL,
-		password_hash TEXT NOT NULL,
-		role TEXT NOT NULL DEFAULT 'user', -- admin, user
-		totp_secret TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		last_login DATETIME
-	);
-
-	-- Services table
-	CREATE TABLE IF NOT EXISTS services (
-		id TEXT PRIMARY KEY, -- UUID
-		name TEXT UNIQUE NOT NULL,
-		image TEXT NOT NULL,
-		port INTEGER NOT NULL DEFAULT 8080,
-		replicas INTEGER NOT NULL DEFAULT 1,
-		status TEXT NOT NULL DEFAULT 'stopped', -- running, stopped, error
-		environment TEXT, -- JSON string for environment variables
-		command TEXT, -- JSON string for command array
-		args TEXT, -- JSON string for args array
-		yaml_config TEXT,
-		version INTEGER NOT NULL DEFAULT 1,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	-- Deployments table
-	CREATE TABLE IF NOT EXISTS deployments (
-		id TEXT PRIMARY KEY, -- UUID
-		service_id TEXT NOT NULL,
-		version INTEGER NOT NULL,
-		status TEXT NOT NULL DEFAULT 'pending', -- pending, running, success, failed
-		started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		finished_at DATETIME,
-		error_message TEXT,
-		FOREIGN KEY (service_id) REFERENCES services(id) ON DELETE CASCADE
-	);
-
-	-- Routes table
-	CREATE TABLE IF NOT EXISTS routes (
-		id TEXT PRIMARY KEY, -- UUID
-		host TEXT NOT NULL,
-		path_prefix TEXT NOT NULL DEFAULT '/',
-		upstream_service_id TEXT,
-		upstream_url TEXT,
-		tls_cert_id TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (upstream_service_id) REFERENCES services(id) ON DELETE SET NULL,
-		FOREIGN KEY (tls_cert_id) REFERENCES certificates(id) ON DELETE SET NULL
-	);
-
-	-- Certificates table
-	CREATE TABLE IF NOT EXISTS certificates (
-		id TEXT PRIMARY KEY, -- UUID
-		domain TEXT UNIQUE NOT NULL,
-		not_before DATETIME NOT NULL,
-		not_after DATETIME NOT NULL,
-		cert_path TEXT NOT NULL,
-		key_path TEXT NOT NULL,
-		issuer_path TEXT,
-		status TEXT NOT NULL DEFAULT 'valid', -- valid, expired, revoked
-		auto_renew BOOLEAN DEFAULT TRUE,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	-- Metrics table (time series data)
-	CREATE TABLE IF NOT EXISTS metrics (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		timestamp DATETIME NOT NULL,
-		scope_type TEXT NOT NULL, -- host, service, route
-		scope_id TEXT NOT NULL,
-		metric_name TEXT NOT NULL,
-		metric_value REAL NOT NULL,
-		labels TEXT, -- JSON format
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	-- Logs index table
-	CREATE TABLE IF NOT EXISTS logs_index (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		service_id TEXT NOT NULL,
-		log_file TEXT NOT NULL,
-		start_timestamp DATETIME NOT NULL,
-		end_timestamp DATETIME NOT NULL,
-		offset_start INTEGER NOT NULL,
-		offset_end INTEGER NOT NULL,
-		line_count INTEGER NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (service_id) REFERENCES services(id) ON DELETE CASCADE
-	);
-
-	-- Snapshots table
-	CREATE TABLE IF NOT EXISTS snapshots (
-		id TEXT PRIMARY KEY, -- UUID
-		plan_id TEXT NOT NULL,
-		timestamp DATETIME NOT NULL,
-		manifest_path TEXT NOT NULL,
-		size_bytes INTEGER NOT NULL,
-		kind TEXT NOT NULL DEFAULT 'incremental', -- full, incremental
-		status TEXT NOT NULL DEFAULT 'creating', -- creating, completed, failed
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (plan_id) REFERENCES snap_plans(id) ON DELETE CASCADE
-	);
-
-	-- Snapshot plans table
-	CREATE TABLE IF NOT EXISTS snap_plans (
-		id TEXT PRIMARY KEY, -- UUID
-		name TEXT UNIQUE NOT NULL,
-		cron_expression TEXT NOT NULL,
-		paths TEXT NOT NULL, -- JSON array
-		keep_daily INTEGER NOT NULL DEFAULT 7,
-		keep_weekly INTEGER NOT NULL DEFAULT 4,
-		keep_monthly INTEGER NOT NULL DEFAULT 3,
-		enabled BOOLEAN DEFAULT TRUE,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	-- Audit log table
-	CREATE TABLE IF NOT EXISTS audit_logs (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER,
-		action TEXT NOT NULL,
-		resource_type TEXT NOT NULL,
-		resource_id TEXT,
-		details TEXT, -- JSON format
-		ip_address TEXT,
-		user_agent TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
-	);
-
-	-- Registered services table (for SSO gateway)
-	CREATE TABLE IF NOT EXISTS registered_services (
-		id TEXT PRIMARY KEY, -- UUID
-		name TEXT UNIQUE NOT NULL,
-		display_name TEXT NOT NULL,
-		description TEXT,
-		service_url TEXT NOT NULL,
-		callback_url TEXT,
-		icon TEXT,
-		category TEXT NOT NULL DEFAULT 'other', -- web, api, admin, monitoring, other
-		is_public BOOLEAN DEFAULT FALSE,
-		required_role TEXT NOT NULL DEFAULT 'user', -- user, admin
-		status TEXT NOT NULL DEFAULT 'active', -- active, inactive, maintenance
-		health_url TEXT,
-		last_healthy DATETIME,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	-- SSO sessions table
-	CREATE TABLE IF NOT EXISTS sso_sessions (
-		id TEXT PRIMARY KEY, -- UUID
-		user_id INTEGER NOT NULL,
-		token_hash TEXT NOT NULL,
-		expires_at DATETIME NOT NULL,
-		ip_address TEXT NOT NULL,
-		user_agent TEXT NOT NULL,
-		is_active BOOLEAN DEFAULT TRUE,
-		last_used DATETIME DEFAULT CURRENT_TIMESTAMP,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-	);
-
-	-- User service permissions table
-	CREATE TABLE IF NOT EXISTS user_service_permissions (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		service_id TEXT NOT NULL,
-		can_access BOOLEAN DEFAULT TRUE,
-		granted_by INTEGER NOT NULL,
-		granted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		expires_at DATETIME,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-		FOREIGN KEY (service_id) REFERENCES registered_services(id) ON DELETE CASCADE,
-		FOREIGN KEY (granted_by) REFERENCES users(id) ON DELETE CASCADE,
-		UNIQUE(user_id, service_id)
-	);
-
-	-- Service health checks table
-	CREATE TABLE IF NOT EXISTS service_health_checks (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		service_id TEXT NOT NULL,
-		is_healthy BOOLEAN NOT NULL,
-		response_time INTEGER, -- milliseconds
-		error_message TEXT,
-		checked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (service_id) REFERENCES registered_services(id) ON DELETE CASCADE
-	);
-
-	-- Create indexes for better performance
-	CREATE INDEX IF NOT EXISTS idx_services_status ON services(status);
-	CREATE INDEX IF NOT EXISTS idx_deployments_service_id ON deployments(service_id);
-	CREATE INDEX IF NOT EXISTS idx_deployments_status ON deployments(status);
-	CREATE INDEX IF NOT EXISTS idx_routes_host ON routes(host);
-	CREATE INDEX IF NOT EXISTS idx_routes_path_prefix ON routes(path_prefix);
-	CREATE INDEX IF NOT EXISTS idx_certificates_domain ON certificates(domain);
-	CREATE INDEX IF NOT EXISTS idx_certificates_not_after ON certificates(not_after);
-	CREATE INDEX IF NOT EXISTS idx_metrics_timestamp ON metrics(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_metrics_scope ON metrics(scope_type, scope_id);
-	CREATE INDEX IF NOT EXISTS idx_metrics_name ON metrics(metric_name);
-	CREATE INDEX IF NOT EXISTS idx_logs_service_timestamp ON logs_index(service_id, start_timestamp);
-	CREATE INDEX IF NOT EXISTS idx_snapshots_plan_timestamp ON snapshots(plan_id, timestamp);
-	CREATE INDEX IF NOT EXISTS idx_audit_logs_user_timestamp ON audit_logs(user_id, created_at);
-	CREATE INDEX IF NOT EXISTS idx_registered_services_status ON registered_services(status);
-	CREATE INDEX IF NOT EXISTS idx_registered_services_category ON registered_services(category);
-	CREATE INDEX IF NOT EXISTS idx_registered_services_role ON registered_services(required_role);
-	CREATE INDEX IF NOT EXISTS idx_sso_sessions_user_id ON sso_sessions(user_id);
-	CREATE INDEX IF NOT EXISTS idx_sso_sessions_token_hash ON sso_sessions(token_hash);
-	CREATE INDEX IF NOT EXISTS idx_sso_sessions_expires_at ON sso_sessions(expires_at);
-	CREATE INDEX IF NOT EXISTS idx_user_service_permissions_user_id ON user_service_permissions(user_id);
-	CREATE INDEX IF NOT EXISTS idx_user_service_permissions_service_id ON user_service_permissions(service_id);
-	CREATE INDEX IF NOT EXISTS idx_service_health_checks_service_id ON service_health_checks(service_id);
-	CREATE INDEX IF NOT EXISTS idx_service_health_checks_checked_at ON service_health_checks(checked_at);
-
-	-- Create triggers for updated_at timestamps
-	CREATE TRIGGER IF NOT EXISTS update_users_timestamp 
-		AFTER UPDATE ON users
-		BEGIN
-			UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
-		END;
-
-	CREATE TRIGGER IF NOT EXISTS update_services_timestamp 
-		AFTER UPDATE ON services
-		BEGIN
-			UPDATE services SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
-		END;
-
-	CREATE TRIGGER IF NOT EXISTS update_routes_timestamp 
-		AFTER UPDATE ON routes
-		BEGIN
-			UPDATE routes SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
-		END;
-
-	CREATE TRIGGER IF NOT EXISTS update_certificates_timestamp 
-		AFTER UPDATE ON certificates
-		BEGIN
-			UPDATE certificates SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
-		END;
-
-	CREATE TRIGGER IF NOT EXISTS update_snap_plans_timestamp 
-		AFTER UPDATE ON snap_plans
-		BEGIN
-			UPDATE snap_plans SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
-		END;
-
-	CREATE TRIGGER IF NOT EXISTS update_registered_services_timestamp 
-		AFTER UPDATE ON registered_services
-		BEGIN
-			UPDATE registered_services SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
-		END;
-	`
-
-	_, err := db.Exec(schema)
+// prepareSchema migrates the schema to the latest version, or with auto-migrate
+// disabled, refuses to use a database whose schema is out of date
+func (db *DB) prepareSchema() error {
+	if !db.config.Console.Database.DisableAutoMigrate {
+		if err := db.Migrate(context.Background()); err != nil {
+			return fmt.Errorf("failed to migrate schema: %w", err)
+		}
+		return nil
+	}
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	if !status.UpToDate() {
+		return fmt.Errorf("database schema is at version %d but %d is required and auto-migrate is disabled; "+
+			"set console.database.disable_auto_migrate to false to migrate", status.CurrentVersion, status.LatestVersion)
+	}
+	return nil
+}
+
+// connectionPragmas returns the pragmas every connection must be opened with
+func connectionPragmas(cfg *config.Config) string {
+	busyTimeout := 5 * time.Second
+	if cfg.Console.Database.Timeout != "" {
+		if timeout, err := time.ParseDuration(cfg.Console.Database.Timeout); err == nil {
+			busyTimeout = timeout
+		}
+	}
+
+	return fmt.Sprintf("_pragma=foreign_keys(1)&_pragma=busy_timeout(%d)", busyTimeout.Milliseconds())
+}
+
+// retryTimeout returns how long statements that find the database busy are retried
+func retryTimeout(cfg *config.Config) time.Duration {
+	if timeout, err := time.ParseDuration(cfg.Console.Database.RetryTimeout); err == nil && timeout > 0 {
+		return timeout
+	}
+	return defaultRetryTimeout
+}
+
+// ForeignKeyViolation describes a row whose foreign key references a missing parent
+type ForeignKeyViolation struct {
+	Table  string `db:"table" json:"table"`
+	RowID  int64  `db:"rowid" json:"rowid"`
+	Parent string `db:"parent" json:"parent"`
+	FKID   int    `db:"fkid" json:"fkid"`
+}
+
+// CheckForeignKeys returns all rows that violate a foreign key constraint
+func (db *DB) CheckForeignKeys() ([]ForeignKeyViolation, error) {
+	var violations []ForeignKeyViolation
+	if err := db.Select(&violations, "PRAGMA foreign_key_check"); err != nil {
+		return nil, fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	return violations, nil
+}
+
+// RepairForeignKeys deletes orphaned rows found by CheckForeignKeys and returns how many were removed
+func (db *DB) RepairForeignKeys(violations []ForeignKeyViolation) (int, error) {
+	removed := 0
+	err := db.WithTx(db.context(), func(tx *DB) error {
+		removed = 0
+		seen := make(map[string]bool)
+		for _, v := range violations {
+			key := fmt.Sprintf("%s:%d", v.Table, v.RowID)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			query := fmt.Sprintf("DELETE FROM %q WHERE rowid = ?", v.Table)
+			if _, err := tx.Exec(query, v.RowID); err != nil {
+				return fmt.Errorf("failed to delete orphan from %s: %w", v.Table, err)
+			}
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// checkIntegrity logs foreign key violations on startup and removes them if repair is enabled
+func (db *DB) checkIntegrity() error {
+	violations, err := db.CheckForeignKeys()
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	for _, v := range violations {
+		log.Printf("⚠️ Foreign key violation: %s row %d references missing %s", v.Table, v.RowID, v.Parent)
+	}
+
+	if !db.config.Console.Database.RepairOrphans {
+		log.Printf("⚠️ Found %d foreign key violations; set console.database.repair_orphans to remove them", len(violations))
+		return nil
+	}
+
+	removed, err := db.RepairForeignKeys(violations)
 	if err != nil {
-		return fmt.Errorf("failed to execute schema: %w", err)
+		return err
 	}
+	log.Printf("🧹 Removed %d orphaned rows", removed)
 
 	return nil
 }
 
-// Close closes the database connection
+// InitSchema brings the schema up to date by applying any pending migrations
+func (db *DB) InitSchema() error {
+	return db.Migrate(context.Background())
+}
+
+// Close closes the database connections
 func (db *DB) Close() error {
+	if db.reader != nil {
+		db.reader.Close()
+	}
 	return db.DB.Close()
 }
 
@@ -371,12 +258,37 @@ func (db *DB) HealthCheck() error {
 	return nil
 }
 
+// Reclaim returns the pages freed by deleted rows to the filesystem and
+// folds the write-ahead log back into the database file. Pages are only
+// freed in databases created with incremental auto-vacuum, which new
+// databases are; older ones need a one-off VACUUM first.
+func (db *DB) Reclaim() error {
+	// incremental_vacuum frees a page for every row it steps through, so
+	// the rows are read to the end rather than executed once
+	rows, err := db.Query("PRAGMA incremental_vacuum")
+	if err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	for rows.Next() {
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("failed to checkpoint write-ahead log: %w", err)
+	}
+	return nil
+}
+
 // GetStats returns database statistics
 func (db *DB) GetStats() (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
 
 	// Get table counts
-	tables := []string{"users", "services", "deployments", "routes", "certificates", "metrics", "logs_index", "snapshots", "snap_plans", "audit_logs", "registered_services", "sso_sessions", "user_service_permissions", "service_health_checks"}
+	tables := []string{"users", "services", "deployments", "routes", "certificates", "metrics", "metrics_rollup", "logs_index", "snapshots", "snap_plans", "snap_plan_runs", "restore_jobs", "snap_blocks", "audit_logs", "registered_services", "sso_sessions", "sso_launch_tokens", "user_service_permissions", "user_service_preferences", "service_health_checks", "maintenance_windows", "oauth_clients", "service_templates"}
 
 	for _, table := range tables {
 		var count int
@@ -386,6 +298,11 @@ func (db *DB) GetStats() (map[string]interface{}, error) {
 		}
 		stats[table+"_count"] = count
 	}
+	var unhealthy int
+	if err := db.Get(&unhealthy, "SELECT COUNT(*) FROM registered_services WHERE NOT is_healthy"); err != nil {
+		return nil, fmt.Errorf("failed to count unhealthy registered services: %w", err)
+	}
+	stats["unhealthy_registered_services_count"] = unhealthy
 
 	// Get database size
 	var pages, pageSize int
@@ -401,6 +318,31 @@ func (db *DB) GetStats() (map[string]interface{}, error) {
 		stats["journal_mode"] = walMode
 	}
 
+	// Get connection pragmas so misconfiguration is visible
+	var foreignKeys bool
+	if err := db.Get(&foreignKeys, "PRAGMA foreign_keys"); err == nil {
+		stats["foreign_keys"] = foreignKeys
+	}
+	var busyTimeout int
+	if err := db.Get(&busyTimeout, "PRAGMA busy_timeout"); err == nil {
+		stats["busy_timeout_ms"] = busyTimeout
+	}
+
+	// Get busy retries so write contention is visible
+	if db.stats != nil {
+		stats["busy_retries"] = db.stats.retries.Load()
+		stats["busy_retry_failures"] = db.stats.failures.Load()
+	}
+
+	// Get statement timings when they are kept
+	if db.queries != nil {
+		totals := db.queries.Totals()
+		stats["queries"] = totals.Queries
+		stats["query_errors"] = totals.Errors
+		stats["slow_queries"] = totals.Slow
+		stats["query_time_ms"] = totals.TotalMS
+	}
+
 	return stats, nil
 }
 
@@ -409,6 +351,16 @@ func (db *DB) UserRepository() *UserRepository {
 	return NewUserRepository(db)
 }
 
+// DeploymentRepository returns a new deployment repository
+func (db *DB) DeploymentRepository() *DeploymentRepository {
+	return NewDeploymentRepository(db)
+}
+
+// IncidentRepository returns a new incident repository
+func (db *DB) IncidentRepository() *IncidentRepository {
+	return NewIncidentRepository(db)
+}
+
 // ServiceRepository returns a new service repository
 func (db *DB) ServiceRepository() *ServiceRepository {
 	return NewServiceRepository(db)
@@ -424,6 +376,11 @@ func (db *DB) MetricRepository() *MetricRepository {
 	return NewMetricRepository(db)
 }
 
+// LogIndexRepository returns a new log index repository
+func (db *DB) LogIndexRepository() *LogIndexRepository {
+	return NewLogIndexRepository(db)
+}
+
 // RegisteredServiceRepository returns a new registered service repository
 func (db *DB) RegisteredServiceRepository() *RegisteredServiceRepository {
 	return NewRegisteredServiceRepository(db)
@@ -434,12 +391,113 @@ func (db *DB) SSOSessionRepository() *SSOSessionRepository {
 	return NewSSOSessionRepository(db)
 }
 
+// SSOLaunchTokenRepository returns a new SSO launch token repository
+func (db *DB) SSOLaunchTokenRepository() *SSOLaunchTokenRepository {
+	return NewSSOLaunchTokenRepository(db)
+}
+
 // UserServicePermissionRepository returns a new user service permission repository
 func (db *DB) UserServicePermissionRepository() *UserServicePermissionRepository {
 	return NewUserServicePermissionRepository(db)
 }
 
+// LoginAttemptRepository returns a new login attempt repository
+func (db *DB) LoginAttemptRepository() *LoginAttemptRepository {
+	return NewLoginAttemptRepository(db)
+}
+
+// AuditLogRepository returns a new audit log repository
+func (db *DB) AuditLogRepository() *AuditLogRepository {
+	return NewAuditLogRepository(db)
+}
+
+// MaintenanceWindowRepository returns a new maintenance window repository
+func (db *DB) MaintenanceWindowRepository() *MaintenanceWindowRepository {
+	return NewMaintenanceWindowRepository(db)
+}
+
+// APIKeyRepository returns a new API key repository
+func (db *DB) APIKeyRepository() *APIKeyRepository {
+	return NewAPIKeyRepository(db)
+}
+
+// PasswordResetTokenRepository returns a new password reset token repository
+func (db *DB) PasswordResetTokenRepository() *PasswordResetTokenRepository {
+	return NewPasswordResetTokenRepository(db)
+}
+
 // ServiceHealthCheckRepository returns a new service health check repository
 func (db *DB) ServiceHealthCheckRepository() *ServiceHealthCheckRepository {
 	return NewServiceHealthCheckRepository(db)
 }
+
+// ProbeResultRepository returns a new probe result repository
+func (db *DB) ProbeResultRepository() *ProbeResultRepository {
+	return NewProbeResultRepository(db)
+}
+
+// ProbeAlertRepository returns a new probe alert repository
+func (db *DB) ProbeAlertRepository() *ProbeAlertRepository {
+	return NewProbeAlertRepository(db)
+}
+
+// SnapshotRepository returns a new snapshot repository
+func (db *DB) SnapshotRepository() *SnapshotRepository {
+	return NewSnapshotRepository(db)
+}
+
+// CertificateRepository returns a new certificate repository
+func (db *DB) CertificateRepository() *CertificateRepository {
+	return NewCertificateRepository(db)
+}
+
+// OAuthClientRepository returns a new OAuth client repository
+func (db *DB) OAuthClientRepository() *OAuthClientRepository {
+	return NewOAuthClientRepository(db)
+}
+
+// UserServicePreferenceRepository returns a new user service preference repository
+func (db *DB) UserServicePreferenceRepository() *UserServicePreferenceRepository {
+	return NewUserServicePreferenceRepository(db)
+}
+
+// MaintenanceRunRepository returns a new maintenance run repository
+func (db *DB) MaintenanceRunRepository() *MaintenanceRunRepository {
+	return NewMaintenanceRunRepository(db)
+}
+
+// ServiceTemplateRepository returns a new service template repository
+func (db *DB) ServiceTemplateRepository() *ServiceTemplateRepository {
+	return NewServiceTemplateRepository(db)
+}
+
+// NodeRepository returns a new node repository
+func (db *DB) NodeRepository() *NodeRepository {
+	return NewNodeRepository(db)
+}
+
+// ServiceRevisionRepository returns a new service revision repository
+func (db *DB) ServiceRevisionRepository() *ServiceRevisionRepository {
+	return NewServiceRevisionRepository(db)
+}
+
+// OrganizationRepository returns organization repository
+func (db *DB) OrganizationRepository() *OrganizationRepository {
+	return NewOrganizationRepository(db)
+}
+
+// SecretRepository returns a new secret repository sealing values with key
+func (db *DB) SecretRepository(key []byte) *SecretRepository {
+	return NewSecretRepository(db, key)
+}
+
+// DeployHookRepository returns a new deploy hook repository sealing secrets
+// with key
+func (db *DB) DeployHookRepository(key []byte) *DeployHookRepository {
+	return NewDeployHookRepository(db, key)
+}
+
+// SearchRepository returns search repository
+func (db *DB) SearchRepository() *SearchRepository {
+	return NewSearchRepository(db)
+}
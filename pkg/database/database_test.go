@@ -1,11 +1,19 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/jmoiron/sqlx"
+
 	"github.com/last-emo-boy/infra-core/pkg/config"
 )
 
@@ -28,6 +36,28 @@ func createTestDB(t *testing.T) *DB {
 	return db
 }
 
+// seedTestUsers inserts users with fixed IDs so that rows referencing them satisfy foreign keys
+func seedTestUsers(t *testing.T, db *DB, ids ...int) {
+	for _, id := range ids {
+		_, err := db.Exec(`INSERT INTO users (id, username, email, password_hash, role) VALUES (?, ?, ?, ?, 'user')`,
+			id, fmt.Sprintf("seed-user-%d", id), fmt.Sprintf("seed-user-%d@example.com", id), "hash")
+		if err != nil {
+			t.Fatalf("Failed to seed user %d: %v", id, err)
+		}
+	}
+}
+
+// seedTestRegisteredServices inserts registered services with fixed IDs so that rows referencing them satisfy foreign keys
+func seedTestRegisteredServices(t *testing.T, db *DB, ids ...string) {
+	for _, id := range ids {
+		_, err := db.Exec(`INSERT INTO registered_services (id, name, display_name, service_url) VALUES (?, ?, ?, ?)`,
+			id, id, id, "http://localhost:8080")
+		if err != nil {
+			t.Fatalf("Failed to seed registered service %s: %v", id, err)
+		}
+	}
+}
+
 func TestNewDB(t *testing.T) {
 	cfg := &config.Config{
 		Console: config.ConsoleConfig{
@@ -137,6 +167,218 @@ func TestUserOperations(t *testing.T) {
 	}
 }
 
+func TestUserTOTP(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	userRepo := db.UserRepository()
+	user := &User{Username: "totpuser", Email: "totp@example.com", PasswordHash: "hash", Role: "user"}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// A pending secret is stored without enabling two-factor login
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	if err := userRepo.UpdateTOTP(user.ID, &secret, false); err != nil {
+		t.Fatalf("Failed to store TOTP secret: %v", err)
+	}
+	stored, err := userRepo.GetByID(user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if stored.TOTPSecret == nil || *stored.TOTPSecret != secret {
+		t.Fatalf("Expected TOTP secret to be stored, got %v", stored.TOTPSecret)
+	}
+	if stored.TOTPEnabled {
+		t.Fatal("TOTP should not be enabled before confirmation")
+	}
+
+	if err := userRepo.UpdateTOTP(user.ID, &secret, true); err != nil {
+		t.Fatalf("Failed to enable TOTP: %v", err)
+	}
+	stored, _ = userRepo.GetByID(user.ID)
+	if !stored.TOTPEnabled {
+		t.Fatal("TOTP should be enabled after confirmation")
+	}
+
+	if err := userRepo.UpdateTOTP(user.ID, nil, false); err != nil {
+		t.Fatalf("Failed to disable TOTP: %v", err)
+	}
+	stored, _ = userRepo.GetByID(user.ID)
+	if stored.TOTPSecret != nil || stored.TOTPEnabled {
+		t.Fatalf("Expected TOTP to be cleared, got secret=%v enabled=%v", stored.TOTPSecret, stored.TOTPEnabled)
+	}
+}
+
+func TestCreateFirstAdmin(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	seedTestUsers(t, db, 1)
+
+	userRepo := db.UserRepository()
+	admin := &User{Username: "root", Email: "root@example.com", PasswordHash: "hash", Role: "user"}
+	if err := userRepo.CreateFirstAdmin(admin); err != nil {
+		t.Fatalf("Failed to create first admin: %v", err)
+	}
+	stored, err := userRepo.GetByID(admin.ID)
+	if err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+	if stored.Username != "root" || stored.Role != "admin" {
+		t.Errorf("Expected root to be created as an admin, got %s with role %s", stored.Username, stored.Role)
+	}
+
+	second := &User{Username: "root2", Email: "root2@example.com", PasswordHash: "hash"}
+	if err := userRepo.CreateFirstAdmin(second); !errors.Is(err, ErrAdminExists) {
+		t.Errorf("Expected ErrAdminExists creating a second admin, got %v", err)
+	}
+	if count, err := userRepo.CountByRole("admin"); err != nil || count != 1 {
+		t.Errorf("Expected 1 admin, got %d (%v)", count, err)
+	}
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	if !status.UpToDate() || status.CurrentVersion != status.LatestVersion {
+		t.Errorf("Expected a fresh database to be fully migrated, got %+v", status)
+	}
+	if status.LatestVersion < 2 || len(status.Applied) != status.LatestVersion {
+		t.Errorf("Expected every migration to be recorded, got %+v", status.Applied)
+	}
+
+	// Migrating again, directly or through InitSchema, changes nothing
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatalf("Failed to re-run migrations: %v", err)
+	}
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("Failed to re-run InitSchema: %v", err)
+	}
+	var applied int
+	if err := db.Get(&applied, "SELECT COUNT(*) FROM schema_migrations"); err != nil {
+		t.Fatalf("Failed to count migrations: %v", err)
+	}
+	if applied != len(status.Applied) {
+		t.Errorf("Expected %d applied migrations after re-running, got %d", len(status.Applied), applied)
+	}
+}
+
+func TestMigrateAdoptsLegacyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: path},
+		},
+	}
+
+	db, err := NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	// Turn it into a database created by the old InitSchema: no migration
+	// history and a users table from before totp_enabled existed
+	if _, err := db.Exec("DROP TABLE schema_migrations"); err != nil {
+		t.Fatalf("Failed to drop schema_migrations: %v", err)
+	}
+	if _, err := db.Exec("ALTER TABLE users DROP COLUMN totp_enabled"); err != nil {
+		t.Fatalf("Failed to drop column: %v", err)
+	}
+	seedTestUsers(t, db, 1)
+	db.Close()
+
+	db, err = NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	if !status.UpToDate() {
+		t.Errorf("Expected legacy database to be migrated, pending %v", status.Pending)
+	}
+
+	user, err := db.UserRepository().GetByID(1)
+	if err != nil {
+		t.Fatalf("Failed to read migrated user: %v", err)
+	}
+	if user.TOTPEnabled {
+		t.Error("Migrated users should not have TOTP enabled")
+	}
+}
+
+func TestMigrateRollsBackFailedMigration(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	original := goMigrations
+	defer func() { goMigrations = original }()
+	goMigrations = append(append([]Migration(nil), original...), Migration{
+		Version: 999,
+		Name:    "broken",
+		Up: func(tx *sqlx.Tx) error {
+			if _, err := tx.Exec("CREATE TABLE half_done (id INTEGER)"); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		},
+	})
+
+	if err := db.Migrate(context.Background()); err == nil {
+		t.Fatal("Expected the broken migration to fail")
+	}
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	if len(status.Pending) != 1 || status.Pending[0] != 999 || status.LatestVersion != 999 {
+		t.Errorf("Expected migration 999 to remain pending, got %+v", status)
+	}
+
+	var tables int
+	if err := db.Get(&tables, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'"); err != nil {
+		t.Fatalf("Failed to inspect schema: %v", err)
+	}
+	if tables != 0 {
+		t.Error("Expected the failed migration's changes to be rolled back")
+	}
+}
+
+func TestNewDBWithAutoMigrateDisabled(t *testing.T) {
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db"), DisableAutoMigrate: true},
+		},
+	}
+
+	if _, err := NewDB(cfg); err == nil {
+		t.Fatal("Expected an unmigrated database to be refused")
+	}
+
+	cfg.Console.Database.DisableAutoMigrate = false
+	db, err := NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	db.Close()
+
+	cfg.Console.Database.DisableAutoMigrate = true
+	db, err = NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Expected a migrated database to open, got %v", err)
+	}
+	db.Close()
+}
+
 func TestServiceOperations(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
@@ -254,6 +496,108 @@ func TestGetStats(t *testing.T) {
 			}
 		}
 	}
+
+	if stats["foreign_keys"] != true {
+		t.Errorf("Expected foreign_keys to be enabled, got %v", stats["foreign_keys"])
+	}
+	if stats["busy_timeout_ms"] != 30000 {
+		t.Errorf("Expected busy_timeout_ms to be 30000, got %v", stats["busy_timeout_ms"])
+	}
+}
+
+func TestForeignKeysEnforced(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		walMode bool
+	}{
+		{name: "in-memory", path: ":memory:"},
+		{name: "file", path: filepath.Join(t.TempDir(), "fk.db")},
+		{name: "file with WAL", path: filepath.Join(t.TempDir(), "fk-wal.db"), walMode: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := NewDB(&config.Config{
+				Console: config.ConsoleConfig{
+					Database: config.DatabaseConfig{Path: tt.path, WALMode: tt.walMode},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create database: %v", err)
+			}
+			defer db.Close()
+
+			seedTestUsers(t, db, 1)
+
+			// Exercise several pooled connections, each must enforce foreign keys
+			for i := 0; i < 3; i++ {
+				err := db.UserServicePermissionRepository().Grant(1, "missing-service", 1, nil)
+				if err == nil {
+					t.Fatal("Expected permission referencing a missing service to fail")
+				}
+			}
+		})
+	}
+}
+
+func TestRepairForeignKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orphans.db")
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: path},
+		},
+	}
+
+	db, err := NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	// Insert an orphan the way an unenforced connection would have
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "PRAGMA foreign_keys = OFF"); err != nil {
+		t.Fatalf("Failed to disable foreign keys: %v", err)
+	}
+	if _, err := conn.ExecContext(context.Background(),
+		"INSERT INTO service_health_checks (service_id, is_healthy) VALUES ('missing-service', 1)"); err != nil {
+		t.Fatalf("Failed to insert orphan: %v", err)
+	}
+	conn.Close()
+	db.Close()
+
+	// Reopening without repair keeps the orphan
+	db, err = NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	violations, err := db.CheckForeignKeys()
+	if err != nil {
+		t.Fatalf("Failed to check foreign keys: %v", err)
+	}
+	if len(violations) != 1 || violations[0].Table != "service_health_checks" || violations[0].Parent != "registered_services" {
+		t.Fatalf("Expected one service_health_checks violation, got %+v", violations)
+	}
+	db.Close()
+
+	// Reopening with repair removes it
+	cfg.Console.Database.RepairOrphans = true
+	db, err = NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen database with repair: %v", err)
+	}
+	defer db.Close()
+
+	violations, err = db.CheckForeignKeys()
+	if err != nil {
+		t.Fatalf("Failed to check foreign keys: %v", err)
+	}
+	if len(violations) != 0 {
+		t.Errorf("Expected no violations after repair, got %+v", violations)
+	}
 }
 
 // RegisteredServiceRepository Tests
@@ -576,10 +920,13 @@ func TestRegisteredServiceRepository_UpdateHealthStatus(t *testing.T) {
 	}
 
 	// Update health status to healthy
-	err = repo.UpdateHealthStatus(service.ID, true)
+	streak, err := repo.UpdateHealthStatus(service.ID, true)
 	if err != nil {
 		t.Fatalf("Failed to update health status: %v", err)
 	}
+	if streak != 0 {
+		t.Errorf("Expected no failure streak, got %d", streak)
+	}
 
 	// Verify the health status update
 	retrieved, err := repo.GetByID(service.ID)
@@ -592,9 +939,77 @@ func TestRegisteredServiceRepository_UpdateHealthStatus(t *testing.T) {
 	}
 
 	// Update health status to unhealthy
-	err = repo.UpdateHealthStatus(service.ID, false)
+	for want := 1; want <= 2; want++ {
+		streak, err = repo.UpdateHealthStatus(service.ID, false)
+		if err != nil {
+			t.Fatalf("Failed to update health status to unhealthy: %v", err)
+		}
+		if streak != want {
+			t.Errorf("Expected failure streak %d, got %d", want, streak)
+		}
+	}
+	retrieved, err = repo.GetByID(service.ID)
+	if err != nil {
+		t.Fatalf("Failed to get updated service: %v", err)
+	}
+	if retrieved.FailureStreak != 2 {
+		t.Errorf("Expected failure streak 2, got %d", retrieved.FailureStreak)
+	}
+
+	// A healthy check ends the streak
+	streak, err = repo.UpdateHealthStatus(service.ID, true)
+	if err != nil {
+		t.Fatalf("Failed to update health status: %v", err)
+	}
+	if streak != 0 {
+		t.Errorf("Expected failure streak to reset, got %d", streak)
+	}
+
+	if _, err := repo.UpdateHealthStatus("missing", true); err == nil {
+		t.Error("Expected an error updating a missing service")
+	}
+}
+
+func TestRegisteredServiceRepository_TransitionStatus(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	repo := db.RegisteredServiceRepository()
+	service := &RegisteredService{
+		Name:         "transition-service",
+		DisplayName:  "Transition Service",
+		ServiceURL:   "http://localhost:8080",
+		Category:     "api",
+		RequiredRole: "user",
+		Status:       RegisteredServiceActive,
+	}
+	if err := repo.Create(service); err != nil {
+		t.Fatalf("Failed to create test service: %v", err)
+	}
+
+	changed, err := repo.TransitionStatus(service.ID, RegisteredServiceActive, RegisteredServiceUnreachable)
+	if err != nil {
+		t.Fatalf("Failed to transition status: %v", err)
+	}
+	if !changed {
+		t.Error("Expected the status to change")
+	}
+
+	// The status only changes from the expected one
+	changed, err = repo.TransitionStatus(service.ID, RegisteredServiceActive, RegisteredServiceMaintenance)
+	if err != nil {
+		t.Fatalf("Failed to transition status: %v", err)
+	}
+	if changed {
+		t.Error("Expected the status not to change")
+	}
+
+	retrieved, err := repo.GetByID(service.ID)
 	if err != nil {
-		t.Fatalf("Failed to update health status to unhealthy: %v", err)
+		t.Fatalf("Failed to get updated service: %v", err)
+	}
+	if retrieved.Status != RegisteredServiceUnreachable {
+		t.Errorf("Expected status %s, got %s", RegisteredServiceUnreachable, retrieved.Status)
 	}
 }
 
@@ -642,6 +1057,7 @@ func TestRegisteredServiceRepository_Delete(t *testing.T) {
 func TestSSOSessionRepository_Create(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestUsers(t, db, 1)
 
 	repo := db.SSOSessionRepository()
 	
@@ -670,6 +1086,7 @@ func TestSSOSessionRepository_Create(t *testing.T) {
 func TestSSOSessionRepository_GetByTokenHash(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestUsers(t, db, 2)
 
 	repo := db.SSOSessionRepository()
 	
@@ -707,6 +1124,7 @@ func TestSSOSessionRepository_GetByTokenHash(t *testing.T) {
 func TestSSOSessionRepository_UpdateLastUsed(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestUsers(t, db, 3)
 
 	repo := db.SSOSessionRepository()
 	
@@ -748,6 +1166,7 @@ func TestSSOSessionRepository_UpdateLastUsed(t *testing.T) {
 func TestSSOSessionRepository_Invalidate(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestUsers(t, db, 4)
 
 	repo := db.SSOSessionRepository()
 	
@@ -792,6 +1211,7 @@ func TestSSOSessionRepository_Invalidate(t *testing.T) {
 func TestSSOSessionRepository_InvalidateUserSessions(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestUsers(t, db, 5)
 
 	repo := db.SSOSessionRepository()
 	
@@ -849,9 +1269,75 @@ func TestSSOSessionRepository_InvalidateUserSessions(t *testing.T) {
 	}
 }
 
+func TestSSOSessionRepository_InvalidateOtherUserSessions(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	seedTestUsers(t, db, 1, 2)
+
+	repo := db.SSOSessionRepository()
+
+	newSession := func(userID int, expiresAt time.Time) *SSOSession {
+		session := &SSOSession{
+			UserID:    userID,
+			TokenHash: fmt.Sprintf("session_%d_%d", userID, expiresAt.UnixNano()),
+			ExpiresAt: expiresAt,
+			IPAddress: "192.168.1.1",
+			UserAgent: "Firefox/89.0",
+			IsActive:  true,
+		}
+		if err := repo.Create(session); err != nil {
+			t.Fatalf("Failed to create test session: %v", err)
+		}
+		return session
+	}
+	current := newSession(1, time.Now().Add(24*time.Hour))
+	other := newSession(1, time.Now().Add(12*time.Hour))
+	newSession(1, time.Now().Add(-time.Hour))
+	foreign := newSession(2, time.Now().Add(24*time.Hour))
+
+	sessions, err := repo.ListActiveByUser(1)
+	if err != nil {
+		t.Fatalf("Failed to list sessions: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 unexpired sessions, got %d", len(sessions))
+	}
+
+	invalidated, err := repo.InvalidateOtherUserSessions(1, current.ID)
+	if err != nil {
+		t.Fatalf("Failed to invalidate other sessions: %v", err)
+	}
+	if invalidated != 2 {
+		t.Errorf("Expected 2 sessions invalidated, got %d", invalidated)
+	}
+
+	sessions, err = repo.ListActiveByUser(1)
+	if err != nil {
+		t.Fatalf("Failed to list sessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != current.ID {
+		t.Errorf("Expected only the kept session to be active, got %+v", sessions)
+	}
+
+	retrieved, err := repo.GetByID(other.ID)
+	if err != nil {
+		t.Fatalf("Failed to get invalidated session: %v", err)
+	}
+	if retrieved.IsActive {
+		t.Error("Expected the other session to be inactive")
+	}
+	if retrieved, err = repo.GetByID(foreign.ID); err != nil || !retrieved.IsActive {
+		t.Errorf("Expected sessions of other users to stay active, got %+v, %v", retrieved, err)
+	}
+	if _, err := repo.GetByID("missing"); !errors.Is(err, ErrSSOSessionNotFound) {
+		t.Errorf("Expected ErrSSOSessionNotFound, got %v", err)
+	}
+}
+
 func TestSSOSessionRepository_CleanupExpiredSessions(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestUsers(t, db, 6, 7)
 
 	repo := db.SSOSessionRepository()
 	
@@ -887,10 +1373,13 @@ func TestSSOSessionRepository_CleanupExpiredSessions(t *testing.T) {
 	}
 
 	// Cleanup expired sessions
-	err := repo.CleanupExpiredSessions()
+	removed, err := repo.CleanupExpiredSessions(time.Now())
 	if err != nil {
 		t.Fatalf("Failed to cleanup expired sessions: %v", err)
 	}
+	if removed != 1 {
+		t.Errorf("Expected 1 expired session removed, got %d", removed)
+	}
 
 	// Verify expired session is cleaned up (should error when trying to get it)
 	_, err = repo.GetByTokenHash("expired_session_1")
@@ -910,6 +1399,8 @@ func TestSSOSessionRepository_CleanupExpiredSessions(t *testing.T) {
 func TestUserServicePermissionRepository_Grant(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestUsers(t, db, 1, 2)
+	seedTestRegisteredServices(t, db, "test-service-123", "service-with-expiry")
 
 	repo := db.UserServicePermissionRepository()
 	
@@ -944,6 +1435,8 @@ func TestUserServicePermissionRepository_Grant(t *testing.T) {
 func TestUserServicePermissionRepository_Revoke(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestUsers(t, db, 2, 3)
+	seedTestRegisteredServices(t, db, "test-service-456")
 
 	repo := db.UserServicePermissionRepository()
 	
@@ -986,6 +1479,8 @@ func TestUserServicePermissionRepository_Revoke(t *testing.T) {
 func TestUserServicePermissionRepository_CheckPermission(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestUsers(t, db, 3, 4)
+	seedTestRegisteredServices(t, db, "check-permission-service")
 
 	repo := db.UserServicePermissionRepository()
 	
@@ -1021,6 +1516,7 @@ func TestUserServicePermissionRepository_CheckPermission(t *testing.T) {
 func TestUserServicePermissionRepository_ListUserServices(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestUsers(t, db, 4, 5)
 
 	// First create some registered services
 	regRepo := db.RegisteredServiceRepository()
@@ -1095,35 +1591,144 @@ func TestUserServicePermissionRepository_ListUserServices(t *testing.T) {
 	}
 }
 
-// ServiceHealthCheckRepository tests
-func TestServiceHealthCheckRepository_Record(t *testing.T) {
+func TestUserServicePreferenceRepository(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestUsers(t, db, 4, 5)
 
-	repo := db.ServiceHealthCheckRepository()
-	
-	check := &ServiceHealthCheck{
-		ServiceID:     "health-service-1",
-		IsHealthy:     true,
-		ResponseTime:  150,
-		ErrorMessage:  nil,
-		CheckedAt:     time.Now(),
+	services := db.RegisteredServiceRepository()
+	for _, name := range []string{"alerts", "builds", "chat", "docs", "wiki"} {
+		service := &RegisteredService{ID: name, Name: name, DisplayName: name, ServiceURL: "http://localhost", Category: "web", IsPublic: true, RequiredRole: "user", Status: RegisteredServiceActive}
+		if err := services.Create(service); err != nil {
+			t.Fatalf("Failed to create service: %v", err)
+		}
 	}
 
-	err := repo.Record(check)
-	if err != nil {
-		t.Fatalf("Failed to record health check: %v", err)
+	repo := db.UserServicePreferenceRepository()
+	order := func(userID int) string {
+		listed, err := db.UserServicePermissionRepository().ListUserServices(userID)
+		if err != nil {
+			t.Fatalf("Failed to list user services: %v", err)
+		}
+		names := make([]string, len(listed))
+		for i, service := range listed {
+			names[i] = service.Name
+		}
+		return strings.Join(names, ",")
 	}
 
-	// Verify the check has an ID after recording
-	if check.ID == 0 {
-		t.Error("Expected health check to have an ID after recording")
+	// Without preferences services are listed by category and name
+	if got := order(4); got != "alerts,builds,chat,docs,wiki" {
+		t.Errorf("Expected services by name, got %s", got)
 	}
-}
 
-func TestServiceHealthCheckRepository_GetLatest(t *testing.T) {
-	db := createTestDB(t)
-	defer db.Close()
+	// Pinned services come first in their sort order, then the most recently
+	// used, then the rest
+	now := time.Now()
+	for _, visit := range []struct {
+		service string
+		at      time.Time
+	}{{"alerts", now.Add(-time.Hour)}, {"chat", now.Add(-time.Minute)}, {"wiki", now.Add(-2 * time.Hour)}} {
+		if err := repo.RecordVisit(4, visit.service, visit.at); err != nil {
+			t.Fatalf("Failed to record visit: %v", err)
+		}
+	}
+	if err := repo.Pin(4, "wiki", nil); err != nil {
+		t.Fatalf("Failed to pin service: %v", err)
+	}
+	if err := repo.Pin(4, "docs", nil); err != nil {
+		t.Fatalf("Failed to pin service: %v", err)
+	}
+	if got := order(4); got != "wiki,docs,chat,alerts,builds" {
+		t.Errorf("Expected pinned services first, then by last access, got %s", got)
+	}
+
+	// Re-pinning keeps a service's place unless a sort order is given
+	if err := repo.Pin(4, "wiki", nil); err != nil {
+		t.Fatalf("Failed to pin service: %v", err)
+	}
+	if got := order(4); got != "wiki,docs,chat,alerts,builds" {
+		t.Errorf("Expected re-pinning to keep the order, got %s", got)
+	}
+	first := -1
+	if err := repo.Pin(4, "docs", &first); err != nil {
+		t.Fatalf("Failed to pin service: %v", err)
+	}
+	if got := order(4); got != "docs,wiki,chat,alerts,builds" {
+		t.Errorf("Expected docs to move first, got %s", got)
+	}
+
+	// Unpinned services keep their last access
+	if err := repo.Unpin(4, "wiki"); err != nil {
+		t.Fatalf("Failed to unpin service: %v", err)
+	}
+	if got := order(4); got != "docs,chat,alerts,wiki,builds" {
+		t.Errorf("Expected wiki to be ordered by last access, got %s", got)
+	}
+	preference, err := repo.Get(4, "wiki")
+	if err != nil {
+		t.Fatalf("Failed to get preference: %v", err)
+	}
+	if preference.Pinned || preference.LastAccessed == nil || !preference.LastAccessed.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("Expected an unpinned preference with its last access, got %+v", preference)
+	}
+
+	// Preferences are per user
+	if got := order(5); got != "alerts,builds,chat,docs,wiki" {
+		t.Errorf("Expected another user's order to be unaffected, got %s", got)
+	}
+	preference, err = repo.Get(5, "docs")
+	if err != nil {
+		t.Fatalf("Failed to get default preference: %v", err)
+	}
+	if preference.Pinned || preference.LastAccessed != nil || preference.ServiceID != "docs" {
+		t.Errorf("Expected the default preference, got %+v", preference)
+	}
+
+	// Deleting a service deletes the preferences for it
+	if err := services.Delete("docs"); err != nil {
+		t.Fatalf("Failed to delete service: %v", err)
+	}
+	var remaining int
+	if err := db.Get(&remaining, "SELECT COUNT(*) FROM user_service_preferences WHERE service_id = 'docs'"); err != nil {
+		t.Fatalf("Failed to count preferences: %v", err)
+	}
+	if remaining != 0 {
+		t.Errorf("Expected the preferences of a deleted service to be deleted, found %d", remaining)
+	}
+}
+
+// ServiceHealthCheckRepository tests
+func TestServiceHealthCheckRepository_Record(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	seedTestRegisteredServices(t, db, "health-service-1")
+
+	repo := db.ServiceHealthCheckRepository()
+	
+	check := &ServiceHealthCheck{
+		ServiceID:     "health-service-1",
+		IsHealthy:     true,
+		ResponseTime:  150,
+		ErrorMessage:  nil,
+		CheckedAt:     time.Now(),
+	}
+
+	err := repo.Record(check)
+	if err != nil {
+		t.Fatalf("Failed to record health check: %v", err)
+	}
+
+	// Verify the check has an ID after recording
+	if check.ID == 0 {
+		t.Error("Expected health check to have an ID after recording")
+	}
+}
+
+func TestServiceHealthCheckRepository_GetLatest(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	seedTestRegisteredServices(t, db, "health-service-2")
 
 	repo := db.ServiceHealthCheckRepository()
 	
@@ -1176,6 +1781,7 @@ func TestServiceHealthCheckRepository_GetLatest(t *testing.T) {
 func TestServiceHealthCheckRepository_GetHistory(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
+	seedTestRegisteredServices(t, db, "health-service-3")
 
 	repo := db.ServiceHealthCheckRepository()
 	
@@ -1563,6 +2169,60 @@ func TestMetricRepository_GetByService(t *testing.T) {
 	}
 }
 
+func TestMetricRepository_Top(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	repo := db.MetricRepository()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	samples := []struct {
+		scopeType string
+		scopeID   string
+		age       time.Duration
+		value     float64
+	}{
+		{"instance", "api-0", time.Minute, 40},
+		{"instance", "web-0", 2 * time.Minute, 90},
+		{"instance", "web-0", time.Minute, 20},
+		{"instance", "old-0", time.Hour, 99},
+		{"service", "web", time.Minute, 500},
+	}
+	for _, sample := range samples {
+		err := repo.Insert(&Metric{
+			Timestamp:   now.Add(-sample.age),
+			ScopeType:   sample.scopeType,
+			ScopeID:     sample.scopeID,
+			MetricName:  "cpu_usage",
+			MetricValue: sample.value,
+		})
+		if err != nil {
+			t.Fatalf("Failed to insert metric: %v", err)
+		}
+	}
+
+	// The latest sample of each instance counts, not its largest
+	top, err := repo.Top("instance", "cpu_usage", now.Add(-5*time.Minute), 10)
+	if err != nil {
+		t.Fatalf("Failed to get top metrics: %v", err)
+	}
+	if len(top) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(top))
+	}
+	if top[0].ScopeID != "api-0" || top[0].MetricValue != 40 || top[1].ScopeID != "web-0" || top[1].MetricValue != 20 {
+		t.Errorf("Expected api-0 at 40 then web-0 at 20, got %s at %v then %s at %v",
+			top[0].ScopeID, top[0].MetricValue, top[1].ScopeID, top[1].MetricValue)
+	}
+
+	top, err = repo.Top("instance", "cpu_usage", now.Add(-5*time.Minute), 1)
+	if err != nil {
+		t.Fatalf("Failed to get top metrics: %v", err)
+	}
+	if len(top) != 1 {
+		t.Errorf("Expected the limit to apply, got %d metrics", len(top))
+	}
+}
+
 func TestMetricRepository_DeleteOld(t *testing.T) {
 	db := createTestDB(t)
 	defer db.Close()
@@ -1633,4 +2293,1538 @@ func TestMetricRepository_DeleteOld(t *testing.T) {
 // Helper function for string pointers
 func stringPtr(s string) *string {
 	return &s
-}
\ No newline at end of file
+}
+func TestProbeResultRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	repo := db.ProbeResultRepository()
+	now := time.Now()
+
+	var results []*ProbeResult
+	for i := 0; i < 5; i++ {
+		results = append(results, &ProbeResult{
+			ID:           fmt.Sprintf("probe-a-%d", i),
+			ProbeID:      "probe-a",
+			Status:       "success",
+			ResponseTime: int64(i) * int64(time.Millisecond),
+			StatusCode:   200,
+			Metadata:     stringPtr(`{"attempts":1}`),
+			Timestamp:    now.Add(-time.Duration(i) * time.Hour),
+		})
+	}
+	results = append(results, &ProbeResult{ID: "probe-b-0", ProbeID: "probe-b", Status: "failure", Error: "refused", Timestamp: now})
+
+	if err := repo.InsertBatch(results); err != nil {
+		t.Fatalf("Failed to insert probe results: %v", err)
+	}
+	// Inserting the same results again is a no-op
+	if err := repo.InsertBatch(results[:2]); err != nil {
+		t.Fatalf("Failed to re-insert probe results: %v", err)
+	}
+
+	got, err := repo.ListByProbe("probe-a", now.Add(-150*time.Minute), 10)
+	if err != nil {
+		t.Fatalf("Failed to list probe results: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 results within the window, got %d", len(got))
+	}
+	if got[0].ID != "probe-a-0" || got[2].ID != "probe-a-2" {
+		t.Errorf("Expected newest first, got %s..%s", got[0].ID, got[2].ID)
+	}
+	if !got[0].Timestamp.Equal(now) {
+		t.Errorf("Expected timestamp %v, got %v", now, got[0].Timestamp)
+	}
+	if got[1].ResponseTime != int64(time.Millisecond) || got[1].StatusCode != 200 {
+		t.Errorf("Unexpected result fields: %+v", got[1])
+	}
+
+	limited, err := repo.ListByProbe("probe-a", time.Time{}, 2)
+	if err != nil {
+		t.Fatalf("Failed to list probe results: %v", err)
+	}
+	if len(limited) != 2 {
+		t.Errorf("Expected limit of 2 results, got %d", len(limited))
+	}
+
+	between, err := repo.ListBetween("probe-a", now.Add(-3*time.Hour), now)
+	if err != nil {
+		t.Fatalf("Failed to list probe results: %v", err)
+	}
+	if len(between) != 3 || between[0].ID != "probe-a-3" || between[2].ID != "probe-a-1" {
+		t.Errorf("Expected probe-a-3..probe-a-1 oldest first, got %d results", len(between))
+	}
+
+	removed, err := repo.DeleteOlderThan(now.Add(-90 * time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to delete old probe results: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("Expected 3 results removed, got %d", removed)
+	}
+}
+
+func TestProbeAlertRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	repo := db.ProbeAlertRepository()
+	now := time.Now()
+
+	stale := &ProbeAlert{ID: "stale", ProbeID: "probe-a", Type: "availability", Severity: "high",
+		Status: "active", Message: "down", Count: 1, FirstSeen: now.Add(-time.Hour), LastSeen: now.Add(-time.Hour)}
+	fresh := &ProbeAlert{ID: "fresh", ProbeID: "probe-b", Type: "threshold", Severity: "medium",
+		Status: "active", Message: "slow", Count: 1, FirstSeen: now, LastSeen: now}
+	if err := repo.UpsertBatch([]*ProbeAlert{stale, fresh}); err != nil {
+		t.Fatalf("Failed to insert probe alerts: %v", err)
+	}
+
+	// Updating an alert keeps a single row
+	fresh.Count = 2
+	if err := repo.UpsertBatch([]*ProbeAlert{fresh}); err != nil {
+		t.Fatalf("Failed to update probe alert: %v", err)
+	}
+
+	active, err := repo.ListByStatus("active", time.Time{}, 10)
+	if err != nil {
+		t.Fatalf("Failed to list active alerts: %v", err)
+	}
+	if len(active) != 2 || active[0].ID != "fresh" || active[0].Count != 2 {
+		t.Fatalf("Unexpected active alerts: %+v", active)
+	}
+
+	resolved, err := repo.ResolveStale(now.Add(-10*time.Minute), now)
+	if err != nil {
+		t.Fatalf("Failed to resolve stale alerts: %v", err)
+	}
+	if resolved != 1 {
+		t.Errorf("Expected 1 stale alert resolved, got %d", resolved)
+	}
+
+	active, err = repo.ListByStatus("active", now.Add(-time.Minute), 10)
+	if err != nil {
+		t.Fatalf("Failed to list active alerts: %v", err)
+	}
+	if len(active) != 1 || active[0].ID != "fresh" {
+		t.Errorf("Expected only the fresh alert to remain active, got %+v", active)
+	}
+
+	removed, err := repo.DeleteResolvedBefore(now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to delete resolved alerts: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 resolved alert removed, got %d", removed)
+	}
+}
+
+func TestLoginAttemptRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	repo := db.LoginAttemptRepository()
+	now := time.Now()
+
+	attempts := []*LoginAttempt{
+		{Username: "alice", IPAddress: "10.0.0.1", CreatedAt: now.Add(-30 * time.Minute)},
+		{Username: "alice", IPAddress: "10.0.0.1", CreatedAt: now.Add(-10 * time.Minute)},
+		{Username: "alice", IPAddress: "10.0.0.2", CreatedAt: now.Add(-5 * time.Minute)},
+		{Username: "alice", IPAddress: "10.0.0.2", Locked: true, CreatedAt: now.Add(-2 * time.Minute)},
+		{Username: "alice", IPAddress: "10.0.0.2", Success: true, CreatedAt: now.Add(-time.Minute)},
+		{Username: "bob", IPAddress: "10.0.0.3", CreatedAt: now},
+	}
+	for _, attempt := range attempts {
+		if err := repo.Record(attempt); err != nil {
+			t.Fatalf("Failed to record login attempt: %v", err)
+		}
+	}
+	if attempts[0].ID == 0 {
+		t.Error("Login attempt ID should be set after recording")
+	}
+
+	// Only unlocked failures inside the window count
+	failures, err := repo.RecentFailures("alice", now.Add(-15*time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to get recent failures: %v", err)
+	}
+	if len(failures) != 2 {
+		t.Fatalf("Expected 2 failures within the window, got %d", len(failures))
+	}
+	if !failures[0].Equal(now.Add(-5*time.Minute)) || !failures[1].Equal(now.Add(-10*time.Minute)) {
+		t.Errorf("Expected failures newest first, got %v", failures)
+	}
+
+	failures, _ = repo.RecentFailures("alice", now.Add(-time.Hour))
+	if len(failures) != 3 {
+		t.Errorf("Expected 3 failures within a wider window, got %d", len(failures))
+	}
+
+	// Clearing resets the count for that username only, keeping the history
+	cleared, err := repo.Clear("alice")
+	if err != nil {
+		t.Fatalf("Failed to clear failures: %v", err)
+	}
+	if cleared != 4 {
+		t.Errorf("Expected 4 failures cleared, got %d", cleared)
+	}
+	failures, _ = repo.RecentFailures("alice", now.Add(-time.Hour))
+	if len(failures) != 0 {
+		t.Errorf("Expected no failures after clearing, got %d", len(failures))
+	}
+	failures, _ = repo.RecentFailures("bob", now.Add(-time.Hour))
+	if len(failures) != 1 {
+		t.Errorf("Expected bob's failure to remain, got %d", len(failures))
+	}
+
+	all, err := repo.List("", 10)
+	if err != nil {
+		t.Fatalf("Failed to list login attempts: %v", err)
+	}
+	if len(all) != len(attempts) {
+		t.Fatalf("Expected %d attempts, got %d", len(attempts), len(all))
+	}
+	if all[0].Username != "bob" || !all[1].Success {
+		t.Errorf("Expected attempts newest first, got %+v", all[0])
+	}
+
+	alice, err := repo.List("alice", 2)
+	if err != nil {
+		t.Fatalf("Failed to list login attempts: %v", err)
+	}
+	if len(alice) != 2 || alice[0].Username != "alice" || !alice[1].Locked {
+		t.Errorf("Unexpected attempts for alice: %+v", alice)
+	}
+}
+
+func TestPasswordResetTokenRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	seedTestUsers(t, db, 1, 2)
+	repo := db.PasswordResetTokenRepository()
+	now := time.Now()
+
+	tokens := []*PasswordResetToken{
+		{UserID: 1, TokenHash: "valid", ExpiresAt: now.Add(time.Hour)},
+		{UserID: 2, TokenHash: "expired", ExpiresAt: now.Add(-time.Minute)},
+	}
+	for _, token := range tokens {
+		if err := repo.Create(token); err != nil {
+			t.Fatalf("Failed to create reset token: %v", err)
+		}
+	}
+
+	userID, err := repo.Consume("valid", now)
+	if err != nil {
+		t.Fatalf("Failed to consume reset token: %v", err)
+	}
+	if userID != 1 {
+		t.Errorf("Expected token for user 1, got %d", userID)
+	}
+
+	// Tokens are single-use, and expired or unknown tokens are rejected
+	for _, hash := range []string{"valid", "expired", "unknown"} {
+		if _, err := repo.Consume(hash, now); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("Expected ErrInvalidResetToken consuming %q, got %v", hash, err)
+		}
+	}
+
+	// A new token replaces the user's outstanding ones
+	if err := repo.Create(&PasswordResetToken{UserID: 2, TokenHash: "first", ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to create reset token: %v", err)
+	}
+	if err := repo.Create(&PasswordResetToken{UserID: 2, TokenHash: "second", ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to create reset token: %v", err)
+	}
+	if _, err := repo.Consume("first", now); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("Expected the earlier token to be invalidated, got %v", err)
+	}
+	if userID, err := repo.Consume("second", now); err != nil || userID != 2 {
+		t.Errorf("Expected the latest token to be valid for user 2, got %d, %v", userID, err)
+	}
+}
+
+func TestAPIKeyRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	seedTestUsers(t, db, 1, 2)
+	repo := db.APIKeyRepository()
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+
+	keys := []*APIKey{
+		{UserID: 1, Name: "ci", Prefix: "ick_1", KeyHash: "active", Scopes: "read"},
+		{UserID: 1, Name: "old", Prefix: "ick_2", KeyHash: "expired", Scopes: "read,write", ExpiresAt: &expired},
+		{UserID: 2, Name: "deploy", Prefix: "ick_3", KeyHash: "other", Scopes: "write"},
+	}
+	for _, key := range keys {
+		if err := repo.Create(key); err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+	}
+
+	key, err := repo.GetActiveByHash("active", now)
+	if err != nil {
+		t.Fatalf("Failed to get API key: %v", err)
+	}
+	if key.UserID != 1 || key.Name != "ci" || key.LastUsed != nil {
+		t.Errorf("Unexpected API key: %+v", key)
+	}
+
+	if _, err := repo.GetActiveByHash("expired", now); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected ErrAPIKeyNotFound for an expired key, got %v", err)
+	}
+
+	if err := repo.UpdateLastUsed(key.ID, now); err != nil {
+		t.Fatalf("Failed to update last used: %v", err)
+	}
+	key, err = repo.GetActiveByHash("active", now)
+	if err != nil {
+		t.Fatalf("Failed to get API key: %v", err)
+	}
+	if key.LastUsed == nil {
+		t.Error("Expected last used to be set")
+	}
+
+	listed, err := repo.ListByUser(1)
+	if err != nil {
+		t.Fatalf("Failed to list API keys: %v", err)
+	}
+	if len(listed) != 2 {
+		t.Errorf("Expected 2 API keys for user 1, got %d", len(listed))
+	}
+
+	// Users can only revoke their own keys
+	if err := repo.Revoke(2, key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected ErrAPIKeyNotFound revoking another user's key, got %v", err)
+	}
+	if err := repo.Revoke(1, key.ID); err != nil {
+		t.Fatalf("Failed to revoke API key: %v", err)
+	}
+	if _, err := repo.GetActiveByHash("active", now); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected ErrAPIKeyNotFound for a revoked key, got %v", err)
+	}
+}
+
+func TestMaintenanceWindowRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	services := db.RegisteredServiceRepository()
+	for _, name := range []string{"portal", "wiki"} {
+		service := &RegisteredService{ID: name, Name: name, DisplayName: name, ServiceURL: "http://localhost", Category: "web", RequiredRole: "user", Status: RegisteredServiceActive}
+		if err := services.Create(service); err != nil {
+			t.Fatalf("Failed to create service: %v", err)
+		}
+	}
+
+	repo := db.MaintenanceWindowRepository()
+	start := time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC)
+	window := &MaintenanceWindow{ServiceID: "portal", StartsAt: start, EndsAt: start.Add(2 * time.Hour), Message: "Database upgrade"}
+	if err := repo.Create(window); err != nil {
+		t.Fatalf("Failed to create maintenance window: %v", err)
+	}
+
+	// Overlapping windows of the same service are rejected, touching ones and
+	// windows of other services are not
+	overlapping := &MaintenanceWindow{ServiceID: "portal", StartsAt: start.Add(time.Hour), EndsAt: start.Add(3 * time.Hour)}
+	if err := repo.Create(overlapping); !errors.Is(err, ErrMaintenanceWindowOverlap) {
+		t.Errorf("Expected ErrMaintenanceWindowOverlap, got %v", err)
+	}
+	enclosing := &MaintenanceWindow{ServiceID: "portal", StartsAt: start.Add(-time.Hour), EndsAt: start.Add(3 * time.Hour)}
+	if err := repo.Create(enclosing); !errors.Is(err, ErrMaintenanceWindowOverlap) {
+		t.Errorf("Expected ErrMaintenanceWindowOverlap for an enclosing window, got %v", err)
+	}
+	next := &MaintenanceWindow{ServiceID: "portal", StartsAt: start.Add(2 * time.Hour), EndsAt: start.Add(3 * time.Hour), Message: "Reindex"}
+	if err := repo.Create(next); err != nil {
+		t.Fatalf("Failed to create adjacent maintenance window: %v", err)
+	}
+	other := &MaintenanceWindow{ServiceID: "wiki", StartsAt: start, EndsAt: start.Add(time.Hour)}
+	if err := repo.Create(other); err != nil {
+		t.Fatalf("Failed to create maintenance window of another service: %v", err)
+	}
+
+	// A window is active from its start up to its end
+	for _, tt := range []struct {
+		at      time.Time
+		message string
+	}{
+		{start.Add(-time.Nanosecond), ""},
+		{start, "Database upgrade"},
+		{start.Add(2*time.Hour - time.Microsecond), "Database upgrade"},
+		{start.Add(2 * time.Hour), "Reindex"},
+		{start.Add(3 * time.Hour), ""},
+	} {
+		active, err := repo.GetActive("portal", tt.at)
+		if tt.message == "" {
+			if !errors.Is(err, ErrMaintenanceWindowNotFound) {
+				t.Errorf("Expected no active window at %s, got %+v, %v", tt.at, active, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Failed to get active window at %s: %v", tt.at, err)
+		}
+		if active.Message != tt.message || !active.ActiveAt(tt.at) {
+			t.Errorf("Expected window %q to be active at %s, got %+v", tt.message, tt.at, active)
+		}
+	}
+
+	active, err := repo.ListActive(start.Add(30 * time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to list active windows: %v", err)
+	}
+	if len(active) != 2 || active["portal"].ID != window.ID || active["wiki"].ID != other.ID {
+		t.Errorf("Unexpected active windows: %+v", active)
+	}
+
+	// Updates are checked for overlaps against every other window
+	window.EndsAt = start.Add(150 * time.Minute)
+	if err := repo.Update(window); !errors.Is(err, ErrMaintenanceWindowOverlap) {
+		t.Errorf("Expected ErrMaintenanceWindowOverlap extending into the next window, got %v", err)
+	}
+	window.EndsAt = start.Add(time.Hour)
+	window.Message = "Shorter upgrade"
+	if err := repo.Update(window); err != nil {
+		t.Fatalf("Failed to update maintenance window: %v", err)
+	}
+
+	listed, err := repo.ListByService("portal")
+	if err != nil {
+		t.Fatalf("Failed to list maintenance windows: %v", err)
+	}
+	if len(listed) != 2 || listed[0].Message != "Shorter upgrade" || !listed[0].EndsAt.Equal(start.Add(time.Hour)) || listed[1].ID != next.ID {
+		t.Errorf("Unexpected maintenance windows: %+v", listed)
+	}
+
+	if err := repo.Delete(next.ID); err != nil {
+		t.Fatalf("Failed to delete maintenance window: %v", err)
+	}
+	if err := repo.Delete(next.ID); !errors.Is(err, ErrMaintenanceWindowNotFound) {
+		t.Errorf("Expected ErrMaintenanceWindowNotFound deleting twice, got %v", err)
+	}
+	if err := repo.Update(next); !errors.Is(err, ErrMaintenanceWindowNotFound) {
+		t.Errorf("Expected ErrMaintenanceWindowNotFound updating a deleted window, got %v", err)
+	}
+}
+
+func TestAuditLogRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	seedTestUsers(t, db, 1, 2)
+	repo := db.AuditLogRepository()
+	now := time.Now()
+	alice, bob := 1, 2
+
+	entries := []*AuditLog{
+		{UserID: &alice, Action: "create", ResourceType: "service", ResourceID: stringPtr("svc-1"), CreatedAt: now.Add(-2 * time.Hour)},
+		{UserID: &alice, Action: "update", ResourceType: "service", ResourceID: stringPtr("svc-1"), Details: stringPtr(`{"port":{"from":80,"to":8080}}`), CreatedAt: now.Add(-time.Hour)},
+		{UserID: &bob, Action: "delete", ResourceType: "user", ResourceID: stringPtr("3"), CreatedAt: now},
+	}
+	for _, entry := range entries {
+		if err := repo.Create(entry); err != nil {
+			t.Fatalf("Failed to create audit log: %v", err)
+		}
+	}
+
+	since := now.Add(-90 * time.Minute)
+	tests := []struct {
+		name     string
+		filter   AuditLogFilter
+		expected []string
+	}{
+		{name: "all, newest first", filter: AuditLogFilter{}, expected: []string{"delete", "update", "create"}},
+		{name: "by user", filter: AuditLogFilter{UserID: &alice}, expected: []string{"update", "create"}},
+		{name: "by action", filter: AuditLogFilter{Action: "update"}, expected: []string{"update"}},
+		{name: "by resource type", filter: AuditLogFilter{ResourceType: "user"}, expected: []string{"delete"}},
+		{name: "by time range", filter: AuditLogFilter{Since: &since, Until: &now}, expected: []string{"delete", "update"}},
+		{name: "paged", filter: AuditLogFilter{Limit: 1, Offset: 1}, expected: []string{"update"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := repo.List(tt.filter)
+			if err != nil {
+				t.Fatalf("Failed to list audit logs: %v", err)
+			}
+			var actions []string
+			for _, log := range logs {
+				actions = append(actions, log.Action)
+			}
+			if strings.Join(actions, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected actions %v, got %v", tt.expected, actions)
+			}
+		})
+	}
+}
+
+func TestServiceRepository_ListPaged(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	repo := db.ServiceRepository()
+	for i, name := range []string{"svc-c", "svc-a", "svc-e", "svc-b", "svc-d"} {
+		status := "stopped"
+		if i%2 == 0 {
+			status = "running"
+		}
+		service := &Service{Name: name, Image: "nginx:latest", Port: 8080 + i, Replicas: 1, Status: status, Environment: map[string]string{"INDEX": fmt.Sprint(i)}}
+		if err := repo.Create(service); err != nil {
+			t.Fatalf("Failed to create service: %v", err)
+		}
+	}
+
+	names := func(services []*Service) string {
+		var result []string
+		for _, service := range services {
+			result = append(result, service.Name)
+		}
+		return strings.Join(result, ",")
+	}
+
+	tests := []struct {
+		name          string
+		opts          ListOptions
+		expected      string
+		expectedTotal int
+	}{
+		{name: "first page", opts: ListOptions{Limit: 2, SortBy: "name", SortDir: SortAsc}, expected: "svc-a,svc-b", expectedTotal: 5},
+		{name: "descending", opts: ListOptions{Limit: 2, SortBy: "name", SortDir: SortDesc}, expected: "svc-e,svc-d", expectedTotal: 5},
+		{name: "last partial page", opts: ListOptions{Limit: 2, Offset: 4, SortBy: "name", SortDir: SortAsc}, expected: "svc-e", expectedTotal: 5},
+		{name: "offset at end", opts: ListOptions{Limit: 2, Offset: 5, SortBy: "name"}, expected: "", expectedTotal: 5},
+		{name: "offset past end", opts: ListOptions{Limit: 2, Offset: 50, SortBy: "name"}, expected: "", expectedTotal: 5},
+		{name: "no limit", opts: ListOptions{Offset: 3, SortBy: "name", SortDir: SortAsc}, expected: "svc-d,svc-e", expectedTotal: 5},
+		{name: "filtered", opts: ListOptions{SortBy: "name", SortDir: SortAsc, Filters: map[string]string{"status": "running"}}, expected: "svc-c,svc-d,svc-e", expectedTotal: 3},
+		{name: "filtered page", opts: ListOptions{Limit: 1, Offset: 1, SortBy: "name", SortDir: SortAsc, Filters: map[string]string{"status": "running"}}, expected: "svc-d", expectedTotal: 3},
+		{name: "filter values are bound", opts: ListOptions{Filters: map[string]string{"status": "running' OR '1'='1"}}, expected: "", expectedTotal: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services, total, err := repo.ListPaged(tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to list services: %v", err)
+			}
+			if got := names(services); got != tt.expected {
+				t.Errorf("Expected services %q, got %q", tt.expected, got)
+			}
+			if total != tt.expectedTotal {
+				t.Errorf("Expected total %d, got %d", tt.expectedTotal, total)
+			}
+		})
+	}
+
+	services, _, err := repo.ListPaged(ListOptions{Limit: 1, SortBy: "name", SortDir: SortAsc})
+	if err != nil {
+		t.Fatalf("Failed to list services: %v", err)
+	}
+	if services[0].Environment["INDEX"] != "1" {
+		t.Errorf("Expected JSON fields to be decoded, got %v", services[0].Environment)
+	}
+}
+
+func TestListPagedRejectsUnsafeOptions(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	seedTestUsers(t, db, 1)
+
+	invalid := []ListOptions{
+		{SortBy: "name; DROP TABLE users; --"},
+		{SortBy: "password_hash"},
+		{SortBy: "username", SortDir: "asc; DROP TABLE users"},
+		{Filters: map[string]string{"1=1 OR role": "admin"}},
+		{Filters: map[string]string{"password_hash": "hash"}},
+		{Limit: -1},
+		{Offset: -1},
+	}
+	for _, opts := range invalid {
+		if _, _, err := db.UserRepository().ListPaged(opts); !errors.Is(err, ErrInvalidListOptions) {
+			t.Errorf("Expected ErrInvalidListOptions for %+v, got %v", opts, err)
+		}
+	}
+
+	// The table is untouched and valid options still work
+	users, total, err := db.UserRepository().ListPaged(ListOptions{SortBy: "username", Filters: map[string]string{"role": "user"}})
+	if err != nil {
+		t.Fatalf("Failed to list users: %v", err)
+	}
+	if len(users) != 1 || total != 1 {
+		t.Errorf("Expected 1 user, got %d (total %d)", len(users), total)
+	}
+}
+
+func TestRegisteredServiceAndRouteListPaged(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	seedTestRegisteredServices(t, db, "grafana", "jenkins", "prometheus")
+	if _, err := db.Exec(`UPDATE registered_services SET category = 'monitoring' WHERE id IN ('grafana', 'prometheus')`); err != nil {
+		t.Fatalf("Failed to categorise services: %v", err)
+	}
+
+	services, total, err := db.RegisteredServiceRepository().ListPaged(ListOptions{
+		Limit: 1, SortBy: "name", SortDir: SortDesc, Filters: map[string]string{"category": "monitoring"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to list registered services: %v", err)
+	}
+	if total != 2 || len(services) != 1 || services[0].Name != "prometheus" {
+		t.Errorf("Expected prometheus of 2 monitoring services, got %d services (total %d)", len(services), total)
+	}
+
+	routeRepo := db.RouteRepository()
+	for _, host := range []string{"a.example.com", "b.example.com", "a.example.com"} {
+		if err := routeRepo.Create(&Route{Host: host, PathPrefix: "/"}); err != nil {
+			t.Fatalf("Failed to create route: %v", err)
+		}
+	}
+
+	routes, total, err := routeRepo.ListPaged(ListOptions{Filters: map[string]string{"host": "a.example.com"}})
+	if err != nil {
+		t.Fatalf("Failed to list routes: %v", err)
+	}
+	if total != 2 || len(routes) != 2 {
+		t.Errorf("Expected 2 routes for a.example.com, got %d (total %d)", len(routes), total)
+	}
+}
+
+func TestBackupTo(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(dir, "console.db"), WALMode: true, BackupDir: filepath.Join(dir, "backups")},
+		},
+	}
+
+	db, err := NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	seedTestUsers(t, db, 1, 2, 3)
+
+	backup, err := db.CreateBackup()
+	if err != nil {
+		t.Fatalf("Failed to back up database: %v", err)
+	}
+	if filepath.Dir(backup.Path) != cfg.Console.Database.BackupDir || backup.SizeBytes == 0 || len(backup.SHA256) != 64 {
+		t.Errorf("Unexpected backup info: %+v", backup)
+	}
+
+	if err := db.BackupTo(backup.Path); err == nil {
+		t.Error("Expected backing up over an existing file to fail")
+	}
+
+	backups, err := db.ListBackups()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 1 || backups[0].SHA256 != backup.SHA256 {
+		t.Errorf("Expected the backup to be listed with its checksum, got %+v", backups)
+	}
+
+	// The backup is a complete, migrated database in its own right
+	restored, err := NewDB(&config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: backup.Path, DisableAutoMigrate: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer restored.Close()
+
+	stats, err := restored.GetStats()
+	if err != nil {
+		t.Fatalf("Failed to get backup stats: %v", err)
+	}
+	if stats["users_count"] != 3 {
+		t.Errorf("Expected 3 users in the backup, got %v", stats["users_count"])
+	}
+}
+
+// insertTestMetric inserts one sample of the cpu_usage series of service-a
+func insertTestMetric(t *testing.T, repo *MetricRepository, at time.Time, value float64) {
+	t.Helper()
+	err := repo.Insert(&Metric{Timestamp: at, ScopeType: "service", ScopeID: "service-a", MetricName: "cpu_usage", MetricValue: value})
+	if err != nil {
+		t.Fatalf("Failed to insert metric: %v", err)
+	}
+}
+
+func TestMetricRepository_QueryAggregated(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	repo := db.MetricRepository()
+	base := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	// Samples on both sides of the 12:05 boundary, none between 12:10 and 12:15
+	insertTestMetric(t, repo, base, 10)
+	insertTestMetric(t, repo, base.Add(4*time.Minute+59*time.Second), 30)
+	insertTestMetric(t, repo, base.Add(5*time.Minute), 100)
+	insertTestMetric(t, repo, base.Add(15*time.Minute), 7)
+	// A sample just past the end of the range must be excluded
+	insertTestMetric(t, repo, base.Add(20*time.Minute), 1000)
+
+	buckets, err := repo.QueryAggregated("service", "service-a", "cpu_usage", base.Add(2*time.Minute), base.Add(20*time.Minute), 5*time.Minute, MetricAggAvg)
+	if err != nil {
+		t.Fatalf("Failed to query aggregated metrics: %v", err)
+	}
+	if len(buckets) != 4 {
+		t.Fatalf("Expected 4 buckets, got %d", len(buckets))
+	}
+
+	expected := []struct {
+		count int
+		value *float64
+	}{
+		{2, floatPtr(20)},
+		{1, floatPtr(100)},
+		{0, nil},
+		{1, floatPtr(7)},
+	}
+	for i, bucket := range buckets {
+		if start := base.Add(time.Duration(i) * 5 * time.Minute); !bucket.Start.Equal(start) {
+			t.Errorf("Bucket %d: expected start %v, got %v", i, start, bucket.Start)
+		}
+		if bucket.Count != expected[i].count {
+			t.Errorf("Bucket %d: expected %d samples, got %d", i, expected[i].count, bucket.Count)
+		}
+		switch {
+		case expected[i].value == nil && bucket.Value != nil:
+			t.Errorf("Bucket %d: expected no value, got %v", i, *bucket.Value)
+		case expected[i].value != nil && (bucket.Value == nil || *bucket.Value != *expected[i].value):
+			t.Errorf("Bucket %d: expected value %v, got %v", i, *expected[i].value, bucket.Value)
+		}
+	}
+
+	aggregations := map[string]float64{
+		MetricAggMin:   10,
+		MetricAggMax:   30,
+		MetricAggSum:   40,
+		MetricAggCount: 2,
+		MetricAggP95:   30, // mean 20 + 1.645 * stddev 10, capped at the maximum
+	}
+	for agg, want := range aggregations {
+		buckets, err := repo.QueryAggregated("service", "service-a", "cpu_usage", base, base.Add(10*time.Minute), 5*time.Minute, agg)
+		if err != nil {
+			t.Fatalf("Failed to query %s: %v", agg, err)
+		}
+		if buckets[0].Value == nil || *buckets[0].Value != want {
+			t.Errorf("Expected %s of %v, got %v", agg, want, buckets[0].Value)
+		}
+	}
+
+	buckets, err = repo.QueryAggregated("service", "service-a", "cpu_usage", base.Add(10*time.Minute), base.Add(15*time.Minute), 5*time.Minute, MetricAggCount)
+	if err != nil {
+		t.Fatalf("Failed to query count: %v", err)
+	}
+	if buckets[0].Value == nil || *buckets[0].Value != 0 {
+		t.Errorf("Expected an empty bucket to count 0, got %v", buckets[0].Value)
+	}
+
+	invalid := []struct {
+		step time.Duration
+		agg  string
+	}{
+		{5 * time.Minute, "median"},
+		{0, MetricAggAvg},
+		{1500 * time.Millisecond, MetricAggAvg},
+		{time.Second, MetricAggAvg}, // too many buckets over a year
+	}
+	for _, tc := range invalid {
+		_, err := repo.QueryAggregated("service", "service-a", "cpu_usage", base.AddDate(-1, 0, 0), base, tc.step, tc.agg)
+		if !errors.Is(err, ErrInvalidMetricQuery) {
+			t.Errorf("Expected ErrInvalidMetricQuery for step %v and agg %q, got %v", tc.step, tc.agg, err)
+		}
+	}
+}
+
+func TestMetricRepository_RollupSurvivesRawDeletion(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	repo := db.MetricRepository()
+	base := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		insertTestMetric(t, repo, base.Add(time.Duration(i)*time.Minute), float64(i+1))
+	}
+	insertTestMetric(t, repo, base.Add(7*time.Minute), 10)
+	insertTestMetric(t, repo, base.Add(12*time.Minute), 50)
+
+	// Only the two complete buckets before 12:12 are rolled up
+	rolled, err := repo.Rollup(base.Add(12 * time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to roll up metrics: %v", err)
+	}
+	if rolled != 2 {
+		t.Fatalf("Expected 2 rollup buckets, got %d", rolled)
+	}
+
+	// Rolling up again must not add the same buckets twice
+	if rolled, err = repo.Rollup(base.Add(12 * time.Minute)); err != nil || rolled != 0 {
+		t.Fatalf("Expected a repeated rollup to write nothing, got %d (%v)", rolled, err)
+	}
+
+	query := func() []*MetricBucket {
+		t.Helper()
+		buckets, err := repo.QueryAggregated("service", "service-a", "cpu_usage", base, base.Add(15*time.Minute), 5*time.Minute, MetricAggSum)
+		if err != nil {
+			t.Fatalf("Failed to query aggregated metrics: %v", err)
+		}
+		return buckets
+	}
+
+	// While the raw metrics remain, rollups must not double count them
+	before := query()
+
+	// Deleting before 12:08 rounds down to 12:05, keeping the 12:07 sample raw
+	deleted, err := repo.DeleteOlderThan(base.Add(8 * time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to delete raw metrics: %v", err)
+	}
+	if deleted != 4 {
+		t.Errorf("Expected 4 raw metrics deleted, got %d", deleted)
+	}
+	after := query()
+
+	// Once raw metrics are deleted, only the rollups remain
+	if _, err := repo.DeleteOlderThan(base.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to delete raw metrics: %v", err)
+	}
+	rollupsOnly := query()
+
+	expected := []float64{10, 10, 50}
+	for name, buckets := range map[string][]*MetricBucket{"before": before, "after": after} {
+		for i, want := range expected {
+			if buckets[i].Value == nil || *buckets[i].Value != want {
+				t.Errorf("%s deletion, bucket %d: expected sum %v, got %v", name, i, want, buckets[i].Value)
+			}
+		}
+	}
+	for i, want := range expected[:2] {
+		if rollupsOnly[i].Value == nil || *rollupsOnly[i].Value != want || rollupsOnly[i].Count == 0 {
+			t.Errorf("Rollups only, bucket %d: expected sum %v, got %v", i, want, rollupsOnly[i].Value)
+		}
+	}
+	if rollupsOnly[2].Value != nil {
+		t.Errorf("Expected the bucket that was never rolled up to be empty, got %v", *rollupsOnly[2].Value)
+	}
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestLogIndexRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	if err := db.ServiceRepository().Create(&Service{ID: "log-service", Name: "log-service", Image: "nginx", Port: 80, Status: "running"}); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	repo := db.LogIndexRepository()
+	base := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	chunks := []*LogIndex{
+		{LogFile: "/logs/log-service/000002.log", OffsetStart: 0, OffsetEnd: 80, LineCount: 2},
+		{LogFile: "/logs/log-service/000001.log", OffsetStart: 120, OffsetEnd: 200, LineCount: 2},
+		{LogFile: "/logs/log-service/000001.log", OffsetStart: 0, OffsetEnd: 120, LineCount: 3},
+	}
+	for i, chunk := range chunks {
+		chunk.ServiceID = "log-service"
+		chunk.StartTimestamp = base.Add(time.Duration(i) * time.Minute)
+		chunk.EndTimestamp = chunk.StartTimestamp.Add(30 * time.Second)
+		if err := repo.Create(chunk); err != nil {
+			t.Fatalf("Failed to create log index entry: %v", err)
+		}
+		if chunk.ID == 0 {
+			t.Error("Expected the entry ID to be set")
+		}
+	}
+
+	if err := repo.Create(&LogIndex{ServiceID: "missing", LogFile: "x.log", StartTimestamp: base, EndTimestamp: base}); err == nil {
+		t.Error("Expected an entry for an unknown service to violate the foreign key")
+	}
+
+	entries, err := repo.ListByService("log-service")
+	if err != nil {
+		t.Fatalf("Failed to list log index entries: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	expected := []struct {
+		file  string
+		start int64
+	}{
+		{"/logs/log-service/000001.log", 0},
+		{"/logs/log-service/000001.log", 120},
+		{"/logs/log-service/000002.log", 0},
+	}
+	for i, entry := range entries {
+		if entry.LogFile != expected[i].file || entry.OffsetStart != expected[i].start {
+			t.Errorf("Entry %d: expected %s at %d, got %s at %d", i, expected[i].file, expected[i].start, entry.LogFile, entry.OffsetStart)
+		}
+	}
+	if !entries[2].EndTimestamp.Equal(base.Add(30 * time.Second)) {
+		t.Errorf("Expected end timestamp %v, got %v", base.Add(30*time.Second), entries[2].EndTimestamp)
+	}
+
+	deleted, err := repo.DeleteByFile("log-service", "/logs/log-service/000001.log")
+	if err != nil {
+		t.Fatalf("Failed to delete log index entries: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected 2 entries deleted, got %d", deleted)
+	}
+	if entries, _ := repo.ListByService("log-service"); len(entries) != 1 {
+		t.Errorf("Expected 1 entry left, got %d", len(entries))
+	}
+}
+
+func TestDeploymentRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"svc-a", "svc-b"} {
+		if err := db.ServiceRepository().Create(&Service{ID: id, Name: id, Image: "nginx", Port: 80, Status: "running"}); err != nil {
+			t.Fatalf("Failed to create service: %v", err)
+		}
+	}
+
+	repo := db.DeploymentRepository()
+	base := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	var created []*Deployment
+	for i, serviceID := range []string{"svc-a", "svc-b", "svc-a"} {
+		deployment := &Deployment{
+			ServiceID: serviceID,
+			Status:    "deploying",
+			Strategy:  "rolling",
+			Config:    `{"image":"nginx:1.` + strconv.Itoa(i) + `"}`,
+			StartedAt: base.Add(time.Duration(i) * time.Minute),
+		}
+		if err := repo.Create(deployment); err != nil {
+			t.Fatalf("Failed to create deployment: %v", err)
+		}
+		created = append(created, deployment)
+	}
+	if created[0].Version != 1 || created[1].Version != 1 || created[2].Version != 2 {
+		t.Errorf("Expected versions to count up per service, got %d, %d, %d",
+			created[0].Version, created[1].Version, created[2].Version)
+	}
+
+	finished := base.Add(time.Hour)
+	if err := repo.UpdateStatus(created[2].ID, "failed", &finished, stringPtr("image pull failed")); err != nil {
+		t.Fatalf("Failed to update deployment status: %v", err)
+	}
+	if err := repo.UpdateStatus("missing", "failed", nil, nil); err == nil {
+		t.Error("Expected updating an unknown deployment to fail")
+	}
+	for _, line := range []string{"Created 1 service instances", "Deployment failed"} {
+		if err := repo.AppendLog(created[2].ID, line); err != nil {
+			t.Fatalf("Failed to append deployment log: %v", err)
+		}
+	}
+
+	deployment, err := repo.GetByID(created[2].ID)
+	if err != nil {
+		t.Fatalf("Failed to get deployment: %v", err)
+	}
+	if deployment.Status != "failed" || deployment.Config != `{"image":"nginx:1.2"}` || deployment.Strategy != "rolling" {
+		t.Errorf("Unexpected deployment: %+v", deployment)
+	}
+	if deployment.FinishedAt == nil || !deployment.FinishedAt.Equal(finished) {
+		t.Errorf("Expected finished at %v, got %v", finished, deployment.FinishedAt)
+	}
+	if deployment.ErrorMessage == nil || *deployment.ErrorMessage != "image pull failed" {
+		t.Errorf("Expected the error message to be stored, got %v", deployment.ErrorMessage)
+	}
+	lines, err := deployment.LogLines()
+	if err != nil {
+		t.Fatalf("Failed to decode deployment logs: %v", err)
+	}
+	if len(lines) != 2 || lines[1] != "Deployment failed" {
+		t.Errorf("Expected the appended log lines, got %v", lines)
+	}
+
+	if _, err := repo.GetByID("missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for an unknown deployment, got %v", err)
+	}
+
+	byService, err := repo.ListByService("svc-a")
+	if err != nil {
+		t.Fatalf("Failed to list deployments: %v", err)
+	}
+	if len(byService) != 2 || byService[0].Version != 2 || byService[1].Version != 1 {
+		t.Errorf("Expected svc-a deployments newest version first, got %d", len(byService))
+	}
+
+	recent, err := repo.ListRecent(2)
+	if err != nil {
+		t.Fatalf("Failed to list recent deployments: %v", err)
+	}
+	if len(recent) != 2 || recent[0].ID != created[2].ID || recent[1].ID != created[1].ID {
+		t.Errorf("Expected the two newest deployments, got %d", len(recent))
+	}
+
+	if err := repo.Delete(created[0].ID); err != nil {
+		t.Fatalf("Failed to delete deployment: %v", err)
+	}
+	if err := repo.Delete(created[0].ID); err == nil {
+		t.Error("Expected deleting a deleted deployment to fail")
+	}
+}
+
+func TestDashboardQueries(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	// Sums cover rollups of pruned raw metrics without counting kept raw metrics twice
+	metrics := db.MetricRepository()
+	for _, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute, 10 * time.Minute} {
+		metric := &Metric{Timestamp: now.Add(-age), ScopeType: "route", ScopeID: "web", MetricName: "request_count", MetricValue: 10}
+		if err := metrics.Insert(metric); err != nil {
+			t.Fatalf("Failed to insert metric: %v", err)
+		}
+	}
+	if _, err := metrics.Rollup(now.Add(-time.Hour)); err != nil {
+		t.Fatalf("Failed to roll up metrics: %v", err)
+	}
+	if _, err := metrics.DeleteOlderThan(now.Add(-150 * time.Minute)); err != nil {
+		t.Fatalf("Failed to prune metrics: %v", err)
+	}
+	if total, err := metrics.Sum("request_count", now.Add(-24*time.Hour)); err != nil || total != 40 {
+		t.Errorf("Expected 40 requests in the last day, got %v (%v)", total, err)
+	}
+	if total, err := metrics.Sum("request_count", now.Add(-time.Hour)); err != nil || total != 20 {
+		t.Errorf("Expected 20 requests in the last hour, got %v (%v)", total, err)
+	}
+	if total, err := metrics.Sum("error_count", now.Add(-24*time.Hour)); err != nil || total != 0 {
+		t.Errorf("Expected no errors, got %v (%v)", total, err)
+	}
+
+	alerts := []*ProbeAlert{
+		{ID: "a1", ProbeID: "p1", Type: "availability", Severity: "critical", Status: "active", Message: "down", Count: 1, FirstSeen: now, LastSeen: now},
+		{ID: "a2", ProbeID: "p2", Type: "availability", Severity: "critical", Status: "active", Message: "down", Count: 1, FirstSeen: now, LastSeen: now},
+		{ID: "a3", ProbeID: "p3", Type: "performance", Severity: "medium", Status: "active", Message: "slow", Count: 1, FirstSeen: now, LastSeen: now},
+		{ID: "a4", ProbeID: "p4", Type: "performance", Severity: "medium", Status: "resolved", Message: "slow", Count: 1, FirstSeen: now, LastSeen: now, ResolvedAt: &now},
+	}
+	if err := db.ProbeAlertRepository().UpsertBatch(alerts); err != nil {
+		t.Fatalf("Failed to upsert alerts: %v", err)
+	}
+	counts, err := db.ProbeAlertRepository().CountActiveBySeverity()
+	if err != nil {
+		t.Fatalf("Failed to count alerts: %v", err)
+	}
+	if len(counts) != 2 || counts["critical"] != 2 || counts["medium"] != 1 {
+		t.Errorf("Unexpected active alert counts: %v", counts)
+	}
+
+	for _, plan := range []string{"db", "files"} {
+		if _, err := db.Exec("INSERT INTO snap_plans (id, name, cron_expression, paths) VALUES (?, ?, '@daily', '[]')", plan, plan); err != nil {
+			t.Fatalf("Failed to create snapshot plan: %v", err)
+		}
+	}
+	snapshots := []struct {
+		id     string
+		age    time.Duration
+		status string
+	}{
+		{"old", 48 * time.Hour, "completed"},
+		{"latest", 24 * time.Hour, "completed"},
+		{"failed", time.Hour, "failed"},
+	}
+	for _, s := range snapshots {
+		_, err := db.Exec("INSERT INTO snapshots (id, plan_id, timestamp, manifest_path, size_bytes, kind, status) VALUES (?, 'db', ?, '', 1024, 'full', ?)",
+			s.id, now.Add(-s.age), s.status)
+		if err != nil {
+			t.Fatalf("Failed to create snapshot: %v", err)
+		}
+	}
+	latest, err := db.SnapshotRepository().LatestPerPlan()
+	if err != nil {
+		t.Fatalf("Failed to list latest snapshots: %v", err)
+	}
+	if len(latest) != 2 {
+		t.Fatalf("Expected 2 plans, got %d", len(latest))
+	}
+	if latest[0].SnapshotID == nil || *latest[0].SnapshotID != "latest" || !latest[0].Timestamp.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("Expected the latest completed snapshot of the db plan, got %+v", latest[0])
+	}
+	if latest[1].PlanName != "files" || latest[1].SnapshotID != nil || latest[1].Timestamp != nil {
+		t.Errorf("Expected no snapshot of the files plan, got %+v", latest[1])
+	}
+
+	certs := db.CertificateRepository()
+	for domain, expiresIn := range map[string]time.Duration{"expired.example.com": -time.Hour, "soon.example.com": 7 * 24 * time.Hour, "later.example.com": 90 * 24 * time.Hour} {
+		cert := &Certificate{ID: domain, Domain: domain, NotBefore: now.Add(-30 * 24 * time.Hour), NotAfter: now.Add(expiresIn), CertPath: "cert.pem", KeyPath: "key.pem", Status: "valid"}
+		if err := certs.Create(cert); err != nil {
+			t.Fatalf("Failed to create certificate: %v", err)
+		}
+	}
+	expiring, err := certs.ListExpiring(now.Add(30 * 24 * time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to list expiring certificates: %v", err)
+	}
+	if len(expiring) != 2 || expiring[0].Domain != "expired.example.com" || expiring[1].Domain != "soon.example.com" {
+		t.Errorf("Expected the expired and soon expiring certificates, got %d", len(expiring))
+	}
+}
+
+func TestCertificateRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	repo := db.CertificateRepository()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	issuer := "issuer.pem"
+	cert := &Certificate{ID: "cert-1", Domain: "app.example.com", NotBefore: now.Add(-24 * time.Hour), NotAfter: now.Add(89 * 24 * time.Hour), CertPath: "app.crt", KeyPath: "app.key", IssuerPath: &issuer, Status: "valid", AutoRenew: true}
+	if err := repo.Create(cert); err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	if err := repo.Create(&Certificate{ID: "cert-2", Domain: "app.example.com", NotAfter: now, Status: "valid"}); err == nil {
+		t.Error("Expected a second certificate of the same domain to be rejected")
+	}
+
+	got, err := repo.GetByDomain("app.example.com")
+	if err != nil {
+		t.Fatalf("Failed to get certificate by domain: %v", err)
+	}
+	if got.ID != "cert-1" || !got.NotAfter.Equal(cert.NotAfter) || got.IssuerPath == nil || *got.IssuerPath != issuer || !got.AutoRenew {
+		t.Errorf("Unexpected certificate: %+v", got)
+	}
+	if _, err := repo.GetByDomain("missing.example.com"); !errors.Is(err, ErrCertificateNotFound) {
+		t.Errorf("Expected ErrCertificateNotFound, got %v", err)
+	}
+	if _, err := repo.GetByID("missing"); !errors.Is(err, ErrCertificateNotFound) {
+		t.Errorf("Expected ErrCertificateNotFound by ID, got %v", err)
+	}
+
+	// Saving a renewal replaces the record of the domain, keeping its ID
+	if err := repo.UpdateStatus("cert-1", "expired"); err != nil {
+		t.Fatalf("Failed to update certificate status: %v", err)
+	}
+	renewed := &Certificate{ID: "cert-renewed", Domain: "app.example.com", NotBefore: now, NotAfter: now.Add(90 * 24 * time.Hour), CertPath: "app.crt", KeyPath: "app.key", Status: "valid", AutoRenew: true}
+	if err := repo.Save(renewed); err != nil {
+		t.Fatalf("Failed to save renewed certificate: %v", err)
+	}
+	if renewed.ID != "cert-1" {
+		t.Errorf("Expected the renewal to keep ID cert-1, got %s", renewed.ID)
+	}
+	got, err = repo.GetByID("cert-1")
+	if err != nil {
+		t.Fatalf("Failed to get certificate: %v", err)
+	}
+	if got.Status != "valid" || !got.NotAfter.Equal(renewed.NotAfter) || got.IssuerPath != nil {
+		t.Errorf("Expected the renewed certificate, got %+v", got)
+	}
+	other := &Certificate{ID: "cert-3", Domain: "api.example.com", NotBefore: now, NotAfter: now.Add(10 * 24 * time.Hour), CertPath: "api.crt", KeyPath: "api.key", Status: "valid"}
+	if err := repo.Save(other); err != nil {
+		t.Fatalf("Failed to save new certificate: %v", err)
+	}
+
+	certs, err := repo.List()
+	if err != nil {
+		t.Fatalf("Failed to list certificates: %v", err)
+	}
+	if len(certs) != 2 || certs[0].Domain != "api.example.com" || certs[1].Domain != "app.example.com" {
+		t.Errorf("Expected both certificates soonest to expire first, got %d", len(certs))
+	}
+
+	if err := repo.UpdateStatus("missing", "revoked"); !errors.Is(err, ErrCertificateNotFound) {
+		t.Errorf("Expected ErrCertificateNotFound updating a missing certificate, got %v", err)
+	}
+	if err := repo.Delete("cert-3"); err != nil {
+		t.Fatalf("Failed to delete certificate: %v", err)
+	}
+	if err := repo.Delete("cert-3"); !errors.Is(err, ErrCertificateNotFound) {
+		t.Errorf("Expected ErrCertificateNotFound deleting twice, got %v", err)
+	}
+	if certs, _ := repo.List(); len(certs) != 1 {
+		t.Errorf("Expected 1 certificate after delete, got %d", len(certs))
+	}
+}
+
+func TestCertificateExpiringWithin(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	repo := db.CertificateRepository()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	within := 14 * 24 * time.Hour
+	for _, tt := range []struct {
+		domain   string
+		notAfter time.Time
+		status   string
+	}{
+		{domain: "past.example.com", notAfter: now.Add(-time.Second), status: "valid"},
+		{domain: "now.example.com", notAfter: now, status: "valid"},
+		{domain: "next.example.com", notAfter: now.Add(time.Millisecond), status: "valid"},
+		{domain: "edge.example.com", notAfter: now.Add(within), status: "valid"},
+		{domain: "beyond.example.com", notAfter: now.Add(within + time.Millisecond), status: "valid"},
+		{domain: "revoked.example.com", notAfter: now.Add(time.Hour), status: "revoked"},
+		{domain: "expired.example.com", notAfter: now.Add(time.Hour), status: "expired"},
+	} {
+		cert := &Certificate{ID: tt.domain, Domain: tt.domain, NotBefore: now.Add(-60 * 24 * time.Hour), NotAfter: tt.notAfter, CertPath: "cert.pem", KeyPath: "key.pem", Status: tt.status}
+		if err := repo.Create(cert); err != nil {
+			t.Fatalf("Failed to create certificate: %v", err)
+		}
+	}
+
+	// Only valid certificates still valid at now and expiring by now+within,
+	// inclusive, are listed
+	expiring, err := repo.ListExpiringWithin(now, within)
+	if err != nil {
+		t.Fatalf("Failed to list expiring certificates: %v", err)
+	}
+	var domains []string
+	for _, cert := range expiring {
+		domains = append(domains, cert.Domain)
+	}
+	if want := "next.example.com,edge.example.com"; strings.Join(domains, ",") != want {
+		t.Errorf("Expected %v, got %v", want, domains)
+	}
+
+	if expiring, err := repo.ListExpiringWithin(now, 0); err != nil || len(expiring) != 0 {
+		t.Errorf("Expected nothing expiring within 0, got %d (%v)", len(expiring), err)
+	}
+
+	// Certificates whose not_after has come are marked expired, once
+	marked, err := repo.MarkExpired(now)
+	if err != nil {
+		t.Fatalf("Failed to mark expired certificates: %v", err)
+	}
+	if marked != 2 {
+		t.Errorf("Expected 2 certificates marked expired, got %d", marked)
+	}
+	for domain, want := range map[string]string{"past.example.com": "expired", "now.example.com": "expired", "next.example.com": "valid", "revoked.example.com": "revoked"} {
+		cert, err := repo.GetByDomain(domain)
+		if err != nil {
+			t.Fatalf("Failed to get certificate: %v", err)
+		}
+		if cert.Status != want {
+			t.Errorf("Expected %s to be %s, got %s", domain, want, cert.Status)
+		}
+	}
+	if marked, _ := repo.MarkExpired(now); marked != 0 {
+		t.Errorf("Expected nothing left to mark, got %d", marked)
+	}
+}
+
+func TestSecretRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	seedTestUsers(t, db, 1)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	repo := db.SecretRepository(key)
+
+	createdBy := 1
+	secret := &Secret{Name: "db-password", Description: "Primary database", CreatedBy: &createdBy}
+	if err := repo.Set(secret, "hunter2"); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	if secret.CreatedAt.IsZero() || secret.UpdatedAt.IsZero() {
+		t.Errorf("Expected timestamps to be set, got %+v", secret)
+	}
+
+	value, err := repo.Get("db-password")
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if value != "hunter2" {
+		t.Errorf("Expected hunter2, got %q", value)
+	}
+
+	// Values are stored sealed
+	var stored []byte
+	if err := db.Get(&stored, "SELECT value FROM secrets WHERE name = ?", "db-password"); err != nil {
+		t.Fatalf("Failed to read stored value: %v", err)
+	}
+	if strings.Contains(string(stored), "hunter2") {
+		t.Errorf("Expected the stored value to be encrypted, got %q", stored)
+	}
+
+	// Updating keeps the creator and replaces the value
+	if err := repo.Set(&Secret{Name: "db-password", Description: "Rotated"}, "correct horse"); err != nil {
+		t.Fatalf("Failed to update secret: %v", err)
+	}
+	if value, _ := repo.Get("db-password"); value != "correct horse" {
+		t.Errorf("Expected the updated value, got %q", value)
+	}
+	meta, err := repo.GetMetadata("db-password")
+	if err != nil {
+		t.Fatalf("Failed to get secret metadata: %v", err)
+	}
+	if meta.Description != "Rotated" || meta.CreatedBy == nil || *meta.CreatedBy != 1 {
+		t.Errorf("Expected the description updated and the creator kept, got %+v", meta)
+	}
+
+	if err := repo.Set(&Secret{Name: "api-token"}, ""); err != nil {
+		t.Fatalf("Failed to set empty secret: %v", err)
+	}
+	secrets, err := repo.List()
+	if err != nil {
+		t.Fatalf("Failed to list secrets: %v", err)
+	}
+	if len(secrets) != 2 || secrets[0].Name != "api-token" || secrets[1].Name != "db-password" {
+		t.Errorf("Expected secrets listed by name, got %+v", secrets)
+	}
+
+	if _, err := repo.Get("missing"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+	if err := repo.Delete("api-token"); err != nil {
+		t.Fatalf("Failed to delete secret: %v", err)
+	}
+	if err := repo.Delete("api-token"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+}
+
+func TestSecretRepositoryKeys(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	if err := db.SecretRepository(key).Set(&Secret{Name: "token"}, "value"); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+
+	// Another key cannot open the value
+	other := []byte("fedcba9876543210fedcba9876543210")
+	if _, err := db.SecretRepository(other).Get("token"); !errors.Is(err, ErrSecretUnreadable) {
+		t.Errorf("Expected ErrSecretUnreadable, got %v", err)
+	}
+
+	// A value moved to another name does not open either
+	if _, err := db.Exec("UPDATE secrets SET name = 'moved' WHERE name = 'token'"); err != nil {
+		t.Fatalf("Failed to rename secret: %v", err)
+	}
+	if _, err := db.SecretRepository(key).Get("moved"); !errors.Is(err, ErrSecretUnreadable) {
+		t.Errorf("Expected ErrSecretUnreadable, got %v", err)
+	}
+
+	// Without a key values are unavailable, but secrets can be listed
+	noKey := db.SecretRepository(nil)
+	if _, err := noKey.Get("moved"); !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("Expected ErrNoMasterKey, got %v", err)
+	}
+	if err := noKey.Set(&Secret{Name: "token"}, "value"); !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("Expected ErrNoMasterKey, got %v", err)
+	}
+	if secrets, err := noKey.List(); err != nil || len(secrets) != 1 {
+		t.Errorf("Expected 1 secret listed, got %d (%v)", len(secrets), err)
+	}
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Console.Database.Path = filepath.Join(dir, "console.db")
+
+	// Readers do not create the key
+	if _, err := LoadMasterKey(cfg, false); !errors.Is(err, ErrNoMasterKey) {
+		t.Fatalf("Expected ErrNoMasterKey, got %v", err)
+	}
+
+	key, err := LoadMasterKey(cfg, true)
+	if err != nil {
+		t.Fatalf("Failed to generate master key: %v", err)
+	}
+	if len(key) != config.MasterKeySize {
+		t.Errorf("Expected a %d byte key, got %d", config.MasterKeySize, len(key))
+	}
+	path := filepath.Join(dir, "secrets.key")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected the key beside the database: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// Later loads return the same key
+	again, err := LoadMasterKey(cfg, false)
+	if err != nil {
+		t.Fatalf("Failed to load master key: %v", err)
+	}
+	if string(again) != string(key) {
+		t.Error("Expected the generated key to be loaded")
+	}
+
+	// A configured key takes precedence over the file
+	cfg.Secrets.MasterKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	configured, err := LoadMasterKey(cfg, true)
+	if err != nil {
+		t.Fatalf("Failed to load configured key: %v", err)
+	}
+	if string(configured) != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("Expected the configured key, got %q", configured)
+	}
+
+	// A damaged key file is an error, not replaced
+	cfg.Secrets.MasterKey = ""
+	if err := os.WriteFile(path, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("Failed to damage key file: %v", err)
+	}
+	if _, err := LoadMasterKey(cfg, true); err == nil {
+		t.Error("Expected an error for a damaged key file")
+	}
+}
+
+func TestReclaim(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Console.Database = config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db"), WALMode: true, Timeout: "30s"}
+	db, err := NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// New databases free deleted pages incrementally
+	var autoVacuum int
+	if err := db.Get(&autoVacuum, "PRAGMA auto_vacuum"); err != nil {
+		t.Fatalf("Failed to read auto_vacuum: %v", err)
+	}
+	if autoVacuum != 2 {
+		t.Errorf("Expected incremental auto_vacuum (2), got %d", autoVacuum)
+	}
+
+	for i := 0; i < 200; i++ {
+		if _, err := db.Exec("INSERT INTO audit_logs (action, resource_type, details, created_at) VALUES ('x', 'y', ?, ?)",
+			strings.Repeat("x", 2000), formatTimestamp(time.Now().AddDate(-2, 0, 0))); err != nil {
+			t.Fatalf("Failed to insert audit log: %v", err)
+		}
+	}
+	deleted, err := db.AuditLogRepository().DeleteOlderThan(time.Now().AddDate(-1, 0, 0))
+	if err != nil || deleted != 200 {
+		t.Fatalf("Expected 200 audit logs deleted, got %d (%v)", deleted, err)
+	}
+
+	if err := db.Reclaim(); err != nil {
+		t.Fatalf("Failed to reclaim space: %v", err)
+	}
+	var free int
+	if err := db.Get(&free, "PRAGMA freelist_count"); err != nil {
+		t.Fatalf("Failed to read freelist_count: %v", err)
+	}
+	if free != 0 {
+		t.Errorf("Expected the freed pages to be returned, %d left", free)
+	}
+}
+
+func TestNodeRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	repo := db.NodeRepository()
+	node := &Node{Name: "worker-1", Address: "10.0.0.2", Status: "ready", CPUCapacity: 4e9, PodCapacity: 10, Labels: `{"zone":"a"}`}
+	if err := repo.Register(node); err != nil {
+		t.Fatalf("Failed to register node: %v", err)
+	}
+	if node.ID == "" || node.LastHeartbeat == nil {
+		t.Fatalf("Expected an ID and heartbeat, got %+v", node)
+	}
+	id := node.ID
+
+	// Registering again by name updates the node and keeps its ID
+	again := &Node{Name: "worker-1", Address: "10.0.0.3", Status: "ready", CPUCapacity: 8e9, PodCapacity: 10}
+	if err := repo.Register(again); err != nil {
+		t.Fatalf("Failed to re-register node: %v", err)
+	}
+	if again.ID != id || again.Address != "10.0.0.3" || again.CPUCapacity != 8e9 {
+		t.Errorf("Expected node %s updated, got %+v", id, again)
+	}
+	labels, err := again.LabelMap()
+	if err != nil || len(labels) != 0 {
+		t.Errorf("Expected no labels, got %v (%v)", labels, err)
+	}
+
+	at := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := repo.Heartbeat(id, "ready", 1e9, 1<<30, 2, at); err != nil {
+		t.Fatalf("Failed to record heartbeat: %v", err)
+	}
+	if err := repo.SetStatus(id, "not_ready"); err != nil {
+		t.Fatalf("Failed to set node status: %v", err)
+	}
+	nodes, err := repo.List()
+	if err != nil {
+		t.Fatalf("Failed to list nodes: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].Status != "not_ready" || nodes[0].CPUUsed != 1e9 || nodes[0].PodsUsed != 2 || !nodes[0].LastHeartbeat.Equal(at) {
+		t.Errorf("Unexpected nodes: %+v", nodes[0])
+	}
+
+	if err := repo.SetStatus("missing", "ready"); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+	if _, err := repo.GetByName("missing"); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+}
+
+func TestServiceRevisionRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	service := &Service{ID: "svc-1", Name: "api", Image: "api:1", Port: 8080, Replicas: 1, Status: "running", Version: 1,
+		Environment: map[string]string{"MODE": "live"}}
+	if err := db.ServiceRepository().Create(service); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	repo := db.ServiceRevisionRepository()
+	if err := repo.Record(service, nil); err != nil {
+		t.Fatalf("Failed to record revision: %v", err)
+	}
+	// Recording a version again keeps the configuration it was recorded with
+	service.Image = "api:2"
+	if err := repo.Record(service, nil); err != nil {
+		t.Fatalf("Failed to record revision again: %v", err)
+	}
+	service.Version = 2
+	if err := repo.Record(service, nil); err != nil {
+		t.Fatalf("Failed to record revision: %v", err)
+	}
+
+	revisions, err := repo.List(service.ID)
+	if err != nil {
+		t.Fatalf("Failed to list revisions: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Revision != 2 || revisions[1].Revision != 1 {
+		t.Fatalf("Expected revisions 2 and 1, got %+v", revisions)
+	}
+
+	first, err := repo.Get(service.ID, 1)
+	if err != nil {
+		t.Fatalf("Failed to get revision: %v", err)
+	}
+	config, err := first.Service()
+	if err != nil {
+		t.Fatalf("Failed to decode revision: %v", err)
+	}
+	if config.Image != "api:1" || config.Environment["MODE"] != "live" {
+		t.Errorf("Expected the first configuration, got %+v", config)
+	}
+	if _, err := repo.Get(service.ID, 3); !errors.Is(err, ErrServiceRevisionNotFound) {
+		t.Errorf("Expected ErrServiceRevisionNotFound, got %v", err)
+	}
+
+	// Revisions go with their service
+	if err := db.ServiceRepository().Delete(service.ID); err != nil {
+		t.Fatalf("Failed to delete service: %v", err)
+	}
+	if revisions, _ := repo.List(service.ID); len(revisions) != 0 {
+		t.Errorf("Expected no revisions left, got %d", len(revisions))
+	}
+}
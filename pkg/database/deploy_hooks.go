@@ -0,0 +1,129 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrDeployHookNotFound is returned when a service has no deploy hook
+	ErrDeployHookNotFound = fmt.Errorf("deploy hook %w", ErrNotFound)
+	// ErrDeployHookReplayed is returned when a nonce was already used with
+	// a service's deploy hook
+	ErrDeployHookReplayed = errors.New("deploy hook nonce already used")
+)
+
+// DeployHookRepository provides database operations for deploy hooks. Their
+// secrets are sealed with AES-256-GCM under the secrets master key, with
+// the service's ID as additional data.
+type DeployHookRepository struct {
+	db  *DB
+	key []byte
+}
+
+// NewDeployHookRepository creates a new deploy hook repository sealing
+// secrets with key. Without a key, setting and reading hooks fails with
+// ErrNoMasterKey.
+func NewDeployHookRepository(db *DB, key []byte) *DeployHookRepository {
+	return &DeployHookRepository{db: db, key: key}
+}
+
+// Set gives a service a deploy hook with a secret, replacing any it had
+// along with the nonces it was used with
+func (r *DeployHookRepository) Set(hook *DeployHook, secret string) error {
+	aead, err := masterKeyAEAD(r.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), []byte(hook.ServiceID))
+
+	hook.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	hook.LastUsedAt = nil
+	return r.db.WithTx(r.db.context(), func(tx *DB) error {
+		if _, err := tx.Exec("DELETE FROM deploy_hooks WHERE service_id = ?", hook.ServiceID); err != nil {
+			return fmt.Errorf("failed to replace deploy hook: %w", err)
+		}
+		query := `
+			INSERT INTO deploy_hooks (service_id, secret, strategy, created_by, created_at)
+			VALUES (?, ?, ?, ?, ?)
+		`
+		if _, err := tx.Exec(query, hook.ServiceID, sealed, hook.Strategy, hook.CreatedBy, formatTimestamp(hook.CreatedAt)); err != nil {
+			return fmt.Errorf("failed to save deploy hook: %w", err)
+		}
+		return nil
+	})
+}
+
+// Get returns a service's deploy hook and its secret
+func (r *DeployHookRepository) Get(serviceID string) (*DeployHook, string, error) {
+	aead, err := masterKeyAEAD(r.key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var row struct {
+		DeployHook
+		Secret []byte `db:"secret"`
+	}
+	err = r.db.Get(&row, "SELECT * FROM deploy_hooks WHERE service_id = ?", serviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrDeployHookNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get deploy hook: %w", notFound(err))
+	}
+
+	if len(row.Secret) < aead.NonceSize() {
+		return nil, "", fmt.Errorf("%w: deploy hook of %s", ErrSecretUnreadable, serviceID)
+	}
+	secret, err := aead.Open(nil, row.Secret[:aead.NonceSize()], row.Secret[aead.NonceSize():], []byte(serviceID))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: deploy hook of %s", ErrSecretUnreadable, serviceID)
+	}
+	return &row.DeployHook, string(secret), nil
+}
+
+// UseNonce records a nonce a service's deploy hook was used with until it
+// expires, or returns ErrDeployHookReplayed when it was already used.
+// Expired nonces are forgotten.
+func (r *DeployHookRepository) UseNonce(serviceID, nonce string, expiresAt time.Time) error {
+	now := time.Now()
+	return r.db.WithTx(r.db.context(), func(tx *DB) error {
+		if _, err := tx.Exec("DELETE FROM deploy_hook_nonces WHERE expires_at <= ?", formatTimestamp(now)); err != nil {
+			return fmt.Errorf("failed to delete expired deploy hook nonces: %w", err)
+		}
+		result, err := tx.Exec(`
+			INSERT INTO deploy_hook_nonces (service_id, nonce, expires_at) VALUES (?, ?, ?)
+			ON CONFLICT(service_id, nonce) DO NOTHING
+		`, serviceID, nonce, formatTimestamp(expiresAt))
+		if err != nil {
+			return fmt.Errorf("failed to record deploy hook nonce: %w", err)
+		}
+		if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+			return ErrDeployHookReplayed
+		}
+		if _, err := tx.Exec("UPDATE deploy_hooks SET last_used_at = ? WHERE service_id = ?", formatTimestamp(now), serviceID); err != nil {
+			return fmt.Errorf("failed to update deploy hook last used time: %w", err)
+		}
+		return nil
+	})
+}
+
+// Delete removes a service's deploy hook
+func (r *DeployHookRepository) Delete(serviceID string) error {
+	result, err := r.db.Exec("DELETE FROM deploy_hooks WHERE service_id = ?", serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to delete deploy hook: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrDeployHookNotFound
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeployHookRepository(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	if err := db.ServiceRepository().Create(&Service{ID: "svc-a", Name: "svc-a", Image: "nginx", Port: 80, Status: "running"}); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	if _, _, err := db.DeployHookRepository(nil).Get("svc-a"); !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("Expected ErrNoMasterKey without a key, got %v", err)
+	}
+	repo := db.DeployHookRepository([]byte("0123456789abcdef0123456789abcdef"))
+	if _, _, err := repo.Get("svc-a"); !errors.Is(err, ErrDeployHookNotFound) {
+		t.Errorf("Expected ErrDeployHookNotFound, got %v", err)
+	}
+
+	if err := repo.Set(&DeployHook{ServiceID: "svc-a", Strategy: "recreate"}, "first"); err != nil {
+		t.Fatalf("Failed to set deploy hook: %v", err)
+	}
+	hook, secret, err := repo.Get("svc-a")
+	if err != nil {
+		t.Fatalf("Failed to get deploy hook: %v", err)
+	}
+	if secret != "first" || hook.Strategy != "recreate" || hook.LastUsedAt != nil {
+		t.Errorf("Unexpected deploy hook %+v with secret %q", hook, secret)
+	}
+
+	// Secrets are not readable under another key
+	if _, _, err := db.DeployHookRepository([]byte("fedcba9876543210fedcba9876543210")).Get("svc-a"); !errors.Is(err, ErrSecretUnreadable) {
+		t.Errorf("Expected ErrSecretUnreadable under another key, got %v", err)
+	}
+
+	// Nonces can only be used once until they expire
+	expiresAt := time.Now().Add(time.Minute)
+	if err := repo.UseNonce("svc-a", "n1", expiresAt); err != nil {
+		t.Fatalf("Failed to use nonce: %v", err)
+	}
+	if err := repo.UseNonce("svc-a", "n1", expiresAt); !errors.Is(err, ErrDeployHookReplayed) {
+		t.Errorf("Expected ErrDeployHookReplayed, got %v", err)
+	}
+	if hook, _, _ := repo.Get("svc-a"); hook.LastUsedAt == nil {
+		t.Error("Expected the last used time to be set")
+	}
+	if err := repo.UseNonce("svc-a", "n2", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Failed to use nonce: %v", err)
+	}
+	if err := repo.UseNonce("svc-a", "n2", expiresAt); err != nil {
+		t.Errorf("Expected an expired nonce to be forgotten, got %v", err)
+	}
+
+	// Replacing the hook replaces its secret and forgets its nonces
+	if err := repo.Set(&DeployHook{ServiceID: "svc-a"}, "second"); err != nil {
+		t.Fatalf("Failed to replace deploy hook: %v", err)
+	}
+	if _, secret, _ := repo.Get("svc-a"); secret != "second" {
+		t.Errorf("Expected the replaced secret, got %q", secret)
+	}
+	if err := repo.UseNonce("svc-a", "n1", expiresAt); err != nil {
+		t.Errorf("Expected nonces to be forgotten with the replaced hook, got %v", err)
+	}
+
+	if err := repo.Delete("svc-a"); err != nil {
+		t.Fatalf("Failed to delete deploy hook: %v", err)
+	}
+	if err := repo.Delete("svc-a"); !errors.Is(err, ErrDeployHookNotFound) {
+		t.Errorf("Expected ErrDeployHookNotFound, got %v", err)
+	}
+}
+
+func TestDeploymentTrigger(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	if err := db.ServiceRepository().Create(&Service{ID: "svc-a", Name: "svc-a", Image: "nginx", Port: 80, Status: "running"}); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	deployment := &Deployment{
+		ServiceID:     "svc-a",
+		Status:        "deploying",
+		Strategy:      "rolling",
+		Config:        "{}",
+		StartedAt:     time.Now(),
+		TriggerSource: stringPtr("deploy_hook"),
+		TriggeredBy:   stringPtr("ci #42"),
+		Notes:         stringPtr("release 1.2"),
+	}
+	if err := db.DeploymentRepository().Create(deployment); err != nil {
+		t.Fatalf("Failed to create deployment: %v", err)
+	}
+
+	got, err := db.DeploymentRepository().GetByID(deployment.ID)
+	if err != nil {
+		t.Fatalf("Failed to get deployment: %v", err)
+	}
+	if got.TriggerSource == nil || *got.TriggerSource != "deploy_hook" ||
+		got.TriggeredBy == nil || *got.TriggeredBy != "ci #42" ||
+		got.Notes == nil || *got.Notes != "release 1.2" {
+		t.Errorf("Unexpected deployment trigger %+v", got)
+	}
+}
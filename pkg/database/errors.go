@@ -0,0 +1,39 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is wrapped by the errors of lookups, updates and deletes of
+// rows that do not exist, including the not found errors of each resource
+// such as ErrSecretNotFound. Lookups wrapping it also still wrap
+// sql.ErrNoRows.
+var ErrNotFound = errors.New("not found")
+
+// IsNotFound reports whether err is the error of a row that does not exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// notFound wraps ErrNotFound into errors of lookups that found no row,
+// leaving other errors as they are
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
+
+// affectedRow returns ErrNotFound when a statement changed no row
+func affectedRow(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
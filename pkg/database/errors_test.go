@@ -0,0 +1,68 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestNotFoundErrors(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	lookups := map[string]func() error{
+		"user by ID":                func() error { _, err := db.UserRepository().GetByID(42); return err },
+		"user by username":          func() error { _, err := db.UserRepository().GetByUsername("nobody"); return err },
+		"user by email":             func() error { _, err := db.UserRepository().GetByEmail("nobody@example.com"); return err },
+		"service by ID":             func() error { _, err := db.ServiceRepository().GetByID("missing"); return err },
+		"service by name":           func() error { _, err := db.ServiceRepository().GetByName("missing"); return err },
+		"route by ID":               func() error { _, err := db.RouteRepository().GetByID("missing"); return err },
+		"registered service":        func() error { _, err := db.RegisteredServiceRepository().GetByID("missing"); return err },
+		"SSO session":               func() error { _, err := db.SSOSessionRepository().GetByTokenHash("missing"); return err },
+		"deployment":                func() error { _, err := db.DeploymentRepository().GetByID("missing"); return err },
+		"service template":          func() error { _, err := db.ServiceTemplateRepository().GetByID("missing"); return err },
+		"secret":                    func() error { _, err := db.SecretRepository(nil).GetMetadata("missing"); return err },
+		"organization":              func() error { _, err := db.OrganizationRepository().Get("missing"); return err },
+		"user update":               func() error { return db.UserRepository().Update(&User{ID: 42, Username: "nobody"}) },
+		"user delete":               func() error { return db.UserRepository().Delete(42) },
+		"user last login":           func() error { return db.UserRepository().UpdateLastLogin(42) },
+		"service update":            func() error { return db.ServiceRepository().Update(&Service{ID: "missing", Name: "missing"}) },
+		"service delete":            func() error { return db.ServiceRepository().Delete("missing") },
+		"route update":              func() error { return db.RouteRepository().Update(&Route{ID: "missing"}) },
+		"route delete":              func() error { return db.RouteRepository().Delete("missing") },
+		"registered service update": func() error { return db.RegisteredServiceRepository().Update(&RegisteredService{ID: "missing"}) },
+		"registered service delete": func() error { return db.RegisteredServiceRepository().Delete("missing") },
+		"deployment delete":         func() error { return db.DeploymentRepository().Delete("missing") },
+	}
+	for name, lookup := range lookups {
+		err := lookup()
+		if !IsNotFound(err) {
+			t.Errorf("%s: expected a not found error, got %v", name, err)
+		}
+	}
+
+	// Lookups still wrap sql.ErrNoRows for callers checking it
+	_, err := db.ServiceRepository().GetByID("missing")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the error to still wrap sql.ErrNoRows, got %v", err)
+	}
+
+	// Rows that exist are found, updated and deleted
+	user := &User{Username: "alice", Email: "alice@example.com", PasswordHash: "x", Role: "user"}
+	if err := db.UserRepository().Create(user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user.DisplayName = "Alice"
+	if err := db.UserRepository().Update(user); err != nil {
+		t.Errorf("Failed to update user: %v", err)
+	}
+	if err := db.UserRepository().Delete(user.ID); err != nil {
+		t.Errorf("Failed to delete user: %v", err)
+	}
+
+	// Other failures are not mistaken for missing rows
+	db.Close()
+	if _, err := db.UserRepository().GetByID(user.ID); err == nil || IsNotFound(err) {
+		t.Errorf("Expected a database error, got %v", err)
+	}
+}
@@ -0,0 +1,813 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// ConfigExportVersion is the version of the configuration export format. It
+// is raised whenever the format changes, and UpgradeConfigExport upgrades
+// documents of older versions.
+const ConfigExportVersion = 1
+
+// Import modes
+const (
+	// ImportMerge creates the resources of a document and updates existing
+	// ones of the same name, leaving other resources alone
+	ImportMerge = "merge"
+	// ImportReplace deletes every exported kind of resource before creating
+	// those of the document
+	ImportReplace = "replace"
+)
+
+// ErrInvalidConfigExport is returned when a configuration export cannot be
+// imported as a whole, such as one of an unsupported version
+var ErrInvalidConfigExport = errors.New("invalid configuration export")
+
+// ConfigExport is the configuration of an install: everything needed to move
+// it to a new host, other than users, which permissions refer to by username.
+// Secrets, such as password hashes, API keys, OAuth client secrets and signing
+// keys, are left out, as is runtime state, such as service status. Service
+// environments are included. Probes are held by the probe daemon rather than
+// the database, so the console exports and imports them through its API.
+type ConfigExport struct {
+	Version            int                         `json:"version" yaml:"version"`
+	ExportedAt         time.Time                   `json:"exported_at" yaml:"exported_at"`
+	Services           []ExportedService           `json:"services" yaml:"services"`
+	Routes             []ExportedRoute             `json:"routes" yaml:"routes"`
+	RegisteredServices []ExportedRegisteredService `json:"registered_services" yaml:"registered_services"`
+	Permissions        []ExportedPermission        `json:"permissions" yaml:"permissions"`
+	SnapPlans          []ExportedSnapPlan          `json:"snap_plans" yaml:"snap_plans"`
+	Probes             []ExportedProbe             `json:"probes" yaml:"probes"`
+}
+
+// ExportedService is a deployable service, identified by name
+type ExportedService struct {
+	Name        string            `db:"name" json:"name" yaml:"name"`
+	Image       string            `db:"image" json:"image" yaml:"image"`
+	Port        int               `db:"port" json:"port" yaml:"port"`
+	Replicas    int               `db:"replicas" json:"replicas" yaml:"replicas"`
+	Environment map[string]string `db:"-" json:"environment,omitempty" yaml:"environment,omitempty"`
+	Command     []string          `db:"-" json:"command,omitempty" yaml:"command,omitempty"`
+	Args        []string          `db:"-" json:"args,omitempty" yaml:"args,omitempty"`
+	YAMLConfig  string            `db:"yaml_config" json:"yaml_config,omitempty" yaml:"yaml_config,omitempty"`
+}
+
+// ExportedRoute is a gate route, identified by host and path prefix. Its
+// upstream is either a service, by name, or a URL. TLS certificates are
+// issued again on the new host.
+type ExportedRoute struct {
+	Host            string `db:"host" json:"host" yaml:"host"`
+	PathPrefix      string `db:"path_prefix" json:"path_prefix" yaml:"path_prefix"`
+	UpstreamService string `db:"upstream_service" json:"upstream_service,omitempty" yaml:"upstream_service,omitempty"`
+	UpstreamURL     string `db:"upstream_url" json:"upstream_url,omitempty" yaml:"upstream_url,omitempty"`
+}
+
+// ExportedRegisteredService is a service registered with the SSO gateway,
+// identified by name
+type ExportedRegisteredService struct {
+	Name         string  `db:"name" json:"name" yaml:"name"`
+	DisplayName  string  `db:"display_name" json:"display_name" yaml:"display_name"`
+	Description  *string `db:"description" json:"description,omitempty" yaml:"description,omitempty"`
+	ServiceURL   string  `db:"service_url" json:"service_url" yaml:"service_url"`
+	CallbackURL  *string `db:"callback_url" json:"callback_url,omitempty" yaml:"callback_url,omitempty"`
+	Icon         *string `db:"icon" json:"icon,omitempty" yaml:"icon,omitempty"`
+	Category     string  `db:"category" json:"category" yaml:"category"`
+	IsPublic     bool    `db:"is_public" json:"is_public" yaml:"is_public"`
+	RequiredRole string  `db:"required_role" json:"required_role" yaml:"required_role"`
+	Status       string  `db:"status" json:"status" yaml:"status"`
+	HealthURL    *string `db:"health_url" json:"health_url,omitempty" yaml:"health_url,omitempty"`
+	ProxyEnabled bool    `db:"proxy_enabled" json:"proxy_enabled" yaml:"proxy_enabled"`
+	ProxyPath    *string `db:"proxy_path" json:"proxy_path,omitempty" yaml:"proxy_path,omitempty"`
+}
+
+// ExportedPermission is a user's permission to access a registered service,
+// identified by username and service name
+type ExportedPermission struct {
+	Username  string     `db:"username" json:"username" yaml:"username"`
+	Service   string     `db:"service" json:"service" yaml:"service"`
+	CanAccess bool       `db:"can_access" json:"can_access" yaml:"can_access"`
+	ExpiresAt *time.Time `db:"expires_at" json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+}
+
+// ExportedSnapPlan is a scheduled snapshot plan, identified by name
+type ExportedSnapPlan struct {
+	Name           string   `db:"name" json:"name" yaml:"name"`
+	CronExpression string   `db:"cron_expression" json:"cron_expression" yaml:"cron_expression"`
+	Paths          []string `db:"-" json:"paths" yaml:"paths"`
+	KeepDaily      int      `db:"keep_daily" json:"keep_daily" yaml:"keep_daily"`
+	KeepWeekly     int      `db:"keep_weekly" json:"keep_weekly" yaml:"keep_weekly"`
+	KeepMonthly    int      `db:"keep_monthly" json:"keep_monthly" yaml:"keep_monthly"`
+	Enabled        bool     `db:"enabled" json:"enabled" yaml:"enabled"`
+}
+
+// ExportedProbe is a probe of the probe daemon, identified by name. A probe
+// bound to a service refers to it by name rather than ID.
+type ExportedProbe struct {
+	Name            string                   `json:"name" yaml:"name"`
+	Type            string                   `json:"type" yaml:"type"`
+	Target          string                   `json:"target,omitempty" yaml:"target,omitempty"`
+	Service         *ExportedProbeService    `json:"service,omitempty" yaml:"service,omitempty"`
+	Interval        string                   `json:"interval" yaml:"interval"`
+	Timeout         string                   `json:"timeout" yaml:"timeout"`
+	Retries         int                      `json:"retries" yaml:"retries"`
+	Enabled         bool                     `json:"enabled" yaml:"enabled"`
+	ExpectedStatus  int                      `json:"expected_status,omitempty" yaml:"expected_status,omitempty"`
+	ExpectedContent string                   `json:"expected_content,omitempty" yaml:"expected_content,omitempty"`
+	Headers         map[string]string        `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Thresholds      *ExportedProbeThresholds `json:"thresholds,omitempty" yaml:"thresholds,omitempty"`
+	Tags            []string                 `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Config          map[string]interface{}   `json:"config,omitempty" yaml:"config,omitempty"`
+}
+
+// ExportedProbeService is the service a probe is bound to: a registered
+// service or one run by the orchestrator, by name
+type ExportedProbeService struct {
+	Type     string `json:"type" yaml:"type"` // registered or managed
+	Name     string `json:"name" yaml:"name"`
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+}
+
+// ExportedProbeThresholds are the alert thresholds of a probe
+type ExportedProbeThresholds struct {
+	ResponseTime    string  `json:"response_time" yaml:"response_time"`
+	SuccessRate     float64 `json:"success_rate" yaml:"success_rate"`
+	ConsecutiveFail int     `json:"consecutive_fail" yaml:"consecutive_fail"`
+}
+
+// Probe service types, as the probe daemon names them
+const (
+	ProbeServiceRegistered = "registered"
+	ProbeServiceManaged    = "managed"
+)
+
+// serviceTable returns the table of the services a probe of this service type
+// is bound to
+func (s *ExportedProbeService) serviceTable() string {
+	if s.Type == ProbeServiceManaged {
+		return "services"
+	}
+	return "registered_services"
+}
+
+// ImportResult counts what an import did with one kind of resource
+type ImportResult struct {
+	Created  int      `json:"created"`
+	Updated  int      `json:"updated"`
+	Skipped  int      `json:"skipped"`
+	Errored  int      `json:"errored"`
+	Messages []string `json:"messages,omitempty"` // why resources were skipped or errored
+}
+
+func (r *ImportResult) skip(name, format string, args ...interface{}) {
+	r.Skipped++
+	r.Messages = append(r.Messages, name+": "+fmt.Sprintf(format, args...))
+}
+
+// Fail records a resource that cannot be imported, and why
+func (r *ImportResult) Fail(name, format string, args ...interface{}) {
+	r.Errored++
+	r.Messages = append(r.Messages, name+": "+fmt.Sprintf(format, args...))
+}
+
+// ImportReport is the outcome of an import. Nothing is applied when any
+// resource errored.
+type ImportReport struct {
+	Mode               string       `json:"mode"`
+	Applied            bool         `json:"applied"`
+	Services           ImportResult `json:"services"`
+	Routes             ImportResult `json:"routes"`
+	RegisteredServices ImportResult `json:"registered_services"`
+	Permissions        ImportResult `json:"permissions"`
+	SnapPlans          ImportResult `json:"snap_plans"`
+	Probes             ImportResult `json:"probes"`
+}
+
+// Errored returns how many resources errored
+func (r *ImportReport) Errored() int {
+	return r.Services.Errored + r.Routes.Errored + r.RegisteredServices.Errored + r.Permissions.Errored + r.SnapPlans.Errored + r.Probes.Errored
+}
+
+// UpgradeConfigExport checks the version of a configuration export, upgrading
+// documents of older versions to ConfigExportVersion
+func UpgradeConfigExport(doc *ConfigExport) error {
+	switch {
+	case doc.Version == 0:
+		return fmt.Errorf("%w: version is missing", ErrInvalidConfigExport)
+	case doc.Version > ConfigExportVersion:
+		return fmt.Errorf("%w: version %d is newer than the supported version %d", ErrInvalidConfigExport, doc.Version, ConfigExportVersion)
+	}
+	// Upgrades from older versions go here, one version at a time
+	return nil
+}
+
+// ExportConfig reads the configuration of the install, with every kind of
+// resource sorted by its name
+func (db *DB) ExportConfig() (*ConfigExport, error) {
+	doc, err := readConfigExport(db)
+	if err != nil {
+		return nil, err
+	}
+	doc.Version = ConfigExportVersion
+	doc.ExportedAt = time.Now().UTC()
+	return doc, nil
+}
+
+// readConfigExport reads every exported kind of resource
+func readConfigExport(q sqlx.Queryer) (*ConfigExport, error) {
+	doc := &ConfigExport{}
+
+	var services []struct {
+		ExportedService
+		EnvJSON  sql.NullString `db:"environment"`
+		CmdJSON  sql.NullString `db:"command"`
+		ArgsJSON sql.NullString `db:"args"`
+	}
+	err := sqlx.Select(q, &services, `SELECT name, image, port, replicas, environment, command, args,
+		COALESCE(yaml_config, '') AS yaml_config FROM services ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export services: %w", err)
+	}
+	doc.Services = make([]ExportedService, len(services))
+	for i, row := range services {
+		service := row.ExportedService
+		if err := unmarshalColumn(row.EnvJSON, &service.Environment); err != nil {
+			return nil, fmt.Errorf("failed to export service %s: %w", service.Name, err)
+		}
+		if err := unmarshalColumn(row.CmdJSON, &service.Command); err != nil {
+			return nil, fmt.Errorf("failed to export service %s: %w", service.Name, err)
+		}
+		if err := unmarshalColumn(row.ArgsJSON, &service.Args); err != nil {
+			return nil, fmt.Errorf("failed to export service %s: %w", service.Name, err)
+		}
+		service.normalize()
+		doc.Services[i] = service
+	}
+
+	err = sqlx.Select(q, &doc.Routes, `SELECT r.host, r.path_prefix, COALESCE(s.name, '') AS upstream_service,
+		COALESCE(r.upstream_url, '') AS upstream_url
+		FROM routes r LEFT JOIN services s ON s.id = r.upstream_service_id
+		ORDER BY r.host, r.path_prefix`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export routes: %w", err)
+	}
+
+	// Unreachable is the health checker's verdict rather than configuration
+	err = sqlx.Select(q, &doc.RegisteredServices, `SELECT name, display_name, description, service_url, callback_url, icon,
+		category, is_public, required_role, CASE status WHEN ? THEN ? ELSE status END AS status,
+		health_url, proxy_enabled, proxy_path FROM registered_services ORDER BY name`,
+		RegisteredServiceUnreachable, RegisteredServiceActive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export registered services: %w", err)
+	}
+
+	err = sqlx.Select(q, &doc.Permissions, `SELECT u.username, rs.name AS service, p.can_access, p.expires_at
+		FROM user_service_permissions p
+		JOIN users u ON u.id = p.user_id
+		JOIN registered_services rs ON rs.id = p.service_id
+		ORDER BY rs.name, u.username`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export permissions: %w", err)
+	}
+
+	var plans []struct {
+		ExportedSnapPlan
+		PathsJSON string `db:"paths"`
+	}
+	err = sqlx.Select(q, &plans, `SELECT name, cron_expression, paths, keep_daily, keep_weekly, keep_monthly,
+		COALESCE(enabled, FALSE) AS enabled FROM snap_plans ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export snapshot plans: %w", err)
+	}
+	doc.SnapPlans = make([]ExportedSnapPlan, len(plans))
+	for i, row := range plans {
+		plan := row.ExportedSnapPlan
+		if err := json.Unmarshal([]byte(row.PathsJSON), &plan.Paths); err != nil {
+			return nil, fmt.Errorf("failed to export snapshot plan %s: %w", plan.Name, err)
+		}
+		doc.SnapPlans[i] = plan
+	}
+
+	return doc, nil
+}
+
+// unmarshalColumn decodes a JSON column, leaving dest alone when it is empty
+func unmarshalColumn(column sql.NullString, dest interface{}) error {
+	if !column.Valid || column.String == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(column.String), dest)
+}
+
+// normalize drops empty collections, so that a service compares equal to its
+// copy in the database, which stores them as empty JSON
+func (s *ExportedService) normalize() {
+	if len(s.Environment) == 0 {
+		s.Environment = nil
+	}
+	if len(s.Command) == 0 {
+		s.Command = nil
+	}
+	if len(s.Args) == 0 {
+		s.Args = nil
+	}
+}
+
+// ImportConfig applies a configuration export in one transaction. In merge
+// mode resources are matched to existing ones by name, and those that are
+// unchanged are skipped. Permissions of users that do not exist are skipped,
+// since users are not exported. Cross-references are resolved by name after
+// the resources they refer to have been applied. When any resource errors,
+// the transaction is rolled back and the report says why. Probes are only
+// checked, including the services they are bound to; the caller applies them
+// to the probe daemon once the import is.
+func (db *DB) ImportConfig(doc *ConfigExport, mode string, importedBy int) (*ImportReport, error) {
+	if mode != ImportMerge && mode != ImportReplace {
+		return nil, fmt.Errorf("%w: unknown import mode %q", ErrInvalidConfigExport, mode)
+	}
+	if err := UpgradeConfigExport(doc); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTxx(db.context(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if mode == ImportReplace {
+		// Permissions and routes go with the services they refer to, but are
+		// deleted explicitly so that routes to URLs go as well
+		for _, table := range []string{"user_service_permissions", "routes", "registered_services", "services", "snap_plans"} {
+			if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+				return nil, fmt.Errorf("failed to clear %s: %w", table, err)
+			}
+		}
+	}
+
+	existing, err := readConfigExport(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	im := &importer{tx: tx, report: &ImportReport{Mode: mode}, importedBy: importedBy}
+	if err := im.services(doc.Services, existing.Services); err != nil {
+		return nil, err
+	}
+	if err := im.routes(doc.Routes, existing.Routes); err != nil {
+		return nil, err
+	}
+	if err := im.registeredServices(doc.RegisteredServices, existing.RegisteredServices); err != nil {
+		return nil, err
+	}
+	if err := im.permissions(doc.Permissions, existing.Permissions); err != nil {
+		return nil, err
+	}
+	if err := im.snapPlans(doc.SnapPlans, existing.SnapPlans); err != nil {
+		return nil, err
+	}
+	if err := im.probes(doc.Probes); err != nil {
+		return nil, err
+	}
+
+	if im.report.Errored() > 0 {
+		return im.report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit import: %w", err)
+	}
+	im.report.Applied = true
+	return im.report, nil
+}
+
+// importer applies the resources of a configuration export within a
+// transaction. Problems with single resources are recorded in the report;
+// the returned errors are those of the database.
+type importer struct {
+	tx         *sqlx.Tx
+	report     *ImportReport
+	importedBy int
+}
+
+// lookupID returns the ID of the row of table with the given name, or ""
+func (im *importer) lookupID(table, column, value string) (string, error) {
+	var id string
+	err := im.tx.Get(&id, fmt.Sprintf("SELECT CAST(id AS TEXT) FROM %s WHERE %s = ?", table, column), value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up %s %q: %w", table, value, err)
+	}
+	return id, nil
+}
+
+// duplicate reports a resource that appears twice in the document
+func duplicate(seen map[string]bool, key string) bool {
+	if seen[key] {
+		return true
+	}
+	seen[key] = true
+	return false
+}
+
+func (im *importer) services(services, existing []ExportedService) error {
+	result := &im.report.Services
+	current := make(map[string]ExportedService, len(existing))
+	for _, service := range existing {
+		current[service.Name] = service
+	}
+
+	seen := make(map[string]bool)
+	for _, service := range services {
+		service.normalize()
+		switch {
+		case service.Name == "":
+			result.Fail("(unnamed)", "name is required")
+			continue
+		case duplicate(seen, service.Name):
+			result.Fail(service.Name, "appears more than once")
+			continue
+		case service.Image == "":
+			result.Fail(service.Name, "image is required")
+			continue
+		case service.Port < 1 || service.Port > 65535:
+			result.Fail(service.Name, "port must be between 1 and 65535, got %d", service.Port)
+			continue
+		case service.Replicas < 0:
+			result.Fail(service.Name, "replicas must not be negative, got %d", service.Replicas)
+			continue
+		}
+
+		old, exists := current[service.Name]
+		if exists && reflect.DeepEqual(old, service) {
+			result.Skipped++
+			continue
+		}
+
+		row := &Service{Environment: service.Environment, Command: service.Command, Args: service.Args}
+		envJSON, err := row.MarshalEnvironment()
+		if err != nil {
+			return fmt.Errorf("failed to marshal environment: %w", err)
+		}
+		cmdJSON, err := row.MarshalCommand()
+		if err != nil {
+			return fmt.Errorf("failed to marshal command: %w", err)
+		}
+		argsJSON, err := row.MarshalArgs()
+		if err != nil {
+			return fmt.Errorf("failed to marshal args: %w", err)
+		}
+
+		if exists {
+			_, err = im.tx.Exec(`UPDATE services SET image = ?, port = ?, replicas = ?, environment = ?, command = ?, args = ?,
+				yaml_config = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE name = ?`,
+				service.Image, service.Port, service.Replicas, envJSON, cmdJSON, argsJSON, service.YAMLConfig, service.Name)
+			if err != nil {
+				result.Fail(service.Name, "%v", err)
+				continue
+			}
+			result.Updated++
+			continue
+		}
+
+		_, err = im.tx.Exec(`INSERT INTO services (id, name, image, port, replicas, environment, command, args, yaml_config)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			uuid.New().String(), service.Name, service.Image, service.Port, service.Replicas, envJSON, cmdJSON, argsJSON, service.YAMLConfig)
+		if err != nil {
+			result.Fail(service.Name, "%v", err)
+			continue
+		}
+		result.Created++
+	}
+	return nil
+}
+
+func (im *importer) routes(routes, existing []ExportedRoute) error {
+	result := &im.report.Routes
+	routeKey := func(route ExportedRoute) string { return route.Host + route.PathPrefix }
+	current := make(map[string]ExportedRoute, len(existing))
+	for _, route := range existing {
+		current[routeKey(route)] = route
+	}
+
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		if route.PathPrefix == "" {
+			route.PathPrefix = "/"
+		}
+		key := routeKey(route)
+		switch {
+		case route.Host == "":
+			result.Fail(key, "host is required")
+			continue
+		case !strings.HasPrefix(route.PathPrefix, "/"):
+			result.Fail(key, "path_prefix must start with /")
+			continue
+		case duplicate(seen, key):
+			result.Fail(key, "appears more than once")
+			continue
+		case (route.UpstreamService == "") == (route.UpstreamURL == ""):
+			result.Fail(key, "exactly one of upstream_service and upstream_url is required")
+			continue
+		}
+
+		var serviceID, upstreamURL *string
+		if route.UpstreamService != "" {
+			id, err := im.lookupID("services", "name", route.UpstreamService)
+			if err != nil {
+				return err
+			}
+			if id == "" {
+				result.Fail(key, "upstream service %q does not exist", route.UpstreamService)
+				continue
+			}
+			serviceID = &id
+		} else {
+			upstreamURL = &route.UpstreamURL
+		}
+
+		old, exists := current[key]
+		if exists && old == route {
+			result.Skipped++
+			continue
+		}
+
+		var err error
+		if exists {
+			_, err = im.tx.Exec(`UPDATE routes SET upstream_service_id = ?, upstream_url = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE host = ? AND path_prefix = ?`, serviceID, upstreamURL, route.Host, route.PathPrefix)
+		} else {
+			_, err = im.tx.Exec(`INSERT INTO routes (id, host, path_prefix, upstream_service_id, upstream_url) VALUES (?, ?, ?, ?, ?)`,
+				uuid.New().String(), route.Host, route.PathPrefix, serviceID, upstreamURL)
+		}
+		if err != nil {
+			result.Fail(key, "%v", err)
+			continue
+		}
+		if exists {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+	}
+	return nil
+}
+
+func (im *importer) registeredServices(services, existing []ExportedRegisteredService) error {
+	result := &im.report.RegisteredServices
+	current := make(map[string]ExportedRegisteredService, len(existing))
+	for _, service := range existing {
+		current[service.Name] = service
+	}
+
+	seen := make(map[string]bool)
+	for _, service := range services {
+		if service.Category == "" {
+			service.Category = "other"
+		}
+		if service.RequiredRole == "" {
+			service.RequiredRole = "user"
+		}
+		if service.Status == "" || service.Status == RegisteredServiceUnreachable {
+			service.Status = RegisteredServiceActive
+		}
+		switch {
+		case service.Name == "":
+			result.Fail("(unnamed)", "name is required")
+			continue
+		case duplicate(seen, service.Name):
+			result.Fail(service.Name, "appears more than once")
+			continue
+		case service.DisplayName == "" || service.ServiceURL == "":
+			result.Fail(service.Name, "display_name and service_url are required")
+			continue
+		case service.Status != RegisteredServiceActive && service.Status != RegisteredServiceInactive && service.Status != RegisteredServiceMaintenance:
+			result.Fail(service.Name, "unknown status %q", service.Status)
+			continue
+		}
+
+		old, exists := current[service.Name]
+		if exists && reflect.DeepEqual(old, service) {
+			result.Skipped++
+			continue
+		}
+
+		var err error
+		if exists {
+			_, err = im.tx.Exec(`UPDATE registered_services SET display_name = ?, description = ?, service_url = ?, callback_url = ?,
+				icon = ?, category = ?, is_public = ?, required_role = ?, status = ?, health_url = ?, proxy_enabled = ?, proxy_path = ?,
+				updated_at = CURRENT_TIMESTAMP WHERE name = ?`,
+				service.DisplayName, service.Description, service.ServiceURL, service.CallbackURL, service.Icon, service.Category,
+				service.IsPublic, service.RequiredRole, service.Status, service.HealthURL, service.ProxyEnabled, service.ProxyPath, service.Name)
+		} else {
+			_, err = im.tx.Exec(`INSERT INTO registered_services (id, name, display_name, description, service_url, callback_url,
+				icon, category, is_public, required_role, status, health_url, proxy_enabled, proxy_path)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				uuid.New().String(), service.Name, service.DisplayName, service.Description, service.ServiceURL, service.CallbackURL,
+				service.Icon, service.Category, service.IsPublic, service.RequiredRole, service.Status, service.HealthURL,
+				service.ProxyEnabled, service.ProxyPath)
+		}
+		if err != nil {
+			result.Fail(service.Name, "%v", err)
+			continue
+		}
+		if exists {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+	}
+	return nil
+}
+
+func (im *importer) permissions(permissions, existing []ExportedPermission) error {
+	result := &im.report.Permissions
+	permissionKey := func(p ExportedPermission) string { return p.Username + "@" + p.Service }
+	current := make(map[string]ExportedPermission, len(existing))
+	for _, permission := range existing {
+		current[permissionKey(permission)] = permission
+	}
+
+	seen := make(map[string]bool)
+	for _, permission := range permissions {
+		key := permissionKey(permission)
+		switch {
+		case permission.Username == "" || permission.Service == "":
+			result.Fail(key, "username and service are required")
+			continue
+		case duplicate(seen, key):
+			result.Fail(key, "appears more than once")
+			continue
+		}
+
+		serviceID, err := im.lookupID("registered_services", "name", permission.Service)
+		if err != nil {
+			return err
+		}
+		if serviceID == "" {
+			result.Fail(key, "registered service %q does not exist", permission.Service)
+			continue
+		}
+		userID, err := im.lookupID("users", "username", permission.Username)
+		if err != nil {
+			return err
+		}
+		if userID == "" {
+			result.skip(key, "user %q does not exist", permission.Username)
+			continue
+		}
+
+		old, exists := current[key]
+		if exists && old.CanAccess == permission.CanAccess && sameTime(old.ExpiresAt, permission.ExpiresAt) {
+			result.Skipped++
+			continue
+		}
+
+		if exists {
+			_, err = im.tx.Exec(`UPDATE user_service_permissions SET can_access = ?, expires_at = ?
+				WHERE user_id = ? AND service_id = ?`, permission.CanAccess, permission.ExpiresAt, userID, serviceID)
+		} else {
+			_, err = im.tx.Exec(`INSERT INTO user_service_permissions (user_id, service_id, can_access, granted_by, granted_at, expires_at)
+				VALUES (?, ?, ?, ?, ?, ?)`, userID, serviceID, permission.CanAccess, im.importedBy, time.Now(), permission.ExpiresAt)
+		}
+		if err != nil {
+			result.Fail(key, "%v", err)
+			continue
+		}
+		if exists {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+	}
+	return nil
+}
+
+// sameTime reports whether two optional times are the same instant
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func (im *importer) snapPlans(plans, existing []ExportedSnapPlan) error {
+	result := &im.report.SnapPlans
+	current := make(map[string]ExportedSnapPlan, len(existing))
+	for _, plan := range existing {
+		current[plan.Name] = plan
+	}
+
+	seen := make(map[string]bool)
+	for _, plan := range plans {
+		switch {
+		case plan.Name == "":
+			result.Fail("(unnamed)", "name is required")
+			continue
+		case duplicate(seen, plan.Name):
+			result.Fail(plan.Name, "appears more than once")
+			continue
+		case plan.CronExpression == "":
+			result.Fail(plan.Name, "cron_expression is required")
+			continue
+		case len(plan.Paths) == 0:
+			result.Fail(plan.Name, "at least one path is required")
+			continue
+		case plan.KeepDaily < 0 || plan.KeepWeekly < 0 || plan.KeepMonthly < 0:
+			result.Fail(plan.Name, "retention must not be negative")
+			continue
+		}
+
+		old, exists := current[plan.Name]
+		if exists && reflect.DeepEqual(old, plan) {
+			result.Skipped++
+			continue
+		}
+
+		paths, err := json.Marshal(plan.Paths)
+		if err != nil {
+			return fmt.Errorf("failed to marshal snapshot plan paths: %w", err)
+		}
+		if exists {
+			_, err = im.tx.Exec(`UPDATE snap_plans SET cron_expression = ?, paths = ?, keep_daily = ?, keep_weekly = ?,
+				keep_monthly = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP WHERE name = ?`,
+				plan.CronExpression, string(paths), plan.KeepDaily, plan.KeepWeekly, plan.KeepMonthly, plan.Enabled, plan.Name)
+		} else {
+			_, err = im.tx.Exec(`INSERT INTO snap_plans (id, name, cron_expression, paths, keep_daily, keep_weekly, keep_monthly, enabled)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				uuid.New().String(), plan.Name, plan.CronExpression, string(paths), plan.KeepDaily, plan.KeepWeekly, plan.KeepMonthly, plan.Enabled)
+		}
+		if err != nil {
+			result.Fail(plan.Name, "%v", err)
+			continue
+		}
+		if exists {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+	}
+	return nil
+}
+
+// probes checks the probes of a document, which are applied to the probe
+// daemon rather than the database
+func (im *importer) probes(probes []ExportedProbe) error {
+	result := &im.report.Probes
+	seen := make(map[string]bool)
+	for _, probe := range probes {
+		switch {
+		case probe.Name == "":
+			result.Fail("(unnamed)", "name is required")
+			continue
+		case duplicate(seen, probe.Name):
+			result.Fail(probe.Name, "appears more than once")
+			continue
+		case probe.Type == "":
+			result.Fail(probe.Name, "type is required")
+			continue
+		case probe.Target == "" && probe.Service == nil:
+			result.Fail(probe.Name, "target or service is required")
+			continue
+		}
+		if !validDuration(probe.Interval) || !validDuration(probe.Timeout) ||
+			(probe.Thresholds != nil && !validDuration(probe.Thresholds.ResponseTime)) {
+			result.Fail(probe.Name, "interval, timeout and response_time must be durations such as 30s")
+			continue
+		}
+
+		if service := probe.Service; service != nil {
+			if service.Type != ProbeServiceRegistered && service.Type != ProbeServiceManaged {
+				result.Fail(probe.Name, "service type must be %s or %s, got %q", ProbeServiceRegistered, ProbeServiceManaged, service.Type)
+				continue
+			}
+			id, err := im.lookupID(service.serviceTable(), "name", service.Name)
+			if err != nil {
+				return err
+			}
+			if id == "" {
+				result.Fail(probe.Name, "%s service %q does not exist", service.Type, service.Name)
+				continue
+			}
+		}
+	}
+	return nil
+}
+
+// validDuration reports whether value is empty or a duration
+func validDuration(value string) bool {
+	if value == "" {
+		return true
+	}
+	_, err := time.ParseDuration(value)
+	return err == nil
+}
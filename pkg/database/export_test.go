@@ -0,0 +1,286 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seedConfig fills a database with one resource of every exported kind,
+// plus a few that refer to each other
+func seedConfig(t *testing.T, db *DB) {
+	seedTestUsers(t, db, 1, 2)
+
+	services := db.ServiceRepository()
+	web := &Service{Name: "web", Image: "nginx:1.27", Port: 80, Replicas: 2, Status: "running",
+		Environment: map[string]string{"MODE": "prod"}, Command: []string{"nginx"}, Args: []string{"-g", "daemon off;"},
+		YAMLConfig: "name: web\nimage: nginx:1.27\nport: 80\n"}
+	api := &Service{Name: "api", Image: "api:2", Port: 9000, Replicas: 1, Status: "stopped"}
+	for _, service := range []*Service{web, api} {
+		if err := services.Create(service); err != nil {
+			t.Fatalf("Failed to seed service: %v", err)
+		}
+	}
+
+	upstream := "http://10.0.0.5:8080"
+	routes := db.RouteRepository()
+	for _, route := range []*Route{
+		{Host: "example.com", PathPrefix: "/", UpstreamServiceID: &web.ID},
+		{Host: "example.com", PathPrefix: "/api", UpstreamServiceID: &api.ID},
+		{Host: "legacy.example.com", PathPrefix: "/", UpstreamURL: &upstream},
+	} {
+		if err := routes.Create(route); err != nil {
+			t.Fatalf("Failed to seed route: %v", err)
+		}
+	}
+
+	description, proxyPath := "Metrics dashboards", "grafana"
+	registered := db.RegisteredServiceRepository()
+	grafana := &RegisteredService{Name: "grafana", DisplayName: "Grafana", Description: &description,
+		ServiceURL: "http://grafana:3000", Category: "monitoring", RequiredRole: "user", Status: RegisteredServiceUnreachable,
+		ProxyEnabled: true, ProxyPath: &proxyPath}
+	admin := &RegisteredService{Name: "admin-panel", DisplayName: "Admin", ServiceURL: "http://admin:8000",
+		Category: "admin", RequiredRole: "admin", Status: RegisteredServiceMaintenance, IsPublic: true}
+	for _, service := range []*RegisteredService{grafana, admin} {
+		if err := registered.Create(service); err != nil {
+			t.Fatalf("Failed to seed registered service: %v", err)
+		}
+	}
+
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	permissions := db.UserServicePermissionRepository()
+	if err := permissions.Grant(1, grafana.ID, 2, nil); err != nil {
+		t.Fatalf("Failed to seed permission: %v", err)
+	}
+	if err := permissions.Grant(2, admin.ID, 1, &expires); err != nil {
+		t.Fatalf("Failed to seed permission: %v", err)
+	}
+	if err := permissions.Revoke(2, admin.ID); err != nil {
+		t.Fatalf("Failed to seed permission: %v", err)
+	}
+
+	_, err := db.Exec(`INSERT INTO snap_plans (id, name, cron_expression, paths, keep_daily, keep_weekly, keep_monthly, enabled)
+		VALUES ('plan-1', 'nightly', '0 3 * * *', '["/srv/data","/etc/app"]', 7, 4, 6, TRUE)`)
+	if err != nil {
+		t.Fatalf("Failed to seed snapshot plan: %v", err)
+	}
+}
+
+// configTables reads the rows an import writes, with IDs replaced by the
+// names they refer to so that two databases can be compared
+func configTables(t *testing.T, db *DB) map[string][]map[string]interface{} {
+	queries := map[string]string{
+		"services": `SELECT name, image, port, replicas, environment, command, args, yaml_config FROM services ORDER BY name`,
+		"routes": `SELECT r.host, r.path_prefix, s.name AS service, r.upstream_url
+			FROM routes r LEFT JOIN services s ON s.id = r.upstream_service_id ORDER BY r.host, r.path_prefix`,
+		"registered_services": `SELECT name, display_name, description, service_url, callback_url, icon, category, is_public,
+			required_role, health_url, proxy_enabled, proxy_path FROM registered_services ORDER BY name`,
+		"permissions": `SELECT u.username, rs.name AS service, p.can_access, p.expires_at FROM user_service_permissions p
+			JOIN users u ON u.id = p.user_id JOIN registered_services rs ON rs.id = p.service_id ORDER BY rs.name, u.username`,
+		"snap_plans": `SELECT name, cron_expression, paths, keep_daily, keep_weekly, keep_monthly, enabled FROM snap_plans ORDER BY name`,
+	}
+
+	tables := make(map[string][]map[string]interface{})
+	for table, query := range queries {
+		rows, err := db.Queryx(query)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", table, err)
+		}
+		for rows.Next() {
+			row := make(map[string]interface{})
+			if err := rows.MapScan(row); err != nil {
+				t.Fatalf("Failed to scan %s: %v", table, err)
+			}
+			if expires, ok := row["expires_at"].(time.Time); ok {
+				row["expires_at"] = expires.UTC()
+			}
+			tables[table] = append(tables[table], row)
+		}
+		rows.Close()
+	}
+	return tables
+}
+
+func TestConfigExportRoundTrip(t *testing.T) {
+	source := createTestDB(t)
+	defer source.Close()
+	seedConfig(t, source)
+
+	doc, err := source.ExportConfig()
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if doc.Version != ConfigExportVersion {
+		t.Errorf("Expected version %d, got %d", ConfigExportVersion, doc.Version)
+	}
+	if doc.RegisteredServices[1].Status != RegisteredServiceActive {
+		t.Errorf("Expected unreachable to be exported as active, got %s", doc.RegisteredServices[1].Status)
+	}
+	if len(doc.Routes) != 3 || doc.Routes[1].UpstreamService != "api" {
+		t.Errorf("Expected routes to refer to services by name, got %+v", doc.Routes)
+	}
+
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Failed to encode export: %v", err)
+	}
+	for _, secret := range []string{"password_hash", `"hash"`, "totp"} {
+		if strings.Contains(string(encoded), secret) {
+			t.Errorf("Export should not contain %s", secret)
+		}
+	}
+	var decoded ConfigExport
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Failed to decode export: %v", err)
+	}
+
+	// Users are not exported, so the target has its own
+	target := createTestDB(t)
+	defer target.Close()
+	_, err = target.Exec(`INSERT INTO users (id, username, email, password_hash, role) VALUES
+		(7, 'seed-user-1', 'one@example.com', 'other', 'admin'), (8, 'seed-user-2', 'two@example.com', 'other', 'user')`)
+	if err != nil {
+		t.Fatalf("Failed to seed users: %v", err)
+	}
+
+	report, err := target.ImportConfig(&decoded, ImportMerge, 7)
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if !report.Applied || report.Errored() != 0 {
+		t.Fatalf("Expected the import to apply, got %+v", report)
+	}
+	if report.Services.Created != 2 || report.Routes.Created != 3 || report.RegisteredServices.Created != 2 ||
+		report.Permissions.Created != 2 || report.SnapPlans.Created != 1 {
+		t.Errorf("Expected every resource to be created, got %+v", report)
+	}
+
+	sourceTables, targetTables := configTables(t, source), configTables(t, target)
+	for table, rows := range sourceTables {
+		if !reflect.DeepEqual(rows, targetTables[table]) {
+			t.Errorf("Table %s differs after the round trip:\nsource: %v\ntarget: %v", table, rows, targetTables[table])
+		}
+	}
+
+	// Importing again changes nothing
+	report, err = target.ImportConfig(&decoded, ImportMerge, 7)
+	if err != nil {
+		t.Fatalf("Failed to import again: %v", err)
+	}
+	if report.Services.Skipped != 2 || report.Routes.Skipped != 3 || report.RegisteredServices.Skipped != 2 ||
+		report.Permissions.Skipped != 2 || report.SnapPlans.Skipped != 1 {
+		t.Errorf("Expected every resource to be skipped, got %+v", report)
+	}
+}
+
+func TestConfigImportMergeAndReplace(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	seedConfig(t, db)
+
+	doc := &ConfigExport{
+		Version: ConfigExportVersion,
+		Services: []ExportedService{
+			{Name: "web", Image: "nginx:1.28", Port: 80, Replicas: 3},
+			{Name: "worker", Image: "worker:1", Port: 9100, Replicas: 1},
+		},
+		Routes: []ExportedRoute{
+			{Host: "example.com", PathPrefix: "/api", UpstreamService: "worker"},
+		},
+		Permissions: []ExportedPermission{
+			{Username: "nobody", Service: "grafana", CanAccess: true},
+		},
+	}
+
+	before, err := db.ServiceRepository().GetByName("web")
+	if err != nil {
+		t.Fatalf("Failed to get web: %v", err)
+	}
+
+	report, err := db.ImportConfig(doc, ImportMerge, 1)
+	if err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	if !report.Applied || report.Services.Updated != 1 || report.Services.Created != 1 || report.Routes.Updated != 1 {
+		t.Fatalf("Expected web and the /api route to be updated and worker created, got %+v", report)
+	}
+	if report.Permissions.Skipped != 1 || len(report.Permissions.Messages) != 1 {
+		t.Errorf("Expected the permission of a missing user to be skipped with a reason, got %+v", report.Permissions)
+	}
+
+	web, err := db.ServiceRepository().GetByName("web")
+	if err != nil {
+		t.Fatalf("Failed to get web: %v", err)
+	}
+	if web.Image != "nginx:1.28" || web.Replicas != 3 || web.Version != before.Version+1 || web.Status != "running" {
+		t.Errorf("Expected web to be updated in place, got %+v", web)
+	}
+	routes, err := db.RouteRepository().List()
+	if err != nil || len(routes) != 3 {
+		t.Fatalf("Expected merging to keep the other routes, got %d (%v)", len(routes), err)
+	}
+
+	// The registered service the permission refers to goes too
+	doc.Permissions = nil
+	report, err = db.ImportConfig(doc, ImportReplace, 1)
+	if err != nil {
+		t.Fatalf("Failed to replace: %v", err)
+	}
+	if !report.Applied || report.Services.Created != 2 || report.Routes.Created != 1 {
+		t.Fatalf("Expected replacing to create everything afresh, got %+v", report)
+	}
+	tables := configTables(t, db)
+	if len(tables["services"]) != 2 || len(tables["routes"]) != 1 || len(tables["registered_services"]) != 0 || len(tables["snap_plans"]) != 0 {
+		t.Errorf("Expected replacing to remove what the document lacks, got %v", tables)
+	}
+}
+
+func TestConfigImportRollsBackOnErrors(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	seedConfig(t, db)
+	before := configTables(t, db)
+
+	doc := &ConfigExport{
+		Version: ConfigExportVersion,
+		Services: []ExportedService{
+			{Name: "new", Image: "new:1", Port: 8080},
+			{Name: "broken", Port: 8080},
+		},
+		Routes: []ExportedRoute{
+			{Host: "example.com", PathPrefix: "/missing", UpstreamService: "missing"},
+		},
+		Probes: []ExportedProbe{
+			{Name: "new port", Type: "tcp", Service: &ExportedProbeService{Type: ProbeServiceManaged, Name: "new"}},
+			{Name: "missing port", Type: "tcp", Service: &ExportedProbeService{Type: ProbeServiceManaged, Name: "missing"}},
+			{Name: "slow", Type: "http", Target: "https://example.com", Interval: "often"},
+		},
+	}
+	report, err := db.ImportConfig(doc, ImportReplace, 1)
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if report.Applied || report.Services.Errored != 1 || report.Routes.Errored != 1 || report.Services.Created != 1 ||
+		report.Probes.Errored != 2 {
+		t.Fatalf("Expected the import to be rejected with four errors, got %+v", report)
+	}
+	if !reflect.DeepEqual(before, configTables(t, db)) {
+		t.Error("Expected a rejected import to change nothing")
+	}
+
+	_, err = db.ImportConfig(&ConfigExport{Version: ConfigExportVersion + 1}, ImportMerge, 1)
+	if !errors.Is(err, ErrInvalidConfigExport) {
+		t.Errorf("Expected a newer version to be rejected, got %v", err)
+	}
+	_, err = db.ImportConfig(&ConfigExport{}, ImportMerge, 1)
+	if !errors.Is(err, ErrInvalidConfigExport) {
+		t.Errorf("Expected a missing version to be rejected, got %v", err)
+	}
+	_, err = db.ImportConfig(&ConfigExport{Version: ConfigExportVersion}, "append", 1)
+	if !errors.Is(err, ErrInvalidConfigExport) {
+		t.Errorf("Expected an unknown mode to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,555 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migrationFiles holds the SQL migrations, named <version>_<name>.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a single versioned schema change. SQL migrations are loaded
+// from migrationFiles; Go migrations set Up for changes SQL cannot express.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+	Up      func(tx *sqlx.Tx) error
+}
+
+// goMigrations are the migrations written in Go
+var goMigrations = []Migration{
+	{Version: 2, Name: "add_legacy_columns", Up: addLegacyColumns},
+	{Version: 4, Name: "deployment_records", Up: addDeploymentRecordColumns},
+	{Version: 7, Name: "snap_blocks", Up: addSnapBlocks},
+	{Version: 8, Name: "snapshot_parents", Up: addSnapshotParentColumns},
+	{Version: 9, Name: "service_failure_streak", Up: addServiceFailureStreakColumns},
+	{Version: 12, Name: "registered_service_proxy", Up: addServiceProxyColumns},
+	{Version: 13, Name: "user_display_name", Up: addUserDisplayNameColumns},
+	{Version: 17, Name: "sso_launch_tokens", Up: addSSOLaunchTokens},
+	{Version: 20, Name: "audit_impersonator", Up: addAuditImpersonatorColumns},
+	{Version: 22, Name: "organizations", Up: addOrganizations},
+	{Version: 23, Name: "deploy_hooks", Up: addDeployHooks},
+	{Version: 24, Name: "registered_service_is_healthy", Up: addServiceIsHealthyColumns},
+	{Version: 26, Name: "probe_alert_org", Up: addProbeAlertOrgColumns},
+}
+
+// AppliedMigration records a migration applied to the database
+type AppliedMigration struct {
+	Version   int       `db:"version" json:"version"`
+	Name      string    `db:"name" json:"name"`
+	AppliedAt time.Time `db:"applied_at" json:"applied_at"`
+}
+
+// MigrationStatus reports the schema version of the database against the
+// latest migration known to this build
+type MigrationStatus struct {
+	CurrentVersion int                `json:"current_version"`
+	LatestVersion  int                `json:"latest_version"`
+	Pending        []int              `json:"pending"`
+	Applied        []AppliedMigration `json:"applied"`
+}
+
+// UpToDate reports whether every known migration has been applied
+func (s *MigrationStatus) UpToDate() bool {
+	return len(s.Pending) == 0
+}
+
+// loadMigrations returns all migrations ordered by version
+func loadMigrations() ([]Migration, error) {
+	migrations := append([]Migration(nil), goMigrations...)
+
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		versionPart, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionPart)
+		if !found || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %s, expected <version>_<name>.sql", file)
+		}
+
+		contents, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", file, err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(contents)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// ensureMigrationsTable creates the table recording applied migrations
+func (db *DB) ensureMigrationsTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// Migrate applies pending migrations in version order, each in its own
+// transaction
+func (db *DB) Migrate(ctx context.Context) error {
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	if status.UpToDate() {
+		return nil
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[int]bool, len(status.Pending))
+	for _, version := range status.Pending {
+		pending[version] = true
+	}
+
+	for _, m := range migrations {
+		if !pending[m.Version] {
+			continue
+		}
+		if err := db.applyMigration(ctx, m); err != nil {
+			return err
+		}
+		log.Printf("🔄 Applied database migration %03d_%s", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+// applyMigration runs a migration and records it in one transaction
+func (db *DB) applyMigration(ctx context.Context, m Migration) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %w", m.Version, err)
+	}
+	defer tx.Rollback()
+
+	if m.SQL != "" {
+		if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+			return fmt.Errorf("failed to apply migration %03d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	if m.Up != nil {
+		if err := m.Up(tx); err != nil {
+			return fmt.Errorf("failed to apply migration %03d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, formatTimestamp(time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", m.Version, err)
+	}
+	return nil
+}
+
+// MigrationStatus reports which migrations have been applied and which are pending
+func (db *DB) MigrationStatus() (*MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MigrationStatus{Pending: []int{}, Applied: []AppliedMigration{}}
+	if len(migrations) > 0 {
+		status.LatestVersion = migrations[len(migrations)-1].Version
+	}
+
+	var exists int
+	if err := db.Get(&exists, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'"); err != nil {
+		return nil, fmt.Errorf("failed to check for schema_migrations table: %w", err)
+	}
+	if exists > 0 {
+		if err := db.Select(&status.Applied, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version"); err != nil {
+			return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+		}
+	}
+
+	applied := make(map[int]bool, len(status.Applied))
+	for _, m := range status.Applied {
+		applied[m.Version] = true
+		if m.Version > status.CurrentVersion {
+			status.CurrentVersion = m.Version
+		}
+	}
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			status.Pending = append(status.Pending, m.Version)
+		}
+	}
+
+	return status, nil
+}
+
+// tableColumn is a column added to an existing table
+type tableColumn struct {
+	table      string
+	column     string
+	definition string
+}
+
+// legacyColumns lists columns added to tables before versioned migrations
+// existed. Databases created by those versions may lack them, since the
+// initial migration only creates tables that are missing.
+var legacyColumns = []tableColumn{
+	{table: "users", column: "totp_enabled", definition: "BOOLEAN NOT NULL DEFAULT 0"},
+}
+
+// deploymentRecordColumns keep the resolved deploy config and log lines of
+// deployments, so the orchestrator can reload them and roll back to them
+var deploymentRecordColumns = []tableColumn{
+	{table: "deployments", column: "strategy", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "deployments", column: "config", definition: "TEXT NOT NULL DEFAULT '{}'"}, // JSON deploy config
+	{table: "deployments", column: "logs", definition: "TEXT NOT NULL DEFAULT '[]'"},   // JSON array of log lines
+	{table: "deployments", column: "updated_at", definition: "DATETIME"},
+}
+
+// snapBlockColumns mark the snapshots whose block references are counted in
+// snap_blocks. Those recorded before references were counted have theirs
+// counted from their manifests.
+var snapBlockColumns = []tableColumn{
+	{table: "snapshots", column: "refs_counted", definition: "BOOLEAN NOT NULL DEFAULT 0"},
+}
+
+// snapshotParentColumns link an incremental snapshot to the snapshot it was
+// taken against. Snapshots without a parent are full.
+var snapshotParentColumns = []tableColumn{
+	{table: "snapshots", column: "parent_id", definition: "TEXT"},
+}
+
+// serviceFailureStreakColumns count the consecutive failed health checks of
+// registered services
+var serviceFailureStreakColumns = []tableColumn{
+	{table: "registered_services", column: "failure_streak", definition: "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// serviceProxyColumns let the console proxy requests to registered services
+// under a path of its own, injecting the identity of the signed in user
+var serviceProxyColumns = []tableColumn{
+	{table: "registered_services", column: "proxy_enabled", definition: "BOOLEAN NOT NULL DEFAULT 0"},
+	{table: "registered_services", column: "proxy_path", definition: "TEXT"},
+}
+
+// userDisplayNameColumns hold the name users choose to be shown by, which
+// unlike the username they can change themselves
+var userDisplayNameColumns = []tableColumn{
+	{table: "users", column: "display_name", definition: "TEXT NOT NULL DEFAULT ''"},
+}
+
+// ssoHandoffColumns record where the last launch token of an SSO session was
+// redeemed
+var ssoHandoffColumns = []tableColumn{
+	{table: "sso_sessions", column: "last_handoff_ip", definition: "TEXT"},
+	{table: "sso_sessions", column: "last_handoff_at", definition: "DATETIME"},
+}
+
+// auditImpersonatorColumns record the admin who acted as the user of an
+// audit entry with an impersonation token
+var auditImpersonatorColumns = []tableColumn{
+	{table: "audit_logs", column: "impersonator_id", definition: "INTEGER"},
+}
+
+// orgColumns assign services, routes, registered services and snapshot
+// plans to the organization that owns them. Rows without one are only seen
+// by global admins when multi-tenancy is enabled.
+var orgColumns = []tableColumn{
+	{table: "services", column: "org_id", definition: "TEXT REFERENCES organizations(id)"},
+	{table: "routes", column: "org_id", definition: "TEXT REFERENCES organizations(id)"},
+	{table: "registered_services", column: "org_id", definition: "TEXT REFERENCES organizations(id)"},
+	{table: "snap_plans", column: "org_id", definition: "TEXT REFERENCES organizations(id)"},
+}
+
+// deployTriggerColumns record what started a deployment, such as a deploy
+// hook, and the notes it came with
+var deployTriggerColumns = []tableColumn{
+	{table: "deployments", column: "trigger_source", definition: "TEXT"},
+	{table: "deployments", column: "triggered_by", definition: "TEXT"},
+	{table: "deployments", column: "notes", definition: "TEXT"},
+}
+
+// serviceIsHealthyColumns hold the result of the latest health check of
+// registered services, NULL until they are first checked
+var serviceIsHealthyColumns = []tableColumn{
+	{table: "registered_services", column: "is_healthy", definition: "BOOLEAN"},
+}
+
+// probeAlertOrgColumns record the organization owning the probe an alert
+// was raised by, NULL for the monitor's own probes and for alerts recorded
+// before organizations owned probes
+var probeAlertOrgColumns = []tableColumn{
+	{table: "probe_alerts", column: "org_id", definition: "TEXT"},
+}
+
+// addLegacyColumns adds any legacyColumns missing from existing tables
+func addLegacyColumns(tx *sqlx.Tx) error {
+	return addMissingColumns(tx, legacyColumns)
+}
+
+// addDeploymentRecordColumns adds the deploymentRecordColumns. They are
+// checked for like legacy columns so that a database adopted without
+// migration history can be migrated again.
+func addDeploymentRecordColumns(tx *sqlx.Tx) error {
+	if err := addMissingColumns(tx, deploymentRecordColumns); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_deployments_service_version ON deployments(service_id, version)"); err != nil {
+		return fmt.Errorf("failed to create deployments index: %w", err)
+	}
+	return nil
+}
+
+// addMissingColumns adds the columns that existing tables lack
+func addMissingColumns(tx *sqlx.Tx, columns []tableColumn) error {
+	for _, c := range columns {
+		var count int
+		if err := tx.Get(&count, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", c.table, c.column); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", c.table, err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	return nil
+}
+
+// addSnapBlocks creates the snap_blocks table, which counts how many
+// snapshots reference each block of the snap repository so that blocks no
+// snapshot references can be removed, and adds the snapBlockColumns
+func addSnapBlocks(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS snap_blocks (
+			hash TEXT PRIMARY KEY, -- SHA-256 of the block
+			ref_count INTEGER NOT NULL DEFAULT 0
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create snap_blocks table: %w", err)
+	}
+	return addMissingColumns(tx, snapBlockColumns)
+}
+
+// addSnapshotParentColumns adds the snapshotParentColumns
+func addSnapshotParentColumns(tx *sqlx.Tx) error {
+	return addMissingColumns(tx, snapshotParentColumns)
+}
+
+// addServiceFailureStreakColumns adds the serviceFailureStreakColumns
+func addServiceFailureStreakColumns(tx *sqlx.Tx) error {
+	return addMissingColumns(tx, serviceFailureStreakColumns)
+}
+
+// addServiceProxyColumns adds the serviceProxyColumns. Proxy paths are
+// unique, as they select the service requests are proxied to.
+func addServiceProxyColumns(tx *sqlx.Tx) error {
+	if err := addMissingColumns(tx, serviceProxyColumns); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_registered_services_proxy_path ON registered_services(proxy_path)"); err != nil {
+		return fmt.Errorf("failed to create registered_services proxy path index: %w", err)
+	}
+	return nil
+}
+
+// addUserDisplayNameColumns adds the userDisplayNameColumns
+func addUserDisplayNameColumns(tx *sqlx.Tx) error {
+	return addMissingColumns(tx, userDisplayNameColumns)
+}
+
+// addSSOLaunchTokens creates the sso_launch_tokens table, which makes the SSO
+// tokens of service launch URLs single-use, and adds the ssoHandoffColumns
+func addSSOLaunchTokens(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS sso_launch_tokens (
+			token_hash TEXT PRIMARY KEY, -- SHA-256 of the token
+			user_id INTEGER NOT NULL,
+			service_id TEXT NOT NULL,
+			session_id TEXT,
+			expires_at DATETIME NOT NULL,
+			consumed_at DATETIME,
+			consumed_ip TEXT,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (service_id) REFERENCES registered_services(id) ON DELETE CASCADE,
+			FOREIGN KEY (session_id) REFERENCES sso_sessions(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create sso_launch_tokens table: %w", err)
+	}
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_sso_launch_tokens_expires_at ON sso_launch_tokens(expires_at)"); err != nil {
+		return fmt.Errorf("failed to create sso_launch_tokens index: %w", err)
+	}
+	return addMissingColumns(tx, ssoHandoffColumns)
+}
+
+// addAuditImpersonatorColumns adds the auditImpersonatorColumns
+func addAuditImpersonatorColumns(tx *sqlx.Tx) error {
+	return addMissingColumns(tx, auditImpersonatorColumns)
+}
+
+// addOrganizations creates the organizations and organization_members
+// tables and the default organization, and adds the orgColumns. Existing
+// rows are assigned to the default organization.
+func addOrganizations(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS organizations (
+			id TEXT PRIMARY KEY, -- slug, such as "default"
+			display_name TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create organizations table: %w", err)
+	}
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS organization_members (
+			org_id TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			role TEXT NOT NULL DEFAULT 'member', -- org_admin, member
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (org_id, user_id),
+			FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create organization_members table: %w", err)
+	}
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_organization_members_user_id ON organization_members(user_id)"); err != nil {
+		return fmt.Errorf("failed to create organization_members index: %w", err)
+	}
+	if _, err := tx.Exec("INSERT OR IGNORE INTO organizations (id, display_name, created_at) VALUES (?, ?, ?)",
+		DefaultOrgID, "Default", formatTimestamp(time.Now())); err != nil {
+		return fmt.Errorf("failed to create default organization: %w", err)
+	}
+
+	if err := addMissingColumns(tx, orgColumns); err != nil {
+		return err
+	}
+	for _, c := range orgColumns {
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET org_id = ? WHERE org_id IS NULL", c.table), DefaultOrgID); err != nil {
+			return fmt.Errorf("failed to assign %s to the default organization: %w", c.table, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_org_id ON %s(org_id)", c.table, c.table)); err != nil {
+			return fmt.Errorf("failed to create %s organization index: %w", c.table, err)
+		}
+	}
+	return nil
+}
+
+// addDeployHooks creates the tables of the signed webhooks CI deploys
+// services with, and the nonces they were used with, and records what
+// triggered deployments
+func addDeployHooks(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS deploy_hooks (
+			service_id TEXT PRIMARY KEY,
+			secret BLOB NOT NULL, -- sealed with the secrets master key
+			strategy TEXT NOT NULL DEFAULT '',
+			created_by INTEGER,
+			created_at DATETIME NOT NULL,
+			last_used_at DATETIME,
+			FOREIGN KEY (service_id) REFERENCES services(id) ON DELETE CASCADE,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create deploy_hooks table: %w", err)
+	}
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS deploy_hook_nonces (
+			service_id TEXT NOT NULL,
+			nonce TEXT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			PRIMARY KEY (service_id, nonce),
+			FOREIGN KEY (service_id) REFERENCES deploy_hooks(service_id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create deploy_hook_nonces table: %w", err)
+	}
+	return addMissingColumns(tx, deployTriggerColumns)
+}
+
+// addServiceIsHealthyColumns adds the serviceIsHealthyColumns, filled from
+// the latest health check of each service. Services last checked unhealthy
+// had their last healthy time cleared, so it is restored from their checks.
+func addServiceIsHealthyColumns(tx *sqlx.Tx) error {
+	if err := addMissingColumns(tx, serviceIsHealthyColumns); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_service_health_checks_service_checked_at ON service_health_checks(service_id, checked_at)"); err != nil {
+		return fmt.Errorf("failed to create service health check index: %w", err)
+	}
+	_, err := tx.Exec(`
+		UPDATE registered_services SET
+			is_healthy = (
+				SELECT is_healthy FROM service_health_checks
+				WHERE service_id = registered_services.id
+				ORDER BY checked_at DESC, id DESC LIMIT 1
+			),
+			last_healthy = COALESCE(last_healthy, (
+				SELECT MAX(checked_at) FROM service_health_checks
+				WHERE service_id = registered_services.id AND is_healthy
+			))
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to backfill service health: %w", err)
+	}
+	return nil
+}
+
+// addProbeAlertOrgColumns adds the probeAlertOrgColumns
+func addProbeAlertOrgColumns(tx *sqlx.Tx) error {
+	if err := addMissingColumns(tx, probeAlertOrgColumns); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_probe_alerts_org_id ON probe_alerts(org_id)"); err != nil {
+		return fmt.Errorf("failed to create probe alert organization index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+-- Raw metrics rolled up into 5-minute buckets so history outlives the raw
+-- data retention. The sum of squares lets queries approximate percentiles.
+CREATE TABLE IF NOT EXISTS metrics_rollup (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	bucket_start DATETIME NOT NULL,
+	scope_type TEXT NOT NULL,
+	scope_id TEXT NOT NULL,
+	metric_name TEXT NOT NULL,
+	sample_count INTEGER NOT NULL,
+	value_sum REAL NOT NULL,
+	value_min REAL NOT NULL,
+	value_max REAL NOT NULL,
+	value_sum_squares REAL NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(scope_type, scope_id, metric_name, bucket_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_metrics_series_timestamp ON metrics(scope_type, scope_id, metric_name, timestamp);
+CREATE INDEX IF NOT EXISTS idx_metrics_rollup_bucket_start ON metrics_rollup(bucket_start);
@@ -0,0 +1,13 @@
+-- Runs of scheduled snapshot plans, including the ones skipped because the
+-- previous run of the plan was still in progress
+CREATE TABLE IF NOT EXISTS snap_plan_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	plan_id TEXT NOT NULL,
+	scheduled_at DATETIME NOT NULL,
+	status TEXT NOT NULL, -- triggered, skipped
+	snapshot_id TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (plan_id) REFERENCES snap_plans(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_snap_plan_runs_plan_id ON snap_plan_runs(plan_id, scheduled_at);
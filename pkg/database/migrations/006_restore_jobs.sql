@@ -0,0 +1,21 @@
+-- Snapshot restores, so their outcome outlives the snap service
+CREATE TABLE IF NOT EXISTS restore_jobs (
+	id TEXT PRIMARY KEY,
+	snapshot_id TEXT NOT NULL,
+	target_path TEXT NOT NULL,
+	output_path TEXT NOT NULL, -- target_path, or the shadow directory
+	restore_mode TEXT NOT NULL, -- full, shadow
+	status TEXT NOT NULL, -- pending, running, completed, failed, cancelled
+	progress REAL NOT NULL DEFAULT 0,
+	message TEXT NOT NULL DEFAULT '',
+	total_files INTEGER NOT NULL DEFAULT 0,
+	files_restored INTEGER NOT NULL DEFAULT 0,
+	total_bytes INTEGER NOT NULL DEFAULT 0,
+	bytes_restored INTEGER NOT NULL DEFAULT 0,
+	errors TEXT NOT NULL DEFAULT '[]', -- JSON array of the files that failed
+	started_at DATETIME NOT NULL,
+	completed_at DATETIME,
+	FOREIGN KEY (snapshot_id) REFERENCES snapshots(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_restore_jobs_snapshot_id ON restore_jobs(snapshot_id);
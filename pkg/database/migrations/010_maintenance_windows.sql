@@ -0,0 +1,15 @@
+-- Planned maintenance of registered services, during which health checks and
+-- probe alerts for the service are suppressed
+CREATE TABLE IF NOT EXISTS maintenance_windows (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	service_id TEXT NOT NULL,
+	starts_at DATETIME NOT NULL,
+	ends_at DATETIME NOT NULL, -- exclusive
+	message TEXT NOT NULL DEFAULT '',
+	created_by INTEGER,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (service_id) REFERENCES registered_services(id) ON DELETE CASCADE,
+	FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_maintenance_windows_service_id ON maintenance_windows(service_id, starts_at);
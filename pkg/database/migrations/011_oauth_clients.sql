@@ -0,0 +1,11 @@
+-- Clients of the console's OpenID Connect provider. Only the hash of the
+-- client secret is stored, as with API keys.
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	id TEXT PRIMARY KEY, -- client_id
+	name TEXT NOT NULL,
+	secret_hash TEXT NOT NULL,
+	redirect_uris TEXT NOT NULL, -- JSON array
+	created_by INTEGER,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
@@ -0,0 +1,12 @@
+-- Secrets referenced by services through env_from_secret. Values are sealed
+-- with AES-GCM under the secrets master key, which is not in the database,
+-- so backups of it do not reveal them.
+CREATE TABLE IF NOT EXISTS secrets (
+	name TEXT PRIMARY KEY,
+	value BLOB NOT NULL, -- nonce followed by the sealed value
+	description TEXT NOT NULL DEFAULT '',
+	created_by INTEGER,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
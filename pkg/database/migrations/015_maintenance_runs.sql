@@ -0,0 +1,10 @@
+-- Runs of the console's retention cleanup, with the rows each deleted
+CREATE TABLE IF NOT EXISTS maintenance_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at DATETIME NOT NULL,
+	duration_ms INTEGER NOT NULL DEFAULT 0,
+	deleted TEXT NOT NULL DEFAULT '{}', -- JSON object of rows deleted per table
+	error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_maintenance_runs_started_at ON maintenance_runs(started_at);
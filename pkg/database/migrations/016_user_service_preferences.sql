@@ -0,0 +1,14 @@
+-- Per-user preferences for registered services in the SSO portal: pinned
+-- services are listed first, in sort_order, and the rest by last access
+CREATE TABLE IF NOT EXISTS user_service_preferences (
+	user_id INTEGER NOT NULL,
+	service_id TEXT NOT NULL,
+	pinned BOOLEAN NOT NULL DEFAULT FALSE,
+	sort_order INTEGER NOT NULL DEFAULT 0,
+	last_accessed DATETIME,
+	PRIMARY KEY (user_id, service_id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (service_id) REFERENCES registered_services(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_service_preferences_service_id ON user_service_preferences(service_id);
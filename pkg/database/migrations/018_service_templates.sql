@@ -0,0 +1,79 @@
+-- Templates services are created from. The body is a YAML service spec
+-- whose quoted values may hold {{variable}} placeholders, described by the
+-- JSON array in variables.
+CREATE TABLE IF NOT EXISTS service_templates (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	description TEXT NOT NULL DEFAULT '',
+	category TEXT NOT NULL DEFAULT '',
+	body TEXT NOT NULL,
+	variables TEXT NOT NULL DEFAULT '[]', -- JSON array
+	builtin BOOLEAN NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+-- Built-in templates, kept as they are when the migration is re-applied to
+-- a database adopted from before migrations were tracked
+INSERT OR IGNORE INTO service_templates (id, name, description, category, body, variables, builtin, created_at, updated_at) VALUES
+('static-site', 'Static site', 'nginx serving the files of a host directory', 'web',
+'name: "{{name}}"
+image: "nginx:{{version}}"
+ports:
+  - internal: 80
+    external: "{{port}}"
+replicas: "{{replicas}}"
+volumes:
+  - "{{content_dir}}:/usr/share/nginx/html:ro"
+health_check:
+  path: /
+  interval: 30s
+',
+'[{"name": "name", "type": "string", "description": "Service name", "default": "site", "required": true, "pattern": "^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$"},
+  {"name": "version", "type": "string", "description": "nginx image tag", "default": "1.27-alpine", "pattern": "^[A-Za-z0-9][A-Za-z0-9_.-]*$"},
+  {"name": "port", "type": "integer", "description": "Published port", "default": 8080},
+  {"name": "replicas", "type": "integer", "description": "Number of instances", "default": 1},
+  {"name": "content_dir", "type": "string", "description": "Host directory with the site files", "required": true, "pattern": "^/[^:]*$"}]',
+1, strftime('%Y-%m-%d %H:%M:%S', 'now'), strftime('%Y-%m-%d %H:%M:%S', 'now')),
+('postgres', 'PostgreSQL', 'PostgreSQL database keeping its data in a host directory', 'database',
+'name: "{{name}}"
+image: "postgres:{{version}}"
+ports:
+  - internal: 5432
+    external: "{{port}}"
+env:
+  POSTGRES_DB: "{{database}}"
+  POSTGRES_USER: "{{user}}"
+env_from_secret:
+  POSTGRES_PASSWORD: "{{password_secret}}"
+volumes:
+  - "{{data_dir}}:/var/lib/postgresql/data"
+resources:
+  memory: "{{memory}}"
+',
+'[{"name": "name", "type": "string", "description": "Service name", "default": "postgres", "required": true, "pattern": "^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$"},
+  {"name": "version", "type": "string", "description": "postgres image tag", "default": "16-alpine", "pattern": "^[A-Za-z0-9][A-Za-z0-9_.-]*$"},
+  {"name": "port", "type": "integer", "description": "Published port", "default": 5432},
+  {"name": "database", "type": "string", "description": "Database created on first start", "default": "app", "pattern": "^[A-Za-z_][A-Za-z0-9_]*$"},
+  {"name": "user", "type": "string", "description": "Owner of the database", "default": "app", "pattern": "^[A-Za-z_][A-Za-z0-9_]*$"},
+  {"name": "password_secret", "type": "string", "description": "Secret holding the password", "required": true, "pattern": "^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$"},
+  {"name": "data_dir", "type": "string", "description": "Host directory for the data", "required": true, "pattern": "^/[^:]*$"},
+  {"name": "memory", "type": "string", "description": "Memory limit", "default": "512Mi"}]',
+1, strftime('%Y-%m-%d %H:%M:%S', 'now'), strftime('%Y-%m-%d %H:%M:%S', 'now')),
+('redis', 'Redis', 'Redis cache with optional append-only persistence', 'database',
+'name: "{{name}}"
+image: "redis:{{version}}"
+ports:
+  - internal: 6379
+    external: "{{port}}"
+command: ["redis-server", "--appendonly", "{{appendonly}}", "--maxmemory", "{{max_memory}}"]
+resources:
+  memory: "{{memory}}"
+',
+'[{"name": "name", "type": "string", "description": "Service name", "default": "redis", "required": true, "pattern": "^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$"},
+  {"name": "version", "type": "string", "description": "redis image tag", "default": "7-alpine", "pattern": "^[A-Za-z0-9][A-Za-z0-9_.-]*$"},
+  {"name": "port", "type": "integer", "description": "Published port", "default": 6379},
+  {"name": "appendonly", "type": "string", "description": "Persist writes to an append-only file", "default": "no", "pattern": "^(yes|no)$"},
+  {"name": "max_memory", "type": "string", "description": "Memory used for data before keys are evicted", "default": "256mb", "pattern": "^[0-9]+(kb|mb|gb)?$"},
+  {"name": "memory", "type": "string", "description": "Memory limit of the container", "default": "384Mi"}]',
+1, strftime('%Y-%m-%d %H:%M:%S', 'now'), strftime('%Y-%m-%d %H:%M:%S', 'now'));
@@ -0,0 +1,20 @@
+-- Nodes that registered with the orchestrator to run services. Capacity is
+-- what a node offers and usage what its agent last reported; CPU is in
+-- nano CPUs and memory in bytes, zero when not limited. Labels are a JSON
+-- object matched by the node selectors of services.
+CREATE TABLE IF NOT EXISTS nodes (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	address TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'not_ready',
+	cpu_capacity INTEGER NOT NULL DEFAULT 0,
+	memory_capacity INTEGER NOT NULL DEFAULT 0,
+	pod_capacity INTEGER NOT NULL DEFAULT 0,
+	cpu_used INTEGER NOT NULL DEFAULT 0,
+	memory_used INTEGER NOT NULL DEFAULT 0,
+	pods_used INTEGER NOT NULL DEFAULT 0,
+	labels TEXT NOT NULL DEFAULT '{}',
+	last_heartbeat DATETIME,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
@@ -0,0 +1,13 @@
+-- The configurations a service had, one per version, so that an update can
+-- be reverted. Config is the service as JSON, as it was saved.
+CREATE TABLE IF NOT EXISTS service_revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	service_id TEXT NOT NULL,
+	revision INTEGER NOT NULL,
+	config TEXT NOT NULL,
+	created_by INTEGER,
+	created_at DATETIME NOT NULL,
+	UNIQUE (service_id, revision),
+	FOREIGN KEY (service_id) REFERENCES services(id) ON DELETE CASCADE,
+	FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
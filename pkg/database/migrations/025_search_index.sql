@@ -0,0 +1,73 @@
+-- Full-text index of the services, routes, users and registered services the
+-- console searches. The trigram tokenizer matches any substring of at least
+-- three characters, case-insensitively. Label and keywords are searched;
+-- secondary is only displayed. Triggers keep the index in sync.
+CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+	label,
+	keywords,
+	secondary UNINDEXED,
+	type UNINDEXED, -- services, routes, users, sso_services
+	resource_id UNINDEXED,
+	tokenize = 'trigram'
+);
+
+INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+SELECT name, '', image, 'services', id FROM services;
+INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+SELECT host, path_prefix, path_prefix, 'routes', id FROM routes;
+INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+SELECT username, display_name || ' ' || email, email, 'users', CAST(id AS TEXT) FROM users;
+INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+SELECT display_name, name, service_url, 'sso_services', id FROM registered_services;
+
+CREATE TRIGGER IF NOT EXISTS search_index_services_insert AFTER INSERT ON services BEGIN
+	INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+	VALUES (new.name, '', new.image, 'services', new.id);
+END;
+CREATE TRIGGER IF NOT EXISTS search_index_services_update AFTER UPDATE OF id, name, image ON services BEGIN
+	DELETE FROM search_index WHERE type = 'services' AND resource_id = old.id;
+	INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+	VALUES (new.name, '', new.image, 'services', new.id);
+END;
+CREATE TRIGGER IF NOT EXISTS search_index_services_delete AFTER DELETE ON services BEGIN
+	DELETE FROM search_index WHERE type = 'services' AND resource_id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS search_index_routes_insert AFTER INSERT ON routes BEGIN
+	INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+	VALUES (new.host, new.path_prefix, new.path_prefix, 'routes', new.id);
+END;
+CREATE TRIGGER IF NOT EXISTS search_index_routes_update AFTER UPDATE OF id, host, path_prefix ON routes BEGIN
+	DELETE FROM search_index WHERE type = 'routes' AND resource_id = old.id;
+	INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+	VALUES (new.host, new.path_prefix, new.path_prefix, 'routes', new.id);
+END;
+CREATE TRIGGER IF NOT EXISTS search_index_routes_delete AFTER DELETE ON routes BEGIN
+	DELETE FROM search_index WHERE type = 'routes' AND resource_id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS search_index_users_insert AFTER INSERT ON users BEGIN
+	INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+	VALUES (new.username, new.display_name || ' ' || new.email, new.email, 'users', CAST(new.id AS TEXT));
+END;
+CREATE TRIGGER IF NOT EXISTS search_index_users_update AFTER UPDATE OF id, username, email, display_name ON users BEGIN
+	DELETE FROM search_index WHERE type = 'users' AND resource_id = CAST(old.id AS TEXT);
+	INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+	VALUES (new.username, new.display_name || ' ' || new.email, new.email, 'users', CAST(new.id AS TEXT));
+END;
+CREATE TRIGGER IF NOT EXISTS search_index_users_delete AFTER DELETE ON users BEGIN
+	DELETE FROM search_index WHERE type = 'users' AND resource_id = CAST(old.id AS TEXT);
+END;
+
+CREATE TRIGGER IF NOT EXISTS search_index_registered_services_insert AFTER INSERT ON registered_services BEGIN
+	INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+	VALUES (new.display_name, new.name, new.service_url, 'sso_services', new.id);
+END;
+CREATE TRIGGER IF NOT EXISTS search_index_registered_services_update AFTER UPDATE OF id, name, display_name, service_url ON registered_services BEGIN
+	DELETE FROM search_index WHERE type = 'sso_services' AND resource_id = old.id;
+	INSERT INTO search_index (label, keywords, secondary, type, resource_id)
+	VALUES (new.display_name, new.name, new.service_url, 'sso_services', new.id);
+END;
+CREATE TRIGGER IF NOT EXISTS search_index_registered_services_delete AFTER DELETE ON registered_services BEGIN
+	DELETE FROM search_index WHERE type = 'sso_services' AND resource_id = old.id;
+END;
@@ -10,9 +10,11 @@ type User struct {
 	ID           int        `db:"id" json:"id"`
 	Username     string     `db:"username" json:"username"`
 	Email        string     `db:"email" json:"email"`
+	DisplayName  string     `db:"display_name" json:"display_name"`
 	PasswordHash string     `db:"password_hash" json:"-"`
 	Role         string     `db:"role" json:"role"`
 	TOTPSecret   *string    `db:"totp_secret" json:"-"`
+	TOTPEnabled  bool       `db:"totp_enabled" json:"totp_enabled"`
 	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
 	UpdatedAt    time.Time  `db:"updated_at" json:"updated_at"`
 	LastLogin    *time.Time `db:"last_login" json:"last_login"`
@@ -31,6 +33,7 @@ type Service struct {
 	Args        []string          `db:"args" json:"args"`
 	YAMLConfig  string            `db:"yaml_config" json:"yaml_config"`
 	Version     int               `db:"version" json:"version"`
+	OrgID       *string           `db:"org_id" json:"org_id,omitempty"`
 	CreatedAt   time.Time         `db:"created_at" json:"created_at"`
 	UpdatedAt   time.Time         `db:"updated_at" json:"updated_at"`
 }
@@ -95,9 +98,61 @@ type Deployment struct {
 	ServiceID    string     `db:"service_id" json:"service_id"`
 	Version      int        `db:"version" json:"version"`
 	Status       string     `db:"status" json:"status"`
+	Strategy     string     `db:"strategy" json:"strategy"`
+	Config       string     `db:"config" json:"config"` // JSON string of the resolved deploy config
+	Logs         string     `db:"logs" json:"-"`        // JSON array of log lines, see LogLines
 	StartedAt    time.Time  `db:"started_at" json:"started_at"`
+	UpdatedAt    *time.Time `db:"updated_at" json:"updated_at"`
 	FinishedAt   *time.Time `db:"finished_at" json:"finished_at"`
 	ErrorMessage *string    `db:"error_message" json:"error_message"`
+	// TriggerSource is what started the deployment, such as a deploy hook,
+	// and TriggeredBy and Notes what it said about it
+	TriggerSource *string `db:"trigger_source" json:"trigger_source,omitempty"`
+	TriggeredBy   *string `db:"triggered_by" json:"triggered_by,omitempty"`
+	Notes         *string `db:"notes" json:"notes,omitempty"`
+}
+
+// LogLines converts the stored log lines JSON to a slice
+func (d *Deployment) LogLines() ([]string, error) {
+	lines := []string{}
+	if d.Logs == "" {
+		return lines, nil
+	}
+	if err := json.Unmarshal([]byte(d.Logs), &lines); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// Incident represents an outage or degradation affecting a service
+type Incident struct {
+	ID         string     `db:"id" json:"id"`
+	ServiceID  string     `db:"service_id" json:"service_id"`
+	Title      string     `db:"title" json:"title"`
+	Severity   string     `db:"severity" json:"severity"` // minor, major, critical
+	Status     string     `db:"status" json:"status"`     // open, resolved
+	OpenedAt   time.Time  `db:"opened_at" json:"opened_at"`
+	ResolvedAt *time.Time `db:"resolved_at" json:"resolved_at"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+}
+
+// TimeBucket is a half-open [Start, End) interval used to group aggregate queries
+type TimeBucket struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// DeploymentBucketStats holds aggregated deployment outcomes for one time bucket,
+// optionally broken down by service
+type DeploymentBucketStats struct {
+	Bucket             int      `db:"bucket" json:"-"`
+	ServiceID          *string  `db:"service_id" json:"service_id,omitempty"`
+	ServiceName        *string  `db:"service_name" json:"service_name,omitempty"`
+	Deployments        int      `db:"deployments" json:"deployments"`
+	Failures           int      `db:"failures" json:"failures"`
+	Rollbacks          int      `db:"rollbacks" json:"rollbacks"`
+	Incidents          int      `db:"incidents" json:"incidents"`                                 // deployments followed by an incident within the window
+	MeanTimeToIncident *float64 `db:"mean_time_to_incident" json:"mean_time_to_incident_seconds"` // seconds
 }
 
 // Route represents a routing rule
@@ -108,6 +163,7 @@ type Route struct {
 	UpstreamServiceID *string   `db:"upstream_service_id" json:"upstream_service_id"`
 	UpstreamURL       *string   `db:"upstream_url" json:"upstream_url"`
 	TLSCertID         *string   `db:"tls_cert_id" json:"tls_cert_id"`
+	OrgID             *string   `db:"org_id" json:"org_id,omitempty"`
 	CreatedAt         time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt         time.Time `db:"updated_at" json:"updated_at"`
 }
@@ -139,6 +195,14 @@ type Metric struct {
 	CreatedAt   time.Time `db:"created_at" json:"created_at"`
 }
 
+// MetricBucket is one time bucket of an aggregated metric series. Value is
+// nil when the bucket holds no samples, except for the count aggregation.
+type MetricBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+	Value *float64  `json:"value"`
+}
+
 // LogIndex represents log file index information
 type LogIndex struct {
 	ID             int       `db:"id" json:"id"`
@@ -164,6 +228,18 @@ type Snapshot struct {
 	CreatedAt    time.Time `db:"created_at" json:"created_at"`
 }
 
+// PlanSnapshot is a snapshot plan with its latest completed snapshot, whose
+// fields are nil if the plan has none
+type PlanSnapshot struct {
+	PlanID     string     `db:"plan_id" json:"plan_id"`
+	PlanName   string     `db:"plan_name" json:"plan_name"`
+	Enabled    bool       `db:"enabled" json:"enabled"`
+	SnapshotID *string    `db:"snapshot_id" json:"snapshot_id"`
+	Timestamp  *time.Time `db:"timestamp" json:"timestamp"`
+	SizeBytes  *int64     `db:"size_bytes" json:"size_bytes"`
+	Kind       *string    `db:"kind" json:"kind"`
+}
+
 // SnapPlan represents a backup plan
 type SnapPlan struct {
 	ID             string    `db:"id" json:"id"`
@@ -174,40 +250,75 @@ type SnapPlan struct {
 	KeepWeekly     int       `db:"keep_weekly" json:"keep_weekly"`
 	KeepMonthly    int       `db:"keep_monthly" json:"keep_monthly"`
 	Enabled        bool      `db:"enabled" json:"enabled"`
+	OrgID          *string   `db:"org_id" json:"org_id,omitempty"`
 	CreatedAt      time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
 }
 
 // AuditLog represents an audit log entry
 type AuditLog struct {
-	ID           int       `db:"id" json:"id"`
-	UserID       *int      `db:"user_id" json:"user_id"`
-	Action       string    `db:"action" json:"action"`
-	ResourceType string    `db:"resource_type" json:"resource_type"`
-	ResourceID   *string   `db:"resource_id" json:"resource_id"`
-	Details      *string   `db:"details" json:"details"`
-	IPAddress    *string   `db:"ip_address" json:"ip_address"`
-	UserAgent    *string   `db:"user_agent" json:"user_agent"`
-	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	ID             int       `db:"id" json:"id"`
+	UserID         *int      `db:"user_id" json:"user_id"`
+	ImpersonatorID *int      `db:"impersonator_id" json:"impersonator_id,omitempty"` // the admin who acted as the user, if any
+	Action         string    `db:"action" json:"action"`
+	ResourceType   string    `db:"resource_type" json:"resource_type"`
+	ResourceID     *string   `db:"resource_id" json:"resource_id"`
+	Details        *string   `db:"details" json:"details"`
+	IPAddress      *string   `db:"ip_address" json:"ip_address"`
+	UserAgent      *string   `db:"user_agent" json:"user_agent"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
 }
 
+// Registered service statuses. The health checker marks an active service
+// unreachable after repeated failed checks, and active again once a check
+// passes.
+const (
+	RegisteredServiceActive      = "active"
+	RegisteredServiceInactive    = "inactive"
+	RegisteredServiceMaintenance = "maintenance"
+	RegisteredServiceUnreachable = "unreachable"
+)
+
 // RegisteredService represents a service registered with the SSO gateway
 type RegisteredService struct {
-	ID           string     `db:"id" json:"id"`
-	Name         string     `db:"name" json:"name"`
-	DisplayName  string     `db:"display_name" json:"display_name"`
-	Description  *string    `db:"description" json:"description"`
-	ServiceURL   string     `db:"service_url" json:"service_url"`
-	CallbackURL  *string    `db:"callback_url" json:"callback_url"`
-	Icon         *string    `db:"icon" json:"icon"`
-	Category     string     `db:"category" json:"category"`
-	IsPublic     bool       `db:"is_public" json:"is_public"`
-	RequiredRole string     `db:"required_role" json:"required_role"`
-	Status       string     `db:"status" json:"status"` // active, inactive, maintenance
-	HealthURL    *string    `db:"health_url" json:"health_url"`
-	LastHealthy  *time.Time `db:"last_healthy" json:"last_healthy"`
-	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
-	UpdatedAt    time.Time  `db:"updated_at" json:"updated_at"`
+	ID            string     `db:"id" json:"id"`
+	Name          string     `db:"name" json:"name"`
+	DisplayName   string     `db:"display_name" json:"display_name"`
+	Description   *string    `db:"description" json:"description"`
+	ServiceURL    string     `db:"service_url" json:"service_url"`
+	CallbackURL   *string    `db:"callback_url" json:"callback_url"`
+	Icon          *string    `db:"icon" json:"icon"`
+	Category      string     `db:"category" json:"category"`
+	IsPublic      bool       `db:"is_public" json:"is_public"`
+	RequiredRole  string     `db:"required_role" json:"required_role"`
+	Status        string     `db:"status" json:"status"` // active, inactive, maintenance, unreachable
+	HealthURL     *string    `db:"health_url" json:"health_url"`
+	LastHealthy   *time.Time `db:"last_healthy" json:"last_healthy"`
+	IsHealthy     *bool      `db:"is_healthy" json:"is_healthy"`         // result of the latest health check, nil until checked
+	FailureStreak int        `db:"failure_streak" json:"failure_streak"` // consecutive failed health checks
+	ProxyEnabled  bool       `db:"proxy_enabled" json:"proxy_enabled"`   // served by the console under /portal/<proxy_path>
+	ProxyPath     *string    `db:"proxy_path" json:"proxy_path"`
+	OrgID         *string    `db:"org_id" json:"org_id,omitempty"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// UserService is a registered service as listed for a user, with the user's
+// preferences for it
+type UserService struct {
+	RegisteredService
+	Pinned       bool       `db:"pinned" json:"pinned"`
+	SortOrder    int        `db:"sort_order" json:"sort_order"` // position among the user's pinned services
+	LastAccessed *time.Time `db:"last_accessed" json:"last_accessed"`
+}
+
+// UserServicePreference is a user's preferences for a registered service
+type UserServicePreference struct {
+	UserID       int        `db:"user_id" json:"user_id"`
+	ServiceID    string     `db:"service_id" json:"service_id"`
+	Pinned       bool       `db:"pinned" json:"pinned"`
+	SortOrder    int        `db:"sort_order" json:"sort_order"`
+	LastAccessed *time.Time `db:"last_accessed" json:"last_accessed"`
 }
 
 // SSOSession represents an SSO session
@@ -221,6 +332,22 @@ type SSOSession struct {
 	IsActive  bool      `db:"is_active" json:"is_active"`
 	LastUsed  time.Time `db:"last_used" json:"last_used"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
+
+	LastHandoffIP *string    `db:"last_handoff_ip" json:"last_handoff_ip"` // where the session's last launch token was redeemed
+	LastHandoffAt *time.Time `db:"last_handoff_at" json:"last_handoff_at"`
+}
+
+// SSOLaunchToken records an SSO token minted for a service launch URL, so
+// that it can only be redeemed once. Only the hash of the token is stored.
+type SSOLaunchToken struct {
+	TokenHash  string     `db:"token_hash" json:"-"`
+	UserID     int        `db:"user_id" json:"user_id"`
+	ServiceID  string     `db:"service_id" json:"service_id"`
+	SessionID  *string    `db:"session_id" json:"session_id"`
+	ExpiresAt  time.Time  `db:"expires_at" json:"expires_at"`
+	ConsumedAt *time.Time `db:"consumed_at" json:"consumed_at"`
+	ConsumedIP *string    `db:"consumed_ip" json:"consumed_ip"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
 }
 
 // UserServicePermission represents user permissions for specific services
@@ -244,6 +371,42 @@ type ServiceHealthCheck struct {
 	CheckedAt    time.Time `db:"checked_at" json:"checked_at"`
 }
 
+// ServiceHealthSummary is a registered service with its latest health
+// check. The check's fields are nil when the service was never checked.
+type ServiceHealthSummary struct {
+	ServiceID     string     `db:"service_id" json:"service_id"`
+	Name          string     `db:"name" json:"name"`
+	DisplayName   string     `db:"display_name" json:"display_name"`
+	Status        string     `db:"status" json:"status"`
+	FailureStreak int        `db:"failure_streak" json:"failure_streak"`
+	LastHealthy   *time.Time `db:"last_healthy" json:"last_healthy"`
+	CheckID       *int       `db:"check_id" json:"check_id"`
+	IsHealthy     *bool      `db:"is_healthy" json:"is_healthy"`
+	ResponseTime  *int       `db:"response_time" json:"response_time"` // in milliseconds
+	ErrorMessage  *string    `db:"error_message" json:"error_message"`
+	CheckedAt     *time.Time `db:"checked_at" json:"checked_at"`
+}
+
+// LatestCheck returns the service's latest health check, or nil if it was
+// never checked
+func (s *ServiceHealthSummary) LatestCheck() *ServiceHealthCheck {
+	if s.CheckID == nil {
+		return nil
+	}
+	check := &ServiceHealthCheck{ID: *s.CheckID, ServiceID: s.ServiceID}
+	if s.IsHealthy != nil {
+		check.IsHealthy = *s.IsHealthy
+	}
+	if s.ResponseTime != nil {
+		check.ResponseTime = *s.ResponseTime
+	}
+	if s.CheckedAt != nil {
+		check.CheckedAt = *s.CheckedAt
+	}
+	check.ErrorMessage = s.ErrorMessage
+	return check
+}
+
 // ServicePermissionDetail represents a user's relationship to a registered service
 type ServicePermissionDetail struct {
 	UserID    int        `db:"user_id" json:"user_id"`
@@ -255,3 +418,236 @@ type ServicePermissionDetail struct {
 	GrantedAt *time.Time `db:"granted_at" json:"granted_at"`
 	ExpiresAt *time.Time `db:"expires_at" json:"expires_at"`
 }
+
+// ProbeResult is a persisted probe execution result
+type ProbeResult struct {
+	ID           string    `db:"id" json:"id"`
+	ProbeID      string    `db:"probe_id" json:"probe_id"`
+	Status       string    `db:"status" json:"status"`               // success, failure, timeout, error
+	ResponseTime int64     `db:"response_time" json:"response_time"` // in nanoseconds
+	StatusCode   int       `db:"status_code" json:"status_code"`
+	Message      string    `db:"message" json:"message"`
+	Error        string    `db:"error" json:"error"`
+	Metadata     *string   `db:"metadata" json:"metadata"` // JSON format
+	Timestamp    time.Time `db:"timestamp" json:"timestamp"`
+}
+
+// ProbeAlert is a persisted probe alert
+type ProbeAlert struct {
+	ID         string     `db:"id" json:"id"`
+	ProbeID    string     `db:"probe_id" json:"probe_id"`
+	Type       string     `db:"type" json:"type"`         // threshold, availability, performance, certificate
+	Severity   string     `db:"severity" json:"severity"` // low, medium, high, critical
+	Status     string     `db:"status" json:"status"`     // active, resolved, suppressed
+	Message    string     `db:"message" json:"message"`
+	Count      int        `db:"count" json:"count"`
+	FirstSeen  time.Time  `db:"first_seen" json:"first_seen"`
+	LastSeen   time.Time  `db:"last_seen" json:"last_seen"`
+	ResolvedAt *time.Time `db:"resolved_at" json:"resolved_at"`
+	Metadata   *string    `db:"metadata" json:"metadata"` // JSON format
+	OrgID      *string    `db:"org_id" json:"org_id,omitempty"`
+}
+
+// LoginAttempt records a single login attempt for lockout and auditing
+type LoginAttempt struct {
+	ID        int       `db:"id" json:"id"`
+	Username  string    `db:"username" json:"username"`
+	IPAddress string    `db:"ip_address" json:"ip_address"`
+	Success   bool      `db:"success" json:"success"`
+	Locked    bool      `db:"locked" json:"locked"`   // rejected because the account was locked
+	Cleared   bool      `db:"cleared" json:"cleared"` // no longer counts towards a lockout
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// PasswordResetToken is a single-use token for resetting a user's password.
+// Only the hash of the token is stored.
+type PasswordResetToken struct {
+	ID        int        `db:"id" json:"id"`
+	UserID    int        `db:"user_id" json:"user_id"`
+	TokenHash string     `db:"token_hash" json:"-"`
+	ExpiresAt time.Time  `db:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time `db:"used_at" json:"used_at"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+// APIKey is a long-lived credential for machine access on behalf of a user.
+// Only the hash of the key is stored.
+type APIKey struct {
+	ID        int        `db:"id" json:"id"`
+	UserID    int        `db:"user_id" json:"user_id"`
+	Name      string     `db:"name" json:"name"`
+	Prefix    string     `db:"prefix" json:"prefix"`
+	KeyHash   string     `db:"key_hash" json:"-"`
+	Scopes    string     `db:"scopes" json:"scopes"` // comma-separated: read, write
+	LastUsed  *time.Time `db:"last_used" json:"last_used"`
+	ExpiresAt *time.Time `db:"expires_at" json:"expires_at"`
+	Revoked   bool       `db:"revoked" json:"revoked"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+// MaintenanceWindow is planned maintenance of a registered service. A window
+// is active from StartsAt up to, but not including, EndsAt.
+type MaintenanceWindow struct {
+	ID        int       `db:"id" json:"id"`
+	ServiceID string    `db:"service_id" json:"service_id"`
+	StartsAt  time.Time `db:"starts_at" json:"starts_at"`
+	EndsAt    time.Time `db:"ends_at" json:"ends_at"`
+	Message   string    `db:"message" json:"message"`
+	CreatedBy *int      `db:"created_by" json:"created_by"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// ActiveAt reports whether the window is active at t
+func (w *MaintenanceWindow) ActiveAt(t time.Time) bool {
+	return !t.Before(w.StartsAt) && t.Before(w.EndsAt)
+}
+
+// OAuthClient is an application registered to authenticate users through
+// the console's OpenID Connect provider
+type OAuthClient struct {
+	ID           string    `db:"id" json:"client_id"`
+	Name         string    `db:"name" json:"name"`
+	SecretHash   string    `db:"secret_hash" json:"-"`
+	RedirectURIs string    `db:"redirect_uris" json:"-"` // JSON array, see RedirectURIList
+	CreatedBy    *int      `db:"created_by" json:"created_by"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+// RedirectURIList converts the stored redirect URIs JSON to a slice
+func (c *OAuthClient) RedirectURIList() ([]string, error) {
+	uris := []string{}
+	if c.RedirectURIs == "" {
+		return uris, nil
+	}
+	if err := json.Unmarshal([]byte(c.RedirectURIs), &uris); err != nil {
+		return nil, err
+	}
+	return uris, nil
+}
+
+// ServiceTemplate is a service spec with {{variable}} placeholders that
+// services are created from. Built-in templates are seeded by the migration
+// creating the table.
+type ServiceTemplate struct {
+	ID          string    `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	Category    string    `db:"category" json:"category"`
+	Body        string    `db:"body" json:"body"`
+	Variables   string    `db:"variables" json:"-"` // JSON array of templates.Variable
+	Builtin     bool      `db:"builtin" json:"builtin"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// Secret is a named value services reference through env_from_secret. The
+// value is sealed in the database and is not part of this record, so
+// secrets can be listed without revealing them.
+type Secret struct {
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	CreatedBy   *int      `db:"created_by" json:"created_by"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// DeployHook is the signed webhook CI deploys a service with. Its secret is
+// only returned when generated.
+type DeployHook struct {
+	ServiceID  string     `db:"service_id" json:"service_id"`
+	Strategy   string     `db:"strategy" json:"strategy"` // of the deployments it triggers, the orchestrator's default when empty
+	CreatedBy  *int       `db:"created_by" json:"created_by"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
+}
+
+// MaintenanceRun is a run of the console's retention cleanup. Error holds
+// the problems of a run that did not clean up every table.
+type MaintenanceRun struct {
+	ID         int       `db:"id" json:"id"`
+	StartedAt  time.Time `db:"started_at" json:"started_at"`
+	DurationMS int64     `db:"duration_ms" json:"duration_ms"`
+	Deleted    string    `db:"deleted" json:"-"` // JSON object, see DeletedRows
+	Error      string    `db:"error" json:"error,omitempty"`
+}
+
+// DeletedRows converts the stored JSON to the rows deleted per table
+func (r *MaintenanceRun) DeletedRows() (map[string]int64, error) {
+	deleted := map[string]int64{}
+	if r.Deleted == "" {
+		return deleted, nil
+	}
+	if err := json.Unmarshal([]byte(r.Deleted), &deleted); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// Node is a host that registered with the orchestrator to run services.
+// CPU is in nano CPUs and memory in bytes; a capacity of zero is not
+// limited.
+type Node struct {
+	ID             string     `db:"id" json:"id"`
+	Name           string     `db:"name" json:"name"`
+	Address        string     `db:"address" json:"address"`
+	Status         string     `db:"status" json:"status"`
+	CPUCapacity    int64      `db:"cpu_capacity" json:"cpu_capacity"`
+	MemoryCapacity int64      `db:"memory_capacity" json:"memory_capacity"`
+	PodCapacity    int        `db:"pod_capacity" json:"pod_capacity"`
+	CPUUsed        int64      `db:"cpu_used" json:"cpu_used"`
+	MemoryUsed     int64      `db:"memory_used" json:"memory_used"`
+	PodsUsed       int        `db:"pods_used" json:"pods_used"`
+	Labels         string     `db:"labels" json:"-"` // JSON object, see LabelMap
+	LastHeartbeat  *time.Time `db:"last_heartbeat" json:"last_heartbeat"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// LabelMap converts the stored JSON to the labels of the node
+func (n *Node) LabelMap() (map[string]string, error) {
+	labels := map[string]string{}
+	if n.Labels == "" {
+		return labels, nil
+	}
+	if err := json.Unmarshal([]byte(n.Labels), &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// ServiceRevision is the configuration a service had at one of its
+// versions. Config is the service as JSON, see Service.
+type ServiceRevision struct {
+	ID        int       `db:"id" json:"id"`
+	ServiceID string    `db:"service_id" json:"service_id"`
+	Revision  int       `db:"revision" json:"revision"`
+	Config    string    `db:"config" json:"-"`
+	CreatedBy *int      `db:"created_by" json:"created_by"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Service decodes the service stored in the revision
+func (r *ServiceRevision) Service() (*Service, error) {
+	var service Service
+	if err := json.Unmarshal([]byte(r.Config), &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
+}
+
+// Organization owns services, routes and registered services when
+// multi-tenancy is enabled. Its ID is a slug, such as "default".
+type Organization struct {
+	ID          string    `db:"id" json:"id"`
+	DisplayName string    `db:"display_name" json:"display_name"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+// OrgMember is a user's membership of an organization
+type OrgMember struct {
+	OrgID     string    `db:"org_id" json:"org_id"`
+	UserID    int       `db:"user_id" json:"user_id"`
+	Username  string    `db:"username" json:"username"`
+	Role      string    `db:"role" json:"role"` // org_admin, member
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
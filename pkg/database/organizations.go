@@ -0,0 +1,264 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultOrgID is the organization existing services, routes, registered
+// services and snapshot plans were assigned to when organizations were
+// introduced, and that resources created outside any organization belong to
+const DefaultOrgID = "default"
+
+// Roles of organization members
+const (
+	OrgRoleAdmin  = "org_admin" // manages the organization's members
+	OrgRoleMember = "member"
+)
+
+var (
+	// ErrOrganizationNotFound is returned when an organization does not exist
+	ErrOrganizationNotFound = fmt.Errorf("organization %w", ErrNotFound)
+	// ErrOrganizationExists is returned when creating an organization whose
+	// ID is taken
+	ErrOrganizationExists = errors.New("organization already exists")
+	// ErrOrganizationNotEmpty is returned when deleting an organization that
+	// still owns resources
+	ErrOrganizationNotEmpty = errors.New("organization still owns resources")
+	// ErrOrgMemberNotFound is returned when a user is not a member of an
+	// organization
+	ErrOrgMemberNotFound = fmt.Errorf("organization member %w", ErrNotFound)
+	// ErrCrossOrgReference is returned when a resource would reference one
+	// owned by another organization
+	ErrCrossOrgReference = errors.New("resource belongs to another organization")
+)
+
+// OrgHeader names the organization a request acts in. Users set it to
+// switch organizations in the console, which sets it on its requests to the
+// daemons to pass on the organization it is scoped to.
+const OrgHeader = "X-Org-ID"
+
+// orgScopeKey is the context key of the organization statements are scoped to
+type orgScopeKey struct{}
+
+// WithOrgScope returns a context scoping a DB bound to it with WithContext to
+// an organization: its services, routes and registered services only list,
+// get, update and delete those the organization owns, and create them owned
+// by it
+func WithOrgScope(ctx context.Context, orgID string) context.Context {
+	return context.WithValue(ctx, orgScopeKey{}, orgID)
+}
+
+// OrgScope returns the organization ctx is scoped to, if any
+func OrgScope(ctx context.Context) (string, bool) {
+	orgID, ok := ctx.Value(orgScopeKey{}).(string)
+	return orgID, ok
+}
+
+// orgScope returns the organization the DB's statements are scoped to
+func (db *DB) orgScope() (string, bool) {
+	if db.ctx == nil {
+		return "", false
+	}
+	return OrgScope(db.ctx)
+}
+
+// orgCondition returns the condition restricting a statement to the rows of
+// the scoped organization, to be appended to its WHERE clause, and its
+// arguments. Both are empty when the DB is not scoped.
+func (db *DB) orgCondition(column string) (string, []interface{}) {
+	orgID, ok := db.orgScope()
+	if !ok {
+		return "", nil
+	}
+	return " AND " + column + " = ?", []interface{}{orgID}
+}
+
+// orgWhere is orgCondition for statements without a WHERE clause of their
+// own
+func (db *DB) orgWhere(column string) (string, []interface{}) {
+	orgID, ok := db.orgScope()
+	if !ok {
+		return "", nil
+	}
+	return " WHERE " + column + " = ?", []interface{}{orgID}
+}
+
+// orgFilters returns list filters restricted to the scoped organization
+func (db *DB) orgFilters(filters map[string]string) map[string]string {
+	orgID, ok := db.orgScope()
+	if !ok {
+		return filters
+	}
+	scoped := make(map[string]string, len(filters)+1)
+	for column, value := range filters {
+		scoped[column] = value
+	}
+	scoped["org_id"] = orgID
+	return scoped
+}
+
+// ownerOrg returns the organization a new resource belongs to: the scoped
+// organization, else the one it names, else the default organization
+func (db *DB) ownerOrg(orgID *string) (*string, error) {
+	if scoped, ok := db.orgScope(); ok {
+		if scoped == "" {
+			return nil, ErrOrganizationNotFound
+		}
+		return &scoped, nil
+	}
+	if orgID != nil {
+		return orgID, nil
+	}
+	defaultOrg := DefaultOrgID
+	return &defaultOrg, nil
+}
+
+// OrganizationRepository provides database operations for organizations and
+// their members
+type OrganizationRepository struct {
+	db *DB
+}
+
+// NewOrganizationRepository creates a new organization repository
+func NewOrganizationRepository(db *DB) *OrganizationRepository {
+	return &OrganizationRepository{db: db}
+}
+
+// Create creates an organization, or returns ErrOrganizationExists
+func (r *OrganizationRepository) Create(org *Organization) error {
+	org.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	query := `INSERT INTO organizations (id, display_name, created_at) VALUES (?, ?, ?) ON CONFLICT(id) DO NOTHING`
+	result, err := r.db.Exec(query, org.ID, org.DisplayName, formatTimestamp(org.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrOrganizationExists
+	}
+	return nil
+}
+
+// Get returns an organization
+func (r *OrganizationRepository) Get(id string) (*Organization, error) {
+	var org Organization
+	if err := r.db.Get(&org, "SELECT * FROM organizations WHERE id = ?", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, fmt.Errorf("failed to get organization: %w", notFound(err))
+	}
+	return &org, nil
+}
+
+// List lists every organization by ID
+func (r *OrganizationRepository) List() ([]*Organization, error) {
+	orgs := []*Organization{}
+	if err := r.db.Select(&orgs, "SELECT * FROM organizations ORDER BY id"); err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+	return orgs, nil
+}
+
+// Delete deletes an organization and its memberships. Organizations that
+// still own services, routes, registered services or snapshot plans are not
+// deleted, and ErrOrganizationNotEmpty is returned.
+func (r *OrganizationRepository) Delete(id string) error {
+	return r.db.WithTx(r.db.context(), func(tx *DB) error {
+		for _, c := range orgColumns {
+			var owned int
+			if err := tx.Get(&owned, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE org_id = ?", c.table), id); err != nil {
+				return fmt.Errorf("failed to count %s of organization: %w", c.table, err)
+			}
+			if owned > 0 {
+				return ErrOrganizationNotEmpty
+			}
+		}
+
+		result, err := tx.Exec("DELETE FROM organizations WHERE id = ?", id)
+		if err != nil {
+			return fmt.Errorf("failed to delete organization: %w", err)
+		}
+		if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+			return ErrOrganizationNotFound
+		}
+		return nil
+	})
+}
+
+// SetMember adds a user to an organization with a role, or changes the role
+// of a member
+func (r *OrganizationRepository) SetMember(orgID string, userID int, role string) error {
+	query := `
+		INSERT INTO organization_members (org_id, user_id, role, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(org_id, user_id) DO UPDATE SET role = excluded.role
+	`
+	if _, err := r.db.Exec(query, orgID, userID, role, formatTimestamp(time.Now())); err != nil {
+		return fmt.Errorf("failed to set organization member: %w", err)
+	}
+	return nil
+}
+
+// RemoveMember removes a user from an organization
+func (r *OrganizationRepository) RemoveMember(orgID string, userID int) error {
+	result, err := r.db.Exec("DELETE FROM organization_members WHERE org_id = ? AND user_id = ?", orgID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove organization member: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrOrgMemberNotFound
+	}
+	return nil
+}
+
+// GetMember returns a user's membership of an organization
+func (r *OrganizationRepository) GetMember(orgID string, userID int) (*OrgMember, error) {
+	var member OrgMember
+	query := `
+		SELECT m.org_id, m.user_id, u.username, m.role, m.created_at
+		FROM organization_members m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = ? AND m.user_id = ?
+	`
+	if err := r.db.Get(&member, query, orgID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrgMemberNotFound
+		}
+		return nil, fmt.Errorf("failed to get organization member: %w", notFound(err))
+	}
+	return &member, nil
+}
+
+// ListMembers lists the members of an organization by username
+func (r *OrganizationRepository) ListMembers(orgID string) ([]*OrgMember, error) {
+	members := []*OrgMember{}
+	query := `
+		SELECT m.org_id, m.user_id, u.username, m.role, m.created_at
+		FROM organization_members m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = ?
+		ORDER BY u.username
+	`
+	if err := r.db.Select(&members, query, orgID); err != nil {
+		return nil, fmt.Errorf("failed to list organization members: %w", err)
+	}
+	return members, nil
+}
+
+// ListMemberships lists the organizations a user is a member of, oldest
+// membership first
+func (r *OrganizationRepository) ListMemberships(userID int) ([]*OrgMember, error) {
+	members := []*OrgMember{}
+	query := `
+		SELECT m.org_id, m.user_id, u.username, m.role, m.created_at
+		FROM organization_members m JOIN users u ON u.id = m.user_id
+		WHERE m.user_id = ?
+		ORDER BY m.created_at, m.org_id
+	`
+	if err := r.db.Select(&members, query, userID); err != nil {
+		return nil, fmt.Errorf("failed to list organization memberships: %w", err)
+	}
+	return members, nil
+}
@@ -0,0 +1,294 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+// createTestOrgs creates organizations, each scoping a copy of db
+func createTestOrgs(t *testing.T, db *DB, ids ...string) map[string]*DB {
+	scoped := make(map[string]*DB, len(ids))
+	for _, id := range ids {
+		if err := db.OrganizationRepository().Create(&Organization{ID: id, DisplayName: id}); err != nil {
+			t.Fatalf("Failed to create organization %s: %v", id, err)
+		}
+		scoped[id] = db.WithContext(WithOrgScope(context.Background(), id))
+	}
+	return scoped
+}
+
+func TestMigrateCreatesDefaultOrg(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	org, err := db.OrganizationRepository().Get(DefaultOrgID)
+	if err != nil {
+		t.Fatalf("Expected the default organization: %v", err)
+	}
+	if org.DisplayName != "Default" {
+		t.Errorf("Unexpected default organization %+v", org)
+	}
+
+	// Resources created outside any organization belong to the default one
+	service := &Service{Name: "web", Image: "nginx", Port: 80, Replicas: 1, Status: "stopped"}
+	if err := db.ServiceRepository().Create(service); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	got, err := db.ServiceRepository().GetByID(service.ID)
+	if err != nil {
+		t.Fatalf("Failed to get service: %v", err)
+	}
+	if got.OrgID == nil || *got.OrgID != DefaultOrgID {
+		t.Errorf("Expected the service in the default organization, got %v", got.OrgID)
+	}
+
+	if err := db.OrganizationRepository().Create(&Organization{ID: DefaultOrgID}); !errors.Is(err, ErrOrganizationExists) {
+		t.Errorf("Expected ErrOrganizationExists, got %v", err)
+	}
+}
+
+func TestOrgScope(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	orgs := createTestOrgs(t, db, "acme", "globex")
+	acme, globex := orgs["acme"], orgs["globex"]
+
+	service := &Service{Name: "acme-web", Image: "nginx", Port: 80, Replicas: 1, Status: "stopped"}
+	if err := acme.ServiceRepository().Create(service); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	if service.OrgID == nil || *service.OrgID != "acme" {
+		t.Fatalf("Expected the service owned by its organization, got %v", service.OrgID)
+	}
+	registered := &RegisteredService{Name: "acme-portal", DisplayName: "Portal", ServiceURL: "http://localhost:9000"}
+	if err := acme.RegisteredServiceRepository().Create(registered); err != nil {
+		t.Fatalf("Failed to create registered service: %v", err)
+	}
+	route := &Route{Host: "acme.example.com", PathPrefix: "/", UpstreamServiceID: &service.ID}
+	if err := acme.RouteRepository().Create(route); err != nil {
+		t.Fatalf("Failed to create route: %v", err)
+	}
+
+	// Another organization cannot see, change or delete them
+	if _, err := globex.ServiceRepository().GetByID(service.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected no service in another organization, got %v", err)
+	}
+	if _, err := globex.ServiceRepository().GetByName(service.Name); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected no service by name in another organization, got %v", err)
+	}
+	if _, err := globex.RegisteredServiceRepository().GetByID(registered.ID); err == nil {
+		t.Error("Expected no registered service in another organization")
+	}
+	if _, err := globex.RouteRepository().GetByID(route.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected no route in another organization, got %v", err)
+	}
+	if services, err := globex.ServiceRepository().List(); err != nil || len(services) != 0 {
+		t.Errorf("Expected no services listed in another organization, got %d (%v)", len(services), err)
+	}
+	if services, total, err := globex.ServiceRepository().ListPaged(ListOptions{Limit: 10}); err != nil || total != 0 || len(services) != 0 {
+		t.Errorf("Expected no services paged in another organization, got %d (%v)", total, err)
+	}
+	if registeredServices, err := globex.RegisteredServiceRepository().List(); err != nil || len(registeredServices) != 0 {
+		t.Errorf("Expected no registered services listed in another organization, got %d (%v)", len(registeredServices), err)
+	}
+	if routes, err := globex.RouteRepository().List(); err != nil || len(routes) != 0 {
+		t.Errorf("Expected no routes listed in another organization, got %d (%v)", len(routes), err)
+	}
+
+	renamed := *service
+	renamed.Name = "hijacked"
+	if err := globex.ServiceRepository().Update(&renamed); !IsNotFound(err) {
+		t.Errorf("Expected updating another organization's service not to find it, got %v", err)
+	}
+	if err := globex.ServiceRepository().Delete(service.ID); !IsNotFound(err) {
+		t.Errorf("Expected deleting another organization's service not to find it, got %v", err)
+	}
+	if err := globex.RouteRepository().Delete(route.ID); !IsNotFound(err) {
+		t.Errorf("Expected deleting another organization's route not to find it, got %v", err)
+	}
+	got, err := acme.ServiceRepository().GetByID(service.ID)
+	if err != nil || got.Name != "acme-web" {
+		t.Errorf("Expected the service untouched by another organization, got %+v (%v)", got, err)
+	}
+	if _, err := acme.RouteRepository().GetByID(route.ID); err != nil {
+		t.Errorf("Expected the route untouched by another organization: %v", err)
+	}
+
+	// Unscoped, every organization's resources are visible
+	if services, err := db.ServiceRepository().List(); err != nil || len(services) != 1 {
+		t.Errorf("Expected 1 service unscoped, got %d (%v)", len(services), err)
+	}
+	if services, err := acme.ServiceRepository().List(); err != nil || len(services) != 1 {
+		t.Errorf("Expected 1 service in its organization, got %d (%v)", len(services), err)
+	}
+
+	// Users without an organization are scoped to none and cannot create
+	none := db.WithContext(WithOrgScope(context.Background(), ""))
+	if err := none.ServiceRepository().Create(&Service{Name: "orphan", Image: "nginx", Port: 81, Replicas: 1, Status: "stopped"}); !errors.Is(err, ErrOrganizationNotFound) {
+		t.Errorf("Expected ErrOrganizationNotFound, got %v", err)
+	}
+}
+
+func TestOrgScopeDeploymentsAndAlerts(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	orgs := createTestOrgs(t, db, "acme", "globex")
+	acme, globex := orgs["acme"], orgs["globex"]
+
+	now := time.Now()
+	for _, scoped := range []*DB{acme, globex} {
+		service := &Service{Name: "web", Image: "nginx", Port: 80, Replicas: 1, Status: "running"}
+		if scoped == globex {
+			service.Name = "api"
+		}
+		if err := scoped.ServiceRepository().Create(service); err != nil {
+			t.Fatalf("Failed to create service: %v", err)
+		}
+		if err := db.DeploymentRepository().Create(&Deployment{ServiceID: service.ID, StartedAt: now.Add(-time.Minute)}); err != nil {
+			t.Fatalf("Failed to create deployment: %v", err)
+		}
+	}
+
+	deployments, err := acme.DeploymentRepository().ListRecent(10)
+	if err != nil || len(deployments) != 1 {
+		t.Fatalf("Expected one recent deployment in the organization, got %d (%v)", len(deployments), err)
+	}
+	if deployments, err := db.DeploymentRepository().ListRecent(10); err != nil || len(deployments) != 2 {
+		t.Errorf("Expected every recent deployment unscoped, got %d (%v)", len(deployments), err)
+	}
+
+	buckets := []TimeBucket{{Start: now.Add(-time.Hour), End: now.Add(time.Hour)}}
+	stats, err := acme.DeploymentRepository().Stats(buckets, time.Hour, true)
+	if err != nil {
+		t.Fatalf("Failed to aggregate deployment stats: %v", err)
+	}
+	if len(stats) != 1 || stats[0].ServiceName == nil || *stats[0].ServiceName != "web" || stats[0].Deployments != 1 {
+		t.Errorf("Expected the stats of the organization's service only, got %+v", stats)
+	}
+
+	acmeOrg := "acme"
+	alerts := []*ProbeAlert{
+		{ID: "acme-down", ProbeID: "acme-probe", Type: "availability", Severity: "critical", Status: "active", Message: "down", Count: 1, FirstSeen: now, LastSeen: now, OrgID: &acmeOrg},
+		{ID: "monitor-slow", ProbeID: "monitor-probe", Type: "threshold", Severity: "low", Status: "active", Message: "slow", Count: 1, FirstSeen: now, LastSeen: now},
+	}
+	if err := db.ProbeAlertRepository().UpsertBatch(alerts); err != nil {
+		t.Fatalf("Failed to save probe alerts: %v", err)
+	}
+	if counts, err := acme.ProbeAlertRepository().CountActiveBySeverity(); err != nil || len(counts) != 1 || counts["critical"] != 1 {
+		t.Errorf("Expected the organization's active alert only, got %v (%v)", counts, err)
+	}
+	if counts, err := globex.ProbeAlertRepository().CountActiveBySeverity(); err != nil || len(counts) != 0 {
+		t.Errorf("Expected no active alerts in another organization, got %v (%v)", counts, err)
+	}
+	if counts, err := db.ProbeAlertRepository().CountActiveBySeverity(); err != nil || len(counts) != 2 {
+		t.Errorf("Expected every active alert unscoped, got %v (%v)", counts, err)
+	}
+}
+
+func TestRouteCrossOrgReference(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	orgs := createTestOrgs(t, db, "acme", "globex")
+
+	service := &Service{Name: "acme-web", Image: "nginx", Port: 80, Replicas: 1, Status: "stopped"}
+	if err := orgs["acme"].ServiceRepository().Create(service); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	route := &Route{Host: "globex.example.com", PathPrefix: "/", UpstreamServiceID: &service.ID}
+	if err := orgs["globex"].RouteRepository().Create(route); !errors.Is(err, ErrCrossOrgReference) {
+		t.Fatalf("Expected ErrCrossOrgReference, got %v", err)
+	}
+
+	route.UpstreamServiceID = nil
+	if err := orgs["globex"].RouteRepository().Create(route); err != nil {
+		t.Fatalf("Failed to create route: %v", err)
+	}
+	route.UpstreamServiceID = &service.ID
+	if err := orgs["globex"].RouteRepository().Update(route); !errors.Is(err, ErrCrossOrgReference) {
+		t.Errorf("Expected ErrCrossOrgReference on update, got %v", err)
+	}
+	// Admins moving a route keep it in its organization
+	if err := db.RouteRepository().Update(route); !errors.Is(err, ErrCrossOrgReference) {
+		t.Errorf("Expected ErrCrossOrgReference on unscoped update, got %v", err)
+	}
+}
+
+func TestOrganizationMembers(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	seedTestUsers(t, db, 1, 2)
+	createTestOrgs(t, db, "acme", "globex")
+	repo := db.OrganizationRepository()
+
+	for _, m := range []struct {
+		org  string
+		user int
+		role string
+	}{{"globex", 1, OrgRoleMember}, {"acme", 1, OrgRoleMember}, {"acme", 2, OrgRoleMember}, {"acme", 2, OrgRoleAdmin}} {
+		if err := repo.SetMember(m.org, m.user, m.role); err != nil {
+			t.Fatalf("Failed to set member: %v", err)
+		}
+	}
+
+	members, err := repo.ListMembers("acme")
+	if err != nil || len(members) != 2 {
+		t.Fatalf("Expected 2 members, got %d (%v)", len(members), err)
+	}
+	member, err := repo.GetMember("acme", 2)
+	if err != nil || member.Role != OrgRoleAdmin || member.Username != "seed-user-2" {
+		t.Errorf("Unexpected member %+v (%v)", member, err)
+	}
+	memberships, err := repo.ListMemberships(1)
+	if err != nil || len(memberships) != 2 {
+		t.Fatalf("Expected 2 memberships, got %d (%v)", len(memberships), err)
+	}
+
+	if err := repo.RemoveMember("acme", 1); err != nil {
+		t.Fatalf("Failed to remove member: %v", err)
+	}
+	if err := repo.RemoveMember("acme", 1); !errors.Is(err, ErrOrgMemberNotFound) {
+		t.Errorf("Expected ErrOrgMemberNotFound, got %v", err)
+	}
+	if _, err := repo.GetMember("acme", 1); !errors.Is(err, ErrOrgMemberNotFound) {
+		t.Errorf("Expected ErrOrgMemberNotFound, got %v", err)
+	}
+}
+
+func TestOrganizationDelete(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	seedTestUsers(t, db, 1)
+	orgs := createTestOrgs(t, db, "acme")
+	repo := db.OrganizationRepository()
+	if err := repo.SetMember("acme", 1, OrgRoleMember); err != nil {
+		t.Fatalf("Failed to set member: %v", err)
+	}
+
+	service := &Service{Name: "acme-web", Image: "nginx", Port: 80, Replicas: 1, Status: "stopped"}
+	if err := orgs["acme"].ServiceRepository().Create(service); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	if err := repo.Delete("acme"); !errors.Is(err, ErrOrganizationNotEmpty) {
+		t.Fatalf("Expected ErrOrganizationNotEmpty, got %v", err)
+	}
+
+	if err := orgs["acme"].ServiceRepository().Delete(service.ID); err != nil {
+		t.Fatalf("Failed to delete service: %v", err)
+	}
+	if err := repo.Delete("acme"); err != nil {
+		t.Fatalf("Failed to delete organization: %v", err)
+	}
+	if _, err := repo.Get("acme"); !errors.Is(err, ErrOrganizationNotFound) {
+		t.Errorf("Expected ErrOrganizationNotFound, got %v", err)
+	}
+	if memberships, _ := repo.ListMemberships(1); len(memberships) != 0 {
+		t.Errorf("Expected memberships deleted with the organization, got %d", len(memberships))
+	}
+	if err := repo.Delete("acme"); !errors.Is(err, ErrOrganizationNotFound) {
+		t.Errorf("Expected ErrOrganizationNotFound, got %v", err)
+	}
+}
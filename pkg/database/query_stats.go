@@ -0,0 +1,367 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// Query stats defaults used when the database config leaves them unset
+const (
+	DefaultSlowQueryThreshold = 200 * time.Millisecond
+	DefaultMaxStatements      = 500
+)
+
+// queryStatsSamples is how many of the latest durations of a statement its
+// percentiles are computed from
+const queryStatsSamples = 256
+
+// otherStatements is the fingerprint statements are counted under once
+// MaxStatements distinct ones are tracked
+const otherStatements = "(other statements)"
+
+// Query stat orders accepted by QueryStats.Top
+const (
+	QueryStatsByTotal = "total"
+	QueryStatsByCount = "count"
+	QueryStatsByP50   = "p50"
+	QueryStatsByP95   = "p95"
+	QueryStatsByP99   = "p99"
+	QueryStatsByMax   = "max"
+)
+
+// SlowQuery is a statement that took longer than the slow query threshold.
+// Its values are left out: Statement is its fingerprint, and only the number
+// of its arguments is kept.
+type SlowQuery struct {
+	Statement string
+	Args      int
+	Duration  time.Duration
+	Rows      int64
+	Err       error
+}
+
+// QueryStat aggregates the runs of one statement. Rows counts those changed
+// by statements, and returned by Get and Select; Errors counts failed runs,
+// not queries finding no rows. Percentiles are of the latest runs.
+type QueryStat struct {
+	Fingerprint string  `json:"fingerprint"`
+	Count       int64   `json:"count"`
+	Errors      int64   `json:"errors"`
+	Slow        int64   `json:"slow"`
+	Rows        int64   `json:"rows"`
+	TotalMS     float64 `json:"total_ms"`
+	MeanMS      float64 `json:"mean_ms"`
+	P50MS       float64 `json:"p50_ms"`
+	P95MS       float64 `json:"p95_ms"`
+	P99MS       float64 `json:"p99_ms"`
+	MaxMS       float64 `json:"max_ms"`
+}
+
+// QueryTotals counts every statement run since the stats were started
+type QueryTotals struct {
+	Queries int64   `json:"queries"`
+	Errors  int64   `json:"errors"`
+	Slow    int64   `json:"slow"`
+	TotalMS float64 `json:"total_ms"`
+}
+
+// statementStats aggregates the runs of a statement; samples is a ring of
+// its latest durations
+type statementStats struct {
+	count, errors, slow, rows int64
+	total, max                time.Duration
+	samples                   []time.Duration
+	next                      int
+}
+
+// QueryStats times the statements a DB runs, aggregated by fingerprint, and
+// reports those slower than a threshold. The copies WithContext and WithTx
+// make of a DB share them. A nil QueryStats times nothing.
+type QueryStats struct {
+	slowThreshold time.Duration
+	maxStatements int
+
+	mu         sync.Mutex
+	statements map[string]*statementStats
+	totals     QueryTotals
+	onSlow     func(SlowQuery)
+}
+
+// newQueryStats returns the query stats of a database config, or nil when
+// they are disabled
+func newQueryStats(cfg config.QueryStatsConfig) *QueryStats {
+	if !cfg.Enabled {
+		return nil
+	}
+	threshold, _ := time.ParseDuration(cfg.SlowThreshold) // validated on load
+	if threshold <= 0 {
+		threshold = DefaultSlowQueryThreshold
+	}
+	maxStatements := cfg.MaxStatements
+	if maxStatements <= 0 {
+		maxStatements = DefaultMaxStatements
+	}
+	return &QueryStats{
+		slowThreshold: threshold,
+		maxStatements: maxStatements,
+		statements:    make(map[string]*statementStats),
+		onSlow:        logSlowQuery,
+	}
+}
+
+// QueryStats returns the stats of the statements the database runs, or nil
+// when console.database.query_stats is disabled
+func (db *DB) QueryStats() *QueryStats {
+	return db.queries
+}
+
+// SlowThreshold returns how long statements run before they are slow
+func (s *QueryStats) SlowThreshold() time.Duration {
+	return s.slowThreshold
+}
+
+// SetSlowQueryHook reports slow statements to fn instead of the log
+func (s *QueryStats) SetSlowQueryHook(fn func(SlowQuery)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSlow = fn
+}
+
+// logSlowQuery logs a slow statement
+func logSlowQuery(q SlowQuery) {
+	if q.Err != nil {
+		log.Printf("🐢 Slow query took %v and failed (%d args redacted): %s: %v", q.Duration, q.Args, q.Statement, q.Err)
+		return
+	}
+	log.Printf("🐢 Slow query took %v, %d rows (%d args redacted): %s", q.Duration, q.Rows, q.Args, q.Statement)
+}
+
+// begin returns when a statement starts, or the zero time when nothing is
+// timed
+func (s *QueryStats) begin() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// observe records a statement that began at start
+func (s *QueryStats) observe(query string, args int, start time.Time, rows int64, err error) {
+	if s == nil {
+		return
+	}
+	duration := time.Since(start)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	fingerprint := Fingerprint(query)
+	slow := duration > s.slowThreshold
+
+	s.mu.Lock()
+	stats, ok := s.statements[fingerprint]
+	if !ok {
+		if len(s.statements) >= s.maxStatements {
+			fingerprint = otherStatements
+			stats = s.statements[fingerprint]
+		}
+		if stats == nil {
+			stats = &statementStats{samples: make([]time.Duration, 0, queryStatsSamples)}
+			s.statements[fingerprint] = stats
+		}
+	}
+	stats.count++
+	stats.rows += rows
+	stats.total += duration
+	stats.max = max(stats.max, duration)
+	if len(stats.samples) < queryStatsSamples {
+		stats.samples = append(stats.samples, duration)
+	} else {
+		stats.samples[stats.next] = duration
+		stats.next = (stats.next + 1) % queryStatsSamples
+	}
+	s.totals.Queries++
+	s.totals.TotalMS += milliseconds(duration)
+	if err != nil {
+		stats.errors++
+		s.totals.Errors++
+	}
+	if slow {
+		stats.slow++
+		s.totals.Slow++
+	}
+	onSlow := s.onSlow
+	s.mu.Unlock()
+
+	if slow && onSlow != nil {
+		onSlow(SlowQuery{Statement: fingerprint, Args: args, Duration: duration, Rows: rows, Err: err})
+	}
+}
+
+// Totals returns the counts of every statement run
+func (s *QueryStats) Totals() QueryTotals {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totals
+}
+
+// Top returns the n statements ranking first by an order, such as
+// QueryStatsByTotal for the most time spent overall, or every statement
+// when n is not positive. Unknown orders rank by total time.
+func (s *QueryStats) Top(n int, by string) []QueryStat {
+	s.mu.Lock()
+	stats := make([]QueryStat, 0, len(s.statements))
+	for fingerprint, statement := range s.statements {
+		stats = append(stats, statement.stat(fingerprint))
+	}
+	s.mu.Unlock()
+
+	key := func(stat QueryStat) float64 {
+		switch by {
+		case QueryStatsByCount:
+			return float64(stat.Count)
+		case QueryStatsByP50:
+			return stat.P50MS
+		case QueryStatsByP95:
+			return stat.P95MS
+		case QueryStatsByP99:
+			return stat.P99MS
+		case QueryStatsByMax:
+			return stat.MaxMS
+		default:
+			return stat.TotalMS
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if ki, kj := key(stats[i]), key(stats[j]); ki != kj {
+			return ki > kj
+		}
+		return stats[i].Fingerprint < stats[j].Fingerprint
+	})
+	if n > 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// Reset forgets every statement run so far
+func (s *QueryStats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statements = make(map[string]*statementStats)
+	s.totals = QueryTotals{}
+}
+
+// stat summarizes the runs of a statement. Callers hold the stats' lock.
+func (st *statementStats) stat(fingerprint string) QueryStat {
+	samples := append([]time.Duration(nil), st.samples...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	percentile := func(q float64) float64 {
+		if len(samples) == 0 {
+			return 0
+		}
+		return milliseconds(samples[int(q*float64(len(samples)-1)+0.5)])
+	}
+
+	stat := QueryStat{
+		Fingerprint: fingerprint,
+		Count:       st.count,
+		Errors:      st.errors,
+		Slow:        st.slow,
+		Rows:        st.rows,
+		TotalMS:     milliseconds(st.total),
+		P50MS:       percentile(0.50),
+		P95MS:       percentile(0.95),
+		P99MS:       percentile(0.99),
+		MaxMS:       milliseconds(st.max),
+	}
+	if st.count > 0 {
+		stat.MeanMS = stat.TotalMS / float64(st.count)
+	}
+	return stat
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// rowsScanned returns how many rows Select scanned into dest, a pointer to
+// a slice
+func rowsScanned(dest interface{}) int64 {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return 0
+	}
+	return int64(v.Elem().Len())
+}
+
+// rowsAffected returns the rows a statement changed, zero when unknown
+func rowsAffected(result sql.Result) int64 {
+	if result == nil {
+		return 0
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// valueLists matches the value lists of IN and VALUES clauses, which vary
+// in length with the values of a statement
+var valueLists = regexp.MustCompile(`(?i)\b(IN|VALUES) ?\( ?\?(?: ?, ?\?)* ?\)(?: ?, ?\( ?\?(?: ?, ?\?)* ?\))*`)
+
+// Fingerprint normalizes a statement so that runs differing only in their
+// values are aggregated together: string and number literals become ?,
+// whitespace is collapsed, and the value lists of IN and VALUES clauses,
+// however long, become (...).
+func Fingerprint(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = b.Len() > 0
+			continue
+		case c == '\'':
+			// A string literal, in which '' is an escaped quote
+			for i++; i < len(query); i++ {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			c = '?'
+		case c >= '0' && c <= '9' && (i == 0 || !isWordByte(query[i-1])):
+			for i+1 < len(query) && (isWordByte(query[i+1]) || query[i+1] == '.') {
+				i++
+			}
+			c = '?'
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteByte(c)
+	}
+	return valueLists.ReplaceAllString(b.String(), "$1 (...)")
+}
+
+// isWordByte reports whether c may be part of an identifier
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"SELECT * FROM users WHERE id = 5 AND name = 'bob'", "SELECT * FROM users WHERE id = 72 AND name = 'al''ice'"},
+		{"SELECT * FROM metrics WHERE value > 1.5e3 LIMIT 10", "SELECT *\n\t FROM metrics\n WHERE value > 0.25 LIMIT 100"},
+		{"DELETE FROM sessions WHERE id IN (?, ?, ?)", "DELETE FROM sessions WHERE id IN (?)"},
+		{"DELETE FROM sessions WHERE id IN (1, 'a')", "DELETE FROM sessions WHERE id IN (?,?,?,?)"},
+		{"INSERT INTO tags (name, value) VALUES (?, ?), (?, ?)", "INSERT INTO tags (name, value) VALUES (\n  ?, ?\n)"},
+	}
+	for _, tt := range tests {
+		if a, b := Fingerprint(tt.a), Fingerprint(tt.b); a != b {
+			t.Errorf("Fingerprints differ:\n%q\n%q", a, b)
+		}
+	}
+
+	if got := Fingerprint("  SELECT id FROM users WHERE name = 'it''s 42' AND age > 30  "); got != "SELECT id FROM users WHERE name = ? AND age > ?" {
+		t.Errorf("Unexpected fingerprint %q", got)
+	}
+	if got := Fingerprint("SELECT sha256, t1.x2 FROM files WHERE id IN (3, 4)"); got != "SELECT sha256, t1.x2 FROM files WHERE id IN (...)" {
+		t.Errorf("Identifiers with digits must be kept, got %q", got)
+	}
+	if Fingerprint("SELECT * FROM users") == Fingerprint("SELECT * FROM services") {
+		t.Error("Statements on different tables must not share a fingerprint")
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Console.Database = config.DatabaseConfig{
+		Path:       filepath.Join(t.TempDir(), "console.db"),
+		QueryStats: config.QueryStatsConfig{Enabled: true, SlowThreshold: "1ns"},
+	}
+	db, err := NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	stats := db.QueryStats()
+	if stats == nil {
+		t.Fatal("Expected query stats when enabled")
+	}
+	stats.Reset()
+	var mu sync.Mutex
+	var slow []SlowQuery
+	stats.SetSlowQueryHook(func(q SlowQuery) {
+		mu.Lock()
+		defer mu.Unlock()
+		slow = append(slow, q)
+	})
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if err := db.UserRepository().Create(&User{Username: user, Email: user + "@example.com", PasswordHash: "secret-hash", Role: "user"}); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+	for _, user := range []string{"alice", "bob", "carol", "dave"} {
+		db.UserRepository().GetByUsername(user)
+	}
+	if _, err := db.Exec("SELECT * FROM missing_table"); err == nil {
+		t.Fatal("Expected querying a missing table to fail")
+	}
+
+	totals := stats.Totals()
+	if totals.Queries != 8 || totals.Errors != 1 || totals.Slow != 8 {
+		t.Errorf("Unexpected totals %+v", totals)
+	}
+
+	// Runs differing only in their values are aggregated together
+	top := stats.Top(0, QueryStatsByCount)
+	if len(top) != 3 {
+		t.Fatalf("Expected 3 statements, got %d: %+v", len(top), top)
+	}
+	lookup := top[0]
+	if lookup.Count != 4 || lookup.Rows != 3 || lookup.Errors != 0 || !strings.Contains(lookup.Fingerprint, "FROM users") {
+		t.Errorf("Unexpected lookup stats %+v", lookup)
+	}
+	if insert := top[1]; insert.Count != 3 || insert.Rows != 3 || !strings.HasPrefix(insert.Fingerprint, "INSERT INTO users") {
+		t.Errorf("Unexpected insert stats %+v", insert)
+	}
+	if lookup.P50MS > lookup.P95MS || lookup.P95MS > lookup.MaxMS || lookup.MeanMS <= 0 {
+		t.Errorf("Unexpected lookup timings %+v", lookup)
+	}
+	if limited := stats.Top(1, QueryStatsByTotal); len(limited) != 1 {
+		t.Errorf("Expected 1 statement, got %d", len(limited))
+	}
+
+	// Slow queries are reported without their values
+	mu.Lock()
+	reported := append([]SlowQuery(nil), slow...)
+	mu.Unlock()
+	if len(reported) != 8 {
+		t.Fatalf("Expected 8 slow queries, got %d", len(reported))
+	}
+	for _, q := range reported {
+		for _, value := range []string{"alice", "bob", "secret-hash", "example.com"} {
+			if strings.Contains(q.Statement, value) {
+				t.Errorf("Slow query %q leaks %q", q.Statement, value)
+			}
+		}
+	}
+	if reported[0].Args == 0 || reported[7].Err == nil {
+		t.Errorf("Unexpected slow queries %+v", reported)
+	}
+
+	dbStats, err := db.GetStats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if dbStats["queries"].(int64) < 8 || dbStats["query_errors"] != int64(1) {
+		t.Errorf("Unexpected stats %v", dbStats)
+	}
+}
+
+func TestQueryStatsDisabled(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Console.Database.Path = filepath.Join(t.TempDir(), "console.db")
+	db, err := NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if db.QueryStats() != nil {
+		t.Fatal("Expected no query stats when disabled")
+	}
+	if _, err := db.UserRepository().GetByUsername("nobody"); err == nil {
+		t.Fatal("Expected no user")
+	}
+	stats, err := db.GetStats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if _, ok := stats["queries"]; ok {
+		t.Error("Expected no query counters when disabled")
+	}
+}
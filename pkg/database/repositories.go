@@ -2,12 +2,114 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Sort directions accepted by ListOptions
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// ErrInvalidListOptions is returned when list options name a column that
+// cannot be sorted or filtered on, or an unknown sort direction
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// ListOptions pages, sorts and filters a list query. Filters match columns to
+// exact values. A zero Limit returns every row after Offset.
+type ListOptions struct {
+	Limit   int
+	Offset  int
+	SortBy  string
+	SortDir string
+	Filters map[string]string
+}
+
+// listQuery describes the columns a paged list may be sorted and filtered by.
+// Only these whitelisted names are ever written into SQL; values are bound as
+// parameters.
+type listQuery struct {
+	table         string
+	columns       string
+	sortColumns   []string
+	filterColumns []string
+	defaultSort   string
+}
+
+// build returns the row query and the matching COUNT query with their arguments
+func (q listQuery) build(opts ListOptions) (query string, args []interface{}, countQuery string, countArgs []interface{}, err error) {
+	sortBy := opts.SortBy
+	if sortBy == "" {
+		sortBy = q.defaultSort
+	}
+	if !containsString(q.sortColumns, sortBy) {
+		return "", nil, "", nil, fmt.Errorf("%w: cannot sort by %q", ErrInvalidListOptions, sortBy)
+	}
+
+	sortDir := strings.ToLower(opts.SortDir)
+	switch sortDir {
+	case "":
+		sortDir = SortDesc
+	case SortAsc, SortDesc:
+	default:
+		return "", nil, "", nil, fmt.Errorf("%w: unknown sort direction %q", ErrInvalidListOptions, opts.SortDir)
+	}
+
+	if opts.Limit < 0 || opts.Offset < 0 {
+		return "", nil, "", nil, fmt.Errorf("%w: limit and offset must not be negative", ErrInvalidListOptions)
+	}
+
+	// Sort filter columns so the generated SQL is stable
+	filterColumns := make([]string, 0, len(opts.Filters))
+	for column := range opts.Filters {
+		if !containsString(q.filterColumns, column) {
+			return "", nil, "", nil, fmt.Errorf("%w: cannot filter by %q", ErrInvalidListOptions, column)
+		}
+		filterColumns = append(filterColumns, column)
+	}
+	sort.Strings(filterColumns)
+
+	where := ""
+	for i, column := range filterColumns {
+		if i == 0 {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		where += column + " = ?"
+		countArgs = append(countArgs, opts.Filters[column])
+	}
+
+	limit := opts.Limit
+	if limit == 0 {
+		limit = -1 // no limit
+	}
+
+	query = "SELECT " + q.columns + " FROM " + q.table + where +
+		" ORDER BY " + sortBy + " " + sortDir + ", rowid " + sortDir + " LIMIT ? OFFSET ?"
+	args = append(append(args, countArgs...), limit, opts.Offset)
+	countQuery = "SELECT COUNT(*) FROM " + q.table + where
+	return query, args, countQuery, countArgs, nil
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 // UserRepository provides database operations for users
 type UserRepository struct {
 	db *DB
@@ -21,8 +123,8 @@ func NewUserRepository(db *DB) *UserRepository {
 // Create creates a new user
 func (r *UserRepository) Create(user *User) error {
 	query := `
-		INSERT INTO users (username, email, password_hash, role, totp_secret)
-		VALUES (:username, :email, :password_hash, :role, :totp_secret)
+		INSERT INTO users (username, email, display_name, password_hash, role, totp_secret, totp_enabled)
+		VALUES (:username, :email, :display_name, :password_hash, :role, :totp_secret, :totp_enabled)
 	`
 	result, err := r.db.NamedExec(query, user)
 	if err != nil {
@@ -38,13 +140,57 @@ func (r *UserRepository) Create(user *User) error {
 	return nil
 }
 
+// ErrAdminExists is returned when creating the first admin after an admin
+// account has already been created
+var ErrAdminExists = errors.New("an admin account already exists")
+
+// CreateFirstAdmin creates user as an admin, only if no admin exists yet. The
+// check and insert are a single statement, so concurrent setups cannot both
+// create an admin.
+func (r *UserRepository) CreateFirstAdmin(user *User) error {
+	query := `
+		INSERT INTO users (username, email, display_name, password_hash, role, totp_enabled)
+		SELECT ?, ?, ?, ?, 'admin', 0
+		WHERE NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin')
+	`
+	result, err := r.db.Exec(query, user.Username, user.Email, user.DisplayName, user.PasswordHash)
+	if err != nil {
+		return fmt.Errorf("failed to create admin: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to create admin: %w", err)
+	}
+	if rows == 0 {
+		return ErrAdminExists
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get user ID: %w", err)
+	}
+	user.ID = int(id)
+	user.Role = "admin"
+	return nil
+}
+
+// CountByRole counts the users with a role
+func (r *UserRepository) CountByRole(role string) (int, error) {
+	var count int
+	if err := r.db.Get(&count, "SELECT COUNT(*) FROM users WHERE role = ?", role); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
 // GetByID gets a user by ID
 func (r *UserRepository) GetByID(id int) (*User, error) {
 	var user User
 	query := "SELECT * FROM users WHERE id = ?"
 	err := r.db.Get(&user, query, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user by ID: %w", err)
+		return nil, fmt.Errorf("failed to get user by ID: %w", notFound(err))
 	}
 	return &user, nil
 }
@@ -55,7 +201,18 @@ func (r *UserRepository) GetByUsername(username string) (*User, error) {
 	query := "SELECT * FROM users WHERE username = ?"
 	err := r.db.Get(&user, query, username)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user by username: %w", err)
+		return nil, fmt.Errorf("failed to get user by username: %w", notFound(err))
+	}
+	return &user, nil
+}
+
+// GetByEmail gets a user by email
+func (r *UserRepository) GetByEmail(email string) (*User, error) {
+	var user User
+	query := "SELECT * FROM users WHERE email = ?"
+	err := r.db.Get(&user, query, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by email: %w", notFound(err))
 	}
 	return &user, nil
 }
@@ -64,34 +221,56 @@ func (r *UserRepository) GetByUsername(username string) (*User, error) {
 func (r *UserRepository) Update(user *User) error {
 	query := `
 		UPDATE users 
-		SET username = :username, email = :email, password_hash = :password_hash, 
-		    role = :role, totp_secret = :totp_secret, last_login = :last_login
+		SET username = :username, email = :email, display_name = :display_name, password_hash = :password_hash, 
+		    role = :role, totp_secret = :totp_secret, totp_enabled = :totp_enabled, last_login = :last_login
 		WHERE id = :id
 	`
-	_, err := r.db.NamedExec(query, user)
+	result, err := r.db.NamedExec(query, user)
 	if err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
+	if err := affectedRow(result); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
 	return nil
 }
 
 // UpdateLastLogin updates the user's last login timestamp
 func (r *UserRepository) UpdateLastLogin(userID int) error {
 	query := "UPDATE users SET last_login = CURRENT_TIMESTAMP WHERE id = ?"
-	_, err := r.db.Exec(query, userID)
+	result, err := r.db.Exec(query, userID)
 	if err != nil {
 		return fmt.Errorf("failed to update last login: %w", err)
 	}
+	if err := affectedRow(result); err != nil {
+		return fmt.Errorf("failed to update last login: %w", err)
+	}
+	return nil
+}
+
+// UpdateTOTP stores a user's TOTP secret and whether two-factor login is enabled
+func (r *UserRepository) UpdateTOTP(userID int, secret *string, enabled bool) error {
+	query := "UPDATE users SET totp_secret = ?, totp_enabled = ? WHERE id = ?"
+	result, err := r.db.Exec(query, secret, enabled, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update TOTP settings: %w", err)
+	}
+	if err := affectedRow(result); err != nil {
+		return fmt.Errorf("failed to update TOTP settings: %w", err)
+	}
 	return nil
 }
 
 // Delete deletes a user account
 func (r *UserRepository) Delete(userID int) error {
 	query := "DELETE FROM users WHERE id = ?"
-	_, err := r.db.Exec(query, userID)
+	result, err := r.db.Exec(query, userID)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
+	if err := affectedRow(result); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
 	return nil
 }
 
@@ -111,11 +290,17 @@ func (r *RegisteredServiceRepository) Create(service *RegisteredService) error {
 		service.ID = uuid.New().String()
 	}
 
+	orgID, err := r.db.ownerOrg(service.OrgID)
+	if err != nil {
+		return fmt.Errorf("failed to create registered service: %w", err)
+	}
+	service.OrgID = orgID
+
 	query := `
-		INSERT INTO registered_services (id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO registered_services (id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, proxy_enabled, proxy_path, org_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := r.db.Exec(query, service.ID, service.Name, service.DisplayName, service.Description, service.ServiceURL, service.CallbackURL, service.Icon, service.Category, service.IsPublic, service.RequiredRole, service.Status, service.HealthURL)
+	_, err = r.db.Exec(query, service.ID, service.Name, service.DisplayName, service.Description, service.ServiceURL, service.CallbackURL, service.Icon, service.Category, service.IsPublic, service.RequiredRole, service.Status, service.HealthURL, service.ProxyEnabled, service.ProxyPath, service.OrgID)
 	if err != nil {
 		return fmt.Errorf("failed to create registered service: %w", err)
 	}
@@ -126,10 +311,11 @@ func (r *RegisteredServiceRepository) Create(service *RegisteredService) error {
 // GetByID gets a registered service by ID
 func (r *RegisteredServiceRepository) GetByID(id string) (*RegisteredService, error) {
 	var service RegisteredService
-	query := `SELECT id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, last_healthy, created_at, updated_at FROM registered_services WHERE id = ?`
-	err := r.db.Get(&service, query, id)
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	query := `SELECT id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, last_healthy, is_healthy, failure_streak, proxy_enabled, proxy_path, org_id, created_at, updated_at FROM registered_services WHERE id = ?` + scope
+	err := r.db.Get(&service, query, append([]interface{}{id}, scopeArgs...)...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get registered service: %w", err)
+		return nil, fmt.Errorf("failed to get registered service: %w", notFound(err))
 	}
 
 	return &service, nil
@@ -138,10 +324,24 @@ func (r *RegisteredServiceRepository) GetByID(id string) (*RegisteredService, er
 // GetByName gets a registered service by name
 func (r *RegisteredServiceRepository) GetByName(name string) (*RegisteredService, error) {
 	var service RegisteredService
-	query := `SELECT id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, last_healthy, created_at, updated_at FROM registered_services WHERE name = ?`
-	err := r.db.Get(&service, query, name)
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	query := `SELECT id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, last_healthy, is_healthy, failure_streak, proxy_enabled, proxy_path, org_id, created_at, updated_at FROM registered_services WHERE name = ?` + scope
+	err := r.db.Get(&service, query, append([]interface{}{name}, scopeArgs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get registered service: %w", notFound(err))
+	}
+
+	return &service, nil
+}
+
+// GetByProxyPath gets the registered service with a proxy path. Proxy paths
+// are unique across organizations, so it is not scoped to one.
+func (r *RegisteredServiceRepository) GetByProxyPath(proxyPath string) (*RegisteredService, error) {
+	var service RegisteredService
+	query := `SELECT id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, last_healthy, is_healthy, failure_streak, proxy_enabled, proxy_path, org_id, created_at, updated_at FROM registered_services WHERE proxy_path = ?`
+	err := r.db.Get(&service, query, proxyPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get registered service: %w", err)
+		return nil, fmt.Errorf("failed to get registered service: %w", notFound(err))
 	}
 
 	return &service, nil
@@ -149,8 +349,9 @@ func (r *RegisteredServiceRepository) GetByName(name string) (*RegisteredService
 
 // List lists all registered services
 func (r *RegisteredServiceRepository) List() ([]*RegisteredService, error) {
-	query := `SELECT id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, last_healthy, created_at, updated_at FROM registered_services ORDER BY created_at DESC`
-	rows, err := r.db.Query(query)
+	scope, scopeArgs := r.db.orgWhere("org_id")
+	query := `SELECT id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, last_healthy, is_healthy, failure_streak, proxy_enabled, proxy_path, org_id, created_at, updated_at FROM registered_services` + scope + ` ORDER BY created_at DESC`
+	rows, err := r.db.Query(query, scopeArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query registered services: %w", err)
 	}
@@ -159,7 +360,7 @@ func (r *RegisteredServiceRepository) List() ([]*RegisteredService, error) {
 	var services []*RegisteredService
 	for rows.Next() {
 		var service RegisteredService
-		err := rows.Scan(&service.ID, &service.Name, &service.DisplayName, &service.Description, &service.ServiceURL, &service.CallbackURL, &service.Icon, &service.Category, &service.IsPublic, &service.RequiredRole, &service.Status, &service.HealthURL, &service.LastHealthy, &service.CreatedAt, &service.UpdatedAt)
+		err := rows.Scan(&service.ID, &service.Name, &service.DisplayName, &service.Description, &service.ServiceURL, &service.CallbackURL, &service.Icon, &service.Category, &service.IsPublic, &service.RequiredRole, &service.Status, &service.HealthURL, &service.LastHealthy, &service.IsHealthy, &service.FailureStreak, &service.ProxyEnabled, &service.ProxyPath, &service.OrgID, &service.CreatedAt, &service.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan registered service: %w", err)
 		}
@@ -169,10 +370,42 @@ func (r *RegisteredServiceRepository) List() ([]*RegisteredService, error) {
 	return services, nil
 }
 
+// registeredServiceListQuery defines how registered services can be paged,
+// sorted and filtered
+var registeredServiceListQuery = listQuery{
+	table:         "registered_services",
+	columns:       "id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, last_healthy, is_healthy, failure_streak, proxy_enabled, proxy_path, org_id, created_at, updated_at",
+	sortColumns:   []string{"name", "display_name", "category", "status", "created_at", "updated_at"},
+	filterColumns: []string{"category", "status", "required_role", "org_id"},
+	defaultSort:   "created_at",
+}
+
+// ListPaged lists a page of registered services with the total number
+// matching the filters
+func (r *RegisteredServiceRepository) ListPaged(opts ListOptions) ([]*RegisteredService, int, error) {
+	opts.Filters = r.db.orgFilters(opts.Filters)
+	query, args, countQuery, countArgs, err := registeredServiceListQuery.build(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := r.db.Get(&total, countQuery, countArgs...); err != nil {
+		return nil, 0, fmt.Errorf("failed to count registered services: %w", err)
+	}
+
+	var services []*RegisteredService
+	if err := r.db.Select(&services, query, args...); err != nil {
+		return nil, 0, fmt.Errorf("failed to list registered services: %w", err)
+	}
+	return services, total, nil
+}
+
 // ListByCategory lists registered services by category
 func (r *RegisteredServiceRepository) ListByCategory(category string) ([]*RegisteredService, error) {
-	query := `SELECT id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, last_healthy, created_at, updated_at FROM registered_services WHERE category = ? ORDER BY display_name`
-	rows, err := r.db.Query(query, category)
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	query := `SELECT id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, last_healthy, is_healthy, failure_streak, proxy_enabled, proxy_path, org_id, created_at, updated_at FROM registered_services WHERE category = ?` + scope + ` ORDER BY display_name`
+	rows, err := r.db.Query(query, append([]interface{}{category}, scopeArgs...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query registered services by category: %w", err)
 	}
@@ -181,7 +414,7 @@ func (r *RegisteredServiceRepository) ListByCategory(category string) ([]*Regist
 	var services []*RegisteredService
 	for rows.Next() {
 		var service RegisteredService
-		err := rows.Scan(&service.ID, &service.Name, &service.DisplayName, &service.Description, &service.ServiceURL, &service.CallbackURL, &service.Icon, &service.Category, &service.IsPublic, &service.RequiredRole, &service.Status, &service.HealthURL, &service.LastHealthy, &service.CreatedAt, &service.UpdatedAt)
+		err := rows.Scan(&service.ID, &service.Name, &service.DisplayName, &service.Description, &service.ServiceURL, &service.CallbackURL, &service.Icon, &service.Category, &service.IsPublic, &service.RequiredRole, &service.Status, &service.HealthURL, &service.LastHealthy, &service.IsHealthy, &service.FailureStreak, &service.ProxyEnabled, &service.ProxyPath, &service.OrgID, &service.CreatedAt, &service.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan registered service: %w", err)
 		}
@@ -195,45 +428,124 @@ func (r *RegisteredServiceRepository) ListByCategory(category string) ([]*Regist
 func (r *RegisteredServiceRepository) Update(service *RegisteredService) error {
 	query := `
 		UPDATE registered_services 
-		SET display_name = ?, description = ?, service_url = ?, callback_url = ?, icon = ?, category = ?, is_public = ?, required_role = ?, status = ?, health_url = ?
-		WHERE id = ?
-	`
-	_, err := r.db.Exec(query, service.DisplayName, service.Description, service.ServiceURL, service.CallbackURL, service.Icon, service.Category, service.IsPublic, service.RequiredRole, service.Status, service.HealthURL, service.ID)
+		SET display_name = ?, description = ?, service_url = ?, callback_url = ?, icon = ?, category = ?, is_public = ?, required_role = ?, status = ?, health_url = ?, proxy_enabled = ?, proxy_path = ?
+		WHERE id = ?`
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	result, err := r.db.Exec(query+scope, append([]interface{}{service.DisplayName, service.Description, service.ServiceURL, service.CallbackURL, service.Icon, service.Category, service.IsPublic, service.RequiredRole, service.Status, service.HealthURL, service.ProxyEnabled, service.ProxyPath, service.ID}, scopeArgs...)...)
 	if err != nil {
 		return fmt.Errorf("failed to update registered service: %w", err)
 	}
+	if err := affectedRow(result); err != nil {
+		return fmt.Errorf("failed to update registered service: %w", err)
+	}
 
 	return nil
 }
 
-// UpdateHealthStatus updates the health status of a service
-func (r *RegisteredServiceRepository) UpdateHealthStatus(serviceID string, isHealthy bool) error {
-	var lastHealthy *time.Time
-	if isHealthy {
-		now := time.Now()
-		lastHealthy = &now
+// UpdateHealthStatus records the result of a service's latest health check,
+// resetting its failure streak and last healthy time when healthy and
+// extending the streak otherwise. It returns the failure streak after the
+// update.
+func (r *RegisteredServiceRepository) UpdateHealthStatus(serviceID string, isHealthy bool) (int, error) {
+	query := `
+		UPDATE registered_services
+		SET is_healthy = ?,
+			last_healthy = CASE WHEN ? THEN ? ELSE last_healthy END,
+			failure_streak = CASE WHEN ? THEN 0 ELSE failure_streak + 1 END
+		WHERE id = ?
+		RETURNING failure_streak
+	`
+	var streak int
+	if err := r.db.Get(&streak, query, isHealthy, isHealthy, time.Now(), isHealthy, serviceID); err != nil {
+		return 0, fmt.Errorf("failed to update service health status: %w", err)
+	}
+
+	return streak, nil
+}
+
+// ListUnhealthy lists the services whose latest health check failed, longest
+// failing first
+func (r *RegisteredServiceRepository) ListUnhealthy() ([]*RegisteredService, error) {
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	query := `SELECT id, name, display_name, description, service_url, callback_url, icon, category, is_public, required_role, status, health_url, last_healthy, is_healthy, failure_streak, proxy_enabled, proxy_path, org_id, created_at, updated_at FROM registered_services WHERE NOT is_healthy` + scope + ` ORDER BY failure_streak DESC, name`
+	var services []*RegisteredService
+	if err := r.db.Select(&services, query, scopeArgs...); err != nil {
+		return nil, fmt.Errorf("failed to list unhealthy registered services: %w", err)
+	}
+
+	return services, nil
+}
+
+// healthSummaryQuery joins registered services with their latest health
+// check, looked up through the (service_id, checked_at) index
+const healthSummaryQuery = `
+	SELECT rs.id AS service_id, rs.name, rs.display_name, rs.status, rs.failure_streak, rs.last_healthy,
+	       hc.id AS check_id, hc.is_healthy, hc.response_time, hc.error_message, hc.checked_at
+	FROM registered_services rs
+	LEFT JOIN service_health_checks hc ON hc.id = (
+		SELECT id FROM service_health_checks
+		WHERE service_id = rs.id
+		ORDER BY checked_at DESC, id DESC LIMIT 1
+	)
+`
+
+// GetHealthSummary returns every service with its latest health check in a
+// single query
+func (r *RegisteredServiceRepository) GetHealthSummary() ([]*ServiceHealthSummary, error) {
+	scope, scopeArgs := r.db.orgWhere("rs.org_id")
+	summaries := []*ServiceHealthSummary{}
+	if err := r.db.Select(&summaries, healthSummaryQuery+scope+" ORDER BY rs.display_name, rs.name", scopeArgs...); err != nil {
+		return nil, fmt.Errorf("failed to get service health summary: %w", err)
+	}
+
+	return summaries, nil
+}
+
+// GetServiceHealthSummary returns a service with its latest health check
+func (r *RegisteredServiceRepository) GetServiceHealthSummary(serviceID string) (*ServiceHealthSummary, error) {
+	scope, scopeArgs := r.db.orgCondition("rs.org_id")
+	var summary ServiceHealthSummary
+	if err := r.db.Get(&summary, healthSummaryQuery+" WHERE rs.id = ?"+scope, append([]interface{}{serviceID}, scopeArgs...)...); err != nil {
+		return nil, fmt.Errorf("failed to get service health summary: %w", notFound(err))
 	}
 
-	query := `UPDATE registered_services SET last_healthy = ? WHERE id = ?`
-	_, err := r.db.Exec(query, lastHealthy, serviceID)
+	return &summary, nil
+}
+
+// TransitionStatus changes the status of a service if it is still from. It
+// reports whether the status changed.
+func (r *RegisteredServiceRepository) TransitionStatus(serviceID, from, to string) (bool, error) {
+	query := `UPDATE registered_services SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
+	result, err := r.db.Exec(query, to, serviceID, from)
 	if err != nil {
-		return fmt.Errorf("failed to update service health status: %w", err)
+		return false, fmt.Errorf("failed to update service status: %w", err)
 	}
 
-	return nil
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update service status: %w", err)
+	}
+	return rows > 0, nil
 }
 
 // Delete deletes a registered service
 func (r *RegisteredServiceRepository) Delete(serviceID string) error {
-	query := `DELETE FROM registered_services WHERE id = ?`
-	_, err := r.db.Exec(query, serviceID)
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	query := `DELETE FROM registered_services WHERE id = ?` + scope
+	result, err := r.db.Exec(query, append([]interface{}{serviceID}, scopeArgs...)...)
 	if err != nil {
 		return fmt.Errorf("failed to delete registered service: %w", err)
 	}
+	if err := affectedRow(result); err != nil {
+		return fmt.Errorf("failed to delete registered service: %w", err)
+	}
 
 	return nil
 }
 
+// ErrSSOSessionNotFound is returned when an SSO session does not exist
+var ErrSSOSessionNotFound = fmt.Errorf("SSO session %w", ErrNotFound)
+
 // SSOSessionRepository provides database operations for SSO sessions
 type SSOSessionRepository struct {
 	db *DB
@@ -265,15 +577,41 @@ func (r *SSOSessionRepository) Create(session *SSOSession) error {
 // GetByTokenHash gets an SSO session by token hash
 func (r *SSOSessionRepository) GetByTokenHash(tokenHash string) (*SSOSession, error) {
 	var session SSOSession
-	query := `SELECT id, user_id, token_hash, expires_at, ip_address, user_agent, is_active, last_used, created_at FROM sso_sessions WHERE token_hash = ? AND is_active = TRUE AND expires_at > ?`
+	query := `SELECT id, user_id, token_hash, expires_at, ip_address, user_agent, is_active, last_used, created_at, last_handoff_ip, last_handoff_at FROM sso_sessions WHERE token_hash = ? AND is_active = TRUE AND expires_at > ?`
 	err := r.db.Get(&session, query, tokenHash, time.Now())
 	if err != nil {
-		return nil, fmt.Errorf("failed to get SSO session: %w", err)
+		return nil, fmt.Errorf("failed to get SSO session: %w", notFound(err))
+	}
+
+	return &session, nil
+}
+
+// GetByID gets an SSO session by ID, whether or not it is still active
+func (r *SSOSessionRepository) GetByID(sessionID string) (*SSOSession, error) {
+	var session SSOSession
+	query := `SELECT id, user_id, token_hash, expires_at, ip_address, user_agent, is_active, last_used, created_at, last_handoff_ip, last_handoff_at FROM sso_sessions WHERE id = ?`
+	err := r.db.Get(&session, query, sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSSOSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SSO session: %w", notFound(err))
 	}
 
 	return &session, nil
 }
 
+// ListActiveByUser lists a user's active, unexpired sessions, most recently used first
+func (r *SSOSessionRepository) ListActiveByUser(userID int) ([]*SSOSession, error) {
+	var sessions []*SSOSession
+	query := `SELECT id, user_id, token_hash, expires_at, ip_address, user_agent, is_active, last_used, created_at, last_handoff_ip, last_handoff_at FROM sso_sessions WHERE user_id = ? AND is_active = TRUE AND expires_at > ? ORDER BY last_used DESC, created_at DESC`
+	if err := r.db.Select(&sessions, query, userID, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to list SSO sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
 // UpdateLastUsed updates the last used timestamp for a session
 func (r *SSOSessionRepository) UpdateLastUsed(sessionID string) error {
 	query := `UPDATE sso_sessions SET last_used = ? WHERE id = ?`
@@ -307,17 +645,122 @@ func (r *SSOSessionRepository) InvalidateUserSessions(userID int) error {
 	return nil
 }
 
-// CleanupExpiredSessions removes expired sessions from the database
-func (r *SSOSessionRepository) CleanupExpiredSessions() error {
+// InvalidateOtherUserSessions invalidates all sessions for a user except
+// keepSessionID, returning how many were invalidated
+func (r *SSOSessionRepository) InvalidateOtherUserSessions(userID int, keepSessionID string) (int64, error) {
+	query := `UPDATE sso_sessions SET is_active = FALSE WHERE user_id = ? AND id != ? AND is_active = TRUE`
+	result, err := r.db.Exec(query, userID, keepSessionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to invalidate user sessions: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to invalidate user sessions: %w", err)
+	}
+	return rows, nil
+}
+
+// CleanupExpiredSessions removes sessions that expired before now and
+// returns how many were removed
+func (r *SSOSessionRepository) CleanupExpiredSessions(now time.Time) (int64, error) {
 	query := `DELETE FROM sso_sessions WHERE expires_at < ?`
-	_, err := r.db.Exec(query, time.Now())
+	result, err := r.db.Exec(query, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cleanup expired sessions: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// ErrSSOLaunchTokenNotFound is returned when a token was not minted for a
+// launch URL
+var ErrSSOLaunchTokenNotFound = fmt.Errorf("SSO launch token %w", ErrNotFound)
+
+// ErrSSOLaunchTokenUsed is returned when a launch token has already been
+// redeemed or has expired
+var ErrSSOLaunchTokenUsed = errors.New("SSO launch token already used or expired")
+
+// SSOLaunchTokenRepository provides database operations for the SSO tokens
+// of service launch URLs
+type SSOLaunchTokenRepository struct {
+	db *DB
+}
+
+// NewSSOLaunchTokenRepository creates a new SSO launch token repository
+func NewSSOLaunchTokenRepository(db *DB) *SSOLaunchTokenRepository {
+	return &SSOLaunchTokenRepository{db: db}
+}
+
+// Create stores a launch token
+func (r *SSOLaunchTokenRepository) Create(token *SSOLaunchToken) error {
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO sso_launch_tokens (token_hash, user_id, service_id, session_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := r.db.Exec(query, token.TokenHash, token.UserID, token.ServiceID, token.SessionID,
+		formatTimestamp(token.ExpiresAt), formatTimestamp(token.CreatedAt))
 	if err != nil {
-		return fmt.Errorf("failed to cleanup expired sessions: %w", err)
+		return fmt.Errorf("failed to create SSO launch token: %w", err)
 	}
 
 	return nil
 }
 
+// Consume redeems a launch token from ip at now, recording the handoff in
+// the SSO session the token was minted in. It returns
+// ErrSSOLaunchTokenNotFound for tokens not minted for a launch URL, and
+// ErrSSOLaunchTokenUsed for ones already redeemed or expired.
+func (r *SSOLaunchTokenRepository) Consume(tokenHash, ip string, now time.Time) (*SSOLaunchToken, error) {
+	var token SSOLaunchToken
+	err := r.db.WithTx(r.db.context(), func(tx *DB) error {
+		err := tx.Get(&token, "SELECT * FROM sso_launch_tokens WHERE token_hash = ?", tokenHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSSOLaunchTokenNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get SSO launch token: %w", err)
+		}
+		if token.ConsumedAt != nil || !now.Before(token.ExpiresAt) {
+			return ErrSSOLaunchTokenUsed
+		}
+
+		query := `UPDATE sso_launch_tokens SET consumed_at = ?, consumed_ip = ? WHERE token_hash = ? AND consumed_at IS NULL`
+		if _, err := tx.Exec(query, formatTimestamp(now), ip, tokenHash); err != nil {
+			return fmt.Errorf("failed to consume SSO launch token: %w", err)
+		}
+		token.ConsumedAt, token.ConsumedIP = &now, &ip
+
+		if token.SessionID != nil {
+			query := `UPDATE sso_sessions SET last_handoff_ip = ?, last_handoff_at = ?, last_used = ? WHERE id = ?`
+			if _, err := tx.Exec(query, ip, formatTimestamp(now), now, *token.SessionID); err != nil {
+				return fmt.Errorf("failed to record SSO handoff: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// DeleteExpired removes launch tokens that expired before now and returns
+// how many were removed
+func (r *SSOLaunchTokenRepository) DeleteExpired(now time.Time) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM sso_launch_tokens WHERE expires_at < ?`, formatTimestamp(now))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired SSO launch tokens: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
 // UserServicePermissionRepository provides database operations for user service permissions
 type UserServicePermissionRepository struct {
 	db *DB
@@ -369,31 +812,45 @@ func (r *UserServicePermissionRepository) CheckPermission(userID int, serviceID
 	return canAccess, nil
 }
 
-// ListUserServices lists all services a user has access to
-func (r *UserServicePermissionRepository) ListUserServices(userID int) ([]*RegisteredService, error) {
+// ListUserServices lists all services a user has access to with the user's
+// preferences for them: pinned services first in their sort order, then the
+// rest by most recent access, and services never accessed by category and
+// name
+func (r *UserServicePermissionRepository) ListUserServices(userID int) ([]*UserService, error) {
 	query := `
-		SELECT rs.id, rs.name, rs.display_name, rs.description, rs.service_url, rs.callback_url, rs.icon, rs.category, rs.is_public, rs.required_role, rs.status, rs.health_url, rs.last_healthy, rs.created_at, rs.updated_at
+		SELECT rs.id, rs.name, rs.display_name, rs.description, rs.service_url, rs.callback_url, rs.icon, rs.category, rs.is_public, rs.required_role, rs.status, rs.health_url, rs.last_healthy, rs.is_healthy, rs.failure_streak, rs.proxy_enabled, rs.proxy_path, rs.org_id, rs.created_at, rs.updated_at,
+		       COALESCE(pref.pinned, FALSE), COALESCE(pref.sort_order, 0), pref.last_accessed
 		FROM registered_services rs
 		LEFT JOIN user_service_permissions usp ON rs.id = usp.service_id AND usp.user_id = ?
+		LEFT JOIN user_service_preferences pref ON rs.id = pref.service_id AND pref.user_id = ?
 		WHERE rs.status = 'active' AND (
 			rs.is_public = TRUE OR 
 			(usp.can_access = TRUE AND (usp.expires_at IS NULL OR usp.expires_at > ?))
-		)
-		ORDER BY rs.category, rs.display_name
+		)%s
+		ORDER BY COALESCE(pref.pinned, FALSE) DESC,
+		         CASE WHEN pref.pinned THEN pref.sort_order END,
+		         pref.last_accessed IS NULL, pref.last_accessed DESC,
+		         rs.category, rs.display_name
 	`
-	rows, err := r.db.Query(query, userID, time.Now())
+	scope, scopeArgs := r.db.orgCondition("rs.org_id")
+	rows, err := r.db.Query(fmt.Sprintf(query, scope), append([]interface{}{userID, userID, time.Now()}, scopeArgs...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list user services: %w", err)
 	}
 	defer rows.Close()
 
-	var services []*RegisteredService
+	var services []*UserService
 	for rows.Next() {
-		var service RegisteredService
-		err := rows.Scan(&service.ID, &service.Name, &service.DisplayName, &service.Description, &service.ServiceURL, &service.CallbackURL, &service.Icon, &service.Category, &service.IsPublic, &service.RequiredRole, &service.Status, &service.HealthURL, &service.LastHealthy, &service.CreatedAt, &service.UpdatedAt)
+		var service UserService
+		var lastAccessed sql.NullTime
+		err := rows.Scan(&service.ID, &service.Name, &service.DisplayName, &service.Description, &service.ServiceURL, &service.CallbackURL, &service.Icon, &service.Category, &service.IsPublic, &service.RequiredRole, &service.Status, &service.HealthURL, &service.LastHealthy, &service.IsHealthy, &service.FailureStreak, &service.ProxyEnabled, &service.ProxyPath, &service.OrgID, &service.CreatedAt, &service.UpdatedAt,
+			&service.Pinned, &service.SortOrder, &lastAccessed)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user service: %w", err)
 		}
+		if lastAccessed.Valid {
+			service.LastAccessed = &lastAccessed.Time
+		}
 		services = append(services, &service)
 	}
 
@@ -460,6 +917,76 @@ func (r *UserServicePermissionRepository) ListServicePermissions(serviceID strin
 	return permissions, nil
 }
 
+// UserServicePreferenceRepository provides database operations for users'
+// preferences for registered services
+type UserServicePreferenceRepository struct {
+	db *DB
+}
+
+// NewUserServicePreferenceRepository creates a new user service preference repository
+func NewUserServicePreferenceRepository(db *DB) *UserServicePreferenceRepository {
+	return &UserServicePreferenceRepository{db: db}
+}
+
+// Get gets a user's preferences for a service, which are the defaults if the
+// user has none
+func (r *UserServicePreferenceRepository) Get(userID int, serviceID string) (*UserServicePreference, error) {
+	var preference UserServicePreference
+	query := `SELECT * FROM user_service_preferences WHERE user_id = ? AND service_id = ?`
+	err := r.db.Get(&preference, query, userID, serviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &UserServicePreference{UserID: userID, ServiceID: serviceID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service preference: %w", notFound(err))
+	}
+	return &preference, nil
+}
+
+// Pin pins a service for a user at sortOrder. Without a sort order a newly
+// pinned service goes after the user's other pinned services, and a pinned
+// one keeps its place.
+func (r *UserServicePreferenceRepository) Pin(userID int, serviceID string, sortOrder *int) error {
+	query := `
+		INSERT INTO user_service_preferences (user_id, service_id, pinned, sort_order)
+		VALUES (?, ?, TRUE, COALESCE(?, (SELECT COALESCE(MAX(sort_order) + 1, 0) FROM user_service_preferences WHERE user_id = ? AND pinned)))
+		ON CONFLICT (user_id, service_id) DO UPDATE SET
+			sort_order = CASE WHEN pinned AND ? IS NULL THEN sort_order ELSE excluded.sort_order END,
+			pinned = TRUE
+	`
+	_, err := r.db.Exec(query, userID, serviceID, sortOrder, userID, sortOrder)
+	if err != nil {
+		return fmt.Errorf("failed to pin service: %w", err)
+	}
+
+	return nil
+}
+
+// Unpin unpins a service for a user
+func (r *UserServicePreferenceRepository) Unpin(userID int, serviceID string) error {
+	query := `UPDATE user_service_preferences SET pinned = FALSE, sort_order = 0 WHERE user_id = ? AND service_id = ?`
+	_, err := r.db.Exec(query, userID, serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to unpin service: %w", err)
+	}
+
+	return nil
+}
+
+// RecordVisit records that a user launched a service at the given time
+func (r *UserServicePreferenceRepository) RecordVisit(userID int, serviceID string, at time.Time) error {
+	query := `
+		INSERT INTO user_service_preferences (user_id, service_id, last_accessed) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, service_id) DO UPDATE SET last_accessed = excluded.last_accessed
+	`
+	_, err := r.db.Exec(query, userID, serviceID, formatTimestamp(at))
+	if err != nil {
+		return fmt.Errorf("failed to record service visit: %w", err)
+	}
+
+	return nil
+}
+
 // ServiceHealthCheckRepository provides database operations for service health checks
 type ServiceHealthCheckRepository struct {
 	db *DB
@@ -502,7 +1029,7 @@ func (r *ServiceHealthCheckRepository) GetLatest(serviceID string) (*ServiceHeal
 	`
 	err := r.db.Get(&check, query, serviceID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get latest health check: %w", err)
+		return nil, fmt.Errorf("failed to get latest health check: %w", notFound(err))
 	}
 
 	return &check, nil
@@ -536,15 +1063,16 @@ func (r *ServiceHealthCheckRepository) GetHistory(serviceID string, limit int) (
 	return checks, nil
 }
 
-// CleanupOldChecks removes old health check records
-func (r *ServiceHealthCheckRepository) CleanupOldChecks(olderThan time.Time) error {
+// CleanupOldChecks removes health check records older than olderThan and
+// returns how many were removed
+func (r *ServiceHealthCheckRepository) CleanupOldChecks(olderThan time.Time) (int64, error) {
 	query := `DELETE FROM service_health_checks WHERE checked_at < ?`
-	_, err := r.db.Exec(query, olderThan)
+	result, err := r.db.Exec(query, olderThan)
 	if err != nil {
-		return fmt.Errorf("failed to cleanup old health checks: %w", err)
+		return 0, fmt.Errorf("failed to cleanup old health checks: %w", err)
 	}
 
-	return nil
+	return result.RowsAffected()
 }
 
 // List lists all users
@@ -558,6 +1086,34 @@ func (r *UserRepository) List() ([]*User, error) {
 	return users, nil
 }
 
+// userListQuery defines how users can be paged, sorted and filtered
+var userListQuery = listQuery{
+	table:         "users",
+	columns:       "*",
+	sortColumns:   []string{"id", "username", "email", "role", "created_at", "updated_at", "last_login"},
+	filterColumns: []string{"role"},
+	defaultSort:   "created_at",
+}
+
+// ListPaged lists a page of users with the total number matching the filters
+func (r *UserRepository) ListPaged(opts ListOptions) ([]*User, int, error) {
+	query, args, countQuery, countArgs, err := userListQuery.build(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := r.db.Get(&total, countQuery, countArgs...); err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	var users []*User
+	if err := r.db.Select(&users, query, args...); err != nil {
+		return nil, 0, fmt.Errorf("failed to list users: %w", err)
+	}
+	return users, total, nil
+}
+
 // ServiceRepository provides database operations for services
 type ServiceRepository struct {
 	db *DB
@@ -590,12 +1146,18 @@ func (r *ServiceRepository) Create(service *Service) error {
 		return fmt.Errorf("failed to marshal args: %w", err)
 	}
 
+	orgID, err := r.db.ownerOrg(service.OrgID)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	service.OrgID = orgID
+
 	query := `
-		INSERT INTO services (id, name, image, port, replicas, status, environment, command, args, yaml_config, version)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO services (id, name, image, port, replicas, status, environment, command, args, yaml_config, version, org_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	_, err = r.db.Exec(query, service.ID, service.Name, service.Image, service.Port, service.Replicas,
-		service.Status, envJSON, cmdJSON, argsJSON, service.YAMLConfig, service.Version)
+		service.Status, envJSON, cmdJSON, argsJSON, service.YAMLConfig, service.Version, service.OrgID)
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
@@ -607,15 +1169,15 @@ func (r *ServiceRepository) GetByID(id string) (*Service, error) {
 	var service Service
 	var envJSON, cmdJSON, argsJSON string
 
-	query := `SELECT id, name, image, port, replicas, status, environment, command, args, 
-		yaml_config, version, created_at, updated_at FROM services WHERE id = ?`
-	row := r.db.QueryRow(query, id)
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	query := `SELECT ` + serviceColumns + ` FROM services WHERE id = ?` + scope
+	row := r.db.QueryRow(query, append([]interface{}{id}, scopeArgs...)...)
 
 	err := row.Scan(&service.ID, &service.Name, &service.Image, &service.Port, &service.Replicas,
 		&service.Status, &envJSON, &cmdJSON, &argsJSON, &service.YAMLConfig, &service.Version,
-		&service.CreatedAt, &service.UpdatedAt)
+		&service.OrgID, &service.CreatedAt, &service.UpdatedAt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get service by ID: %w", err)
+		return nil, fmt.Errorf("failed to get service by ID: %w", notFound(err))
 	}
 
 	// Deserialize JSON fields
@@ -637,15 +1199,15 @@ func (r *ServiceRepository) GetByName(name string) (*Service, error) {
 	var service Service
 	var envJSON, cmdJSON, argsJSON string
 
-	query := `SELECT id, name, image, port, replicas, status, environment, command, args, 
-		yaml_config, version, created_at, updated_at FROM services WHERE name = ?`
-	row := r.db.QueryRow(query, name)
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	query := `SELECT ` + serviceColumns + ` FROM services WHERE name = ?` + scope
+	row := r.db.QueryRow(query, append([]interface{}{name}, scopeArgs...)...)
 
 	err := row.Scan(&service.ID, &service.Name, &service.Image, &service.Port, &service.Replicas,
 		&service.Status, &envJSON, &cmdJSON, &argsJSON, &service.YAMLConfig, &service.Version,
-		&service.CreatedAt, &service.UpdatedAt)
+		&service.OrgID, &service.CreatedAt, &service.UpdatedAt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get service by name: %w", err)
+		return nil, fmt.Errorf("failed to get service by name: %w", notFound(err))
 	}
 
 	// Deserialize JSON fields
@@ -686,26 +1248,73 @@ func (r *ServiceRepository) Update(service *Service) error {
 		UPDATE services 
 		SET name = ?, image = ?, port = ?, replicas = ?, status = ?, 
 			environment = ?, command = ?, args = ?, yaml_config = ?, version = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
-	`
-	_, err = r.db.Exec(query, service.Name, service.Image, service.Port, service.Replicas, service.Status,
-		envJSON, cmdJSON, argsJSON, service.YAMLConfig, service.Version, service.ID)
+		WHERE id = ?`
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	result, err := r.db.Exec(query+scope, append([]interface{}{service.Name, service.Image, service.Port, service.Replicas, service.Status,
+		envJSON, cmdJSON, argsJSON, service.YAMLConfig, service.Version, service.ID}, scopeArgs...)...)
 	if err != nil {
 		return fmt.Errorf("failed to update service: %w", err)
 	}
+	if err := affectedRow(result); err != nil {
+		return fmt.Errorf("failed to update service: %w", err)
+	}
 	return nil
 }
 
 // List lists all services
 func (r *ServiceRepository) List() ([]*Service, error) {
-	query := `SELECT id, name, image, port, replicas, status, environment, command, args, 
-		yaml_config, version, created_at, updated_at FROM services ORDER BY created_at DESC`
-	rows, err := r.db.Query(query)
+	scope, scopeArgs := r.db.orgWhere("org_id")
+	query := `SELECT ` + serviceColumns + ` FROM services` + scope + ` ORDER BY created_at DESC`
+	rows, err := r.db.Query(query, scopeArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query services: %w", err)
 	}
 	defer rows.Close()
 
+	return scanServices(rows)
+}
+
+// serviceColumns are the service columns read by scanServices, in order
+const serviceColumns = `id, name, image, port, replicas, status, environment, command, args,
+		yaml_config, version, org_id, created_at, updated_at`
+
+// serviceListQuery defines how services can be paged, sorted and filtered
+var serviceListQuery = listQuery{
+	table:         "services",
+	columns:       serviceColumns,
+	sortColumns:   []string{"name", "image", "port", "replicas", "status", "version", "created_at", "updated_at"},
+	filterColumns: []string{"status", "image", "org_id"},
+	defaultSort:   "created_at",
+}
+
+// ListPaged lists a page of services with the total number matching the filters
+func (r *ServiceRepository) ListPaged(opts ListOptions) ([]*Service, int, error) {
+	opts.Filters = r.db.orgFilters(opts.Filters)
+	query, args, countQuery, countArgs, err := serviceListQuery.build(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := r.db.Get(&total, countQuery, countArgs...); err != nil {
+		return nil, 0, fmt.Errorf("failed to count services: %w", err)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query services: %w", err)
+	}
+	defer rows.Close()
+
+	services, err := scanServices(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return services, total, nil
+}
+
+// scanServices reads services selected with serviceColumns
+func scanServices(rows *sql.Rows) ([]*Service, error) {
 	var services []*Service
 	for rows.Next() {
 		var service Service
@@ -713,7 +1322,7 @@ func (r *ServiceRepository) List() ([]*Service, error) {
 
 		err := rows.Scan(&service.ID, &service.Name, &service.Image, &service.Port, &service.Replicas,
 			&service.Status, &envJSON, &cmdJSON, &argsJSON, &service.YAMLConfig, &service.Version,
-			&service.CreatedAt, &service.UpdatedAt)
+			&service.OrgID, &service.CreatedAt, &service.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan service: %w", err)
 		}
@@ -732,7 +1341,7 @@ func (r *ServiceRepository) List() ([]*Service, error) {
 		services = append(services, &service)
 	}
 
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating services: %w", err)
 	}
 
@@ -741,11 +1350,15 @@ func (r *ServiceRepository) List() ([]*Service, error) {
 
 // Delete deletes a service
 func (r *ServiceRepository) Delete(id string) error {
-	query := "DELETE FROM services WHERE id = ?"
-	_, err := r.db.Exec(query, id)
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	query := "DELETE FROM services WHERE id = ?" + scope
+	result, err := r.db.Exec(query, append([]interface{}{id}, scopeArgs...)...)
 	if err != nil {
 		return fmt.Errorf("failed to delete service: %w", err)
 	}
+	if err := affectedRow(result); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
 	return nil
 }
 
@@ -759,17 +1372,26 @@ func NewRouteRepository(db *DB) *RouteRepository {
 	return &RouteRepository{db: db}
 }
 
-// Create creates a new route
+// Create creates a new route. Its upstream service must belong to the same
+// organization, or ErrCrossOrgReference is returned.
 func (r *RouteRepository) Create(route *Route) error {
 	if route.ID == "" {
 		route.ID = uuid.New().String()
 	}
+	orgID, err := r.db.ownerOrg(route.OrgID)
+	if err != nil {
+		return fmt.Errorf("failed to create route: %w", err)
+	}
+	route.OrgID = orgID
+	if err := r.checkUpstreamOrg(route); err != nil {
+		return err
+	}
 
 	query := `
-		INSERT INTO routes (id, host, path_prefix, upstream_service_id, upstream_url, tls_cert_id)
-		VALUES (:id, :host, :path_prefix, :upstream_service_id, :upstream_url, :tls_cert_id)
+		INSERT INTO routes (id, host, path_prefix, upstream_service_id, upstream_url, tls_cert_id, org_id)
+		VALUES (:id, :host, :path_prefix, :upstream_service_id, :upstream_url, :tls_cert_id, :org_id)
 	`
-	_, err := r.db.NamedExec(query, route)
+	_, err = r.db.NamedExec(query, route)
 	if err != nil {
 		return fmt.Errorf("failed to create route: %w", err)
 	}
@@ -779,10 +1401,11 @@ func (r *RouteRepository) Create(route *Route) error {
 // GetByID gets a route by ID
 func (r *RouteRepository) GetByID(id string) (*Route, error) {
 	var route Route
-	query := "SELECT * FROM routes WHERE id = ?"
-	err := r.db.Get(&route, query, id)
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	query := "SELECT * FROM routes WHERE id = ?" + scope
+	err := r.db.Get(&route, query, append([]interface{}{id}, scopeArgs...)...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get route by ID: %w", err)
+		return nil, fmt.Errorf("failed to get route by ID: %w", notFound(err))
 	}
 	return &route, nil
 }
@@ -790,16 +1413,77 @@ func (r *RouteRepository) GetByID(id string) (*Route, error) {
 // List lists all routes
 func (r *RouteRepository) List() ([]*Route, error) {
 	var routes []*Route
-	query := "SELECT * FROM routes ORDER BY created_at DESC"
-	err := r.db.Select(&routes, query)
+	scope, scopeArgs := r.db.orgWhere("org_id")
+	query := "SELECT * FROM routes" + scope + " ORDER BY created_at DESC"
+	err := r.db.Select(&routes, query, scopeArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list routes: %w", err)
 	}
 	return routes, nil
 }
 
-// Update updates a route
+// AttributeMetrics records metrics scoped to a route that has an upstream
+// service as metrics of that service, so that they are listed with the
+// service's own. The route stays in the labels the metrics were given.
+func (r *RouteRepository) AttributeMetrics(metrics []*Metric) error {
+	var routes []*Route
+	query := "SELECT * FROM routes WHERE upstream_service_id IS NOT NULL AND upstream_service_id != ''"
+	if err := r.db.Select(&routes, query); err != nil {
+		return fmt.Errorf("failed to list routes of services: %w", err)
+	}
+
+	services := make(map[string]string, len(routes))
+	for _, route := range routes {
+		services[route.ID] = *route.UpstreamServiceID
+	}
+	for _, metric := range metrics {
+		if serviceID, ok := services[metric.ScopeID]; ok && metric.ScopeType == "route" {
+			metric.ScopeType, metric.ScopeID = "service", serviceID
+		}
+	}
+	return nil
+}
+
+// routeListQuery defines how routes can be paged, sorted and filtered
+var routeListQuery = listQuery{
+	table:         "routes",
+	columns:       "*",
+	sortColumns:   []string{"host", "path_prefix", "created_at", "updated_at"},
+	filterColumns: []string{"host", "upstream_service_id", "org_id"},
+	defaultSort:   "created_at",
+}
+
+// ListPaged lists a page of routes with the total number matching the filters
+func (r *RouteRepository) ListPaged(opts ListOptions) ([]*Route, int, error) {
+	opts.Filters = r.db.orgFilters(opts.Filters)
+	query, args, countQuery, countArgs, err := routeListQuery.build(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := r.db.Get(&total, countQuery, countArgs...); err != nil {
+		return nil, 0, fmt.Errorf("failed to count routes: %w", err)
+	}
+
+	var routes []*Route
+	if err := r.db.Select(&routes, query, args...); err != nil {
+		return nil, 0, fmt.Errorf("failed to list routes: %w", err)
+	}
+	return routes, total, nil
+}
+
+// Update updates a route. It stays in its organization, which its upstream
+// service must belong to, or ErrCrossOrgReference is returned.
 func (r *RouteRepository) Update(route *Route) error {
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	if err := r.db.Get(&route.OrgID, "SELECT org_id FROM routes WHERE id = ?"+scope, append([]interface{}{route.ID}, scopeArgs...)...); err != nil {
+		return fmt.Errorf("failed to update route: %w", notFound(err))
+	}
+	if err := r.checkUpstreamOrg(route); err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE routes 
 		SET host = :host, path_prefix = :path_prefix, upstream_service_id = :upstream_service_id, 
@@ -813,16 +1497,63 @@ func (r *RouteRepository) Update(route *Route) error {
 	return nil
 }
 
+// checkUpstreamOrg returns ErrCrossOrgReference when the upstream service of
+// a route belongs to another organization than the route
+func (r *RouteRepository) checkUpstreamOrg(route *Route) error {
+	if route.UpstreamServiceID == nil || *route.UpstreamServiceID == "" {
+		return nil
+	}
+	var serviceOrg *string
+	err := r.db.Get(&serviceOrg, "SELECT org_id FROM services WHERE id = ?", *route.UpstreamServiceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil // left to the foreign key
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get upstream service of route: %w", err)
+	}
+	if (serviceOrg == nil) != (route.OrgID == nil) || serviceOrg != nil && *serviceOrg != *route.OrgID {
+		return ErrCrossOrgReference
+	}
+	return nil
+}
+
 // Delete deletes a route
 func (r *RouteRepository) Delete(id string) error {
-	query := "DELETE FROM routes WHERE id = ?"
-	_, err := r.db.Exec(query, id)
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	query := "DELETE FROM routes WHERE id = ?" + scope
+	result, err := r.db.Exec(query, append([]interface{}{id}, scopeArgs...)...)
 	if err != nil {
 		return fmt.Errorf("failed to delete route: %w", err)
 	}
+	if err := affectedRow(result); err != nil {
+		return fmt.Errorf("failed to delete route: %w", err)
+	}
 	return nil
 }
 
+// Metric aggregations supported by QueryAggregated
+const (
+	MetricAggAvg   = "avg"
+	MetricAggMin   = "min"
+	MetricAggMax   = "max"
+	MetricAggSum   = "sum"
+	MetricAggCount = "count"
+	MetricAggP95   = "p95"
+)
+
+// MetricAggregations lists the aggregations supported by QueryAggregated
+var MetricAggregations = []string{MetricAggAvg, MetricAggMin, MetricAggMax, MetricAggSum, MetricAggCount, MetricAggP95}
+
+// MetricRollupInterval is the bucket size raw metrics are rolled up into
+const MetricRollupInterval = 5 * time.Minute
+
+// maxMetricBuckets caps how many buckets one aggregated query may return
+const maxMetricBuckets = 10000
+
+// ErrInvalidMetricQuery is returned when an aggregated metric query has an
+// unknown aggregation, a bad step or an empty time range
+var ErrInvalidMetricQuery = errors.New("invalid metric query")
+
 // MetricRepository provides database operations for metrics
 type MetricRepository struct {
 	db *DB
@@ -833,19 +1564,58 @@ func NewMetricRepository(db *DB) *MetricRepository {
 	return &MetricRepository{db: db}
 }
 
+// orgFilter returns the condition restricting metrics, with columns
+// prefixed by prefix, to those of the scoped organization's services and
+// routes and its arguments. Instance metrics name their service in their
+// labels, which rollups do not keep, so they are only matched withLabels.
+// Both are empty when the DB is not scoped.
+func (r *MetricRepository) orgFilter(prefix string, withLabels bool) (string, []interface{}) {
+	orgID, ok := r.db.orgScope()
+	if !ok {
+		return "", nil
+	}
+	condition := "((" + prefix + "scope_type = 'service' AND " + prefix + "scope_id IN (SELECT id FROM services WHERE org_id = ?))" +
+		" OR (" + prefix + "scope_type = 'route' AND " + prefix + "scope_id IN (SELECT id FROM routes WHERE org_id = ?))"
+	args := []interface{}{orgID, orgID}
+	if withLabels {
+		condition += " OR (" + prefix + "scope_type = 'instance' AND json_extract(" + prefix + "labels, '$.service_id') IN (SELECT id FROM services WHERE org_id = ?))"
+		args = append(args, orgID)
+	}
+	return condition + ")", args
+}
+
 // Insert inserts a new metric
 func (r *MetricRepository) Insert(metric *Metric) error {
 	query := `
 		INSERT INTO metrics (timestamp, scope_type, scope_id, metric_name, metric_value, labels)
-		VALUES (:timestamp, :scope_type, :scope_id, :metric_name, :metric_value, :labels)
+		VALUES (?, ?, ?, ?, ?, ?)
 	`
-	_, err := r.db.NamedExec(query, metric)
+	_, err := r.db.Exec(query, formatTimestamp(metric.Timestamp), metric.ScopeType, metric.ScopeID,
+		metric.MetricName, metric.MetricValue, metric.Labels)
 	if err != nil {
 		return fmt.Errorf("failed to insert metric: %w", err)
 	}
 	return nil
 }
 
+// InsertBatch inserts metrics in a single transaction
+func (r *MetricRepository) InsertBatch(metrics []*Metric) error {
+	query := `
+		INSERT INTO metrics (timestamp, scope_type, scope_id, metric_name, metric_value, labels)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	return r.db.WithTx(r.db.context(), func(tx *DB) error {
+		for _, metric := range metrics {
+			_, err := tx.Exec(query, formatTimestamp(metric.Timestamp), metric.ScopeType, metric.ScopeID,
+				metric.MetricName, metric.MetricValue, metric.Labels)
+			if err != nil {
+				return fmt.Errorf("failed to insert metric: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
 // Query queries metrics by time range and filters
 func (r *MetricRepository) Query(scopeType, scopeID, metricName string, from, to time.Time, limit int) ([]*Metric, error) {
 	var metrics []*Metric
@@ -856,7 +1626,7 @@ func (r *MetricRepository) Query(scopeType, scopeID, metricName string, from, to
 		ORDER BY timestamp DESC
 		LIMIT ?
 	`
-	err := r.db.Select(&metrics, query, scopeType, scopeID, metricName, from, to, limit)
+	err := r.db.Select(&metrics, query, scopeType, scopeID, metricName, formatTimestamp(from), formatTimestamp(to), limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query metrics: %w", err)
 	}
@@ -865,13 +1635,167 @@ func (r *MetricRepository) Query(scopeType, scopeID, metricName string, from, to
 
 // DeleteOld deletes old metrics beyond retention period
 func (r *MetricRepository) DeleteOld(retentionDays int) error {
-	cutoff := time.Now().AddDate(0, 0, -retentionDays)
-	query := "DELETE FROM metrics WHERE timestamp < ?"
-	_, err := r.db.Exec(query, cutoff)
+	_, err := r.DeleteOlderThan(time.Now().AddDate(0, 0, -retentionDays))
+	return err
+}
+
+// DeleteOlderThan deletes raw metrics recorded before cutoff and returns how
+// many were removed. The cutoff is rounded down to a rollup bucket boundary so
+// that a bucket is never left half raw and half rolled up. Rollups are kept.
+func (r *MetricRepository) DeleteOlderThan(cutoff time.Time) (int64, error) {
+	cutoff = cutoff.UTC().Truncate(MetricRollupInterval)
+	result, err := r.db.Exec("DELETE FROM metrics WHERE timestamp < ?", formatTimestamp(cutoff))
 	if err != nil {
-		return fmt.Errorf("failed to delete old metrics: %w", err)
+		return 0, fmt.Errorf("failed to delete old metrics: %w", err)
 	}
-	return nil
+	return result.RowsAffected()
+}
+
+// DeleteRollupsOlderThan deletes 5-minute rollups of buckets that started
+// before cutoff and returns how many were removed
+func (r *MetricRepository) DeleteRollupsOlderThan(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM metrics_rollup WHERE bucket_start < ?", formatTimestamp(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old metric rollups: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// Rollup rolls raw metrics recorded before the given time into 5-minute
+// buckets in metrics_rollup and returns how many buckets were written. Only
+// complete buckets are rolled up, and buckets after the last rollup, so it is
+// safe to run repeatedly.
+func (r *MetricRepository) Rollup(before time.Time) (int64, error) {
+	end := before.UTC().Truncate(MetricRollupInterval)
+
+	var last sql.NullString
+	if err := r.db.Get(&last, "SELECT MAX(bucket_start) FROM metrics_rollup"); err != nil {
+		return 0, fmt.Errorf("failed to find last metric rollup: %w", err)
+	}
+	start := ""
+	if last.Valid {
+		lastBucket, err := time.Parse(timestampFormat, last.String)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse last metric rollup: %w", err)
+		}
+		start = formatTimestamp(lastBucket.Add(MetricRollupInterval))
+	}
+
+	seconds := int64(MetricRollupInterval / time.Second)
+	query := `
+		INSERT OR IGNORE INTO metrics_rollup (bucket_start, scope_type, scope_id, metric_name,
+			sample_count, value_sum, value_min, value_max, value_sum_squares)
+		SELECT strftime('%Y-%m-%d %H:%M:%S', (CAST(strftime('%s', timestamp) AS INTEGER) / ?) * ?, 'unixepoch') AS bucket,
+			scope_type, scope_id, metric_name,
+			COUNT(*), SUM(metric_value), MIN(metric_value), MAX(metric_value), SUM(metric_value * metric_value)
+		FROM metrics
+		WHERE timestamp >= ? AND timestamp < ?
+		GROUP BY scope_type, scope_id, metric_name, bucket
+	`
+	result, err := r.db.Exec(query, seconds, seconds, start, formatTimestamp(end))
+	if err != nil {
+		return 0, fmt.Errorf("failed to roll up metrics: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// QueryAggregated aggregates a metric series into step-sized buckets between
+// from and to. Buckets are aligned to multiples of step since the Unix epoch
+// and every bucket in the range is returned, including empty ones. Rollups
+// fill in periods whose raw metrics have been deleted, at 5-minute resolution.
+//
+// The p95 aggregation is approximated as mean + 1.645 standard deviations,
+// capped at the bucket maximum, since rollups do not keep individual samples.
+func (r *MetricRepository) QueryAggregated(scopeType, scopeID, metricName string, from, to time.Time, step time.Duration, agg string) ([]*MetricBucket, error) {
+	if step < time.Second || step%time.Second != 0 {
+		return nil, fmt.Errorf("%w: step must be a whole number of seconds", ErrInvalidMetricQuery)
+	}
+	if !containsString(MetricAggregations, agg) {
+		return nil, fmt.Errorf("%w: unknown aggregation %q", ErrInvalidMetricQuery, agg)
+	}
+	start := from.UTC().Truncate(step)
+	if !to.After(start) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidMetricQuery)
+	}
+	if int64(to.Sub(start)/step) >= maxMetricBuckets {
+		return nil, fmt.Errorf("%w: more than %d buckets, use a larger step", ErrInvalidMetricQuery, maxMetricBuckets)
+	}
+
+	seconds := int64(step / time.Second)
+	query := `
+		SELECT (CAST(strftime('%s', ts) AS INTEGER) / ?) * ? AS bucket,
+			SUM(samples) AS samples, SUM(total) AS total, MIN(low) AS low, MAX(high) AS high, SUM(squares) AS squares
+		FROM (
+			SELECT timestamp AS ts, 1 AS samples, metric_value AS total, metric_value AS low,
+				metric_value AS high, metric_value * metric_value AS squares
+			FROM metrics
+			WHERE scope_type = ? AND scope_id = ? AND metric_name = ? AND timestamp >= ? AND timestamp < ?
+			UNION ALL
+			SELECT bucket_start, sample_count, value_sum, value_min, value_max, value_sum_squares
+			FROM metrics_rollup
+			WHERE scope_type = ? AND scope_id = ? AND metric_name = ? AND bucket_start >= ? AND bucket_start < ?
+			  AND bucket_start <= COALESCE((
+				SELECT strftime('%Y-%m-%d %H:%M:%S', MIN(timestamp), ?)
+				FROM metrics WHERE scope_type = ? AND scope_id = ? AND metric_name = ?
+			  ), '9999-12-31 23:59:59')
+		)
+		GROUP BY bucket
+		ORDER BY bucket
+	`
+	series := []interface{}{scopeType, scopeID, metricName}
+	args := []interface{}{seconds, seconds}
+	args = append(args, append(series, formatTimestamp(start), formatTimestamp(to))...)
+	args = append(args, append(series, formatTimestamp(start), formatTimestamp(to))...)
+	args = append(args, fmt.Sprintf("-%d seconds", int64(MetricRollupInterval/time.Second)))
+	args = append(args, series...)
+
+	var rows []struct {
+		Bucket  int64   `db:"bucket"`
+		Samples int     `db:"samples"`
+		Total   float64 `db:"total"`
+		Low     float64 `db:"low"`
+		High    float64 `db:"high"`
+		Squares float64 `db:"squares"`
+	}
+	if err := r.db.Select(&rows, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to query aggregated metrics: %w", err)
+	}
+
+	buckets := []*MetricBucket{}
+	next := 0
+	for bucketStart := start; bucketStart.Before(to); bucketStart = bucketStart.Add(step) {
+		bucket := &MetricBucket{Start: bucketStart}
+		if agg == MetricAggCount {
+			zero := 0.0
+			bucket.Value = &zero
+		}
+		if next < len(rows) && rows[next].Bucket == bucketStart.Unix() {
+			row := rows[next]
+			next++
+
+			var value float64
+			switch agg {
+			case MetricAggAvg:
+				value = row.Total / float64(row.Samples)
+			case MetricAggMin:
+				value = row.Low
+			case MetricAggMax:
+				value = row.High
+			case MetricAggSum:
+				value = row.Total
+			case MetricAggCount:
+				value = float64(row.Samples)
+			case MetricAggP95:
+				mean := row.Total / float64(row.Samples)
+				variance := math.Max(row.Squares/float64(row.Samples)-mean*mean, 0)
+				value = math.Min(mean+1.645*math.Sqrt(variance), row.High)
+			}
+			bucket.Count = row.Samples
+			bucket.Value = &value
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
 }
 
 // GetByService gets metrics for a specific service
@@ -893,18 +1817,140 @@ func (r *MetricRepository) GetByService(serviceID string, limit int) ([]*Metric,
 // GetRecent gets recent metrics across all services
 func (r *MetricRepository) GetRecent(limit int) ([]*Metric, error) {
 	var metrics []*Metric
+	scope, scopeArgs := r.orgFilter("", true)
+	if scope != "" {
+		scope = "WHERE " + scope
+	}
 	query := `
-		SELECT * FROM metrics 
+		SELECT * FROM metrics
+		` + scope + `
 		ORDER BY timestamp DESC
 		LIMIT ?
 	`
-	err := r.db.Select(&metrics, query, limit)
+	err := r.db.Select(&metrics, query, append(scopeArgs, limit)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get recent metrics: %w", err)
 	}
 	return metrics, nil
 }
 
+// Sum sums a metric over all scopes since the given time. Rollups fill in
+// periods whose raw metrics have been deleted.
+func (r *MetricRepository) Sum(metricName string, since time.Time) (float64, error) {
+	var total float64
+	rawScope, rawArgs := r.orgFilter("", true)
+	rollupScope, rollupArgs := r.orgFilter("", false)
+	if rawScope != "" {
+		rawScope, rollupScope = " AND "+rawScope, " AND "+rollupScope
+	}
+	query := `
+		SELECT COALESCE(SUM(value), 0) FROM (
+			SELECT metric_value AS value FROM metrics
+			WHERE metric_name = ? AND timestamp >= ?` + rawScope + `
+			UNION ALL
+			SELECT value_sum FROM metrics_rollup
+			WHERE metric_name = ? AND bucket_start >= ?` + rollupScope + `
+			  AND bucket_start <= COALESCE((
+				SELECT strftime('%Y-%m-%d %H:%M:%S', MIN(timestamp), ?)
+				FROM metrics WHERE metric_name = ?
+			  ), '9999-12-31 23:59:59')
+		)
+	`
+	start := formatTimestamp(since.UTC())
+	args := append([]interface{}{metricName, start}, rawArgs...)
+	args = append(args, metricName, start)
+	args = append(args, rollupArgs...)
+	args = append(args, fmt.Sprintf("-%d seconds", int64(MetricRollupInterval/time.Second)), metricName)
+	err := r.db.Get(&total, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum metrics: %w", err)
+	}
+	return total, nil
+}
+
+// Top returns the latest sample of a metric of each scope of a type sampled
+// since the given time, largest first
+func (r *MetricRepository) Top(scopeType, metricName string, since time.Time, limit int) ([]*Metric, error) {
+	var metrics []*Metric
+	scope, scopeArgs := r.orgFilter("m.", true)
+	if scope != "" {
+		scope = " AND " + scope
+	}
+	query := `
+		SELECT m.* FROM metrics m
+		JOIN (
+			SELECT scope_id, MAX(timestamp) AS latest FROM metrics
+			WHERE scope_type = ? AND metric_name = ? AND timestamp >= ?
+			GROUP BY scope_id
+		) l ON m.scope_id = l.scope_id AND m.timestamp = l.latest
+		WHERE m.scope_type = ? AND m.metric_name = ?` + scope + `
+		GROUP BY m.scope_id
+		ORDER BY m.metric_value DESC, m.scope_id
+		LIMIT ?
+	`
+	args := append([]interface{}{scopeType, metricName, formatTimestamp(since.UTC()), scopeType, metricName}, scopeArgs...)
+	err := r.db.Select(&metrics, query, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top metrics: %w", err)
+	}
+	return metrics, nil
+}
+
+// LogIndexRepository provides database operations for the service log index
+type LogIndexRepository struct {
+	db *DB
+}
+
+// NewLogIndexRepository creates a new log index repository
+func NewLogIndexRepository(db *DB) *LogIndexRepository {
+	return &LogIndexRepository{db: db}
+}
+
+// Create records a chunk of a service log file
+func (r *LogIndexRepository) Create(entry *LogIndex) error {
+	query := `
+		INSERT INTO logs_index (service_id, log_file, start_timestamp, end_timestamp, offset_start, offset_end, line_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := r.db.Exec(query, entry.ServiceID, entry.LogFile, formatTimestamp(entry.StartTimestamp),
+		formatTimestamp(entry.EndTimestamp), entry.OffsetStart, entry.OffsetEnd, entry.LineCount)
+	if err != nil {
+		return fmt.Errorf("failed to create log index entry: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get log index entry ID: %w", err)
+	}
+	entry.ID = int(id)
+	return nil
+}
+
+// ListByService lists the indexed chunks of a service's logs in file and
+// offset order
+func (r *LogIndexRepository) ListByService(serviceID string) ([]*LogIndex, error) {
+	var entries []*LogIndex
+	query := `
+		SELECT * FROM logs_index
+		WHERE service_id = ?
+		ORDER BY log_file, offset_start
+	`
+	if err := r.db.Select(&entries, query, serviceID); err != nil {
+		return nil, fmt.Errorf("failed to list log index entries: %w", err)
+	}
+	return entries, nil
+}
+
+// DeleteByFile removes the index entries of a deleted log file and returns
+// how many were removed
+func (r *LogIndexRepository) DeleteByFile(serviceID, logFile string) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM logs_index WHERE service_id = ? AND log_file = ?", serviceID, logFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete log index entries: %w", err)
+	}
+	return result.RowsAffected()
+}
+
 // AuditLogRepository provides database operations for audit logs
 type AuditLogRepository struct {
 	db *DB
@@ -917,24 +1963,1563 @@ func NewAuditLogRepository(db *DB) *AuditLogRepository {
 
 // Create creates a new audit log entry
 func (r *AuditLogRepository) Create(log *AuditLog) error {
+	if log.CreatedAt.IsZero() {
+		log.CreatedAt = time.Now()
+	}
+
 	query := `
-		INSERT INTO audit_logs (user_id, action, resource_type, resource_id, details, ip_address, user_agent)
-		VALUES (:user_id, :action, :resource_type, :resource_id, :details, :ip_address, :user_agent)
+		INSERT INTO audit_logs (user_id, impersonator_id, action, resource_type, resource_id, details, ip_address, user_agent, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := r.db.NamedExec(query, log)
+	result, err := r.db.Exec(query, log.UserID, log.ImpersonatorID, log.Action, log.ResourceType, log.ResourceID,
+		log.Details, log.IPAddress, log.UserAgent, formatTimestamp(log.CreatedAt))
 	if err != nil {
 		return fmt.Errorf("failed to create audit log: %w", err)
 	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get audit log ID: %w", err)
+	}
+	log.ID = int(id)
 	return nil
 }
 
-// List lists audit logs with pagination
-func (r *AuditLogRepository) List(limit, offset int) ([]*AuditLog, error) {
+// AuditLogFilter narrows an audit log listing. Zero values match everything.
+type AuditLogFilter struct {
+	UserID       *int
+	Action       string
+	ResourceType string
+	Since        *time.Time
+	Until        *time.Time
+	Limit        int
+	Offset       int
+}
+
+// List lists audit logs matching the filter, newest first
+func (r *AuditLogRepository) List(filter AuditLogFilter) ([]*AuditLog, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.UserID != nil {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, *filter.UserID)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.ResourceType != "" {
+		conditions = append(conditions, "resource_type = ?")
+		args = append(args, filter.ResourceType)
+	}
+	if filter.Since != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, formatTimestamp(*filter.Since))
+	}
+	if filter.Until != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, formatTimestamp(*filter.Until))
+	}
+
+	query := "SELECT * FROM audit_logs"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?"
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1 // no limit
+	}
+	args = append(args, limit, filter.Offset)
+
 	var logs []*AuditLog
-	query := "SELECT * FROM audit_logs ORDER BY created_at DESC LIMIT ? OFFSET ?"
-	err := r.db.Select(&logs, query, limit, offset)
-	if err != nil {
+	if err := r.db.Select(&logs, query, args...); err != nil {
 		return nil, fmt.Errorf("failed to list audit logs: %w", err)
 	}
 	return logs, nil
 }
+
+// DeleteOlderThan deletes audit logs created before cutoff and returns how
+// many were removed
+func (r *AuditLogRepository) DeleteOlderThan(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM audit_logs WHERE created_at < ?", formatTimestamp(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old audit logs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// timestampFormat is the layout used for timestamps written by repositories that
+// feed aggregate queries. Values are stored in UTC without a zone suffix so they
+// sort lexically, can use column indexes for range scans and are understood by
+// SQLite's date functions.
+const timestampFormat = "2006-01-02 15:04:05.999999999"
+
+// formatTimestamp formats a time for storage and comparison in aggregate queries
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampFormat)
+}
+
+// DeploymentRepository provides database operations for deployments
+type DeploymentRepository struct {
+	db *DB
+}
+
+// NewDeploymentRepository creates a new deployment repository
+func NewDeploymentRepository(db *DB) *DeploymentRepository {
+	return &DeploymentRepository{db: db}
+}
+
+// Create records a new deployment. Unless set, the version is the service's
+// next one, so that versions count up per service.
+func (r *DeploymentRepository) Create(deployment *Deployment) error {
+	if deployment.ID == "" {
+		deployment.ID = uuid.New().String()
+	}
+	if deployment.Status == "" {
+		deployment.Status = "pending"
+	}
+	if deployment.Config == "" {
+		deployment.Config = "{}"
+	}
+	if deployment.Logs == "" {
+		deployment.Logs = "[]"
+	}
+	if deployment.StartedAt.IsZero() {
+		deployment.StartedAt = time.Now()
+	}
+	if deployment.UpdatedAt == nil {
+		updatedAt := deployment.StartedAt
+		deployment.UpdatedAt = &updatedAt
+	}
+
+	var finishedAt interface{}
+	if deployment.FinishedAt != nil {
+		finishedAt = formatTimestamp(*deployment.FinishedAt)
+	}
+
+	query := `
+		INSERT INTO deployments (id, service_id, version, status, strategy, config, logs,
+			started_at, updated_at, finished_at, error_message, trigger_source, triggered_by, notes)
+		SELECT ?, ?, CASE WHEN ? > 0 THEN ? ELSE COALESCE(MAX(version), 0) + 1 END, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		FROM deployments WHERE service_id = ?
+		RETURNING version
+	`
+	err := r.db.QueryRow(query, deployment.ID, deployment.ServiceID, deployment.Version, deployment.Version,
+		deployment.Status, deployment.Strategy, deployment.Config, deployment.Logs,
+		formatTimestamp(deployment.StartedAt), formatTimestamp(*deployment.UpdatedAt), finishedAt,
+		deployment.ErrorMessage, deployment.TriggerSource, deployment.TriggeredBy, deployment.Notes,
+		deployment.ServiceID).Scan(&deployment.Version)
+	if err != nil {
+		return fmt.Errorf("failed to create deployment: %w", err)
+	}
+	return nil
+}
+
+// GetByID gets a deployment by ID
+func (r *DeploymentRepository) GetByID(id string) (*Deployment, error) {
+	var deployment Deployment
+	if err := r.db.Get(&deployment, "SELECT * FROM deployments WHERE id = ?", id); err != nil {
+		return nil, fmt.Errorf("failed to get deployment: %w", notFound(err))
+	}
+	return &deployment, nil
+}
+
+// ListByService lists a service's deployments, newest version first
+func (r *DeploymentRepository) ListByService(serviceID string) ([]*Deployment, error) {
+	var deployments []*Deployment
+	query := `
+		SELECT * FROM deployments
+		WHERE service_id = ?
+		ORDER BY version DESC
+	`
+	if err := r.db.Select(&deployments, query, serviceID); err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	return deployments, nil
+}
+
+// ListRecent lists the most recently started deployments of all services,
+// newest first
+func (r *DeploymentRepository) ListRecent(limit int) ([]*Deployment, error) {
+	var deployments []*Deployment
+	scope, scopeArgs := r.db.orgWhere("s.org_id")
+	query := `
+		SELECT d.* FROM deployments d
+		JOIN services s ON s.id = d.service_id` + scope + `
+		ORDER BY d.started_at DESC, d.version DESC
+		LIMIT ?
+	`
+	if err := r.db.Select(&deployments, query, append(scopeArgs, limit)...); err != nil {
+		return nil, fmt.Errorf("failed to list recent deployments: %w", err)
+	}
+	return deployments, nil
+}
+
+// UpdateStatus sets a deployment's status. The finish time and error
+// message are only changed when given.
+func (r *DeploymentRepository) UpdateStatus(id, status string, finishedAt *time.Time, errorMessage *string) error {
+	var finished interface{}
+	if finishedAt != nil {
+		finished = formatTimestamp(*finishedAt)
+	}
+
+	query := `
+		UPDATE deployments
+		SET status = ?, updated_at = ?,
+			finished_at = COALESCE(?, finished_at),
+			error_message = COALESCE(?, error_message)
+		WHERE id = ?
+	`
+	result, err := r.db.Exec(query, status, formatTimestamp(time.Now()), finished, errorMessage, id)
+	if err != nil {
+		return fmt.Errorf("failed to update deployment status: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("deployment %w: %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// AppendLog appends a line to a deployment's log
+func (r *DeploymentRepository) AppendLog(id, line string) error {
+	query := `
+		UPDATE deployments
+		SET logs = json_insert(COALESCE(NULLIF(logs, ''), '[]'), '$[#]', ?), updated_at = ?
+		WHERE id = ?
+	`
+	result, err := r.db.Exec(query, line, formatTimestamp(time.Now()), id)
+	if err != nil {
+		return fmt.Errorf("failed to append deployment log: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("deployment %w: %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// Delete deletes a deployment record
+func (r *DeploymentRepository) Delete(id string) error {
+	result, err := r.db.Exec("DELETE FROM deployments WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete deployment: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("deployment %w: %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// Stats aggregates deployment outcomes per bucket. Failures and rollbacks are
+// counted from the deployment status; an incident is attributed to a deployment
+// when one opens on the same service within window after the deployment started.
+// With byService set, each bucket yields one row per deployed service.
+func (r *DeploymentRepository) Stats(buckets []TimeBucket, window time.Duration, byService bool) ([]*DeploymentBucketStats, error) {
+	if len(buckets) == 0 {
+		return []*DeploymentBucketStats{}, nil
+	}
+
+	values := make([]string, len(buckets))
+	args := make([]interface{}, 0, len(buckets)*3+3)
+	for i, bucket := range buckets {
+		values[i] = "(?, ?, ?)"
+		args = append(args, i, formatTimestamp(bucket.Start), formatTimestamp(bucket.End))
+	}
+	args = append(args,
+		window.Hours()/24,
+		formatTimestamp(buckets[0].Start),
+		formatTimestamp(buckets[len(buckets)-1].End),
+	)
+
+	scope, scopeArgs := r.db.orgCondition("s.org_id")
+	args = append(args, scopeArgs...)
+
+	serviceColumns := "NULL AS service_id, NULL AS service_name"
+	groupBy := "b.idx"
+	if byService {
+		serviceColumns = "d.service_id AS service_id, s.name AS service_name"
+		groupBy = "b.idx, d.service_id"
+	}
+
+	query := `
+		WITH buckets(idx, start_at, end_at) AS (VALUES ` + strings.Join(values, ", ") + `),
+		windowed AS (
+			SELECT d.id, d.service_id, d.status, d.started_at,
+				(SELECT MIN(i.opened_at) FROM incidents i
+				 WHERE i.service_id = d.service_id
+				   AND i.opened_at >= d.started_at
+				   AND julianday(i.opened_at) < julianday(d.started_at) + ?) AS first_incident
+			FROM deployments d
+			JOIN services s ON s.id = d.service_id
+			WHERE d.started_at >= ? AND d.started_at < ?` + scope + `
+		)
+		SELECT b.idx AS bucket, ` + serviceColumns + `,
+			COUNT(d.id) AS deployments,
+			COALESCE(SUM(CASE WHEN d.status = 'failed' THEN 1 ELSE 0 END), 0) AS failures,
+			COALESCE(SUM(CASE WHEN d.status = 'rolled_back' THEN 1 ELSE 0 END), 0) AS rollbacks,
+			COUNT(d.first_incident) AS incidents,
+			AVG((julianday(d.first_incident) - julianday(d.started_at)) * 86400.0) AS mean_time_to_incident
+		FROM buckets b
+		LEFT JOIN windowed d ON d.started_at >= b.start_at AND d.started_at < b.end_at
+		LEFT JOIN services s ON s.id = d.service_id
+		GROUP BY ` + groupBy + `
+		ORDER BY b.idx, service_name
+	`
+
+	var stats []*DeploymentBucketStats
+	if err := r.db.Select(&stats, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to aggregate deployment stats: %w", err)
+	}
+	return stats, nil
+}
+
+// IncidentRepository provides database operations for incidents
+type IncidentRepository struct {
+	db *DB
+}
+
+// NewIncidentRepository creates a new incident repository
+func NewIncidentRepository(db *DB) *IncidentRepository {
+	return &IncidentRepository{db: db}
+}
+
+// Create records a new incident
+func (r *IncidentRepository) Create(incident *Incident) error {
+	if incident.ID == "" {
+		incident.ID = uuid.New().String()
+	}
+	if incident.Severity == "" {
+		incident.Severity = "minor"
+	}
+	if incident.Status == "" {
+		incident.Status = "open"
+	}
+	if incident.OpenedAt.IsZero() {
+		incident.OpenedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO incidents (id, service_id, title, severity, status, opened_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := r.db.Exec(query, incident.ID, incident.ServiceID, incident.Title,
+		incident.Severity, incident.Status, formatTimestamp(incident.OpenedAt))
+	if err != nil {
+		return fmt.Errorf("failed to create incident: %w", err)
+	}
+	return nil
+}
+
+// Resolve marks an incident as resolved
+func (r *IncidentRepository) Resolve(id string, resolvedAt time.Time) error {
+	query := "UPDATE incidents SET status = 'resolved', resolved_at = ? WHERE id = ?"
+	result, err := r.db.Exec(query, formatTimestamp(resolvedAt), id)
+	if err != nil {
+		return fmt.Errorf("failed to resolve incident: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("incident not found: %s", id)
+	}
+	return nil
+}
+
+// ListByService lists incidents for a service, newest first
+func (r *IncidentRepository) ListByService(serviceID string, limit int) ([]*Incident, error) {
+	var incidents []*Incident
+	query := `
+		SELECT * FROM incidents
+		WHERE service_id = ?
+		ORDER BY opened_at DESC
+		LIMIT ?
+	`
+	if err := r.db.Select(&incidents, query, serviceID, limit); err != nil {
+		return nil, fmt.Errorf("failed to list incidents: %w", err)
+	}
+	return incidents, nil
+}
+
+// ProbeResultRepository provides database operations for probe results
+type ProbeResultRepository struct {
+	db *DB
+}
+
+// NewProbeResultRepository creates a new probe result repository
+func NewProbeResultRepository(db *DB) *ProbeResultRepository {
+	return &ProbeResultRepository{db: db}
+}
+
+// InsertBatch inserts probe results in a single transaction, skipping IDs that already exist
+func (r *ProbeResultRepository) InsertBatch(results []*ProbeResult) error {
+	query := `
+		INSERT OR IGNORE INTO probe_results (id, probe_id, status, response_time, status_code, message, error, metadata, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	return r.db.WithTx(r.db.context(), func(tx *DB) error {
+		for _, result := range results {
+			_, err := tx.Exec(query, result.ID, result.ProbeID, result.Status, result.ResponseTime,
+				result.StatusCode, result.Message, result.Error, result.Metadata, formatTimestamp(result.Timestamp))
+			if err != nil {
+				return fmt.Errorf("failed to insert probe result: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ListByProbe lists results for a probe recorded at or after since, newest first
+func (r *ProbeResultRepository) ListByProbe(probeID string, since time.Time, limit int) ([]*ProbeResult, error) {
+	var results []*ProbeResult
+	query := `
+		SELECT * FROM probe_results
+		WHERE probe_id = ? AND timestamp >= ?
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ?
+	`
+	if err := r.db.Select(&results, query, probeID, formatTimestamp(since), limit); err != nil {
+		return nil, fmt.Errorf("failed to list probe results: %w", err)
+	}
+	return results, nil
+}
+
+// ListBetween lists results for a probe recorded at or after from and before
+// to, oldest first
+func (r *ProbeResultRepository) ListBetween(probeID string, from, to time.Time) ([]*ProbeResult, error) {
+	var results []*ProbeResult
+	query := `
+		SELECT * FROM probe_results
+		WHERE probe_id = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp ASC, id ASC
+	`
+	if err := r.db.Select(&results, query, probeID, formatTimestamp(from), formatTimestamp(to)); err != nil {
+		return nil, fmt.Errorf("failed to list probe results: %w", err)
+	}
+	return results, nil
+}
+
+// DeleteOlderThan deletes results recorded before cutoff and returns how many were removed
+func (r *ProbeResultRepository) DeleteOlderThan(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM probe_results WHERE timestamp < ?", formatTimestamp(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old probe results: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// ProbeAlertRepository provides database operations for probe alerts
+type ProbeAlertRepository struct {
+	db *DB
+}
+
+// NewProbeAlertRepository creates a new probe alert repository
+func NewProbeAlertRepository(db *DB) *ProbeAlertRepository {
+	return &ProbeAlertRepository{db: db}
+}
+
+// UpsertBatch inserts alerts or updates the mutable fields of alerts that already exist
+func (r *ProbeAlertRepository) UpsertBatch(alerts []*ProbeAlert) error {
+	query := `
+		INSERT INTO probe_alerts (id, probe_id, type, severity, status, message, count, first_seen, last_seen, resolved_at, metadata, org_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			severity = excluded.severity,
+			status = excluded.status,
+			message = excluded.message,
+			count = excluded.count,
+			last_seen = excluded.last_seen,
+			resolved_at = excluded.resolved_at,
+			metadata = excluded.metadata
+	`
+	return r.db.WithTx(r.db.context(), func(tx *DB) error {
+		for _, alert := range alerts {
+			var resolvedAt *string
+			if alert.ResolvedAt != nil {
+				formatted := formatTimestamp(*alert.ResolvedAt)
+				resolvedAt = &formatted
+			}
+			_, err := tx.Exec(query, alert.ID, alert.ProbeID, alert.Type, alert.Severity, alert.Status, alert.Message,
+				alert.Count, formatTimestamp(alert.FirstSeen), formatTimestamp(alert.LastSeen), resolvedAt, alert.Metadata, alert.OrgID)
+			if err != nil {
+				return fmt.Errorf("failed to upsert probe alert: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ListByStatus lists alerts with the given status last seen at or after since, most recent first
+func (r *ProbeAlertRepository) ListByStatus(status string, since time.Time, limit int) ([]*ProbeAlert, error) {
+	var alerts []*ProbeAlert
+	query := `
+		SELECT * FROM probe_alerts
+		WHERE status = ? AND last_seen >= ?
+		ORDER BY last_seen DESC, id DESC
+		LIMIT ?
+	`
+	if err := r.db.Select(&alerts, query, status, formatTimestamp(since), limit); err != nil {
+		return nil, fmt.Errorf("failed to list probe alerts: %w", err)
+	}
+	return alerts, nil
+}
+
+// ResolveStale resolves active alerts last seen before cutoff and returns how many were resolved
+func (r *ProbeAlertRepository) ResolveStale(cutoff, resolvedAt time.Time) (int64, error) {
+	query := "UPDATE probe_alerts SET status = 'resolved', resolved_at = ? WHERE status = 'active' AND last_seen < ?"
+	result, err := r.db.Exec(query, formatTimestamp(resolvedAt), formatTimestamp(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve stale probe alerts: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// CountActiveBySeverity counts active alerts by severity
+func (r *ProbeAlertRepository) CountActiveBySeverity() (map[string]int, error) {
+	var rows []struct {
+		Severity string `db:"severity"`
+		Count    int    `db:"count"`
+	}
+	scope, scopeArgs := r.db.orgCondition("org_id")
+	query := "SELECT severity, COUNT(*) AS count FROM probe_alerts WHERE status = 'active'" + scope + " GROUP BY severity"
+	if err := r.db.Select(&rows, query, scopeArgs...); err != nil {
+		return nil, fmt.Errorf("failed to count active probe alerts: %w", err)
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Severity] = row.Count
+	}
+	return counts, nil
+}
+
+// DeleteResolvedBefore deletes resolved and suppressed alerts closed before cutoff
+// and returns how many were removed
+func (r *ProbeAlertRepository) DeleteResolvedBefore(cutoff time.Time) (int64, error) {
+	query := "DELETE FROM probe_alerts WHERE status IN ('resolved', 'suppressed') AND resolved_at < ?"
+	result, err := r.db.Exec(query, formatTimestamp(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete resolved probe alerts: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// LoginAttemptRepository provides database operations for login attempts
+type LoginAttemptRepository struct {
+	db *DB
+}
+
+// NewLoginAttemptRepository creates a new login attempt repository
+func NewLoginAttemptRepository(db *DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+// Record stores a login attempt, timestamping it now when no time is set
+func (r *LoginAttemptRepository) Record(attempt *LoginAttempt) error {
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO login_attempts (username, ip_address, success, locked, cleared, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	result, err := r.db.Exec(query, attempt.Username, attempt.IPAddress, attempt.Success,
+		attempt.Locked, attempt.Cleared, formatTimestamp(attempt.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get login attempt ID: %w", err)
+	}
+	attempt.ID = int(id)
+	return nil
+}
+
+// RecentFailures returns the times of failed attempts for a username made at
+// or after since that still count towards a lockout, newest first
+func (r *LoginAttemptRepository) RecentFailures(username string, since time.Time) ([]time.Time, error) {
+	var failures []time.Time
+	query := `
+		SELECT created_at FROM login_attempts
+		WHERE username = ? AND success = 0 AND locked = 0 AND cleared = 0 AND created_at >= ?
+		ORDER BY created_at DESC, id DESC
+	`
+	if err := r.db.Select(&failures, query, username, formatTimestamp(since)); err != nil {
+		return nil, fmt.Errorf("failed to count failed logins: %w", err)
+	}
+	return failures, nil
+}
+
+// Clear stops a username's failed attempts counting towards a lockout and
+// returns how many were cleared. The attempts are kept for auditing.
+func (r *LoginAttemptRepository) Clear(username string) (int64, error) {
+	query := "UPDATE login_attempts SET cleared = 1 WHERE username = ? AND success = 0 AND cleared = 0"
+	result, err := r.db.Exec(query, username)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear failed logins: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// DeleteOlderThan deletes login attempts made before cutoff and returns how
+// many were removed
+func (r *LoginAttemptRepository) DeleteOlderThan(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM login_attempts WHERE created_at < ?", formatTimestamp(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old login attempts: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// List lists login attempts, optionally for a single username, newest first
+func (r *LoginAttemptRepository) List(username string, limit int) ([]*LoginAttempt, error) {
+	var attempts []*LoginAttempt
+	query := `
+		SELECT * FROM login_attempts
+		WHERE ? = '' OR username = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`
+	if err := r.db.Select(&attempts, query, username, username, limit); err != nil {
+		return nil, fmt.Errorf("failed to list login attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+// ErrInvalidResetToken is returned when a password reset token does not
+// exist, has expired or has already been used
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// PasswordResetTokenRepository provides database operations for password reset tokens
+type PasswordResetTokenRepository struct {
+	db *DB
+}
+
+// NewPasswordResetTokenRepository creates a new password reset token repository
+func NewPasswordResetTokenRepository(db *DB) *PasswordResetTokenRepository {
+	return &PasswordResetTokenRepository{db: db}
+}
+
+// Create stores a reset token and invalidates any earlier unused tokens for the same user
+func (r *PasswordResetTokenRepository) Create(token *PasswordResetToken) error {
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+
+	return r.db.WithTx(r.db.context(), func(tx *DB) error {
+		if _, err := tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = ? AND used_at IS NULL", token.UserID); err != nil {
+			return fmt.Errorf("failed to invalidate previous reset tokens: %w", err)
+		}
+
+		query := `
+			INSERT INTO password_reset_tokens (user_id, token_hash, expires_at, created_at)
+			VALUES (?, ?, ?, ?)
+		`
+		result, err := tx.Exec(query, token.UserID, token.TokenHash, formatTimestamp(token.ExpiresAt), formatTimestamp(token.CreatedAt))
+		if err != nil {
+			return fmt.Errorf("failed to create password reset token: %w", err)
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get password reset token ID: %w", err)
+		}
+		token.ID = int(id)
+		return nil
+	})
+}
+
+// GetValid returns an unexpired, unused token without consuming it
+func (r *PasswordResetTokenRepository) GetValid(tokenHash string, now time.Time) (*PasswordResetToken, error) {
+	var token PasswordResetToken
+	query := "SELECT * FROM password_reset_tokens WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?"
+	err := r.db.Get(&token, query, tokenHash, formatTimestamp(now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get password reset token: %w", notFound(err))
+	}
+	return &token, nil
+}
+
+// Consume marks an unexpired, unused token as used at now and returns the
+// user it belongs to. A token can only be consumed once.
+func (r *PasswordResetTokenRepository) Consume(tokenHash string, now time.Time) (int, error) {
+	var userID int
+	query := `
+		UPDATE password_reset_tokens SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id
+	`
+	err := r.db.Get(&userID, query, formatTimestamp(now), tokenHash, formatTimestamp(now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInvalidResetToken
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to consume password reset token: %w", err)
+	}
+	return userID, nil
+}
+
+// ErrAPIKeyNotFound is returned when an API key does not exist, or is
+// revoked or expired when an active key is required
+var ErrAPIKeyNotFound = fmt.Errorf("API key %w", ErrNotFound)
+
+// APIKeyRepository provides database operations for API keys
+type APIKeyRepository struct {
+	db *DB
+}
+
+// NewAPIKeyRepository creates a new API key repository
+func NewAPIKeyRepository(db *DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// Create stores a new API key
+func (r *APIKeyRepository) Create(key *APIKey) error {
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+
+	var expiresAt *string
+	if key.ExpiresAt != nil {
+		formatted := formatTimestamp(*key.ExpiresAt)
+		expiresAt = &formatted
+	}
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, revoked, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := r.db.Exec(query, key.UserID, key.Name, key.Prefix, key.KeyHash, key.Scopes,
+		expiresAt, key.Revoked, formatTimestamp(key.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get API key ID: %w", err)
+	}
+	key.ID = int(id)
+	return nil
+}
+
+// GetActiveByHash gets an unrevoked, unexpired API key by the hash of the key
+func (r *APIKeyRepository) GetActiveByHash(keyHash string, now time.Time) (*APIKey, error) {
+	var key APIKey
+	query := `
+		SELECT * FROM api_keys
+		WHERE key_hash = ? AND revoked = 0 AND (expires_at IS NULL OR expires_at > ?)
+	`
+	err := r.db.Get(&key, query, keyHash, formatTimestamp(now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", notFound(err))
+	}
+	return &key, nil
+}
+
+// ListByUser lists a user's API keys, newest first
+func (r *APIKeyRepository) ListByUser(userID int) ([]*APIKey, error) {
+	var keys []*APIKey
+	query := "SELECT * FROM api_keys WHERE user_id = ? ORDER BY created_at DESC, id DESC"
+	if err := r.db.Select(&keys, query, userID); err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// Revoke revokes one of a user's API keys
+func (r *APIKeyRepository) Revoke(userID, keyID int) error {
+	result, err := r.db.Exec("UPDATE api_keys SET revoked = 1 WHERE id = ? AND user_id = ?", keyID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if rows == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// UpdateLastUsed records when an API key last authenticated a request
+func (r *APIKeyRepository) UpdateLastUsed(keyID int, usedAt time.Time) error {
+	_, err := r.db.Exec("UPDATE api_keys SET last_used = ? WHERE id = ?", formatTimestamp(usedAt), keyID)
+	if err != nil {
+		return fmt.Errorf("failed to update API key last used: %w", err)
+	}
+	return nil
+}
+
+// Maintenance window errors
+var (
+	ErrMaintenanceWindowNotFound = fmt.Errorf("maintenance window %w", ErrNotFound)
+	ErrMaintenanceWindowOverlap  = errors.New("maintenance window overlaps another window of the service")
+)
+
+// MaintenanceWindowRepository provides database operations for maintenance windows
+type MaintenanceWindowRepository struct {
+	db *DB
+}
+
+// NewMaintenanceWindowRepository creates a new maintenance window repository
+func NewMaintenanceWindowRepository(db *DB) *MaintenanceWindowRepository {
+	return &MaintenanceWindowRepository{db: db}
+}
+
+// Create stores a maintenance window, rejecting it with
+// ErrMaintenanceWindowOverlap if it overlaps another window of the service.
+// Windows that merely touch, one ending when the next starts, don't overlap.
+func (r *MaintenanceWindowRepository) Create(window *MaintenanceWindow) error {
+	if window.CreatedAt.IsZero() {
+		window.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO maintenance_windows (service_id, starts_at, ends_at, message, created_by, created_at)
+		SELECT ?, ?, ?, ?, ?, ?
+		WHERE NOT EXISTS (
+			SELECT 1 FROM maintenance_windows WHERE service_id = ? AND starts_at < ? AND ends_at > ?
+		)
+	`
+	startsAt, endsAt := formatTimestamp(window.StartsAt), formatTimestamp(window.EndsAt)
+	result, err := r.db.Exec(query, window.ServiceID, startsAt, endsAt, window.Message, window.CreatedBy,
+		formatTimestamp(window.CreatedAt), window.ServiceID, endsAt, startsAt)
+	if err != nil {
+		return fmt.Errorf("failed to create maintenance window: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to create maintenance window: %w", err)
+	}
+	if rows == 0 {
+		return ErrMaintenanceWindowOverlap
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get maintenance window ID: %w", err)
+	}
+	window.ID = int(id)
+	return nil
+}
+
+// GetByID gets a maintenance window by ID
+func (r *MaintenanceWindowRepository) GetByID(id int) (*MaintenanceWindow, error) {
+	var window MaintenanceWindow
+	err := r.db.Get(&window, "SELECT * FROM maintenance_windows WHERE id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMaintenanceWindowNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get maintenance window: %w", notFound(err))
+	}
+	return &window, nil
+}
+
+// ListByService lists a service's maintenance windows in the order they start
+func (r *MaintenanceWindowRepository) ListByService(serviceID string) ([]*MaintenanceWindow, error) {
+	windows := []*MaintenanceWindow{}
+	query := "SELECT * FROM maintenance_windows WHERE service_id = ? ORDER BY starts_at, id"
+	if err := r.db.Select(&windows, query, serviceID); err != nil {
+		return nil, fmt.Errorf("failed to list maintenance windows: %w", err)
+	}
+	return windows, nil
+}
+
+// GetActive gets the window of a service that is active at now, returning
+// ErrMaintenanceWindowNotFound when the service isn't under maintenance
+func (r *MaintenanceWindowRepository) GetActive(serviceID string, now time.Time) (*MaintenanceWindow, error) {
+	var window MaintenanceWindow
+	query := "SELECT * FROM maintenance_windows WHERE service_id = ? AND starts_at <= ? AND ends_at > ?"
+	err := r.db.Get(&window, query, serviceID, formatTimestamp(now), formatTimestamp(now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMaintenanceWindowNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active maintenance window: %w", notFound(err))
+	}
+	return &window, nil
+}
+
+// ListActive returns the windows active at now keyed by service ID. Windows
+// of a service never overlap, so each service has at most one.
+func (r *MaintenanceWindowRepository) ListActive(now time.Time) (map[string]*MaintenanceWindow, error) {
+	var windows []*MaintenanceWindow
+	query := "SELECT * FROM maintenance_windows WHERE starts_at <= ? AND ends_at > ?"
+	if err := r.db.Select(&windows, query, formatTimestamp(now), formatTimestamp(now)); err != nil {
+		return nil, fmt.Errorf("failed to list active maintenance windows: %w", err)
+	}
+
+	active := make(map[string]*MaintenanceWindow, len(windows))
+	for _, window := range windows {
+		active[window.ServiceID] = window
+	}
+	return active, nil
+}
+
+// Update changes the period and message of a maintenance window, rejecting
+// the change with ErrMaintenanceWindowOverlap if it would overlap another
+// window of the service
+func (r *MaintenanceWindowRepository) Update(window *MaintenanceWindow) error {
+	if _, err := r.GetByID(window.ID); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE maintenance_windows SET starts_at = ?, ends_at = ?, message = ?
+		WHERE id = ? AND NOT EXISTS (
+			SELECT 1 FROM maintenance_windows
+			WHERE service_id = ? AND id != ? AND starts_at < ? AND ends_at > ?
+		)
+	`
+	startsAt, endsAt := formatTimestamp(window.StartsAt), formatTimestamp(window.EndsAt)
+	result, err := r.db.Exec(query, startsAt, endsAt, window.Message, window.ID,
+		window.ServiceID, window.ID, endsAt, startsAt)
+	if err != nil {
+		return fmt.Errorf("failed to update maintenance window: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update maintenance window: %w", err)
+	}
+	if rows == 0 {
+		return ErrMaintenanceWindowOverlap
+	}
+	return nil
+}
+
+// Delete deletes a maintenance window
+func (r *MaintenanceWindowRepository) Delete(id int) error {
+	result, err := r.db.Exec("DELETE FROM maintenance_windows WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete maintenance window: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete maintenance window: %w", err)
+	}
+	if rows == 0 {
+		return ErrMaintenanceWindowNotFound
+	}
+	return nil
+}
+
+// SnapshotRepository provides database operations for snapshots
+type SnapshotRepository struct {
+	db *DB
+}
+
+// NewSnapshotRepository creates a new snapshot repository
+func NewSnapshotRepository(db *DB) *SnapshotRepository {
+	return &SnapshotRepository{db: db}
+}
+
+// LatestPerPlan lists every snapshot plan with its latest completed
+// snapshot, by plan name. Plans without one have no snapshot fields set.
+// Scoped to an organization, only its plans are listed.
+func (r *SnapshotRepository) LatestPerPlan() ([]*PlanSnapshot, error) {
+	var snapshots []*PlanSnapshot
+	query := `
+		SELECT p.id AS plan_id, p.name AS plan_name, p.enabled,
+			s.id AS snapshot_id, s.timestamp, s.size_bytes, s.kind
+		FROM snap_plans p
+		LEFT JOIN snapshots s ON s.id = (
+			SELECT id FROM snapshots
+			WHERE plan_id = p.id AND status = 'completed'
+			ORDER BY timestamp DESC
+			LIMIT 1
+		)`
+	scope, scopeArgs := r.db.orgWhere("p.org_id")
+	if err := r.db.Select(&snapshots, query+scope+" ORDER BY p.name", scopeArgs...); err != nil {
+		return nil, fmt.Errorf("failed to list latest snapshots: %w", err)
+	}
+	return snapshots, nil
+}
+
+// ErrCertificateNotFound is returned when a certificate does not exist
+var ErrCertificateNotFound = fmt.Errorf("certificate %w", ErrNotFound)
+
+// CertificateRepository provides database operations for certificates
+type CertificateRepository struct {
+	db *DB
+}
+
+// NewCertificateRepository creates a new certificate repository
+func NewCertificateRepository(db *DB) *CertificateRepository {
+	return &CertificateRepository{db: db}
+}
+
+// Create creates a new certificate
+func (r *CertificateRepository) Create(cert *Certificate) error {
+	query := `
+		INSERT INTO certificates (id, domain, not_before, not_after, cert_path, key_path, issuer_path, status, auto_renew)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING created_at, updated_at
+	`
+	err := r.db.QueryRowx(query, cert.ID, cert.Domain, formatTimestamp(cert.NotBefore), formatTimestamp(cert.NotAfter),
+		cert.CertPath, cert.KeyPath, cert.IssuerPath, cert.Status, cert.AutoRenew).Scan(&cert.CreatedAt, &cert.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return nil
+}
+
+// ListExpiring lists certificates that are not revoked and expire before
+// the given time, soonest first. Scoped to an organization, it lists those
+// of the hosts of its routes.
+func (r *CertificateRepository) ListExpiring(before time.Time) ([]*Certificate, error) {
+	var certs []*Certificate
+	scope, scopeArgs := r.db.orgWhere("org_id")
+	if scope != "" {
+		scope = " AND domain IN (SELECT host FROM routes" + scope + ")"
+	}
+	query := `
+		SELECT * FROM certificates
+		WHERE status != 'revoked' AND not_after < ?` + scope + `
+		ORDER BY not_after
+	`
+	if err := r.db.Select(&certs, query, append([]interface{}{formatTimestamp(before.UTC())}, scopeArgs...)...); err != nil {
+		return nil, fmt.Errorf("failed to list expiring certificates: %w", err)
+	}
+	return certs, nil
+}
+
+// Save records a certificate issued or renewed for a domain, replacing the
+// dates and files of the domain's existing record and marking it valid again.
+// The ID and creation time of an existing record are kept.
+func (r *CertificateRepository) Save(cert *Certificate) error {
+	if cert.ID == "" {
+		cert.ID = uuid.New().String()
+	}
+
+	query := `
+		INSERT INTO certificates (id, domain, not_before, not_after, cert_path, key_path, issuer_path, status, auto_renew)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(domain) DO UPDATE SET
+			not_before = excluded.not_before,
+			not_after = excluded.not_after,
+			cert_path = excluded.cert_path,
+			key_path = excluded.key_path,
+			issuer_path = excluded.issuer_path,
+			status = excluded.status,
+			auto_renew = excluded.auto_renew
+		RETURNING id, created_at, updated_at
+	`
+	err := r.db.QueryRowx(query, cert.ID, cert.Domain, formatTimestamp(cert.NotBefore), formatTimestamp(cert.NotAfter),
+		cert.CertPath, cert.KeyPath, cert.IssuerPath, cert.Status, cert.AutoRenew).Scan(&cert.ID, &cert.CreatedAt, &cert.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	return nil
+}
+
+// GetByID gets a certificate by ID
+func (r *CertificateRepository) GetByID(id string) (*Certificate, error) {
+	var cert Certificate
+	err := r.db.Get(&cert, "SELECT * FROM certificates WHERE id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCertificateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate: %w", notFound(err))
+	}
+	return &cert, nil
+}
+
+// GetByDomain gets the certificate of a domain
+func (r *CertificateRepository) GetByDomain(domain string) (*Certificate, error) {
+	var cert Certificate
+	err := r.db.Get(&cert, "SELECT * FROM certificates WHERE domain = ?", domain)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCertificateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate: %w", notFound(err))
+	}
+	return &cert, nil
+}
+
+// List lists every certificate, soonest to expire first
+func (r *CertificateRepository) List() ([]*Certificate, error) {
+	certs := []*Certificate{}
+	if err := r.db.Select(&certs, "SELECT * FROM certificates ORDER BY not_after, domain"); err != nil {
+		return nil, fmt.Errorf("failed to list certificates: %w", err)
+	}
+	return certs, nil
+}
+
+// ListExpiringWithin lists the valid certificates that are still valid at now
+// but expire within the given duration, soonest first. A certificate expiring
+// exactly at now is already expired and one expiring exactly at now+within is
+// included.
+func (r *CertificateRepository) ListExpiringWithin(now time.Time, within time.Duration) ([]*Certificate, error) {
+	certs := []*Certificate{}
+	query := `
+		SELECT * FROM certificates
+		WHERE status = 'valid' AND not_after > ? AND not_after <= ?
+		ORDER BY not_after, domain
+	`
+	if err := r.db.Select(&certs, query, formatTimestamp(now), formatTimestamp(now.Add(within))); err != nil {
+		return nil, fmt.Errorf("failed to list expiring certificates: %w", err)
+	}
+	return certs, nil
+}
+
+// UpdateStatus sets the status of a certificate
+func (r *CertificateRepository) UpdateStatus(id, status string) error {
+	result, err := r.db.Exec("UPDATE certificates SET status = ? WHERE id = ?", status, id)
+	if err != nil {
+		return fmt.Errorf("failed to update certificate status: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update certificate status: %w", err)
+	}
+	if rows == 0 {
+		return ErrCertificateNotFound
+	}
+	return nil
+}
+
+// MarkExpired marks the valid certificates whose not_after has passed at now
+// as expired, returning how many were marked
+func (r *CertificateRepository) MarkExpired(now time.Time) (int64, error) {
+	result, err := r.db.Exec("UPDATE certificates SET status = 'expired' WHERE status = 'valid' AND not_after <= ?", formatTimestamp(now))
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark expired certificates: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark expired certificates: %w", err)
+	}
+	return rows, nil
+}
+
+// Delete deletes a certificate record. The certificate files are left alone.
+func (r *CertificateRepository) Delete(id string) error {
+	result, err := r.db.Exec("DELETE FROM certificates WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete certificate: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete certificate: %w", err)
+	}
+	if rows == 0 {
+		return ErrCertificateNotFound
+	}
+	return nil
+}
+
+// ErrOAuthClientNotFound is returned when an OAuth client does not exist
+var ErrOAuthClientNotFound = fmt.Errorf("OAuth client %w", ErrNotFound)
+
+// OAuthClientRepository provides database operations for OAuth clients
+type OAuthClientRepository struct {
+	db *DB
+}
+
+// NewOAuthClientRepository creates a new OAuth client repository
+func NewOAuthClientRepository(db *DB) *OAuthClientRepository {
+	return &OAuthClientRepository{db: db}
+}
+
+// Create stores a new OAuth client
+func (r *OAuthClientRepository) Create(client *OAuthClient) error {
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := r.db.Exec(query, client.ID, client.Name, client.SecretHash, client.RedirectURIs,
+		client.CreatedBy, formatTimestamp(client.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to create OAuth client: %w", err)
+	}
+	return nil
+}
+
+// GetByID gets an OAuth client by its client ID
+func (r *OAuthClientRepository) GetByID(id string) (*OAuthClient, error) {
+	var client OAuthClient
+	err := r.db.Get(&client, "SELECT * FROM oauth_clients WHERE id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOAuthClientNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OAuth client: %w", notFound(err))
+	}
+	return &client, nil
+}
+
+// List lists all OAuth clients by name
+func (r *OAuthClientRepository) List() ([]*OAuthClient, error) {
+	var clients []*OAuthClient
+	if err := r.db.Select(&clients, "SELECT * FROM oauth_clients ORDER BY name, id"); err != nil {
+		return nil, fmt.Errorf("failed to list OAuth clients: %w", err)
+	}
+	return clients, nil
+}
+
+// Delete deletes an OAuth client
+func (r *OAuthClientRepository) Delete(id string) error {
+	result, err := r.db.Exec("DELETE FROM oauth_clients WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete OAuth client: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete OAuth client: %w", err)
+	}
+	if rows == 0 {
+		return ErrOAuthClientNotFound
+	}
+	return nil
+}
+
+// ErrServiceTemplateNotFound is returned when a service template does not exist
+var ErrServiceTemplateNotFound = fmt.Errorf("service template %w", ErrNotFound)
+
+// ServiceTemplateRepository provides database operations for service templates
+type ServiceTemplateRepository struct {
+	db *DB
+}
+
+// NewServiceTemplateRepository creates a new service template repository
+func NewServiceTemplateRepository(db *DB) *ServiceTemplateRepository {
+	return &ServiceTemplateRepository{db: db}
+}
+
+// Create stores a new service template, generating its ID when empty
+func (r *ServiceTemplateRepository) Create(template *ServiceTemplate) error {
+	if template.ID == "" {
+		template.ID = uuid.New().String()
+	}
+	if template.Variables == "" {
+		template.Variables = "[]"
+	}
+	now := time.Now()
+	template.CreatedAt = now
+	template.UpdatedAt = now
+
+	query := `
+		INSERT INTO service_templates (id, name, description, category, body, variables, builtin, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := r.db.Exec(query, template.ID, template.Name, template.Description, template.Category,
+		template.Body, template.Variables, template.Builtin, formatTimestamp(now), formatTimestamp(now))
+	if err != nil {
+		return fmt.Errorf("failed to create service template: %w", err)
+	}
+	return nil
+}
+
+// GetByID gets a service template by ID
+func (r *ServiceTemplateRepository) GetByID(id string) (*ServiceTemplate, error) {
+	var template ServiceTemplate
+	err := r.db.Get(&template, "SELECT * FROM service_templates WHERE id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrServiceTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service template: %w", notFound(err))
+	}
+	return &template, nil
+}
+
+// GetByName gets a service template by name
+func (r *ServiceTemplateRepository) GetByName(name string) (*ServiceTemplate, error) {
+	var template ServiceTemplate
+	err := r.db.Get(&template, "SELECT * FROM service_templates WHERE name = ?", name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrServiceTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service template: %w", notFound(err))
+	}
+	return &template, nil
+}
+
+// List lists service templates by category and name, only those of the
+// category when it is set
+func (r *ServiceTemplateRepository) List(category string) ([]*ServiceTemplate, error) {
+	templates := []*ServiceTemplate{}
+	query := "SELECT * FROM service_templates"
+	var args []interface{}
+	if category != "" {
+		query += " WHERE category = ?"
+		args = append(args, category)
+	}
+	query += " ORDER BY category, name"
+	if err := r.db.Select(&templates, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list service templates: %w", err)
+	}
+	return templates, nil
+}
+
+// Update updates a service template's name, description, category, body
+// and variables
+func (r *ServiceTemplateRepository) Update(template *ServiceTemplate) error {
+	template.UpdatedAt = time.Now()
+
+	query := `
+		UPDATE service_templates
+		SET name = ?, description = ?, category = ?, body = ?, variables = ?, updated_at = ?
+		WHERE id = ?
+	`
+	result, err := r.db.Exec(query, template.Name, template.Description, template.Category, template.Body,
+		template.Variables, formatTimestamp(template.UpdatedAt), template.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update service template: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update service template: %w", err)
+	}
+	if rows == 0 {
+		return ErrServiceTemplateNotFound
+	}
+	return nil
+}
+
+// Delete deletes a service template
+func (r *ServiceTemplateRepository) Delete(id string) error {
+	result, err := r.db.Exec("DELETE FROM service_templates WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete service template: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete service template: %w", err)
+	}
+	if rows == 0 {
+		return ErrServiceTemplateNotFound
+	}
+	return nil
+}
+
+// MaintenanceRunRepository provides database operations for runs of the
+// retention cleanup
+type MaintenanceRunRepository struct {
+	db *DB
+}
+
+// NewMaintenanceRunRepository creates a new maintenance run repository
+func NewMaintenanceRunRepository(db *DB) *MaintenanceRunRepository {
+	return &MaintenanceRunRepository{db: db}
+}
+
+// Create records a maintenance run
+func (r *MaintenanceRunRepository) Create(run *MaintenanceRun) error {
+	if run.Deleted == "" {
+		run.Deleted = "{}"
+	}
+
+	query := `
+		INSERT INTO maintenance_runs (started_at, duration_ms, deleted, error)
+		VALUES (?, ?, ?, ?)
+	`
+	result, err := r.db.Exec(query, formatTimestamp(run.StartedAt), run.DurationMS, run.Deleted, run.Error)
+	if err != nil {
+		return fmt.Errorf("failed to record maintenance run: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get maintenance run ID: %w", err)
+	}
+	run.ID = int(id)
+	return nil
+}
+
+// List lists the latest maintenance runs, newest first
+func (r *MaintenanceRunRepository) List(limit int) ([]*MaintenanceRun, error) {
+	runs := []*MaintenanceRun{}
+	query := "SELECT * FROM maintenance_runs ORDER BY started_at DESC, id DESC LIMIT ?"
+	if err := r.db.Select(&runs, query, limit); err != nil {
+		return nil, fmt.Errorf("failed to list maintenance runs: %w", err)
+	}
+	return runs, nil
+}
+
+// ErrNodeNotFound is returned when a node does not exist
+var ErrNodeNotFound = fmt.Errorf("node %w", ErrNotFound)
+
+// NodeRepository provides database operations for cluster nodes
+type NodeRepository struct {
+	db *DB
+}
+
+// NewNodeRepository creates a new node repository
+func NewNodeRepository(db *DB) *NodeRepository {
+	return &NodeRepository{db: db}
+}
+
+// Register stores a node, or updates the address, capacity, labels and
+// status of the node of the same name, which keeps its ID. It sets the
+// node's ID and timestamps from the stored row.
+func (r *NodeRepository) Register(node *Node) error {
+	if node.ID == "" {
+		node.ID = uuid.New().String()
+	}
+	if node.Labels == "" {
+		node.Labels = "{}"
+	}
+	now := time.Now()
+
+	query := `
+		INSERT INTO nodes (id, name, address, status, cpu_capacity, memory_capacity, pod_capacity, labels, last_heartbeat, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			address = excluded.address, status = excluded.status, cpu_capacity = excluded.cpu_capacity,
+			memory_capacity = excluded.memory_capacity, pod_capacity = excluded.pod_capacity,
+			labels = excluded.labels, last_heartbeat = excluded.last_heartbeat, updated_at = excluded.updated_at
+	`
+	_, err := r.db.Exec(query, node.ID, node.Name, node.Address, node.Status, node.CPUCapacity, node.MemoryCapacity,
+		node.PodCapacity, node.Labels, formatTimestamp(now), formatTimestamp(now), formatTimestamp(now))
+	if err != nil {
+		return fmt.Errorf("failed to register node: %w", err)
+	}
+
+	stored, err := r.GetByName(node.Name)
+	if err != nil {
+		return err
+	}
+	*node = *stored
+	return nil
+}
+
+// GetByName gets a node by name
+func (r *NodeRepository) GetByName(name string) (*Node, error) {
+	var node Node
+	err := r.db.Get(&node, "SELECT * FROM nodes WHERE name = ?", name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNodeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node: %w", notFound(err))
+	}
+	return &node, nil
+}
+
+// List lists the nodes by name
+func (r *NodeRepository) List() ([]*Node, error) {
+	nodes := []*Node{}
+	if err := r.db.Select(&nodes, "SELECT * FROM nodes ORDER BY name"); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	return nodes, nil
+}
+
+// Heartbeat records the usage a node reported at a time, and marks it with
+// status
+func (r *NodeRepository) Heartbeat(id, status string, cpu, memory int64, pods int, at time.Time) error {
+	query := `
+		UPDATE nodes
+		SET status = ?, cpu_used = ?, memory_used = ?, pods_used = ?, last_heartbeat = ?, updated_at = ?
+		WHERE id = ?
+	`
+	result, err := r.db.Exec(query, status, cpu, memory, pods, formatTimestamp(at), formatTimestamp(time.Now()), id)
+	if err != nil {
+		return fmt.Errorf("failed to record node heartbeat: %w", err)
+	}
+	return nodeUpdated(result)
+}
+
+// SetStatus sets the status of a node
+func (r *NodeRepository) SetStatus(id, status string) error {
+	result, err := r.db.Exec("UPDATE nodes SET status = ?, updated_at = ? WHERE id = ?", status, formatTimestamp(time.Now()), id)
+	if err != nil {
+		return fmt.Errorf("failed to set node status: %w", err)
+	}
+	return nodeUpdated(result)
+}
+
+// nodeUpdated returns ErrNodeNotFound if an update changed no node
+func nodeUpdated(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update node: %w", err)
+	}
+	if rows == 0 {
+		return ErrNodeNotFound
+	}
+	return nil
+}
+
+// ErrServiceRevisionNotFound is returned when a service has no such revision
+var ErrServiceRevisionNotFound = fmt.Errorf("service revision %w", ErrNotFound)
+
+// ServiceRevisionRepository provides database operations for the revisions
+// of services
+type ServiceRevisionRepository struct {
+	db *DB
+}
+
+// NewServiceRevisionRepository creates a new service revision repository
+func NewServiceRevisionRepository(db *DB) *ServiceRevisionRepository {
+	return &ServiceRevisionRepository{db: db}
+}
+
+// Record stores the configuration of a service as the revision of its
+// version, unless that revision was already recorded
+func (r *ServiceRevisionRepository) Record(service *Service, createdBy *int) error {
+	config, err := json.Marshal(service)
+	if err != nil {
+		return fmt.Errorf("failed to marshal service revision: %w", err)
+	}
+
+	query := `
+		INSERT OR IGNORE INTO service_revisions (service_id, revision, config, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	if _, err := r.db.Exec(query, service.ID, service.Version, string(config), createdBy, formatTimestamp(time.Now())); err != nil {
+		return fmt.Errorf("failed to record service revision: %w", err)
+	}
+	return nil
+}
+
+// List returns the revisions of a service, newest first
+func (r *ServiceRevisionRepository) List(serviceID string) ([]*ServiceRevision, error) {
+	revisions := []*ServiceRevision{}
+	query := "SELECT * FROM service_revisions WHERE service_id = ? ORDER BY revision DESC"
+	if err := r.db.Select(&revisions, query, serviceID); err != nil {
+		return nil, fmt.Errorf("failed to list service revisions: %w", err)
+	}
+	return revisions, nil
+}
+
+// Get returns a revision of a service
+func (r *ServiceRevisionRepository) Get(serviceID string, revision int) (*ServiceRevision, error) {
+	var rev ServiceRevision
+	query := "SELECT * FROM service_revisions WHERE service_id = ? AND revision = ?"
+	if err := r.db.Get(&rev, query, serviceID, revision); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrServiceRevisionNotFound
+		}
+		return nil, fmt.Errorf("failed to get service revision: %w", notFound(err))
+	}
+	return &rev, nil
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// The console, orchestrator, probe and snap daemons and their background
+// loops all write to the same SQLite file. Within a process writes are
+// queued on a single connection; between processes SQLite's busy timeout
+// waits for the lock, and statements that still find the database busy are
+// retried with jittered backoff until the retry timeout.
+
+// Retry defaults used when the database config leaves them unset
+const (
+	defaultRetryTimeout = 10 * time.Second
+	retryBackoffMin     = 10 * time.Millisecond
+	retryBackoffMax     = 500 * time.Millisecond
+)
+
+// retryStats counts busy retries. The copies WithContext and WithTx make of
+// a DB share them.
+type retryStats struct {
+	retries  atomic.Int64 // statements and transactions run again after finding the database busy
+	failures atomic.Int64 // ones still busy at the retry timeout, whose error reached the caller
+}
+
+// queryer runs statements; the pools and transactions both do
+type queryer interface {
+	sqlx.ExtContext
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// isBusy reports whether err is SQLite finding the database or a table locked
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}
+
+// isReadOnly reports whether a statement only reads, so that it can run on
+// the read pool. Statements with RETURNING clauses start with the write.
+func isReadOnly(query string) bool {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	end := strings.IndexAny(query, " \t\r\n")
+	if end < 0 {
+		end = len(query)
+	}
+	switch strings.ToUpper(query[:end]) {
+	case "SELECT", "WITH":
+		return true
+	}
+	return false
+}
+
+// queryer returns what a statement runs on: the transaction the DB is bound
+// to, the read pool for queries, or the write connection otherwise
+func (db *DB) queryer(query string) queryer {
+	switch {
+	case db.tx != nil:
+		return db.tx
+	case db.reader != nil && isReadOnly(query):
+		return db.reader
+	default:
+		return db.DB
+	}
+}
+
+// retry runs a statement again while it finds the database busy, waiting a
+// jittered, doubling backoff in between, until the retry timeout passes or
+// the DB's context is done. Statements in a transaction are run once; WithTx
+// retries the transaction as a whole.
+func (db *DB) retry(statement func() error) error {
+	if db.tx != nil {
+		return statement()
+	}
+
+	timeout := db.retryTimeout
+	if timeout <= 0 {
+		timeout = defaultRetryTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	backoff := retryBackoffMin
+	for {
+		err := statement()
+		if !isBusy(err) {
+			return err
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		if time.Now().Add(wait).After(deadline) {
+			db.countRetry(false)
+			return err
+		}
+		db.countRetry(true)
+		select {
+		case <-db.context().Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, retryBackoffMax)
+	}
+}
+
+// countRetry counts a retry, or a statement that gave up retrying
+func (db *DB) countRetry(retried bool) {
+	if db.stats == nil {
+		return
+	}
+	if retried {
+		db.stats.retries.Add(1)
+	} else {
+		db.stats.failures.Add(1)
+	}
+}
+
+// WithTx runs fn in a transaction that is committed when fn returns nil and
+// rolled back otherwise. Statements of the DB passed to fn, and of the
+// repositories it returns, run in the transaction. When the database is
+// busy the whole transaction is retried, so fn may run more than once and
+// should only change the database. Within a transaction, fn joins it.
+func (db *DB) WithTx(ctx context.Context, fn func(tx *DB) error) error {
+	bound := db.WithContext(ctx)
+	if db.tx != nil {
+		return fn(bound)
+	}
+
+	return bound.retry(func() error {
+		tx, err := bound.DB.BeginTxx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		inTx := *bound
+		inTx.tx = tx
+		if err := fn(&inTx); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// openSharedDB opens the database file at path the way each daemon does,
+// with a busy timeout short enough that contention reaches the retries
+func openSharedDB(t *testing.T, path string) *DB {
+	cfg := &config.Config{}
+	cfg.Console.Database = config.DatabaseConfig{Path: path, WALMode: true, Timeout: "1ms", RetryTimeout: "30s"}
+	db, err := NewDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	return db
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console.db")
+	// Two handles on the file stand in for two daemons
+	dbs := []*DB{openSharedDB(t, path), openSharedDB(t, path)}
+	for _, db := range dbs {
+		defer db.Close()
+	}
+
+	const writers, writes = 8, 25
+	errs := make(chan error, len(dbs)*writers*writes)
+	var wg sync.WaitGroup
+	for d, db := range dbs {
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(db *DB, writer string) {
+				defer wg.Done()
+				for i := 0; i < writes; i++ {
+					if i%2 == 0 {
+						errs <- db.AuditLogRepository().Create(&AuditLog{Action: "write", ResourceType: writer})
+						continue
+					}
+					// Both rows or neither
+					errs <- db.WithTx(context.Background(), func(tx *DB) error {
+						if err := tx.LoginAttemptRepository().Record(&LoginAttempt{Username: writer}); err != nil {
+							return err
+						}
+						return tx.AuditLogRepository().Create(&AuditLog{Action: "login", ResourceType: writer})
+					})
+				}
+			}(db, fmt.Sprintf("writer-%d-%d", d, w))
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Write failed: %v", err)
+		}
+	}
+
+	var audits, attempts int
+	if err := dbs[0].Get(&audits, "SELECT COUNT(*) FROM audit_logs"); err != nil {
+		t.Fatalf("Failed to count audit logs: %v", err)
+	}
+	if err := dbs[0].Get(&attempts, "SELECT COUNT(*) FROM login_attempts"); err != nil {
+		t.Fatalf("Failed to count login attempts: %v", err)
+	}
+	total := len(dbs) * writers * writes
+	if audits != total {
+		t.Errorf("Expected %d audit logs, got %d", total, audits)
+	}
+	if want := len(dbs) * writers * (writes / 2); attempts != want {
+		t.Errorf("Expected %d login attempts, got %d", want, attempts)
+	}
+
+	for _, db := range dbs {
+		stats, err := db.GetStats()
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+		if stats["busy_retry_failures"] != int64(0) {
+			t.Errorf("Expected no writes to give up, got %v", stats["busy_retry_failures"])
+		}
+	}
+}
+
+func TestRetryWhileLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console.db")
+	db, other := openSharedDB(t, path), openSharedDB(t, path)
+	defer db.Close()
+	defer other.Close()
+
+	// Another process holds the write lock for a while
+	tx, err := other.Beginx()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO login_attempts (username, success, created_at) VALUES ('other', 0, ?)", formatTimestamp(time.Now())); err != nil {
+		t.Fatalf("Failed to insert login attempt: %v", err)
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		tx.Commit()
+	}()
+
+	if err := db.LoginAttemptRepository().Record(&LoginAttempt{Username: "ops"}); err != nil {
+		t.Fatalf("Expected the write to wait for the lock, got %v", err)
+	}
+	stats, err := db.GetStats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if retries, _ := stats["busy_retries"].(int64); retries == 0 {
+		t.Errorf("Expected busy retries to be counted, got %v", stats["busy_retries"])
+	}
+
+	// Without retries left the busy error reaches the caller
+	tx, err = other.Beginx()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	db.retryTimeout = 50 * time.Millisecond
+	err = db.LoginAttemptRepository().Record(&LoginAttempt{Username: "ops"})
+	if !isBusy(err) {
+		t.Fatalf("Expected a busy error, got %v", err)
+	}
+	stats, _ = db.GetStats()
+	if stats["busy_retry_failures"] != int64(1) {
+		t.Errorf("Expected 1 failed retry, got %v", stats["busy_retry_failures"])
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	count := func() int {
+		var n int
+		if err := db.Get(&n, "SELECT COUNT(*) FROM login_attempts"); err != nil {
+			t.Fatalf("Failed to count login attempts: %v", err)
+		}
+		return n
+	}
+
+	// An error rolls back every statement, including those of a nested call
+	errFailed := errors.New("failed")
+	err := db.WithTx(context.Background(), func(tx *DB) error {
+		if err := tx.LoginAttemptRepository().Record(&LoginAttempt{Username: "a"}); err != nil {
+			return err
+		}
+		return tx.WithTx(context.Background(), func(nested *DB) error {
+			if err := nested.LoginAttemptRepository().Record(&LoginAttempt{Username: "b"}); err != nil {
+				return err
+			}
+			return errFailed
+		})
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("Expected the transaction's error, got %v", err)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("Expected the transaction to be rolled back, found %d rows", n)
+	}
+
+	err = db.WithTx(context.Background(), func(tx *DB) error {
+		for _, username := range []string{"a", "b"} {
+			if err := tx.LoginAttemptRepository().Record(&LoginAttempt{Username: username}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to run transaction: %v", err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Expected 2 committed rows, got %d", n)
+	}
+}
+
+func TestIsReadOnly(t *testing.T) {
+	for query, want := range map[string]bool{
+		"SELECT * FROM users":                            true,
+		"\n\t\tselect id FROM services":                  true,
+		"WITH recent AS (SELECT 1) SELECT * FROM recent": true,
+		"INSERT INTO users (username) VALUES (?)":        false,
+		"UPDATE users SET role = ? RETURNING id":         false,
+		"PRAGMA page_count":                              false,
+	} {
+		if got := isReadOnly(query); got != want {
+			t.Errorf("isReadOnly(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
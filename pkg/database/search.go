@@ -0,0 +1,213 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Types of search results, as stored in the search_index table
+const (
+	SearchTypeServices    = "services"
+	SearchTypeRoutes      = "routes"
+	SearchTypeUsers       = "users"
+	SearchTypeSSOServices = "sso_services"
+)
+
+// SearchTypes are all the types of search results, in the order results are
+// grouped
+var SearchTypes = []string{SearchTypeServices, SearchTypeRoutes, SearchTypeUsers, SearchTypeSSOServices}
+
+// MinSearchQueryLength is the length of the shortest query the trigram
+// index can match
+const MinSearchQueryLength = 3
+
+// defaultSearchLimit is the number of results of each type returned when
+// SearchOptions has no limit
+const defaultSearchLimit = 10
+
+// ErrInvalidSearch is returned when a search query is too short or names an
+// unknown result type
+var ErrInvalidSearch = errors.New("invalid search")
+
+// SearchOptions selects what a search matches
+type SearchOptions struct {
+	Query string
+	Types []string // result types to search, all of them when empty
+	Limit int      // results of each type, defaultSearchLimit when zero
+
+	// UserID, when set, restricts registered services to those listed for
+	// the user by ListUserServices. Those that are not public must also have
+	// a required role HasRole accepts.
+	UserID  int
+	HasRole func(requiredRole string) bool
+}
+
+// SearchResult is a resource matching a search
+type SearchResult struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Label     string `json:"label"`
+	Secondary string `json:"secondary"`
+	// Snippet is the label, or else the other matched text, HTML-escaped
+	// with the matches wrapped in <mark> tags
+	Snippet string `json:"snippet"`
+}
+
+// searchRow is a row of the search_index table as selected by Search
+type searchRow struct {
+	ResourceID   string  `db:"resource_id"`
+	Label        string  `db:"label"`
+	Keywords     string  `db:"keywords"`
+	Secondary    string  `db:"secondary"`
+	IsPublic     *bool   `db:"is_public"`
+	RequiredRole *string `db:"required_role"`
+}
+
+// SearchRepository searches services, routes, users and registered services
+// through the search_index table
+type SearchRepository struct {
+	db *DB
+}
+
+// NewSearchRepository creates a new search repository
+func NewSearchRepository(db *DB) *SearchRepository {
+	return &SearchRepository{db: db}
+}
+
+// Search returns the resources whose name, display name, host, path or email
+// contains the query, case-insensitively, grouped by type. Exact matches of
+// the label rank first, then labels starting with the query, then the rest
+// by relevance.
+func (r *SearchRepository) Search(opts SearchOptions) (map[string][]*SearchResult, error) {
+	query := strings.TrimSpace(opts.Query)
+	if utf8.RuneCountInString(query) < MinSearchQueryLength {
+		return nil, fmt.Errorf("%w: query must be at least %d characters", ErrInvalidSearch, MinSearchQueryLength)
+	}
+	types := opts.Types
+	if len(types) == 0 {
+		types = SearchTypes
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	results := make(map[string][]*SearchResult, len(types))
+	for _, resultType := range types {
+		rows, err := r.search(resultType, query, limit, opts)
+		if err != nil {
+			return nil, err
+		}
+		results[resultType] = rows
+	}
+	return results, nil
+}
+
+// search returns the results of one type
+func (r *SearchRepository) search(resultType, query string, limit int, opts SearchOptions) ([]*SearchResult, error) {
+	columns := "NULL AS is_public, NULL AS required_role"
+	var join string
+	var joinArgs []interface{}
+	conditions := []string{"search_index MATCH ?", "search_index.type = ?"}
+	args := []interface{}{`{label keywords} : "` + strings.ReplaceAll(query, `"`, `""`) + `"`, resultType}
+	filterRoles := false
+	switch resultType {
+	case SearchTypeServices:
+		join = "JOIN services t ON t.id = search_index.resource_id"
+	case SearchTypeRoutes:
+		join = "JOIN routes t ON t.id = search_index.resource_id"
+	case SearchTypeUsers:
+	case SearchTypeSSOServices:
+		join = "JOIN registered_services t ON t.id = search_index.resource_id"
+		if opts.UserID != 0 {
+			columns = "t.is_public, t.required_role"
+			join += " LEFT JOIN user_service_permissions usp ON usp.service_id = t.id AND usp.user_id = ?"
+			joinArgs = append(joinArgs, opts.UserID)
+			conditions = append(conditions, `t.status = 'active'`,
+				`(t.is_public = TRUE OR (usp.can_access = TRUE AND (usp.expires_at IS NULL OR usp.expires_at > ?)))`)
+			args = append(args, time.Now())
+			filterRoles = opts.HasRole != nil
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown result type %q", ErrInvalidSearch, resultType)
+	}
+
+	statement := `SELECT search_index.resource_id, search_index.label, search_index.keywords, search_index.secondary, ` + columns + `
+		FROM search_index ` + join + `
+		WHERE ` + strings.Join(conditions, " AND ")
+	if join != "" {
+		scope, scopeArgs := r.db.orgCondition("t.org_id")
+		statement += scope
+		args = append(args, scopeArgs...)
+	}
+	statement += `
+		ORDER BY CASE
+			WHEN search_index.label = ? COLLATE NOCASE THEN 0
+			WHEN instr(lower(search_index.label), lower(?)) = 1 THEN 1
+			ELSE 2
+		END, search_index.rank, search_index.label`
+	args = append(append(joinArgs, args...), query, query)
+	if !filterRoles {
+		// Results filtered by role are limited once filtered
+		statement += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	var rows []searchRow
+	if err := r.db.Select(&rows, statement, args...); err != nil {
+		return nil, fmt.Errorf("failed to search %s: %w", resultType, err)
+	}
+
+	results := []*SearchResult{}
+	for _, row := range rows {
+		if len(results) == limit {
+			break
+		}
+		if filterRoles && !*row.IsPublic && !opts.HasRole(*row.RequiredRole) {
+			continue
+		}
+		snippet, ok := highlight(row.Label, query)
+		if !ok {
+			snippet, _ = highlight(row.Keywords, query)
+		}
+		results = append(results, &SearchResult{
+			Type:      resultType,
+			ID:        row.ResourceID,
+			Label:     row.Label,
+			Secondary: row.Secondary,
+			Snippet:   snippet,
+		})
+	}
+	return results, nil
+}
+
+// highlight returns text HTML-escaped with the case-insensitive occurrences
+// of query wrapped in <mark> tags, and whether there were any
+func highlight(text, query string) (string, bool) {
+	lowerText, lowerQuery := strings.ToLower(text), strings.ToLower(query)
+	if len(lowerText) != len(text) || len(lowerQuery) != len(query) || query == "" {
+		// Lowercasing changed byte offsets, so matches cannot be located
+		return html.EscapeString(text), false
+	}
+
+	var b strings.Builder
+	found := false
+	for {
+		i := strings.Index(lowerText, lowerQuery)
+		if i < 0 {
+			break
+		}
+		found = true
+		b.WriteString(html.EscapeString(text[:i]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[i : i+len(query)]))
+		b.WriteString("</mark>")
+		text, lowerText = text[i+len(query):], lowerText[i+len(query):]
+	}
+	b.WriteString(html.EscapeString(text))
+	return b.String(), found
+}
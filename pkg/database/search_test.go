@@ -0,0 +1,243 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+// searchLabels returns the labels of search results
+func searchLabels(results []*SearchResult) []string {
+	labels := []string{}
+	for _, result := range results {
+		labels = append(labels, result.Label)
+	}
+	return labels
+}
+
+func TestSearchRanking(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	for _, name := range []string{"my-web-app", "webhooks", "web"} {
+		service := &Service{Name: name, Image: "nginx", Port: 80, Replicas: 1, Status: "stopped"}
+		if err := db.ServiceRepository().Create(service); err != nil {
+			t.Fatalf("Failed to create service %s: %v", name, err)
+		}
+	}
+
+	results, err := db.SearchRepository().Search(SearchOptions{Query: "WEB", Types: []string{SearchTypeServices}})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	labels := searchLabels(results[SearchTypeServices])
+	// The exact name ranks first, then the prefix, then the substring
+	if len(labels) != 3 || labels[0] != "web" || labels[1] != "webhooks" || labels[2] != "my-web-app" {
+		t.Errorf("Unexpected ranking %v", labels)
+	}
+	if len(results) != 1 {
+		t.Errorf("Expected only the requested type, got %v", results)
+	}
+	result := results[SearchTypeServices][2]
+	if result.Type != SearchTypeServices || result.ID == "" || result.Secondary != "nginx" || result.Snippet != "my-<mark>web</mark>-app" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	results, err = db.SearchRepository().Search(SearchOptions{Query: "web", Types: []string{SearchTypeServices}, Limit: 2})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if labels := searchLabels(results[SearchTypeServices]); len(labels) != 2 || labels[0] != "web" {
+		t.Errorf("Expected the 2 best results, got %v", labels)
+	}
+}
+
+func TestSearchTypes(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+
+	user := &User{Username: "alice", Email: "alice@example.com", DisplayName: "Alice Example", PasswordHash: "hash", Role: "user"}
+	if err := db.UserRepository().Create(user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	route := &Route{Host: "shop.example.com", PathPrefix: "/checkout"}
+	if err := db.RouteRepository().Create(route); err != nil {
+		t.Fatalf("Failed to create route: %v", err)
+	}
+	registered := &RegisteredService{Name: "grafana", DisplayName: "Example Dashboards", ServiceURL: "http://localhost:3000", Category: "monitoring", RequiredRole: "user", Status: "active"}
+	if err := db.RegisteredServiceRepository().Create(registered); err != nil {
+		t.Fatalf("Failed to create registered service: %v", err)
+	}
+
+	results, err := db.SearchRepository().Search(SearchOptions{Query: "example"})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	for _, resultType := range SearchTypes {
+		if _, ok := results[resultType]; !ok {
+			t.Errorf("Expected results of type %s", resultType)
+		}
+	}
+	if len(results[SearchTypeServices]) != 0 {
+		t.Errorf("Expected no services, got %v", searchLabels(results[SearchTypeServices]))
+	}
+	if users := results[SearchTypeUsers]; len(users) != 1 || users[0].ID != "1" || users[0].Secondary != "alice@example.com" ||
+		users[0].Snippet != "Alice <mark>Example</mark> alice@<mark>example</mark>.com" {
+		t.Errorf("Unexpected users %+v", users)
+	}
+	if routes := results[SearchTypeRoutes]; len(routes) != 1 || routes[0].ID != route.ID || routes[0].Secondary != "/checkout" {
+		t.Errorf("Unexpected routes %+v", routes)
+	}
+	if services := results[SearchTypeSSOServices]; len(services) != 1 || services[0].ID != registered.ID || services[0].Label != "Example Dashboards" {
+		t.Errorf("Unexpected SSO services %+v", services)
+	}
+
+	// Paths and registered service names are searched too
+	results, err = db.SearchRepository().Search(SearchOptions{Query: "checkout", Types: []string{SearchTypeRoutes}})
+	if err != nil || len(results[SearchTypeRoutes]) != 1 {
+		t.Errorf("Expected the route by path, got %v, %v", results, err)
+	}
+	results, err = db.SearchRepository().Search(SearchOptions{Query: "grafana", Types: []string{SearchTypeSSOServices}})
+	if err != nil || len(results[SearchTypeSSOServices]) != 1 {
+		t.Errorf("Expected the registered service by name, got %v, %v", results, err)
+	}
+
+	for _, opts := range []SearchOptions{{Query: " ab "}, {Query: "example", Types: []string{"secrets"}}} {
+		if _, err := db.SearchRepository().Search(opts); !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("Expected ErrInvalidSearch for %+v, got %v", opts, err)
+		}
+	}
+}
+
+func TestSearchIndexSync(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	search := func(query string) []string {
+		t.Helper()
+		results, err := db.SearchRepository().Search(SearchOptions{Query: query, Types: []string{SearchTypeServices, SearchTypeUsers}})
+		if err != nil {
+			t.Fatalf("Failed to search: %v", err)
+		}
+		return append(searchLabels(results[SearchTypeServices]), searchLabels(results[SearchTypeUsers])...)
+	}
+
+	service := &Service{Name: "billing", Image: "billing:1", Port: 80, Replicas: 1, Status: "stopped"}
+	if err := db.ServiceRepository().Create(service); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	user := &User{Username: "bob", Email: "bob@example.com", PasswordHash: "hash", Role: "user"}
+	if err := db.UserRepository().Create(user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if labels := search("bill"); len(labels) != 1 {
+		t.Fatalf("Expected the created service, got %v", labels)
+	}
+
+	service.Name = "invoicing"
+	if err := db.ServiceRepository().Update(service); err != nil {
+		t.Fatalf("Failed to update service: %v", err)
+	}
+	user.Email = "robert@example.org"
+	if err := db.UserRepository().Update(user); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	if labels := search("bill"); len(labels) != 0 {
+		t.Errorf("Expected the old name unindexed, got %v", labels)
+	}
+	if labels := search("invoic"); len(labels) != 1 || labels[0] != "invoicing" {
+		t.Errorf("Expected the new name indexed, got %v", labels)
+	}
+	if labels := search("robert@"); len(labels) != 1 || labels[0] != "bob" {
+		t.Errorf("Expected the new email indexed, got %v", labels)
+	}
+	var entries int
+	if err := db.Get(&entries, "SELECT COUNT(*) FROM search_index"); err != nil || entries != 2 {
+		t.Errorf("Expected one entry per resource, got %d, %v", entries, err)
+	}
+
+	if err := db.ServiceRepository().Delete(service.ID); err != nil {
+		t.Fatalf("Failed to delete service: %v", err)
+	}
+	if err := db.UserRepository().Delete(user.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if err := db.Get(&entries, "SELECT COUNT(*) FROM search_index"); err != nil || entries != 0 {
+		t.Errorf("Expected deleted resources unindexed, got %d, %v", entries, err)
+	}
+}
+
+func TestSearchAccess(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	orgs := createTestOrgs(t, db, "acme", "globex")
+
+	user := &User{Username: "carol", Email: "carol@example.com", PasswordHash: "hash", Role: "user"}
+	if err := db.UserRepository().Create(user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	services := map[string]*RegisteredService{}
+	for _, service := range []*RegisteredService{
+		{Name: "team-public", DisplayName: "Team Public", IsPublic: true, RequiredRole: "admin", Status: "active"},
+		{Name: "team-granted", DisplayName: "Team Granted", RequiredRole: "user", Status: "active"},
+		{Name: "team-admin", DisplayName: "Team Admin", RequiredRole: "admin", Status: "active"},
+		{Name: "team-private", DisplayName: "Team Private", RequiredRole: "user", Status: "active"},
+		{Name: "team-inactive", DisplayName: "Team Inactive", IsPublic: true, RequiredRole: "user", Status: "inactive"},
+	} {
+		service.ServiceURL = "http://localhost:9000"
+		service.Category = "web"
+		if err := orgs["acme"].RegisteredServiceRepository().Create(service); err != nil {
+			t.Fatalf("Failed to create registered service: %v", err)
+		}
+		services[service.Name] = service
+	}
+	for _, name := range []string{"team-granted", "team-admin"} {
+		if err := db.UserServicePermissionRepository().Grant(user.ID, services[name].ID, user.ID, nil); err != nil {
+			t.Fatalf("Failed to grant access: %v", err)
+		}
+	}
+
+	hasRole := func(requiredRole string) bool { return requiredRole != "admin" }
+	results, err := orgs["acme"].SearchRepository().Search(SearchOptions{Query: "team", UserID: user.ID, HasRole: hasRole})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	labels := searchLabels(results[SearchTypeSSOServices])
+	sort.Strings(labels)
+	if len(labels) != 2 || labels[0] != "Team Granted" || labels[1] != "Team Public" {
+		t.Errorf("Expected only the services the user can access, got %v", labels)
+	}
+
+	results, err = orgs["acme"].SearchRepository().Search(SearchOptions{Query: "team", Types: []string{SearchTypeSSOServices}})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if labels := searchLabels(results[SearchTypeSSOServices]); len(labels) != 5 {
+		t.Errorf("Expected every service without a user, got %v", labels)
+	}
+
+	// Another organization's resources are not found
+	results, err = orgs["globex"].SearchRepository().Search(SearchOptions{Query: "team", Types: []string{SearchTypeSSOServices}})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if labels := searchLabels(results[SearchTypeSSOServices]); len(labels) != 0 {
+		t.Errorf("Expected no services of another organization, got %v", labels)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		text, query, expected string
+		found                 bool
+	}{
+		{"Web <App>", "web", "<mark>Web</mark> &lt;App&gt;", true},
+		{"abcabc", "BC", "a<mark>bc</mark>a<mark>bc</mark>", true},
+		{"a&b", "xyz", "a&amp;b", false},
+	}
+	for _, test := range tests {
+		snippet, found := highlight(test.text, test.query)
+		if snippet != test.expected || found != test.found {
+			t.Errorf("highlight(%q, %q) = %q, %v; expected %q, %v", test.text, test.query, snippet, found, test.expected, test.found)
+		}
+	}
+}
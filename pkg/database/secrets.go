@@ -0,0 +1,245 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// defaultKeyFile is the name of the generated master key, kept beside the
+// console database unless secrets.key_file says otherwise
+const defaultKeyFile = "secrets.key"
+
+var (
+	// ErrSecretNotFound is returned when a secret does not exist
+	ErrSecretNotFound = fmt.Errorf("secret %w", ErrNotFound)
+
+	// ErrNoMasterKey is returned when secret values are read or written
+	// without a master key
+	ErrNoMasterKey = errors.New("no secrets master key")
+
+	// ErrSecretUnreadable is returned when a stored value does not open with
+	// the master key, usually because the key was replaced
+	ErrSecretUnreadable = errors.New("secret cannot be decrypted with the master key")
+)
+
+// MasterKeyFile returns the file holding the secrets master key, or "" when
+// the console database is in memory and no file is configured
+func MasterKeyFile(cfg *config.Config) string {
+	if cfg.Secrets.KeyFile != "" {
+		return cfg.Secrets.KeyFile
+	}
+	path := cfg.Console.Database.Path
+	if path == "" || path == ":memory:" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(path), defaultKeyFile)
+}
+
+// LoadMasterKey returns the secrets master key: secrets.master_key when set,
+// otherwise the key in MasterKeyFile. When the file is missing a key is
+// generated into it with mode 0600 if generate is set, and ErrNoMasterKey is
+// returned otherwise. Without a file the generated key is not kept.
+func LoadMasterKey(cfg *config.Config, generate bool) ([]byte, error) {
+	if cfg.Secrets.MasterKey != "" {
+		return config.ParseMasterKey(cfg.Secrets.MasterKey)
+	}
+
+	path := MasterKeyFile(cfg)
+	if path == "" {
+		if !generate {
+			return nil, ErrNoMasterKey
+		}
+		return newMasterKey()
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := config.ParseMasterKey(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key %s: %w", path, err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read master key: %w", err)
+	}
+	if !generate {
+		return nil, fmt.Errorf("%w: %s does not exist", ErrNoMasterKey, path)
+	}
+
+	key, err := newMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create master key directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		// Another daemon generated it first
+		return LoadMasterKey(cfg, false)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create master key: %w", err)
+	}
+	_, err = file.WriteString(base64.StdEncoding.EncodeToString(key) + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write master key: %w", err)
+	}
+	log.Printf("🔑 Generated secrets master key %s", path)
+	return key, nil
+}
+
+// newMasterKey returns a random master key
+func newMasterKey() ([]byte, error) {
+	key := make([]byte, config.MasterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate master key: %w", err)
+	}
+	return key, nil
+}
+
+// SecretRepository provides database operations for secrets. Values are
+// sealed with AES-256-GCM under the master key, with the secret's name as
+// additional data so a value cannot be moved to another secret.
+type SecretRepository struct {
+	db  *DB
+	key []byte
+}
+
+// NewSecretRepository creates a new secret repository sealing values with
+// key. Without a key secrets can still be listed and deleted, but reading
+// and writing values fails with ErrNoMasterKey.
+func NewSecretRepository(db *DB, key []byte) *SecretRepository {
+	return &SecretRepository{db: db, key: key}
+}
+
+// aead returns the cipher sealing values
+func (r *SecretRepository) aead() (cipher.AEAD, error) {
+	return masterKeyAEAD(r.key)
+}
+
+// masterKeyAEAD returns the cipher sealing values under the master key
+func masterKeyAEAD(key []byte) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, ErrNoMasterKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Set stores a secret and its value. An existing secret of the same name
+// gets the new value and description and keeps its creator.
+func (r *SecretRepository) Set(secret *Secret, value string) error {
+	aead, err := r.aead()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(secret.Name))
+
+	now := formatTimestamp(time.Now())
+	query := `
+		INSERT INTO secrets (name, value, description, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			value = excluded.value,
+			description = excluded.description,
+			updated_at = excluded.updated_at
+		RETURNING created_by, created_at, updated_at
+	`
+	err = r.db.QueryRowx(query, secret.Name, sealed, secret.Description, secret.CreatedBy, now, now).
+		Scan(&secret.CreatedBy, &secret.CreatedAt, &secret.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save secret: %w", err)
+	}
+	return nil
+}
+
+// Get returns the value of a secret
+func (r *SecretRepository) Get(name string) (string, error) {
+	aead, err := r.aead()
+	if err != nil {
+		return "", err
+	}
+
+	var sealed []byte
+	err = r.db.Get(&sealed, "SELECT value FROM secrets WHERE name = ?", name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrSecretNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret: %w", notFound(err))
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("%w: %s", ErrSecretUnreadable, name)
+	}
+	value, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrSecretUnreadable, name)
+	}
+	return string(value), nil
+}
+
+// GetMetadata returns a secret without its value
+func (r *SecretRepository) GetMetadata(name string) (*Secret, error) {
+	var secret Secret
+	err := r.db.Get(&secret, "SELECT name, description, created_by, created_at, updated_at FROM secrets WHERE name = ?", name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSecretNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret: %w", notFound(err))
+	}
+	return &secret, nil
+}
+
+// List lists all secrets by name, without their values
+func (r *SecretRepository) List() ([]*Secret, error) {
+	secrets := []*Secret{}
+	err := r.db.Select(&secrets, "SELECT name, description, created_by, created_at, updated_at FROM secrets ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	return secrets, nil
+}
+
+// Delete deletes a secret
+func (r *SecretRepository) Delete(name string) error {
+	result, err := r.db.Exec("DELETE FROM secrets WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+	if rows == 0 {
+		return ErrSecretNotFound
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// createHealthTestServices creates registered services with interleaved
+// health checks: web was healthy and is now failing, api failed and is now
+// healthy, and docs was never checked
+func createHealthTestServices(t *testing.T, db *DB) time.Time {
+	services := db.RegisteredServiceRepository()
+	for _, name := range []string{"web", "api", "docs"} {
+		service := &RegisteredService{ID: name, Name: name, DisplayName: name, ServiceURL: "http://" + name, Category: "tools", RequiredRole: "user", Status: RegisteredServiceActive}
+		if err := services.Create(service); err != nil {
+			t.Fatalf("Failed to create registered service: %v", err)
+		}
+	}
+
+	base := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	checks := db.ServiceHealthCheckRepository()
+	for i, check := range []struct {
+		serviceID string
+		healthy   bool
+	}{
+		{"web", true}, {"api", false}, {"web", true}, {"api", false}, {"web", false}, {"api", true},
+	} {
+		message := "connection refused"
+		record := &ServiceHealthCheck{ServiceID: check.serviceID, IsHealthy: check.healthy, ResponseTime: 10 * (i + 1), CheckedAt: base.Add(time.Duration(i) * time.Minute)}
+		if !check.healthy {
+			record.ErrorMessage = &message
+		}
+		if err := checks.Record(record); err != nil {
+			t.Fatalf("Failed to record health check: %v", err)
+		}
+	}
+	return base
+}
+
+func TestServiceHealthBackfill(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	base := createHealthTestServices(t, db)
+
+	// Before the migration, the latest result was unknown and services last
+	// checked unhealthy had their last healthy time cleared
+	if _, err := db.Exec("UPDATE registered_services SET is_healthy = NULL, last_healthy = NULL"); err != nil {
+		t.Fatalf("Failed to reset service health: %v", err)
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if err := addServiceIsHealthyColumns(tx); err != nil {
+		tx.Rollback()
+		t.Fatalf("Failed to backfill service health: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit backfill: %v", err)
+	}
+
+	tests := []struct {
+		id          string
+		healthy     *bool
+		lastHealthy *time.Time
+	}{
+		{"web", boolPtr(false), timePtr(base.Add(2 * time.Minute))},
+		{"api", boolPtr(true), timePtr(base.Add(5 * time.Minute))},
+		{"docs", nil, nil},
+	}
+	for _, test := range tests {
+		service, err := db.RegisteredServiceRepository().GetByID(test.id)
+		if err != nil {
+			t.Fatalf("Failed to get registered service: %v", err)
+		}
+		if (service.IsHealthy == nil) != (test.healthy == nil) || (test.healthy != nil && *service.IsHealthy != *test.healthy) {
+			t.Errorf("Expected %s healthy %v, got %v", test.id, test.healthy, service.IsHealthy)
+		}
+		if (service.LastHealthy == nil) != (test.lastHealthy == nil) || (test.lastHealthy != nil && !service.LastHealthy.Equal(*test.lastHealthy)) {
+			t.Errorf("Expected %s last healthy at %v, got %v", test.id, test.lastHealthy, service.LastHealthy)
+		}
+	}
+}
+
+func TestServiceHealthSummary(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	base := createHealthTestServices(t, db)
+
+	summaries, err := db.RegisteredServiceRepository().GetHealthSummary()
+	if err != nil {
+		t.Fatalf("Failed to get health summary: %v", err)
+	}
+	if len(summaries) != 3 {
+		t.Fatalf("Expected 3 services, got %d", len(summaries))
+	}
+	byID := make(map[string]*ServiceHealthSummary)
+	for _, summary := range summaries {
+		byID[summary.ServiceID] = summary
+	}
+
+	web := byID["web"]
+	if web.IsHealthy == nil || *web.IsHealthy || web.ResponseTime == nil || *web.ResponseTime != 50 ||
+		web.ErrorMessage == nil || web.CheckedAt == nil || !web.CheckedAt.Equal(base.Add(4*time.Minute)) {
+		t.Errorf("Expected the latest check of web to be the failed one, got %+v", web)
+	}
+	api := byID["api"]
+	if api.IsHealthy == nil || !*api.IsHealthy || api.ResponseTime == nil || *api.ResponseTime != 60 || api.ErrorMessage != nil {
+		t.Errorf("Expected the latest check of api to be the healthy one, got %+v", api)
+	}
+	if docs := byID["docs"]; docs.CheckID != nil || docs.IsHealthy != nil || docs.LatestCheck() != nil {
+		t.Errorf("Expected docs to have no check, got %+v", docs)
+	}
+
+	// A single service's summary matches
+	summary, err := db.RegisteredServiceRepository().GetServiceHealthSummary("web")
+	if err != nil {
+		t.Fatalf("Failed to get service health summary: %v", err)
+	}
+	check := summary.LatestCheck()
+	if check == nil || check.IsHealthy || check.ResponseTime != 50 || !check.CheckedAt.Equal(base.Add(4*time.Minute)) {
+		t.Errorf("Unexpected latest check %+v", check)
+	}
+	if _, err := db.RegisteredServiceRepository().GetServiceHealthSummary("missing"); err == nil {
+		t.Error("Expected an error for a missing service")
+	}
+}
+
+func TestListUnhealthyServices(t *testing.T) {
+	db := createTestDB(t)
+	defer db.Close()
+	repo := db.RegisteredServiceRepository()
+	createHealthTestServices(t, db)
+
+	// The health checker records each check's result on the service
+	for _, update := range []struct {
+		id      string
+		healthy bool
+	}{
+		{"web", true}, {"web", false}, {"web", false}, {"api", false}, {"api", true},
+	} {
+		if _, err := repo.UpdateHealthStatus(update.id, update.healthy); err != nil {
+			t.Fatalf("Failed to update health status: %v", err)
+		}
+	}
+
+	unhealthy, err := repo.ListUnhealthy()
+	if err != nil {
+		t.Fatalf("Failed to list unhealthy services: %v", err)
+	}
+	if len(unhealthy) != 1 || unhealthy[0].ID != "web" || unhealthy[0].FailureStreak != 2 {
+		t.Fatalf("Expected only web to be unhealthy, got %+v", unhealthy)
+	}
+	// Failing keeps the time the service was last healthy
+	if unhealthy[0].LastHealthy == nil {
+		t.Error("Expected the last healthy time to be kept")
+	}
+
+	stats, err := db.GetStats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats["unhealthy_registered_services_count"] != 1 {
+		t.Errorf("Expected 1 unhealthy service in stats, got %v", stats["unhealthy_registered_services_count"])
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
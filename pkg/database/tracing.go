@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/last-emo-boy/infra-core/pkg/tracing"
+)
+
+// The query methods below shadow those of the embedded sqlx.DB, so that every
+// repository statement runs with the DB's context on the connection chosen by
+// queryer, is retried while the database is busy and, when tracing is on,
+// gets a span named after the statement. Transactions are not traced. When
+// query stats are kept each statement is timed, retries included.
+
+// WithContext returns a DB whose statements run with ctx, so that they are
+// cancelled with it and traced as children of its span. The copy shares the
+// connection pool.
+func (db *DB) WithContext(ctx context.Context) *DB {
+	bound := *db
+	bound.ctx = ctx
+	return &bound
+}
+
+// context returns the context statements run with
+func (db *DB) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+// startSpan starts the span of a statement, returning a nil span when
+// tracing is off. Statements outside a traced request, such as those of the
+// health checker's loop, are not traced either.
+func (db *DB) startSpan(query string) (context.Context, trace.Span) {
+	ctx := db.context()
+	if !tracing.Enabled() || !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, nil
+	}
+	return tracing.Start(ctx, tracing.StatementName(query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "sqlite"),
+			attribute.String("db.statement", query),
+		),
+	)
+}
+
+// endSpan ends a statement's span, recording its error
+func endSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		tracing.RecordError(span, err)
+	}
+	span.End()
+}
+
+// Get runs a query expected to return one row and scans it into dest
+func (db *DB) Get(dest interface{}, query string, args ...interface{}) error {
+	ctx, span := db.startSpan(query)
+	start := db.queries.begin()
+	err := db.retry(func() error {
+		return sqlx.GetContext(ctx, db.queryer(query), dest, query, args...)
+	})
+	endSpan(span, err)
+	if db.queries != nil {
+		var rows int64
+		if err == nil {
+			rows = 1
+		}
+		db.queries.observe(query, len(args), start, rows, err)
+	}
+	return err
+}
+
+// Select runs a query and scans every row into dest
+func (db *DB) Select(dest interface{}, query string, args ...interface{}) error {
+	ctx, span := db.startSpan(query)
+	start := db.queries.begin()
+	err := db.retry(func() error {
+		return sqlx.SelectContext(ctx, db.queryer(query), dest, query, args...)
+	})
+	endSpan(span, err)
+	if db.queries != nil {
+		db.queries.observe(query, len(args), start, rowsScanned(dest), err)
+	}
+	return err
+}
+
+// Exec runs a statement that returns no rows
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := db.startSpan(query)
+	start := db.queries.begin()
+	var result sql.Result
+	err := db.retry(func() (err error) {
+		result, err = db.queryer(query).ExecContext(ctx, query, args...)
+		return err
+	})
+	endSpan(span, err)
+	if db.queries != nil {
+		db.queries.observe(query, len(args), start, rowsAffected(result), err)
+	}
+	return result, err
+}
+
+// NamedExec runs a statement with named parameters taken from arg
+func (db *DB) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	ctx, span := db.startSpan(query)
+	start := db.queries.begin()
+	var result sql.Result
+	err := db.retry(func() (err error) {
+		result, err = sqlx.NamedExecContext(ctx, db.queryer(query), query, arg)
+		return err
+	})
+	endSpan(span, err)
+	if db.queries != nil {
+		db.queries.observe(query, 1, start, rowsAffected(result), err)
+	}
+	return result, err
+}
+
+// Query runs a query returning rows. Its span covers running the query, not
+// reading the rows.
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := db.startSpan(query)
+	start := db.queries.begin()
+	var rows *sql.Rows
+	err := db.retry(func() (err error) {
+		rows, err = db.queryer(query).QueryContext(ctx, query, args...)
+		return err
+	})
+	endSpan(span, err)
+	db.queries.observe(query, len(args), start, 0, err)
+	return rows, err
+}
+
+// Queryx is Query returning sqlx rows
+func (db *DB) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	ctx, span := db.startSpan(query)
+	start := db.queries.begin()
+	var rows *sqlx.Rows
+	err := db.retry(func() (err error) {
+		rows, err = db.queryer(query).QueryxContext(ctx, query, args...)
+		return err
+	})
+	endSpan(span, err)
+	db.queries.observe(query, len(args), start, 0, err)
+	return rows, err
+}
+
+// QueryRow runs a query expected to return at most one row
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	ctx, span := db.startSpan(query)
+	start := db.queries.begin()
+	var row *sql.Row
+	err := db.retry(func() error {
+		row = db.queryer(query).QueryRowContext(ctx, query, args...)
+		return row.Err()
+	})
+	endSpan(span, err)
+	db.queries.observe(query, len(args), start, 0, err)
+	return row
+}
+
+// QueryRowx is QueryRow returning an sqlx row
+func (db *DB) QueryRowx(query string, args ...interface{}) *sqlx.Row {
+	ctx, span := db.startSpan(query)
+	start := db.queries.begin()
+	var row *sqlx.Row
+	err := db.retry(func() error {
+		row = db.queryer(query).QueryRowxContext(ctx, query, args...)
+		return row.Err()
+	})
+	endSpan(span, err)
+	db.queries.observe(query, len(args), start, 0, err)
+	return row
+}
@@ -0,0 +1,86 @@
+// Package diff compares two versions of a resource field by field, so that
+// previews of a change, the audit log and the UI describe it the same way.
+package diff
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// Change is one field that differs between two versions of a resource.
+// Fields of nested objects are joined with dots, such as env.LOG_LEVEL.
+// From is nil for a field that was added and To for one that was removed.
+type Change struct {
+	Field    string      `json:"field"`
+	From     interface{} `json:"from"`
+	To       interface{} `json:"to"`
+	Redeploy bool        `json:"redeploy"` // applying the change replaces the running instances
+}
+
+// Compare returns the changes from before to after, sorted by field. Both
+// are compared as their JSON encoding: objects key by key, other values,
+// arrays included, as a whole. A nil version has no fields.
+func Compare(before, after interface{}) []Change {
+	changes := []Change{}
+	compareValues("", snapshot(before), snapshot(after), &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// Redeploys reports whether applying any of the changes replaces the
+// running instances
+func Redeploys(changes []Change) bool {
+	for _, change := range changes {
+		if change.Redeploy {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot returns the JSON form of a value: maps for objects, float64 for
+// numbers, and so on
+func snapshot(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// compareValues appends the changes between two JSON values at a field
+func compareValues(field string, before, after interface{}, changes *[]Change) {
+	beforeObject, beforeIsObject := before.(map[string]interface{})
+	afterObject, afterIsObject := after.(map[string]interface{})
+	if (beforeIsObject || before == nil) && (afterIsObject || after == nil) && (beforeIsObject || afterIsObject) {
+		for key, value := range beforeObject {
+			compareValues(join(field, key), value, afterObject[key], changes)
+		}
+		for key, value := range afterObject {
+			if _, ok := beforeObject[key]; !ok {
+				compareValues(join(field, key), nil, value, changes)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		*changes = append(*changes, Change{Field: field, From: before, To: after})
+	}
+}
+
+// join returns the field of a key of an object
+func join(field, key string) string {
+	if field == "" {
+		return key
+	}
+	return field + "." + key
+}
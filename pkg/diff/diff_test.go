@@ -0,0 +1,67 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type resource struct {
+	Image   string            `json:"image"`
+	Port    int               `json:"port,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Command []string          `json:"command,omitempty"`
+}
+
+func TestCompareEnvironment(t *testing.T) {
+	before := resource{Image: "api:1", Env: map[string]string{"MODE": "live", "DEBUG": "1", "REGION": "eu"}}
+	after := resource{Image: "api:1", Env: map[string]string{"MODE": "test", "REGION": "eu", "TRACE": "on"}}
+
+	assert.Equal(t, []Change{
+		{Field: "env.DEBUG", From: "1", To: nil},
+		{Field: "env.MODE", From: "live", To: "test"},
+		{Field: "env.TRACE", From: nil, To: "on"},
+	}, Compare(before, after))
+
+	// A map set for the first time or cleared is compared key by key too
+	assert.Equal(t, []Change{
+		{Field: "env.DEBUG", From: nil, To: "1"},
+		{Field: "env.MODE", From: nil, To: "live"},
+		{Field: "env.REGION", From: nil, To: "eu"},
+	}, Compare(resource{Image: "api:1"}, before))
+	assert.Equal(t, []Change{{Field: "env.MODE", From: "test", To: nil}},
+		Compare(resource{Env: map[string]string{"MODE": "test"}}, resource{Env: map[string]string{}}))
+}
+
+func TestCompare(t *testing.T) {
+	before := resource{Image: "api:1", Port: 8080, Command: []string{"serve", "--fast"}}
+
+	assert.Empty(t, Compare(before, before))
+	assert.NotNil(t, Compare(before, before), "no changes are an empty list")
+
+	// Arrays are compared as a whole, numbers as JSON numbers
+	assert.Equal(t, []Change{
+		{Field: "command", From: []interface{}{"serve", "--fast"}, To: []interface{}{"serve"}},
+		{Field: "image", From: "api:1", To: "api:2"},
+		{Field: "port", From: float64(8080), To: nil},
+	}, Compare(before, resource{Image: "api:2", Command: []string{"serve"}}))
+
+	// A missing version has no fields
+	assert.Equal(t, []Change{
+		{Field: "command", From: nil, To: []interface{}{"serve", "--fast"}},
+		{Field: "image", From: nil, To: "api:1"},
+		{Field: "port", From: nil, To: float64(8080)},
+	}, Compare(nil, before))
+
+	// Nested objects are joined with dots
+	assert.Equal(t, []Change{{Field: "health.check.path", From: "/", To: "/health"}}, Compare(
+		map[string]interface{}{"health": map[string]interface{}{"check": map[string]interface{}{"path": "/"}}},
+		map[string]interface{}{"health": map[string]interface{}{"check": map[string]interface{}{"path": "/health"}}},
+	))
+}
+
+func TestRedeploys(t *testing.T) {
+	assert.False(t, Redeploys(nil))
+	assert.False(t, Redeploys([]Change{{Field: "replicas"}}))
+	assert.True(t, Redeploys([]Change{{Field: "replicas"}, {Field: "image", Redeploy: true}}))
+}
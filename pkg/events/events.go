@@ -0,0 +1,317 @@
+// Package events is an in-memory bus of the events the UI follows in real
+// time, such as services changing status, deployments progressing and
+// alerts firing. Each daemon publishes to a bus of its own and streams it as
+// server-sent events; the console relays the daemons' streams into its bus.
+//
+// Publishing never blocks: subscribers get events through a buffered
+// channel, and those that fall behind lose events, which are counted. The
+// latest events are kept so that a client reconnecting with the ID of the
+// last event it saw gets those it missed.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	ServiceStatusChanged = "service.status_changed"
+	ResourceExceeded     = "service.resource_exceeded"
+	DeploymentProgress   = "deployment.progress"
+	AlertCreated         = "alert.created"
+	AlertResolved        = "alert.resolved"
+	SnapshotCompleted    = "snapshot.completed"
+	StorageWarning       = "snapshot.storage_warning"
+	CertificateRenewed   = "certificate.renewed"
+)
+
+// Types lists every event type
+var Types = []string{
+	ServiceStatusChanged,
+	ResourceExceeded,
+	DeploymentProgress,
+	AlertCreated,
+	AlertResolved,
+	SnapshotCompleted,
+	StorageWarning,
+	CertificateRenewed,
+}
+
+const (
+	// DefaultHistory is how many of the latest events a bus keeps for
+	// clients that reconnect
+	DefaultHistory = 1000
+
+	// DefaultBuffer is how many events a subscriber may fall behind before
+	// it loses them
+	DefaultBuffer = 64
+)
+
+// Event is something that happened, numbered in the order its bus got it
+type Event struct {
+	ID     uint64          `json:"id"`
+	Type   string          `json:"type"`
+	Source string          `json:"source"` // the daemon that published it, such as "orchestrator"
+	Time   time.Time       `json:"time"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// ServiceStatus is the data of service.status_changed events, published for
+// orchestrator instances and for services the console checks the health of
+type ServiceStatus struct {
+	ServiceID string `json:"service_id"`
+	Service   string `json:"service"`
+	Instance  string `json:"instance,omitempty"` // the orchestrator instance that changed
+	OldStatus string `json:"old_status"`
+	NewStatus string `json:"new_status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Resource is the data of service.resource_exceeded events, published when
+// an orchestrator instance stays over its memory requirement, with the
+// action the orchestrator took: alert or restart
+type Resource struct {
+	ServiceID string `json:"service_id"`
+	Service   string `json:"service"`
+	Instance  string `json:"instance"`
+	Resource  string `json:"resource"` // memory
+	Usage     int64  `json:"usage"`
+	Limit     int64  `json:"limit"`
+	Action    string `json:"action"`
+	Message   string `json:"message"`
+}
+
+// Deployment is the data of deployment.progress events, published when a
+// rollout advances and when it finishes
+type Deployment struct {
+	DeploymentID string `json:"deployment_id"`
+	Service      string `json:"service"`
+	Status       string `json:"status"`
+	Phase        string `json:"phase,omitempty"`
+	Updated      int    `json:"updated"`
+	Total        int    `json:"total"`
+	Message      string `json:"message,omitempty"`
+}
+
+// Alert is the data of alert.created and alert.resolved events
+type Alert struct {
+	AlertID  string `json:"alert_id"`
+	ProbeID  string `json:"probe_id"`
+	Probe    string `json:"probe,omitempty"`
+	Type     string `json:"type"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Snapshot is the data of snapshot.completed events, published when a
+// snapshot finishes whether it succeeded or not
+type Snapshot struct {
+	SnapshotID string `json:"snapshot_id"`
+	PlanID     string `json:"plan_id,omitempty"`
+	Status     string `json:"status"` // completed or failed
+	Files      int    `json:"files"`
+	Size       int64  `json:"size"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Storage is the data of snapshot.storage_warning events, published when
+// the snapshot repository reaches 80% and 95% of its quota or its disk
+type Storage struct {
+	Level         string  `json:"level"` // warning or critical
+	UsagePercent  float64 `json:"usage_percent"`
+	RepoBytes     int64   `json:"repo_bytes"`
+	QuotaBytes    int64   `json:"quota_bytes,omitempty"`
+	FreeDiskBytes int64   `json:"free_disk_bytes"`
+	Message       string  `json:"message"`
+}
+
+// Certificate is the data of certificate.renewed events
+type Certificate struct {
+	CertificateID string    `json:"certificate_id"`
+	Domain        string    `json:"domain"`
+	NotAfter      time.Time `json:"not_after"`
+}
+
+// Query selects the events a client streams: those of Types, every type
+// when empty, starting with the kept events after LastEventID, if not zero
+type Query struct {
+	Types       []string
+	LastEventID uint64
+}
+
+// Stats counts the events of a bus
+type Stats struct {
+	Published   uint64 `json:"published"`
+	Dropped     uint64 `json:"dropped"` // events subscribers lost by falling behind
+	Subscribers int    `json:"subscribers"`
+}
+
+// Bus delivers events to its subscribers and keeps the latest ones. A nil
+// bus drops what is published to it, so publishers need not check for one.
+type Bus struct {
+	source      string
+	history     []Event // ring of the latest events, oldest at start
+	start       int
+	lastID      uint64
+	dropped     uint64
+	subscribers map[*Subscription]struct{}
+	mutex       sync.Mutex
+}
+
+// NewBus creates a bus of the events source publishes, keeping the latest
+// history of them, DefaultHistory when not positive
+func NewBus(source string, history int) *Bus {
+	if history <= 0 {
+		history = DefaultHistory
+	}
+	return &Bus{
+		source:      source,
+		history:     make([]Event, 0, history),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends an event of a type with data, encoded as JSON, to the
+// subscribers
+func (b *Bus) Publish(eventType string, data interface{}) {
+	if b == nil {
+		return
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("❌ Failed to encode %s event: %v", eventType, err)
+		return
+	}
+	b.add(Event{Type: eventType, Source: b.source, Time: time.Now(), Data: payload})
+}
+
+// Forward sends an event another bus published to the subscribers, keeping
+// its source and time but numbering it in this bus
+func (b *Bus) Forward(event Event) {
+	if b == nil {
+		return
+	}
+	event.ID = 0
+	if event.Source == "" {
+		event.Source = b.source
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.add(event)
+}
+
+// add numbers an event, keeps it and offers it to every subscriber of its
+// type, counting those whose buffer is full
+func (b *Bus) add(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lastID++
+	event.ID = b.lastID
+	if len(b.history) < cap(b.history) {
+		b.history = append(b.history, event)
+	} else {
+		b.history[b.start] = event
+		b.start = (b.start + 1) % len(b.history)
+	}
+
+	for subscription := range b.subscribers {
+		if !subscription.wants(event.Type) {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			subscription.dropped++
+			b.dropped++
+		}
+	}
+}
+
+// Subscribe subscribes to the events of types, every one when empty, with a
+// buffer of that many events, DefaultBuffer when not positive. The kept
+// events after lastID are returned for replay, ahead of those the
+// subscription gets; all kept events are when lastID is ahead of the bus,
+// which then restarted since the client saw it.
+func (b *Bus) Subscribe(types []string, lastID uint64, buffer int) (*Subscription, []Event) {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	subscription := &Subscription{bus: b, events: make(chan Event, buffer)}
+	if len(types) > 0 {
+		subscription.types = make(map[string]bool, len(types))
+		for _, eventType := range types {
+			subscription.types[eventType] = true
+		}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	restarted := lastID > b.lastID
+	var replay []Event
+	if lastID > 0 {
+		for i := range b.history {
+			event := b.history[(b.start+i)%len(b.history)]
+			if (restarted || event.ID > lastID) && subscription.wants(event.Type) {
+				replay = append(replay, event)
+			}
+		}
+	}
+	b.subscribers[subscription] = struct{}{}
+	return subscription, replay
+}
+
+// Stats returns the counts of the bus
+func (b *Bus) Stats() Stats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return Stats{Published: b.lastID, Dropped: b.dropped, Subscribers: len(b.subscribers)}
+}
+
+// Subscription receives the events of a bus until closed
+type Subscription struct {
+	bus     *Bus
+	types   map[string]bool // nil for every type
+	events  chan Event
+	dropped uint64 // guarded by the bus's mutex
+}
+
+// Events returns the channel the subscription's events arrive on
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns how many events the subscription lost by falling behind
+func (s *Subscription) Dropped() uint64 {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+	return s.dropped
+}
+
+// Close stops the subscription. Events already buffered stay readable.
+func (s *Subscription) Close() {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+	delete(s.bus.subscribers, s)
+}
+
+func (s *Subscription) wants(eventType string) bool {
+	return s.types == nil || s.types[eventType]
+}
+
+// CheckTypes returns an error for the first type that isn't an event type
+func CheckTypes(types []string) error {
+	for _, eventType := range types {
+		if !slices.Contains(Types, eventType) {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	return nil
+}
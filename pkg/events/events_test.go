@@ -0,0 +1,174 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// errEnough stops reading a stream
+var errEnough = errors.New("enough")
+
+func newTestServer(t *testing.T, bus *Bus) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/events/stream", bus.Stream)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// subscribe connects to the stream of a server with a query and a last
+// event ID, if not empty, and waits until the bus has the subscriber
+func subscribe(t *testing.T, server *httptest.Server, bus *Bus, query, lastEventID string) (*http.Response, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	subscribers := bus.Stats().Subscribers
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events/stream"+query, nil)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	require.Eventually(t, func() bool { return bus.Stats().Subscribers > subscribers }, time.Second, 5*time.Millisecond)
+	return resp, cancel
+}
+
+// readEvents reads count events of a stream
+func readEvents(t *testing.T, resp *http.Response, count int) []Event {
+	var received []Event
+	err := Read(resp.Body, func(event Event) error {
+		received = append(received, event)
+		if len(received) == count {
+			return errEnough
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, errEnough)
+	return received
+}
+
+func TestStreamDeliversEventsInOrder(t *testing.T) {
+	bus := NewBus("console", 0)
+	server := newTestServer(t, bus)
+	all, _ := subscribe(t, server, bus, "", "")
+	alerts, _ := subscribe(t, server, bus, "?types=alert.created,alert.resolved", "")
+
+	bus.Publish(ServiceStatusChanged, ServiceStatus{ServiceID: "svc-1", Service: "web", OldStatus: "starting", NewStatus: "running"})
+	bus.Publish(AlertCreated, Alert{AlertID: "alert-1", Severity: "critical"})
+	bus.Publish(DeploymentProgress, Deployment{DeploymentID: "deploy-1", Status: "deploying", Updated: 1, Total: 2})
+	bus.Publish(AlertResolved, Alert{AlertID: "alert-1"})
+
+	received := readEvents(t, all, 4)
+	for i, event := range received {
+		assert.Equal(t, uint64(i+1), event.ID)
+		assert.Equal(t, "console", event.Source)
+	}
+	assert.Equal(t, []string{ServiceStatusChanged, AlertCreated, DeploymentProgress, AlertResolved},
+		[]string{received[0].Type, received[1].Type, received[2].Type, received[3].Type})
+	var status ServiceStatus
+	require.NoError(t, json.Unmarshal(received[0].Data, &status))
+	assert.Equal(t, "running", status.NewStatus)
+
+	// Only the selected types are streamed
+	received = readEvents(t, alerts, 2)
+	assert.Equal(t, []uint64{2, 4}, []uint64{received[0].ID, received[1].ID})
+}
+
+func TestStreamReplaysAfterReconnecting(t *testing.T) {
+	bus := NewBus("orchestrator", 0)
+	server := newTestServer(t, bus)
+	resp, disconnect := subscribe(t, server, bus, "", "")
+
+	bus.Publish(DeploymentProgress, Deployment{DeploymentID: "deploy-1", Updated: 0})
+	bus.Publish(DeploymentProgress, Deployment{DeploymentID: "deploy-1", Updated: 1})
+	last := readEvents(t, resp, 2)[1]
+	disconnect()
+	require.Eventually(t, func() bool { return bus.Stats().Subscribers == 0 }, time.Second, 5*time.Millisecond)
+
+	// Events published while disconnected are replayed first
+	bus.Publish(DeploymentProgress, Deployment{DeploymentID: "deploy-1", Updated: 2})
+	bus.Publish(ServiceStatusChanged, ServiceStatus{Service: "web", NewStatus: "running"})
+	resp, _ = subscribe(t, server, bus, "", "2")
+	bus.Publish(DeploymentProgress, Deployment{DeploymentID: "deploy-1", Status: "deployed"})
+
+	received := readEvents(t, resp, 3)
+	assert.Equal(t, uint64(2), last.ID)
+	assert.Equal(t, []uint64{3, 4, 5}, []uint64{received[0].ID, received[1].ID, received[2].ID})
+
+	// A replay is filtered like the stream
+	resp, _ = subscribe(t, server, bus, "?last_event_id=1&types=service.status_changed", "")
+	assert.Equal(t, uint64(4), readEvents(t, resp, 1)[0].ID)
+}
+
+func TestStreamRejectsInvalidRequests(t *testing.T) {
+	bus := NewBus("console", 0)
+	server := newTestServer(t, bus)
+
+	for _, query := range []string{"?types=service.created", "?last_event_id=latest"} {
+		resp, err := http.Get(server.URL + "/events/stream" + query)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	bus := NewBus("snap", 3)
+	for i := 0; i < 5; i++ {
+		bus.Publish(SnapshotCompleted, Snapshot{PlanID: "nightly"})
+	}
+
+	// Only the latest events are kept
+	subscription, replay := bus.Subscribe(nil, 1, 2)
+	require.Len(t, replay, 3)
+	assert.Equal(t, uint64(3), replay[0].ID)
+	assert.Equal(t, uint64(5), replay[2].ID)
+
+	// A bus that restarted since the client's last event replays every kept one
+	_, replay = bus.Subscribe(nil, 40, 0)
+	assert.Len(t, replay, 3)
+	_, replay = bus.Subscribe(nil, 0, 0)
+	assert.Empty(t, replay)
+
+	// A subscriber that falls behind loses events without holding up others
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			bus.Publish(SnapshotCompleted, Snapshot{PlanID: "hourly"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publishing blocked on a slow subscriber")
+	}
+	assert.Equal(t, uint64(8), subscription.Dropped())
+	assert.Len(t, subscription.Events(), 2)
+	assert.Equal(t, uint64(6), (<-subscription.Events()).ID)
+
+	subscription.Close()
+	stats := bus.Stats()
+	assert.Equal(t, uint64(15), stats.Published)
+	assert.Equal(t, uint64(8), stats.Dropped)
+	assert.Equal(t, 2, stats.Subscribers)
+
+	// Publishing to no bus is harmless
+	var none *Bus
+	none.Publish(AlertCreated, Alert{})
+}
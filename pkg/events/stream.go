@@ -0,0 +1,150 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keepaliveInterval is how often an idle stream sends a comment, so that
+// proxies don't close it
+const keepaliveInterval = 15 * time.Second
+
+// droppedEvent names the message telling a client how many events it lost
+// by falling behind. It has no ID, so reconnecting with the last one seen
+// replays those still kept.
+const droppedEvent = "dropped"
+
+// maxEventSize bounds the lines Read accepts
+const maxEventSize = 1 << 20
+
+// Stream streams the events of the bus as server-sent events until the
+// client disconnects. Each event is sent with its ID and type, its data being
+// the whole event as JSON. The types query parameter, a comma-separated
+// list, selects the types to stream; the Last-Event-ID header, or the
+// last_event_id query parameter, replays the kept events after it first.
+func (b *Bus) Stream(c *gin.Context) {
+	var types []string
+	for _, eventType := range strings.Split(c.Query("types"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			types = append(types, eventType)
+		}
+	}
+	if err := CheckTypes(types); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "types": Types})
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid last event ID"})
+			return
+		}
+	}
+
+	subscription, replay := b.Subscribe(types, lastID, DefaultBuffer)
+	defer subscription.Close()
+
+	// Streams outlive the server's write timeout
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for _, event := range replay {
+		if writeEvent(c.Writer, event) != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	var reported uint64
+	for {
+		var err error
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event := <-subscription.Events():
+			err = writeEvent(c.Writer, event)
+		case <-ticker.C:
+			_, err = io.WriteString(c.Writer, ": keepalive\n\n")
+		}
+		if dropped := subscription.Dropped(); err == nil && dropped > reported {
+			_, err = fmt.Fprintf(c.Writer, "event: %s\ndata: {\"dropped\":%d}\n\n", droppedEvent, dropped-reported)
+			reported = dropped
+		}
+		if err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeEvent writes an event as a server-sent event
+func writeEvent(w io.Writer, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// Read reads the events of a stream, calling handle with each one until the
+// stream ends or handle returns an error, which Read then returns. Messages
+// without an ID, such as notices of dropped events, are skipped.
+func Read(r io.Reader, handle func(Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxEventSize)
+
+	var id string
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if id != "" && data.Len() > 0 {
+				var event Event
+				if err := json.Unmarshal([]byte(data.String()), &event); err != nil {
+					return fmt.Errorf("failed to decode event %s: %w", id, err)
+				}
+				if err := handle(event); err != nil {
+					return err
+				}
+			}
+			id = ""
+			data.Reset()
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		}
+	}
+	return scanner.Err()
+}
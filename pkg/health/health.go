@@ -0,0 +1,340 @@
+// Package health serves the liveness, readiness and detailed health
+// endpoints every daemon exposes:
+//
+//   - /health/live answers as long as the process is up
+//   - /health/ready answers 200 once the daemon's background engines have
+//     started and its critical dependencies are reachable, and 503 again as
+//     soon as it starts shutting down
+//   - /health reports the status and latency of every dependency
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// Statuses of a daemon and of each of its checks
+const (
+	StatusHealthy   = "healthy"
+	StatusDegraded  = "degraded"
+	StatusUnhealthy = "unhealthy"
+)
+
+// Readiness statuses
+const (
+	StatusAlive        = "alive"
+	StatusReady        = "ready"
+	StatusStarting     = "starting"
+	StatusNotReady     = "not_ready"
+	StatusShuttingDown = "shutting_down"
+)
+
+// Timeouts of the checks run for a readiness and a health request
+const (
+	readyTimeout  = 2 * time.Second
+	healthTimeout = 5 * time.Second
+)
+
+// Check is a dependency of a daemon. Critical checks must pass for the
+// daemon to be ready; the others only degrade its health.
+type Check struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of running a check
+type CheckResult struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the detailed health of a daemon
+type Report struct {
+	Service   string                  `json:"service"`
+	Status    string                  `json:"status"`
+	Ready     bool                    `json:"ready"`
+	Uptime    string                  `json:"uptime"`
+	Checks    map[string]*CheckResult `json:"checks"`
+	Details   map[string]interface{}  `json:"details,omitempty"`
+	Timestamp string                  `json:"timestamp"`
+}
+
+// degradedError is a check failure that degrades health without making the
+// daemon unhealthy or unready
+type degradedError struct {
+	err error
+}
+
+func (e *degradedError) Error() string { return e.err.Error() }
+
+func (e *degradedError) Unwrap() error { return e.err }
+
+// Degraded marks a check failure as degrading the daemon rather than making
+// it unhealthy, even for a critical check
+func Degraded(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &degradedError{err: err}
+}
+
+// Monitor tracks whether a daemon has finished starting or begun shutting
+// down, and runs its dependency checks
+type Monitor struct {
+	service   string
+	startTime time.Time
+	started   atomic.Bool
+	draining  atomic.Bool
+
+	mu      sync.RWMutex
+	checks  []Check
+	details map[string]func() interface{}
+}
+
+// New creates the monitor of a daemon, not ready until SetStarted is called
+func New(service string) *Monitor {
+	return &Monitor{
+		service:   service,
+		startTime: time.Now(),
+		details:   make(map[string]func() interface{}),
+	}
+}
+
+// AddCheck adds a dependency check
+func (m *Monitor) AddCheck(check Check) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checks = append(m.checks, check)
+}
+
+// AddDetail adds the value returned by fn to detailed health reports
+func (m *Monitor) AddDetail(name string, fn func() interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.details[name] = fn
+}
+
+// SetStarted marks the daemon's background engines as started
+func (m *Monitor) SetStarted() {
+	m.started.Store(true)
+}
+
+// SetDraining marks the daemon as shutting down, failing readiness checks so
+// that traffic is routed elsewhere
+func (m *Monitor) SetDraining() {
+	m.draining.Store(true)
+}
+
+// Readiness returns whether the daemon should receive traffic, with the
+// results of its critical checks when it has started
+func (m *Monitor) Readiness(ctx context.Context) (string, map[string]*CheckResult) {
+	if m.draining.Load() {
+		return StatusShuttingDown, nil
+	}
+	if !m.started.Load() {
+		return StatusStarting, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	results := m.run(ctx, true)
+	for _, result := range results {
+		if result.Status == StatusUnhealthy {
+			return StatusNotReady, results
+		}
+	}
+	return StatusReady, results
+}
+
+// Report runs every check and reports the daemon's health
+func (m *Monitor) Report(ctx context.Context) *Report {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+	results := m.run(ctx, false)
+
+	status := StatusHealthy
+	ready := m.started.Load() && !m.draining.Load()
+	for _, result := range results {
+		switch {
+		case result.Status == StatusUnhealthy && result.Critical:
+			status = StatusUnhealthy
+			ready = false
+		case result.Status != StatusHealthy && status == StatusHealthy:
+			status = StatusDegraded
+		}
+	}
+
+	report := &Report{
+		Service:   m.service,
+		Status:    status,
+		Ready:     ready,
+		Uptime:    time.Since(m.startTime).Round(time.Second).String(),
+		Checks:    results,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.details) > 0 {
+		report.Details = make(map[string]interface{}, len(m.details))
+		for name, fn := range m.details {
+			report.Details[name] = fn()
+		}
+	}
+	return report
+}
+
+// run runs the checks, only the critical ones if criticalOnly, concurrently
+func (m *Monitor) run(ctx context.Context, criticalOnly bool) map[string]*CheckResult {
+	m.mu.RLock()
+	checks := make([]Check, 0, len(m.checks))
+	for _, check := range m.checks {
+		if check.Critical || !criticalOnly {
+			checks = append(checks, check)
+		}
+	}
+	m.mu.RUnlock()
+
+	results := make([]*CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	byName := make(map[string]*CheckResult, len(checks))
+	for i, check := range checks {
+		byName[check.Name] = results[i]
+	}
+	return byName
+}
+
+// runCheck runs a check and times it
+func runCheck(ctx context.Context, check Check) *CheckResult {
+	start := time.Now()
+	err := check.Run(ctx)
+	result := &CheckResult{
+		Status:    StatusHealthy,
+		Critical:  check.Critical,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	var degraded *degradedError
+	switch {
+	case err == nil:
+	case errors.As(err, &degraded):
+		result.Status = StatusDegraded
+		result.Error = err.Error()
+	default:
+		result.Status = StatusUnhealthy
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Live answers as long as the process is up
+func (m *Monitor) Live(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    StatusAlive,
+		"service":   m.service,
+		"uptime":    time.Since(m.startTime).Round(time.Second).String(),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// Ready answers 200 when the daemon should receive traffic and 503 otherwise
+func (m *Monitor) Ready(w http.ResponseWriter, req *http.Request) {
+	status, results := m.Readiness(req.Context())
+
+	code := http.StatusOK
+	if status != StatusReady {
+		code = http.StatusServiceUnavailable
+	}
+	response := map[string]interface{}{
+		"status":    status,
+		"service":   m.service,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+	if results != nil {
+		response["checks"] = results
+	}
+	writeJSON(w, code, response)
+}
+
+// Health answers with the detailed health report, 503 when a critical
+// dependency is down
+func (m *Monitor) Health(w http.ResponseWriter, req *http.Request) {
+	report := m.Report(req.Context())
+
+	code := http.StatusOK
+	if report.Status == StatusUnhealthy {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, report)
+}
+
+// RegisterRoutes registers /health, /health/live and /health/ready on r
+func (m *Monitor) RegisterRoutes(r gin.IRouter) {
+	r.GET("/health", gin.WrapF(m.Health))
+	r.GET("/health/live", gin.WrapF(m.Live))
+	r.GET("/health/ready", gin.WrapF(m.Ready))
+}
+
+// HandleFunc registers /health, /health/live and /health/ready on mux
+func (m *Monitor) HandleFunc(mux *http.ServeMux) {
+	mux.HandleFunc("/health", m.Health)
+	mux.HandleFunc("/health/live", m.Live)
+	mux.HandleFunc("/health/ready", m.Ready)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Database checks that the database answers queries. It is critical: a
+// daemon cannot serve without its database.
+func Database(db *database.DB) Check {
+	return Check{
+		Name:     "database",
+		Critical: true,
+		Run: func(ctx context.Context) error {
+			return db.WithContext(ctx).HealthCheck()
+		},
+	}
+}
+
+// WritableDir checks that files can be created in dir
+func WritableDir(name, dir string) Check {
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			file, err := os.CreateTemp(dir, ".health-*")
+			if err != nil {
+				return fmt.Errorf("directory not writable: %w", err)
+			}
+			file.Close()
+			return os.Remove(file.Name())
+		},
+	}
+}
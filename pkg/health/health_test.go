@@ -0,0 +1,162 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// get serves a request to handler and decodes the JSON response
+func get(t *testing.T, handler http.HandlerFunc) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return w.Code, body
+}
+
+// dependency is a check whose outcome tests set
+type dependency struct {
+	err atomic.Pointer[error]
+}
+
+func (d *dependency) set(err error) {
+	d.err.Store(&err)
+}
+
+func (d *dependency) run(ctx context.Context) error {
+	if err := d.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func TestReadinessTransitions(t *testing.T) {
+	var db dependency
+	monitor := New("test")
+	monitor.AddCheck(Check{Name: "database", Critical: true, Run: db.run})
+
+	// Live as soon as the process is up, ready only once started
+	code, body := get(t, monitor.Live)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusAlive, body["status"])
+	code, body = get(t, monitor.Ready)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusStarting, body["status"])
+
+	monitor.SetStarted()
+	code, body = get(t, monitor.Ready)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusReady, body["status"])
+
+	// A critical dependency going down fails readiness until it recovers
+	db.set(errors.New("database is locked"))
+	code, body = get(t, monitor.Ready)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusNotReady, body["status"])
+	result := body["checks"].(map[string]interface{})["database"].(map[string]interface{})
+	assert.Equal(t, "database is locked", result["error"])
+
+	db.set(nil)
+	code, _ = get(t, monitor.Ready)
+	assert.Equal(t, http.StatusOK, code)
+
+	// Shutting down fails readiness for good while the process stays live
+	monitor.SetDraining()
+	code, body = get(t, monitor.Ready)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusShuttingDown, body["status"])
+	code, _ = get(t, monitor.Live)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestHealthReport(t *testing.T) {
+	var critical, downstream dependency
+	monitor := New("test")
+	monitor.AddCheck(Check{Name: "database", Critical: true, Run: critical.run})
+	monitor.AddCheck(Check{Name: "snap", Run: downstream.run})
+	monitor.AddDetail("engine", func() interface{} { return map[string]bool{"running": true} })
+	monitor.SetStarted()
+
+	code, body := get(t, monitor.Health)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusHealthy, body["status"])
+	assert.Equal(t, true, body["ready"])
+	assert.Equal(t, "test", body["service"])
+	assert.Equal(t, map[string]interface{}{"running": true}, body["details"].(map[string]interface{})["engine"])
+	checks := body["checks"].(map[string]interface{})
+	require.Len(t, checks, 2)
+	assert.Contains(t, checks["database"], "latency_ms")
+
+	// A failing downstream service only degrades health
+	downstream.set(errors.New("connection refused"))
+	code, body = get(t, monitor.Health)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusDegraded, body["status"])
+	assert.Equal(t, true, body["ready"])
+	code, _ = get(t, monitor.Ready)
+	assert.Equal(t, http.StatusOK, code, "readiness only runs critical checks")
+
+	// So does a critical check reporting itself degraded
+	downstream.set(nil)
+	critical.set(Degraded(errors.New("slow")))
+	code, body = get(t, monitor.Health)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusDegraded, body["status"])
+	code, _ = get(t, monitor.Ready)
+	assert.Equal(t, http.StatusOK, code)
+
+	// A failing critical dependency makes the daemon unhealthy
+	critical.set(errors.New("disk I/O error"))
+	code, body = get(t, monitor.Health)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusUnhealthy, body["status"])
+	assert.Equal(t, false, body["ready"])
+
+	// Health keeps reporting dependencies while shutting down
+	critical.set(nil)
+	monitor.SetDraining()
+	code, body = get(t, monitor.Health)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusHealthy, body["status"])
+	assert.Equal(t, false, body["ready"])
+}
+
+func TestDatabaseCheck(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Console.Database.Path = filepath.Join(t.TempDir(), "infra-core.db")
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+
+	check := Database(db)
+	assert.True(t, check.Critical)
+	assert.NoError(t, check.Run(context.Background()))
+
+	require.NoError(t, db.Close())
+	assert.Error(t, check.Run(context.Background()))
+}
+
+func TestWritableDirCheck(t *testing.T) {
+	dir := t.TempDir()
+	check := WritableDir("data_dir", dir)
+	assert.False(t, check.Critical)
+	require.NoError(t, check.Run(context.Background()))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the probe file is removed")
+
+	assert.Error(t, WritableDir("data_dir", filepath.Join(dir, "missing")).Run(context.Background()))
+}
@@ -0,0 +1,170 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// Output formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// RequestIDHeader carries the ID correlating a request across daemons
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs accepted from clients
+const maxRequestIDLength = 128
+
+// ParseLevel parses a configured log level, defaulting to info when unset
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+	}
+}
+
+// Format returns the output format for a component: cfg.Format when set,
+// otherwise JSON in production and text everywhere else
+func Format(cfg config.LogConfig, environment string) string {
+	if cfg.Format != "" {
+		return cfg.Format
+	}
+	if environment == "production" {
+		return FormatJSON
+	}
+	return FormatText
+}
+
+// New returns a logger for a daemon component writing to w. Every entry
+// carries the component name.
+func New(component string, cfg config.LogConfig, environment string, w io.Writer) *slog.Logger {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if Format(cfg, environment) == FormatJSON {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(handler).With("component", component)
+}
+
+// Setup creates a component's logger and makes it the default for both slog
+// and the standard log package. It writes to stderr when cfg.Console is set
+// or no file is configured, and appends to cfg.File when one is. The returned
+// function closes the log file; it is usable even when the file could not be
+// opened and logging fell back to stderr.
+func Setup(component string, cfg config.LogConfig, environment string) (func() error, error) {
+	var writers []io.Writer
+	closeFn := func() error { return nil }
+	var fileErr error
+
+	if cfg.File != "" {
+		file, err := openLogFile(cfg.File)
+		if err != nil {
+			fileErr = err
+		} else {
+			writers = append(writers, file)
+			closeFn = file.Close
+		}
+	}
+	if cfg.Console || len(writers) == 0 {
+		writers = append(writers, os.Stderr)
+	}
+
+	slog.SetDefault(New(component, cfg, environment, io.MultiWriter(writers...)))
+	// The handler adds its own timestamp to entries written with the log package
+	log.SetFlags(0)
+	return closeFn, fileErr
+}
+
+// openLogFile opens a log file for appending, creating its directory
+func openLogFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	return file, nil
+}
+
+type loggerKey struct{}
+
+type requestIDKey struct{}
+
+// WithLogger returns a context carrying a request-scoped logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// NewRequestID generates a request ID
+func NewRequestID() string {
+	return uuid.NewString()
+}
+
+// ValidRequestID reports whether a request ID sent by a client can be passed
+// on as is: printable ASCII without spaces and of reasonable length
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDFrom returns the valid request ID in header, or a new one
+func RequestIDFrom(header string) string {
+	if ValidRequestID(header) {
+		return header
+	}
+	return NewRequestID()
+}
+
+// WithRequestID returns a context carrying a request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
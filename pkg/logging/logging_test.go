@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+func TestNewJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New("probe", config.LogConfig{Level: "warn"}, "production", &buf)
+
+	logger.Info("dropped below the configured level")
+	logger.Warn("probe failed", "probe_id", "p1", "attempt", 3)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "WARN", entry["level"])
+	assert.Equal(t, "probe failed", entry["msg"])
+	assert.Equal(t, "probe", entry["component"])
+	assert.Equal(t, "p1", entry["probe_id"])
+	assert.Equal(t, float64(3), entry["attempt"])
+	assert.NotEmpty(t, entry["time"])
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, FormatJSON, Format(config.LogConfig{}, "production"))
+	assert.Equal(t, FormatText, Format(config.LogConfig{}, "development"))
+	assert.Equal(t, FormatJSON, Format(config.LogConfig{Format: FormatJSON}, "development"))
+	assert.Equal(t, FormatText, Format(config.LogConfig{Format: FormatText}, "production"))
+
+	var buf bytes.Buffer
+	New("gate", config.LogConfig{}, "development", &buf).Info("started")
+	assert.Contains(t, buf.String(), "msg=started component=gate")
+}
+
+func TestParseLevel(t *testing.T) {
+	for input, expected := range map[string]slog.Level{
+		"":      slog.LevelInfo,
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		level, err := ParseLevel(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, level, input)
+	}
+
+	_, err := ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestSetupWritesFile(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	path := filepath.Join(t.TempDir(), "logs", "snap.log")
+	closeLogs, err := Setup("snap", config.LogConfig{Format: FormatJSON, File: path}, "development")
+	require.NoError(t, err)
+
+	slog.Info("snapshot created", "snapshot_id", "s1")
+	require.NoError(t, closeLogs())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, "snap", entry["component"])
+	assert.Equal(t, "s1", entry["snapshot_id"])
+}
+
+func TestRequestIDs(t *testing.T) {
+	assert.Equal(t, "abc-123", RequestIDFrom("abc-123"))
+	assert.NotEmpty(t, RequestIDFrom(""))
+	assert.NotEqual(t, "bad id", RequestIDFrom("bad id"))
+	assert.NotEqual(t, strings.Repeat("a", 200), RequestIDFrom(strings.Repeat("a", 200)))
+
+	ctx := WithRequestID(context.Background(), "abc-123")
+	assert.Equal(t, "abc-123", RequestID(ctx))
+	assert.Empty(t, RequestID(context.Background()))
+
+	var buf bytes.Buffer
+	logger := New("console", config.LogConfig{Format: FormatJSON}, "", &buf)
+	assert.Same(t, logger, FromContext(WithLogger(ctx, logger)))
+	assert.Same(t, slog.Default(), FromContext(ctx))
+}
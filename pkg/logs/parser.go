@@ -0,0 +1,315 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Log formats
+const (
+	FormatPlain = "plain"
+	FormatJSON  = "json"
+)
+
+// Log levels in increasing order of severity
+const (
+	LevelTrace = "trace"
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+	LevelFatal = "fatal"
+)
+
+var levelRank = map[string]int{
+	LevelTrace: 1,
+	LevelDebug: 2,
+	LevelInfo:  3,
+	LevelWarn:  4,
+	LevelError: 5,
+	LevelFatal: 6,
+}
+
+// Config describes how the log output of a service is parsed and filtered
+type Config struct {
+	Format          string `yaml:"format" json:"format"`                     // plain, json
+	TimestampField  string `yaml:"timestamp_field" json:"timestamp_field"`   // JSON field holding the timestamp
+	TimestampFormat string `yaml:"timestamp_format" json:"timestamp_format"` // Go layout, "unix" or "unix_ms"
+	LevelField      string `yaml:"level_field" json:"level_field"`           // JSON field holding the level
+	MessageField    string `yaml:"message_field" json:"message_field"`       // JSON field holding the message
+	MinLevel        string `yaml:"min_level" json:"min_level"`               // drop entries below this level, empty captures everything
+}
+
+// Entry is a single parsed log line
+type Entry struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Level     string                 `json:"level,omitempty"`
+	Message   string                 `json:"message"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Raw       string                 `json:"raw"`
+	Parsed    bool                   `json:"parsed"`
+}
+
+// Stats holds parser counters
+type Stats struct {
+	Lines     int64 `json:"lines"`
+	Parsed    int64 `json:"parsed"`
+	Malformed int64 `json:"malformed"`
+	Dropped   int64 `json:"dropped"`
+}
+
+// Parser turns raw log lines into entries according to a service's log config
+type Parser struct {
+	config    Config
+	minRank   int
+	lines     int64
+	parsed    int64
+	malformed int64
+	dropped   int64
+}
+
+// DefaultConfig returns the config used for services that do not configure logging
+func DefaultConfig() Config {
+	return Config{
+		Format:          FormatPlain,
+		TimestampField:  "time",
+		TimestampFormat: time.RFC3339Nano,
+		LevelField:      "level",
+		MessageField:    "msg",
+	}
+}
+
+// ConfigFromServiceYAML reads the logging section of a service's YAML config,
+// applying defaults for anything left unset
+func ConfigFromServiceYAML(yamlConfig string) (Config, error) {
+	var spec struct {
+		Logging Config `yaml:"logging"`
+	}
+	if strings.TrimSpace(yamlConfig) != "" {
+		if err := yaml.Unmarshal([]byte(yamlConfig), &spec); err != nil {
+			return Config{}, fmt.Errorf("failed to parse service config: %w", err)
+		}
+	}
+
+	cfg := spec.Logging
+	defaults := DefaultConfig()
+	if cfg.Format == "" {
+		cfg.Format = defaults.Format
+	}
+	if cfg.TimestampField == "" {
+		cfg.TimestampField = defaults.TimestampField
+	}
+	if cfg.TimestampFormat == "" {
+		cfg.TimestampFormat = defaults.TimestampFormat
+	}
+	if cfg.LevelField == "" {
+		cfg.LevelField = defaults.LevelField
+	}
+	if cfg.MessageField == "" {
+		cfg.MessageField = defaults.MessageField
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// ApplyToServiceYAML stores the config as the logging section of a service's
+// YAML config, leaving the rest of the document untouched
+func ApplyToServiceYAML(yamlConfig string, cfg Config) (string, error) {
+	if cfg.Format == "" {
+		cfg.Format = FormatPlain
+	}
+	if err := cfg.Validate(); err != nil {
+		return "", err
+	}
+
+	spec := map[string]interface{}{}
+	if strings.TrimSpace(yamlConfig) != "" {
+		if err := yaml.Unmarshal([]byte(yamlConfig), &spec); err != nil {
+			return "", fmt.Errorf("failed to parse service config: %w", err)
+		}
+	}
+	spec["logging"] = cfg
+
+	out, err := yaml.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode service config: %w", err)
+	}
+	return string(out), nil
+}
+
+// Validate checks the config for unsupported values
+func (c Config) Validate() error {
+	switch c.Format {
+	case FormatPlain, FormatJSON:
+	default:
+		return fmt.Errorf("unsupported log format: %s", c.Format)
+	}
+
+	if c.MinLevel != "" {
+		if _, ok := levelRank[NormalizeLevel(c.MinLevel)]; !ok {
+			return fmt.Errorf("unsupported log level: %s", c.MinLevel)
+		}
+	}
+
+	return nil
+}
+
+// NewParser creates a parser for the given config
+func NewParser(cfg Config) (*Parser, error) {
+	if cfg.Format == "" {
+		cfg.Format = FormatPlain
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Parser{
+		config:  cfg,
+		minRank: levelRank[NormalizeLevel(cfg.MinLevel)],
+	}, nil
+}
+
+// Parse parses a single line received at the given time. The returned bool is
+// false when the entry falls below the configured minimum level and should be dropped.
+// Malformed lines are never fatal: they are counted and kept as plain text.
+func (p *Parser) Parse(line string, arrival time.Time) (Entry, bool) {
+	atomic.AddInt64(&p.lines, 1)
+
+	line = strings.TrimRight(line, "\r\n")
+	entry := Entry{
+		Timestamp: arrival,
+		Message:   line,
+		Raw:       line,
+	}
+
+	if p.config.Format == FormatJSON {
+		if p.parseJSON(line, &entry) {
+			atomic.AddInt64(&p.parsed, 1)
+		} else {
+			atomic.AddInt64(&p.malformed, 1)
+		}
+	}
+
+	if !p.Allows(entry.Level) {
+		atomic.AddInt64(&p.dropped, 1)
+		return entry, false
+	}
+
+	return entry, true
+}
+
+// Allows reports whether an entry with the given level passes the minimum level.
+// Entries without a recognisable level are always kept.
+func (p *Parser) Allows(level string) bool {
+	rank, ok := levelRank[NormalizeLevel(level)]
+	if !ok || p.minRank == 0 {
+		return true
+	}
+	return rank >= p.minRank
+}
+
+// Stats returns a copy of the parser counters
+func (p *Parser) Stats() Stats {
+	return Stats{
+		Lines:     atomic.LoadInt64(&p.lines),
+		Parsed:    atomic.LoadInt64(&p.parsed),
+		Malformed: atomic.LoadInt64(&p.malformed),
+		Dropped:   atomic.LoadInt64(&p.dropped),
+	}
+}
+
+// parseJSON fills the entry from a JSON line and reports whether parsing succeeded
+func (p *Parser) parseJSON(line string, entry *Entry) bool {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return false
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(trimmed), &fields); err != nil {
+		return false
+	}
+
+	entry.Parsed = true
+	entry.Fields = fields
+
+	if raw, ok := fields[p.config.LevelField]; ok {
+		entry.Level = NormalizeLevel(fmt.Sprint(raw))
+		delete(fields, p.config.LevelField)
+	}
+
+	if raw, ok := fields[p.config.MessageField]; ok {
+		entry.Message = fmt.Sprint(raw)
+		delete(fields, p.config.MessageField)
+	}
+
+	if raw, ok := fields[p.config.TimestampField]; ok {
+		if ts, err := parseTimestamp(raw, p.config.TimestampFormat); err == nil {
+			entry.Timestamp = ts
+			delete(fields, p.config.TimestampField)
+		}
+	}
+
+	return true
+}
+
+// parseTimestamp converts a JSON timestamp value using the configured format
+func parseTimestamp(raw interface{}, format string) (time.Time, error) {
+	switch format {
+	case "unix", "unix_ms":
+		var value float64
+		switch v := raw.(type) {
+		case float64:
+			value = v
+		case string:
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			value = parsed
+		default:
+			return time.Time{}, fmt.Errorf("unsupported timestamp value: %v", raw)
+		}
+		if format == "unix_ms" {
+			return time.UnixMilli(int64(value)), nil
+		}
+		secs := int64(value)
+		return time.Unix(secs, int64((value-float64(secs))*1e9)), nil
+	default:
+		s, ok := raw.(string)
+		if !ok {
+			return time.Time{}, fmt.Errorf("unsupported timestamp value: %v", raw)
+		}
+		if format == "" {
+			format = time.RFC3339Nano
+		}
+		return time.Parse(format, s)
+	}
+}
+
+// NormalizeLevel maps common level spellings onto the canonical level names.
+// Unknown values are returned lower-cased.
+func NormalizeLevel(level string) string {
+	level = strings.ToLower(strings.TrimSpace(level))
+	switch level {
+	case "trc", "10":
+		return LevelTrace
+	case "dbg", "debug", "20":
+		return LevelDebug
+	case "inf", "information", "notice", "30":
+		return LevelInfo
+	case "wrn", "warning", "40":
+		return LevelWarn
+	case "err", "50":
+		return LevelError
+	case "crit", "critical", "panic", "ftl", "60":
+		return LevelFatal
+	}
+	return level
+}
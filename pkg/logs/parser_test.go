@@ -0,0 +1,184 @@
+package logs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMixedStream(t *testing.T) {
+	parser, err := NewParser(Config{
+		Format:          FormatJSON,
+		TimestampField:  "ts",
+		TimestampFormat: time.RFC3339Nano,
+		LevelField:      "severity",
+		MessageField:    "message",
+	})
+	require.NoError(t, err)
+
+	arrival := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	lines := []string{
+		`{"ts":"2025-03-01T11:59:58.5Z","severity":"INFO","message":"server started","port":8080}`,
+		`{"ts":"2025-03-01T11:59:59Z","severity":"warning","message":"slow query","duration_ms":812`,
+		`panic: runtime error: index out of range`,
+		`{"severity":"ERROR","message":"no timestamp here"}`,
+		`{"ts":"not a time","severity":"debug","message":"bad timestamp"}`,
+		"",
+	}
+
+	var entries []Entry
+	for _, line := range lines {
+		entry, keep := parser.Parse(line, arrival)
+		require.True(t, keep)
+		entries = append(entries, entry)
+	}
+
+	// Valid JSON with all fields
+	assert.True(t, entries[0].Parsed)
+	assert.Equal(t, LevelInfo, entries[0].Level)
+	assert.Equal(t, "server started", entries[0].Message)
+	assert.Equal(t, time.Date(2025, 3, 1, 11, 59, 58, 500000000, time.UTC), entries[0].Timestamp)
+	assert.Equal(t, float64(8080), entries[0].Fields["port"])
+	assert.NotContains(t, entries[0].Fields, "severity")
+
+	// Truncated JSON is kept as plain text
+	assert.False(t, entries[1].Parsed)
+	assert.Empty(t, entries[1].Level)
+	assert.Equal(t, lines[1], entries[1].Message)
+	assert.Equal(t, arrival, entries[1].Timestamp)
+
+	// Plain text line
+	assert.False(t, entries[2].Parsed)
+	assert.Equal(t, lines[2], entries[2].Message)
+	assert.Equal(t, arrival, entries[2].Timestamp)
+
+	// Missing or unparseable timestamps fall back to arrival time
+	assert.Equal(t, LevelError, entries[3].Level)
+	assert.Equal(t, arrival, entries[3].Timestamp)
+	assert.Equal(t, arrival, entries[4].Timestamp)
+	assert.Equal(t, "not a time", entries[4].Fields["ts"])
+
+	stats := parser.Stats()
+	assert.Equal(t, int64(6), stats.Lines)
+	assert.Equal(t, int64(3), stats.Parsed)
+	assert.Equal(t, int64(3), stats.Malformed)
+	assert.Zero(t, stats.Dropped)
+}
+
+func TestMinLevel(t *testing.T) {
+	parser, err := NewParser(Config{
+		Format:       FormatJSON,
+		LevelField:   "level",
+		MessageField: "msg",
+		MinLevel:     "INFO",
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		line string
+		keep bool
+	}{
+		{`{"level":"trace","msg":"a"}`, false},
+		{`{"level":"debug","msg":"b"}`, false},
+		{`{"level":"info","msg":"c"}`, true},
+		{`{"level":"warning","msg":"d"}`, true},
+		{`{"level":"fatal","msg":"e"}`, true},
+		{`{"level":"custom","msg":"f"}`, true},
+		{`{"msg":"g"}`, true},
+		{`not json`, true},
+	}
+
+	for _, tt := range tests {
+		_, keep := parser.Parse(tt.line, time.Now())
+		assert.Equal(t, tt.keep, keep, tt.line)
+	}
+
+	assert.Equal(t, int64(2), parser.Stats().Dropped)
+}
+
+func TestPlainFormatCapturesEverything(t *testing.T) {
+	parser, err := NewParser(Config{})
+	require.NoError(t, err)
+
+	arrival := time.Now()
+	entry, keep := parser.Parse(`{"level":"debug","msg":"json ignored"}`+"\n", arrival)
+	assert.True(t, keep)
+	assert.False(t, entry.Parsed)
+	assert.Equal(t, `{"level":"debug","msg":"json ignored"}`, entry.Raw)
+	assert.Equal(t, arrival, entry.Timestamp)
+	assert.Zero(t, parser.Stats().Malformed)
+}
+
+func TestUnixTimestamps(t *testing.T) {
+	tests := []struct {
+		format   string
+		line     string
+		expected time.Time
+	}{
+		{"unix", `{"time":1740830400}`, time.Unix(1740830400, 0)},
+		{"unix", `{"time":"1740830400.25"}`, time.Unix(1740830400, 250000000)},
+		{"unix_ms", `{"time":1740830400123}`, time.UnixMilli(1740830400123)},
+	}
+
+	for _, tt := range tests {
+		parser, err := NewParser(Config{Format: FormatJSON, TimestampField: "time", TimestampFormat: tt.format})
+		require.NoError(t, err)
+
+		entry, _ := parser.Parse(tt.line, time.Now())
+		assert.True(t, tt.expected.Equal(entry.Timestamp), "%s: got %v", tt.line, entry.Timestamp)
+	}
+}
+
+func TestConfigFromServiceYAML(t *testing.T) {
+	cfg, err := ConfigFromServiceYAML(`
+name: api
+image: api:latest
+logging:
+  format: json
+  level_field: severity
+  min_level: warn
+`)
+	require.NoError(t, err)
+	assert.Equal(t, FormatJSON, cfg.Format)
+	assert.Equal(t, "severity", cfg.LevelField)
+	assert.Equal(t, "msg", cfg.MessageField)
+	assert.Equal(t, "time", cfg.TimestampField)
+	assert.Equal(t, time.RFC3339Nano, cfg.TimestampFormat)
+	assert.Equal(t, "warn", cfg.MinLevel)
+
+	cfg, err = ConfigFromServiceYAML("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), cfg)
+
+	_, err = ConfigFromServiceYAML("logging:\n  format: xml\n")
+	assert.Error(t, err)
+
+	_, err = ConfigFromServiceYAML("logging:\n  min_level: verbose\n")
+	assert.Error(t, err)
+}
+
+func TestNormalizeLevel(t *testing.T) {
+	assert.Equal(t, LevelWarn, NormalizeLevel("WARNING"))
+	assert.Equal(t, LevelError, NormalizeLevel(" err "))
+	assert.Equal(t, LevelFatal, NormalizeLevel("panic"))
+	assert.Equal(t, LevelInfo, NormalizeLevel("30"))
+	assert.Equal(t, "custom", NormalizeLevel("Custom"))
+}
+
+func TestApplyToServiceYAML(t *testing.T) {
+	original := "name: api\nimage: api:latest\n"
+	updated, err := ApplyToServiceYAML(original, Config{Format: FormatJSON, MinLevel: "warn"})
+	require.NoError(t, err)
+	assert.Contains(t, updated, "image: api:latest")
+
+	cfg, err := ConfigFromServiceYAML(updated)
+	require.NoError(t, err)
+	assert.Equal(t, FormatJSON, cfg.Format)
+	assert.Equal(t, "warn", cfg.MinLevel)
+	assert.Equal(t, "level", cfg.LevelField)
+
+	_, err = ApplyToServiceYAML(original, Config{Format: "xml"})
+	assert.Error(t, err)
+}
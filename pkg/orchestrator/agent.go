@@ -0,0 +1,399 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// errNodeUnknown is returned when the primary does not know the agent's
+// node, which then registers again
+var errNodeUnknown = errors.New("node is not registered with the primary")
+
+// Agent runs the service instances of a node for a primary orchestrator.
+// It registers the node, sends heartbeats with the resources its instances
+// request and their statuses, and runs the start, stop and health commands
+// it long polls the primary for.
+type Agent struct {
+	primary   string
+	token     string
+	node      NodeRegistration
+	interval  time.Duration // between heartbeats
+	logs      *LogCollector
+	runtime   Runtime
+	client    *http.Client
+	mutex     sync.Mutex
+	nodeID    string
+	instances map[string]*ServiceInstance
+	simulated map[string]string // status of simulated instances
+}
+
+// NewAgent creates the agent of the node an orchestrator configuration
+// describes. Its instances run in the configured runtime, with their logs
+// kept in the node's own database.
+func NewAgent(db *database.DB, cfg *config.Config) *Agent {
+	cluster := cfg.Orchestrator.Cluster
+	node := localNode(cfg)
+	if cfg.Orchestrator.NodeName == "" {
+		if hostname, err := os.Hostname(); err == nil {
+			node.Name = hostname
+		}
+	}
+	if node.Address == "" {
+		node.Address = node.Name
+	}
+
+	a := &Agent{
+		primary: strings.TrimSuffix(cluster.Primary, "/"),
+		token:   cluster.Token,
+		node: NodeRegistration{
+			Name:     node.Name,
+			Address:  node.Address,
+			Capacity: node.Capacity,
+			Labels:   node.Labels,
+		},
+		interval:  defaultHeartbeatInterval,
+		client:    &http.Client{Timeout: maxCommandWait + 10*time.Second},
+		instances: make(map[string]*ServiceInstance),
+		simulated: make(map[string]string),
+	}
+	if interval, err := time.ParseDuration(cluster.HeartbeatInterval); err == nil && interval > 0 {
+		a.interval = interval
+	}
+	if db != nil && db.DB != nil {
+		a.logs = NewLogCollector(db, LogOptionsFromConfig(cfg.Orchestrator.ServiceLogs))
+	}
+	a.runtime = newRuntimes(cfg.Orchestrator, a.logs)
+	return a
+}
+
+// SetRuntime sets the runtime that runs the node's instances. Without one,
+// instances are only simulated. It must be called before Run.
+func (a *Agent) SetRuntime(runtime Runtime) {
+	a.runtime = runtime
+}
+
+// NodeID returns the ID the primary gave the node, or "" before it
+// registered
+func (a *Agent) NodeID() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.nodeID
+}
+
+// Run registers the node and runs the commands of the primary until ctx is
+// done, then stops the node's instances
+func (a *Agent) Run(ctx context.Context) {
+	if a.logs != nil {
+		a.logs.Start()
+		defer a.logs.Stop()
+	}
+
+	log.Printf("🖥️  Node agent %s joining %s", a.node.Name, a.primary)
+	if !a.registerUntilDone(ctx) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.heartbeatLoop(ctx)
+	}()
+	a.commandLoop(ctx, &wg)
+	wg.Wait()
+
+	a.stopAll()
+}
+
+// registerUntilDone registers the node, retrying every heartbeat interval,
+// and reports whether it did before ctx was done
+func (a *Agent) registerUntilDone(ctx context.Context) bool {
+	for {
+		err := a.register(ctx)
+		if err == nil {
+			return true
+		}
+		log.Printf("⚠️  Failed to register node %s: %v", a.node.Name, err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(a.interval):
+		}
+	}
+}
+
+// register registers the node with the primary
+func (a *Agent) register(ctx context.Context) error {
+	var resp struct {
+		Node Node `json:"node"`
+	}
+	if err := a.call(ctx, http.MethodPost, "/api/v1/cluster/nodes/register", a.node, &resp); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	a.nodeID = resp.Node.ID
+	a.mutex.Unlock()
+	log.Printf("✅ Node %s registered as %s", a.node.Name, resp.Node.ID)
+	return nil
+}
+
+// heartbeatLoop sends a heartbeat every interval, registering again when
+// the primary lost the node
+func (a *Agent) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := a.call(ctx, http.MethodPost, a.nodePath("/heartbeat"), a.heartbeat(ctx), nil)
+		switch {
+		case errors.Is(err, errNodeUnknown):
+			a.registerUntilDone(ctx)
+		case err != nil && ctx.Err() == nil:
+			log.Printf("⚠️  Failed to send heartbeat of node %s: %v", a.node.Name, err)
+		}
+	}
+}
+
+// heartbeat returns the statuses of the node's instances and the resources
+// those that are up request
+func (a *Agent) heartbeat(ctx context.Context) NodeHeartbeat {
+	a.mutex.Lock()
+	instances := make([]*ServiceInstance, 0, len(a.instances))
+	for _, instance := range a.instances {
+		instances = append(instances, instance)
+	}
+	a.mutex.Unlock()
+
+	heartbeat := NodeHeartbeat{Instances: []InstanceReport{}}
+	for _, instance := range instances {
+		status, err := a.status(ctx, instance.ID)
+		if err != nil {
+			continue
+		}
+		heartbeat.Instances = append(heartbeat.Instances, InstanceReport{ID: instance.ID, Status: status})
+		if !startable(&ServiceInstance{Status: status}) {
+			heartbeat.Usage = addCapacity(heartbeat.Usage, instanceRequest(instance.Resources))
+		}
+	}
+	return heartbeat
+}
+
+// commandLoop long polls the primary for commands and runs each as it
+// arrives until ctx is done
+func (a *Agent) commandLoop(ctx context.Context, wg *sync.WaitGroup) {
+	for ctx.Err() == nil {
+		var resp struct {
+			Commands []*NodeCommand `json:"commands"`
+		}
+		path := a.nodePath("/commands?wait=" + url.QueryEscape(defaultCommandWait.String()))
+		if err := a.call(ctx, http.MethodGet, path, nil, &resp); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, errNodeUnknown) {
+				a.registerUntilDone(ctx)
+				continue
+			}
+			log.Printf("⚠️  Failed to poll commands of node %s: %v", a.node.Name, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(a.interval):
+			}
+			continue
+		}
+
+		for _, command := range resp.Commands {
+			wg.Add(1)
+			go func(command *NodeCommand) {
+				defer wg.Done()
+				a.runCommand(ctx, command)
+			}(command)
+		}
+	}
+}
+
+// runCommand runs a command and sends its result to the primary
+func (a *Agent) runCommand(ctx context.Context, command *NodeCommand) {
+	var err error
+	switch command.Type {
+	case CommandStart:
+		err = a.start(ctx, command.Instance)
+	case CommandStop:
+		err = a.stop(ctx, command.InstanceID)
+	case CommandHealth:
+		err = a.checkHealth(ctx, command.InstanceID)
+	default:
+		err = fmt.Errorf("unknown command %q", command.Type)
+	}
+
+	var result CommandResult
+	if err != nil {
+		result.Error = err.Error()
+	}
+	path := a.nodePath("/commands/" + url.PathEscape(command.ID))
+	if err := a.call(ctx, http.MethodPost, path, result, nil); err != nil && ctx.Err() == nil {
+		log.Printf("⚠️  Failed to report result of command %s: %v", command.ID, err)
+	}
+}
+
+// start starts an instance in the runtime or, without one, simulates it
+func (a *Agent) start(ctx context.Context, instance *ServiceInstance) error {
+	if instance == nil {
+		return errors.New("start command without an instance")
+	}
+	a.mutex.Lock()
+	a.instances[instance.ID] = instance
+	a.mutex.Unlock()
+
+	if a.runtime != nil {
+		return a.runtime.Start(ctx, instance)
+	}
+	a.mutex.Lock()
+	a.simulated[instance.ID] = "running"
+	a.mutex.Unlock()
+	return nil
+}
+
+// stop stops an instance. Instances the node does not run are stopped.
+func (a *Agent) stop(ctx context.Context, id string) error {
+	var err error
+	if a.runtime != nil {
+		err = a.runtime.Stop(ctx, id)
+	}
+	if err != nil && !errors.Is(err, ErrInstanceNotFound) {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.runtime == nil && a.simulated[id] != "" {
+		a.simulated[id] = "stopped"
+	}
+	return nil
+}
+
+// status reports the status of an instance
+func (a *Agent) status(ctx context.Context, id string) (string, error) {
+	if a.runtime != nil {
+		return a.runtime.Status(ctx, id)
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	status, exists := a.simulated[id]
+	if !exists {
+		return "", ErrInstanceNotFound
+	}
+	return status, nil
+}
+
+// checkHealth checks the health of a running instance as the orchestrator
+// would on its own node
+func (a *Agent) checkHealth(ctx context.Context, id string) error {
+	a.mutex.Lock()
+	instance, exists := a.instances[id]
+	a.mutex.Unlock()
+	if !exists {
+		return ErrInstanceNotFound
+	}
+
+	status, err := a.status(ctx, id)
+	if err != nil {
+		return err
+	}
+	if status != "running" {
+		return fmt.Errorf("service instance %s is %s", id, status)
+	}
+	if checker, ok := a.runtime.(HealthChecker); ok {
+		return checker.CheckHealth(ctx, instance)
+	}
+	if a.runtime == nil {
+		return nil
+	}
+	return checkHealthEndpoint(ctx, "localhost", instance.Port)
+}
+
+// stopAll stops the node's instances as the agent exits
+func (a *Agent) stopAll() {
+	a.mutex.Lock()
+	ids := make([]string, 0, len(a.instances))
+	for id := range a.instances {
+		ids = append(ids, id)
+	}
+	a.mutex.Unlock()
+
+	for _, id := range ids {
+		if err := a.stop(context.Background(), id); err != nil {
+			log.Printf("Failed to stop service instance %s: %v", id, err)
+		}
+	}
+}
+
+// nodePath returns the path of an endpoint of the node's agent API
+func (a *Agent) nodePath(suffix string) string {
+	return "/api/v1/cluster/nodes/" + url.PathEscape(a.NodeID()) + suffix
+}
+
+// call sends a request with the cluster token to the primary and decodes
+// its response into out, if given
+func (a *Agent) call(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, a.primary+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		if resp.StatusCode == http.StatusNotFound && failure.Error == "Node not found" {
+			return errNodeUnknown
+		}
+		return fmt.Errorf("primary returned %d: %s", resp.StatusCode, failure.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,135 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bulk deployment requests act on at most maxBulkDeployments deployments,
+// running bulkWorkers actions at a time
+const (
+	maxBulkDeployments = 500
+	bulkWorkers        = 4
+)
+
+// BulkDeploymentRequest applies an action, currently only delete, to
+// several deployments named by ID or matched by a selector
+type BulkDeploymentRequest struct {
+	Action   string              `json:"action" binding:"required"`
+	IDs      []string            `json:"ids,omitempty"`
+	Selector *DeploymentSelector `json:"selector,omitempty"`
+}
+
+// DeploymentSelector matches deployments by status and service name
+type DeploymentSelector struct {
+	Status      string `json:"status,omitempty"`
+	ServiceName string `json:"service_name,omitempty"`
+}
+
+// BulkResult is the outcome of a bulk action on one deployment
+type BulkResult struct {
+	Success bool   `json:"success"`
+	Status  string `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BulkDeployments deletes several deployments concurrently, such as every
+// failed deployment of a service. Each deployment succeeds or fails on its
+// own; the response maps every ID to its result.
+func (o *Orchestrator) BulkDeployments(c *gin.Context) {
+	var req BulkDeploymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Action != "delete" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid action %q, must be delete", req.Action)})
+		return
+	}
+
+	ids, err := o.bulkDeploymentIDs(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := make(map[string]*BulkResult, len(ids))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, bulkWorkers)
+	for _, id := range ids {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(id string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result := &BulkResult{Success: true, Status: "deleted"}
+			if err := o.deleteDeployment(id); err != nil {
+				result = &BulkResult{Error: err.Error()}
+			}
+			mu.Lock()
+			results[id] = result
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"action":    req.Action,
+		"results":   results,
+		"succeeded": succeeded,
+		"failed":    len(ids) - succeeded,
+	})
+}
+
+// bulkDeploymentIDs returns the distinct IDs a bulk request names, or those
+// of the loaded deployments its selector matches
+func (o *Orchestrator) bulkDeploymentIDs(req BulkDeploymentRequest) ([]string, error) {
+	ids := []string{}
+	switch {
+	case len(req.IDs) > 0 && req.Selector != nil:
+		return nil, errors.New("ids and selector cannot both be set")
+	case req.Selector != nil:
+		// An empty selector would match every deployment
+		if req.Selector.Status == "" && req.Selector.ServiceName == "" {
+			return nil, errors.New("selector must set status or service_name")
+		}
+		o.mutex.RLock()
+		for id, deployment := range o.deployments {
+			if (req.Selector.Status == "" || deployment.Status == req.Selector.Status) &&
+				(req.Selector.ServiceName == "" || deployment.ServiceName == req.Selector.ServiceName) {
+				ids = append(ids, id)
+			}
+		}
+		o.mutex.RUnlock()
+	case len(req.IDs) == 0:
+		return nil, errors.New("ids or selector is required")
+	default:
+		seen := make(map[string]bool, len(req.IDs))
+		for _, id := range req.IDs {
+			if id == "" {
+				return nil, errors.New("ids cannot be empty")
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	if len(ids) > maxBulkDeployments {
+		return nil, fmt.Errorf("%d deployments named, at most %d can be changed at once", len(ids), maxBulkDeployments)
+	}
+	return ids, nil
+}
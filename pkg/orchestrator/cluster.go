@@ -0,0 +1,933 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+// Node statuses. Only ready nodes are given new service instances.
+const (
+	NodeReady    = "ready"
+	NodeNotReady = "not_ready"
+)
+
+// Commands a node agent runs on its instances
+const (
+	CommandStart  = "start"
+	CommandStop   = "stop"
+	CommandHealth = "health"
+)
+
+// localNodeID is the ID of the node the orchestrator itself runs on
+const localNodeID = "localhost"
+
+// Cluster defaults, overridden by orchestrator.cluster
+const (
+	defaultHeartbeatInterval = 10 * time.Second
+	defaultNodeTimeout       = 30 * time.Second
+	defaultNodePods          = 100
+)
+
+// Long polls for commands wait this long by default, and at most below the
+// server's write timeout. Agents have commandTimeout to run a command, long
+// enough to pull an image.
+const (
+	defaultCommandWait = 20 * time.Second
+	maxCommandWait     = 25 * time.Second
+	commandTimeout     = 5 * time.Minute
+)
+
+// ErrNoSchedulableNode is returned when no ready node matching a service's
+// node selector has room for one of its instances
+var ErrNoSchedulableNode = errors.New("no ready node has room for the service")
+
+// NodeCapacity is an amount of node resources: CPU in nano CPUs, memory in
+// bytes and service instances
+type NodeCapacity struct {
+	NanoCPUs    int64 `json:"nano_cpus"`
+	MemoryBytes int64 `json:"memory_bytes"`
+	Pods        int   `json:"pods"`
+}
+
+// NodeRegistration is what a node agent registers with. A capacity of zero
+// is not limited.
+type NodeRegistration struct {
+	Name     string            `json:"name" binding:"required"`
+	Address  string            `json:"address"`
+	Capacity NodeCapacity      `json:"capacity"`
+	Labels   map[string]string `json:"labels"`
+}
+
+// NodeHeartbeat is what a node agent reports: the resources its instances
+// request and the status of each
+type NodeHeartbeat struct {
+	Usage     NodeCapacity     `json:"usage"`
+	Instances []InstanceReport `json:"instances"`
+}
+
+// InstanceReport is the status of an instance a node agent runs
+type InstanceReport struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// NodeCommand is a command for a node agent: to start Instance, or to stop
+// or check the health of the instance with InstanceID
+type NodeCommand struct {
+	ID         string           `json:"id"`
+	Type       string           `json:"type"`
+	InstanceID string           `json:"instance_id"`
+	Instance   *ServiceInstance `json:"instance,omitempty"`
+}
+
+// CommandResult is the outcome of a node command, failed with Error
+type CommandResult struct {
+	Error string `json:"error,omitempty"`
+}
+
+// cluster tracks the agents of the nodes that registered with the
+// orchestrator, and the commands sent to them. It has its own mutex, which
+// may be taken while holding o.mutex but not the other way round.
+type cluster struct {
+	token    string
+	timeout  time.Duration // without heartbeats until a node is not ready
+	nodes    *database.NodeRepository
+	mutex    sync.Mutex
+	agents   map[string]*nodeAgent      // by node ID
+	commands map[string]*pendingCommand // sent and not yet done, by ID
+}
+
+// nodeAgent is the state of a node's agent
+type nodeAgent struct {
+	ready    bool
+	queue    []*NodeCommand
+	notify   chan struct{} // closed when commands are queued
+	statuses map[string]string
+}
+
+// pendingCommand is a command waiting for its result
+type pendingCommand struct {
+	node      string
+	delivered bool // to the agent's long poll
+	done      chan CommandResult
+}
+
+// newCluster returns the cluster of an orchestrator configuration, or nil
+// unless cluster mode is on for a primary
+func newCluster(db *database.DB, cfg config.OrchestratorConfig) *cluster {
+	if !cfg.ClusterMode || cfg.Cluster.Token == "" || cfg.Cluster.Primary != "" {
+		return nil
+	}
+
+	c := &cluster{
+		token:    cfg.Cluster.Token,
+		timeout:  defaultNodeTimeout,
+		agents:   make(map[string]*nodeAgent),
+		commands: make(map[string]*pendingCommand),
+	}
+	if timeout, err := time.ParseDuration(cfg.Cluster.NodeTimeout); err == nil && timeout > 0 {
+		c.timeout = timeout
+	}
+	if db != nil && db.DB != nil {
+		c.nodes = db.NodeRepository()
+	}
+	return c
+}
+
+// agent returns the agent of a node, creating it. Callers hold c.mutex.
+func (c *cluster) agent(nodeID string) *nodeAgent {
+	agent, exists := c.agents[nodeID]
+	if !exists {
+		agent = &nodeAgent{notify: make(chan struct{}), statuses: make(map[string]string)}
+		c.agents[nodeID] = agent
+	}
+	return agent
+}
+
+// setReady marks the agent of a node ready or not. Commands waiting on an
+// agent that is no longer ready fail.
+func (c *cluster) setReady(nodeID string, ready bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.agent(nodeID).ready = ready
+	if ready {
+		return
+	}
+	for id, pending := range c.commands {
+		if pending.node == nodeID {
+			pending.done <- CommandResult{Error: fmt.Sprintf("node %s is not ready", nodeID)}
+			delete(c.commands, id)
+		}
+	}
+}
+
+// report records the statuses of the instances a node agent runs
+func (c *cluster) report(nodeID string, instances []InstanceReport) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	agent := c.agent(nodeID)
+	agent.ready = true
+	for _, instance := range instances {
+		agent.statuses[instance.ID] = instance.Status
+	}
+}
+
+// queue queues a command for a node agent and wakes its long poll.
+// Callers hold c.mutex.
+func (c *cluster) queue(nodeID string, command *NodeCommand) {
+	command.ID = uuid.New().String()
+	agent := c.agent(nodeID)
+	agent.queue = append(agent.queue, command)
+	close(agent.notify)
+	agent.notify = make(chan struct{})
+}
+
+// known reports whether a node registered
+func (c *cluster) known(nodeID string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, exists := c.agents[nodeID]
+	return exists
+}
+
+// take returns the commands queued for a node agent, or a channel closed
+// once there are some
+func (c *cluster) take(nodeID string) ([]*NodeCommand, <-chan struct{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	agent := c.agent(nodeID)
+	commands := agent.queue
+	agent.queue = nil
+	for _, command := range commands {
+		if pending, exists := c.commands[command.ID]; exists {
+			pending.delivered = true
+		}
+	}
+	return commands, agent.notify
+}
+
+// dispatch sends a command to a ready node agent and waits for its result.
+// Agents that do not take the command within the node timeout are taken
+// to be gone.
+func (c *cluster) dispatch(ctx context.Context, nodeID string, command *NodeCommand) error {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	c.mutex.Lock()
+	if agent := c.agents[nodeID]; agent == nil || !agent.ready {
+		c.mutex.Unlock()
+		return fmt.Errorf("node %s is not ready", nodeID)
+	}
+	c.queue(nodeID, command)
+	done := make(chan CommandResult, 1)
+	c.commands[command.ID] = &pendingCommand{node: nodeID, done: done}
+	c.mutex.Unlock()
+
+	pickup := time.NewTimer(c.timeout)
+	defer pickup.Stop()
+	for {
+		select {
+		case result := <-done:
+			if result.Error != "" {
+				return errors.New(result.Error)
+			}
+			return nil
+		case <-pickup.C:
+			if c.withdraw(nodeID, command.ID, false) {
+				return fmt.Errorf("node %s did not take the command within %s", nodeID, c.timeout)
+			}
+		case <-ctx.Done():
+			c.withdraw(nodeID, command.ID, true)
+			return ctx.Err()
+		}
+	}
+}
+
+// withdraw drops a pending command, reporting whether it did. Commands
+// already delivered are only dropped with force.
+func (c *cluster) withdraw(nodeID, commandID string, force bool) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	pending, exists := c.commands[commandID]
+	if !exists || (pending.delivered && !force) {
+		return false
+	}
+	delete(c.commands, commandID)
+	agent := c.agent(nodeID)
+	for i, command := range agent.queue {
+		if command.ID == commandID {
+			agent.queue = append(agent.queue[:i], agent.queue[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// complete delivers the result of a command, reporting whether it was
+// waited for
+func (c *cluster) complete(nodeID, commandID string, result CommandResult) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	pending, exists := c.commands[commandID]
+	if !exists || pending.node != nodeID {
+		return false
+	}
+	pending.done <- result
+	delete(c.commands, commandID)
+	return true
+}
+
+// setStatus records the status of an instance on a node until its agent
+// reports it
+func (c *cluster) setStatus(nodeID, instanceID, status string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.agent(nodeID).statuses[instanceID] = status
+}
+
+// status returns the last status of an instance on a node. Instances on a
+// node that is not ready have failed.
+func (c *cluster) status(nodeID, instanceID string) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	agent := c.agents[nodeID]
+	if agent == nil {
+		return "", ErrInstanceNotFound
+	}
+	status, exists := agent.statuses[instanceID]
+	if !exists {
+		return "", ErrInstanceNotFound
+	}
+	if !agent.ready {
+		return "failed", nil
+	}
+	return status, nil
+}
+
+// nodeRuntime runs instances on the node they are scheduled on: those of
+// the local node in the orchestrator's runtime, or simulated without one,
+// and the others by sending commands to their node's agent
+type nodeRuntime struct {
+	local     Runtime
+	cluster   *cluster
+	mutex     sync.Mutex
+	nodes     map[string]string // node ID of each instance started
+	simulated map[string]string // status of simulated local instances
+}
+
+func newNodeRuntime(local Runtime, c *cluster) *nodeRuntime {
+	return &nodeRuntime{
+		local:     local,
+		cluster:   c,
+		nodes:     make(map[string]string),
+		simulated: make(map[string]string),
+	}
+}
+
+// Start starts an instance on its node, stopping it on the node it ran on
+// before if it moved
+func (r *nodeRuntime) Start(ctx context.Context, service *ServiceInstance) error {
+	node := service.NodeID
+	if node == "" {
+		node = localNodeID
+	}
+
+	r.mutex.Lock()
+	previous, exists := r.nodes[service.ID]
+	r.mutex.Unlock()
+	if exists && previous != node {
+		if err := r.stopOn(ctx, previous, service.ID); err != nil && !errors.Is(err, ErrInstanceNotFound) {
+			return err
+		}
+	}
+	r.mutex.Lock()
+	r.nodes[service.ID] = node
+	r.mutex.Unlock()
+
+	if node != localNodeID {
+		err := r.cluster.dispatch(ctx, node, &NodeCommand{Type: CommandStart, InstanceID: service.ID, Instance: service})
+		if err == nil {
+			r.cluster.setStatus(node, service.ID, "running")
+		}
+		return err
+	}
+	if r.local != nil {
+		return r.local.Start(ctx, service)
+	}
+	r.mutex.Lock()
+	r.simulated[service.ID] = "running"
+	r.mutex.Unlock()
+	return nil
+}
+
+// Stop stops an instance on the node it was started on
+func (r *nodeRuntime) Stop(ctx context.Context, id string) error {
+	node := r.node(id)
+	if node == "" {
+		return ErrInstanceNotFound
+	}
+	return r.stopOn(ctx, node, id)
+}
+
+// stopOn stops an instance on a node. Agents that are not ready are left
+// the command to run once they are back.
+func (r *nodeRuntime) stopOn(ctx context.Context, node, id string) error {
+	if node != localNodeID {
+		command := &NodeCommand{Type: CommandStop, InstanceID: id}
+		r.cluster.mutex.Lock()
+		if agent := r.cluster.agents[node]; agent != nil && !agent.ready {
+			r.cluster.queue(node, command)
+			agent.statuses[id] = "stopped"
+			r.cluster.mutex.Unlock()
+			return nil
+		}
+		r.cluster.mutex.Unlock()
+
+		err := r.cluster.dispatch(ctx, node, command)
+		if err == nil {
+			r.cluster.setStatus(node, id, "stopped")
+		}
+		return err
+	}
+	if r.local != nil {
+		return r.local.Stop(ctx, id)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.simulated[id]; !exists {
+		return ErrInstanceNotFound
+	}
+	r.simulated[id] = "stopped"
+	return nil
+}
+
+// Status reports the status of an instance, as its agent last reported it
+// for those on other nodes
+func (r *nodeRuntime) Status(ctx context.Context, id string) (string, error) {
+	switch node := r.node(id); node {
+	case "":
+		return "", ErrInstanceNotFound
+	case localNodeID:
+		if r.local != nil {
+			return r.local.Status(ctx, id)
+		}
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return r.simulated[id], nil
+	default:
+		return r.cluster.status(node, id)
+	}
+}
+
+// CheckHealth checks the health of an instance where it runs: agents check
+// those on their node, and the local runtime or the instance's health
+// endpoint those on the local node
+func (r *nodeRuntime) CheckHealth(ctx context.Context, service *ServiceInstance) error {
+	node := r.node(service.ID)
+	if node != "" && node != localNodeID {
+		return r.cluster.dispatch(ctx, node, &NodeCommand{Type: CommandHealth, InstanceID: service.ID})
+	}
+	if checker, ok := r.local.(HealthChecker); ok {
+		return checker.CheckHealth(ctx, service)
+	}
+	if r.local == nil {
+		return nil
+	}
+	return checkHealthEndpoint(ctx, "localhost", service.Port)
+}
+
+// PID returns the process ID of a local instance if the local runtime
+// reports one
+func (r *nodeRuntime) PID(id string) int {
+	if reporter, ok := r.local.(PIDReporter); ok && r.node(id) == localNodeID {
+		return reporter.PID(id)
+	}
+	return 0
+}
+
+// Restarts reports the restarts of a local instance if the local runtime
+// restarts instances itself
+func (r *nodeRuntime) Restarts(ctx context.Context, id string) (int, string, error) {
+	if reporter, ok := r.local.(RestartReporter); ok && r.node(id) == localNodeID {
+		return reporter.Restarts(ctx, id)
+	}
+	return 0, "", nil
+}
+
+// node returns the ID of the node an instance was started on, or ""
+func (r *nodeRuntime) node(id string) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.nodes[id]
+}
+
+// localNode returns the node the orchestrator runs on, offering the
+// capacity of orchestrator.cluster or, by default, all of the host's CPUs
+// and 100 instances
+func localNode(cfg *config.Config) *Node {
+	node := &Node{
+		ID:            localNodeID,
+		Name:          localNodeID,
+		Status:        NodeReady,
+		Local:         true,
+		Labels:        map[string]string{},
+		Capacity:      NodeCapacity{NanoCPUs: int64(runtime.NumCPU()) * 1e9, Pods: defaultNodePods},
+		Services:      []string{},
+		LastHeartbeat: time.Now(),
+		Metadata:      make(map[string]interface{}),
+	}
+	if cfg != nil {
+		cluster := cfg.Orchestrator.Cluster
+		if cfg.Orchestrator.NodeName != "" {
+			node.Name = cfg.Orchestrator.NodeName
+		}
+		node.Address = cluster.Address
+		if cpu, err := spec.ParseCPU(cluster.CPU); err == nil && cpu > 0 {
+			node.Capacity.NanoCPUs = cpu
+		}
+		node.Capacity.MemoryBytes, _ = spec.ParseMemory(cluster.Memory)
+		if cluster.Pods > 0 {
+			node.Capacity.Pods = cluster.Pods
+		}
+		for key, value := range cluster.Labels {
+			node.Labels[key] = value
+		}
+	}
+	node.Resources = nodeResources(node.Capacity, node.Usage)
+	return node
+}
+
+// loadNodes loads the nodes that registered before, not ready until their
+// agents send a heartbeat. Callers hold o.mutex.
+func (o *Orchestrator) loadNodes() error {
+	if o.cluster == nil || o.cluster.nodes == nil {
+		return nil
+	}
+
+	records, err := o.cluster.nodes.List()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		labels, err := record.LabelMap()
+		if err != nil {
+			return fmt.Errorf("failed to decode labels of node %s: %w", record.Name, err)
+		}
+		node := &Node{
+			ID:       record.ID,
+			Name:     record.Name,
+			Address:  record.Address,
+			Status:   NodeNotReady,
+			Labels:   labels,
+			Capacity: NodeCapacity{NanoCPUs: record.CPUCapacity, MemoryBytes: record.MemoryCapacity, Pods: record.PodCapacity},
+			Usage:    NodeCapacity{NanoCPUs: record.CPUUsed, MemoryBytes: record.MemoryUsed, Pods: record.PodsUsed},
+			Services: []string{},
+			Metadata: make(map[string]interface{}),
+		}
+		if record.LastHeartbeat != nil {
+			node.LastHeartbeat = *record.LastHeartbeat
+		}
+		node.Resources = nodeResources(node.Capacity, node.Usage)
+		o.nodes[node.ID] = node
+		o.cluster.setReady(node.ID, false)
+	}
+	return nil
+}
+
+// nodeMonitorLoop marks nodes not ready once their heartbeats stop
+func (o *Orchestrator) nodeMonitorLoop() {
+	ticker := time.NewTicker(o.cluster.timeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.ctx.Done():
+			return
+		case <-ticker.C:
+			o.checkNodes()
+		}
+	}
+}
+
+// checkNodes marks the nodes without a heartbeat for longer than the node
+// timeout not ready
+func (o *Orchestrator) checkNodes() {
+	o.mutex.Lock()
+	var lost []*Node
+	for _, node := range o.nodes {
+		if !node.Local && node.Status == NodeReady && time.Since(node.LastHeartbeat) > o.cluster.timeout {
+			node.Status = NodeNotReady
+			lost = append(lost, node)
+		}
+	}
+	o.mutex.Unlock()
+
+	for _, node := range lost {
+		o.cluster.setReady(node.ID, false)
+		if o.cluster.nodes != nil {
+			if err := o.cluster.nodes.SetStatus(node.ID, NodeNotReady); err != nil {
+				log.Printf("Failed to record status of node %s: %v", node.Name, err)
+			}
+		}
+		o.recordEvent("Warning", "NodeNotReady",
+			fmt.Sprintf("Node %s sent no heartbeat for %s", node.Name, o.cluster.timeout))
+		log.Printf("⚠️  Node %s is not ready", node.Name)
+	}
+}
+
+// requireNodeToken admits requests of node agents, which carry the cluster
+// token as a bearer token
+func (o *Orchestrator) requireNodeToken(c *gin.Context) {
+	if o.cluster == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Cluster mode is not enabled"})
+		return
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(o.cluster.token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid cluster token"})
+		return
+	}
+	c.Next()
+}
+
+// RegisterNode registers the node of an agent, or updates the node of the
+// same name, which keeps its ID
+func (o *Orchestrator) RegisterNode(c *gin.Context) {
+	var req NodeRegistration
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Capacity.NanoCPUs < 0 || req.Capacity.MemoryBytes < 0 || req.Capacity.Pods < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Capacity must not be negative"})
+		return
+	}
+	if req.Labels == nil {
+		req.Labels = map[string]string{}
+	}
+
+	o.mutex.Lock()
+	var existing *Node
+	for _, node := range o.nodes {
+		if node.Name == req.Name {
+			existing = node
+		}
+	}
+	o.mutex.Unlock()
+	if existing != nil && existing.Local {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Node %s is the primary's own node", req.Name)})
+		return
+	}
+
+	id := uuid.New().String()
+	if existing != nil {
+		id = existing.ID
+	}
+	if o.cluster.nodes != nil {
+		labels, _ := json.Marshal(req.Labels)
+		record := &database.Node{
+			ID:             id,
+			Name:           req.Name,
+			Address:        req.Address,
+			Status:         NodeReady,
+			CPUCapacity:    req.Capacity.NanoCPUs,
+			MemoryCapacity: req.Capacity.MemoryBytes,
+			PodCapacity:    req.Capacity.Pods,
+			Labels:         string(labels),
+		}
+		if err := o.cluster.nodes.Register(record); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to register node: %v", err)})
+			return
+		}
+		id = record.ID
+	}
+
+	o.mutex.Lock()
+	node, exists := o.nodes[id]
+	if !exists {
+		node = &Node{ID: id, Services: []string{}, Metadata: make(map[string]interface{})}
+		o.nodes[id] = node
+	}
+	node.Name = req.Name
+	node.Address = req.Address
+	node.Status = NodeReady
+	node.Labels = req.Labels
+	node.Capacity = req.Capacity
+	node.LastHeartbeat = time.Now()
+	node.Resources = nodeResources(node.Capacity, node.Usage)
+	registered := *node
+	o.mutex.Unlock()
+
+	o.cluster.setReady(id, true)
+	o.recordEvent("Normal", "NodeRegistered", fmt.Sprintf("Node %s registered from %s", req.Name, c.ClientIP()))
+	log.Printf("🖥️  Node %s registered", req.Name)
+
+	c.JSON(http.StatusOK, gin.H{"node": registered})
+}
+
+// NodeHeartbeat records the heartbeat of a node agent. Unknown nodes get a
+// 404, upon which agents register again.
+func (o *Orchestrator) NodeHeartbeat(c *gin.Context) {
+	var req NodeHeartbeat
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	o.mutex.Lock()
+	node, exists := o.nodes[c.Param("id")]
+	if !exists || node.Local {
+		o.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+	recovered := node.Status != NodeReady
+	node.Status = NodeReady
+	node.Usage = req.Usage
+	node.LastHeartbeat = time.Now()
+	node.Resources = nodeResources(node.Capacity, node.Usage)
+	heartbeat := *node
+	o.mutex.Unlock()
+
+	o.cluster.report(heartbeat.ID, req.Instances)
+	if o.cluster.nodes != nil {
+		err := o.cluster.nodes.Heartbeat(heartbeat.ID, NodeReady, req.Usage.NanoCPUs, req.Usage.MemoryBytes, req.Usage.Pods, heartbeat.LastHeartbeat)
+		if err != nil {
+			log.Printf("Failed to record heartbeat of node %s: %v", heartbeat.Name, err)
+		}
+	}
+	if recovered {
+		o.recordEvent("Normal", "NodeReady", fmt.Sprintf("Node %s is ready", heartbeat.Name))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": heartbeat.Status})
+}
+
+// NodeCommands returns the commands queued for a node agent, waiting for
+// some up to the duration given by the wait query parameter
+func (o *Orchestrator) NodeCommands(c *gin.Context) {
+	wait := defaultCommandWait
+	if value := c.Query("wait"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wait, expected a duration like 20s"})
+			return
+		}
+		wait = min(parsed, maxCommandWait)
+	}
+
+	// Health checks wait on the agent while holding o.mutex, so the node is
+	// looked up among the agents
+	nodeID := c.Param("id")
+	if !o.cluster.known(nodeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		commands, queued := o.cluster.take(nodeID)
+		if len(commands) > 0 {
+			c.JSON(http.StatusOK, gin.H{"commands": commands})
+			return
+		}
+		select {
+		case <-queued:
+		case <-timer.C:
+			c.JSON(http.StatusOK, gin.H{"commands": []*NodeCommand{}})
+			return
+		case <-c.Request.Context().Done():
+			return
+		case <-o.ctx.Done():
+			c.JSON(http.StatusOK, gin.H{"commands": []*NodeCommand{}})
+			return
+		}
+	}
+}
+
+// CompleteNodeCommand records the result of a command a node agent ran
+func (o *Orchestrator) CompleteNodeCommand(c *gin.Context) {
+	var result CommandResult
+	if err := c.ShouldBindJSON(&result); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !o.cluster.complete(c.Param("id"), c.Param("command_id"), result) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Command not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "completed"})
+}
+
+// schedule picks the node of each replica of a request: of the ready nodes
+// matching its node selector, the one with the largest share of CPU, memory
+// and instances left free by the replica, ties going to the first by name.
+// Callers hold o.mutex.
+func (o *Orchestrator) schedule(req DeployRequest) ([]string, error) {
+	need := NodeCapacity{Pods: 1}
+	if req.Resources != nil {
+		need.NanoCPUs, _ = spec.ParseCPU(req.Resources.CPU)
+		need.MemoryBytes, _ = spec.ParseMemory(req.Resources.Memory)
+	}
+
+	nodes := make([]*Node, 0, len(o.nodes))
+	for _, node := range o.nodes {
+		if node.Status == NodeReady && matchesSelector(node.Labels, req.NodeSelector) {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+
+	// The instances the deployment replaces make room for it
+	used := o.requested(func(service *ServiceInstance) bool { return service.Name != req.Name })
+	placement := make([]string, max(req.Replicas, 1))
+	for i := range placement {
+		var best *Node
+		bestFree := -1.0
+		for _, node := range nodes {
+			if free, fits := freeShare(node.Capacity, used[node.ID], need); fits && free > bestFree {
+				best, bestFree = node, free
+			}
+		}
+		if best == nil {
+			return nil, fmt.Errorf("%w: %s needs %s CPUs, %s of memory and an instance slot",
+				ErrNoSchedulableNode, req.Name, formatCPU(need.NanoCPUs), formatMemory(need.MemoryBytes))
+		}
+		placement[i] = best.ID
+		used[best.ID] = addCapacity(used[best.ID], need)
+	}
+	return placement, nil
+}
+
+// requested sums the resources requested by the instances that are up on
+// each node, of those counted. Callers hold o.mutex.
+func (o *Orchestrator) requested(counted func(*ServiceInstance) bool) map[string]NodeCapacity {
+	used := make(map[string]NodeCapacity)
+	for _, service := range o.services {
+		if startable(service) || !counted(service) {
+			continue
+		}
+		node := service.NodeID
+		if node == "" {
+			node = localNodeID
+		}
+		used[node] = addCapacity(used[node], instanceRequest(service.Resources))
+	}
+	return used
+}
+
+// instanceRequest returns the resources an instance requests
+func instanceRequest(resources *ResourceRequirements) NodeCapacity {
+	request := NodeCapacity{Pods: 1}
+	if resources != nil {
+		request.NanoCPUs, _ = spec.ParseCPU(resources.CPU)
+		request.MemoryBytes, _ = spec.ParseMemory(resources.Memory)
+	}
+	return request
+}
+
+// freeShare returns the smallest share of a node's capacity left free once
+// need is added to used, and whether need fits at all. Resources without a
+// capacity are not limited.
+func freeShare(capacity, used, need NodeCapacity) (float64, bool) {
+	free := 1.0
+	for _, resource := range [][3]int64{
+		{capacity.NanoCPUs, used.NanoCPUs, need.NanoCPUs},
+		{capacity.MemoryBytes, used.MemoryBytes, need.MemoryBytes},
+		{int64(capacity.Pods), int64(used.Pods), int64(need.Pods)},
+	} {
+		if resource[0] <= 0 {
+			continue
+		}
+		share := float64(resource[0]-resource[1]-resource[2]) / float64(resource[0])
+		if share < 0 {
+			return 0, false
+		}
+		free = min(free, share)
+	}
+	return free, true
+}
+
+func addCapacity(a, b NodeCapacity) NodeCapacity {
+	return NodeCapacity{NanoCPUs: a.NanoCPUs + b.NanoCPUs, MemoryBytes: a.MemoryBytes + b.MemoryBytes, Pods: a.Pods + b.Pods}
+}
+
+// matchesSelector reports whether labels have every value of a selector
+func matchesSelector(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// respondScheduleError responds to a deployment no node has room for,
+// reporting whether err was one
+func respondScheduleError(c *gin.Context, err error) bool {
+	if !errors.Is(err, ErrNoSchedulableNode) {
+		return false
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	return true
+}
+
+// nodeResources describes the capacity and usage of a node
+func nodeResources(capacity, usage NodeCapacity) *NodeResources {
+	return &NodeResources{
+		CPU:    resourceUsage(capacity.NanoCPUs, usage.NanoCPUs, formatCPU),
+		Memory: resourceUsage(capacity.MemoryBytes, usage.MemoryBytes, formatMemory),
+		Pods: resourceUsage(int64(capacity.Pods), int64(usage.Pods), func(n int64) string {
+			return strconv.FormatInt(n, 10)
+		}),
+	}
+}
+
+// resourceUsage describes the usage of one resource. Resources without a
+// capacity are unlimited.
+func resourceUsage(total, used int64, format func(int64) string) ResourceUsage {
+	if total <= 0 {
+		return ResourceUsage{Used: format(used), Available: "unlimited", Total: "unlimited"}
+	}
+	return ResourceUsage{
+		Used:      format(used),
+		Available: format(max(total-used, 0)),
+		Total:     format(total),
+		Percent:   float64(used) / float64(total) * 100,
+	}
+}
+
+// formatCPU formats nano CPUs as cores
+func formatCPU(nanoCPUs int64) string {
+	return strconv.FormatFloat(float64(nanoCPUs)/1e9, 'f', -1, 64)
+}
+
+// formatMemory formats bytes in gibibytes
+func formatMemory(bytes int64) string {
+	return strconv.FormatFloat(float64(bytes)/(1<<30), 'f', 2, 64) + "Gi"
+}
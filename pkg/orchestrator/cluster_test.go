@@ -0,0 +1,271 @@
+package orchestrator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// startClusterTest starts a primary offering one CPU of its own, serving
+// the orchestrator API over HTTP for node agents and returning the test
+// router besides
+func startClusterTest(t *testing.T) (*database.DB, *Orchestrator, *gin.Engine, *httptest.Server, *fakeRuntime) {
+	cfg := setupDeploymentTest(t)
+	cfg.Orchestrator.NodeName = "primary"
+	cfg.Orchestrator.ClusterMode = true
+	cfg.Orchestrator.Cluster = config.ClusterConfig{Token: "secret", NodeTimeout: "300ms", CPU: "1", Pods: 10}
+
+	db, o, r := startTestOrchestratorWith(t, cfg, newFakeRuntime())
+	api := gin.New()
+	o.RegisterRoutes(api.Group("/api/v1"))
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return db, o, r, server, o.runtime.(*nodeRuntime).local.(*fakeRuntime)
+}
+
+// startTestOrchestratorWith starts an orchestrator on a runtime that
+// checks health without delay
+func startTestOrchestratorWith(t *testing.T, cfg *config.Config, runtime Runtime) (*database.DB, *Orchestrator, *gin.Engine) {
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+
+	o := New(db, cfg)
+	o.SetRuntime(runtime)
+	o.healthInterval = time.Millisecond
+	require.NoError(t, o.Start())
+	t.Cleanup(func() {
+		o.Stop()
+		_ = db.Close()
+	})
+	return db, o, setupTestRouter(o)
+}
+
+// clusterAgent is a node agent running in the test
+type clusterAgent struct {
+	*Agent
+	runtime *fakeRuntime
+	stop    func()
+}
+
+// startAgent starts the agent of a node offering cpu and labels, and waits
+// for the primary to have it ready
+func startAgent(t *testing.T, o *Orchestrator, server *httptest.Server, name, cpu string, labels map[string]string) *clusterAgent {
+	cfg := &config.Config{Orchestrator: config.OrchestratorConfig{
+		NodeName: name,
+		Cluster: config.ClusterConfig{
+			Token:             "secret",
+			Primary:           server.URL,
+			HeartbeatInterval: "50ms",
+			CPU:               cpu,
+			Pods:              10,
+			Labels:            labels,
+		},
+	}}
+	agent := &clusterAgent{Agent: NewAgent(nil, cfg), runtime: newFakeRuntime()}
+	agent.SetRuntime(agent.runtime)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agent.Run(ctx)
+	}()
+	agent.stop = func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(agent.stop)
+
+	require.Eventually(t, func() bool {
+		o.mutex.RLock()
+		defer o.mutex.RUnlock()
+		node := o.nodes[agent.NodeID()]
+		return node != nil && node.Status == NodeReady
+	}, 5*time.Second, 10*time.Millisecond)
+	return agent
+}
+
+// placement returns the node name of each instance of a service
+func placement(o *Orchestrator, name string) []string {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	var nodes []string
+	for _, service := range o.services {
+		if service.Name == name {
+			nodes = append(nodes, service.ID+"@"+o.nodes[service.NodeID].Name)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func TestClusterScheduling(t *testing.T) {
+	db, o, r, server, local := startClusterTest(t)
+	a := startAgent(t, o, server, "worker-a", "4", map[string]string{"zone": "a"})
+	b := startAgent(t, o, server, "worker-b", "2", map[string]string{"disk": "ssd"})
+	// Instances on the nodes are stopped while their agents still run
+	t.Cleanup(o.Stop)
+
+	// Each replica goes to the node left with the most room, ties to the
+	// first by name
+	deployment := deployStrategy(t, o, r, DeployRequest{Image: "web:1", Replicas: 3, Resources: &ResourceRequirements{CPU: "1"}}, "deployed")
+	assert.Equal(t, []string{"web-0@worker-a", "web-1@worker-a", "web-2@worker-b"}, placement(o, "web"))
+	assert.Contains(t, deployment.Logs, "Scheduled web-2 on node worker-b")
+	assert.Equal(t, map[string]string{"web-0": "web:1", "web-1": "web:1"}, a.runtime.images())
+	assert.Equal(t, map[string]string{"web-2": "web:1"}, b.runtime.images())
+	assert.Empty(t, local.images())
+
+	// Node selectors limit the nodes; the selected node is full up to what
+	// it offers
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{
+		Name: "db", Image: "postgres:16", Resources: &ResourceRequirements{CPU: "1"}, NodeSelector: map[string]string{"disk": "ssd"},
+	})
+	require.Equal(t, http.StatusCreated, code, response)
+	waitForStatus(t, o, response["deployment_id"].(string), "deployed")
+	assert.Equal(t, []string{"db-0@worker-b"}, placement(o, "db"))
+
+	code, response = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{
+		Name: "cache", Image: "redis:7", Resources: &ResourceRequirements{CPU: "500m"}, NodeSelector: map[string]string{"disk": "ssd"},
+	})
+	assert.Equal(t, http.StatusServiceUnavailable, code, response)
+	assert.Contains(t, response["error"], "no ready node has room")
+
+	// Without a selector, any node with room will do
+	code, response = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "cache", Image: "redis:7", Resources: &ResourceRequirements{CPU: "1"}})
+	require.Equal(t, http.StatusCreated, code, response)
+	waitForStatus(t, o, response["deployment_id"].(string), "deployed")
+	assert.Equal(t, []string{"cache-0@worker-a"}, placement(o, "cache"))
+
+	// Nodes report what their instances use, which the cluster adds up
+	require.Eventually(t, func() bool {
+		code, response := serveJSON(t, r, http.MethodGet, "/cluster/resources", nil)
+		usage := response["usage"].(map[string]interface{})
+		return code == http.StatusOK && usage["nano_cpus"] == 5e9 && usage["pods"] == 5.0
+	}, 5*time.Second, 10*time.Millisecond)
+	_, response = serveJSON(t, r, http.MethodGet, "/cluster/resources", nil)
+	assert.Equal(t, 7e9, response["capacity"].(map[string]interface{})["nano_cpus"])
+	assert.Equal(t, 3.0, response["ready_nodes"])
+
+	_, response = serveJSON(t, r, http.MethodGet, "/nodes", nil)
+	nodes := response["nodes"].([]interface{})
+	require.Len(t, nodes, 3)
+	assert.Equal(t, "primary", nodes[0].(map[string]interface{})["name"])
+	assert.Equal(t, true, nodes[0].(map[string]interface{})["local"])
+	assert.Equal(t, map[string]interface{}{"zone": "a"}, nodes[1].(map[string]interface{})["labels"])
+
+	// The nodes are persisted
+	records, err := db.NodeRepository().List()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, a.NodeID(), records[0].ID)
+	assert.Equal(t, int64(4e9), records[0].CPUCapacity)
+}
+
+func TestClusterNodeNotReady(t *testing.T) {
+	db, o, r, server, _ := startClusterTest(t)
+	b := startAgent(t, o, server, "worker-b", "2", map[string]string{"disk": "ssd"})
+	t.Cleanup(o.Stop)
+
+	deployStrategy(t, o, r, DeployRequest{Image: "web:1", NodeSelector: map[string]string{"disk": "ssd"}}, "deployed")
+	assert.Equal(t, []string{"web-0@worker-b"}, placement(o, "web"))
+
+	// Once its heartbeats stop, the node is not ready and gets no services
+	b.stop()
+	require.Eventually(t, func() bool {
+		o.mutex.RLock()
+		defer o.mutex.RUnlock()
+		return o.nodes[b.NodeID()].Status == NodeNotReady
+	}, 5*time.Second, 10*time.Millisecond)
+	record, err := db.NodeRepository().GetByName("worker-b")
+	require.NoError(t, err)
+	assert.Equal(t, NodeNotReady, record.Status)
+	assert.Equal(t, "NodeNotReady", o.clusterEvents()[0].Reason)
+
+	o.performHealthChecks()
+	o.mutex.RLock()
+	assert.Equal(t, "failed", o.services["web-0"].Status)
+	o.mutex.RUnlock()
+
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "db", Image: "postgres:16", NodeSelector: map[string]string{"disk": "ssd"}})
+	assert.Equal(t, http.StatusServiceUnavailable, code, response)
+
+	// The agent comes back under the same ID
+	again := startAgent(t, o, server, "worker-b", "2", map[string]string{"disk": "ssd"})
+	assert.Equal(t, b.NodeID(), again.NodeID())
+}
+
+func TestClusterAgentAPI(t *testing.T) {
+	_, _, _, server, _ := startClusterTest(t)
+
+	call := func(method, path, token string) int {
+		req, err := http.NewRequest(method, server.URL+"/api/v1/cluster/nodes"+path, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodPost, "/register", ""))
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodPost, "/register", "wrong"))
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/register", "secret"))
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, "/missing/commands?wait=0s", "secret"))
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, "/localhost/commands?wait=0s", "secret"))
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodGet, "/localhost/commands?wait=soon", "secret"))
+
+	// Agents may not take the name of the primary's node
+	agent := NewAgent(nil, &config.Config{Orchestrator: config.OrchestratorConfig{
+		NodeName: "primary",
+		Cluster:  config.ClusterConfig{Token: "secret", Primary: server.URL},
+	}})
+	assert.ErrorContains(t, agent.register(context.Background()), "409")
+
+	// Without cluster mode there is no agent API
+	api := gin.New()
+	New(nil, &config.Config{}).RegisterRoutes(api.Group("/api/v1"))
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/cluster/nodes/register", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	api.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSchedulePlacement(t *testing.T) {
+	o := New(nil, nil)
+	o.nodes["a"] = &Node{ID: "a", Name: "a", Status: NodeReady, Capacity: NodeCapacity{NanoCPUs: 2e9, MemoryBytes: 4 << 30, Pods: 4}}
+	o.nodes["b"] = &Node{ID: "b", Name: "b", Status: NodeReady, Capacity: NodeCapacity{NanoCPUs: 4e9, MemoryBytes: 1 << 30}}
+	o.nodes["c"] = &Node{ID: "c", Name: "c", Status: NodeNotReady, Capacity: NodeCapacity{NanoCPUs: 64e9}}
+
+	// b has the most CPU but the least memory left
+	placed, err := o.schedule(DeployRequest{Name: "web", Replicas: 3, Resources: &ResourceRequirements{CPU: "500m", Memory: "512Mi"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "a", "b"}, placed)
+
+	// Instances of other services that are up take room; those of the
+	// service deployed are replaced
+	o.services["api-0"] = &ServiceInstance{ID: "api-0", Name: "api", NodeID: "b", Status: "running", Resources: &ResourceRequirements{CPU: "3"}}
+	o.services["web-0"] = &ServiceInstance{ID: "web-0", Name: "web", NodeID: "a", Status: "running", Resources: &ResourceRequirements{CPU: "2"}}
+	o.services["old-0"] = &ServiceInstance{ID: "old-0", Name: "old", NodeID: "a", Status: "stopped", Resources: &ResourceRequirements{CPU: "2"}}
+	placed, err = o.schedule(DeployRequest{Name: "web", Resources: &ResourceRequirements{CPU: "1"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, placed)
+
+	_, err = o.schedule(DeployRequest{Name: "web", Replicas: 5})
+	assert.NoError(t, err, "b does not limit instances")
+	_, err = o.schedule(DeployRequest{Name: "web", Resources: &ResourceRequirements{CPU: "3"}})
+	assert.ErrorIs(t, err, ErrNoSchedulableNode)
+	_, err = o.schedule(DeployRequest{Name: "web", NodeSelector: map[string]string{"gpu": "yes"}})
+	assert.ErrorIs(t, err, ErrNoSchedulableNode)
+}
@@ -0,0 +1,272 @@
+package orchestrator
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultDependencyTimeout is how long a service waits for its
+// dependencies to become healthy without orchestrator.dependency_timeout
+const defaultDependencyTimeout = 5 * time.Minute
+
+// maxClusterEvents is how many cluster events are kept
+const maxClusterEvents = 200
+
+// ClusterEvent is something that happened in the cluster
+type ClusterEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Type      string `json:"type"` // Normal or Warning
+	Reason    string `json:"reason"`
+	Message   string `json:"message"`
+}
+
+// recordEvent records a cluster event, dropping the oldest once there are
+// too many
+func (o *Orchestrator) recordEvent(eventType, reason, message string) {
+	o.eventsMutex.Lock()
+	defer o.eventsMutex.Unlock()
+
+	o.events = append(o.events, ClusterEvent{
+		Timestamp: time.Now().Unix(),
+		Type:      eventType,
+		Reason:    reason,
+		Message:   message,
+	})
+	if len(o.events) > maxClusterEvents {
+		o.events = o.events[len(o.events)-maxClusterEvents:]
+	}
+}
+
+// clusterEvents returns the recorded cluster events, newest first
+func (o *Orchestrator) clusterEvents() []ClusterEvent {
+	o.eventsMutex.Lock()
+	defer o.eventsMutex.Unlock()
+
+	events := make([]ClusterEvent, len(o.events))
+	for i, event := range o.events {
+		events[len(events)-1-i] = event
+	}
+	return events
+}
+
+// serviceDependencies returns the dependencies of every known service: the
+// ones with instances and the ones being deployed. Callers hold o.mutex.
+func (o *Orchestrator) serviceDependencies() map[string][]string {
+	graph := make(map[string][]string)
+	for _, service := range o.services {
+		graph[service.Name] = service.DependsOn
+	}
+	for _, deployment := range o.deployments {
+		if deployment.Status == "deploying" && deployment.Request != nil {
+			graph[deployment.ServiceName] = deployment.Request.DependsOn
+		}
+	}
+	return graph
+}
+
+// checkDependencies validates the dependencies of a service about to be
+// deployed: they must exist and must not depend on the service in turn.
+// Callers hold o.mutex.
+func (o *Orchestrator) checkDependencies(name string, dependsOn []string) error {
+	graph := o.serviceDependencies()
+	for _, dependency := range dependsOn {
+		if _, exists := graph[dependency]; !exists && dependency != name {
+			return fmt.Errorf("dependency %s does not exist", dependency)
+		}
+	}
+
+	graph[name] = dependsOn
+	_, err := dependencyOrder(graph)
+	return err
+}
+
+// dependencyOrder sorts services so that each comes after its
+// dependencies, alphabetically where the order is free. It fails if the
+// dependencies form a cycle.
+func dependencyOrder(graph map[string][]string) ([]string, error) {
+	pending := make(map[string]int, len(graph))
+	dependents := make(map[string][]string)
+	for name, dependsOn := range graph {
+		pending[name] += 0
+		for _, dependency := range dependsOn {
+			if _, exists := graph[dependency]; !exists {
+				// Dependencies removed meanwhile do not hold anything up
+				continue
+			}
+			pending[name]++
+			dependents[dependency] = append(dependents[dependency], name)
+		}
+	}
+
+	var ready []string
+	for name, count := range pending {
+		if count == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	var order []string
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, dependent := range dependents[name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(order) < len(graph) {
+		var cycle []string
+		for name, count := range pending {
+			if count > 0 {
+				cycle = append(cycle, name)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("dependency cycle between %s", strings.Join(cycle, ", "))
+	}
+	return order, nil
+}
+
+// waitForDependencies waits until each dependency of a service has a
+// healthy running instance, failing once the dependency timeout passes
+func (o *Orchestrator) waitForDependencies(name string, dependsOn []string) error {
+	deadline := time.Now().Add(o.dependencyTimeout)
+	for _, dependency := range dependsOn {
+		if o.dependencyReady(dependency) {
+			continue
+		}
+
+		o.recordEvent("Normal", "WaitingOnDependency",
+			fmt.Sprintf("Service %s is waiting on dependency %s", name, dependency))
+		for !o.dependencyReady(dependency) {
+			if time.Now().After(deadline) {
+				err := fmt.Errorf("timed out after %s waiting on dependency %s to become healthy",
+					o.dependencyTimeout, dependency)
+				o.recordEvent("Warning", "DependencyTimeout", fmt.Sprintf("Service %s %v", name, err))
+				return err
+			}
+			select {
+			case <-o.ctx.Done():
+				return o.ctx.Err()
+			case <-time.After(o.healthInterval):
+			}
+		}
+		o.recordEvent("Normal", "DependencyReady",
+			fmt.Sprintf("Dependency %s of service %s is healthy", dependency, name))
+	}
+	return nil
+}
+
+// dependencyReady reports whether a service has a healthy running instance
+func (o *Orchestrator) dependencyReady(name string) bool {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	for _, service := range o.services {
+		if service.Name == name && service.Status == "running" && service.Health == "healthy" {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreServices recreates the instances of services that have none, such
+// as after a restart, from their latest successful deployment. It returns
+// the restored instances. Callers hold o.mutex.
+func (o *Orchestrator) restoreServices() []*ServiceInstance {
+	present := make(map[string]bool)
+	for _, service := range o.services {
+		present[service.Name] = true
+	}
+
+	latest := make(map[string]*Deployment)
+	for _, deployment := range o.deployments {
+		if deployment.Status != "deployed" || deployment.Request == nil || present[deployment.ServiceName] {
+			continue
+		}
+		if current, exists := latest[deployment.ServiceName]; !exists || deployment.CreatedAt.After(current.CreatedAt) {
+			latest[deployment.ServiceName] = deployment
+		}
+	}
+
+	var restored []*ServiceInstance
+	for _, deployment := range latest {
+		instances, _ := newInstances(*deployment.Request, deployment.ServiceID)
+		var placement []string
+		if o.cluster != nil {
+			// Instances no node has room for are left on the local node
+			placement, _ = o.schedule(*deployment.Request)
+		}
+		for i, service := range instances {
+			if placement != nil {
+				service.NodeID = placement[i]
+			}
+			service.Status = "stopped"
+			o.services[service.ID] = service
+			restored = append(restored, service)
+		}
+	}
+	return restored
+}
+
+// startable reports whether an instance is down and not being started or
+// stopped by anything else
+func startable(service *ServiceInstance) bool {
+	return service.Status == "stopped" || service.Status == "exited" || service.Status == "failed"
+}
+
+// startInOrder starts the instances that are down, service by service in
+// dependency order, each once its dependencies are healthy. Services being
+// deployed are left to their rollout.
+func (o *Orchestrator) startInOrder(order []string) {
+	for _, name := range order {
+		o.mutex.Lock()
+		if o.deploying(name) {
+			o.mutex.Unlock()
+			continue
+		}
+		var stopped []*ServiceInstance
+		var dependsOn []string
+		for _, service := range o.services {
+			if service.Name == name && startable(service) {
+				o.setInstanceStatus(service, "starting")
+				stopped = append(stopped, service)
+				dependsOn = service.DependsOn
+			}
+		}
+		o.mutex.Unlock()
+		if len(stopped) == 0 {
+			continue
+		}
+
+		if err := o.waitForDependencies(name, dependsOn); err != nil {
+			log.Printf("❌ Not starting service %s: %v", name, err)
+			o.mutex.Lock()
+			for _, service := range stopped {
+				o.setInstanceStatus(service, "failed")
+				service.Health = "unhealthy"
+			}
+			o.mutex.Unlock()
+			continue
+		}
+
+		sort.Slice(stopped, func(i, j int) bool { return stopped[i].ID < stopped[j].ID })
+		for _, service := range stopped {
+			err := o.startInstance(service)
+			if err == nil {
+				err = o.waitHealthy(service, defaultHealthCheckFailures)
+			}
+			if err != nil {
+				log.Printf("❌ Failed to start service instance %s: %v", service.ID, err)
+			}
+		}
+	}
+}
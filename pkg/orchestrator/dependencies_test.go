@@ -0,0 +1,180 @@
+package orchestrator
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDependencyOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph map[string][]string
+		order []string
+		err   string
+	}{
+		{
+			name:  "chain",
+			graph: map[string][]string{"api": {"cache"}, "cache": {"db"}, "db": nil},
+			order: []string{"db", "cache", "api"},
+		},
+		{
+			name:  "diamond",
+			graph: map[string][]string{"api": {"queue", "cache"}, "cache": {"db"}, "queue": {"db"}, "db": nil},
+			order: []string{"db", "cache", "queue", "api"},
+		},
+		{
+			name:  "removed dependency",
+			graph: map[string][]string{"api": {"db"}},
+			order: []string{"api"},
+		},
+		{
+			name:  "cycle",
+			graph: map[string][]string{"api": {"cache"}, "cache": {"db"}, "db": {"api"}, "web": {"api"}},
+			err:   "dependency cycle between api, cache, db, web",
+		},
+		{
+			name:  "self",
+			graph: map[string][]string{"api": {"api"}},
+			err:   "dependency cycle between api",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := dependencyOrder(tt.graph)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.order, order)
+		})
+	}
+}
+
+// deployService deploys a service and waits for the deployment to reach a
+// status
+func deployService(t *testing.T, o *Orchestrator, req DeployRequest, status string) string {
+	code, response := serveJSON(t, setupTestRouter(o), http.MethodPost, "/deploy", req)
+	require.Equal(t, http.StatusCreated, code, response)
+	id := response["deployment_id"].(string)
+	waitForStatus(t, o, id, status)
+	return id
+}
+
+// eventReasons returns the reasons of the cluster events, oldest first
+func eventReasons(t *testing.T, o *Orchestrator) []string {
+	code, response := serveJSON(t, setupTestRouter(o), http.MethodGet, "/cluster/events", nil)
+	require.Equal(t, http.StatusOK, code)
+
+	events := response["events"].([]interface{})
+	reasons := make([]string, len(events))
+	for i, event := range events {
+		reasons[len(events)-1-i] = event.(map[string]interface{})["reason"].(string)
+	}
+	return reasons
+}
+
+func TestDeployInvalidDependencies(t *testing.T) {
+	o, r, _ := startStrategyTest(t)
+
+	deployService(t, o, DeployRequest{Name: "db", Image: "db:1"}, "deployed")
+	deployService(t, o, DeployRequest{Name: "api", Image: "api:1", DependsOn: []string{"db"}}, "deployed")
+
+	tests := []struct {
+		req DeployRequest
+		err string
+	}{
+		{DeployRequest{Name: "web", Image: "web:1", DependsOn: []string{"cache"}}, "dependency cache does not exist"},
+		{DeployRequest{Name: "web", Image: "web:1", DependsOn: []string{"web"}}, "dependency cycle between web"},
+		{DeployRequest{Name: "db", Image: "db:2", DependsOn: []string{"api"}}, "dependency cycle between api, db"},
+	}
+	for _, tt := range tests {
+		code, response := serveJSON(t, r, http.MethodPost, "/deploy", tt.req)
+		assert.Equal(t, http.StatusBadRequest, code, tt.req)
+		assert.Equal(t, tt.err, response["error"])
+	}
+}
+
+func TestDeployWaitsForDependencies(t *testing.T) {
+	o, r, runtime := startStrategyTest(t)
+
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "db", Image: "db:slow"})
+	require.Equal(t, http.StatusCreated, code, response)
+	db := response["deployment_id"].(string)
+
+	// A dependency being deployed counts as existing
+	code, response = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "api", Image: "api:1", DependsOn: []string{"db"}})
+	require.Equal(t, http.StatusCreated, code, response)
+	api := response["deployment_id"].(string)
+
+	assert.Eventually(t, func() bool {
+		_, response := serveJSON(t, r, http.MethodGet, "/deployments/"+api, nil)
+		progress, _ := response["progress"].(map[string]interface{})
+		return progress["phase"] == PhaseWaiting
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotContains(t, runtime.images(), "api-0")
+
+	close(runtime.release)
+	waitForStatus(t, o, db, "deployed")
+	waitForStatus(t, o, api, "deployed")
+	assert.Equal(t, map[string]string{"db-0": "db:slow", "api-0": "api:1"}, runtime.images())
+	assert.Equal(t, []string{"WaitingOnDependency", "DependencyReady"}, eventReasons(t, o))
+
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	assert.Contains(t, o.deployments[api].Logs, "Waiting on dependencies db")
+	assert.Equal(t, []string{"db"}, o.services["api-0"].DependsOn)
+}
+
+func TestDependencyTimeout(t *testing.T) {
+	o, r, runtime := startStrategyTest(t)
+	o.dependencyTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { close(runtime.release) })
+
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "db", Image: "db:slow"})
+	require.Equal(t, http.StatusCreated, code, response)
+
+	api := deployService(t, o, DeployRequest{Name: "api", Image: "api:1", DependsOn: []string{"db"}}, "failed")
+	assert.NotContains(t, runtime.images(), "api-0")
+	assert.Equal(t, []string{"WaitingOnDependency", "DependencyTimeout"}, eventReasons(t, o))
+
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	assert.Equal(t, "timed out after 50ms waiting on dependency db to become healthy", o.deployments[api].Error)
+}
+
+func TestSyncServicesStartsInDependencyOrder(t *testing.T) {
+	cfg := setupDeploymentTest(t)
+	_, o, _ := startTestOrchestrator(t, cfg)
+
+	deployService(t, o, DeployRequest{Name: "db", Image: "db:1"}, "deployed")
+	deployService(t, o, DeployRequest{Name: "queue", Image: "queue:1", DependsOn: []string{"db"}}, "deployed")
+	deployService(t, o, DeployRequest{Name: "cache", Image: "cache:1", DependsOn: []string{"db"}}, "deployed")
+	deployService(t, o, DeployRequest{Name: "api", Image: "api:1", DependsOn: []string{"queue", "cache"}}, "deployed")
+	o.Stop()
+
+	// After a restart the services are restored from their deployments and
+	// started once their dependencies are healthy
+	_, o, r := startTestOrchestrator(t, cfg)
+	runtime := newFakeRuntime()
+	o.SetRuntime(runtime)
+	o.healthInterval = time.Millisecond
+
+	code, response := serveJSON(t, r, http.MethodPost, "/sync", nil)
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, float64(4), response["restored_count"])
+	assert.Equal(t, float64(4), response["synced_count"])
+	assert.Equal(t, []interface{}{"db", "cache", "queue", "api"}, response["order"])
+
+	assert.Eventually(t, func() bool {
+		o.mutex.RLock()
+		defer o.mutex.RUnlock()
+		return o.services["api-0"].Health == "healthy"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"start db-0 db:1", "start cache-0 cache:1", "start queue-0 queue:1", "start api-0 api:1"}, runtime.takeEvents())
+}
@@ -0,0 +1,263 @@
+package orchestrator
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// recentDeployments is how many deployment records are reloaded on start
+const recentDeployments = 100
+
+// finished reports whether a deployment status is final
+func finished(status string) bool {
+	return status == "deployed" || status == "failed" || status == "rolled_back"
+}
+
+// loadDeployments reloads the most recent deployment records. Deployments
+// still in progress were interrupted by the restart and are marked failed.
+func (o *Orchestrator) loadDeployments() error {
+	if o.records == nil {
+		return nil
+	}
+
+	records, err := o.records.ListRecent(recentDeployments)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		deployment, err := deploymentFromRecord(record)
+		if err != nil {
+			log.Printf("⚠️  Skipping deployment %s: %v", record.ID, err)
+			continue
+		}
+		if !finished(deployment.Status) {
+			o.setDeploymentStatus(deployment, "failed", "interrupted by orchestrator restart")
+		}
+		o.deployments[deployment.ID] = deployment
+	}
+
+	log.Printf("📦 Loaded %d deployment records", len(records))
+	return nil
+}
+
+// findDeployment returns a deployment from memory, or from its record if it
+// is older than the reloaded ones, or nil if there is none. Callers hold
+// o.mutex.
+func (o *Orchestrator) findDeployment(id string) (*Deployment, error) {
+	if deployment, exists := o.deployments[id]; exists {
+		return deployment, nil
+	}
+	if o.records == nil {
+		return nil, nil
+	}
+
+	record, err := o.records.GetByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return deploymentFromRecord(record)
+}
+
+// errDeploymentNotFound is returned when deleting a deployment that does not exist
+var errDeploymentNotFound = errors.New("deployment not found")
+
+// deleteDeployment deletes a deployment and its record
+func (o *Orchestrator) deleteDeployment(id string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	deployment, err := o.findDeployment(id)
+	if err != nil {
+		return err
+	}
+	if deployment == nil {
+		return errDeploymentNotFound
+	}
+
+	if o.records != nil {
+		if err := o.records.Delete(id); err != nil {
+			return err
+		}
+	}
+	delete(o.deployments, id)
+	return nil
+}
+
+// rollbackTarget returns the deployment to roll a deployment back to: the
+// newest successful one of the same service before it, or the one with the
+// given revision if it is positive. It returns nil if there is none.
+func (o *Orchestrator) rollbackTarget(deployment *Deployment, revision int) (*Deployment, error) {
+	if o.records == nil || deployment.ServiceID == "" {
+		return nil, nil
+	}
+
+	records, err := o.records.ListByService(deployment.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Version >= deployment.Revision || record.Status != "deployed" {
+			continue
+		}
+		if revision > 0 && record.Version != revision {
+			continue
+		}
+		return deploymentFromRecord(record)
+	}
+	return nil, nil
+}
+
+// recordDeployment writes a new deployment through to the database, setting
+// its service ID and revision. The service is registered if this is its
+// first deployment.
+func (o *Orchestrator) recordDeployment(deployment *Deployment) error {
+	if o.records == nil {
+		return nil
+	}
+
+	req := deployment.Request
+	services := o.db.ServiceRepository()
+	service, err := services.GetByName(req.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		service = &database.Service{
+			Name:        req.Name,
+			Image:       req.Image,
+			Port:        req.Port,
+			Replicas:    req.Replicas,
+			Status:      "stopped",
+			Environment: req.Environment,
+			Version:     1,
+		}
+		err = services.Create(service)
+	} else if err == nil && req.Port != 0 && service.Port != req.Port {
+		// The record keeps the port the service is deployed on
+		service.Port = req.Port
+		err = services.Update(service)
+	}
+	if err != nil {
+		return err
+	}
+
+	config, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal deploy config: %w", err)
+	}
+	logs, err := json.Marshal(deployment.Logs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal deployment logs: %w", err)
+	}
+
+	record := &database.Deployment{
+		ID:        deployment.ID,
+		ServiceID: service.ID,
+		Status:    deployment.Status,
+		Strategy:  deployment.Strategy,
+		Config:    string(config),
+		Logs:      string(logs),
+		StartedAt: deployment.CreatedAt,
+	}
+	if req.TriggerSource != "" {
+		record.TriggerSource = &req.TriggerSource
+	}
+	if req.TriggeredBy != "" {
+		record.TriggeredBy = &req.TriggeredBy
+	}
+	if req.Notes != "" {
+		record.Notes = &req.Notes
+	}
+	if err := o.records.Create(record); err != nil {
+		return err
+	}
+
+	deployment.ServiceID = service.ID
+	deployment.Revision = record.Version
+	return nil
+}
+
+// setDeploymentStatus updates a deployment's status in memory and in its
+// record, and publishes it. Callers hold o.mutex.
+func (o *Orchestrator) setDeploymentStatus(deployment *Deployment, status, message string) {
+	now := time.Now()
+	deployment.Status = status
+	deployment.UpdatedAt = now
+	if message != "" {
+		deployment.Error = message
+	}
+
+	var finishedAt *time.Time
+	if finished(status) {
+		deployment.FinishedAt = &now
+		finishedAt = &now
+	}
+	o.publishProgress(deployment)
+
+	if o.records == nil {
+		return
+	}
+	var errorMessage *string
+	if message != "" {
+		errorMessage = &message
+	}
+	if err := o.records.UpdateStatus(deployment.ID, status, finishedAt, errorMessage); err != nil {
+		log.Printf("❌ Failed to record status of deployment %s: %v", deployment.ID, err)
+	}
+}
+
+// logDeployment appends a line to a deployment's log in memory and in its
+// record. Callers hold o.mutex.
+func (o *Orchestrator) logDeployment(deployment *Deployment, line string) {
+	deployment.Logs = append(deployment.Logs, line)
+	deployment.UpdatedAt = time.Now()
+
+	if o.records == nil {
+		return
+	}
+	if err := o.records.AppendLog(deployment.ID, line); err != nil {
+		log.Printf("❌ Failed to record log of deployment %s: %v", deployment.ID, err)
+	}
+}
+
+// deploymentFromRecord converts a deployment record, whose config holds the
+// deploy request, back into a Deployment
+func deploymentFromRecord(record *database.Deployment) (*Deployment, error) {
+	var req DeployRequest
+	if err := json.Unmarshal([]byte(record.Config), &req); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal deploy config: %w", err)
+	}
+	logs, err := record.LogLines()
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal deployment logs: %w", err)
+	}
+
+	deployment := &Deployment{
+		ID:          record.ID,
+		ServiceID:   record.ServiceID,
+		ServiceName: req.Name,
+		Version:     "latest",
+		Revision:    record.Version,
+		Status:      record.Status,
+		Strategy:    record.Strategy,
+		Config:      req.Config,
+		Request:     &req,
+		CreatedAt:   record.StartedAt,
+		UpdatedAt:   record.StartedAt,
+		FinishedAt:  record.FinishedAt,
+		Logs:        logs,
+	}
+	if record.UpdatedAt != nil {
+		deployment.UpdatedAt = *record.UpdatedAt
+	}
+	if record.ErrorMessage != nil {
+		deployment.Error = *record.ErrorMessage
+	}
+	return deployment, nil
+}
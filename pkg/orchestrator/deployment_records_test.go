@@ -0,0 +1,270 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// setupDeploymentTest returns a config for a database file that outlives
+// the orchestrators of a test
+func setupDeploymentTest(t *testing.T) *config.Config {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	return &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(dir, "console.db")},
+		},
+		Orchestrator: config.OrchestratorConfig{
+			ServiceLogs: config.ServiceLogsConfig{Dir: filepath.Join(dir, "logs")},
+		},
+	}
+}
+
+// startTestOrchestrator opens the database and starts an orchestrator on
+// it, whose simulated instances deploy immediately
+func startTestOrchestrator(t *testing.T, cfg *config.Config) (*database.DB, *Orchestrator, *gin.Engine) {
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+
+	o := New(db, cfg)
+	o.deployDelay = 0
+	require.NoError(t, o.Start())
+	t.Cleanup(func() {
+		o.Stop()
+		_ = db.Close()
+	})
+	return db, o, setupTestRouter(o)
+}
+
+// serveJSON sends a request to the router and decodes the response
+func serveJSON(t *testing.T, r *gin.Engine, method, path string, body interface{}) (int, map[string]interface{}) {
+	var reader *strings.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = strings.NewReader(string(data))
+	} else {
+		reader = strings.NewReader("")
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w.Code, response
+}
+
+// deployAndWait deploys an image of service "web" and waits until the
+// deployment succeeded
+func deployAndWait(t *testing.T, o *Orchestrator, r *gin.Engine, image string) string {
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "web", Image: image, Port: 8080})
+	require.Equal(t, http.StatusCreated, code)
+	id := response["deployment_id"].(string)
+	waitForStatus(t, o, id, "deployed")
+	return id
+}
+
+// waitForStatus waits until a deployment has a status
+func waitForStatus(t *testing.T, o *Orchestrator, id, status string) {
+	require.Eventually(t, func() bool {
+		o.mutex.RLock()
+		defer o.mutex.RUnlock()
+		return o.deployments[id] != nil && o.deployments[id].Status == status
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDeploymentsPersistAcrossRestart(t *testing.T) {
+	cfg := setupDeploymentTest(t)
+
+	db, o, r := startTestOrchestrator(t, cfg)
+	deployed := deployAndWait(t, o, r, "web:1")
+
+	// A deployment the orchestrator stops in the middle of
+	service, err := db.ServiceRepository().GetByName("web")
+	require.NoError(t, err)
+	interrupted := &database.Deployment{ServiceID: service.ID, Status: "deploying", Config: `{"name":"web","image":"web:2"}`}
+	require.NoError(t, db.DeploymentRepository().Create(interrupted))
+
+	o.Stop()
+	require.NoError(t, db.Close())
+
+	_, o, r = startTestOrchestrator(t, cfg)
+
+	code, response := serveJSON(t, r, http.MethodGet, "/deployments", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(2), response["total"])
+
+	var deployment Deployment
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/deployments/"+deployed, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &deployment))
+	assert.Equal(t, "web", deployment.ServiceName)
+	assert.Equal(t, "deployed", deployment.Status)
+	assert.Equal(t, 1, deployment.Revision)
+	assert.Equal(t, "web:1", deployment.Request.Image)
+	assert.NotNil(t, deployment.FinishedAt)
+	require.Len(t, deployment.Logs, 3)
+	assert.Contains(t, deployment.Logs[2], "Service web-0 deployed successfully")
+
+	o.mutex.RLock()
+	reloaded := o.deployments[interrupted.ID]
+	o.mutex.RUnlock()
+	require.NotNil(t, reloaded)
+	assert.Equal(t, "failed", reloaded.Status)
+	assert.Equal(t, "interrupted by orchestrator restart", reloaded.Error)
+
+	// Deleting writes through as well
+	code, _ = serveJSON(t, r, http.MethodDelete, "/deployments/"+deployed, nil)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = serveJSON(t, r, http.MethodGet, "/deployments/"+deployed, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestRollbackDeploymentToRevision(t *testing.T) {
+	cfg := setupDeploymentTest(t)
+	db, o, r := startTestOrchestrator(t, cfg)
+
+	var ids []string
+	for i := 1; i <= 3; i++ {
+		ids = append(ids, deployAndWait(t, o, r, fmt.Sprintf("web:%d", i)))
+	}
+
+	code, response := serveJSON(t, r, http.MethodPost, "/deployments/"+ids[2]+"/rollback?revision=1", nil)
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, float64(1), response["target_revision"])
+	assert.Equal(t, float64(4), response["revision"])
+	rollback := response["rollback_deployment_id"].(string)
+	waitForStatus(t, o, rollback, "deployed")
+
+	record, err := db.DeploymentRepository().GetByID(rollback)
+	require.NoError(t, err)
+	assert.Contains(t, record.Config, `"image":"web:1"`)
+	record, err = db.DeploymentRepository().GetByID(ids[2])
+	require.NoError(t, err)
+	assert.Equal(t, "rolled_back", record.Status)
+	assert.NotNil(t, record.FinishedAt)
+
+	// Rolling back is refused to a deployment that did not succeed, to a
+	// later one, and for a deployment that is already rolled back
+	code, _ = serveJSON(t, r, http.MethodPost, "/deployments/"+rollback+"/rollback?revision=3", nil)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = serveJSON(t, r, http.MethodPost, "/deployments/"+ids[0]+"/rollback?revision=2", nil)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = serveJSON(t, r, http.MethodPost, "/deployments/"+ids[2]+"/rollback", nil)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = serveJSON(t, r, http.MethodPost, "/deployments/"+rollback+"/rollback?revision=first", nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// By default the previous successful deployment is redeployed, found in
+	// the database after a restart
+	o.Stop()
+	require.NoError(t, db.Close())
+	_, o, r = startTestOrchestrator(t, cfg)
+
+	code, response = serveJSON(t, r, http.MethodPost, "/deployments/"+rollback+"/rollback", nil)
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, float64(2), response["target_revision"])
+	assert.Equal(t, float64(5), response["revision"])
+	waitForStatus(t, o, response["rollback_deployment_id"].(string), "deployed")
+
+	code, _ = serveJSON(t, r, http.MethodPost, "/deployments/unknown/rollback", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestBulkDeleteDeployments(t *testing.T) {
+	cfg := setupDeploymentTest(t)
+	db, o, r := startTestOrchestrator(t, cfg)
+
+	first := deployAndWait(t, o, r, "nginx:1.25")
+	second := deployAndWait(t, o, r, "nginx:1.26")
+	third := deployAndWait(t, o, r, "nginx:1.27")
+
+	// Missing deployments fail without stopping the others
+	code, response := serveJSON(t, r, http.MethodPost, "/deployments/bulk", gin.H{
+		"action": "delete",
+		"ids":    []string{first, "missing", first},
+	})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), response["succeeded"])
+	assert.Equal(t, float64(1), response["failed"])
+	results := response["results"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"success": true, "status": "deleted"}, results[first])
+	assert.Equal(t, map[string]interface{}{"success": false, "error": "deployment not found"}, results["missing"])
+
+	_, err := db.DeploymentRepository().GetByID(first)
+	assert.Error(t, err, "the record is deleted too")
+
+	// A selector matches the loaded deployments
+	code, response = serveJSON(t, r, http.MethodPost, "/deployments/bulk", gin.H{
+		"action":   "delete",
+		"selector": gin.H{"service_name": "web", "status": "deployed"},
+	})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(2), response["succeeded"])
+	assert.Contains(t, response["results"], second)
+	assert.Contains(t, response["results"], third)
+	o.mutex.RLock()
+	assert.Empty(t, o.deployments)
+	o.mutex.RUnlock()
+
+	for _, body := range []gin.H{
+		{"action": "rollback", "ids": []string{second}},
+		{"action": "delete"},
+		{"action": "delete", "selector": gin.H{}},
+		{"action": "delete", "ids": []string{second}, "selector": gin.H{"status": "failed"}},
+	} {
+		code, _ = serveJSON(t, r, http.MethodPost, "/deployments/bulk", body)
+		assert.Equal(t, http.StatusBadRequest, code, body)
+	}
+}
+
+func TestDeployServiceDryRun(t *testing.T) {
+	_, o, r := startTestOrchestrator(t, setupDeploymentTest(t))
+	deployAndWait(t, o, r, "web:1")
+
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy?dry_run=true", DeployRequest{
+		Name: "web", Image: "web:2", Port: 8080, Replicas: 1, Environment: map[string]string{"MODE": "fast"},
+	})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response["dry_run"])
+	assert.Equal(t, true, response["exists"])
+	assert.Equal(t, true, response["redeploy"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"field": "env.MODE", "from": nil, "to": "fast", "redeploy": true},
+		map[string]interface{}{"field": "image", "from": "web:1", "to": "web:2", "redeploy": true},
+		map[string]interface{}{"field": "replicas", "from": nil, "to": float64(1), "redeploy": false},
+	}, response["changes"])
+
+	// Nothing was deployed
+	code, response = serveJSON(t, r, http.MethodGet, "/deployments", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), response["total"])
+
+	code, response = serveJSON(t, r, http.MethodPost, "/deploy?dry_run=1", DeployRequest{Name: "api", Image: "api:1", Port: 9090})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, response["exists"])
+	assert.Len(t, response["changes"], 3, "a new service has every field it sets")
+
+	code, _ = serveJSON(t, r, http.MethodPost, "/deploy?dry_run=maybe", DeployRequest{Name: "api", Image: "api:1", Port: 9090})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = serveJSON(t, r, http.MethodPost, "/deploy?dry_run=true", DeployRequest{Name: "api", Port: 9090})
+	assert.Equal(t, http.StatusBadRequest, code, "dry runs are validated")
+}
@@ -0,0 +1,286 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Docker Engine API defaults
+const (
+	DefaultDockerHost = "unix:///var/run/docker.sock"
+	dockerAPIVersion  = "v1.41"
+)
+
+// errDockerNotFound is returned by Docker clients for images and containers
+// that do not exist
+var errDockerNotFound = errors.New("not found in docker")
+
+// DockerClient is the part of the Docker Engine API the Docker runtime uses
+type DockerClient interface {
+	ImageExists(ctx context.Context, image string) (bool, error)
+	PullImage(ctx context.Context, image string) error
+	CreateContainer(ctx context.Context, name string, config *ContainerConfig) (string, error)
+	StartContainer(ctx context.Context, id string) error
+	StopContainer(ctx context.Context, id string, timeout time.Duration) error
+	RemoveContainer(ctx context.Context, id string) error
+	InspectContainer(ctx context.Context, id string) (*ContainerState, error)
+	// ContainerLogs follows a container's output written after since, in
+	// the multiplexed format of containers without a TTY
+	ContainerLogs(ctx context.Context, id string, since time.Time) (io.ReadCloser, error)
+}
+
+// ContainerConfig is the body of a container create request
+type ContainerConfig struct {
+	Image        string              `json:"Image"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig   HostConfig          `json:"HostConfig"`
+}
+
+// HostConfig holds the host side settings of a container
+type HostConfig struct {
+	PortBindings  map[string][]PortBinding `json:"PortBindings,omitempty"`
+	RestartPolicy RestartPolicy            `json:"RestartPolicy"`
+	NanoCPUs      int64                    `json:"NanoCpus,omitempty"`
+	Memory        int64                    `json:"Memory,omitempty"`
+}
+
+// PortBinding publishes a container port on the host
+type PortBinding struct {
+	HostIP   string `json:"HostIp,omitempty"`
+	HostPort string `json:"HostPort"`
+}
+
+// RestartPolicy is a container's restart policy
+type RestartPolicy struct {
+	Name string `json:"Name"`
+}
+
+// ContainerState is the state of a container as inspected
+type ContainerState struct {
+	Status       string `json:"Status"` // created, running, restarting, removing, paused, exited or dead
+	ExitCode     int    `json:"ExitCode"`
+	OOMKilled    bool   `json:"OOMKilled"`
+	RestartCount int    `json:"-"` // of the container rather than its state, set by InspectContainer
+}
+
+// dockerEngine is a DockerClient talking to the Docker Engine API over HTTP
+type dockerEngine struct {
+	client *http.Client
+	base   string
+}
+
+// NewDockerClient creates a client of the Docker Engine API at host, a
+// unix:// socket or a tcp:// address. An empty host is DefaultDockerHost.
+func NewDockerClient(host string) (DockerClient, error) {
+	if host == "" {
+		host = DefaultDockerHost
+	}
+
+	transport := &http.Transport{}
+	var base string
+	switch {
+	case strings.HasPrefix(host, "unix://"):
+		socket := strings.TrimPrefix(host, "unix://")
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		base = "http://docker"
+	case strings.HasPrefix(host, "tcp://"):
+		base = "http://" + strings.TrimPrefix(host, "tcp://")
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q, expected unix:// or tcp://", host)
+	}
+
+	return &dockerEngine{
+		client: &http.Client{Transport: transport},
+		base:   base + "/" + dockerAPIVersion,
+	}, nil
+}
+
+// ImageExists reports whether an image is present locally
+func (d *dockerEngine) ImageExists(ctx context.Context, image string) (bool, error) {
+	resp, err := d.do(ctx, http.MethodGet, "/images/"+image+"/json", nil, nil)
+	if errors.Is(err, errDockerNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// PullImage pulls an image, the latest tag if it has none, and waits for
+// the pull to finish
+func (d *dockerEngine) PullImage(ctx context.Context, image string) error {
+	query := url.Values{"fromImage": {image}}
+	if !strings.Contains(image, "@") && !strings.Contains(image[strings.LastIndex(image, "/")+1:], ":") {
+		query.Set("tag", "latest")
+	}
+
+	resp, err := d.do(ctx, http.MethodPost, "/images/create", query, nil)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, err)
+	}
+	defer resp.Body.Close()
+
+	// Progress is streamed as JSON messages; failures are reported in them
+	// after the response status
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to pull image %s: %w", image, err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("failed to pull image %s: %s", image, message.Error)
+		}
+	}
+}
+
+// CreateContainer creates a container and returns its ID
+func (d *dockerEngine) CreateContainer(ctx context.Context, name string, config *ContainerConfig) (string, error) {
+	resp, err := d.do(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, config)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("failed to decode created container %s: %w", name, err)
+	}
+	return created.ID, nil
+}
+
+// StartContainer starts a container
+func (d *dockerEngine) StartContainer(ctx context.Context, id string) error {
+	resp, err := d.do(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start container %s: %w", id, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// StopContainer stops a container, which Docker kills if it has not exited
+// after timeout
+func (d *dockerEngine) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	query := url.Values{"t": {strconv.Itoa(int(timeout.Round(time.Second).Seconds()))}}
+	resp, err := d.do(ctx, http.MethodPost, "/containers/"+id+"/stop", query, nil)
+	if err != nil {
+		return fmt.Errorf("failed to stop container %s: %w", id, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RemoveContainer removes a container, killing it if it is running
+func (d *dockerEngine) RemoveContainer(ctx context.Context, id string) error {
+	resp, err := d.do(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {"true"}}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to remove container %s: %w", id, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// InspectContainer returns the state of a container
+func (d *dockerEngine) InspectContainer(ctx context.Context, id string) (*ContainerState, error) {
+	resp, err := d.do(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+
+	var inspected struct {
+		State        ContainerState `json:"State"`
+		RestartCount int            `json:"RestartCount"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspected); err != nil {
+		return nil, fmt.Errorf("failed to decode container %s: %w", id, err)
+	}
+	inspected.State.RestartCount = inspected.RestartCount
+	return &inspected.State, nil
+}
+
+// ContainerLogs follows the stdout and stderr of a container
+func (d *dockerEngine) ContainerLogs(ctx context.Context, id string, since time.Time) (io.ReadCloser, error) {
+	query := url.Values{"follow": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	if !since.IsZero() {
+		query.Set("since", fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond()))
+	}
+
+	resp, err := d.do(ctx, http.MethodGet, "/containers/"+id+"/logs", query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to follow logs of container %s: %w", id, err)
+	}
+	return resp.Body, nil
+}
+
+// do sends a request to the API. Error responses are returned as errors,
+// wrapping errDockerNotFound for 404.
+func (d *dockerEngine) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := d.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var apiError struct {
+		Message string `json:"message"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&apiError)
+	if apiError.Message == "" {
+		apiError.Message = resp.Status
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", errDockerNotFound, apiError.Message)
+	}
+	return nil, errors.New(apiError.Message)
+}
@@ -0,0 +1,389 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+// Labels set on the containers of service instances
+const (
+	labelService  = "infra-core.service"
+	labelInstance = "infra-core.instance"
+)
+
+// containerPrefix prefixes the names of the containers of service
+// instances, which are named after the instance, so replicas get the
+// instance's -N suffix
+const containerPrefix = "infra-core-"
+
+// DockerRuntimeOptions configures a DockerRuntime. Zero values use the
+// defaults.
+type DockerRuntimeOptions struct {
+	GracePeriod time.Duration // how long Stop waits before Docker kills a container
+}
+
+// DockerRuntime runs service instances as Docker containers of their image,
+// with their output captured into the service logs. Restarts are left to
+// Docker, following the instance's restart policy.
+type DockerRuntime struct {
+	client     DockerClient
+	logs       *LogCollector
+	opts       DockerRuntimeOptions
+	mutex      sync.Mutex
+	containers map[string]*container
+}
+
+// container is the container of a service instance. Its fields are
+// guarded by the runtime's mutex.
+type container struct {
+	id      string // empty while the container is being created
+	name    string
+	logKey  string
+	stopped bool
+	cancel  context.CancelFunc // ends following the container's logs
+	done    chan struct{}      // closed once the logs are no longer followed
+}
+
+// NewDockerRuntime creates a Docker runtime using client, capturing output
+// with logs, which may be nil to discard it
+func NewDockerRuntime(client DockerClient, logs *LogCollector, opts DockerRuntimeOptions) *DockerRuntime {
+	if opts.GracePeriod <= 0 {
+		opts.GracePeriod = defaultStopGracePeriod
+	}
+
+	return &DockerRuntime{
+		client:     client,
+		logs:       logs,
+		opts:       opts,
+		containers: make(map[string]*container),
+	}
+}
+
+// Start pulls a service instance's image if it is absent, then creates and
+// starts its container. A container left over under the same name is
+// replaced.
+func (r *DockerRuntime) Start(ctx context.Context, service *ServiceInstance) error {
+	if service.Image == "" {
+		return fmt.Errorf("service instance %s has no image to run", service.ID)
+	}
+	config, err := containerConfig(service)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	if c, exists := r.containers[service.ID]; exists && !c.stopped {
+		r.mutex.Unlock()
+		return fmt.Errorf("service instance %s is already running", service.ID)
+	}
+	c := &container{name: containerPrefix + service.ID, logKey: logKey(service)}
+	r.containers[service.ID] = c
+	r.mutex.Unlock()
+
+	id, err := r.create(ctx, c.name, config)
+	if err != nil {
+		r.mutex.Lock()
+		delete(r.containers, service.ID)
+		r.mutex.Unlock()
+		return err
+	}
+
+	r.mutex.Lock()
+	c.id = id
+	c.done = make(chan struct{})
+	if r.logs != nil {
+		var follow context.Context
+		follow, c.cancel = context.WithCancel(context.Background())
+		go r.followLogs(follow, c)
+	} else {
+		c.cancel = func() {}
+		close(c.done)
+	}
+	r.mutex.Unlock()
+
+	log.Printf("🐳 Started service instance %s (container %s)", service.ID, shortID(id))
+	return nil
+}
+
+// create pulls the image if needed, and creates and starts a container
+func (r *DockerRuntime) create(ctx context.Context, name string, config *ContainerConfig) (string, error) {
+	exists, err := r.client.ImageExists(ctx, config.Image)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		log.Printf("📥 Pulling image %s", config.Image)
+		if err := r.client.PullImage(ctx, config.Image); err != nil {
+			return "", err
+		}
+	}
+
+	if err := r.client.RemoveContainer(ctx, name); err != nil && !errors.Is(err, errDockerNotFound) {
+		return "", err
+	}
+	id, err := r.client.CreateContainer(ctx, name, config)
+	if err != nil {
+		return "", err
+	}
+	if err := r.client.StartContainer(ctx, id); err != nil {
+		_ = r.client.RemoveContainer(context.WithoutCancel(ctx), id)
+		return "", err
+	}
+	return id, nil
+}
+
+// Stop stops an instance's container, which Docker kills if it has not
+// exited after the grace period, and removes it. The container is removed
+// right away once ctx is done.
+func (r *DockerRuntime) Stop(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	c, exists := r.containers[id]
+	if !exists {
+		r.mutex.Unlock()
+		return ErrInstanceNotFound
+	}
+	if c.stopped {
+		r.mutex.Unlock()
+		return nil
+	}
+	if c.id == "" {
+		r.mutex.Unlock()
+		return fmt.Errorf("service instance %s is still being created", id)
+	}
+	r.mutex.Unlock()
+
+	if err := r.client.StopContainer(ctx, c.id, r.opts.GracePeriod); err != nil && !errors.Is(err, errDockerNotFound) && ctx.Err() == nil {
+		return err
+	}
+	if err := r.client.RemoveContainer(context.WithoutCancel(ctx), c.id); err != nil && !errors.Is(err, errDockerNotFound) {
+		return err
+	}
+	c.cancel()
+	<-c.done
+
+	r.mutex.Lock()
+	c.stopped = true
+	r.mutex.Unlock()
+	return nil
+}
+
+// Status reports the status of an instance from the state of its container
+func (r *DockerRuntime) Status(ctx context.Context, id string) (string, error) {
+	r.mutex.Lock()
+	c, exists := r.containers[id]
+	if !exists {
+		r.mutex.Unlock()
+		return "", ErrInstanceNotFound
+	}
+	stopped, containerID := c.stopped, c.id
+	r.mutex.Unlock()
+
+	if stopped {
+		return "stopped", nil
+	}
+	if containerID == "" {
+		return "starting", nil
+	}
+
+	state, err := r.client.InspectContainer(ctx, containerID)
+	if errors.Is(err, errDockerNotFound) {
+		// Removed behind the orchestrator's back
+		return "failed", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return containerStatus(state), nil
+}
+
+// Restarts reports how many times Docker restarted an instance's container
+// and why, as far as the container's state tells
+func (r *DockerRuntime) Restarts(ctx context.Context, id string) (int, string, error) {
+	r.mutex.Lock()
+	c, exists := r.containers[id]
+	if !exists {
+		r.mutex.Unlock()
+		return 0, "", ErrInstanceNotFound
+	}
+	stopped, containerID := c.stopped, c.id
+	r.mutex.Unlock()
+
+	if stopped || containerID == "" {
+		return 0, "", nil
+	}
+	state, err := r.client.InspectContainer(ctx, containerID)
+	if errors.Is(err, errDockerNotFound) {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	switch {
+	case state.RestartCount == 0:
+		return 0, "", nil
+	case state.OOMKilled:
+		return state.RestartCount, "killed out of memory", nil
+	case state.ExitCode != 0:
+		return state.RestartCount, fmt.Sprintf("exited with code %d", state.ExitCode), nil
+	}
+	return state.RestartCount, "restarted by its restart policy", nil
+}
+
+// followLogs captures a container's output until ctx is cancelled or the
+// container exits for good. Docker ends the stream when a container exits,
+// so it is followed again after restarts.
+func (r *DockerRuntime) followLogs(ctx context.Context, c *container) {
+	defer close(c.done)
+
+	stdout := r.logs.Writer(c.logKey, StreamStdout)
+	stderr := r.logs.Writer(c.logKey, StreamStderr)
+	defer stdout.Close()
+	defer stderr.Close()
+
+	var since time.Time
+	for {
+		stream, err := r.client.ContainerLogs(ctx, c.id, since)
+		if err == nil {
+			err = demuxLogs(stream, stdout, stderr)
+			stream.Close()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("⚠️  Failed to follow logs of service instance container %s: %v", c.name, err)
+		}
+		since = time.Now()
+
+		state, err := r.client.InspectContainer(ctx, c.id)
+		if err != nil || state.Status == "exited" || state.Status == "dead" {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// containerStatus maps a container's state to a runtime status
+func containerStatus(state *ContainerState) string {
+	switch state.Status {
+	case "created":
+		return "starting"
+	case "running", "restarting":
+		return state.Status
+	case "removing":
+		return "stopping"
+	case "paused":
+		return "stopped"
+	case "exited":
+		if state.ExitCode == 0 {
+			return "exited"
+		}
+	}
+	return "failed"
+}
+
+// containerConfig returns the container configuration of a service
+// instance. Its command replaces the image's entrypoint and its args the
+// image's command; its port is published on the same host port.
+func containerConfig(service *ServiceInstance) (*ContainerConfig, error) {
+	if !validRestartPolicy(service.RestartPolicy) {
+		return nil, fmt.Errorf("invalid restart policy %q", service.RestartPolicy)
+	}
+	policy := service.RestartPolicy
+	if policy == "" {
+		policy = RestartOnFailure
+	}
+
+	config := &ContainerConfig{
+		Image:      service.Image,
+		Entrypoint: service.Command,
+		Cmd:        service.Args,
+		Labels: map[string]string{
+			labelService:  service.Name,
+			labelInstance: service.ID,
+		},
+		HostConfig: HostConfig{RestartPolicy: RestartPolicy{Name: policy}},
+	}
+
+	keys := make([]string, 0, len(service.Environment))
+	for key := range service.Environment {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		config.Env = append(config.Env, key+"="+service.Environment[key])
+	}
+
+	if service.Port > 0 {
+		port := strconv.Itoa(service.Port) + "/tcp"
+		config.ExposedPorts = map[string]struct{}{port: {}}
+		config.HostConfig.PortBindings = map[string][]PortBinding{
+			port: {{HostPort: strconv.Itoa(service.Port)}},
+		}
+	}
+
+	if service.Resources != nil {
+		cpus, err := spec.ParseCPU(service.Resources.CPU)
+		if err != nil {
+			return nil, err
+		}
+		memory, err := spec.ParseMemory(service.Resources.Memory)
+		if err != nil {
+			return nil, err
+		}
+		config.HostConfig.NanoCPUs = cpus
+		config.HostConfig.Memory = memory
+	}
+
+	return config, nil
+}
+
+// demuxLogs copies the multiplexed output of a container to stdout and
+// stderr. Each frame has an 8 byte header: the stream, 3 bytes of padding
+// and the big endian size of the payload.
+func demuxLogs(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var w io.Writer
+		switch header[0] {
+		case 1:
+			w = stdout
+		case 2:
+			w = stderr
+		default:
+			w = io.Discard
+		}
+		if _, err := io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
+
+// shortID abbreviates a container ID as Docker does
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
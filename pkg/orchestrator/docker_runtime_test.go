@@ -0,0 +1,409 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDocker is an in-memory DockerClient whose containers run until they
+// are stopped and write output once
+type fakeDocker struct {
+	mutex      sync.Mutex
+	images     map[string]bool
+	pulled     []string
+	containers map[string]*fakeContainer
+	created    int
+	output     []byte
+}
+
+type fakeContainer struct {
+	name   string
+	config *ContainerConfig
+	state  ContainerState
+}
+
+func newFakeDocker(images ...string) *fakeDocker {
+	d := &fakeDocker{images: make(map[string]bool), containers: make(map[string]*fakeContainer)}
+	for _, image := range images {
+		d.images[image] = true
+	}
+	return d
+}
+
+func (d *fakeDocker) ImageExists(ctx context.Context, image string) (bool, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.images[image], nil
+}
+
+func (d *fakeDocker) PullImage(ctx context.Context, image string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if strings.HasPrefix(image, "missing") {
+		return fmt.Errorf("failed to pull image %s: manifest unknown", image)
+	}
+	d.images[image] = true
+	d.pulled = append(d.pulled, image)
+	return nil
+}
+
+func (d *fakeDocker) CreateContainer(ctx context.Context, name string, config *ContainerConfig) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, c := range d.containers {
+		if c.name == name {
+			return "", fmt.Errorf("container name %s is already in use", name)
+		}
+	}
+	d.created++
+	id := fmt.Sprintf("%064d", d.created)
+	d.containers[id] = &fakeContainer{name: name, config: config, state: ContainerState{Status: "created"}}
+	return id, nil
+}
+
+func (d *fakeDocker) StartContainer(ctx context.Context, id string) error {
+	return d.update(id, func(c *fakeContainer) { c.state.Status = "running" })
+}
+
+func (d *fakeDocker) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	return d.update(id, func(c *fakeContainer) { c.state = ContainerState{Status: "exited", ExitCode: 0} })
+}
+
+func (d *fakeDocker) RemoveContainer(ctx context.Context, id string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for key, c := range d.containers {
+		if key == id || c.name == id {
+			delete(d.containers, key)
+			return nil
+		}
+	}
+	return errDockerNotFound
+}
+
+func (d *fakeDocker) InspectContainer(ctx context.Context, id string) (*ContainerState, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	c, exists := d.containers[id]
+	if !exists {
+		return nil, errDockerNotFound
+	}
+	state := c.state
+	return &state, nil
+}
+
+// ContainerLogs returns the output on the first call, then blocks until the
+// logs are no longer followed
+func (d *fakeDocker) ContainerLogs(ctx context.Context, id string, since time.Time) (io.ReadCloser, error) {
+	if !since.IsZero() {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return io.NopCloser(bytes.NewReader(d.output)), nil
+}
+
+func (d *fakeDocker) update(id string, change func(*fakeContainer)) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	c, exists := d.containers[id]
+	if !exists {
+		return errDockerNotFound
+	}
+	change(c)
+	return nil
+}
+
+// setState changes the state of the container with a name
+func (d *fakeDocker) setState(name string, state ContainerState) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, c := range d.containers {
+		if c.name == name {
+			c.state = state
+		}
+	}
+}
+
+// container returns the container with a name, or nil
+func (d *fakeDocker) container(name string) *fakeContainer {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, c := range d.containers {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// logFrame encodes output in the multiplexed format of container logs
+func logFrame(stream byte, text string) []byte {
+	frame := make([]byte, 8, 8+len(text))
+	frame[0] = stream
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(text)))
+	return append(frame, text...)
+}
+
+func TestDockerRuntimeStartStop(t *testing.T) {
+	docker := newFakeDocker()
+	runtime := NewDockerRuntime(docker, nil, DockerRuntimeOptions{})
+	ctx := context.Background()
+
+	service := &ServiceInstance{
+		ID:          "web-1",
+		Name:        "web",
+		Image:       "nginx:alpine",
+		Args:        []string{"nginx", "-g", "daemon off;"},
+		Port:        8081,
+		Environment: map[string]string{"MODE": "production", "DEBUG": "false"},
+		Resources:   &ResourceRequirements{CPU: "500m", Memory: "512Mi"},
+	}
+	require.NoError(t, runtime.Start(ctx, service))
+	assert.Equal(t, []string{"nginx:alpine"}, docker.pulled)
+	assert.Equal(t, "running", runtimeStatus(t, runtime, "web-1"))
+	assert.Error(t, runtime.Start(ctx, service), "an instance cannot be started twice")
+
+	c := docker.container("infra-core-web-1")
+	require.NotNil(t, c)
+	assert.Equal(t, &ContainerConfig{
+		Image:        "nginx:alpine",
+		Cmd:          []string{"nginx", "-g", "daemon off;"},
+		Env:          []string{"DEBUG=false", "MODE=production"},
+		Labels:       map[string]string{labelService: "web", labelInstance: "web-1"},
+		ExposedPorts: map[string]struct{}{"8081/tcp": {}},
+		HostConfig: HostConfig{
+			PortBindings:  map[string][]PortBinding{"8081/tcp": {{HostPort: "8081"}}},
+			RestartPolicy: RestartPolicy{Name: RestartOnFailure},
+			NanoCPUs:      500_000_000,
+			Memory:        512 << 20,
+		},
+	}, c.config)
+
+	// The status follows the container's state as Docker restarts it
+	docker.setState("infra-core-web-1", ContainerState{Status: "restarting", ExitCode: 137})
+	assert.Equal(t, "restarting", runtimeStatus(t, runtime, "web-1"))
+	docker.setState("infra-core-web-1", ContainerState{Status: "exited", ExitCode: 137})
+	assert.Equal(t, "failed", runtimeStatus(t, runtime, "web-1"))
+
+	// Restarts and containers killed out of memory are reported
+	docker.setState("infra-core-web-1", ContainerState{Status: "running", OOMKilled: true, RestartCount: 2})
+	restarts, reason, err := runtime.Restarts(ctx, "web-1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, restarts)
+	assert.Equal(t, "killed out of memory", reason)
+
+	require.NoError(t, runtime.Stop(ctx, "web-1"))
+	assert.Equal(t, "stopped", runtimeStatus(t, runtime, "web-1"))
+	assert.Nil(t, docker.container("infra-core-web-1"), "stopped containers are removed")
+	assert.NoError(t, runtime.Stop(ctx, "web-1"))
+
+	// Started again, the image is present and a leftover container under
+	// the same name is replaced
+	_, err = docker.CreateContainer(ctx, "infra-core-web-1", &ContainerConfig{Image: "nginx:alpine"})
+	require.NoError(t, err)
+	require.NoError(t, runtime.Start(ctx, service))
+	assert.Len(t, docker.pulled, 1)
+	assert.Equal(t, "running", runtimeStatus(t, runtime, "web-1"))
+
+	_, err = runtime.Status(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+	assert.ErrorIs(t, runtime.Stop(ctx, "unknown"), ErrInstanceNotFound)
+	assert.Error(t, runtime.Start(ctx, &ServiceInstance{ID: "no-image"}))
+	assert.Error(t, runtime.Start(ctx, &ServiceInstance{ID: "missing", Image: "missing:1"}))
+	_, err = runtime.Status(ctx, "missing")
+	assert.ErrorIs(t, err, ErrInstanceNotFound, "instances that failed to start are forgotten")
+}
+
+// runtimeStatus returns an instance's status, failing the test if unknown
+func runtimeStatus(t *testing.T, runtime Runtime, id string) string {
+	status, err := runtime.Status(context.Background(), id)
+	require.NoError(t, err)
+	return status
+}
+
+func TestContainerConfig(t *testing.T) {
+	config, err := containerConfig(&ServiceInstance{
+		ID:            "api-0",
+		Image:         "api:1",
+		Command:       []string{"/bin/api"},
+		Args:          []string{"--verbose"},
+		RestartPolicy: RestartAlways,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/bin/api"}, config.Entrypoint)
+	assert.Equal(t, []string{"--verbose"}, config.Cmd)
+	assert.Equal(t, RestartAlways, config.HostConfig.RestartPolicy.Name)
+	assert.Nil(t, config.ExposedPorts)
+	assert.Zero(t, config.HostConfig.NanoCPUs)
+
+	_, err = containerConfig(&ServiceInstance{ID: "api-0", Image: "api:1", Resources: &ResourceRequirements{Memory: "lots"}})
+	assert.Error(t, err)
+	_, err = containerConfig(&ServiceInstance{ID: "api-0", Image: "api:1", RestartPolicy: "sometimes"})
+	assert.Error(t, err)
+}
+
+func TestDemuxLogs(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(logFrame(1, "listening\n"))
+	stream.Write(logFrame(2, "warning: "))
+	stream.Write(logFrame(2, "no config\n"))
+	stream.Write(logFrame(1, ""))
+
+	var stdout, stderr bytes.Buffer
+	require.NoError(t, demuxLogs(&stream, &stdout, &stderr))
+	assert.Equal(t, "listening\n", stdout.String())
+	assert.Equal(t, "warning: no config\n", stderr.String())
+
+	truncated := logFrame(1, "partial line")[:12]
+	assert.Error(t, demuxLogs(bytes.NewReader(truncated), &stdout, &stderr))
+}
+
+func TestDockerEngineClient(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		path := strings.TrimPrefix(r.URL.Path, "/"+dockerAPIVersion)
+
+		switch {
+		case path == "/images/nginx:alpine/json":
+			w.Write([]byte(`{"Id":"sha256:1"}`))
+		case path == "/images/create" && r.URL.Query().Get("fromImage") == "private/app":
+			w.Write([]byte(`{"status":"Pulling"}` + "\n" + `{"error":"pull access denied"}`))
+		case path == "/images/create":
+			w.Write([]byte(`{"status":"Pulling"}` + "\n" + `{"status":"Downloaded"}`))
+		case path == "/containers/create":
+			var config ContainerConfig
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&config))
+			assert.Equal(t, "nginx:alpine", config.Image)
+			assert.Equal(t, int64(1<<30), config.HostConfig.Memory)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"abc123"}`))
+		case path == "/containers/abc123/start", path == "/containers/abc123/stop", path == "/containers/abc123":
+			w.WriteHeader(http.StatusNoContent)
+		case path == "/containers/abc123/json":
+			w.Write([]byte(`{"Id":"abc123","State":{"Status":"exited","ExitCode":2}}`))
+		case path == "/containers/abc123/logs":
+			w.Write(logFrame(1, "hello\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such object"}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewDockerClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	exists, err := client.ImageExists(ctx, "nginx:alpine")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = client.ImageExists(ctx, "redis")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, client.PullImage(ctx, "redis"))
+	assert.Contains(t, requests[len(requests)-1], "tag=latest")
+	require.NoError(t, client.PullImage(ctx, "localhost:5000/redis:7"))
+	assert.NotContains(t, requests[len(requests)-1], "tag=")
+	assert.ErrorContains(t, client.PullImage(ctx, "private/app"), "pull access denied")
+
+	id, err := client.CreateContainer(ctx, "infra-core-web-0", &ContainerConfig{
+		Image:      "nginx:alpine",
+		HostConfig: HostConfig{Memory: 1 << 30},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", id)
+	assert.Contains(t, requests[len(requests)-1], "name=infra-core-web-0")
+
+	require.NoError(t, client.StartContainer(ctx, id))
+	require.NoError(t, client.StopContainer(ctx, id, 10*time.Second))
+	assert.Contains(t, requests[len(requests)-1], "t=10")
+	require.NoError(t, client.RemoveContainer(ctx, id))
+	assert.Equal(t, "DELETE /"+dockerAPIVersion+"/containers/abc123?force=true", requests[len(requests)-1])
+
+	state, err := client.InspectContainer(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, &ContainerState{Status: "exited", ExitCode: 2}, state)
+	assert.Equal(t, "failed", containerStatus(state))
+
+	logs, err := client.ContainerLogs(ctx, id, time.Unix(1700000000, 5))
+	require.NoError(t, err)
+	var stdout bytes.Buffer
+	require.NoError(t, demuxLogs(logs, &stdout, io.Discard))
+	logs.Close()
+	assert.Equal(t, "hello\n", stdout.String())
+	assert.Contains(t, requests[len(requests)-1], "since=1700000000.000000005")
+
+	err = client.StartContainer(ctx, "gone")
+	assert.ErrorIs(t, err, errDockerNotFound)
+	assert.ErrorContains(t, err, "No such object")
+
+	_, err = NewDockerClient("docker.sock")
+	assert.Error(t, err)
+}
+
+func TestOrchestratorWithDockerRuntime(t *testing.T) {
+	cfg := setupDeploymentTest(t)
+	cfg.Orchestrator.Runtime = RuntimeProcess
+	_, o, r := startTestOrchestrator(t, cfg)
+
+	docker := newFakeDocker("nginx:alpine")
+	docker.output = append(logFrame(1, "ready\n"), logFrame(2, "no config\n")...)
+	o.runtime.(*runtimeSet).runtimes[RuntimeDocker] = NewDockerRuntime(docker, o.Logs(), DockerRuntimeOptions{})
+
+	// Services select the docker runtime over the configured one
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{
+		Name:      "web",
+		Image:     "nginx:alpine",
+		Replicas:  2,
+		Runtime:   RuntimeDocker,
+		Resources: &ResourceRequirements{CPU: "2", Memory: "1Gi"},
+	})
+	require.Equal(t, http.StatusCreated, code, response)
+	waitForStatus(t, o, response["deployment_id"].(string), "deployed")
+
+	for i := 0; i < 2; i++ {
+		c := docker.container(fmt.Sprintf("infra-core-web-%d", i))
+		require.NotNil(t, c, i)
+		assert.Equal(t, int64(2_000_000_000), c.config.HostConfig.NanoCPUs)
+		assert.Equal(t, int64(1<<30), c.config.HostConfig.Memory)
+	}
+
+	code, response = serveJSON(t, r, http.MethodGet, "/services/web-1", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "running", response["status"])
+	assert.Nil(t, response["pid"])
+
+	// Output of both containers is captured into the service's logs
+	assert.Eventually(t, func() bool {
+		_, response := serveJSON(t, r, http.MethodGet, "/services/web-0/logs", nil)
+		logs, _ := response["logs"].([]interface{})
+		return len(logs) == 4
+	}, 5*time.Second, 20*time.Millisecond)
+
+	code, _ = serveJSON(t, r, http.MethodPost, "/services/web-0/stop", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Nil(t, docker.container("infra-core-web-0"))
+	assert.NotNil(t, docker.container("infra-core-web-1"))
+
+	code, _ = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "bad", Image: "bad", Runtime: "kubernetes"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "bad", Image: "bad", Resources: &ResourceRequirements{CPU: "fast"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -1,50 +1,288 @@
 package orchestrator
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+
+	"github.com/last-emo-boy/infra-core/pkg/diff"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
 )
 
-// DeployService handles service deployment requests
+// RegisterRoutes registers the orchestrator API under api, which the daemon
+// mounts at /api/v1
+func (o *Orchestrator) RegisterRoutes(api gin.IRouter) {
+	// Service orchestration
+	services := api.Group("/services")
+	{
+		services.POST("/deploy", o.DeployService)
+		services.POST("/:id/start", o.StartService)
+		services.POST("/:id/stop", o.StopService)
+		services.POST("/:id/restart", o.RestartService)
+		services.DELETE("/:id", o.RemoveService)
+		services.GET("/:id/status", o.GetServiceStatus)
+		services.GET("/:id/logs", o.GetServiceLogs)
+	}
+
+	// Deployment management
+	deployments := api.Group("/deployments")
+	{
+		deployments.GET("/", o.ListDeployments)
+		deployments.GET("/:id", o.GetDeployment)
+		deployments.POST("/:id/rollback", o.RollbackDeployment)
+		deployments.DELETE("/:id", o.DeleteDeployment)
+		deployments.POST("/bulk", o.BulkDeployments)
+	}
+
+	// Cluster management
+	cluster := api.Group("/cluster")
+	{
+		cluster.GET("/nodes", o.ListNodes)
+		cluster.GET("/resources", o.GetClusterResources)
+		cluster.GET("/events", o.GetClusterEvents)
+
+		// Node agents, which authenticate with the cluster token
+		agents := cluster.Group("/nodes", o.requireNodeToken)
+		agents.POST("/register", o.RegisterNode)
+		agents.POST("/:id/heartbeat", o.NodeHeartbeat)
+		agents.GET("/:id/commands", o.NodeCommands)
+		agents.POST("/:id/commands/:command_id", o.CompleteNodeCommand)
+	}
+
+	// Status changes and deployment progress as server-sent events
+	api.GET("/events/stream", o.eventBus.Stream)
+
+	// Orchestrator control
+	control := api.Group("/control")
+	{
+		control.POST("/sync", o.SyncServices)
+		control.POST("/cleanup", o.CleanupResources)
+		control.GET("/metrics", o.GetMetrics)
+	}
+}
+
+// DeployService handles service deployment requests. With ?dry_run=true
+// the request is validated and its changes from the service's current
+// deployment returned without deploying.
 func (o *Orchestrator) DeployService(c *gin.Context) {
+	dryRun := false
+	if value := c.Query("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be true or false"})
+			return
+		}
+	}
+
 	var req DeployRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !validRestartPolicy(req.RestartPolicy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid restart_policy, expected no, on-failure or always"})
+		return
+	}
+	if !validStrategy(req.Strategy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid strategy, expected rolling or recreate"})
+		return
+	}
+	for _, key := range []string{"max_unavailable", "health_check_failures"} {
+		if _, err := rolloutSetting(req.Config, key, 1); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if !validRuntime(req.Runtime) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid runtime, expected process or docker"})
+		return
+	}
+	if req.Runtime != "" && o.runtime == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Runtime %s is not enabled, services are simulated", req.Runtime)})
+		return
+	}
+	if problems := req.applySpec(); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service spec", "errors": problems})
+		return
+	}
 
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
 
-	// Generate deployment ID
-	deploymentID := uuid.New().String()
+	if o.deploying(req.Name) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A deployment of %s is in progress", req.Name)})
+		return
+	}
+	if err := o.checkDependencies(req.Name, req.DependsOn); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if dryRun {
+		var before *spec.Spec
+		current := o.currentDeployment(req.Name)
+		if current != nil {
+			before = current.Request.spec()
+		}
+		changes := spec.Diff(before, req.spec())
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run":  true,
+			"service":  req.Name,
+			"exists":   current != nil,
+			"changes":  changes,
+			"redeploy": diff.Redeploys(changes),
+		})
+		return
+	}
+
+	deployment, createdServices, err := o.deployOnPort(req)
+	if respondPortError(c, err) || respondScheduleError(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to record deployment: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"deployment_id": deployment.ID,
+		"revision":      deployment.Revision,
+		"services":      createdServices,
+		"port":          deployment.Request.Port,
+		"status":        "deploying",
+	})
+}
+
+// currentDeployment returns the newest deployment of a service that did not
+// fail, or nil if there is none. Callers hold o.mutex.
+func (o *Orchestrator) currentDeployment(name string) *Deployment {
+	var current *Deployment
+	for _, deployment := range o.deployments {
+		if deployment.ServiceName != name || deployment.Request == nil || deployment.Status == "failed" {
+			continue
+		}
+		if current == nil || deployment.CreatedAt.After(current.CreatedAt) {
+			current = deployment
+		}
+	}
+	return current
+}
+
+// applySpec fills the request from its YAML spec, if it has one, and
+// validates the service it describes
+func (req *DeployRequest) applySpec() []spec.ValidationError {
+	if req.Spec == "" {
+		return req.spec().Validate()
+	}
+
+	if req.Name != "" || req.Image != "" || req.Command != nil || req.Args != nil || req.Port != 0 ||
+		req.Replicas != 0 || req.Environment != nil || req.EnvFromSecret != nil || req.Resources != nil || req.NodeSelector != nil {
+		return []spec.ValidationError{{
+			Field:   "spec",
+			Message: "replaces name, image, command, args, port, replicas, environment, env_from_secret, resources and node_selector, which must be left unset",
+		}}
+	}
+	parsed, problems := spec.ParseAndValidate(req.Spec)
+	if len(problems) > 0 {
+		return problems
+	}
+
+	req.Name, req.Image = parsed.Name, parsed.Image
+	req.Command, req.Args = parsed.Command, parsed.Args
+	req.Port, req.Replicas = parsed.PrimaryPort(), parsed.Replicas
+	req.Environment, req.EnvFromSecret = parsed.Env, parsed.EnvFromSecret
+	req.NodeSelector = parsed.NodeSelector
+	if parsed.Resources != nil {
+		req.Resources = &ResourceRequirements{CPU: parsed.Resources.CPU, Memory: parsed.Resources.Memory}
+	}
+	return nil
+}
+
+// spec returns the service spec of a request given field by field
+func (req *DeployRequest) spec() *spec.Spec {
+	s := &spec.Spec{
+		Name:          req.Name,
+		Image:         req.Image,
+		Port:          req.Port,
+		Replicas:      req.Replicas,
+		Env:           req.Environment,
+		EnvFromSecret: req.EnvFromSecret,
+		Command:       req.Command,
+		Args:          req.Args,
+		NodeSelector:  req.NodeSelector,
+	}
+	if req.Resources != nil {
+		s.Resources = &spec.Resources{CPU: req.Resources.CPU, Memory: req.Resources.Memory}
+	}
+	return s
+}
 
+// deploy records a deployment of a request and creates its service
+// instances. Callers hold o.mutex.
+func (o *Orchestrator) deploy(req DeployRequest) (*Deployment, []string, error) {
 	// Create deployment record
 	deployment := &Deployment{
-		ID:          deploymentID,
+		ID:          uuid.New().String(),
 		ServiceName: req.Name,
 		Version:     "latest", // Could be extracted from image tag
 		Status:      "deploying",
 		Strategy:    req.Strategy,
 		Config:      req.Config,
+		Request:     &req,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 		Logs:        []string{},
 	}
 
-	o.deployments[deploymentID] = deployment
+	// In cluster mode, each instance goes to the node with the most room
+	var placement []string
+	if o.cluster != nil {
+		var err error
+		if placement, err = o.schedule(req); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := o.recordDeployment(deployment); err != nil {
+		return nil, nil, err
+	}
+	o.deployments[deployment.ID] = deployment
 
 	// Create service instances
+	instances, createdServices := newInstances(req, deployment.ServiceID)
+	replicas := len(instances)
+
+	o.logDeployment(deployment,
+		fmt.Sprintf("Created %d service instances: %v", replicas, createdServices))
+	for i, node := range placement {
+		instances[i].NodeID = node
+		o.logDeployment(deployment, fmt.Sprintf("Scheduled %s on node %s", instances[i].ID, o.nodes[node].Name))
+	}
+
+	// The instances replace the current ones as the strategy says
+	deployment.Progress = &DeploymentProgress{Phase: PhasePending, Total: replicas}
+	o.publishProgress(deployment)
+	go o.run(o.planRollout(deployment, instances))
+
+	return deployment, createdServices, nil
+}
+
+// newInstances creates the service instances of a deploy request, whose
+// logs are kept under the service's record ID
+func newInstances(req DeployRequest, recordID string) ([]*ServiceInstance, []string) {
 	replicas := req.Replicas
 	if replicas <= 0 {
 		replicas = 1
 	}
 
+	var instances []*ServiceInstance
 	var createdServices []string
 	for i := 0; i < replicas; i++ {
 		serviceID := fmt.Sprintf("%s-%d", req.Name, i)
@@ -54,37 +292,34 @@ func (o *Orchestrator) DeployService(c *gin.Context) {
 		}
 
 		service := &ServiceInstance{
-			ID:          serviceID,
-			Name:        req.Name,
-			Image:       req.Image,
-			Port:        port,
-			Status:      "starting",
-			Health:      "unknown",
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
-			Environment: req.Environment,
-			Resources:   req.Resources,
-			Config:      req.Config,
+			ID:            serviceID,
+			RecordID:      recordID,
+			Name:          req.Name,
+			Image:         req.Image,
+			Command:       req.Command,
+			Args:          req.Args,
+			Port:          port,
+			Status:        "starting",
+			Health:        "unknown",
+			RestartPolicy: req.RestartPolicy,
+			Runtime:       req.Runtime,
+			DependsOn:     req.DependsOn,
+			CreatedAt:     time.Now(),
+			UpdatedAt:     time.Now(),
+			Environment:   req.Environment,
+			EnvFromSecret: req.EnvFromSecret,
+			Resources:     req.Resources,
+			Config:        req.Config,
 		}
 
-		o.services[serviceID] = service
+		instances = append(instances, service)
 		createdServices = append(createdServices, serviceID)
-
-		// Simulate deployment process
-		go o.deployServiceInstance(service, deployment)
 	}
 
-	deployment.Logs = append(deployment.Logs, 
-		fmt.Sprintf("Created %d service instances: %v", replicas, createdServices))
-
-	c.JSON(http.StatusCreated, gin.H{
-		"deployment_id": deploymentID,
-		"services":      createdServices,
-		"status":        "deploying",
-	})
+	return instances, createdServices
 }
 
-// StartService starts a specific service
+// StartService starts a specific service once its dependencies are healthy
 func (o *Orchestrator) StartService(c *gin.Context) {
 	serviceID := c.Param("id")
 
@@ -102,16 +337,31 @@ func (o *Orchestrator) StartService(c *gin.Context) {
 		return
 	}
 
-	service.Status = "starting"
-	service.UpdatedAt = time.Now()
+	o.setInstanceStatus(service, "starting")
 
-	// Simulate starting service
+	name, dependsOn := service.Name, service.DependsOn
+	logger := logging.FromContext(c.Request.Context())
 	go func() {
+		if err := o.waitForDependencies(name, dependsOn); err != nil {
+			logger.Error("not starting service instance", "service_id", serviceID, "error", err)
+			o.mutex.Lock()
+			o.setInstanceStatus(service, "failed")
+			service.Health = "unhealthy"
+			o.mutex.Unlock()
+			return
+		}
+
+		if o.runtime != nil {
+			if err := o.launchInstance(service); err != nil {
+				logger.Error("failed to start service instance", "service_id", serviceID, "error", err)
+			}
+			return
+		}
+		// Simulate starting service
 		time.Sleep(2 * time.Second)
 		o.mutex.Lock()
-		service.Status = "running"
+		o.setInstanceStatus(service, "running")
 		service.Health = "healthy"
-		service.UpdatedAt = time.Now()
 		o.mutex.Unlock()
 	}()
 
@@ -121,29 +371,36 @@ func (o *Orchestrator) StartService(c *gin.Context) {
 	})
 }
 
-// StopService stops a specific service
+// StopService stops a specific service. With a runtime, the instance gets
+// SIGTERM and, if it has not exited after the grace period, SIGKILL.
 func (o *Orchestrator) StopService(c *gin.Context) {
 	serviceID := c.Param("id")
 
 	o.mutex.Lock()
-	defer o.mutex.Unlock()
-
 	service, exists := o.services[serviceID]
 	if !exists {
+		o.mutex.Unlock()
 		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
 		return
 	}
 
 	if service.Status == "stopped" {
+		o.mutex.Unlock()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Service is already stopped"})
 		return
 	}
+	o.mutex.Unlock()
 
-	if err := o.stopServiceInstance(service); err != nil {
+	// Stopping waits for the instance to exit, so it runs unlocked
+	if err := o.stopRuntime(serviceID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	o.mutex.Lock()
+	o.markStopped(service)
+	o.mutex.Unlock()
+
 	c.JSON(http.StatusOK, gin.H{
 		"service_id": serviceID,
 		"status":     "stopped",
@@ -163,18 +420,7 @@ func (o *Orchestrator) RestartService(c *gin.Context) {
 		return
 	}
 
-	service.Status = "restarting"
-	service.UpdatedAt = time.Now()
-
-	// Simulate restart
-	go func() {
-		time.Sleep(3 * time.Second)
-		o.mutex.Lock()
-		service.Status = "running"
-		service.Health = "healthy"
-		service.UpdatedAt = time.Now()
-		o.mutex.Unlock()
-	}()
+	o.restartInstance(service, "restart requested")
 
 	c.JSON(http.StatusOK, gin.H{
 		"service_id": serviceID,
@@ -187,24 +433,30 @@ func (o *Orchestrator) RemoveService(c *gin.Context) {
 	serviceID := c.Param("id")
 
 	o.mutex.Lock()
-	defer o.mutex.Unlock()
-
 	service, exists := o.services[serviceID]
 	if !exists {
+		o.mutex.Unlock()
 		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
 		return
 	}
+	stop := o.needsStop(service)
+	o.mutex.Unlock()
 
 	// Stop service if running
-	if service.Status == "running" {
-		if err := o.stopServiceInstance(service); err != nil {
+	if stop {
+		if err := o.stopRuntime(serviceID); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to stop service: %v", err)})
 			return
 		}
 	}
 
+	o.mutex.Lock()
+	if stop {
+		o.markStopped(service)
+	}
 	// Remove from services map
 	delete(o.services, serviceID)
+	o.mutex.Unlock()
 
 	c.JSON(http.StatusOK, gin.H{
 		"service_id": serviceID,
@@ -212,12 +464,13 @@ func (o *Orchestrator) RemoveService(c *gin.Context) {
 	})
 }
 
-// GetServiceStatus returns the status of a specific service
+// GetServiceStatus returns the status of a specific service, as reported by
+// the runtime if there is one
 func (o *Orchestrator) GetServiceStatus(c *gin.Context) {
 	serviceID := c.Param("id")
 
-	o.mutex.RLock()
-	defer o.mutex.RUnlock()
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
 
 	service, exists := o.services[serviceID]
 	if !exists {
@@ -225,33 +478,29 @@ func (o *Orchestrator) GetServiceStatus(c *gin.Context) {
 		return
 	}
 
+	o.refreshInstance(service)
 	c.JSON(http.StatusOK, service)
 }
 
-// GetServiceLogs returns logs for a specific service
+// GetServiceLogs returns logs for a specific service, see ServeServiceLogs
+// for the supported query parameters
 func (o *Orchestrator) GetServiceLogs(c *gin.Context) {
 	serviceID := c.Param("id")
 
 	o.mutex.RLock()
-	defer o.mutex.RUnlock()
-
 	service, exists := o.services[serviceID]
+	var key string
+	if exists {
+		key = logKey(service)
+	}
+	o.mutex.RUnlock()
+
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
 		return
 	}
 
-	// In a real implementation, this would fetch actual container logs
-	logs := []string{
-		fmt.Sprintf("[%s] Service %s started", time.Now().Format(time.RFC3339), service.Name),
-		fmt.Sprintf("[%s] Health check passed", time.Now().Add(-30*time.Second).Format(time.RFC3339)),
-		fmt.Sprintf("[%s] Service ready to accept connections", time.Now().Add(-25*time.Second).Format(time.RFC3339)),
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"service_id": serviceID,
-		"logs":       logs,
-	})
+	ServeServiceLogs(c, o.logReader, key)
 }
 
 // ListDeployments returns all deployments
@@ -277,8 +526,12 @@ func (o *Orchestrator) GetDeployment(c *gin.Context) {
 	o.mutex.RLock()
 	defer o.mutex.RUnlock()
 
-	deployment, exists := o.deployments[deploymentID]
-	if !exists {
+	deployment, err := o.findDeployment(deploymentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get deployment: %v", err)})
+		return
+	}
+	if deployment == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
 		return
 	}
@@ -286,38 +539,78 @@ func (o *Orchestrator) GetDeployment(c *gin.Context) {
 	c.JSON(http.StatusOK, deployment)
 }
 
-// RollbackDeployment rolls back a deployment
+// RollbackDeployment rolls back a deployment by redeploying the config of
+// the previous successful deployment of its service, or of the one with the
+// revision given by the revision query parameter
 func (o *Orchestrator) RollbackDeployment(c *gin.Context) {
 	deploymentID := c.Param("id")
 
+	revision := 0
+	if value := c.Query("revision"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+			return
+		}
+		revision = parsed
+	}
+
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
 
-	deployment, exists := o.deployments[deploymentID]
-	if !exists {
+	deployment, err := o.findDeployment(deploymentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get deployment: %v", err)})
+		return
+	}
+	if deployment == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
 		return
 	}
+	if deployment.Status == "rolled_back" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Deployment is already rolled back"})
+		return
+	}
+	if o.deploying(deployment.ServiceName) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A deployment of %s is in progress", deployment.ServiceName)})
+		return
+	}
 
-	deployment.Status = "rolling_back"
-	deployment.UpdatedAt = time.Now()
-	deployment.Logs = append(deployment.Logs, 
-		fmt.Sprintf("Rollback initiated at %s", time.Now().Format(time.RFC3339)))
+	target, err := o.rollbackTarget(deployment, revision)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to find deployment to roll back to: %v", err)})
+		return
+	}
+	if target == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "No earlier successful deployment to roll back to"})
+		return
+	}
 
-	// Simulate rollback process
-	go func() {
-		time.Sleep(5 * time.Second)
-		o.mutex.Lock()
-		deployment.Status = "rolled_back"
-		deployment.UpdatedAt = time.Now()
-		deployment.Logs = append(deployment.Logs, 
-			fmt.Sprintf("Rollback completed at %s", time.Now().Format(time.RFC3339)))
-		o.mutex.Unlock()
-	}()
+	// The rollback is not what triggered the deployment it rolls back to
+	req := *target.Request
+	req.TriggerSource, req.TriggeredBy, req.Notes = TriggerRollback, "", ""
+	rollback, createdServices, err := o.deployOnPort(req)
+	if respondPortError(c, err) || respondScheduleError(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to record deployment: %v", err)})
+		return
+	}
+	o.logDeployment(rollback,
+		fmt.Sprintf("Rollback of revision %d to revision %d", deployment.Revision, target.Revision))
+
+	o.setDeploymentStatus(deployment, "rolled_back", "")
+	o.logDeployment(deployment,
+		fmt.Sprintf("Rolled back to revision %d at %s", target.Revision, time.Now().Format(time.RFC3339)))
 
 	c.JSON(http.StatusOK, gin.H{
-		"deployment_id": deploymentID,
-		"status":        "rolling_back",
+		"deployment_id":          deploymentID,
+		"rollback_deployment_id": rollback.ID,
+		"revision":               rollback.Revision,
+		"target_revision":        target.Revision,
+		"services":               createdServices,
+		"status":                 "rolling_back",
 	})
 }
 
@@ -325,23 +618,22 @@ func (o *Orchestrator) RollbackDeployment(c *gin.Context) {
 func (o *Orchestrator) DeleteDeployment(c *gin.Context) {
 	deploymentID := c.Param("id")
 
-	o.mutex.Lock()
-	defer o.mutex.Unlock()
-
-	if _, exists := o.deployments[deploymentID]; !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+	if err := o.deleteDeployment(deploymentID); err != nil {
+		if errors.Is(err, errDeploymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete deployment: %v", err)})
 		return
 	}
 
-	delete(o.deployments, deploymentID)
-
 	c.JSON(http.StatusOK, gin.H{
 		"deployment_id": deploymentID,
 		"status":        "deleted",
 	})
 }
 
-// ListNodes returns all cluster nodes
+// ListNodes returns all cluster nodes by name
 func (o *Orchestrator) ListNodes(c *gin.Context) {
 	o.mutex.RLock()
 	defer o.mutex.RUnlock()
@@ -350,6 +642,7 @@ func (o *Orchestrator) ListNodes(c *gin.Context) {
 	for _, node := range o.nodes {
 		nodes = append(nodes, node)
 	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
 
 	c.JSON(http.StatusOK, gin.H{
 		"nodes": nodes,
@@ -357,52 +650,49 @@ func (o *Orchestrator) ListNodes(c *gin.Context) {
 	})
 }
 
-// GetClusterResources returns cluster resource usage
+// GetClusterResources returns the capacity of the ready nodes and what their
+// service instances use of it. Nodes without a limit on a resource make the
+// cluster's unlimited.
 func (o *Orchestrator) GetClusterResources(c *gin.Context) {
 	o.mutex.RLock()
 	defer o.mutex.RUnlock()
 
-	totalResources := &NodeResources{
-		CPU:     ResourceUsage{Used: "0", Available: "0", Total: "0", Percent: 0},
-		Memory:  ResourceUsage{Used: "0GB", Available: "0GB", Total: "0GB", Percent: 0},
-		Storage: ResourceUsage{Used: "0GB", Available: "0GB", Total: "0GB", Percent: 0},
-		Network: ResourceUsage{Used: "0Mbps", Available: "0Mbps", Total: "0Mbps", Percent: 0},
-		Pods:    ResourceUsage{Used: "0", Available: "0", Total: "0", Percent: 0},
-	}
-
-	// Aggregate resources from all nodes
+	var capacity, usage NodeCapacity
+	var unlimited [3]bool
+	ready := 0
 	for _, node := range o.nodes {
-		// Simple aggregation (in practice would be more complex)
-		if totalCPU, err := strconv.Atoi(node.Resources.CPU.Total); err == nil {
-			if currentTotal, err := strconv.Atoi(totalResources.CPU.Total); err == nil {
-				totalResources.CPU.Total = strconv.Itoa(currentTotal + totalCPU)
-			}
+		if node.Status != NodeReady {
+			continue
 		}
+		ready++
+		capacity = addCapacity(capacity, node.Capacity)
+		usage = addCapacity(usage, node.Usage)
+		unlimited[0] = unlimited[0] || node.Capacity.NanoCPUs <= 0
+		unlimited[1] = unlimited[1] || node.Capacity.MemoryBytes <= 0
+		unlimited[2] = unlimited[2] || node.Capacity.Pods <= 0
+	}
+	if unlimited[0] {
+		capacity.NanoCPUs = 0
+	}
+	if unlimited[1] {
+		capacity.MemoryBytes = 0
+	}
+	if unlimited[2] {
+		capacity.Pods = 0
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"cluster_resources": totalResources,
+		"cluster_resources": nodeResources(capacity, usage),
+		"capacity":          capacity,
+		"usage":             usage,
 		"node_count":        len(o.nodes),
+		"ready_nodes":       ready,
 	})
 }
 
-// GetClusterEvents returns cluster events
+// GetClusterEvents returns cluster events, newest first
 func (o *Orchestrator) GetClusterEvents(c *gin.Context) {
-	// Simulate cluster events
-	events := []map[string]interface{}{
-		{
-			"timestamp": time.Now().Add(-5 * time.Minute).Unix(),
-			"type":      "Normal",
-			"reason":    "ServiceStarted",
-			"message":   "Service hello-service-0 started successfully",
-		},
-		{
-			"timestamp": time.Now().Add(-10 * time.Minute).Unix(),
-			"type":      "Warning",
-			"reason":    "HighMemoryUsage",
-			"message":   "Node localhost memory usage is above 80%",
-		},
-	}
+	events := o.clusterEvents()
 
 	c.JSON(http.StatusOK, gin.H{
 		"events": events,
@@ -410,22 +700,34 @@ func (o *Orchestrator) GetClusterEvents(c *gin.Context) {
 	})
 }
 
-// SyncServices synchronizes service states
+// SyncServices synchronizes service states: services without instances,
+// as after a restart, are restored from their latest successful deployment,
+// and instances that are not running are started in dependency order
 func (o *Orchestrator) SyncServices(c *gin.Context) {
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
 
+	restored := o.restoreServices()
+	order, err := dependencyOrder(o.serviceDependencies())
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
 	syncedCount := 0
 	for _, service := range o.services {
-		// Simulate sync operation
-		service.UpdatedAt = time.Now()
-		syncedCount++
+		if startable(service) && !o.deploying(service.Name) {
+			syncedCount++
+		}
 	}
+	go o.startInOrder(order)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":        "Services synchronized",
 		"synced_count":   syncedCount,
+		"restored_count": len(restored),
 		"total_services": len(o.services),
+		"order":          order,
 	})
 }
 
@@ -495,21 +797,29 @@ func (o *Orchestrator) GetMetrics(c *gin.Context) {
 	})
 }
 
-// deployServiceInstance simulates deploying a service instance
-func (o *Orchestrator) deployServiceInstance(service *ServiceInstance, deployment *Deployment) {
-	// Simulate deployment time
-	time.Sleep(3 * time.Second)
+// launchInstance starts an instance in the runtime and records the outcome
+// on it, returning the error for callers to report. Callers do not hold
+// o.mutex.
+func (o *Orchestrator) launchInstance(service *ServiceInstance) error {
+	o.mutex.RLock()
+	instance := *service
+	o.mutex.RUnlock()
+
+	err := o.resolveSecrets(&instance)
+	if err == nil {
+		err = o.runtime.Start(o.ctx, &instance)
+	}
 
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
 
-	service.Status = "running"
-	service.Health = "healthy"
-	service.UpdatedAt = time.Now()
-
-	deployment.Status = "deployed"
-	deployment.UpdatedAt = time.Now()
-	deployment.Logs = append(deployment.Logs, 
-		fmt.Sprintf("Service %s deployed successfully at %s", 
-			service.ID, time.Now().Format(time.RFC3339)))
-}
\ No newline at end of file
+	if err != nil {
+		o.setInstanceStatus(service, "failed")
+		service.Health = "unhealthy"
+		return err
+	}
+	o.setInstanceStatus(service, "running")
+	service.Health = "unknown"
+	o.refreshInstance(service)
+	return nil
+}
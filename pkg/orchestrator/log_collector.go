@@ -0,0 +1,435 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// Log streams a service's output is captured from
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// Service log defaults used when the orchestrator config leaves them unset
+const (
+	defaultServiceLogDir         = "./log/services"
+	defaultServiceLogMaxFileSize = 10 << 20
+	defaultServiceLogMaxFiles    = 5
+)
+
+// Index tuning: a chunk of a log file is indexed once it reaches
+// logChunkLines lines, or on the next flush
+const (
+	logChunkLines    = 1000
+	logFlushInterval = time.Second
+)
+
+// logFileSuffix names service log files <sequence>.log so they sort in the
+// order they were written
+const logFileSuffix = ".log"
+
+// LogCollectorOptions configures a LogCollector
+type LogCollectorOptions struct {
+	Dir         string
+	MaxFileSize int64 // bytes
+	MaxFiles    int
+}
+
+// LogOptionsFromConfig reads the service log options from the orchestrator
+// config, applying defaults for anything left unset
+func LogOptionsFromConfig(cfg config.ServiceLogsConfig) LogCollectorOptions {
+	opts := LogCollectorOptions{
+		Dir:         cfg.Dir,
+		MaxFileSize: int64(cfg.MaxFileSizeMB) << 20,
+		MaxFiles:    cfg.MaxFiles,
+	}
+	if opts.Dir == "" {
+		opts.Dir = defaultServiceLogDir
+	}
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = defaultServiceLogMaxFileSize
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = defaultServiceLogMaxFiles
+	}
+	return opts
+}
+
+// LogCollector captures the output of managed services into rotating files,
+// one directory per service, and indexes each chunk it writes in logs_index
+// so that readers can seek by time or line count
+type LogCollector struct {
+	opts     LogCollectorOptions
+	repo     *database.LogIndexRepository
+	mutex    sync.Mutex
+	services map[string]*serviceLog
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// serviceLog is the file currently being written for one service and the
+// chunk of it not yet indexed
+type serviceLog struct {
+	mutex     sync.Mutex
+	serviceID string
+	dir       string
+	file      *os.File
+	path      string
+	sequence  int
+	size      int64
+	chunk     database.LogIndex
+}
+
+// NewLogCollector creates a log collector. Services are keyed by their
+// database ID, which the index references.
+func NewLogCollector(db *database.DB, opts LogCollectorOptions) *LogCollector {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &LogCollector{
+		opts:     opts,
+		repo:     db.LogIndexRepository(),
+		services: make(map[string]*serviceLog),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start starts indexing written chunks in the background
+func (lc *LogCollector) Start() {
+	lc.wg.Add(1)
+	go lc.run()
+}
+
+// Stop stops the collector, indexing and closing every open log file
+func (lc *LogCollector) Stop() {
+	lc.cancel()
+	lc.wg.Wait()
+
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	for id, sl := range lc.services {
+		sl.mutex.Lock()
+		sl.close(lc.repo)
+		sl.mutex.Unlock()
+		delete(lc.services, id)
+	}
+}
+
+// run flushes pending chunks until the collector is stopped
+func (lc *LogCollector) run() {
+	defer lc.wg.Done()
+
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lc.ctx.Done():
+			return
+		case <-ticker.C:
+			lc.Flush()
+		}
+	}
+}
+
+// Flush indexes the chunks written since the last flush
+func (lc *LogCollector) Flush() {
+	lc.mutex.Lock()
+	logs := make([]*serviceLog, 0, len(lc.services))
+	for _, sl := range lc.services {
+		logs = append(logs, sl)
+	}
+	lc.mutex.Unlock()
+
+	for _, sl := range logs {
+		sl.mutex.Lock()
+		sl.commit(lc.repo)
+		sl.mutex.Unlock()
+	}
+}
+
+// Close indexes and closes a service's log file, for example once it stops
+func (lc *LogCollector) Close(serviceID string) {
+	lc.mutex.Lock()
+	sl, exists := lc.services[serviceID]
+	delete(lc.services, serviceID)
+	lc.mutex.Unlock()
+
+	if exists {
+		sl.mutex.Lock()
+		sl.close(lc.repo)
+		sl.mutex.Unlock()
+	}
+}
+
+// Writer returns a writer that captures a service stream line by line. Close
+// it to capture a final line that does not end in a newline.
+func (lc *LogCollector) Writer(serviceID, stream string) io.WriteCloser {
+	return &lineWriter{collector: lc, serviceID: serviceID, stream: stream}
+}
+
+// RunCommand runs a service process with its stdout and stderr captured into
+// the service's logs, returning once it exits
+func (lc *LogCollector) RunCommand(serviceID string, cmd *exec.Cmd) error {
+	stdout := lc.Writer(serviceID, StreamStdout)
+	stderr := lc.Writer(serviceID, StreamStderr)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	defer stdout.Close()
+	defer stderr.Close()
+
+	return cmd.Run()
+}
+
+// CaptureContainer follows the output of a docker container into the
+// service's logs until the container exits or ctx is cancelled
+func (lc *LogCollector) CaptureContainer(ctx context.Context, serviceID, container string) error {
+	since := time.Now().UTC().Format(time.RFC3339)
+	cmd := exec.CommandContext(ctx, "docker", "logs", "--follow", "--since", since, container)
+
+	stdout := lc.Writer(serviceID, StreamStdout)
+	stderr := lc.Writer(serviceID, StreamStderr)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	defer stdout.Close()
+	defer stderr.Close()
+
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to follow logs of container %s: %w", container, err)
+	}
+	return nil
+}
+
+// WriteLine appends a line of service output received at the given time
+func (lc *LogCollector) WriteLine(serviceID, stream, text string, at time.Time) error {
+	sl, err := lc.serviceLog(serviceID)
+	if err != nil {
+		return err
+	}
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	return sl.write(lc, formatLogLine(at, stream, text))
+}
+
+// serviceLog returns the open log of a service, opening it on first use
+func (lc *LogCollector) serviceLog(serviceID string) (*serviceLog, error) {
+	if !validLogServiceID(serviceID) {
+		return nil, fmt.Errorf("invalid service ID for logs: %q", serviceID)
+	}
+
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	if sl, exists := lc.services[serviceID]; exists {
+		return sl, nil
+	}
+
+	sl := &serviceLog{serviceID: serviceID, dir: filepath.Join(lc.opts.Dir, serviceID)}
+	if err := os.MkdirAll(sl.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	files, err := logFiles(sl.dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		sl.sequence = files[len(files)-1].sequence
+	}
+	if err := sl.open(sl.sequence); err != nil {
+		return nil, err
+	}
+
+	lc.services[serviceID] = sl
+	return sl, nil
+}
+
+// write appends a formatted line, rotating the file first if the line would
+// take it past the size limit
+func (sl *serviceLog) write(lc *LogCollector, line string) error {
+	if sl.file == nil {
+		// Closed by a concurrent Close, carry on in the same file
+		if err := sl.open(sl.sequence); err != nil {
+			return err
+		}
+	}
+	if sl.size > 0 && sl.size+int64(len(line)) > lc.opts.MaxFileSize {
+		if err := sl.rotate(lc); err != nil {
+			return err
+		}
+	}
+
+	n, err := sl.file.WriteString(line)
+	if err != nil {
+		return fmt.Errorf("failed to write service log: %w", err)
+	}
+
+	at, _, _, _ := parseLogLine(line)
+	if sl.chunk.LineCount == 0 {
+		sl.chunk.OffsetStart = sl.size
+		sl.chunk.StartTimestamp = at
+	}
+	sl.size += int64(n)
+	sl.chunk.OffsetEnd = sl.size
+	sl.chunk.EndTimestamp = at
+	sl.chunk.LineCount++
+
+	if sl.chunk.LineCount >= logChunkLines {
+		sl.commit(lc.repo)
+	}
+	return nil
+}
+
+// open opens the log file with the given sequence number for appending
+func (sl *serviceLog) open(sequence int) error {
+	path := filepath.Join(sl.dir, fmt.Sprintf("%06d%s", sequence, logFileSuffix))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open service log: %w", err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat service log: %w", err)
+	}
+
+	sl.file, sl.path, sl.sequence, sl.size = file, path, sequence, stat.Size()
+	sl.chunk = database.LogIndex{}
+	return nil
+}
+
+// rotate indexes and closes the current file, starts the next one and
+// deletes files beyond the retention limit
+func (sl *serviceLog) rotate(lc *LogCollector) error {
+	sl.close(lc.repo)
+	if err := sl.open(sl.sequence + 1); err != nil {
+		return err
+	}
+
+	files, err := logFiles(sl.dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > lc.opts.MaxFiles {
+		if err := os.Remove(files[0].path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to delete service log %s: %v", files[0].path, err)
+		}
+		if _, err := lc.repo.DeleteByFile(sl.serviceID, files[0].path); err != nil {
+			log.Printf("Failed to delete log index for %s: %v", files[0].path, err)
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// commit indexes the pending chunk. A chunk that fails to index is still in
+// the file, where readers pick it up as unindexed data.
+func (sl *serviceLog) commit(repo *database.LogIndexRepository) {
+	if sl.chunk.LineCount == 0 {
+		return
+	}
+
+	chunk := sl.chunk
+	chunk.ServiceID = sl.serviceID
+	chunk.LogFile = sl.path
+	if err := repo.Create(&chunk); err != nil {
+		log.Printf("Failed to index service log %s: %v", sl.path, err)
+	}
+	sl.chunk = database.LogIndex{}
+}
+
+// close indexes the pending chunk and closes the file
+func (sl *serviceLog) close(repo *database.LogIndexRepository) {
+	sl.commit(repo)
+	if sl.file != nil {
+		sl.file.Close()
+		sl.file = nil
+	}
+}
+
+// lineWriter splits written output into lines for a LogCollector
+type lineWriter struct {
+	collector *LogCollector
+	serviceID string
+	stream    string
+	buffer    []byte
+}
+
+// Write captures every complete line in p, buffering any partial line
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	for {
+		i := bytes.IndexByte(w.buffer, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(w.buffer[:i]), "\r")
+		w.buffer = w.buffer[i+1:]
+		if err := w.collector.WriteLine(w.serviceID, w.stream, line, time.Now()); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Close captures any buffered partial line
+func (w *lineWriter) Close() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	line := string(w.buffer)
+	w.buffer = nil
+	return w.collector.WriteLine(w.serviceID, w.stream, line, time.Now())
+}
+
+// validLogServiceID reports whether a service ID can name a log directory
+func validLogServiceID(serviceID string) bool {
+	return serviceID != "" && serviceID != "." && serviceID != ".." && !strings.ContainsAny(serviceID, `/\`)
+}
+
+// logFile is a service log file and its sequence number
+type logFile struct {
+	path     string
+	sequence int
+}
+
+// logFiles lists a service's log files in the order they were written
+func logFiles(dir string) ([]logFile, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log directory: %w", err)
+	}
+
+	var files []logFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, logFileSuffix) {
+			continue
+		}
+		sequence, err := strconv.Atoi(strings.TrimSuffix(name, logFileSuffix))
+		if err != nil {
+			continue
+		}
+		files = append(files, logFile{path: filepath.Join(dir, name), sequence: sequence})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].sequence < files[j].sequence })
+	return files, nil
+}
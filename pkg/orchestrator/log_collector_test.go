@@ -0,0 +1,252 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+var logTestBase = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+// setupLogTest creates a database with a service to collect logs for, and a
+// collector whose files hold five test lines each
+func setupLogTest(t *testing.T) (*database.DB, *LogCollector, *LogReader) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(dir, "console.db")},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	require.NoError(t, db.ServiceRepository().Create(&database.Service{ID: "api", Name: "api", Image: "api:latest", Port: 8080, Status: "running"}))
+
+	opts := LogCollectorOptions{Dir: filepath.Join(dir, "logs"), MaxFileSize: 200, MaxFiles: 3}
+	collector := NewLogCollector(db, opts)
+	t.Cleanup(collector.Stop)
+	return db, collector, NewLogReader(db, opts.Dir)
+}
+
+// writeTestLines writes lines "line NN" one second apart from logTestBase
+func writeTestLines(t *testing.T, collector *LogCollector, from, to int) {
+	for i := from; i < to; i++ {
+		at := logTestBase.Add(time.Duration(i) * time.Second)
+		require.NoError(t, collector.WriteLine("api", StreamStdout, fmt.Sprintf("line %02d", i), at))
+	}
+}
+
+// messages returns the messages of log lines
+func messages(lines []LogLine) []string {
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		result[i] = line.Message
+	}
+	return result
+}
+
+// lineRange returns the messages "line NN" for from <= NN < to
+func lineRange(from, to int) []string {
+	result := []string{}
+	for i := from; i < to; i++ {
+		result = append(result, fmt.Sprintf("line %02d", i))
+	}
+	return result
+}
+
+func TestLogCollectorRotatesAndRetainsFiles(t *testing.T) {
+	db, collector, reader := setupLogTest(t)
+
+	writeTestLines(t, collector, 0, 30)
+	collector.Flush()
+
+	files, err := logFiles(filepath.Join(collector.opts.Dir, "api"))
+	require.NoError(t, err)
+	require.Len(t, files, 3, "older files beyond max_files are deleted")
+	for _, file := range files {
+		stat, err := os.Stat(file.path)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, stat.Size(), int64(200))
+	}
+
+	entries, err := db.LogIndexRepository().ListByService("api")
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	for _, entry := range entries {
+		assert.GreaterOrEqual(t, entry.LogFile, files[0].path, "index entries of deleted files are removed")
+	}
+
+	lines, _, err := reader.Read("api", nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, lineRange(15, 30), messages(lines))
+	assert.Equal(t, StreamStdout, lines[0].Stream)
+	assert.Equal(t, logTestBase.Add(15*time.Second), lines[0].Timestamp)
+}
+
+func TestLogReaderTailAcrossFiles(t *testing.T) {
+	_, collector, reader := setupLogTest(t)
+
+	writeTestLines(t, collector, 0, 15)
+	collector.Flush()
+
+	lines, _, err := reader.Read("api", nil, 7)
+	require.NoError(t, err)
+	assert.Equal(t, lineRange(8, 15), messages(lines))
+
+	// Lines not indexed yet are still read
+	writeTestLines(t, collector, 15, 17)
+	lines, _, err = reader.Read("api", nil, 3)
+	require.NoError(t, err)
+	assert.Equal(t, lineRange(14, 17), messages(lines))
+
+	// The fourth file pushed the first out of retention
+	lines, _, err = reader.Read("api", nil, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, lineRange(5, 17), messages(lines))
+}
+
+func TestLogReaderSinceAcrossFiles(t *testing.T) {
+	_, collector, reader := setupLogTest(t)
+
+	writeTestLines(t, collector, 0, 15)
+	collector.Flush()
+
+	since := logTestBase.Add(7 * time.Second)
+	lines, _, err := reader.Read("api", &since, 0)
+	require.NoError(t, err)
+	assert.Equal(t, lineRange(7, 15), messages(lines))
+
+	lines, _, err = reader.Read("api", &since, 2)
+	require.NoError(t, err)
+	assert.Equal(t, lineRange(13, 15), messages(lines))
+
+	since = logTestBase.Add(time.Hour)
+	lines, _, err = reader.Read("api", &since, 0)
+	require.NoError(t, err)
+	assert.Empty(t, lines)
+}
+
+func TestLogReaderReadFromFollowsRotation(t *testing.T) {
+	_, collector, reader := setupLogTest(t)
+
+	writeTestLines(t, collector, 0, 3)
+	lines, position, err := reader.Read("api", nil, 0)
+	require.NoError(t, err)
+	require.Len(t, lines, 3)
+
+	lines, position, err = reader.ReadFrom("api", position)
+	require.NoError(t, err)
+	assert.Empty(t, lines)
+
+	// Rotates twice, into the second and third files
+	writeTestLines(t, collector, 3, 12)
+	lines, position, err = reader.ReadFrom("api", position)
+	require.NoError(t, err)
+	assert.Equal(t, lineRange(3, 12), messages(lines))
+
+	writeTestLines(t, collector, 12, 13)
+	lines, _, err = reader.ReadFrom("api", position)
+	require.NoError(t, err)
+	assert.Equal(t, lineRange(12, 13), messages(lines))
+}
+
+func TestLogCollectorRunCommand(t *testing.T) {
+	_, collector, reader := setupLogTest(t)
+
+	cmd := exec.Command("sh", "-c", "echo started; echo failed >&2; printf unterminated")
+	require.NoError(t, collector.RunCommand("api", cmd))
+	collector.Close("api")
+
+	lines, _, err := reader.Read("api", nil, 0)
+	require.NoError(t, err)
+	require.Len(t, lines, 3)
+
+	streams := map[string]string{}
+	for _, line := range lines {
+		streams[line.Message] = line.Stream
+	}
+	assert.Equal(t, StreamStdout, streams["started"])
+	assert.Equal(t, StreamStderr, streams["failed"])
+	assert.Equal(t, StreamStdout, streams["unterminated"])
+}
+
+func TestLogCollectorRejectsInvalidServiceID(t *testing.T) {
+	_, collector, _ := setupLogTest(t)
+
+	assert.Error(t, collector.WriteLine("..", StreamStdout, "escape", time.Now()))
+	assert.Error(t, collector.WriteLine("a/b", StreamStdout, "escape", time.Now()))
+}
+
+func TestGetServiceLogsFromCollector(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, collector, _ := setupLogTest(t)
+
+	o := New(db, &config.Config{})
+	o.logs = collector
+	o.logReader = NewLogReader(db, collector.opts.Dir)
+	o.services["api"] = &ServiceInstance{ID: "api", Name: "api", Status: "running"}
+	r := setupTestRouter(o)
+
+	writeTestLines(t, collector, 0, 8)
+	collector.Flush()
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/services/api/logs?"+query, nil))
+		return w
+	}
+
+	w := get("tail=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Logs []string `json:"logs"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, []string{
+		"2026-10-16T12:00:06Z stdout line 06",
+		"2026-10-16T12:00:07Z stdout line 07",
+	}, body.Logs)
+
+	w = get("since=2026-10-16T12:00:05Z")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Len(t, body.Logs, 3)
+
+	for _, query := range []string{"tail=0", "tail=many", "since=today", "follow=maybe"} {
+		assert.Equal(t, http.StatusBadRequest, get(query).Code, query)
+	}
+
+	// Following streams the tail, then new lines until the client goes away
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(2 * followPollInterval)
+		for i := 8; i < 10; i++ {
+			at := logTestBase.Add(time.Duration(i) * time.Second)
+			assert.NoError(t, collector.WriteLine("api", StreamStdout, fmt.Sprintf("line %02d", i), at))
+		}
+	}()
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/services/api/logs?tail=1&follow=true", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "2026-10-16T12:00:07Z stdout line 07\n"+
+		"2026-10-16T12:00:08Z stdout line 08\n"+
+		"2026-10-16T12:00:09Z stdout line 09\n", w.Body.String())
+}
@@ -0,0 +1,373 @@
+package orchestrator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// Service log query limits
+const (
+	defaultLogTail     = 100
+	maxLogTail         = 10000
+	followPollInterval = 250 * time.Millisecond
+)
+
+// LogLine is one captured line of service output
+type LogLine struct {
+	Timestamp time.Time `json:"timestamp"`
+	Stream    string    `json:"stream"`
+	Message   string    `json:"message"`
+}
+
+// String formats the line as it is stored: "<RFC3339Nano> <stream> <message>"
+func (l LogLine) String() string {
+	return strings.TrimSuffix(formatLogLine(l.Timestamp, l.Stream, l.Message), "\n")
+}
+
+// LogPosition is a point in a service's logs that following resumes from
+type LogPosition struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+}
+
+// LogReader reads service logs written by a LogCollector, using logs_index
+// to seek to the chunks a query needs instead of reading whole files. Data
+// not indexed yet, such as the chunk being written, is read from the files.
+type LogReader struct {
+	repo *database.LogIndexRepository
+	dir  string
+}
+
+// NewLogReader creates a reader for the service logs under dir
+func NewLogReader(db *database.DB, dir string) *LogReader {
+	return &LogReader{repo: db.LogIndexRepository(), dir: dir}
+}
+
+// logSegment is a byte range of a log file, with the line count and last
+// timestamp from the index. lines is -1 for ranges that are not indexed.
+type logSegment struct {
+	file  string
+	start int64
+	end   int64
+	lines int
+	last  time.Time
+}
+
+// Read returns a service's lines at or after since, if set, keeping only the
+// last tail lines if tail is positive. It also returns the position to follow
+// new lines from.
+func (r *LogReader) Read(serviceID string, since *time.Time, tail int) ([]LogLine, LogPosition, error) {
+	if r == nil {
+		return []LogLine{}, LogPosition{}, nil
+	}
+
+	segments, err := r.segments(serviceID)
+	if err != nil {
+		return nil, LogPosition{}, err
+	}
+
+	first := 0
+	if since != nil {
+		for first < len(segments) && segments[first].lines >= 0 && segments[first].last.Before(*since) {
+			first++
+		}
+	}
+	if tail > 0 {
+		count, i := 0, len(segments)
+		for i > first && count < tail {
+			i--
+			if segments[i].lines >= 0 {
+				count += segments[i].lines
+				continue
+			}
+			lines, _, err := readLogRange(segments[i].file, segments[i].start, segments[i].end)
+			if err != nil {
+				return nil, LogPosition{}, err
+			}
+			count += len(lines)
+		}
+		first = i
+	}
+
+	lines := []LogLine{}
+	position := LogPosition{}
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		position = LogPosition{File: last.file, Offset: last.end}
+	}
+	for _, segment := range segments[first:] {
+		read, end, err := readLogRange(segment.file, segment.start, segment.end)
+		if err != nil {
+			return nil, LogPosition{}, err
+		}
+		for _, line := range read {
+			if since == nil || !line.Timestamp.Before(*since) {
+				lines = append(lines, line)
+			}
+		}
+		position = LogPosition{File: segment.file, Offset: end}
+	}
+
+	if tail > 0 && len(lines) > tail {
+		lines = lines[len(lines)-tail:]
+	}
+	return lines, position, nil
+}
+
+// ReadFrom returns the complete lines written after a position, moving on to
+// newer files when the service's log has rotated, and the position after them
+func (r *LogReader) ReadFrom(serviceID string, from LogPosition) ([]LogLine, LogPosition, error) {
+	if r == nil {
+		return []LogLine{}, from, nil
+	}
+
+	if !validLogServiceID(serviceID) {
+		return []LogLine{}, from, nil
+	}
+	files, err := logFiles(filepath.Join(r.dir, serviceID))
+	if err != nil {
+		return nil, from, err
+	}
+
+	lines := []LogLine{}
+	position := from
+	for _, file := range files {
+		if file.path < from.File {
+			continue
+		}
+		start := int64(0)
+		if file.path == from.File {
+			start = from.Offset
+		}
+
+		read, end, err := readLogRange(file.path, start, -1)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, from, err
+		}
+		lines = append(lines, read...)
+		position = LogPosition{File: file.path, Offset: end}
+	}
+	return lines, position, nil
+}
+
+// segments lists the ranges of a service's log files in order, taking
+// indexed chunks from logs_index and filling the gaps between them
+func (r *LogReader) segments(serviceID string) ([]logSegment, error) {
+	if !validLogServiceID(serviceID) {
+		return nil, nil
+	}
+	files, err := logFiles(filepath.Join(r.dir, serviceID))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := r.repo.ListByService(serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := make(map[string][]*database.LogIndex)
+	for _, entry := range entries {
+		indexed[entry.LogFile] = append(indexed[entry.LogFile], entry)
+	}
+
+	var segments []logSegment
+	for _, file := range files {
+		stat, err := os.Stat(file.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat service log: %w", err)
+		}
+
+		offset := int64(0)
+		for _, entry := range indexed[file.path] {
+			if entry.OffsetStart < offset || entry.OffsetEnd > stat.Size() {
+				continue
+			}
+			if entry.OffsetStart > offset {
+				segments = append(segments, logSegment{file: file.path, start: offset, end: entry.OffsetStart, lines: -1})
+			}
+			segments = append(segments, logSegment{
+				file:  file.path,
+				start: entry.OffsetStart,
+				end:   entry.OffsetEnd,
+				lines: entry.LineCount,
+				last:  entry.EndTimestamp,
+			})
+			offset = entry.OffsetEnd
+		}
+		if stat.Size() > offset {
+			segments = append(segments, logSegment{file: file.path, start: offset, end: stat.Size(), lines: -1})
+		}
+	}
+	return segments, nil
+}
+
+// readLogRange reads the complete lines between two offsets of a file, or to
+// the end of the file if end is negative, and returns the offset after the
+// last complete line
+func readLogRange(path string, start, end int64) ([]LogLine, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, start, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return nil, start, fmt.Errorf("failed to seek service log: %w", err)
+	}
+	var source io.Reader = file
+	if end >= 0 {
+		source = io.LimitReader(file, end-start)
+	}
+
+	lines := []LogLine{}
+	offset := start
+	reader := bufio.NewReader(source)
+	for {
+		raw, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, start, fmt.Errorf("failed to read service log: %w", err)
+		}
+		offset += int64(len(raw))
+
+		at, stream, message, ok := parseLogLine(raw)
+		if !ok {
+			message = strings.TrimSuffix(raw, "\n")
+		}
+		lines = append(lines, LogLine{Timestamp: at, Stream: stream, Message: message})
+	}
+	return lines, offset, nil
+}
+
+// formatLogLine formats a line for storage. Newlines in the text are replaced
+// so that every stored line is one line of the file.
+func formatLogLine(at time.Time, stream, text string) string {
+	text = strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+	return at.UTC().Format(time.RFC3339Nano) + " " + stream + " " + text + "\n"
+}
+
+// parseLogLine splits a stored line into its timestamp, stream and text
+func parseLogLine(line string) (time.Time, string, string, bool) {
+	parts := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 3)
+	if len(parts) < 2 {
+		return time.Time{}, "", "", false
+	}
+	at, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", "", false
+	}
+	text := ""
+	if len(parts) == 3 {
+		text = parts[2]
+	}
+	return at, parts[1], text, true
+}
+
+// ServeServiceLogs writes a service's logs. Query parameters: tail=N for the
+// last N lines (default 100, or every line when since is set), since=<RFC3339>
+// and follow=true, which keeps the response open and streams new lines as
+// plain text until the client disconnects.
+func ServeServiceLogs(c *gin.Context, reader *LogReader, serviceID string) {
+	var since *time.Time
+	if value := c.Query("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since time, expected RFC3339"})
+			return
+		}
+		since = &parsed
+	}
+
+	tail := defaultLogTail
+	if since != nil {
+		tail = maxLogTail
+	}
+	if value := c.Query("tail"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxLogTail {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tail, expected 1 to " + strconv.Itoa(maxLogTail)})
+			return
+		}
+		tail = parsed
+	}
+
+	follow := false
+	if value := c.Query("follow"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid follow, expected true or false"})
+			return
+		}
+		follow = parsed
+	}
+
+	lines, position, err := reader.Read(serviceID, since, tail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read service logs"})
+		return
+	}
+
+	if !follow {
+		logs := make([]string, len(lines))
+		for i, line := range lines {
+			logs[i] = line.String()
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"service_id": serviceID,
+			"logs":       logs,
+			"total":      len(logs),
+			"tail":       tail,
+			"since":      c.Query("since"),
+		})
+		return
+	}
+
+	// Following outlives the server's write timeout
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	for {
+		for _, line := range lines {
+			if _, err := io.WriteString(c.Writer, line.String()+"\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		lines, position, err = reader.ReadFrom(serviceID, position)
+		if err != nil {
+			return
+		}
+	}
+}
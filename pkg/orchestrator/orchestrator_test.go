@@ -365,6 +365,7 @@ func setupTestRouter(orchestrator *Orchestrator) *gin.Engine {
 	r.GET("/deployments/:id", orchestrator.GetDeployment)
 	r.POST("/deployments/:id/rollback", orchestrator.RollbackDeployment)
 	r.DELETE("/deployments/:id", orchestrator.DeleteDeployment)
+	r.POST("/deployments/bulk", orchestrator.BulkDeployments)
 	r.GET("/nodes", orchestrator.ListNodes)
 	r.GET("/cluster/resources", orchestrator.GetClusterResources)
 	r.GET("/cluster/events", orchestrator.GetClusterEvents)
@@ -413,6 +414,38 @@ func TestDeployServiceHandler(t *testing.T) {
 	assert.Equal(t, "deploying", response["status"])
 }
 
+func TestDeployServiceFromSpec(t *testing.T) {
+	orchestrator := New(&database.DB{}, &config.Config{})
+	r := setupTestRouter(orchestrator)
+
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{
+		Spec: "name: api\nimage: api:1.2\nport: 8080\nreplicas: 2\nresources:\n  memory: 256Mi\n",
+	})
+	require.Equal(t, http.StatusCreated, code, response)
+	assert.Equal(t, []interface{}{"api-0", "api-1"}, response["services"])
+	orchestrator.mutex.RLock()
+	deployed := orchestrator.deployments[response["deployment_id"].(string)].Request
+	orchestrator.mutex.RUnlock()
+	assert.Equal(t, "api:1.2", deployed.Image)
+	assert.Equal(t, 8080, deployed.Port)
+	assert.Equal(t, "256Mi", deployed.Resources.Memory)
+
+	// Invalid specs and requests are rejected with every problem listed
+	for _, req := range []DeployRequest{
+		{Spec: "name: web\nimage: web:1\nreplcas: 2\nport: http\n"},
+		{Name: "web", Image: "web:1", Replicas: -1, Resources: &ResourceRequirements{CPU: "fast"}},
+	} {
+		code, response := serveJSON(t, r, http.MethodPost, "/deploy", req)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "Invalid service spec", response["error"])
+		assert.Len(t, response["errors"], 2)
+	}
+
+	code, response = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "web", Spec: "name: web\nimage: web:1\n"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "spec", response["errors"].([]interface{})[0].(map[string]interface{})["field"])
+}
+
 func TestStartServiceHandler(t *testing.T) {
 	mockDB := &database.DB{}
 	mockConfig := &config.Config{}
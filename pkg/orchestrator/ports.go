@@ -0,0 +1,236 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+// Default range of ports allocated to services without one
+const (
+	defaultServicePortMin = 20000
+	defaultServicePortMax = 29999
+)
+
+var (
+	// ErrNoFreePort is returned when every port of the allocation range is
+	// taken
+	ErrNoFreePort = errors.New("no free port left in orchestrator.service_ports")
+
+	// ErrPortOutOfRange is returned when the replicas of a service would
+	// take ports above 65535
+	ErrPortOutOfRange = errors.New("the ports of the service's replicas run past 65535")
+)
+
+// PortConflictError is returned when a port a service asks for is taken
+type PortConflictError struct {
+	Port  int
+	Owner string // such as "service web" or "the gate"
+}
+
+func (e *PortConflictError) Error() string {
+	return fmt.Sprintf("port %d is already used by %s", e.Port, e.Owner)
+}
+
+// PortRegistry keeps services off each other's ports. A service takes its
+// port and, with several replicas, the ports after it, one per replica; it
+// may not take a port of another service, of a daemon, or that a process on
+// the host listens on. Assignments are serialized, so concurrent creates
+// and deploys never get the same port.
+type PortRegistry struct {
+	db       *database.DB
+	daemons  map[int]string // daemon ports to their owner
+	min, max int
+	inUse    func(port int) bool // whether a process on the host listens on a port
+	mutex    sync.Mutex
+}
+
+// NewPortRegistry creates a registry of the ports of the services in the
+// database and of the daemons configured in cfg
+func NewPortRegistry(db *database.DB, cfg *config.Config) *PortRegistry {
+	r := &PortRegistry{
+		db:      db,
+		daemons: make(map[int]string),
+		min:     defaultServicePortMin,
+		max:     defaultServicePortMax,
+		inUse:   listening,
+	}
+	if ports := cfg.Orchestrator.ServicePorts; ports.Min > 0 && ports.Max >= ports.Min {
+		r.min, r.max = ports.Min, ports.Max
+	}
+
+	reserve := func(port int, owner string) {
+		if port > 0 && r.daemons[port] == "" {
+			r.daemons[port] = owner
+		}
+	}
+	reserve(cfg.Gate.Ports.HTTP, "the gate")
+	reserve(cfg.Gate.Ports.HTTPS, "the gate")
+	if cfg.Gate.Ports.HTTP > 0 {
+		reserve(cfg.Gate.Ports.HTTP+1000, "the gate's management API")
+	}
+	reserve(cfg.Console.Port, "the console")
+	reserve(cfg.Orchestrator.Port, "the orchestrator")
+	reserve(cfg.Probe.Port, "the probe daemon")
+	reserve(cfg.Snap.Port, "the snap daemon")
+	return r
+}
+
+// Assign checks the ports a service asks for, port and one more per replica
+// after the first, and calls save with the port while no other assignment
+// runs. A port of 0 keeps the service's current port; a service without one
+// is given the first free port of orchestrator.service_ports if allocate is
+// set, and no port otherwise. Ports the service already has are not
+// conflicts, even though its running instances listen on them. It returns
+// the port, a *PortConflictError when the port is taken, ErrPortOutOfRange
+// or ErrNoFreePort.
+func (r *PortRegistry) Assign(name string, port, replicas int, allocate bool, save func(port int) error) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if replicas < 1 {
+		replicas = 1
+	}
+	others, own, current, err := r.owners(name)
+	if err != nil {
+		return 0, err
+	}
+
+	if port == 0 {
+		port = current
+	}
+	if port == 0 && allocate {
+		if port, err = r.allocate(replicas, others, own); err != nil {
+			return 0, err
+		}
+	} else if port != 0 {
+		if err := r.check(port, replicas, others, own); err != nil {
+			return 0, err
+		}
+	}
+	if err := save(port); err != nil {
+		return 0, err
+	}
+	return port, nil
+}
+
+// owners maps the ports of the daemons and of services other than name to
+// their owner, and returns the ports name takes and its primary port
+func (r *PortRegistry) owners(name string) (map[int]string, map[int]bool, int, error) {
+	services, err := r.db.ServiceRepository().List()
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to list service ports: %w", err)
+	}
+
+	others := make(map[int]string, len(r.daemons)+len(services))
+	for port, owner := range r.daemons {
+		others[port] = owner
+	}
+	own := make(map[int]bool)
+	current := 0
+	for _, service := range services {
+		if service.Port <= 0 {
+			continue
+		}
+		replicas := max(service.Replicas, 1)
+		for port := service.Port; port < service.Port+replicas && port <= 65535; port++ {
+			if service.Name == name {
+				own[port] = true
+			} else if others[port] == "" {
+				others[port] = "service " + service.Name
+			}
+		}
+		if service.Name == name {
+			current = service.Port
+		}
+	}
+	return others, own, current, nil
+}
+
+// check returns the first conflict on the ports from port
+func (r *PortRegistry) check(port, replicas int, others map[int]string, own map[int]bool) error {
+	if port+replicas-1 > 65535 {
+		return ErrPortOutOfRange
+	}
+	for p := port; p < port+replicas; p++ {
+		if owner := others[p]; owner != "" {
+			return &PortConflictError{Port: p, Owner: owner}
+		}
+		if !own[p] && r.inUse(p) {
+			return &PortConflictError{Port: p, Owner: "another process on the host"}
+		}
+	}
+	return nil
+}
+
+// allocate returns the first port of the range free for replicas ports
+func (r *PortRegistry) allocate(replicas int, others map[int]string, own map[int]bool) (int, error) {
+	for port := r.min; port+replicas-1 <= r.max; port++ {
+		if r.check(port, replicas, others, own) == nil {
+			return port, nil
+		}
+	}
+	return 0, ErrNoFreePort
+}
+
+// deployOnPort deploys a request once its port is checked. A request without
+// a port takes that of the service's record, which the console allocated,
+// and is otherwise deployed without one. Callers hold o.mutex.
+func (o *Orchestrator) deployOnPort(req DeployRequest) (*Deployment, []string, error) {
+	if o.ports == nil {
+		return o.deploy(req)
+	}
+
+	var deployment *Deployment
+	var createdServices []string
+	_, err := o.ports.Assign(req.Name, req.Port, req.Replicas, false, func(port int) error {
+		if req.Spec != "" && port != req.Port {
+			document, err := spec.WithPort(req.Spec, port)
+			if err != nil {
+				return err
+			}
+			req.Spec = document
+		}
+		req.Port = port
+
+		var err error
+		deployment, createdServices, err = o.deploy(req)
+		return err
+	})
+	return deployment, createdServices, err
+}
+
+// respondPortError responds to a port that could not be assigned, reporting
+// whether err was one
+func respondPortError(c *gin.Context, err error) bool {
+	var conflict *PortConflictError
+	switch {
+	case errors.As(err, &conflict):
+		c.JSON(http.StatusConflict, gin.H{"error": conflict.Error(), "port": conflict.Port, "owner": conflict.Owner})
+	case errors.Is(err, ErrPortOutOfRange):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNoFreePort):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// listening reports whether a process on the host listens on a TCP port
+func listening(port int) bool {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return true
+	}
+	listener.Close()
+	return false
+}
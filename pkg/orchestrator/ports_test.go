@@ -0,0 +1,154 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// newTestPortRegistry returns a registry over a fresh database, allocating
+// from 30000 to 30009, with the console on 8082 and no host listeners
+func newTestPortRegistry(t *testing.T) (*PortRegistry, *database.DB) {
+	cfg := &config.Config{
+		Console:      config.ConsoleConfig{Port: 8082, Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")}},
+		Gate:         config.GateConfig{Ports: config.PortsConfig{HTTP: 8080, HTTPS: 8443}},
+		Orchestrator: config.OrchestratorConfig{ServicePorts: config.ServicePortsConfig{Min: 30000, Max: 30009}},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	registry := NewPortRegistry(db, cfg)
+	registry.inUse = func(int) bool { return false }
+	return registry, db
+}
+
+func TestPortRegistryConflicts(t *testing.T) {
+	registry, db := newTestPortRegistry(t)
+	require.NoError(t, db.ServiceRepository().Create(&database.Service{Name: "web", Image: "nginx", Port: 8091, Replicas: 2, Status: "stopped"}))
+
+	noSave := func(int) error { return nil }
+	tests := []struct {
+		name     string
+		service  string
+		port     int
+		replicas int
+		owner    string
+	}{
+		{"another service", "api", 8091, 1, "service web"},
+		{"the port of another service's replica", "api", 8092, 1, "service web"},
+		{"a replica on another service's port", "api", 8089, 3, "service web"},
+		{"the gate", "api", 8443, 1, "the gate"},
+		{"the gate's management API", "api", 9080, 1, "the gate's management API"},
+		{"the console", "api", 8082, 1, "the console"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := registry.Assign(tt.service, tt.port, tt.replicas, true, noSave)
+			var conflict *PortConflictError
+			require.ErrorAs(t, err, &conflict)
+			assert.Equal(t, tt.owner, conflict.Owner)
+		})
+	}
+
+	// A service keeps its own ports, even while its instances listen on them
+	registry.inUse = func(port int) bool { return port == 8091 || port == 9000 }
+	port, err := registry.Assign("web", 0, 2, true, noSave)
+	require.NoError(t, err)
+	assert.Equal(t, 8091, port)
+
+	_, err = registry.Assign("api", 9000, 1, true, noSave)
+	assert.EqualError(t, err, "port 9000 is already used by another process on the host")
+
+	_, err = registry.Assign("api", 65535, 2, true, noSave)
+	assert.ErrorIs(t, err, ErrPortOutOfRange)
+
+	// Nothing is saved on a conflict
+	saved := false
+	_, err = registry.Assign("api", 8091, 1, true, func(int) error { saved = true; return nil })
+	assert.Error(t, err)
+	assert.False(t, saved)
+}
+
+func TestPortRegistryAllocatesUniquePorts(t *testing.T) {
+	registry, db := newTestPortRegistry(t)
+	require.NoError(t, db.ServiceRepository().Create(&database.Service{Name: "taken", Image: "nginx", Port: 30000, Status: "stopped"}))
+
+	var wg sync.WaitGroup
+	ports := make([]int, 9)
+	errs := make([]error, len(ports))
+	for i := range ports {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("svc-%d", i)
+			ports[i], errs[i] = registry.Assign(name, 0, 1, true, func(port int) error {
+				return db.ServiceRepository().Create(&database.Service{Name: name, Image: "nginx", Port: port, Status: "stopped"})
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[int]bool{}
+	for i, port := range ports {
+		require.NoError(t, errs[i])
+		assert.True(t, port > 30000 && port <= 30009, port)
+		assert.False(t, seen[port], "port %d allocated twice", port)
+		seen[port] = true
+	}
+
+	// The range is used up
+	_, err := registry.Assign("one-more", 0, 1, true, func(int) error { return nil })
+	assert.ErrorIs(t, err, ErrNoFreePort)
+
+	// Without allocate a service without a port keeps none
+	port, err := registry.Assign("worker", 0, 1, false, func(int) error { return nil })
+	require.NoError(t, err)
+	assert.Zero(t, port)
+
+	// A failed save releases the port
+	failed := errors.New("disk full")
+	_, err = registry.Assign("svc-0", 0, 1, true, func(int) error { return failed })
+	assert.ErrorIs(t, err, failed)
+}
+
+func TestDeployRejectsTakenPorts(t *testing.T) {
+	cfg := setupDeploymentTest(t)
+	cfg.Console.Port = 8082
+	db, o, r := startTestOrchestrator(t, cfg)
+	o.ports.inUse = func(int) bool { return false }
+	deployAndWait(t, o, r, "web:1")
+
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "api", Image: "api:1", Port: 8080})
+	require.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "port 8080 is already used by service web", response["error"])
+	assert.Equal(t, "service web", response["owner"])
+
+	code, response = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "api", Image: "api:1", Port: 8082})
+	require.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "the console", response["owner"])
+
+	// A service deployed without a port takes the one of its record
+	service, err := db.ServiceRepository().GetByName("web")
+	require.NoError(t, err)
+	code, response = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Spec: "name: web\nimage: web:2\n"})
+	require.Equal(t, http.StatusCreated, code, response)
+	assert.Equal(t, float64(service.Port), response["port"])
+
+	// Moving a service to a free port updates its record
+	waitForStatus(t, o, response["deployment_id"].(string), "deployed")
+	code, _ = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "web", Image: "web:3", Port: 8090})
+	require.Equal(t, http.StatusCreated, code)
+	service, err = db.ServiceRepository().GetByName("web")
+	require.NoError(t, err)
+	assert.Equal(t, 8090, service.Port)
+}
@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd)
+
+package orchestrator
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing on platforms without process groups
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcess signals a started command. Platforms without signals only
+// support killing it.
+func signalProcess(cmd *exec.Cmd, sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		return cmd.Process.Kill()
+	}
+	return cmd.Process.Signal(sig)
+}
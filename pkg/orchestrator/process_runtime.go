@@ -0,0 +1,329 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Process runtime defaults
+const (
+	defaultStopGracePeriod = 10 * time.Second
+	defaultRestartDelay    = time.Second
+	defaultMaxRestartDelay = 30 * time.Second
+)
+
+// ProcessRuntimeOptions configures a ProcessRuntime. Zero values use the
+// defaults.
+type ProcessRuntimeOptions struct {
+	GracePeriod     time.Duration // how long Stop waits after SIGTERM before SIGKILL
+	RestartDelay    time.Duration // wait before a restart, doubled for each consecutive one
+	MaxRestartDelay time.Duration // cap of the restart delay; runs longer than it reset the delay
+}
+
+// ProcessRuntime runs service instances as local processes from their
+// command, args and environment, with their output captured into the
+// service logs
+type ProcessRuntime struct {
+	logs      *LogCollector
+	opts      ProcessRuntimeOptions
+	mutex     sync.Mutex
+	processes map[string]*process
+}
+
+// process is a supervised service instance. Its fields are guarded by the
+// runtime's mutex.
+type process struct {
+	id       string
+	logKey   string
+	name     string
+	args     []string
+	env      []string
+	policy   string
+	cmd      *exec.Cmd
+	outputs  []io.WriteCloser
+	pid      int
+	started  time.Time
+	status   string
+	restarts int
+	exited   string        // why the process last exited before a restart
+	stop     chan struct{} // closed by Stop
+	done     chan struct{} // closed once the process is no longer supervised
+}
+
+// NewProcessRuntime creates a process runtime capturing output with logs,
+// which may be nil to discard it
+func NewProcessRuntime(logs *LogCollector, opts ProcessRuntimeOptions) *ProcessRuntime {
+	if opts.GracePeriod <= 0 {
+		opts.GracePeriod = defaultStopGracePeriod
+	}
+	if opts.RestartDelay <= 0 {
+		opts.RestartDelay = defaultRestartDelay
+	}
+	if opts.MaxRestartDelay < opts.RestartDelay {
+		opts.MaxRestartDelay = max(defaultMaxRestartDelay, opts.RestartDelay)
+	}
+
+	return &ProcessRuntime{
+		logs:      logs,
+		opts:      opts,
+		processes: make(map[string]*process),
+	}
+}
+
+// Start launches a service instance's command, followed by its args
+func (r *ProcessRuntime) Start(ctx context.Context, service *ServiceInstance) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(service.Command) == 0 {
+		return fmt.Errorf("service instance %s has no command to run", service.ID)
+	}
+	if !validRestartPolicy(service.RestartPolicy) {
+		return fmt.Errorf("invalid restart policy %q", service.RestartPolicy)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if p, exists := r.processes[service.ID]; exists {
+		select {
+		case <-p.done:
+		default:
+			return fmt.Errorf("service instance %s is already running", service.ID)
+		}
+	}
+
+	p := &process{
+		id:     service.ID,
+		logKey: logKey(service),
+		name:   service.Command[0],
+		args:   append(append([]string{}, service.Command[1:]...), service.Args...),
+		env:    os.Environ(),
+		policy: service.RestartPolicy,
+		status: "starting",
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if p.policy == "" {
+		p.policy = RestartOnFailure
+	}
+	keys := make([]string, 0, len(service.Environment))
+	for key := range service.Environment {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		p.env = append(p.env, key+"="+service.Environment[key])
+	}
+
+	if err := r.spawn(p); err != nil {
+		return err
+	}
+	r.processes[service.ID] = p
+	go r.supervise(p)
+
+	log.Printf("🚀 Started service instance %s (pid %d)", p.id, p.pid)
+	return nil
+}
+
+// Stop sends SIGTERM to an instance, then SIGKILL if it has not exited after
+// the grace period or once ctx is done, and waits for it to exit
+func (r *ProcessRuntime) Stop(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	p, exists := r.processes[id]
+	if !exists {
+		r.mutex.Unlock()
+		return ErrInstanceNotFound
+	}
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	cmd := p.cmd
+	running := p.pid != 0
+	if running {
+		p.status = "stopping"
+	}
+	r.mutex.Unlock()
+
+	if running {
+		_ = signalProcess(cmd, syscall.SIGTERM)
+	}
+
+	timer := time.NewTimer(r.opts.GracePeriod)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	log.Printf("⚠️  Killing service instance %s, it did not stop in time", id)
+	_ = signalProcess(cmd, syscall.SIGKILL)
+	<-p.done
+	return nil
+}
+
+// Status reports the status of an instance
+func (r *ProcessRuntime) Status(ctx context.Context, id string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p, exists := r.processes[id]
+	if !exists {
+		return "", ErrInstanceNotFound
+	}
+	return p.status, nil
+}
+
+// PID returns the process ID of an instance, or 0 if it is not running
+func (r *ProcessRuntime) PID(id string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if p, exists := r.processes[id]; exists {
+		return p.pid
+	}
+	return 0
+}
+
+// Restarts reports how many times an instance's process was restarted since
+// the instance was started and why it last exited
+func (r *ProcessRuntime) Restarts(ctx context.Context, id string) (int, string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p, exists := r.processes[id]
+	if !exists {
+		return 0, "", ErrInstanceNotFound
+	}
+	return p.restarts, p.exited, nil
+}
+
+// spawn starts a process's command. Callers hold r.mutex.
+func (r *ProcessRuntime) spawn(p *process) error {
+	cmd := exec.Command(p.name, p.args...)
+	cmd.Env = p.env
+	cmd.WaitDelay = r.opts.GracePeriod
+	setProcessGroup(cmd)
+
+	var outputs []io.WriteCloser
+	if r.logs != nil {
+		stdout := r.logs.Writer(p.logKey, StreamStdout)
+		stderr := r.logs.Writer(p.logKey, StreamStderr)
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		outputs = []io.WriteCloser{stdout, stderr}
+	}
+
+	if err := cmd.Start(); err != nil {
+		for _, output := range outputs {
+			output.Close()
+		}
+		return fmt.Errorf("failed to start service instance %s: %w", p.id, err)
+	}
+
+	p.cmd = cmd
+	p.outputs = outputs
+	p.pid = cmd.Process.Pid
+	p.started = time.Now()
+	p.status = "running"
+	return nil
+}
+
+// supervise waits for a process to exit and restarts it as its restart
+// policy says, until it is stopped or not restarted
+func (r *ProcessRuntime) supervise(p *process) {
+	defer close(p.done)
+
+	delay := r.opts.RestartDelay
+	for {
+		err := p.cmd.Wait()
+		for _, output := range p.outputs {
+			output.Close()
+		}
+
+		r.mutex.Lock()
+		p.pid = 0
+		if r.stopped(p) {
+			r.mutex.Unlock()
+			return
+		}
+		if p.policy == RestartNever || (p.policy == RestartOnFailure && err == nil) {
+			p.status = "exited"
+			if err != nil {
+				p.status = "failed"
+				log.Printf("❌ Service instance %s failed: %v", p.id, err)
+			}
+			r.mutex.Unlock()
+			return
+		}
+		if time.Since(p.started) > r.opts.MaxRestartDelay {
+			delay = r.opts.RestartDelay
+		}
+		p.status = "restarting"
+		p.exited = exitReason(err)
+		r.mutex.Unlock()
+
+		log.Printf("🔁 Restarting service instance %s in %s, it exited: %v", p.id, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-p.stop:
+			timer.Stop()
+		case <-timer.C:
+		}
+		delay = min(delay*2, r.opts.MaxRestartDelay)
+
+		r.mutex.Lock()
+		if r.stopped(p) {
+			r.mutex.Unlock()
+			return
+		}
+		if err := r.spawn(p); err != nil {
+			p.status = "failed"
+			r.mutex.Unlock()
+			log.Printf("❌ Failed to restart service instance %s: %v", p.id, err)
+			return
+		}
+		p.restarts++
+		r.mutex.Unlock()
+	}
+}
+
+// stopped marks a process stopped if Stop was called for it. Callers hold
+// r.mutex.
+func (r *ProcessRuntime) stopped(p *process) bool {
+	select {
+	case <-p.stop:
+		p.status = "stopped"
+		return true
+	default:
+		return false
+	}
+}
+
+// exitReason describes why a supervised process exited. The kernel kills
+// processes that run out of memory with SIGKILL, which the runtime only
+// sends to processes it stops, so it is reported as a likely cause.
+func exitReason(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
+			return "killed by SIGKILL, possibly out of memory"
+		}
+	}
+	if err == nil {
+		return "exited"
+	}
+	return "exited: " + err.Error()
+}
@@ -0,0 +1,205 @@
+//go:build linux || darwin || freebsd
+
+package orchestrator
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestProcessRuntime creates a runtime with short delays that stops its
+// instances when the test ends
+func newTestProcessRuntime(t *testing.T, grace time.Duration) *ProcessRuntime {
+	runtime := NewProcessRuntime(nil, ProcessRuntimeOptions{
+		GracePeriod:     grace,
+		RestartDelay:    10 * time.Millisecond,
+		MaxRestartDelay: 50 * time.Millisecond,
+	})
+	t.Cleanup(func() {
+		for id := range runtime.processes {
+			_ = runtime.Stop(context.Background(), id)
+		}
+	})
+	return runtime
+}
+
+func TestProcessRuntimeStartStop(t *testing.T) {
+	runtime := newTestProcessRuntime(t, 5*time.Second)
+	ctx := context.Background()
+
+	service := &ServiceInstance{ID: "sleeper", Command: []string{"sleep"}, Args: []string{"30"}}
+	require.NoError(t, runtime.Start(ctx, service))
+	assert.Equal(t, "running", runtimeStatus(t, runtime, "sleeper"))
+	pid := runtime.PID("sleeper")
+	require.Positive(t, pid)
+	assert.Error(t, runtime.Start(ctx, service), "an instance cannot be started twice")
+
+	// sleep exits on SIGTERM, well within the grace period
+	started := time.Now()
+	require.NoError(t, runtime.Stop(ctx, "sleeper"))
+	assert.Less(t, time.Since(started), 5*time.Second)
+	assert.Equal(t, "stopped", runtimeStatus(t, runtime, "sleeper"))
+	assert.Zero(t, runtime.PID("sleeper"))
+	assert.ErrorIs(t, syscall.Kill(pid, 0), syscall.ESRCH)
+
+	// A stopped instance can be started again
+	require.NoError(t, runtime.Start(ctx, service))
+	assert.Equal(t, "running", runtimeStatus(t, runtime, "sleeper"))
+
+	_, err := runtime.Status(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+	assert.ErrorIs(t, runtime.Stop(ctx, "unknown"), ErrInstanceNotFound)
+	assert.Error(t, runtime.Start(ctx, &ServiceInstance{ID: "no-command"}))
+	assert.Error(t, runtime.Start(ctx, &ServiceInstance{ID: "missing", Command: []string{"/nonexistent/binary"}}))
+}
+
+func TestProcessRuntimeKillsAfterGracePeriod(t *testing.T) {
+	grace := 200 * time.Millisecond
+	runtime := newTestProcessRuntime(t, grace)
+	ctx := context.Background()
+
+	// The ignored SIGTERM is inherited by the sleeps as well
+	service := &ServiceInstance{ID: "stubborn", Command: []string{"sh", "-c", `trap "" TERM; while true; do sleep 0.05; done`}}
+	require.NoError(t, runtime.Start(ctx, service))
+	time.Sleep(100 * time.Millisecond)
+
+	started := time.Now()
+	require.NoError(t, runtime.Stop(ctx, "stubborn"))
+	assert.GreaterOrEqual(t, time.Since(started), grace)
+	assert.Equal(t, "stopped", runtimeStatus(t, runtime, "stubborn"))
+}
+
+func TestProcessRuntimeRestartPolicies(t *testing.T) {
+	runtime := newTestProcessRuntime(t, time.Second)
+	ctx := context.Background()
+
+	restarts := func(id string) int {
+		runtime.mutex.Lock()
+		defer runtime.mutex.Unlock()
+		return runtime.processes[id].restarts
+	}
+
+	tests := []struct {
+		id      string
+		policy  string
+		command string
+		status  string
+		restart bool
+	}{
+		{id: "crash-default", policy: "", command: "exit 1", restart: true},
+		{id: "crash-on-failure", policy: RestartOnFailure, command: "exit 1", restart: true},
+		{id: "exit-always", policy: RestartAlways, command: "exit 0", restart: true},
+		{id: "exit-on-failure", policy: RestartOnFailure, command: "exit 0", status: "exited"},
+		{id: "crash-never", policy: RestartNever, command: "exit 1", status: "failed"},
+	}
+	for _, tt := range tests {
+		service := &ServiceInstance{ID: tt.id, RestartPolicy: tt.policy, Command: []string{"sh", "-c", tt.command}}
+		require.NoError(t, runtime.Start(ctx, service), tt.id)
+	}
+
+	for _, tt := range tests {
+		if tt.restart {
+			assert.Eventually(t, func() bool { return restarts(tt.id) >= 3 }, 5*time.Second, 10*time.Millisecond, tt.id)
+			assert.Contains(t, []string{"running", "restarting"}, runtimeStatus(t, runtime, tt.id), tt.id)
+			continue
+		}
+		assert.Eventually(t, func() bool { return runtimeStatus(t, runtime, tt.id) == tt.status }, 5*time.Second, 10*time.Millisecond, tt.id)
+		assert.Zero(t, restarts(tt.id), tt.id)
+	}
+
+	restarted, reason, err := runtime.Restarts(ctx, "crash-on-failure")
+	require.NoError(t, err)
+	assert.Positive(t, restarted)
+	assert.Equal(t, "exited: exit status 1", reason)
+
+	// Stopping ends the restarts
+	require.NoError(t, runtime.Stop(ctx, "crash-default"))
+	assert.Equal(t, "stopped", runtimeStatus(t, runtime, "crash-default"))
+	count := restarts("crash-default")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, count, restarts("crash-default"))
+
+	assert.Error(t, runtime.Start(ctx, &ServiceInstance{ID: "bad-policy", RestartPolicy: "sometimes", Command: []string{"true"}}))
+}
+
+func TestOrchestratorWithProcessRuntime(t *testing.T) {
+	cfg := setupDeploymentTest(t)
+	cfg.Orchestrator.Runtime = RuntimeProcess
+	cfg.Orchestrator.StopGracePeriod = "2s"
+	_, o, r := startTestOrchestrator(t, cfg)
+	require.IsType(t, &runtimeSet{}, o.runtime)
+	runtime := o.runtime.(*runtimeSet).runtimes[RuntimeProcess].(*ProcessRuntime)
+	runtime.opts.RestartDelay = 10 * time.Millisecond
+
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{
+		Name:    "web",
+		Image:   "web:1",
+		Command: []string{"sh", "-c", "echo ready; exec sleep 30"},
+	})
+	require.Equal(t, http.StatusCreated, code, response)
+	waitForStatus(t, o, response["deployment_id"].(string), "deployed")
+
+	code, response = serveJSON(t, r, http.MethodGet, "/services/web-0", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "running", response["status"])
+	pid := int(response["pid"].(float64))
+	require.Positive(t, pid)
+	pids := o.ServicePIDs()
+	require.Len(t, pids, 1)
+	for _, servicePID := range pids {
+		assert.Equal(t, pid, servicePID)
+	}
+
+	// Output is captured into the service's logs
+	assert.Eventually(t, func() bool {
+		_, response := serveJSON(t, r, http.MethodGet, "/services/web-0/logs", nil)
+		logs, _ := response["logs"].([]interface{})
+		return len(logs) == 1 && strings.HasSuffix(logs[0].(string), "stdout ready")
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// A crashed process is restarted
+	require.NoError(t, syscall.Kill(pid, syscall.SIGKILL))
+	assert.Eventually(t, func() bool {
+		_, response := serveJSON(t, r, http.MethodGet, "/services/web-0", nil)
+		newPID, _ := response["pid"].(float64)
+		return response["status"] == "running" && newPID > 0 && int(newPID) != pid
+	}, 5*time.Second, 20*time.Millisecond)
+
+	code, _ = serveJSON(t, r, http.MethodPost, "/services/web-0/stop", nil)
+	require.Equal(t, http.StatusOK, code)
+	code, response = serveJSON(t, r, http.MethodGet, "/services/web-0", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "stopped", response["status"])
+	assert.Nil(t, response["pid"])
+
+	// The health check notices processes that died without being restarted
+	code, response = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{
+		Name:          "job",
+		Image:         "job:1",
+		Command:       []string{"sh", "-c", "exit 3"},
+		RestartPolicy: RestartNever,
+	})
+	require.Equal(t, http.StatusCreated, code, response)
+	assert.Eventually(t, func() bool {
+		o.performHealthChecks()
+		o.mutex.RLock()
+		defer o.mutex.RUnlock()
+		job := o.services["job-0"]
+		return job != nil && job.Status == "failed" && job.Health == "unhealthy"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// A deployment without a command fails
+	code, response = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "image-only", Image: "nginx"})
+	require.Equal(t, http.StatusCreated, code, response)
+	waitForStatus(t, o, response["deployment_id"].(string), "failed")
+
+	code, _ = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "bad", Image: "bad", RestartPolicy: "sometimes"})
+	assert.Equal(t, http.StatusBadRequest, code)
+}
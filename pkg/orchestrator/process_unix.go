@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package orchestrator
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts a command in its own process group, so that
+// signals reach the processes it spawns as well
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcess signals the process group of a started command
+func signalProcess(cmd *exec.Cmd, sig syscall.Signal) error {
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
@@ -0,0 +1,169 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// Restart policies of service instances, as in Docker
+const (
+	RestartNever     = "no"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// Runtime names accepted by orchestrator.runtime
+const (
+	RuntimeSimulated = "simulated"
+	RuntimeProcess   = "process"
+	RuntimeDocker    = "docker"
+)
+
+// ErrInstanceNotFound is returned by runtimes for instances they do not run
+var ErrInstanceNotFound = errors.New("service instance not found")
+
+// Runtime runs service instances. Start returns once the instance has been
+// launched; the runtime then keeps it running according to its restart
+// policy until Stop. Status reports "starting", "running", "restarting",
+// "stopping", "stopped", "exited" or "failed".
+type Runtime interface {
+	Start(ctx context.Context, service *ServiceInstance) error
+	Stop(ctx context.Context, id string) error
+	Status(ctx context.Context, id string) (string, error)
+}
+
+// PIDReporter is implemented by runtimes that run instances as local
+// processes, to report the current process of an instance
+type PIDReporter interface {
+	PID(id string) int
+}
+
+// RestartReporter is implemented by runtimes that restart instances as
+// their restart policy says, to report how many times an instance was
+// restarted since it was started and why it last was
+type RestartReporter interface {
+	Restarts(ctx context.Context, id string) (int, string, error)
+}
+
+// validRestartPolicy reports whether a restart policy is known. Empty means
+// the default, RestartOnFailure.
+func validRestartPolicy(policy string) bool {
+	switch policy {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+		return true
+	}
+	return false
+}
+
+// validRuntime reports whether a service instance may select a runtime.
+// Empty means the orchestrator's runtime.
+func validRuntime(name string) bool {
+	return name == "" || name == RuntimeProcess || name == RuntimeDocker
+}
+
+// runtimeSet runs each instance in the runtime it selects, or in the
+// default one
+type runtimeSet struct {
+	name      string // of the default runtime
+	runtimes  map[string]Runtime
+	mutex     sync.Mutex
+	instances map[string]Runtime
+}
+
+// newRuntimes returns the runtimes of an orchestrator configuration, or nil
+// if instances are only simulated
+func newRuntimes(cfg config.OrchestratorConfig, logs *LogCollector) Runtime {
+	if cfg.Runtime == "" || cfg.Runtime == RuntimeSimulated {
+		return nil
+	}
+
+	grace, _ := time.ParseDuration(cfg.StopGracePeriod)
+	set := &runtimeSet{
+		name: cfg.Runtime,
+		runtimes: map[string]Runtime{
+			RuntimeProcess: NewProcessRuntime(logs, ProcessRuntimeOptions{GracePeriod: grace}),
+		},
+		instances: make(map[string]Runtime),
+	}
+	client, err := NewDockerClient(cfg.DockerHost)
+	if err != nil {
+		log.Printf("⚠️  Docker runtime unavailable: %v", err)
+	} else {
+		set.runtimes[RuntimeDocker] = NewDockerRuntime(client, logs, DockerRuntimeOptions{GracePeriod: grace})
+	}
+	return set
+}
+
+// Start starts an instance in the runtime it selects
+func (s *runtimeSet) Start(ctx context.Context, service *ServiceInstance) error {
+	name := service.Runtime
+	if name == "" {
+		name = s.name
+	}
+	runtime, exists := s.runtimes[name]
+	if !exists {
+		return fmt.Errorf("runtime %s is not available", name)
+	}
+
+	s.mutex.Lock()
+	if previous, exists := s.instances[service.ID]; exists && previous != runtime {
+		// The instance moved to another runtime; its old one may still run it
+		s.mutex.Unlock()
+		if err := previous.Stop(ctx, service.ID); err != nil && !errors.Is(err, ErrInstanceNotFound) {
+			return err
+		}
+		s.mutex.Lock()
+	}
+	s.instances[service.ID] = runtime
+	s.mutex.Unlock()
+
+	return runtime.Start(ctx, service)
+}
+
+// Stop stops an instance in the runtime that started it
+func (s *runtimeSet) Stop(ctx context.Context, id string) error {
+	runtime := s.instance(id)
+	if runtime == nil {
+		return ErrInstanceNotFound
+	}
+	return runtime.Stop(ctx, id)
+}
+
+// Status reports the status of an instance from the runtime that started it
+func (s *runtimeSet) Status(ctx context.Context, id string) (string, error) {
+	runtime := s.instance(id)
+	if runtime == nil {
+		return "", ErrInstanceNotFound
+	}
+	return runtime.Status(ctx, id)
+}
+
+// PID returns the process ID of an instance if its runtime reports one
+func (s *runtimeSet) PID(id string) int {
+	if reporter, ok := s.instance(id).(PIDReporter); ok {
+		return reporter.PID(id)
+	}
+	return 0
+}
+
+// Restarts reports the restarts of an instance if its runtime restarts
+// instances itself
+func (s *runtimeSet) Restarts(ctx context.Context, id string) (int, string, error) {
+	if reporter, ok := s.instance(id).(RestartReporter); ok {
+		return reporter.Restarts(ctx, id)
+	}
+	return 0, "", nil
+}
+
+// instance returns the runtime that started an instance, or nil
+func (s *runtimeSet) instance(id string) Runtime {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.instances[id]
+}
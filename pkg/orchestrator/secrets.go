@@ -0,0 +1,62 @@
+package orchestrator
+
+import (
+	"fmt"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// SetSecretsKey sets the master key secrets are decrypted with. Without one
+// the key is loaded from the configuration, or the file the console
+// generates it in, when a service first needs a secret.
+func (o *Orchestrator) SetSecretsKey(key []byte) {
+	o.secretsMutex.Lock()
+	defer o.secretsMutex.Unlock()
+	o.secretsKey = key
+}
+
+// secretRepository returns the repository secrets are read from
+func (o *Orchestrator) secretRepository() (*database.SecretRepository, error) {
+	if o.db == nil || o.db.DB == nil {
+		return nil, fmt.Errorf("secrets require a database")
+	}
+
+	o.secretsMutex.Lock()
+	defer o.secretsMutex.Unlock()
+	if o.secretsKey == nil && o.config != nil {
+		key, err := database.LoadMasterKey(o.config, false)
+		if err != nil {
+			return nil, err
+		}
+		o.secretsKey = key
+	}
+	return o.db.SecretRepository(o.secretsKey), nil
+}
+
+// resolveSecrets adds the variables an instance reads from secrets to its
+// environment. The instance is the copy handed to the runtime, so values
+// never reach the instances the API returns.
+func (o *Orchestrator) resolveSecrets(instance *ServiceInstance) error {
+	if len(instance.EnvFromSecret) == 0 {
+		return nil
+	}
+
+	secrets, err := o.secretRepository()
+	if err != nil {
+		return fmt.Errorf("secrets are unavailable: %w", err)
+	}
+
+	environment := make(map[string]string, len(instance.Environment)+len(instance.EnvFromSecret))
+	for name, value := range instance.Environment {
+		environment[name] = value
+	}
+	for name, secret := range instance.EnvFromSecret {
+		value, err := secrets.Get(secret)
+		if err != nil {
+			return fmt.Errorf("failed to read secret %s for %s: %w", secret, name, err)
+		}
+		environment[name] = value
+	}
+	instance.Environment = environment
+	return nil
+}
@@ -0,0 +1,53 @@
+package orchestrator
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestEnvFromSecret(t *testing.T) {
+	cfg := setupDeploymentTest(t)
+	db, o, r := startTestOrchestrator(t, cfg)
+	runtime := newFakeRuntime()
+	o.SetRuntime(runtime)
+	o.healthInterval = time.Millisecond
+
+	req := DeployRequest{
+		Image:         "web:1",
+		Environment:   map[string]string{"MODE": "production"},
+		EnvFromSecret: map[string]string{"DB_PASSWORD": "web-db-password"},
+	}
+
+	// Until the console generated the master key, nothing can be resolved
+	deployment := deployStrategy(t, o, r, req, "failed")
+	assert.Contains(t, strings.Join(deployment.Logs, "\n"), "no secrets master key")
+	assert.Empty(t, runtime.images())
+
+	key, err := database.LoadMasterKey(cfg, true)
+	require.NoError(t, err)
+
+	// A missing secret fails the start as well, restoring the failed instance
+	deployment = deployStrategy(t, o, r, req, "rolled_back")
+	assert.Contains(t, strings.Join(deployment.Logs, "\n"), "failed to read secret web-db-password for DB_PASSWORD: secret not found")
+
+	require.NoError(t, db.SecretRepository(key).Set(&database.Secret{Name: "web-db-password"}, "hunter2"))
+	deployStrategy(t, o, r, req, "deployed")
+
+	runtime.mutex.Lock()
+	env := runtime.env["web-0"]
+	runtime.mutex.Unlock()
+	assert.Equal(t, map[string]string{"MODE": "production", "DB_PASSWORD": "hunter2"}, env)
+
+	// The value only reaches the runtime, not the instances the API returns
+	o.mutex.RLock()
+	instance := o.services["web-0"]
+	o.mutex.RUnlock()
+	assert.Equal(t, map[string]string{"MODE": "production"}, instance.Environment)
+	assert.Equal(t, map[string]string{"DB_PASSWORD": "web-db-password"}, instance.EnvFromSecret)
+}
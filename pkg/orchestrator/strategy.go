@@ -0,0 +1,467 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/events"
+)
+
+// Deployment strategies. Rolling is the default.
+const (
+	StrategyRolling  = "rolling"
+	StrategyRecreate = "recreate"
+)
+
+// Deployment phases reported in its progress
+const (
+	PhasePending     = "pending"
+	PhaseWaiting     = "waiting" // on dependencies
+	PhaseStopping    = "stopping"
+	PhaseStarting    = "starting"
+	PhaseUpdating    = "updating"
+	PhaseRollingBack = "rolling_back"
+	PhaseComplete    = "complete"
+	PhaseFailed      = "failed"
+	PhaseRolledBack  = "rolled_back"
+)
+
+// Rollout defaults, overridden by the max_unavailable and
+// health_check_failures keys of a deployment's config
+const (
+	defaultMaxUnavailable      = 1
+	defaultHealthCheckFailures = 3
+)
+
+// DeploymentProgress is how far a deployment has got: its phase and how many
+// of its instances are updated and healthy
+type DeploymentProgress struct {
+	Phase   string `json:"phase"`
+	Updated int    `json:"updated"`
+	Total   int    `json:"total"`
+}
+
+// HealthChecker is implemented by runtimes that check the health of their
+// instances themselves, instead of the orchestrator probing their port. A
+// nil error means healthy.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context, service *ServiceInstance) error
+}
+
+// rollout is the plan of a deployment. previous holds the instance each new
+// one replaces, or nil, and surplus the instances of the service beyond
+// the new replica count.
+type rollout struct {
+	deployment     *Deployment
+	instances      []*ServiceInstance
+	previous       []*ServiceInstance
+	surplus        []*ServiceInstance
+	stopped        []*ServiceInstance // previous and surplus instances stopped so far
+	maxUnavailable int
+	healthFailures int
+}
+
+// validStrategy reports whether a deployment strategy is known
+func validStrategy(strategy string) bool {
+	return strategy == "" || strategy == StrategyRolling || strategy == StrategyRecreate
+}
+
+// rolloutSetting returns a positive integer setting from a deployment
+// config, or def if it is absent. JSON numbers arrive as float64.
+func rolloutSetting(config map[string]interface{}, key string, def int) (int, error) {
+	value, exists := config[key]
+	if !exists {
+		return def, nil
+	}
+	number, ok := value.(float64)
+	if !ok {
+		if integer, isInt := value.(int); isInt {
+			number, ok = float64(integer), true
+		}
+	}
+	if !ok || number < 1 || number != float64(int(number)) {
+		return 0, fmt.Errorf("invalid %s, expected a positive integer", key)
+	}
+	return int(number), nil
+}
+
+// planRollout plans replacing the instances of a deployment's service with
+// new ones. Callers hold o.mutex.
+func (o *Orchestrator) planRollout(deployment *Deployment, instances []*ServiceInstance) *rollout {
+	r := &rollout{deployment: deployment, instances: instances}
+	r.maxUnavailable, _ = rolloutSetting(deployment.Config, "max_unavailable", defaultMaxUnavailable)
+	r.healthFailures, _ = rolloutSetting(deployment.Config, "health_check_failures", defaultHealthCheckFailures)
+
+	replaced := make(map[string]bool)
+	for _, instance := range instances {
+		r.previous = append(r.previous, o.services[instance.ID])
+		replaced[instance.ID] = true
+	}
+	for id, service := range o.services {
+		if service.Name == deployment.ServiceName && !replaced[id] {
+			r.surplus = append(r.surplus, service)
+		}
+	}
+	return r
+}
+
+// run carries out a rollout with the deployment's strategy once the
+// service's dependencies are healthy. If a new instance fails to start or
+// to become healthy, the previous instances are restored.
+func (o *Orchestrator) run(r *rollout) {
+	err := o.awaitDependencies(r)
+	if err == nil && r.deployment.Strategy == StrategyRecreate {
+		err = o.recreate(r)
+	} else if err == nil {
+		err = o.rollingUpdate(r)
+	}
+	if o.ctx.Err() != nil {
+		// The orchestrator is stopping; the deployment is marked failed
+		// once it restarts
+		return
+	}
+	if err != nil {
+		o.revert(r, err)
+		return
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for _, service := range r.surplus {
+		if o.services[service.ID] == service {
+			delete(o.services, service.ID)
+		}
+	}
+	o.updateProgress(r, PhaseComplete, len(r.instances), "")
+	o.setStatus(r, "deployed", "")
+}
+
+// awaitDependencies waits until the dependencies of a rollout's service
+// are healthy, before any instance is touched
+func (o *Orchestrator) awaitDependencies(r *rollout) error {
+	dependsOn := r.deployment.Request.DependsOn
+	if len(dependsOn) == 0 {
+		return nil
+	}
+
+	o.mutex.Lock()
+	o.updateProgress(r, PhaseWaiting, 0, fmt.Sprintf("Waiting on dependencies %s", strings.Join(dependsOn, ", ")))
+	o.mutex.Unlock()
+	return o.waitForDependencies(r.deployment.ServiceName, dependsOn)
+}
+
+// rollingUpdate replaces instances maxUnavailable at a time, waiting for
+// each batch to become healthy before the next
+func (o *Orchestrator) rollingUpdate(r *rollout) error {
+	o.mutex.Lock()
+	o.updateProgress(r, PhaseUpdating, 0, fmt.Sprintf("Rolling update of %d instances, %d at a time",
+		len(r.instances), r.maxUnavailable))
+	o.mutex.Unlock()
+
+	for start := 0; start < len(r.instances); start += r.maxUnavailable {
+		end := min(start+r.maxUnavailable, len(r.instances))
+		var replaced []*ServiceInstance
+		for _, previous := range r.previous[start:end] {
+			if previous != nil {
+				replaced = append(replaced, previous)
+			}
+		}
+		o.stopInstances(r, replaced)
+		if err := o.startInstances(r, start, end); err != nil {
+			return err
+		}
+	}
+
+	// Scaled down instances go once the new ones are healthy
+	o.stopInstances(r, r.surplus)
+	return nil
+}
+
+// recreate stops all instances of the service, then starts the new ones
+func (o *Orchestrator) recreate(r *rollout) error {
+	o.mutex.Lock()
+	o.updateProgress(r, PhaseStopping, 0, fmt.Sprintf("Recreating %d instances", len(r.instances)))
+	o.mutex.Unlock()
+
+	var previous []*ServiceInstance
+	for _, service := range r.previous {
+		if service != nil {
+			previous = append(previous, service)
+		}
+	}
+	o.stopInstances(r, append(previous, r.surplus...))
+
+	o.mutex.Lock()
+	o.updateProgress(r, PhaseStarting, 0, "")
+	o.mutex.Unlock()
+	return o.startInstances(r, 0, len(r.instances))
+}
+
+// startInstances starts the new instances in [start, end) in place of the
+// previous ones and waits until they are healthy
+func (o *Orchestrator) startInstances(r *rollout, start, end int) error {
+	o.mutex.Lock()
+	for _, service := range r.instances[start:end] {
+		o.services[service.ID] = service
+	}
+	o.mutex.Unlock()
+
+	errs := make([]error, end-start)
+	var wg sync.WaitGroup
+	for i, service := range r.instances[start:end] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := o.startInstance(service); err != nil {
+				errs[i] = fmt.Errorf("service instance %s failed to start: %w", service.ID, err)
+				return
+			}
+			errs[i] = o.waitHealthy(service, r.healthFailures)
+		}()
+	}
+	wg.Wait()
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	updated := r.deployment.Progress.Updated
+	for i, service := range r.instances[start:end] {
+		if errs[i] != nil {
+			continue
+		}
+		updated++
+		o.logRollout(r,
+			fmt.Sprintf("Service %s deployed successfully at %s (%d/%d updated)",
+				service.ID, time.Now().Format(time.RFC3339), updated, len(r.instances)))
+	}
+	o.updateProgress(r, r.deployment.Progress.Phase, updated, "")
+	return errors.Join(errs...)
+}
+
+// startInstance starts an instance in the runtime or, without one,
+// simulates starting it
+func (o *Orchestrator) startInstance(service *ServiceInstance) error {
+	if o.runtime != nil {
+		return o.launchInstance(service)
+	}
+
+	select {
+	case <-o.ctx.Done():
+		return o.ctx.Err()
+	case <-time.After(o.deployDelay):
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.setInstanceStatus(service, "running")
+	service.Health = "healthy"
+	return nil
+}
+
+// waitHealthy checks an instance's health until it passes, failing once it
+// failed maxFailures times
+func (o *Orchestrator) waitHealthy(service *ServiceInstance, maxFailures int) error {
+	for failures := 0; ; {
+		if o.checkInstance(service) {
+			return nil
+		}
+		failures++
+		if failures >= maxFailures {
+			return fmt.Errorf("service instance %s failed its health check %d times", service.ID, failures)
+		}
+
+		select {
+		case <-o.ctx.Done():
+			return o.ctx.Err()
+		case <-time.After(o.healthInterval):
+		}
+	}
+}
+
+// checkInstance checks the health of an instance and records it.
+// Simulated instances are always healthy.
+func (o *Orchestrator) checkInstance(service *ServiceInstance) bool {
+	if o.runtime == nil {
+		return true
+	}
+
+	o.mutex.Lock()
+	o.refreshInstance(service)
+	instance := *service
+	o.mutex.Unlock()
+
+	health := "unhealthy"
+	if instance.Status == "running" {
+		health = o.probeHealth(&instance)
+	}
+
+	o.mutex.Lock()
+	service.Health = health
+	service.UpdatedAt = time.Now()
+	o.mutex.Unlock()
+	return health == "healthy"
+}
+
+// probeHealth checks the health of a running instance, asking the runtime
+// if it checks health itself and otherwise connecting to the instance's
+// health endpoint. Instances without a port are healthy.
+func (o *Orchestrator) probeHealth(service *ServiceInstance) string {
+	if checker, ok := o.runtime.(HealthChecker); ok {
+		if err := checker.CheckHealth(o.ctx, service); err != nil {
+			return "unhealthy"
+		}
+		return "healthy"
+	}
+	if err := checkHealthEndpoint(o.ctx, "localhost", service.Port); err != nil {
+		return "unhealthy"
+	}
+	return "healthy"
+}
+
+// checkHealthEndpoint checks the health endpoint of an instance listening
+// on a port of host. Instances without a port are healthy.
+func checkHealthEndpoint(ctx context.Context, host string, port int) error {
+	if port <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s:%d/health", host, port), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// stopInstances stops instances the rollout replaces, remembering them to
+// be restored
+func (o *Orchestrator) stopInstances(r *rollout, services []*ServiceInstance) {
+	for _, service := range services {
+		if err := o.stopRuntime(service.ID); err != nil {
+			log.Printf("❌ Failed to stop service instance %s: %v", service.ID, err)
+		}
+		o.mutex.Lock()
+		o.markStopped(service)
+		o.mutex.Unlock()
+		r.stopped = append(r.stopped, service)
+	}
+}
+
+// revert restores the previous instances after a rollout failed. A first
+// deployment has nothing to restore, so its instances are left as they
+// failed to be inspected.
+func (o *Orchestrator) revert(r *rollout, cause error) {
+	o.mutex.Lock()
+	o.logRollout(r, fmt.Sprintf("Deployment failed: %v", cause))
+	if len(r.stopped) == 0 {
+		o.updateProgress(r, PhaseFailed, r.deployment.Progress.Updated, "")
+		o.setStatus(r, "failed", cause.Error())
+		o.mutex.Unlock()
+		return
+	}
+	o.updateProgress(r, PhaseRollingBack, r.deployment.Progress.Updated, "Rolling back to the previous instances")
+	var started []*ServiceInstance
+	for _, service := range r.instances {
+		if o.services[service.ID] == service {
+			started = append(started, service)
+		}
+	}
+	o.mutex.Unlock()
+
+	for _, service := range started {
+		if err := o.stopRuntime(service.ID); err != nil {
+			log.Printf("❌ Failed to stop service instance %s: %v", service.ID, err)
+		}
+		o.mutex.Lock()
+		o.markStopped(service)
+		delete(o.services, service.ID)
+		o.mutex.Unlock()
+	}
+
+	for _, service := range r.stopped {
+		o.mutex.Lock()
+		o.services[service.ID] = service
+		o.setInstanceStatus(service, "starting")
+		o.mutex.Unlock()
+
+		err := o.startInstance(service)
+		o.mutex.Lock()
+		if err != nil {
+			o.logRollout(r, fmt.Sprintf("Service %s failed to restart: %v", service.ID, err))
+		} else {
+			o.logRollout(r, fmt.Sprintf("Service %s restored", service.ID))
+		}
+		o.mutex.Unlock()
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.updateProgress(r, PhaseRolledBack, 0, "")
+	o.setStatus(r, "rolled_back", cause.Error())
+}
+
+// updateProgress records a rollout's progress and logs a line if not
+// empty. Callers hold o.mutex.
+func (o *Orchestrator) updateProgress(r *rollout, phase string, updated int, line string) {
+	r.deployment.Progress = &DeploymentProgress{Phase: phase, Updated: updated, Total: len(r.instances)}
+	r.deployment.UpdatedAt = time.Now()
+	if line != "" {
+		o.logRollout(r, line)
+	}
+	o.publishProgress(r.deployment)
+}
+
+// publishProgress publishes a deployment.progress event with a deployment's
+// status and progress. Callers hold o.mutex.
+func (o *Orchestrator) publishProgress(deployment *Deployment) {
+	data := events.Deployment{
+		DeploymentID: deployment.ID,
+		Service:      deployment.ServiceName,
+		Status:       deployment.Status,
+		Message:      deployment.Error,
+	}
+	if progress := deployment.Progress; progress != nil {
+		data.Phase = progress.Phase
+		data.Updated = progress.Updated
+		data.Total = progress.Total
+	}
+	o.eventBus.Publish(events.DeploymentProgress, data)
+}
+
+// logRollout appends a line to a rollout's deployment log, unless the
+// deployment was deleted meanwhile. Callers hold o.mutex.
+func (o *Orchestrator) logRollout(r *rollout, line string) {
+	if o.deployments[r.deployment.ID] == r.deployment {
+		o.logDeployment(r.deployment, line)
+	}
+}
+
+// setStatus sets the final status of a rollout's deployment, unless it was
+// deleted meanwhile. Callers hold o.mutex.
+func (o *Orchestrator) setStatus(r *rollout, status, message string) {
+	if o.deployments[r.deployment.ID] != r.deployment {
+		return
+	}
+	o.setDeploymentStatus(r.deployment, status, message)
+}
+
+// deploying reports whether a deployment of a service is in progress.
+// Callers hold o.mutex.
+func (o *Orchestrator) deploying(name string) bool {
+	for _, deployment := range o.deployments {
+		if deployment.ServiceName == name && deployment.Status == "deploying" {
+			return true
+		}
+	}
+	return false
+}
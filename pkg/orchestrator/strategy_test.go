@@ -0,0 +1,253 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRuntime records the instances it starts and stops. Instances of
+// images in unhealthy fail their health checks, and those of images tagged
+// "slow" only pass once release is closed.
+type fakeRuntime struct {
+	mutex     sync.Mutex
+	running   map[string]string            // instance ID to image
+	env       map[string]map[string]string // instance ID to the environment it started with
+	events    []string
+	unhealthy map[string]bool
+	release   chan struct{}
+}
+
+func newFakeRuntime(unhealthy ...string) *fakeRuntime {
+	f := &fakeRuntime{running: make(map[string]string), env: make(map[string]map[string]string), unhealthy: make(map[string]bool), release: make(chan struct{})}
+	for _, image := range unhealthy {
+		f.unhealthy[image] = true
+	}
+	return f
+}
+
+func (f *fakeRuntime) Start(ctx context.Context, service *ServiceInstance) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.running[service.ID] = service.Image
+	f.env[service.ID] = service.Environment
+	f.events = append(f.events, "start "+service.ID+" "+service.Image)
+	return nil
+}
+
+func (f *fakeRuntime) Stop(ctx context.Context, id string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, exists := f.running[id]; !exists {
+		return ErrInstanceNotFound
+	}
+	delete(f.running, id)
+	f.events = append(f.events, "stop "+id)
+	return nil
+}
+
+func (f *fakeRuntime) Status(ctx context.Context, id string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, exists := f.running[id]; !exists {
+		return "", ErrInstanceNotFound
+	}
+	return "running", nil
+}
+
+func (f *fakeRuntime) CheckHealth(ctx context.Context, service *ServiceInstance) error {
+	if strings.HasSuffix(service.Image, ":slow") {
+		select {
+		case <-f.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if f.unhealthy[service.Image] {
+		return errors.New("health check failed")
+	}
+	return nil
+}
+
+// takeEvents returns the events so far and forgets them
+func (f *fakeRuntime) takeEvents() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	events := f.events
+	f.events = nil
+	return events
+}
+
+// images returns the image each running instance runs
+func (f *fakeRuntime) images() map[string]string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	images := make(map[string]string, len(f.running))
+	for id, image := range f.running {
+		images[id] = image
+	}
+	return images
+}
+
+// startStrategyTest starts an orchestrator on a fake runtime that checks
+// health without delay
+func startStrategyTest(t *testing.T, unhealthy ...string) (*Orchestrator, *gin.Engine, *fakeRuntime) {
+	_, o, r := startTestOrchestrator(t, setupDeploymentTest(t))
+	runtime := newFakeRuntime(unhealthy...)
+	o.SetRuntime(runtime)
+	o.healthInterval = time.Millisecond
+	return o, r, runtime
+}
+
+// deployStrategy deploys an image of service "web" and waits for the
+// deployment to reach a status
+func deployStrategy(t *testing.T, o *Orchestrator, r *gin.Engine, req DeployRequest, status string) *Deployment {
+	req.Name = "web"
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", req)
+	require.Equal(t, http.StatusCreated, code, response)
+	id := response["deployment_id"].(string)
+	waitForStatus(t, o, id, status)
+
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	return o.deployments[id]
+}
+
+func TestRollingDeployment(t *testing.T) {
+	o, r, runtime := startStrategyTest(t)
+
+	deployStrategy(t, o, r, DeployRequest{Image: "web:1", Replicas: 3}, "deployed")
+	assert.Equal(t, []string{"start web-0 web:1", "start web-1 web:1", "start web-2 web:1"}, runtime.takeEvents())
+
+	// Instances are replaced one at a time
+	deployment := deployStrategy(t, o, r, DeployRequest{Image: "web:2", Replicas: 3, Strategy: StrategyRolling}, "deployed")
+	assert.Equal(t, []string{
+		"stop web-0", "start web-0 web:2",
+		"stop web-1", "start web-1 web:2",
+		"stop web-2", "start web-2 web:2",
+	}, runtime.takeEvents())
+
+	code, response := serveJSON(t, r, http.MethodGet, "/deployments/"+deployment.ID, nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"phase": PhaseComplete, "updated": float64(3), "total": float64(3)}, response["progress"])
+	logs := response["logs"].([]interface{})
+	assert.Contains(t, logs, "Rolling update of 3 instances, 1 at a time")
+	assert.Contains(t, logs[len(logs)-1], "Service web-2 deployed successfully")
+	assert.Contains(t, logs[len(logs)-1], "(3/3 updated)")
+
+	// max_unavailable instances at a time, and scaled down instances are
+	// removed at the end
+	deployStrategy(t, o, r, DeployRequest{
+		Image:    "web:3",
+		Replicas: 2,
+		Config:   map[string]interface{}{"max_unavailable": 2},
+	}, "deployed")
+	events := runtime.takeEvents()
+	require.Len(t, events, 5)
+	sort.Strings(events[2:4])
+	assert.Equal(t, []string{"stop web-0", "stop web-1", "start web-0 web:3", "start web-1 web:3", "stop web-2"}, events)
+	assert.Equal(t, map[string]string{"web-0": "web:3", "web-1": "web:3"}, runtime.images())
+
+	o.mutex.RLock()
+	assert.NotContains(t, o.services, "web-2")
+	assert.Equal(t, "healthy", o.services["web-0"].Health)
+	o.mutex.RUnlock()
+}
+
+func TestRollingDeploymentRollsBackUnhealthy(t *testing.T) {
+	o, r, runtime := startStrategyTest(t, "web:bad")
+
+	deployStrategy(t, o, r, DeployRequest{Image: "web:1", Replicas: 3}, "deployed")
+	runtime.takeEvents()
+
+	deployment := deployStrategy(t, o, r, DeployRequest{
+		Image:    "web:bad",
+		Replicas: 3,
+		Config:   map[string]interface{}{"health_check_failures": 2},
+	}, "rolled_back")
+
+	// The rollout stops at the first instance, which is restored
+	assert.Equal(t, []string{"stop web-0", "start web-0 web:bad", "stop web-0", "start web-0 web:1"}, runtime.takeEvents())
+	assert.Equal(t, map[string]string{"web-0": "web:1", "web-1": "web:1", "web-2": "web:1"}, runtime.images())
+
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	assert.Equal(t, "service instance web-0 failed its health check 2 times", deployment.Error)
+	assert.Equal(t, &DeploymentProgress{Phase: PhaseRolledBack, Total: 3}, deployment.Progress)
+	assert.Contains(t, deployment.Logs, "Rolling back to the previous instances")
+	assert.Contains(t, deployment.Logs, "Service web-0 restored")
+	assert.Equal(t, "web:1", o.services["web-0"].Image)
+	assert.Equal(t, "running", o.services["web-0"].Status)
+}
+
+func TestRecreateDeployment(t *testing.T) {
+	o, r, runtime := startStrategyTest(t, "web:bad")
+
+	deployStrategy(t, o, r, DeployRequest{Image: "web:1", Replicas: 2}, "deployed")
+	runtime.takeEvents()
+
+	// All instances are stopped before any new one starts
+	deployment := deployStrategy(t, o, r, DeployRequest{Image: "web:2", Replicas: 2, Strategy: StrategyRecreate}, "deployed")
+	events := runtime.takeEvents()
+	require.Len(t, events, 4)
+	sort.Strings(events[2:])
+	assert.Equal(t, []string{"stop web-0", "stop web-1", "start web-0 web:2", "start web-1 web:2"}, events)
+	o.mutex.RLock()
+	assert.Equal(t, &DeploymentProgress{Phase: PhaseComplete, Updated: 2, Total: 2}, deployment.Progress)
+	assert.Contains(t, deployment.Logs, "Recreating 2 instances")
+	o.mutex.RUnlock()
+
+	// A failed recreate restores all previous instances
+	deployStrategy(t, o, r, DeployRequest{Image: "web:bad", Replicas: 1, Strategy: StrategyRecreate}, "rolled_back")
+	assert.Equal(t, map[string]string{"web-0": "web:2", "web-1": "web:2"}, runtime.images())
+
+	// A first deployment has nothing to restore, so it only fails
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "job", Image: "web:bad", Strategy: StrategyRecreate})
+	require.Equal(t, http.StatusCreated, code, response)
+	waitForStatus(t, o, response["deployment_id"].(string), "failed")
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	assert.Equal(t, PhaseFailed, o.deployments[response["deployment_id"].(string)].Progress.Phase)
+	assert.Equal(t, "unhealthy", o.services["job-0"].Health)
+}
+
+func TestDeployWhileDeploying(t *testing.T) {
+	o, r, runtime := startStrategyTest(t)
+
+	code, response := serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "web", Image: "web:slow"})
+	require.Equal(t, http.StatusCreated, code, response)
+	id := response["deployment_id"].(string)
+
+	assert.Eventually(t, func() bool {
+		_, response := serveJSON(t, r, http.MethodGet, "/deployments/"+id, nil)
+		progress, _ := response["progress"].(map[string]interface{})
+		return progress["phase"] == PhaseUpdating
+	}, 5*time.Second, 10*time.Millisecond)
+
+	code, _ = serveJSON(t, r, http.MethodPost, "/deploy", DeployRequest{Name: "web", Image: "web:2"})
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = serveJSON(t, r, http.MethodPost, "/deployments/"+id+"/rollback", nil)
+	assert.Equal(t, http.StatusConflict, code)
+
+	close(runtime.release)
+	waitForStatus(t, o, id, "deployed")
+
+	tests := []DeployRequest{
+		{Name: "web", Image: "web:2", Strategy: "blue-green"},
+		{Name: "web", Image: "web:2", Config: map[string]interface{}{"max_unavailable": 0}},
+		{Name: "web", Image: "web:2", Config: map[string]interface{}{"health_check_failures": "3"}},
+	}
+	for _, req := range tests {
+		code, _ = serveJSON(t, r, http.MethodPost, "/deploy", req)
+		assert.Equal(t, http.StatusBadRequest, code, req)
+	}
+}
@@ -0,0 +1,332 @@
+package orchestrator
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/events"
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+// Usage sampling defaults
+const (
+	defaultUsageSampleInterval = 15 * time.Second
+	defaultMemorySamples       = 3
+)
+
+// Actions taken on instances that stay over their memory requirement
+const (
+	MemoryActionAlert   = "alert"
+	MemoryActionRestart = "restart"
+)
+
+// metricScopeInstance is the scope of the metrics of service instances,
+// whose scope IDs are instance IDs
+const metricScopeInstance = "instance"
+
+// clockTicks is the unit of the CPU times in /proc/<pid>/stat, USER_HZ,
+// which Linux fixes at 100 on every architecture
+const clockTicks = 100
+
+// InstanceUsage is the CPU and memory a service instance used when last
+// sampled, next to what its resource requirements allow
+type InstanceUsage struct {
+	CPUPercent       float64   `json:"cpu_percent"`                  // of one core, since the previous sample
+	CPULimitPercent  float64   `json:"cpu_limit_percent,omitempty"`  // the CPU requirement, if any
+	MemoryBytes      int64     `json:"memory_bytes"`                 // resident set size
+	MemoryLimitBytes int64     `json:"memory_limit_bytes,omitempty"` // the memory requirement, if any
+	SampledAt        time.Time `json:"sampled_at"`
+}
+
+// usageSampler keeps what the samples of each instance's process are
+// compared to. Its fields are guarded by the orchestrator's mutex.
+type usageSampler struct {
+	procRoot  string
+	interval  time.Duration
+	action    string // MemoryActionAlert or MemoryActionRestart
+	samples   int    // consecutive samples over the memory requirement before acting
+	instances map[string]*instanceSample
+}
+
+// instanceSample is the latest sample of an instance's process
+type instanceSample struct {
+	pid      int
+	cpuTicks uint64
+	at       time.Time
+	over     int // consecutive samples over the memory requirement
+}
+
+// processUsage is what a process used, as read from /proc
+type processUsage struct {
+	cpuTicks uint64
+	rss      int64
+}
+
+// newUsageSampler creates a sampler enforcing the configured limits
+func newUsageSampler(cfg config.LimitsConfig) *usageSampler {
+	s := &usageSampler{
+		procRoot:  instanceProcRoot,
+		interval:  defaultUsageSampleInterval,
+		action:    MemoryActionAlert,
+		samples:   defaultMemorySamples,
+		instances: make(map[string]*instanceSample),
+	}
+	if interval, err := time.ParseDuration(cfg.SampleInterval); err == nil && interval > 0 {
+		s.interval = interval
+	}
+	if cfg.MemoryAction != "" {
+		s.action = cfg.MemoryAction
+	}
+	if cfg.MemorySamples > 0 {
+		s.samples = cfg.MemorySamples
+	}
+	return s
+}
+
+// usageLoop samples the instances run as local processes every interval
+func (o *Orchestrator) usageLoop() {
+	if o.usage.procRoot == "" {
+		log.Printf("⚠️  Sampling service instance usage is not supported on this platform")
+		return
+	}
+
+	ticker := time.NewTicker(o.usage.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.ctx.Done():
+			return
+		case <-ticker.C:
+			o.sampleUsage(time.Now())
+		}
+	}
+}
+
+// sampleUsage reads the CPU and memory of every instance running as a local
+// process, records them as metrics of the instance and acts on instances
+// that stayed over their memory requirement. CPU usage is measured between
+// samples, so an instance's first sample has none.
+func (o *Orchestrator) sampleUsage(now time.Time) {
+	s := o.usage
+	if s == nil || s.procRoot == "" {
+		return
+	}
+
+	o.mutex.RLock()
+	pids := make(map[*ServiceInstance]int)
+	if reporter, ok := o.runtime.(PIDReporter); ok {
+		for _, service := range o.services {
+			if pid := reporter.PID(service.ID); pid > 0 {
+				pids[service] = pid
+			}
+		}
+	}
+	o.mutex.RUnlock()
+
+	usages := make(map[*ServiceInstance]processUsage, len(pids))
+	for service, pid := range pids {
+		usage, err := readProcessUsage(s.procRoot, pid)
+		if err != nil {
+			// The process may have exited since it was reported
+			continue
+		}
+		usages[service] = usage
+	}
+
+	var metrics []*database.Metric
+	o.mutex.Lock()
+	sampled := make(map[string]bool, len(usages))
+	for service, usage := range usages {
+		if o.services[service.ID] != service {
+			continue
+		}
+		sampled[service.ID] = true
+		service.Usage = s.update(service, pids[service], usage, now)
+		metrics = append(metrics, instanceMetrics(service, now)...)
+		o.enforceMemory(service)
+	}
+	for id := range s.instances {
+		if !sampled[id] {
+			delete(s.instances, id)
+		}
+	}
+	o.mutex.Unlock()
+
+	if o.db == nil || o.db.DB == nil {
+		return
+	}
+	repo := o.db.MetricRepository()
+	for _, metric := range metrics {
+		if err := repo.Insert(metric); err != nil {
+			log.Printf("❌ Failed to record %s metric of service instance %s: %v", metric.MetricName, metric.ScopeID, err)
+		}
+	}
+}
+
+// update compares the usage of an instance's process to its previous sample
+// and its requirements. Callers hold o.mutex.
+func (s *usageSampler) update(service *ServiceInstance, pid int, usage processUsage, now time.Time) *InstanceUsage {
+	result := &InstanceUsage{MemoryBytes: usage.rss, SampledAt: now}
+	if service.Resources != nil {
+		if cpus, err := spec.ParseCPU(service.Resources.CPU); err == nil {
+			result.CPULimitPercent = float64(cpus) / 1e7
+		}
+		if memory, err := spec.ParseMemory(service.Resources.Memory); err == nil {
+			result.MemoryLimitBytes = memory
+		}
+	}
+
+	previous, exists := s.instances[service.ID]
+	if !exists || previous.pid != pid {
+		// A new process is compared to nothing it did before
+		previous = &instanceSample{pid: pid}
+		s.instances[service.ID] = previous
+	} else if elapsed := now.Sub(previous.at).Seconds(); elapsed > 0 && usage.cpuTicks >= previous.cpuTicks {
+		result.CPUPercent = float64(usage.cpuTicks-previous.cpuTicks) / clockTicks / elapsed * 100
+	}
+	previous.cpuTicks = usage.cpuTicks
+	previous.at = now
+
+	if result.MemoryLimitBytes > 0 && usage.rss > result.MemoryLimitBytes {
+		previous.over++
+	} else {
+		previous.over = 0
+	}
+	return result
+}
+
+// enforceMemory alerts on or restarts an instance that stayed over its
+// memory requirement for the configured number of samples. Callers hold
+// o.mutex.
+func (o *Orchestrator) enforceMemory(service *ServiceInstance) {
+	s := o.usage
+	sample := s.instances[service.ID]
+	if sample == nil || sample.over < s.samples || service.Status != "running" {
+		return
+	}
+	sample.over = 0
+
+	usage := service.Usage
+	reason := fmt.Sprintf("memory %s over its %s requirement", formatMebibytes(usage.MemoryBytes), formatMebibytes(usage.MemoryLimitBytes))
+	message := fmt.Sprintf("Service instance %s used %s for %d samples", service.ID, reason, s.samples)
+	if s.action == MemoryActionRestart {
+		message += ", restarting it"
+	}
+
+	log.Printf("⚠️  %s", message)
+	o.recordEvent("Warning", "MemoryLimitExceeded", message)
+	o.eventBus.Publish(events.ResourceExceeded, events.Resource{
+		ServiceID: service.RecordID,
+		Service:   service.Name,
+		Instance:  service.ID,
+		Resource:  "memory",
+		Usage:     usage.MemoryBytes,
+		Limit:     usage.MemoryLimitBytes,
+		Action:    s.action,
+		Message:   message,
+	})
+	if s.action == MemoryActionRestart {
+		o.restartInstance(service, reason)
+	}
+}
+
+// instanceMetrics returns the metrics of an instance's latest sample,
+// labelled with its service
+func instanceMetrics(service *ServiceInstance, now time.Time) []*database.Metric {
+	labels, _ := json.Marshal(map[string]string{"service_id": service.RecordID, "service": service.Name})
+	labelString := string(labels)
+
+	metric := func(name string, value float64) *database.Metric {
+		return &database.Metric{
+			Timestamp:   now,
+			ScopeType:   metricScopeInstance,
+			ScopeID:     service.ID,
+			MetricName:  name,
+			MetricValue: value,
+			Labels:      &labelString,
+		}
+	}
+	return []*database.Metric{
+		metric("cpu_usage", service.Usage.CPUPercent),
+		metric("memory_rss_bytes", float64(service.Usage.MemoryBytes)),
+	}
+}
+
+// readProcessUsage reads the CPU time and resident set size of a process
+func readProcessUsage(procRoot string, pid int) (processUsage, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return processUsage{}, err
+	}
+	// The command name in parentheses may contain spaces, so fields are
+	// counted from its end: state is field 3, utime 14 and stime 15
+	end := strings.LastIndexByte(string(stat), ')')
+	if end < 0 {
+		return processUsage{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 13 {
+		return processUsage{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return processUsage{}, fmt.Errorf("invalid utime of process %d: %q", pid, fields[11])
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return processUsage{}, fmt.Errorf("invalid stime of process %d: %q", pid, fields[12])
+	}
+
+	rss, err := readProcessRSS(filepath.Join(dir, "status"))
+	if err != nil {
+		return processUsage{}, err
+	}
+	return processUsage{cpuTicks: utime + stime, rss: rss}, nil
+}
+
+// readProcessRSS reads the VmRSS field of a /proc/<pid>/status file in bytes
+func readProcessRSS(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || name != "VmRSS" {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			break
+		}
+		kilobytes, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid VmRSS in %s: %q", path, fields[0])
+		}
+		return kilobytes * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("missing VmRSS in %s", path)
+}
+
+// formatMebibytes formats bytes in whole mebibytes
+func formatMebibytes(bytes int64) string {
+	return strconv.FormatInt(bytes>>20, 10) + "Mi"
+}
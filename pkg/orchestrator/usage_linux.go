@@ -0,0 +1,6 @@
+//go:build linux
+
+package orchestrator
+
+// instanceProcRoot is where the usage of instance processes is read from
+const instanceProcRoot = "/proc"
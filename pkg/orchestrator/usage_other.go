@@ -0,0 +1,7 @@
+//go:build !linux
+
+package orchestrator
+
+// instanceProcRoot is empty on platforms without /proc, where the usage of
+// instance processes is not sampled
+const instanceProcRoot = ""
@@ -0,0 +1,162 @@
+//go:build linux
+
+package orchestrator
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// helperMemoryEnv makes the test binary a service instance that allocates
+// the given mebibytes, see TestUsageHelperProcess
+const helperMemoryEnv = "INFRA_CORE_HELPER_ALLOCATE_MB"
+
+// TestUsageHelperProcess is not a test: run as a service instance with
+// helperMemoryEnv set, it allocates that much memory and keeps it until
+// it is stopped
+func TestUsageHelperProcess(t *testing.T) {
+	size, err := strconv.Atoi(os.Getenv(helperMemoryEnv))
+	if err != nil {
+		t.Skip("only run as a service instance")
+	}
+
+	memory := make([]byte, size<<20)
+	for i := 0; i < len(memory); i += os.Getpagesize() {
+		memory[i] = 1
+	}
+	time.Sleep(time.Minute)
+	runtime.KeepAlive(memory)
+}
+
+// helperRequest deploys the test binary allocating mebibytes of memory,
+// requiring limit
+func helperRequest(name string, mebibytes int, limit string) DeployRequest {
+	return DeployRequest{
+		Name:        name,
+		Image:       name + ":1",
+		Command:     []string{os.Args[0], "-test.run=^TestUsageHelperProcess$"},
+		Environment: map[string]string{helperMemoryEnv: strconv.Itoa(mebibytes)},
+		Resources:   &ResourceRequirements{Memory: limit},
+	}
+}
+
+func TestMemoryLimitEnforcement(t *testing.T) {
+	cfg := setupDeploymentTest(t)
+	cfg.Orchestrator.Runtime = RuntimeProcess
+	cfg.Orchestrator.Limits.MemorySamples = 2
+	db, o, r := startTestOrchestrator(t, cfg)
+	assert.Equal(t, MemoryActionAlert, o.usage.action)
+
+	for _, req := range []DeployRequest{helperRequest("hog", 64, "16Mi"), helperRequest("lean", 1, "256Mi")} {
+		code, response := serveJSON(t, r, http.MethodPost, "/deploy", req)
+		require.Equal(t, http.StatusCreated, code, response)
+		waitForStatus(t, o, response["deployment_id"].(string), "deployed")
+	}
+
+	usage := func(id string) *InstanceUsage {
+		o.mutex.RLock()
+		defer o.mutex.RUnlock()
+		return o.services[id].Usage
+	}
+	require.Eventually(t, func() bool {
+		o.sampleUsage(time.Now())
+		hog := usage("hog-0")
+		return hog != nil && hog.MemoryBytes > 48<<20
+	}, 10*time.Second, 50*time.Millisecond)
+	started := time.Now()
+
+	// Over its requirement for long enough, the hog is alerted on but kept
+	o.sampleUsage(time.Now())
+	o.sampleUsage(time.Now())
+	alerts := 0
+	for _, event := range o.clusterEvents() {
+		if event.Reason == "MemoryLimitExceeded" {
+			assert.Contains(t, event.Message, "hog-0")
+			alerts++
+		}
+	}
+	assert.Positive(t, alerts)
+
+	code, response := serveJSON(t, r, http.MethodGet, "/services/hog-0", nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "running", response["status"])
+	assert.Zero(t, response["restarts"])
+	require.IsType(t, map[string]interface{}{}, response["usage"])
+	hogUsage := response["usage"].(map[string]interface{})
+	assert.Greater(t, hogUsage["memory_bytes"], float64(48<<20))
+	assert.Equal(t, float64(16<<20), hogUsage["memory_limit_bytes"])
+	pid := int(response["pid"].(float64))
+
+	// With the restart action, it is restarted and the restart counted
+	o.mutex.Lock()
+	o.usage.action = MemoryActionRestart
+	o.mutex.Unlock()
+	o.sampleUsage(time.Now())
+	o.sampleUsage(time.Now())
+	assert.Eventually(t, func() bool {
+		_, response := serveJSON(t, r, http.MethodGet, "/services/hog-0", nil)
+		newPID, _ := response["pid"].(float64)
+		return response["status"] == "running" && newPID > 0 && int(newPID) != pid
+	}, 10*time.Second, 50*time.Millisecond)
+	_, response = serveJSON(t, r, http.MethodGet, "/services/hog-0", nil)
+	assert.Equal(t, float64(1), response["restarts"])
+	assert.Contains(t, response["last_restart_reason"], "over its 16Mi requirement")
+
+	// The lean instance stays within its requirement
+	_, response = serveJSON(t, r, http.MethodGet, "/services/lean-0", nil)
+	assert.Equal(t, "running", response["status"])
+	assert.Zero(t, response["restarts"])
+
+	// Samples are recorded as metrics of the instances
+	top, err := db.MetricRepository().Top(metricScopeInstance, "memory_rss_bytes", started.Add(-time.Minute), 5)
+	require.NoError(t, err)
+	require.Len(t, top, 2)
+	assert.Equal(t, "hog-0", top[0].ScopeID)
+	assert.Equal(t, "lean-0", top[1].ScopeID)
+	require.NotNil(t, top[0].Labels)
+	assert.Contains(t, *top[0].Labels, `"service":"hog"`)
+}
+
+func TestReadProcessUsage(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "42")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stat"),
+		[]byte("42 (web (worker) 1) S 1 42 42 0 -1 4194560 500 0 0 0 250 50 0 0 20 0 1 0 100 1000000 300\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "status"),
+		[]byte("Name:\tweb\nVmPeak:\t  20000 kB\nVmRSS:\t    1024 kB\n"), 0o644))
+
+	usage, err := readProcessUsage(root, 42)
+	require.NoError(t, err)
+	assert.Equal(t, processUsage{cpuTicks: 300, rss: 1 << 20}, usage)
+
+	_, err = readProcessUsage(root, 43)
+	assert.Error(t, err)
+
+	// CPU usage is measured between samples of the same process
+	s := newUsageSampler(config.LimitsConfig{})
+	service := &ServiceInstance{ID: "web-0", Resources: &ResourceRequirements{CPU: "500m", Memory: "512Ki"}}
+	now := time.Now()
+	first := s.update(service, 42, usage, now)
+	assert.Zero(t, first.CPUPercent)
+	assert.Equal(t, 50.0, first.CPULimitPercent)
+	assert.Equal(t, int64(512<<10), first.MemoryLimitBytes)
+
+	second := s.update(service, 42, processUsage{cpuTicks: 400, rss: 1 << 20}, now.Add(2*time.Second))
+	assert.InDelta(t, 50.0, second.CPUPercent, 0.001)
+	assert.Equal(t, 2, s.instances["web-0"].over)
+
+	restarted := s.update(service, 99, processUsage{cpuTicks: 10, rss: 1 << 10}, now.Add(3*time.Second))
+	assert.Zero(t, restarted.CPUPercent)
+	assert.Zero(t, s.instances["web-0"].over)
+}
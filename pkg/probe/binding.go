@@ -0,0 +1,239 @@
+package probe
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of services a probe can be bound to
+const (
+	ServiceRefRegistered = "registered" // registered with the console, probed at its URLs
+	ServiceRefManaged    = "managed"    // run by the orchestrator, probed on its port
+)
+
+// Endpoints of a bound service, besides custom:<path>
+const (
+	EndpointHealth = "health"
+	EndpointRoot   = "root"
+)
+
+// customEndpoint prefixes the path of a custom endpoint
+const customEndpoint = "custom:"
+
+// errServiceGone is returned when the service a probe is bound to no longer
+// exists
+var errServiceGone = errors.New("service not found")
+
+// ServiceRef binds a probe to a service instead of a fixed target. The
+// target is resolved from the service each time the probe runs, so it
+// follows changes of the service's URL or port.
+type ServiceRef struct {
+	Type     string `json:"type"` // registered or managed
+	ID       string `json:"id"`
+	Endpoint string `json:"endpoint,omitempty"` // health (default), root or custom:<path>
+}
+
+// validate checks the reference for a probe of probeType
+func (ref *ServiceRef) validate(probeType string) error {
+	if ref.Type != ServiceRefRegistered && ref.Type != ServiceRefManaged {
+		return fmt.Errorf("service_ref.type must be %s or %s, got %q", ServiceRefRegistered, ServiceRefManaged, ref.Type)
+	}
+	if ref.ID == "" {
+		return errors.New("service_ref.id is required")
+	}
+	switch endpoint := ref.Endpoint; {
+	case endpoint == "", endpoint == EndpointHealth, endpoint == EndpointRoot:
+	case strings.HasPrefix(endpoint, customEndpoint) && len(endpoint) > len(customEndpoint):
+	default:
+		return fmt.Errorf("service_ref.endpoint must be health, root or custom:<path>, got %q", endpoint)
+	}
+	switch probeType {
+	case "http", "tcp", "tls", "icmp":
+		return nil
+	default:
+		return fmt.Errorf("%s probes cannot be bound to a service", probeType)
+	}
+}
+
+// String describes the referenced service
+func (ref *ServiceRef) String() string {
+	return ref.Type + " service " + ref.ID
+}
+
+// validateTarget checks that a probe has either a target or a service_ref
+func validateTarget(req *CreateProbeRequest) error {
+	switch {
+	case req.ServiceRef != nil && req.Target != "":
+		return errors.New("target and service_ref cannot both be set")
+	case req.ServiceRef != nil:
+		return req.ServiceRef.validate(req.Type)
+	case req.Target == "":
+		return errors.New("target or service_ref is required")
+	}
+	return nil
+}
+
+// resolveTarget returns the target a probe runs against: its own, or the
+// current address of the service it is bound to. It returns errServiceGone
+// if the service no longer exists.
+func (pm *ProbeMonitor) resolveTarget(probe *ProbeConfig) (string, error) {
+	ref := probe.ServiceRef
+	if ref == nil {
+		return probe.Target, nil
+	}
+	if pm.db == nil || pm.db.DB == nil {
+		return "", fmt.Errorf("cannot resolve %s without a database", ref)
+	}
+
+	var base, health string
+	switch ref.Type {
+	case ServiceRefRegistered:
+		service, err := pm.db.RegisteredServiceRepository().GetByID(ref.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%s: %w", ref, errServiceGone)
+		}
+		if err != nil {
+			return "", err
+		}
+		base = service.ServiceURL
+		if service.HealthURL != nil {
+			health = *service.HealthURL
+		}
+	case ServiceRefManaged:
+		service, err := pm.db.ServiceRepository().GetByID(ref.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%s: %w", ref, errServiceGone)
+		}
+		if err != nil {
+			return "", err
+		}
+		// Managed services listen on the host, as the orchestrator checks them
+		base = fmt.Sprintf("http://localhost:%d", service.Port)
+		health = base + "/health"
+	default:
+		return "", fmt.Errorf("unknown service_ref.type %q", ref.Type)
+	}
+
+	var target string
+	switch endpoint := ref.Endpoint; {
+	case endpoint == "" || endpoint == EndpointHealth:
+		if health == "" {
+			return "", fmt.Errorf("%s has no health URL", ref)
+		}
+		target = health
+	case endpoint == EndpointRoot:
+		target = base
+	default:
+		target = strings.TrimRight(base, "/") + "/" + strings.TrimLeft(strings.TrimPrefix(endpoint, customEndpoint), "/")
+	}
+	return probeAddress(probe.Type, target)
+}
+
+// probeAddress converts the URL of a service to the target a probe of
+// probeType expects: the URL itself for HTTP probes, host:port for TCP and
+// TLS probes and the host for ICMP probes
+func probeAddress(probeType, target string) (string, error) {
+	if probeType == "http" {
+		return target, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid service URL %q", target)
+	}
+	if probeType == "icmp" {
+		return u.Hostname(), nil
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// unbindProbe disables a probe whose service no longer exists, recording why
+func (pm *ProbeMonitor) unbindProbe(probeID, reason string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	probe, exists := pm.probes[probeID]
+	if !exists || !probe.Enabled {
+		return
+	}
+	probe.Enabled = false
+	probe.DisabledReason = reason
+	probe.UpdatedAt = time.Now()
+	delete(pm.lastRun, probeID)
+	log.Printf("⚠️ Disabled probe %s: %s", probe.Name, reason)
+}
+
+// syncServiceProbes creates an HTTP health probe for each registered service
+// with a health URL and no probe bound to it or targeting that URL yet. It
+// returns the probes created.
+func (pm *ProbeMonitor) syncServiceProbes() ([]*ProbeConfig, error) {
+	if pm.db == nil || pm.db.DB == nil {
+		return nil, errors.New("no database to read registered services from")
+	}
+	services, err := pm.db.RegisteredServiceRepository().List()
+	if err != nil {
+		return nil, err
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	probed := make(map[string]bool)
+	for _, probe := range pm.probes {
+		if ref := probe.ServiceRef; ref != nil && ref.Type == ServiceRefRegistered {
+			probed[ref.ID] = true
+		}
+	}
+	targets := make(map[string]bool)
+	for _, probe := range pm.probes {
+		targets[probe.Target] = true
+	}
+
+	var created []*ProbeConfig
+	for _, service := range services {
+		if service.HealthURL == nil || *service.HealthURL == "" || probed[service.ID] || targets[*service.HealthURL] {
+			continue
+		}
+		now := time.Now()
+		probe := &ProbeConfig{
+			ID:         uuid.New().String(),
+			Name:       service.DisplayName + " Health Check",
+			Type:       "http",
+			ServiceRef: &ServiceRef{Type: ServiceRefRegistered, ID: service.ID, Endpoint: EndpointHealth},
+			Interval:   defaultProbeInterval,
+			Timeout:    10 * time.Second,
+			Enabled:    true,
+			Tags:       []string{service.ID, service.Name}, // matched by maintenance windows
+			CreatedAt:  now,
+			UpdatedAt:  now,
+			Config:     make(map[string]interface{}),
+		}
+		if service.OrgID != nil {
+			probe.OrgID = *service.OrgID
+		}
+		if service.DisplayName == "" {
+			probe.Name = service.Name + " Health Check"
+		}
+		if service.Name == service.ID {
+			probe.Tags = probe.Tags[:1]
+		}
+		applyProbeDefaults(probe)
+		pm.probes[probe.ID] = probe
+		created = append(created, probe)
+	}
+	return created, nil
+}
@@ -0,0 +1,245 @@
+package probe
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// startBindingTest creates a monitor on a database of services, and a router
+// serving its API
+func startBindingTest(t *testing.T) (*ProbeMonitor, *database.DB, *gin.Engine) {
+	cfg := &config.Config{Console: config.ConsoleConfig{Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "probe.db")}}}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	monitor := New(db, cfg)
+	monitor.backoff = 0
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	monitor.RegisterRoutes(router.Group("/api/v1"))
+	return monitor, db, router
+}
+
+// registerService registers a service with the console
+func registerService(t *testing.T, db *database.DB, id, serviceURL, healthURL string) *database.RegisteredService {
+	service := &database.RegisteredService{
+		ID:           id,
+		Name:         id,
+		DisplayName:  strings.ToUpper(id),
+		ServiceURL:   serviceURL,
+		Category:     "web",
+		RequiredRole: "user",
+		Status:       database.RegisteredServiceActive,
+	}
+	if healthURL != "" {
+		service.HealthURL = &healthURL
+	}
+	require.NoError(t, db.RegisteredServiceRepository().Create(service))
+	return service
+}
+
+// healthServer serves 200 at /health and /healthz and returns its URL and port
+func healthServer(t *testing.T) (string, int) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" && r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	return server.URL, port
+}
+
+// runBound runs a probe of the monitor and returns its result
+func runBound(t *testing.T, monitor *ProbeMonitor, probe *ProbeConfig) *ProbeResult {
+	monitor.mutex.Lock()
+	monitor.probes[probe.ID] = probe
+	monitor.mutex.Unlock()
+
+	monitor.executeProbe(probe)
+	monitor.mutex.RLock()
+	defer monitor.mutex.RUnlock()
+	var latest *ProbeResult
+	for _, result := range monitor.results {
+		if result.ProbeID == probe.ID && (latest == nil || result.Timestamp.After(latest.Timestamp)) {
+			latest = result
+		}
+	}
+	require.NotNil(t, latest, "probe %s has no result", probe.ID)
+	return latest
+}
+
+func TestResolveServiceTargets(t *testing.T) {
+	monitor, db, _ := startBindingTest(t)
+	registerService(t, db, "wiki", "https://wiki.internal/", "https://wiki.internal/status")
+	registerService(t, db, "blog", "http://blog.internal:8080", "")
+	managed := &database.Service{Name: "api", Image: "api:1", Port: 9000, Replicas: 1, Status: "running"}
+	require.NoError(t, db.ServiceRepository().Create(managed))
+
+	for _, tc := range []struct {
+		probeType string
+		ref       ServiceRef
+		target    string
+		err       string
+	}{
+		{"http", ServiceRef{Type: ServiceRefRegistered, ID: "wiki"}, "https://wiki.internal/status", ""},
+		{"http", ServiceRef{Type: ServiceRefRegistered, ID: "wiki", Endpoint: EndpointRoot}, "https://wiki.internal/", ""},
+		{"http", ServiceRef{Type: ServiceRefRegistered, ID: "wiki", Endpoint: "custom:/api/ping"}, "https://wiki.internal/api/ping", ""},
+		{"tcp", ServiceRef{Type: ServiceRefRegistered, ID: "wiki", Endpoint: EndpointRoot}, "wiki.internal:443", ""},
+		{"tls", ServiceRef{Type: ServiceRefRegistered, ID: "blog", Endpoint: EndpointRoot}, "blog.internal:8080", ""},
+		{"icmp", ServiceRef{Type: ServiceRefRegistered, ID: "blog", Endpoint: EndpointRoot}, "blog.internal", ""},
+		{"http", ServiceRef{Type: ServiceRefRegistered, ID: "blog"}, "", "has no health URL"},
+		{"http", ServiceRef{Type: ServiceRefManaged, ID: managed.ID}, "http://localhost:9000/health", ""},
+		{"tcp", ServiceRef{Type: ServiceRefManaged, ID: managed.ID}, "localhost:9000", ""},
+		{"http", ServiceRef{Type: ServiceRefManaged, ID: "missing"}, "", "service not found"},
+	} {
+		ref := tc.ref
+		target, err := monitor.resolveTarget(&ProbeConfig{Type: tc.probeType, ServiceRef: &ref})
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, "%+v", tc.ref)
+			continue
+		}
+		require.NoError(t, err, "%+v", tc.ref)
+		assert.Equal(t, tc.target, target, "%+v", tc.ref)
+	}
+
+	// Unbound probes keep their target
+	target, err := monitor.resolveTarget(&ProbeConfig{Type: "http", Target: "http://fixed"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://fixed", target)
+}
+
+func TestBoundProbeFollowsService(t *testing.T) {
+	monitor, db, router := startBindingTest(t)
+	first, firstPort := healthServer(t)
+	second, secondPort := healthServer(t)
+
+	// A registered service moving to a new URL
+	service := registerService(t, db, "wiki", first, first+"/healthz")
+	probe := &ProbeConfig{ID: "wiki-health", Name: "wiki", Type: "http", ExpectedStatus: 200, Enabled: true,
+		ServiceRef: &ServiceRef{Type: ServiceRefRegistered, ID: "wiki"}}
+	result := runBound(t, monitor, probe)
+	assert.Equal(t, "success", result.Status, result.Error)
+	assert.Equal(t, first+"/healthz", result.Metadata["resolved_target"])
+
+	healthURL := second + "/healthz"
+	service.ServiceURL, service.HealthURL = second, &healthURL
+	require.NoError(t, db.RegisteredServiceRepository().Update(service))
+	result = runBound(t, monitor, probe)
+	assert.Equal(t, "success", result.Status, result.Error)
+	assert.Equal(t, healthURL, result.Metadata["resolved_target"])
+
+	// A managed service whose port changes
+	managed := &database.Service{Name: "api", Image: "api:1", Port: firstPort, Replicas: 1, Status: "running"}
+	require.NoError(t, db.ServiceRepository().Create(managed))
+	probe = &ProbeConfig{ID: "api-health", Name: "api", Type: "tcp", Enabled: true,
+		ServiceRef: &ServiceRef{Type: ServiceRefManaged, ID: managed.ID}}
+	result = runBound(t, monitor, probe)
+	assert.Equal(t, "success", result.Status, result.Error)
+	assert.Equal(t, "localhost:"+strconv.Itoa(firstPort), result.Metadata["resolved_target"])
+
+	managed.Port = secondPort
+	require.NoError(t, db.ServiceRepository().Update(managed))
+	result = runBound(t, monitor, probe)
+	assert.Equal(t, "localhost:"+strconv.Itoa(secondPort), result.Metadata["resolved_target"])
+
+	// Deleting the service disables the probe, with the reason
+	require.NoError(t, db.RegisteredServiceRepository().Delete("wiki"))
+	monitor.executeProbe(monitor.probes["wiki-health"])
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/probes/wiki-health", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var fetched ProbeConfig
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fetched))
+	assert.False(t, fetched.Enabled)
+	assert.Equal(t, "registered service wiki: service not found", fetched.DisabledReason)
+
+	// Enabling it again clears the reason
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/probes/wiki-health/enable", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, monitor.probes["wiki-health"].DisabledReason)
+}
+
+func TestCreateBoundProbe(t *testing.T) {
+	_, db, router := startBindingTest(t)
+	registerService(t, db, "wiki", "http://wiki.internal", "http://wiki.internal/health")
+
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{"name": "wiki", "type": "http", "service_ref": {"type": "registered", "id": "wiki"}}`, http.StatusCreated},
+		{`{"name": "wiki", "type": "http", "service_ref": {"type": "registered", "id": "missing"}}`, http.StatusBadRequest},
+		{`{"name": "wiki", "type": "http", "target": "http://wiki.internal", "service_ref": {"type": "registered", "id": "wiki"}}`, http.StatusBadRequest},
+		{`{"name": "wiki", "type": "http", "service_ref": {"type": "docker", "id": "wiki"}}`, http.StatusBadRequest},
+		{`{"name": "wiki", "type": "http", "service_ref": {"type": "registered", "id": "wiki", "endpoint": "custom:"}}`, http.StatusBadRequest},
+		{`{"name": "wiki", "type": "script", "service_ref": {"type": "registered", "id": "wiki"}}`, http.StatusBadRequest},
+		{`{"name": "wiki", "type": "http"}`, http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/probes/", strings.NewReader(tc.body)))
+		assert.Equal(t, tc.code, w.Code, "%s: %s", tc.body, w.Body.String())
+	}
+}
+
+func TestSyncServiceProbes(t *testing.T) {
+	monitor, db, router := startBindingTest(t)
+	registerService(t, db, "wiki", "http://wiki.internal", "http://wiki.internal/health")
+	registerService(t, db, "blog", "http://blog.internal", "http://blog.internal/health")
+	registerService(t, db, "docs", "http://docs.internal", "")
+	registerService(t, db, "chat", "http://chat.internal", "http://chat.internal/health")
+	monitor.probes["chat-health"] = &ProbeConfig{ID: "chat-health", Type: "http", Target: "http://chat.internal/health"}
+
+	sync := func() []*ProbeConfig {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/probes/sync-services", nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response struct {
+			Created []*ProbeConfig `json:"created"`
+			Total   int            `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, len(response.Created), response.Total)
+		return response.Created
+	}
+
+	// Services with a health URL and no probe get one
+	created := sync()
+	require.Len(t, created, 2)
+	services := map[string]*ProbeConfig{}
+	for _, probe := range created {
+		services[probe.ServiceRef.ID] = probe
+	}
+	require.Contains(t, services, "wiki")
+	require.Contains(t, services, "blog")
+	wiki := services["wiki"]
+	assert.Equal(t, "WIKI Health Check", wiki.Name)
+	assert.Equal(t, "http", wiki.Type)
+	assert.Equal(t, EndpointHealth, wiki.ServiceRef.Endpoint)
+	assert.Equal(t, 200, wiki.ExpectedStatus)
+	assert.True(t, wiki.Enabled)
+	assert.Equal(t, []string{"wiki"}, wiki.Tags)
+	assert.Equal(t, database.DefaultOrgID, wiki.OrgID, "probes belong to the organization of their service")
+
+	// Syncing again creates nothing
+	assert.Empty(t, sync())
+	assert.Len(t, monitor.probes, 3)
+}
@@ -1,14 +1,70 @@
 package probe
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
 )
 
+// RegisterRoutes registers the probe API under api, which the daemon mounts
+// at /api/v1
+func (pm *ProbeMonitor) RegisterRoutes(api gin.IRouter) {
+	// Probe configuration
+	probes := api.Group("/probes")
+	{
+		probes.POST("/", pm.CreateProbe)
+		probes.POST("/sync-services", pm.SyncServiceProbes)
+		probes.GET("/", pm.ListProbes)
+		probes.GET("/:id", pm.GetProbe)
+		probes.PUT("/:id", pm.UpdateProbe)
+		probes.DELETE("/:id", pm.DeleteProbe)
+		probes.POST("/:id/enable", pm.EnableProbe)
+		probes.POST("/:id/disable", pm.DisableProbe)
+	}
+
+	// Probe results and metrics
+	results := api.Group("/results")
+	{
+		results.GET("/", pm.ListResults)
+		results.GET("/:probe_id", pm.GetProbeResults)
+		results.GET("/:probe_id/latest", pm.GetLatestResult)
+		results.GET("/:probe_id/metrics", pm.GetProbeMetrics)
+		results.GET("/:probe_id/history", pm.GetProbeHistory)
+		results.GET("/:probe_id/sla", pm.GetProbeSLA)
+	}
+
+	// Health monitoring
+	health := api.Group("/health")
+	{
+		health.GET("/services", pm.GetServiceHealth)
+		health.GET("/services/:service_id", pm.GetServiceHealthDetail)
+		health.GET("/overview", pm.GetHealthOverview)
+		health.GET("/alerts", pm.GetActiveAlerts)
+		health.GET("/sla", pm.GetTagSLA)
+	}
+
+	// Alerts created and resolved as server-sent events
+	api.GET("/events/stream", pm.eventBus.Stream)
+
+	// Monitoring control
+	control := api.Group("/control")
+	{
+		control.POST("/scan", pm.TriggerFullScan)
+		control.POST("/cleanup", pm.CleanupOldResults)
+		control.GET("/metrics", pm.GetMonitorMetrics)
+		control.GET("/status", pm.GetDetailedStatus)
+		control.POST("/notifications/test", pm.TestNotification)
+		control.GET("/publishers", pm.GetPublishers)
+	}
+}
+
 // CreateProbe creates a new monitoring probe
 func (pm *ProbeMonitor) CreateProbe(c *gin.Context) {
 	var req CreateProbeRequest
@@ -17,6 +73,19 @@ func (pm *ProbeMonitor) CreateProbe(c *gin.Context) {
 		return
 	}
 
+	if err := validateTarget(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateExpectedContent(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateScript(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Parse interval and timeout
 	interval, err := time.ParseDuration(req.Interval)
 	if err != nil {
@@ -34,6 +103,7 @@ func (pm *ProbeMonitor) CreateProbe(c *gin.Context) {
 		Name:            req.Name,
 		Type:            req.Type,
 		Target:          req.Target,
+		ServiceRef:      req.ServiceRef,
 		Interval:        interval,
 		Timeout:         timeout,
 		Retries:         req.Retries,
@@ -48,7 +118,84 @@ func (pm *ProbeMonitor) CreateProbe(c *gin.Context) {
 		Config:          req.Config,
 	}
 
-	// Set defaults
+	applyProbeDefaults(probe)
+
+	orgID, ok := probeOwner(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+	probe.OrgID = orgID
+
+	// A probe cannot be bound to a service that does not exist
+	if probe.ServiceRef != nil {
+		if _, err := pm.resolveTarget(probe); errors.Is(err, errServiceGone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	pm.mutex.Lock()
+	pm.probes[probe.ID] = probe
+	pm.mutex.Unlock()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"probe_id": probe.ID,
+		"message":  "Probe created successfully",
+		"probe":    probe,
+	})
+}
+
+// probeOwner returns the organization a probe created by a request belongs
+// to: the one the request is scoped to, else the default organization.
+// Requests scoped to no organization, of users without one, create none.
+func probeOwner(c *gin.Context) (string, bool) {
+	orgID, scoped := database.OrgScope(c.Request.Context())
+	if !scoped {
+		return database.DefaultOrgID, true
+	}
+	return orgID, orgID != ""
+}
+
+// visibleTo reports whether the organization a request is scoped to owns
+// probe. Requests without an organization see every probe.
+func visibleTo(c *gin.Context, probe *ProbeConfig) bool {
+	orgID, scoped := database.OrgScope(c.Request.Context())
+	return !scoped || probe.OrgID == orgID
+}
+
+// alertVisibleTo reports whether the organization a request is scoped to
+// owns the probe that raised alert
+func alertVisibleTo(c *gin.Context, alert *Alert) bool {
+	orgID, scoped := database.OrgScope(c.Request.Context())
+	return !scoped || alert.OrgID == orgID
+}
+
+// scopedProbe returns the probe with id if the request may see it. The
+// caller holds pm.mutex.
+func (pm *ProbeMonitor) scopedProbe(c *gin.Context, id string) (*ProbeConfig, bool) {
+	probe, exists := pm.probes[id]
+	if !exists || !visibleTo(c, probe) {
+		return nil, false
+	}
+	return probe, true
+}
+
+// hidesProbe reports whether the request is scoped to an organization that
+// does not own the probe with id, whose results it then may not see
+func (pm *ProbeMonitor) hidesProbe(c *gin.Context, id string) bool {
+	if _, scoped := database.OrgScope(c.Request.Context()); !scoped {
+		return false
+	}
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+	_, visible := pm.scopedProbe(c, id)
+	return !visible
+}
+
+// applyProbeDefaults sets the retries, expected status and thresholds of a
+// new probe that are not configured
+func applyProbeDefaults(probe *ProbeConfig) {
 	if probe.Retries <= 0 {
 		probe.Retries = 3
 	}
@@ -62,15 +209,42 @@ func (pm *ProbeMonitor) CreateProbe(c *gin.Context) {
 			ConsecutiveFail: 3,
 		}
 	}
+}
 
-	pm.mutex.Lock()
-	pm.probes[probe.ID] = probe
-	pm.mutex.Unlock()
+// validateExpectedContent checks the content_match mode and, in regex mode, the pattern
+func validateExpectedContent(req *CreateProbeRequest) error {
+	mode := contentMatchMode(req.Config)
+	if req.ExpectedContent == "" && mode == ContentMatchSubstring {
+		return nil
+	}
+	_, err := matchContent(nil, req.ExpectedContent, mode)
+	return err
+}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"probe_id": probe.ID,
-		"message":  "Probe created successfully",
-		"probe":    probe,
+// validateScript checks the steps of a script probe
+func validateScript(req *CreateProbeRequest) error {
+	if req.Type != "script" {
+		return nil
+	}
+	_, err := parseScript(req.Config)
+	return err
+}
+
+// SyncServiceProbes creates a health probe for every registered service with
+// a health URL that is not probed yet
+func (pm *ProbeMonitor) SyncServiceProbes(c *gin.Context) {
+	created, err := pm.syncServiceProbes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to sync service probes: %v", err)})
+		return
+	}
+	if created == nil {
+		created = []*ProbeConfig{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"created": created,
+		"total":   len(created),
 	})
 }
 
@@ -81,7 +255,9 @@ func (pm *ProbeMonitor) ListProbes(c *gin.Context) {
 
 	probes := make([]*ProbeConfig, 0, len(pm.probes))
 	for _, probe := range pm.probes {
-		probes = append(probes, probe)
+		if visibleTo(c, probe) {
+			probes = append(probes, probe)
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -97,7 +273,7 @@ func (pm *ProbeMonitor) GetProbe(c *gin.Context) {
 	pm.mutex.RLock()
 	defer pm.mutex.RUnlock()
 
-	probe, exists := pm.probes[probeID]
+	probe, exists := pm.scopedProbe(c, probeID)
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Probe not found"})
 		return
@@ -116,10 +292,23 @@ func (pm *ProbeMonitor) UpdateProbe(c *gin.Context) {
 		return
 	}
 
+	if err := validateTarget(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateExpectedContent(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateScript(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
-	probe, exists := pm.probes[probeID]
+	probe, exists := pm.scopedProbe(c, probeID)
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Probe not found"})
 		return
@@ -128,6 +317,7 @@ func (pm *ProbeMonitor) UpdateProbe(c *gin.Context) {
 	// Update probe configuration
 	probe.Name = req.Name
 	probe.Target = req.Target
+	probe.ServiceRef = req.ServiceRef
 	probe.ExpectedStatus = req.ExpectedStatus
 	probe.ExpectedContent = req.ExpectedContent
 	probe.Headers = req.Headers
@@ -161,12 +351,14 @@ func (pm *ProbeMonitor) DeleteProbe(c *gin.Context) {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
-	if _, exists := pm.probes[probeID]; !exists {
+	if _, exists := pm.scopedProbe(c, probeID); !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Probe not found"})
 		return
 	}
 
 	delete(pm.probes, probeID)
+	delete(pm.lastRun, probeID)
+	pm.publisher.forget(probeID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "Probe deleted successfully",
@@ -181,13 +373,14 @@ func (pm *ProbeMonitor) EnableProbe(c *gin.Context) {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
-	probe, exists := pm.probes[probeID]
+	probe, exists := pm.scopedProbe(c, probeID)
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Probe not found"})
 		return
 	}
 
 	probe.Enabled = true
+	probe.DisabledReason = ""
 	probe.UpdatedAt = time.Now()
 
 	c.JSON(http.StatusOK, gin.H{
@@ -203,7 +396,7 @@ func (pm *ProbeMonitor) DisableProbe(c *gin.Context) {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
-	probe, exists := pm.probes[probeID]
+	probe, exists := pm.scopedProbe(c, probeID)
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Probe not found"})
 		return
@@ -211,6 +404,8 @@ func (pm *ProbeMonitor) DisableProbe(c *gin.Context) {
 
 	probe.Enabled = false
 	probe.UpdatedAt = time.Now()
+	// Re-enabling runs the probe on the next tick
+	delete(pm.lastRun, probeID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "Probe disabled",
@@ -227,71 +422,102 @@ func (pm *ProbeMonitor) ListResults(c *gin.Context) {
 	limitStr := c.DefaultQuery("limit", "100")
 	limit, _ := strconv.Atoi(limitStr)
 
-	results := make([]*ProbeResult, 0)
-	count := 0
+	// Return the most recent results first, of the organization's probes
+	// when the request is scoped to one
+	_, scoped := database.OrgScope(c.Request.Context())
+	results := make([]*ProbeResult, 0, len(pm.results))
 	for _, result := range pm.results {
-		if count >= limit {
-			break
+		if _, visible := pm.scopedProbe(c, result.ProbeID); visible || !scoped {
+			results = append(results, result)
 		}
-		results = append(results, result)
-		count++
+	}
+	total := len(results)
+	sortResults(results)
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+	if limit >= 0 && len(results) > limit {
+		results = results[:limit]
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"results": results,
-		"total":   len(pm.results),
+		"total":   total,
 		"limit":   limit,
 	})
 }
 
-// GetProbeResults returns results for a specific probe
+// GetProbeResults returns results for a specific probe, optionally limited to the last hours
 func (pm *ProbeMonitor) GetProbeResults(c *gin.Context) {
 	probeID := c.Param("probe_id")
+	if pm.hidesProbe(c, probeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Probe not found"})
+		return
+	}
 
-	pm.mutex.RLock()
-	defer pm.mutex.RUnlock()
+	hours, _ := strconv.Atoi(c.DefaultQuery("hours", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
 
-	results := make([]*ProbeResult, 0)
-	for _, result := range pm.results {
-		if result.ProbeID == probeID {
-			results = append(results, result)
-		}
+	var since time.Time
+	if hours > 0 {
+		since = time.Now().Add(-time.Duration(hours) * time.Hour)
 	}
 
+	results := pm.probeResults(probeID, since, limit)
+
 	c.JSON(http.StatusOK, gin.H{
 		"probe_id": probeID,
 		"results":  results,
+		"hours":    hours,
+		"limit":    limit,
 		"total":    len(results),
 	})
 }
 
-// GetLatestResult returns the latest result for a probe
-func (pm *ProbeMonitor) GetLatestResult(c *gin.Context) {
-	probeID := c.Param("probe_id")
-
+// probeResults returns the newest limit results for a probe since the given
+// time, oldest first. Memory holds everything recorded since the monitor
+// started, so the database is only consulted when memory has too few results.
+func (pm *ProbeMonitor) probeResults(probeID string, since time.Time, limit int) []*ProbeResult {
 	pm.mutex.RLock()
-	defer pm.mutex.RUnlock()
-
-	var latestResult *ProbeResult
+	results := make([]*ProbeResult, 0)
 	for _, result := range pm.results {
-		if result.ProbeID == probeID {
-			if latestResult == nil || result.Timestamp.After(latestResult.Timestamp) {
-				latestResult = result
-			}
+		if result.ProbeID == probeID && !result.Timestamp.Before(since) {
+			results = append(results, result)
 		}
 	}
+	pm.mutex.RUnlock()
 
-	if latestResult == nil {
+	var stored []*ProbeResult
+	if limit < 0 || len(results) < limit {
+		stored = pm.store.recentResults(probeID, since, limit)
+	}
+	return mergeResults(results, stored, limit)
+}
+
+// GetLatestResult returns the latest result for a probe
+func (pm *ProbeMonitor) GetLatestResult(c *gin.Context) {
+	probeID := c.Param("probe_id")
+	if pm.hidesProbe(c, probeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Probe not found"})
+		return
+	}
+
+	results := pm.probeResults(probeID, time.Time{}, 1)
+	if len(results) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "No results found for probe"})
 		return
 	}
 
-	c.JSON(http.StatusOK, latestResult)
+	c.JSON(http.StatusOK, results[0])
 }
 
 // GetProbeMetrics returns aggregated metrics for a probe
 func (pm *ProbeMonitor) GetProbeMetrics(c *gin.Context) {
 	probeID := c.Param("probe_id")
+	if pm.hidesProbe(c, probeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Probe not found"})
+		return
+	}
 
 	pm.mutex.RLock()
 	defer pm.mutex.RUnlock()
@@ -304,35 +530,42 @@ func (pm *ProbeMonitor) GetProbeMetrics(c *gin.Context) {
 
 	minResponse = time.Hour // Initialize to large value
 
+	// Walk results in time order so consecutive failures are counted correctly
+	probeResults := make([]*ProbeResult, 0)
 	for _, result := range pm.results {
 		if result.ProbeID == probeID {
-			totalChecks++
-			
-			if result.Status == "success" {
-				successfulChecks++
-				consecutiveFails = 0
-				if result.Timestamp.After(lastSuccess) {
-					lastSuccess = result.Timestamp
-				}
-			} else {
-				failedChecks++
-				consecutiveFails++
-				if result.Timestamp.After(lastFailure) {
-					lastFailure = result.Timestamp
-				}
-			}
+			probeResults = append(probeResults, result)
+		}
+	}
+	sortResults(probeResults)
 
-			totalResponse += result.ResponseTime
-			if result.ResponseTime > maxResponse {
-				maxResponse = result.ResponseTime
+	for _, result := range probeResults {
+		totalChecks++
+		
+		if result.Status == "success" {
+			successfulChecks++
+			consecutiveFails = 0
+			if result.Timestamp.After(lastSuccess) {
+				lastSuccess = result.Timestamp
 			}
-			if result.ResponseTime < minResponse {
-				minResponse = result.ResponseTime
-			}
-			if result.Timestamp.After(lastCheck) {
-				lastCheck = result.Timestamp
+		} else {
+			failedChecks++
+			consecutiveFails++
+			if result.Timestamp.After(lastFailure) {
+				lastFailure = result.Timestamp
 			}
 		}
+
+		totalResponse += result.ResponseTime
+		if result.ResponseTime > maxResponse {
+			maxResponse = result.ResponseTime
+		}
+		if result.ResponseTime < minResponse {
+			minResponse = result.ResponseTime
+		}
+		if result.Timestamp.After(lastCheck) {
+			lastCheck = result.Timestamp
+		}
 	}
 
 	// Calculate averages and rates
@@ -371,27 +604,25 @@ func (pm *ProbeMonitor) GetProbeMetrics(c *gin.Context) {
 // GetProbeHistory returns historical data for a probe
 func (pm *ProbeMonitor) GetProbeHistory(c *gin.Context) {
 	probeID := c.Param("probe_id")
+	if pm.hidesProbe(c, probeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Probe not found"})
+		return
+	}
 	
 	// Get time range from query parameters
 	hoursStr := c.DefaultQuery("hours", "24")
 	hours, _ := strconv.Atoi(hoursStr)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "1000"))
 	
 	since := time.Now().Add(-time.Duration(hours) * time.Hour)
 
-	pm.mutex.RLock()
-	defer pm.mutex.RUnlock()
-
-	results := make([]*ProbeResult, 0)
-	for _, result := range pm.results {
-		if result.ProbeID == probeID && result.Timestamp.After(since) {
-			results = append(results, result)
-		}
-	}
+	results := pm.probeResults(probeID, since, limit)
 
 	c.JSON(http.StatusOK, gin.H{
 		"probe_id": probeID,
 		"history":  results,
 		"hours":    hours,
+		"limit":    limit,
 		"total":    len(results),
 	})
 }
@@ -490,6 +721,7 @@ func (pm *ProbeMonitor) GetServiceHealthDetail(c *gin.Context) {
 				results = append(results, result)
 			}
 		}
+		sortResults(results)
 		serviceResults[probe.ID] = results
 	}
 
@@ -562,20 +794,42 @@ func (pm *ProbeMonitor) GetHealthOverview(c *gin.Context) {
 	})
 }
 
-// GetActiveAlerts returns all active alerts
+// GetActiveAlerts returns active alerts, most recently seen first, optionally
+// limited to those seen in the last hours
 func (pm *ProbeMonitor) GetActiveAlerts(c *gin.Context) {
-	pm.mutex.RLock()
-	defer pm.mutex.RUnlock()
+	hours, _ := strconv.Atoi(c.DefaultQuery("hours", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+
+	var since time.Time
+	if hours > 0 {
+		since = time.Now().Add(-time.Duration(hours) * time.Hour)
+	}
 
+	pm.mutex.RLock()
 	activeAlerts := make([]*Alert, 0)
 	for _, alert := range pm.alerts {
-		if alert.Status == "active" {
-			activeAlerts = append(activeAlerts, alert)
+		if alert.Status == "active" && !alert.LastSeen.Before(since) && alertVisibleTo(c, alert) {
+			copied := *alert
+			activeAlerts = append(activeAlerts, &copied)
 		}
 	}
+	pm.mutex.RUnlock()
+
+	// Alerts raised before a restart are only in the database
+	var stored []*Alert
+	if limit < 0 || len(activeAlerts) < limit {
+		for _, alert := range pm.store.activeAlerts(since, limit) {
+			if alertVisibleTo(c, alert) {
+				stored = append(stored, alert)
+			}
+		}
+	}
+	activeAlerts = mergeAlerts(activeAlerts, stored, limit)
 
 	c.JSON(http.StatusOK, gin.H{
 		"alerts": activeAlerts,
+		"hours":  hours,
+		"limit":  limit,
 		"total":  len(activeAlerts),
 	})
 }
@@ -590,12 +844,47 @@ func (pm *ProbeMonitor) TriggerFullScan(c *gin.Context) {
 	})
 }
 
-// CleanupOldResults removes old probe results
+// CleanupOldResults removes old probe results and resolved alerts
 func (pm *ProbeMonitor) CleanupOldResults(c *gin.Context) {
-	pm.performCleanup()
+	prunedResults, prunedAlerts := pm.performCleanup()
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Cleanup completed",
+		"message":        "Cleanup completed",
+		"pruned_results": prunedResults,
+		"pruned_alerts":  prunedAlerts,
+		"timestamp":      time.Now(),
+	})
+}
+
+// TestNotificationRequest names the channel a test message is sent through
+type TestNotificationRequest struct {
+	Channel string `json:"channel" binding:"required"`
+	Message string `json:"message"`
+}
+
+// TestNotification sends a test message through a configured notification channel
+func (pm *ProbeMonitor) TestNotification(c *gin.Context) {
+	var req TestNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Message == "" {
+		req.Message = "Test notification from the InfraCore probe monitor"
+	}
+
+	if err := pm.sendTestNotification(c.Request.Context(), req.Channel, req.Message); err != nil {
+		if errors.Is(err, errChannelNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification channel not found"})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Notification failed: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Test notification sent",
+		"channel":   req.Channel,
 		"timestamp": time.Now(),
 	})
 }
@@ -685,4 +974,20 @@ func (pm *ProbeMonitor) GetDetailedStatus(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, status)
-}
\ No newline at end of file
+}
+
+// GetPublishers reports the deliveries of probe state changes to each
+// publisher target
+func (pm *ProbeMonitor) GetPublishers(c *gin.Context) {
+	stats := pm.publisher.stats()
+
+	deadLetterFile := ""
+	if pm.publisher != nil {
+		deadLetterFile = pm.publisher.deadLetters
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"publishers":       stats,
+		"count":            len(stats),
+		"dead_letter_file": deadLetterFile,
+	})
+}
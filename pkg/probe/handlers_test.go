@@ -0,0 +1,105 @@
+package probe
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestProbeOrgScope(t *testing.T) {
+	monitor, _, _ := startBindingTest(t)
+	monitor.probes["gate-health"] = &ProbeConfig{ID: "gate-health", Type: "http", Target: "http://localhost:80/health"}
+	router := gin.New()
+	router.Use(middleware.OrgScopeMiddleware())
+	monitor.RegisterRoutes(router.Group("/api/v1"))
+
+	// serveAs sends a request scoped to orgID, or unscoped when orgID is nil
+	serveAs := func(orgID *string, method, path, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if orgID != nil {
+			req.Header.Set(database.OrgHeader, *orgID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+	acme, globex, none := "acme", "globex", ""
+	body := `{"name": "acme-site", "type": "tcp", "target": "127.0.0.1:1", "interval": "1h"}`
+
+	code, response := serveAs(&acme, http.MethodPost, "/api/v1/probes/", body)
+	require.Equal(t, http.StatusCreated, code, response)
+	probeID := response["probe_id"].(string)
+	assert.Equal(t, "acme", response["probe"].(map[string]interface{})["org_id"])
+
+	monitor.mutex.Lock()
+	monitor.results["r1"] = &ProbeResult{ID: "r1", ProbeID: probeID, Status: "success", Timestamp: time.Now()}
+	monitor.mutex.Unlock()
+
+	code, response = serveAs(&acme, http.MethodGet, "/api/v1/probes/"+probeID, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "acme-site", response["name"])
+	code, response = serveAs(&acme, http.MethodGet, "/api/v1/probes/", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 1, response["total"], "the monitor's own probes belong to no organization")
+	code, response = serveAs(&acme, http.MethodGet, "/api/v1/results/", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 1, response["total"])
+
+	// Another organization's probes and their results do not exist for its
+	// members
+	code, response = serveAs(&globex, http.MethodGet, "/api/v1/probes/", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 0, response["total"])
+	code, response = serveAs(&globex, http.MethodGet, "/api/v1/results/", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 0, response["total"])
+	for _, request := range []struct{ method, path, body string }{
+		{http.MethodGet, "/api/v1/probes/" + probeID, ""},
+		{http.MethodPut, "/api/v1/probes/" + probeID, body},
+		{http.MethodPost, "/api/v1/probes/" + probeID + "/disable", ""},
+		{http.MethodDelete, "/api/v1/probes/" + probeID, ""},
+		{http.MethodGet, "/api/v1/results/" + probeID, ""},
+		{http.MethodGet, "/api/v1/results/" + probeID + "/latest", ""},
+		{http.MethodGet, "/api/v1/results/" + probeID + "/metrics", ""},
+		{http.MethodGet, "/api/v1/results/" + probeID + "/history", ""},
+		{http.MethodGet, "/api/v1/results/" + probeID + "/sla", ""},
+	} {
+		code, _ := serveAs(&globex, request.method, request.path, request.body)
+		assert.Equal(t, http.StatusNotFound, code, "%s %s", request.method, request.path)
+	}
+	code, _ = serveAs(&none, http.MethodPost, "/api/v1/probes/", body)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Unscoped requests, of the console's admins, see every probe
+	code, response = serveAs(nil, http.MethodGet, "/api/v1/probes/", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 2, response["total"])
+	code, response = serveAs(nil, http.MethodGet, "/api/v1/probes/"+probeID, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response["enabled"])
+
+	// Alerts are seen by the organization owning the probe that raised them
+	monitor.createAlert(probeID, "availability", "critical", "acme-site is down")
+	code, response = serveAs(&acme, http.MethodGet, "/api/v1/health/alerts", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 1, response["total"])
+	code, response = serveAs(&globex, http.MethodGet, "/api/v1/health/alerts", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 0, response["total"])
+
+	code, _ = serveAs(&acme, http.MethodDelete, "/api/v1/probes/"+probeID, "")
+	assert.Equal(t, http.StatusOK, code)
+}
@@ -0,0 +1,292 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ICMP probes send count echo requests (config key "count") to the target
+// host and fail when more than max_packet_loss (a fraction, config key
+// "max_packet_loss") of them go unanswered. Echoes are sent over an
+// unprivileged datagram ICMP socket where the kernel allows one (on Linux,
+// when the process's group is within net.ipv4.ping_group_range), else over a
+// raw socket, which needs CAP_NET_RAW. Without either the probe falls back to
+// connecting to TCP port 80, which only shows whether the host answers.
+
+// ICMP probe methods, recorded in the method metadata of results
+const (
+	ICMPMethodUDP         = "udp"
+	ICMPMethodRaw         = "raw"
+	ICMPMethodTCPFallback = "tcp-fallback"
+)
+
+const (
+	defaultPingCount     = 3
+	maxPingCount         = 100
+	defaultMaxPacketLoss = 0.5
+	defaultPingTimeout   = 5 * time.Second
+)
+
+// pingPayload is the data of the echo requests
+var pingPayload = []byte("infra-core-probe")
+
+// pinger sends echo requests over one ICMP socket
+type pinger struct {
+	conn     *icmp.PacketConn
+	method   string
+	ipv6     bool
+	id       int // echo identifier, replaced by the kernel on datagram sockets
+	received []byte
+}
+
+// openPinger opens a datagram ICMP socket for the address family of dst,
+// else a raw one, returning why both failed
+func openPinger(dst net.IP) (*pinger, error) {
+	v6 := dst.To4() == nil
+	sockets := []struct{ method, network, address string }{
+		{ICMPMethodUDP, "udp4", "0.0.0.0"},
+		{ICMPMethodRaw, "ip4:icmp", "0.0.0.0"},
+	}
+	if v6 {
+		sockets = []struct{ method, network, address string }{
+			{ICMPMethodUDP, "udp6", "::"},
+			{ICMPMethodRaw, "ip6:ipv6-icmp", "::"},
+		}
+	}
+
+	var errs []error
+	for _, socket := range sockets {
+		conn, err := icmp.ListenPacket(socket.network, socket.address)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s socket: %w", socket.method, err))
+			continue
+		}
+		return &pinger{
+			conn:     conn,
+			method:   socket.method,
+			ipv6:     v6,
+			id:       rand.Intn(1 << 16),
+			received: make([]byte, 1500),
+		}, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// ping sends the echo request numbered seq to dst and waits for its reply
+// until deadline, returning the round trip time
+func (p *pinger) ping(dst net.IP, seq int, deadline time.Time) (time.Duration, error) {
+	message := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: p.id, Seq: seq, Data: pingPayload},
+	}
+	replyType, protocol := icmp.Type(ipv4.ICMPTypeEchoReply), 1
+	if p.ipv6 {
+		// The kernel computes the ICMPv6 checksum
+		message.Type = ipv6.ICMPTypeEchoRequest
+		replyType, protocol = ipv6.ICMPTypeEchoReply, 58
+	}
+	data, err := message.Marshal(nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode echo request: %w", err)
+	}
+
+	var addr net.Addr = &net.IPAddr{IP: dst}
+	if p.method == ICMPMethodUDP {
+		addr = &net.UDPAddr{IP: dst}
+	}
+	if err := p.conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	sent := time.Now()
+	if _, err := p.conn.WriteTo(data, addr); err != nil {
+		return 0, fmt.Errorf("failed to send echo request: %w", err)
+	}
+
+	// Raw sockets receive every ICMP message the host does
+	for {
+		n, peer, err := p.conn.ReadFrom(p.received)
+		if err != nil {
+			return 0, err
+		}
+		rtt := time.Since(sent)
+		reply, err := icmp.ParseMessage(protocol, p.received[:n])
+		if err != nil || reply.Type != replyType || !peerIP(peer).Equal(dst) {
+			continue
+		}
+		echo, ok := reply.Body.(*icmp.Echo)
+		if !ok || echo.Seq != seq || (p.method == ICMPMethodRaw && echo.ID != p.id) {
+			continue
+		}
+		return rtt, nil
+	}
+}
+
+// peerIP returns the IP address of the sender of an ICMP message
+func peerIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+// executeICMPProbe pings the target host, recording the method used, the
+// packets sent and received, the packet loss in percent and the minimum,
+// average and maximum round trip times in milliseconds
+func (pm *ProbeMonitor) executeICMPProbe(probe *ProbeConfig, result *ProbeResult) {
+	count, maxLoss, err := icmpSettings(probe.Config)
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+		return
+	}
+	timeout := probe.Timeout
+	if timeout <= 0 {
+		timeout = defaultPingTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	dst, err := resolvePingTarget(ctx, probe.Target)
+	if err != nil {
+		result.Status = "failure"
+		result.Error = fmt.Sprintf("failed to resolve %s: %v", probe.Target, err)
+		return
+	}
+	result.Metadata["address"] = dst.String()
+
+	p, err := openPinger(dst)
+	if err != nil {
+		result.Metadata["method"] = ICMPMethodTCPFallback
+		result.Metadata["fallback_reason"] = err.Error()
+		executeTCPPing(dst, timeout, result)
+		return
+	}
+	defer p.conn.Close()
+	result.Metadata["method"] = p.method
+
+	// Each echo gets an equal share of the timeout
+	start := time.Now()
+	perPacket := timeout / time.Duration(count)
+	var rtts []time.Duration
+	var lastErr error
+	for seq := 1; seq <= count; seq++ {
+		rtt, err := p.ping(dst, seq, time.Now().Add(perPacket))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		rtts = append(rtts, rtt)
+	}
+
+	loss := float64(count-len(rtts)) / float64(count)
+	result.Metadata["packets_sent"] = count
+	result.Metadata["packets_received"] = len(rtts)
+	result.Metadata["packet_loss"] = loss * 100
+	result.ResponseTime = time.Since(start)
+	if len(rtts) > 0 {
+		minRTT, maxRTT, total := rtts[0], rtts[0], time.Duration(0)
+		for _, rtt := range rtts {
+			minRTT, maxRTT, total = min(minRTT, rtt), max(maxRTT, rtt), total+rtt
+		}
+		avgRTT := total / time.Duration(len(rtts))
+		result.Metadata["rtt_min_ms"] = milliseconds(minRTT)
+		result.Metadata["rtt_avg_ms"] = milliseconds(avgRTT)
+		result.Metadata["rtt_max_ms"] = milliseconds(maxRTT)
+		result.ResponseTime = avgRTT
+	}
+
+	if loss > maxLoss {
+		result.Status = "failure"
+		result.Message = fmt.Sprintf("%.0f%% packet loss, at most %.0f%% allowed", loss*100, maxLoss*100)
+		if lastErr != nil {
+			result.Error = lastErr.Error()
+		}
+		return
+	}
+	result.Status = "success"
+	result.Message = fmt.Sprintf("%d of %d echo replies received", len(rtts), count)
+}
+
+// executeTCPPing checks that dst answers a connection to TCP port 80. A
+// refused connection also shows the host is up.
+func executeTCPPing(dst net.IP, timeout time.Duration, result *ProbeResult) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(dst.String(), "80"), timeout)
+	result.ResponseTime = time.Since(start)
+	if err == nil {
+		conn.Close()
+	} else if !errors.Is(err, syscall.ECONNREFUSED) {
+		result.Status = "failure"
+		result.Error = fmt.Sprintf("ICMP unavailable and TCP check failed: %v", err)
+		return
+	}
+	result.Metadata["rtt_avg_ms"] = milliseconds(result.ResponseTime)
+	result.Status = "success"
+	result.Message = "host answered on TCP port 80 (ICMP unavailable)"
+}
+
+// resolvePingTarget returns the address of the host an ICMP probe targets,
+// which may be written with a port or in brackets
+func resolvePingTarget(ctx context.Context, target string) (net.IP, error) {
+	host := target
+	if h, _, err := net.SplitHostPort(target); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+	return addrs[0].IP, nil
+}
+
+// icmpSettings returns the packet count and the maximum packet loss of an
+// ICMP probe's config
+func icmpSettings(cfg map[string]interface{}) (int, float64, error) {
+	count, maxLoss := defaultPingCount, defaultMaxPacketLoss
+	switch v := cfg["count"].(type) {
+	case float64:
+		count = int(v)
+	case int:
+		count = v
+	}
+	if count < 1 || count > maxPingCount {
+		return 0, 0, fmt.Errorf("invalid count %d, expected 1 to %d", count, maxPingCount)
+	}
+
+	switch v := cfg["max_packet_loss"].(type) {
+	case float64:
+		maxLoss = v
+	case int:
+		maxLoss = float64(v)
+	}
+	if maxLoss < 0 || maxLoss > 1 {
+		return 0, 0, fmt.Errorf("invalid max_packet_loss %v, expected a fraction from 0 to 1", maxLoss)
+	}
+	return count, maxLoss, nil
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
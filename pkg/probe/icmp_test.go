@@ -0,0 +1,152 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/icmp"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// runICMPProbe runs an ICMP probe of target once
+func runICMPProbe(target string, timeout time.Duration, cfg map[string]interface{}) *ProbeResult {
+	monitor := New(&database.DB{}, &config.Config{})
+	result := &ProbeResult{Metadata: make(map[string]interface{})}
+	monitor.executeICMPProbe(&ProbeConfig{Type: "icmp", Target: target, Timeout: timeout, Config: cfg}, result)
+	return result
+}
+
+func TestICMPProbeLocalhost(t *testing.T) {
+	targets := []string{"127.0.0.1", "localhost"}
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+		ln.Close()
+		targets = append(targets, "::1", "[::1]:80")
+	}
+
+	for _, target := range targets {
+		t.Run(target, func(t *testing.T) {
+			result := runICMPProbe(target, 2*time.Second, map[string]interface{}{"count": float64(2)})
+
+			require.Equal(t, "success", result.Status, "%s: %s", result.Message, result.Error)
+			ip := net.ParseIP(result.Metadata["address"].(string))
+			require.NotNil(t, ip)
+			assert.True(t, ip.IsLoopback())
+			if result.Metadata["method"] == ICMPMethodTCPFallback {
+				t.Logf("ICMP sockets unavailable: %s", result.Metadata["fallback_reason"])
+				assert.NotContains(t, result.Metadata, "packet_loss")
+				return
+			}
+			assert.Contains(t, []interface{}{ICMPMethodUDP, ICMPMethodRaw}, result.Metadata["method"])
+			assert.Equal(t, 2, result.Metadata["packets_sent"])
+			assert.Equal(t, 2, result.Metadata["packets_received"])
+			assert.Equal(t, 0.0, result.Metadata["packet_loss"])
+			minRTT, avgRTT, maxRTT := result.Metadata["rtt_min_ms"].(float64), result.Metadata["rtt_avg_ms"].(float64), result.Metadata["rtt_max_ms"].(float64)
+			assert.True(t, minRTT <= avgRTT && avgRTT <= maxRTT, "rtt %v/%v/%v", minRTT, avgRTT, maxRTT)
+			assert.Positive(t, result.ResponseTime)
+		})
+	}
+}
+
+func TestICMPProbeUnreachable(t *testing.T) {
+	// 198.51.100.0/24 is reserved for documentation and 100::/64 discards
+	// packets, so neither answers
+	for _, target := range []string{"198.51.100.1", "100::1"} {
+		t.Run(target, func(t *testing.T) {
+			start := time.Now()
+			result := runICMPProbe(target, 600*time.Millisecond, map[string]interface{}{"count": 2, "max_packet_loss": 0.5})
+
+			assert.Equal(t, "failure", result.Status)
+			assert.Less(t, time.Since(start), 2*time.Second, "the timeout bounds the whole probe")
+			if result.Metadata["method"] != ICMPMethodTCPFallback {
+				assert.Equal(t, 0, result.Metadata["packets_received"])
+				assert.Equal(t, 100.0, result.Metadata["packet_loss"])
+				assert.Contains(t, result.Message, "100% packet loss")
+				assert.NotContains(t, result.Metadata, "rtt_avg_ms")
+			}
+		})
+	}
+}
+
+func TestICMPProbeSockets(t *testing.T) {
+	for _, socket := range []struct{ method, network string }{
+		{ICMPMethodUDP, "udp4"},
+		{ICMPMethodRaw, "ip4:icmp"},
+	} {
+		t.Run(socket.method, func(t *testing.T) {
+			conn, err := icmp.ListenPacket(socket.network, "0.0.0.0")
+			if err != nil {
+				t.Skipf("%s ICMP sockets unavailable: %v", socket.method, err)
+			}
+			p := &pinger{conn: conn, method: socket.method, id: 4242, received: make([]byte, 1500)}
+			defer p.conn.Close()
+
+			rtt, err := p.ping(net.ParseIP("127.0.0.1"), 7, time.Now().Add(time.Second))
+			require.NoError(t, err)
+			assert.Positive(t, rtt)
+		})
+	}
+}
+
+func TestTCPPingFallback(t *testing.T) {
+	// Connected or refused, the host answered
+	result := &ProbeResult{Metadata: make(map[string]interface{})}
+	executeTCPPing(net.ParseIP("127.0.0.1"), time.Second, result)
+	assert.Equal(t, "success", result.Status, result.Error)
+	assert.Contains(t, result.Metadata, "rtt_avg_ms")
+}
+
+func TestICMPSettings(t *testing.T) {
+	count, maxLoss, err := icmpSettings(nil)
+	require.NoError(t, err)
+	assert.Equal(t, defaultPingCount, count)
+	assert.Equal(t, defaultMaxPacketLoss, maxLoss)
+
+	count, maxLoss, err = icmpSettings(map[string]interface{}{"count": float64(5), "max_packet_loss": float64(0)})
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+	assert.Equal(t, 0.0, maxLoss)
+
+	for _, cfg := range []map[string]interface{}{
+		{"count": 0},
+		{"count": float64(maxPingCount + 1)},
+		{"max_packet_loss": 1.5},
+		{"max_packet_loss": -1},
+	} {
+		_, _, err := icmpSettings(cfg)
+		assert.Error(t, err, "%v", cfg)
+	}
+
+	result := runICMPProbe("127.0.0.1", time.Second, map[string]interface{}{"count": 0})
+	assert.Equal(t, "error", result.Status)
+}
+
+func TestResolvePingTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"127.0.0.1", "127.0.0.1"},
+		{"127.0.0.1:80", "127.0.0.1"},
+		{"::1", "::1"},
+		{"[::1]", "::1"},
+		{"[::1]:80", "::1"},
+	}
+	for _, tt := range tests {
+		ip, err := resolvePingTarget(context.Background(), tt.target)
+		require.NoError(t, err, tt.target)
+		assert.Equal(t, tt.expected, ip.String(), tt.target)
+	}
+
+	ip, err := resolvePingTarget(context.Background(), "localhost")
+	require.NoError(t, err)
+	assert.True(t, ip.IsLoopback())
+
+	_, err = resolvePingTarget(context.Background(), "host.invalid")
+	assert.Error(t, err)
+}
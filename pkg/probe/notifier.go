@@ -0,0 +1,414 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/events"
+)
+
+// Notification events
+const (
+	EventAlertCreated  = "alert_created"
+	EventAlertResolved = "alert_resolved"
+	EventTest          = "test"
+)
+
+// Notification delivery outcomes recorded in alert metadata
+const (
+	DeliveryDelivered  = "delivered"
+	DeliveryFailed     = "failed"
+	DeliverySuppressed = "suppressed"
+)
+
+// Notification defaults used when the probe config leaves them unset
+const (
+	defaultNotifyRateLimit  = 5 * time.Minute
+	defaultNotifyMaxRetries = 3
+	notifyBackoff           = time.Second
+	notifyTimeout           = 10 * time.Second
+)
+
+// errChannelNotFound is returned for test messages to unconfigured channels
+var errChannelNotFound = errors.New("notification channel not found")
+
+// Notification is what channels are told when an alert is raised or resolved
+type Notification struct {
+	Event     string    `json:"event"`
+	AlertID   string    `json:"alert_id,omitempty"`
+	ProbeID   string    `json:"probe_id,omitempty"`
+	Probe     string    `json:"probe,omitempty"` // probe name
+	Type      string    `json:"type,omitempty"`
+	Severity  string    `json:"severity,omitempty"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Summary returns a one line description of the notification
+func (n Notification) Summary() string {
+	probe := n.Probe
+	if probe == "" {
+		probe = n.ProbeID
+	}
+
+	switch n.Event {
+	case EventAlertCreated:
+		return fmt.Sprintf("🚨 [%s] %s: %s", strings.ToUpper(n.Severity), probe, n.Message)
+	case EventAlertResolved:
+		return fmt.Sprintf("✅ [%s] %s resolved: %s", strings.ToUpper(n.Severity), probe, n.Message)
+	default:
+		return n.Message
+	}
+}
+
+// Notifier delivers notifications through one channel
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// WebhookNotifier POSTs notifications to a URL as JSON
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a notifier posting to url
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: &http.Client{Timeout: notifyTimeout}}
+}
+
+// Notify posts the notification, failing unless the webhook answers with a 2xx status
+func (wn *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	return postJSON(ctx, wn.client, wn.url, n)
+}
+
+// SlackNotifier posts notifications to a Slack-compatible incoming webhook
+type SlackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewSlackNotifier creates a notifier posting to the incoming webhook at url
+func NewSlackNotifier(url string) *SlackNotifier {
+	return &SlackNotifier{url: url, client: &http.Client{Timeout: notifyTimeout}}
+}
+
+// Notify posts the notification's summary as the message text
+func (sn *SlackNotifier) Notify(ctx context.Context, n Notification) error {
+	return postJSON(ctx, sn.client, sn.url, map[string]string{"text": n.Summary()})
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// EmailNotifier mails notifications through an SMTP server
+type EmailNotifier struct {
+	cfg      config.SMTPConfig
+	sendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewEmailNotifier creates a notifier sending through the configured server
+func NewEmailNotifier(cfg config.SMTPConfig) *EmailNotifier {
+	return &EmailNotifier{cfg: cfg, sendMail: smtp.SendMail}
+}
+
+// Notify mails the notification to every configured recipient
+func (en *EmailNotifier) Notify(ctx context.Context, n Notification) error {
+	port := en.cfg.Port
+	if port == 0 {
+		port = 587
+	}
+
+	var auth smtp.Auth
+	if en.cfg.Username != "" {
+		auth = smtp.PlainAuth("", en.cfg.Username, en.cfg.Password, en.cfg.Host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", en.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(en.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", n.Summary())
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n", n.Message)
+	for _, field := range [][2]string{
+		{"Event", n.Event}, {"Probe", n.ProbeID}, {"Alert", n.AlertID},
+		{"Type", n.Type}, {"Severity", n.Severity},
+	} {
+		if field[1] != "" {
+			fmt.Fprintf(&msg, "%s: %s\r\n", field[0], field[1])
+		}
+	}
+	fmt.Fprintf(&msg, "Time: %s\r\n", n.Timestamp.UTC().Format(time.RFC3339))
+
+	addr := net.JoinHostPort(en.cfg.Host, strconv.Itoa(port))
+	if err := en.sendMail(addr, auth, en.cfg.From, en.cfg.To, []byte(msg.String())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// newNotifier creates the notifier for a configured channel
+func newNotifier(channel config.NotificationChannelConfig) (Notifier, error) {
+	switch channel.Type {
+	case "webhook":
+		return NewWebhookNotifier(channel.URL), nil
+	case "slack":
+		return NewSlackNotifier(channel.URL), nil
+	case "email":
+		return NewEmailNotifier(channel.SMTP), nil
+	default:
+		return nil, fmt.Errorf("unsupported notification channel type: %s", channel.Type)
+	}
+}
+
+// notificationRouter sends alert notifications to the channels routed for
+// their severity, at most once per rate limit window for each probe and event
+type notificationRouter struct {
+	channels  map[string]Notifier
+	routes    map[string][]string
+	enabled   bool // alerts are only routed when notifications are enabled, test messages always go out
+	rateLimit time.Duration
+	retries   int
+	backoff   time.Duration // delay before the first retry, doubled for each further retry
+	mutex     sync.Mutex
+	lastSent  map[string]time.Time // keyed by probe ID and event
+}
+
+// newNotificationRouter returns nil when no channels are configured
+func newNotificationRouter(cfg config.ProbeMonitorConfig) *notificationRouter {
+	if len(cfg.Notifications.Channels) == 0 {
+		return nil
+	}
+
+	router := &notificationRouter{
+		channels:  make(map[string]Notifier),
+		routes:    cfg.Notifications.Routes,
+		enabled:   cfg.EnableNotifications,
+		rateLimit: defaultNotifyRateLimit,
+		retries:   defaultNotifyMaxRetries,
+		backoff:   notifyBackoff,
+		lastSent:  make(map[string]time.Time),
+	}
+	if limit, err := time.ParseDuration(cfg.Notifications.RateLimit); err == nil {
+		router.rateLimit = limit
+	}
+	if cfg.Notifications.MaxRetries > 0 {
+		router.retries = cfg.Notifications.MaxRetries
+	}
+
+	for _, channel := range cfg.Notifications.Channels {
+		notifier, err := newNotifier(channel)
+		if err != nil {
+			log.Printf("⚠️ Skipping notification channel %s: %v", channel.Name, err)
+			continue
+		}
+		router.channels[channel.Name] = notifier
+	}
+	return router
+}
+
+// route returns the channels a notification goes to, or nil when it isn't
+// routed anywhere or a notification for the same probe and event went out
+// within the rate limit. The second value reports whether it was rate limited.
+func (nr *notificationRouter) route(n Notification) ([]string, bool) {
+	if nr == nil || !nr.enabled || len(nr.routes[n.Severity]) == 0 {
+		return nil, false
+	}
+
+	nr.mutex.Lock()
+	defer nr.mutex.Unlock()
+
+	key := n.ProbeID + "/" + n.Event
+	if last, ok := nr.lastSent[key]; ok && n.Timestamp.Sub(last) < nr.rateLimit {
+		return nil, true
+	}
+	nr.lastSent[key] = n.Timestamp
+	return nr.routes[n.Severity], false
+}
+
+// deliver sends a notification through a channel, retrying failures with
+// backoff until the retries run out or ctx is done, and returns the delivery record
+func (nr *notificationRouter) deliver(ctx context.Context, name string, n Notification) map[string]interface{} {
+	record := map[string]interface{}{
+		"channel": name,
+		"event":   n.Event,
+	}
+
+	notifier, ok := nr.channels[name]
+	if !ok {
+		record["status"] = DeliveryFailed
+		record["attempts"] = 0
+		record["error"] = "unknown notification channel"
+		record["time"] = time.Now().UTC().Format(time.RFC3339)
+		return record
+	}
+
+	attempts := 1
+	err := notifier.Notify(ctx, n)
+	for backoff := nr.backoff; err != nil && attempts <= nr.retries; backoff *= 2 {
+		if !waitContext(ctx, backoff) {
+			break
+		}
+		attempts++
+		err = notifier.Notify(ctx, n)
+	}
+
+	record["attempts"] = attempts
+	record["time"] = time.Now().UTC().Format(time.RFC3339)
+	if err != nil {
+		record["status"] = DeliveryFailed
+		record["error"] = err.Error()
+		log.Printf("⚠️ Notification %s for %s via %s failed after %d attempts: %v", n.Event, n.ProbeID, name, attempts, err)
+	} else {
+		record["status"] = DeliveryDelivered
+	}
+	return record
+}
+
+// waitContext waits for d, returning false if ctx is done first
+func waitContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// notify publishes an alert event and routes it to its channels. Delivery
+// happens in the background and its outcome is recorded in the alert's
+// notifications metadata. Callers must hold the monitor mutex.
+func (pm *ProbeMonitor) notify(alert *Alert, event string) {
+	n := Notification{
+		Event:     event,
+		AlertID:   alert.ID,
+		ProbeID:   alert.ProbeID,
+		Type:      alert.Type,
+		Severity:  alert.Severity,
+		Message:   alert.Message,
+		Timestamp: time.Now(),
+	}
+	if probe, ok := pm.probes[alert.ProbeID]; ok {
+		n.Probe = probe.Name
+	}
+
+	eventType := events.AlertCreated
+	if event == EventAlertResolved {
+		eventType = events.AlertResolved
+	}
+	pm.eventBus.Publish(eventType, events.Alert{
+		AlertID:  alert.ID,
+		ProbeID:  alert.ProbeID,
+		Probe:    n.Probe,
+		Type:     alert.Type,
+		Severity: alert.Severity,
+		Message:  alert.Message,
+	})
+
+	channels, limited := pm.notifications.route(n)
+	if limited {
+		recordDeliveries(alert, []map[string]interface{}{{
+			"event":  event,
+			"status": DeliverySuppressed,
+			"time":   n.Timestamp.UTC().Format(time.RFC3339),
+		}})
+		pm.store.saveAlert(alert)
+		return
+	}
+	if len(channels) == 0 {
+		return
+	}
+
+	go func() {
+		records := make([]map[string]interface{}, len(channels))
+		var wg sync.WaitGroup
+		for i, name := range channels {
+			wg.Add(1)
+			go func(i int, name string) {
+				defer wg.Done()
+				records[i] = pm.notifications.deliver(pm.ctx, name, n)
+			}(i, name)
+		}
+		wg.Wait()
+
+		pm.mutex.Lock()
+		recordDeliveries(alert, records)
+		pm.store.saveAlert(alert)
+		pm.mutex.Unlock()
+	}()
+}
+
+// recordDeliveries appends delivery records to an alert's notifications
+// metadata. The metadata map is replaced rather than modified, so copies of the
+// alert handed out under a read lock are never written to.
+func recordDeliveries(alert *Alert, records []map[string]interface{}) {
+	metadata := make(map[string]interface{}, len(alert.Metadata)+1)
+	for key, value := range alert.Metadata {
+		metadata[key] = value
+	}
+
+	var deliveries []interface{}
+	if existing, ok := alert.Metadata["notifications"].([]interface{}); ok {
+		deliveries = append(deliveries, existing...)
+	}
+	for _, record := range records {
+		deliveries = append(deliveries, record)
+	}
+	metadata["notifications"] = deliveries
+	alert.Metadata = metadata
+}
+
+// sendTestNotification sends a test message through a named channel in a
+// single attempt, ignoring routing and rate limits
+func (pm *ProbeMonitor) sendTestNotification(ctx context.Context, channel, message string) error {
+	notifier, ok := pm.notifications.channel(channel)
+	if !ok {
+		return errChannelNotFound
+	}
+	return notifier.Notify(ctx, Notification{
+		Event:     EventTest,
+		Message:   message,
+		Timestamp: time.Now(),
+	})
+}
+
+func (nr *notificationRouter) channel(name string) (Notifier, bool) {
+	if nr == nil {
+		return nil, false
+	}
+	notifier, ok := nr.channels[name]
+	return notifier, ok
+}
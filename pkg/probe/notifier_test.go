@@ -0,0 +1,359 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// captureWebhook records the JSON bodies posted to it, answering with 500 for
+// the first failures requests
+func captureWebhook(t *testing.T, failures int32) (*httptest.Server, func() []map[string]interface{}) {
+	var mutex sync.Mutex
+	var requests int32
+	var payloads []map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]interface{}
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		mutex.Lock()
+		payloads = append(payloads, payload)
+		mutex.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []map[string]interface{} {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]map[string]interface{}{}, payloads...)
+	}
+}
+
+// notifyingMonitor creates a monitor routing critical alerts to a webhook and
+// a Slack channel and medium alerts to the webhook only
+func notifyingMonitor(webhookURL, slackURL string) *ProbeMonitor {
+	cfg := &config.Config{Probe: config.ProbeMonitorConfig{
+		EnableNotifications: true,
+		Notifications: config.ProbeNotificationsConfig{
+			Channels: []config.NotificationChannelConfig{
+				{Name: "ops", Type: "webhook", URL: webhookURL},
+				{Name: "chat", Type: "slack", URL: slackURL},
+			},
+			Routes: map[string][]string{
+				"critical": {"ops", "chat"},
+				"medium":   {"ops"},
+			},
+			MaxRetries: 2,
+		},
+	}}
+
+	monitor := New(&database.DB{}, cfg)
+	monitor.notifications.backoff = time.Millisecond
+	monitor.probes["api"] = &ProbeConfig{ID: "api", Name: "API Health Check"}
+	return monitor
+}
+
+// alertDeliveries waits for count delivery records on the alert of a probe
+// and returns them
+func alertDeliveries(t *testing.T, monitor *ProbeMonitor, probeID string, count int) []map[string]interface{} {
+	var deliveries []map[string]interface{}
+	require.Eventually(t, func() bool {
+		monitor.mutex.RLock()
+		defer monitor.mutex.RUnlock()
+
+		deliveries = nil
+		for _, alert := range monitor.alerts {
+			if alert.ProbeID != probeID {
+				continue
+			}
+			records, _ := alert.Metadata["notifications"].([]interface{})
+			for _, record := range records {
+				deliveries = append(deliveries, record.(map[string]interface{}))
+			}
+		}
+		return len(deliveries) == count
+	}, 5*time.Second, 5*time.Millisecond)
+	return deliveries
+}
+
+func TestAlertNotificationRouting(t *testing.T) {
+	webhook, webhookPayloads := captureWebhook(t, 0)
+	slack, slackPayloads := captureWebhook(t, 0)
+	monitor := notifyingMonitor(webhook.URL, slack.URL)
+
+	monitor.createAlert("api", "availability", "critical", "Service has failed 3 consecutive checks")
+	deliveries := alertDeliveries(t, monitor, "api", 2)
+	for _, delivery := range deliveries {
+		assert.Equal(t, DeliveryDelivered, delivery["status"])
+		assert.Equal(t, EventAlertCreated, delivery["event"])
+		assert.Equal(t, 1, delivery["attempts"])
+	}
+
+	require.Len(t, webhookPayloads(), 1)
+	payload := webhookPayloads()[0]
+	assert.Equal(t, EventAlertCreated, payload["event"])
+	assert.Equal(t, "api", payload["probe_id"])
+	assert.Equal(t, "API Health Check", payload["probe"])
+	assert.Equal(t, "critical", payload["severity"])
+	assert.Equal(t, "availability", payload["type"])
+	assert.Equal(t, "Service has failed 3 consecutive checks", payload["message"])
+	assert.Equal(t, []map[string]interface{}{
+		{"text": "🚨 [CRITICAL] API Health Check: Service has failed 3 consecutive checks"},
+	}, slackPayloads())
+
+	// Medium alerts only go to the webhook, low ones aren't routed anywhere
+	monitor.createAlert("cache", "threshold", "medium", "Response time 3s exceeds threshold 1s")
+	alertDeliveries(t, monitor, "cache", 1)
+	monitor.createAlert("queue", "threshold", "low", "Queue depth is growing")
+
+	require.Len(t, webhookPayloads(), 2)
+	assert.Equal(t, "cache", webhookPayloads()[1]["probe_id"])
+	assert.Len(t, slackPayloads(), 1)
+	assert.Empty(t, alertDeliveries(t, monitor, "queue", 0))
+}
+
+func TestAlertNotificationRateLimit(t *testing.T) {
+	webhook, payloads := captureWebhook(t, 0)
+	monitor := notifyingMonitor(webhook.URL, webhook.URL)
+	monitor.notifications.routes = map[string][]string{"high": {"ops"}}
+
+	// A storm of alerts for one probe is notified once
+	for i := 0; i < 5; i++ {
+		monitor.createAlert("api", "availability", "high", "Service has failed consecutive checks")
+	}
+	deliveries := alertDeliveries(t, monitor, "api", 5)
+	statuses := make(map[interface{}]int)
+	for _, delivery := range deliveries {
+		statuses[delivery["status"]]++
+	}
+	assert.Equal(t, map[interface{}]int{DeliveryDelivered: 1, DeliverySuppressed: 4}, statuses)
+
+	// Other probes are limited separately
+	monitor.createAlert("cache", "availability", "high", "Service has failed consecutive checks")
+	alertDeliveries(t, monitor, "cache", 1)
+	require.Len(t, payloads(), 2)
+
+	// Resolving the storm is notified once as well
+	monitor.mutex.Lock()
+	for _, alert := range monitor.alerts {
+		alert.LastSeen = time.Now().Add(-time.Hour)
+	}
+	monitor.mutex.Unlock()
+	monitor.processAlerts()
+	alertDeliveries(t, monitor, "api", 10)
+	alertDeliveries(t, monitor, "cache", 2)
+
+	var resolved []string
+	for _, payload := range payloads() {
+		if payload["event"] == EventAlertResolved {
+			resolved = append(resolved, payload["probe_id"].(string))
+		}
+	}
+	assert.ElementsMatch(t, []string{"api", "cache"}, resolved)
+
+	// Once the window has passed the probe is notified again
+	monitor.notifications.rateLimit = 0
+	monitor.createAlert("api", "availability", "high", "Service has failed consecutive checks")
+	alertDeliveries(t, monitor, "api", 11)
+	assert.Len(t, payloads(), 5)
+}
+
+func TestAlertNotificationRetries(t *testing.T) {
+	flaky, payloads := captureWebhook(t, 2)
+	down, _ := captureWebhook(t, 100)
+	monitor := notifyingMonitor(flaky.URL, down.URL)
+
+	monitor.createAlert("api", "availability", "critical", "Service is down")
+	deliveries := alertDeliveries(t, monitor, "api", 2)
+
+	byChannel := make(map[interface{}]map[string]interface{})
+	for _, delivery := range deliveries {
+		byChannel[delivery["channel"]] = delivery
+	}
+	assert.Equal(t, DeliveryDelivered, byChannel["ops"]["status"])
+	assert.Equal(t, 3, byChannel["ops"]["attempts"])
+	assert.Len(t, payloads(), 1)
+
+	assert.Equal(t, DeliveryFailed, byChannel["chat"]["status"])
+	assert.Equal(t, 3, byChannel["chat"]["attempts"])
+	assert.Equal(t, "webhook returned 500 Internal Server Error", byChannel["chat"]["error"])
+}
+
+func TestNotificationsDisabled(t *testing.T) {
+	webhook, payloads := captureWebhook(t, 0)
+	monitor := notifyingMonitor(webhook.URL, webhook.URL)
+	monitor.notifications.enabled = false
+
+	monitor.createAlert("api", "availability", "critical", "Service is down")
+	assert.Empty(t, alertDeliveries(t, monitor, "api", 0))
+	assert.Empty(t, payloads())
+
+	// Without channels there is nothing to route to
+	monitor = New(&database.DB{}, &config.Config{})
+	assert.Nil(t, monitor.notifications)
+	monitor.createAlert("api", "availability", "critical", "Service is down")
+	assert.Empty(t, alertDeliveries(t, monitor, "api", 0))
+}
+
+func TestEmailNotifier(t *testing.T) {
+	notifier := NewEmailNotifier(config.SMTPConfig{
+		Host:     "smtp.example.com",
+		Username: "alerts",
+		Password: "secret",
+		From:     "probe@example.com",
+		To:       []string{"ops@example.com", "oncall@example.com"},
+	})
+
+	var addr string
+	var to []string
+	var msg string
+	notifier.sendMail = func(a string, auth smtp.Auth, from string, recipients []string, body []byte) error {
+		assert.NotNil(t, auth)
+		assert.Equal(t, "probe@example.com", from)
+		addr, to, msg = a, recipients, string(body)
+		return nil
+	}
+
+	require.NoError(t, notifier.Notify(context.Background(), Notification{
+		Event:     EventAlertResolved,
+		ProbeID:   "api",
+		Severity:  "high",
+		Message:   "Service has failed 3 consecutive checks",
+		Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}))
+	assert.Equal(t, "smtp.example.com:587", addr)
+	assert.Equal(t, []string{"ops@example.com", "oncall@example.com"}, to)
+	assert.Contains(t, msg, "To: ops@example.com, oncall@example.com\r\n")
+	assert.Contains(t, msg, "Subject: ✅ [HIGH] api resolved: Service has failed 3 consecutive checks\r\n")
+	assert.Contains(t, msg, "Time: 2024-05-01T12:00:00Z\r\n")
+	assert.Contains(t, msg, "\r\n\r\nService has failed 3 consecutive checks\r\n")
+}
+
+func TestTestNotificationEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	webhook, payloads := captureWebhook(t, 0)
+	down, _ := captureWebhook(t, 100)
+	monitor := notifyingMonitor(webhook.URL, down.URL)
+	// Test messages go out even while alert notifications are disabled
+	monitor.notifications.enabled = false
+
+	r := gin.New()
+	r.POST("/control/notifications/test", monitor.TestNotification)
+	post := func(body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/control/notifications/test", strings.NewReader(body)))
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+
+	code, response := post(`{"channel": "ops", "message": "Hello from staging"}`)
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, "ops", response["channel"])
+	require.Len(t, payloads(), 1)
+	assert.Equal(t, EventTest, payloads()[0]["event"])
+	assert.Equal(t, "Hello from staging", payloads()[0]["message"])
+
+	code, response = post(`{"channel": "chat"}`)
+	assert.Equal(t, http.StatusBadGateway, code)
+	assert.Equal(t, "Notification failed: webhook returned 500 Internal Server Error", response["error"])
+
+	code, response = post(`{"channel": "pager"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "Notification channel not found", response["error"])
+
+	code, _ = post(`{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestMaintenanceSuppressesAlerts(t *testing.T) {
+	webhook, payloads := captureWebhook(t, 0)
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "probe.db")}},
+		Probe: config.ProbeMonitorConfig{
+			EnableNotifications: true,
+			Notifications: config.ProbeNotificationsConfig{
+				Channels: []config.NotificationChannelConfig{{Name: "ops", Type: "webhook", URL: webhook.URL}},
+				Routes:   map[string][]string{"high": {"ops"}},
+			},
+		},
+	}
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.RegisteredServiceRepository().Create(&database.RegisteredService{
+		ID:           "portal-id",
+		Name:         "portal",
+		DisplayName:  "Portal",
+		ServiceURL:   "http://localhost",
+		Category:     "web",
+		RequiredRole: "user",
+		Status:       database.RegisteredServiceActive,
+	}))
+	window := &database.MaintenanceWindow{
+		ServiceID: "portal-id",
+		StartsAt:  time.Now().Add(-time.Minute),
+		EndsAt:    time.Now().Add(time.Hour),
+	}
+	require.NoError(t, db.MaintenanceWindowRepository().Create(window))
+
+	monitor := New(db, cfg)
+	monitor.probes["portal-health"] = &ProbeConfig{ID: "portal-health", Tags: []string{"web", "portal"}}
+	monitor.probes["portal-api"] = &ProbeConfig{ID: "portal-api", Tags: []string{"portal-id"}}
+	monitor.probes["wiki-health"] = &ProbeConfig{ID: "wiki-health", Tags: []string{"wiki"}}
+
+	// Probes tagged with the service by name or ID are suppressed
+	for _, probeID := range []string{"portal-health", "portal-api", "wiki-health"} {
+		monitor.createAlert(probeID, "availability", "high", "Service has failed consecutive checks")
+	}
+	alertDeliveries(t, monitor, "wiki-health", 1)
+	require.Len(t, payloads(), 1)
+	assert.Equal(t, "wiki-health", payloads()[0]["probe_id"])
+
+	monitor.mutex.RLock()
+	statuses := make(map[string]string)
+	for _, alert := range monitor.alerts {
+		statuses[alert.ProbeID] = alert.Status
+		if alert.Status == "suppressed" {
+			assert.Equal(t, window.ID, alert.Metadata["maintenance_window"])
+			assert.Equal(t, "portal-id", alert.Metadata["service_id"])
+			assert.NotNil(t, alert.ResolvedAt)
+		}
+	}
+	monitor.mutex.RUnlock()
+	assert.Equal(t, map[string]string{
+		"portal-health": "suppressed",
+		"portal-api":    "suppressed",
+		"wiki-health":   "active",
+	}, statuses)
+
+	// Once the window is over alerts are raised again
+	window.EndsAt = time.Now()
+	require.NoError(t, db.MaintenanceWindowRepository().Update(window))
+	monitor.createAlert("portal-health", "availability", "high", "Service has failed consecutive checks")
+	alertDeliveries(t, monitor, "portal-health", 1)
+	require.Len(t, payloads(), 2)
+	assert.Equal(t, "portal-health", payloads()[1]["probe_id"])
+}
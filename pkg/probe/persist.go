@@ -0,0 +1,374 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// Persistence tuning
+const (
+	persistQueueSize     = 1024
+	persistBatchSize     = 100
+	persistFlushInterval = time.Second
+)
+
+// Retention defaults used when the probe config leaves them unset
+const (
+	defaultResultRetention = 7 * 24 * time.Hour
+	defaultAlertRetention  = 30 * 24 * time.Hour
+)
+
+// In-memory retention, the database keeps anything older
+const (
+	memoryResultRetention = 24 * time.Hour
+	memoryAlertRetention  = 7 * 24 * time.Hour
+)
+
+// store writes probe results and alerts through to the database in batches
+type store struct {
+	results *database.ProbeResultRepository
+	alerts  *database.ProbeAlertRepository
+	queue   chan interface{} // *database.ProbeResult or *database.ProbeAlert
+	done    chan struct{}
+}
+
+// newStore returns nil when there is no database connection, which disables persistence
+func newStore(db *database.DB) *store {
+	if db == nil || db.DB == nil {
+		return nil
+	}
+
+	return &store{
+		results: db.ProbeResultRepository(),
+		alerts:  db.ProbeAlertRepository(),
+		queue:   make(chan interface{}, persistQueueSize),
+		done:    make(chan struct{}),
+	}
+}
+
+// saveResult queues a result for persistence without blocking the probe
+func (s *store) saveResult(result *ProbeResult) {
+	if s == nil {
+		return
+	}
+	s.enqueue(resultRecord(result), result.ID)
+}
+
+// saveAlert queues the current state of an alert for persistence.
+// Callers must hold the monitor mutex if the alert is shared.
+func (s *store) saveAlert(alert *Alert) {
+	if s == nil {
+		return
+	}
+	s.enqueue(alertRecord(alert), alert.ID)
+}
+
+func (s *store) enqueue(record interface{}, id string) {
+	select {
+	case s.queue <- record:
+	default:
+		log.Printf("⚠️ Probe persistence queue full, dropping %s", id)
+	}
+}
+
+// run flushes queued records until ctx is cancelled, then flushes whatever is left
+func (s *store) run(ctx context.Context) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(persistFlushInterval)
+	defer ticker.Stop()
+
+	var results []*database.ProbeResult
+	var alerts []*database.ProbeAlert
+	add := func(record interface{}) {
+		switch r := record.(type) {
+		case *database.ProbeResult:
+			results = append(results, r)
+		case *database.ProbeAlert:
+			alerts = append(alerts, r)
+		}
+	}
+	flush := func() {
+		if len(results) > 0 {
+			if err := s.results.InsertBatch(results); err != nil {
+				log.Printf("❌ Failed to persist %d probe results: %v", len(results), err)
+			}
+			results = nil
+		}
+		if len(alerts) > 0 {
+			if err := s.alerts.UpsertBatch(alerts); err != nil {
+				log.Printf("❌ Failed to persist %d probe alerts: %v", len(alerts), err)
+			}
+			alerts = nil
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case record := <-s.queue:
+					add(record)
+				default:
+					flush()
+					return
+				}
+			}
+		case record := <-s.queue:
+			add(record)
+			if len(results)+len(alerts) >= persistBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// recentResults loads persisted results for a probe, newest first
+func (s *store) recentResults(probeID string, since time.Time, limit int) []*ProbeResult {
+	if s == nil {
+		return nil
+	}
+
+	records, err := s.results.ListByProbe(probeID, since, limit)
+	if err != nil {
+		log.Printf("❌ Failed to load probe results: %v", err)
+		return nil
+	}
+
+	results := make([]*ProbeResult, 0, len(records))
+	for _, record := range records {
+		results = append(results, resultFromRecord(record))
+	}
+	return results
+}
+
+// resultsBetween loads persisted results for a probe recorded at or after
+// from and before to, oldest first
+func (s *store) resultsBetween(probeID string, from, to time.Time) []*ProbeResult {
+	if s == nil {
+		return nil
+	}
+
+	records, err := s.results.ListBetween(probeID, from, to)
+	if err != nil {
+		log.Printf("❌ Failed to load probe results: %v", err)
+		return nil
+	}
+
+	results := make([]*ProbeResult, 0, len(records))
+	for _, record := range records {
+		results = append(results, resultFromRecord(record))
+	}
+	return results
+}
+
+// activeAlerts loads persisted active alerts, most recently seen first
+func (s *store) activeAlerts(since time.Time, limit int) []*Alert {
+	if s == nil {
+		return nil
+	}
+
+	records, err := s.alerts.ListByStatus("active", since, limit)
+	if err != nil {
+		log.Printf("❌ Failed to load probe alerts: %v", err)
+		return nil
+	}
+
+	alerts := make([]*Alert, 0, len(records))
+	for _, record := range records {
+		alerts = append(alerts, alertFromRecord(record))
+	}
+	return alerts
+}
+
+// resolveStale resolves persisted alerts that are no longer held in memory
+func (s *store) resolveStale(cutoff, now time.Time) {
+	if s == nil {
+		return
+	}
+	if _, err := s.alerts.ResolveStale(cutoff, now); err != nil {
+		log.Printf("❌ Failed to resolve stale probe alerts: %v", err)
+	}
+}
+
+// prune deletes persisted results and resolved alerts past their retention
+func (s *store) prune(resultCutoff, alertCutoff time.Time) (int64, int64) {
+	if s == nil {
+		return 0, 0
+	}
+
+	results, err := s.results.DeleteOlderThan(resultCutoff)
+	if err != nil {
+		log.Printf("❌ Failed to prune probe results: %v", err)
+	}
+	alerts, err := s.alerts.DeleteResolvedBefore(alertCutoff)
+	if err != nil {
+		log.Printf("❌ Failed to prune probe alerts: %v", err)
+	}
+	return results, alerts
+}
+
+// retention returns the configured result and alert retention periods
+func (pm *ProbeMonitor) retention() (time.Duration, time.Duration) {
+	results, alerts := defaultResultRetention, defaultAlertRetention
+	if pm.config == nil {
+		return results, alerts
+	}
+	if d, err := config.ParseRetention(pm.config.Probe.ResultRetention); err == nil {
+		results = d
+	}
+	if d, err := config.ParseRetention(pm.config.Probe.AlertRetention); err == nil {
+		alerts = d
+	}
+	return results, alerts
+}
+
+// mergeResults combines in-memory and persisted results, dropping duplicates,
+// and returns the newest limit results oldest first
+func mergeResults(memory, stored []*ProbeResult, limit int) []*ProbeResult {
+	seen := make(map[string]bool, len(memory))
+	merged := make([]*ProbeResult, 0, len(memory)+len(stored))
+	for _, result := range memory {
+		seen[result.ID] = true
+		merged = append(merged, result)
+	}
+	for _, result := range stored {
+		if !seen[result.ID] {
+			merged = append(merged, result)
+		}
+	}
+
+	sortResults(merged)
+	if limit >= 0 && len(merged) > limit {
+		merged = merged[len(merged)-limit:]
+	}
+	return merged
+}
+
+// mergeAlerts combines in-memory and persisted alerts, preferring the in-memory
+// copy, and returns the limit most recently seen
+func mergeAlerts(memory, stored []*Alert, limit int) []*Alert {
+	seen := make(map[string]bool, len(memory))
+	merged := make([]*Alert, 0, len(memory)+len(stored))
+	for _, alert := range memory {
+		seen[alert.ID] = true
+		merged = append(merged, alert)
+	}
+	for _, alert := range stored {
+		if !seen[alert.ID] {
+			merged = append(merged, alert)
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].LastSeen.Equal(merged[j].LastSeen) {
+			return merged[i].ID > merged[j].ID
+		}
+		return merged[i].LastSeen.After(merged[j].LastSeen)
+	})
+	if limit >= 0 && len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged
+}
+
+func resultRecord(result *ProbeResult) *database.ProbeResult {
+	return &database.ProbeResult{
+		ID:           result.ID,
+		ProbeID:      result.ProbeID,
+		Status:       result.Status,
+		ResponseTime: int64(result.ResponseTime),
+		StatusCode:   result.StatusCode,
+		Message:      result.Message,
+		Error:        result.Error,
+		Metadata:     marshalMetadata(result.Metadata),
+		Timestamp:    result.Timestamp,
+	}
+}
+
+func resultFromRecord(record *database.ProbeResult) *ProbeResult {
+	return &ProbeResult{
+		ID:           record.ID,
+		ProbeID:      record.ProbeID,
+		Status:       record.Status,
+		ResponseTime: time.Duration(record.ResponseTime),
+		StatusCode:   record.StatusCode,
+		Message:      record.Message,
+		Error:        record.Error,
+		Metadata:     unmarshalMetadata(record.Metadata),
+		Timestamp:    record.Timestamp,
+	}
+}
+
+func alertRecord(alert *Alert) *database.ProbeAlert {
+	record := &database.ProbeAlert{
+		ID:        alert.ID,
+		ProbeID:   alert.ProbeID,
+		Type:      alert.Type,
+		Severity:  alert.Severity,
+		Status:    alert.Status,
+		Message:   alert.Message,
+		Count:     alert.Count,
+		FirstSeen: alert.FirstSeen,
+		LastSeen:  alert.LastSeen,
+		Metadata:  marshalMetadata(alert.Metadata),
+	}
+	if alert.ResolvedAt != nil {
+		resolvedAt := *alert.ResolvedAt
+		record.ResolvedAt = &resolvedAt
+	}
+	if alert.OrgID != "" {
+		orgID := alert.OrgID
+		record.OrgID = &orgID
+	}
+	return record
+}
+
+func alertFromRecord(record *database.ProbeAlert) *Alert {
+	alert := &Alert{
+		ID:         record.ID,
+		ProbeID:    record.ProbeID,
+		Type:       record.Type,
+		Severity:   record.Severity,
+		Status:     record.Status,
+		Message:    record.Message,
+		Count:      record.Count,
+		FirstSeen:  record.FirstSeen,
+		LastSeen:   record.LastSeen,
+		ResolvedAt: record.ResolvedAt,
+		Metadata:   unmarshalMetadata(record.Metadata),
+	}
+	if record.OrgID != nil {
+		alert.OrgID = *record.OrgID
+	}
+	return alert
+}
+
+func marshalMetadata(metadata map[string]interface{}) *string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil
+	}
+	encoded := string(data)
+	return &encoded
+}
+
+func unmarshalMetadata(encoded *string) map[string]interface{} {
+	metadata := make(map[string]interface{})
+	if encoded != nil {
+		_ = json.Unmarshal([]byte(*encoded), &metadata)
+	}
+	return metadata
+}
@@ -0,0 +1,156 @@
+package probe
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// startPersistentMonitor opens the database at cfg's path and starts a monitor
+// whose scheduler never fires, so only explicitly executed probes record results
+func startPersistentMonitor(t *testing.T, cfg *config.Config) (*ProbeMonitor, *database.DB) {
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+
+	monitor := New(db, cfg)
+	monitor.tick = time.Hour
+	require.NoError(t, monitor.Start())
+	return monitor, db
+}
+
+func probeAPI(monitor *ProbeMonitor) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/results/:probe_id", monitor.GetProbeResults)
+	r.GET("/results/:probe_id/latest", monitor.GetLatestResult)
+	r.GET("/results/:probe_id/history", monitor.GetProbeHistory)
+	r.GET("/alerts", monitor.GetActiveAlerts)
+	r.POST("/cleanup", monitor.CleanupOldResults)
+	return r
+}
+
+func getJSON(t *testing.T, r *gin.Engine, method, url string, target interface{}) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), target))
+}
+
+type resultsResponse struct {
+	Results []*ProbeResult `json:"results"`
+	History []*ProbeResult `json:"history"`
+	Total   int            `json:"total"`
+}
+
+func resultIDs(results []*ProbeResult) []string {
+	ids := make([]string, 0, len(results))
+	for _, result := range results {
+		ids = append(ids, result.ID)
+	}
+	return ids
+}
+
+func TestResultsSurviveRestart(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "probe.db"), Timeout: "30s"},
+		},
+		Probe: config.ProbeMonitorConfig{ResultRetention: "36h", AlertRetention: "7d"},
+	}
+	probe := &ProbeConfig{ID: "api", Type: "http", Target: server.URL, Timeout: time.Second, ExpectedStatus: 200}
+
+	// First run records results and an alert
+	monitor, db := startPersistentMonitor(t, cfg)
+	for i := 0; i < 3; i++ {
+		monitor.executeProbe(probe)
+	}
+	monitor.createAlert("api", "availability", "high", "api is down")
+	before := make([]*ProbeResult, 0, len(monitor.results))
+	for _, result := range monitor.results {
+		before = append(before, result)
+	}
+	sortResults(before)
+
+	// A result from two days ago, beyond the in-memory window
+	old := &ProbeResult{ID: newResultID("api", time.Now().Add(-48*time.Hour)), ProbeID: "api", Status: "success",
+		Timestamp: time.Now().Add(-48 * time.Hour), Metadata: map[string]interface{}{}}
+	require.NoError(t, db.ProbeResultRepository().InsertBatch([]*database.ProbeResult{resultRecord(old)}))
+
+	monitor.Stop()
+	require.NoError(t, db.Close())
+
+	// After a restart the API serves history from the database
+	monitor, db = startPersistentMonitor(t, cfg)
+	defer db.Close()
+	defer monitor.Stop()
+	r := probeAPI(monitor)
+
+	var results resultsResponse
+	getJSON(t, r, http.MethodGet, "/results/api", &results)
+	assert.Equal(t, append([]string{old.ID}, resultIDs(before)...), resultIDs(results.Results))
+	assert.Equal(t, float64(1), results.Results[1].Metadata["attempts"])
+	assert.Equal(t, 200, results.Results[1].StatusCode)
+
+	getJSON(t, r, http.MethodGet, "/results/api?limit=2", &results)
+	assert.Equal(t, resultIDs(before[1:]), resultIDs(results.Results))
+
+	getJSON(t, r, http.MethodGet, "/results/api/history?hours=24", &results)
+	assert.Equal(t, resultIDs(before), resultIDs(results.History))
+
+	var latest ProbeResult
+	getJSON(t, r, http.MethodGet, "/results/api/latest", &latest)
+	assert.Equal(t, before[2].ID, latest.ID)
+
+	var alerts struct {
+		Alerts []*Alert `json:"alerts"`
+		Total  int      `json:"total"`
+	}
+	getJSON(t, r, http.MethodGet, "/alerts", &alerts)
+	require.Equal(t, 1, alerts.Total)
+	assert.Equal(t, "api is down", alerts.Alerts[0].Message)
+
+	// New results are merged with the persisted ones without duplicates
+	monitor.executeProbe(probe)
+	getJSON(t, r, http.MethodGet, "/results/api/history?hours=24", &results)
+	assert.Len(t, results.History, 4)
+	assert.Equal(t, resultIDs(before), resultIDs(results.History[:3]))
+
+	// Cleanup prunes the database using the configured retention
+	var cleanup struct {
+		PrunedResults int `json:"pruned_results"`
+	}
+	getJSON(t, r, http.MethodPost, "/cleanup", &cleanup)
+	assert.Equal(t, 1, cleanup.PrunedResults)
+
+	getJSON(t, r, http.MethodGet, "/results/api", &results)
+	assert.Len(t, results.Results, 4)
+}
+
+func TestMergeResults(t *testing.T) {
+	now := time.Now()
+	result := func(id string, age time.Duration) *ProbeResult {
+		return &ProbeResult{ID: id, Timestamp: now.Add(-age)}
+	}
+
+	memory := []*ProbeResult{result("c", time.Minute), result("d", 0)}
+	stored := []*ProbeResult{result("d", 0), result("b", time.Hour), result("a", 2*time.Hour)}
+
+	assert.Equal(t, []string{"a", "b", "c", "d"}, resultIDs(mergeResults(memory, stored, 10)))
+	assert.Equal(t, []string{"c", "d"}, resultIDs(mergeResults(memory, stored, 2)))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, resultIDs(mergeResults(memory, stored, -1)))
+}
@@ -1,42 +1,70 @@
 package probe
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/last-emo-boy/infra-core/pkg/config"
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/events"
 )
 
 // ProbeMonitor manages health probes and monitoring
 type ProbeMonitor struct {
-	db      *database.DB
-	config  *config.Config
-	probes  map[string]*ProbeConfig
-	results map[string]*ProbeResult
-	alerts  map[string]*Alert
-	mutex   sync.RWMutex
-	ctx     context.Context
-	cancel  context.CancelFunc
-	running bool
+	db            *database.DB
+	config        *config.Config
+	probes        map[string]*ProbeConfig
+	results       map[string]*ProbeResult
+	alerts        map[string]*Alert
+	store         *store               // nil when results are kept in memory only
+	notifications *notificationRouter  // nil when no notification channels are configured
+	publisher     *statePublisher      // nil when no publisher targets are configured
+	eventBus      *events.Bus          // alerts created and resolved, streamed to the console
+	lastRun       map[string]time.Time // when each probe was last dispatched
+	tick          time.Duration        // how often the scheduler checks for due probes
+	backoff       time.Duration        // delay before the first retry, doubled for each further retry
+	mutex         sync.RWMutex
+	ctx           context.Context
+	cancel        context.CancelFunc
+	running       bool
 }
 
+// schedulerTick bounds how late a probe can run relative to its interval
+const schedulerTick = time.Second
+
+// retryBackoff is the delay before retrying a failed probe attempt
+const retryBackoff = 500 * time.Millisecond
+
+// defaultProbeInterval applies to probes configured without an interval
+const defaultProbeInterval = 60 * time.Second
+
 // ProbeConfig defines a monitoring probe configuration
 type ProbeConfig struct {
 	ID              string                 `json:"id"`
 	Name            string                 `json:"name"`
-	Type            string                 `json:"type"` // http, tcp, icmp, dns, custom
+	Type            string                 `json:"type"` // http, tcp, icmp, tls, script, dns, custom
 	Target          string                 `json:"target"`
+	ServiceRef      *ServiceRef            `json:"service_ref,omitempty"` // the target is resolved from the service when set
+	OrgID           string                 `json:"org_id,omitempty"`      // the organization owning the probe, empty for the monitor's own
 	Interval        time.Duration          `json:"interval"`
 	Timeout         time.Duration          `json:"timeout"`
 	Retries         int                    `json:"retries"`
 	Enabled         bool                   `json:"enabled"`
+	DisabledReason  string                 `json:"disabled_reason,omitempty"` // why the monitor disabled the probe
 	ExpectedStatus  int                    `json:"expected_status,omitempty"`
 	ExpectedContent string                 `json:"expected_content,omitempty"`
 	Headers         map[string]string      `json:"headers,omitempty"`
@@ -71,7 +99,7 @@ type ProbeResult struct {
 type Alert struct {
 	ID          string                 `json:"id"`
 	ProbeID     string                 `json:"probe_id"`
-	Type        string                 `json:"type"` // threshold, availability, performance
+	Type        string                 `json:"type"` // threshold, availability, performance, certificate
 	Severity    string                 `json:"severity"` // low, medium, high, critical
 	Status      string                 `json:"status"` // active, resolved, suppressed
 	Message     string                 `json:"message"`
@@ -80,6 +108,7 @@ type Alert struct {
 	LastSeen    time.Time              `json:"last_seen"`
 	ResolvedAt  *time.Time             `json:"resolved_at,omitempty"`
 	Metadata    map[string]interface{} `json:"metadata"`
+	OrgID       string                 `json:"org_id,omitempty"` // the organization owning the probe, empty for the monitor's own
 }
 
 // ProbeMetrics contains aggregated probe metrics
@@ -103,7 +132,8 @@ type ProbeMetrics struct {
 type CreateProbeRequest struct {
 	Name            string                 `json:"name" binding:"required"`
 	Type            string                 `json:"type" binding:"required"`
-	Target          string                 `json:"target" binding:"required"`
+	Target          string                 `json:"target"` // required unless service_ref is set
+	ServiceRef      *ServiceRef            `json:"service_ref"`
 	Interval        string                 `json:"interval"`
 	Timeout         string                 `json:"timeout"`
 	Retries         int                    `json:"retries"`
@@ -120,17 +150,29 @@ func New(db *database.DB, config *config.Config) *ProbeMonitor {
 	ctx, cancel := context.WithCancel(context.Background())
 	
 	return &ProbeMonitor{
-		db:      db,
-		config:  config,
-		probes:  make(map[string]*ProbeConfig),
-		results: make(map[string]*ProbeResult),
-		alerts:  make(map[string]*Alert),
-		ctx:     ctx,
-		cancel:  cancel,
-		running: false,
+		db:            db,
+		config:        config,
+		probes:        make(map[string]*ProbeConfig),
+		results:       make(map[string]*ProbeResult),
+		alerts:        make(map[string]*Alert),
+		store:         newStore(db),
+		notifications: newNotificationRouter(config.Probe),
+		publisher:     newStatePublisher(config.Probe.Publishers),
+		eventBus:      events.NewBus("probe", events.DefaultHistory),
+		lastRun:       make(map[string]time.Time),
+		tick:          schedulerTick,
+		backoff:       retryBackoff,
+		ctx:           ctx,
+		cancel:        cancel,
+		running:       false,
 	}
 }
 
+// EventBus returns the bus the monitor publishes alert events to
+func (pm *ProbeMonitor) EventBus() *events.Bus {
+	return pm.eventBus
+}
+
 // Start starts the probe monitor
 func (pm *ProbeMonitor) Start() error {
 	pm.mutex.Lock()
@@ -148,6 +190,10 @@ func (pm *ProbeMonitor) Start() error {
 	}
 
 	// Start background monitoring loops
+	if pm.store != nil {
+		go pm.store.run(pm.ctx)
+	}
+	pm.publisher.start(pm.ctx)
 	go pm.monitoringLoop()
 	go pm.alertingLoop()
 	go pm.cleanupLoop()
@@ -172,6 +218,11 @@ func (pm *ProbeMonitor) Stop() {
 	// Cancel context to stop background tasks
 	pm.cancel()
 
+	// Wait for queued results and alerts to be written
+	if pm.store != nil {
+		<-pm.store.done
+	}
+
 	pm.running = false
 	log.Println("✅ Probe monitor stopped")
 }
@@ -280,9 +331,9 @@ func (pm *ProbeMonitor) loadProbes() error {
 	return nil
 }
 
-// monitoringLoop performs periodic monitoring checks
+// monitoringLoop runs due probes on every scheduler tick
 func (pm *ProbeMonitor) monitoringLoop() {
-	ticker := time.NewTicker(10 * time.Second) // Check every 10 seconds
+	ticker := time.NewTicker(pm.tick)
 	defer ticker.Stop()
 
 	for {
@@ -295,7 +346,7 @@ func (pm *ProbeMonitor) monitoringLoop() {
 	}
 }
 
-// alertingLoop processes alerts and notifications
+// alertingLoop resolves stale alerts, notifying their channels
 func (pm *ProbeMonitor) alertingLoop() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -327,15 +378,20 @@ func (pm *ProbeMonitor) cleanupLoop() {
 
 // executeProbes runs all enabled probes that are due
 func (pm *ProbeMonitor) executeProbes() {
-	pm.mutex.RLock()
+	now := time.Now()
+
+	pm.mutex.Lock()
 	probesToRun := make([]*ProbeConfig, 0)
-	
+
 	for _, probe := range pm.probes {
-		if probe.Enabled && pm.shouldRunProbe(probe) {
-			probesToRun = append(probesToRun, probe)
+		if probe.Enabled && pm.shouldRunProbe(probe, now) {
+			pm.lastRun[probe.ID] = now
+			// Run against a copy so concurrent updates don't race with the check
+			snapshot := *probe
+			probesToRun = append(probesToRun, &snapshot)
 		}
 	}
-	pm.mutex.RUnlock()
+	pm.mutex.Unlock()
 
 	// Execute probes concurrently
 	for _, probe := range probesToRun {
@@ -343,46 +399,117 @@ func (pm *ProbeMonitor) executeProbes() {
 	}
 }
 
-// shouldRunProbe determines if a probe should be executed now
-func (pm *ProbeMonitor) shouldRunProbe(probe *ProbeConfig) bool {
-	// Simple time-based scheduling
-	// In a real implementation, this would track last execution times
-	return true
+// shouldRunProbe reports whether a probe's interval has elapsed since it last ran.
+// Probes that have never run are due immediately. Callers must hold the mutex.
+func (pm *ProbeMonitor) shouldRunProbe(probe *ProbeConfig, now time.Time) bool {
+	last, ok := pm.lastRun[probe.ID]
+	if !ok {
+		return true
+	}
+
+	interval := probe.Interval
+	if interval <= 0 {
+		interval = defaultProbeInterval
+	}
+
+	// Allow half a tick of slack so ticker jitter doesn't push a run to the next tick
+	return now.Sub(last) >= interval-pm.tick/2
 }
 
-// executeProbe executes a single probe
+// executeProbe executes a single probe, retrying failed attempts up to probe.Retries times
 func (pm *ProbeMonitor) executeProbe(probe *ProbeConfig) {
 	start := time.Now()
 	result := &ProbeResult{
-		ID:        fmt.Sprintf("%s-%d", probe.ID, start.Unix()),
+		ID:        newResultID(probe.ID, start),
 		ProbeID:   probe.ID,
 		Timestamp: start,
 		Metadata:  make(map[string]interface{}),
 	}
 
-	switch probe.Type {
-	case "http":
-		pm.executeHTTPProbe(probe, result)
-	case "tcp":
-		pm.executeTCPProbe(probe, result)
-	case "icmp":
-		pm.executeICMPProbe(probe, result)
-	default:
+	// Probes bound to a service run against its current address
+	target, err := pm.resolveTarget(probe)
+	if errors.Is(err, errServiceGone) {
+		pm.unbindProbe(probe.ID, err.Error())
+		return
+	}
+	bound := *probe
+	bound.Target = target
+	probe = &bound
+
+	attempts := 0
+	if err != nil {
 		result.Status = "error"
-		result.Error = fmt.Sprintf("unsupported probe type: %s", probe.Type)
+		result.Error = fmt.Sprintf("failed to resolve target: %v", err)
+	} else {
+		attempts = 1
+		pm.executeAttempt(probe, result)
+	}
+
+	// Only failures are transient, errors come from the probe configuration
+	for backoff := pm.backoff; result.Status == "failure" && attempts <= probe.Retries; backoff *= 2 {
+		if !pm.waitRetry(backoff) {
+			break
+		}
+		attempts++
+		pm.executeAttempt(probe, result)
 	}
 
-	result.ResponseTime = time.Since(start)
+	result.Metadata["attempts"] = attempts
+	if probe.ServiceRef != nil && err == nil {
+		result.Metadata["resolved_target"] = target
+	}
+	// Script probes report the time their steps took, ICMP probes the
+	// average round trip time
+	if probe.Type != "script" && probe.Type != "icmp" {
+		result.ResponseTime = time.Since(start)
+	}
 
 	// Store result
 	pm.mutex.Lock()
 	pm.results[result.ID] = result
 	pm.mutex.Unlock()
+	pm.store.saveResult(result)
+	pm.publishState(probe, result)
 
 	// Check for alerts
 	pm.checkThresholds(probe, result)
 }
 
+// waitRetry waits before the next attempt, returning false if the monitor is stopped
+func (pm *ProbeMonitor) waitRetry(backoff time.Duration) bool {
+	select {
+	case <-pm.ctx.Done():
+		return false
+	case <-time.After(backoff):
+		return true
+	}
+}
+
+// executeAttempt runs one attempt of a probe, replacing the outcome of any previous attempt
+func (pm *ProbeMonitor) executeAttempt(probe *ProbeConfig, result *ProbeResult) {
+	result.Status = ""
+	result.StatusCode = 0
+	result.Message = ""
+	result.Error = ""
+	result.Metadata = make(map[string]interface{})
+
+	switch probe.Type {
+	case "http":
+		pm.executeHTTPProbe(probe, result)
+	case "tcp":
+		pm.executeTCPProbe(probe, result)
+	case "icmp":
+		pm.executeICMPProbe(probe, result)
+	case "tls":
+		pm.executeTLSProbe(probe, result)
+	case "script":
+		pm.executeScriptProbe(probe, result)
+	default:
+		result.Status = "error"
+		result.Error = fmt.Sprintf("unsupported probe type: %s", probe.Type)
+	}
+}
+
 // executeHTTPProbe executes an HTTP health check
 func (pm *ProbeMonitor) executeHTTPProbe(probe *ProbeConfig, result *ProbeResult) {
 	client := &http.Client{
@@ -424,10 +551,106 @@ func (pm *ProbeMonitor) executeHTTPProbe(probe *ProbeConfig, result *ProbeResult
 		return
 	}
 
+	// Check response body
+	if probe.ExpectedContent != "" && !checkContent(probe, resp.Body, result) {
+		return
+	}
+
 	result.Status = "success"
 	result.Message = "HTTP check passed"
 }
 
+// Content matching modes set through the probe's content_match config key
+const (
+	ContentMatchSubstring = "substring"
+	ContentMatchRegex     = "regex"
+)
+
+// maxProbeBodySize caps how much of a response body is searched for expected content
+const maxProbeBodySize = 1 << 20
+
+// maxContentSnippet caps the body excerpt recorded in result metadata
+const maxContentSnippet = 256
+
+// contentMatchMode returns the content_match mode configured for a probe
+func contentMatchMode(cfg map[string]interface{}) string {
+	if mode, ok := cfg["content_match"].(string); ok && mode != "" {
+		return mode
+	}
+	return ContentMatchSubstring
+}
+
+// matchContent returns the range of body matching expected, or nil when it doesn't match
+func matchContent(body []byte, expected, mode string) ([]int, error) {
+	switch mode {
+	case ContentMatchSubstring:
+		if i := bytes.Index(body, []byte(expected)); i >= 0 {
+			return []int{i, i + len(expected)}, nil
+		}
+		return nil, nil
+	case ContentMatchRegex:
+		re, err := regexp.Compile(expected)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expected content pattern: %w", err)
+		}
+		return re.FindIndex(body), nil
+	default:
+		return nil, fmt.Errorf("unsupported content_match: %s", mode)
+	}
+}
+
+// checkContent reads up to maxProbeBodySize bytes of body and checks it for the
+// probe's expected content, recording the outcome in result. It returns true on a match.
+func checkContent(probe *ProbeConfig, body io.Reader, result *ProbeResult) bool {
+	data, err := io.ReadAll(io.LimitReader(body, maxProbeBodySize+1))
+	if err != nil {
+		result.Status = "failure"
+		result.Error = fmt.Sprintf("failed to read response body: %v", err)
+		return false
+	}
+
+	truncated := len(data) > maxProbeBodySize
+	if truncated {
+		data = data[:maxProbeBodySize]
+		result.Metadata["body_truncated"] = true
+	}
+
+	mode := contentMatchMode(probe.Config)
+	result.Metadata["content_match"] = mode
+
+	match, err := matchContent(data, probe.ExpectedContent, mode)
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+		return false
+	}
+
+	if match == nil {
+		result.Metadata["body_snippet"] = contentSnippet(data)
+		result.Status = "failure"
+		if mode == ContentMatchRegex {
+			result.Message = fmt.Sprintf("response body does not match pattern %q", probe.ExpectedContent)
+		} else {
+			result.Message = fmt.Sprintf("expected content %q not found in response body", probe.ExpectedContent)
+		}
+		if truncated {
+			result.Message += fmt.Sprintf(" (only the first %d bytes were checked)", maxProbeBodySize)
+		}
+		return false
+	}
+
+	result.Metadata["matched_content"] = contentSnippet(data[match[0]:match[1]])
+	return true
+}
+
+// contentSnippet returns a printable excerpt of at most maxContentSnippet bytes
+func contentSnippet(data []byte) string {
+	if len(data) <= maxContentSnippet {
+		return strings.ToValidUTF8(string(data), "")
+	}
+	return strings.ToValidUTF8(string(data[:maxContentSnippet]), "") + "..."
+}
+
 // executeTCPProbe executes a TCP connectivity check
 func (pm *ProbeMonitor) executeTCPProbe(probe *ProbeConfig, result *ProbeResult) {
 	conn, err := net.DialTimeout("tcp", probe.Target, probe.Timeout)
@@ -442,24 +665,114 @@ func (pm *ProbeMonitor) executeTCPProbe(probe *ProbeConfig, result *ProbeResult)
 	result.Message = "TCP connection successful"
 }
 
-// executeICMPProbe executes an ICMP ping check
-func (pm *ProbeMonitor) executeICMPProbe(probe *ProbeConfig, result *ProbeResult) {
-	// ICMP requires raw sockets, which need admin privileges
-	// For now, implement as a simplified TCP check to port 80
-	conn, err := net.DialTimeout("tcp", probe.Target+":80", probe.Timeout)
+// executeTLSProbe completes a TLS handshake with the target and records the
+// certificate's expiry, issuer and subject alternative names. The server name
+// sent for SNI and checked against the certificate defaults to the target host
+// and can be overridden with the server_name config key when connecting by address.
+func (pm *ProbeMonitor) executeTLSProbe(probe *ProbeConfig, result *ProbeResult) {
+	addr, serverName := tlsTarget(probe)
+	result.Metadata["server_name"] = serverName
+
+	dialer := &net.Dialer{Timeout: probe.Timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		ServerName: serverName,
+		// Expired and self-signed certificates still need to be inspected
+		InsecureSkipVerify: true,
+	})
 	if err != nil {
 		result.Status = "failure"
-		result.Error = fmt.Sprintf("ICMP check failed: %v", err)
+		result.Error = fmt.Sprintf("TLS handshake failed: %v", err)
 		return
 	}
 	defer conn.Close()
 
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		result.Status = "failure"
+		result.Error = "server presented no certificate"
+		return
+	}
+	leaf := certs[0]
+
+	remaining := time.Until(leaf.NotAfter)
+	sans := append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	result.Metadata["days_remaining"] = int(math.Floor(remaining.Hours() / 24))
+	result.Metadata["not_after"] = leaf.NotAfter.UTC().Format(time.RFC3339)
+	result.Metadata["issuer"] = leaf.Issuer.String()
+	result.Metadata["subject"] = leaf.Subject.String()
+	result.Metadata["sans"] = sans
+
+	if err := leaf.VerifyHostname(serverName); err != nil {
+		result.Status = "failure"
+		result.Error = fmt.Sprintf("certificate is not valid for %s: %v", serverName, err)
+		return
+	}
+	if remaining <= 0 {
+		result.Status = "failure"
+		result.Message = fmt.Sprintf("certificate expired on %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+		return
+	}
+
 	result.Status = "success"
-	result.Message = "ICMP check passed"
+	result.Message = fmt.Sprintf("certificate valid for %d more days", int(remaining.Hours()/24))
+}
+
+// tlsTarget returns the address to dial, defaulting to port 443, and the server name for SNI
+func tlsTarget(probe *ProbeConfig) (string, string) {
+	addr := probe.Target
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = strings.Trim(addr, "[]")
+		addr = net.JoinHostPort(host, "443")
+	}
+
+	if name, ok := probe.Config["server_name"].(string); ok && name != "" {
+		return addr, name
+	}
+	return addr, host
+}
+
+// Certificate expiry thresholds in days
+const (
+	defaultMinDaysRemaining = 14
+	criticalDaysRemaining   = 3
+)
+
+// checkCertificateExpiry alerts when a TLS probe's certificate expires within
+// the min_days_remaining config value, escalating to critical close to expiry
+func (pm *ProbeMonitor) checkCertificateExpiry(probe *ProbeConfig, result *ProbeResult) {
+	days, ok := result.Metadata["days_remaining"].(int)
+	if !ok {
+		return
+	}
+
+	minDays := defaultMinDaysRemaining
+	switch v := probe.Config["min_days_remaining"].(type) {
+	case float64:
+		minDays = int(v)
+	case int:
+		minDays = v
+	}
+
+	switch {
+	case days <= criticalDaysRemaining:
+		pm.createAlert(probe.ID, "certificate", "critical",
+			fmt.Sprintf("Certificate for %s expires in %d days", result.Metadata["server_name"], days))
+	case days <= minDays:
+		pm.createAlert(probe.ID, "certificate", "medium",
+			fmt.Sprintf("Certificate for %s expires in %d days", result.Metadata["server_name"], days))
+	}
 }
 
 // checkThresholds evaluates probe results against thresholds
 func (pm *ProbeMonitor) checkThresholds(probe *ProbeConfig, result *ProbeResult) {
+	if probe.Type == "tls" {
+		pm.checkCertificateExpiry(probe, result)
+	}
+
 	if probe.Thresholds == nil {
 		return
 	}
@@ -486,39 +799,47 @@ func (pm *ProbeMonitor) checkThresholds(probe *ProbeConfig, result *ProbeResult)
 
 // processAlerts processes and manages alerts
 func (pm *ProbeMonitor) processAlerts() {
-	pm.mutex.Lock()
-	defer pm.mutex.Unlock()
-
 	now := time.Now()
+	cutoff := now.Add(-10 * time.Minute)
+
+	pm.mutex.Lock()
 	for _, alert := range pm.alerts {
 		// Auto-resolve old alerts
-		if alert.Status == "active" && now.Sub(alert.LastSeen) > 10*time.Minute {
+		if alert.Status == "active" && alert.LastSeen.Before(cutoff) {
 			alert.Status = "resolved"
 			resolvedAt := now
 			alert.ResolvedAt = &resolvedAt
+			pm.store.saveAlert(alert)
+			pm.notify(alert, EventAlertResolved)
 			log.Printf("🔍 Auto-resolved alert: %s", alert.Message)
 		}
 	}
+	pm.mutex.Unlock()
+
+	// Alerts raised before a restart are only in the database
+	pm.store.resolveStale(cutoff, now)
 }
 
-// performCleanup cleans up old results and resolved alerts
-func (pm *ProbeMonitor) performCleanup() {
+// performCleanup cleans up old results and resolved alerts, in memory and in
+// the database, and returns how many database rows were pruned
+func (pm *ProbeMonitor) performCleanup() (int64, int64) {
+	now := time.Now()
+	resultRetention, alertRetention := pm.retention()
+
 	pm.mutex.Lock()
-	defer pm.mutex.Unlock()
 
-	cutoff := time.Now().Add(-24 * time.Hour)
-	
-	// Clean up old results
+	// Clean up old results, memory only holds the most recent day
+	cutoff := now.Add(-min(resultRetention, memoryResultRetention))
 	for resultID, result := range pm.results {
 		if result.Timestamp.Before(cutoff) {
 			delete(pm.results, resultID)
 		}
 	}
 
-	// Clean up resolved alerts older than 7 days
-	alertCutoff := time.Now().Add(-7 * 24 * time.Hour)
+	// Clean up resolved and suppressed alerts, memory only holds the most recent week
+	alertCutoff := now.Add(-min(alertRetention, memoryAlertRetention))
 	for alertID, alert := range pm.alerts {
-		if alert.Status == "resolved" && alert.ResolvedAt != nil && 
+		if alert.Status != "active" && alert.ResolvedAt != nil && 
 		   alert.ResolvedAt.Before(alertCutoff) {
 			delete(pm.alerts, alertID)
 		}
@@ -526,6 +847,9 @@ func (pm *ProbeMonitor) performCleanup() {
 
 	log.Printf("🧹 Cleanup completed: %d results, %d alerts", 
 		len(pm.results), len(pm.alerts))
+	pm.mutex.Unlock()
+
+	return pm.store.prune(now.Add(-resultRetention), now.Add(-alertRetention))
 }
 
 // Helper methods for failure tracking
@@ -542,9 +866,10 @@ func (pm *ProbeMonitor) resetFailureCount(probeID string) {
 	// Implementation would reset failure count
 }
 
-// createAlert creates a new alert
+// createAlert creates a new alert. Alerts of probes tagged with a registered
+// service under maintenance are recorded as suppressed and not notified.
 func (pm *ProbeMonitor) createAlert(probeID, alertType, severity, message string) {
-	alertID := fmt.Sprintf("%s-%s-%d", probeID, alertType, time.Now().Unix())
+	alertID := newResultID(probeID+"-"+alertType, time.Now())
 	
 	alert := &Alert{
 		ID:        alertID,
@@ -559,9 +884,84 @@ func (pm *ProbeMonitor) createAlert(probeID, alertType, severity, message string
 		Metadata:  make(map[string]interface{}),
 	}
 
+	if window := pm.maintenanceWindow(probeID, alert.FirstSeen); window != nil {
+		alert.Status = "suppressed"
+		alert.ResolvedAt = &alert.FirstSeen
+		alert.Metadata["maintenance_window"] = window.ID
+		alert.Metadata["service_id"] = window.ServiceID
+	}
+
 	pm.mutex.Lock()
+	if probe, ok := pm.probes[probeID]; ok {
+		alert.OrgID = probe.OrgID
+	}
 	pm.alerts[alertID] = alert
+	pm.store.saveAlert(alert)
+	if alert.Status == "active" {
+		pm.notify(alert, EventAlertCreated)
+	}
 	pm.mutex.Unlock()
 
+	if alert.Status == "suppressed" {
+		log.Printf("🔧 Alert suppressed during maintenance: %s - %s", severity, message)
+		return
+	}
 	log.Printf("🚨 Alert created: %s - %s", severity, message)
-}
\ No newline at end of file
+}
+
+// maintenanceWindow returns the active maintenance window of a registered
+// service the probe is tagged with, by service ID or name, or nil
+func (pm *ProbeMonitor) maintenanceWindow(probeID string, now time.Time) *database.MaintenanceWindow {
+	if pm.db == nil || pm.db.DB == nil {
+		return nil
+	}
+
+	pm.mutex.RLock()
+	var tags []string
+	if probe, ok := pm.probes[probeID]; ok {
+		tags = append(tags, probe.Tags...)
+	}
+	pm.mutex.RUnlock()
+	if len(tags) == 0 {
+		return nil
+	}
+
+	windows, err := pm.db.MaintenanceWindowRepository().ListActive(now)
+	if err != nil {
+		log.Printf("⚠️ Failed to load maintenance windows: %v", err)
+		return nil
+	}
+
+	services := pm.db.RegisteredServiceRepository()
+	for serviceID, window := range windows {
+		service, err := services.GetByID(serviceID)
+		if err != nil {
+			continue
+		}
+		for _, tag := range tags {
+			if tag == service.ID || tag == service.Name {
+				return window
+			}
+		}
+	}
+	return nil
+}
+
+// idSequence disambiguates IDs generated within the same nanosecond
+var idSequence uint64
+
+// newResultID returns a collision-free ID of the form <prefix>-<unix nanos>-<sequence>
+func newResultID(prefix string, t time.Time) string {
+	seq := atomic.AddUint64(&idSequence, 1)
+	return fmt.Sprintf("%s-%019d-%d", prefix, t.UnixNano(), seq)
+}
+
+// sortResults orders results by timestamp, oldest first, falling back to ID for identical timestamps
+func sortResults(results []*ProbeResult) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Timestamp.Equal(results[j].Timestamp) {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].Timestamp.Before(results[j].Timestamp)
+	})
+}
@@ -1,11 +1,22 @@
 package probe
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"math"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -296,16 +307,103 @@ func TestShouldRunProbe(t *testing.T) {
 	mockDB := &database.DB{}
 	mockConfig := &config.Config{}
 	monitor := New(mockDB, mockConfig)
-	
+
 	probe := &ProbeConfig{
 		ID:       "test-probe",
 		Enabled:  true,
 		Interval: 30 * time.Second,
 	}
-	
-	// Currently always returns true (simplified implementation)
-	shouldRun := monitor.shouldRunProbe(probe)
-	assert.True(t, shouldRun)
+	now := time.Now()
+
+	// Never run before, due immediately
+	assert.True(t, monitor.shouldRunProbe(probe, now))
+
+	monitor.lastRun[probe.ID] = now
+	assert.False(t, monitor.shouldRunProbe(probe, now.Add(10*time.Second)))
+	assert.True(t, monitor.shouldRunProbe(probe, now.Add(30*time.Second)))
+
+	// Ticker jitter within half a tick still counts as due
+	assert.True(t, monitor.shouldRunProbe(probe, now.Add(30*time.Second-time.Millisecond)))
+
+	// Unset intervals fall back to the default
+	probe.Interval = 0
+	assert.False(t, monitor.shouldRunProbe(probe, now.Add(30*time.Second)))
+	assert.True(t, monitor.shouldRunProbe(probe, now.Add(defaultProbeInterval)))
+}
+
+// countingServer counts requests per path
+func countingServer(t *testing.T) (*httptest.Server, func(path string) int) {
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		counts[r.URL.Path]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return counts[path]
+	}
+}
+
+func TestProbeScheduling(t *testing.T) {
+	server, count := countingServer(t)
+
+	monitor := New(&database.DB{}, &config.Config{})
+	monitor.tick = 10 * time.Millisecond
+	for id, interval := range map[string]time.Duration{"fast": 50 * time.Millisecond, "slow": 200 * time.Millisecond} {
+		monitor.probes[id] = &ProbeConfig{
+			ID:       id,
+			Type:     "http",
+			Target:   server.URL + "/" + id,
+			Interval: interval,
+			Timeout:  time.Second,
+			Enabled:  true,
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/probes/:id", monitor.UpdateProbe)
+	r.POST("/probes/:id/disable", monitor.DisableProbe)
+
+	go monitor.monitoringLoop()
+	defer monitor.cancel()
+
+	// Runs at 0, 50, ... 500ms and at 0, 200, 400ms
+	time.Sleep(520 * time.Millisecond)
+	fast, slow := count("/fast"), count("/slow")
+	assert.InDelta(t, 11, fast, 3, "fast probe runs")
+	assert.InDelta(t, 3, slow, 1, "slow probe runs")
+
+	t.Run("interval update takes effect", func(t *testing.T) {
+		body := `{"name":"slow","type":"http","target":"` + server.URL + `/slow","interval":"50ms"}`
+		req := httptest.NewRequest(http.MethodPut, "/probes/slow", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		before := count("/slow")
+		time.Sleep(520 * time.Millisecond)
+		assert.InDelta(t, 10, count("/slow")-before, 3)
+	})
+
+	t.Run("disabled probe stops running", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/probes/fast/disable", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		// Allow an already dispatched run to land
+		time.Sleep(20 * time.Millisecond)
+		before := count("/fast")
+		time.Sleep(200 * time.Millisecond)
+		assert.Equal(t, before, count("/fast"))
+	})
 }
 
 func TestExecuteHTTPProbe(t *testing.T) {
@@ -409,6 +507,119 @@ func TestExecuteHTTPProbeWrongStatus(t *testing.T) {
 	assert.Contains(t, result.Message, "got 500, expected 200")
 }
 
+func TestExecuteHTTPProbeContent(t *testing.T) {
+	large := strings.Repeat("a", maxProbeBodySize) + "marker"
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			_, _ = w.Write([]byte(large))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"ok","version":"1.4.2"}`))
+	}))
+	defer testServer.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+		mode     string
+		status   string
+		metadata map[string]interface{}
+	}{
+		{
+			name:     "substring match",
+			expected: `"status":"ok"`,
+			status:   "success",
+			metadata: map[string]interface{}{"content_match": "substring", "matched_content": `"status":"ok"`},
+		},
+		{
+			name:     "regex match",
+			expected: `"version":"1\.\d+\.\d+"`,
+			mode:     "regex",
+			status:   "success",
+			metadata: map[string]interface{}{"content_match": "regex", "matched_content": `"version":"1.4.2"`},
+		},
+		{
+			name:     "missing content",
+			expected: `"status":"degraded"`,
+			status:   "failure",
+			metadata: map[string]interface{}{"body_snippet": `{"status":"ok","version":"1.4.2"}`},
+		},
+		{
+			name:     "regex mismatch",
+			expected: `"version":"2\.`,
+			mode:     "regex",
+			status:   "failure",
+		},
+		{
+			name:     "invalid regex",
+			expected: `(`,
+			mode:     "regex",
+			status:   "error",
+		},
+		{
+			name:     "oversized body within cap",
+			path:     "/large",
+			expected: "aaaa",
+			status:   "success",
+			metadata: map[string]interface{}{"body_truncated": true, "matched_content": "aaaa"},
+		},
+		{
+			name:     "oversized body beyond cap",
+			path:     "/large",
+			expected: "marker",
+			status:   "failure",
+			metadata: map[string]interface{}{"body_truncated": true, "body_snippet": strings.Repeat("a", maxContentSnippet) + "..."},
+		},
+	}
+
+	monitor := New(&database.DB{}, &config.Config{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probe := &ProbeConfig{
+				ID:              "content-probe",
+				Type:            "http",
+				Target:          testServer.URL + tt.path,
+				Timeout:         5 * time.Second,
+				ExpectedStatus:  200,
+				ExpectedContent: tt.expected,
+				Config:          map[string]interface{}{},
+			}
+			if tt.mode != "" {
+				probe.Config["content_match"] = tt.mode
+			}
+
+			result := &ProbeResult{Metadata: make(map[string]interface{})}
+			monitor.executeHTTPProbe(probe, result)
+
+			assert.Equal(t, tt.status, result.Status, result.Message+result.Error)
+			for key, value := range tt.metadata {
+				assert.Equal(t, value, result.Metadata[key], key)
+			}
+			if tt.status == "failure" {
+				assert.Contains(t, result.Message, strconv.Quote(tt.expected))
+			}
+		})
+	}
+}
+
+func TestCreateProbeRejectsInvalidPattern(t *testing.T) {
+	monitor := New(&database.DB{}, &config.Config{})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/probes", monitor.CreateProbe)
+
+	body := `{"name":"api","type":"http","target":"http://localhost","expected_content":"(","config":{"content_match":"regex"}}`
+	req := httptest.NewRequest(http.MethodPost, "/probes", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid expected content pattern")
+	assert.Empty(t, monitor.probes)
+}
+
 func TestExecuteTCPProbe(t *testing.T) {
 	// Start a simple TCP server
 	listener, err := net.Listen("tcp", "localhost:0")
@@ -477,6 +688,134 @@ func TestExecuteTCPProbeFailure(t *testing.T) {
 	assert.Contains(t, result.Error, "TCP connection failed")
 }
 
+// newTLSServer starts a TLS server with a self-signed certificate for dnsName
+// expiring after validFor, and reports the SNI server name of each handshake
+func newTLSServer(t *testing.T, dnsName string, validFor time.Duration) (*httptest.Server, <-chan string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsName},
+		Issuer:       pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	serverNames := make(chan string, 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server, serverNames
+}
+
+func TestExecuteTLSProbe(t *testing.T) {
+	const domain = "probe.example.test"
+
+	tests := []struct {
+		name       string
+		validFor   time.Duration
+		serverName string
+		minDays    interface{}
+		status     string
+		severity   string
+	}{
+		{name: "valid certificate", validFor: 60 * 24 * time.Hour, serverName: domain, status: "success"},
+		{name: "expiring soon", validFor: 10 * 24 * time.Hour, serverName: domain, status: "success", severity: "medium"},
+		{name: "custom threshold", validFor: 10 * 24 * time.Hour, serverName: domain, minDays: float64(7), status: "success"},
+		{name: "about to expire", validFor: 2 * 24 * time.Hour, serverName: domain, status: "success", severity: "critical"},
+		{name: "expired", validFor: -time.Hour, serverName: domain, status: "failure", severity: "critical"},
+		{name: "hostname mismatch", validFor: 60 * 24 * time.Hour, serverName: "other.example.test", status: "failure"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, serverNames := newTLSServer(t, domain, tt.validFor)
+
+			probe := &ProbeConfig{
+				ID:      "tls-probe",
+				Type:    "tls",
+				Target:  server.Listener.Addr().String(),
+				Timeout: 5 * time.Second,
+				Config:  map[string]interface{}{"server_name": tt.serverName},
+			}
+			if tt.minDays != nil {
+				probe.Config["min_days_remaining"] = tt.minDays
+			}
+
+			monitor := New(&database.DB{}, &config.Config{})
+			monitor.executeProbe(probe)
+
+			require.Len(t, monitor.results, 1)
+			for _, result := range monitor.results {
+				assert.Equal(t, tt.status, result.Status, result.Message+result.Error)
+				assert.Equal(t, []string{domain}, result.Metadata["sans"])
+				assert.Equal(t, "CN="+domain, result.Metadata["issuer"])
+				// A moment has passed since the certificate was issued
+				assert.Equal(t, int(math.Floor((tt.validFor-time.Second).Hours()/24)), result.Metadata["days_remaining"])
+			}
+
+			// SNI carries the configured name, not the dialed address
+			assert.Equal(t, tt.serverName, <-serverNames)
+
+			if tt.severity == "" {
+				assert.Empty(t, monitor.alerts)
+				return
+			}
+			require.Len(t, monitor.alerts, 1)
+			for _, alert := range monitor.alerts {
+				assert.Equal(t, "certificate", alert.Type)
+				assert.Equal(t, tt.severity, alert.Severity)
+			}
+		})
+	}
+
+	t.Run("handshake failure", func(t *testing.T) {
+		plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer plain.Close()
+
+		monitor := New(&database.DB{}, &config.Config{})
+		result := &ProbeResult{Metadata: make(map[string]interface{})}
+		monitor.executeTLSProbe(&ProbeConfig{Type: "tls", Target: plain.Listener.Addr().String(), Timeout: time.Second}, result)
+
+		assert.Equal(t, "failure", result.Status)
+		assert.Contains(t, result.Error, "TLS handshake failed")
+	})
+}
+
+func TestTLSTarget(t *testing.T) {
+	tests := []struct {
+		target     string
+		config     map[string]interface{}
+		addr       string
+		serverName string
+	}{
+		{target: "example.com", addr: "example.com:443", serverName: "example.com"},
+		{target: "example.com:8443", addr: "example.com:8443", serverName: "example.com"},
+		{target: "10.0.0.5:443", config: map[string]interface{}{"server_name": "example.com"}, addr: "10.0.0.5:443", serverName: "example.com"},
+		{target: "[::1]", addr: "[::1]:443", serverName: "::1"},
+	}
+
+	for _, tt := range tests {
+		addr, serverName := tlsTarget(&ProbeConfig{Target: tt.target, Config: tt.config})
+		assert.Equal(t, tt.addr, addr, tt.target)
+		assert.Equal(t, tt.serverName, serverName, tt.target)
+	}
+}
+
 func TestCreateAlert(t *testing.T) {
 	mockDB := &database.DB{}
 	mockConfig := &config.Config{}
@@ -533,6 +872,104 @@ func TestCheckThresholds(t *testing.T) {
 	assert.Equal(t, 1, len(monitor.alerts))
 }
 
+func TestExecuteProbeRetries(t *testing.T) {
+	// flakyServer fails the first failures requests, then succeeds
+	flakyServer := func(failures int) (*httptest.Server, *int32) {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if int(atomic.AddInt32(&requests, 1)) <= failures {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(server.Close)
+		return server, &requests
+	}
+
+	newProbe := func(target string, retries int) *ProbeConfig {
+		return &ProbeConfig{
+			ID:             "retry-probe",
+			Type:           "http",
+			Target:         target,
+			Timeout:        time.Second,
+			Retries:        retries,
+			ExpectedStatus: 200,
+			Thresholds: &ProbeThresholds{
+				ResponseTime:    time.Minute,
+				ConsecutiveFail: 1,
+			},
+		}
+	}
+
+	onlyResult := func(t *testing.T, monitor *ProbeMonitor) *ProbeResult {
+		require.Len(t, monitor.results, 1)
+		for _, result := range monitor.results {
+			return result
+		}
+		return nil
+	}
+
+	t.Run("succeeds after transient failures", func(t *testing.T) {
+		server, requests := flakyServer(2)
+		monitor := New(&database.DB{}, &config.Config{})
+		monitor.backoff = 20 * time.Millisecond
+
+		monitor.executeProbe(newProbe(server.URL, 3))
+
+		result := onlyResult(t, monitor)
+		assert.Equal(t, "success", result.Status)
+		assert.Empty(t, result.Error)
+		assert.Equal(t, 200, result.StatusCode)
+		assert.Equal(t, 3, result.Metadata["attempts"])
+		assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+		// Elapsed time covers both backoffs (20ms + 40ms)
+		assert.GreaterOrEqual(t, result.ResponseTime, 60*time.Millisecond)
+		assert.Empty(t, monitor.alerts)
+	})
+
+	t.Run("fails once retries are exhausted", func(t *testing.T) {
+		server, requests := flakyServer(10)
+		monitor := New(&database.DB{}, &config.Config{})
+		monitor.backoff = time.Millisecond
+
+		monitor.executeProbe(newProbe(server.URL, 2))
+
+		result := onlyResult(t, monitor)
+		assert.Equal(t, "failure", result.Status)
+		assert.Equal(t, 3, result.Metadata["attempts"])
+		assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+		assert.Len(t, monitor.alerts, 1)
+	})
+
+	t.Run("errors are not retried", func(t *testing.T) {
+		monitor := New(&database.DB{}, &config.Config{})
+		monitor.backoff = time.Millisecond
+
+		probe := newProbe("", 3)
+		probe.Type = "unknown"
+		monitor.executeProbe(probe)
+
+		result := onlyResult(t, monitor)
+		assert.Equal(t, "error", result.Status)
+		assert.Equal(t, 1, result.Metadata["attempts"])
+	})
+
+	t.Run("stopping the monitor ends retries", func(t *testing.T) {
+		server, requests := flakyServer(10)
+		monitor := New(&database.DB{}, &config.Config{})
+		monitor.backoff = time.Minute
+		monitor.cancel()
+
+		monitor.executeProbe(newProbe(server.URL, 3))
+
+		result := onlyResult(t, monitor)
+		assert.Equal(t, "failure", result.Status)
+		assert.Equal(t, 1, result.Metadata["attempts"])
+		assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+	})
+}
+
 func TestProcessAlerts(t *testing.T) {
 	mockDB := &database.DB{}
 	mockConfig := &config.Config{}
@@ -727,4 +1164,50 @@ func TestProbeAPIHandlers(t *testing.T) {
 		assert.Equal(t, float64(1), response["total"])
 		assert.Contains(t, response, "probes")
 	})
-}
\ No newline at end of file
+}
+func TestResultIDsCollisionFree(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	monitor := New(&database.DB{}, &config.Config{})
+	probe := &ProbeConfig{
+		ID:      "tight-loop-probe",
+		Type:    "tcp",
+		Target:  listener.Addr().String(),
+		Timeout: time.Second,
+	}
+
+	const runs = 200
+	for i := 0; i < runs; i++ {
+		monitor.executeProbe(probe)
+	}
+	assert.Len(t, monitor.results, runs)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		id := newResultID(probe.ID, time.Now())
+		require.False(t, seen[id], "duplicate ID %s", id)
+		seen[id] = true
+	}
+
+	// Results come back in execution order regardless of map iteration order
+	results := make([]*ProbeResult, 0, len(monitor.results))
+	for _, result := range monitor.results {
+		results = append(results, result)
+	}
+	sortResults(results)
+	for i := 1; i < len(results); i++ {
+		assert.False(t, results[i].Timestamp.Before(results[i-1].Timestamp))
+	}
+}
@@ -0,0 +1,345 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// Effective states of a probe, as pushed to publisher targets
+const (
+	StateUp   = "up"
+	StateDown = "down"
+)
+
+// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of a state change
+// event's body, keyed with the target's secret
+const SignatureHeader = "X-Infra-Core-Signature"
+
+// Publisher defaults used when the probe config leaves them unset
+const (
+	defaultPublishDebounce   = 2
+	defaultPublishMaxRetries = 3
+	publishBackoff           = time.Second
+	publishQueueSize         = 100
+)
+
+// StateChangeEvent is pushed to publisher targets when the effective state of
+// a probe changes
+type StateChangeEvent struct {
+	ID        string       `json:"id"` // unique, so targets can ignore redeliveries
+	ProbeID   string       `json:"probe_id"`
+	Probe     string       `json:"probe"` // probe name
+	Tags      []string     `json:"tags"`
+	OldState  string       `json:"old_state"`
+	NewState  string       `json:"new_state"`
+	Result    *ProbeResult `json:"result"` // the check that changed the state
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// PublisherStats reports the deliveries to one target
+type PublisherStats struct {
+	Name         string     `json:"name"`
+	URL          string     `json:"url"`
+	Delivered    int64      `json:"delivered"`
+	Failed       int64      `json:"failed"`  // events given up on and dead-lettered
+	Retries      int64      `json:"retries"` // attempts after a failed one
+	Queued       int        `json:"queued"`
+	LastDelivery *time.Time `json:"last_delivery,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastErrorAt  *time.Time `json:"last_error_at,omitempty"`
+}
+
+// publisherTarget delivers events to one endpoint in the order they happened
+type publisherTarget struct {
+	name   string
+	url    string
+	secret []byte
+	events chan StateChangeEvent
+	mutex  sync.Mutex
+	stats  PublisherStats
+}
+
+// probeState is the effective state of a probe, and how many checks in a row
+// have disagreed with it
+type probeState struct {
+	state   string
+	pending int
+}
+
+// statePublisher pushes changes of the effective state of probes to the
+// configured targets. A probe's state only changes after debounce checks in
+// a row agree, so a single flaky check does not reach the status page.
+type statePublisher struct {
+	targets     []*publisherTarget
+	debounce    int
+	retries     int
+	backoff     time.Duration // delay before the first retry, doubled for each further retry
+	client      *http.Client
+	deadLetters string // file undeliverable events are appended to, "" to only log them
+	mutex       sync.Mutex
+	states      map[string]*probeState // keyed by probe ID
+	fileMutex   sync.Mutex
+}
+
+// newStatePublisher returns nil when no targets are configured
+func newStatePublisher(cfg config.ProbePublishersConfig) *statePublisher {
+	if len(cfg.Targets) == 0 {
+		return nil
+	}
+
+	sp := &statePublisher{
+		debounce:    defaultPublishDebounce,
+		retries:     defaultPublishMaxRetries,
+		backoff:     publishBackoff,
+		client:      &http.Client{Timeout: notifyTimeout},
+		deadLetters: cfg.DeadLetterFile,
+		states:      make(map[string]*probeState),
+	}
+	if cfg.Debounce > 0 {
+		sp.debounce = cfg.Debounce
+	}
+	if cfg.MaxRetries > 0 {
+		sp.retries = cfg.MaxRetries
+	}
+	for _, target := range cfg.Targets {
+		sp.targets = append(sp.targets, &publisherTarget{
+			name:   target.Name,
+			url:    target.URL,
+			secret: []byte(target.Secret),
+			events: make(chan StateChangeEvent, publishQueueSize),
+			stats:  PublisherStats{Name: target.Name, URL: target.URL},
+		})
+	}
+	return sp
+}
+
+// start delivers queued events to each target until ctx is done
+func (sp *statePublisher) start(ctx context.Context) {
+	if sp == nil {
+		return
+	}
+	for _, target := range sp.targets {
+		go sp.run(ctx, target)
+	}
+}
+
+// run delivers a target's events one at a time
+func (sp *statePublisher) run(ctx context.Context, target *publisherTarget) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-target.events:
+			sp.deliver(ctx, target, event)
+		}
+	}
+}
+
+// observe records the outcome of a check and returns the event to publish if
+// it changed the probe's effective state. A probe's first check sets its
+// state without an event.
+func (sp *statePublisher) observe(probe *ProbeConfig, result *ProbeResult) *StateChangeEvent {
+	state := StateDown
+	if result.Status == "success" {
+		state = StateUp
+	}
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	current, ok := sp.states[probe.ID]
+	if !ok {
+		sp.states[probe.ID] = &probeState{state: state}
+		return nil
+	}
+	if state == current.state {
+		current.pending = 0
+		return nil
+	}
+	current.pending++
+	if current.pending < sp.debounce {
+		return nil
+	}
+
+	event := &StateChangeEvent{
+		ID:        newResultID(probe.ID+"-"+state, result.Timestamp),
+		ProbeID:   probe.ID,
+		Probe:     probe.Name,
+		Tags:      probe.Tags,
+		OldState:  current.state,
+		NewState:  state,
+		Result:    result,
+		Timestamp: result.Timestamp,
+	}
+	current.state = state
+	current.pending = 0
+	return event
+}
+
+// forget drops the state of a deleted probe
+func (sp *statePublisher) forget(probeID string) {
+	if sp == nil {
+		return
+	}
+	sp.mutex.Lock()
+	delete(sp.states, probeID)
+	sp.mutex.Unlock()
+}
+
+// publish queues an event for every target. Events for a target whose queue
+// is full are dead-lettered.
+func (sp *statePublisher) publish(event StateChangeEvent) {
+	for _, target := range sp.targets {
+		select {
+		case target.events <- event:
+		default:
+			sp.giveUp(target, event, 0, fmt.Errorf("delivery queue is full"))
+		}
+	}
+}
+
+// deliver posts an event to a target, retrying failures with backoff until
+// the retries run out or ctx is done, and dead-letters it if it could not be
+// delivered
+func (sp *statePublisher) deliver(ctx context.Context, target *publisherTarget, event StateChangeEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		sp.giveUp(target, event, 0, fmt.Errorf("failed to encode event: %w", err))
+		return
+	}
+
+	attempts := 1
+	err = sp.post(ctx, target, body)
+	for backoff := sp.backoff; err != nil && attempts <= sp.retries; backoff *= 2 {
+		if !waitContext(ctx, backoff) {
+			break
+		}
+		attempts++
+		target.mutex.Lock()
+		target.stats.Retries++
+		target.mutex.Unlock()
+		err = sp.post(ctx, target, body)
+	}
+	if err != nil {
+		sp.giveUp(target, event, attempts, err)
+		return
+	}
+
+	now := time.Now()
+	target.mutex.Lock()
+	target.stats.Delivered++
+	target.stats.LastDelivery = &now
+	target.mutex.Unlock()
+}
+
+// post sends a signed event body to a target
+func (sp *statePublisher) post(ctx context.Context, target *publisherTarget, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create publisher request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(target.secret, body))
+
+	resp, err := sp.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call publisher target: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("publisher target returned %s", resp.Status)
+	}
+	return nil
+}
+
+// giveUp appends an event that could not be delivered to a target to the
+// dead letter file and counts it
+func (sp *statePublisher) giveUp(target *publisherTarget, event StateChangeEvent, attempts int, err error) {
+	now := time.Now()
+	log.Printf("⚠️ State change %s of %s to %s undeliverable after %d attempts: %v",
+		event.NewState, event.ProbeID, target.name, attempts, err)
+	if sp.deadLetters != "" {
+		sp.writeDeadLetter(map[string]interface{}{
+			"target":   target.name,
+			"attempts": attempts,
+			"error":    err.Error(),
+			"failed":   now.UTC(),
+			"event":    event,
+		})
+	}
+
+	target.mutex.Lock()
+	target.stats.Failed++
+	target.stats.LastError = err.Error()
+	target.stats.LastErrorAt = &now
+	target.mutex.Unlock()
+}
+
+// writeDeadLetter appends a line to the dead letter file
+func (sp *statePublisher) writeDeadLetter(letter map[string]interface{}) {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		log.Printf("❌ Failed to encode dead letter: %v", err)
+		return
+	}
+
+	sp.fileMutex.Lock()
+	defer sp.fileMutex.Unlock()
+	file, err := os.OpenFile(sp.deadLetters, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("❌ Failed to open dead letter file: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("❌ Failed to write dead letter: %v", err)
+	}
+}
+
+// stats returns the delivery stats of every target, in configured order
+func (sp *statePublisher) stats() []PublisherStats {
+	if sp == nil {
+		return []PublisherStats{}
+	}
+	stats := make([]PublisherStats, len(sp.targets))
+	for i, target := range sp.targets {
+		target.mutex.Lock()
+		stats[i] = target.stats
+		target.mutex.Unlock()
+		stats[i].Queued = len(target.events)
+	}
+	return stats
+}
+
+// Sign returns the SignatureHeader value of a body signed with secret, so
+// that receivers can verify events by computing it themselves
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// publishState publishes a change of a probe's effective state caused by a
+// check, if any
+func (pm *ProbeMonitor) publishState(probe *ProbeConfig, result *ProbeResult) {
+	if pm.publisher == nil {
+		return
+	}
+	if event := pm.publisher.observe(probe, result); event != nil {
+		log.Printf("📣 Probe %s is %s", probe.Name, event.NewState)
+		pm.publisher.publish(*event)
+	}
+}
@@ -0,0 +1,160 @@
+package probe
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// publishingMonitor creates a started monitor pushing state changes to the
+// given targets
+func publishingMonitor(t *testing.T, publishers config.ProbePublishersConfig) *ProbeMonitor {
+	monitor := New(&database.DB{}, &config.Config{Probe: config.ProbeMonitorConfig{Publishers: publishers}})
+	monitor.publisher.backoff = time.Millisecond
+	monitor.publisher.start(monitor.ctx)
+	t.Cleanup(monitor.cancel)
+	return monitor
+}
+
+func TestStateChangePublishing(t *testing.T) {
+	var healthy atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+
+	var mutex sync.Mutex
+	var events []StateChangeEvent
+	statusPage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, Sign([]byte("status-secret"), body), r.Header.Get(SignatureHeader), "signature")
+		var event StateChangeEvent
+		assert.NoError(t, json.Unmarshal(body, &event))
+		mutex.Lock()
+		events = append(events, event)
+		mutex.Unlock()
+	}))
+	defer statusPage.Close()
+	received := func() []StateChangeEvent {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]StateChangeEvent{}, events...)
+	}
+
+	monitor := publishingMonitor(t, config.ProbePublishersConfig{
+		Targets: []config.PublisherTargetConfig{{Name: "status", URL: statusPage.URL, Secret: "status-secret"}},
+	})
+	probe := &ProbeConfig{ID: "api", Name: "API", Type: "http", Target: upstream.URL, Timeout: time.Second,
+		ExpectedStatus: http.StatusOK, Tags: []string{"service:api"}}
+
+	check := func(up bool, times int) {
+		healthy.Store(up)
+		for i := 0; i < times; i++ {
+			monitor.executeProbe(probe)
+		}
+	}
+
+	// The first check sets the state, and a single failure is debounced
+	check(true, 2)
+	check(false, 1)
+	check(true, 1)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, received())
+
+	check(false, 3)
+	require.Eventually(t, func() bool { return len(received()) == 1 }, 5*time.Second, 5*time.Millisecond)
+	check(true, 2)
+	require.Eventually(t, func() bool { return len(received()) == 2 }, 5*time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	got := received()
+	require.Len(t, got, 2, "one event per flip")
+	assert.Equal(t, StateUp, got[0].OldState)
+	assert.Equal(t, StateDown, got[0].NewState)
+	assert.Equal(t, "failure", got[0].Result.Status)
+	assert.Equal(t, StateDown, got[1].OldState)
+	assert.Equal(t, StateUp, got[1].NewState)
+	assert.Equal(t, "success", got[1].Result.Status)
+	for _, event := range got {
+		assert.Equal(t, "api", event.ProbeID)
+		assert.Equal(t, "API", event.Probe)
+		assert.Equal(t, []string{"service:api"}, event.Tags)
+		assert.NotEmpty(t, event.ID)
+	}
+	assert.NotEqual(t, got[0].ID, got[1].ID)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/control/publishers", monitor.GetPublishers)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/control/publishers", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Publishers []PublisherStats `json:"publishers"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Publishers, 1)
+	assert.Equal(t, "status", response.Publishers[0].Name)
+	assert.Equal(t, int64(2), response.Publishers[0].Delivered)
+	assert.Zero(t, response.Publishers[0].Failed)
+	assert.NotNil(t, response.Publishers[0].LastDelivery)
+}
+
+func TestStateChangeDeadLetters(t *testing.T) {
+	var requests atomic.Int32
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	deadLetters := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	monitor := publishingMonitor(t, config.ProbePublishersConfig{
+		Targets:        []config.PublisherTargetConfig{{Name: "broken", URL: broken.URL, Secret: "s"}},
+		Debounce:       1,
+		MaxRetries:     2,
+		DeadLetterFile: deadLetters,
+	})
+	probe := &ProbeConfig{ID: "db", Name: "Database"}
+
+	monitor.publishState(probe, &ProbeResult{Status: "success", Timestamp: time.Now()})
+	monitor.publishState(probe, &ProbeResult{Status: "timeout", Timestamp: time.Now()})
+
+	require.Eventually(t, func() bool { return monitor.publisher.stats()[0].Failed == 1 }, 5*time.Second, 5*time.Millisecond)
+	stats := monitor.publisher.stats()[0]
+	assert.Equal(t, int64(2), stats.Retries)
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Contains(t, stats.LastError, "502")
+
+	file, err := os.Open(deadLetters)
+	require.NoError(t, err)
+	defer file.Close()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 1)
+	assert.Equal(t, "broken", lines[0]["target"])
+	assert.Equal(t, float64(3), lines[0]["attempts"])
+	assert.Equal(t, StateDown, lines[0]["event"].(map[string]interface{})["new_state"])
+}
@@ -0,0 +1,408 @@
+package probe
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Script probes run an ordered list of HTTP steps from their "steps" config
+// key, such as logging in and then fetching a profile with the token the
+// login returned. Values extracted from a step's JSON response are available
+// to later steps as ${name}. Steps whose URL starts with "/" are relative to
+// the probe's target.
+
+// redactedVariable replaces the values of sensitive variables in results
+const redactedVariable = "[REDACTED]"
+
+// scriptVariable matches a ${name} reference
+var scriptVariable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// scriptStep is one HTTP request of a script probe
+type scriptStep struct {
+	Name           string              `json:"name"`
+	Method         string              `json:"method"`
+	URL            string              `json:"url"`
+	Headers        map[string]string   `json:"headers"`
+	Body           json.RawMessage     `json:"body"` // a string is sent as is, anything else as JSON
+	ExpectedStatus int                 `json:"expected_status"`
+	Assertions     []scriptAssertion   `json:"assertions"`
+	Extract        []scriptExtraction  `json:"extract"`
+	path           map[string][]string // parsed JSON paths of assertions and extractions
+}
+
+// scriptAssertion checks a value of a step's JSON response
+type scriptAssertion struct {
+	Path   string          `json:"path"`
+	Equals json.RawMessage `json:"equals"` // the value must equal this JSON value
+	Exists *bool           `json:"exists"` // the value must, or must not, be present
+}
+
+// scriptExtraction stores a value of a step's JSON response in a variable
+type scriptExtraction struct {
+	Var       string `json:"var"`
+	Path      string `json:"path"`
+	Sensitive bool   `json:"sensitive"` // redact the value wherever results record it
+}
+
+// parseScript decodes and validates the steps of a script probe
+func parseScript(cfg map[string]interface{}) ([]scriptStep, error) {
+	raw, ok := cfg["steps"]
+	if !ok {
+		return nil, fmt.Errorf("script probes need a steps list in their config")
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid steps: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var steps []scriptStep
+	if err := decoder.Decode(&steps); err != nil {
+		return nil, fmt.Errorf("invalid steps: %w", err)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("script probes need at least one step")
+	}
+
+	defined := make(map[string]bool)
+	for i := range steps {
+		step := &steps[i]
+		label := fmt.Sprintf("step %d", i+1)
+		if step.Name != "" {
+			label += fmt.Sprintf(" (%s)", step.Name)
+		}
+
+		if step.Method == "" {
+			step.Method = http.MethodGet
+		}
+		step.Method = strings.ToUpper(step.Method)
+		switch step.Method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		default:
+			return nil, fmt.Errorf("%s: unsupported method %s", label, step.Method)
+		}
+		if step.URL == "" {
+			return nil, fmt.Errorf("%s: url is required", label)
+		}
+		if !strings.HasPrefix(step.URL, "/") && !strings.HasPrefix(step.URL, "http://") &&
+			!strings.HasPrefix(step.URL, "https://") && !strings.HasPrefix(step.URL, "${") {
+			return nil, fmt.Errorf("%s: url must be an http:// or https:// URL, or a path relative to the target", label)
+		}
+		if step.ExpectedStatus == 0 {
+			step.ExpectedStatus = http.StatusOK
+		} else if step.ExpectedStatus < 100 || step.ExpectedStatus > 599 {
+			return nil, fmt.Errorf("%s: expected_status %d is not an HTTP status", label, step.ExpectedStatus)
+		}
+
+		// Variables must be extracted by an earlier step
+		texts := []string{step.URL, string(step.Body)}
+		for _, value := range step.Headers {
+			texts = append(texts, value)
+		}
+		for _, text := range texts {
+			for _, match := range scriptVariable.FindAllStringSubmatch(text, -1) {
+				if !defined[match[1]] {
+					return nil, fmt.Errorf("%s: unknown variable ${%s}, extract it in an earlier step", label, match[1])
+				}
+			}
+		}
+
+		step.path = make(map[string][]string)
+		for j, assertion := range step.Assertions {
+			segments, err := parseJSONPath(assertion.Path)
+			if err != nil {
+				return nil, fmt.Errorf("%s: assertion %d: %w", label, j+1, err)
+			}
+			if (assertion.Equals == nil) == (assertion.Exists == nil) {
+				return nil, fmt.Errorf("%s: assertion %d: needs exactly one of equals or exists", label, j+1)
+			}
+			step.path[assertion.Path] = segments
+		}
+		for j, extraction := range step.Extract {
+			if !scriptVariable.MatchString("${" + extraction.Var + "}") {
+				return nil, fmt.Errorf("%s: extraction %d: invalid variable name %q", label, j+1, extraction.Var)
+			}
+			segments, err := parseJSONPath(extraction.Path)
+			if err != nil {
+				return nil, fmt.Errorf("%s: extraction %d: %w", label, j+1, err)
+			}
+			step.path[extraction.Path] = segments
+			defined[extraction.Var] = true
+		}
+	}
+	return steps, nil
+}
+
+// parseJSONPath splits a path such as $.user.roles[0] into its keys and
+// indices. Only dotted keys and array indices are supported.
+func parseJSONPath(path string) ([]string, error) {
+	if path != "$" && !strings.HasPrefix(path, "$.") && !strings.HasPrefix(path, "$[") {
+		return nil, fmt.Errorf("path %q must start with $", path)
+	}
+
+	var segments []string
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("path %q has an empty key", path)
+			}
+			segments = append(segments, key)
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unclosed [", path)
+			}
+			if _, err := strconv.Atoi(rest[1:end]); err != nil {
+				return nil, fmt.Errorf("path %q has a non-numeric index [%s]", path, rest[1:end])
+			}
+			segments = append(segments, rest[:end+1])
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("path %q is invalid at %q", path, rest)
+		}
+	}
+	return segments, nil
+}
+
+// lookupJSONPath returns the value at a parsed path of a decoded JSON document
+func lookupJSONPath(document interface{}, segments []string) (interface{}, bool) {
+	value := document
+	for _, segment := range segments {
+		if strings.HasPrefix(segment, "[") {
+			index, _ := strconv.Atoi(segment[1 : len(segment)-1])
+			list, ok := value.([]interface{})
+			if !ok || index < 0 || index >= len(list) {
+				return nil, false
+			}
+			value = list[index]
+			continue
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[segment]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// scriptRun holds the variables of one run of a script probe
+type scriptRun struct {
+	vars      map[string]string
+	sensitive []string // values to redact from what the result records
+}
+
+// expand replaces variable references in text
+func (run *scriptRun) expand(text string) string {
+	return scriptVariable.ReplaceAllStringFunc(text, func(ref string) string {
+		return run.vars[ref[2:len(ref)-1]]
+	})
+}
+
+// redact hides the values of sensitive variables in text
+func (run *scriptRun) redact(text string) string {
+	for _, value := range run.sensitive {
+		if value != "" {
+			text = strings.ReplaceAll(text, value, redactedVariable)
+		}
+	}
+	return text
+}
+
+// executeScriptProbe runs the steps of a script probe in order, stopping at
+// the first that fails. The result records every step run, and its response
+// time is the sum of theirs.
+func (pm *ProbeMonitor) executeScriptProbe(probe *ProbeConfig, result *ProbeResult) {
+	steps, err := parseScript(probe.Config)
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+		return
+	}
+
+	client := &http.Client{
+		Timeout: probe.Timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	run := &scriptRun{vars: make(map[string]string)}
+	records := make([]map[string]interface{}, 0, len(steps))
+	var total time.Duration
+	defer func() {
+		result.ResponseTime = total
+		result.Metadata["steps"] = records
+		result.Message = run.redact(result.Message)
+		result.Error = run.redact(result.Error)
+	}()
+
+	for i, step := range steps {
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("step %d", i+1)
+		}
+		target, err := resolveStepURL(probe, step, run)
+		started := time.Now()
+		statusCode := 0
+		if err == nil {
+			statusCode, err = pm.runScriptStep(client, target, step, run)
+		}
+		duration := time.Since(started)
+		total += duration
+
+		record := map[string]interface{}{
+			"name":        name,
+			"method":      step.Method,
+			"url":         run.redact(target),
+			"duration_ms": duration.Milliseconds(),
+			"passed":      err == nil,
+		}
+		if statusCode != 0 {
+			record["status_code"] = statusCode
+			result.StatusCode = statusCode
+		}
+		if err != nil {
+			record["error"] = run.redact(err.Error())
+		}
+		records = append(records, record)
+
+		if err != nil {
+			result.Status = "failure"
+			result.Message = fmt.Sprintf("%s failed: %v", name, err)
+			return
+		}
+	}
+
+	extracted := make(map[string]string, len(run.vars))
+	for name, value := range run.vars {
+		extracted[name] = run.redact(value)
+	}
+	result.Metadata["variables"] = extracted
+	result.Status = "success"
+	result.Message = fmt.Sprintf("Script passed %d steps", len(steps))
+}
+
+// resolveStepURL expands the variables in a step's URL and resolves paths
+// against the probe's target
+func resolveStepURL(probe *ProbeConfig, step scriptStep, run *scriptRun) (string, error) {
+	target := run.expand(step.URL)
+	if !strings.HasPrefix(target, "/") {
+		return target, nil
+	}
+	base, err := url.Parse(probe.Target)
+	if err != nil {
+		return target, fmt.Errorf("invalid target: %w", err)
+	}
+	relative, err := url.Parse(target)
+	if err != nil {
+		return target, fmt.Errorf("invalid url: %w", err)
+	}
+	return base.ResolveReference(relative).String(), nil
+}
+
+// runScriptStep sends one step's request to target and checks its response,
+// storing the values it extracts. It returns the response's status code, if
+// any.
+func (pm *ProbeMonitor) runScriptStep(client *http.Client, target string, step scriptStep, run *scriptRun) (int, error) {
+	var body io.Reader
+	isJSON := false
+	if len(step.Body) > 0 {
+		var text string
+		if err := json.Unmarshal(step.Body, &text); err != nil {
+			text, isJSON = string(step.Body), true
+		}
+		body = strings.NewReader(run.expand(text))
+	}
+
+	req, err := http.NewRequestWithContext(pm.ctx, step.Method, target, body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	if isJSON {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range step.Headers {
+		req.Header.Set(key, run.expand(value))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != step.ExpectedStatus {
+		return resp.StatusCode, fmt.Errorf("unexpected status code: got %d, expected %d", resp.StatusCode, step.ExpectedStatus)
+	}
+	if len(step.Assertions) == 0 && len(step.Extract) == 0 {
+		return resp.StatusCode, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBodySize))
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to read response body: %w", err)
+	}
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return resp.StatusCode, fmt.Errorf("response is not JSON: %w", err)
+	}
+
+	// Extract first, so that sensitive values never reach assertion errors
+	for _, extraction := range step.Extract {
+		value, ok := lookupJSONPath(document, step.path[extraction.Path])
+		if !ok {
+			return resp.StatusCode, fmt.Errorf("nothing to extract into %s at %s", extraction.Var, extraction.Path)
+		}
+		text, isString := value.(string)
+		if !isString {
+			encoded, _ := json.Marshal(value)
+			text = string(encoded)
+		}
+		run.vars[extraction.Var] = text
+		if extraction.Sensitive {
+			run.sensitive = append(run.sensitive, text)
+		}
+	}
+
+	for _, assertion := range step.Assertions {
+		value, ok := lookupJSONPath(document, step.path[assertion.Path])
+		if assertion.Exists != nil {
+			if ok != *assertion.Exists {
+				return resp.StatusCode, fmt.Errorf("assertion failed: %s exists is %t", assertion.Path, ok)
+			}
+			continue
+		}
+		var expected interface{}
+		if err := json.Unmarshal(assertion.Equals, &expected); err != nil {
+			return resp.StatusCode, fmt.Errorf("invalid assertion value for %s: %w", assertion.Path, err)
+		}
+		if !ok || !reflect.DeepEqual(value, expected) {
+			actual, _ := json.Marshal(value)
+			if !ok {
+				actual = []byte("nothing")
+			}
+			return resp.StatusCode, fmt.Errorf("assertion failed: %s is %s, expected %s", assertion.Path, actual, assertion.Equals)
+		}
+	}
+	return resp.StatusCode, nil
+}
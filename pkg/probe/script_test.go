@@ -0,0 +1,212 @@
+package probe
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+const scriptToken = "s3cr3t-token"
+
+// tokenAPI serves a login endpoint handing out a token and a profile
+// endpoint requiring it
+func tokenAPI(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		var credentials map[string]string
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&credentials) != nil ||
+			credentials["password"] != "hunter2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token": scriptToken,
+			"user":  map[string]interface{}{"id": 42, "name": credentials["username"]},
+		})
+	})
+	mux.HandleFunc("/users/42", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+scriptToken {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": "invalid token ` + r.Header.Get("Authorization") + `"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":  "ops",
+			"roles": []string{"admin", "viewer"},
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// loginSteps logs in and fetches the profile of the logged in user
+func loginSteps(profileAssertions ...map[string]interface{}) []interface{} {
+	assertions := []interface{}{
+		map[string]interface{}{"path": "$.roles[0]", "equals": "admin"},
+	}
+	for _, assertion := range profileAssertions {
+		assertions = append(assertions, assertion)
+	}
+	return []interface{}{
+		map[string]interface{}{
+			"name":   "login",
+			"method": "post",
+			"url":    "/login",
+			"body":   map[string]interface{}{"username": "ops", "password": "hunter2"},
+			"assertions": []interface{}{
+				map[string]interface{}{"path": "$.token", "exists": true},
+			},
+			"extract": []interface{}{
+				map[string]interface{}{"var": "token", "path": "$.token", "sensitive": true},
+				map[string]interface{}{"var": "user_id", "path": "$.user.id"},
+			},
+		},
+		map[string]interface{}{
+			"name":       "profile",
+			"url":        "/users/${user_id}",
+			"headers":    map[string]interface{}{"Authorization": "Bearer ${token}"},
+			"assertions": assertions,
+		},
+	}
+}
+
+func runScript(t *testing.T, target string, steps []interface{}) *ProbeResult {
+	monitor := New(&database.DB{}, &config.Config{})
+	t.Cleanup(monitor.cancel)
+	probe := &ProbeConfig{ID: "login", Name: "Login flow", Type: "script", Target: target,
+		Timeout: 5 * time.Second, Config: map[string]interface{}{"steps": steps}}
+	monitor.executeProbe(probe)
+
+	require.Len(t, monitor.results, 1)
+	for _, result := range monitor.results {
+		return result
+	}
+	return nil
+}
+
+func TestScriptProbe(t *testing.T) {
+	server := tokenAPI(t)
+
+	result := runScript(t, server.URL, loginSteps())
+	assert.Equal(t, "success", result.Status, result.Message)
+	steps := result.Metadata["steps"].([]map[string]interface{})
+	require.Len(t, steps, 2)
+	assert.Equal(t, "login", steps[0]["name"])
+	assert.Equal(t, http.MethodPost, steps[0]["method"])
+	assert.Equal(t, server.URL+"/users/42", steps[1]["url"])
+	assert.Equal(t, http.StatusOK, steps[1]["status_code"])
+	for _, step := range steps {
+		assert.Equal(t, true, step["passed"])
+	}
+	assert.Equal(t, map[string]string{"token": redactedVariable, "user_id": "42"}, result.Metadata["variables"])
+	assert.Positive(t, result.ResponseTime)
+
+	encoded, err := json.Marshal(result)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), scriptToken, "sensitive variables are redacted")
+}
+
+func TestScriptProbeStopsAtFailedStep(t *testing.T) {
+	server := tokenAPI(t)
+
+	// A failed assertion stops the script
+	result := runScript(t, server.URL, loginSteps(map[string]interface{}{"path": "$.name", "equals": "root"}))
+	assert.Equal(t, "failure", result.Status)
+	assert.Contains(t, result.Message, "profile failed")
+	assert.Contains(t, result.Message, `$.name is "ops", expected "root"`)
+
+	// So does an unexpected status, and later steps do not run
+	steps := loginSteps()
+	steps[0].(map[string]interface{})["body"] = map[string]interface{}{"username": "ops", "password": "wrong"}
+	result = runScript(t, server.URL, steps)
+	assert.Equal(t, "failure", result.Status)
+	assert.Equal(t, http.StatusUnauthorized, result.StatusCode)
+	records := result.Metadata["steps"].([]map[string]interface{})
+	require.Len(t, records, 1)
+	assert.Equal(t, false, records[0]["passed"])
+	assert.Contains(t, records[0]["error"], "got 401, expected 200")
+
+	// Sensitive values are redacted from errors too
+	steps = loginSteps()
+	steps[1].(map[string]interface{})["expected_status"] = http.StatusForbidden
+	steps[1].(map[string]interface{})["headers"] = map[string]interface{}{"Authorization": "Token ${token}"}
+	steps[1].(map[string]interface{})["assertions"] = []interface{}{
+		map[string]interface{}{"path": "$.error", "equals": "no"},
+	}
+	result = runScript(t, server.URL, steps)
+	assert.Equal(t, "failure", result.Status)
+	encoded, err := json.Marshal(result)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), scriptToken)
+	assert.Contains(t, result.Message, redactedVariable)
+}
+
+func TestParseScript(t *testing.T) {
+	_, err := parseScript(map[string]interface{}{"steps": loginSteps()})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		steps interface{}
+		err   string
+	}{
+		{"missing", nil, "need a steps list"},
+		{"empty", []interface{}{}, "at least one step"},
+		{"unknown field", []interface{}{map[string]interface{}{"url": "/", "expect": 200}}, `unknown field "expect"`},
+		{"missing url", []interface{}{map[string]interface{}{"name": "home"}}, "step 1 (home): url is required"},
+		{"bad method", []interface{}{map[string]interface{}{"url": "/", "method": "FETCH"}}, "step 1: unsupported method FETCH"},
+		{"bad url", []interface{}{map[string]interface{}{"url": "ftp://example.com"}}, "step 1: url must be"},
+		{"bad status", []interface{}{map[string]interface{}{"url": "/", "expected_status": 42}}, "expected_status 42 is not an HTTP status"},
+		{"unknown variable", []interface{}{
+			map[string]interface{}{"url": "/", "extract": []interface{}{map[string]interface{}{"var": "id", "path": "$.id"}}},
+			map[string]interface{}{"url": "/items/${id}", "headers": map[string]interface{}{"Authorization": "Bearer ${token}"}},
+		}, "step 2: unknown variable ${token}, extract it in an earlier step"},
+		{"bad path", []interface{}{map[string]interface{}{"url": "/", "assertions": []interface{}{
+			map[string]interface{}{"path": "token", "exists": true}}}}, `step 1: assertion 1: path "token" must start with $`},
+		{"bad index", []interface{}{map[string]interface{}{"url": "/", "extract": []interface{}{
+			map[string]interface{}{"var": "id", "path": "$.items[first]"}}}}, "non-numeric index [first]"},
+		{"no check", []interface{}{map[string]interface{}{"url": "/", "assertions": []interface{}{
+			map[string]interface{}{"path": "$.ok"}}}}, "needs exactly one of equals or exists"},
+		{"bad variable", []interface{}{map[string]interface{}{"url": "/", "extract": []interface{}{
+			map[string]interface{}{"var": "user-id", "path": "$.id"}}}}, `invalid variable name "user-id"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := map[string]interface{}{}
+			if tt.steps != nil {
+				cfg["steps"] = tt.steps
+			}
+			_, err := parseScript(cfg)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestCreateScriptProbeValidation(t *testing.T) {
+	monitor := New(&database.DB{}, &config.Config{})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/probes", monitor.CreateProbe)
+
+	body := `{"name": "Login", "type": "script", "target": "http://localhost", "config": {"steps": [{"url": "/login", "method": "POST"}, {"url": "/me", "headers": {"Authorization": "${token}"}}]}}`
+	req := httptest.NewRequest(http.MethodPost, "/probes", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "step 2: unknown variable ${token}")
+	assert.Empty(t, monitor.probes)
+}
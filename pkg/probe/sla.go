@@ -0,0 +1,460 @@
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Granularities of the buckets of an SLA report
+const (
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+)
+
+// SLA report defaults and limits
+const (
+	defaultSLAPeriod     = 30 * 24 * time.Hour
+	defaultSLAPercentile = 99.0
+	maxSLABuckets        = 1000
+)
+
+// A result stands for the state of its probe until the next result, but for
+// at most slaCoverage intervals of the probe. Time not covered by any result,
+// because the probe was disabled or the monitor was down, is unmonitored.
+const slaCoverage = 2
+
+// SLAReport is the availability of one probe, or of the probes with a tag,
+// over a period split into buckets. Reports over past periods only depend
+// on the results stored for them.
+type SLAReport struct {
+	ProbeID          string      `json:"probe_id,omitempty"`
+	Tag              string      `json:"tag,omitempty"`
+	Probes           []string    `json:"probes,omitempty"` // with the tag, sorted
+	From             time.Time   `json:"from"`
+	To               time.Time   `json:"to"`
+	Granularity      string      `json:"granularity"`
+	Percentile       float64     `json:"percentile"`
+	CountUnmonitored bool        `json:"count_unmonitored"`
+	Summary          SLABucket   `json:"summary"`
+	Buckets          []SLABucket `json:"buckets"`
+}
+
+// SLABucket is the availability over one bucket of a report. Uptime is the
+// share of the time monitored, or of all the time when unmonitored time
+// counts as downtime; it is null when no time counts. An incident is a run
+// of failed checks, counted in the bucket it starts in and ended by the next
+// successful check. Across probes times and incidents add up.
+type SLABucket struct {
+	Start              time.Time `json:"start"`
+	End                time.Time `json:"end"`
+	UptimePercent      *float64  `json:"uptime_percent"`
+	UptimeMinutes      float64   `json:"uptime_minutes"`
+	DowntimeMinutes    float64   `json:"downtime_minutes"`
+	UnmonitoredMinutes float64   `json:"unmonitored_minutes"`
+	Incidents          int       `json:"incidents"`
+	MTTRSeconds        *float64  `json:"mttr_seconds"`     // mean time to recover from the incidents resolved, null if none
+	ResponseTimeMs     *float64  `json:"response_time_ms"` // at the percentile of the report, null without checks
+	Checks             int       `json:"checks"`
+}
+
+// slaWindow is the period, buckets and settings of a report
+type slaWindow struct {
+	from, to         time.Time
+	bounds           []time.Time // of the buckets, from..to
+	granularity      string
+	percentile       float64
+	countUnmonitored bool
+}
+
+// slaTally accumulates the figures of a bucket
+type slaTally struct {
+	up, down, unmonitored time.Duration
+	incidents             int
+	repairs               []time.Duration
+	responses             []time.Duration
+	checks                int
+}
+
+// parseSLATime parses an RFC 3339 time or a date, taken as UTC midnight
+func parseSLATime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+// slaWindow reads the period and settings of a report from the query: from
+// and to, the last 30 days by default, granularity, percentile and
+// count_unmonitored, which defaults to the probe config
+func (pm *ProbeMonitor) slaWindow(c *gin.Context, now time.Time) (*slaWindow, error) {
+	w := &slaWindow{
+		to:          now.UTC(),
+		granularity: c.DefaultQuery("granularity", GranularityDay),
+		percentile:  defaultSLAPercentile,
+	}
+	if pm.config != nil {
+		w.countUnmonitored = pm.config.Probe.SLACountUnmonitored
+	}
+
+	var err error
+	if value := c.Query("to"); value != "" {
+		if w.to, err = parseSLATime(value); err != nil {
+			return nil, err
+		}
+	}
+	w.from = w.to.Add(-defaultSLAPeriod)
+	if value := c.Query("from"); value != "" {
+		if w.from, err = parseSLATime(value); err != nil {
+			return nil, err
+		}
+	}
+	if !w.from.Before(w.to) {
+		return nil, errors.New("from must be before to")
+	}
+	if value := c.Query("percentile"); value != "" {
+		w.percentile, err = strconv.ParseFloat(value, 64)
+		if err != nil || w.percentile <= 0 || w.percentile > 100 {
+			return nil, fmt.Errorf("percentile must be a number in (0, 100], got %q", value)
+		}
+	}
+	if value := c.Query("count_unmonitored"); value != "" {
+		if w.countUnmonitored, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid count_unmonitored %q", value)
+		}
+	}
+
+	if w.bounds, err = slaBounds(w.from, w.to, w.granularity); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// slaBounds splits from..to at the calendar days, weeks starting on Monday
+// or months of UTC
+func slaBounds(from, to time.Time, granularity string) ([]time.Time, error) {
+	switch granularity {
+	case GranularityDay, GranularityWeek, GranularityMonth:
+	default:
+		return nil, fmt.Errorf("granularity must be day, week or month, got %q", granularity)
+	}
+
+	bounds := []time.Time{from}
+	for start := from; start.Before(to); {
+		if len(bounds) > maxSLABuckets {
+			return nil, fmt.Errorf("period spans more than %d buckets", maxSLABuckets)
+		}
+		t := start.UTC()
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		var next time.Time
+		switch granularity {
+		case GranularityDay:
+			next = day.AddDate(0, 0, 1)
+		case GranularityWeek:
+			next = day.AddDate(0, 0, 7-(int(day.Weekday())+6)%7)
+		case GranularityMonth:
+			next = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+		}
+		if next.After(to) {
+			next = to
+		}
+		bounds = append(bounds, next)
+		start = next
+	}
+	return bounds, nil
+}
+
+// bucket returns the index of the bucket t falls in
+func (w *slaWindow) bucket(t time.Time) int {
+	i := sort.Search(len(w.bounds), func(i int) bool { return w.bounds[i].After(t) }) - 1
+	return min(max(i, 0), len(w.bounds)-2)
+}
+
+// addSpan adds the time from start to end to the tallies of its buckets
+func (w *slaWindow) addSpan(tallies []slaTally, start, end time.Time, field func(*slaTally) *time.Duration) {
+	for i := w.bucket(start); start.Before(end) && i < len(tallies); i++ {
+		stop := earliest(end, w.bounds[i+1])
+		*field(&tallies[i]) += stop.Sub(start)
+		start = stop
+	}
+}
+
+// tally splits the time of the window up to now between the states of a
+// probe, given its results oldest first from a gap before the window on.
+// A result covers the time until the next one, up to gap.
+func (w *slaWindow) tally(results []*ProbeResult, gap time.Duration, now time.Time) []slaTally {
+	tallies := make([]slaTally, len(w.bounds)-1)
+	end := earliest(w.to, now)
+	if !w.from.Before(end) {
+		return tallies
+	}
+
+	up := func(t *slaTally) *time.Duration { return &t.up }
+	down := func(t *slaTally) *time.Duration { return &t.down }
+	unmonitored := func(t *slaTally) *time.Duration { return &t.unmonitored }
+
+	incident := func(start, resolved time.Time) {
+		if !resolved.IsZero() && !resolved.After(w.from) {
+			return
+		}
+		tally := &tallies[w.bucket(latest(start, w.from))]
+		tally.incidents++
+		if !resolved.IsZero() {
+			tally.repairs = append(tally.repairs, resolved.Sub(start))
+		}
+	}
+
+	cursor := w.from
+	var failingSince time.Time
+	for i, result := range results {
+		if !result.Timestamp.Before(end) {
+			break
+		}
+		success := result.Status == "success"
+		switch {
+		case !success && failingSince.IsZero():
+			failingSince = result.Timestamp
+		case success && !failingSince.IsZero():
+			incident(failingSince, result.Timestamp)
+			failingSince = time.Time{}
+		}
+
+		if !result.Timestamp.Before(w.from) {
+			tally := &tallies[w.bucket(result.Timestamp)]
+			tally.checks++
+			if result.ResponseTime > 0 {
+				tally.responses = append(tally.responses, result.ResponseTime)
+			}
+		}
+
+		covered := result.Timestamp.Add(gap)
+		if i+1 < len(results) {
+			covered = earliest(covered, results[i+1].Timestamp)
+		}
+		start, covered := latest(result.Timestamp, cursor), earliest(covered, end)
+		if !start.Before(covered) {
+			continue
+		}
+		w.addSpan(tallies, cursor, start, unmonitored)
+		if success {
+			w.addSpan(tallies, start, covered, up)
+		} else {
+			w.addSpan(tallies, start, covered, down)
+		}
+		cursor = covered
+	}
+	if !failingSince.IsZero() {
+		incident(failingSince, time.Time{})
+	}
+	w.addSpan(tallies, cursor, end, unmonitored)
+	return tallies
+}
+
+// add adds the figures of other to the tally
+func (t *slaTally) add(other *slaTally) {
+	t.up += other.up
+	t.down += other.down
+	t.unmonitored += other.unmonitored
+	t.incidents += other.incidents
+	t.repairs = append(t.repairs, other.repairs...)
+	t.responses = append(t.responses, other.responses...)
+	t.checks += other.checks
+}
+
+// bucket reports the tally as the bucket from start to end
+func (t *slaTally) bucket(start, end time.Time, w *slaWindow) SLABucket {
+	downtime, counted := t.down, t.up+t.down
+	if w.countUnmonitored {
+		downtime += t.unmonitored
+		counted += t.unmonitored
+	}
+
+	bucket := SLABucket{
+		Start:              start,
+		End:                end,
+		UptimeMinutes:      roundSLA(t.up.Minutes()),
+		DowntimeMinutes:    roundSLA(downtime.Minutes()),
+		UnmonitoredMinutes: roundSLA(t.unmonitored.Minutes()),
+		Incidents:          t.incidents,
+		Checks:             t.checks,
+	}
+	if counted > 0 {
+		uptime := roundSLA(100 * float64(t.up) / float64(counted))
+		bucket.UptimePercent = &uptime
+	}
+	if len(t.repairs) > 0 {
+		var total time.Duration
+		for _, repair := range t.repairs {
+			total += repair
+		}
+		mttr := roundSLA((total / time.Duration(len(t.repairs))).Seconds())
+		bucket.MTTRSeconds = &mttr
+	}
+	if len(t.responses) > 0 {
+		responses := append([]time.Duration(nil), t.responses...)
+		sort.Slice(responses, func(i, j int) bool { return responses[i] < responses[j] })
+		rank := int(math.Ceil(w.percentile / 100 * float64(len(responses))))
+		ms := roundSLA(float64(responses[max(rank, 1)-1]) / float64(time.Millisecond))
+		bucket.ResponseTimeMs = &ms
+	}
+	return bucket
+}
+
+// earliest returns the earlier of two times
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// latest returns the later of two times
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// roundSLA rounds a figure of a report to three decimals
+func roundSLA(value float64) float64 {
+	return math.Round(value*1000) / 1000
+}
+
+// slaGap returns how long a result of a probe covers
+func slaGap(probe *ProbeConfig) time.Duration {
+	interval := probe.Interval
+	if interval <= 0 {
+		interval = defaultProbeInterval
+	}
+	return slaCoverage * interval
+}
+
+// resultsBetween returns the results of a probe recorded at or after from
+// and before to, held in memory or stored, oldest first
+func (pm *ProbeMonitor) resultsBetween(probeID string, from, to time.Time) []*ProbeResult {
+	pm.mutex.RLock()
+	results := make([]*ProbeResult, 0)
+	for _, result := range pm.results {
+		if result.ProbeID == probeID && !result.Timestamp.Before(from) && result.Timestamp.Before(to) {
+			results = append(results, result)
+		}
+	}
+	pm.mutex.RUnlock()
+
+	return mergeResults(results, pm.store.resultsBetween(probeID, from, to), -1)
+}
+
+// slaReport reports on the probes, keyed by ID with their result coverage,
+// over the window
+func (pm *ProbeMonitor) slaReport(w *slaWindow, probes map[string]time.Duration, now time.Time) *SLAReport {
+	ids := make([]string, 0, len(probes))
+	for id := range probes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	tallies := make([]slaTally, len(w.bounds)-1)
+	for _, id := range ids {
+		gap := probes[id]
+		for i, tally := range w.tally(pm.resultsBetween(id, w.from.Add(-gap), w.to), gap, now) {
+			tallies[i].add(&tally)
+		}
+	}
+
+	report := &SLAReport{
+		From:             w.from,
+		To:               w.to,
+		Granularity:      w.granularity,
+		Percentile:       w.percentile,
+		CountUnmonitored: w.countUnmonitored,
+		Buckets:          make([]SLABucket, 0, len(tallies)),
+	}
+	var summary slaTally
+	for i := range tallies {
+		summary.add(&tallies[i])
+		report.Buckets = append(report.Buckets, tallies[i].bucket(w.bounds[i], w.bounds[i+1], w))
+	}
+	report.Summary = summary.bucket(w.from, w.to, w)
+	return report
+}
+
+// GetProbeSLA reports the uptime of a probe by day, week or month
+func (pm *ProbeMonitor) GetProbeSLA(c *gin.Context) {
+	probeID := c.Param("probe_id")
+	now := time.Now()
+
+	w, err := pm.slaWindow(c, now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pm.mutex.RLock()
+	probe, exists := pm.scopedProbe(c, probeID)
+	var gap time.Duration
+	if exists {
+		gap = slaGap(probe)
+	}
+	pm.mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Probe not found"})
+		return
+	}
+
+	report := pm.slaReport(w, map[string]time.Duration{probeID: gap}, now)
+	report.ProbeID = probeID
+	c.JSON(http.StatusOK, report)
+}
+
+// GetTagSLA reports the uptime of the probes with a tag by day, week or
+// month, adding up their times and incidents
+func (pm *ProbeMonitor) GetTagSLA(c *gin.Context) {
+	tag := c.Query("tag")
+	if tag == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tag is required"})
+		return
+	}
+	now := time.Now()
+
+	w, err := pm.slaWindow(c, now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	probes := make(map[string]time.Duration)
+	pm.mutex.RLock()
+	for _, probe := range pm.probes {
+		for _, probeTag := range probe.Tags {
+			if probeTag == tag {
+				probes[probe.ID] = slaGap(probe)
+				break
+			}
+		}
+	}
+	pm.mutex.RUnlock()
+	if len(probes) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No probes with tag " + tag})
+		return
+	}
+
+	report := pm.slaReport(w, probes, now)
+	report.Tag = tag
+	for id := range probes {
+		report.Probes = append(report.Probes, id)
+	}
+	sort.Strings(report.Probes)
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,251 @@
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// slaDay is the first day of the synthetic timelines
+var slaDay = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// slaTimeline returns a result a minute from start for each character of
+// pattern: s succeeds in 100ms, S in 900ms, f fails and . has no result
+func slaTimeline(probeID string, start time.Time, pattern string) []*ProbeResult {
+	var results []*ProbeResult
+	for i, state := range pattern {
+		result := &ProbeResult{
+			ID:        fmt.Sprintf("%s-%s-%d", probeID, start.Format("0102"), i),
+			ProbeID:   probeID,
+			Status:    "success",
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		}
+		switch state {
+		case 's':
+			result.ResponseTime = 100 * time.Millisecond
+		case 'S':
+			result.ResponseTime = 900 * time.Millisecond
+		case 'f':
+			result.Status = "failure"
+		case '.':
+			continue
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// slaDays returns two days of results: a late start and a ten minute outage,
+// then two hours unmonitored, five flaps and twenty slow checks
+func slaDays(probeID string) ([]*ProbeResult, []*ProbeResult) {
+	first := strings.Repeat(".", 5) + strings.Repeat("s", 595) + strings.Repeat("f", 10) + strings.Repeat("s", 830)
+	second := strings.Repeat("s", 60) + strings.Repeat(".", 120) + strings.Repeat("fs", 5) +
+		strings.Repeat("s", 810) + strings.Repeat("S", 20) + strings.Repeat("s", 420)
+	return slaTimeline(probeID, slaDay, first), slaTimeline(probeID, slaDay.AddDate(0, 0, 1), second)
+}
+
+// slaFigures are the figures of a bucket compared by the tests
+type slaFigures struct {
+	uptime, up, down, unmonitored float64
+	incidents, checks             int
+	mttr, response                float64 // zero when null
+}
+
+func figuresOf(bucket SLABucket) slaFigures {
+	figures := slaFigures{
+		up:          bucket.UptimeMinutes,
+		down:        bucket.DowntimeMinutes,
+		unmonitored: bucket.UnmonitoredMinutes,
+		incidents:   bucket.Incidents,
+		checks:      bucket.Checks,
+	}
+	if bucket.UptimePercent != nil {
+		figures.uptime = *bucket.UptimePercent
+	}
+	if bucket.MTTRSeconds != nil {
+		figures.mttr = *bucket.MTTRSeconds
+	}
+	if bucket.ResponseTimeMs != nil {
+		figures.response = *bucket.ResponseTimeMs
+	}
+	return figures
+}
+
+// tallyReport reports on results over from..to as the monitor would
+func tallyReport(t *testing.T, results []*ProbeResult, from, to, now time.Time, countUnmonitored bool) []SLABucket {
+	bounds, err := slaBounds(from, to, GranularityDay)
+	require.NoError(t, err)
+	w := &slaWindow{from: from, to: to, bounds: bounds, granularity: GranularityDay, percentile: 99, countUnmonitored: countUnmonitored}
+
+	var buckets []SLABucket
+	var summary slaTally
+	for i, tally := range w.tally(results, 2*time.Minute, now) {
+		summary.add(&tally)
+		buckets = append(buckets, tally.bucket(bounds[i], bounds[i+1], w))
+	}
+	return append(buckets, summary.bucket(from, to, w))
+}
+
+func TestSLABounds(t *testing.T) {
+	from := time.Date(2026, 1, 30, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		granularity string
+		to          time.Time
+		bounds      []string
+	}{
+		{GranularityDay, time.Date(2026, 2, 1, 6, 0, 0, 0, time.UTC), []string{"01-30T12", "01-31T00", "02-01T00", "02-01T06"}},
+		{GranularityWeek, time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC), []string{"01-30T12", "02-02T00", "02-09T00", "02-10T00"}},
+		{GranularityMonth, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), []string{"01-30T12", "02-01T00", "03-01T00", "03-02T00"}},
+	} {
+		bounds, err := slaBounds(from, tc.to, tc.granularity)
+		require.NoError(t, err)
+		var formatted []string
+		for _, bound := range bounds {
+			formatted = append(formatted, bound.Format("01-02T15"))
+		}
+		assert.Equal(t, tc.bounds, formatted, tc.granularity)
+	}
+
+	_, err := slaBounds(from, from.AddDate(10, 0, 0), GranularityDay)
+	assert.ErrorContains(t, err, "more than 1000 buckets")
+	_, err = slaBounds(from, from.AddDate(0, 0, 1), "year")
+	assert.ErrorContains(t, err, "granularity must be")
+}
+
+func TestSLATally(t *testing.T) {
+	first, second := slaDays("api")
+	results := append(first, second...)
+	from, to := slaDay, slaDay.AddDate(0, 0, 2)
+	later := to.AddDate(0, 1, 0)
+
+	// Gaps are unmonitored, and each run of failures is an incident
+	assert.Equal(t, []slaFigures{
+		{uptime: 99.303, up: 1425, down: 10, unmonitored: 5, incidents: 1, checks: 1435, mttr: 600, response: 100},
+		{uptime: 99.621, up: 1316, down: 5, unmonitored: 119, incidents: 5, checks: 1320, mttr: 60, response: 900},
+		{uptime: 99.456, up: 2741, down: 15, unmonitored: 124, incidents: 6, checks: 2755, mttr: 150, response: 100},
+	}, figuresMap(tallyReport(t, results, from, to, later, false)))
+
+	// Unmonitored time may count as downtime
+	counted := figuresMap(tallyReport(t, results, from, to, later, true))
+	assert.Equal(t, slaFigures{uptime: 91.389, up: 1316, down: 124, unmonitored: 119, incidents: 5, checks: 1320, mttr: 60, response: 900}, counted[1])
+
+	// A report starting during an outage counts the incident from its start,
+	// and one ending during it counts it unresolved
+	outage := slaDay.Add(605 * time.Minute)
+	during := figuresMap(tallyReport(t, results, outage, slaDay.AddDate(0, 0, 1), later, false))
+	assert.Equal(t, slaFigures{uptime: 99.401, up: 830, down: 5, incidents: 1, checks: 835, mttr: 600, response: 100}, during[0])
+	until := figuresMap(tallyReport(t, results, slaDay, outage, later, false))
+	assert.Equal(t, slaFigures{uptime: 99.167, up: 595, down: 5, unmonitored: 5, incidents: 1, checks: 600, response: 100}, until[0])
+
+	// Time to come is not counted
+	partial := figuresMap(tallyReport(t, results, from, to, outage, false))
+	assert.Equal(t, until[0], partial[0])
+	assert.Equal(t, slaFigures{}, partial[1])
+
+	// Without results there is nothing to report on
+	empty := tallyReport(t, nil, from, to, later, false)
+	assert.Nil(t, empty[0].UptimePercent)
+	assert.Equal(t, 1440.0, empty[0].UnmonitoredMinutes)
+	zero := 0.0
+	assert.Equal(t, &zero, tallyReport(t, nil, from, to, later, true)[0].UptimePercent)
+}
+
+// figuresMap returns the figures of buckets
+func figuresMap(buckets []SLABucket) []slaFigures {
+	figures := make([]slaFigures, 0, len(buckets))
+	for _, bucket := range buckets {
+		figures = append(figures, figuresOf(bucket))
+	}
+	return figures
+}
+
+func TestSLAEndpoints(t *testing.T) {
+	monitor, db, router := startBindingTest(t)
+	for _, id := range []string{"api-http", "api-tcp", "web"} {
+		tags := []string{"api"}
+		if id == "web" {
+			tags = []string{"web"}
+		}
+		monitor.probes[id] = &ProbeConfig{ID: id, Name: id, Type: "http", Interval: time.Minute, Enabled: true, Tags: tags}
+	}
+
+	// The first day is held in memory and the second stored, for one probe,
+	// while the other always succeeded
+	first, second := slaDays("api-http")
+	for _, result := range first {
+		monitor.results[result.ID] = result
+	}
+	records := make([]*database.ProbeResult, 0, len(second))
+	for _, result := range append(second,
+		slaTimeline("api-tcp", slaDay, strings.Repeat("s", 1440))...) {
+		records = append(records, resultRecord(result))
+	}
+	require.NoError(t, db.ProbeResultRepository().InsertBatch(records))
+	for _, result := range slaTimeline("api-tcp", slaDay.AddDate(0, 0, 1), strings.Repeat("s", 1440)) {
+		monitor.results[result.ID] = result
+	}
+
+	get := func(path string) (int, []byte) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code, w.Body.Bytes()
+	}
+
+	code, body := get("/api/v1/results/api-http/sla?from=2026-03-01&to=2026-03-03")
+	require.Equal(t, http.StatusOK, code, string(body))
+	var report SLAReport
+	require.NoError(t, json.Unmarshal(body, &report))
+	assert.Equal(t, "api-http", report.ProbeID)
+	assert.Equal(t, GranularityDay, report.Granularity)
+	assert.Equal(t, 99.0, report.Percentile)
+	require.Len(t, report.Buckets, 2)
+	assert.Equal(t, slaDay.AddDate(0, 0, 1), report.Buckets[1].Start)
+	assert.Equal(t, slaFigures{uptime: 99.456, up: 2741, down: 15, unmonitored: 124, incidents: 6, checks: 2755, mttr: 150, response: 100}, figuresOf(report.Summary))
+
+	// Reports over the past are the same every time
+	_, again := get("/api/v1/results/api-http/sla?from=2026-03-01&to=2026-03-03")
+	assert.Equal(t, string(body), string(again))
+
+	code, body = get("/api/v1/results/api-http/sla?from=2026-03-01&to=2026-03-03&granularity=month&count_unmonitored=true&percentile=50")
+	require.Equal(t, http.StatusOK, code, string(body))
+	report = SLAReport{}
+	require.NoError(t, json.Unmarshal(body, &report))
+	require.Len(t, report.Buckets, 1)
+	assert.True(t, report.CountUnmonitored)
+	assert.Equal(t, 139.0, report.Buckets[0].DowntimeMinutes)
+	assert.Equal(t, 100.0, *report.Buckets[0].ResponseTimeMs)
+
+	// The probes with a tag add up
+	code, body = get("/api/v1/health/sla?tag=api&from=2026-03-01&to=2026-03-03")
+	require.Equal(t, http.StatusOK, code, string(body))
+	report = SLAReport{}
+	require.NoError(t, json.Unmarshal(body, &report))
+	assert.Equal(t, "api", report.Tag)
+	assert.Equal(t, []string{"api-http", "api-tcp"}, report.Probes)
+	assert.Equal(t, slaFigures{uptime: 99.734, up: 5621, down: 15, unmonitored: 124, incidents: 6, checks: 5635, mttr: 150, response: 100}, figuresOf(report.Summary))
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/api/v1/results/missing/sla", http.StatusNotFound},
+		{"/api/v1/results/api-http/sla?granularity=year", http.StatusBadRequest},
+		{"/api/v1/results/api-http/sla?from=2026-03-03&to=2026-03-01", http.StatusBadRequest},
+		{"/api/v1/results/api-http/sla?from=yesterday", http.StatusBadRequest},
+		{"/api/v1/results/api-http/sla?percentile=0", http.StatusBadRequest},
+		{"/api/v1/health/sla", http.StatusBadRequest},
+		{"/api/v1/health/sla?tag=db", http.StatusNotFound},
+	} {
+		code, body := get(tc.path)
+		assert.Equal(t, tc.code, code, "%s: %s", tc.path, body)
+	}
+}
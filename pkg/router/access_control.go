@@ -0,0 +1,202 @@
+package router
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// accessPolicy is a validated access control policy ready to enforce
+type accessPolicy struct {
+	allow        []*net.IPNet
+	deny         []*net.IPNet
+	username     string
+	passwordHash []byte
+
+	// Digest of the last password that matched the hash, so that bcrypt
+	// does not run for every request of an authenticated client
+	verified atomic.Pointer[[sha256.Size]byte]
+}
+
+// compileAccessControl validates an access control policy, returning nil
+// when there is none
+func compileAccessControl(ac *config.AccessControlConfig) (*accessPolicy, error) {
+	if ac == nil {
+		return nil, nil
+	}
+
+	allow, err := parseNetworks(ac.AllowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow_cidrs: %w", err)
+	}
+	deny, err := parseNetworks(ac.DenyCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny_cidrs: %w", err)
+	}
+	policy := &accessPolicy{allow: allow, deny: deny}
+
+	if ac.BasicAuth != nil {
+		if ac.BasicAuth.Username == "" {
+			return nil, fmt.Errorf("basic_auth username is required")
+		}
+		if _, err := bcrypt.Cost([]byte(ac.BasicAuth.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("basic_auth password_hash must be a bcrypt hash")
+		}
+		policy.username = ac.BasicAuth.Username
+		policy.passwordHash = []byte(ac.BasicAuth.PasswordHash)
+	}
+
+	return policy, nil
+}
+
+// parseNetworks parses addresses and CIDRs, an address standing for itself
+// alone
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(networks))
+	for _, network := range networks {
+		if _, ipNet, err := net.ParseCIDR(network); err == nil {
+			nets = append(nets, ipNet)
+			continue
+		}
+		ip := net.ParseIP(network)
+		if ip == nil {
+			return nil, fmt.Errorf("not an IP address or CIDR: %s", network)
+		}
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+// containsIP reports whether any of the networks contains an address
+func containsIP(nets []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether a client address may reach the route. Denied
+// networks win over allowed ones, and every address is allowed when no
+// allowed networks are set.
+func (p *accessPolicy) allowed(address string) bool {
+	if containsIP(p.deny, address) {
+		return false
+	}
+	return len(p.allow) == 0 || containsIP(p.allow, address)
+}
+
+// authenticated reports whether a request carries the basic auth credential
+// of the route, or whether the route requires none
+func (p *accessPolicy) authenticated(req *http.Request) bool {
+	if p.passwordHash == nil {
+		return true
+	}
+
+	username, password, ok := req.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(username), []byte(p.username)) != 1 {
+		return false
+	}
+
+	digest := sha256.Sum256([]byte(password))
+	if verified := p.verified.Load(); verified != nil && subtle.ConstantTimeCompare(digest[:], verified[:]) == 1 {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(p.passwordHash, []byte(password)) != nil {
+		return false
+	}
+	p.verified.Store(&digest)
+	return true
+}
+
+// SetTrustedProxies sets the addresses or CIDRs whose X-Forwarded-For header
+// names the client. Call it before serving.
+func (r *Router) SetTrustedProxies(proxies []string) error {
+	trusted, err := parseNetworks(proxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+	r.trusted = trusted
+	return nil
+}
+
+// SetDefaultAccessControl sets the policy of routes without their own access
+// control. Call it before serving.
+func (r *Router) SetDefaultAccessControl(ac config.AccessControlConfig) error {
+	policy, err := compileAccessControl(&ac)
+	if err != nil {
+		return err
+	}
+	r.defaultAccess = policy
+	return nil
+}
+
+// ClientIP returns the address of the client that sent a request. Behind
+// trusted proxies it is the last address in X-Forwarded-For that is not a
+// trusted proxy itself.
+func (r *Router) ClientIP(req *http.Request) string {
+	ip := clientIP(req)
+	if !containsIP(r.trusted, ip) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, value := range req.Header.Values("X-Forwarded-For") {
+		for _, address := range strings.Split(value, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				forwarded = append(forwarded, address)
+			}
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip = forwarded[i]
+		if !containsIP(r.trusted, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// authorize enforces the access control of a route, answering 403 to
+// clients outside its networks and 401 to those without its credential. The
+// gate's credential is removed from authorized requests, so that it does not
+// reach the upstream.
+func (r *Router) authorize(w http.ResponseWriter, req *http.Request, routeID string, policy *accessPolicy) bool {
+	if policy == nil {
+		policy = r.defaultAccess
+	}
+	if policy == nil {
+		return true
+	}
+
+	if !policy.allowed(r.ClientIP(req)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	if !policy.authenticated(req) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", routeID))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if policy.passwordHash != nil {
+		req.Header.Del("Authorization")
+	}
+	return true
+}
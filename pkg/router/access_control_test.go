@@ -0,0 +1,176 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+func TestAccessPolicyNetworks(t *testing.T) {
+	tests := []struct {
+		name   string
+		allow  []string
+		deny   []string
+		client string
+		want   bool
+	}{
+		{name: "no rules", client: "203.0.113.5", want: true},
+		{name: "allowed IPv4 network", allow: []string{"10.0.0.0/8"}, client: "10.20.30.40", want: true},
+		{name: "outside allowed IPv4 network", allow: []string{"10.0.0.0/8"}, client: "11.0.0.1", want: false},
+		{name: "allowed single address", allow: []string{"192.0.2.10"}, client: "192.0.2.10", want: true},
+		{name: "next to allowed single address", allow: []string{"192.0.2.10"}, client: "192.0.2.11", want: false},
+		{name: "allowed IPv6 network", allow: []string{"2001:db8::/32"}, client: "2001:db8:1::7", want: true},
+		{name: "outside allowed IPv6 network", allow: []string{"2001:db8::/32"}, client: "2001:db9::1", want: false},
+		{name: "IPv4-mapped IPv6 client", allow: []string{"10.0.0.0/8"}, client: "::ffff:10.1.1.1", want: true},
+		{name: "IPv4 client of IPv6 allow list", allow: []string{"2001:db8::/32"}, client: "10.1.1.1", want: false},
+		{name: "denied IPv4 network", deny: []string{"198.51.100.0/24"}, client: "198.51.100.9", want: false},
+		{name: "outside denied network", deny: []string{"198.51.100.0/24"}, client: "198.51.101.9", want: true},
+		{name: "denied IPv6 address", deny: []string{"2001:db8::1"}, client: "2001:db8::1", want: false},
+		{name: "deny wins over allow", allow: []string{"10.0.0.0/8"}, deny: []string{"10.0.0.0/16"}, client: "10.0.3.4", want: false},
+		{name: "allowed outside the denied part", allow: []string{"10.0.0.0/8"}, deny: []string{"10.0.0.0/16"}, client: "10.1.3.4", want: true},
+		{name: "unparseable client", allow: []string{"10.0.0.0/8"}, client: "unknown", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := compileAccessControl(&config.AccessControlConfig{AllowCIDRs: tt.allow, DenyCIDRs: tt.deny})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, policy.allowed(tt.client))
+		})
+	}
+}
+
+func TestCompileAccessControl(t *testing.T) {
+	tests := []struct {
+		name string
+		ac   config.AccessControlConfig
+	}{
+		{name: "malformed allowed network", ac: config.AccessControlConfig{AllowCIDRs: []string{"10.0.0.0/8", "intranet"}}},
+		{name: "prefix too long", ac: config.AccessControlConfig{DenyCIDRs: []string{"10.0.0.0/40"}}},
+		{name: "basic auth without username", ac: config.AccessControlConfig{BasicAuth: &config.BasicAuthConfig{PasswordHash: hashPassword(t, "secret")}}},
+		{name: "basic auth with plain password", ac: config.AccessControlConfig{BasicAuth: &config.BasicAuthConfig{Username: "ops", PasswordHash: "secret"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileAccessControl(&tt.ac)
+			assert.Error(t, err)
+		})
+	}
+
+	// Invalid policies are rejected when routes are added
+	router := NewRouter(&config.Config{})
+	err := router.AddRoute(&Route{ID: "admin", PathPrefix: "/", Upstream: "http://127.0.0.1:1", AccessControl: &tests[0].ac})
+	assert.Error(t, err)
+}
+
+func hashPassword(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+	return string(hash)
+}
+
+func TestAccessControlBasicAuth(t *testing.T) {
+	// The upstream reports the credential it received, if any
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("admin" + req.Header.Get("Authorization")))
+	}))
+	t.Cleanup(backend.Close)
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{
+		ID:         "admin",
+		PathPrefix: "/admin",
+		Upstream:   backend.URL,
+		AccessControl: &config.AccessControlConfig{
+			AllowCIDRs: []string{"192.0.2.0/24"},
+			BasicAuth:  &config.BasicAuthConfig{Username: "ops", PasswordHash: hashPassword(t, "s3cret")},
+		},
+	}))
+
+	tests := []struct {
+		name     string
+		client   string
+		username string
+		password string
+		want     int
+	}{
+		{name: "valid credential", client: "192.0.2.1", username: "ops", password: "s3cret", want: http.StatusOK},
+		{name: "valid credential again", client: "192.0.2.1", username: "ops", password: "s3cret", want: http.StatusOK},
+		{name: "no credential", client: "192.0.2.1", want: http.StatusUnauthorized},
+		{name: "wrong password", client: "192.0.2.1", username: "ops", password: "secret", want: http.StatusUnauthorized},
+		{name: "wrong username", client: "192.0.2.1", username: "root", password: "s3cret", want: http.StatusUnauthorized},
+		{name: "network checked before credential", client: "203.0.113.1", username: "ops", password: "s3cret", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			req.RemoteAddr = tt.client + ":40000"
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+			switch tt.want {
+			case http.StatusOK:
+				assert.Equal(t, "admin", w.Body.String(), "the gate's credential does not reach the upstream")
+			case http.StatusUnauthorized:
+				assert.Equal(t, `Basic realm="admin", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAccessControlPolicies(t *testing.T) {
+	backend := newNamedBackend(t, "ok")
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.SetTrustedProxies([]string{"10.0.0.1"}))
+	require.NoError(t, router.SetDefaultAccessControl(config.AccessControlConfig{DenyCIDRs: []string{"198.51.100.0/24"}}))
+	require.NoError(t, router.AddRoute(&Route{ID: "app", PathPrefix: "/app", Upstream: backend.URL}))
+	require.NoError(t, router.AddRoute(&Route{ID: "open", PathPrefix: "/open", Upstream: backend.URL, AccessControl: &config.AccessControlConfig{}}))
+
+	serve := func(path, remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// The default policy applies to routes without their own
+	assert.Equal(t, http.StatusForbidden, serve("/app", "198.51.100.7:40000", ""))
+	assert.Equal(t, http.StatusOK, serve("/app", "203.0.113.7:40000", ""))
+	assert.Equal(t, http.StatusOK, serve("/open", "198.51.100.7:40000", ""), "a route policy replaces the default")
+
+	// Behind a trusted proxy the forwarded client is checked, otherwise the
+	// header is ignored
+	assert.Equal(t, http.StatusForbidden, serve("/app", "10.0.0.1:40000", "198.51.100.7"))
+	assert.Equal(t, http.StatusOK, serve("/app", "10.0.0.1:40000", "203.0.113.7"))
+	assert.Equal(t, http.StatusOK, serve("/app", "203.0.113.7:40000", "198.51.100.7"))
+	assert.Equal(t, http.StatusForbidden, serve("/app", "198.51.100.7:40000", "203.0.113.7"))
+
+	// Updating a route takes effect for the next request
+	require.NoError(t, router.UpdateRoute(&Route{
+		ID:            "app",
+		PathPrefix:    "/app",
+		Upstream:      backend.URL,
+		AccessControl: &config.AccessControlConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+	}))
+	assert.Equal(t, http.StatusForbidden, serve("/app", "203.0.113.7:40000", ""))
+	assert.Equal(t, http.StatusOK, serve("/app", "192.0.2.7:40000", ""))
+
+	require.NoError(t, router.UpdateRoute(&Route{ID: "app", PathPrefix: "/app", Upstream: backend.URL}))
+	assert.Equal(t, http.StatusOK, serve("/app", "203.0.113.7:40000", ""))
+	assert.Equal(t, http.StatusForbidden, serve("/app", "198.51.100.7:40000", ""), "removing the route policy restores the default")
+}
@@ -0,0 +1,302 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// Access log formats
+const (
+	AccessLogJSON     = "json"
+	AccessLogCombined = "combined"
+)
+
+// Access log defaults used when the gate config leaves them unset
+const (
+	defaultAccessLogMaxSize    = 100 << 20
+	defaultAccessLogMaxFiles   = 5
+	defaultAccessLogBufferSize = 4096
+)
+
+// accessLogBatchSize is how many bytes of entries are written at once when
+// requests arrive faster than they are written
+const accessLogBatchSize = 64 << 10
+
+// AccessLogEntry is one request served by the gate. Route is empty for
+// requests no route matched, Upstream for those not proxied.
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	ClientIP   string    `json:"client_ip"`
+	Host       string    `json:"host"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Route      string    `json:"route,omitempty"`
+	Upstream   string    `json:"upstream,omitempty"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMS float64   `json:"duration_ms"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// AccessLogger writes access log entries in the background so that logging
+// never blocks the request being logged. Entries are dropped when the
+// writer falls behind.
+type AccessLogger struct {
+	format   string
+	out      io.WriteCloser
+	sample   map[string]int
+	counters map[string]*atomic.Uint64
+
+	entries chan *AccessLogEntry
+	dropped atomic.Int64
+	mu      sync.RWMutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewAccessLogger creates an access logger writing to the configured file,
+// or to stdout when none is
+func NewAccessLogger(cfg config.AccessLogConfig) (*AccessLogger, error) {
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if cfg.File != "" {
+		maxSize := int64(cfg.MaxSizeMB) << 20
+		if maxSize <= 0 {
+			maxSize = defaultAccessLogMaxSize
+		}
+		maxFiles := cfg.MaxFiles
+		if maxFiles <= 0 {
+			maxFiles = defaultAccessLogMaxFiles
+		}
+		file, err := openRotatingFile(cfg.File, maxSize, maxFiles)
+		if err != nil {
+			return nil, err
+		}
+		out = file
+	}
+
+	return newAccessLogger(cfg, out), nil
+}
+
+// newAccessLogger creates an access logger writing to out
+func newAccessLogger(cfg config.AccessLogConfig, out io.WriteCloser) *AccessLogger {
+	bufferSize := cfg.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultAccessLogBufferSize
+	}
+	format := cfg.Format
+	if format == "" {
+		format = AccessLogJSON
+	}
+
+	// Counters are created up front so sampling needs no lock
+	counters := make(map[string]*atomic.Uint64, len(cfg.Sample))
+	for route := range cfg.Sample {
+		counters[route] = &atomic.Uint64{}
+	}
+
+	return &AccessLogger{
+		format:   format,
+		out:      out,
+		sample:   cfg.Sample,
+		counters: counters,
+		entries:  make(chan *AccessLogEntry, bufferSize),
+	}
+}
+
+// Start starts the background writer
+func (al *AccessLogger) Start() {
+	al.wg.Add(1)
+	go al.run()
+}
+
+// Stop stops accepting entries, waits for buffered entries to be written and
+// closes the log file
+func (al *AccessLogger) Stop() {
+	al.mu.Lock()
+	if !al.stopped {
+		al.stopped = true
+		close(al.entries)
+	}
+	al.mu.Unlock()
+
+	al.wg.Wait()
+}
+
+// Log queues an entry for writing. The entry is dropped if the buffer is
+// full or the logger has stopped.
+func (al *AccessLogger) Log(entry *AccessLogEntry) {
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+
+	if al.stopped {
+		al.dropped.Add(1)
+		return
+	}
+
+	select {
+	case al.entries <- entry:
+	default:
+		al.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many entries were dropped without being written
+func (al *AccessLogger) Dropped() int64 {
+	return al.dropped.Load()
+}
+
+// sampled reports whether a request of a route is logged: every request,
+// or 1 in N for routes sampled at N
+func (al *AccessLogger) sampled(routeID string) bool {
+	counter, ok := al.counters[routeID]
+	if !ok || al.sample[routeID] <= 1 {
+		return true
+	}
+	return (counter.Add(1)-1)%uint64(al.sample[routeID]) == 0
+}
+
+// run writes queued entries until the logger is stopped, batching entries
+// that arrive while earlier ones are written
+func (al *AccessLogger) run() {
+	defer al.wg.Done()
+	defer al.out.Close()
+
+	var batch bytes.Buffer
+	for entry := range al.entries {
+		al.formatEntry(&batch, entry)
+		if len(al.entries) > 0 && batch.Len() < accessLogBatchSize {
+			continue
+		}
+		if _, err := al.out.Write(batch.Bytes()); err != nil {
+			log.Printf("❌ Failed to write access log: %v", err)
+		}
+		batch.Reset()
+	}
+}
+
+// formatEntry appends an entry as one line in the configured format
+func (al *AccessLogger) formatEntry(buf *bytes.Buffer, entry *AccessLogEntry) {
+	if al.format != AccessLogCombined {
+		_ = json.NewEncoder(buf).Encode(entry)
+		return
+	}
+
+	// Apache combined format, followed by the route, upstream and duration
+	fmt.Fprintf(buf, "%s - - [%s] %s %d %d %s %s route=%s upstream=%s duration_ms=%s\n",
+		entry.ClientIP,
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(entry.Method+" "+entry.Path),
+		entry.Status,
+		entry.Bytes,
+		strconv.Quote(dashIfEmpty(entry.Referer)),
+		strconv.Quote(dashIfEmpty(entry.UserAgent)),
+		dashIfEmpty(entry.Route),
+		dashIfEmpty(entry.Upstream),
+		strconv.FormatFloat(entry.DurationMS, 'f', 3, 64),
+	)
+}
+
+func dashIfEmpty(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// nopCloser keeps stdout open when the logger stops
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// rotatingFile appends to a file, renaming it to <path>.1 once it reaches
+// maxSize and shifting older files up to <path>.<maxFiles>, beyond which they
+// are deleted. It is written by a single goroutine.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// openRotatingFile opens a rotating file for appending, creating its directory
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create access log directory: %w", err)
+	}
+	f := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the current file for appending
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat access log: %w", err)
+	}
+	f.file, f.size = file, stat.Size()
+	return nil
+}
+
+// Write appends p, rotating the file first if p would take it past maxSize
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate closes the current file, shifts the rotated files and starts a new one
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+
+	if err := os.Remove(f.rotatedPath(f.maxFiles)); err != nil && !os.IsNotExist(err) {
+		log.Printf("❌ Failed to delete access log %s: %v", f.rotatedPath(f.maxFiles), err)
+	}
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(f.rotatedPath(i), f.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			log.Printf("❌ Failed to rotate access log %s: %v", f.rotatedPath(i), err)
+		}
+	}
+	if err := os.Rename(f.path, f.rotatedPath(1)); err != nil {
+		log.Printf("❌ Failed to rotate access log %s: %v", f.path, err)
+	}
+	return f.open()
+}
+
+// rotatedPath returns the path of the nth most recently rotated file
+func (f *rotatingFile) rotatedPath(n int) string {
+	return f.path + "." + strconv.Itoa(n)
+}
+
+// Close closes the current file
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,201 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+)
+
+// readAccessLog reads the entries of a JSON access log file
+func readAccessLog(t *testing.T, path string) []AccessLogEntry {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var entries []AccessLogEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AccessLogEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry), scanner.Text())
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+	return entries
+}
+
+func TestAccessLog(t *testing.T) {
+	backend := newNamedBackend(t, "hello")
+	path := filepath.Join(t.TempDir(), "logs", "access.log")
+	accessLog, err := NewAccessLogger(config.AccessLogConfig{File: path})
+	require.NoError(t, err)
+	accessLog.Start()
+
+	router := NewRouter(&config.Config{})
+	router.SetAccessLogger(accessLog)
+	require.NoError(t, router.SetTrustedProxies([]string{"10.0.0.0/8"}))
+	require.NoError(t, router.AddRoute(&Route{ID: "app", Host: "app.example.com", PathPrefix: "/app", StripPrefix: true, Upstream: backend.URL}))
+
+	// Proxied through a trusted proxy, which names the client
+	req := httptest.NewRequest(http.MethodGet, "http://app.example.com/app/users", nil)
+	req.RemoteAddr = "10.1.2.3:40000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7, 10.9.9.9")
+	req.Header.Set("User-Agent", "curl/8.0")
+	req.Header.Set(logging.RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Matching no route
+	req = httptest.NewRequest(http.MethodPost, "http://other.example.com/missing", nil)
+	req.RemoteAddr = "203.0.113.5:40000"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	accessLog.Stop()
+	entries := readAccessLog(t, path)
+	require.Len(t, entries, 2)
+
+	proxied := entries[0]
+	assert.Equal(t, "req-1", proxied.RequestID)
+	assert.Equal(t, "198.51.100.7", proxied.ClientIP)
+	assert.Equal(t, "app.example.com", proxied.Host)
+	assert.Equal(t, http.MethodGet, proxied.Method)
+	assert.Equal(t, "/app/users", proxied.Path, "the path is logged as requested, before the prefix is stripped")
+	assert.Equal(t, "app", proxied.Route)
+	assert.Equal(t, backend.URL, proxied.Upstream)
+	assert.Equal(t, http.StatusOK, proxied.Status)
+	assert.Equal(t, int64(len("hello")), proxied.Bytes)
+	assert.Equal(t, "curl/8.0", proxied.UserAgent)
+	assert.Positive(t, proxied.DurationMS)
+	assert.WithinDuration(t, time.Now(), proxied.Time, time.Minute)
+
+	unrouted := entries[1]
+	assert.Equal(t, "203.0.113.5", unrouted.ClientIP, "X-Forwarded-For from untrusted clients is ignored")
+	assert.Equal(t, http.MethodPost, unrouted.Method)
+	assert.Equal(t, "/missing", unrouted.Path)
+	assert.Empty(t, unrouted.Route)
+	assert.Empty(t, unrouted.Upstream)
+	assert.Equal(t, http.StatusNotFound, unrouted.Status)
+	assert.Positive(t, unrouted.Bytes)
+	assert.NotEmpty(t, unrouted.RequestID)
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	backend := newNamedBackend(t, "ok")
+	path := filepath.Join(t.TempDir(), "access.log")
+	accessLog, err := NewAccessLogger(config.AccessLogConfig{File: path, Sample: map[string]int{"busy": 10}})
+	require.NoError(t, err)
+	accessLog.Start()
+
+	router := NewRouter(&config.Config{})
+	router.SetAccessLogger(accessLog)
+	require.NoError(t, router.AddRoute(&Route{ID: "busy", PathPrefix: "/busy", Upstream: backend.URL}))
+	require.NoError(t, router.AddRoute(&Route{ID: "quiet", PathPrefix: "/quiet", Upstream: backend.URL}))
+
+	for i := 0; i < 25; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/busy", nil))
+	}
+	for i := 0; i < 3; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/quiet", nil))
+	}
+	accessLog.Stop()
+
+	counts := map[string]int{}
+	for _, entry := range readAccessLog(t, path) {
+		counts[entry.Route]++
+	}
+	assert.Equal(t, map[string]int{"busy": 3, "quiet": 3}, counts)
+}
+
+func TestAccessLogDropsWhenFull(t *testing.T) {
+	// Without a running writer entries beyond the buffer are dropped
+	accessLog, err := NewAccessLogger(config.AccessLogConfig{File: filepath.Join(t.TempDir(), "access.log"), BufferSize: 2})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		accessLog.Log(&AccessLogEntry{Status: http.StatusOK})
+	}
+	assert.Equal(t, int64(3), accessLog.Dropped())
+
+	accessLog.Start()
+	accessLog.Stop()
+	accessLog.Log(&AccessLogEntry{Status: http.StatusOK})
+	assert.Equal(t, int64(4), accessLog.Dropped(), "entries logged after stopping are dropped")
+}
+
+func TestAccessLogCombinedFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	accessLog, err := NewAccessLogger(config.AccessLogConfig{File: path, Format: AccessLogCombined})
+	require.NoError(t, err)
+	accessLog.Start()
+	accessLog.Log(&AccessLogEntry{
+		Time:       time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC),
+		ClientIP:   "198.51.100.7",
+		Method:     http.MethodGet,
+		Path:       "/app",
+		Route:      "app",
+		Upstream:   "http://127.0.0.1:8081",
+		Status:     http.StatusOK,
+		Bytes:      512,
+		DurationMS: 1.5,
+		UserAgent:  "curl/8.0",
+	})
+	accessLog.Stop()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `198.51.100.7 - - [01/Mar/2026:12:30:00 +0000] "GET /app" 200 512 "-" "curl/8.0" route=app upstream=http://127.0.0.1:8081 duration_ms=1.500`+"\n", string(data))
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	file, err := openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	// Each write of 6 bytes fills a file, so every write after the first rotates
+	for _, line := range []string{"aaaaa\n", "bbbbb\n", "ccccc\n", "ddddd\n"} {
+		_, err := file.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, file.Close())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"access.log", "access.log.1", "access.log.2"}, names, "only max_files rotated files are kept")
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		return strings.TrimSpace(string(data))
+	}
+	assert.Equal(t, "ddddd", read("access.log"))
+	assert.Equal(t, "ccccc", read("access.log.1"))
+	assert.Equal(t, "bbbbb", read("access.log.2"))
+
+	// Reopening appends to the current file
+	file, err = openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	_, err = file.Write([]byte("e\n"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.Equal(t, "ddddd\ne", read("access.log"))
+}
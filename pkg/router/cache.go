@@ -0,0 +1,409 @@
+package router
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+)
+
+// CacheHeader tells clients whether a response came from the gate's cache
+const CacheHeader = "X-Cache"
+
+// Values of CacheHeader
+const (
+	CacheHit  = "HIT"
+	CacheMiss = "MISS"
+)
+
+// Cache defaults used when the gate config leaves them unset
+const (
+	defaultCacheMaxSize  = 64 << 20
+	defaultCacheMaxEntry = 1 << 20
+)
+
+// defaultCacheContentTypes are the media types cached when a route does not
+// list its own. A type ending in /* matches every subtype.
+var defaultCacheContentTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/wasm",
+	"image/*",
+	"font/*",
+}
+
+// RouteCache caches the 200 responses to GET requests of a route for TTL, a
+// duration such as "5m". ContentTypes replaces the default list of cacheable
+// media types. Responses to requests with an Authorization or Cookie header
+// are only cached, and only served from the cache, when marked
+// Cache-Control: public.
+type RouteCache struct {
+	Enabled      bool     `json:"enabled"`
+	TTL          string   `json:"ttl"`
+	ContentTypes []string `json:"content_types,omitempty"`
+}
+
+// CacheMetrics counts the cache lookups of a route
+type CacheMetrics struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// cachePolicy is a validated RouteCache ready to apply
+type cachePolicy struct {
+	ttl          time.Duration
+	contentTypes []string
+}
+
+// compileCache validates a route cache, returning nil when caching is off
+func compileCache(c *RouteCache) (*cachePolicy, error) {
+	if c == nil || !c.Enabled {
+		return nil, nil
+	}
+
+	ttl, err := time.ParseDuration(c.TTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid cache ttl: %q", c.TTL)
+	}
+
+	contentTypes := c.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = defaultCacheContentTypes
+	}
+	for _, contentType := range contentTypes {
+		if !strings.Contains(contentType, "/") {
+			return nil, fmt.Errorf("invalid cache content type: %q", contentType)
+		}
+	}
+
+	return &cachePolicy{ttl: ttl, contentTypes: contentTypes}, nil
+}
+
+// cacheableType reports whether responses of a content type may be cached
+func (p *cachePolicy) cacheableType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range p.contentTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// cachedResponse is a response stored in the cache
+type cachedResponse struct {
+	key      string
+	routeID  string
+	header   http.Header
+	body     []byte
+	storedAt time.Time
+	expires  time.Time
+	public   bool // may be served to requests with credentials
+}
+
+// size approximates the memory held by a cached response
+func (e *cachedResponse) size() int64 {
+	size := int64(len(e.key) + len(e.body))
+	for name, values := range e.header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
+
+// responseCache is a memory-bounded LRU of responses shared by every route
+type responseCache struct {
+	maxSize  int64
+	maxEntry int64
+	now      func() time.Time
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// newResponseCache creates a cache bounded as configured
+func newResponseCache(cfg config.GateCacheConfig) *responseCache {
+	maxSize := int64(cfg.MaxSizeMB) << 20
+	if maxSize <= 0 {
+		maxSize = defaultCacheMaxSize
+	}
+	maxEntry := int64(cfg.MaxEntryKB) << 10
+	if maxEntry <= 0 {
+		maxEntry = defaultCacheMaxEntry
+	}
+	return &responseCache{
+		maxSize:  maxSize,
+		maxEntry: min(maxEntry, maxSize),
+		now:      time.Now,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the fresh response stored under key, dropping it once expired
+func (c *responseCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*cachedResponse)
+	if !c.now().Before(entry.expires) {
+		c.remove(element)
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return entry
+}
+
+// put stores a response, evicting the least recently used ones to make room
+func (c *responseCache) put(entry *cachedResponse) {
+	size := entry.size()
+	if size > c.maxEntry {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[entry.key]; ok {
+		c.remove(element)
+	}
+	for c.size+size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += size
+}
+
+// purge removes the responses of a route, returning how many there were
+func (c *responseCache) purge(routeID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*cachedResponse).routeID == routeID {
+			c.remove(element)
+			purged++
+		}
+		element = next
+	}
+	return purged
+}
+
+// stats returns the number of cached responses and their size
+func (c *responseCache) stats() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len(), c.size
+}
+
+// remove drops an element; the caller holds the lock
+func (c *responseCache) remove(element *list.Element) {
+	entry := element.Value.(*cachedResponse)
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	c.size -= entry.size()
+}
+
+// cacheKey identifies the response to a request of a route by host, path and
+// query
+func cacheKey(routeID string, req *http.Request) string {
+	return routeID + "\x00" + req.Host + req.URL.RequestURI()
+}
+
+// cacheBypassed reports whether a request must not be answered from or
+// stored in the cache
+func cacheBypassed(req *http.Request) bool {
+	return req.Method != http.MethodGet || hasDirective(req.Header, "no-store")
+}
+
+// credentialed reports whether a request carries credentials, so that its
+// response may be personal to the client sending them
+func credentialed(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+}
+
+// hasDirective reports whether a Cache-Control header holds a directive
+func hasDirective(header http.Header, directive string) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, d := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if strings.EqualFold(name, directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acceptsEncoding reports whether a request accepts a content encoding
+func acceptsEncoding(req *http.Request, encoding string) bool {
+	if encoding == "" || strings.EqualFold(encoding, "identity") {
+		return true
+	}
+	for _, value := range req.Header.Values("Accept-Encoding") {
+		for _, accepted := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(accepted), ";")
+			if (strings.EqualFold(name, encoding) || name == "*") && strings.ReplaceAll(params, " ", "") != "q=0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveCached answers a request from the cache, reporting false on a miss.
+// A cached compressed response is a miss for clients that cannot decode it,
+// and one not marked public is a miss for requests with credentials.
+func (r *Router) serveCached(w http.ResponseWriter, req *http.Request, routeID, key string) bool {
+	entry := r.cache.get(key)
+	if entry == nil || (credentialed(req) && !entry.public) || !acceptsEncoding(req, entry.header.Get("Content-Encoding")) {
+		r.recordCache(routeID, false)
+		return false
+	}
+	r.recordCache(routeID, true)
+
+	header := w.Header()
+	for name, values := range entry.header {
+		header[name] = values
+	}
+	header.Set("Age", strconv.Itoa(int(r.cache.now().Sub(entry.storedAt).Seconds())))
+	header.Set(CacheHeader, CacheHit)
+	w.WriteHeader(http.StatusOK)
+	w.Write(entry.body)
+	return true
+}
+
+// cacheResponse stores a recorded upstream response to req if it may be
+// reused. Responses to requests with credentials must be marked public.
+func (r *Router) cacheResponse(req *http.Request, routeID, key string, policy *cachePolicy, recorder *cacheRecorder) {
+	header := recorder.Header()
+	public := hasDirective(header, "public")
+	switch {
+	case recorder.status != http.StatusOK || recorder.overflow:
+		return
+	case hasDirective(header, "no-store") || hasDirective(header, "private"):
+		return
+	case credentialed(req) && !public:
+		return
+	case header.Get("Set-Cookie") != "":
+		return
+	case !policy.cacheableType(header.Get("Content-Type")):
+		return
+	}
+	for _, vary := range header.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			if !strings.EqualFold(strings.TrimSpace(name), "Accept-Encoding") {
+				return
+			}
+		}
+	}
+
+	stored := header.Clone()
+	stored.Del(CacheHeader)
+	stored.Del("Age")
+	stored.Del(logging.RequestIDHeader)
+
+	now := r.cache.now()
+	r.cache.put(&cachedResponse{
+		key:      key,
+		routeID:  routeID,
+		header:   stored,
+		body:     recorder.body.Bytes(),
+		storedAt: now,
+		expires:  now.Add(policy.ttl),
+		public:   public,
+	})
+}
+
+// PurgeCache removes the cached responses of a route, returning how many
+// there were
+func (r *Router) PurgeCache(routeID string) (int, error) {
+	if _, err := r.GetRoute(routeID); err != nil {
+		return 0, err
+	}
+	return r.cache.purge(routeID), nil
+}
+
+// recordCache counts a cache lookup of a route
+func (r *Router) recordCache(routeID string, hit bool) {
+	r.metrics.mu.Lock()
+	defer r.metrics.mu.Unlock()
+
+	m, ok := r.metrics.Cache[routeID]
+	if !ok {
+		m = &CacheMetrics{}
+		r.metrics.Cache[routeID] = m
+	}
+	if hit {
+		m.Hits++
+	} else {
+		m.Misses++
+	}
+}
+
+// cacheRecorder copies the body of a response while it is written, giving up
+// once it grows past the largest cacheable response
+type cacheRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (w *cacheRecorder) WriteHeader(status int) {
+	// Informational responses precede the final status
+	if w.status == 0 && status >= http.StatusOK {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.overflow {
+		if int64(w.body.Len()+len(p)) > w.limit {
+			w.overflow = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *cacheRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,294 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// newCountingBackend starts an upstream answering with the request URI and
+// the given headers, counting the requests it receives
+func newCountingBackend(t *testing.T, header http.Header) (*httptest.Server, *atomic.Int64) {
+	var count atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		count.Add(1)
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		w.Write([]byte(req.URL.RequestURI()))
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+// fakeClock replaces the clock of a router's cache
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newCachingRouter(t *testing.T, upstream string, cache *RouteCache) (*Router, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	router := NewRouter(&config.Config{})
+	router.cache.now = clock.Now
+	require.NoError(t, router.AddRoute(&Route{ID: "assets", PathPrefix: "/assets", StripPrefix: true, Upstream: upstream, Cache: cache}))
+	return router, clock
+}
+
+func serveGet(router *Router, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCacheTTL(t *testing.T) {
+	backend, count := newCountingBackend(t, nil)
+	router, clock := newCachingRouter(t, backend.URL, &RouteCache{Enabled: true, TTL: "1m"})
+
+	w := serveGet(router, "/assets/app.js?v=1", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, CacheMiss, w.Header().Get(CacheHeader))
+	assert.Equal(t, "/app.js?v=1", w.Body.String())
+
+	clock.now = clock.now.Add(30 * time.Second)
+	w = serveGet(router, "/assets/app.js?v=1", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, CacheHit, w.Header().Get(CacheHeader))
+	assert.Equal(t, "/app.js?v=1", w.Body.String())
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "30", w.Header().Get("Age"))
+	assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+	assert.Equal(t, int64(1), count.Load(), "hits do not reach the upstream")
+
+	// The query is part of the key
+	w = serveGet(router, "/assets/app.js?v=2", nil)
+	assert.Equal(t, CacheMiss, w.Header().Get(CacheHeader))
+	assert.Equal(t, int64(2), count.Load())
+
+	// Expired responses are fetched again
+	clock.now = clock.now.Add(31 * time.Second)
+	w = serveGet(router, "/assets/app.js?v=1", nil)
+	assert.Equal(t, CacheMiss, w.Header().Get(CacheHeader))
+	assert.Equal(t, int64(3), count.Load())
+	w = serveGet(router, "/assets/app.js?v=1", nil)
+	assert.Equal(t, CacheHit, w.Header().Get(CacheHeader))
+	assert.Equal(t, int64(3), count.Load())
+
+	metrics := router.GetMetrics().Cache["assets"]
+	require.NotNil(t, metrics)
+	assert.Equal(t, int64(2), metrics.Hits)
+	assert.Equal(t, int64(3), metrics.Misses)
+	assert.InDelta(t, 0.4, metrics.HitRatio, 1e-9)
+}
+
+func TestCacheBypass(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  http.Header
+		request http.Header
+		method  string
+		want    string
+	}{
+		{name: "no-store response", header: http.Header{"Cache-Control": {"public, no-store"}}, want: CacheMiss},
+		{name: "private response", header: http.Header{"Cache-Control": {"private, max-age=60"}}, want: CacheMiss},
+		{name: "response setting a cookie", header: http.Header{"Set-Cookie": {"session=1"}}, want: CacheMiss},
+		{name: "content type outside the allowlist", header: http.Header{"Content-Type": {"application/octet-stream"}}, want: CacheMiss},
+		{name: "response varying by cookie", header: http.Header{"Vary": {"Accept-Encoding, Cookie"}}, want: CacheMiss},
+		{name: "no-store request", request: http.Header{"Cache-Control": {"no-store"}}},
+		{name: "POST request", method: http.MethodPost},
+		{name: "cacheable response", header: http.Header{"Vary": {"Accept-Encoding"}, "Cache-Control": {"max-age=60"}}, want: CacheHit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, count := newCountingBackend(t, tt.header)
+			router, _ := newCachingRouter(t, backend.URL, &RouteCache{Enabled: true, TTL: "1m"})
+
+			var w *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/assets/index.html", nil)
+				if tt.method != "" {
+					req.Method = tt.method
+				}
+				for name, values := range tt.request {
+					req.Header[name] = values
+				}
+				w = httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				require.Equal(t, http.StatusOK, w.Code)
+			}
+
+			assert.Equal(t, tt.want, w.Header().Get(CacheHeader))
+			if tt.want == CacheHit {
+				assert.Equal(t, int64(1), count.Load())
+			} else {
+				assert.Equal(t, int64(2), count.Load())
+			}
+		})
+	}
+}
+
+func TestCacheCredentials(t *testing.T) {
+	// The upstream answers each client with its own data
+	var count atomic.Int64
+	var public atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		count.Add(1)
+		if public.Load() {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("account of " + req.Header.Get("Authorization") + req.Header.Get("Cookie")))
+	}))
+	t.Cleanup(backend.Close)
+	router, _ := newCachingRouter(t, backend.URL, &RouteCache{Enabled: true, TTL: "1m"})
+
+	alice := http.Header{"Authorization": {"Bearer alice"}}
+	bob := http.Header{"Authorization": {"Bearer bob"}}
+	w := serveGet(router, "/assets/account.json", alice)
+	assert.Equal(t, "account of Bearer alice", w.Body.String())
+	w = serveGet(router, "/assets/account.json", bob)
+	assert.Equal(t, CacheMiss, w.Header().Get(CacheHeader))
+	assert.Equal(t, "account of Bearer bob", w.Body.String(), "one client's response is not served to another")
+	w = serveGet(router, "/assets/account.json", http.Header{"Cookie": {"session=carol"}})
+	assert.Equal(t, "account of session=carol", w.Body.String())
+	w = serveGet(router, "/assets/account.json", nil)
+	assert.Equal(t, CacheMiss, w.Header().Get(CacheHeader))
+	assert.Equal(t, int64(4), count.Load())
+
+	// Anonymous responses are not served to requests with credentials
+	w = serveGet(router, "/assets/account.json", alice)
+	assert.Equal(t, CacheMiss, w.Header().Get(CacheHeader))
+	assert.Equal(t, "account of Bearer alice", w.Body.String())
+	assert.Equal(t, CacheHit, serveGet(router, "/assets/account.json", nil).Header().Get(CacheHeader))
+
+	// Responses the upstream marks public are shared
+	public.Store(true)
+	serveGet(router, "/assets/logo.svg", alice)
+	w = serveGet(router, "/assets/logo.svg", bob)
+	assert.Equal(t, CacheHit, w.Header().Get(CacheHeader))
+	assert.Equal(t, "account of Bearer alice", w.Body.String())
+	assert.Equal(t, int64(6), count.Load())
+}
+
+func TestCacheContentEncoding(t *testing.T) {
+	backend, count := newCountingBackend(t, http.Header{"Content-Encoding": {"gzip"}, "Vary": {"Accept-Encoding"}})
+	router, _ := newCachingRouter(t, backend.URL, &RouteCache{Enabled: true, TTL: "1m"})
+	gzip := http.Header{"Accept-Encoding": {"gzip, br"}}
+
+	assert.Equal(t, CacheMiss, serveGet(router, "/assets/app.css", gzip).Header().Get(CacheHeader))
+	assert.Equal(t, CacheHit, serveGet(router, "/assets/app.css", gzip).Header().Get(CacheHeader))
+	assert.Equal(t, CacheMiss, serveGet(router, "/assets/app.css", nil).Header().Get(CacheHeader), "compressed responses are not served to clients that cannot decode them")
+	assert.Equal(t, int64(2), count.Load())
+}
+
+func TestCacheContentTypes(t *testing.T) {
+	policy, err := compileCache(&RouteCache{Enabled: true, TTL: "10s", ContentTypes: []string{"image/*", "application/json"}})
+	require.NoError(t, err)
+	assert.True(t, policy.cacheableType("image/png"))
+	assert.True(t, policy.cacheableType("application/json; charset=utf-8"))
+	assert.False(t, policy.cacheableType("text/html"))
+	assert.False(t, policy.cacheableType(""))
+
+	for _, cache := range []*RouteCache{
+		{Enabled: true},
+		{Enabled: true, TTL: "soon"},
+		{Enabled: true, TTL: "-1s"},
+		{Enabled: true, TTL: "1m", ContentTypes: []string{"html"}},
+	} {
+		_, err := compileCache(cache)
+		assert.Error(t, err, "%+v", cache)
+	}
+
+	policy, err = compileCache(&RouteCache{TTL: "soon"})
+	assert.NoError(t, err)
+	assert.Nil(t, policy, "disabled caches are not validated")
+}
+
+func TestCacheLRUEviction(t *testing.T) {
+	cache := newResponseCache(config.GateCacheConfig{})
+	cache.maxSize = 300
+	cache.maxEntry = 200
+	expires := cache.now().Add(time.Hour)
+	entry := func(key string, size int) *cachedResponse {
+		return &cachedResponse{key: key, routeID: "assets", header: http.Header{}, body: []byte(strings.Repeat("x", size-len(key))), expires: expires}
+	}
+
+	cache.put(entry("a", 100))
+	cache.put(entry("b", 100))
+	cache.put(entry("c", 100))
+	require.NotNil(t, cache.get("a"), "reading a makes b the least recently used")
+
+	cache.put(entry("d", 100))
+	assert.Nil(t, cache.get("b"))
+	assert.NotNil(t, cache.get("a"))
+	assert.NotNil(t, cache.get("c"))
+	assert.NotNil(t, cache.get("d"))
+
+	// Larger entries evict as many as needed
+	cache.put(entry("e", 200))
+	assert.Nil(t, cache.get("a"))
+	assert.Nil(t, cache.get("c"))
+	assert.NotNil(t, cache.get("d"))
+	assert.NotNil(t, cache.get("e"))
+	entries, size := cache.stats()
+	assert.Equal(t, 2, entries)
+	assert.Equal(t, int64(300), size)
+
+	// Entries over the limit are not stored at all
+	cache.put(entry("f", 201))
+	assert.Nil(t, cache.get("f"))
+	assert.NotNil(t, cache.get("d"))
+
+	// Replacing an entry does not count it twice
+	cache.put(entry("d", 100))
+	_, size = cache.stats()
+	assert.Equal(t, int64(300), size)
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	backend, count := newCountingBackend(t, nil)
+	router, _ := newCachingRouter(t, backend.URL, &RouteCache{Enabled: true, TTL: "1h"})
+
+	serveGet(router, "/assets/a.js", nil)
+	serveGet(router, "/assets/b.js", nil)
+	assert.Equal(t, CacheHit, serveGet(router, "/assets/a.js", nil).Header().Get(CacheHeader))
+
+	purged, err := router.PurgeCache("assets")
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Equal(t, CacheMiss, serveGet(router, "/assets/a.js", nil).Header().Get(CacheHeader))
+
+	_, err = router.PurgeCache("missing")
+	assert.ErrorIs(t, err, ErrRouteNotFound)
+
+	// Updating the route drops what was cached under the old rules
+	assert.Equal(t, CacheHit, serveGet(router, "/assets/a.js", nil).Header().Get(CacheHeader))
+	require.NoError(t, router.UpdateRoute(&Route{ID: "assets", PathPrefix: "/assets", StripPrefix: true, Upstream: backend.URL, Cache: &RouteCache{Enabled: true, TTL: "1h"}}))
+	assert.Equal(t, CacheMiss, serveGet(router, "/assets/a.js", nil).Header().Get(CacheHeader))
+
+	// And turning caching off proxies every request
+	require.NoError(t, router.UpdateRoute(&Route{ID: "assets", PathPrefix: "/assets", StripPrefix: true, Upstream: backend.URL}))
+	before := count.Load()
+	w := serveGet(router, "/assets/a.js", nil)
+	assert.Empty(t, w.Header().Get(CacheHeader))
+	assert.Equal(t, before+1, count.Load())
+	entries, _ := router.cache.stats()
+	assert.Zero(t, entries)
+}
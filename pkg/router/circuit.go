@@ -0,0 +1,212 @@
+package router
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// Circuit breaker states of an upstream. An open circuit answers requests
+// with 503 instead of dialing the upstream; once its cooldown passes, a
+// single trial request half-opens it and closes it again on success.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// Circuit breaker defaults used when the gate config leaves them unset
+const (
+	defaultCircuitFailures = 5
+	defaultCircuitWindow   = 30 * time.Second
+	defaultCircuitCooldown = 30 * time.Second
+)
+
+// CircuitState is the circuit breaker state of one upstream of a route
+type CircuitState struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"` // consecutive failures counted towards opening
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+	Opens     int64      `json:"opens"` // times the circuit opened
+}
+
+// circuitSettings are when circuits open and for how long
+type circuitSettings struct {
+	failures int           // consecutive failures that open a circuit
+	window   time.Duration // the failures must happen within this window
+	cooldown time.Duration // how long a circuit stays open
+}
+
+// newCircuitSettings applies the defaults to the gate's circuit breaker config
+func newCircuitSettings(cfg config.CircuitBreakerConfig) circuitSettings {
+	settings := circuitSettings{
+		failures: defaultCircuitFailures,
+		window:   defaultCircuitWindow,
+		cooldown: defaultCircuitCooldown,
+	}
+	if cfg.Failures > 0 {
+		settings.failures = cfg.Failures
+	}
+	if window, err := time.ParseDuration(cfg.Window); err == nil && window > 0 {
+		settings.window = window
+	}
+	if cooldown, err := time.ParseDuration(cfg.Cooldown); err == nil && cooldown > 0 {
+		settings.cooldown = cooldown
+	}
+	return settings
+}
+
+// circuitAllows reports whether a backend may be sent a request: its circuit
+// is closed, or open past its cooldown with no trial request in flight.
+// Callers hold p.mu.
+func (p *upstreamPool) circuitAllows(b *backend, now time.Time) bool {
+	switch b.circuit {
+	case CircuitOpen:
+		return !now.Before(b.openUntil)
+	case CircuitHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// startTrial half-opens the circuit of a backend picked while open, making
+// the request the circuit's trial. Callers hold p.mu.
+func (p *upstreamPool) startTrial(b *backend) {
+	if b.circuit == CircuitOpen {
+		b.circuit = CircuitHalfOpen
+	}
+}
+
+// recordCircuit feeds a request outcome into a backend's circuit, reporting
+// whether it opened or closed. Callers hold p.mu.
+func (p *upstreamPool) recordCircuit(b *backend, ok bool, now time.Time) (opened, closed bool) {
+	switch b.circuit {
+	case CircuitHalfOpen:
+		if ok {
+			b.circuit = CircuitClosed
+			b.circuitFailures = 0
+			return false, true
+		}
+		p.openCircuit(b, now)
+		return true, false
+	case CircuitOpen:
+		// Requests sent before the circuit opened
+		return false, false
+	}
+
+	if ok {
+		b.circuitFailures = 0
+		return false, false
+	}
+	if b.circuitFailures == 0 || now.Sub(b.circuitSince) > p.circuit.window {
+		b.circuitFailures = 0
+		b.circuitSince = now
+	}
+	b.circuitFailures++
+	if b.circuitFailures < p.circuit.failures {
+		return false, false
+	}
+	p.openCircuit(b, now)
+	return true, false
+}
+
+// openCircuit opens a backend's circuit for the cooldown. Callers hold p.mu.
+func (p *upstreamPool) openCircuit(b *backend, now time.Time) {
+	b.circuit = CircuitOpen
+	b.circuitFailures = 0
+	b.openUntil = now.Add(p.circuit.cooldown)
+	b.circuitOpens++
+}
+
+// abandonTrial reopens a half-open circuit whose trial request ended without
+// an outcome, such as the client going away, so that the next request is
+// tried instead
+func (p *upstreamPool) abandonTrial(b *backend, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if b.circuit == CircuitHalfOpen {
+		b.circuit = CircuitOpen
+		b.openUntil = now
+	}
+}
+
+// retryAfter returns how long until a backend with weight can be tried
+// again, or zero if the pool has no backend with weight. Callers hold p.mu.
+func (p *upstreamPool) retryAfter(now time.Time) time.Duration {
+	var wait time.Duration
+	for _, b := range p.backends {
+		if b.weight == 0 {
+			continue
+		}
+		remaining := max(b.openUntil.Sub(now), time.Second)
+		if wait == 0 || remaining < wait {
+			wait = remaining
+		}
+	}
+	return wait
+}
+
+// circuitStates returns the circuit state of each backend, keyed by URL
+func (p *upstreamPool) circuitStates() map[string]*CircuitState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	states := make(map[string]*CircuitState, len(p.backends))
+	for _, b := range p.backends {
+		state := &CircuitState{State: b.circuit, Failures: b.circuitFailures, Opens: b.circuitOpens}
+		if state.State == "" {
+			state.State = CircuitClosed
+		}
+		if b.circuit != CircuitClosed && b.circuit != "" {
+			openUntil := b.openUntil
+			state.OpenUntil = &openUntil
+		}
+		states[b.url] = state
+	}
+	return states
+}
+
+// CircuitStates returns the circuit breaker state of every upstream, keyed
+// by route ID and upstream URL
+func (r *Router) CircuitStates() map[string]map[string]*CircuitState {
+	r.mu.RLock()
+	pools := make(map[string]*upstreamPool, len(r.proxies))
+	for routeID, pool := range r.proxies {
+		pools[routeID] = pool
+	}
+	r.mu.RUnlock()
+
+	states := make(map[string]map[string]*CircuitState, len(pools))
+	for routeID, pool := range pools {
+		states[routeID] = pool.circuitStates()
+	}
+	return states
+}
+
+// circuitOpenPage describes a request of a route whose upstreams all have
+// open circuits, to be retried once the first closes
+func circuitOpenPage(routeID string, retryAfter time.Duration) *ErrorPage {
+	return &ErrorPage{
+		Code:          errorCircuitOpen,
+		Status:        http.StatusServiceUnavailable,
+		Message:       "The upstream keeps failing and is not tried until it recovers.",
+		Route:         routeID,
+		UpstreamState: upstreamCircuitOpen,
+		RetryAfter:    int(math.Ceil(retryAfter.Seconds())),
+	}
+}
+
+// logCircuit logs a circuit opening or closing
+func logCircuit(routeID, upstream string, opened, closed bool) {
+	switch {
+	case opened:
+		log.Printf("⚠️ Opened circuit of upstream %s of route %s", upstream, routeID)
+	case closed:
+		log.Printf("✅ Closed circuit of upstream %s of route %s", upstream, routeID)
+	}
+}
@@ -0,0 +1,183 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// toggleBackend starts a test upstream that fails with 502 while failing is
+// set, counting the requests it receives
+func toggleBackend(t *testing.T) (*httptest.Server, *atomic.Bool, *atomic.Int64) {
+	var failing atomic.Bool
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if failing.Load() {
+			http.Error(w, "upstream down", http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	t.Cleanup(server.Close)
+	return server, &failing, &hits
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	upstream, failing, hits := toggleBackend(t)
+
+	router := NewRouter(&config.Config{Gate: config.GateConfig{CircuitBreaker: config.CircuitBreakerConfig{
+		Failures: 3,
+		Window:   "1m",
+		Cooldown: "100ms",
+	}}})
+	require.NoError(t, router.AddRoute(&Route{ID: "api", Upstream: upstream.URL}))
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	state := func() *CircuitState {
+		return router.GetMetrics().Circuits["api"][upstream.URL]
+	}
+
+	assert.Equal(t, &CircuitState{State: CircuitClosed}, state())
+
+	// Consecutive failures open the circuit
+	failing.Store(true)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusBadGateway, serve().Code)
+	}
+	assert.Equal(t, CircuitOpen, state().State)
+	assert.Equal(t, int64(1), state().Opens)
+	require.NotNil(t, state().OpenUntil)
+
+	// An open circuit answers without dialing the upstream
+	w := serve()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, errorCircuitOpen, body["error"])
+	assert.Equal(t, "api", body["route"])
+	assert.Equal(t, float64(1), body["retry_after"])
+	assert.Equal(t, int64(3), hits.Load())
+
+	// After the cooldown a failed trial opens it again
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, http.StatusBadGateway, serve().Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serve().Code)
+	assert.Equal(t, int64(4), hits.Load())
+	assert.Equal(t, CircuitOpen, state().State)
+	assert.Equal(t, int64(2), state().Opens)
+
+	// And a successful one closes it
+	failing.Store(false)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, serve().Code)
+	assert.Equal(t, http.StatusOK, serve().Code)
+	assert.Equal(t, CircuitClosed, state().State)
+	assert.Nil(t, state().OpenUntil)
+}
+
+func TestCircuitBreakerHalfOpenTrial(t *testing.T) {
+	release := make(chan struct{})
+	var hits atomic.Int64
+	var failing atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if failing.Load() {
+			http.Error(w, "upstream down", http.StatusBadGateway)
+			return
+		}
+		<-release
+		fmt.Fprint(w, "ok")
+	}))
+	defer upstream.Close()
+
+	router := NewRouter(&config.Config{Gate: config.GateConfig{CircuitBreaker: config.CircuitBreakerConfig{
+		Failures: 2,
+		Cooldown: "50ms",
+	}}})
+	require.NoError(t, router.AddRoute(&Route{ID: "api", Upstream: upstream.URL}))
+
+	failing.Store(true)
+	for i := 0; i < 2; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	failing.Store(false)
+	time.Sleep(50 * time.Millisecond)
+
+	// Only one trial request is let through while half-open
+	trial := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		trial <- w.Code
+	}()
+	require.Eventually(t, func() bool { return hits.Load() == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, router.GetMetrics().Circuits["api"][upstream.URL].State)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, int64(3), hits.Load())
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-trial)
+	assert.Equal(t, CircuitClosed, router.GetMetrics().Circuits["api"][upstream.URL].State)
+}
+
+func TestCircuitBreakerIgnoresClientCancellation(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	router := NewRouter(&config.Config{Gate: config.GateConfig{CircuitBreaker: config.CircuitBreakerConfig{Failures: 1}}})
+	require.NoError(t, router.AddRoute(&Route{ID: "api", Upstream: upstream.URL}))
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	}
+
+	assert.Equal(t, CircuitClosed, router.GetMetrics().Circuits["api"][upstream.URL].State)
+	assert.Zero(t, router.GetMetrics().Upstreams["api"][upstream.URL].ErrorCount)
+}
+
+func TestCircuitFailureWindow(t *testing.T) {
+	pool := &upstreamPool{circuit: circuitSettings{failures: 2, window: time.Minute, cooldown: time.Minute}}
+	b := &backend{url: "http://upstream", weight: 1, pool: pool}
+	pool.backends = []*backend{b}
+	now := time.Now()
+
+	// Failures further apart than the window do not add up
+	pool.observe(b, false, now)
+	_, opened, _ := pool.observe(b, false, now.Add(2*time.Minute))
+	assert.False(t, opened)
+	_, opened, _ = pool.observe(b, false, now.Add(2*time.Minute+time.Second))
+	assert.True(t, opened)
+
+	// Nor do failures interrupted by a success
+	b.circuit = CircuitClosed
+	pool.observe(b, false, now)
+	pool.observe(b, true, now)
+	_, opened, _ = pool.observe(b, false, now)
+	assert.False(t, opened)
+}
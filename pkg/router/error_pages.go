@@ -0,0 +1,202 @@
+package router
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+)
+
+// Codes of the errors the gate answers itself, the error field of their
+// JSON responses
+const (
+	errorRouteNotFound       = "route_not_found"
+	errorUpstreamUnreachable = "upstream_unreachable"
+	errorUpstreamTimeout     = "upstream_timeout"
+	errorCircuitOpen         = "circuit_open"
+	errorNoUpstream          = "no_upstream_available"
+	errorInternal            = "internal_error"
+)
+
+// States of the upstream reported with an error
+const (
+	upstreamUnreachable = "unreachable"
+	upstreamTimeout     = "timeout"
+	upstreamCircuitOpen = "circuit_open"
+	upstreamUnavailable = "unavailable"
+)
+
+// errorPageFallback is the template serving statuses without their own
+const errorPageFallback = "error.html"
+
+//go:embed error_pages/error.html
+var errorPageFiles embed.FS
+
+// defaultErrorPage is the built-in error page
+var defaultErrorPage = template.Must(template.ParseFS(errorPageFiles, "error_pages/"+errorPageFallback))
+
+// ErrorPage describes an error the gate answers itself rather than the
+// upstream, such as no route matching the request or its upstream being
+// unreachable. It is the JSON body of the response, and the data of the
+// HTML templates of error pages.
+type ErrorPage struct {
+	Code          string `json:"error"`
+	Status        int    `json:"status"`
+	Message       string `json:"message"`
+	RequestID     string `json:"request_id"`
+	Route         string `json:"route,omitempty"`
+	UpstreamState string `json:"upstream_state,omitempty"`
+	RetryAfter    int    `json:"retry_after,omitempty"` // seconds
+}
+
+// Title returns the text of the page's status, such as "Bad Gateway"
+func (p *ErrorPage) Title() string {
+	return http.StatusText(p.Status)
+}
+
+// errorPages are the HTML templates of error pages by file name, such as
+// 502.html, falling back to error.html and then to the built-in page
+type errorPages map[string]*template.Template
+
+// LoadErrorPages serves error pages from the templates in dir, or from the
+// built-in page when dir is empty. Call it before serving.
+func (r *Router) LoadErrorPages(dir string) error {
+	if dir == "" {
+		r.errorPages = nil
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return fmt.Errorf("failed to list error pages: %w", err)
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("failed to read error pages: %w", err)
+		}
+	}
+
+	pages := make(errorPages, len(files))
+	for _, file := range files {
+		name := filepath.Base(file)
+		if name != errorPageFallback {
+			status, err := strconv.Atoi(strings.TrimSuffix(name, ".html"))
+			if err != nil || http.StatusText(status) == "" {
+				continue
+			}
+		}
+		page, err := template.ParseFiles(file)
+		if err != nil {
+			return fmt.Errorf("failed to parse error page %s: %w", name, err)
+		}
+		pages[name] = page
+	}
+	r.errorPages = pages
+	return nil
+}
+
+// template returns the template of the page of a status
+func (p errorPages) template(status int) *template.Template {
+	if page, ok := p[strconv.Itoa(status)+".html"]; ok {
+		return page
+	}
+	if page, ok := p[errorPageFallback]; ok {
+		return page
+	}
+	return defaultErrorPage
+}
+
+// writeError answers a request with an error of the gate: JSON when the
+// client prefers it, an HTML page otherwise. Both carry the request ID the
+// access log records.
+func (r *Router) writeError(w http.ResponseWriter, req *http.Request, page *ErrorPage) {
+	page.RequestID = logging.RequestID(req.Context())
+
+	header := w.Header()
+	header.Set("Cache-Control", "no-store")
+	header.Add("Vary", "Accept")
+	if page.RetryAfter > 0 {
+		header.Set("Retry-After", strconv.Itoa(page.RetryAfter))
+	}
+
+	if prefersJSON(req.Header.Get("Accept")) {
+		header.Set("Content-Type", "application/json")
+		w.WriteHeader(page.Status)
+		json.NewEncoder(w).Encode(page)
+		return
+	}
+
+	// Rendered before the status is sent, so that a broken template can
+	// still fall back to the built-in page
+	var body bytes.Buffer
+	if err := r.errorPages.template(page.Status).Execute(&body, page); err != nil {
+		log.Printf("⚠️ Failed to render error page %d, serving the built-in one: %v", page.Status, err)
+		body.Reset()
+		defaultErrorPage.Execute(&body, page)
+	}
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(page.Status)
+	w.Write(body.Bytes())
+}
+
+// proxyErrorPage describes a request the reverse proxy failed to get a
+// response to: a timeout, or else an unreachable upstream
+func proxyErrorPage(routeID string, err error) *ErrorPage {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &ErrorPage{
+			Code:          errorUpstreamTimeout,
+			Status:        http.StatusGatewayTimeout,
+			Message:       "The upstream did not respond in time.",
+			Route:         routeID,
+			UpstreamState: upstreamTimeout,
+		}
+	}
+	return &ErrorPage{
+		Code:          errorUpstreamUnreachable,
+		Status:        http.StatusBadGateway,
+		Message:       "The upstream could not be reached.",
+		Route:         routeID,
+		UpstreamState: upstreamUnreachable,
+	}
+}
+
+// prefersJSON reports whether an Accept header prefers JSON, named as
+// application/json or a +json type, to HTML. Neither is preferred through
+// wildcards, so browsers get HTML.
+func prefersJSON(accept string) bool {
+	jsonQuality, htmlQuality := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.EqualFold(strings.TrimSpace(key), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			jsonQuality = max(jsonQuality, quality)
+		case mediaType == "text/html":
+			htmlQuality = max(htmlQuality, quality)
+		}
+	}
+	return jsonQuality > 0 && jsonQuality >= htmlQuality
+}
@@ -0,0 +1,160 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+)
+
+// timeoutError is a network error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorPageNegotiation(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	accessLog, err := NewAccessLogger(config.AccessLogConfig{File: path})
+	require.NoError(t, err)
+	accessLog.Start()
+
+	router := NewRouter(&config.Config{})
+	router.SetAccessLogger(accessLog)
+	require.NoError(t, router.AddRoute(&Route{ID: "api", Host: "api.example.com", Upstream: down.URL}))
+
+	serve := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// API clients get JSON with the request ID the access log records
+	w := serve("http://api.example.com/users", "application/json")
+	require.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var page ErrorPage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, errorUpstreamUnreachable, page.Code)
+	assert.Equal(t, http.StatusBadGateway, page.Status)
+	assert.Equal(t, "api", page.Route)
+	assert.Equal(t, upstreamUnreachable, page.UpstreamState)
+	require.NotEmpty(t, page.RequestID)
+	assert.Equal(t, w.Header().Get(logging.RequestIDHeader), page.RequestID)
+
+	w = serve("http://other.example.com/", "application/problem+json")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	var unrouted ErrorPage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &unrouted))
+	assert.Equal(t, errorRouteNotFound, unrouted.Code)
+	assert.Empty(t, unrouted.Route)
+
+	// Browsers, and clients that accept anything, get the HTML page
+	for _, accept := range []string{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "*/*", "", "application/json;q=0.5, text/html"} {
+		w = serve("http://api.example.com/users", accept)
+		require.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"), accept)
+		assert.Contains(t, w.Body.String(), "<h1>502 Bad Gateway</h1>")
+		assert.Contains(t, w.Body.String(), w.Header().Get(logging.RequestIDHeader))
+		assert.Contains(t, w.Body.String(), "route <code>api</code>")
+	}
+
+	accessLog.Stop()
+	entries := readAccessLog(t, path)
+	require.Len(t, entries, 6)
+	assert.Equal(t, page.RequestID, entries[0].RequestID)
+	assert.Equal(t, unrouted.RequestID, entries[1].RequestID)
+	assert.Equal(t, http.StatusNotFound, entries[1].Status)
+}
+
+func TestErrorPageTemplates(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "502.html"), []byte(`<p>{{.Title}}: {{.Route}} is {{.UpstreamState}} ({{.RequestID}})</p>`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "error.html"), []byte(`<p>Oops {{.Status}}: {{.Message}}</p>`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.html"), []byte(`{{broken`), 0o644))
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.LoadErrorPages(dir), "files not named after a status are ignored")
+	require.NoError(t, router.AddRoute(&Route{ID: "api", PathPrefix: "/api", Upstream: down.URL}))
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(logging.RequestIDHeader, "req-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// A status's own template, then error.html
+	w := serve("/api/users")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "<p>Bad Gateway: api is unreachable (req-1)</p>", w.Body.String())
+	w = serve("/missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "<p>Oops 404: No route matches this host and path.</p>", w.Body.String())
+
+	// Templates that fail to render fall back to the built-in page
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "404.html"), []byte(`{{.Missing}}`), 0o644))
+	require.NoError(t, router.LoadErrorPages(dir))
+	w = serve("/missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "<h1>404 Not Found</h1>")
+
+	// Loading fails on templates that do not parse and missing directories
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "503.html"), []byte(`{{.Status`), 0o644))
+	assert.ErrorContains(t, router.LoadErrorPages(dir), "503.html")
+	assert.Error(t, router.LoadErrorPages(filepath.Join(dir, "missing")))
+
+	require.NoError(t, router.LoadErrorPages(""))
+	assert.Contains(t, serve("/api/users").Body.String(), "<h1>502 Bad Gateway</h1>")
+}
+
+func TestProxyErrorPage(t *testing.T) {
+	page := proxyErrorPage("api", &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}})
+	assert.Equal(t, http.StatusGatewayTimeout, page.Status)
+	assert.Equal(t, errorUpstreamTimeout, page.Code)
+	assert.Equal(t, upstreamTimeout, page.UpstreamState)
+
+	page = proxyErrorPage("api", errors.New("connection refused"))
+	assert.Equal(t, http.StatusBadGateway, page.Status)
+	assert.Equal(t, errorUpstreamUnreachable, page.Code)
+}
+
+func TestPrefersJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		json   bool
+	}{
+		{"application/json", true},
+		{"application/json, text/plain, */*", true},
+		{"application/vnd.api+json", true},
+		{"text/html, application/json", true},
+		{"text/html;q=0.9, application/json;q=0.8", false},
+		{"application/json;q=0", false},
+		{"*/*", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.json, prefersJSON(tt.accept), tt.accept)
+	}
+}
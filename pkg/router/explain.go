@@ -0,0 +1,150 @@
+package router
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Reasons a route was or was not selected
+const (
+	ReasonSelected         = "selected"
+	ReasonHostMismatch     = "host mismatch"
+	ReasonPathMismatch     = "path prefix mismatch"
+	ReasonMethodExcluded   = "method excluded"
+	ReasonHeaderMismatch   = "header mismatch"
+	ReasonLowerPriority    = "lower priority"
+	ReasonHostPreferred    = "host-specific route preferred"
+	ReasonExactHost        = "exact host preferred over wildcard"
+	ReasonShorterPrefix    = "shorter prefix"
+	ReasonFewerConstraints = "fewer method/header constraints"
+	ReasonTieBreak         = "tie broken by route ID"
+)
+
+// MatchTrace explains how a request was routed
+type MatchTrace struct {
+	Host       string              `json:"host"`
+	Path       string              `json:"path"`
+	Method     string              `json:"method"`
+	Route      *Route              `json:"route"`
+	Candidates []*RouteCandidate   `json:"candidates"`
+	Upstreams  []*WeightedUpstream `json:"upstreams,omitempty"`
+	Sticky     string              `json:"sticky,omitempty"`
+}
+
+// RouteCandidate records how a single route fared against the request
+type RouteCandidate struct {
+	RouteID    string `json:"route_id"`
+	Host       string `json:"host,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+	Priority   int    `json:"priority,omitempty"`
+	Score      int    `json:"score"`
+	Selected   bool   `json:"selected"`
+	Reason     string `json:"reason"`
+
+	route *Route
+	rank  matchRank
+}
+
+// Explain runs the routing decision for a request without proxying it
+// and returns the selected route along with every candidate considered
+func (r *Router) Explain(req *http.Request) *MatchTrace {
+	trace := &MatchTrace{
+		Host:   req.Host,
+		Path:   req.URL.Path,
+		Method: req.Method,
+	}
+
+	route := r.matchRoute(req, trace)
+	if route != nil {
+		trace.Route = route
+		trace.Upstreams, _ = upstreamsFor(route)
+		trace.Sticky = route.Sticky
+	}
+
+	return trace
+}
+
+// consider records a route evaluated by matchRoute
+func (t *MatchTrace) consider(route *Route, rank matchRank, reason string) {
+	score := rank.prefix
+	if rank.host >= 0 {
+		score += hostPoints[rank.host]
+	}
+
+	t.Candidates = append(t.Candidates, &RouteCandidate{
+		RouteID:    route.ID,
+		Host:       route.Host,
+		PathPrefix: route.PathPrefix,
+		Priority:   route.Priority,
+		Score:      score,
+		Reason:     reason,
+		route:      route,
+		rank:       rank,
+	})
+}
+
+// finish marks the selected route and explains why the other matching routes lost
+func (t *MatchTrace) finish(best *Route) {
+	sort.Slice(t.Candidates, func(i, j int) bool {
+		return t.Candidates[i].RouteID < t.Candidates[j].RouteID
+	})
+
+	if best == nil {
+		return
+	}
+
+	var winner *RouteCandidate
+	for _, c := range t.Candidates {
+		if c.route == best {
+			winner = c
+			break
+		}
+	}
+
+	for _, c := range t.Candidates {
+		switch {
+		case c == winner:
+			c.Selected = true
+			c.Reason = ReasonSelected
+		case c.Reason != "":
+			// Did not match at all
+		case c.rank.priority < winner.rank.priority:
+			c.Reason = ReasonLowerPriority
+		case c.rank.host == hostAny && winner.rank.host != hostAny:
+			c.Reason = ReasonHostPreferred
+		case c.rank.host < winner.rank.host:
+			c.Reason = ReasonExactHost
+		case c.rank.prefix < winner.rank.prefix:
+			c.Reason = ReasonShorterPrefix
+		case c.rank.specificity < winner.rank.specificity:
+			c.Reason = ReasonFewerConstraints
+		default:
+			c.Reason = ReasonTieBreak
+		}
+	}
+}
+
+// ExplainRequest builds the request to explain from admin API query parameters.
+// Headers are passed as repeated "header=Name:Value" parameters.
+func ExplainRequest(host, path, method string, headers []string) (*http.Request, error) {
+	if method == "" {
+		method = http.MethodGet
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	req, err := http.NewRequest(strings.ToUpper(method), path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Host = host
+
+	for _, h := range headers {
+		name, value, _ := strings.Cut(h, ":")
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	return req, nil
+}
@@ -0,0 +1,174 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// newAmbiguousRouter sets up overlapping prefixes, a header-matched canary,
+// a method-restricted route and a host-specific route, each served by a backend
+// that echoes the route ID
+func newAmbiguousRouter(t *testing.T) *Router {
+	router := NewRouter(&config.Config{})
+	routes := []*Route{
+		{ID: "root", PathPrefix: "/"},
+		{ID: "api", PathPrefix: "/api"},
+		{ID: "api-v2", PathPrefix: "/api/v2"},
+		{ID: "api-canary", PathPrefix: "/api/v2", Headers: map[string]string{"X-Canary": "1"}},
+		{ID: "api-write", PathPrefix: "/api/v2/orders", Methods: []string{http.MethodPost}},
+		{ID: "admin", Host: "admin.example.com", PathPrefix: "/"},
+	}
+	for _, route := range routes {
+		route.Upstream = newNamedBackend(t, route.ID).URL
+		require.NoError(t, router.AddRoute(route))
+	}
+	return router
+}
+
+func reasons(trace *MatchTrace) map[string]string {
+	result := make(map[string]string, len(trace.Candidates))
+	for _, c := range trace.Candidates {
+		result[c.RouteID] = c.Reason
+	}
+	return result
+}
+
+func TestExplain(t *testing.T) {
+	router := newAmbiguousRouter(t)
+
+	tests := []struct {
+		name     string
+		host     string
+		path     string
+		method   string
+		headers  []string
+		expected string
+		reasons  map[string]string
+	}{
+		{
+			name:     "header matched canary",
+			host:     "example.com:8080",
+			path:     "/api/v2/orders",
+			method:   http.MethodGet,
+			headers:  []string{"X-Canary: 1"},
+			expected: "api-canary",
+			reasons: map[string]string{
+				"admin":      ReasonHostMismatch,
+				"api":        ReasonShorterPrefix,
+				"api-canary": ReasonSelected,
+				"api-v2":     ReasonFewerConstraints,
+				"api-write":  ReasonMethodExcluded,
+				"root":       ReasonShorterPrefix,
+			},
+		},
+		{
+			name:     "canary header absent",
+			host:     "example.com",
+			path:     "/api/v2/users",
+			method:   http.MethodGet,
+			expected: "api-v2",
+			reasons: map[string]string{
+				"admin":      ReasonHostMismatch,
+				"api":        ReasonShorterPrefix,
+				"api-canary": ReasonHeaderMismatch,
+				"api-v2":     ReasonSelected,
+				"api-write":  ReasonPathMismatch,
+				"root":       ReasonShorterPrefix,
+			},
+		},
+		{
+			name:     "method restricted route",
+			host:     "example.com",
+			path:     "/api/v2/orders",
+			method:   http.MethodPost,
+			headers:  []string{"X-Canary: 1"},
+			expected: "api-write",
+			reasons: map[string]string{
+				"api-canary": ReasonShorterPrefix,
+				"api-write":  ReasonSelected,
+			},
+		},
+		{
+			name:     "host specific route",
+			host:     "admin.example.com",
+			path:     "/api",
+			method:   http.MethodGet,
+			expected: "admin",
+			reasons: map[string]string{
+				"admin":  ReasonSelected,
+				"api":    ReasonHostPreferred,
+				"api-v2": ReasonPathMismatch,
+				"root":   ReasonHostPreferred,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ExplainRequest(tt.host, tt.path, tt.method, tt.headers)
+			require.NoError(t, err)
+
+			trace := router.Explain(req)
+			require.NotNil(t, trace.Route)
+			assert.Equal(t, tt.expected, trace.Route.ID)
+			assert.Len(t, trace.Candidates, 6)
+			require.Len(t, trace.Upstreams, 1)
+			assert.Equal(t, trace.Route.Upstream, trace.Upstreams[0].URL)
+
+			got := reasons(trace)
+			for routeID, reason := range tt.reasons {
+				assert.Equal(t, reason, got[routeID], routeID)
+			}
+
+			// The explanation agrees with real routing
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expected, w.Body.String())
+		})
+	}
+
+	t.Run("wildcard and priority", func(t *testing.T) {
+		router := NewRouter(&config.Config{})
+		for _, route := range []*Route{
+			{ID: "apps", Host: "*.example.com", PathPrefix: "/api"},
+			{ID: "shop", Host: "shop.example.com", PathPrefix: "/"},
+			{ID: "pinned", PathPrefix: "/", Priority: -1},
+			{ID: "root", PathPrefix: "/"},
+		} {
+			route.Upstream = "http://127.0.0.1:1"
+			require.NoError(t, router.AddRoute(route))
+		}
+
+		req, err := ExplainRequest("shop.example.com", "/api/items", "", nil)
+		require.NoError(t, err)
+
+		trace := router.Explain(req)
+		require.NotNil(t, trace.Route)
+		assert.Equal(t, map[string]string{
+			"apps":   ReasonExactHost,
+			"shop":   ReasonSelected,
+			"pinned": ReasonLowerPriority,
+			"root":   ReasonHostPreferred,
+		}, reasons(trace))
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		router := NewRouter(&config.Config{})
+		require.NoError(t, router.AddRoute(&Route{ID: "only", Host: "a.example.com", Upstream: "http://127.0.0.1:1"}))
+
+		req, err := ExplainRequest("b.example.com", "/", "", nil)
+		require.NoError(t, err)
+
+		trace := router.Explain(req)
+		assert.Nil(t, trace.Route)
+		require.Len(t, trace.Candidates, 1)
+		assert.Equal(t, ReasonHostMismatch, trace.Candidates[0].Reason)
+		assert.Equal(t, http.MethodGet, trace.Method)
+	})
+}
@@ -0,0 +1,133 @@
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PrometheusContentType is the content type of the Prometheus text exposition format
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// upstreamSeries is the metrics of one route and upstream pair with its labels
+type upstreamSeries struct {
+	labels  string
+	metrics *UpstreamMetrics
+}
+
+// WritePrometheus writes the router metrics in the Prometheus text exposition
+// format. Per-upstream series are labelled with the route ID, the route's host
+// and the upstream URL.
+func (r *Router) WritePrometheus(w io.Writer) error {
+	metrics := r.GetMetrics()
+
+	hosts := make(map[string]string)
+	for _, route := range r.ListRoutes() {
+		hosts[route.ID] = route.Host
+	}
+
+	var series []upstreamSeries
+	for routeID, byUpstream := range metrics.Upstreams {
+		for upstream, m := range byUpstream {
+			labels := fmt.Sprintf(`route=%s,host=%s,upstream=%s`,
+				quoteLabel(routeID), quoteLabel(hosts[routeID]), quoteLabel(upstream))
+			series = append(series, upstreamSeries{labels: labels, metrics: m})
+		}
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].labels < series[j].labels
+	})
+
+	bw := bufio.NewWriter(w)
+
+	writeHeader(bw, "gate_requests_total", "counter", "Requests proxied to an upstream.")
+	for _, s := range series {
+		fmt.Fprintf(bw, "gate_requests_total{%s} %d\n", s.labels, s.metrics.RequestCount)
+	}
+
+	writeHeader(bw, "gate_errors_total", "counter", "Upstream requests that failed or returned a 5xx status.")
+	for _, s := range series {
+		fmt.Fprintf(bw, "gate_errors_total{%s} %d\n", s.labels, s.metrics.ErrorCount)
+	}
+
+	writeHeader(bw, "gate_upstream_ejections_total", "counter", "Times an upstream was ejected by the passive health check.")
+	for _, s := range series {
+		fmt.Fprintf(bw, "gate_upstream_ejections_total{%s} %d\n", s.labels, s.metrics.Ejections)
+	}
+
+	writeHeader(bw, "gate_response_time_seconds", "histogram", "Time taken to proxy a request to an upstream.")
+	for _, s := range series {
+		var cumulative int64
+		for i, bound := range ResponseTimeBuckets {
+			if s.metrics.ResponseTimeBuckets != nil {
+				cumulative += s.metrics.ResponseTimeBuckets[i]
+			}
+			fmt.Fprintf(bw, "gate_response_time_seconds_bucket{%s,le=%q} %d\n",
+				s.labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(bw, "gate_response_time_seconds_bucket{%s,le=\"+Inf\"} %d\n", s.labels, s.metrics.RequestCount)
+		fmt.Fprintf(bw, "gate_response_time_seconds_sum{%s} %s\n",
+			s.labels, strconv.FormatFloat(float64(s.metrics.ResponseTime)/1e9, 'g', -1, 64))
+		fmt.Fprintf(bw, "gate_response_time_seconds_count{%s} %d\n", s.labels, s.metrics.RequestCount)
+	}
+
+	writeHeader(bw, "gate_unrouted_requests_total", "counter", "Requests that matched no route.")
+	fmt.Fprintf(bw, "gate_unrouted_requests_total %d\n", metrics.ErrorCount["no-route"])
+
+	cacheRoutes := make([]string, 0, len(metrics.Cache))
+	for routeID := range metrics.Cache {
+		cacheRoutes = append(cacheRoutes, routeID)
+	}
+	sort.Strings(cacheRoutes)
+
+	writeHeader(bw, "gate_cache_hits_total", "counter", "Requests answered from the response cache.")
+	for _, routeID := range cacheRoutes {
+		fmt.Fprintf(bw, "gate_cache_hits_total{route=%s} %d\n", quoteLabel(routeID), metrics.Cache[routeID].Hits)
+	}
+
+	writeHeader(bw, "gate_cache_misses_total", "counter", "Cacheable requests proxied because no fresh response was cached.")
+	for _, routeID := range cacheRoutes {
+		fmt.Fprintf(bw, "gate_cache_misses_total{route=%s} %d\n", quoteLabel(routeID), metrics.Cache[routeID].Misses)
+	}
+
+	writeHeader(bw, "gate_cache_hit_ratio", "gauge", "Share of cacheable requests answered from the response cache.")
+	for _, routeID := range cacheRoutes {
+		fmt.Fprintf(bw, "gate_cache_hit_ratio{route=%s} %s\n",
+			quoteLabel(routeID), strconv.FormatFloat(metrics.Cache[routeID].HitRatio, 'g', -1, 64))
+	}
+
+	entries, size := r.cache.stats()
+	writeHeader(bw, "gate_cache_entries", "gauge", "Responses held in the response cache.")
+	fmt.Fprintf(bw, "gate_cache_entries %d\n", entries)
+	writeHeader(bw, "gate_cache_size_bytes", "gauge", "Memory held by the response cache.")
+	fmt.Fprintf(bw, "gate_cache_size_bytes %d\n", size)
+
+	if r.accessLog != nil {
+		writeHeader(bw, "gate_access_log_dropped_total", "counter", "Access log entries dropped because the writer fell behind.")
+		fmt.Fprintf(bw, "gate_access_log_dropped_total %d\n", r.accessLog.Dropped())
+	}
+	if r.traffic != nil {
+		writeHeader(bw, "gate_metrics_export_pending", "gauge", "Traffic metrics waiting to be written to the console.")
+		fmt.Fprintf(bw, "gate_metrics_export_pending %d\n", r.traffic.Pending())
+		writeHeader(bw, "gate_metrics_export_dropped_total", "counter", "Traffic metrics dropped because the console was unreachable for too long.")
+		fmt.Fprintf(bw, "gate_metrics_export_dropped_total %d\n", r.traffic.Dropped())
+	}
+
+	return bw.Flush()
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric family
+func writeHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// labelEscaper escapes label values as required by the text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quoteLabel returns a quoted, escaped label value
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
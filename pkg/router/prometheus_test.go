@@ -0,0 +1,130 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// sampleLine matches a sample line of the text exposition format
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{([a-zA-Z_][a-zA-Z0-9_]*="(\\.|[^"\\])*",?)*\})? (\S+)$`)
+
+// parseSamples checks every line of an exposition and returns the sample values keyed by series
+func parseSamples(t *testing.T, exposition string) map[string]float64 {
+	samples := make(map[string]float64)
+	typed := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(exposition))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			require.Len(t, fields, 4, line)
+			typed[fields[2]] = fields[3]
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+
+		match := sampleLine.FindStringSubmatch(line)
+		require.NotNil(t, match, "invalid sample line: %q", line)
+
+		family := match[1]
+		if typed[family] == "" {
+			family = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(family, "_bucket"), "_sum"), "_count")
+			assert.Equal(t, "histogram", typed[family], "sample before TYPE: %q", line)
+		}
+
+		value, err := strconv.ParseFloat(match[5], 64)
+		require.NoError(t, err, line)
+		samples[strings.TrimSuffix(line, " "+match[5])] = value
+	}
+	require.NoError(t, scanner.Err())
+	return samples
+}
+
+func TestWritePrometheus(t *testing.T) {
+	backend := newNamedBackend(t, "ok")
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{
+		ID:         "api",
+		Host:       "api.example.com",
+		PathPrefix: "/",
+		Upstreams: []*WeightedUpstream{
+			{URL: backend.URL, Weight: 1},
+			{URL: failing.URL, Weight: 1},
+		},
+	}))
+
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = "api.example.com"
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://unknown.example.com/", nil))
+
+	// A slow request lands above the largest bucket
+	router.recordUpstreamRequest("api", backend.URL, 20*time.Second)
+
+	var buf bytes.Buffer
+	require.NoError(t, router.WritePrometheus(&buf))
+	samples := parseSamples(t, buf.String())
+
+	labels := func(upstream string) string {
+		return `route="api",host="api.example.com",upstream="` + upstream + `"`
+	}
+
+	assert.Equal(t, float64(3), samples[`gate_requests_total{`+labels(backend.URL)+`}`])
+	assert.Equal(t, float64(2), samples[`gate_requests_total{`+labels(failing.URL)+`}`])
+	assert.Equal(t, float64(0), samples[`gate_errors_total{`+labels(backend.URL)+`}`])
+	assert.Equal(t, float64(2), samples[`gate_errors_total{`+labels(failing.URL)+`}`])
+	assert.Equal(t, float64(1), samples[`gate_unrouted_requests_total`])
+
+	// Buckets are cumulative and end with the total count
+	bucket := func(le string) float64 {
+		value, ok := samples[`gate_response_time_seconds_bucket{`+labels(backend.URL)+`,le="`+le+`"}`]
+		require.True(t, ok, "missing bucket le=%s", le)
+		return value
+	}
+	previous := float64(0)
+	for _, bound := range ResponseTimeBuckets {
+		value := bucket(strconv.FormatFloat(bound, 'g', -1, 64))
+		assert.GreaterOrEqual(t, value, previous)
+		previous = value
+	}
+	assert.Equal(t, float64(2), previous)
+	assert.Equal(t, float64(3), bucket("+Inf"))
+	assert.Equal(t, float64(3), samples[`gate_response_time_seconds_count{`+labels(backend.URL)+`}`])
+	assert.GreaterOrEqual(t, samples[`gate_response_time_seconds_sum{`+labels(backend.URL)+`}`], float64(20))
+
+	// Validate with promtool when it is installed
+	if promtool, err := exec.LookPath("promtool"); err == nil {
+		cmd := exec.Command(promtool, "check", "metrics")
+		cmd.Stdin = &buf
+		out, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(out))
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	assert.Equal(t, `"plain"`, quoteLabel("plain"))
+	assert.Equal(t, `"a\"b\\c\nd"`, quoteLabel("a\"b\\c\nd"))
+}
@@ -2,34 +2,106 @@ package router
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/tracing"
 )
 
-// Route represents a routing rule
+// Route represents a routing rule.
+// Host is an exact host name or a "*.example.com" wildcard matching one-level subdomains.
+// See matchRoute for how overlapping routes are ranked; Priority overrides that ranking.
+// StripPrefix removes PathPrefix from the request path before it is proxied.
+// Transform adds or removes headers and rewrites the path on the way to and from the upstream.
+// When Upstreams is set, traffic is split between them by weight and Upstream is ignored.
+// Sticky pins a client to one upstream by IP hash ("ip") or cookie ("cookie").
+// Methods and Headers further restrict which requests the route matches.
+// Strategy picks between upstreams: weighted "round_robin" (the default) or "least_connections".
+// ForceHTTPS redirects plain HTTP requests for the route to the HTTPS listener.
+// AccessControl replaces the gate's default access control for the route; an empty one lets every client through.
+// Cache answers repeated GET requests from memory instead of the upstream.
+// ServiceID names the service the upstream runs; exported traffic metrics of
+// the route are recorded as that service's.
 type Route struct {
-	ID         string    `json:"id"`
-	Host       string    `json:"host"`
-	PathPrefix string    `json:"path_prefix"`
-	Upstream   string    `json:"upstream"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID            string                      `json:"id"`
+	Host          string                      `json:"host"`
+	PathPrefix    string                      `json:"path_prefix"`
+	Priority      int                         `json:"priority,omitempty"`
+	StripPrefix   bool                        `json:"strip_prefix,omitempty"`
+	Methods       []string                    `json:"methods,omitempty"`
+	Headers       map[string]string           `json:"headers,omitempty"`
+	Upstream      string                      `json:"upstream"`
+	Upstreams     []*WeightedUpstream         `json:"upstreams,omitempty"`
+	Sticky        string                      `json:"sticky,omitempty"`
+	StickyCookie  string                      `json:"sticky_cookie,omitempty"`
+	Transform     *Transform                  `json:"transform,omitempty"`
+	Strategy      string                      `json:"strategy,omitempty"`
+	ForceHTTPS    bool                        `json:"force_https,omitempty"`
+	AccessControl *config.AccessControlConfig `json:"access_control,omitempty"`
+	Cache         *RouteCache                 `json:"cache,omitempty"`
+	Holding       bool                        `json:"holding,omitempty"` // serve the starting page until released
+	ServiceID     string                      `json:"service_id,omitempty"`
+	CreatedAt     time.Time                   `json:"created_at"`
+	UpdatedAt     time.Time                   `json:"updated_at"`
 }
 
+// Route lookup errors, wrapped with the route ID
+var (
+	ErrRouteExists   = errors.New("route already exists")
+	ErrRouteNotFound = errors.New("route not found")
+)
+
+// ErrNoUpstreamReachable is returned by HealthCheck when routes are
+// configured but none of their upstreams accepts connections
+var ErrNoUpstreamReachable = errors.New("no upstream reachable")
+
+// upstreamDialTimeout bounds each connection attempt of HealthCheck
+const upstreamDialTimeout = 2 * time.Second
+
 // Router handles HTTP request routing
 type Router struct {
 	routes  map[string]*Route
-	proxies map[string]*httputil.ReverseProxy
+	proxies map[string]*upstreamPool
 	mu      sync.RWMutex
 	config  *config.Config
 	metrics *Metrics
+
+	// Log of served requests, nil when off
+	accessLog *AccessLogger
+
+	// Aggregates the traffic of routes for export, nil when off
+	traffic *TrafficExporter
+
+	// Templates of the error pages loaded from a directory, nil for the
+	// built-in page
+	errorPages errorPages
+
+	// Proxies trusted to name the client, and the access control of routes
+	// without their own
+	trusted       []*net.IPNet
+	defaultAccess *accessPolicy
+
+	// Responses cached for routes that enable caching
+	cache *responseCache
+
+	// Passive health check and circuit breaker settings applied to new
+	// upstream pools
+	ejectAfter    int
+	ejectCooldown time.Duration
+	circuit       circuitSettings
 }
 
 // Metrics holds routing metrics
@@ -37,63 +109,97 @@ type Metrics struct {
 	RequestCount  map[string]int64 `json:"request_count"`
 	ErrorCount    map[string]int64 `json:"error_count"`
 	ResponseTimes map[string]int64 `json:"response_times"`
-	mu            sync.RWMutex
+	// Upstreams holds per-upstream metrics keyed by route ID and upstream URL
+	Upstreams map[string]map[string]*UpstreamMetrics `json:"upstreams"`
+	// Cache holds the cache lookups of routes that enable caching
+	Cache map[string]*CacheMetrics `json:"cache"`
+	// Circuits holds the circuit breaker state keyed by route ID and upstream URL
+	Circuits map[string]map[string]*CircuitState `json:"circuits"`
+	mu       sync.RWMutex
 }
 
 // NewRouter creates a new router instance
 func NewRouter(cfg *config.Config) *Router {
 	return &Router{
 		routes:  make(map[string]*Route),
-		proxies: make(map[string]*httputil.ReverseProxy),
+		proxies: make(map[string]*upstreamPool),
 		config:  cfg,
 		metrics: &Metrics{
 			RequestCount:  make(map[string]int64),
 			ErrorCount:    make(map[string]int64),
 			ResponseTimes: make(map[string]int64),
+			Upstreams:     make(map[string]map[string]*UpstreamMetrics),
+			Cache:         make(map[string]*CacheMetrics),
 		},
+		cache:         newResponseCache(cfg.Gate.Cache),
+		ejectAfter:    defaultEjectAfter,
+		ejectCooldown: defaultEjectCooldown,
+		circuit:       newCircuitSettings(cfg.Gate.CircuitBreaker),
 	}
 }
 
 // AddRoute adds a new route
 func (r *Router) AddRoute(route *Route) error {
+	if route.ID == "" {
+		return fmt.Errorf("route ID is required")
+	}
+	if err := validateHost(route.Host); err != nil {
+		return err
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// Validate upstream URL
-	upstream, err := url.Parse(route.Upstream)
+	if _, exists := r.routes[route.ID]; exists {
+		return fmt.Errorf("%w: %s", ErrRouteExists, route.ID)
+	}
+
+	// Validate upstreams and create reverse proxies
+	pool, err := r.newUpstreamPool(route, nil)
 	if err != nil {
-		return fmt.Errorf("invalid upstream URL: %w", err)
+		return err
+	}
+
+	// Store route and proxy
+	route.UpdatedAt = time.Now()
+	if route.CreatedAt.IsZero() {
+		route.CreatedAt = time.Now()
 	}
 
-	// Create reverse proxy
-	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	r.routes[route.ID] = route
+	r.proxies[route.ID] = pool
 
-	// Customize proxy behavior
-	proxy.Director = func(req *http.Request) {
-		req.URL.Scheme = upstream.Scheme
-		req.URL.Host = upstream.Host
-		req.Host = upstream.Host
+	return nil
+}
 
-		// Add forwarded headers
-		req.Header.Set("X-Forwarded-Proto", req.URL.Scheme)
-		req.Header.Set("X-Forwarded-Host", req.Host)
-		req.Header.Set("X-Real-IP", req.RemoteAddr)
+// UpdateRoute replaces the matching rules, upstreams and weights of an existing route.
+// Proxies for upstreams that are kept are reused, so in-flight requests are not interrupted.
+func (r *Router) UpdateRoute(route *Route) error {
+	if err := validateHost(route.Host); err != nil {
+		return err
 	}
 
-	// Error handler
-	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
-		r.recordError(route.ID)
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.routes[route.ID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrRouteNotFound, route.ID)
 	}
 
-	// Store route and proxy
-	route.UpdatedAt = time.Now()
-	if route.CreatedAt.IsZero() {
-		route.CreatedAt = time.Now()
+	pool, err := r.newUpstreamPool(route, r.proxies[route.ID])
+	if err != nil {
+		return err
 	}
 
+	route.CreatedAt = existing.CreatedAt
+	route.UpdatedAt = time.Now()
+
 	r.routes[route.ID] = route
-	r.proxies[route.ID] = proxy
+	r.proxies[route.ID] = pool
+
+	// Responses cached under the old rules may no longer be valid
+	r.cache.purge(route.ID)
 
 	return nil
 }
@@ -104,11 +210,12 @@ func (r *Router) RemoveRoute(routeID string) error {
 	defer r.mu.Unlock()
 
 	if _, exists := r.routes[routeID]; !exists {
-		return fmt.Errorf("route not found: %s", routeID)
+		return fmt.Errorf("%w: %s", ErrRouteNotFound, routeID)
 	}
 
 	delete(r.routes, routeID)
 	delete(r.proxies, routeID)
+	r.cache.purge(routeID)
 
 	return nil
 }
@@ -120,7 +227,7 @@ func (r *Router) GetRoute(routeID string) (*Route, error) {
 
 	route, exists := r.routes[routeID]
 	if !exists {
-		return nil, fmt.Errorf("route not found: %s", routeID)
+		return nil, fmt.Errorf("%w: %s", ErrRouteNotFound, routeID)
 	}
 
 	return route, nil
@@ -143,34 +250,214 @@ func (r *Router) ListRoutes() []*Route {
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	start := time.Now()
 
+	// Correlate the request across the gate and its upstream. The director
+	// forwards the ID and every response, errors included, echoes it.
+	requestID := logging.RequestIDFrom(req.Header.Get(logging.RequestIDHeader))
+	req = req.WithContext(logging.WithRequestID(req.Context(), requestID))
+	w.Header().Set(logging.RequestIDHeader, requestID)
+
+	if !tracing.Enabled() && r.accessLog == nil && r.traffic == nil {
+		r.serve(w, req, start)
+		return
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	if tracing.Enabled() {
+		// The director passes the span upstream in the traceparent header
+		ctx, span := tracing.StartServerSpan(req, "gate "+req.Method)
+		defer span.End()
+		defer func() { tracing.SetHTTPStatus(span, recorder.status) }()
+		req = req.WithContext(ctx)
+	}
+
+	routeID, upstream := r.serve(recorder, req, start)
+	if r.traffic != nil {
+		r.traffic.record(routeID, recorder.status, time.Since(start))
+	}
+	if r.accessLog != nil && r.accessLog.sampled(routeID) {
+		r.accessLog.Log(&AccessLogEntry{
+			Time:       start,
+			RequestID:  requestID,
+			ClientIP:   r.ClientIP(req),
+			Host:       req.Host,
+			Method:     req.Method,
+			Path:       req.URL.Path,
+			Route:      routeID,
+			Upstream:   upstream,
+			Status:     recorder.status,
+			Bytes:      recorder.bytes,
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			Referer:    req.Referer(),
+			UserAgent:  req.UserAgent(),
+		})
+	}
+}
+
+// SetAccessLogger logs every request served from now on to al, or stops
+// logging when al is nil. Call it before serving.
+func (r *Router) SetAccessLogger(al *AccessLogger) {
+	r.accessLog = al
+}
+
+// serve routes a request to the upstream of its route, returning the ID of
+// the route and the upstream the request was proxied to, if any
+func (r *Router) serve(w http.ResponseWriter, req *http.Request, start time.Time) (string, string) {
 	// Find matching route
 	route := r.findRoute(req)
 	if route == nil {
 		r.recordError("no-route")
-		http.NotFound(w, req)
-		return
+		r.writeError(w, req, &ErrorPage{
+			Code:    errorRouteNotFound,
+			Status:  http.StatusNotFound,
+			Message: "No route matches this host and path.",
+		})
+		return "", ""
+	}
+
+	// Send plain HTTP to the HTTPS listener when required
+	if req.TLS == nil && (route.ForceHTTPS || r.config.Gate.TLS.ForceHTTPS) {
+		http.Redirect(w, req, r.httpsURL(req), http.StatusPermanentRedirect)
+		return route.ID, ""
 	}
 
 	// Get proxy for this route
 	r.mu.RLock()
-	proxy, exists := r.proxies[route.ID]
+	pool, exists := r.proxies[route.ID]
 	r.mu.RUnlock()
 
 	if !exists {
 		r.recordError(route.ID)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		r.writeError(w, req, &ErrorPage{
+			Code:    errorInternal,
+			Status:  http.StatusInternalServerError,
+			Message: "The route has no upstreams.",
+			Route:   route.ID,
+		})
+		return route.ID, ""
+	}
+
+	if !r.authorize(w, req, route.ID, pool.access) {
+		return route.ID, ""
+	}
+	if route.Holding {
+		writeStartingPage(w)
+		return route.ID, ""
 	}
 
 	// Record metrics
 	r.recordRequest(route.ID, time.Since(start))
+	trace.SpanFromContext(req.Context()).SetAttributes(attribute.String("gate.route", route.ID))
+
+	// Answer repeated requests from the cache, keyed before the path is stripped
+	var key string
+	if pool.cache != nil && !cacheBypassed(req) {
+		key = cacheKey(route.ID, req)
+		if r.serveCached(w, req, route.ID, key) {
+			return route.ID, ""
+		}
+		w.Header().Set(CacheHeader, CacheMiss)
+	}
+
+	if route.StripPrefix && route.PathPrefix != "" {
+		req = stripPrefix(req, route.PathPrefix)
+	}
 
 	// Proxy the request
-	proxy.ServeHTTP(w, req)
+	if key == "" {
+		return route.ID, r.serveUpstream(w, req, route.ID, pool)
+	}
+	recorder := &cacheRecorder{ResponseWriter: w, limit: r.cache.maxEntry}
+	upstream := r.serveUpstream(recorder, req, route.ID, pool)
+	r.cacheResponse(req, route.ID, key, pool.cache, recorder)
+	return route.ID, upstream
+}
+
+// statusRecorder remembers the status and size of a response. Unwrap lets
+// the reverse proxy flush and hijack the underlying connection.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// httpsURL returns the request URL on the gate's HTTPS port
+func (r *Router) httpsURL(req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else {
+		host = strings.Trim(host, "[]")
+	}
+	if port := r.config.Gate.Ports.HTTPS; port != 0 && port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return "https://" + host + req.URL.RequestURI()
 }
 
 // findRoute finds the best matching route for a request
 func (r *Router) findRoute(req *http.Request) *Route {
+	return r.matchRoute(req, nil)
+}
+
+// Host match ranks, from least to most specific
+const (
+	hostAny = iota
+	hostWildcard
+	hostExact
+)
+
+// Score points for a host match, reported in match traces
+var hostPoints = [...]int{hostAny: 0, hostWildcard: 50, hostExact: 100}
+
+// matchRank orders matching routes; fields are compared in declaration order
+type matchRank struct {
+	priority    int
+	host        int
+	prefix      int
+	specificity int
+}
+
+// less reports whether m ranks below o
+func (m matchRank) less(o matchRank) bool {
+	switch {
+	case m.priority != o.priority:
+		return m.priority < o.priority
+	case m.host != o.host:
+		return m.host < o.host
+	case m.prefix != o.prefix:
+		return m.prefix < o.prefix
+	default:
+		return m.specificity < o.specificity
+	}
+}
+
+// matchRoute ranks every route matching the request and returns the best one.
+// Routes are ordered by, in turn:
+//  1. explicit Priority, highest first
+//  2. host: an exact host beats a "*.domain" wildcard, which beats a route without a host
+//  3. path prefix, longest first
+//  4. number of method/header constraints, most first
+//  5. route ID, lowest first
+//
+// When trace is non-nil each route considered is recorded in it.
+func (r *Router) matchRoute(req *http.Request, trace *MatchTrace) *Route {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -181,39 +468,134 @@ func (r *Router) findRoute(req *http.Request) *Route {
 	if colonIndex := strings.Index(host, ":"); colonIndex != -1 {
 		host = host[:colonIndex]
 	}
+	host = strings.ToLower(host)
 
 	var bestMatch *Route
-	var bestScore int
+	var bestRank matchRank
 
 	for _, route := range r.routes {
-		score := 0
+		rank := matchRank{priority: route.Priority, specificity: routeSpecificity(route)}
+		reason := ""
 
 		// Check host match
-		if route.Host != "" && route.Host == host {
-			score += 100
-		} else if route.Host != "" {
-			continue // Host doesn't match, skip this route
+		if rank.host = matchHost(route.Host, host); rank.host < 0 {
+			reason = ReasonHostMismatch
 		}
 
 		// Check path prefix match
-		if route.PathPrefix != "" {
+		if reason == "" && route.PathPrefix != "" {
 			if strings.HasPrefix(path, route.PathPrefix) {
-				score += len(route.PathPrefix)
+				rank.prefix = len(route.PathPrefix)
 			} else {
-				continue // Path doesn't match, skip this route
+				reason = ReasonPathMismatch
 			}
 		}
 
-		// Select best match (highest score)
-		if bestMatch == nil || score > bestScore {
+		// Check method and header constraints
+		if reason == "" && !methodAllowed(route, req.Method) {
+			reason = ReasonMethodExcluded
+		}
+		if reason == "" && !headersMatch(route, req.Header) {
+			reason = ReasonHeaderMismatch
+		}
+
+		if trace != nil {
+			trace.consider(route, rank, reason)
+		}
+		if reason != "" {
+			continue // Route doesn't match, skip it
+		}
+
+		if bestMatch == nil || bestRank.less(rank) ||
+			(rank == bestRank && route.ID < bestMatch.ID) {
 			bestMatch = route
-			bestScore = score
+			bestRank = rank
 		}
 	}
 
+	if trace != nil {
+		trace.finish(bestMatch)
+	}
+
 	return bestMatch
 }
 
+// matchHost returns the host rank of a route host pattern against a request
+// host, or -1 if it does not match
+func matchHost(pattern, host string) int {
+	pattern = strings.ToLower(pattern)
+	switch {
+	case pattern == "":
+		return hostAny
+	case pattern == host:
+		return hostExact
+	case strings.HasPrefix(pattern, "*."):
+		// One-level subdomains only: *.example.com matches a.example.com,
+		// but neither example.com nor a.b.example.com
+		label, ok := strings.CutSuffix(host, pattern[1:])
+		if ok && label != "" && !strings.Contains(label, ".") {
+			return hostWildcard
+		}
+	}
+	return -1
+}
+
+// validateHost checks that a wildcard appears only as the leftmost label of a route host
+func validateHost(host string) error {
+	if strings.Contains(strings.TrimPrefix(host, "*."), "*") || host == "*." {
+		return fmt.Errorf("invalid route host: %s: a wildcard must be the whole leftmost label", host)
+	}
+	return nil
+}
+
+// stripPrefix returns a shallow copy of the request with prefix removed from its path.
+// The prefix is passed upstream in X-Forwarded-Prefix so it can build links.
+func stripPrefix(req *http.Request, prefix string) *http.Request {
+	stripped := req.Clone(req.Context())
+	stripped.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, prefix), "/")
+	if rawPath, ok := strings.CutPrefix(req.URL.RawPath, prefix); ok {
+		stripped.URL.RawPath = "/" + strings.TrimPrefix(rawPath, "/")
+	} else {
+		stripped.URL.RawPath = ""
+	}
+	if forwarded := strings.TrimSuffix(prefix, "/"); forwarded != "" {
+		stripped.Header.Set("X-Forwarded-Prefix", forwarded)
+	}
+	return stripped
+}
+
+// methodAllowed reports whether the route accepts the request method
+func methodAllowed(route *Route, method string) bool {
+	if len(route.Methods) == 0 {
+		return true
+	}
+	for _, m := range route.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// headersMatch reports whether the request carries every header value the route requires
+func headersMatch(route *Route, header http.Header) bool {
+	for name, value := range route.Headers {
+		if header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// routeSpecificity counts the method and header constraints of a route
+func routeSpecificity(route *Route) int {
+	specificity := len(route.Headers)
+	if len(route.Methods) > 0 {
+		specificity++
+	}
+	return specificity
+}
+
 // recordRequest records request metrics
 func (r *Router) recordRequest(routeID string, duration time.Duration) {
 	r.metrics.mu.Lock()
@@ -233,6 +615,8 @@ func (r *Router) recordError(routeID string) {
 
 // GetMetrics returns current metrics
 func (r *Router) GetMetrics() *Metrics {
+	circuits := r.CircuitStates()
+
 	r.metrics.mu.RLock()
 	defer r.metrics.mu.RUnlock()
 
@@ -241,6 +625,9 @@ func (r *Router) GetMetrics() *Metrics {
 		RequestCount:  make(map[string]int64),
 		ErrorCount:    make(map[string]int64),
 		ResponseTimes: make(map[string]int64),
+		Upstreams:     make(map[string]map[string]*UpstreamMetrics),
+		Cache:         make(map[string]*CacheMetrics),
+		Circuits:      circuits,
 	}
 
 	for k, v := range r.metrics.RequestCount {
@@ -252,19 +639,78 @@ func (r *Router) GetMetrics() *Metrics {
 	for k, v := range r.metrics.ResponseTimes {
 		metrics.ResponseTimes[k] = v
 	}
+	for routeID, byUpstream := range r.metrics.Upstreams {
+		copied := make(map[string]*UpstreamMetrics, len(byUpstream))
+		for upstream, m := range byUpstream {
+			value := *m
+			value.ResponseTimeBuckets = append([]int64(nil), m.ResponseTimeBuckets...)
+			copied[upstream] = &value
+		}
+		metrics.Upstreams[routeID] = copied
+	}
+	for routeID, m := range r.metrics.Cache {
+		value := *m
+		if lookups := value.Hits + value.Misses; lookups > 0 {
+			value.HitRatio = float64(value.Hits) / float64(lookups)
+		}
+		metrics.Cache[routeID] = &value
+	}
 
 	return metrics
 }
 
-// HealthCheck checks if the router is healthy
+// HealthCheck checks that routes are configured and that at least one of
+// their upstreams accepts connections, returning ErrNoUpstreamReachable when
+// none does
 func (r *Router) HealthCheck(ctx context.Context) error {
 	r.mu.RLock()
 	routeCount := len(r.routes)
+	seen := make(map[string]bool)
+	var addresses []string
+	for _, pool := range r.proxies {
+		for _, b := range pool.backends {
+			address, err := dialAddress(b.url)
+			if b.weight == 0 || err != nil || seen[address] {
+				continue
+			}
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
 	r.mu.RUnlock()
 
 	if routeCount == 0 {
 		return fmt.Errorf("no routes configured")
 	}
+	if len(addresses) == 0 {
+		return fmt.Errorf("%w: no upstream has weight", ErrNoUpstreamReachable)
+	}
+	sort.Strings(addresses)
+
+	dialer := net.Dialer{Timeout: upstreamDialTimeout}
+	var lastErr error
+	for _, address := range addresses {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("%w: %v", ErrNoUpstreamReachable, lastErr)
+}
 
-	return nil
+// dialAddress returns the host and port to connect to for an upstream URL
+func dialAddress(rawURL string) (string, error) {
+	upstream, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if upstream.Port() != "" {
+		return upstream.Host, nil
+	}
+	if upstream.Scheme == "https" {
+		return net.JoinHostPort(upstream.Hostname(), "443"), nil
+	}
+	return net.JoinHostPort(upstream.Hostname(), "80"), nil
 }
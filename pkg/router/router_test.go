@@ -2,6 +2,7 @@ package router
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -64,6 +65,23 @@ func TestAddRoute(t *testing.T) {
 			},
 			expectedError: true,
 		},
+		{
+			name: "upstream without scheme",
+			route: &Route{
+				ID:         "test-route-5",
+				PathPrefix: "/api",
+				Upstream:   "localhost:8080",
+			},
+			expectedError: true,
+		},
+		{
+			name: "missing route ID",
+			route: &Route{
+				PathPrefix: "/api",
+				Upstream:   "http://localhost:8080",
+			},
+			expectedError: true,
+		},
 		{
 			name: "https upstream",
 			route: &Route{
@@ -108,6 +126,21 @@ func TestAddRoute(t *testing.T) {
 	}
 }
 
+func TestAddDuplicateRoute(t *testing.T) {
+	router := NewRouter(&config.Config{})
+
+	require.NoError(t, router.AddRoute(&Route{ID: "api", Upstream: "http://localhost:8080"}))
+	err := router.AddRoute(&Route{ID: "api", Upstream: "http://localhost:9090"})
+	assert.ErrorIs(t, err, ErrRouteExists)
+
+	route, err := router.GetRoute("api")
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080", route.Upstream)
+
+	_, err = router.GetRoute("missing")
+	assert.ErrorIs(t, err, ErrRouteNotFound)
+}
+
 func TestRemoveRoute(t *testing.T) {
 	router := NewRouter(&config.Config{})
 
@@ -372,7 +405,7 @@ func TestServeHTTP(t *testing.T) {
 			name:           "no matching route",
 			path:           "/notfound",
 			expectedStatus: http.StatusNotFound,
-			expectedBody:   "404 page not found\n",
+			expectedBody:   "<h1>404 Not Found</h1>",
 		},
 	}
 
@@ -384,7 +417,7 @@ func TestServeHTTP(t *testing.T) {
 			router.ServeHTTP(w, req)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
-			assert.Equal(t, tt.expectedBody, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.expectedBody)
 		})
 	}
 }
@@ -514,19 +547,42 @@ func TestHealthCheck(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "no routes configured")
 
-	// Add a route
-	route := &Route{
+	// A route whose upstream refuses connections degrades the router
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL
+	down.Close()
+	require.NoError(t, router.AddRoute(&Route{
 		ID:         "test-route",
 		Host:       "example.com",
 		PathPrefix: "/api",
-		Upstream:   "http://localhost:8080",
-	}
-	err = router.AddRoute(route)
-	require.NoError(t, err)
-
-	// Health check should pass with routes
+		Upstream:   downURL,
+	}))
 	err = router.HealthCheck(ctx)
-	assert.NoError(t, err)
+	assert.ErrorIs(t, err, ErrNoUpstreamReachable)
+
+	// One reachable upstream is enough
+	up := httptest.NewServer(http.NotFoundHandler())
+	defer up.Close()
+	require.NoError(t, router.AddRoute(&Route{
+		ID:         "other-route",
+		PathPrefix: "/",
+		Upstreams: []*WeightedUpstream{
+			{URL: downURL, Weight: 1},
+			{URL: up.URL, Weight: 1},
+		},
+	}))
+	assert.NoError(t, router.HealthCheck(ctx))
+
+	// Upstreams without weight receive no traffic and do not count
+	require.NoError(t, router.UpdateRoute(&Route{
+		ID:         "other-route",
+		PathPrefix: "/",
+		Upstreams: []*WeightedUpstream{
+			{URL: downURL, Weight: 1},
+			{URL: up.URL, Weight: 0},
+		},
+	}))
+	assert.ErrorIs(t, router.HealthCheck(ctx), ErrNoUpstreamReachable)
 }
 
 func TestConcurrentAccess(t *testing.T) {
@@ -576,4 +632,184 @@ func TestConcurrentAccess(t *testing.T) {
 	// Verify all routes were removed
 	routes = router.ListRoutes()
 	assert.Empty(t, routes)
-}
\ No newline at end of file
+}
+func TestForceHTTPS(t *testing.T) {
+	backend := newNamedBackend(t, "backend")
+
+	tests := []struct {
+		name     string
+		global   bool
+		route    bool
+		port     int
+		host     string
+		tls      bool
+		location string
+	}{
+		{name: "not forced", host: "example.com"},
+		{name: "route flag", route: true, port: 443, host: "example.com:8080", location: "https://example.com/app/x?y=1"},
+		{name: "global flag", global: true, port: 8443, host: "example.com", location: "https://example.com:8443/app/x?y=1"},
+		{name: "ipv6 host", route: true, port: 443, host: "[::1]:8080", location: "https://[::1]/app/x?y=1"},
+		{name: "already https", route: true, port: 443, host: "example.com", tls: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Gate.Ports.HTTPS = tt.port
+			cfg.Gate.TLS.ForceHTTPS = tt.global
+
+			router := NewRouter(cfg)
+			require.NoError(t, router.AddRoute(&Route{ID: "app", PathPrefix: "/app", Upstream: backend.URL, ForceHTTPS: tt.route}))
+
+			req := httptest.NewRequest("POST", "/app/x?y=1", nil)
+			req.Host = tt.host
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if tt.location == "" {
+				assert.Equal(t, http.StatusOK, w.Code)
+				assert.Equal(t, "backend", w.Body.String())
+				return
+			}
+			assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestRouteMatchingOrder(t *testing.T) {
+	router := NewRouter(&config.Config{})
+	routes := []*Route{
+		{ID: "default", PathPrefix: "/"},
+		{ID: "apps", Host: "*.apps.example.com", PathPrefix: "/"},
+		{ID: "apps-api", Host: "*.apps.example.com", PathPrefix: "/api"},
+		{ID: "admin", Host: "admin.apps.example.com", PathPrefix: "/"},
+		{ID: "status", PathPrefix: "/status"},
+		{ID: "status-override", Host: "*.apps.example.com", PathPrefix: "/status", Priority: -1},
+		{ID: "maintenance", Host: "legacy.example.com", PathPrefix: "/", Priority: 10},
+		{ID: "legacy-api", Host: "legacy.example.com", PathPrefix: "/api"},
+		{ID: "tie-b", Host: "tie.example.com", PathPrefix: "/"},
+		{ID: "tie-a", Host: "tie.example.com", PathPrefix: "/"},
+	}
+	for _, route := range routes {
+		route.Upstream = "http://127.0.0.1:8080"
+		require.NoError(t, router.AddRoute(route))
+	}
+
+	tests := []struct {
+		name     string
+		host     string
+		path     string
+		expected string
+	}{
+		{name: "wildcard subdomain", host: "shop.apps.example.com", path: "/", expected: "apps"},
+		{name: "wildcard with longer prefix", host: "shop.apps.example.com", path: "/api/items", expected: "apps-api"},
+		{name: "exact beats wildcard", host: "admin.apps.example.com", path: "/", expected: "admin"},
+		{name: "exact host beats longer wildcard prefix", host: "admin.apps.example.com", path: "/api/items", expected: "admin"},
+		{name: "host match is case insensitive", host: "Shop.Apps.Example.com:8443", path: "/", expected: "apps"},
+		{name: "wildcard does not match apex", host: "apps.example.com", path: "/", expected: "default"},
+		{name: "wildcard matches one level only", host: "a.b.apps.example.com", path: "/", expected: "default"},
+		{name: "negative priority ranks below defaults", host: "shop.apps.example.com", path: "/status", expected: "apps"},
+		{name: "priority beats longer prefix", host: "legacy.example.com", path: "/api", expected: "maintenance"},
+		{name: "ties broken by route ID", host: "tie.example.com", path: "/", expected: "tie-a"},
+		{name: "no host falls back", host: "other.example.com", path: "/status/x", expected: "status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Host = tt.host
+			route := router.findRoute(req)
+			require.NotNil(t, route)
+			assert.Equal(t, tt.expected, route.ID)
+		})
+	}
+
+	t.Run("invalid wildcard", func(t *testing.T) {
+		for _, host := range []string{"*", "*.", "api.*.example.com", "**.example.com"} {
+			err := router.AddRoute(&Route{ID: "bad", Host: host, Upstream: "http://127.0.0.1:8080"})
+			assert.Error(t, err, host)
+		}
+	})
+}
+
+func TestStripPrefix(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.URL.EscapedPath(), r.Header.Get("X-Forwarded-Prefix"))
+	}))
+	defer backend.Close()
+
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{ID: "console", PathPrefix: "/console", StripPrefix: true, Upstream: backend.URL}))
+	require.NoError(t, router.AddRoute(&Route{ID: "docs", PathPrefix: "/docs/", StripPrefix: true, Upstream: backend.URL}))
+	require.NoError(t, router.AddRoute(&Route{ID: "keep", PathPrefix: "/keep", Upstream: backend.URL}))
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "/console", expected: "/ /console"},
+		{path: "/console/", expected: "/ /console"},
+		{path: "/console/services?x=1", expected: "/services /console"},
+		{path: "/console/a%2Fb", expected: "/a%2Fb /console"},
+		{path: "/docs/guide", expected: "/guide /docs"},
+		{path: "/keep/x", expected: "/keep/x "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expected, w.Body.String())
+		})
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	received := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Request-ID")
+		// Upstreams echo the ID like the console does
+		w.Header().Set("X-Request-ID", r.Header.Get("X-Request-ID"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{ID: "app", PathPrefix: "/app", StripPrefix: true, Upstream: backend.URL}))
+
+	t.Run("forwards incoming ID", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/app/items", nil)
+		req.Header.Set("X-Request-ID", "req-abc")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "req-abc", <-received)
+		assert.Equal(t, []string{"req-abc"}, w.Header().Values("X-Request-ID"))
+	})
+
+	t.Run("generates missing ID", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/app/items", nil))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		id := <-received
+		assert.NotEmpty(t, id)
+		assert.Equal(t, []string{id}, w.Header().Values("X-Request-ID"))
+	})
+
+	t.Run("gate errors carry the ID", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/unrouted", nil)
+		req.Header.Set("X-Request-ID", "req-404")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "req-404", w.Header().Get("X-Request-ID"))
+	})
+}
@@ -0,0 +1,160 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+const (
+	// DefaultReadyInterval is the wait between the first polls of an
+	// upstream's ready path, doubled after each failed one
+	DefaultReadyInterval = time.Second
+
+	// maxReadyInterval bounds the wait between polls
+	maxReadyInterval = 30 * time.Second
+
+	// readyTimeout bounds each poll
+	readyTimeout = 5 * time.Second
+
+	// startingRetryAfter is the Retry-After of the starting page, in seconds
+	startingRetryAfter = 5
+)
+
+// startingPage is served by routes held until their upstream is ready
+var startingPage = template.Must(template.New("starting").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.}}">
+<title>Infra-Core is starting up</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #0f172a; color: #e2e8f0; display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+main { text-align: center; }
+h1 { font-size: 1.5rem; margin-bottom: 0.5rem; }
+p { color: #94a3b8; }
+</style>
+</head>
+<body>
+<main>
+<h1>Infra-Core is starting up</h1>
+<p>This page reloads in {{.}} seconds.</p>
+</main>
+</body>
+</html>
+`))
+
+// writeStartingPage answers a request to a held route with 503 and the
+// starting page
+func writeStartingPage(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", fmt.Sprint(startingRetryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	startingPage.Execute(w, startingRetryAfter)
+}
+
+// StaticRoute converts a static route of the gate config into a route, held
+// when it has a ready path
+func StaticRoute(cfg config.StaticRouteConfig) *Route {
+	path := cfg.Path
+	if path == "" {
+		path = "/"
+	}
+	return &Route{
+		ID:          cfg.ID,
+		Host:        cfg.Host,
+		PathPrefix:  path,
+		Priority:    cfg.Priority,
+		StripPrefix: cfg.StripPrefix,
+		Upstream:    cfg.Upstream,
+		Holding:     cfg.ReadyPath != "",
+		ServiceID:   cfg.ServiceID,
+	}
+}
+
+// ReadyURL returns the URL polled before proxying a static route, or ""
+// when it is proxied at once
+func ReadyURL(cfg config.StaticRouteConfig) string {
+	if cfg.ReadyPath == "" {
+		return ""
+	}
+	return strings.TrimSuffix(cfg.Upstream, "/") + cfg.ReadyPath
+}
+
+// Release stops holding a route, proxying its requests from now on
+func (r *Router) Release(routeID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	route, exists := r.routes[routeID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrRouteNotFound, routeID)
+	}
+	// Routes are read without the lock while serving, so the held one is
+	// replaced rather than changed
+	released := *route
+	released.Holding = false
+	released.UpdatedAt = time.Now()
+	r.routes[routeID] = &released
+	return nil
+}
+
+// ReleaseWhenReady polls readyURL, waiting interval after the first failed
+// poll and twice as long after each next one, until it answers with a 2xx
+// status, then releases the routes. It gives up when ctx is done.
+func (r *Router) ReleaseWhenReady(ctx context.Context, readyURL string, interval time.Duration, routeIDs ...string) {
+	client := &http.Client{Timeout: readyTimeout}
+	start := time.Now()
+	delay := interval
+	for attempt := 1; ; attempt++ {
+		err := pollReady(ctx, client, readyURL)
+		if err == nil {
+			break
+		}
+		if attempt == 1 {
+			log.Printf("⏳ Holding routes %s until %s is ready: %v", strings.Join(routeIDs, ", "), readyURL, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		delay = min(delay*2, maxReadyInterval)
+	}
+
+	for _, routeID := range routeIDs {
+		if err := r.Release(routeID); err != nil {
+			log.Printf("❌ Failed to release route %s: %v", routeID, err)
+			continue
+		}
+		log.Printf("✅ Upstream %s is ready after %s, proxying route %s", readyURL, time.Since(start).Round(time.Millisecond), routeID)
+	}
+}
+
+// pollReady requests a ready URL once, failing unless it answers with a 2xx
+// status
+func pollReady(ctx context.Context, client *http.Client, readyURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, readyURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+func TestStaticRoute(t *testing.T) {
+	route := StaticRoute(config.StaticRouteConfig{ID: "console", Upstream: "http://127.0.0.1:8082/", ReadyPath: "/health/ready"})
+	assert.Equal(t, "/", route.PathPrefix, "the path defaults to every path")
+	assert.True(t, route.Holding)
+	assert.Equal(t, "http://127.0.0.1:8082/health/ready", ReadyURL(config.StaticRouteConfig{Upstream: "http://127.0.0.1:8082/", ReadyPath: "/health/ready"}))
+
+	cfg := config.StaticRouteConfig{ID: "docs", Host: "docs.example.com", Path: "/v2", Upstream: "http://127.0.0.1:4000", StripPrefix: true, Priority: 10}
+	route = StaticRoute(cfg)
+	assert.Equal(t, &Route{ID: "docs", Host: "docs.example.com", PathPrefix: "/v2", Priority: 10, StripPrefix: true, Upstream: "http://127.0.0.1:4000"}, route)
+	assert.Empty(t, ReadyURL(cfg), "routes without a ready path are proxied at once")
+}
+
+func TestReleaseWhenReady(t *testing.T) {
+	var ready atomic.Bool
+	var polls atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health/ready" {
+			polls.Add(1)
+			if !ready.Load() {
+				http.Error(w, "starting", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprint(w, "console")
+	}))
+	defer upstream.Close()
+
+	router := NewRouter(&config.Config{})
+	cfg := config.StaticRouteConfig{ID: "console", Upstream: upstream.URL, ReadyPath: "/health/ready"}
+	require.NoError(t, router.AddRoute(StaticRoute(cfg)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	released := make(chan struct{})
+	go func() {
+		router.ReleaseWhenReady(ctx, ReadyURL(cfg), 10*time.Millisecond, "console")
+		close(released)
+	}()
+
+	// The starting page is served while the upstream is not ready
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), "Infra-Core is starting up")
+	require.Eventually(t, func() bool { return polls.Load() >= 2 }, time.Second, 5*time.Millisecond, "polled again after failing")
+
+	ready.Store(true)
+	select {
+	case <-released:
+	case <-time.After(2 * time.Second):
+		t.Fatal("route not released once the upstream was ready")
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "console", w.Body.String())
+	route, err := router.GetRoute("console")
+	require.NoError(t, err)
+	assert.False(t, route.Holding)
+
+	assert.ErrorIs(t, router.Release("missing"), ErrRouteNotFound)
+}
+
+func TestReleaseWhenReadyStopsWithContext(t *testing.T) {
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{ID: "app", PathPrefix: "/", Upstream: "http://127.0.0.1:1", Holding: true}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		router.ReleaseWhenReady(ctx, "http://127.0.0.1:1/ready", time.Hour, "app")
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("polling did not stop with its context")
+	}
+
+	route, err := router.GetRoute("app")
+	require.NoError(t, err)
+	assert.True(t, route.Holding, "still held")
+}
@@ -0,0 +1,186 @@
+package router
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// websocketGUID is the key suffix used to compute Sec-WebSocket-Accept (RFC 6455)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// newEchoWebSocketBackend completes a WebSocket handshake and echoes every byte back
+func newEchoWebSocketBackend(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			http.Error(w, "expected websocket upgrade", http.StatusBadRequest)
+			return
+		}
+
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			base64.StdEncoding.EncodeToString(sum[:]))
+		rw.Flush()
+
+		io.Copy(conn, rw)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dialWebSocket opens a WebSocket connection to addr and returns it after the handshake
+func dialWebSocket(t *testing.T, addr string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: app.example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	return conn, reader, resp
+}
+
+func TestWebSocketProxy(t *testing.T) {
+	backend := newEchoWebSocketBackend(t)
+
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{ID: "ws", PathPrefix: "/", Upstream: backend.URL}))
+
+	gate := httptest.NewServer(router)
+	defer gate.Close()
+
+	conn, reader, resp := dialWebSocket(t, gate.Listener.Addr().String())
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+
+	// Messages round-trip through the tunnel in both directions
+	for _, message := range []string{"hello", "world"} {
+		_, err := conn.Write([]byte(message))
+		require.NoError(t, err)
+
+		buf := make([]byte, len(message))
+		_, err = io.ReadFull(reader, buf)
+		require.NoError(t, err)
+		assert.Equal(t, message, string(buf))
+	}
+
+	// The request is counted once the tunnel closes, with its full duration
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, router.GetMetrics().Upstreams["ws"])
+	conn.Close()
+
+	require.Eventually(t, func() bool {
+		m := router.GetMetrics().Upstreams["ws"][backend.URL]
+		return m != nil && m.RequestCount == 1
+	}, time.Second, 5*time.Millisecond)
+	m := router.GetMetrics().Upstreams["ws"][backend.URL]
+	assert.GreaterOrEqual(t, time.Duration(m.ResponseTime), 20*time.Millisecond)
+	assert.Zero(t, m.ErrorCount)
+}
+
+func TestWebSocketUpstreamDown(t *testing.T) {
+	backend := newEchoWebSocketBackend(t)
+	backend.Close()
+
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{ID: "ws", PathPrefix: "/", Upstream: backend.URL}))
+
+	gate := httptest.NewServer(router)
+	defer gate.Close()
+
+	_, _, resp := dialWebSocket(t, gate.Listener.Addr().String())
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, int64(1), router.GetMetrics().Upstreams["ws"][backend.URL].ErrorCount)
+}
+
+func TestServerSentEventsProxy(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= 2; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			<-release
+		}
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{ID: "sse", PathPrefix: "/", Upstream: backend.URL}))
+
+	gate := &http.Server{Handler: router, ReadTimeout: 50 * time.Millisecond, WriteTimeout: 50 * time.Millisecond}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go gate.Serve(listener)
+	defer gate.Close()
+
+	req, err := http.NewRequest("GET", "http://"+listener.Addr().String()+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		_, err = reader.ReadString('\n')
+		require.NoError(t, err)
+		return line
+	}
+
+	// Each event arrives while the upstream is still writing, and the stream
+	// outlives the server's timeouts
+	assert.Equal(t, "data: 1\n", readEvent())
+	time.Sleep(100 * time.Millisecond)
+	release <- struct{}{}
+	assert.Equal(t, "data: 2\n", readEvent())
+}
+
+func TestIsStreaming(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected bool
+	}{
+		{name: "plain request", headers: map[string]string{"Accept": "text/html"}},
+		{name: "websocket", headers: map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}, expected: true},
+		{name: "connection token list", headers: map[string]string{"Connection": "keep-alive, upgrade", "Upgrade": "websocket"}, expected: true},
+		{name: "upgrade without connection token", headers: map[string]string{"Upgrade": "websocket"}},
+		{name: "event stream", headers: map[string]string{"Accept": "text/event-stream"}, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.expected, isStreaming(req))
+		})
+	}
+}
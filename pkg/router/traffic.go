@@ -0,0 +1,272 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// Traffic export defaults used when the gate config leaves them unset
+const (
+	DefaultTrafficFlushInterval = time.Minute
+	DefaultTrafficMaxBuffered   = 10000
+)
+
+// trafficFinalFlushTimeout bounds the flush made when the exporter stops
+const trafficFinalFlushTimeout = 10 * time.Second
+
+// Scopes of exported traffic metrics: the route's service when it names
+// one, otherwise the route
+const (
+	metricScopeRoute   = "route"
+	metricScopeService = "service"
+)
+
+// Traffic metrics written per route and flush interval. Errors are
+// responses with a 5xx status; latencies are estimated from the response
+// time histogram of the interval.
+const (
+	TrafficMetricRequests   = "gate_requests"
+	TrafficMetricErrors     = "gate_errors"
+	TrafficMetricLatencyP50 = "gate_latency_p50_ms"
+	TrafficMetricLatencyP95 = "gate_latency_p95_ms"
+	TrafficMetricLatencyP99 = "gate_latency_p99_ms"
+)
+
+// MetricsWriter stores exported metrics, such as in the console's metrics
+// table. A failed write is retried with the metrics of the next flush.
+type MetricsWriter interface {
+	WriteMetrics(ctx context.Context, metrics []*database.Metric) error
+}
+
+// MetricsWriterFunc lets a function be used as a MetricsWriter
+type MetricsWriterFunc func(ctx context.Context, metrics []*database.Metric) error
+
+// WriteMetrics calls f
+func (f MetricsWriterFunc) WriteMetrics(ctx context.Context, metrics []*database.Metric) error {
+	return f(ctx, metrics)
+}
+
+// routeTraffic is the traffic of a route since the last flush. Buckets
+// counts responses per ResponseTimeBuckets bucket, the last one counting
+// those slower than every bucket.
+type routeTraffic struct {
+	requests int64
+	errors   int64
+	slowest  time.Duration
+	buckets  []int64
+}
+
+// TrafficExporter aggregates the requests the router serves per route and
+// periodically writes their counts, error counts and latency percentiles
+// to a MetricsWriter. Metrics that could not be written are kept, up to a
+// cap beyond which the oldest are dropped, and written with the next flush.
+type TrafficExporter struct {
+	router      *Router
+	writer      MetricsWriter
+	interval    time.Duration
+	maxBuffered int
+
+	mu      sync.Mutex
+	traffic map[string]*routeTraffic
+
+	// Metrics not written yet, oldest first
+	flushMu sync.Mutex
+	pending []*database.Metric
+	dropped atomic.Int64
+}
+
+// NewTrafficExporter creates an exporter of the traffic of r to writer.
+// Set it on the router with SetTrafficExporter and run it with Run.
+func NewTrafficExporter(r *Router, writer MetricsWriter, cfg config.GateMetricsExportConfig) *TrafficExporter {
+	interval, _ := time.ParseDuration(cfg.FlushInterval) // validated on load
+	if interval <= 0 {
+		interval = DefaultTrafficFlushInterval
+	}
+	maxBuffered := cfg.MaxBuffered
+	if maxBuffered <= 0 {
+		maxBuffered = DefaultTrafficMaxBuffered
+	}
+	return &TrafficExporter{
+		router:      r,
+		writer:      writer,
+		interval:    interval,
+		maxBuffered: maxBuffered,
+		traffic:     make(map[string]*routeTraffic),
+	}
+}
+
+// SetTrafficExporter aggregates every request served from now on in e, or
+// stops when e is nil. Call it before serving.
+func (r *Router) SetTrafficExporter(e *TrafficExporter) {
+	r.traffic = e
+}
+
+// record counts a response of a route
+func (e *TrafficExporter) record(routeID string, status int, duration time.Duration) {
+	if routeID == "" {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	t, ok := e.traffic[routeID]
+	if !ok {
+		t = &routeTraffic{buckets: make([]int64, len(ResponseTimeBuckets)+1)}
+		e.traffic[routeID] = t
+	}
+	t.requests++
+	if status >= 500 {
+		t.errors++
+	}
+	if duration > t.slowest {
+		t.slowest = duration
+	}
+	t.buckets[sort.SearchFloat64s(ResponseTimeBuckets, duration.Seconds())]++
+}
+
+// Run flushes every interval until ctx is done, then flushes once more
+func (e *TrafficExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), trafficFinalFlushTimeout)
+			if err := e.Flush(flushCtx); err != nil {
+				log.Printf("❌ Dropping %d traffic metrics on shutdown: %v", e.Pending(), err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := e.Flush(ctx); err != nil {
+				log.Printf("⚠️ Keeping %d traffic metrics to retry: %v", e.Pending(), err)
+			}
+		}
+	}
+}
+
+// Flush writes the traffic since the last flush, together with the metrics
+// earlier flushes failed to write
+func (e *TrafficExporter) Flush(ctx context.Context) error {
+	now := time.Now().UTC()
+	e.mu.Lock()
+	traffic := e.traffic
+	e.traffic = make(map[string]*routeTraffic)
+	e.mu.Unlock()
+
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	e.pending = append(e.pending, e.metrics(traffic, now)...)
+	if over := len(e.pending) - e.maxBuffered; over > 0 {
+		e.pending = append([]*database.Metric(nil), e.pending[over:]...)
+		e.dropped.Add(int64(over))
+	}
+	if len(e.pending) == 0 {
+		return nil
+	}
+
+	if err := e.writer.WriteMetrics(ctx, e.pending); err != nil {
+		return fmt.Errorf("failed to write traffic metrics: %w", err)
+	}
+	e.pending = nil
+	return nil
+}
+
+// Pending returns how many metrics wait to be written
+func (e *TrafficExporter) Pending() int {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+	return len(e.pending)
+}
+
+// Dropped returns how many metrics were dropped because too many waited
+// to be written
+func (e *TrafficExporter) Dropped() int64 {
+	return e.dropped.Load()
+}
+
+// metrics returns the metrics of the traffic of an interval ending at now
+func (e *TrafficExporter) metrics(traffic map[string]*routeTraffic, now time.Time) []*database.Metric {
+	routeIDs := make([]string, 0, len(traffic))
+	for routeID := range traffic {
+		routeIDs = append(routeIDs, routeID)
+	}
+	sort.Strings(routeIDs)
+
+	metrics := make([]*database.Metric, 0, 5*len(routeIDs))
+	for _, routeID := range routeIDs {
+		t := traffic[routeID]
+
+		scopeType, scopeID := metricScopeRoute, routeID
+		labels := map[string]string{"route": routeID}
+		e.router.mu.RLock()
+		if route, ok := e.router.routes[routeID]; ok {
+			if route.ServiceID != "" {
+				scopeType, scopeID = metricScopeService, route.ServiceID
+			}
+			if route.Host != "" {
+				labels["host"] = route.Host
+			}
+		}
+		e.router.mu.RUnlock()
+		encoded, _ := json.Marshal(labels)
+		labelsJSON := string(encoded)
+
+		add := func(name string, value float64) {
+			metrics = append(metrics, &database.Metric{
+				Timestamp:   now,
+				ScopeType:   scopeType,
+				ScopeID:     scopeID,
+				MetricName:  name,
+				MetricValue: value,
+				Labels:      &labelsJSON,
+			})
+		}
+		add(TrafficMetricRequests, float64(t.requests))
+		add(TrafficMetricErrors, float64(t.errors))
+		add(TrafficMetricLatencyP50, t.percentile(0.50))
+		add(TrafficMetricLatencyP95, t.percentile(0.95))
+		add(TrafficMetricLatencyP99, t.percentile(0.99))
+	}
+	return metrics
+}
+
+// percentile estimates a response time percentile in milliseconds,
+// interpolating within the histogram bucket it falls in. Estimates never
+// exceed the slowest response.
+func (t *routeTraffic) percentile(q float64) float64 {
+	slowest := float64(t.slowest.Microseconds()) / 1000
+	rank := q * float64(t.requests)
+
+	var seen int64
+	lower := 0.0
+	for i, count := range t.buckets {
+		if i == len(ResponseTimeBuckets) {
+			// Slower than every bucket
+			return slowest
+		}
+		upper := ResponseTimeBuckets[i] * 1000
+		if count > 0 && float64(seen+count) >= rank {
+			estimate := lower + (upper-lower)*(rank-float64(seen))/float64(count)
+			if estimate > slowest {
+				return slowest
+			}
+			return estimate
+		}
+		seen += count
+		lower = upper
+	}
+	return slowest
+}
@@ -0,0 +1,172 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// metricValues returns the value of each metric by name
+func metricValues(metrics []*database.Metric) map[string]float64 {
+	values := make(map[string]float64, len(metrics))
+	for _, metric := range metrics {
+		values[metric.MetricName] = metric.MetricValue
+	}
+	return values
+}
+
+func TestTrafficExport(t *testing.T) {
+	db, err := database.NewDB(&config.Config{
+		Console: config.ConsoleConfig{Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")}},
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	router := NewRouter(&config.Config{})
+	exporter := NewTrafficExporter(router, MetricsWriterFunc(func(ctx context.Context, metrics []*database.Metric) error {
+		return db.WithContext(ctx).MetricRepository().InsertBatch(metrics)
+	}), config.GateMetricsExportConfig{})
+	router.SetTrafficExporter(exporter)
+	require.NoError(t, router.AddRoute(&Route{ID: "api", Host: "api.example.com", Upstream: backend.URL, ServiceID: "svc-api"}))
+	require.NoError(t, router.AddRoute(&Route{ID: "docs", Host: "docs.example.com", Upstream: backend.URL}))
+
+	serve := func(target string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w.Code
+	}
+	for i := 0; i < 4; i++ {
+		require.Equal(t, http.StatusOK, serve("http://api.example.com/users"))
+	}
+	require.Equal(t, http.StatusInternalServerError, serve("http://api.example.com/fail"))
+	require.Equal(t, http.StatusOK, serve("http://docs.example.com/"))
+	require.Equal(t, http.StatusNotFound, serve("http://unknown.example.com/"), "requests no route matched are not exported")
+
+	require.NoError(t, exporter.Flush(context.Background()))
+	assert.Zero(t, exporter.Pending())
+
+	// Routes of a service are recorded as the service
+	metrics, err := db.MetricRepository().GetByService("svc-api", 100)
+	require.NoError(t, err)
+	require.Len(t, metrics, 5)
+	values := metricValues(metrics)
+	assert.Equal(t, 5.0, values[TrafficMetricRequests])
+	assert.Equal(t, 1.0, values[TrafficMetricErrors])
+	assert.Positive(t, values[TrafficMetricLatencyP50])
+	assert.LessOrEqual(t, values[TrafficMetricLatencyP50], values[TrafficMetricLatencyP95])
+	assert.LessOrEqual(t, values[TrafficMetricLatencyP95], values[TrafficMetricLatencyP99])
+	require.NotNil(t, metrics[0].Labels)
+	assert.JSONEq(t, `{"route":"api","host":"api.example.com"}`, *metrics[0].Labels)
+
+	// Others as their route
+	since := time.Now().Add(-time.Minute)
+	docs, err := db.MetricRepository().Query("route", "docs", TrafficMetricRequests, since, time.Now().Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	assert.Equal(t, 1.0, docs[0].MetricValue)
+
+	// Each flush covers the traffic since the one before
+	require.NoError(t, exporter.Flush(context.Background()))
+	metrics, err = db.MetricRepository().GetByService("svc-api", 100)
+	require.NoError(t, err)
+	assert.Len(t, metrics, 5)
+}
+
+func TestTrafficExportRetry(t *testing.T) {
+	backend := newNamedBackend(t, "ok")
+	var written []*database.Metric
+	down := true
+	router := NewRouter(&config.Config{})
+	exporter := NewTrafficExporter(router, MetricsWriterFunc(func(ctx context.Context, metrics []*database.Metric) error {
+		if down {
+			return errors.New("console unreachable")
+		}
+		written = append(written, metrics...)
+		return nil
+	}), config.GateMetricsExportConfig{MaxBuffered: 12})
+	router.SetTrafficExporter(exporter)
+	require.NoError(t, router.AddRoute(&Route{ID: "app", Upstream: backend.URL}))
+
+	serve := func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// Metrics are kept while the console is down, up to the cap
+	serve()
+	assert.Error(t, exporter.Flush(context.Background()))
+	assert.Equal(t, 5, exporter.Pending())
+	serve()
+	serve()
+	assert.Error(t, exporter.Flush(context.Background()))
+	assert.Equal(t, 10, exporter.Pending())
+	serve()
+	assert.Error(t, exporter.Flush(context.Background()))
+	assert.Equal(t, 12, exporter.Pending())
+	assert.Equal(t, int64(3), exporter.Dropped())
+
+	// and written, oldest first, once it is back
+	down = false
+	require.NoError(t, exporter.Flush(context.Background()))
+	assert.Zero(t, exporter.Pending())
+	require.Len(t, written, 12)
+	var requests []float64
+	for _, metric := range written {
+		if metric.MetricName == TrafficMetricRequests {
+			requests = append(requests, metric.MetricValue)
+		}
+	}
+	assert.Equal(t, []float64{2, 1}, requests)
+
+	// Nothing is written when no traffic was served
+	written = nil
+	require.NoError(t, exporter.Flush(context.Background()))
+	assert.Empty(t, written)
+
+	var buf strings.Builder
+	require.NoError(t, router.WritePrometheus(&buf))
+	assert.Contains(t, buf.String(), "gate_metrics_export_dropped_total 3\n")
+}
+
+func TestTrafficPercentile(t *testing.T) {
+	exporter := NewTrafficExporter(NewRouter(&config.Config{}), nil, config.GateMetricsExportConfig{})
+	record := func(routeID string, d time.Duration, n int) {
+		for i := 0; i < n; i++ {
+			exporter.record(routeID, http.StatusOK, d)
+		}
+	}
+	record("app", 3*time.Millisecond, 90)
+	record("app", 40*time.Millisecond, 9)
+	record("app", 12*time.Second, 1)
+
+	traffic := exporter.traffic["app"]
+	assert.InDelta(t, 2.78, traffic.percentile(0.50), 0.01, "interpolated within the first bucket")
+	assert.InDelta(t, 38.89, traffic.percentile(0.95), 0.01)
+	assert.InDelta(t, 50, traffic.percentile(0.99), 0.01)
+	assert.Equal(t, 12000.0, traffic.percentile(1), "beyond the last bucket is the slowest response")
+
+	// Estimates never exceed the slowest response
+	record("fast", 60*time.Millisecond, 1)
+	assert.Equal(t, 60.0, exporter.traffic["fast"].percentile(0.99))
+}
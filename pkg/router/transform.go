@@ -0,0 +1,91 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// Transform rewrites requests and responses proxied for a route.
+// The path rewrite applies to the path after StripPrefix, and the forwarded
+// headers set by the gate cannot be added or removed.
+type Transform struct {
+	AddRequestHeaders      map[string]string `json:"add_request_headers,omitempty"`
+	RemoveRequestHeaders   []string          `json:"remove_request_headers,omitempty"`
+	AddResponseHeaders     map[string]string `json:"add_response_headers,omitempty"`
+	RemoveResponseHeaders  []string          `json:"remove_response_headers,omitempty"`
+	RewritePathRegex       string            `json:"rewrite_path_regex,omitempty"`
+	RewritePathReplacement string            `json:"rewrite_path_replacement,omitempty"`
+}
+
+// forwardedHeaders are always set by the gate from the client request
+var forwardedHeaders = []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "X-Real-IP"}
+
+// compiledTransform is a validated Transform ready to apply
+type compiledTransform struct {
+	*Transform
+	rewrite *regexp.Regexp
+}
+
+// compileTransform validates a route transform, returning nil when there is none
+func compileTransform(t *Transform) (*compiledTransform, error) {
+	if t == nil {
+		return nil, nil
+	}
+
+	compiled := &compiledTransform{Transform: t}
+
+	if t.RewritePathRegex != "" {
+		rewrite, err := regexp.Compile(t.RewritePathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite_path_regex: %w", err)
+		}
+		compiled.rewrite = rewrite
+	}
+
+	requestHeaders := append([]string(nil), t.RemoveRequestHeaders...)
+	for name := range t.AddRequestHeaders {
+		requestHeaders = append(requestHeaders, name)
+	}
+	for _, name := range requestHeaders {
+		for _, reserved := range forwardedHeaders {
+			if http.CanonicalHeaderKey(name) == http.CanonicalHeaderKey(reserved) {
+				return nil, fmt.Errorf("header %s is set by the gate and cannot be transformed", reserved)
+			}
+		}
+	}
+
+	return compiled, nil
+}
+
+// applyRequest rewrites the path and headers of an outgoing request
+func (t *compiledTransform) applyRequest(req *http.Request) {
+	if t == nil {
+		return
+	}
+
+	if t.rewrite != nil {
+		req.URL.Path = t.rewrite.ReplaceAllString(req.URL.Path, t.RewritePathReplacement)
+		req.URL.RawPath = ""
+	}
+	for _, name := range t.RemoveRequestHeaders {
+		req.Header.Del(name)
+	}
+	for name, value := range t.AddRequestHeaders {
+		req.Header.Set(name, value)
+	}
+}
+
+// applyResponse rewrites the headers of an upstream response
+func (t *compiledTransform) applyResponse(resp *http.Response) {
+	if t == nil {
+		return
+	}
+
+	for _, name := range t.RemoveResponseHeaders {
+		resp.Header.Del(name)
+	}
+	for name, value := range t.AddResponseHeaders {
+		resp.Header.Set(name, value)
+	}
+}
@@ -0,0 +1,139 @@
+package router
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// recordingBackend captures the last request it received
+type recordingBackend struct {
+	*httptest.Server
+	last *http.Request
+}
+
+func newRecordingBackend(t *testing.T) *recordingBackend {
+	rb := &recordingBackend{}
+	rb.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rb.last = r
+		w.Header().Set("Server", "upstream/1.0")
+		w.Header().Set("X-Upstream", "yes")
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(rb.Close)
+	return rb
+}
+
+func TestRouteTransform(t *testing.T) {
+	backend := newRecordingBackend(t)
+
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{
+		ID:         "billing",
+		Host:       "billing.example.com",
+		PathPrefix: "/billing",
+		Upstream:   backend.URL,
+		Transform: &Transform{
+			AddRequestHeaders:      map[string]string{"X-Service-Name": "billing"},
+			RemoveRequestHeaders:   []string{"Cookie"},
+			AddResponseHeaders:     map[string]string{"Strict-Transport-Security": "max-age=63072000"},
+			RemoveResponseHeaders:  []string{"Server"},
+			RewritePathRegex:       `^/billing/v1/(.*)$`,
+			RewritePathReplacement: "/api/$1",
+		},
+	}))
+
+	req := httptest.NewRequest("GET", "/billing/v1/invoices?page=2", nil)
+	req.Host = "billing.example.com"
+	req.RemoteAddr = "203.0.113.7:51234"
+	req.TLS = &tls.ConnectionState{}
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The upstream sees the rewritten path and transformed headers
+	seen := backend.last
+	require.NotNil(t, seen)
+	assert.Equal(t, "/api/invoices", seen.URL.Path)
+	assert.Equal(t, "page=2", seen.URL.RawQuery)
+	assert.Equal(t, "billing", seen.Header.Get("X-Service-Name"))
+	assert.Empty(t, seen.Header.Get("Cookie"))
+
+	// Forwarded headers describe the client request
+	assert.Equal(t, "https", seen.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "billing.example.com", seen.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "203.0.113.7", seen.Header.Get("X-Real-IP"))
+	assert.Equal(t, "198.51.100.1, 203.0.113.7", seen.Header.Get("X-Forwarded-For"))
+
+	// The client sees the transformed response headers
+	assert.Equal(t, "max-age=63072000", w.Header().Get("Strict-Transport-Security"))
+	assert.Empty(t, w.Header().Get("Server"))
+	assert.Equal(t, "yes", w.Header().Get("X-Upstream"))
+
+	t.Run("updated transform applies to reused proxies", func(t *testing.T) {
+		require.NoError(t, router.UpdateRoute(&Route{
+			ID:         "billing",
+			Host:       "billing.example.com",
+			PathPrefix: "/billing",
+			Upstream:   backend.URL,
+			Transform:  &Transform{AddRequestHeaders: map[string]string{"X-Service-Name": "billing-v2"}},
+		}))
+
+		req := httptest.NewRequest("GET", "/billing/v1/invoices", nil)
+		req.Host = "billing.example.com"
+		req.Header.Set("Cookie", "session=secret")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		seen := backend.last
+		assert.Equal(t, "/billing/v1/invoices", seen.URL.Path)
+		assert.Equal(t, "billing-v2", seen.Header.Get("X-Service-Name"))
+		assert.Equal(t, "session=secret", seen.Header.Get("Cookie"))
+		assert.Equal(t, "http", seen.Header.Get("X-Forwarded-Proto"))
+	})
+}
+
+func TestRouteTransformWithStripPrefix(t *testing.T) {
+	backend := newRecordingBackend(t)
+
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{
+		ID:          "console",
+		PathPrefix:  "/console",
+		StripPrefix: true,
+		Upstream:    backend.URL,
+		Transform:   &Transform{RewritePathRegex: `^/old/`, RewritePathReplacement: "/new/"},
+	}))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/console/old/page", nil))
+	require.NotNil(t, backend.last)
+	assert.Equal(t, "/new/page", backend.last.URL.Path)
+}
+
+func TestInvalidTransform(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform *Transform
+	}{
+		{name: "invalid regex", transform: &Transform{RewritePathRegex: `^/(unclosed`}},
+		{name: "forwarded header added", transform: &Transform{AddRequestHeaders: map[string]string{"x-forwarded-proto": "https"}}},
+		{name: "forwarded header removed", transform: &Transform{RemoveRequestHeaders: []string{"X-Real-IP"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(&config.Config{})
+			err := router.AddRoute(&Route{ID: "bad", Upstream: "http://127.0.0.1:8080", Transform: tt.transform})
+			assert.Error(t, err)
+			_, getErr := router.GetRoute("bad")
+			assert.ErrorIs(t, getErr, ErrRouteNotFound)
+		})
+	}
+}
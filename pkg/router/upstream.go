@@ -0,0 +1,578 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/last-emo-boy/infra-core/pkg/logging"
+	"github.com/last-emo-boy/infra-core/pkg/tracing"
+)
+
+// Sticky session modes for weighted routes
+const (
+	StickyNone   = ""
+	StickyIP     = "ip"
+	StickyCookie = "cookie"
+)
+
+// DefaultStickyCookie is the cookie used for sticky sessions when a route does not name one
+const DefaultStickyCookie = "infra_core_upstream"
+
+// Load balancing strategies for routes with several upstreams
+const (
+	StrategyRoundRobin       = "round_robin"
+	StrategyLeastConnections = "least_connections"
+)
+
+// Passive health check defaults: an upstream that fails this many requests in a
+// row (connection errors, timeouts or 502/503/504 responses) is ejected from
+// selection for the cooldown, then tried again
+const (
+	defaultEjectAfter    = 3
+	defaultEjectCooldown = 30 * time.Second
+)
+
+// WeightedUpstream is a single upstream target of a route with its traffic weight
+type WeightedUpstream struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// ResponseTimeBuckets are the upper bounds, in seconds, of the response time histogram
+var ResponseTimeBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// UpstreamMetrics holds metrics for a single upstream of a route.
+// ResponseTimeBuckets counts requests per ResponseTimeBuckets bucket, not
+// cumulatively; requests slower than the last bucket are only in RequestCount.
+type UpstreamMetrics struct {
+	RequestCount        int64   `json:"request_count"`
+	ErrorCount          int64   `json:"error_count"`
+	ResponseTime        int64   `json:"response_time"`
+	Ejections           int64   `json:"ejections"`
+	ResponseTimeBuckets []int64 `json:"-"`
+}
+
+// backend is a configured upstream together with its reverse proxy
+type backend struct {
+	url     string
+	weight  int
+	current int
+	proxy   *httputil.ReverseProxy
+	pool    *upstreamPool
+	active  int64 // in-flight requests, updated atomically
+
+	// Passive health and circuit breaker state, guarded by pool.mu
+	failures        int
+	ejectedUntil    time.Time
+	circuit         string
+	circuitFailures int
+	circuitSince    time.Time // first of the counted consecutive failures
+	openUntil       time.Time
+	circuitOpens    int64
+}
+
+// upstreamPool selects a backend for each request of a route
+type upstreamPool struct {
+	backends      []*backend
+	sticky        string
+	cookie        string
+	strategy      string
+	transform     *compiledTransform
+	access        *accessPolicy
+	cache         *cachePolicy
+	ejectAfter    int
+	ejectCooldown time.Duration
+	circuit       circuitSettings
+	mu            sync.Mutex
+}
+
+// backendKey is the request context key carrying the backend serving a request
+type backendKey struct{}
+
+// upstreamsFor returns the weighted upstreams of a route, falling back to the single Upstream field
+func upstreamsFor(route *Route) ([]*WeightedUpstream, error) {
+	if len(route.Upstreams) == 0 {
+		if route.Upstream == "" {
+			return nil, fmt.Errorf("route has no upstream")
+		}
+		return []*WeightedUpstream{{URL: route.Upstream, Weight: 1}}, nil
+	}
+
+	seen := make(map[string]bool, len(route.Upstreams))
+	for _, u := range route.Upstreams {
+		if u.Weight < 0 {
+			return nil, fmt.Errorf("negative weight for upstream %s", u.URL)
+		}
+		if seen[u.URL] {
+			return nil, fmt.Errorf("duplicate upstream: %s", u.URL)
+		}
+		seen[u.URL] = true
+	}
+
+	return route.Upstreams, nil
+}
+
+// newUpstreamPool builds a pool for the route, reusing proxies from the previous pool when possible
+func (r *Router) newUpstreamPool(route *Route, previous *upstreamPool) (*upstreamPool, error) {
+	switch route.Sticky {
+	case StickyNone, StickyIP, StickyCookie:
+	default:
+		return nil, fmt.Errorf("invalid sticky mode: %s", route.Sticky)
+	}
+
+	switch route.Strategy {
+	case "", StrategyRoundRobin, StrategyLeastConnections:
+	default:
+		return nil, fmt.Errorf("invalid load balancing strategy: %s", route.Strategy)
+	}
+
+	upstreams, err := upstreamsFor(route)
+	if err != nil {
+		return nil, err
+	}
+
+	transform, err := compileTransform(route.Transform)
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := compileAccessControl(route.AccessControl)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := compileCache(route.Cache)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]*backend)
+	if previous != nil {
+		previous.mu.Lock()
+		defer previous.mu.Unlock()
+		for _, b := range previous.backends {
+			existing[b.url] = b
+		}
+	}
+
+	pool := &upstreamPool{
+		backends:      make([]*backend, 0, len(upstreams)),
+		sticky:        route.Sticky,
+		cookie:        route.StickyCookie,
+		strategy:      route.Strategy,
+		transform:     transform,
+		access:        access,
+		cache:         cache,
+		ejectAfter:    r.ejectAfter,
+		ejectCooldown: r.ejectCooldown,
+		circuit:       r.circuit,
+	}
+	if pool.cookie == "" {
+		pool.cookie = DefaultStickyCookie
+	}
+
+	for _, u := range upstreams {
+		b := &backend{url: u.URL, weight: u.Weight, pool: pool}
+		if old, ok := existing[u.URL]; ok {
+			// Keep the proxy and health state of upstreams that stay in the route
+			b.proxy = old.proxy
+			b.failures = old.failures
+			b.ejectedUntil = old.ejectedUntil
+			b.circuit = old.circuit
+			b.circuitFailures = old.circuitFailures
+			b.circuitSince = old.circuitSince
+			b.openUntil = old.openUntil
+			b.circuitOpens = old.circuitOpens
+		} else {
+			b.proxy, err = r.newProxy(route.ID, u.URL)
+			if err != nil {
+				return nil, err
+			}
+		}
+		pool.backends = append(pool.backends, b)
+	}
+
+	return pool, nil
+}
+
+// newProxy creates a reverse proxy for one upstream of a route
+func (r *Router) newProxy(routeID, rawURL string) (*httputil.ReverseProxy, error) {
+	upstream, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL: %w", err)
+	}
+	if (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL: %s: must be an absolute http or https URL", rawURL)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+
+	// Customize proxy behavior. The proxy is shared by every pool built for the
+	// route, so the current transform is taken from the backend serving the request.
+	proxy.Director = func(req *http.Request) {
+		host, proto := req.Host, "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+
+		req.URL.Scheme = upstream.Scheme
+		req.URL.Host = upstream.Host
+		req.Host = upstream.Host
+
+		if b, _ := req.Context().Value(backendKey{}).(*backend); b != nil {
+			b.pool.transform.applyRequest(req)
+		}
+
+		// Add forwarded headers; X-Forwarded-For is appended by the reverse proxy
+		req.Header.Set("X-Forwarded-Proto", proto)
+		req.Header.Set("X-Forwarded-Host", host)
+		req.Header.Set("X-Real-IP", clientIP(req))
+		if id := logging.RequestID(req.Context()); id != "" {
+			req.Header.Set(logging.RequestIDHeader, id)
+		}
+		if tracing.Enabled() {
+			tracing.Inject(req.Context(), req.Header)
+		}
+	}
+
+	// Count upstream 5xx responses so both sides of a split can be compared
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		// The gate already set the ID the upstream echoes
+		resp.Header.Del(logging.RequestIDHeader)
+
+		if b, _ := resp.Request.Context().Value(backendKey{}).(*backend); b != nil {
+			b.pool.transform.applyResponse(resp)
+		}
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			r.recordUpstreamError(routeID, rawURL)
+		}
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			r.observeUpstream(resp.Request, routeID, rawURL, false)
+		default:
+			r.observeUpstream(resp.Request, routeID, rawURL, true)
+		}
+		return nil
+	}
+
+	// Error handler
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		// The client going away says nothing about the upstream's health
+		if errors.Is(err, context.Canceled) || req.Context().Err() != nil {
+			if b, _ := req.Context().Value(backendKey{}).(*backend); b != nil {
+				b.pool.abandonTrial(b, time.Now())
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		r.recordError(routeID)
+		r.recordUpstreamError(routeID, rawURL)
+		r.observeUpstream(req, routeID, rawURL, false)
+		if tracing.Enabled() {
+			tracing.RecordError(trace.SpanFromContext(req.Context()), err)
+		}
+		logging.FromContext(req.Context()).Warn("upstream request failed",
+			"route", routeID, "upstream", rawURL, "request_id", logging.RequestID(req.Context()), "error", err)
+		r.writeError(w, req, proxyErrorPage(routeID, err))
+	}
+
+	return proxy, nil
+}
+
+// observeUpstream feeds the outcome of a proxied request into the passive health check
+func (r *Router) observeUpstream(req *http.Request, routeID, upstream string, ok bool) {
+	b, _ := req.Context().Value(backendKey{}).(*backend)
+	if b == nil {
+		return
+	}
+	ejected, opened, closed := b.pool.observe(b, ok, time.Now())
+	if ejected {
+		log.Printf("⚠️ Ejecting upstream %s of route %s after %d consecutive failures", upstream, routeID, b.pool.ejectAfter)
+		r.recordUpstreamEjection(routeID, upstream)
+	}
+	logCircuit(routeID, upstream, opened, closed)
+}
+
+// observe records a request outcome for a backend, reporting whether it was
+// just ejected and whether its circuit just opened or closed
+func (p *upstreamPool) observe(b *backend, ok bool, now time.Time) (ejected, opened, closed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	opened, closed = p.recordCircuit(b, ok, now)
+	if ok {
+		b.failures = 0
+		return false, opened, closed
+	}
+
+	b.failures++
+	if p.ejectAfter <= 0 || b.failures < p.ejectAfter || now.Before(b.ejectedUntil) {
+		return false, opened, closed
+	}
+	b.failures = 0
+	b.ejectedUntil = now.Add(p.ejectCooldown)
+	return true, opened, closed
+}
+
+// available returns the backends eligible for selection: those with weight
+// whose circuit lets requests through that are not ejected, or all of those if
+// every one is ejected
+func (p *upstreamPool) available(now time.Time) []*backend {
+	var weighted, healthy []*backend
+	for _, b := range p.backends {
+		if b.weight == 0 || !p.circuitAllows(b, now) {
+			continue
+		}
+		weighted = append(weighted, b)
+		if !now.Before(b.ejectedUntil) {
+			healthy = append(healthy, b)
+		}
+	}
+	if len(healthy) == 0 {
+		return weighted
+	}
+	return healthy
+}
+
+// pick selects a backend for the request. If there is none it returns nil,
+// with how long until one can be tried again if their circuits are open.
+func (p *upstreamPool) pick(req *http.Request) (*backend, *http.Cookie, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	candidates := p.available(now)
+	if len(candidates) == 0 {
+		return nil, nil, p.retryAfter(now)
+	}
+
+	b, cookie := p.pickFrom(req, candidates)
+	p.startTrial(b)
+	return b, cookie, 0
+}
+
+// pickFrom selects one of the candidates for the request. Callers hold p.mu.
+func (p *upstreamPool) pickFrom(req *http.Request, candidates []*backend) (*backend, *http.Cookie) {
+	if len(p.backends) == 1 {
+		return candidates[0], nil
+	}
+
+	switch p.sticky {
+	case StickyIP:
+		return pickByHash(clientIP(req), candidates), nil
+	case StickyCookie:
+		if c, err := req.Cookie(p.cookie); err == nil {
+			for _, b := range candidates {
+				if upstreamKey(b.url) == c.Value {
+					return b, nil
+				}
+			}
+		}
+		b := p.choose(candidates)
+		return b, &http.Cookie{Name: p.cookie, Value: upstreamKey(b.url), Path: "/", HttpOnly: true}
+	default:
+		return p.choose(candidates), nil
+	}
+}
+
+// choose applies the pool's load balancing strategy to the candidates
+func (p *upstreamPool) choose(candidates []*backend) *backend {
+	if p.strategy == StrategyLeastConnections {
+		return pickLeastConnections(candidates)
+	}
+	return pickRoundRobin(candidates)
+}
+
+// pickRoundRobin implements smooth weighted round-robin selection
+func pickRoundRobin(candidates []*backend) *backend {
+	total := 0
+	var best *backend
+	for _, b := range candidates {
+		total += b.weight
+		b.current += b.weight
+		if best == nil || b.current > best.current {
+			best = b
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// pickLeastConnections selects the backend with the fewest in-flight requests
+// relative to its weight, breaking ties by weighted round-robin
+func pickLeastConnections(candidates []*backend) *backend {
+	var least []*backend
+	var leastActive int64
+	var leastWeight int
+	for _, b := range candidates {
+		active := atomic.LoadInt64(&b.active)
+		switch {
+		case least == nil || active*int64(leastWeight) < leastActive*int64(b.weight):
+			least = []*backend{b}
+			leastActive, leastWeight = active, b.weight
+		case active*int64(leastWeight) == leastActive*int64(b.weight):
+			least = append(least, b)
+		}
+	}
+	return pickRoundRobin(least)
+}
+
+// pickByHash maps a client key onto the cumulative weight range of the candidates
+func pickByHash(key string, candidates []*backend) *backend {
+	total := 0
+	for _, b := range candidates {
+		total += b.weight
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	point := int(h.Sum32() % uint32(total))
+
+	for _, b := range candidates {
+		if point < b.weight {
+			return b
+		}
+		point -= b.weight
+	}
+	return nil
+}
+
+// clientIP returns the client address of a request without its port
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// upstreamKey returns the opaque sticky cookie value identifying an upstream
+func upstreamKey(rawURL string) string {
+	h := fnv.New32a()
+	h.Write([]byte(rawURL))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// serveUpstream proxies the request to the selected backend and records
+// per-upstream metrics, returning the backend's URL
+func (r *Router) serveUpstream(w http.ResponseWriter, req *http.Request, routeID string, pool *upstreamPool) string {
+	b, cookie, retryAfter := pool.pick(req)
+	if b == nil && retryAfter > 0 {
+		r.recordError(routeID)
+		r.writeError(w, req, circuitOpenPage(routeID, retryAfter))
+		return ""
+	}
+	if b == nil {
+		r.recordError(routeID)
+		r.writeError(w, req, &ErrorPage{
+			Code:          errorNoUpstream,
+			Status:        http.StatusServiceUnavailable,
+			Message:       "None of the upstreams of this route is available.",
+			Route:         routeID,
+			UpstreamState: upstreamUnavailable,
+		})
+		return ""
+	}
+
+	if cookie != nil {
+		http.SetCookie(w, cookie)
+	}
+
+	// Long-lived streams must outlive the server's read and write timeouts. The
+	// reverse proxy itself tunnels upgraded connections and flushes event streams.
+	if isStreaming(req) {
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Time{})
+		_ = rc.SetWriteDeadline(time.Time{})
+	}
+
+	// For upgraded connections this returns, and the duration is recorded, once the tunnel closes
+	atomic.AddInt64(&b.active, 1)
+	defer atomic.AddInt64(&b.active, -1)
+
+	start := time.Now()
+	b.proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), backendKey{}, b)))
+	r.recordUpstreamRequest(routeID, b.url, time.Since(start))
+	return b.url
+}
+
+// isStreaming reports whether a request opens a long-lived stream: a protocol
+// upgrade such as WebSocket, or a Server-Sent Events subscription
+func isStreaming(req *http.Request) bool {
+	if req.Header.Get("Upgrade") != "" {
+		for _, value := range req.Header["Connection"] {
+			for _, token := range strings.Split(value, ",") {
+				if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+					return true
+				}
+			}
+		}
+	}
+
+	return strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+}
+
+// recordUpstreamRequest records request metrics for an upstream
+func (r *Router) recordUpstreamRequest(routeID, upstream string, duration time.Duration) {
+	r.metrics.mu.Lock()
+	defer r.metrics.mu.Unlock()
+
+	m := r.upstreamMetricsLocked(routeID, upstream)
+	m.RequestCount++
+	m.ResponseTime += duration.Nanoseconds()
+
+	if i := sort.SearchFloat64s(ResponseTimeBuckets, duration.Seconds()); i < len(ResponseTimeBuckets) {
+		if m.ResponseTimeBuckets == nil {
+			m.ResponseTimeBuckets = make([]int64, len(ResponseTimeBuckets))
+		}
+		m.ResponseTimeBuckets[i]++
+	}
+}
+
+// recordUpstreamError records error metrics for an upstream
+func (r *Router) recordUpstreamError(routeID, upstream string) {
+	r.metrics.mu.Lock()
+	defer r.metrics.mu.Unlock()
+
+	r.upstreamMetricsLocked(routeID, upstream).ErrorCount++
+}
+
+// recordUpstreamEjection counts a passive health check ejection of an upstream
+func (r *Router) recordUpstreamEjection(routeID, upstream string) {
+	r.metrics.mu.Lock()
+	defer r.metrics.mu.Unlock()
+
+	r.upstreamMetricsLocked(routeID, upstream).Ejections++
+}
+
+// upstreamMetricsLocked returns the metrics entry for an upstream, creating it if needed
+func (r *Router) upstreamMetricsLocked(routeID, upstream string) *UpstreamMetrics {
+	byUpstream, ok := r.metrics.Upstreams[routeID]
+	if !ok {
+		byUpstream = make(map[string]*UpstreamMetrics)
+		r.metrics.Upstreams[routeID] = byUpstream
+	}
+
+	m, ok := byUpstream[upstream]
+	if !ok {
+		m = &UpstreamMetrics{}
+		byUpstream[upstream] = m
+	}
+	return m
+}
@@ -0,0 +1,331 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// newNamedBackend starts a test upstream that identifies itself in the response body
+func newNamedBackend(t *testing.T, name string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWeightedSplit(t *testing.T) {
+	a := newNamedBackend(t, "a")
+	b := newNamedBackend(t, "b")
+
+	tests := []struct {
+		name    string
+		sticky  string
+		weightA int
+		weightB int
+	}{
+		{name: "round robin 90/10", weightA: 90, weightB: 10},
+		{name: "round robin 50/50", weightA: 1, weightB: 1},
+		{name: "ip hash 75/25", sticky: StickyIP, weightA: 75, weightB: 25},
+		{name: "zero weight", weightA: 100, weightB: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(&config.Config{})
+			require.NoError(t, router.AddRoute(&Route{
+				ID:         "split",
+				PathPrefix: "/",
+				Sticky:     tt.sticky,
+				Upstreams: []*WeightedUpstream{
+					{URL: a.URL, Weight: tt.weightA},
+					{URL: b.URL, Weight: tt.weightB},
+				},
+			}))
+
+			const total = 4000
+			counts := map[string]int{}
+			for i := 0; i < total; i++ {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.RemoteAddr = fmt.Sprintf("10.%d.%d.%d:5000", i/65536, (i/256)%256, i%256)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				require.Equal(t, http.StatusOK, w.Code)
+				counts[w.Body.String()]++
+			}
+
+			expectedB := float64(tt.weightB) / float64(tt.weightA+tt.weightB)
+			observedB := float64(counts["b"]) / total
+			assert.InDelta(t, expectedB, observedB, 0.03)
+			if tt.weightB == 0 {
+				assert.Zero(t, counts["b"])
+			}
+
+			metrics := router.GetMetrics()
+			assert.Equal(t, int64(counts["a"]), metrics.Upstreams["split"][a.URL].RequestCount)
+			if counts["b"] > 0 {
+				assert.Equal(t, int64(counts["b"]), metrics.Upstreams["split"][b.URL].RequestCount)
+			}
+		})
+	}
+}
+
+func TestStickyClients(t *testing.T) {
+	a := newNamedBackend(t, "a")
+	b := newNamedBackend(t, "b")
+
+	t.Run("ip hash", func(t *testing.T) {
+		router := NewRouter(&config.Config{})
+		require.NoError(t, router.AddRoute(&Route{
+			ID:     "sticky-ip",
+			Sticky: StickyIP,
+			Upstreams: []*WeightedUpstream{
+				{URL: a.URL, Weight: 50},
+				{URL: b.URL, Weight: 50},
+			},
+		}))
+
+		var first string
+		for i := 0; i < 100; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = fmt.Sprintf("192.168.1.20:%d", 40000+i)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if i == 0 {
+				first = w.Body.String()
+			}
+			assert.Equal(t, first, w.Body.String())
+		}
+	})
+
+	t.Run("cookie", func(t *testing.T) {
+		router := NewRouter(&config.Config{})
+		require.NoError(t, router.AddRoute(&Route{
+			ID:     "sticky-cookie",
+			Sticky: StickyCookie,
+			Upstreams: []*WeightedUpstream{
+				{URL: a.URL, Weight: 50},
+				{URL: b.URL, Weight: 50},
+			},
+		}))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		first := w.Body.String()
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, DefaultStickyCookie, cookies[0].Name)
+
+		for i := 0; i < 100; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(cookies[0])
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, first, w.Body.String())
+			assert.Empty(t, w.Result().Cookies())
+		}
+	})
+}
+
+func TestUpdateRoute(t *testing.T) {
+	a := newNamedBackend(t, "a")
+	b := newNamedBackend(t, "b")
+
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{
+		ID:         "ramp",
+		PathPrefix: "/",
+		Upstreams: []*WeightedUpstream{
+			{URL: a.URL, Weight: 100},
+			{URL: b.URL, Weight: 0},
+		},
+	}))
+
+	original, err := router.GetRoute("ramp")
+	require.NoError(t, err)
+	createdAt := original.CreatedAt
+	oldProxy := router.proxies["ramp"].backends[0].proxy
+
+	require.NoError(t, router.UpdateRoute(&Route{
+		ID:         "ramp",
+		PathPrefix: "/",
+		Upstreams: []*WeightedUpstream{
+			{URL: a.URL, Weight: 0},
+			{URL: b.URL, Weight: 100},
+		},
+	}))
+
+	updated, err := router.GetRoute("ramp")
+	require.NoError(t, err)
+	assert.Equal(t, createdAt, updated.CreatedAt)
+	assert.Same(t, oldProxy, router.proxies["ramp"].backends[0].proxy)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "b", w.Body.String())
+
+	t.Run("unknown route", func(t *testing.T) {
+		err := router.UpdateRoute(&Route{ID: "missing", Upstream: a.URL})
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid sticky mode", func(t *testing.T) {
+		err := router.UpdateRoute(&Route{ID: "ramp", Upstream: a.URL, Sticky: "header"})
+		assert.Error(t, err)
+	})
+
+	t.Run("all weights zero", func(t *testing.T) {
+		require.NoError(t, router.UpdateRoute(&Route{
+			ID: "ramp",
+			Upstreams: []*WeightedUpstream{
+				{URL: a.URL, Weight: 0},
+				{URL: b.URL, Weight: 0},
+			},
+		}))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+func TestLeastConnections(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, "slow")
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := newNamedBackend(t, "fast")
+
+	router := NewRouter(&config.Config{})
+	require.NoError(t, router.AddRoute(&Route{
+		ID:       "least",
+		Strategy: StrategyLeastConnections,
+		Upstreams: []*WeightedUpstream{
+			{URL: slow.URL, Weight: 1},
+			{URL: fast.URL, Weight: 1},
+		},
+	}))
+
+	// The first request ties and goes to the slow upstream, where it stays in flight
+	go router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&router.proxies["least"].backends[0].active) == 1
+	}, time.Second, time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, "fast", w.Body.String())
+	}
+
+	t.Run("invalid strategy", func(t *testing.T) {
+		err := router.UpdateRoute(&Route{ID: "least", Upstream: fast.URL, Strategy: "random"})
+		assert.Error(t, err)
+	})
+}
+
+func TestPassiveEjection(t *testing.T) {
+	healthy := newNamedBackend(t, "healthy")
+
+	var failing atomic.Bool
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "upstream down", http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, "flaky")
+	}))
+	defer flaky.Close()
+
+	router := NewRouter(&config.Config{})
+	router.ejectCooldown = 100 * time.Millisecond
+	require.NoError(t, router.AddRoute(&Route{
+		ID: "pool",
+		Upstreams: []*WeightedUpstream{
+			{URL: healthy.URL, Weight: 1},
+			{URL: flaky.URL, Weight: 1},
+		},
+	}))
+
+	// Counts responses by body, and errors of the gate by their code
+	serve := func(n int) map[string]int {
+		counts := map[string]int{}
+		for i := 0; i < n; i++ {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", "application/json")
+			router.ServeHTTP(w, req)
+			if w.Header().Get("Content-Type") == "application/json" {
+				var page ErrorPage
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+				counts[page.Code]++
+				continue
+			}
+			counts[w.Body.String()]++
+		}
+		return counts
+	}
+
+	// Both upstreams share traffic while healthy
+	assert.Equal(t, map[string]int{"healthy": 5, "flaky": 5}, serve(10))
+
+	// Three consecutive failures eject the flaky upstream
+	failing.Store(true)
+	counts := serve(6)
+	assert.Equal(t, 3, counts["healthy"])
+	assert.Equal(t, 3, counts["upstream down\n"])
+	assert.Equal(t, map[string]int{"healthy": 10}, serve(10))
+
+	metrics := router.GetMetrics().Upstreams["pool"]
+	assert.Equal(t, int64(1), metrics[flaky.URL].Ejections)
+	assert.Equal(t, int64(3), metrics[flaky.URL].ErrorCount)
+	assert.Zero(t, metrics[healthy.URL].Ejections)
+
+	// After the cooldown the upstream is tried again
+	failing.Store(false)
+	time.Sleep(router.ejectCooldown)
+	assert.Equal(t, map[string]int{"healthy": 5, "flaky": 5}, serve(10))
+
+	t.Run("connection errors", func(t *testing.T) {
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+
+		require.NoError(t, router.UpdateRoute(&Route{
+			ID: "pool",
+			Upstreams: []*WeightedUpstream{
+				{URL: healthy.URL, Weight: 1},
+				{URL: down.URL, Weight: 1},
+			},
+		}))
+
+		counts := serve(16)
+		assert.Equal(t, 3, counts[errorUpstreamUnreachable])
+		assert.Equal(t, 13, counts["healthy"])
+		assert.Equal(t, int64(1), router.GetMetrics().Upstreams["pool"][down.URL].Ejections)
+	})
+
+	t.Run("all upstreams ejected", func(t *testing.T) {
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+
+		require.NoError(t, router.AddRoute(&Route{ID: "single", PathPrefix: "/single", Upstream: down.URL}))
+		for i := 0; i < 5; i++ {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/single", nil))
+			assert.Equal(t, http.StatusBadGateway, w.Code)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// DefaultAuditBufferSize is how many audit entries can wait to be written
+// before new entries are dropped
+const DefaultAuditBufferSize = 1024
+
+// AuditLogger writes audit log entries in the background so that recording
+// an entry never blocks or fails the request that produced it
+type AuditLogger struct {
+	repo    *database.AuditLogRepository
+	entries chan *database.AuditLog
+	dropped atomic.Int64
+	mu      sync.RWMutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewAuditLogger creates a new audit logger buffering up to bufferSize entries
+func NewAuditLogger(db *database.DB, bufferSize int) *AuditLogger {
+	if bufferSize <= 0 {
+		bufferSize = DefaultAuditBufferSize
+	}
+
+	return &AuditLogger{
+		repo:    db.AuditLogRepository(),
+		entries: make(chan *database.AuditLog, bufferSize),
+	}
+}
+
+// Start starts the background writer
+func (al *AuditLogger) Start() {
+	al.wg.Add(1)
+	go al.run()
+}
+
+// Stop stops accepting entries and waits for buffered entries to be written
+func (al *AuditLogger) Stop() {
+	al.mu.Lock()
+	if !al.stopped {
+		al.stopped = true
+		close(al.entries)
+	}
+	al.mu.Unlock()
+
+	al.wg.Wait()
+}
+
+// Log queues an entry for writing. The entry is dropped if the buffer is
+// full or the logger has stopped.
+func (al *AuditLogger) Log(entry *database.AuditLog) {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+
+	if al.stopped {
+		al.dropped.Add(1)
+		return
+	}
+
+	select {
+	case al.entries <- entry:
+	default:
+		al.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many entries were dropped without being written
+func (al *AuditLogger) Dropped() int64 {
+	return al.dropped.Load()
+}
+
+// run writes queued entries until the logger is stopped
+func (al *AuditLogger) run() {
+	defer al.wg.Done()
+
+	for entry := range al.entries {
+		if err := al.repo.Create(entry); err != nil {
+			log.Printf("Failed to write audit log %s %s: %v", entry.Action, entry.ResourceType, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// setupAuditTestDB uses a file database so the background writer shares it
+func setupAuditTestDB(t *testing.T) *database.DB {
+	cfg := &config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "console.db")},
+		},
+	}
+
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestAuditLogger_WritesEntries(t *testing.T) {
+	db := setupAuditTestDB(t)
+
+	logger := NewAuditLogger(db, 0)
+	logger.Start()
+	for _, action := range []string{"create", "update", "delete"} {
+		logger.Log(&database.AuditLog{Action: action, ResourceType: "service"})
+	}
+	logger.Stop()
+
+	logs, err := db.AuditLogRepository().List(database.AuditLogFilter{})
+	require.NoError(t, err)
+	require.Len(t, logs, 3)
+	assert.Equal(t, "delete", logs[0].Action)
+	assert.False(t, logs[0].CreatedAt.IsZero())
+	assert.Zero(t, logger.Dropped())
+}
+
+func TestAuditLogger_DropsWhenFull(t *testing.T) {
+	db := setupAuditTestDB(t)
+
+	// Without a running writer the buffer fills and Log must not block
+	logger := NewAuditLogger(db, 1)
+	logger.Log(&database.AuditLog{Action: "create", ResourceType: "user"})
+	logger.Log(&database.AuditLog{Action: "delete", ResourceType: "user"})
+	assert.Equal(t, int64(1), logger.Dropped())
+
+	// Buffered entries are still written on shutdown, later ones are dropped
+	logger.Start()
+	logger.Stop()
+	logger.Log(&database.AuditLog{Action: "update", ResourceType: "user"})
+	assert.Equal(t, int64(2), logger.Dropped())
+
+	logs, err := db.AuditLogRepository().List(database.AuditLogFilter{})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "create", logs[0].Action)
+}
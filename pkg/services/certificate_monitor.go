@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/events"
+)
+
+// certificateCheckInterval is how often certificates are checked for expiry
+const certificateCheckInterval = time.Hour
+
+// renewalCheckInterval is how often certificates are checked for renewals
+// by the gate, when they are published
+const renewalCheckInterval = time.Minute
+
+// CertificateMonitor periodically marks certificates whose not_after has
+// passed as expired, so that listings and the dashboard don't show them as
+// valid until the gate renews them. With an event bus it also publishes the
+// certificates the gate renewed.
+type CertificateMonitor struct {
+	repo            *database.CertificateRepository
+	interval        time.Duration
+	renewalInterval time.Duration
+	eventBus        *events.Bus
+	notAfter        map[string]time.Time // of each certificate at the last renewal check
+	ctx             context.Context
+	cancel          context.CancelFunc
+	wg              sync.WaitGroup
+}
+
+// NewCertificateMonitor creates a certificate monitor
+func NewCertificateMonitor(db *database.DB) *CertificateMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &CertificateMonitor{
+		repo:            db.CertificateRepository(),
+		interval:        certificateCheckInterval,
+		renewalInterval: renewalCheckInterval,
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+}
+
+// SetEventBus sets the bus renewed certificates are published to as
+// certificate.renewed events. It must be called before Start.
+func (cm *CertificateMonitor) SetEventBus(bus *events.Bus) {
+	cm.eventBus = bus
+}
+
+// Start starts the monitor
+func (cm *CertificateMonitor) Start() {
+	cm.wg.Add(1)
+	go cm.run()
+}
+
+// Stop stops the monitor
+func (cm *CertificateMonitor) Stop() {
+	cm.cancel()
+	cm.wg.Wait()
+}
+
+// run checks certificates on every interval until stopped
+func (cm *CertificateMonitor) run() {
+	defer cm.wg.Done()
+
+	ticker := time.NewTicker(cm.interval)
+	defer ticker.Stop()
+	renewals := time.NewTicker(cm.renewalInterval)
+	defer renewals.Stop()
+
+	cm.Check(time.Now())
+	cm.CheckRenewals()
+
+	for {
+		select {
+		case <-cm.ctx.Done():
+			return
+		case <-ticker.C:
+			cm.Check(time.Now())
+		case <-renewals.C:
+			cm.CheckRenewals()
+		}
+	}
+}
+
+// Check marks the certificates that are past their not_after at now as
+// expired
+func (cm *CertificateMonitor) Check(now time.Time) {
+	expired, err := cm.repo.MarkExpired(now)
+	if err != nil {
+		log.Printf("❌ Failed to mark expired certificates: %v", err)
+		return
+	}
+	if expired > 0 {
+		log.Printf("⚠️ Marked %d certificates expired", expired)
+	}
+}
+
+// CheckRenewals publishes the certificates whose not_after moved later since
+// the last check, which the gate renewed meanwhile. The first check only
+// records the certificates. Without an event bus it does nothing.
+func (cm *CertificateMonitor) CheckRenewals() {
+	if cm.eventBus == nil {
+		return
+	}
+	certs, err := cm.repo.List()
+	if err != nil {
+		log.Printf("❌ Failed to check certificates for renewals: %v", err)
+		return
+	}
+
+	notAfter := make(map[string]time.Time, len(certs))
+	for _, cert := range certs {
+		notAfter[cert.ID] = cert.NotAfter
+		if previous, ok := cm.notAfter[cert.ID]; ok && cert.NotAfter.After(previous) {
+			cm.eventBus.Publish(events.CertificateRenewed, events.Certificate{
+				CertificateID: cert.ID,
+				Domain:        cert.Domain,
+				NotAfter:      cert.NotAfter,
+			})
+		}
+	}
+	cm.notAfter = notAfter
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/events"
+)
+
+func TestCertificateMonitor_Check(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := db.CertificateRepository()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for domain, notAfter := range map[string]time.Time{
+		"expired.example.com": now.Add(-time.Minute),
+		"valid.example.com":   now.Add(time.Minute),
+	} {
+		require.NoError(t, repo.Create(&database.Certificate{
+			ID:        domain,
+			Domain:    domain,
+			NotBefore: now.Add(-90 * 24 * time.Hour),
+			NotAfter:  notAfter,
+			CertPath:  domain + ".crt",
+			KeyPath:   domain + ".key",
+			Status:    "valid",
+		}))
+	}
+
+	NewCertificateMonitor(db).Check(now)
+
+	expired, err := repo.GetByID("expired.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "expired", expired.Status)
+	valid, err := repo.GetByID("valid.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "valid", valid.Status)
+}
+
+func TestCertificateMonitor_CheckRenewals(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := db.CertificateRepository()
+	notAfter := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	cert := &database.Certificate{
+		ID:        "cert-1",
+		Domain:    "app.example.com",
+		NotBefore: notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:  notAfter,
+		CertPath:  "app.crt",
+		KeyPath:   "app.key",
+		Status:    "valid",
+	}
+	require.NoError(t, repo.Create(cert))
+
+	bus := events.NewBus("console", 0)
+	subscription, _ := bus.Subscribe(nil, 0, 0)
+	monitor := NewCertificateMonitor(db)
+	monitor.SetEventBus(bus)
+
+	// The first check only records the certificates
+	monitor.CheckRenewals()
+	monitor.CheckRenewals()
+	assert.Empty(t, subscription.Events())
+
+	cert.NotAfter = notAfter.Add(90 * 24 * time.Hour)
+	require.NoError(t, repo.Save(cert))
+	monitor.CheckRenewals()
+	require.Len(t, subscription.Events(), 1)
+	event := <-subscription.Events()
+	assert.Equal(t, events.CertificateRenewed, event.Type)
+	var renewed events.Certificate
+	require.NoError(t, json.Unmarshal(event.Data, &renewed))
+	assert.Equal(t, "app.example.com", renewed.Domain)
+	assert.True(t, cert.NotAfter.Equal(renewed.NotAfter))
+}
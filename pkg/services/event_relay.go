@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/events"
+)
+
+const (
+	// relayRetryDelay is the wait before reconnecting to a daemon whose
+	// event stream ended, doubled for each failed attempt after it
+	relayRetryDelay = time.Second
+
+	// relayMaxRetryDelay bounds the wait between reconnects
+	relayMaxRetryDelay = 30 * time.Second
+)
+
+// EventSource streams the events of a daemon, as the clients of the
+// orchestrator, probe and snap daemons do
+type EventSource interface {
+	Events(ctx context.Context, query events.Query, handle func(events.Event) error) error
+}
+
+// EventRelay forwards the events the daemons stream to the console's bus,
+// so that the UI follows a single stream. It reconnects to a daemon with the
+// ID of the last event it got, so that events published meanwhile are
+// replayed rather than lost.
+type EventRelay struct {
+	bus        *events.Bus
+	sources    map[string]EventSource
+	retryDelay time.Duration
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// NewEventRelay creates a relay to bus
+func NewEventRelay(bus *events.Bus) *EventRelay {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &EventRelay{
+		bus:        bus,
+		sources:    make(map[string]EventSource),
+		retryDelay: relayRetryDelay,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Add relays the events of a daemon, named for the logs. It must be called
+// before Start.
+func (r *EventRelay) Add(name string, source EventSource) {
+	r.sources[name] = source
+}
+
+// Start starts relaying the events of every daemon
+func (r *EventRelay) Start() {
+	for name, source := range r.sources {
+		r.wg.Add(1)
+		go r.relay(name, source)
+	}
+}
+
+// Stop stops relaying and waits for the streams to close
+func (r *EventRelay) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// relay forwards the events of a daemon until stopped, reconnecting whenever
+// its stream ends
+func (r *EventRelay) relay(name string, source EventSource) {
+	defer r.wg.Done()
+
+	var lastID uint64
+	delay := r.retryDelay
+	for {
+		err := source.Events(r.ctx, events.Query{LastEventID: lastID}, func(event events.Event) error {
+			lastID = event.ID
+			delay = r.retryDelay
+			r.bus.Forward(event)
+			return nil
+		})
+		if r.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("⚠️ Lost the event stream of the %s, reconnecting in %s: %v", name, delay, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		delay = min(delay*2, relayMaxRetryDelay)
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/events"
+)
+
+// fakeEventSource streams the events of a bus, ending the first stream
+// after one event to make the relay reconnect
+type fakeEventSource struct {
+	bus     *events.Bus
+	mutex   sync.Mutex
+	queries []events.Query
+}
+
+func (f *fakeEventSource) Events(ctx context.Context, query events.Query, handle func(events.Event) error) error {
+	f.mutex.Lock()
+	f.queries = append(f.queries, query)
+	first := len(f.queries) == 1
+	f.mutex.Unlock()
+
+	subscription, replay := f.bus.Subscribe(query.Types, query.LastEventID, 0)
+	defer subscription.Close()
+	for _, event := range replay {
+		if err := handle(event); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-subscription.Events():
+			if err := handle(event); err != nil {
+				return err
+			}
+			if first {
+				return errors.New("connection reset")
+			}
+		}
+	}
+}
+
+func (f *fakeEventSource) Queries() []events.Query {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]events.Query(nil), f.queries...)
+}
+
+func TestEventRelay(t *testing.T) {
+	daemon := events.NewBus("orchestrator", 0)
+	source := &fakeEventSource{bus: daemon}
+	console := events.NewBus("console", 0)
+	subscription, _ := console.Subscribe(nil, 0, 0)
+
+	relay := NewEventRelay(console)
+	relay.retryDelay = 10 * time.Millisecond
+	relay.Add("orchestrator", source)
+	relay.Start()
+	defer relay.Stop()
+
+	require.Eventually(t, func() bool { return daemon.Stats().Subscribers == 1 }, time.Second, 5*time.Millisecond)
+	daemon.Publish(events.DeploymentProgress, events.Deployment{DeploymentID: "deploy-1", Updated: 0})
+
+	// Published while the relay reconnects, and replayed to it
+	require.Eventually(t, func() bool { return len(source.Queries()) == 2 || daemon.Stats().Subscribers == 0 }, time.Second, 5*time.Millisecond)
+	daemon.Publish(events.DeploymentProgress, events.Deployment{DeploymentID: "deploy-1", Updated: 1})
+	daemon.Publish(events.ServiceStatusChanged, events.ServiceStatus{Service: "web", NewStatus: "running"})
+
+	var relayed []events.Event
+	for len(relayed) < 3 {
+		select {
+		case event := <-subscription.Events():
+			relayed = append(relayed, event)
+		case <-time.After(time.Second):
+			t.Fatalf("relayed %d of 3 events", len(relayed))
+		}
+	}
+	assert.Equal(t, []string{events.DeploymentProgress, events.DeploymentProgress, events.ServiceStatusChanged},
+		[]string{relayed[0].Type, relayed[1].Type, relayed[2].Type})
+	for i, event := range relayed {
+		assert.Equal(t, uint64(i+1), event.ID, "renumbered by the console's bus")
+		assert.Equal(t, "orchestrator", event.Source)
+	}
+
+	queries := source.Queries()
+	require.Len(t, queries, 2)
+	assert.Zero(t, queries[0].LastEventID)
+	assert.Equal(t, uint64(1), queries[1].LastEventID, "reconnects after the last event")
+}
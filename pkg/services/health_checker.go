@@ -2,34 +2,70 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"log"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/last-emo-boy/infra-core/pkg/config"
 	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/events"
 )
 
+// defaultFailureThreshold is how many consecutive failed checks mark a
+// service unreachable when the console config leaves it unset
+const defaultFailureThreshold = 3
+
+// auditActionStatusChange is the audit log action of a status change made
+// by the health checker
+const auditActionStatusChange = "status_change"
+
 // HealthChecker performs health checks on registered services
 type HealthChecker struct {
-	db       *database.DB
-	client   *http.Client
-	interval time.Duration
-	ctx      context.Context
-	cancel   context.CancelFunc
-	wg       sync.WaitGroup
+	db               *database.DB
+	client           *http.Client
+	interval         time.Duration
+	failureThreshold int
+	notifier         StatusNotifier
+	eventBus         *events.Bus
+	ctx              context.Context
+	cancel           context.CancelFunc
+	wg               sync.WaitGroup
 }
 
-// NewHealthChecker creates a new health checker
-func NewHealthChecker(db *database.DB) *HealthChecker {
+// NewHealthChecker creates a new health checker using the console service
+// health config
+func NewHealthChecker(db *database.DB, cfg config.ServiceHealthConfig) *HealthChecker {
 	ctx, cancel := context.WithCancel(context.Background())
-	
-	return &HealthChecker{
-		db:       db,
-		client:   &http.Client{Timeout: 10 * time.Second},
-		interval: 1 * time.Minute, // Check every minute
-		ctx:      ctx,
-		cancel:   cancel,
+
+	hc := &HealthChecker{
+		db:               db,
+		client:           &http.Client{Timeout: 10 * time.Second},
+		interval:         1 * time.Minute, // Check every minute
+		failureThreshold: defaultFailureThreshold,
+		ctx:              ctx,
+		cancel:           cancel,
+	}
+	if cfg.FailureThreshold > 0 {
+		hc.failureThreshold = cfg.FailureThreshold
+	}
+	if cfg.WebhookURL != "" {
+		hc.notifier = NewWebhookNotifier(cfg.WebhookURL)
 	}
+	return hc
+}
+
+// SetNotifier sets who is told about status changes, replacing the webhook
+// from the config. It must be called before Start.
+func (hc *HealthChecker) SetNotifier(notifier StatusNotifier) {
+	hc.notifier = notifier
+}
+
+// SetEventBus sets the bus status changes are published to as
+// service.status_changed events. It must be called before Start.
+func (hc *HealthChecker) SetEventBus(bus *events.Bus) {
+	hc.eventBus = bus
 }
 
 // Start starts the health checker
@@ -38,7 +74,8 @@ func (hc *HealthChecker) Start() {
 	go hc.run()
 }
 
-// Stop stops the health checker
+// Stop stops the health checker and waits for it to finish. Checks in
+// flight are abandoned without being recorded.
 func (hc *HealthChecker) Stop() {
 	hc.cancel()
 	hc.wg.Wait()
@@ -72,11 +109,20 @@ func (hc *HealthChecker) checkAllServices() {
 		return
 	}
 
+	// Services under maintenance aren't checked, so they don't pile up failed checks
+	maintenance, err := hc.db.MaintenanceWindowRepository().ListActive(time.Now())
+	if err != nil {
+		log.Printf("Failed to load maintenance windows: %v", err)
+	}
+
 	var wg sync.WaitGroup
 	for _, service := range services {
 		if service.HealthURL == nil || *service.HealthURL == "" {
 			continue
 		}
+		if _, ok := maintenance[service.ID]; ok {
+			continue
+		}
 
 		wg.Add(1)
 		go func(svc *database.RegisteredService) {
@@ -98,7 +144,15 @@ func (hc *HealthChecker) checkService(service *database.RegisteredService) {
 	isHealthy := true
 	var errorMessage *string
 
-	resp, err := hc.client.Get(*service.HealthURL)
+	var resp *http.Response
+	req, err := http.NewRequestWithContext(hc.ctx, http.MethodGet, *service.HealthURL, nil)
+	if err == nil {
+		resp, err = hc.client.Do(req)
+	}
+	if err != nil && hc.ctx.Err() != nil {
+		// Stopped mid-check, which says nothing about the service
+		return
+	}
 	if err != nil {
 		isHealthy = false
 		errMsg := err.Error()
@@ -131,12 +185,91 @@ func (hc *HealthChecker) checkService(service *database.RegisteredService) {
 		return
 	}
 
+	// Checks made during maintenance are recorded but neither count towards
+	// the failure streak nor change the service's status
+	if _, err := hc.db.MaintenanceWindowRepository().GetActive(service.ID, time.Now()); err == nil {
+		return
+	}
+
 	// Update service health status
 	serviceRepo := hc.db.RegisteredServiceRepository()
-	if err := serviceRepo.UpdateHealthStatus(service.ID, isHealthy); err != nil {
+	streak, err := serviceRepo.UpdateHealthStatus(service.ID, isHealthy)
+	if err != nil {
 		// Log error but don't fail
 		return
 	}
+
+	hc.updateStatus(service, streak, errorMessage)
+}
+
+// updateStatus marks an active service unreachable once its failure streak
+// reaches the threshold, and an unreachable one active again once a check
+// passes. Services an administrator set to another status are left alone.
+func (hc *HealthChecker) updateStatus(service *database.RegisteredService, streak int, checkErr *string) {
+	var from, to string
+	switch {
+	case streak >= hc.failureThreshold && service.Status == database.RegisteredServiceActive:
+		from, to = database.RegisteredServiceActive, database.RegisteredServiceUnreachable
+	case streak == 0 && service.Status == database.RegisteredServiceUnreachable:
+		from, to = database.RegisteredServiceUnreachable, database.RegisteredServiceActive
+	default:
+		return
+	}
+
+	changed, err := hc.db.RegisteredServiceRepository().TransitionStatus(service.ID, from, to)
+	if err != nil {
+		log.Printf("Failed to mark service %s %s: %v", service.Name, to, err)
+		return
+	}
+	if !changed {
+		return // the status was changed meanwhile
+	}
+	service.Status = to
+
+	change := StatusChange{
+		ServiceID:     service.ID,
+		Service:       service.Name,
+		OldStatus:     from,
+		NewStatus:     to,
+		FailureStreak: streak,
+		Timestamp:     time.Now(),
+	}
+	if checkErr != nil {
+		change.Error = *checkErr
+	}
+	log.Printf("🏥 Service %s changed from %s to %s", service.Name, from, to)
+
+	hc.audit(change)
+	hc.eventBus.Publish(events.ServiceStatusChanged, events.ServiceStatus{
+		ServiceID: change.ServiceID,
+		Service:   change.Service,
+		OldStatus: change.OldStatus,
+		NewStatus: change.NewStatus,
+		Error:     change.Error,
+	})
+	if hc.notifier != nil {
+		if err := hc.notifier.Notify(hc.ctx, change); err != nil {
+			log.Printf("Failed to notify status change of service %s: %v", service.Name, err)
+		}
+	}
+}
+
+// audit records a status change in the audit log
+func (hc *HealthChecker) audit(change StatusChange) {
+	entry := &database.AuditLog{
+		Action:       auditActionStatusChange,
+		ResourceType: "registered_service",
+		ResourceID:   &change.ServiceID,
+		CreatedAt:    change.Timestamp,
+	}
+	if details, err := json.Marshal(change); err == nil {
+		detailsJSON := string(details)
+		entry.Details = &detailsJSON
+	}
+
+	if err := hc.db.AuditLogRepository().Create(entry); err != nil {
+		log.Printf("Failed to audit status change of service %s: %v", change.Service, err)
+	}
 }
 
 // CheckService performs an immediate health check on a specific service
@@ -160,7 +293,7 @@ func (hc *HealthChecker) CleanupOldHealthChecks() {
 	cutoff := time.Now().AddDate(0, 0, -7)
 	
 	healthRepo := hc.db.ServiceHealthCheckRepository()
-	if err := healthRepo.CleanupOldChecks(cutoff); err != nil {
+	if _, err := healthRepo.CleanupOldChecks(cutoff); err != nil {
 		// Log error but don't fail
 		return
 	}
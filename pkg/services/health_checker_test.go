@@ -1,9 +1,12 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -36,7 +39,7 @@ func TestNewHealthChecker(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
-	hc := NewHealthChecker(db)
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{})
 
 	assert.NotNil(t, hc)
 	assert.Equal(t, db, hc.db)
@@ -51,7 +54,7 @@ func TestHealthChecker_StartStop(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
-	hc := NewHealthChecker(db)
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{})
 
 	// Start the health checker
 	hc.Start()
@@ -93,7 +96,7 @@ func TestHealthChecker_CheckService(t *testing.T) {
 	err := serviceRepo.Create(service)
 	require.NoError(t, err)
 
-	hc := NewHealthChecker(db)
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{})
 
 	// Perform health check
 	healthCheck, err := hc.CheckService("test-service")
@@ -132,7 +135,7 @@ func TestHealthChecker_CheckServiceUnhealthy(t *testing.T) {
 	err := serviceRepo.Create(service)
 	require.NoError(t, err)
 
-	hc := NewHealthChecker(db)
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{})
 
 	// Perform health check
 	healthCheck, err := hc.CheckService("unhealthy-service")
@@ -165,7 +168,7 @@ func TestHealthChecker_CheckServiceNetworkError(t *testing.T) {
 	err := serviceRepo.Create(service)
 	require.NoError(t, err)
 
-	hc := NewHealthChecker(db)
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{})
 
 	// Perform health check
 	healthCheck, err := hc.CheckService("network-error-service")
@@ -196,7 +199,7 @@ func TestHealthChecker_CheckServiceNoHealthURL(t *testing.T) {
 	err := serviceRepo.Create(service)
 	require.NoError(t, err)
 
-	hc := NewHealthChecker(db)
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{})
 
 	// Perform health check - should still work but not actually check anything
 	healthCheck, err := hc.CheckService("no-health-url-service")
@@ -214,7 +217,7 @@ func TestHealthChecker_CheckServiceNotFound(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
-	hc := NewHealthChecker(db)
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{})
 
 	// Try to check a service that doesn't exist
 	healthCheck, err := hc.CheckService("nonexistent-service")
@@ -281,7 +284,7 @@ func TestHealthChecker_CheckAllServices(t *testing.T) {
 	err = serviceRepo.Create(noHealthURLService)
 	require.NoError(t, err)
 
-	hc := NewHealthChecker(db)
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{})
 
 	// Check all services
 	hc.checkAllServices()
@@ -331,7 +334,19 @@ func TestHealthChecker_CleanupOldHealthChecks(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
-	hc := NewHealthChecker(db)
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{})
+
+	// Health checks reference a registered service
+	err := db.RegisteredServiceRepository().Create(&database.RegisteredService{
+		ID:           "test-service",
+		Name:         "test-service",
+		DisplayName:  "Test Service",
+		ServiceURL:   "http://localhost:8080",
+		Category:     "api",
+		RequiredRole: "user",
+		Status:       "active",
+	})
+	require.NoError(t, err)
 
 	// Create some test health checks
 	healthRepo := db.ServiceHealthCheckRepository()
@@ -343,7 +358,7 @@ func TestHealthChecker_CleanupOldHealthChecks(t *testing.T) {
 		ResponseTime: 100,
 		CheckedAt:    time.Now(),
 	}
-	err := healthRepo.Record(recentCheck)
+	err = healthRepo.Record(recentCheck)
 	require.NoError(t, err)
 
 	// Old check (should be cleaned up)
@@ -393,7 +408,7 @@ func TestHealthChecker_Integration(t *testing.T) {
 	err := serviceRepo.Create(service)
 	require.NoError(t, err)
 
-	hc := NewHealthChecker(db)
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{})
 
 	// Perform health check
 	healthCheck, err := hc.CheckService("integration-service")
@@ -412,4 +427,257 @@ func TestHealthChecker_Integration(t *testing.T) {
 	assert.NotNil(t, latestCheck)
 	assert.Equal(t, healthCheck.ServiceID, latestCheck.ServiceID)
 	assert.Equal(t, healthCheck.IsHealthy, latestCheck.IsHealthy)
-}
\ No newline at end of file
+}
+func TestHealthChecker_StatusTransitions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// An upstream that can be toggled between healthy and failing
+	var failing atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	changes := make(chan StatusChange, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var change StatusChange
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&change))
+		changes <- change
+	}))
+	defer webhook.Close()
+
+	serviceRepo := db.RegisteredServiceRepository()
+	healthURL := upstream.URL + "/health"
+	require.NoError(t, serviceRepo.Create(&database.RegisteredService{
+		ID:         "flaky-service",
+		Name:       "flaky",
+		ServiceURL: upstream.URL,
+		HealthURL:  &healthURL,
+		Status:     database.RegisteredServiceActive,
+	}))
+
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{FailureThreshold: 2, WebhookURL: webhook.URL})
+	check := func(wantStatus string, wantStreak int) {
+		t.Helper()
+		_, err := hc.CheckService("flaky-service")
+		require.NoError(t, err)
+		service, err := serviceRepo.GetByID("flaky-service")
+		require.NoError(t, err)
+		assert.Equal(t, wantStatus, service.Status)
+		assert.Equal(t, wantStreak, service.FailureStreak)
+	}
+
+	// A single failure is not enough
+	failing.Store(true)
+	check(database.RegisteredServiceActive, 1)
+	assert.Empty(t, changes)
+
+	check(database.RegisteredServiceUnreachable, 2)
+	require.Len(t, changes, 1)
+	change := <-changes
+	assert.Equal(t, "flaky-service", change.ServiceID)
+	assert.Equal(t, "flaky", change.Service)
+	assert.Equal(t, database.RegisteredServiceActive, change.OldStatus)
+	assert.Equal(t, database.RegisteredServiceUnreachable, change.NewStatus)
+	assert.Equal(t, "503 Service Unavailable", change.Error)
+	assert.Equal(t, 2, change.FailureStreak)
+	assert.WithinDuration(t, time.Now(), change.Timestamp, time.Minute)
+
+	// Further failures change nothing
+	check(database.RegisteredServiceUnreachable, 3)
+	assert.Empty(t, changes)
+
+	failing.Store(false)
+	check(database.RegisteredServiceActive, 0)
+	require.Len(t, changes, 1)
+	change = <-changes
+	assert.Equal(t, database.RegisteredServiceUnreachable, change.OldStatus)
+	assert.Equal(t, database.RegisteredServiceActive, change.NewStatus)
+	assert.Empty(t, change.Error)
+	assert.Equal(t, 0, change.FailureStreak)
+
+	// Each transition is audited
+	entries, err := db.AuditLogRepository().List(database.AuditLogFilter{Action: auditActionStatusChange})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for i, want := range []string{database.RegisteredServiceActive, database.RegisteredServiceUnreachable} {
+		require.NotNil(t, entries[i].ResourceID)
+		assert.Equal(t, "flaky-service", *entries[i].ResourceID)
+		assert.Nil(t, entries[i].UserID)
+		var details StatusChange
+		require.NotNil(t, entries[i].Details)
+		require.NoError(t, json.Unmarshal([]byte(*entries[i].Details), &details))
+		assert.Equal(t, want, details.NewStatus)
+	}
+}
+
+func TestHealthChecker_KeepsAdministratorStatus(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	serviceRepo := db.RegisteredServiceRepository()
+	healthURL := upstream.URL + "/health"
+	require.NoError(t, serviceRepo.Create(&database.RegisteredService{
+		ID:         "maintenance-service",
+		Name:       "maintenance",
+		ServiceURL: upstream.URL,
+		HealthURL:  &healthURL,
+		Status:     database.RegisteredServiceMaintenance,
+	}))
+
+	notifier := &recordingNotifier{}
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{FailureThreshold: 1})
+	hc.SetNotifier(notifier)
+
+	// A service under maintenance is expected to fail its checks
+	for i := 0; i < 3; i++ {
+		_, err := hc.CheckService("maintenance-service")
+		require.NoError(t, err)
+	}
+
+	service, err := serviceRepo.GetByID("maintenance-service")
+	require.NoError(t, err)
+	assert.Equal(t, database.RegisteredServiceMaintenance, service.Status)
+	assert.Equal(t, 3, service.FailureStreak)
+	assert.Empty(t, notifier.changes)
+}
+
+func TestHealthChecker_MaintenanceWindow(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	serviceRepo := db.RegisteredServiceRepository()
+	healthURL := upstream.URL + "/health"
+	require.NoError(t, serviceRepo.Create(&database.RegisteredService{
+		ID:         "upgrading-service",
+		Name:       "upgrading",
+		ServiceURL: upstream.URL,
+		HealthURL:  &healthURL,
+		Status:     database.RegisteredServiceActive,
+	}))
+
+	windowRepo := db.MaintenanceWindowRepository()
+	window := &database.MaintenanceWindow{
+		ServiceID: "upgrading-service",
+		StartsAt:  time.Now().Add(-time.Minute),
+		EndsAt:    time.Now().Add(time.Hour),
+		Message:   "Upgrading to v2",
+	}
+	require.NoError(t, windowRepo.Create(window))
+
+	notifier := &recordingNotifier{}
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{FailureThreshold: 1})
+	hc.SetNotifier(notifier)
+
+	// Scheduled checks skip the service
+	hc.checkAllServices()
+	_, err := db.ServiceHealthCheckRepository().GetLatest("upgrading-service")
+	assert.Error(t, err)
+
+	// Explicit checks are recorded without changing the service
+	check, err := hc.CheckService("upgrading-service")
+	require.NoError(t, err)
+	assert.False(t, check.IsHealthy)
+	service, err := serviceRepo.GetByID("upgrading-service")
+	require.NoError(t, err)
+	assert.Equal(t, database.RegisteredServiceActive, service.Status)
+	assert.Zero(t, service.FailureStreak)
+	assert.Empty(t, notifier.changes)
+
+	// Once the window is over failures count again
+	window.EndsAt = time.Now()
+	require.NoError(t, windowRepo.Update(window))
+	hc.checkAllServices()
+	service, err = serviceRepo.GetByID("upgrading-service")
+	require.NoError(t, err)
+	assert.Equal(t, database.RegisteredServiceUnreachable, service.Status)
+	assert.Equal(t, 1, service.FailureStreak)
+	require.Len(t, notifier.changes, 1)
+}
+
+func TestWebhookNotifierFailure(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer webhook.Close()
+
+	err := NewWebhookNotifier(webhook.URL).Notify(context.Background(), StatusChange{ServiceID: "svc"})
+	assert.EqualError(t, err, "webhook returned 502 Bad Gateway")
+}
+
+// recordingNotifier records the status changes it is told about
+type recordingNotifier struct {
+	changes []StatusChange
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, change StatusChange) error {
+	n.changes = append(n.changes, change)
+	return nil
+}
+
+func TestHealthChecker_StopAbandonsChecks(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	healthURL := server.URL + "/health"
+	require.NoError(t, db.RegisteredServiceRepository().Create(&database.RegisteredService{
+		ID:         "slow-service",
+		Name:       "Slow Service",
+		ServiceURL: server.URL,
+		HealthURL:  &healthURL,
+		Status:     "active",
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}))
+
+	hc := NewHealthChecker(db, config.ServiceHealthConfig{FailureThreshold: 1})
+	hc.Start()
+	<-requested
+
+	stopped := make(chan struct{})
+	go func() {
+		hc.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop should not wait for the check in flight")
+	}
+
+	// The abandoned check is neither recorded nor counted as a failure
+	_, err := db.ServiceHealthCheckRepository().GetLatest("slow-service")
+	assert.Error(t, err)
+	service, err := db.RegisteredServiceRepository().GetByID("slow-service")
+	require.NoError(t, err)
+	assert.Equal(t, "active", service.Status)
+}
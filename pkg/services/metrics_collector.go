@@ -0,0 +1,367 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// defaultMetricsCollectInterval is how often metrics are sampled when the
+// console config leaves it unset
+const defaultMetricsCollectInterval = 30 * time.Second
+
+// Scopes of the metrics written by the collector
+const (
+	metricScopeHost    = "host"
+	metricScopeService = "service"
+)
+
+// errMetricsUnsupported is returned for metrics the platform cannot report
+var errMetricsUnsupported = errors.New("metrics collection is not supported on this platform")
+
+// ServicePIDSource reports the process IDs of running service instances,
+// keyed by service ID
+type ServicePIDSource interface {
+	ServicePIDs() map[string]int
+}
+
+// MetricsCollector periodically samples host CPU, memory, disk usage of the
+// data directory and load average, and the memory of service processes,
+// into the metrics table. Host metrics are read from /proc, so on other
+// platforms only service metrics from a PID source are collected, if any.
+type MetricsCollector struct {
+	repo          *database.MetricRepository
+	procRoot      string
+	host          bool
+	hostID        string
+	dataDir       string
+	pids          ServicePIDSource
+	interval      time.Duration
+	retentionDays int
+	prevCPU       *cpuTimes
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+}
+
+// NewMetricsCollector creates a collector of host metrics using the console
+// metrics config. Disk usage is reported for the file system of dataDir.
+func NewMetricsCollector(db *database.DB, cfg config.MetricsConfig, dataDir string) *MetricsCollector {
+	mc := newMetricsCollector(db, cfg)
+	mc.host = true
+	mc.dataDir = dataDir
+	if hostname, err := os.Hostname(); err == nil {
+		mc.hostID = hostname
+	}
+	return mc
+}
+
+// NewServiceMetricsCollector creates a collector of the memory of the
+// service processes reported by pids, for the process that runs them
+func NewServiceMetricsCollector(db *database.DB, cfg config.MetricsConfig, pids ServicePIDSource) *MetricsCollector {
+	mc := newMetricsCollector(db, cfg)
+	mc.pids = pids
+	return mc
+}
+
+func newMetricsCollector(db *database.DB, cfg config.MetricsConfig) *MetricsCollector {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	mc := &MetricsCollector{
+		repo:          db.MetricRepository(),
+		procRoot:      hostProcRoot,
+		hostID:        "localhost",
+		interval:      defaultMetricsCollectInterval,
+		retentionDays: retentionDays(defaultMetricsRawRetention),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+	if d, err := time.ParseDuration(cfg.CollectInterval); err == nil && d > 0 {
+		mc.interval = d
+	}
+	if d, err := config.ParseRetention(cfg.RawRetention); err == nil {
+		mc.retentionDays = retentionDays(d)
+	}
+	return mc
+}
+
+// retentionDays rounds a retention up to whole days, keeping at least one
+func retentionDays(retention time.Duration) int {
+	days := int((retention + 24*time.Hour - 1) / (24 * time.Hour))
+	if days < 1 {
+		return 1
+	}
+	return days
+}
+
+// Start starts the collector
+func (mc *MetricsCollector) Start() {
+	if mc.procRoot == "" {
+		log.Printf("⚠️ Host metrics collection is not supported on this platform")
+	}
+	mc.wg.Add(1)
+	go mc.run()
+}
+
+// Stop stops the collector
+func (mc *MetricsCollector) Stop() {
+	mc.cancel()
+	mc.wg.Wait()
+}
+
+// run collects metrics on every interval until stopped
+func (mc *MetricsCollector) run() {
+	defer mc.wg.Done()
+
+	ticker := time.NewTicker(mc.interval)
+	defer ticker.Stop()
+
+	mc.Collect(time.Now())
+
+	for {
+		select {
+		case <-mc.ctx.Done():
+			return
+		case <-ticker.C:
+			mc.Collect(time.Now())
+		}
+	}
+}
+
+// Collect samples all metrics once, records them at now and then deletes
+// metrics past their retention. Metrics that cannot be read are skipped.
+// CPU usage is measured between samples, so the first sample has none.
+func (mc *MetricsCollector) Collect(now time.Time) {
+	var metrics []*database.Metric
+	add := func(scopeType, scopeID, name string, value float64) {
+		metrics = append(metrics, &database.Metric{
+			Timestamp:   now,
+			ScopeType:   scopeType,
+			ScopeID:     scopeID,
+			MetricName:  name,
+			MetricValue: value,
+		})
+	}
+
+	if mc.host {
+		for name, value := range mc.hostMetrics() {
+			add(metricScopeHost, mc.hostID, name, value)
+		}
+	}
+	if mc.pids != nil && mc.procRoot != "" {
+		for serviceID, pid := range mc.pids.ServicePIDs() {
+			rss, err := readProcessRSS(mc.procRoot, pid)
+			if err != nil {
+				// The process may have exited since it was reported
+				continue
+			}
+			add(metricScopeService, serviceID, "memory_rss_bytes", float64(rss))
+		}
+	}
+
+	for _, metric := range metrics {
+		if err := mc.repo.Insert(metric); err != nil {
+			log.Printf("❌ Failed to record %s metric: %v", metric.MetricName, err)
+		}
+	}
+	if err := mc.repo.DeleteOld(mc.retentionDays); err != nil {
+		log.Printf("❌ Failed to delete old metrics: %v", err)
+	}
+}
+
+// hostMetrics samples the metrics of the host by name
+func (mc *MetricsCollector) hostMetrics() map[string]float64 {
+	metrics := make(map[string]float64)
+
+	if mc.dataDir != "" {
+		if total, used, avail, err := diskUsage(mc.dataDir); err == nil && total > 0 {
+			metrics["disk_total_bytes"] = float64(total)
+			metrics["disk_used_bytes"] = float64(used)
+			metrics["disk_usage"] = percent(used, used+avail)
+		} else if err != nil && !errors.Is(err, errMetricsUnsupported) {
+			log.Printf("⚠️ Failed to read disk usage of %s: %v", mc.dataDir, err)
+		}
+	}
+	if mc.procRoot == "" {
+		return metrics
+	}
+
+	if cpu, err := readCPUTimes(mc.procRoot); err == nil {
+		if mc.prevCPU != nil {
+			if usage, ok := cpu.usageSince(*mc.prevCPU); ok {
+				metrics["cpu_usage"] = usage
+			}
+		}
+		mc.prevCPU = &cpu
+	} else {
+		log.Printf("⚠️ Failed to read CPU times: %v", err)
+	}
+	if total, available, err := readMemInfo(mc.procRoot); err == nil && total > 0 {
+		used := total - min(available, total)
+		metrics["memory_total_bytes"] = float64(total)
+		metrics["memory_used_bytes"] = float64(used)
+		metrics["memory_usage"] = percent(used, total)
+	} else if err != nil {
+		log.Printf("⚠️ Failed to read memory info: %v", err)
+	}
+	if load, err := readLoadAvg(mc.procRoot); err == nil {
+		metrics["load_1"] = load[0]
+		metrics["load_5"] = load[1]
+		metrics["load_15"] = load[2]
+	} else {
+		log.Printf("⚠️ Failed to read load average: %v", err)
+	}
+	return metrics
+}
+
+// percent returns part as a percentage of whole
+func percent(part, whole uint64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole) * 100
+}
+
+// cpuTimes are the aggregate CPU times of /proc/stat, in clock ticks
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+// usageSince returns the percentage of CPU time spent busy since an earlier
+// sample. It reports false if no time has passed between the samples.
+func (c cpuTimes) usageSince(prev cpuTimes) (float64, bool) {
+	if c.total <= prev.total || c.idle < prev.idle {
+		return 0, false
+	}
+	total := c.total - prev.total
+	idle := min(c.idle-prev.idle, total)
+	return percent(total-idle, total), true
+}
+
+// readCPUTimes reads the aggregate CPU line of /proc/stat. Guest time is
+// already counted in user time, so only the first eight fields are summed.
+// Idle time includes time waiting for I/O.
+func readCPUTimes(procRoot string) (cpuTimes, error) {
+	file, err := os.Open(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+
+		var times cpuTimes
+		for i, field := range fields[1:min(len(fields), 9)] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return cpuTimes{}, fmt.Errorf("invalid CPU time %q", field)
+			}
+			times.total += value
+			// idle and iowait
+			if i == 3 || i == 4 {
+				times.idle += value
+			}
+		}
+		return times, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return cpuTimes{}, err
+	}
+	return cpuTimes{}, errors.New("no cpu line in stat")
+}
+
+// readMemInfo reads the total and available memory of /proc/meminfo in bytes
+func readMemInfo(procRoot string) (total, available uint64, err error) {
+	values, err := readKilobyteFields(filepath.Join(procRoot, "meminfo"), "MemTotal", "MemAvailable")
+	if err != nil {
+		return 0, 0, err
+	}
+	return values["MemTotal"], values["MemAvailable"], nil
+}
+
+// readProcessRSS reads the resident set size of a process in bytes
+func readProcessRSS(procRoot string, pid int) (uint64, error) {
+	values, err := readKilobyteFields(filepath.Join(procRoot, strconv.Itoa(pid), "status"), "VmRSS")
+	if err != nil {
+		return 0, err
+	}
+	return values["VmRSS"], nil
+}
+
+// readKilobyteFields reads "Name: value kB" fields of a /proc file in bytes.
+// It fails if any of the named fields is missing.
+func readKilobyteFields(path string, names ...string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]uint64, len(names))
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		for _, wanted := range names {
+			if name != wanted {
+				continue
+			}
+			fields := strings.Fields(rest)
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("missing value of %s in %s", name, path)
+			}
+			value, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %s in %s: %q", name, path, fields[0])
+			}
+			values[name] = value * 1024
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("missing %s in %s", name, path)
+		}
+	}
+	return values, nil
+}
+
+// readLoadAvg reads the 1, 5 and 15 minute load averages of /proc/loadavg
+func readLoadAvg(procRoot string) ([3]float64, error) {
+	var load [3]float64
+	data, err := os.ReadFile(filepath.Join(procRoot, "loadavg"))
+	if err != nil {
+		return load, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return load, fmt.Errorf("invalid loadavg: %q", strings.TrimSpace(string(data)))
+	}
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return load, fmt.Errorf("invalid load average %q", fields[i])
+		}
+	}
+	return load, nil
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package services
+
+import "syscall"
+
+// hostProcRoot is where host metrics are read from
+const hostProcRoot = "/proc"
+
+// diskUsage returns the total, used and available bytes of the file system
+// containing path. Available bytes exclude blocks reserved for root.
+func diskUsage(path string) (total, used, avail uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, 0, err
+	}
+	blockSize := uint64(stat.Bsize)
+	total = stat.Blocks * blockSize
+	used = (stat.Blocks - stat.Bfree) * blockSize
+	avail = stat.Bavail * blockSize
+	return total, used, avail, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package services
+
+// hostProcRoot is empty on platforms without /proc, where no host metrics
+// are collected
+const hostProcRoot = ""
+
+// diskUsage is not supported on platforms without /proc
+func diskUsage(path string) (total, used, avail uint64, err error) {
+	return 0, 0, 0, errMetricsUnsupported
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// fixturePIDs reports fixed service process IDs
+type fixturePIDs map[string]int
+
+func (p fixturePIDs) ServicePIDs() map[string]int { return p }
+
+func TestProcParsing(t *testing.T) {
+	cpu, err := readCPUTimes("testdata/proc")
+	require.NoError(t, err)
+	assert.Equal(t, cpuTimes{idle: 7500, total: 10000}, cpu, "guest time is not counted twice")
+
+	usage, ok := cpu.usageSince(cpuTimes{idle: 7000, total: 9000})
+	require.True(t, ok)
+	assert.Equal(t, 50.0, usage)
+	_, ok = cpu.usageSince(cpu)
+	assert.False(t, ok)
+
+	total, available, err := readMemInfo("testdata/proc")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8000000*1024), total)
+	assert.Equal(t, uint64(6000000*1024), available)
+
+	load, err := readLoadAvg("testdata/proc")
+	require.NoError(t, err)
+	assert.Equal(t, [3]float64{0.54, 0.43, 0.40}, load)
+
+	rss, err := readProcessRSS("testdata/proc", 4242)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(51200*1024), rss)
+
+	_, err = readProcessRSS("testdata/proc", 1)
+	assert.Error(t, err)
+	_, _, err = readMemInfo(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestNewMetricsCollector(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	mc := NewMetricsCollector(db, config.MetricsConfig{}, "")
+	assert.Equal(t, defaultMetricsCollectInterval, mc.interval)
+	assert.Equal(t, 7, mc.retentionDays)
+
+	mc = NewMetricsCollector(db, config.MetricsConfig{CollectInterval: "10s", RawRetention: "36h"}, "")
+	assert.Equal(t, 10*time.Second, mc.interval)
+	assert.Equal(t, 2, mc.retentionDays, "retention is rounded up to whole days")
+
+	mc = NewMetricsCollector(db, config.MetricsConfig{RawRetention: "1h"}, "")
+	assert.Equal(t, 1, mc.retentionDays)
+}
+
+func TestMetricsCollector_Collect(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := db.MetricRepository()
+	now := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, repo.Insert(&database.Metric{
+		Timestamp:   now.AddDate(0, 0, -8),
+		ScopeType:   metricScopeHost,
+		ScopeID:     "node-1",
+		MetricName:  "cpu_usage",
+		MetricValue: 99,
+	}))
+
+	mc := NewMetricsCollector(db, config.MetricsConfig{RawRetention: "7d"}, t.TempDir())
+	mc.procRoot = "testdata/proc"
+	mc.hostID = "node-1"
+
+	collected := func(scopeType, scopeID string) map[string]float64 {
+		var metrics []*database.Metric
+		require.NoError(t, db.Select(&metrics, "SELECT * FROM metrics WHERE scope_type = ? AND scope_id = ? ORDER BY timestamp",
+			scopeType, scopeID))
+		values := make(map[string]float64)
+		for _, metric := range metrics {
+			values[metric.MetricName] = metric.MetricValue
+		}
+		return values
+	}
+
+	// CPU usage needs a previous sample
+	mc.Collect(now)
+	metrics := collected(metricScopeHost, "node-1")
+	assert.NotContains(t, metrics, "cpu_usage")
+	assert.Equal(t, float64(8000000*1024), metrics["memory_total_bytes"])
+	assert.Equal(t, float64(2000000*1024), metrics["memory_used_bytes"])
+	assert.Equal(t, 25.0, metrics["memory_usage"])
+	assert.Equal(t, 0.54, metrics["load_1"])
+	assert.Equal(t, 0.43, metrics["load_5"])
+	assert.Equal(t, 0.40, metrics["load_15"])
+	if runtime.GOOS == "linux" {
+		assert.Positive(t, metrics["disk_total_bytes"])
+		assert.Contains(t, metrics, "disk_used_bytes")
+		assert.Contains(t, metrics, "disk_usage")
+	}
+
+	stats, err := db.GetStats()
+	require.NoError(t, err)
+	assert.Equal(t, len(metrics), stats["metrics_count"], "metrics past retention are deleted")
+
+	mc.prevCPU = &cpuTimes{idle: 7000, total: 9000}
+	mc.Collect(now.Add(time.Second))
+	metrics = collected(metricScopeHost, "node-1")
+	assert.Equal(t, 50.0, metrics["cpu_usage"])
+
+	// Service collectors only report the memory of live service processes
+	mc = NewServiceMetricsCollector(db, config.MetricsConfig{}, fixturePIDs{"web": 4242, "exited": 1})
+	mc.procRoot = "testdata/proc"
+	mc.Collect(now)
+	assert.Equal(t, map[string]float64{"memory_rss_bytes": 51200 * 1024}, collected(metricScopeService, "web"))
+	assert.Empty(t, collected(metricScopeService, "exited"))
+	assert.Empty(t, collected(metricScopeHost, "localhost"))
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// Metric retention defaults used when the console config leaves them unset
+const (
+	defaultMetricsRollupAfter  = time.Hour
+	defaultMetricsRawRetention = 7 * 24 * time.Hour
+)
+
+// MetricsDownsampler periodically rolls raw metrics up into 5-minute buckets
+// and then prunes raw metrics past their retention
+type MetricsDownsampler struct {
+	repo         *database.MetricRepository
+	rollupAfter  time.Duration
+	rawRetention time.Duration
+	interval     time.Duration
+	ctx          context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+}
+
+// NewMetricsDownsampler creates a downsampler using the console metrics config
+func NewMetricsDownsampler(db *database.DB, cfg config.MetricsConfig) *MetricsDownsampler {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	md := &MetricsDownsampler{
+		repo:         db.MetricRepository(),
+		rollupAfter:  defaultMetricsRollupAfter,
+		rawRetention: defaultMetricsRawRetention,
+		interval:     database.MetricRollupInterval,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	if d, err := config.ParseRetention(cfg.RollupAfter); err == nil {
+		md.rollupAfter = d
+	}
+	if d, err := config.ParseRetention(cfg.RawRetention); err == nil {
+		md.rawRetention = d
+	}
+	return md
+}
+
+// Start starts the downsampler
+func (md *MetricsDownsampler) Start() {
+	md.wg.Add(1)
+	go md.run()
+}
+
+// Stop stops the downsampler
+func (md *MetricsDownsampler) Stop() {
+	md.cancel()
+	md.wg.Wait()
+}
+
+// run downsamples on every interval until stopped
+func (md *MetricsDownsampler) run() {
+	defer md.wg.Done()
+
+	ticker := time.NewTicker(md.interval)
+	defer ticker.Stop()
+
+	md.Downsample(time.Now())
+
+	for {
+		select {
+		case <-md.ctx.Done():
+			return
+		case <-ticker.C:
+			md.Downsample(time.Now())
+		}
+	}
+}
+
+// Downsample rolls up raw metrics older than the rollup age and then deletes
+// raw metrics older than the retention. Pruning is skipped if the rollup
+// fails so that no raw data is lost before it is rolled up.
+func (md *MetricsDownsampler) Downsample(now time.Time) {
+	if _, err := md.repo.Rollup(now.Add(-md.rollupAfter)); err != nil {
+		log.Printf("❌ Failed to roll up metrics: %v", err)
+		return
+	}
+	if _, err := md.repo.DeleteOlderThan(now.Add(-md.rawRetention)); err != nil {
+		log.Printf("❌ Failed to prune raw metrics: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+func TestNewMetricsDownsampler(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	md := NewMetricsDownsampler(db, config.MetricsConfig{})
+	assert.Equal(t, defaultMetricsRollupAfter, md.rollupAfter)
+	assert.Equal(t, defaultMetricsRawRetention, md.rawRetention)
+
+	md = NewMetricsDownsampler(db, config.MetricsConfig{RollupAfter: "30m", RawRetention: "2d"})
+	assert.Equal(t, 30*time.Minute, md.rollupAfter)
+	assert.Equal(t, 48*time.Hour, md.rawRetention)
+}
+
+func TestMetricsDownsampler_Downsample(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := db.MetricRepository()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for _, age := range []time.Duration{3 * time.Hour, 90 * time.Minute, 10 * time.Minute} {
+		require.NoError(t, repo.Insert(&database.Metric{
+			Timestamp:   now.Add(-age),
+			ScopeType:   "host",
+			ScopeID:     "node-1",
+			MetricName:  "load",
+			MetricValue: 1,
+		}))
+	}
+
+	md := NewMetricsDownsampler(db, config.MetricsConfig{RollupAfter: "1h", RawRetention: "2h"})
+	md.Downsample(now)
+
+	stats, err := db.GetStats()
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats["metrics_rollup_count"], "samples older than an hour are rolled up")
+	assert.Equal(t, 2, stats["metrics_count"], "samples older than two hours are pruned")
+
+	buckets, err := repo.QueryAggregated("host", "node-1", "load", now.Add(-4*time.Hour), now, time.Hour, database.MetricAggCount)
+	require.NoError(t, err)
+	var total float64
+	for _, bucket := range buckets {
+		total += *bucket.Value
+	}
+	assert.Equal(t, float64(3), total)
+}
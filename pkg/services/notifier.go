@@ -0,0 +1,66 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// StatusChange describes a registered service changing status after its
+// health checks started failing or recovered
+type StatusChange struct {
+	ServiceID     string    `json:"service_id"`
+	Service       string    `json:"service"`
+	OldStatus     string    `json:"old_status"`
+	NewStatus     string    `json:"new_status"`
+	Error         string    `json:"error,omitempty"` // error of the check that caused the change
+	FailureStreak int       `json:"failure_streak"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// StatusNotifier is told about every status change the health checker makes
+type StatusNotifier interface {
+	Notify(ctx context.Context, change StatusChange) error
+}
+
+// WebhookNotifier POSTs status changes to a URL as JSON
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a notifier posting to url
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify posts the change, failing unless the webhook answers with a 2xx status
+func (wn *WebhookNotifier) Notify(ctx context.Context, change StatusChange) error {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("failed to encode status change: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wn.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// Retention defaults used when the console config leaves them unset
+const (
+	defaultRetentionInterval     = 24 * time.Hour
+	defaultMetricRollupRetention = 90 * 24 * time.Hour
+	defaultHealthCheckRetention  = 7 * 24 * time.Hour
+	defaultAuditLogRetention     = 365 * 24 * time.Hour
+	defaultLoginAttemptRetention = 30 * 24 * time.Hour
+)
+
+// RetentionManager periodically deletes rows past their retention so the
+// console database does not grow without bound, then returns the freed
+// space to the filesystem. Every run is recorded in maintenance_runs.
+type RetentionManager struct {
+	db            *database.DB
+	interval      time.Duration
+	metrics       time.Duration
+	healthChecks  time.Duration
+	auditLogs     time.Duration
+	loginAttempts time.Duration
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+}
+
+// NewRetentionManager creates a retention manager using the console
+// retention config
+func NewRetentionManager(db *database.DB, cfg config.RetentionConfig) *RetentionManager {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	rm := &RetentionManager{
+		db:            db,
+		interval:      defaultRetentionInterval,
+		metrics:       defaultMetricRollupRetention,
+		healthChecks:  defaultHealthCheckRetention,
+		auditLogs:     defaultAuditLogRetention,
+		loginAttempts: defaultLoginAttemptRetention,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+	if d, err := time.ParseDuration(cfg.Interval); err == nil && d > 0 {
+		rm.interval = d
+	}
+	for _, setting := range []struct {
+		value     string
+		retention *time.Duration
+	}{
+		{cfg.Metrics, &rm.metrics},
+		{cfg.HealthChecks, &rm.healthChecks},
+		{cfg.AuditLogs, &rm.auditLogs},
+		{cfg.LoginAttempts, &rm.loginAttempts},
+	} {
+		if d, err := config.ParseRetention(setting.value); err == nil && setting.value != "" {
+			*setting.retention = d
+		}
+	}
+	return rm
+}
+
+// Start starts the retention manager
+func (rm *RetentionManager) Start() {
+	rm.wg.Add(1)
+	go rm.run()
+}
+
+// Stop stops the retention manager, waiting for a run in progress
+func (rm *RetentionManager) Stop() {
+	rm.cancel()
+	rm.wg.Wait()
+}
+
+// run cleans up on every interval until stopped
+func (rm *RetentionManager) run() {
+	defer rm.wg.Done()
+
+	ticker := time.NewTicker(rm.interval)
+	defer ticker.Stop()
+
+	rm.Run(time.Now())
+
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+			rm.Run(time.Now())
+		}
+	}
+}
+
+// Run deletes the rows past their retention at now and expired SSO sessions
+// and launch tokens, reclaims the space they took and records the run. A
+// table that fails to clean up does not stop the others; its error is
+// recorded instead.
+func (rm *RetentionManager) Run(now time.Time) *database.MaintenanceRun {
+	started := time.Now()
+	deleted := make(map[string]int64)
+	var problems []string
+
+	prune := func(table string, deleteRows func() (int64, error)) {
+		rows, err := deleteRows()
+		if err != nil {
+			log.Printf("❌ Failed to clean up %s: %v", table, err)
+			problems = append(problems, fmt.Sprintf("%s: %v", table, err))
+			return
+		}
+		deleted[table] = rows
+	}
+	prune("metrics_rollup", func() (int64, error) {
+		return rm.db.MetricRepository().DeleteRollupsOlderThan(now.Add(-rm.metrics))
+	})
+	prune("service_health_checks", func() (int64, error) {
+		return rm.db.ServiceHealthCheckRepository().CleanupOldChecks(now.Add(-rm.healthChecks))
+	})
+	prune("audit_logs", func() (int64, error) {
+		return rm.db.AuditLogRepository().DeleteOlderThan(now.Add(-rm.auditLogs))
+	})
+	prune("login_attempts", func() (int64, error) {
+		return rm.db.LoginAttemptRepository().DeleteOlderThan(now.Add(-rm.loginAttempts))
+	})
+	prune("sso_sessions", func() (int64, error) {
+		return rm.db.SSOSessionRepository().CleanupExpiredSessions(now)
+	})
+	prune("sso_launch_tokens", func() (int64, error) {
+		return rm.db.SSOLaunchTokenRepository().DeleteExpired(now)
+	})
+
+	if err := rm.db.Reclaim(); err != nil {
+		log.Printf("❌ Failed to reclaim database space: %v", err)
+		problems = append(problems, err.Error())
+	}
+
+	summary, err := json.Marshal(deleted)
+	if err != nil {
+		summary = []byte("{}")
+	}
+	run := &database.MaintenanceRun{
+		StartedAt:  now,
+		DurationMS: time.Since(started).Milliseconds(),
+		Deleted:    string(summary),
+		Error:      strings.Join(problems, "; "),
+	}
+	if err := rm.db.MaintenanceRunRepository().Create(run); err != nil {
+		log.Printf("❌ Failed to record maintenance run: %v", err)
+	}
+
+	var total int64
+	for _, rows := range deleted {
+		total += rows
+	}
+	log.Printf("🧹 Retention cleanup deleted %d rows in %dms", total, run.DurationMS)
+	return run
+}
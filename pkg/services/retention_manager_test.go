@@ -0,0 +1,108 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// countRows returns the number of rows in a table
+func countRows(t *testing.T, db *database.DB, table string) int {
+	var count int
+	require.NoError(t, db.Get(&count, fmt.Sprintf("SELECT COUNT(*) FROM %s", table)))
+	return count
+}
+
+func TestRetentionManager_Run(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	old, recent := now.AddDate(0, 0, -400), now.Add(-time.Hour)
+
+	user := &database.User{Username: "ops", Email: "ops@example.com", PasswordHash: "x", Role: "admin"}
+	require.NoError(t, db.UserRepository().Create(user))
+	require.NoError(t, db.RegisteredServiceRepository().Create(&database.RegisteredService{
+		ID: "wiki", Name: "wiki", DisplayName: "Wiki", ServiceURL: "http://localhost:8080", RequiredRole: "user", Status: "active",
+	}))
+
+	for i, at := range []time.Time{old, old.Add(time.Hour), recent} {
+		_, err := db.Exec(`INSERT INTO metrics_rollup (bucket_start, scope_type, scope_id, metric_name, sample_count,
+			value_sum, value_min, value_max, value_sum_squares) VALUES (?, 'host', 'h1', 'cpu', 1, 1, 1, 1, 1)`,
+			at.UTC().Add(time.Duration(i)*time.Minute).Format("2006-01-02 15:04:05"))
+		require.NoError(t, err)
+	}
+	for _, at := range []time.Time{now.AddDate(0, 0, -8), recent} {
+		require.NoError(t, db.ServiceHealthCheckRepository().Record(&database.ServiceHealthCheck{ServiceID: "wiki", IsHealthy: true, CheckedAt: at}))
+	}
+	for _, at := range []time.Time{old, recent} {
+		require.NoError(t, db.AuditLogRepository().Create(&database.AuditLog{Action: "login", ResourceType: "user", CreatedAt: at}))
+	}
+	for _, at := range []time.Time{now.AddDate(0, 0, -31), now.AddDate(0, 0, -40), recent} {
+		require.NoError(t, db.LoginAttemptRepository().Record(&database.LoginAttempt{Username: "ops", CreatedAt: at}))
+	}
+	for i, expires := range []time.Time{now.Add(-time.Minute), now.Add(time.Hour)} {
+		require.NoError(t, db.SSOSessionRepository().Create(&database.SSOSession{
+			UserID: user.ID, TokenHash: fmt.Sprintf("token-%d", i), ExpiresAt: expires, IsActive: true,
+		}))
+	}
+
+	// Default retentions but for audit logs, kept for two years
+	rm := NewRetentionManager(db, config.RetentionConfig{AuditLogs: "730d"})
+	run := rm.Run(now)
+
+	assert.Empty(t, run.Error)
+	assert.Equal(t, now.Unix(), run.StartedAt.Unix())
+	deleted, err := run.DeletedRows()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{
+		"metrics_rollup":        2,
+		"service_health_checks": 1,
+		"audit_logs":            0,
+		"login_attempts":        2,
+		"sso_sessions":          1,
+		"sso_launch_tokens":     0,
+	}, deleted)
+
+	assert.Equal(t, 1, countRows(t, db, "metrics_rollup"))
+	assert.Equal(t, 1, countRows(t, db, "service_health_checks"))
+	assert.Equal(t, 2, countRows(t, db, "audit_logs"))
+	assert.Equal(t, 1, countRows(t, db, "login_attempts"))
+	assert.Equal(t, 1, countRows(t, db, "sso_sessions"))
+
+	// The run is recorded, and a second run has nothing left to delete
+	second := rm.Run(now)
+	runs, err := db.MaintenanceRunRepository().List(10)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, second.ID, runs[0].ID)
+	recorded, err := runs[1].DeletedRows()
+	require.NoError(t, err)
+	assert.Equal(t, deleted, recorded)
+	recorded, err = runs[0].DeletedRows()
+	require.NoError(t, err)
+	for table, rows := range recorded {
+		assert.Zero(t, rows, table)
+	}
+}
+
+func TestNewRetentionManager(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	rm := NewRetentionManager(db, config.RetentionConfig{})
+	assert.Equal(t, defaultRetentionInterval, rm.interval)
+	assert.Equal(t, defaultAuditLogRetention, rm.auditLogs)
+
+	rm = NewRetentionManager(db, config.RetentionConfig{Interval: "6h", Metrics: "30d", HealthChecks: "36h", LoginAttempts: "bad"})
+	assert.Equal(t, 6*time.Hour, rm.interval)
+	assert.Equal(t, 30*24*time.Hour, rm.metrics)
+	assert.Equal(t, 36*time.Hour, rm.healthChecks)
+	assert.Equal(t, defaultLoginAttemptRetention, rm.loginAttempts)
+}
@@ -0,0 +1,34 @@
+package spec
+
+import (
+	"strings"
+
+	"github.com/last-emo-boy/infra-core/pkg/diff"
+)
+
+// redeployFields are the top-level fields of a spec whose changes only
+// apply to new instances, so the running ones have to be replaced. Scaling,
+// health checks and log settings apply to the instances as they are.
+var redeployFields = map[string]bool{
+	"image":           true,
+	"port":            true,
+	"ports":           true,
+	"env":             true,
+	"env_from_secret": true,
+	"command":         true,
+	"args":            true,
+	"volumes":         true,
+	"resources":       true,
+	"node_selector":   true,
+}
+
+// Diff returns the changes from one spec to another, marking those that
+// require the service to be redeployed. Before is nil for a new service.
+func Diff(before, after *Spec) []diff.Change {
+	changes := diff.Compare(before, after)
+	for i := range changes {
+		field, _, _ := strings.Cut(changes[i].Field, ".")
+		changes[i].Redeploy = redeployFields[field]
+	}
+	return changes
+}
@@ -0,0 +1,26 @@
+package spec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/last-emo-boy/infra-core/pkg/diff"
+)
+
+func TestDiff(t *testing.T) {
+	before := &Spec{Name: "api", Image: "api:1", Port: 8080, Replicas: 2, Env: map[string]string{"MODE": "live"}}
+	after := &Spec{Name: "api", Image: "api:1", Port: 8080, Replicas: 3, Env: map[string]string{"MODE": "live", "DEBUG": "1"}}
+
+	changes := Diff(before, after)
+	assert.Equal(t, []diff.Change{
+		{Field: "env.DEBUG", From: nil, To: "1", Redeploy: true},
+		{Field: "replicas", From: float64(2), To: float64(3)},
+	}, changes)
+	assert.True(t, diff.Redeploys(changes))
+
+	// Scaling applies to the running instances
+	after.Env = before.Env
+	assert.False(t, diff.Redeploys(Diff(before, after)))
+	assert.Empty(t, Diff(before, before))
+}
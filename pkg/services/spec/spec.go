@@ -0,0 +1,350 @@
+// Package spec defines the YAML spec of a service and validates it, so that
+// mistakes are reported when a spec is saved rather than when it is deployed.
+package spec
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/last-emo-boy/infra-core/pkg/logs"
+)
+
+// Spec is the YAML spec of a service. The console keeps it in the service's
+// yaml_config and fills the service's image, port, replicas and environment
+// columns from it.
+type Spec struct {
+	Name          string            `yaml:"name" json:"name"`
+	Image         string            `yaml:"image" json:"image"`
+	Port          int               `yaml:"port,omitempty" json:"port,omitempty"`   // shorthand for a single port
+	Ports         []Port            `yaml:"ports,omitempty" json:"ports,omitempty"` // the first is the service's port when port is unset
+	Replicas      int               `yaml:"replicas,omitempty" json:"replicas,omitempty"`
+	Env           map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	EnvFromSecret map[string]string `yaml:"env_from_secret,omitempty" json:"env_from_secret,omitempty"` // variable to the name of the secret holding its value
+	Command       []string          `yaml:"command,omitempty" json:"command,omitempty"`
+	Args          []string          `yaml:"args,omitempty" json:"args,omitempty"`
+	Volumes       []string          `yaml:"volumes,omitempty" json:"volumes,omitempty"` // host:container[:ro]
+	Resources     *Resources        `yaml:"resources,omitempty" json:"resources,omitempty"`
+	NodeSelector  map[string]string `yaml:"node_selector,omitempty" json:"node_selector,omitempty"` // labels of the cluster nodes the service may run on
+	HealthCheck   *HealthCheck      `yaml:"health_check,omitempty" json:"health_check,omitempty"`
+	Logging       *logs.Config      `yaml:"logging,omitempty" json:"logging,omitempty"`
+}
+
+// Port is a port the service listens on
+type Port struct {
+	Internal int    `yaml:"internal" json:"internal"`
+	External int    `yaml:"external,omitempty" json:"external,omitempty"` // published port, unset to keep it internal
+	Protocol string `yaml:"protocol,omitempty" json:"protocol,omitempty"` // tcp (the default) or udp
+}
+
+// Resources limits what the service may use
+type Resources struct {
+	CPU    string `yaml:"cpu,omitempty" json:"cpu,omitempty"`       // cores like "0.5" or millicores like "500m"
+	Memory string `yaml:"memory,omitempty" json:"memory,omitempty"` // bytes with an optional unit like "512Mi" or "1G"
+}
+
+// HealthCheck describes how the service's health is checked over HTTP
+type HealthCheck struct {
+	Path     string `yaml:"path,omitempty" json:"path,omitempty"`
+	Port     int    `yaml:"port,omitempty" json:"port,omitempty"` // defaults to the service's port
+	Interval string `yaml:"interval,omitempty" json:"interval,omitempty"`
+	Timeout  string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Retries  int    `yaml:"retries,omitempty" json:"retries,omitempty"`
+}
+
+// ValidationError is a problem with one field of a spec
+type ValidationError struct {
+	Field   string `json:"field"`          // path of the field, such as ports[0].internal, empty for the whole spec
+	Line    int    `json:"line,omitempty"` // line of the field in the YAML, when known
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	var b strings.Builder
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", e.Line)
+	}
+	if e.Field != "" {
+		b.WriteString(e.Field + ": ")
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// ParseAndValidate decodes a YAML spec and checks it, returning every problem
+// found rather than stopping at the first. Unknown fields and values of the
+// wrong type are reported with their line. The spec is nil when the YAML
+// could not be decoded into one.
+func ParseAndValidate(document string) (*Spec, []ValidationError) {
+	if strings.TrimSpace(document) == "" {
+		return nil, []ValidationError{{Message: "spec is empty"}}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(document), &root); err != nil {
+		return nil, []ValidationError{syntaxError(err)}
+	}
+	if len(root.Content) == 0 {
+		return nil, []ValidationError{{Message: "spec is empty"}}
+	}
+
+	v := &validator{lines: make(map[string]int)}
+	v.checkNode(root.Content[0], reflect.TypeOf(Spec{}), "")
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+
+	var spec Spec
+	if err := root.Content[0].Decode(&spec); err != nil {
+		return nil, []ValidationError{syntaxError(err)}
+	}
+	spec.validate(v)
+	return &spec, v.errs
+}
+
+// Validate checks a spec built in code rather than parsed from YAML
+func (s *Spec) Validate() []ValidationError {
+	v := &validator{lines: make(map[string]int)}
+	s.validate(v)
+	return v.errs
+}
+
+// PrimaryPort returns the port the service is reached on, or 0 if it has none
+func (s *Spec) PrimaryPort() int {
+	if s.Port > 0 {
+		return s.Port
+	}
+	if len(s.Ports) > 0 {
+		return s.Ports[0].Internal
+	}
+	return 0
+}
+
+// SetPrimaryPort changes the port the service is reached on
+func (s *Spec) SetPrimaryPort(port int) {
+	if s.Port == 0 && len(s.Ports) > 0 {
+		s.Ports[0].Internal = port
+		return
+	}
+	s.Port = port
+}
+
+// WithPort sets the port of a YAML spec, leaving the rest of the document
+// as it is
+func WithPort(document string, port int) (string, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(document), &root); err != nil {
+		return "", fmt.Errorf("failed to parse service spec: %w", err)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return "", fmt.Errorf("service spec is not a mapping")
+	}
+
+	mapping := root.Content[0]
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(port)}
+	replaced := false
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == "port" {
+			mapping.Content[i+1] = value
+			replaced = true
+		}
+	}
+	if !replaced {
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "port"}, value)
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return "", fmt.Errorf("failed to encode service spec: %w", err)
+	}
+	return out.String(), nil
+}
+
+// YAML encodes the spec
+func (s *Spec) YAML() (string, error) {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode service spec: %w", err)
+	}
+	return string(out), nil
+}
+
+// yamlLine finds the line number in a yaml.v3 error message
+var yamlLine = regexp.MustCompile(`line (\d+)`)
+
+// syntaxError converts a YAML decoding error
+func syntaxError(err error) ValidationError {
+	message := strings.TrimPrefix(err.Error(), "yaml: ")
+	problem := ValidationError{Message: message}
+	if match := yamlLine.FindStringSubmatch(message); match != nil {
+		problem.Line, _ = strconv.Atoi(match[1])
+		problem.Message = strings.TrimPrefix(strings.TrimPrefix(message, match[0]), ": ")
+	}
+	return problem
+}
+
+// validator collects the problems found in a spec, along with the line of
+// each field seen in its YAML
+type validator struct {
+	errs  []ValidationError
+	lines map[string]int
+}
+
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{Field: field, Line: v.lines[field], Message: fmt.Sprintf(format, args...)})
+}
+
+// checkNode checks that a YAML node has the shape of type t, recording the
+// line of every field. Problems are reported per field, so that one typo
+// does not hide the others.
+func (v *validator) checkNode(node *yaml.Node, t reflect.Type, field string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	v.lines[field] = node.Line
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.mismatch(node, field, "a mapping")
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			path := joinField(field, key.Value)
+			f, ok := fields[key.Value]
+			if !ok {
+				v.lines[path] = key.Line
+				v.unknown(path, key.Value, fields)
+				continue
+			}
+			v.checkNode(value, f.Type, path)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.mismatch(node, field, "a list")
+			return
+		}
+		for i, item := range node.Content {
+			v.checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", field, i))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.mismatch(node, field, "a mapping")
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.checkNode(node.Content[i+1], t.Elem(), joinField(field, node.Content[i].Value))
+		}
+	default:
+		if node.Kind != yaml.ScalarNode {
+			v.mismatch(node, field, kindName(t))
+			return
+		}
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.mismatch(node, field, kindName(t))
+		}
+	}
+}
+
+// mismatch reports a value of the wrong type
+func (v *validator) mismatch(node *yaml.Node, field, expected string) {
+	got := fmt.Sprintf("%q", node.Value)
+	switch node.Kind {
+	case yaml.MappingNode:
+		got = "a mapping"
+	case yaml.SequenceNode:
+		got = "a list"
+	}
+	v.add(field, "must be %s, got %s", expected, got)
+}
+
+// unknown reports a field the spec does not have, suggesting the closest
+// known one when it looks like a typo
+func (v *validator) unknown(field, name string, known map[string]reflect.StructField) {
+	best, distance := "", 3
+	for candidate := range known {
+		if d := editDistance(strings.ToLower(name), candidate); d < distance || (d == distance && candidate < best) {
+			best, distance = candidate, d
+		}
+	}
+	if best != "" {
+		v.add(field, "unknown field %q, did you mean %q?", name, best)
+		return
+	}
+	v.add(field, "unknown field %q", name)
+}
+
+// yamlFields returns the fields of a struct by their YAML name
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// kindName describes the values a scalar type accepts
+func kindName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "true or false"
+	default:
+		return "a string"
+	}
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,167 @@
+package spec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixture reads a spec from testdata
+func fixture(t *testing.T, name string) string {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestParseValidSpecs(t *testing.T) {
+	spec, problems := ParseAndValidate(fixture(t, "valid/minimal.yaml"))
+	require.Empty(t, problems)
+	assert.Equal(t, "hello-service", spec.Name)
+	assert.Equal(t, 8080, spec.PrimaryPort())
+
+	spec, problems = ParseAndValidate(fixture(t, "valid/full.yaml"))
+	require.Empty(t, problems)
+	assert.Equal(t, "registry.example.com/api:1.4.2", spec.Image)
+	assert.Equal(t, 8080, spec.PrimaryPort())
+	assert.Equal(t, 3, spec.Replicas)
+	assert.Equal(t, map[string]string{"NODE_ENV": "production", "PORT": "8080", "DEBUG": "false"}, spec.Env)
+	assert.Equal(t, map[string]string{"DATABASE_PASSWORD": "api-db-password"}, spec.EnvFromSecret)
+	assert.Equal(t, []string{"node", "server.js"}, spec.Command)
+	assert.Equal(t, Port{Internal: 9090, External: 19090, Protocol: "udp"}, spec.Ports[0])
+	assert.Equal(t, "512Mi", spec.Resources.Memory)
+	assert.Equal(t, map[string]string{"zone": "eu-west"}, spec.NodeSelector)
+	assert.Equal(t, "10s", spec.HealthCheck.Interval)
+	assert.Equal(t, "severity", spec.Logging.LevelField)
+
+	// The generated YAML parses back to the same spec
+	encoded, err := spec.YAML()
+	require.NoError(t, err)
+	reparsed, problems := ParseAndValidate(encoded)
+	require.Empty(t, problems)
+	assert.Equal(t, spec, reparsed)
+}
+
+func TestParseInvalidSpecs(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		expected []ValidationError
+	}{
+		{"invalid/unknown_fields.yaml", []ValidationError{
+			{Field: "replcas", Line: 3, Message: `unknown field "replcas", did you mean "replicas"?`},
+			{Field: "ports[0].exteral", Line: 6, Message: `unknown field "exteral", did you mean "external"?`},
+			{Field: "health_check.intervall", Line: 9, Message: `unknown field "intervall", did you mean "interval"?`},
+			{Field: "labels", Line: 10, Message: `unknown field "labels"`},
+		}},
+		{"invalid/type_mismatches.yaml", []ValidationError{
+			{Field: "port", Line: 3, Message: `must be an integer, got "eighty"`},
+			{Field: "replicas", Line: 4, Message: `must be an integer, got "two"`},
+			{Field: "env", Line: 6, Message: "must be a mapping, got a list"},
+			{Field: "command", Line: 7, Message: `must be a list, got "node server.js"`},
+			{Field: "health_check.retries", Line: 9, Message: "must be an integer, got a list"},
+		}},
+		{"invalid/values.yaml", []ValidationError{
+			{Field: "name", Line: 1, Message: `must be 1 to 63 letters, digits, '_', '.' or '-', starting with a letter or digit, got "my service"`},
+			{Field: "image", Line: 2, Message: "is required"},
+			{Field: "port", Line: 3, Message: "must be between 1 and 65535, got 70000"},
+			{Field: "ports[0].internal", Line: 5, Message: "must be between 1 and 65535, got 0"},
+			{Field: "ports[0].protocol", Line: 6, Message: `must be tcp or udp, got "sctp"`},
+			{Field: "replicas", Line: 7, Message: "must not be negative, got -1"},
+			{Field: "env.1BAD", Line: 9, Message: "is not a valid environment variable name"},
+			{Field: "env_from_secret.2BAD", Line: 11, Message: "is not a valid environment variable name"},
+			{Field: "env_from_secret.2BAD", Line: 11, Message: `must name a secret, got "db/password"`},
+			{Field: "volumes[0]", Line: 13, Message: `must be host:/container[:ro], got "data"`},
+			{Field: "resources.cpu", Line: 15, Message: `must be cores like "0.5" or millicores like "500m", got "fast"`},
+			{Field: "health_check.path", Line: 18, Message: `must start with /, got "healthz"`},
+			{Field: "health_check.interval", Line: 19, Message: `must be a positive duration like "10s", got "soon"`},
+			{Field: "logging", Line: 21, Message: "unsupported log format: xml"},
+		}},
+		{"invalid/syntax.yaml", []ValidationError{
+			{Line: 3, Message: "did not find expected '-' indicator"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			_, problems := ParseAndValidate(fixture(t, tt.fixture))
+			assert.Equal(t, tt.expected, problems)
+		})
+	}
+}
+
+func TestParseEmptySpec(t *testing.T) {
+	for _, document := range []string{"", "  \n", "# nothing yet\n"} {
+		spec, problems := ParseAndValidate(document)
+		assert.Nil(t, spec)
+		assert.Equal(t, []ValidationError{{Message: "spec is empty"}}, problems, document)
+	}
+
+	_, problems := ParseAndValidate("- web\n- api\n")
+	assert.Equal(t, []ValidationError{{Line: 1, Message: "must be a mapping, got a list"}}, problems)
+}
+
+func TestValidateBuiltSpec(t *testing.T) {
+	spec := &Spec{Name: "web", Image: "nginx:1.27", Port: 80}
+	assert.Empty(t, spec.Validate())
+
+	spec.SetPrimaryPort(8080)
+	assert.Equal(t, 8080, spec.Port)
+
+	spec = &Spec{Ports: []Port{{Internal: 80}}}
+	spec.SetPrimaryPort(8080)
+	assert.Equal(t, 8080, spec.PrimaryPort())
+	assert.Zero(t, spec.Port)
+	assert.Equal(t, []ValidationError{
+		{Field: "name", Message: "is required"},
+		{Field: "image", Message: "is required"},
+	}, spec.Validate())
+	assert.Equal(t, "image: is required", spec.Validate()[1].Error())
+
+	// A variable comes from env or from a secret, not both
+	spec = &Spec{Name: "web", Image: "nginx:1.27", Env: map[string]string{"TOKEN": "x"}, EnvFromSecret: map[string]string{"TOKEN": "web-token"}}
+	assert.Equal(t, []ValidationError{{Field: "env_from_secret.TOKEN", Message: "is also set in env"}}, spec.Validate())
+	spec = &Spec{Name: "web", Image: "nginx:1.27", NodeSelector: map[string]string{"disk type": "ssd"}}
+	assert.Equal(t, []ValidationError{{Field: "node_selector.disk type", Message: "is not a valid node label"}}, spec.Validate())
+	assert.True(t, ValidSecretName("web-token"))
+	assert.False(t, ValidSecretName("web/token"))
+}
+
+func TestParseResources(t *testing.T) {
+	cpus := map[string]int64{
+		"":      0,
+		"2":     2_000_000_000,
+		"0.5":   500_000_000,
+		"500m":  500_000_000,
+		"1000m": 1_000_000_000,
+	}
+	for value, expected := range cpus {
+		actual, err := ParseCPU(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, actual, value)
+	}
+	for _, value := range []string{"fast", "-1", "0", "m"} {
+		_, err := ParseCPU(value)
+		assert.Error(t, err, value)
+	}
+
+	memory := map[string]int64{
+		"":      0,
+		"1024":  1024,
+		"64Ki":  64 << 10,
+		"512Mi": 512 << 20,
+		"1Gi":   1 << 30,
+		"1.5G":  1_500_000_000,
+		"256M":  256_000_000,
+	}
+	for value, expected := range memory {
+		actual, err := ParseMemory(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, actual, value)
+	}
+	for _, value := range []string{"lots", "-1Gi", "Mi"} {
+		_, err := ParseMemory(value)
+		assert.Error(t, err, value)
+	}
+}
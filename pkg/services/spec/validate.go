@@ -0,0 +1,191 @@
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// validName matches service names, which also name containers and
+	// service instances
+	validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+
+	// validEnvName matches environment variable names
+	validEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// validLabel matches the names of node labels
+	validLabel = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_./-]{0,62}$`)
+)
+
+// validate checks the values of a decoded spec
+func (s *Spec) validate(v *validator) {
+	if s.Name == "" {
+		v.add("name", "is required")
+	} else if !validName.MatchString(s.Name) {
+		v.add("name", "must be 1 to 63 letters, digits, '_', '.' or '-', starting with a letter or digit, got %q", s.Name)
+	}
+	if s.Image == "" {
+		v.add("image", "is required")
+	} else if strings.ContainsAny(s.Image, " \t\n") {
+		v.add("image", "must not contain whitespace, got %q", s.Image)
+	}
+
+	if s.Port != 0 {
+		validatePort(v, "port", s.Port)
+	}
+	for i, port := range s.Ports {
+		field := fmt.Sprintf("ports[%d]", i)
+		validatePort(v, field+".internal", port.Internal)
+		if port.External != 0 {
+			validatePort(v, field+".external", port.External)
+		}
+		switch port.Protocol {
+		case "", "tcp", "udp":
+		default:
+			v.add(field+".protocol", "must be tcp or udp, got %q", port.Protocol)
+		}
+	}
+	if s.Replicas < 0 {
+		v.add("replicas", "must not be negative, got %d", s.Replicas)
+	}
+
+	for name := range s.Env {
+		if !validEnvName.MatchString(name) {
+			v.add(joinField("env", name), "is not a valid environment variable name")
+		}
+	}
+	for name, secret := range s.EnvFromSecret {
+		field := joinField("env_from_secret", name)
+		if !validEnvName.MatchString(name) {
+			v.add(field, "is not a valid environment variable name")
+		} else if _, set := s.Env[name]; set {
+			v.add(field, "is also set in env")
+		}
+		if !ValidSecretName(secret) {
+			v.add(field, "must name a secret, got %q", secret)
+		}
+	}
+	for i, arg := range s.Command {
+		if arg == "" {
+			v.add(fmt.Sprintf("command[%d]", i), "must not be empty")
+		}
+	}
+	for i, volume := range s.Volumes {
+		parts := strings.Split(volume, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || !strings.HasPrefix(parts[1], "/") ||
+			(len(parts) == 3 && parts[2] != "ro" && parts[2] != "rw") {
+			v.add(fmt.Sprintf("volumes[%d]", i), "must be host:/container[:ro], got %q", volume)
+		}
+	}
+
+	if s.Resources != nil {
+		if _, err := ParseCPU(s.Resources.CPU); err != nil {
+			v.add("resources.cpu", "must be cores like \"0.5\" or millicores like \"500m\", got %q", s.Resources.CPU)
+		}
+		if _, err := ParseMemory(s.Resources.Memory); err != nil {
+			v.add("resources.memory", "must be bytes with an optional unit like \"512Mi\" or \"1G\", got %q", s.Resources.Memory)
+		}
+	}
+
+	for label := range s.NodeSelector {
+		if !validLabel.MatchString(label) {
+			v.add(joinField("node_selector", label), "is not a valid node label")
+		}
+	}
+
+	if check := s.HealthCheck; check != nil {
+		if check.Path != "" && !strings.HasPrefix(check.Path, "/") {
+			v.add("health_check.path", "must start with /, got %q", check.Path)
+		}
+		if check.Port != 0 {
+			validatePort(v, "health_check.port", check.Port)
+		}
+		validateDuration(v, "health_check.interval", check.Interval)
+		validateDuration(v, "health_check.timeout", check.Timeout)
+		if check.Retries < 0 {
+			v.add("health_check.retries", "must not be negative, got %d", check.Retries)
+		}
+	}
+
+	if s.Logging != nil {
+		if err := s.Logging.Validate(); err != nil {
+			v.add("logging", "%v", err)
+		}
+	}
+}
+
+// ValidSecretName reports whether name can name a secret. Secret names
+// follow the rules of service names.
+func ValidSecretName(name string) bool {
+	return validName.MatchString(name)
+}
+
+func validatePort(v *validator, field string, port int) {
+	if port < 1 || port > 65535 {
+		v.add(field, "must be between 1 and 65535, got %d", port)
+	}
+}
+
+func validateDuration(v *validator, field, value string) {
+	if value == "" {
+		return
+	}
+	if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+		v.add(field, "must be a positive duration like \"10s\", got %q", value)
+	}
+}
+
+// ParseCPU parses a CPU requirement in cores, like "2" or "0.5", or in
+// millicores, like "500m", into nano CPUs. Empty means no limit.
+func ParseCPU(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	scale := 1e9
+	number := value
+	if strings.HasSuffix(value, "m") {
+		scale = 1e6
+		number = strings.TrimSuffix(value, "m")
+	}
+	cpus, err := strconv.ParseFloat(number, 64)
+	if err != nil || cpus <= 0 {
+		return 0, fmt.Errorf("invalid cpu requirement %q", value)
+	}
+	return int64(cpus * scale), nil
+}
+
+// memoryUnits are the suffixes of memory requirements, binary ones first
+var memoryUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"k", 1e3}, {"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+}
+
+// ParseMemory parses a memory requirement in bytes, optionally with a unit
+// like "512Mi" or "1G", into bytes. Empty means no limit.
+func ParseMemory(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	scale := int64(1)
+	number := value
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			scale = unit.bytes
+			number = strings.TrimSuffix(value, unit.suffix)
+			break
+		}
+	}
+	memory, err := strconv.ParseFloat(number, 64)
+	if err != nil || memory <= 0 {
+		return 0, fmt.Errorf("invalid memory requirement %q", value)
+	}
+	return int64(memory * float64(scale)), nil
+}
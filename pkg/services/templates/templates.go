@@ -0,0 +1,297 @@
+// Package templates renders service templates: YAML service specs with
+// {{variable}} placeholders and a schema describing the variables.
+//
+// Placeholders may only appear inside quoted YAML values, such as
+// image: "nginx:{{version}}". Values are substituted into the parsed YAML
+// rather than its text, so they can never add keys or change the structure
+// of the spec.
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+// Variable types
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+)
+
+// maxValueLength bounds the length of a string value
+const maxValueLength = 4096
+
+// Variable describes a value a template is instantiated with
+type Variable struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type,omitempty"` // string (the default), integer or boolean
+	Description string      `json:"description,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Pattern     string      `json:"pattern,omitempty"` // regular expression string values must match
+}
+
+var (
+	placeholder  = regexp.MustCompile(`\{\{\s*([^{}\s]*)\s*\}\}`)
+	variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Check validates a template's body and variables, returning every problem
+// found: the body must be YAML using only declared variables in quoted
+// values, and the variables must have valid names, types, defaults and
+// patterns. Variables without a type are given the string type.
+func Check(body string, variables []Variable) []spec.ValidationError {
+	var problems []spec.ValidationError
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, spec.ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	declared := make(map[string]*Variable, len(variables))
+	for i := range variables {
+		variable := &variables[i]
+		field := fmt.Sprintf("variables[%d]", i)
+		if !variableName.MatchString(variable.Name) {
+			add(field+".name", "must be letters, digits and '_', not starting with a digit, got %q", variable.Name)
+			continue
+		}
+		if declared[variable.Name] != nil {
+			add(field+".name", "%s is declared twice", variable.Name)
+			continue
+		}
+		declared[variable.Name] = variable
+
+		switch variable.Type {
+		case "":
+			variable.Type = TypeString
+		case TypeString, TypeInteger, TypeBoolean:
+		default:
+			add(field+".type", "must be string, integer or boolean, got %q", variable.Type)
+			continue
+		}
+		if variable.Pattern != "" {
+			if variable.Type != TypeString {
+				add(field+".pattern", "only applies to string variables")
+			} else if _, err := regexp.Compile(variable.Pattern); err != nil {
+				add(field+".pattern", "is not a regular expression: %v", err)
+				continue
+			}
+		}
+		if variable.Default != nil {
+			if _, err := variable.value(variable.Default); err != nil {
+				add(field+".default", "%v", err)
+			}
+		}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(body), &root); err != nil {
+		add("body", "is not valid YAML: %v", err)
+		return problems
+	}
+
+	// Placeholders in the text but in no scalar are unquoted, where YAML
+	// reads {{ as the start of a mapping
+	found := map[string]bool{}
+	walkScalars(&root, func(node *yaml.Node, key bool) {
+		for _, match := range placeholder.FindAllStringSubmatch(node.Value, -1) {
+			found[match[0]] = true
+			switch {
+			case key:
+				add("body", "line %d: placeholder %s is only allowed in values", node.Line, match[0])
+			case declared[match[1]] == nil:
+				add("body", "line %d: unknown variable %s", node.Line, match[1])
+			}
+		}
+	})
+	for _, match := range placeholder.FindAllString(body, -1) {
+		if !found[match] {
+			add("body", "placeholder %s must be inside a quoted value", match)
+			found[match] = true
+		}
+	}
+	return problems
+}
+
+// Render validates values against the variables and substitutes them into
+// the body, returning the YAML of the service spec. Missing values take the
+// variable's default.
+func Render(body string, variables []Variable, values map[string]interface{}) (string, []spec.ValidationError) {
+	resolved, problems := resolve(variables, values)
+	if len(problems) > 0 {
+		return "", problems
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(body), &root); err != nil {
+		return "", []spec.ValidationError{{Field: "body", Message: fmt.Sprintf("is not valid YAML: %v", err)}}
+	}
+	walkScalars(&root, func(node *yaml.Node, key bool) {
+		if key || !placeholder.MatchString(node.Value) {
+			return
+		}
+
+		// A value that is just a typed placeholder keeps its type
+		if match := placeholder.FindStringSubmatch(node.Value); match[0] == node.Value {
+			if value, ok := resolved[match[1]]; ok && value.tag != "" {
+				node.Value, node.Tag, node.Style = value.text, value.tag, 0
+				return
+			}
+		}
+		node.Value = placeholder.ReplaceAllStringFunc(node.Value, func(match string) string {
+			return resolved[placeholder.FindStringSubmatch(match)[1]].text
+		})
+		node.Tag = "!!str"
+	})
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return "", []spec.ValidationError{{Field: "body", Message: fmt.Sprintf("could not be rendered: %v", err)}}
+	}
+	return out.String(), nil
+}
+
+// renderedValue is a variable's value as YAML text, with the tag of typed
+// values
+type renderedValue struct {
+	text string
+	tag  string
+}
+
+// resolve checks the values given for variables, filling in defaults
+func resolve(variables []Variable, values map[string]interface{}) (map[string]renderedValue, []spec.ValidationError) {
+	var problems []spec.ValidationError
+	resolved := make(map[string]renderedValue, len(variables))
+	known := make(map[string]bool, len(variables))
+
+	for _, variable := range variables {
+		known[variable.Name] = true
+		field := "values." + variable.Name
+
+		raw, given := values[variable.Name]
+		if !given || raw == nil {
+			raw = variable.Default
+		}
+		if raw == nil {
+			if variable.Required {
+				problems = append(problems, spec.ValidationError{Field: field, Message: "is required"})
+				continue
+			}
+			raw = zeroValue(variable.Type)
+		}
+
+		value, err := variable.value(raw)
+		if err != nil {
+			problems = append(problems, spec.ValidationError{Field: field, Message: err.Error()})
+			continue
+		}
+		resolved[variable.Name] = value
+	}
+
+	var unknown []string
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		problems = append(problems, spec.ValidationError{Field: "values." + name, Message: "is not a variable of the template"})
+	}
+	return resolved, problems
+}
+
+// value checks a value against the variable's type and pattern
+func (v *Variable) value(raw interface{}) (renderedValue, error) {
+	switch v.Type {
+	case TypeInteger:
+		switch n := raw.(type) {
+		case float64:
+			if n != math.Trunc(n) || math.Abs(n) > 1<<53 {
+				return renderedValue{}, fmt.Errorf("must be an integer, got %v", n)
+			}
+			return renderedValue{text: strconv.FormatInt(int64(n), 10), tag: "!!int"}, nil
+		case int:
+			return renderedValue{text: strconv.Itoa(n), tag: "!!int"}, nil
+		case string:
+			if parsed, err := strconv.ParseInt(n, 10, 64); err == nil {
+				return renderedValue{text: strconv.FormatInt(parsed, 10), tag: "!!int"}, nil
+			}
+		}
+		return renderedValue{}, fmt.Errorf("must be an integer, got %v", raw)
+
+	case TypeBoolean:
+		switch b := raw.(type) {
+		case bool:
+			return renderedValue{text: strconv.FormatBool(b), tag: "!!bool"}, nil
+		case string:
+			if parsed, err := strconv.ParseBool(b); err == nil {
+				return renderedValue{text: strconv.FormatBool(parsed), tag: "!!bool"}, nil
+			}
+		}
+		return renderedValue{}, fmt.Errorf("must be true or false, got %v", raw)
+
+	default:
+		s, ok := raw.(string)
+		if !ok {
+			return renderedValue{}, fmt.Errorf("must be a string, got %v", raw)
+		}
+		if len(s) > maxValueLength {
+			return renderedValue{}, fmt.Errorf("must be at most %d bytes", maxValueLength)
+		}
+		if strings.ContainsFunc(s, func(r rune) bool { return r < ' ' && r != '\t' }) {
+			return renderedValue{}, fmt.Errorf("must not contain control characters")
+		}
+		if v.Pattern != "" {
+			if pattern, err := regexp.Compile(v.Pattern); err == nil && !pattern.MatchString(s) {
+				return renderedValue{}, fmt.Errorf("must match %s, got %q", v.Pattern, s)
+			}
+		}
+		return renderedValue{text: s}, nil
+	}
+}
+
+// zeroValue is the value of an optional variable without a default
+func zeroValue(variableType string) interface{} {
+	switch variableType {
+	case TypeInteger:
+		return 0.0
+	case TypeBoolean:
+		return false
+	default:
+		return ""
+	}
+}
+
+// walkScalars calls fn for every scalar in a YAML tree, telling it whether
+// the scalar is a mapping key
+func walkScalars(node *yaml.Node, fn func(node *yaml.Node, key bool)) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		fn(node, false)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Kind == yaml.ScalarNode {
+				fn(node.Content[i], true)
+			} else {
+				walkScalars(node.Content[i], fn)
+			}
+			walkScalars(node.Content[i+1], fn)
+		}
+	default:
+		for _, child := range node.Content {
+			walkScalars(child, fn)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package templates
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/services/spec"
+)
+
+const webBody = `name: "{{name}}"
+image: "nginx:{{version}}"
+port: "{{port}}"
+env:
+  GREETING: "{{greeting}}"
+  DEBUG: "{{debug}}"
+`
+
+var webVariables = []Variable{
+	{Name: "name", Required: true, Pattern: `^[a-z][a-z0-9-]*$`},
+	{Name: "version", Default: "1.27"},
+	{Name: "port", Type: TypeInteger, Default: 8080.0},
+	{Name: "greeting"},
+	{Name: "debug", Type: TypeBoolean},
+}
+
+func TestRender(t *testing.T) {
+	rendered, problems := Render(webBody, webVariables, map[string]interface{}{
+		"name":     "web",
+		"port":     "9090",
+		"greeting": "hello: world",
+	})
+	require.Empty(t, problems)
+
+	parsed, problems := spec.ParseAndValidate(rendered)
+	require.Empty(t, problems, rendered)
+	assert.Equal(t, "web", parsed.Name)
+	assert.Equal(t, "nginx:1.27", parsed.Image)
+	assert.Equal(t, 9090, parsed.Port)
+	assert.Equal(t, map[string]string{"GREETING": "hello: world", "DEBUG": "false"}, parsed.Env)
+}
+
+func TestRenderKeepsValuesInTheirPlace(t *testing.T) {
+	// Values that look like YAML stay strings in the value they were given for
+	for _, greeting := range []string{
+		`", "image": "evil`,
+		"{injected: true}",
+		"&anchor yes",
+		"'; image: evil",
+	} {
+		rendered, problems := Render(webBody, webVariables, map[string]interface{}{"name": "web", "greeting": greeting})
+		require.Empty(t, problems)
+
+		parsed, problems := spec.ParseAndValidate(rendered)
+		require.Empty(t, problems, rendered)
+		assert.Equal(t, "nginx:1.27", parsed.Image)
+		assert.Equal(t, greeting, parsed.Env["GREETING"])
+	}
+
+	// Line breaks and other control characters are rejected outright
+	_, problems := Render(webBody, webVariables, map[string]interface{}{"name": "web", "greeting": "x\nimage: evil"})
+	require.Len(t, problems, 1)
+	assert.Equal(t, "values.greeting: must not contain control characters", problems[0].Error())
+}
+
+func TestRenderValidatesValues(t *testing.T) {
+	_, problems := Render(webBody, webVariables, map[string]interface{}{
+		"name":  "Web Server",
+		"port":  80.5,
+		"debug": "maybe",
+		"color": "blue",
+	})
+
+	var messages []string
+	for _, problem := range problems {
+		messages = append(messages, problem.Error())
+	}
+	assert.Equal(t, []string{
+		`values.name: must match ^[a-z][a-z0-9-]*$, got "Web Server"`,
+		"values.port: must be an integer, got 80.5",
+		"values.debug: must be true or false, got maybe",
+		"values.color: is not a variable of the template",
+	}, messages)
+
+	_, problems = Render(webBody, webVariables, nil)
+	require.Len(t, problems, 1)
+	assert.Equal(t, "values.name: is required", problems[0].Error())
+}
+
+func TestCheck(t *testing.T) {
+	variables := append([]Variable(nil), webVariables...)
+	require.Empty(t, Check(webBody, variables))
+	assert.Equal(t, TypeString, variables[0].Type, "the type defaults to string")
+
+	tests := []struct {
+		name      string
+		body      string
+		variables []Variable
+		problem   string
+	}{
+		{"unknown variable", `image: "{{image}}"`, nil, "body: line 1: unknown variable image"},
+		{"unquoted placeholder", "name: web\nport: {{port}}\n", []Variable{{Name: "port", Type: TypeInteger}}, "placeholder {{port}} must be inside a quoted value"},
+		{"placeholder in a key", `"{{key}}": value`, []Variable{{Name: "key"}}, "placeholder {{key}} is only allowed in values"},
+		{"invalid YAML", "name: [web", nil, "body: is not valid YAML"},
+		{"bad name", `name: web`, []Variable{{Name: "service-name"}}, `variables[0].name: must be letters`},
+		{"duplicate", `name: web`, []Variable{{Name: "a"}, {Name: "a"}}, "variables[1].name: a is declared twice"},
+		{"bad type", `name: web`, []Variable{{Name: "a", Type: "float"}}, `variables[0].type: must be string, integer or boolean, got "float"`},
+		{"bad default", `name: web`, []Variable{{Name: "a", Type: TypeInteger, Default: "many"}}, "variables[0].default: must be an integer"},
+		{"bad pattern", `name: web`, []Variable{{Name: "a", Pattern: "("}}, "variables[0].pattern: is not a regular expression"},
+		{"default not matching the pattern", `name: web`, []Variable{{Name: "a", Pattern: "^[0-9]+$", Default: "x"}}, "variables[0].default: must match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := Check(tt.body, tt.variables)
+			require.Len(t, problems, 1, problems)
+			assert.Contains(t, problems[0].Error(), tt.problem)
+		})
+	}
+}
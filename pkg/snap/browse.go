@@ -0,0 +1,406 @@
+package snap
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The browse index of a manifest is kept next to it: the manifest's entries
+// as JSON lines, sorted by directory and name, and a table of the byte range
+// of each directory's entries. Listing a directory reads only its range
+// instead of the whole manifest.
+const (
+	indexFilesSuffix = ".files"
+	indexDirsSuffix  = ".dirs"
+)
+
+var (
+	errPathNotFound = errors.New("path not found in snapshot")
+	errNotDirectory = errors.New("path is not a directory")
+)
+
+// BrowseEntry is a file or directory in a snapshot
+type BrowseEntry struct {
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	Mode        uint32    `json:"mode"`
+	Permissions string    `json:"permissions"` // such as drwxr-xr-x
+	ModTime     time.Time `json:"mod_time"`
+	IsDir       bool      `json:"is_dir"`
+	Target      string    `json:"target,omitempty"` // symlink target
+}
+
+// newBrowseEntry describes a manifest entry
+func newBrowseEntry(entry FileEntry) BrowseEntry {
+	name := filepath.Base(entry.Path)
+	return BrowseEntry{
+		Path:        entry.Path,
+		Name:        name,
+		Size:        entry.Size,
+		Mode:        entry.Mode,
+		Permissions: os.FileMode(entry.Mode).String(),
+		ModTime:     entry.ModTime,
+		IsDir:       entry.IsDir,
+		Target:      entry.Target,
+	}
+}
+
+// indexRange is where the entries of a directory are in the index
+type indexRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+	Count  int   `json:"count"`
+}
+
+// manifestIndex is the browse index of a snapshot. The entries of the
+// snapshot paths are listed under the directory "".
+type manifestIndex struct {
+	filesPath string
+	Roots     []string              `json:"roots"`
+	Dirs      map[string]indexRange `json:"dirs"`
+}
+
+// indexPaths returns the paths of the browse index of a manifest
+func indexPaths(manifestPath string) (string, string) {
+	base := strings.TrimSuffix(manifestPath, ".json")
+	return base + indexFilesSuffix, base + indexDirsSuffix
+}
+
+// parentDir returns the directory a path is listed under in an index
+func (idx *manifestIndex) parentDir(path string) string {
+	for _, root := range idx.Roots {
+		if path == root {
+			return ""
+		}
+	}
+	return filepath.Dir(path)
+}
+
+// snapshotManifestPath returns the manifest path of a completed snapshot
+func (sm *SnapManager) snapshotManifestPath(snapshotID string) (string, error) {
+	var manifestPath, status string
+	err := sm.db.QueryRow("SELECT manifest_path, status FROM snapshots WHERE id = ?", snapshotID).Scan(&manifestPath, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errSnapshotNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query snapshot: %w", err)
+	}
+	if status != StatusCompleted {
+		return "", fmt.Errorf("%w: it is %s", errSnapshotNotReady, status)
+	}
+	return manifestPath, nil
+}
+
+// openIndex returns the browse index of a completed snapshot, building it
+// on first use
+func (sm *SnapManager) openIndex(snapshotID string) (*manifestIndex, error) {
+	manifestPath, err := sm.snapshotManifestPath(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	filesPath, dirsPath := indexPaths(manifestPath)
+	if idx, err := readIndex(filesPath, dirsPath); err == nil {
+		return idx, nil
+	}
+
+	sm.indexMutex.Lock()
+	defer sm.indexMutex.Unlock()
+	if idx, err := readIndex(filesPath, dirsPath); err == nil {
+		return idx, nil
+	}
+	return buildIndex(manifestPath, filesPath, dirsPath)
+}
+
+// readIndex reads the directory table of a browse index
+func readIndex(filesPath, dirsPath string) (*manifestIndex, error) {
+	data, err := os.ReadFile(dirsPath)
+	if err != nil {
+		return nil, err
+	}
+	idx := &manifestIndex{filesPath: filesPath}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("failed to parse index: %w", err)
+	}
+	return idx, nil
+}
+
+// buildIndex writes the browse index of a manifest. The directory table is
+// written last, so an index is only used once complete.
+func buildIndex(manifestPath, filesPath, dirsPath string) (*manifestIndex, error) {
+	manifest, err := readManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &manifestIndex{filesPath: filesPath, Roots: manifest.Paths, Dirs: make(map[string]indexRange)}
+	entries := manifest.Files
+	parents := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		parents[entry.Path] = idx.parentDir(entry.Path)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if pi, pj := parents[entries[i].Path], parents[entries[j].Path]; pi != pj {
+			return pi < pj
+		}
+		return entries[i].Path < entries[j].Path
+	})
+
+	var buffer bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode index entry: %w", err)
+		}
+		parent := parents[entry.Path]
+		dir, exists := idx.Dirs[parent]
+		if !exists {
+			dir.Offset = int64(buffer.Len())
+		}
+		buffer.Write(line)
+		buffer.WriteByte('\n')
+		dir.Length = int64(buffer.Len()) - dir.Offset
+		dir.Count++
+		idx.Dirs[parent] = dir
+	}
+
+	dirs, err := json.Marshal(idx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode index: %w", err)
+	}
+	if err := writeFileAtomic(filesPath, buffer.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(dirsPath, dirs); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// writeFileAtomic writes a file through a temporary file renamed into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+// removeIndex removes the browse index of a manifest, if any
+func removeIndex(manifestPath string) {
+	filesPath, dirsPath := indexPaths(manifestPath)
+	os.Remove(dirsPath)
+	os.Remove(filesPath)
+}
+
+// children calls visit with the entries of a directory in order until it
+// returns false
+func (idx *manifestIndex) children(dir string, visit func(FileEntry) (bool, error)) error {
+	r, ok := idx.Dirs[dir]
+	if !ok {
+		return nil
+	}
+
+	file, err := os.Open(idx.filesPath)
+	if err != nil {
+		return fmt.Errorf("failed to open index: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(io.NewSectionReader(file, r.Offset, r.Length))
+	scanner.Buffer(make([]byte, 64*1024), maxIndexLine)
+	for scanner.Scan() {
+		var entry FileEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("failed to parse index: %w", err)
+		}
+		more, err := visit(entry)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// maxIndexLine bounds an index entry, which holds the hashes of a file's
+// blocks
+const maxIndexLine = 64 * 1024 * 1024
+
+// lookup returns the entry of a path
+func (idx *manifestIndex) lookup(path string) (FileEntry, error) {
+	var found *FileEntry
+	err := idx.children(idx.parentDir(path), func(entry FileEntry) (bool, error) {
+		if entry.Path == path {
+			found = &entry
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return FileEntry{}, err
+	}
+	if found == nil {
+		return FileEntry{}, errPathNotFound
+	}
+	return *found, nil
+}
+
+// subtreeCount counts the entries under a directory at any depth
+func (idx *manifestIndex) subtreeCount(dir string) int {
+	count := 0
+	for path, r := range idx.Dirs {
+		if dir == "" || path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			count += r.Count
+		}
+	}
+	return count
+}
+
+// list returns the page of the entries of a directory, the snapshot paths
+// when it is empty, from offset up to limit of them, and their total. With
+// recursive set every entry under it is listed, each directory followed by
+// its contents.
+func (idx *manifestIndex) list(dir string, recursive bool, offset, limit int) ([]BrowseEntry, int, error) {
+	if dir != "" {
+		entry, err := idx.lookup(dir)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !entry.IsDir {
+			return nil, 0, errNotDirectory
+		}
+	}
+
+	total := idx.Dirs[dir].Count
+	if recursive {
+		total = idx.subtreeCount(dir)
+	}
+
+	page := []BrowseEntry{}
+	skipped := 0
+	var walk func(dir string) (bool, error)
+	walk = func(dir string) (bool, error) {
+		more := true
+		err := idx.children(dir, func(entry FileEntry) (bool, error) {
+			if skipped < offset {
+				skipped++
+			} else {
+				page = append(page, newBrowseEntry(entry))
+				if len(page) == limit {
+					more = false
+					return false, nil
+				}
+			}
+			if recursive && entry.IsDir {
+				var err error
+				more, err = walk(entry.Path)
+				return more, err
+			}
+			return true, nil
+		})
+		return more, err
+	}
+	if _, err := walk(dir); err != nil {
+		return nil, 0, err
+	}
+	return page, total, nil
+}
+
+// subtree returns the entry of a path and, for a directory, every entry
+// under it
+func (idx *manifestIndex) subtree(path string) ([]FileEntry, error) {
+	entry, err := idx.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []FileEntry{entry}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		return idx.children(dir, func(entry FileEntry) (bool, error) {
+			entries = append(entries, entry)
+			if entry.IsDir {
+				return true, walk(entry.Path)
+			}
+			return true, nil
+		})
+	}
+	if entry.IsDir {
+		if err := walk(path); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// FileRestore is the result of restoring a file or directory of a snapshot
+type FileRestore struct {
+	SnapshotID string   `json:"snapshot_id"`
+	FilePath   string   `json:"file_path"`
+	TargetPath string   `json:"target_path"`
+	Files      int      `json:"files"`
+	Bytes      int64    `json:"bytes"`
+	Errors     []string `json:"errors,omitempty"` // files that failed to restore
+}
+
+// restorePathFromSnapshot restores a file or directory of a snapshot to a
+// target path, replacing what is there once every file was restored and
+// verified against its checksum
+func (sm *SnapManager) restorePathFromSnapshot(snapshotID, filePath, targetPath string) (*FileRestore, error) {
+	idx, err := sm.openIndex(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := idx.subtree(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &SnapshotManifest{ID: snapshotID, Paths: []string{filePath}, Files: entries}
+	job := newRestoreJob(snapshotID, targetPath, RestoreModeFull, manifest)
+	task := sm.registerTask(job.ID, "restore")
+	defer sm.unregisterTask(task)
+
+	reporter := newProgressReporter(task)
+	reporter.state.TotalFiles = job.TotalFiles
+	reporter.state.TotalBytes = job.TotalBytes
+	staging := restoreStagingPath(job.TargetPath, job.ID)
+	fileErrors, err := sm.restoreFiles(task.ctx, manifest, staging, reporter)
+	if err == nil && len(fileErrors) > 0 {
+		err = fmt.Errorf("failed to restore %d of %d files", len(fileErrors), len(entries))
+	}
+	if err == nil {
+		err = moveRestore(staging, job)
+	}
+	result := &FileRestore{
+		SnapshotID: snapshotID,
+		FilePath:   filePath,
+		TargetPath: job.TargetPath,
+		Files:      reporter.state.FilesProcessed,
+		Bytes:      reporter.state.BytesWritten,
+		Errors:     fileErrors,
+	}
+	if err != nil {
+		if removeErr := os.RemoveAll(staging); removeErr != nil {
+			err = fmt.Errorf("%w (and failed to remove %s: %v)", err, staging, removeErr)
+		}
+		return result, err
+	}
+	return result, nil
+}
@@ -0,0 +1,172 @@
+package snap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startBrowseTest snapshots a tree and returns the snap manager, a router
+// serving its browse API, the tree and the snapshot ID
+func startBrowseTest(t *testing.T) (*SnapManager, *gin.Engine, string, string) {
+	manager, router := startRestoreTest(t)
+	router.GET("/snapshots/:id/files", manager.ListSnapshotFiles)
+	router.POST("/snapshots/:id/restore-file", manager.RestoreSnapshotFile)
+
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+	return manager, router, source, takeSnapshot(t, manager, source)
+}
+
+// listFiles lists a directory of a snapshot and returns the paths of the
+// files and their total
+func listFiles(t *testing.T, router *gin.Engine, snapshotID string, query url.Values) ([]string, float64) {
+	code, response := serve(t, router, http.MethodGet, "/snapshots/"+snapshotID+"/files?"+query.Encode(), "")
+	require.Equal(t, http.StatusOK, code, response)
+
+	var paths []string
+	for _, file := range response["files"].([]interface{}) {
+		paths = append(paths, file.(map[string]interface{})["path"].(string))
+	}
+	return paths, response["total"].(float64)
+}
+
+func TestListSnapshotFiles(t *testing.T) {
+	manager, router, source, snapshotID := startBrowseTest(t)
+	join := func(names ...string) string {
+		return filepath.Join(append([]string{source}, names...)...)
+	}
+
+	// The snapshot paths
+	paths, total := listFiles(t, router, snapshotID, url.Values{})
+	assert.Equal(t, []string{source}, paths)
+	assert.Equal(t, float64(1), total)
+
+	paths, total = listFiles(t, router, snapshotID, url.Values{"path": {source}})
+	assert.Equal(t, []string{join("a.txt"), join("big.bin"), join("bin"), join("empty"), join("link"), join("nested")}, paths)
+	assert.Equal(t, float64(6), total)
+
+	paths, _ = listFiles(t, router, snapshotID, url.Values{"path": {join("nested", "deep")}})
+	assert.Equal(t, []string{join("nested", "deep", "file")}, paths)
+
+	// Each directory is followed by its contents
+	paths, total = listFiles(t, router, snapshotID, url.Values{"path": {join("nested")}, "recursive": {"true"}})
+	assert.Equal(t, []string{join("nested", "deep"), join("nested", "deep", "file")}, paths)
+	assert.Equal(t, float64(2), total)
+
+	paths, total = listFiles(t, router, snapshotID, url.Values{"path": {source}, "recursive": {"true"}, "offset": {"2"}, "limit": {"3"}})
+	assert.Equal(t, []string{join("bin"), join("bin", "run.sh"), join("empty")}, paths)
+	assert.Equal(t, float64(9), total)
+
+	// The entries describe the files
+	code, response := serve(t, router, http.MethodGet, "/snapshots/"+snapshotID+"/files?path="+url.QueryEscape(join("bin")), "")
+	require.Equal(t, http.StatusOK, code)
+	file := response["files"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "run.sh", file["name"])
+	assert.Equal(t, float64(19), file["size"])
+	assert.Equal(t, "-rwxr-xr-x", file["permissions"])
+	assert.Equal(t, "2020-01-02T03:04:05.000006Z", file["mod_time"])
+	assert.Equal(t, false, file["is_dir"])
+
+	// The index is built once, next to the manifest
+	manifestPath, err := manager.snapshotManifestPath(snapshotID)
+	require.NoError(t, err)
+	filesPath, dirsPath := indexPaths(manifestPath)
+	assert.FileExists(t, filesPath)
+	assert.FileExists(t, dirsPath)
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{join("missing"), http.StatusNotFound},
+		{join("a.txt"), http.StatusBadRequest},
+	} {
+		code, response := serve(t, router, http.MethodGet, "/snapshots/"+snapshotID+"/files?path="+url.QueryEscape(tc.path), "")
+		assert.Equal(t, tc.code, code, "%s: %v", tc.path, response)
+	}
+	code, _ = serve(t, router, http.MethodGet, "/snapshots/snap_missing/files", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Deleting the snapshot removes its index
+	_, _, err = manager.deleteSnapshot(snapshotID, manifestPath)
+	require.NoError(t, err)
+	assert.NoFileExists(t, filesPath)
+	assert.NoFileExists(t, dirsPath)
+}
+
+func TestRestoreSnapshotFile(t *testing.T) {
+	_, router, source, snapshotID := startBrowseTest(t)
+	original := treeState(t, source)
+	mutateTree(t, source)
+
+	// A file is restored in place, leaving the others as they are
+	code, response := serve(t, router, http.MethodPost, "/snapshots/"+snapshotID+"/restore-file", fmt.Sprintf(`{"file_path": %q}`, filepath.Join(source, "a.txt")))
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, float64(1), response["files"])
+	assert.Equal(t, float64(5), response["bytes"])
+	data, err := os.ReadFile(filepath.Join(source, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	assert.FileExists(t, filepath.Join(source, "new.txt"))
+
+	// A file of several blocks is restored elsewhere with the same bytes
+	target := filepath.Join(t.TempDir(), "big.bin")
+	code, response = serve(t, router, http.MethodPost, "/snapshots/"+snapshotID+"/restore-file", fmt.Sprintf(`{"file_path": %q, "target_path": %q}`, filepath.Join(source, "big.bin"), target))
+	require.Equal(t, http.StatusOK, code, response)
+	restored, err := os.ReadFile(target)
+	require.NoError(t, err)
+	expected, err := os.ReadFile(filepath.Join(source, "big.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, expected, restored)
+
+	// A directory is restored with its contents
+	code, response = serve(t, router, http.MethodPost, "/snapshots/"+snapshotID+"/restore-file", fmt.Sprintf(`{"file_path": %q}`, filepath.Join(source, "nested")))
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, float64(3), response["files"])
+	restoredState := treeState(t, source)
+	for _, path := range []string{"nested", "nested/deep", "nested/deep/file", "a.txt"} {
+		assert.Equal(t, original[path], restoredState[path], path)
+	}
+
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"file_path": "relative", "target_path": "relative"}`, http.StatusBadRequest},
+		{fmt.Sprintf(`{"file_path": %q}`, filepath.Join(source, "missing")), http.StatusNotFound},
+	} {
+		code, response := serve(t, router, http.MethodPost, "/snapshots/"+snapshotID+"/restore-file", tc.body)
+		assert.Equal(t, tc.code, code, "%s: %v", tc.body, response)
+	}
+}
+
+func TestRestoreSnapshotFileCorruptBlock(t *testing.T) {
+	manager, router, source, snapshotID := startBrowseTest(t)
+	mutateTree(t, source)
+
+	sum := sha256.Sum256([]byte("hello"))
+	hash := hex.EncodeToString(sum[:])
+	require.NoError(t, os.WriteFile(manager.blockStore.blockIndex[hash], []byte("corrupt"), 0644))
+
+	// The failed restore leaves the target as it was
+	code, response := serve(t, router, http.MethodPost, "/snapshots/"+snapshotID+"/restore-file", fmt.Sprintf(`{"file_path": %q}`, filepath.Join(source, "a.txt")))
+	assert.Equal(t, http.StatusInternalServerError, code)
+	require.Len(t, response["errors"], 1)
+	assert.Contains(t, response["errors"].([]interface{})[0], "hash mismatch")
+
+	data, err := os.ReadFile(filepath.Join(source, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "changed", string(data))
+	assert.Len(t, entries(t, filepath.Dir(source)), 1)
+}
@@ -0,0 +1,93 @@
+package snap
+
+import (
+	"math/bits"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// gear maps each byte to a random value for the rolling hash of
+// content-defined chunking. It is generated from a fixed seed, as changing it
+// would move every block boundary and stop new snapshots from deduplicating
+// against existing ones.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6a09e667f3bcc908)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// splitter finds the boundaries of the blocks of a file with a gear rolling
+// hash, as FastCDC does: a boundary is where the hash of the last 64 bytes
+// has its masked bits clear. Past the minimum size, a stricter mask is used
+// until the average size and a looser one after it, which keeps most blocks
+// close to the average. A nil splitter cuts fixed blocks of BlockSize.
+type splitter struct {
+	minSize, avgSize, maxSize int
+	strictMask, looseMask     uint64
+}
+
+// newSplitter returns the splitter configured for snapshots, nil for fixed
+// blocks
+func newSplitter(chunking config.SnapChunkingConfig) *splitter {
+	if chunking.Mode != "cdc" {
+		return nil
+	}
+	minSize, avgSize, maxSize := chunking.Sizes()
+	maxSize = min(maxSize, BlockSize)
+	avgBits := bits.Len(uint(avgSize)) - 1
+	return &splitter{
+		minSize:    minSize,
+		avgSize:    avgSize,
+		maxSize:    maxSize,
+		strictMask: highBits(avgBits + 1),
+		looseMask:  highBits(avgBits - 1),
+	}
+}
+
+// highBits returns a mask of the n highest bits, which depend on the most
+// bytes of the rolling hash
+func highBits(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// blockLimit returns the size of the largest block
+func (s *splitter) blockLimit() int {
+	if s == nil {
+		return BlockSize
+	}
+	return s.maxSize
+}
+
+// cut returns the length of the block data starts with. data holds
+// blockLimit bytes unless the file ends sooner.
+func (s *splitter) cut(data []byte) int {
+	if s == nil || len(data) <= s.minSize {
+		return len(data)
+	}
+
+	end := min(len(data), s.maxSize)
+	normal := min(end, s.avgSize)
+	var hash uint64
+	i := s.minSize
+	for ; i < normal; i++ {
+		hash = hash<<1 + gear[data[i]]
+		if hash&s.strictMask == 0 {
+			return i + 1
+		}
+	}
+	for ; i < end; i++ {
+		hash = hash<<1 + gear[data[i]]
+		if hash&s.looseMask == 0 {
+			return i + 1
+		}
+	}
+	return end
+}
@@ -0,0 +1,93 @@
+package snap
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testChunking cuts small content-defined blocks, so that a test file of a
+// megabyte spans many of them
+var testChunking = config.SnapChunkingConfig{Mode: "cdc", MinSize: 2 << 10, AvgSize: 8 << 10, MaxSize: 32 << 10}
+
+// randomData returns size bytes of random content
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+// splitData chunks data as a file and returns the hashes and sizes of its
+// blocks
+func splitData(t *testing.T, split *splitter, data []byte) ([]string, []int) {
+	path := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	var sizes []int
+	hashes, _, err := chunkFile(context.Background(), path, split, nil, func(hash string, block []byte, buffer *[]byte) bool {
+		sizes = append(sizes, len(block))
+		blockBuffers.Put(buffer)
+		return true
+	})
+	require.NoError(t, err)
+	return hashes, sizes
+}
+
+func TestSplitterBlockSizes(t *testing.T) {
+	split := newSplitter(testChunking)
+	data := randomData(1 << 20)
+
+	_, sizes := splitData(t, split, data)
+	total := 0
+	for i, size := range sizes {
+		total += size
+		if i < len(sizes)-1 {
+			assert.GreaterOrEqual(t, size, testChunking.MinSize)
+		}
+		assert.LessOrEqual(t, size, testChunking.MaxSize)
+	}
+	assert.Equal(t, len(data), total)
+
+	average := len(data) / len(sizes)
+	assert.Greater(t, average, testChunking.AvgSize/2)
+	assert.Less(t, average, testChunking.AvgSize*2)
+
+	// The same content is cut the same way
+	_, again := splitData(t, split, data)
+	assert.Equal(t, sizes, again)
+
+	// Fixed blocks are used unless configured
+	assert.Nil(t, newSplitter(config.SnapChunkingConfig{}))
+	_, fixed := splitData(t, nil, randomData(BlockSize+10))
+	assert.Equal(t, []int{BlockSize, 10}, fixed)
+}
+
+func TestSplitterShiftedContent(t *testing.T) {
+	split := newSplitter(testChunking)
+	data := randomData(1 << 20)
+	original, _ := splitData(t, split, data)
+
+	middle := len(data) / 2
+	for name, changed := range map[string][]byte{
+		"prefix": append([]byte("abc"), data...),
+		"middle": slices.Concat(data[:middle], []byte("12345"), data[middle:]),
+		"suffix": append(slices.Clip(data), "xyz"...),
+	} {
+		hashes, _ := splitData(t, split, changed)
+		shared := 0
+		for _, hash := range hashes {
+			if slices.Contains(original, hash) {
+				shared++
+			}
+		}
+		// Only the blocks around the change differ
+		assert.GreaterOrEqual(t, shared, len(hashes)-2, name)
+	}
+}
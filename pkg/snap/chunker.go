@@ -0,0 +1,348 @@
+package snap
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// defaultSnapshotWorkers is how many files a snapshot reads at once unless
+// configured
+const defaultSnapshotWorkers = 4
+
+// rateWindow is how often the read rate of a snapshot is measured
+const rateWindow = time.Second
+
+// blockBuffers recycles the buffers blocks are read into, which the workers
+// of a snapshot hand to its writer
+var blockBuffers = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, BlockSize)
+		return &buffer
+	},
+}
+
+// throttle paces the reads of a snapshot to a rate in bytes per second,
+// shared by its workers. A nil throttle does not wait.
+type throttle struct {
+	rate  float64
+	mutex sync.Mutex
+	next  time.Time // when the next read may start
+}
+
+// newThrottle creates a throttle to rate bytes per second, nil when rate is
+// not positive
+func newThrottle(rate int64) *throttle {
+	if rate <= 0 {
+		return nil
+	}
+	return &throttle{rate: float64(rate)}
+}
+
+// wait accounts for n bytes read and waits until reading may go on, or ctx
+// is done
+func (t *throttle) wait(ctx context.Context, n int) error {
+	if t == nil {
+		return ctx.Err()
+	}
+
+	t.mutex.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(float64(n) / t.rate * float64(time.Second)))
+	delay := t.next.Sub(now)
+	t.mutex.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// chunkFile reads a file in blocks cut by split, calling emit with the hash
+// and data of each block in order, and returns the hashes and the file's
+// checksum. The data is copied into a buffer of blockBuffers that emit takes
+// over; it returns false to stop reading.
+func chunkFile(ctx context.Context, filePath string, split *splitter, limit *throttle, emit func(hash string, data []byte, buffer *[]byte) bool) ([]string, string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	// The window holds the bytes read past the last block
+	windowBuffer := blockBuffers.Get().(*[]byte)
+	defer blockBuffers.Put(windowBuffer)
+	window := (*windowBuffer)[:split.blockLimit()]
+
+	var blocks []string
+	hasher := sha256.New()
+	filled, eof := 0, false
+	for {
+		if !eof {
+			n, err := io.ReadFull(file, window[filled:])
+			switch {
+			case err == io.EOF || err == io.ErrUnexpectedEOF:
+				eof = true
+			case err != nil:
+				return nil, "", err
+			}
+			filled += n
+			if err := limit.wait(ctx, n); err != nil {
+				return nil, "", err
+			}
+		}
+		if filled == 0 {
+			break
+		}
+
+		buffer := blockBuffers.Get().(*[]byte)
+		block := append((*buffer)[:0], window[:split.cut(window[:filled])]...)
+		hasher.Write(block)
+		sum := sha256.Sum256(block)
+		blockHash := hex.EncodeToString(sum[:])
+		blocks = append(blocks, blockHash)
+		if !emit(blockHash, block, buffer) {
+			return nil, "", ctx.Err()
+		}
+		filled = copy(window, window[len(block):filled])
+	}
+
+	return blocks, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// chunkMessage is what a snapshot worker hands to the writer: a block of a
+// file, or once the file is read, its manifest entry
+type chunkMessage struct {
+	index  int // of the file in the scan
+	hash   string
+	data   []byte
+	stored []byte // data encoded for the block store, nil if it has the block
+	buffer *[]byte
+
+	done    bool // the file is read, entry is set unless skipped
+	entry   FileEntry
+	reused  bool // the file's blocks are the baseline's
+	skipped bool // the file could not be read
+}
+
+// snapshotFiles reads files with a pool of workers and has a single writer,
+// the calling goroutine, store their blocks and collect their entries, in
+// the order of files regardless of the order they are read in. Files unchanged
+// since the baseline reuse its blocks; files that cannot be read are
+// skipped. It stops if ctx is done or a block does not fit in the repository.
+func (sm *SnapManager) snapshotFiles(ctx context.Context, files []string, previous map[string]FileEntry, manifest *SnapshotManifest, hold *blockHold, limit *throttle, workers int, reporter *progressReporter) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	split := newSplitter(sm.config.Chunking)
+	jobs := make(chan int)
+	messages := make(chan chunkMessage, workers)
+	send := func(message chunkMessage) bool {
+		select {
+		case messages <- message:
+			return true
+		case <-ctx.Done():
+			if message.buffer != nil {
+				blockBuffers.Put(message.buffer)
+			}
+			return false
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				message := sm.readFile(ctx, index, files[index], previous, hold, split, limit, send)
+				if !send(message) {
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for index := range files {
+			select {
+			case jobs <- index:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(messages)
+	}()
+
+	entries := make([]*FileEntry, len(files))
+	failed := make(map[int]bool)
+	rateStart, rateBytes := time.Now(), int64(0)
+	var fatal error
+	for message := range messages {
+		if fatal != nil {
+			// Drained until the workers stop
+			if message.buffer != nil {
+				blockBuffers.Put(message.buffer)
+			}
+			continue
+		}
+
+		if !message.done {
+			stored, err := sm.blockStore.putEncoded(message.hash, message.data, message.stored, hold)
+			blockBuffers.Put(message.buffer)
+			switch {
+			case errors.Is(err, errInsufficientSpace):
+				fatal = err
+				cancel()
+				continue
+			case err != nil:
+				failed[message.index] = true // skipped like files that cannot be read
+			case stored:
+				reporter.state.BytesWritten += int64(len(message.data))
+			}
+			reporter.state.BytesRead += int64(len(message.data))
+			reporter.state.CurrentPath = files[message.index]
+			if elapsed := time.Since(rateStart); elapsed >= rateWindow {
+				reporter.state.ReadRate = float64(reporter.state.BytesRead-rateBytes) / elapsed.Seconds()
+				rateStart, rateBytes = time.Now(), reporter.state.BytesRead
+			}
+			reporter.report(false)
+			continue
+		}
+
+		if message.skipped || failed[message.index] {
+			continue
+		}
+		entry := message.entry
+		for _, blockHash := range entry.Blocks {
+			if blockPath, exists := sm.blockStore.blockPath(blockHash); exists {
+				manifest.Blocks[blockHash] = blockPath
+			}
+		}
+		if message.reused {
+			reporter.state.FilesReused++
+		}
+		entries[message.index] = &entry
+
+		reporter.state.FilesProcessed++
+		reporter.state.Progress = float64(reporter.state.FilesProcessed) / float64(len(files)) * 100.0
+		reporter.state.Message = fmt.Sprintf("Processed %d/%d files", reporter.state.FilesProcessed, len(files))
+		reporter.report(false)
+	}
+	if fatal != nil {
+		return fatal
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry == nil {
+			continue
+		}
+		manifest.Files = append(manifest.Files, *entry)
+		manifest.Size += entry.Size
+		manifest.FileCount++
+	}
+	return nil
+}
+
+// readFile describes a file for the manifest, handing its blocks to the
+// writer with send, and returns the message ending the file. Blocks new to
+// the store are encoded, and so compressed, by the workers.
+func (sm *SnapManager) readFile(ctx context.Context, index int, filePath string, previous map[string]FileEntry, hold *blockHold, split *splitter, limit *throttle, send func(chunkMessage) bool) chunkMessage {
+	done := chunkMessage{index: index, done: true}
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		done.skipped = true // Skip files that can't be stat'd
+		return done
+	}
+
+	done.entry = FileEntry{
+		Path:    filePath,
+		Size:    info.Size(),
+		Mode:    uint32(info.Mode()),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		// Handle symlink
+		target, err := os.Readlink(filePath)
+		if err == nil {
+			done.entry.Target = target
+		}
+	} else if entry, ok := previous[filePath]; ok && unchanged(entry, info) && sm.blockStore.holdBlocks(entry.Blocks, hold) {
+		// Unchanged since the baseline, reuse its blocks
+		done.entry.Blocks = entry.Blocks
+		done.entry.Checksum = entry.Checksum
+		done.reused = true
+	} else if !info.IsDir() {
+		blocks, checksum, err := chunkFile(ctx, filePath, split, limit, func(hash string, data []byte, buffer *[]byte) bool {
+			message := chunkMessage{index: index, hash: hash, data: data, buffer: buffer}
+			if _, exists := sm.blockStore.blockPath(hash); !exists {
+				message.stored = sm.blockStore.encode(data)
+			}
+			return send(message)
+		})
+		if err != nil {
+			done.skipped = true // Skip files that can't be processed
+			return done
+		}
+		done.entry.Blocks = blocks
+		done.entry.Checksum = checksum
+	}
+	return done
+}
+
+// snapshotWorkers returns how many files a snapshot reads at once
+func (sm *SnapManager) snapshotWorkers() int {
+	if sm.config.SnapshotWorkers > 0 {
+		return sm.config.SnapshotWorkers
+	}
+	return defaultSnapshotWorkers
+}
+
+// planThrottle returns the throttle of a snapshot of a plan, nil unless a
+// rate limit applies to the plan
+func (sm *SnapManager) planThrottle(planID string) (*throttle, error) {
+	if sm.config.RateLimit == "" {
+		return nil, nil
+	}
+	rate, err := config.ParseByteRate(sm.config.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+	if len(sm.config.ThrottledPlans) == 0 {
+		return newThrottle(rate), nil
+	}
+
+	var name string
+	if err := sm.db.QueryRow("SELECT name FROM snap_plans WHERE id = ?", planID).Scan(&name); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to query plan: %w", err)
+	}
+	if slices.Contains(sm.config.ThrottledPlans, name) {
+		return newThrottle(rate), nil
+	}
+	return nil, nil
+}
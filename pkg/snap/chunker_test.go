@@ -0,0 +1,176 @@
+package snap
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeLargeTree creates files of random content and size, some over a
+// block, in nested directories
+func writeLargeTree(t testing.TB, root string, files int) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < files; i++ {
+		path := filepath.Join(root, fmt.Sprintf("dir%d", i%5), fmt.Sprintf("sub%d", i%3), fmt.Sprintf("file%d", i))
+		data := make([]byte, random.Intn(BlockSize/4))
+		if i%7 == 0 {
+			data = make([]byte, BlockSize+random.Intn(BlockSize))
+		}
+		random.Read(data)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, data, 0644))
+	}
+}
+
+// newWorkersManager creates a snap manager reading files with workers
+func newWorkersManager(t testing.TB, workers int) *SnapManager {
+	manager := newTestManager(t)
+	manager.config.SnapshotWorkers = workers
+	_, err := manager.db.Exec(`INSERT INTO snap_plans (id, name, cron_expression, paths) VALUES ('plan_test', 'test', '@daily', '[]')`)
+	require.NoError(t, err)
+	return manager
+}
+
+func TestSnapshotWorkersIdenticalManifests(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+	writeLargeTree(t, filepath.Join(source, "large"), 40)
+
+	var manifests []*SnapshotManifest
+	for _, workers := range []int{1, 3, 8} {
+		manager := newWorkersManager(t, workers)
+		snapshotID, progress := snapshotProgress(t, manager, false, source)
+		manifest, err := manager.loadManifest(snapshotID)
+		require.NoError(t, err)
+		assert.Equal(t, manifest.FileCount, progress.FilesProcessed, "workers=%d", workers)
+		manifests = append(manifests, manifest)
+	}
+
+	blocks := func(manifest *SnapshotManifest) []string {
+		var hashes []string
+		for hash := range manifest.Blocks {
+			hashes = append(hashes, hash)
+		}
+		sort.Strings(hashes)
+		return hashes
+	}
+	for _, manifest := range manifests[1:] {
+		assert.Equal(t, manifests[0].Files, manifest.Files)
+		assert.Equal(t, manifests[0].Size, manifest.Size)
+		assert.Equal(t, manifests[0].FileCount, manifest.FileCount)
+		assert.Equal(t, blocks(manifests[0]), blocks(manifest))
+	}
+	assert.Equal(t, 71, manifests[0].FileCount) // with the directories
+}
+
+func TestSnapshotProgressUnderConcurrency(t *testing.T) {
+	manager := newWorkersManager(t, 4)
+	source := t.TempDir()
+	writeLargeTree(t, source, 30)
+
+	var size int64
+	require.NoError(t, filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return err
+	}))
+
+	_, progress := snapshotProgress(t, manager, false, source)
+	assert.Equal(t, size, progress.BytesRead)
+	assert.Equal(t, size, progress.BytesWritten)
+	assert.Equal(t, progress.TotalFiles, progress.FilesProcessed)
+	assert.Equal(t, float64(100), progress.Progress)
+}
+
+func TestThrottle(t *testing.T) {
+	limit := newThrottle(1000)
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, limit.wait(context.Background(), 100))
+	}
+	assert.GreaterOrEqual(t, time.Since(started), 290*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, limit.wait(ctx, 1000), context.Canceled)
+	assert.NoError(t, (*throttle)(nil).wait(context.Background(), 1000))
+}
+
+func TestPlanThrottle(t *testing.T) {
+	manager := newWorkersManager(t, 1)
+	limit, err := manager.planThrottle("plan_test")
+	require.NoError(t, err)
+	assert.Nil(t, limit, "without a rate limit")
+
+	manager.config.RateLimit = "2MB/s"
+	limit, err = manager.planThrottle("plan_test")
+	require.NoError(t, err)
+	require.NotNil(t, limit, "a rate limit applies to every plan")
+	assert.Equal(t, float64(2<<20), limit.rate)
+
+	manager.config.ThrottledPlans = []string{"archive"}
+	limit, err = manager.planThrottle("plan_test")
+	require.NoError(t, err)
+	assert.Nil(t, limit, "the plan is not throttled")
+	manager.config.ThrottledPlans = []string{"archive", "test"}
+	limit, err = manager.planThrottle("plan_test")
+	require.NoError(t, err)
+	assert.NotNil(t, limit)
+}
+
+func TestSnapshotCancelledWhileThrottled(t *testing.T) {
+	manager := newWorkersManager(t, 4)
+	manager.config.RateLimit = "1KB/s"
+	source := t.TempDir()
+	writeFiles(t, source, "abcdefgh", 2048)
+
+	snapshotID := newID("snap")
+	task := manager.registerTask(snapshotID, "snapshot")
+	defer manager.unregisterTask(task)
+	result := make(chan error, 1)
+	go func() {
+		result <- manager.createSnapshotInternal(task.ctx, snapshotID, "plan_test", []string{source}, false, task)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	task.cancel()
+	select {
+	case err := <-result:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("snapshot did not stop")
+	}
+
+	// The blocks the workers read are removed
+	assert.Zero(t, blockFiles(t, manager))
+	assert.Zero(t, manager.blockStore.usedBytes())
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	source := b.TempDir()
+	writeLargeTree(b, source, 64)
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				manager := newWorkersManager(b, workers)
+				snapshotID := newID("snap")
+				task := manager.registerTask(snapshotID, "snapshot")
+				b.StartTimer()
+
+				require.NoError(b, manager.createSnapshotInternal(task.ctx, snapshotID, "plan_test", []string{source}, true, task))
+				manager.unregisterTask(task)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package snap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Blocks are stored in one of two formats. Repositories without compression
+// store the data of a block as is, as every version did before compression,
+// so they stay readable by those versions. With compression, a block starts
+// with a header: blockMagic, the format version, the codec and the length of
+// the data, followed by the data encoded with the codec. A block whose data
+// does not shrink is stored with codecNone.
+//
+// Either way a block is named after the SHA-256 of its uncompressed data:
+// deduplication does not depend on the codec or on whether compression was
+// on when a block was written, and reading a block checks the hash of the
+// decoded data.
+const (
+	blockMagic      = "ICBK"
+	blockVersion    = 1
+	blockHeaderSize = len(blockMagic) + 2 + 4
+)
+
+// Codecs of blocks in the current format
+const (
+	codecNone byte = 0
+	codecGzip byte = 1
+)
+
+// gzipWriters recycles the writers compressing blocks
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		writer, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return writer
+	},
+}
+
+// encodeBlock returns the data of a block as it is stored with a compression
+// setting, none when empty
+func encodeBlock(data []byte, compression string) []byte {
+	if compression == "" || compression == "none" {
+		return data
+	}
+
+	stored := make([]byte, blockHeaderSize, blockHeaderSize+len(data))
+	copy(stored, blockMagic)
+	stored[len(blockMagic)] = blockVersion
+	stored[len(blockMagic)+1] = codecGzip
+	binary.BigEndian.PutUint32(stored[len(blockMagic)+2:], uint32(len(data)))
+
+	buffer := bytes.NewBuffer(stored)
+	writer := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(writer)
+	writer.Reset(buffer)
+	if _, err := writer.Write(data); err == nil && writer.Close() == nil && buffer.Len() < len(stored)+len(data) {
+		return buffer.Bytes()
+	}
+
+	stored[len(blockMagic)+1] = codecNone
+	return append(stored, data...)
+}
+
+// encode returns the data of a block as the store writes it
+func (bs *BlockStore) encode(data []byte) []byte {
+	return encodeBlock(data, bs.compression)
+}
+
+// decodeBlock returns the data of a stored block. Blocks without a header
+// are returned as they are.
+func decodeBlock(stored []byte) ([]byte, error) {
+	if len(stored) < blockHeaderSize || string(stored[:len(blockMagic)]) != blockMagic {
+		return stored, nil
+	}
+	version, codec := stored[len(blockMagic)], stored[len(blockMagic)+1]
+	size := int(binary.BigEndian.Uint32(stored[len(blockMagic)+2:]))
+	if version != blockVersion {
+		return nil, fmt.Errorf("unsupported block format version %d", version)
+	}
+
+	payload := stored[blockHeaderSize:]
+	var data []byte
+	switch codec {
+	case codecNone:
+		data = payload
+	case codecGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress block: %w", err)
+		}
+		data = make([]byte, 0, size)
+		buffer := bytes.NewBuffer(data)
+		if _, err := io.Copy(buffer, io.LimitReader(reader, int64(size)+1)); err != nil {
+			return nil, fmt.Errorf("failed to decompress block: %w", err)
+		}
+		data = buffer.Bytes()
+	default:
+		return nil, fmt.Errorf("unknown block codec %d", codec)
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("block holds %d bytes, its header %d", len(data), size)
+	}
+	return data, nil
+}
+
+// blockDataSize returns the size of the data of a block file of size bytes,
+// which its header records if it has one. Data stored as is may start like
+// a header, so the header must match the rest of the block.
+func blockDataSize(path string, size int64) int64 {
+	file, err := os.Open(path)
+	if err != nil {
+		return size
+	}
+	defer file.Close()
+
+	header := make([]byte, blockHeaderSize+2)
+	if _, err := io.ReadFull(file, header); err != nil || string(header[:len(blockMagic)]) != blockMagic || header[len(blockMagic)] != blockVersion {
+		return size
+	}
+	dataSize := int64(binary.BigEndian.Uint32(header[len(blockMagic)+2:]))
+	switch header[len(blockMagic)+1] {
+	case codecNone:
+		if dataSize == size-int64(blockHeaderSize) {
+			return dataSize
+		}
+	case codecGzip:
+		if header[blockHeaderSize] == 0x1f && header[blockHeaderSize+1] == 0x8b {
+			return dataSize
+		}
+	}
+	return size
+}
+
+// BlockStats compares the data of the snapshots with what the repository
+// stores for it
+type BlockStats struct {
+	Blocks           int     `json:"blocks"`
+	LogicalBytes     int64   `json:"logical_bytes"`     // of the files of all snapshots
+	DataBytes        int64   `json:"data_bytes"`        // of the distinct blocks, uncompressed
+	StoredBytes      int64   `json:"stored_bytes"`      // of the block files
+	DedupRatio       float64 `json:"dedup_ratio"`       // logical over data bytes
+	CompressionRatio float64 `json:"compression_ratio"` // data over stored bytes
+}
+
+// blockStats returns the stats of the blocks of snapshots of logical bytes
+func (bs *BlockStore) blockStats(logical int64) BlockStats {
+	bs.mutex.RLock()
+	stats := BlockStats{
+		Blocks:       len(bs.blockIndex),
+		LogicalBytes: logical,
+		DataBytes:    bs.dataSize,
+		StoredBytes:  bs.size,
+	}
+	bs.mutex.RUnlock()
+
+	if stats.DataBytes > 0 {
+		stats.DedupRatio = float64(stats.LogicalBytes) / float64(stats.DataBytes)
+	}
+	if stats.StoredBytes > 0 {
+		stats.CompressionRatio = float64(stats.DataBytes) / float64(stats.StoredBytes)
+	}
+	return stats
+}
@@ -0,0 +1,155 @@
+package snap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hashOf returns the hex SHA-256 of data
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestEncodeBlock(t *testing.T) {
+	compressible := bytes.Repeat([]byte("infra-core snapshot "), 1000)
+	incompressible := randomData(1000)
+
+	// Without compression blocks are stored as is
+	assert.Equal(t, compressible, encodeBlock(compressible, ""))
+	assert.Equal(t, compressible, encodeBlock(compressible, "none"))
+
+	stored := encodeBlock(compressible, "gzip")
+	assert.Less(t, len(stored), len(compressible)/10)
+	assert.Equal(t, blockMagic, string(stored[:len(blockMagic)]))
+	assert.Equal(t, []byte{blockVersion, codecGzip}, stored[len(blockMagic):len(blockMagic)+2])
+	data, err := decodeBlock(stored)
+	require.NoError(t, err)
+	assert.Equal(t, compressible, data)
+
+	// Data that does not shrink is kept as is behind the header
+	stored = encodeBlock(incompressible, "gzip")
+	assert.Len(t, stored, blockHeaderSize+len(incompressible))
+	assert.Equal(t, codecNone, stored[len(blockMagic)+1])
+	data, err = decodeBlock(stored)
+	require.NoError(t, err)
+	assert.Equal(t, incompressible, data)
+
+	corrupt := encodeBlock(compressible, "gzip")
+	corrupt[len(corrupt)-5] ^= 0xff
+	_, err = decodeBlock(corrupt)
+	assert.Error(t, err)
+
+	future := encodeBlock(compressible, "gzip")
+	future[len(blockMagic)] = blockVersion + 1
+	_, err = decodeBlock(future)
+	assert.ErrorContains(t, err, "unsupported block format version 2")
+}
+
+func TestBlockStoreFormats(t *testing.T) {
+	repoDir := t.TempDir()
+	store, err := NewBlockStore(repoDir)
+	require.NoError(t, err)
+
+	// A block stored as is may start like a header
+	legacy := []byte(blockMagic + "\x01\x01\x00\x00\x00\x05hello")
+	require.NoError(t, store.storeBlock(hashOf(legacy), legacy))
+	data, err := store.readBlock(hashOf(legacy))
+	require.NoError(t, err)
+	assert.Equal(t, legacy, data)
+
+	store.compression = "gzip"
+	compressible := []byte(strings.Repeat("compressible ", 500))
+	require.NoError(t, store.storeBlock(hashOf(compressible), compressible))
+	data, err = store.readBlock(hashOf(compressible))
+	require.NoError(t, err)
+	assert.Equal(t, compressible, data)
+
+	// Blocks are deduplicated by their data whatever the compression
+	store.compression = ""
+	stored, err := store.putBlock(hashOf(compressible), compressible, nil)
+	require.NoError(t, err)
+	assert.False(t, stored)
+
+	stats := store.blockStats(int64(3 * len(compressible)))
+	assert.Equal(t, 2, stats.Blocks)
+	assert.Equal(t, int64(len(legacy)+len(compressible)), stats.DataBytes)
+	assert.Less(t, stats.StoredBytes, stats.DataBytes)
+	assert.Greater(t, stats.CompressionRatio, 1.0)
+	assert.Greater(t, stats.DedupRatio, 2.0)
+
+	// The sizes are read back from the repository
+	reopened, err := NewBlockStore(repoDir)
+	require.NoError(t, err)
+	assert.Equal(t, stats, reopened.blockStats(stats.LogicalBytes))
+
+	// A compressed block that does not decode is reported as corrupt
+	path, _ := store.blockPath(hashOf(compressible))
+	corrupt, err := os.ReadFile(path)
+	require.NoError(t, err)
+	corrupt[blockHeaderSize+3] ^= 0xff
+	require.NoError(t, os.WriteFile(path, corrupt, 0644))
+	_, err = store.readBlock(hashOf(compressible))
+	assert.ErrorContains(t, err, "hash mismatch")
+}
+
+func TestCompressedSnapshotRoundTrip(t *testing.T) {
+	manager, router := startRestoreTest(t)
+	router.GET("/stats", manager.GetStats)
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+
+	// The first snapshot stores fixed blocks as is, the second adds
+	// content-defined compressed blocks and reuses the others
+	legacyID := takeSnapshot(t, manager, source)
+	legacyState := treeState(t, source)
+
+	manager.config.Chunking = testChunking
+	manager.blockStore.compression = "gzip"
+	mutateTree(t, source)
+	require.NoError(t, os.WriteFile(filepath.Join(source, "log.txt"), bytes.Repeat([]byte("GET /health 200\n"), 20000), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "random.bin"), randomData(200<<10), 0644))
+	compressedID := takeSnapshot(t, manager, source)
+	compressedState := treeState(t, source)
+
+	compressed := 0
+	for _, path := range manager.blockStore.blockIndex {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		if bytes.HasPrefix(data, []byte(blockMagic)) {
+			compressed++
+		}
+	}
+	assert.Greater(t, compressed, 10)
+
+	for _, tc := range []struct {
+		snapshotID string
+		state      map[string]string
+	}{
+		{legacyID, legacyState},
+		{compressedID, compressedState},
+	} {
+		id, _ := startRestore(t, router, fmt.Sprintf(`{"snapshot_id": %q, "target_path": %q}`, tc.snapshotID, source))
+		status := waitForRestore(t, router, id, RestoreStatusCompleted)
+		assert.NotContains(t, status, "errors")
+		assert.Equal(t, tc.state, treeState(t, source))
+	}
+
+	code, response := serve(t, router, http.MethodGet, "/stats", "")
+	require.Equal(t, http.StatusOK, code)
+	stats := response["blocks"].(map[string]interface{})
+	assert.Equal(t, response["total_size"], stats["logical_bytes"])
+	assert.Less(t, stats["stored_bytes"], stats["data_bytes"])
+	assert.Greater(t, stats["compression_ratio"], 1.0)
+	assert.Greater(t, stats["dedup_ratio"], 1.0)
+}
@@ -0,0 +1,68 @@
+package snap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// A Sunday
+	from := time.Date(2026, 3, 1, 10, 2, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		next []string
+	}{
+		{"*/5 * * * *", []string{"2026-03-01 10:05", "2026-03-01 10:10", "2026-03-01 10:15"}},
+		{"0 * * * *", []string{"2026-03-01 11:00", "2026-03-01 12:00"}},
+		{"@hourly", []string{"2026-03-01 11:00"}},
+		{"@daily", []string{"2026-03-02 00:00", "2026-03-03 00:00"}},
+		{"30 2 * * mon-fri", []string{"2026-03-02 02:30", "2026-03-03 02:30", "2026-03-04 02:30", "2026-03-05 02:30", "2026-03-06 02:30", "2026-03-09 02:30"}},
+		{"0 0 * * 7", []string{"2026-03-08 00:00"}},
+		{"15,45 9-10 * * *", []string{"2026-03-01 10:15", "2026-03-01 10:45", "2026-03-02 09:15"}},
+		{"0 12 1 */3 *", []string{"2026-04-01 12:00", "2026-07-01 12:00"}},
+		{"0 0 29 feb *", []string{"2028-02-29 00:00"}},
+		// Either day field matches when both are restricted
+		{"0 0 13 * fri", []string{"2026-03-06 00:00", "2026-03-13 00:00", "2026-03-20 00:00"}},
+		{"10/20 * * * *", []string{"2026-03-01 10:10", "2026-03-01 10:30", "2026-03-01 10:50", "2026-03-01 11:10"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := ParseCron(tt.expr)
+			require.NoError(t, err)
+
+			next := from
+			for _, want := range tt.next {
+				next = schedule.Next(next)
+				assert.Equal(t, want, next.Format("2006-01-02 15:04"))
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"", `invalid cron expression "": expected 5 fields, got 0`},
+		{"* * * *", `invalid cron expression "* * * *": expected 5 fields, got 4`},
+		{"60 * * * *", `invalid cron expression "60 * * * *": invalid value "60" in minute field, expected 0-59`},
+		{"* 24 * * *", `invalid cron expression "* 24 * * *": invalid value "24" in hour field, expected 0-23`},
+		{"* * 0 * *", `invalid cron expression "* * 0 * *": invalid value "0" in day of month field, expected 1-31`},
+		{"* * * foo *", `invalid cron expression "* * * foo *": invalid value "foo" in month field, expected 1-12`},
+		{"*/0 * * * *", `invalid cron expression "*/0 * * * *": invalid step "0" in minute field`},
+		{"30-10 * * * *", `invalid cron expression "30-10 * * * *": invalid range "30-10" in minute field`},
+		{"0 0 30 2 *", `invalid cron expression "0 0 30 2 *": never runs`},
+		{"@often", `invalid cron expression "@often": unknown descriptor`},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseCron(tt.expr)
+		assert.EqualError(t, err, tt.err, tt.expr)
+	}
+}
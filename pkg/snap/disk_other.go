@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package snap
+
+import "errors"
+
+// statDisk is not supported on this platform, so only the repository quota
+// is enforced
+func statDisk(path string) (diskSpace, error) {
+	return diskSpace{}, errors.New("disk space is not supported on this platform")
+}
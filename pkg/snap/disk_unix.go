@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package snap
+
+import "syscall"
+
+// statDisk returns the space of the file system holding path
+func statDisk(path string) (diskSpace, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return diskSpace{}, err
+	}
+	return diskSpace{
+		Free:  int64(stat.Bavail) * int64(stat.Bsize),
+		Total: int64(stat.Blocks) * int64(stat.Bsize),
+	}, nil
+}
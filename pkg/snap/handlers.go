@@ -2,16 +2,62 @@ package snap
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/last-emo-boy/infra-core/pkg/database"
+	"github.com/last-emo-boy/infra-core/pkg/logging"
 )
 
+// RegisterRoutes registers the snap API under api, which the daemon mounts
+// at /api/v1
+func (sm *SnapManager) RegisterRoutes(api gin.IRouter) {
+	// Snap plans
+	api.POST("/plans", sm.CreatePlan)
+	api.GET("/plans", sm.ListPlans)
+	api.GET("/plans/:id", sm.GetPlan)
+	api.PUT("/plans/:id", sm.UpdatePlan)
+	api.DELETE("/plans/:id", sm.DeletePlan)
+	api.POST("/plans/:id/enable", sm.EnablePlan)
+	api.POST("/plans/:id/disable", sm.DisablePlan)
+	api.GET("/plans/:id/runs", sm.ListPlanRuns)
+
+	// Snapshots
+	api.POST("/snapshots", sm.CreateSnapshot)
+	api.GET("/snapshots", sm.ListSnapshots)
+	api.GET("/snapshots/:id", sm.GetSnapshot)
+	api.DELETE("/snapshots/:id", sm.DeleteSnapshot)
+	api.GET("/snapshots/:id/status", sm.GetSnapshotStatus)
+	api.GET("/snapshots/:id/progress", sm.StreamProgress)
+	api.POST("/snapshots/:id/verify", sm.VerifySnapshot)
+	api.GET("/snapshots/:id/files", sm.ListSnapshotFiles)
+	api.POST("/snapshots/:id/restore-file", sm.RestoreSnapshotFile)
+
+	// Restore operations
+	api.POST("/restore", sm.RestoreSnapshot)
+	api.GET("/restore/:id/status", sm.GetRestoreStatus)
+	api.GET("/restore/:id/progress", sm.StreamProgress)
+	api.POST("/restore/:id/cancel", sm.CancelRestore)
+
+	// Management
+	api.GET("/stats", sm.GetStats)
+	api.POST("/cleanup", sm.CleanupOrphans)
+	api.POST("/scrub", sm.TriggerScrub)
+	api.GET("/scrub/status", sm.GetScrubStatus)
+	api.GET("/scrub/:id/progress", sm.StreamProgress)
+
+	// Snapshots completed as server-sent events
+	api.GET("/events/stream", sm.eventBus.Stream)
+}
+
 // CreatePlanRequest represents a request to create a backup plan
 type CreatePlanRequest struct {
 	Name        string   `json:"name" binding:"required"`
@@ -38,6 +84,7 @@ type UpdatePlanRequest struct {
 type CreateSnapshotRequest struct {
 	PlanID string   `json:"plan_id" binding:"required"`
 	Paths  []string `json:"paths"`
+	Full   bool     `json:"full"` // take a full snapshot instead of an incremental one
 }
 
 // RestoreRequest represents a request to restore a snapshot
@@ -47,6 +94,13 @@ type RestoreRequest struct {
 	RestoreMode string `json:"restore_mode"` // "full", "shadow"
 }
 
+// RestoreFileRequest represents a request to restore a file or directory of
+// a snapshot
+type RestoreFileRequest struct {
+	FilePath   string `json:"file_path" binding:"required"`
+	TargetPath string `json:"target_path"` // defaults to the file path
+}
+
 // CreatePlan creates a new backup plan
 func (sm *SnapManager) CreatePlan(c *gin.Context) {
 	var req CreatePlanRequest
@@ -54,9 +108,13 @@ func (sm *SnapManager) CreatePlan(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if _, err := ParseCron(req.CronExpr); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Generate plan ID
-	planID := fmt.Sprintf("plan_%d", time.Now().Unix())
+	planID := newID("plan")
 	
 	// Set defaults
 	if req.KeepDaily == 0 {
@@ -69,17 +127,24 @@ func (sm *SnapManager) CreatePlan(c *gin.Context) {
 		req.KeepMonthly = sm.config.DefaultRetention.Monthly
 	}
 
+	orgID, ok := planOwner(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+
 	// Insert into database
 	pathsJSON, _ := json.Marshal(req.Paths)
 	_, err := sm.db.Exec(`
-		INSERT INTO snap_plans (id, name, cron_expression, paths, keep_daily, keep_weekly, keep_monthly, enabled, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-	`, planID, req.Name, req.CronExpr, string(pathsJSON), req.KeepDaily, req.KeepWeekly, req.KeepMonthly, req.Enabled)
+		INSERT INTO snap_plans (id, name, cron_expression, paths, keep_daily, keep_weekly, keep_monthly, enabled, org_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, planID, req.Name, req.CronExpr, string(pathsJSON), req.KeepDaily, req.KeepWeekly, req.KeepMonthly, req.Enabled, orgID)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create plan"})
 		return
 	}
+	sm.reschedule()
 
 	c.JSON(http.StatusCreated, gin.H{
 		"id":           planID,
@@ -90,17 +155,19 @@ func (sm *SnapManager) CreatePlan(c *gin.Context) {
 		"keep_weekly":  req.KeepWeekly,
 		"keep_monthly": req.KeepMonthly,
 		"enabled":      req.Enabled,
+		"org_id":       orgID,
 		"created_at":   time.Now(),
 	})
 }
 
 // ListPlans lists all backup plans
 func (sm *SnapManager) ListPlans(c *gin.Context) {
+	where, args := planWhere(c)
 	rows, err := sm.db.Query(`
-		SELECT id, name, cron_expression, paths, keep_daily, keep_weekly, keep_monthly, enabled, created_at, updated_at
-		FROM snap_plans
+		SELECT id, name, cron_expression, paths, keep_daily, keep_weekly, keep_monthly, enabled, COALESCE(org_id, ''), created_at, updated_at
+		FROM snap_plans`+where+`
 		ORDER BY created_at DESC
-	`)
+	`, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query plans"})
 		return
@@ -109,21 +176,21 @@ func (sm *SnapManager) ListPlans(c *gin.Context) {
 
 	var plans []gin.H
 	for rows.Next() {
-		var id, name, cronExpr, pathsJSON string
+		var id, name, cronExpr, pathsJSON, orgID string
 		var keepDaily, keepWeekly, keepMonthly int
 		var enabled bool
 		var createdAt, updatedAt time.Time
 
-		if err := rows.Scan(&id, &name, &cronExpr, &pathsJSON, &keepDaily, &keepWeekly, &keepMonthly, &enabled, &createdAt, &updatedAt); err != nil {
+		if err := rows.Scan(&id, &name, &cronExpr, &pathsJSON, &keepDaily, &keepWeekly, &keepMonthly, &enabled, &orgID, &createdAt, &updatedAt); err != nil {
 			continue
 		}
 
 		var paths []string
 		if err := json.Unmarshal([]byte(pathsJSON), &paths); err != nil {
-			log.Printf("Failed to unmarshal paths JSON: %v", err)
+			logging.FromContext(c.Request.Context()).Warn("failed to unmarshal plan paths", "plan_id", id, "error", err)
 		}
 
-		plans = append(plans, gin.H{
+		plan := gin.H{
 			"id":           id,
 			"name":         name,
 			"cron_expr":    cronExpr,
@@ -132,9 +199,14 @@ func (sm *SnapManager) ListPlans(c *gin.Context) {
 			"keep_weekly":  keepWeekly,
 			"keep_monthly": keepMonthly,
 			"enabled":      enabled,
+			"org_id":       orgID,
 			"created_at":   createdAt,
 			"updated_at":   updatedAt,
-		})
+		}
+		if next, scheduled := sm.scheduler.nextRun(id); scheduled {
+			plan["next_run"] = next
+		}
+		plans = append(plans, plan)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -147,15 +219,17 @@ func (sm *SnapManager) ListPlans(c *gin.Context) {
 func (sm *SnapManager) GetPlan(c *gin.Context) {
 	planID := c.Param("id")
 
-	var name, cronExpr, pathsJSON string
+	var name, cronExpr, pathsJSON, orgID string
 	var keepDaily, keepWeekly, keepMonthly int
 	var enabled bool
 	var createdAt, updatedAt time.Time
 
+	condition, args := planCondition(c)
 	err := sm.db.QueryRow(`
-		SELECT name, cron_expression, paths, keep_daily, keep_weekly, keep_monthly, enabled, created_at, updated_at
-		FROM snap_plans WHERE id = ?
-	`, planID).Scan(&name, &cronExpr, &pathsJSON, &keepDaily, &keepWeekly, &keepMonthly, &enabled, &createdAt, &updatedAt)
+		SELECT name, cron_expression, paths, keep_daily, keep_weekly, keep_monthly, enabled, COALESCE(org_id, ''), created_at, updated_at
+		FROM snap_plans WHERE id = ?`+condition,
+		append([]interface{}{planID}, args...)...,
+	).Scan(&name, &cronExpr, &pathsJSON, &keepDaily, &keepWeekly, &keepMonthly, &enabled, &orgID, &createdAt, &updatedAt)
 
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
@@ -164,10 +238,10 @@ func (sm *SnapManager) GetPlan(c *gin.Context) {
 
 	var paths []string
 	if err := json.Unmarshal([]byte(pathsJSON), &paths); err != nil {
-		log.Printf("Failed to unmarshal paths JSON: %v", err)
+		logging.FromContext(c.Request.Context()).Warn("failed to unmarshal plan paths", "plan_id", planID, "error", err)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	plan := gin.H{
 		"id":           planID,
 		"name":         name,
 		"cron_expr":    cronExpr,
@@ -176,8 +250,48 @@ func (sm *SnapManager) GetPlan(c *gin.Context) {
 		"keep_weekly":  keepWeekly,
 		"keep_monthly": keepMonthly,
 		"enabled":      enabled,
+		"org_id":       orgID,
 		"created_at":   createdAt,
 		"updated_at":   updatedAt,
+	}
+	if next, scheduled := sm.scheduler.nextRun(planID); scheduled {
+		plan["next_run"] = next
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// ListPlanRuns lists the latest scheduled runs of a backup plan, including
+// the skipped ones
+func (sm *SnapManager) ListPlanRuns(c *gin.Context) {
+	planID := c.Param("id")
+
+	limit := 100
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = parsed
+	}
+
+	var exists int
+	condition, args := planCondition(c)
+	if err := sm.db.QueryRow("SELECT COUNT(*) FROM snap_plans WHERE id = ?"+condition, append([]interface{}{planID}, args...)...).Scan(&exists); err != nil || exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+		return
+	}
+
+	runs, err := sm.planRuns(planID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query plan runs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"runs":  runs,
+		"total": len(runs),
 	})
 }
 
@@ -189,6 +303,12 @@ func (sm *SnapManager) UpdatePlan(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.CronExpr != "" {
+		if _, err := ParseCron(req.CronExpr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	// Build update query dynamically
 	var setParts []string
@@ -230,8 +350,9 @@ func (sm *SnapManager) UpdatePlan(c *gin.Context) {
 	}
 
 	setParts = append(setParts, "updated_at = CURRENT_TIMESTAMP")
-	query := fmt.Sprintf("UPDATE snap_plans SET %s WHERE id = ?", strings.Join(setParts, ", "))
-	args = append(args, planID)
+	condition, scopeArgs := planCondition(c)
+	query := fmt.Sprintf("UPDATE snap_plans SET %s WHERE id = ?%s", strings.Join(setParts, ", "), condition)
+	args = append(append(args, planID), scopeArgs...)
 
 	result, err := sm.db.Exec(query, args...)
 	if err != nil {
@@ -245,6 +366,8 @@ func (sm *SnapManager) UpdatePlan(c *gin.Context) {
 		return
 	}
 
+	sm.reschedule()
+
 	c.JSON(http.StatusOK, gin.H{"message": "Plan updated successfully"})
 }
 
@@ -252,7 +375,8 @@ func (sm *SnapManager) UpdatePlan(c *gin.Context) {
 func (sm *SnapManager) DeletePlan(c *gin.Context) {
 	planID := c.Param("id")
 
-	result, err := sm.db.Exec("DELETE FROM snap_plans WHERE id = ?", planID)
+	condition, args := planCondition(c)
+	result, err := sm.db.Exec("DELETE FROM snap_plans WHERE id = ?"+condition, append([]interface{}{planID}, args...)...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete plan"})
 		return
@@ -264,9 +388,44 @@ func (sm *SnapManager) DeletePlan(c *gin.Context) {
 		return
 	}
 
+	sm.reschedule()
+
 	c.JSON(http.StatusOK, gin.H{"message": "Plan deleted successfully"})
 }
 
+// planOwner returns the organization a plan created by a request belongs
+// to: the one the request is scoped to, else the default organization.
+// Requests scoped to no organization, of users without one, create none.
+func planOwner(c *gin.Context) (string, bool) {
+	orgID, scoped := database.OrgScope(c.Request.Context())
+	if !scoped {
+		return database.DefaultOrgID, true
+	}
+	return orgID, orgID != ""
+}
+
+// planCondition returns the condition restricting a statement on snap_plans
+// to the plans of the organization the request is scoped to, to be appended
+// to its WHERE clause, and its arguments. Both are empty when the request is
+// not scoped.
+func planCondition(c *gin.Context) (string, []interface{}) {
+	orgID, scoped := database.OrgScope(c.Request.Context())
+	if !scoped {
+		return "", nil
+	}
+	return " AND org_id = ?", []interface{}{orgID}
+}
+
+// planWhere is planCondition for statements without a WHERE clause of their
+// own
+func planWhere(c *gin.Context) (string, []interface{}) {
+	condition, args := planCondition(c)
+	if condition == "" {
+		return "", nil
+	}
+	return " WHERE" + strings.TrimPrefix(condition, " AND"), args
+}
+
 // EnablePlan enables a backup plan
 func (sm *SnapManager) EnablePlan(c *gin.Context) {
 	planID := c.Param("id")
@@ -280,7 +439,9 @@ func (sm *SnapManager) DisablePlan(c *gin.Context) {
 }
 
 func (sm *SnapManager) updatePlanStatus(c *gin.Context, planID string, enabled bool) {
-	result, err := sm.db.Exec("UPDATE snap_plans SET enabled = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", enabled, planID)
+	condition, args := planCondition(c)
+	result, err := sm.db.Exec("UPDATE snap_plans SET enabled = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?"+condition,
+		append([]interface{}{enabled, planID}, args...)...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update plan status"})
 		return
@@ -292,6 +453,8 @@ func (sm *SnapManager) updatePlanStatus(c *gin.Context, planID string, enabled b
 		return
 	}
 
+	sm.reschedule()
+
 	action := "disabled"
 	if enabled {
 		action = "enabled"
@@ -309,50 +472,39 @@ func (sm *SnapManager) CreateSnapshot(c *gin.Context) {
 	}
 
 	// Generate snapshot ID
-	snapshotID := fmt.Sprintf("snap_%d", time.Now().Unix())
+	snapshotID := newID("snap")
 
 	// Get paths from plan if not provided
 	paths := req.Paths
 	if len(paths) == 0 {
 		var pathsJSON string
-		err := sm.db.QueryRow("SELECT paths FROM snap_plans WHERE id = ?", req.PlanID).Scan(&pathsJSON)
+		condition, args := planCondition(c)
+		err := sm.db.QueryRow("SELECT paths FROM snap_plans WHERE id = ?"+condition, append([]interface{}{req.PlanID}, args...)...).Scan(&pathsJSON)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
 			return
 		}
 		if err := json.Unmarshal([]byte(pathsJSON), &paths); err != nil {
-			log.Printf("Failed to unmarshal paths JSON: %v", err)
+			logging.FromContext(c.Request.Context()).Warn("failed to unmarshal plan paths", "plan_id", req.PlanID, "error", err)
 		}
 	}
 
-	// Create task
-	task := &Task{
-		ID:      snapshotID,
-		Type:    "snapshot",
-		Status:  StatusPending,
-		Started: time.Now(),
+	// Refuse right away when the repository is already full; the space the
+	// snapshot needs is checked once its files are scanned
+	if err := sm.checkSpace(sm.blockStore.usedBytes(), 0); err != nil {
+		c.JSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+		return
 	}
 
-	sm.taskMutex.Lock()
-	sm.runningTasks[snapshotID] = task
-	sm.taskMutex.Unlock()
+	// Create task
+	task := sm.registerTask(snapshotID, "snapshot")
 
 	// Start snapshot in background
 	go func() {
-		defer func() {
-			sm.taskMutex.Lock()
-			delete(sm.runningTasks, snapshotID)
-			sm.taskMutex.Unlock()
-		}()
+		defer sm.unregisterTask(task)
 
-		err := sm.createSnapshotInternal(sm.ctx, snapshotID, req.PlanID, paths, task)
-		if err != nil {
-			task.Status = StatusFailed
-			task.Message = err.Error()
-		} else {
-			task.Status = StatusCompleted
-			task.Progress = 100.0
-		}
+		err := sm.createSnapshotInternal(task.ctx, snapshotID, req.PlanID, paths, req.Full, task)
+		sm.finishTask(task, err)
 	}()
 
 	c.JSON(http.StatusAccepted, gin.H{
@@ -362,7 +514,8 @@ func (sm *SnapManager) CreateSnapshot(c *gin.Context) {
 	})
 }
 
-// ListSnapshots lists all snapshots
+// ListSnapshots lists snapshots, newest first, optionally filtered by the
+// plan_id and status query parameters
 func (sm *SnapManager) ListSnapshots(c *gin.Context) {
 	limit := 50
 	offset := 0
@@ -378,13 +531,30 @@ func (sm *SnapManager) ListSnapshots(c *gin.Context) {
 		}
 	}
 
+	// Optionally only the snapshots of one plan or in one status
+	var filters []string
+	var args []interface{}
+	if planID := c.Query("plan_id"); planID != "" {
+		filters = append(filters, "s.plan_id = ?")
+		args = append(args, planID)
+	}
+	if status := c.Query("status"); status != "" {
+		filters = append(filters, "s.status = ?")
+		args = append(args, status)
+	}
+	where := ""
+	if len(filters) > 0 {
+		where = "WHERE " + strings.Join(filters, " AND ")
+	}
+
 	rows, err := sm.db.Query(`
-		SELECT s.id, s.plan_id, s.timestamp, s.manifest_path, s.size_bytes, s.status, p.name as plan_name
+		SELECT s.id, s.plan_id, s.timestamp, s.manifest_path, s.size_bytes, s.kind, COALESCE(s.parent_id, ''), s.status, p.name as plan_name
 		FROM snapshots s
 		LEFT JOIN snap_plans p ON s.plan_id = p.id
+		`+where+`
 		ORDER BY s.timestamp DESC
 		LIMIT ? OFFSET ?
-	`, limit, offset)
+	`, append(args, limit, offset)...)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query snapshots"})
@@ -394,11 +564,11 @@ func (sm *SnapManager) ListSnapshots(c *gin.Context) {
 
 	var snapshots []gin.H
 	for rows.Next() {
-		var id, planID, manifestPath, status, planName string
+		var id, planID, manifestPath, kind, parentID, status, planName string
 		var size int64
 		var timestamp time.Time
 
-		if err := rows.Scan(&id, &planID, &timestamp, &manifestPath, &size, &status, &planName); err != nil {
+		if err := rows.Scan(&id, &planID, &timestamp, &manifestPath, &size, &kind, &parentID, &status, &planName); err != nil {
 			continue
 		}
 
@@ -409,6 +579,8 @@ func (sm *SnapManager) ListSnapshots(c *gin.Context) {
 			"timestamp":     timestamp,
 			"manifest_path": manifestPath,
 			"size":          size,
+			"kind":          kind,
+			"parent_id":     parentID,
 			"status":        status,
 		})
 	}
@@ -425,14 +597,14 @@ func (sm *SnapManager) ListSnapshots(c *gin.Context) {
 func (sm *SnapManager) GetSnapshot(c *gin.Context) {
 	snapshotID := c.Param("id")
 
-	var planID, manifestPath, status string
+	var planID, manifestPath, kind, parentID, status string
 	var size int64
 	var timestamp time.Time
 
 	err := sm.db.QueryRow(`
-		SELECT plan_id, timestamp, manifest_path, size_bytes, status
+		SELECT plan_id, timestamp, manifest_path, size_bytes, kind, COALESCE(parent_id, ''), status
 		FROM snapshots WHERE id = ?
-	`, snapshotID).Scan(&planID, &timestamp, &manifestPath, &size, &status)
+	`, snapshotID).Scan(&planID, &timestamp, &manifestPath, &size, &kind, &parentID, &status)
 
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
@@ -445,6 +617,8 @@ func (sm *SnapManager) GetSnapshot(c *gin.Context) {
 		"timestamp":     timestamp,
 		"manifest_path": manifestPath,
 		"size":          size,
+		"kind":          kind,
+		"parent_id":     parentID,
 		"status":        status,
 	})
 }
@@ -461,22 +635,21 @@ func (sm *SnapManager) DeleteSnapshot(c *gin.Context) {
 		return
 	}
 
-	// Delete from database
-	result, err := sm.db.Exec("DELETE FROM snapshots WHERE id = ?", snapshotID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete snapshot"})
+	blocksRemoved, spaceFreed, err := sm.deleteSnapshot(snapshotID, manifestPath)
+	if errors.Is(err, errSnapshotNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
 		return
 	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete snapshot"})
 		return
 	}
 
-	// TODO: Clean up orphaned blocks and manifest file
-
-	c.JSON(http.StatusOK, gin.H{"message": "Snapshot deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Snapshot deleted successfully",
+		"blocks_removed": blocksRemoved,
+		"space_freed":    spaceFreed,
+	})
 }
 
 // GetSnapshotStatus gets the status of a snapshot creation
@@ -488,13 +661,15 @@ func (sm *SnapManager) GetSnapshotStatus(c *gin.Context) {
 	sm.taskMutex.RUnlock()
 
 	if exists {
+		progress := task.Snapshot()
 		c.JSON(http.StatusOK, gin.H{
 			"id":       task.ID,
 			"type":     task.Type,
-			"status":   task.Status,
-			"progress": task.Progress,
-			"message":  task.Message,
+			"status":   progress.Status,
+			"progress": progress.Progress,
+			"message":  progress.Message,
 			"started":  task.Started,
+			"details":  progress,
 		})
 		return
 	}
@@ -517,6 +692,69 @@ func (sm *SnapManager) GetSnapshotStatus(c *gin.Context) {
 	})
 }
 
+// StreamProgress streams task progress as server-sent events until the task finishes.
+// It serves snapshot, restore and scrub tasks alike.
+func (sm *SnapManager) StreamProgress(c *gin.Context) {
+	taskID := c.Param("id")
+
+	sm.taskMutex.RLock()
+	task, exists := sm.runningTasks[taskID]
+	sm.taskMutex.RUnlock()
+
+	if !exists {
+		// The task may already have finished, report the stored snapshot or
+		// restore state
+		progress := TaskProgress{TaskID: taskID, Type: "snapshot", Progress: 100.0, UpdatedAt: time.Now()}
+		err := sm.db.QueryRow("SELECT status FROM snapshots WHERE id = ?", taskID).Scan(&progress.Status)
+		if err != nil {
+			job, jobErr := sm.getRestoreJob(taskID)
+			if jobErr != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+				return
+			}
+			progress.Type = "restore"
+			progress.Status = job.Status
+			progress.Progress = job.Progress
+			progress.Message = job.Message
+			progress.TotalFiles = job.TotalFiles
+			progress.FilesProcessed = job.FilesRestored
+			progress.TotalBytes = job.TotalBytes
+			progress.BytesWritten = job.BytesRestored
+			progress.Errors = len(job.Errors)
+		}
+
+		c.Header("Cache-Control", "no-cache")
+		c.SSEvent(progress.Status, progress)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+
+	for {
+		progress, changed := task.watch()
+
+		event := "progress"
+		if progress.Done() {
+			event = progress.Status
+		}
+		c.SSEvent(event, progress)
+		c.Writer.Flush()
+
+		if progress.Done() {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
 // VerifySnapshot verifies the integrity of a snapshot
 func (sm *SnapManager) VerifySnapshot(c *gin.Context) {
 	snapshotID := c.Param("id")
@@ -531,7 +769,9 @@ func (sm *SnapManager) VerifySnapshot(c *gin.Context) {
 	})
 }
 
-// RestoreSnapshot restores a snapshot
+// RestoreSnapshot restores a snapshot in the background. A full restore
+// replaces the target path; a shadow restore writes next to it, into a
+// directory named after the target and the time.
 func (sm *SnapManager) RestoreSnapshot(c *gin.Context) {
 	var req RestoreRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -539,63 +779,257 @@ func (sm *SnapManager) RestoreSnapshot(c *gin.Context) {
 		return
 	}
 
-	// Generate restore job ID
-	restoreID := fmt.Sprintf("restore_%d", time.Now().Unix())
+	mode := req.RestoreMode
+	if mode == "" {
+		mode = RestoreModeFull
+	}
+	if mode != RestoreModeFull && mode != RestoreModeShadow {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid restore mode %q, expected full or shadow", req.RestoreMode)})
+		return
+	}
+	if !filepath.IsAbs(req.TargetPath) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Target path must be absolute"})
+		return
+	}
 
-	// TODO: Implement restore logic
-	// This would involve reading the manifest and reconstructing files from blocks
+	manifest, err := sm.loadManifest(req.SnapshotID)
+	switch {
+	case errors.Is(err, errSnapshotNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+		return
+	case errors.Is(err, errSnapshotNotReady):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		logging.FromContext(c.Request.Context()).Error("failed to load snapshot", "snapshot_id", req.SnapshotID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read snapshot manifest"})
+		return
+	}
 
-	c.JSON(http.StatusAccepted, gin.H{
-		"id":          restoreID,
-		"snapshot_id": req.SnapshotID,
-		"target_path": req.TargetPath,
-		"status":      RestoreStatusPending,
-		"message":     "Restore operation started",
+	job := newRestoreJob(req.SnapshotID, req.TargetPath, mode, manifest)
+	if busy, err := sm.restoreInProgress(job.TargetPath); err != nil || busy {
+		c.JSON(http.StatusConflict, gin.H{"error": "A restore to this target path is already in progress"})
+		return
+	}
+	if _, err := os.Lstat(job.OutputPath); mode == RestoreModeShadow && err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Shadow path %s already exists", job.OutputPath)})
+		return
+	}
+	if err := sm.insertRestoreJob(job); err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to create restore job", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create restore job"})
+		return
+	}
+
+	response := gin.H{
+		"id":           job.ID,
+		"snapshot_id":  job.SnapshotID,
+		"target_path":  job.TargetPath,
+		"output_path":  job.OutputPath,
+		"restore_mode": job.RestoreMode,
+		"total_files":  job.TotalFiles,
+		"total_bytes":  job.TotalBytes,
+		"status":       RestoreStatusPending,
+		"message":      "Restore operation started",
+	}
+
+	task := sm.registerTask(job.ID, "restore")
+	go sm.runRestore(task, job, manifest)
+
+	c.JSON(http.StatusAccepted, response)
+}
+
+// ListSnapshotFiles lists the files of a directory in a snapshot, or of the
+// snapshot paths when no path is given. With recursive set every file under
+// the directory is listed, each directory followed by its contents.
+func (sm *SnapManager) ListSnapshotFiles(c *gin.Context) {
+	snapshotID := c.Param("id")
+	dir := c.Query("path")
+	recursive := c.Query("recursive") == "true"
+	limit := 100
+	offset := 0
+
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	if o := c.Query("offset"); o != "" {
+		if parsed, err := strconv.Atoi(o); err == nil && parsed >= 0 {
+			offset = parsed
+		}
+	}
+	if dir != "" {
+		dir = filepath.Clean(dir)
+	}
+
+	idx, err := sm.openIndex(snapshotID)
+	if err != nil {
+		sm.respondBrowseError(c, snapshotID, err)
+		return
+	}
+	files, total, err := idx.list(dir, recursive, offset, limit)
+	if err != nil {
+		sm.respondBrowseError(c, snapshotID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"snapshot_id": snapshotID,
+		"path":        dir,
+		"recursive":   recursive,
+		"files":       files,
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
 	})
 }
 
-// GetRestoreStatus gets the status of a restore operation
+// RestoreSnapshotFile restores a file or directory of a snapshot to the
+// target path, by default where it was, and responds once it is restored
+// and verified against its checksums
+func (sm *SnapManager) RestoreSnapshotFile(c *gin.Context) {
+	snapshotID := c.Param("id")
+
+	var req RestoreFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TargetPath == "" {
+		req.TargetPath = req.FilePath
+	}
+	if !filepath.IsAbs(req.TargetPath) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Target path must be absolute"})
+		return
+	}
+
+	targetPath := filepath.Clean(req.TargetPath)
+	if busy, err := sm.restoreInProgress(targetPath); err != nil || busy {
+		c.JSON(http.StatusConflict, gin.H{"error": "A restore to this target path is already in progress"})
+		return
+	}
+
+	result, err := sm.restorePathFromSnapshot(snapshotID, filepath.Clean(req.FilePath), targetPath)
+	if err != nil && result != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to restore snapshot path", "snapshot_id", snapshotID, "path", req.FilePath, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "errors": result.Errors})
+		return
+	}
+	if err != nil {
+		sm.respondBrowseError(c, snapshotID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// respondBrowseError responds with the error of looking up a file in a
+// snapshot
+func (sm *SnapManager) respondBrowseError(c *gin.Context, snapshotID string, err error) {
+	switch {
+	case errors.Is(err, errSnapshotNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+	case errors.Is(err, errPathNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found in snapshot"})
+	case errors.Is(err, errSnapshotNotReady):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errNotDirectory):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is not a directory"})
+	default:
+		logging.FromContext(c.Request.Context()).Error("failed to browse snapshot", "snapshot_id", snapshotID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read snapshot index"})
+	}
+}
+
+// GetRestoreStatus gets the status of a restore operation, with the live
+// progress of a running one
 func (sm *SnapManager) GetRestoreStatus(c *gin.Context) {
 	restoreID := c.Param("id")
 
-	// TODO: Implement restore status tracking
+	job, err := sm.getRestoreJob(restoreID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Restore not found"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"id":       restoreID,
-		"status":   RestoreStatusCompleted,
-		"progress": 100.0,
-		"message":  "Restore completed successfully",
-	})
+	sm.taskMutex.RLock()
+	task, exists := sm.runningTasks[restoreID]
+	sm.taskMutex.RUnlock()
+
+	if exists && task.Type == "restore" {
+		progress := task.Snapshot()
+		job.Status = progress.Status
+		job.Progress = progress.Progress
+		job.Message = progress.Message
+		job.FilesRestored = progress.FilesProcessed
+		job.BytesRestored = progress.BytesWritten
+	}
+
+	c.JSON(http.StatusOK, job)
 }
 
-// CancelRestore cancels a restore operation
+// CancelRestore cancels a restore operation and waits until its partial
+// output is removed
 func (sm *SnapManager) CancelRestore(c *gin.Context) {
 	restoreID := c.Param("id")
 
-	// TODO: Implement restore cancellation
+	sm.taskMutex.RLock()
+	task, exists := sm.runningTasks[restoreID]
+	sm.taskMutex.RUnlock()
+
+	if !exists || task.Type != "restore" {
+		job, err := sm.getRestoreJob(restoreID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Restore not found"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Restore is already %s", job.Status)})
+		return
+	}
+
+	task.cancel()
+	for {
+		progress, changed := task.watch()
+		if progress.Done() {
+			break
+		}
+		select {
+		case <-changed:
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+
+	job, err := sm.getRestoreJob(restoreID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load restore job"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":      restoreID,
-		"status":  RestoreStatusCancelled,
-		"message": "Restore operation cancelled",
+		"id":      job.ID,
+		"status":  job.Status,
+		"message": job.Message,
 	})
 }
 
-// GetStats gets backup and restore statistics
+// GetStats gets backup and restore statistics, and the space the repository
+// uses and has left
 func (sm *SnapManager) GetStats(c *gin.Context) {
 	var totalSnapshots, totalSize int64
 	if err := sm.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(size_bytes), 0) FROM snapshots").Scan(&totalSnapshots, &totalSize); err != nil {
-		log.Printf("Failed to get snapshot stats: %v", err)
+		logging.FromContext(c.Request.Context()).Warn("failed to get snapshot stats", "error", err)
 	}
 
 	var totalPlans int64
 	if err := sm.db.QueryRow("SELECT COUNT(*) FROM snap_plans").Scan(&totalPlans); err != nil {
-		log.Printf("Failed to get total plans count: %v", err)
+		logging.FromContext(c.Request.Context()).Warn("failed to count plans", "error", err)
 	}
 
 	var activePlans int64
 	if err := sm.db.QueryRow("SELECT COUNT(*) FROM snap_plans WHERE enabled = 1").Scan(&activePlans); err != nil {
-		log.Printf("Failed to get active plans count: %v", err)
+		logging.FromContext(c.Request.Context()).Warn("failed to count active plans", "error", err)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -604,26 +1038,33 @@ func (sm *SnapManager) GetStats(c *gin.Context) {
 		"total_plans":     totalPlans,
 		"active_plans":    activePlans,
 		"running_tasks":   len(sm.runningTasks),
+		"storage":         sm.usage(),
+		"blocks":          sm.blockStore.blockStats(totalSize),
 	})
 }
 
 // CleanupOrphans cleans up orphaned blocks
 func (sm *SnapManager) CleanupOrphans(c *gin.Context) {
-	// TODO: Implement orphan cleanup
-	// This would identify blocks not referenced by any snapshot and remove them
+	blocksRemoved, spaceFreed, err := sm.cleanupOrphans()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Orphan cleanup failed: %v", err)})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Orphan cleanup completed",
-		"blocks_removed": 0,
-		"space_freed": 0,
+		"message":        "Orphan cleanup completed",
+		"blocks_removed": blocksRemoved,
+		"space_freed":    spaceFreed,
 	})
 }
 
 // TriggerScrub triggers a scrub operation
 func (sm *SnapManager) TriggerScrub(c *gin.Context) {
-	go sm.performScrub()
+	task := sm.registerTask(newID("scrub"), "scrub")
+	go sm.runScrub(task)
 
 	c.JSON(http.StatusOK, gin.H{
+		"id":      task.ID,
 		"message": "Scrub operation started",
 		"status":  "running",
 	})
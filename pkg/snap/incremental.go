@@ -0,0 +1,115 @@
+package snap
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// snapshotBaseline returns the manifest a new snapshot of a plan is taken
+// against, or nil if the snapshot should be full: because full is set, the
+// plan has no completed snapshot, or its latest chain of incremental
+// snapshots reached the configured length
+func (sm *SnapManager) snapshotBaseline(planID string, full bool) (*SnapshotManifest, error) {
+	if full {
+		return nil, nil
+	}
+
+	var latest struct {
+		ID           string `db:"id"`
+		ManifestPath string `db:"manifest_path"`
+	}
+	err := sm.db.Get(&latest, `
+		SELECT id, manifest_path FROM snapshots
+		WHERE plan_id = ? AND status = ?
+		ORDER BY timestamp DESC LIMIT 1
+	`, planID, StatusCompleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest snapshot: %w", err)
+	}
+
+	if sm.config.FullEvery > 0 {
+		length, err := sm.chainLength(latest.ID, sm.config.FullEvery)
+		if err != nil {
+			return nil, err
+		}
+		if length >= sm.config.FullEvery {
+			return nil, nil
+		}
+	}
+
+	manifest, err := readManifest(latest.ManifestPath)
+	if err != nil {
+		// Without its manifest the latest snapshot cannot be built on, which
+		// only costs a full snapshot
+		log.Printf("Taking a full snapshot of plan %s: snapshot %s: %v", planID, latest.ID, err)
+		return nil, nil
+	}
+	return manifest, nil
+}
+
+// chainLength counts the snapshots from a snapshot back through its parents
+// to the full snapshot they build on, stopping at limit. A parent that was
+// deleted ends the chain.
+func (sm *SnapManager) chainLength(snapshotID string, limit int) (int, error) {
+	length := 0
+	for id := snapshotID; id != "" && length < limit; length++ {
+		var parentID sql.NullString
+		err := sm.db.Get(&parentID, "SELECT parent_id FROM snapshots WHERE id = ?", id)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to query snapshot %s: %w", id, err)
+		}
+		id = parentID.String
+	}
+	return length, nil
+}
+
+// baselineFiles indexes the regular files of a baseline manifest by path
+func baselineFiles(baseline *SnapshotManifest) map[string]FileEntry {
+	files := make(map[string]FileEntry)
+	if baseline == nil {
+		return files
+	}
+	for _, entry := range baseline.Files {
+		if !entry.IsDir && entry.Target == "" {
+			files[entry.Path] = entry
+		}
+	}
+	return files
+}
+
+// unchanged reports whether a regular file matches its baseline entry by
+// size, mode and modification time
+func unchanged(entry FileEntry, info os.FileInfo) bool {
+	return entry.Size == info.Size() &&
+		entry.Mode == uint32(info.Mode()) &&
+		entry.ModTime.Equal(info.ModTime())
+}
+
+// holdBlocks adds blocks to hold if all of them are still stored, and
+// reports whether they were
+func (bs *BlockStore) holdBlocks(hashes []string, hold *blockHold) bool {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	for _, hash := range hashes {
+		if _, exists := bs.blockIndex[hash]; !exists {
+			return false
+		}
+	}
+	for _, hash := range hashes {
+		if !hold.hashes[hash] {
+			hold.hashes[hash] = true
+			bs.held[hash]++
+		}
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package snap
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snapshotProgress snapshots paths and returns the snapshot ID and the
+// progress it reported last
+func snapshotProgress(t *testing.T, manager *SnapManager, full bool, paths ...string) (string, TaskProgress) {
+	snapshotID := newID("snap")
+	task := manager.registerTask(snapshotID, "snapshot")
+	defer manager.unregisterTask(task)
+	require.NoError(t, manager.createSnapshotInternal(task.ctx, snapshotID, "plan_test", paths, full, task))
+	return snapshotID, task.Snapshot()
+}
+
+// snapshotLineage returns the kind and parent of a snapshot
+func snapshotLineage(t *testing.T, manager *SnapManager, snapshotID string) (string, string) {
+	manifest, err := manager.loadManifest(snapshotID)
+	require.NoError(t, err)
+
+	var row struct {
+		Kind     string `db:"kind"`
+		ParentID string `db:"parent_id"`
+	}
+	require.NoError(t, manager.db.Get(&row, "SELECT kind, COALESCE(parent_id, '') AS parent_id FROM snapshots WHERE id = ?", snapshotID))
+	assert.Equal(t, manifest.Kind, row.Kind)
+	assert.Equal(t, manifest.ParentID, row.ParentID)
+	return row.Kind, row.ParentID
+}
+
+func TestIncrementalSnapshot(t *testing.T) {
+	manager, _ := startRestoreTest(t)
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+
+	first, progress := snapshotProgress(t, manager, false, source)
+	kind, parent := snapshotLineage(t, manager, first)
+	assert.Equal(t, KindFull, kind)
+	assert.Empty(t, parent)
+	assert.Zero(t, progress.FilesReused)
+	assert.Equal(t, int64(BlockSize+128), progress.BytesWritten)
+
+	// Nothing changed, so nothing is read or stored again
+	second, progress := snapshotProgress(t, manager, false, source)
+	kind, parent = snapshotLineage(t, manager, second)
+	assert.Equal(t, KindIncremental, kind)
+	assert.Equal(t, first, parent)
+	assert.Equal(t, 5, progress.FilesReused)
+	assert.Zero(t, progress.BytesRead)
+	assert.Zero(t, progress.BytesWritten)
+	assert.Equal(t, snapshotBlocks(t, manager, first), snapshotBlocks(t, manager, second))
+
+	// Changed and new files are read, and a file whose mode changed is read
+	// without its blocks being stored again
+	mutateTree(t, source)
+	third, progress := snapshotProgress(t, manager, false, source)
+	_, parent = snapshotLineage(t, manager, third)
+	assert.Equal(t, second, parent)
+	assert.Equal(t, 2, progress.FilesReused)
+	assert.Equal(t, int64(len("changed")+len("new")+len("#!/bin/sh\necho run\n")), progress.BytesRead)
+	assert.Equal(t, int64(len("changed")+len("new")), progress.BytesWritten)
+
+	// A file whose blocks went missing from the store is stored again
+	// rather than referenced
+	manifest, err := manager.loadManifest(third)
+	require.NoError(t, err)
+	for _, entry := range manifest.Files {
+		if entry.Path == filepath.Join(source, "big.bin") {
+			manager.blockStore.mutex.Lock()
+			delete(manager.blockStore.blockIndex, entry.Blocks[0])
+			manager.blockStore.mutex.Unlock()
+		}
+	}
+	fourth, progress := snapshotProgress(t, manager, false, source)
+	assert.Equal(t, 4, progress.FilesReused)
+	assert.Equal(t, int64(BlockSize), progress.BytesWritten)
+	assert.Equal(t, snapshotBlocks(t, manager, third), snapshotBlocks(t, manager, fourth))
+}
+
+func TestRestoreIncrementalSnapshot(t *testing.T) {
+	manager, router := startRestoreTest(t)
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+
+	first := takeSnapshot(t, manager, source)
+	mutateTree(t, source)
+	second := takeSnapshot(t, manager, source)
+	_, parent := snapshotLineage(t, manager, second)
+	require.Equal(t, first, parent)
+	expected := treeState(t, source)
+
+	// The incremental snapshot restores without its parent
+	_, _, err := manager.deleteSnapshot(first, filepath.Join(manager.config.RepoDir, "manifests", first+".json"))
+	require.NoError(t, err)
+
+	target := filepath.Join(t.TempDir(), "restored")
+	id, _ := startRestore(t, router, fmt.Sprintf(`{"snapshot_id": %q, "target_path": %q}`, second, target))
+	waitForRestore(t, router, id, RestoreStatusCompleted)
+	assert.Equal(t, expected, treeState(t, target))
+
+	// So does the next one, taken against a parent whose own parent is gone
+	third := takeSnapshot(t, manager, source)
+	_, parent = snapshotLineage(t, manager, third)
+	require.Equal(t, second, parent)
+	_, _, err = manager.deleteSnapshot(second, filepath.Join(manager.config.RepoDir, "manifests", second+".json"))
+	require.NoError(t, err)
+
+	target = filepath.Join(t.TempDir(), "restored")
+	id, _ = startRestore(t, router, fmt.Sprintf(`{"snapshot_id": %q, "target_path": %q}`, third, target))
+	waitForRestore(t, router, id, RestoreStatusCompleted)
+	assert.Equal(t, expected, treeState(t, target))
+}
+
+func TestFullSnapshotPolicy(t *testing.T) {
+	manager, _ := startRestoreTest(t)
+	manager.config.FullEvery = 3
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+
+	var previous string
+	for i, want := range []string{KindFull, KindIncremental, KindIncremental, KindFull, KindIncremental} {
+		snapshotID, progress := snapshotProgress(t, manager, false, source)
+		kind, parent := snapshotLineage(t, manager, snapshotID)
+		assert.Equal(t, want, kind, "snapshot %d", i)
+		if want == KindFull {
+			assert.Empty(t, parent, "snapshot %d", i)
+			assert.Zero(t, progress.FilesReused, "snapshot %d", i)
+		} else {
+			assert.Equal(t, previous, parent, "snapshot %d", i)
+		}
+		previous = snapshotID
+	}
+
+	// A full snapshot can be requested at any time
+	snapshotID, progress := snapshotProgress(t, manager, true, source)
+	kind, _ := snapshotLineage(t, manager, snapshotID)
+	assert.Equal(t, KindFull, kind)
+	assert.Zero(t, progress.FilesReused)
+	assert.Equal(t, int64(BlockSize+128), progress.BytesRead)
+	assert.Zero(t, progress.BytesWritten)
+}
+
+func TestCreateFullSnapshot(t *testing.T) {
+	manager, _ := startRestoreTest(t)
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+	first := takeSnapshot(t, manager, source)
+
+	router := gin.New()
+	router.POST("/snapshots", manager.CreateSnapshot)
+	router.GET("/snapshots/:id", manager.GetSnapshot)
+
+	for _, full := range []bool{false, true} {
+		code, response := serve(t, router, http.MethodPost, "/snapshots",
+			fmt.Sprintf(`{"plan_id": "plan_test", "paths": [%q], "full": %t}`, source, full))
+		require.Equal(t, http.StatusAccepted, code, response)
+		snapshotID := response["id"].(string)
+
+		require.Eventually(t, func() bool {
+			code, response = serve(t, router, http.MethodGet, "/snapshots/"+snapshotID, "")
+			return code == http.StatusOK
+		}, 10*time.Second, 5*time.Millisecond)
+		if full {
+			assert.Equal(t, KindFull, response["kind"])
+			assert.Equal(t, "", response["parent_id"])
+		} else {
+			assert.Equal(t, KindIncremental, response["kind"])
+			assert.Equal(t, first, response["parent_id"])
+		}
+	}
+}
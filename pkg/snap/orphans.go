@@ -0,0 +1,282 @@
+package snap
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// blockHold keeps the blocks an in-progress snapshot wrote or reuses from
+// being removed as orphans before the snapshot references them. Its hashes
+// are guarded by the block store's mutex.
+type blockHold struct {
+	store   *BlockStore
+	hashes  map[string]bool
+	written map[string]bool // the blocks the snapshot added to the store
+}
+
+// newHold creates an empty hold on blocks of the store
+func (bs *BlockStore) newHold() *blockHold {
+	return &blockHold{store: bs, hashes: make(map[string]bool), written: make(map[string]bool)}
+}
+
+// release lets the held blocks be removed again
+func (h *blockHold) release() {
+	h.store.mutex.Lock()
+	defer h.store.mutex.Unlock()
+
+	for hash := range h.hashes {
+		if h.store.held[hash]--; h.store.held[hash] <= 0 {
+			delete(h.store.held, hash)
+		}
+	}
+	h.hashes = make(map[string]bool)
+}
+
+// blockPath returns the file of a block
+func (bs *BlockStore) blockPath(hash string) (string, bool) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	path, exists := bs.blockIndex[hash]
+	return path, exists
+}
+
+// removeBlocks removes the blocks that are orphaned and not held by an
+// in-progress snapshot. It returns the removed blocks and the bytes freed.
+func (bs *BlockStore) removeBlocks(orphaned func(hash string) bool) ([]string, int64) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	var removed []string
+	var freed int64
+	for hash, path := range bs.blockIndex {
+		if bs.held[hash] > 0 || !orphaned(hash) {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		var dataSize int64
+		if err == nil {
+			dataSize = blockDataSize(path, info.Size())
+			err = os.Remove(path)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove orphaned block %s: %v", hash, err)
+			continue
+		}
+		if info != nil {
+			freed += info.Size()
+			bs.size -= info.Size()
+			bs.dataSize -= dataSize
+		}
+		delete(bs.blockIndex, hash)
+		removed = append(removed, hash)
+	}
+	return removed, freed
+}
+
+// readManifest reads a snapshot manifest
+func readManifest(manifestPath string) (*SnapshotManifest, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var manifest SnapshotManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// manifestBlocks returns the distinct blocks a snapshot references
+func manifestBlocks(manifest *SnapshotManifest) map[string]bool {
+	blocks := make(map[string]bool)
+	for _, entry := range manifest.Files {
+		for _, hash := range entry.Blocks {
+			blocks[hash] = true
+		}
+	}
+	return blocks
+}
+
+// recordSnapshot saves a completed snapshot along with a reference to each
+// of its blocks
+func (sm *SnapManager) recordSnapshot(manifest *SnapshotManifest, manifestPath string) error {
+	sm.refMutex.Lock()
+	defer sm.refMutex.Unlock()
+
+	tx, err := sm.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO snapshots (id, plan_id, timestamp, manifest_path, size_bytes, kind, parent_id, status, refs_counted)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, 1)
+	`, manifest.ID, manifest.PlanID, manifest.Timestamp, manifestPath, manifest.Size, manifest.Kind, manifest.ParentID, StatusCompleted)
+	if err != nil {
+		return err
+	}
+	for hash := range manifestBlocks(manifest) {
+		_, err := tx.Exec(`
+			INSERT INTO snap_blocks (hash, ref_count) VALUES (?, 1)
+			ON CONFLICT(hash) DO UPDATE SET ref_count = ref_count + 1
+		`, hash)
+		if err != nil {
+			return fmt.Errorf("failed to reference block %s: %w", hash, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// rebuildBlockRefs counts the references to each block from the manifests
+// of all snapshots, replacing the stored counts. It fails if a manifest
+// cannot be read, as the blocks only it references would look orphaned.
+// Callers hold refMutex.
+func (sm *SnapManager) rebuildBlockRefs() (map[string]int, error) {
+	var snapshots []struct {
+		ID           string `db:"id"`
+		ManifestPath string `db:"manifest_path"`
+	}
+	if err := sm.db.Select(&snapshots, "SELECT id, manifest_path FROM snapshots"); err != nil {
+		return nil, fmt.Errorf("failed to query snapshots: %w", err)
+	}
+
+	refs := make(map[string]int)
+	for _, snapshot := range snapshots {
+		manifest, err := readManifest(snapshot.ManifestPath)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", snapshot.ID, err)
+		}
+		for hash := range manifestBlocks(manifest) {
+			refs[hash]++
+		}
+	}
+
+	tx, err := sm.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM snap_blocks"); err != nil {
+		return nil, fmt.Errorf("failed to clear block references: %w", err)
+	}
+	for hash, count := range refs {
+		if _, err := tx.Exec("INSERT INTO snap_blocks (hash, ref_count) VALUES (?, ?)", hash, count); err != nil {
+			return nil, fmt.Errorf("failed to save block references: %w", err)
+		}
+	}
+	if _, err := tx.Exec("UPDATE snapshots SET refs_counted = 1"); err != nil {
+		return nil, fmt.Errorf("failed to mark snapshots counted: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit block references: %w", err)
+	}
+	return refs, nil
+}
+
+// ensureBlockRefs counts the block references of snapshots recorded before
+// references were counted. Callers hold refMutex.
+func (sm *SnapManager) ensureBlockRefs() error {
+	var uncounted int
+	if err := sm.db.Get(&uncounted, "SELECT COUNT(*) FROM snapshots WHERE refs_counted = 0"); err != nil {
+		return fmt.Errorf("failed to query snapshots: %w", err)
+	}
+	if uncounted == 0 {
+		return nil
+	}
+	_, err := sm.rebuildBlockRefs()
+	return err
+}
+
+// deleteSnapshot deletes a snapshot and its manifest, and removes the blocks
+// no other snapshot references. It returns the number of blocks removed and
+// the bytes freed.
+func (sm *SnapManager) deleteSnapshot(snapshotID, manifestPath string) (int, int64, error) {
+	sm.refMutex.Lock()
+	defer sm.refMutex.Unlock()
+
+	// Without the manifest, or counts for every snapshot, the snapshot's
+	// blocks are left to the next orphan cleanup
+	var blocks map[string]bool
+	if err := sm.ensureBlockRefs(); err != nil {
+		log.Printf("Not removing blocks of snapshot %s: %v", snapshotID, err)
+	} else if manifest, err := readManifest(manifestPath); err != nil {
+		log.Printf("Not removing blocks of snapshot %s: %v", snapshotID, err)
+	} else {
+		blocks = manifestBlocks(manifest)
+	}
+
+	tx, err := sm.db.Beginx()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM snapshots WHERE id = ?", snapshotID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return 0, 0, errSnapshotNotFound
+	}
+	for hash := range blocks {
+		if _, err := tx.Exec("UPDATE snap_blocks SET ref_count = ref_count - 1 WHERE hash = ?", hash); err != nil {
+			return 0, 0, fmt.Errorf("failed to release block %s: %w", hash, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit snapshot deletion: %w", err)
+	}
+
+	removeIndex(manifestPath)
+	if err := os.Remove(manifestPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove manifest %s: %v", manifestPath, err)
+	}
+	if len(blocks) == 0 {
+		return 0, 0, nil
+	}
+
+	var unreferenced []string
+	if err := sm.db.Select(&unreferenced, "SELECT hash FROM snap_blocks WHERE ref_count <= 0"); err != nil {
+		return 0, 0, fmt.Errorf("failed to query unreferenced blocks: %w", err)
+	}
+	orphans := make(map[string]bool, len(unreferenced))
+	for _, hash := range unreferenced {
+		orphans[hash] = blocks[hash]
+	}
+	return sm.removeOrphans(func(hash string) bool { return orphans[hash] })
+}
+
+// cleanupOrphans removes the blocks no snapshot references, recounting the
+// references from the manifests first. Blocks of snapshots in progress are
+// kept. It returns the number of blocks removed and the bytes freed.
+func (sm *SnapManager) cleanupOrphans() (int, int64, error) {
+	sm.refMutex.Lock()
+	defer sm.refMutex.Unlock()
+
+	refs, err := sm.rebuildBlockRefs()
+	if err != nil {
+		return 0, 0, err
+	}
+	return sm.removeOrphans(func(hash string) bool { return refs[hash] == 0 })
+}
+
+// removeOrphans removes orphaned blocks and their reference counts. Callers
+// hold refMutex.
+func (sm *SnapManager) removeOrphans(orphaned func(hash string) bool) (int, int64, error) {
+	removed, freed := sm.blockStore.removeBlocks(orphaned)
+
+	for _, hash := range removed {
+		if _, err := sm.db.Exec("DELETE FROM snap_blocks WHERE hash = ?", hash); err != nil {
+			return len(removed), freed, fmt.Errorf("failed to delete reference count of block %s: %w", hash, err)
+		}
+	}
+	if len(removed) > 0 {
+		log.Printf("🧹 Removed %d orphaned blocks, freeing %d bytes", len(removed), freed)
+	}
+	return len(removed), freed, nil
+}
@@ -0,0 +1,234 @@
+package snap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startOrphanTest creates a snap manager with a plan to snapshot, and a
+// router serving its snapshot deletion and orphan cleanup API
+func startOrphanTest(t *testing.T) (*SnapManager, *gin.Engine) {
+	manager, _ := startRestoreTest(t)
+
+	router := gin.New()
+	router.DELETE("/snapshots/:id", manager.DeleteSnapshot)
+	router.POST("/cleanup", manager.CleanupOrphans)
+	return manager, router
+}
+
+// snapshotBlocks returns the blocks a snapshot references
+func snapshotBlocks(t *testing.T, manager *SnapManager, snapshotID string) map[string]bool {
+	manifest, err := manager.loadManifest(snapshotID)
+	require.NoError(t, err)
+	return manifestBlocks(manifest)
+}
+
+// storedBlocks returns the blocks in the store, checking that the index
+// matches the block files
+func storedBlocks(t *testing.T, manager *SnapManager) map[string]bool {
+	onDisk := make(map[string]bool)
+	err := filepath.Walk(filepath.Join(manager.config.RepoDir, "blocks"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		onDisk[filepath.Base(path[:len(path)-len(".block")])] = true
+		return nil
+	})
+	require.NoError(t, err)
+
+	indexed := make(map[string]bool)
+	for hash := range manager.blockStore.blockIndex {
+		indexed[hash] = true
+	}
+	require.Equal(t, onDisk, indexed)
+	return indexed
+}
+
+// refCounts returns the stored block reference counts
+func refCounts(t *testing.T, manager *SnapManager) map[string]int {
+	var rows []struct {
+		Hash     string `db:"hash"`
+		RefCount int    `db:"ref_count"`
+	}
+	require.NoError(t, manager.db.Select(&rows, "SELECT hash, ref_count FROM snap_blocks"))
+	counts := make(map[string]int)
+	for _, row := range rows {
+		counts[row.Hash] = row.RefCount
+	}
+	return counts
+}
+
+// storeOrphan stores a block no snapshot references and returns its hash
+func storeOrphan(t *testing.T, manager *SnapManager, data string) string {
+	sum := sha256.Sum256([]byte(data))
+	hash := hex.EncodeToString(sum[:])
+	require.NoError(t, manager.blockStore.storeBlock(hash, []byte(data)))
+	return hash
+}
+
+func TestDeleteSnapshotRemovesUnreferencedBlocks(t *testing.T) {
+	manager, router := startOrphanTest(t)
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+
+	first := takeSnapshot(t, manager, source)
+	mutateTree(t, source)
+	second := takeSnapshot(t, manager, source)
+
+	firstBlocks := snapshotBlocks(t, manager, first)
+	secondBlocks := snapshotBlocks(t, manager, second)
+	unique := make(map[string]bool)
+	for hash := range firstBlocks {
+		if !secondBlocks[hash] {
+			unique[hash] = true
+		}
+	}
+	require.NotEmpty(t, unique)
+	require.Less(t, len(unique), len(firstBlocks), "the snapshots share blocks")
+
+	counts := refCounts(t, manager)
+	for hash := range firstBlocks {
+		want := 1
+		if secondBlocks[hash] {
+			want = 2
+		}
+		assert.Equal(t, want, counts[hash], hash)
+	}
+
+	var sizes int64
+	for hash := range unique {
+		info, err := os.Stat(manager.blockStore.blockIndex[hash])
+		require.NoError(t, err)
+		sizes += info.Size()
+	}
+	manifestPath := filepath.Join(manager.config.RepoDir, "manifests", first+".json")
+	require.FileExists(t, manifestPath)
+
+	code, response := serve(t, router, http.MethodDelete, "/snapshots/"+first, "")
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, float64(len(unique)), response["blocks_removed"])
+	assert.Equal(t, float64(sizes), response["space_freed"])
+	assert.NoFileExists(t, manifestPath)
+
+	// Only the second snapshot's blocks are left, each referenced once
+	assert.Equal(t, secondBlocks, storedBlocks(t, manager))
+	counts = refCounts(t, manager)
+	assert.Len(t, counts, len(secondBlocks))
+	for hash := range secondBlocks {
+		assert.Equal(t, 1, counts[hash], hash)
+	}
+
+	// The remaining snapshot's blocks are intact
+	for hash := range secondBlocks {
+		_, err := manager.blockStore.readBlock(hash)
+		require.NoError(t, err)
+	}
+
+	code, _ = serve(t, router, http.MethodDelete, "/snapshots/"+second, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Empty(t, storedBlocks(t, manager))
+	assert.Empty(t, refCounts(t, manager))
+
+	code, response = serve(t, router, http.MethodDelete, "/snapshots/"+second, "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "Snapshot not found", response["error"])
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	manager, router := startOrphanTest(t)
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+	snapshotID := takeSnapshot(t, manager, source)
+	kept := snapshotBlocks(t, manager, snapshotID)
+
+	// Blocks left behind by a snapshot that failed, and a reference count
+	// that went stale, are both removed
+	orphan := storeOrphan(t, manager, "left by a failed snapshot")
+	stale := storeOrphan(t, manager, "stale reference")
+	_, err := manager.db.Exec("INSERT INTO snap_blocks (hash, ref_count) VALUES (?, 3)", stale)
+	require.NoError(t, err)
+
+	// A block held by a snapshot in progress is kept
+	hold := manager.blockStore.newHold()
+	held := "written by a snapshot in progress"
+	sum := sha256.Sum256([]byte(held))
+	heldHash := hex.EncodeToString(sum[:])
+	_, err = manager.blockStore.putBlock(heldHash, []byte(held), hold)
+	require.NoError(t, err)
+
+	code, response := serve(t, router, http.MethodPost, "/cleanup", "")
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, float64(2), response["blocks_removed"])
+	assert.Equal(t, float64(len("left by a failed snapshot")+len("stale reference")), response["space_freed"])
+
+	want := map[string]bool{heldHash: true}
+	for hash := range kept {
+		want[hash] = true
+	}
+	assert.Equal(t, want, storedBlocks(t, manager))
+	assert.NotContains(t, refCounts(t, manager), orphan)
+	assert.NotContains(t, refCounts(t, manager), stale)
+
+	// Once released, the block is an orphan too
+	hold.release()
+	code, response = serve(t, router, http.MethodPost, "/cleanup", "")
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, float64(1), response["blocks_removed"])
+	assert.Equal(t, kept, storedBlocks(t, manager))
+}
+
+func TestCleanupOrphansUnreadableManifest(t *testing.T) {
+	manager, router := startOrphanTest(t)
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+	snapshotID := takeSnapshot(t, manager, source)
+	blocks := snapshotBlocks(t, manager, snapshotID)
+
+	require.NoError(t, os.Remove(filepath.Join(manager.config.RepoDir, "manifests", snapshotID+".json")))
+
+	// Without the manifest, the snapshot's blocks cannot be told apart from
+	// orphans, so nothing is removed
+	code, response := serve(t, router, http.MethodPost, "/cleanup", "")
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Contains(t, response["error"], "Orphan cleanup failed: snapshot "+snapshotID+": failed to read manifest")
+	assert.Equal(t, blocks, storedBlocks(t, manager))
+}
+
+func TestDeleteSnapshotCountsLegacyReferences(t *testing.T) {
+	manager, router := startOrphanTest(t)
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+	first := takeSnapshot(t, manager, source)
+	second := takeSnapshot(t, manager, source)
+	blocks := snapshotBlocks(t, manager, first)
+	require.Equal(t, blocks, snapshotBlocks(t, manager, second))
+
+	// Snapshots recorded before references were counted
+	_, err := manager.db.Exec("DELETE FROM snap_blocks")
+	require.NoError(t, err)
+	_, err = manager.db.Exec("UPDATE snapshots SET refs_counted = 0")
+	require.NoError(t, err)
+
+	code, response := serve(t, router, http.MethodDelete, "/snapshots/"+first, "")
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, float64(0), response["blocks_removed"])
+	assert.Equal(t, blocks, storedBlocks(t, manager))
+
+	counts := refCounts(t, manager)
+	for hash := range blocks {
+		assert.Equal(t, 1, counts[hash], hash)
+	}
+
+	code, response = serve(t, router, http.MethodDelete, "/snapshots/"+second, "")
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, float64(len(blocks)), response["blocks_removed"])
+	assert.Empty(t, storedBlocks(t, manager))
+}
@@ -0,0 +1,158 @@
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/last-emo-boy/infra-core/pkg/events"
+)
+
+// errInsufficientSpace is wrapped by the errors of snapshots refused or
+// aborted because they would exceed the repository quota or leave too little
+// free disk space
+var errInsufficientSpace = errors.New("insufficient space")
+
+// Usage levels at which a storage warning is published, in percent of the
+// repository quota or of the disk, whichever is fuller
+const (
+	usageWarningPercent  = 80
+	usageCriticalPercent = 95
+)
+
+// diskSpace is the space of a file system, in bytes
+type diskSpace struct {
+	Free  int64
+	Total int64
+}
+
+// StorageUsage is the space the snapshot repository uses and has left
+type StorageUsage struct {
+	RepoBytes        int64   `json:"repo_bytes"`
+	QuotaBytes       int64   `json:"quota_bytes"` // 0 without a quota
+	QuotaPercent     float64 `json:"quota_percent,omitempty"`
+	FreeDiskBytes    int64   `json:"free_disk_bytes"`
+	TotalDiskBytes   int64   `json:"total_disk_bytes"`
+	MinFreeDiskBytes int64   `json:"min_free_disk_bytes"` // 0 without a floor
+	UsagePercent     float64 `json:"usage_percent"`       // the fuller of the quota and the disk
+	Level            string  `json:"level,omitempty"`     // warning or critical
+}
+
+// usage returns the space the repository uses and has left. The disk is
+// left out where its space cannot be read.
+func (sm *SnapManager) usage() StorageUsage {
+	usage := StorageUsage{
+		RepoBytes:        sm.blockStore.usedBytes(),
+		QuotaBytes:       sm.config.MaxRepoBytes,
+		MinFreeDiskBytes: sm.config.MinFreeDiskBytes,
+	}
+	if usage.QuotaBytes > 0 {
+		usage.QuotaPercent = float64(usage.RepoBytes) / float64(usage.QuotaBytes) * 100
+		usage.UsagePercent = usage.QuotaPercent
+	}
+	if disk, err := sm.statDisk(sm.config.RepoDir); err == nil && disk.Total > 0 {
+		usage.FreeDiskBytes = disk.Free
+		usage.TotalDiskBytes = disk.Total
+		if diskPercent := float64(disk.Total-disk.Free) / float64(disk.Total) * 100; diskPercent > usage.UsagePercent {
+			usage.UsagePercent = diskPercent
+		}
+	}
+	switch {
+	case usage.UsagePercent >= usageCriticalPercent:
+		usage.Level = "critical"
+	case usage.UsagePercent >= usageWarningPercent:
+		usage.Level = "warning"
+	}
+	return usage
+}
+
+// checkSpace fails if writing size more bytes to a repository using used
+// bytes would exceed its quota or leave less free disk space than the floor.
+// The block store calls it before writing a block.
+func (sm *SnapManager) checkSpace(used, size int64) error {
+	if quota := sm.config.MaxRepoBytes; quota > 0 && used+size > quota {
+		return fmt.Errorf("%w: the repository would grow to %d bytes, over its quota of %d", errInsufficientSpace, used+size, quota)
+	}
+	if floor := sm.config.MinFreeDiskBytes; floor > 0 {
+		// Free space that cannot be read does not stop snapshots
+		disk, err := sm.statDisk(sm.config.RepoDir)
+		if err == nil && disk.Free-size < floor {
+			return fmt.Errorf("%w: %d bytes are free on the disk of the repository, and snapshots leave at least %d", errInsufficientSpace, disk.Free, floor)
+		}
+	}
+	return nil
+}
+
+// checkEstimate fails if a snapshot expected to write estimate bytes would
+// not fit
+func (sm *SnapManager) checkEstimate(estimate int64) error {
+	if err := sm.checkSpace(sm.blockStore.usedBytes(), estimate); err != nil {
+		return fmt.Errorf("snapshot needs about %d bytes: %w", estimate, err)
+	}
+	return nil
+}
+
+// checkUsage publishes a storage warning when the repository's usage
+// reaches a higher level than before. Going back under a level lets it be
+// reported again.
+func (sm *SnapManager) checkUsage() StorageUsage {
+	usage := sm.usage()
+	level := 0
+	switch usage.Level {
+	case "critical":
+		level = usageCriticalPercent
+	case "warning":
+		level = usageWarningPercent
+	}
+
+	sm.usageMutex.Lock()
+	raised := level > sm.usageLevel
+	sm.usageLevel = level
+	sm.usageMutex.Unlock()
+
+	if raised {
+		message := fmt.Sprintf("Snapshot repository is %.0f%% full: %d bytes used, %d bytes free on its disk", usage.UsagePercent, usage.RepoBytes, usage.FreeDiskBytes)
+		if usage.QuotaBytes > 0 {
+			message += fmt.Sprintf(", quota %d bytes", usage.QuotaBytes)
+		}
+		log.Printf("⚠️ %s", message)
+		sm.eventBus.Publish(events.StorageWarning, events.Storage{
+			Level:         usage.Level,
+			UsagePercent:  usage.UsagePercent,
+			RepoBytes:     usage.RepoBytes,
+			QuotaBytes:    usage.QuotaBytes,
+			FreeDiskBytes: usage.FreeDiskBytes,
+			Message:       message,
+		})
+	}
+	return usage
+}
+
+// discardBlocks removes the blocks a failed snapshot wrote, unless a
+// snapshot recorded since references them or one in progress holds them
+func (sm *SnapManager) discardBlocks(hold *blockHold) {
+	sm.blockStore.mutex.Lock()
+	written := hold.written
+	hold.written = make(map[string]bool)
+	sm.blockStore.mutex.Unlock()
+	hold.release()
+	if len(written) == 0 {
+		return
+	}
+
+	sm.refMutex.Lock()
+	defer sm.refMutex.Unlock()
+
+	orphans := make(map[string]bool, len(written))
+	for hash := range written {
+		var refs int
+		if err := sm.db.Get(&refs, "SELECT COALESCE(SUM(ref_count), 0) FROM snap_blocks WHERE hash = ?", hash); err != nil {
+			log.Printf("Not removing block %s of a failed snapshot: %v", hash, err)
+			continue
+		}
+		orphans[hash] = refs <= 0
+	}
+	if _, _, err := sm.removeOrphans(func(hash string) bool { return orphans[hash] }); err != nil {
+		log.Printf("Failed to remove the blocks of a failed snapshot: %v", err)
+	}
+}
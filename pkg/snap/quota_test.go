@@ -0,0 +1,169 @@
+package snap
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/last-emo-boy/infra-core/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles writes files of size bytes named after each byte of names,
+// filled with that byte so that every file is a distinct block
+func writeFiles(t *testing.T, dir, names string, size int) {
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	for _, name := range []byte(names) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, string(name)), bytes.Repeat([]byte{name}, size), 0644))
+	}
+}
+
+// trySnapshot takes a full or incremental snapshot of paths and returns its
+// error
+func trySnapshot(manager *SnapManager, full bool, paths ...string) error {
+	snapshotID := newID("snap")
+	task := manager.registerTask(snapshotID, "snapshot")
+	defer manager.unregisterTask(task)
+	return manager.createSnapshotInternal(task.ctx, snapshotID, "plan_test", paths, full, task)
+}
+
+// blockFiles counts the block files in the repository
+func blockFiles(t *testing.T, manager *SnapManager) int {
+	count := 0
+	err := filepath.Walk(filepath.Join(manager.config.RepoDir, "blocks"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return count
+}
+
+func TestSnapshotRefusedOverQuota(t *testing.T) {
+	manager, _ := startRestoreTest(t)
+	manager.config.MaxRepoBytes = 2500
+	source := t.TempDir()
+	writeFiles(t, source, "ab", 1000)
+
+	require.NoError(t, trySnapshot(manager, false, source))
+	assert.Equal(t, int64(2000), manager.blockStore.usedBytes())
+
+	// Unchanged files are not counted, the new ones do not fit
+	writeFiles(t, source, "c", 1000)
+	err := trySnapshot(manager, false, source)
+	require.ErrorIs(t, err, errInsufficientSpace)
+	assert.Equal(t, "snapshot needs about 1000 bytes: insufficient space: the repository would grow to 3000 bytes, over its quota of 2500", err.Error())
+	assert.Equal(t, 2, blockFiles(t, manager))
+
+	// A full snapshot counts every file
+	manager.config.MaxRepoBytes = 3500
+	require.NoError(t, os.Remove(filepath.Join(source, "c")))
+	err = trySnapshot(manager, true, source)
+	require.ErrorIs(t, err, errInsufficientSpace)
+	assert.Contains(t, err.Error(), "snapshot needs about 2000 bytes")
+}
+
+func TestSnapshotAbortedWhenDiskFills(t *testing.T) {
+	manager, _ := startRestoreTest(t)
+	manager.config.MinFreeDiskBytes = 1 << 20
+	free := atomic.Int64{}
+	free.Store(10 << 20)
+	manager.statDisk = func(string) (diskSpace, error) {
+		return diskSpace{Free: free.Load(), Total: 100 << 20}, nil
+	}
+
+	kept := filepath.Join(t.TempDir(), "kept")
+	writeFiles(t, kept, "ab", 1000)
+	require.NoError(t, trySnapshot(manager, false, kept))
+
+	// The disk fills up once the new snapshot wrote its first block
+	source := filepath.Join(t.TempDir(), "data")
+	writeFiles(t, source, "cde", 1000)
+	require.NoError(t, os.WriteFile(filepath.Join(source, "a"), bytes.Repeat([]byte("a"), 1000), 0644))
+	manager.blockStore.checkSpace = func(used, size int64) error {
+		err := manager.checkSpace(used, size)
+		free.Store(1 << 20)
+		return err
+	}
+
+	err := trySnapshot(manager, false, source)
+	require.ErrorIs(t, err, errInsufficientSpace)
+	assert.Contains(t, err.Error(), "1048576 bytes are free on the disk of the repository, and snapshots leave at least 1048576")
+
+	// The blocks it wrote are removed, those of the other snapshot kept
+	assert.Equal(t, 2, blockFiles(t, manager))
+	assert.Equal(t, int64(2000), manager.blockStore.usedBytes())
+	var snapshots int
+	require.NoError(t, manager.db.Get(&snapshots, "SELECT COUNT(*) FROM snapshots"))
+	assert.Equal(t, 1, snapshots)
+}
+
+func TestStorageUsageWarnings(t *testing.T) {
+	manager, router := startRestoreTest(t)
+	router.GET("/stats", manager.GetStats)
+	router.POST("/snapshots", manager.CreateSnapshot)
+	manager.config.MaxRepoBytes = 1000
+	manager.statDisk = func(string) (diskSpace, error) {
+		return diskSpace{Free: 90 << 20, Total: 100 << 20}, nil
+	}
+	subscription, _ := manager.eventBus.Subscribe([]string{events.StorageWarning}, 0, events.DefaultBuffer)
+	defer subscription.Close()
+	nextWarning := func() events.Storage {
+		select {
+		case event := <-subscription.Events():
+			var storage events.Storage
+			require.NoError(t, json.Unmarshal(event.Data, &storage))
+			return storage
+		case <-time.After(time.Second):
+			t.Fatal("no storage warning")
+			return events.Storage{}
+		}
+	}
+
+	source := t.TempDir()
+	writeFiles(t, source, "a", 500)
+	require.NoError(t, trySnapshot(manager, false, source))
+	manager.checkUsage()
+	assert.Empty(t, subscription.Events())
+
+	writeFiles(t, source, "b", 300)
+	require.NoError(t, trySnapshot(manager, false, source))
+	manager.checkUsage()
+	warning := nextWarning()
+	assert.Equal(t, "warning", warning.Level)
+	assert.Equal(t, float64(80), warning.UsagePercent)
+	assert.Equal(t, int64(800), warning.RepoBytes)
+
+	// Each level is reported once
+	manager.checkUsage()
+	writeFiles(t, source, "c", 150)
+	require.NoError(t, trySnapshot(manager, false, source))
+	manager.checkUsage()
+	warning = nextWarning()
+	assert.Equal(t, "critical", warning.Level)
+	assert.Equal(t, int64(950), warning.RepoBytes)
+	manager.checkUsage()
+	assert.Empty(t, subscription.Events())
+
+	code, response := serve(t, router, http.MethodGet, "/stats", "")
+	require.Equal(t, http.StatusOK, code)
+	storage := response["storage"].(map[string]interface{})
+	assert.Equal(t, float64(950), storage["repo_bytes"])
+	assert.Equal(t, float64(1000), storage["quota_bytes"])
+	assert.Equal(t, float64(95), storage["quota_percent"])
+	assert.Equal(t, float64(90<<20), storage["free_disk_bytes"])
+	assert.Equal(t, "critical", storage["level"])
+
+	// A full repository refuses snapshots right away
+	manager.config.MaxRepoBytes = 900
+	code, response = serve(t, router, http.MethodPost, "/snapshots", `{"plan_id": "plan_test", "paths": ["`+source+`"]}`)
+	assert.Equal(t, http.StatusInsufficientStorage, code)
+	assert.Contains(t, response["error"], "over its quota of 900")
+}
@@ -0,0 +1,415 @@
+package snap
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Restore modes
+const (
+	RestoreModeFull   = "full"   // replaces the target path
+	RestoreModeShadow = "shadow" // restores next to the target path
+)
+
+// shadowTimeFormat timestamps the directories of shadow restores
+const shadowTimeFormat = "20060102-150405"
+
+var (
+	errSnapshotNotFound = errors.New("snapshot not found")
+	errSnapshotNotReady = errors.New("snapshot is not completed")
+)
+
+// restoreStagingPath is where a restore writes before its output is moved
+// into place, so that a failed or cancelled restore leaves nothing behind
+func restoreStagingPath(targetPath, restoreID string) string {
+	return filepath.Join(filepath.Dir(targetPath), "."+filepath.Base(targetPath)+".restore-"+restoreID)
+}
+
+// restoreOutputPath returns where a restore puts the files
+func restoreOutputPath(targetPath, mode string, started time.Time) string {
+	if mode == RestoreModeShadow {
+		return targetPath + ".shadow-" + started.Format(shadowTimeFormat)
+	}
+	return targetPath
+}
+
+// restorePath maps a path in a snapshot to its restored path. The files of a
+// snapshot of a single path are restored in place of it; those of a snapshot
+// of several paths under the base name of each.
+func restorePath(roots []string, output, path string) (string, error) {
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(roots) > 1 {
+			rel = filepath.Join(filepath.Base(root), rel)
+		}
+		return filepath.Join(output, rel), nil
+	}
+	return "", fmt.Errorf("not under any of the snapshot paths")
+}
+
+// loadManifest reads the manifest of a completed snapshot
+func (sm *SnapManager) loadManifest(snapshotID string) (*SnapshotManifest, error) {
+	manifestPath, err := sm.snapshotManifestPath(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	return readManifest(manifestPath)
+}
+
+// newRestoreJob creates the job restoring a snapshot
+func newRestoreJob(snapshotID, targetPath, mode string, manifest *SnapshotManifest) *RestoreJob {
+	job := &RestoreJob{
+		ID:          newID("restore"),
+		SnapshotID:  snapshotID,
+		TargetPath:  filepath.Clean(targetPath),
+		RestoreMode: mode,
+		Status:      RestoreStatusPending,
+		Message:     "Restore pending",
+		TotalFiles:  len(manifest.Files),
+		Started:     time.Now(),
+	}
+	job.OutputPath = restoreOutputPath(job.TargetPath, mode, job.Started)
+	for _, entry := range manifest.Files {
+		if !entry.IsDir && os.FileMode(entry.Mode)&os.ModeSymlink == 0 {
+			job.TotalBytes += entry.Size
+		}
+	}
+	return job
+}
+
+// runRestore restores a snapshot into the staging path and moves the result
+// into place. Files that fail to restore are recorded and fail the restore
+// once the others are done; a failed or cancelled restore removes its
+// partial output.
+func (sm *SnapManager) runRestore(task *Task, job *RestoreJob, manifest *SnapshotManifest) {
+	defer sm.unregisterTask(task)
+
+	reporter := newProgressReporter(task)
+	reporter.state.TotalFiles = job.TotalFiles
+	reporter.state.TotalBytes = job.TotalBytes
+	reporter.state.Message = "Restoring files..."
+	reporter.report(true)
+	sm.updateRestoreJob(job, reporter.state, RestoreStatusRunning)
+
+	staging := restoreStagingPath(job.TargetPath, job.ID)
+	fileErrors, err := sm.restoreFiles(task.ctx, manifest, staging, reporter)
+	if err == nil && len(fileErrors) > 0 {
+		err = fmt.Errorf("failed to restore %d of %d files", len(fileErrors), job.TotalFiles)
+	}
+	if err == nil {
+		err = moveRestore(staging, job)
+	}
+	if err != nil {
+		if removeErr := os.RemoveAll(staging); removeErr != nil {
+			log.Printf("Failed to remove partial restore %s: %v", staging, removeErr)
+		}
+	}
+
+	job.Errors = fileErrors
+	reporter.state.CurrentPath = ""
+	status := RestoreStatusCompleted
+	switch {
+	case errors.Is(err, context.Canceled):
+		status = RestoreStatusCancelled
+		reporter.state.Message = "Restore cancelled"
+	case err != nil:
+		status = RestoreStatusFailed
+		reporter.state.Message = err.Error()
+	default:
+		reporter.state.Progress = 100.0
+		reporter.state.Message = fmt.Sprintf("Restored %d files to %s", reporter.state.FilesProcessed, job.OutputPath)
+	}
+	reporter.state.Status = status
+
+	// The job is saved before the final progress is published, so that
+	// whoever waits for the task finds the outcome stored
+	sm.updateRestoreJob(job, reporter.state, status)
+	reporter.report(true)
+}
+
+// restoreFiles restores the entries of a manifest under output. It returns
+// the errors of the files that failed, and an error if the restore stopped.
+func (sm *SnapManager) restoreFiles(ctx context.Context, manifest *SnapshotManifest, output string, reporter *progressReporter) ([]string, error) {
+	var fileErrors []string
+	var dirs []FileEntry
+	var dirPaths []string
+
+	for _, entry := range manifest.Files {
+		if err := ctx.Err(); err != nil {
+			return fileErrors, err
+		}
+
+		reporter.state.CurrentPath = entry.Path
+		dest, err := restorePath(manifest.Paths, output, entry.Path)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(dest), 0755)
+		}
+		if err == nil {
+			switch mode := os.FileMode(entry.Mode); {
+			case entry.IsDir:
+				// Written with the mode of the snapshot once its contents are
+				// restored
+				err = os.MkdirAll(dest, 0700)
+				dirs = append(dirs, entry)
+				dirPaths = append(dirPaths, dest)
+			case mode&os.ModeSymlink != 0:
+				err = os.Symlink(entry.Target, dest)
+			default:
+				err = sm.restoreFile(ctx, entry, dest, reporter)
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return fileErrors, ctx.Err()
+			}
+			fileErrors = append(fileErrors, fmt.Sprintf("%s: %v", entry.Path, err))
+			reporter.state.Errors++
+		} else {
+			reporter.state.FilesProcessed++
+		}
+
+		reporter.state.Progress = restoreProgress(reporter.state)
+		reporter.state.Message = fmt.Sprintf("Restored %d/%d files", reporter.state.FilesProcessed, reporter.state.TotalFiles)
+		reporter.report(false)
+	}
+	if len(fileErrors) > 0 {
+		return fileErrors, nil
+	}
+
+	// Restoring the contents of a directory changes its modification time,
+	// so directories are finished deepest first at the end
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirPaths[i], restoreMode(dirs[i].Mode)); err != nil {
+			return fileErrors, fmt.Errorf("failed to set mode of %s: %w", dirs[i].Path, err)
+		}
+		if err := os.Chtimes(dirPaths[i], dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return fileErrors, fmt.Errorf("failed to set times of %s: %w", dirs[i].Path, err)
+		}
+	}
+	return fileErrors, nil
+}
+
+// restoreFile reconstructs a regular file from its blocks, verifying each
+// block and the file's checksum, and restores its mode and times
+func (sm *SnapManager) restoreFile(ctx context.Context, entry FileEntry, dest string, reporter *progressReporter) error {
+	file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	for _, hash := range entry.Blocks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, err := sm.blockStore.readBlock(hash)
+		if err != nil {
+			return fmt.Errorf("block %s: %w", hash, err)
+		}
+		if _, err := file.Write(data); err != nil {
+			return err
+		}
+		hasher.Write(data)
+
+		reporter.state.BytesRead += int64(len(data))
+		reporter.state.BytesWritten += int64(len(data))
+		reporter.state.Progress = restoreProgress(reporter.state)
+		reporter.report(false)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if checksum := hex.EncodeToString(hasher.Sum(nil)); entry.Checksum != "" && checksum != entry.Checksum {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", entry.Checksum, checksum)
+	}
+	if err := os.Chmod(dest, restoreMode(entry.Mode)); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, entry.ModTime, entry.ModTime)
+}
+
+// restoreMode returns the permission bits of a mode recorded in a manifest
+func restoreMode(mode uint32) os.FileMode {
+	return os.FileMode(mode) & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+}
+
+// restoreProgress returns the percentage of a restore done, by bytes if
+// there are any and by files otherwise
+func restoreProgress(p TaskProgress) float64 {
+	if p.TotalBytes > 0 {
+		return float64(p.BytesWritten) / float64(p.TotalBytes) * 100.0
+	}
+	if p.TotalFiles > 0 {
+		return float64(p.FilesProcessed+p.Errors) / float64(p.TotalFiles) * 100.0
+	}
+	return 100.0
+}
+
+// moveRestore moves a finished restore from staging to its output path. A
+// full restore replaces the target, which is only removed once the restored
+// files are in its place.
+func moveRestore(staging string, job *RestoreJob) error {
+	if _, err := os.Lstat(staging); os.IsNotExist(err) {
+		// An empty snapshot restores an empty directory
+		if err := os.MkdirAll(staging, 0755); err != nil {
+			return err
+		}
+	}
+
+	if job.RestoreMode == RestoreModeShadow {
+		if _, err := os.Lstat(job.OutputPath); err == nil {
+			return fmt.Errorf("shadow path %s already exists", job.OutputPath)
+		}
+		return os.Rename(staging, job.OutputPath)
+	}
+
+	previous := staging + ".previous"
+	if _, err := os.Lstat(job.TargetPath); err == nil {
+		if err := os.Rename(job.TargetPath, previous); err != nil {
+			return fmt.Errorf("failed to move aside %s: %w", job.TargetPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	} else {
+		previous = ""
+	}
+
+	if err := os.Rename(staging, job.TargetPath); err != nil {
+		if previous != "" {
+			if restoreErr := os.Rename(previous, job.TargetPath); restoreErr != nil {
+				log.Printf("Failed to put back %s from %s: %v", job.TargetPath, previous, restoreErr)
+			}
+		}
+		return fmt.Errorf("failed to move restore into %s: %w", job.TargetPath, err)
+	}
+	if previous != "" {
+		if err := os.RemoveAll(previous); err != nil {
+			log.Printf("Failed to remove replaced %s: %v", previous, err)
+		}
+	}
+	return nil
+}
+
+// insertRestoreJob records a new restore job
+func (sm *SnapManager) insertRestoreJob(job *RestoreJob) error {
+	_, err := sm.db.Exec(`
+		INSERT INTO restore_jobs (id, snapshot_id, target_path, output_path, restore_mode, status, message, total_files, total_bytes, started_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, job.ID, job.SnapshotID, job.TargetPath, job.OutputPath, job.RestoreMode, job.Status, job.Message, job.TotalFiles, job.TotalBytes, job.Started)
+	if err != nil {
+		return fmt.Errorf("failed to save restore job: %w", err)
+	}
+	return nil
+}
+
+// updateRestoreJob records the progress of a restore job
+func (sm *SnapManager) updateRestoreJob(job *RestoreJob, progress TaskProgress, status string) {
+	job.Status = status
+	job.Progress = progress.Progress
+	job.Message = progress.Message
+	job.FilesRestored = progress.FilesProcessed
+	job.BytesRestored = progress.BytesWritten
+	var completed interface{}
+	if status != RestoreStatusPending && status != RestoreStatusRunning {
+		job.Completed = time.Now()
+		completed = job.Completed
+	}
+
+	errorsJSON, _ := json.Marshal(job.Errors)
+	if job.Errors == nil {
+		errorsJSON = []byte("[]")
+	}
+	_, err := sm.db.Exec(`
+		UPDATE restore_jobs
+		SET status = ?, progress = ?, message = ?, files_restored = ?, bytes_restored = ?, errors = ?, completed_at = ?
+		WHERE id = ?
+	`, job.Status, job.Progress, job.Message, job.FilesRestored, job.BytesRestored, string(errorsJSON), completed, job.ID)
+	if err != nil {
+		log.Printf("Failed to save restore job %s: %v", job.ID, err)
+	}
+}
+
+// getRestoreJob loads a restore job
+func (sm *SnapManager) getRestoreJob(id string) (*RestoreJob, error) {
+	var job RestoreJob
+	var errorsJSON string
+	var completed sql.NullTime
+	err := sm.db.QueryRow(`
+		SELECT id, snapshot_id, target_path, output_path, restore_mode, status, progress, message,
+			total_files, files_restored, total_bytes, bytes_restored, errors, started_at, completed_at
+		FROM restore_jobs WHERE id = ?
+	`, id).Scan(&job.ID, &job.SnapshotID, &job.TargetPath, &job.OutputPath, &job.RestoreMode, &job.Status, &job.Progress, &job.Message,
+		&job.TotalFiles, &job.FilesRestored, &job.TotalBytes, &job.BytesRestored, &errorsJSON, &job.Started, &completed)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(errorsJSON), &job.Errors); err != nil {
+		log.Printf("Failed to unmarshal restore errors JSON: %v", err)
+	}
+	if completed.Valid {
+		job.Completed = completed.Time
+	}
+	return &job, nil
+}
+
+// restoreInProgress reports whether a restore onto a target path is pending
+// or running
+func (sm *SnapManager) restoreInProgress(targetPath string) (bool, error) {
+	var count int
+	err := sm.db.QueryRow(`
+		SELECT COUNT(*) FROM restore_jobs WHERE target_path = ? AND status IN (?, ?)
+	`, targetPath, RestoreStatusPending, RestoreStatusRunning).Scan(&count)
+	return count > 0, err
+}
+
+// failInterruptedRestores fails the restores a previous run of the service
+// did not finish, removing their partial output
+func (sm *SnapManager) failInterruptedRestores() {
+	if sm.db == nil || sm.db.DB == nil {
+		return
+	}
+
+	rows, err := sm.db.Query(`
+		SELECT id, target_path FROM restore_jobs WHERE status IN (?, ?)
+	`, RestoreStatusPending, RestoreStatusRunning)
+	if err != nil {
+		log.Printf("Failed to query interrupted restores: %v", err)
+		return
+	}
+	var interrupted [][2]string
+	for rows.Next() {
+		var id, targetPath string
+		if err := rows.Scan(&id, &targetPath); err == nil {
+			interrupted = append(interrupted, [2]string{id, targetPath})
+		}
+	}
+	rows.Close()
+
+	for _, job := range interrupted {
+		if err := os.RemoveAll(restoreStagingPath(job[1], job[0])); err != nil {
+			log.Printf("Failed to remove partial restore %s: %v", job[0], err)
+		}
+		_, err := sm.db.Exec(`
+			UPDATE restore_jobs SET status = ?, message = ?, completed_at = ? WHERE id = ?
+		`, RestoreStatusFailed, "Interrupted by a restart of the snap service", time.Now(), job[0])
+		if err != nil {
+			log.Printf("Failed to fail interrupted restore %s: %v", job[0], err)
+		}
+	}
+}
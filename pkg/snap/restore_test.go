@@ -0,0 +1,301 @@
+package snap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startRestoreTest creates a snap manager with a plan to snapshot, and a
+// router serving its restore API
+func startRestoreTest(t *testing.T) (*SnapManager, *gin.Engine) {
+	manager := newTestManager(t)
+	_, err := manager.db.Exec(`INSERT INTO snap_plans (id, name, cron_expression, paths) VALUES ('plan_test', 'test', '@daily', '[]')`)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.POST("/restore", manager.RestoreSnapshot)
+	router.GET("/restore/:id/status", manager.GetRestoreStatus)
+	router.POST("/restore/:id/cancel", manager.CancelRestore)
+	return manager, router
+}
+
+// writeTree creates a directory tree with files of several sizes, modes
+// and times, a symlink and an empty file
+func writeTree(t *testing.T, root string) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	big := make([]byte, BlockSize+100)
+	for i := range big {
+		big[i] = byte(i % 251)
+	}
+
+	files := []struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}{
+		{"a.txt", []byte("hello"), 0644},
+		{"bin/run.sh", []byte("#!/bin/sh\necho run\n"), 0755},
+		{"empty", nil, 0600},
+		{"big.bin", big, 0640},
+		{"nested/deep/file", []byte("deep"), 0640},
+	}
+	for _, file := range files {
+		path := filepath.Join(root, file.path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, file.data, file.mode))
+		require.NoError(t, os.Chmod(path, file.mode))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	require.NoError(t, os.Symlink("a.txt", filepath.Join(root, "link")))
+	require.NoError(t, os.Chmod(filepath.Join(root, "bin"), 0750))
+	require.NoError(t, os.Chtimes(filepath.Join(root, "nested", "deep"), mtime, mtime))
+}
+
+// treeState describes every entry under root: its type, mode, and for
+// files their modification time and content hash
+func treeState(t *testing.T, root string) map[string]string {
+	state := make(map[string]string)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			require.NoError(t, err)
+			state[rel] = "link " + target
+		case info.IsDir():
+			state[rel] = fmt.Sprintf("dir %v", info.Mode())
+			if rel == filepath.Join("nested", "deep") {
+				state[rel] += " " + info.ModTime().UTC().String()
+			}
+		default:
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			sum := sha256.Sum256(data)
+			state[rel] = fmt.Sprintf("file %v %s %s", info.Mode(), info.ModTime().UTC(), hex.EncodeToString(sum[:]))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return state
+}
+
+// takeSnapshot snapshots paths and returns the snapshot ID
+func takeSnapshot(t *testing.T, manager *SnapManager, paths ...string) string {
+	snapshotID, _ := snapshotProgress(t, manager, false, paths...)
+	return snapshotID
+}
+
+// mutateTree changes, removes and adds files
+func mutateTree(t *testing.T, root string) {
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("changed"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(root, "nested", "deep", "file")))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "new.txt"), []byte("new"), 0644))
+	require.NoError(t, os.Chmod(filepath.Join(root, "bin", "run.sh"), 0600))
+}
+
+// startRestore requests a restore and returns its ID
+func startRestore(t *testing.T, router *gin.Engine, body string) (string, map[string]interface{}) {
+	code, response := serve(t, router, http.MethodPost, "/restore", body)
+	require.Equal(t, http.StatusAccepted, code, response)
+	return response["id"].(string), response
+}
+
+// waitForRestore waits until a restore has a status and returns it
+func waitForRestore(t *testing.T, router *gin.Engine, id, status string) map[string]interface{} {
+	var response map[string]interface{}
+	require.Eventually(t, func() bool {
+		_, response = serve(t, router, http.MethodGet, "/restore/"+id+"/status", "")
+		return response["status"] == status
+	}, 10*time.Second, 5*time.Millisecond, "restore %s did not become %s", id, status)
+	return response
+}
+
+// entries lists the names in a directory
+func entries(t *testing.T, dir string) []string {
+	list, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range list {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestRestoreRoundTrip(t *testing.T) {
+	manager, router := startRestoreTest(t)
+	parent := t.TempDir()
+	source := filepath.Join(parent, "data")
+	writeTree(t, source)
+	expected := treeState(t, source)
+
+	snapshotID := takeSnapshot(t, manager, source)
+	mutateTree(t, source)
+	require.NotEqual(t, expected, treeState(t, source))
+
+	// A full restore replaces the target with the snapshot
+	id, response := startRestore(t, router, fmt.Sprintf(`{"snapshot_id": %q, "target_path": %q}`, snapshotID, source))
+	assert.Equal(t, RestoreModeFull, response["restore_mode"])
+	assert.Equal(t, source, response["output_path"])
+
+	status := waitForRestore(t, router, id, RestoreStatusCompleted)
+	assert.Equal(t, expected, treeState(t, source))
+	assert.Equal(t, 100.0, status["progress"])
+	assert.Equal(t, status["total_files"], status["files_restored"])
+	assert.Equal(t, float64(BlockSize+100+5+19+4), status["total_bytes"])
+	assert.Equal(t, status["total_bytes"], status["bytes_restored"])
+	assert.NotContains(t, status, "errors")
+	assert.Equal(t, []string{"data"}, entries(t, parent))
+
+	// A shadow restore leaves the target alone
+	mutateTree(t, source)
+	mutated := treeState(t, source)
+	id, response = startRestore(t, router, fmt.Sprintf(`{"snapshot_id": %q, "target_path": %q, "restore_mode": "shadow"}`, snapshotID, source))
+	shadow := response["output_path"].(string)
+	assert.Regexp(t, `/data\.shadow-\d{8}-\d{6}$`, shadow)
+
+	waitForRestore(t, router, id, RestoreStatusCompleted)
+	assert.Equal(t, expected, treeState(t, shadow))
+	assert.Equal(t, mutated, treeState(t, source))
+	assert.ElementsMatch(t, []string{"data", filepath.Base(shadow)}, entries(t, parent))
+}
+
+func TestRestoreSeveralPaths(t *testing.T) {
+	manager, router := startRestoreTest(t)
+	first := filepath.Join(t.TempDir(), "first")
+	second := filepath.Join(t.TempDir(), "second")
+	writeTree(t, first)
+	require.NoError(t, os.MkdirAll(second, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(second, "b.txt"), []byte("b"), 0644))
+
+	snapshotID := takeSnapshot(t, manager, first, second)
+	target := filepath.Join(t.TempDir(), "restored")
+	id, _ := startRestore(t, router, fmt.Sprintf(`{"snapshot_id": %q, "target_path": %q}`, snapshotID, target))
+	waitForRestore(t, router, id, RestoreStatusCompleted)
+
+	// Each path is restored under its base name
+	assert.Equal(t, []string{"first", "second"}, entries(t, target))
+	assert.Equal(t, treeState(t, first), treeState(t, filepath.Join(target, "first")))
+	data, err := os.ReadFile(filepath.Join(target, "second", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(data))
+}
+
+func TestRestoreCorruptBlock(t *testing.T) {
+	manager, router := startRestoreTest(t)
+	parent := t.TempDir()
+	source := filepath.Join(parent, "data")
+	writeTree(t, source)
+
+	snapshotID := takeSnapshot(t, manager, source)
+	mutateTree(t, source)
+	mutated := treeState(t, source)
+
+	sum := sha256.Sum256([]byte("hello"))
+	hash := hex.EncodeToString(sum[:])
+	require.NoError(t, os.WriteFile(manager.blockStore.blockIndex[hash], []byte("corrupt"), 0644))
+
+	// The other files are restored, but the failed one fails the restore,
+	// which leaves the target as it was
+	id, _ := startRestore(t, router, fmt.Sprintf(`{"snapshot_id": %q, "target_path": %q}`, snapshotID, source))
+	status := waitForRestore(t, router, id, RestoreStatusFailed)
+	assert.Equal(t, "failed to restore 1 of 10 files", status["message"])
+	require.Len(t, status["errors"], 1)
+	assert.Contains(t, status["errors"].([]interface{})[0], filepath.Join(source, "a.txt")+": block "+hash+": hash mismatch")
+	assert.Equal(t, float64(9), status["files_restored"])
+
+	assert.Equal(t, mutated, treeState(t, source))
+	assert.Equal(t, []string{"data"}, entries(t, parent))
+}
+
+func TestCancelRestore(t *testing.T) {
+	manager, router := startRestoreTest(t)
+	parent := t.TempDir()
+	source := filepath.Join(parent, "data")
+	writeTree(t, source)
+
+	snapshotID := takeSnapshot(t, manager, source)
+	mutateTree(t, source)
+	mutated := treeState(t, source)
+
+	// Holding the block store stops the restore at its first block, once
+	// the target directory is restored
+	manager.blockStore.mutex.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			manager.blockStore.mutex.Unlock()
+		}
+	}()
+
+	id, _ := startRestore(t, router, fmt.Sprintf(`{"snapshot_id": %q, "target_path": %q}`, snapshotID, source))
+	staging := restoreStagingPath(source, id)
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(staging)
+		return err == nil
+	}, 5*time.Second, time.Millisecond)
+
+	cancelled := make(chan map[string]interface{})
+	go func() {
+		code, response := serve(t, router, http.MethodPost, "/restore/"+id+"/cancel", "")
+		assert.Equal(t, http.StatusOK, code)
+		cancelled <- response
+	}()
+
+	manager.taskMutex.RLock()
+	task := manager.runningTasks[id]
+	manager.taskMutex.RUnlock()
+	require.NotNil(t, task)
+	require.Eventually(t, func() bool { return task.ctx.Err() != nil }, 5*time.Second, time.Millisecond)
+	manager.blockStore.mutex.Unlock()
+	locked = false
+
+	response := <-cancelled
+	assert.Equal(t, RestoreStatusCancelled, response["status"])
+	assert.Equal(t, "Restore cancelled", response["message"])
+
+	assert.Equal(t, mutated, treeState(t, source))
+	assert.Equal(t, []string{"data"}, entries(t, parent))
+
+	code, response := serve(t, router, http.MethodPost, "/restore/"+id+"/cancel", "")
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "Restore is already cancelled", response["error"])
+}
+
+func TestRestoreInvalidRequests(t *testing.T) {
+	manager, router := startRestoreTest(t)
+	source := filepath.Join(t.TempDir(), "data")
+	writeTree(t, source)
+	snapshotID := takeSnapshot(t, manager, source)
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{fmt.Sprintf(`{"snapshot_id": %q, "target_path": %q, "restore_mode": "merge"}`, snapshotID, source), http.StatusBadRequest},
+		{fmt.Sprintf(`{"snapshot_id": %q, "target_path": "data"}`, snapshotID), http.StatusBadRequest},
+		{fmt.Sprintf(`{"snapshot_id": "snap_missing", "target_path": %q}`, source), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		code, response := serve(t, router, http.MethodPost, "/restore", tt.body)
+		assert.Equal(t, tt.code, code, tt.body)
+		assert.NotEmpty(t, response["error"])
+	}
+
+	code, _ := serve(t, router, http.MethodGet, "/restore/restore_missing/status", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = serve(t, router, http.MethodPost, "/restore/restore_missing/cancel", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,272 @@
+package snap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Plan run statuses
+const (
+	RunStatusTriggered = "triggered"
+	RunStatusSkipped   = "skipped"
+)
+
+// Clock tells the plan scheduler the time, so tests can control it
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// PlanRun is a scheduled run of a plan, triggered or skipped
+type PlanRun struct {
+	ID          int64     `json:"id"`
+	PlanID      string    `json:"plan_id"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	Status      string    `json:"status"`
+	SnapshotID  string    `json:"snapshot_id,omitempty"`
+}
+
+// scheduledPlan is an enabled plan and when it runs next
+type scheduledPlan struct {
+	id       string
+	cronExpr string
+	schedule *CronSchedule
+	paths    []string
+	next     time.Time
+}
+
+// planScheduler keeps the schedule of the enabled plans
+type planScheduler struct {
+	clock   Clock
+	run     func(snapshotID, planID string, paths []string) // takes a scheduled snapshot
+	mutex   sync.Mutex
+	plans   map[string]*scheduledPlan
+	running map[string]bool // plans whose scheduled snapshot is in progress
+	wake    chan struct{}   // signalled when the plans change
+}
+
+// newPlanScheduler creates a scheduler that takes snapshots with run
+func newPlanScheduler(run func(snapshotID, planID string, paths []string)) *planScheduler {
+	return &planScheduler{
+		clock:   realClock{},
+		run:     run,
+		plans:   make(map[string]*scheduledPlan),
+		running: make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// update replaces the scheduled plans. Plans whose cron expression did not
+// change keep their next run time.
+func (s *planScheduler) update(plans []*scheduledPlan) {
+	now := s.clock.Now()
+
+	s.mutex.Lock()
+	scheduled := make(map[string]*scheduledPlan, len(plans))
+	for _, plan := range plans {
+		if current, exists := s.plans[plan.id]; exists && current.cronExpr == plan.cronExpr {
+			plan.next = current.next
+		} else {
+			plan.next = plan.schedule.Next(now)
+		}
+		scheduled[plan.id] = plan
+	}
+	s.plans = scheduled
+	s.mutex.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// timer returns a channel that fires when the next plan is due, or nil if
+// no plan is scheduled
+func (s *planScheduler) timer() <-chan time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var earliest time.Time
+	for _, plan := range s.plans {
+		if !plan.next.IsZero() && (earliest.IsZero() || plan.next.Before(earliest)) {
+			earliest = plan.next
+		}
+	}
+	if earliest.IsZero() {
+		return nil
+	}
+	return s.clock.After(max(earliest.Sub(s.clock.Now()), 0))
+}
+
+// nextRun returns when a plan runs next, if it is scheduled
+func (s *planScheduler) nextRun(planID string) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	plan, exists := s.plans[planID]
+	if !exists || plan.next.IsZero() {
+		return time.Time{}, false
+	}
+	return plan.next, true
+}
+
+// due starts the snapshots of the plans that are due and schedules their
+// next runs. A plan whose previous snapshot is still in progress skips the
+// run. It returns the runs.
+func (s *planScheduler) due() []PlanRun {
+	now := s.clock.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var runs []PlanRun
+	for _, plan := range s.plans {
+		if plan.next.IsZero() || plan.next.After(now) {
+			continue
+		}
+		run := PlanRun{PlanID: plan.id, ScheduledAt: plan.next, Status: RunStatusSkipped}
+		plan.next = plan.schedule.Next(now)
+
+		if !s.running[plan.id] {
+			run.Status = RunStatusTriggered
+			run.SnapshotID = newID("snap")
+			s.running[plan.id] = true
+			go func(plan *scheduledPlan, snapshotID string) {
+				defer func() {
+					s.mutex.Lock()
+					delete(s.running, plan.id)
+					s.mutex.Unlock()
+				}()
+				s.run(snapshotID, plan.id, plan.paths)
+			}(plan, run.SnapshotID)
+		}
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// scheduleRunner takes the snapshots of enabled plans as their cron
+// expressions say, until ctx is done or the manager stops
+func (sm *SnapManager) scheduleRunner(ctx context.Context) {
+	sm.reschedule()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sm.ctx.Done():
+			return
+		case <-sm.scheduler.wake:
+		case <-sm.scheduler.timer():
+			for _, run := range sm.scheduler.due() {
+				if run.Status == RunStatusSkipped {
+					log.Printf("⏭️  Skipped scheduled snapshot of plan %s, the previous one is still in progress", run.PlanID)
+				}
+				if err := sm.recordPlanRun(run); err != nil {
+					log.Printf("Failed to record run of plan %s: %v", run.PlanID, err)
+				}
+			}
+		}
+	}
+}
+
+// reschedule reloads the enabled plans into the scheduler, so plan changes
+// take effect without a restart
+func (sm *SnapManager) reschedule() {
+	plans, err := sm.enabledPlans()
+	if err != nil {
+		log.Printf("Failed to load snapshot plans: %v", err)
+		return
+	}
+	sm.scheduler.update(plans)
+}
+
+// enabledPlans loads the enabled plans. Plans with an invalid cron
+// expression, which older releases accepted, are left out.
+func (sm *SnapManager) enabledPlans() ([]*scheduledPlan, error) {
+	if sm.db == nil || sm.db.DB == nil {
+		return nil, nil
+	}
+
+	rows, err := sm.db.Query(`
+		SELECT id, cron_expression, paths
+		FROM snap_plans
+		WHERE enabled = 1
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plans: %w", err)
+	}
+	defer rows.Close()
+
+	var plans []*scheduledPlan
+	for rows.Next() {
+		var id, cronExpr, pathsJSON string
+		if err := rows.Scan(&id, &cronExpr, &pathsJSON); err != nil {
+			return nil, fmt.Errorf("failed to scan plan: %w", err)
+		}
+
+		schedule, err := ParseCron(cronExpr)
+		if err != nil {
+			log.Printf("Not scheduling plan %s: %v", id, err)
+			continue
+		}
+		var paths []string
+		if err := json.Unmarshal([]byte(pathsJSON), &paths); err != nil {
+			log.Printf("Failed to unmarshal paths JSON: %v", err)
+		}
+		plans = append(plans, &scheduledPlan{id: id, cronExpr: cronExpr, schedule: schedule, paths: paths})
+	}
+	return plans, rows.Err()
+}
+
+// recordPlanRun records a scheduled run of a plan
+func (sm *SnapManager) recordPlanRun(run PlanRun) error {
+	var snapshotID interface{}
+	if run.SnapshotID != "" {
+		snapshotID = run.SnapshotID
+	}
+
+	_, err := sm.db.Exec(`
+		INSERT INTO snap_plan_runs (plan_id, scheduled_at, status, snapshot_id)
+		VALUES (?, ?, ?, ?)
+	`, run.PlanID, run.ScheduledAt, run.Status, snapshotID)
+	if err != nil {
+		return fmt.Errorf("failed to save plan run: %w", err)
+	}
+	return nil
+}
+
+// planRuns returns the latest scheduled runs of a plan, newest first
+func (sm *SnapManager) planRuns(planID string, limit int) ([]PlanRun, error) {
+	rows, err := sm.db.Query(`
+		SELECT id, plan_id, scheduled_at, status, COALESCE(snapshot_id, '')
+		FROM snap_plan_runs
+		WHERE plan_id = ?
+		ORDER BY scheduled_at DESC, id DESC
+		LIMIT ?
+	`, planID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plan runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []PlanRun{}
+	for rows.Next() {
+		var run PlanRun
+		if err := rows.Scan(&run.ID, &run.PlanID, &run.ScheduledAt, &run.Status, &run.SnapshotID); err != nil {
+			return nil, fmt.Errorf("failed to scan plan run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
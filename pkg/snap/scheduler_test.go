@@ -0,0 +1,342 @@
+package snap
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/last-emo-boy/infra-core/pkg/api/middleware"
+	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
+)
+
+// fakeClock is a clock that only moves when advanced
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+	f.timers = append(f.timers, fakeTimer{at: f.now.Add(d), c: c})
+	return c
+}
+
+// Advance moves the clock forward, firing the timers that are due
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+	pending := f.timers[:0]
+	for _, timer := range f.timers {
+		if timer.at.After(f.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.c <- f.now
+		}
+	}
+	f.timers = pending
+}
+
+// waitForTimer waits until something waits on the clock for a time
+func (f *fakeClock) waitForTimer(t *testing.T, at time.Time) {
+	require.Eventually(t, func() bool {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		for _, timer := range f.timers {
+			if timer.at.Equal(at) {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, time.Millisecond)
+}
+
+// scheduledRun is a snapshot started by the scheduler, which finishes once
+// release is closed
+type scheduledRun struct {
+	snapshotID string
+	planID     string
+	release    chan struct{}
+}
+
+// newTestManager creates a snap manager on an in-memory database
+func newTestManager(t testing.TB) *SnapManager {
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.NewDB(&config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: ":memory:"},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	// Every connection to :memory: is a database of its own, so tasks and
+	// requests querying at once must share the one holding the schema
+	db.DB.SetMaxOpenConns(1)
+
+	manager, err := NewSnapManager(db.DB, config.SnapConfig{RepoDir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(manager.Stop)
+	return manager
+}
+
+// startSchedulerTest starts a snap manager whose scheduler runs on a fake
+// clock and reports snapshots instead of taking them
+func startSchedulerTest(t *testing.T, now time.Time) (*SnapManager, *fakeClock, *gin.Engine, chan scheduledRun) {
+	manager := newTestManager(t)
+
+	clock := &fakeClock{now: now}
+	runs := make(chan scheduledRun, 10)
+	manager.scheduler.clock = clock
+	manager.scheduler.run = func(snapshotID, planID string, paths []string) {
+		run := scheduledRun{snapshotID: snapshotID, planID: planID, release: make(chan struct{})}
+		runs <- run
+		select {
+		case <-run.release:
+		case <-manager.ctx.Done():
+		}
+	}
+	manager.Start(context.Background())
+
+	router := gin.New()
+	router.POST("/plans", manager.CreatePlan)
+	router.GET("/plans/:id", manager.GetPlan)
+	router.PUT("/plans/:id", manager.UpdatePlan)
+	router.DELETE("/plans/:id", manager.DeletePlan)
+	router.POST("/plans/:id/enable", manager.EnablePlan)
+	router.POST("/plans/:id/disable", manager.DisablePlan)
+	router.GET("/plans/:id/runs", manager.ListPlanRuns)
+	return manager, clock, router, runs
+}
+
+// serve sends a request to the router and decodes the response
+func serve(t *testing.T, router *gin.Engine, method, path, body string) (int, map[string]interface{}) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w.Code, response
+}
+
+// nextRun returns the next run of a plan reported by the API, if any
+func nextRun(t *testing.T, router *gin.Engine, planID string) string {
+	code, response := serve(t, router, http.MethodGet, "/plans/"+planID, "")
+	require.Equal(t, http.StatusOK, code)
+	next, _ := response["next_run"].(string)
+	return next
+}
+
+func TestScheduledPlanRuns(t *testing.T) {
+	start := time.Date(2026, 3, 1, 10, 2, 30, 0, time.UTC)
+	manager, clock, router, runs := startSchedulerTest(t, start)
+
+	code, response := serve(t, router, http.MethodPost, "/plans",
+		`{"name": "every5", "cron_expr": "*/5 * * * *", "paths": ["/data"], "enabled": true}`)
+	require.Equal(t, http.StatusCreated, code, response)
+	planID := response["id"].(string)
+	assert.Equal(t, "2026-03-01T10:05:00Z", nextRun(t, router, planID))
+
+	// Nothing runs before the scheduled time
+	at := time.Date(2026, 3, 1, 10, 5, 0, 0, time.UTC)
+	clock.waitForTimer(t, at)
+	clock.Advance(at.Sub(start) - time.Second)
+	assert.Empty(t, runs)
+
+	clock.Advance(time.Second)
+	first := <-runs
+	assert.Equal(t, planID, first.planID)
+	assert.Equal(t, "2026-03-01T10:10:00Z", nextRun(t, router, planID))
+
+	// The first run is still in progress at the next scheduled time, so that
+	// run is skipped
+	clock.waitForTimer(t, at.Add(5*time.Minute))
+	clock.Advance(5 * time.Minute)
+	require.Eventually(t, func() bool {
+		_, response := serve(t, router, http.MethodGet, "/plans/"+planID+"/runs", "")
+		return response["total"] == float64(2)
+	}, 5*time.Second, time.Millisecond)
+	assert.Empty(t, runs)
+
+	close(first.release)
+	require.Eventually(t, func() bool {
+		manager.scheduler.mutex.Lock()
+		defer manager.scheduler.mutex.Unlock()
+		return !manager.scheduler.running[planID]
+	}, 5*time.Second, time.Millisecond)
+
+	clock.waitForTimer(t, at.Add(10*time.Minute))
+	clock.Advance(5 * time.Minute)
+	third := <-runs
+	close(third.release)
+
+	code, response = serve(t, router, http.MethodGet, "/plans/"+planID+"/runs", "")
+	require.Equal(t, http.StatusOK, code)
+	var recorded []PlanRun
+	data, _ := json.Marshal(response["runs"])
+	require.NoError(t, json.Unmarshal(data, &recorded))
+	require.Len(t, recorded, 3)
+
+	for i, want := range []struct {
+		at         time.Time
+		status     string
+		snapshotID string
+	}{
+		{at.Add(10 * time.Minute), RunStatusTriggered, third.snapshotID},
+		{at.Add(5 * time.Minute), RunStatusSkipped, ""},
+		{at, RunStatusTriggered, first.snapshotID},
+	} {
+		assert.True(t, want.at.Equal(recorded[i].ScheduledAt), "run %d scheduled at %s", i, recorded[i].ScheduledAt)
+		assert.Equal(t, want.status, recorded[i].Status)
+		assert.Equal(t, want.snapshotID, recorded[i].SnapshotID)
+	}
+}
+
+func TestPlanChangesReschedule(t *testing.T) {
+	start := time.Date(2026, 3, 1, 10, 2, 30, 0, time.UTC)
+	manager, clock, router, runs := startSchedulerTest(t, start)
+
+	code, response := serve(t, router, http.MethodPost, "/plans",
+		`{"name": "hourly", "cron_expr": "@hourly", "paths": ["/data"], "enabled": true}`)
+	require.Equal(t, http.StatusCreated, code, response)
+	planID := response["id"].(string)
+	assert.Equal(t, "2026-03-01T11:00:00Z", nextRun(t, router, planID))
+
+	code, _ = serve(t, router, http.MethodPut, "/plans/"+planID, `{"cron_expr": "30 10 * * *"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2026-03-01T10:30:00Z", nextRun(t, router, planID))
+	clock.waitForTimer(t, time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC))
+
+	// A disabled plan does not run
+	code, _ = serve(t, router, http.MethodPost, "/plans/"+planID+"/disable", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Empty(t, nextRun(t, router, planID))
+	clock.Advance(30 * time.Minute)
+	assert.Never(t, func() bool { return len(runs) > 0 }, 50*time.Millisecond, time.Millisecond)
+
+	code, _ = serve(t, router, http.MethodPost, "/plans/"+planID+"/enable", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2026-03-02T10:30:00Z", nextRun(t, router, planID))
+
+	code, _ = serve(t, router, http.MethodDelete, "/plans/"+planID, "")
+	require.Equal(t, http.StatusOK, code)
+	_, scheduled := manager.scheduler.nextRun(planID)
+	assert.False(t, scheduled)
+}
+
+func TestPlanInvalidCron(t *testing.T) {
+	_, _, router, _ := startSchedulerTest(t, time.Now())
+
+	code, response := serve(t, router, http.MethodPost, "/plans",
+		`{"name": "bad", "cron_expr": "61 * * * *", "paths": ["/data"]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, `invalid cron expression "61 * * * *": invalid value "61" in minute field, expected 0-59`, response["error"])
+
+	code, response = serve(t, router, http.MethodPost, "/plans",
+		`{"name": "good", "cron_expr": "@daily", "paths": ["/data"]}`)
+	require.Equal(t, http.StatusCreated, code, response)
+	planID := response["id"].(string)
+
+	code, response = serve(t, router, http.MethodPut, "/plans/"+planID, `{"cron_expr": "every day"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, `invalid cron expression "every day": expected 5 fields, got 2`, response["error"])
+
+	code, response = serve(t, router, http.MethodGet, "/plans/"+planID, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "@daily", response["cron_expr"])
+}
+
+func TestPlanOrgScope(t *testing.T) {
+	manager := newTestManager(t)
+	for _, orgID := range []string{"acme", "globex"} {
+		_, err := manager.db.Exec("INSERT INTO organizations (id, display_name, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)", orgID, orgID)
+		require.NoError(t, err)
+	}
+	router := gin.New()
+	router.Use(middleware.OrgScopeMiddleware())
+	manager.RegisterRoutes(router)
+
+	// serveAs sends a request scoped to orgID, or unscoped when orgID is nil
+	serveAs := func(orgID *string, method, path, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if orgID != nil {
+			req.Header.Set(database.OrgHeader, *orgID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+	acme, globex, none := "acme", "globex", ""
+
+	code, response := serveAs(&acme, http.MethodPost, "/plans", `{"name": "acme-data", "cron_expr": "@daily", "paths": ["/data"], "enabled": true}`)
+	require.Equal(t, http.StatusCreated, code, response)
+	assert.Equal(t, "acme", response["org_id"])
+	planID := response["id"].(string)
+
+	code, response = serveAs(&acme, http.MethodGet, "/plans/"+planID, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "acme", response["org_id"])
+	code, response = serveAs(&acme, http.MethodGet, "/plans", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 1, response["total"])
+
+	// Another organization's plans do not exist for its members
+	code, response = serveAs(&globex, http.MethodGet, "/plans", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 0, response["total"])
+	for _, request := range []struct{ method, path, body string }{
+		{http.MethodGet, "/plans/" + planID, ""},
+		{http.MethodGet, "/plans/" + planID + "/runs", ""},
+		{http.MethodPut, "/plans/" + planID, `{"name": "taken"}`},
+		{http.MethodPost, "/plans/" + planID + "/disable", ""},
+		{http.MethodDelete, "/plans/" + planID, ""},
+		{http.MethodPost, "/snapshots", `{"plan_id": "` + planID + `"}`},
+	} {
+		code, _ := serveAs(&globex, request.method, request.path, request.body)
+		assert.Equal(t, http.StatusNotFound, code, "%s %s", request.method, request.path)
+	}
+	code, _ = serveAs(&none, http.MethodPost, "/plans", `{"name": "orphan", "cron_expr": "@daily", "paths": ["/data"]}`)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Unscoped requests, of the console's admins, see every plan
+	code, response = serveAs(nil, http.MethodGet, "/plans/"+planID, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "acme-data", response["name"])
+	assert.Equal(t, true, response["enabled"])
+
+	code, _ = serveAs(&acme, http.MethodDelete, "/plans/"+planID, "")
+	assert.Equal(t, http.StatusOK, code)
+}
@@ -3,20 +3,21 @@ package snap
 import (
 	"context"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/events"
 )
 
 const (
@@ -28,6 +29,10 @@ const (
 	StatusCompleted = "completed"
 	StatusFailed    = "failed"
 
+	// Snapshot kinds
+	KindFull        = "full"
+	KindIncremental = "incremental"
+
 	// Restore status
 	RestoreStatusPending   = "pending"
 	RestoreStatusRunning   = "running"
@@ -36,6 +41,9 @@ const (
 	RestoreStatusCancelled = "cancelled"
 )
 
+// progressUpdateInterval bounds how often task progress is published
+var progressUpdateInterval = 250 * time.Millisecond
+
 // SnapManager manages snapshots and restore operations
 type SnapManager struct {
 	db           *sqlx.DB
@@ -43,6 +51,13 @@ type SnapManager struct {
 	blockStore   *BlockStore
 	runningTasks map[string]*Task
 	taskMutex    sync.RWMutex
+	refMutex     sync.Mutex // serializes changes to block references with their cleanup
+	indexMutex   sync.Mutex // serializes building the browse indexes of manifests
+	usageMutex   sync.Mutex
+	usageLevel   int // the storage warning level last reported, in percent
+	statDisk     func(path string) (diskSpace, error)
+	scheduler    *planScheduler
+	eventBus     *events.Bus // snapshots completed, streamed to the console
 	ctx          context.Context
 	cancel       context.CancelFunc
 }
@@ -51,25 +66,163 @@ type SnapManager struct {
 type BlockStore struct {
 	repoDir    string
 	blockIndex map[string]string // hash -> filepath
+	held       map[string]int    // hash -> in-progress snapshots using the block
+	size       int64             // bytes of all blocks
+	dataSize   int64             // bytes of the data of all blocks, uncompressed
 	mutex      sync.RWMutex
+
+	// compression is the codec new blocks are stored with
+	compression string
+
+	// checkSpace is called before writing a block, to refuse it if it
+	// does not fit
+	checkSpace func(used, size int64) error
 }
 
 // Task represents a running operation
 type Task struct {
 	ID       string
-	Type     string // "snapshot" or "restore"
+	Type     string // "snapshot", "restore" or "scrub"
 	Status   string
 	Progress float64
 	Message  string
 	Started  time.Time
 	ctx      context.Context
 	cancel   context.CancelFunc
+
+	progressMu sync.RWMutex
+	current    TaskProgress
+	changed    chan struct{}
+}
+
+// TaskProgress is a point-in-time copy of a task's progress
+type TaskProgress struct {
+	TaskID         string    `json:"task_id"`
+	Type           string    `json:"type"`
+	Status         string    `json:"status"`
+	Progress       float64   `json:"progress"`
+	Message        string    `json:"message"`
+	TotalFiles     int       `json:"total_files"`
+	FilesScanned   int       `json:"files_scanned"`
+	FilesProcessed int       `json:"files_processed"`
+	FilesReused    int       `json:"files_reused,omitempty"`
+	TotalBytes     int64     `json:"total_bytes,omitempty"`
+	BytesRead      int64     `json:"bytes_read"`
+	BytesWritten   int64     `json:"bytes_written"`
+	ReadRate       float64   `json:"read_rate,omitempty"` // bytes per second lately read by a snapshot
+	BlocksChecked  int       `json:"blocks_checked,omitempty"`
+	Errors         int       `json:"errors,omitempty"`
+	CurrentPath    string    `json:"current_path,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Done reports whether the progress describes a finished task
+func (p TaskProgress) Done() bool {
+	switch p.Status {
+	case StatusCompleted, StatusFailed, RestoreStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// publish stores a copy of the progress and wakes up everyone watching the task
+func (t *Task) publish(p TaskProgress) {
+	p.TaskID = t.ID
+	p.Type = t.Type
+	p.UpdatedAt = time.Now()
+
+	t.progressMu.Lock()
+	t.current = p
+	t.Status = p.Status
+	t.Progress = p.Progress
+	t.Message = p.Message
+	if t.changed != nil {
+		close(t.changed)
+	}
+	t.changed = make(chan struct{})
+	t.progressMu.Unlock()
+}
+
+// Snapshot returns the latest published progress of the task
+func (t *Task) Snapshot() TaskProgress {
+	progress, _ := t.watch()
+	return progress
+}
+
+// watch returns the latest progress and a channel that is closed on the next update
+func (t *Task) watch() (TaskProgress, <-chan struct{}) {
+	t.progressMu.Lock()
+	defer t.progressMu.Unlock()
+
+	if t.changed == nil {
+		t.changed = make(chan struct{})
+	}
+
+	progress := t.current
+	if progress.TaskID == "" {
+		// Nothing published yet, derive from the task fields
+		progress = TaskProgress{
+			TaskID:    t.ID,
+			Type:      t.Type,
+			Status:    t.Status,
+			Progress:  t.Progress,
+			Message:   t.Message,
+			UpdatedAt: t.Started,
+		}
+	}
+
+	return progress, t.changed
+}
+
+// progressReporter accumulates progress in a private copy owned by the worker
+// goroutine and publishes it to the task at most once per update interval
+type progressReporter struct {
+	task      *Task
+	state     TaskProgress
+	interval  time.Duration
+	published time.Time
 }
 
-// SnapshotManifest represents the structure of a snapshot
+// newProgressReporter creates a reporter for the task
+func newProgressReporter(task *Task) *progressReporter {
+	return &progressReporter{
+		task:     task,
+		state:    TaskProgress{Status: StatusRunning},
+		interval: progressUpdateInterval,
+	}
+}
+
+// report publishes the current state if the update interval has passed or force is set
+func (r *progressReporter) report(force bool) {
+	if !force && time.Since(r.published) < r.interval {
+		return
+	}
+	r.published = time.Now()
+	r.task.publish(r.state)
+}
+
+// finish publishes the terminal state of the task
+func (r *progressReporter) finish(err error) {
+	r.state.CurrentPath = ""
+	if err != nil {
+		r.state.Status = StatusFailed
+		r.state.Message = err.Error()
+	} else {
+		r.state.Status = StatusCompleted
+		r.state.Progress = 100.0
+	}
+	r.report(true)
+}
+
+// SnapshotManifest represents the structure of a snapshot. An incremental
+// snapshot reuses the blocks of unchanged files from its parent, but its
+// manifest still lists every file and block, so restoring or deleting it
+// never needs the parent.
 type SnapshotManifest struct {
 	ID        string            `json:"id"`
 	PlanID    string            `json:"plan_id"`
+	Kind      string            `json:"kind"`
+	ParentID  string            `json:"parent_id,omitempty"`
 	Timestamp time.Time         `json:"timestamp"`
 	Paths     []string          `json:"paths"`
 	Files     []FileEntry       `json:"files"`
@@ -92,14 +245,21 @@ type FileEntry struct {
 
 // RestoreJob represents a restore operation
 type RestoreJob struct {
-	ID         string    `json:"id"`
-	SnapshotID string    `json:"snapshot_id"`
-	TargetPath string    `json:"target_path"`
-	Status     string    `json:"status"`
-	Progress   float64   `json:"progress"`
-	Message    string    `json:"message"`
-	Started    time.Time `json:"started"`
-	Completed  time.Time `json:"completed,omitempty"`
+	ID            string    `json:"id"`
+	SnapshotID    string    `json:"snapshot_id"`
+	TargetPath    string    `json:"target_path"`
+	OutputPath    string    `json:"output_path,omitempty"` // where the files are restored
+	RestoreMode   string    `json:"restore_mode,omitempty"`
+	Status        string    `json:"status"`
+	Progress      float64   `json:"progress"`
+	Message       string    `json:"message"`
+	TotalFiles    int       `json:"total_files"`
+	FilesRestored int       `json:"files_restored"`
+	TotalBytes    int64     `json:"total_bytes"`
+	BytesRestored int64     `json:"bytes_restored"`
+	Errors        []string  `json:"errors,omitempty"` // files that failed to restore
+	Started       time.Time `json:"started"`
+	Completed     time.Time `json:"completed,omitempty"`
 }
 
 // NewSnapManager creates a new snap manager
@@ -111,14 +271,20 @@ func NewSnapManager(db *sqlx.DB, config config.SnapConfig) (*SnapManager, error)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &SnapManager{
+	sm := &SnapManager{
 		db:           db,
 		config:       config,
 		blockStore:   blockStore,
 		runningTasks: make(map[string]*Task),
+		eventBus:     events.NewBus("snap", events.DefaultHistory),
+		statDisk:     statDisk,
 		ctx:          ctx,
 		cancel:       cancel,
-	}, nil
+	}
+	sm.scheduler = newPlanScheduler(sm.executeScheduledSnapshot)
+	blockStore.checkSpace = func(used, size int64) error { return sm.checkSpace(used, size) }
+	blockStore.compression = config.Compression
+	return sm, nil
 }
 
 // NewBlockStore creates a new block store
@@ -131,6 +297,7 @@ func NewBlockStore(repoDir string) (*BlockStore, error) {
 	bs := &BlockStore{
 		repoDir:    repoDir,
 		blockIndex: make(map[string]string),
+		held:       make(map[string]int),
 	}
 
 	// Load existing blocks
@@ -156,6 +323,8 @@ func (bs *BlockStore) rebuildIndex() error {
 			hash := strings.TrimSuffix(filename, ".block")
 			if len(hash) == 64 { // SHA-256 hex length
 				bs.blockIndex[hash] = path
+				bs.size += info.Size()
+				bs.dataSize += blockDataSize(path, info.Size())
 			}
 		}
 
@@ -163,8 +332,23 @@ func (bs *BlockStore) rebuildIndex() error {
 	})
 }
 
+// usedBytes returns the bytes of all blocks
+func (bs *BlockStore) usedBytes() int64 {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	return bs.size
+}
+
+// EventBus returns the bus the manager publishes snapshot events to
+func (sm *SnapManager) EventBus() *events.Bus {
+	return sm.eventBus
+}
+
 // Start starts the snap manager background tasks
 func (sm *SnapManager) Start(ctx context.Context) {
+	sm.failInterruptedRestores()
+	sm.checkUsage()
+
 	// Start scheduled snapshots
 	go sm.scheduleRunner(ctx)
 	
@@ -184,21 +368,6 @@ func (sm *SnapManager) Stop() {
 	sm.taskMutex.Unlock()
 }
 
-// scheduleRunner runs scheduled backup plans
-func (sm *SnapManager) scheduleRunner(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			sm.checkScheduledPlans()
-		}
-	}
-}
-
 // scrubRunner performs periodic integrity checks
 func (sm *SnapManager) scrubRunner(ctx context.Context) {
 	ticker := time.NewTicker(24 * time.Hour) // Daily scrub
@@ -214,91 +383,55 @@ func (sm *SnapManager) scrubRunner(ctx context.Context) {
 	}
 }
 
-// checkScheduledPlans checks for plans that need to run
-func (sm *SnapManager) checkScheduledPlans() {
-	rows, err := sm.db.Query(`
-		SELECT id, name, cron_expression, paths, enabled
-		FROM snap_plans 
-		WHERE enabled = 1
-	`)
-	if err != nil {
-		return
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var id, name, cronExpr, paths string
-		var enabled bool
-		
-		if err := rows.Scan(&id, &name, &cronExpr, &paths, &enabled); err != nil {
-			continue
-		}
+// executeScheduledSnapshot executes a scheduled snapshot
+func (sm *SnapManager) executeScheduledSnapshot(snapshotID, planID string, paths []string) {
+	task := sm.registerTask(snapshotID, "snapshot")
+	defer sm.unregisterTask(task)
 
-		// Check if plan should run now (simplified cron check)
-		if sm.shouldRunPlan(id, cronExpr) {
-			go sm.executeScheduledSnapshot(id, name, strings.Split(paths, ","))
-		}
-	}
+	err := sm.createSnapshotInternal(task.ctx, snapshotID, planID, paths, false, task)
+	sm.finishTask(task, err)
 }
 
-// shouldRunPlan checks if a plan should run based on its schedule
-func (sm *SnapManager) shouldRunPlan(planID, cronExpr string) bool {
-	// Simplified check - check if last run was more than the interval
-	// In a full implementation, you'd use a proper cron parser
-	
-	var lastRun sql.NullTime
-	err := sm.db.QueryRow(`
-		SELECT MAX(timestamp) FROM snapshots WHERE plan_id = ?
-	`, planID).Scan(&lastRun)
-	
-	if err != nil || !lastRun.Valid {
-		return true // First run
+// finishTask publishes the terminal state of a snapshot task, keeping the
+// last reported counters, and a snapshot.completed event
+func (sm *SnapManager) finishTask(task *Task, err error) {
+	reporter := newProgressReporter(task)
+	reporter.state = task.Snapshot()
+	reporter.finish(err)
+
+	snapshot := events.Snapshot{
+		SnapshotID: task.ID,
+		Status:     reporter.state.Status,
+		Files:      reporter.state.FilesProcessed,
+		Error:      reporter.state.Message,
 	}
-
-	// Simple daily check for now
-	return time.Since(lastRun.Time) > 24*time.Hour
-}
-
-// executeScheduledSnapshot executes a scheduled snapshot
-func (sm *SnapManager) executeScheduledSnapshot(planID, name string, paths []string) {
-	snapshotID := fmt.Sprintf("snap_%d", time.Now().Unix())
-	
-	task := &Task{
-		ID:      snapshotID,
-		Type:    "snapshot",
-		Status:  StatusRunning,
-		Started: time.Now(),
+	if err == nil {
+		snapshot.Error = ""
+		if err := sm.db.QueryRow("SELECT plan_id, size_bytes FROM snapshots WHERE id = ?", task.ID).Scan(&snapshot.PlanID, &snapshot.Size); err != nil {
+			log.Printf("Failed to read snapshot %s: %v", task.ID, err)
+		}
 	}
-	
-	ctx, cancel := context.WithCancel(sm.ctx)
-	task.ctx = ctx
-	task.cancel = cancel
-
-	sm.taskMutex.Lock()
-	sm.runningTasks[snapshotID] = task
-	sm.taskMutex.Unlock()
-
-	defer func() {
-		sm.taskMutex.Lock()
-		delete(sm.runningTasks, snapshotID)
-		sm.taskMutex.Unlock()
-	}()
+	sm.eventBus.Publish(events.SnapshotCompleted, snapshot)
+	sm.checkUsage()
+}
 
-	err := sm.createSnapshotInternal(ctx, snapshotID, planID, paths, task)
+// createSnapshotInternal creates a snapshot with progress tracking. Unless
+// full is set, or the plan is due a full snapshot, files unchanged since the
+// plan's latest snapshot reuse its blocks instead of being read again.
+//
+// A snapshot is refused if the files it would read do not fit in the
+// repository, and aborted if a block does not fit. The blocks a failed
+// snapshot wrote are removed.
+func (sm *SnapManager) createSnapshotInternal(ctx context.Context, snapshotID, planID string, paths []string, full bool, task *Task) (err error) {
+	baseline, err := sm.snapshotBaseline(planID, full)
 	if err != nil {
-		task.Status = StatusFailed
-		task.Message = err.Error()
-	} else {
-		task.Status = StatusCompleted
-		task.Progress = 100.0
+		return err
 	}
-}
 
-// createSnapshotInternal creates a snapshot with progress tracking
-func (sm *SnapManager) createSnapshotInternal(ctx context.Context, snapshotID, planID string, paths []string, task *Task) error {
 	manifest := &SnapshotManifest{
 		ID:        snapshotID,
 		PlanID:    planID,
+		Kind:      KindFull,
 		Timestamp: time.Now(),
 		Paths:     paths,
 		Files:     []FileEntry{},
@@ -307,10 +440,29 @@ func (sm *SnapManager) createSnapshotInternal(ctx context.Context, snapshotID, p
 		FileCount: 0,
 	}
 
+	if baseline != nil {
+		manifest.Kind = KindIncremental
+		manifest.ParentID = baseline.ID
+	}
+	previous := baselineFiles(baseline)
+
+	reporter := newProgressReporter(task)
+
+	// The blocks are kept from cleanup until the snapshot references them
+	hold := sm.blockStore.newHold()
+	defer hold.release()
+	defer func() {
+		if err != nil {
+			sm.discardBlocks(hold)
+		}
+	}()
+
 	// Phase 1: Scan files
-	task.Message = "Scanning files..."
+	reporter.state.Message = "Scanning files..."
+	reporter.report(true)
 	totalFiles := 0
 	var allFiles []string
+	var estimate int64 // bytes of the files that are not unchanged
 
 	for _, path := range paths {
 		err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
@@ -319,6 +471,12 @@ func (sm *SnapManager) createSnapshotInternal(ctx context.Context, snapshotID, p
 			}
 			allFiles = append(allFiles, filePath)
 			totalFiles++
+			if entry, ok := previous[filePath]; info.Mode().IsRegular() && (!ok || !unchanged(entry, info)) {
+				estimate += info.Size()
+			}
+			reporter.state.FilesScanned = totalFiles
+			reporter.state.CurrentPath = filePath
+			reporter.report(false)
 			return nil
 		})
 		if err != nil {
@@ -326,61 +484,22 @@ func (sm *SnapManager) createSnapshotInternal(ctx context.Context, snapshotID, p
 		}
 	}
 
-	task.Message = fmt.Sprintf("Found %d files", totalFiles)
-	processedFiles := 0
+	reporter.state.TotalFiles = totalFiles
+	reporter.state.Message = fmt.Sprintf("Found %d files", totalFiles)
+	reporter.report(true)
+	if err := sm.checkEstimate(estimate); err != nil {
+		return err
+	}
 
 	// Phase 2: Process files
-	for _, filePath := range allFiles {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		info, err := os.Lstat(filePath)
-		if err != nil {
-			continue // Skip files that can't be stat'd
-		}
-
-		fileEntry := FileEntry{
-			Path:    filePath,
-			Size:    info.Size(),
-			Mode:    uint32(info.Mode()),
-			ModTime: info.ModTime(),
-			IsDir:   info.IsDir(),
-		}
-
-		if info.Mode()&os.ModeSymlink != 0 {
-			// Handle symlink
-			target, err := os.Readlink(filePath)
-			if err == nil {
-				fileEntry.Target = target
-			}
-		} else if !info.IsDir() {
-			// Handle regular file - create blocks
-			blocks, checksum, err := sm.processFile(filePath)
-			if err != nil {
-				continue // Skip files that can't be processed
-			}
-			fileEntry.Blocks = blocks
-			fileEntry.Checksum = checksum
-			
-			// Add blocks to manifest
-			for _, blockHash := range blocks {
-				if blockPath, exists := sm.blockStore.blockIndex[blockHash]; exists {
-					manifest.Blocks[blockHash] = blockPath
-				}
-			}
-		}
-
-		manifest.Files = append(manifest.Files, fileEntry)
-		manifest.Size += fileEntry.Size
-		manifest.FileCount++
-
-		processedFiles++
-		task.Progress = float64(processedFiles) / float64(totalFiles) * 100.0
-		task.Message = fmt.Sprintf("Processed %d/%d files", processedFiles, totalFiles)
+	limit, err := sm.planThrottle(planID)
+	if err != nil {
+		return err
+	}
+	if err := sm.snapshotFiles(ctx, allFiles, previous, manifest, hold, limit, sm.snapshotWorkers(), reporter); err != nil {
+		return err
 	}
+	reporter.report(true)
 
 	// Save manifest
 	manifestPath := filepath.Join(sm.config.RepoDir, "manifests", snapshotID+".json")
@@ -398,12 +517,7 @@ func (sm *SnapManager) createSnapshotInternal(ctx context.Context, snapshotID, p
 	}
 
 	// Save to database
-	_, err = sm.db.Exec(`
-		INSERT INTO snapshots (id, plan_id, timestamp, manifest_path, size_bytes, status)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, snapshotID, planID, manifest.Timestamp, manifestPath, manifest.Size, StatusCompleted)
-
-	if err != nil {
+	if err := sm.recordSnapshot(manifest, manifestPath); err != nil {
 		return fmt.Errorf("failed to save snapshot to database: %w", err)
 	}
 
@@ -412,79 +526,151 @@ func (sm *SnapManager) createSnapshotInternal(ctx context.Context, snapshotID, p
 
 // processFile processes a file into blocks
 func (sm *SnapManager) processFile(filePath string) ([]string, string, error) {
-	file, err := os.Open(filePath)
+	var err error
+	blocks, checksum, readErr := chunkFile(context.Background(), filePath, newSplitter(sm.config.Chunking), nil, func(hash string, data []byte, buffer *[]byte) bool {
+		_, err = sm.blockStore.putBlock(hash, data, nil)
+		blockBuffers.Put(buffer)
+		return err == nil
+	})
 	if err != nil {
 		return nil, "", err
 	}
-	defer file.Close()
-
-	var blocks []string
-	hasher := sha256.New()
-	buffer := make([]byte, BlockSize)
-
-	for {
-		n, err := file.Read(buffer)
-		if n == 0 {
-			break
-		}
-		if err != nil && err != io.EOF {
-			return nil, "", err
-		}
-
-		block := buffer[:n]
-		hasher.Write(block)
-
-		// Calculate block hash
-		blockHasher := sha256.New()
-		blockHasher.Write(block)
-		blockHash := hex.EncodeToString(blockHasher.Sum(nil))
-
-		// Store block if not exists
-		if err := sm.blockStore.storeBlock(blockHash, block); err != nil {
-			return nil, "", err
-		}
-
-		blocks = append(blocks, blockHash)
-
-		if err == io.EOF {
-			break
-		}
-	}
-
-	checksum := hex.EncodeToString(hasher.Sum(nil))
-	return blocks, checksum, nil
+	return blocks, checksum, readErr
 }
 
 // storeBlock stores a block in the block store
 func (bs *BlockStore) storeBlock(hash string, data []byte) error {
+	_, err := bs.putBlock(hash, data, nil)
+	return err
+}
+
+// putBlock stores a block if it is not already present and reports whether it
+// was written. The block is added to hold, unless it is nil, even if it was
+// already present.
+func (bs *BlockStore) putBlock(hash string, data []byte, hold *blockHold) (bool, error) {
+	return bs.putEncoded(hash, data, nil, hold)
+}
+
+// putEncoded is putBlock for a block already encoded for the store, or with
+// stored nil to have it encoded if it needs to be written
+func (bs *BlockStore) putEncoded(hash string, data, stored []byte, hold *blockHold) (bool, error) {
 	bs.mutex.Lock()
 	defer bs.mutex.Unlock()
 
+	if hold != nil && !hold.hashes[hash] {
+		hold.hashes[hash] = true
+		bs.held[hash]++
+	}
+
 	// Check if block already exists
 	if _, exists := bs.blockIndex[hash]; exists {
-		return nil // Block already stored
+		return false, nil // Block already stored
+	}
+	if stored == nil {
+		stored = encodeBlock(data, bs.compression)
+	}
+	if bs.checkSpace != nil {
+		if err := bs.checkSpace(bs.size, int64(len(stored))); err != nil {
+			return false, err
+		}
 	}
 
 	// Create block file path
 	blockDir := filepath.Join(bs.repoDir, "blocks", hash[:2])
 	if err := os.MkdirAll(blockDir, 0755); err != nil {
-		return err
+		return false, err
 	}
 
 	blockPath := filepath.Join(blockDir, hash+".block")
 	
 	// Write block data
-	if err := os.WriteFile(blockPath, data, 0644); err != nil {
-		return err
+	if err := os.WriteFile(blockPath, stored, 0644); err != nil {
+		return false, err
 	}
 
 	// Update index
 	bs.blockIndex[hash] = blockPath
-	return nil
+	bs.size += int64(len(stored))
+	bs.dataSize += int64(len(data))
+	if hold != nil {
+		hold.written[hash] = true
+	}
+	return true, nil
+}
+
+// readBlock reads and decodes a block and verifies it against its hash. The
+// data is returned along with a hash mismatch error.
+func (bs *BlockStore) readBlock(hash string) ([]byte, error) {
+	bs.mutex.RLock()
+	blockPath, exists := bs.blockIndex[hash]
+	bs.mutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("block not found in index")
+	}
+
+	stored, err := os.ReadFile(blockPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block: %w", err)
+	}
+	data, decodeErr := decodeBlock(stored)
+
+	// Verify hash
+	sum := sha256.Sum256(data)
+	computedHash := hex.EncodeToString(sum[:])
+	if decodeErr != nil || computedHash != hash {
+		// Data stored as is may happen to start like a header
+		if rawSum := sha256.Sum256(stored); hex.EncodeToString(rawSum[:]) == hash {
+			return stored, nil
+		}
+		if decodeErr != nil {
+			return stored, fmt.Errorf("hash mismatch: expected %s, cannot decode block: %w", hash, decodeErr)
+		}
+		return data, fmt.Errorf("hash mismatch: expected %s, got %s", hash, computedHash)
+	}
+
+	return data, nil
 }
 
-// performScrub performs integrity checking
+// performScrub performs integrity checking as a tracked task
 func (sm *SnapManager) performScrub() {
+	task := sm.registerTask(newID("scrub"), "scrub")
+	sm.runScrub(task)
+}
+
+// registerTask creates a task and adds it to the running tasks
+func (sm *SnapManager) registerTask(id, taskType string) *Task {
+	task := &Task{
+		ID:      id,
+		Type:    taskType,
+		Status:  StatusPending,
+		Started: time.Now(),
+	}
+	task.ctx, task.cancel = context.WithCancel(sm.ctx)
+
+	sm.taskMutex.Lock()
+	sm.runningTasks[id] = task
+	sm.taskMutex.Unlock()
+
+	return task
+}
+
+// unregisterTask removes a task from the running tasks
+func (sm *SnapManager) unregisterTask(task *Task) {
+	sm.taskMutex.Lock()
+	delete(sm.runningTasks, task.ID)
+	sm.taskMutex.Unlock()
+	task.cancel()
+}
+
+// runScrub verifies a sample of blocks, reporting progress on the task
+func (sm *SnapManager) runScrub(task *Task) {
+	defer sm.unregisterTask(task)
+
+	reporter := newProgressReporter(task)
+	reporter.state.Message = "Scrubbing blocks..."
+	reporter.report(true)
+
 	// Sample 10% of blocks for verification
 	sm.blockStore.mutex.RLock()
 	var hashes []string
@@ -501,37 +687,48 @@ func (sm *SnapManager) performScrub() {
 	}
 
 	for i := 0; i < sampleSize; i++ {
+		select {
+		case <-task.ctx.Done():
+			reporter.finish(task.ctx.Err())
+			return
+		default:
+		}
+
 		hash := hashes[i*10/sampleSize]
-		if err := sm.verifyBlock(hash); err != nil {
+		reporter.state.CurrentPath = hash
+		size, err := sm.verifyBlockSize(hash)
+		if err != nil {
 			// Log error or trigger alert
 			fmt.Printf("Block verification failed for %s: %v\n", hash, err)
+			reporter.state.Errors++
 		}
+		reporter.state.BytesRead += size
+		reporter.state.BlocksChecked++
+		reporter.state.Progress = float64(i+1) / float64(sampleSize) * 100.0
+		reporter.state.Message = fmt.Sprintf("Verified %d/%d blocks", i+1, sampleSize)
+		reporter.report(false)
 	}
+
+	reporter.finish(nil)
 }
 
 // verifyBlock verifies a block's integrity
 func (sm *SnapManager) verifyBlock(hash string) error {
-	sm.blockStore.mutex.RLock()
-	blockPath, exists := sm.blockStore.blockIndex[hash]
-	sm.blockStore.mutex.RUnlock()
-
-	if !exists {
-		return fmt.Errorf("block not found in index")
-	}
-
-	data, err := os.ReadFile(blockPath)
-	if err != nil {
-		return fmt.Errorf("failed to read block: %w", err)
-	}
+	_, err := sm.verifyBlockSize(hash)
+	return err
+}
 
-	// Verify hash
-	hasher := sha256.New()
-	hasher.Write(data)
-	computedHash := hex.EncodeToString(hasher.Sum(nil))
+// verifyBlockSize verifies a block's integrity and returns the number of bytes read
+func (sm *SnapManager) verifyBlockSize(hash string) (int64, error) {
+	data, err := sm.blockStore.readBlock(hash)
+	return int64(len(data)), err
+}
 
-	if computedHash != hash {
-		return fmt.Errorf("hash mismatch: expected %s, got %s", hash, computedHash)
-	}
+// idSequence disambiguates IDs generated within the same nanosecond
+var idSequence uint64
 
-	return nil
-}
\ No newline at end of file
+// newID returns a collision-free ID of the form <prefix>_<unix nanos>_<sequence>
+func newID(prefix string) string {
+	seq := atomic.AddUint64(&idSequence, 1)
+	return fmt.Sprintf("%s_%019d_%d", prefix, time.Now().UnixNano(), seq)
+}
@@ -1,21 +1,27 @@
 package snap
 
 import (
+	"bufio"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/last-emo-boy/infra-core/pkg/config"
+	"github.com/last-emo-boy/infra-core/pkg/database"
 )
 
 func TestNewSnapManager(t *testing.T) {
@@ -474,3 +480,109 @@ func TestVerifyBlockIntegrity(t *testing.T) {
 	err = manager.verifyBlock("nonexistent_hash")
 	assert.Error(t, err)
 }
+
+func TestNewIDCollisionFree(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		id := newID("snap")
+		require.False(t, seen[id], "duplicate ID %s", id)
+		seen[id] = true
+	}
+}
+
+func TestSnapshotProgressStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repoDir := t.TempDir()
+	sourceDir := t.TempDir()
+	for i := 0; i < 20; i++ {
+		data := make([]byte, 64*1024)
+		for j := range data {
+			data[j] = byte(i + j)
+		}
+		// Every other file is a duplicate so deduplication shows up in bytes written
+		name := filepath.Join(sourceDir, fmt.Sprintf("file_%02d.bin", i))
+		require.NoError(t, os.WriteFile(name, data[:len(data)-i%2], 0644))
+	}
+
+	db, err := database.NewDB(&config.Config{
+		Console: config.ConsoleConfig{
+			Database: config.DatabaseConfig{Path: ":memory:"},
+		},
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`INSERT INTO snap_plans (id, name, cron_expression, paths) VALUES ('plan_test', 'test', '@daily', '[]')`)
+	require.NoError(t, err)
+
+	manager, err := NewSnapManager(db.DB, config.SnapConfig{RepoDir: repoDir})
+	require.NoError(t, err)
+	defer manager.Stop()
+
+	previous := progressUpdateInterval
+	progressUpdateInterval = 0
+	defer func() { progressUpdateInterval = previous }()
+
+	router := gin.New()
+	router.GET("/api/v1/snapshots/:id/progress", manager.StreamProgress)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	snapshotID := newID("snap")
+	task := manager.registerTask(snapshotID, "snapshot")
+
+	resp, err := http.Get(server.URL + "/api/v1/snapshots/" + snapshotID + "/progress")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/event-stream")
+
+	events := make(chan [2]string)
+	go func() {
+		defer close(events)
+		var event string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				event = strings.TrimPrefix(line, "event:")
+			case strings.HasPrefix(line, "data:"):
+				events <- [2]string{event, strings.TrimPrefix(line, "data:")}
+			}
+		}
+	}()
+
+	// The initial state is sent as soon as the stream is connected
+	first := <-events
+	assert.Equal(t, "progress", first[0])
+
+	go func() {
+		defer manager.unregisterTask(task)
+		err := manager.createSnapshotInternal(task.ctx, snapshotID, "plan_test", []string{sourceDir}, false, task)
+		manager.finishTask(task, err)
+	}()
+
+	var lastRead, lastWritten int64
+	var terminal string
+	var final TaskProgress
+	for ev := range events {
+		var progress TaskProgress
+		require.NoError(t, json.Unmarshal([]byte(ev[1]), &progress))
+		assert.GreaterOrEqual(t, progress.BytesRead, lastRead)
+		assert.GreaterOrEqual(t, progress.BytesWritten, lastWritten)
+		assert.LessOrEqual(t, progress.BytesWritten, progress.BytesRead)
+		lastRead, lastWritten = progress.BytesRead, progress.BytesWritten
+
+		if ev[0] != "progress" {
+			terminal = ev[0]
+			final = progress
+		}
+	}
+
+	assert.Equal(t, StatusCompleted, terminal)
+	assert.Equal(t, 100.0, final.Progress)
+	assert.Equal(t, 21, final.FilesScanned) // directory plus 20 files
+	assert.Equal(t, int64(20*64*1024-10), final.BytesRead)
+	assert.Greater(t, final.BytesWritten, int64(0))
+}
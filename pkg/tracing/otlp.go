@@ -0,0 +1,250 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// otlpTracesPath is where OTLP/HTTP collectors receive spans
+const otlpTracesPath = "/v1/traces"
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP/HTTP
+// with JSON encoding, which every collector accepts on port 4318
+type OTLPExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewOTLPExporter creates an exporter for a collector endpoint such as
+// http://localhost:4318. The traces path is added when the endpoint has none.
+func NewOTLPExporter(endpoint string) (*OTLPExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: must be an http:// or https:// URL", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+	}
+	return &OTLPExporter{
+		url:    u.String(),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// ExportSpans sends a batch of finished spans to the collector
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to export spans: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Shutdown releases the exporter. Spans are sent synchronously, so there is
+// nothing left to flush.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// OTLP JSON payload, see opentelemetry-proto's trace service. IDs are hex
+// encoded and 64-bit integers are strings, as the JSON mapping requires.
+type (
+	otlpExportRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpEvent struct {
+		TimeUnixNano string         `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string         `json:"stringValue,omitempty"`
+		BoolValue   *bool           `json:"boolValue,omitempty"`
+		IntValue    *string         `json:"intValue,omitempty"`
+		DoubleValue *float64        `json:"doubleValue,omitempty"`
+		ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+	}
+	otlpArrayValue struct {
+		Values []otlpValue `json:"values"`
+	}
+)
+
+// OTLP status codes, which are numbered differently from otel's codes
+const (
+	otlpStatusUnset = 0
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+// otlpRequest groups spans by resource and instrumentation scope
+func otlpRequest(spans []sdktrace.ReadOnlySpan) otlpExportRequest {
+	var request otlpExportRequest
+	resources := make(map[attribute.Distinct]int)
+
+	for _, span := range spans {
+		key := span.Resource().Equivalent()
+		r, ok := resources[key]
+		if !ok {
+			r = len(request.ResourceSpans)
+			resources[key] = r
+			request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{Attributes: otlpAttributes(span.Resource().Attributes())},
+			})
+		}
+		resourceSpans := &request.ResourceSpans[r]
+
+		scope := otlpScope{Name: span.InstrumentationScope().Name, Version: span.InstrumentationScope().Version}
+		s := -1
+		for i, existing := range resourceSpans.ScopeSpans {
+			if existing.Scope == scope {
+				s = i
+				break
+			}
+		}
+		if s < 0 {
+			s = len(resourceSpans.ScopeSpans)
+			resourceSpans.ScopeSpans = append(resourceSpans.ScopeSpans, otlpScopeSpans{Scope: scope})
+		}
+
+		scopeSpans := &resourceSpans.ScopeSpans[s]
+		scopeSpans.Spans = append(scopeSpans.Spans, toOTLPSpan(span))
+	}
+	return request
+}
+
+func toOTLPSpan(span sdktrace.ReadOnlySpan) otlpSpan {
+	converted := otlpSpan{
+		TraceID:           span.SpanContext().TraceID().String(),
+		SpanID:            span.SpanContext().SpanID().String(),
+		Name:              span.Name(),
+		Kind:              int(span.SpanKind()),
+		StartTimeUnixNano: unixNano(span.StartTime()),
+		EndTimeUnixNano:   unixNano(span.EndTime()),
+		Attributes:        otlpAttributes(span.Attributes()),
+	}
+	if parent := span.Parent(); parent.IsValid() {
+		converted.ParentSpanID = parent.SpanID().String()
+	}
+
+	for _, event := range span.Events() {
+		converted.Events = append(converted.Events, otlpEvent{
+			TimeUnixNano: unixNano(event.Time),
+			Name:         event.Name,
+			Attributes:   otlpAttributes(event.Attributes),
+		})
+	}
+
+	switch status := span.Status(); status.Code {
+	case codes.Error:
+		converted.Status = otlpStatus{Code: otlpStatusError, Message: status.Description}
+	case codes.Ok:
+		converted.Status = otlpStatus{Code: otlpStatusOK}
+	default:
+		converted.Status = otlpStatus{Code: otlpStatusUnset}
+	}
+	return converted
+}
+
+func otlpAttributes(attrs []attribute.KeyValue) []otlpKeyValue {
+	converted := make([]otlpKeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		converted = append(converted, otlpKeyValue{Key: string(attr.Key), Value: otlpAttributeValue(attr.Value)})
+	}
+	return converted
+}
+
+func otlpAttributeValue(value attribute.Value) otlpValue {
+	switch value.Type() {
+	case attribute.BOOL:
+		b := value.AsBool()
+		return otlpValue{BoolValue: &b}
+	case attribute.INT64:
+		i := strconv.FormatInt(value.AsInt64(), 10)
+		return otlpValue{IntValue: &i}
+	case attribute.FLOAT64:
+		f := value.AsFloat64()
+		return otlpValue{DoubleValue: &f}
+	case attribute.STRINGSLICE:
+		values := make([]otlpValue, 0, len(value.AsStringSlice()))
+		for _, s := range value.AsStringSlice() {
+			values = append(values, otlpAttributeValue(attribute.StringValue(s)))
+		}
+		return otlpValue{ArrayValue: &otlpArrayValue{Values: values}}
+	default:
+		s := value.Emit()
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func unixNano(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+// instrumentationName names the tracer every span is created with
+const instrumentationName = "github.com/last-emo-boy/infra-core"
+
+// enabled is set once a tracer provider is installed. Instrumentation checks
+// it first so that it costs nothing while tracing is off.
+var enabled atomic.Bool
+
+// propagator reads and writes W3C traceparent and tracestate headers
+var propagator = propagation.TraceContext{}
+
+// ServiceName returns the service name a daemon reports its spans under
+func ServiceName(cfg config.TracingConfig, component string) string {
+	if name := cfg.ServiceNames[component]; name != "" {
+		return name
+	}
+	return "infra-core-" + component
+}
+
+// Setup installs a tracer provider exporting a daemon's spans to the OTLP
+// endpoint in cfg. Tracing stays off when no endpoint is configured. The
+// returned function flushes pending spans and must be called on shutdown.
+func Setup(component string, cfg config.TracingConfig) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := NewOTLPExporter(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := cfg.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", ServiceName(cfg, component)),
+		)),
+	)
+	Install(provider)
+	return provider.Shutdown, nil
+}
+
+// Install makes provider the source of every span, enabling tracing. Tests
+// install a provider with an in-memory exporter. The returned function turns
+// tracing off again.
+func Install(provider trace.TracerProvider) func() {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	enabled.Store(true)
+	return func() {
+		enabled.Store(false)
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	}
+}
+
+// Enabled reports whether spans are being recorded
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Start starts a span as a child of the span in ctx
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// StartServerSpan starts the span of an incoming HTTP request, continuing
+// the trace of the traceparent header when the caller sent one
+func StartServerSpan(req *http.Request, name string) (context.Context, trace.Span) {
+	ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	return Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.target", req.URL.RequestURI()),
+			attribute.String("http.host", req.Host),
+		),
+	)
+}
+
+// Inject writes the trace context of ctx into outgoing request headers
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// SetHTTPStatus records a response status on a span, marking server errors
+func SetHTTPStatus(span trace.Span, status int) {
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// RecordError marks a span as failed
+func RecordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// StatementName names the span of a SQL statement after its operation and
+// the table it works on, such as "SELECT users" or "INSERT audit_logs"
+func StatementName(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "SQL"
+	}
+
+	operation := strings.ToUpper(fields[0])
+	var marker string
+	switch operation {
+	case "SELECT", "DELETE":
+		marker = "FROM"
+	case "INSERT", "REPLACE":
+		marker = "INTO"
+	case "UPDATE":
+		return fmt.Sprintf("%s %s", operation, tableName(fields, 1))
+	default:
+		return operation
+	}
+
+	for i, field := range fields {
+		if strings.EqualFold(field, marker) && i+1 < len(fields) {
+			return fmt.Sprintf("%s %s", operation, tableName(fields, i+1))
+		}
+	}
+	return operation
+}
+
+// tableName returns the table at fields[i], without quotes or a trailing
+// column list
+func tableName(fields []string, i int) string {
+	if i >= len(fields) {
+		return ""
+	}
+	name := fields[i]
+	if open := strings.IndexByte(name, '('); open > 0 {
+		name = name[:open]
+	}
+	return strings.Trim(name, "`\"[]();,")
+}
@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/last-emo-boy/infra-core/pkg/config"
+)
+
+func TestStatementName(t *testing.T) {
+	for query, expected := range map[string]string{
+		"SELECT * FROM users WHERE id = ?":                "SELECT users",
+		"select count(*) from `services`":                 "SELECT services",
+		"INSERT INTO audit_logs (id, action) VALUES (?)":  "INSERT audit_logs",
+		"INSERT INTO sessions(id) VALUES (?)":             "INSERT sessions",
+		"UPDATE \"users\" SET name = ?":                   "UPDATE users",
+		"DELETE FROM api_keys WHERE id = ?":               "DELETE api_keys",
+		"REPLACE INTO settings (key, value) VALUES (?,?)": "REPLACE settings",
+		"CREATE TABLE IF NOT EXISTS users (id TEXT)":      "CREATE",
+		"   ": "SQL",
+	} {
+		assert.Equal(t, expected, StatementName(query), query)
+	}
+}
+
+func TestServiceName(t *testing.T) {
+	cfg := config.TracingConfig{ServiceNames: map[string]string{"gate": "edge"}}
+	assert.Equal(t, "edge", ServiceName(cfg, "gate"))
+	assert.Equal(t, "infra-core-console", ServiceName(cfg, "console"))
+}
+
+func TestSetupDisabled(t *testing.T) {
+	shutdown, err := Setup("console", config.TracingConfig{})
+	require.NoError(t, err)
+	assert.False(t, Enabled())
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup("console", config.TracingConfig{Endpoint: "localhost:4318"})
+	assert.Error(t, err)
+}
+
+func TestServerSpanContinuesTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	restore := Install(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer restore()
+	require.True(t, Enabled())
+
+	ctx, parent := Start(context.Background(), "client")
+	header := http.Header{}
+	Inject(ctx, header)
+	parent.End()
+	require.NotEmpty(t, header.Get("traceparent"))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/services", nil)
+	req.Header = header
+	_, span := StartServerSpan(req, "GET /api/v1/services")
+	SetHTTPStatus(span, http.StatusBadGateway)
+	span.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	server := spans[1]
+	assert.Equal(t, spans[0].SpanContext.TraceID(), server.SpanContext.TraceID())
+	assert.Equal(t, spans[0].SpanContext.SpanID(), server.Parent.SpanID())
+	assert.Equal(t, "Bad Gateway", server.Status.Description)
+
+	restore()
+	assert.False(t, Enabled())
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var received otlpExportRequest
+	var path, contentType string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+	}))
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(collector.URL)
+	require.NoError(t, err)
+
+	spans := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	_, child := provider.Tracer("test").Start(ctx, "child")
+	RecordError(child, errors.New("disk full"))
+	child.End()
+	parent.End()
+
+	require.NoError(t, exporter.ExportSpans(context.Background(), spans.GetSpans().Snapshots()))
+	assert.Equal(t, otlpTracesPath, path)
+	assert.Equal(t, "application/json", contentType)
+
+	require.Len(t, received.ResourceSpans, 1)
+	require.Len(t, received.ResourceSpans[0].ScopeSpans, 1)
+	exported := received.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, exported, 2)
+	assert.Equal(t, "child", exported[0].Name)
+	assert.Equal(t, exported[1].SpanID, exported[0].ParentSpanID)
+	assert.Equal(t, exported[1].TraceID, exported[0].TraceID)
+	assert.Len(t, exported[0].TraceID, 32)
+	assert.Equal(t, otlpStatusError, exported[0].Status.Code)
+	assert.Equal(t, "disk full", exported[0].Status.Message)
+	require.Len(t, exported[0].Events, 1)
+	assert.Equal(t, "exception", exported[0].Events[0].Name)
+
+	// Collector errors are reported
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	exporter, err = NewOTLPExporter(failing.URL + "/custom/traces")
+	require.NoError(t, err)
+	assert.Error(t, exporter.ExportSpans(context.Background(), spans.GetSpans().Snapshots()))
+}
@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd)
+
+package upgrade
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Signal is nil on platforms without SIGUSR2; self-upgrade is unavailable there
+var Signal os.Signal
+
+// reusePortControl reports that SO_REUSEPORT is not supported on this platform
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("SO_REUSEPORT is not supported on this platform")
+}
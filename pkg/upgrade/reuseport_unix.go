@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd
+
+package upgrade
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Signal triggers a self-upgrade of the running process
+var Signal os.Signal = unix.SIGUSR2
+
+// reusePortControl sets SO_REUSEPORT so several processes can bind the same address
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,443 @@
+// Package upgrade hands listening sockets from a running process to its
+// replacement so the gate can be upgraded without refusing connections.
+//
+// Listeners come from one of three places: systemd socket activation
+// (LISTEN_FDS), file descriptors inherited from a previous gate process that
+// re-executed itself on SIGUSR2, or a fresh bind, optionally with SO_REUSEPORT
+// so the old and new process can accept on the same port at the same time.
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables passed from the old process to the new one
+const (
+	EnvInheritedFDs = "INFRA_CORE_INHERITED_FDS" // name=fd pairs, comma separated
+	EnvReadyFD      = "INFRA_CORE_UPGRADE_READY_FD"
+	EnvGeneration   = "INFRA_CORE_UPGRADE_GENERATION"
+	EnvParentPID    = "INFRA_CORE_UPGRADE_PARENT_PID"
+)
+
+// Upgrade states
+const (
+	StateServing   = "serving"
+	StateUpgrading = "upgrading"
+	StateDraining  = "draining"
+)
+
+// Listener sources
+const (
+	SourceFresh     = "fresh"
+	SourceInherited = "inherited"
+	SourceSystemd   = "systemd"
+)
+
+// systemd passes activated sockets starting at this descriptor
+const listenFDsStart = 3
+
+// DefaultReadyTimeout bounds how long Upgrade waits for the new process to start serving
+const DefaultReadyTimeout = 30 * time.Second
+
+// drainSettle is how long Drain lets connections accepted just before the
+// listeners closed send their request before the servers shut down
+const drainSettle = 500 * time.Millisecond
+
+// Status describes the upgrade state of the running process
+type Status struct {
+	State          string     `json:"state"`
+	Generation     int        `json:"generation"`
+	PID            int        `json:"pid"`
+	ParentPID      int        `json:"parent_pid,omitempty"`
+	ListenerSource string     `json:"listener_source"`
+	StartedAt      time.Time  `json:"started_at"`
+	LastUpgradeAt  *time.Time `json:"last_upgrade_at,omitempty"`
+	ChildPID       int        `json:"child_pid,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+// inheritedFile is a listening socket handed over by systemd or a parent process
+type inheritedFile struct {
+	name    string
+	file    *os.File
+	claimed bool
+}
+
+// Upgrader owns the process's listeners and can hand them to a new process
+type Upgrader struct {
+	ReusePort    bool
+	ReadyTimeout time.Duration
+
+	mu        sync.Mutex
+	listeners []namedListener
+	inherited []*inheritedFile
+	readyFile *os.File
+	status    Status
+}
+
+type namedListener struct {
+	name     string
+	listener net.Listener
+}
+
+// New creates an upgrader, picking up listeners from systemd or a parent process
+func New(reusePort bool) (*Upgrader, error) {
+	u := &Upgrader{
+		ReusePort:    reusePort,
+		ReadyTimeout: DefaultReadyTimeout,
+		status: Status{
+			State:          StateServing,
+			PID:            os.Getpid(),
+			ListenerSource: SourceFresh,
+			StartedAt:      time.Now(),
+		},
+	}
+
+	if err := u.inherit(os.Getenv, fileForFD); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// fileForFD wraps an inherited descriptor
+func fileForFD(fd int) *os.File {
+	return os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+}
+
+// inherit reads handed-over sockets described by the environment
+func (u *Upgrader) inherit(getenv func(string) string, file func(fd int) *os.File) error {
+	if spec := getenv(EnvInheritedFDs); spec != "" {
+		for _, pair := range strings.Split(spec, ",") {
+			name, rawFD, ok := strings.Cut(pair, "=")
+			fd, err := strconv.Atoi(rawFD)
+			if !ok || err != nil {
+				return fmt.Errorf("invalid %s entry: %s", EnvInheritedFDs, pair)
+			}
+			u.inherited = append(u.inherited, &inheritedFile{name: name, file: file(fd)})
+		}
+		u.status.ListenerSource = SourceInherited
+
+		if raw := getenv(EnvReadyFD); raw != "" {
+			fd, err := strconv.Atoi(raw)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %s", EnvReadyFD, raw)
+			}
+			u.readyFile = file(fd)
+		}
+		u.status.Generation, _ = strconv.Atoi(getenv(EnvGeneration))
+		u.status.ParentPID, _ = strconv.Atoi(getenv(EnvParentPID))
+		return nil
+	}
+
+	// systemd socket activation
+	pid, _ := strconv.Atoi(getenv("LISTEN_PID"))
+	count, _ := strconv.Atoi(getenv("LISTEN_FDS"))
+	if pid != os.Getpid() || count <= 0 {
+		return nil
+	}
+
+	var names []string
+	if raw := getenv("LISTEN_FDNAMES"); raw != "" {
+		names = strings.Split(raw, ":")
+	}
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		u.inherited = append(u.inherited, &inheritedFile{name: name, file: file(listenFDsStart + i)})
+	}
+	u.status.ListenerSource = SourceSystemd
+
+	return nil
+}
+
+// Listen returns the listener registered under name, reusing a handed-over
+// socket when one is available and binding addr otherwise. Unnamed systemd
+// sockets are assigned to names in the order Listen is called.
+func (u *Upgrader) Listen(name, addr string) (net.Listener, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var listener net.Listener
+	if inherited := u.claim(name); inherited != nil {
+		l, err := net.FileListener(inherited.file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to use inherited %s listener: %w", name, err)
+		}
+		// FileListener dups the descriptor, the original is no longer needed
+		inherited.file.Close()
+		listener = l
+	} else {
+		lc := net.ListenConfig{}
+		if u.ReusePort {
+			lc.Control = reusePortControl
+		}
+		l, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		listener = l
+	}
+
+	u.listeners = append(u.listeners, namedListener{name: name, listener: listener})
+	return listener, nil
+}
+
+// claim finds an unclaimed inherited socket for name
+func (u *Upgrader) claim(name string) *inheritedFile {
+	for _, f := range u.inherited {
+		if !f.claimed && f.name == name {
+			f.claimed = true
+			return f
+		}
+	}
+	for _, f := range u.inherited {
+		if !f.claimed && f.name == "" {
+			f.claimed = true
+			return f
+		}
+	}
+	return nil
+}
+
+// Ready tells systemd and the parent process, if any, that this process is
+// serving. The parent then stops accepting and drains.
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// Under Type=notify this also moves the service's main PID to the new process
+	if err := sdNotify(fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid())); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+
+	if u.readyFile == nil {
+		return nil
+	}
+
+	_, err := u.readyFile.Write([]byte("ready\n"))
+	u.readyFile.Close()
+	u.readyFile = nil
+	if err != nil {
+		return fmt.Errorf("failed to notify parent: %w", err)
+	}
+	return nil
+}
+
+// sdNotify sends a state update to systemd when running under Type=notify
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Upgrade re-executes the binary on disk, handing it every listener, and waits
+// until the new process reports ready. On success the caller should stop
+// accepting, drain in-flight requests and exit. On failure the old process keeps serving.
+func (u *Upgrader) Upgrade() error {
+	u.mu.Lock()
+	if u.status.State != StateServing {
+		u.mu.Unlock()
+		return fmt.Errorf("upgrade already in progress")
+	}
+	u.status.State = StateUpgrading
+	u.mu.Unlock()
+
+	pid, err := u.startChild()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+	u.status.LastUpgradeAt = &now
+	if err != nil {
+		u.status.State = StateServing
+		u.status.LastError = err.Error()
+		return err
+	}
+	u.status.State = StateDraining
+	u.status.ChildPID = pid
+	u.status.LastError = ""
+	return nil
+}
+
+// startChild launches the new process and waits for its ready notification
+func (u *Upgrader) startChild() (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	files, spec, err := u.handoverFiles()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create ready pipe: %w", err)
+	}
+	defer readyReader.Close()
+
+	u.mu.Lock()
+	generation := u.status.Generation + 1
+	u.mu.Unlock()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyWriter)
+	cmd.Env = append(handoverEnv(os.Environ()),
+		EnvInheritedFDs+"="+spec,
+		fmt.Sprintf("%s=%d", EnvReadyFD, listenFDsStart+len(files)),
+		fmt.Sprintf("%s=%d", EnvGeneration, generation),
+		fmt.Sprintf("%s=%d", EnvParentPID, os.Getpid()),
+	)
+
+	if err := cmd.Start(); err != nil {
+		readyWriter.Close()
+		return 0, fmt.Errorf("failed to start new process: %w", err)
+	}
+	readyWriter.Close()
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 16)
+		n, err := readyReader.Read(buf)
+		if n == 0 && err != nil {
+			ready <- fmt.Errorf("new process closed ready pipe: %w", err)
+			return
+		}
+		ready <- nil
+	}()
+
+	timeout := u.ReadyTimeout
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			return 0, err
+		}
+		return cmd.Process.Pid, nil
+	case err := <-exited:
+		return 0, fmt.Errorf("new process exited before becoming ready: %v", err)
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("new process not ready after %s", timeout)
+	}
+}
+
+// handoverFiles duplicates the listener descriptors for a new process and
+// describes them in EnvInheritedFDs format, numbering from the first extra fd
+func (u *Upgrader) handoverFiles() ([]*os.File, string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	files := make([]*os.File, 0, len(u.listeners))
+	pairs := make([]string, 0, len(u.listeners))
+	for i, l := range u.listeners {
+		filer, ok := l.listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, "", fmt.Errorf("listener %s cannot be handed over", l.name)
+		}
+		f, err := filer.File()
+		if err != nil {
+			for _, opened := range files {
+				opened.Close()
+			}
+			return nil, "", fmt.Errorf("failed to duplicate %s listener: %w", l.name, err)
+		}
+		files = append(files, f)
+		pairs = append(pairs, fmt.Sprintf("%s=%d", l.name, listenFDsStart+i))
+	}
+
+	return files, strings.Join(pairs, ","), nil
+}
+
+// handoverEnv strips socket activation and previous handover variables
+func handoverEnv(environ []string) []string {
+	env := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES",
+			EnvInheritedFDs, EnvReadyFD, EnvGeneration, EnvParentPID:
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// Drain stops the servers without dropping requests once another process, if
+// any, accepts on the listeners. Keep-alives are disabled first, so that
+// clients reconnect to the new process rather than reuse connections to this
+// one, then this process stops accepting. The servers shut down after the
+// connections they already accepted had time to send their request, as
+// http.Server drops requests that arrive once it is shutting down.
+func (u *Upgrader) Drain(ctx context.Context, servers ...*http.Server) error {
+	for _, server := range servers {
+		server.SetKeepAlivesEnabled(false)
+	}
+
+	u.mu.Lock()
+	for _, l := range u.listeners {
+		l.listener.Close()
+	}
+	u.mu.Unlock()
+
+	select {
+	case <-time.After(drainSettle):
+	case <-ctx.Done():
+	}
+
+	var errs []error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status returns the current upgrade status
+func (u *Upgrader) Status() Status {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.status
+}
@@ -0,0 +1,336 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// envTestChild makes the re-executed test binary act as the new gate process:
+// "serve" serves on the inherited listener, "fail" exits without becoming ready
+const envTestChild = "INFRA_CORE_UPGRADE_TEST_CHILD"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(EnvInheritedFDs) != "" {
+		switch os.Getenv(envTestChild) {
+		case "serve":
+			runTestChild()
+			return
+		case "fail":
+			os.Exit(1)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// runTestChild serves "child" on the inherited listener until SIGTERM
+func runTestChild() {
+	u, err := New(false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	l, err := u.Listen("http", "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "child")
+	})}
+	go server.Serve(l)
+
+	if err := u.Ready(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM)
+	select {
+	case <-quit:
+	case <-time.After(30 * time.Second):
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+	os.Exit(0)
+}
+
+// loadGenerator sends requests in a loop and records every outcome
+type loadGenerator struct {
+	mu       sync.Mutex
+	failures []error
+	bodies   map[string]int
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func startLoad(url string, workers int) *loadGenerator {
+	g := &loadGenerator{bodies: make(map[string]int), stop: make(chan struct{})}
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	for i := 0; i < workers; i++ {
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			for {
+				select {
+				case <-g.stop:
+					return
+				default:
+				}
+
+				resp, err := client.Get(url)
+				if err == nil {
+					var body []byte
+					body, err = io.ReadAll(resp.Body)
+					resp.Body.Close()
+					if err == nil && resp.StatusCode != http.StatusOK {
+						err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+					}
+					if err == nil {
+						g.mu.Lock()
+						g.bodies[string(body)]++
+						g.mu.Unlock()
+						continue
+					}
+				}
+
+				g.mu.Lock()
+				g.failures = append(g.failures, err)
+				g.mu.Unlock()
+			}
+		}()
+	}
+
+	return g
+}
+
+func (g *loadGenerator) finish() ([]error, map[string]int) {
+	close(g.stop)
+	g.wg.Wait()
+	return g.failures, g.bodies
+}
+
+func TestUpgradeKeepsRequestsFlowing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("listener handover is not supported on windows")
+	}
+
+	u, err := New(false)
+	require.NoError(t, err)
+	u.ReadyTimeout = 10 * time.Second
+
+	l, err := u.Listen("http", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// A slow endpoint checks that in-flight requests drain on the old process
+	parent := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		fmt.Fprint(w, "parent")
+	})}
+	go parent.Serve(l)
+
+	url := "http://" + l.Addr().String()
+	load := startLoad(url+"/", 8)
+	time.Sleep(100 * time.Millisecond)
+
+	slow := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+		slow <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	t.Setenv(envTestChild, "serve")
+	require.NoError(t, u.Upgrade())
+
+	status := u.Status()
+	assert.Equal(t, StateDraining, status.State)
+	require.NotZero(t, status.ChildPID)
+	require.NotNil(t, status.LastUpgradeAt)
+	t.Cleanup(func() {
+		if child, err := os.FindProcess(status.ChildPID); err == nil {
+			child.Signal(syscall.SIGTERM)
+		}
+	})
+
+	// The old process stops accepting and drains, its clients reconnecting to
+	// the new one
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, u.Drain(ctx, parent))
+	assert.NoError(t, <-slow)
+
+	time.Sleep(200 * time.Millisecond)
+	failures, bodies := load.finish()
+
+	assert.Empty(t, failures)
+	assert.NotZero(t, bodies["parent"])
+	assert.NotZero(t, bodies["child"])
+}
+
+func TestUpgradeFailureKeepsServing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("listener handover is not supported on windows")
+	}
+
+	u, err := New(false)
+	require.NoError(t, err)
+	u.ReadyTimeout = 5 * time.Second
+
+	_, err = u.Listen("http", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Setenv(envTestChild, "fail")
+
+	assert.Error(t, u.Upgrade())
+	status := u.Status()
+	assert.Equal(t, StateServing, status.State)
+	assert.NotEmpty(t, status.LastError)
+}
+
+func TestInheritSystemdSockets(t *testing.T) {
+	listeners := make([]*net.TCPListener, 3)
+	files := make(map[int]*os.File)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		listeners[i] = l.(*net.TCPListener)
+		defer l.Close()
+
+		f, err := listeners[i].File()
+		require.NoError(t, err)
+		files[listenFDsStart+i] = f
+	}
+
+	env := map[string]string{
+		"LISTEN_PID":     strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS":     "3",
+		"LISTEN_FDNAMES": "metrics::",
+	}
+
+	u := &Upgrader{status: Status{ListenerSource: SourceFresh}}
+	require.NoError(t, u.inherit(func(k string) string { return env[k] }, func(fd int) *os.File { return files[fd] }))
+	assert.Equal(t, SourceSystemd, u.Status().ListenerSource)
+
+	// Named sockets are matched by name, unnamed ones in order
+	httpListener, err := u.Listen("http", "")
+	require.NoError(t, err)
+	defer httpListener.Close()
+	assert.Equal(t, listeners[1].Addr().String(), httpListener.Addr().String())
+
+	metricsListener, err := u.Listen("metrics", "")
+	require.NoError(t, err)
+	defer metricsListener.Close()
+	assert.Equal(t, listeners[0].Addr().String(), metricsListener.Addr().String())
+
+	t.Run("not for this process", func(t *testing.T) {
+		env["LISTEN_PID"] = strconv.Itoa(os.Getpid() + 1)
+		u := &Upgrader{status: Status{ListenerSource: SourceFresh}}
+		require.NoError(t, u.inherit(func(k string) string { return env[k] }, func(fd int) *os.File { return files[fd] }))
+		assert.Equal(t, SourceFresh, u.Status().ListenerSource)
+		assert.Empty(t, u.inherited)
+	})
+}
+
+func TestInheritHandoverEnvironment(t *testing.T) {
+	env := map[string]string{
+		EnvInheritedFDs: "http=3,metrics=4",
+		EnvReadyFD:      "5",
+		EnvGeneration:   "2",
+		EnvParentPID:    "4242",
+	}
+
+	opened := map[int]bool{}
+	u := &Upgrader{status: Status{ListenerSource: SourceFresh}}
+	require.NoError(t, u.inherit(func(k string) string { return env[k] }, func(fd int) *os.File {
+		opened[fd] = true
+		return nil
+	}))
+
+	status := u.Status()
+	assert.Equal(t, SourceInherited, status.ListenerSource)
+	assert.Equal(t, 2, status.Generation)
+	assert.Equal(t, 4242, status.ParentPID)
+	require.Len(t, u.inherited, 2)
+	assert.Equal(t, "metrics", u.inherited[1].name)
+	assert.Equal(t, map[int]bool{3: true, 4: true, 5: true}, opened)
+
+	env[EnvInheritedFDs] = "http"
+	assert.Error(t, (&Upgrader{}).inherit(func(k string) string { return env[k] }, func(fd int) *os.File { return nil }))
+}
+
+func TestHandoverEnv(t *testing.T) {
+	env := handoverEnv([]string{
+		"PATH=/usr/bin",
+		"LISTEN_PID=1",
+		"LISTEN_FDS=2",
+		EnvInheritedFDs + "=http=3",
+		"INFRA_CORE_ENV=production",
+	})
+	assert.Equal(t, []string{"PATH=/usr/bin", "INFRA_CORE_ENV=production"}, env)
+}
+
+func TestReusePort(t *testing.T) {
+	if Signal == nil {
+		t.Skip("SO_REUSEPORT is not supported on this platform")
+	}
+
+	first, err := New(true)
+	require.NoError(t, err)
+	l1, err := first.Listen("http", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l1.Close()
+
+	second, err := New(true)
+	require.NoError(t, err)
+	l2, err := second.Listen("http", l1.Addr().String())
+	require.NoError(t, err)
+	defer l2.Close()
+
+	assert.Equal(t, l1.Addr().String(), l2.Addr().String())
+}
+
+func TestReadyNotifiesSystemd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("systemd notification is not supported on windows")
+	}
+
+	socket := t.TempDir() + "/notify.sock"
+	conn, err := net.ListenPacket("unixgram", socket)
+	require.NoError(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	u := &Upgrader{}
+	require.NoError(t, u.Ready())
+
+	buf := make([]byte, 128)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid()), string(buf[:n]))
+}
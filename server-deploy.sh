@@ -3350,11 +3350,14 @@ Wants=network.target
 Requires=infra-core-console.service
 
 [Service]
-Type=simple
+Type=notify
+NotifyAccess=all
 User=$SERVICE_USER
 Group=$SERVICE_USER
 WorkingDirectory=$DEPLOY_DIR/current
 ExecStart=$DEPLOY_DIR/current/bin/gate
+# Reload re-executes the gate binary on disk and hands over its listeners
+ExecReload=/bin/kill -USR2 \$MAINPID
 EnvironmentFile=/etc/infra-core/environment
 Restart=always
 RestartSec=5
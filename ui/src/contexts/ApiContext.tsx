@@ -8,6 +8,7 @@ import type {
   LoginResponse, 
   Service,
   ServiceSummary,
+  Paged,
   SystemInfo, 
   DashboardData,
   RegisteredService,
@@ -132,8 +133,8 @@ export const ApiProvider: React.FC<ApiProviderProps> = ({ children }: ApiProvide
         return response.data;
       },
       listUsers: async (): Promise<User[]> => {
-        const response = await api.get<User[]>('/api/v1/users');
-        return response.data;
+        const response = await api.get<Paged<User>>('/api/v1/users');
+        return response.data.items ?? [];
       },
       updateUser: async (id: number, data: Partial<User>): Promise<User> => {
         const response = await api.put<User>(`/api/v1/users/${id}`, data);
@@ -145,8 +146,8 @@ export const ApiProvider: React.FC<ApiProviderProps> = ({ children }: ApiProvide
     },
     services: {
       list: async (): Promise<Service[]> => {
-        const response = await api.get<{ services: Service[] }>('/api/v1/services');
-        return (response.data.services ?? []).map(normalizeService);
+        const response = await api.get<Paged<Service>>('/api/v1/services');
+        return (response.data.items ?? []).map(normalizeService);
       },
       get: async (id: string): Promise<Service> => {
         const response = await api.get<{ service: Service }>(`/api/v1/services/${id}`);
@@ -189,8 +190,8 @@ export const ApiProvider: React.FC<ApiProviderProps> = ({ children }: ApiProvide
     },
     sso: {
       listServices: async (): Promise<RegisteredService[]> => {
-        const response = await api.get<{ services: RegisteredService[] }>('/api/v1/sso/services');
-        return response.data.services;
+        const response = await api.get<Paged<RegisteredService>>('/api/v1/sso/services');
+        return response.data.items ?? [];
       },
       getUserServices: async (): Promise<RegisteredService[]> => {
         const response = await api.get<{ services: RegisteredService[] }>('/api/v1/sso/user/services');
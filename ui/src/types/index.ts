@@ -7,6 +7,13 @@ export interface User {
   updated_at: string;
 }
 
+export interface Paged<T> {
+  items: T[];
+  total: number;
+  limit: number;
+  offset: number;
+}
+
 export interface LoginRequest {
   username: string;
   password: string;